@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
+	"io/fs"
 	"log/slog"
 	"net/http"
 	"os"
@@ -13,13 +15,20 @@ import (
 	"github.com/terra-clan/sandbox-engine/internal/api"
 	"github.com/terra-clan/sandbox-engine/internal/cleanup"
 	"github.com/terra-clan/sandbox-engine/internal/config"
+	"github.com/terra-clan/sandbox-engine/internal/metrics"
 	"github.com/terra-clan/sandbox-engine/internal/sandbox"
 	"github.com/terra-clan/sandbox-engine/internal/services"
 	"github.com/terra-clan/sandbox-engine/internal/storage"
 	"github.com/terra-clan/sandbox-engine/internal/templates"
+	"github.com/terra-clan/sandbox-engine/internal/tracing"
+	"github.com/terra-clan/sandbox-engine/internal/version"
+	"github.com/terra-clan/sandbox-engine/migrations"
 )
 
 func main() {
+	migrateOnly := flag.Bool("migrate-only", false, "run database migrations and exit")
+	flag.Parse()
+
 	// Setup structured logging
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
 		Level: slog.LevelInfo,
@@ -33,7 +42,12 @@ func main() {
 		os.Exit(1)
 	}
 
+	build := version.Get()
 	slog.Info("starting sandbox-engine",
+		"version", build.Version,
+		"commit", build.Commit,
+		"build_date", build.BuildDate,
+		"go_version", build.GoVersion,
 		"host", cfg.Server.Host,
 		"port", cfg.Server.Port,
 	)
@@ -42,35 +56,52 @@ func main() {
 	initCtx, initCancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer initCancel()
 
+	// Tracing is set up first so the repository and manager pick up the provider
+	shutdownTracing, err := tracing.Setup(initCtx, cfg.Tracing)
+	if err != nil {
+		slog.Error("failed to set up tracing", "error", err)
+		os.Exit(1)
+	}
+	if cfg.Tracing.Endpoint != "" {
+		slog.Info("tracing enabled", "endpoint", cfg.Tracing.Endpoint, "sample_ratio", cfg.Tracing.SampleRatio)
+	}
+
 	// Run database migrations
-	slog.Info("running database migrations", "dir", cfg.Database.MigrationsDir)
-	if err := storage.MigrateFromDSN(initCtx, cfg.Database.DSN, cfg.Database.MigrationsDir); err != nil {
+	if err := migrate(initCtx, cfg.Database); err != nil {
 		slog.Error("failed to run migrations", "error", err)
 		os.Exit(1)
 	}
+	if *migrateOnly {
+		slog.Info("migrations applied, exiting")
+		return
+	}
 
-	// Initialize database repository
-	repo, err := storage.NewPostgresRepository(initCtx, storage.PostgresConfig{
-		DSN:          cfg.Database.DSN,
-		MaxOpenConns: int32(cfg.Database.MaxOpenConns),
-		MaxIdleConns: int32(cfg.Database.MaxIdleConns),
-	})
+	// Initialize the repository
+	repo, err := openRepository(initCtx, cfg.Database)
 	if err != nil {
 		slog.Error("failed to create database repository", "error", err)
 		os.Exit(1)
 	}
 	slog.Info("database connected successfully")
+	if cfg.Tracing.Endpoint != "" {
+		repo = storage.WithTracing(repo)
+	}
 
 	// Initialize service registry
 	registry := services.NewRegistry()
 
-	// Register service providers
-	postgresProvider, err := services.NewPostgresProvider(cfg.Database.DSN)
-	if err != nil {
-		slog.Error("failed to create postgres provider", "error", err)
-		os.Exit(1)
+	// Register service providers. Sandbox databases are created on the engine's
+	// PostgreSQL server, so the postgres service needs the postgres driver.
+	if cfg.Database.Driver == config.DriverPostgres {
+		postgresProvider, err := services.NewPostgresProvider(cfg.Database.DSN, cfg.Database.AllowedExtensions)
+		if err != nil {
+			slog.Error("failed to create postgres provider", "error", err)
+			os.Exit(1)
+		}
+		registry.Register("postgres", postgresProvider)
+	} else {
+		slog.Warn("postgres service provider disabled", "driver", cfg.Database.Driver)
 	}
-	registry.Register("postgres", postgresProvider)
 
 	redisProvider, err := services.NewRedisProvider(cfg.Redis.Address, cfg.Redis.Password)
 	if err != nil {
@@ -79,31 +110,92 @@ func main() {
 	}
 	registry.Register("redis", redisProvider)
 
+	if cfg.Minio.Endpoint != "" {
+		minioProvider, err := services.NewMinioProvider(services.MinioOptions{
+			Endpoint:  cfg.Minio.Endpoint,
+			AccessKey: cfg.Minio.AccessKey,
+			SecretKey: cfg.Minio.SecretKey,
+			UseSSL:    cfg.Minio.UseSSL,
+			Region:    cfg.Minio.Region,
+		})
+		if err != nil {
+			slog.Error("failed to create minio provider", "error", err)
+			os.Exit(1)
+		}
+		registry.Register("minio", minioProvider)
+	}
+
 	// Load templates
 	templateLoader := templates.NewLoader()
+	templateLoader.SetStrict(cfg.Templates.Strict)
+	templateLoader.SetDefaultLocale(cfg.Templates.DefaultLocale)
+	templateVars, err := templates.LoadVars(cfg.Templates.VarsFile, os.Environ())
+	if err != nil {
+		slog.Error("failed to load template vars", "error", err)
+		os.Exit(1)
+	}
+	templateLoader.SetVars(templateVars)
+	templateLoader.AddValidator(templates.ServiceValidator(registry.List))
+	templateLoader.AddValidator(templates.ServiceOptionsValidator(registry.ValidateOptions))
+	if cfg.Templates.VerifyImages {
+		imageValidator, err := sandbox.NewImageValidator(cfg.Docker)
+		if err != nil {
+			slog.Error("failed to create image validator", "error", err)
+			os.Exit(1)
+		}
+		templateLoader.AddValidator(imageValidator)
+	}
+	templateLoader.SetDocumentSource(repo)
 	if err := templateLoader.LoadFromDir(cfg.Templates.Dir); err != nil {
 		slog.Warn("failed to load templates from dir", "dir", cfg.Templates.Dir, "error", err)
+		// Still serve the templates created through the API
+		if err := templateLoader.LoadFromDir(""); err != nil {
+			slog.Warn("failed to load stored templates", "error", err)
+		}
+	}
+
+	// Initialize metrics
+	engineMetrics, err := metrics.New(cfg.Metrics.LabelKeys)
+	if err != nil {
+		slog.Error("invalid metrics configuration", "error", err)
+		os.Exit(1)
 	}
 
 	// Initialize sandbox manager
-	manager, err := sandbox.NewManager(cfg.Docker, cfg.Traefik, registry, templateLoader, repo)
+	manager, err := sandbox.NewManager(cfg.Docker, cfg.Traefik, cfg.Sandbox, registry, templateLoader, repo, engineMetrics)
 	if err != nil {
 		slog.Error("failed to create sandbox manager", "error", err)
 		os.Exit(1)
 	}
 
-	// Initialize cleanup worker
-	cleaner := cleanup.NewCleaner(manager, cfg.Cleanup.Interval)
+	// Initialize cleanup, prewarm and callback workers
+	cleaner := cleanup.NewCleaner(manager, cfg.Cleanup, engineMetrics)
+	prewarmer := cleanup.NewPrewarmer(manager, cfg.Sandbox)
+	callbacks := cleanup.NewCallbackRetrier(manager)
 
 	// Create context with cancellation
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Start cleanup worker
+	// Start cleanup, prewarm and callback workers
 	cleaner.Start(ctx)
+	prewarmer.Start(ctx)
+	callbacks.Start(ctx)
+
+	if cfg.Templates.WatchInterval > 0 {
+		go templateLoader.Watch(ctx, cfg.Templates.WatchInterval)
+	}
+
+	// Sandboxes created before placement was tracked belong to this host
+	manager.AssignUnplacedSandboxes(ctx)
+
+	// Warm the image cache so the first sandbox of a template doesn't wait on a pull
+	manager.PrePullImages(ctx)
 
 	// Setup HTTP server
-	server := api.NewServer(cfg.Server, manager, templateLoader, repo)
+	// Rate limit buckets share the Redis connection so they hold across replicas
+	limiter := api.NewRateLimiter(redisProvider.Client(), cfg.RateLimit)
+	server := api.NewServer(cfg.Server, manager, templateLoader, repo, engineMetrics, limiter)
 	httpServer := &http.Server{
 		Addr:         fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port),
 		Handler:      server.Router(),
@@ -144,5 +236,54 @@ func main() {
 		slog.Error("manager close error", "error", err)
 	}
 
+	// Flush spans still buffered in the exporter
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("tracing shutdown error", "error", err)
+	}
+
 	slog.Info("sandbox-engine stopped")
 }
+
+// migrate applies the migrations of the configured driver, from MigrationsDir
+// when set and from the set embedded in the binary otherwise
+func migrate(ctx context.Context, cfg config.DatabaseConfig) error {
+	var fsys fs.FS = migrations.FS
+	source := "embedded"
+	if cfg.MigrationsDir != "" {
+		fsys, source = os.DirFS(cfg.MigrationsDir), cfg.MigrationsDir
+	}
+
+	if cfg.Driver == config.DriverSqlite {
+		sqliteFS, err := fs.Sub(fsys, "sqlite")
+		if err != nil {
+			return err
+		}
+		slog.Info("running database migrations", "source", source, "driver", cfg.Driver)
+		return storage.MigrateSqlite(ctx, cfg.DSN, sqliteFS)
+	}
+
+	slog.Info("running database migrations", "source", source, "driver", cfg.Driver)
+	return storage.MigrateFromDSN(ctx, cfg.DSN, fsys)
+}
+
+// openRepository opens the repository of the configured driver
+func openRepository(ctx context.Context, cfg config.DatabaseConfig) (storage.Repository, error) {
+	if cfg.Driver == config.DriverSqlite {
+		repo, err := storage.NewSqliteRepository(ctx, cfg.DSN)
+		if err != nil {
+			return nil, err
+		}
+		return repo, nil
+	}
+
+	repo, err := storage.NewPostgresRepository(ctx, storage.PostgresConfig{
+		DSN:          cfg.DSN,
+		ReadDSN:      cfg.ReadDSN,
+		MaxOpenConns: int32(cfg.MaxOpenConns),
+		MaxIdleConns: int32(cfg.MaxIdleConns),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return repo, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/terra-clan/sandbox-engine/pkg/client"
+)
+
+const defaultURL = "http://localhost:8080"
+
+// app carries what every command needs: its I/O, the global options and,
+// once they are parsed, the client
+type app struct {
+	ctx    context.Context
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	getenv func(string) string
+
+	url        string
+	apiKey     string
+	configPath string
+	json       bool
+
+	client *client.Client
+}
+
+// fileConfig is the content of ~/.sbctl.yaml
+type fileConfig struct {
+	URL    string `yaml:"url"`
+	APIKey string `yaml:"api_key"`
+}
+
+// flags returns the flag set of a command with the global flags registered
+func (a *app) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("sbctl "+name, flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	fs.StringVar(&a.url, "url", "", "API base URL (default $SBCTL_URL, then url in the config file, then "+defaultURL+")")
+	fs.StringVar(&a.apiKey, "api-key", "", "API key (default $SBCTL_API_KEY, then api_key in the config file)")
+	fs.StringVar(&a.configPath, "config", "", "config file (default ~/.sbctl.yaml)")
+	fs.BoolVar(&a.json, "json", false, "print JSON instead of a table")
+	return fs
+}
+
+// parse parses args, flags and arguments in any order, checks that want
+// arguments are left (any number when want is negative), and sets up the
+// client
+func (a *app) parse(fs *flag.FlagSet, args []string, want int) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil, err
+			}
+			return nil, usageError{err}
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+	if want >= 0 && len(positional) != want {
+		return nil, usageErrorf("%s takes %d argument(s), got %d", fs.Name(), want, len(positional))
+	}
+
+	if err := a.configure(); err != nil {
+		return nil, err
+	}
+	return positional, nil
+}
+
+// configure resolves the URL and API key, each from the flag, then the
+// environment, then the config file, and creates the client
+func (a *app) configure() error {
+	cfg, err := a.readConfig()
+	if err != nil {
+		return err
+	}
+
+	url := firstNonEmpty(a.url, a.getenv("SBCTL_URL"), cfg.URL, defaultURL)
+	apiKey := firstNonEmpty(a.apiKey, a.getenv("SBCTL_API_KEY"), cfg.APIKey)
+	if apiKey == "" {
+		return usageErrorf("no API key: pass --api-key, set SBCTL_API_KEY or add api_key to ~/.sbctl.yaml")
+	}
+
+	a.url, a.apiKey = strings.TrimRight(url, "/"), apiKey
+	a.client = client.NewClient(a.url, a.apiKey, client.WithRetry(client.RetryPolicy{MaxAttempts: 3}))
+	return nil
+}
+
+// readConfig reads the config file; the default one may be missing
+func (a *app) readConfig() (fileConfig, error) {
+	var cfg fileConfig
+
+	path := a.configPath
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return cfg, nil
+		}
+		path = filepath.Join(home, ".sbctl.yaml")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && a.configPath == "" {
+			return cfg, nil
+		}
+		return cfg, fmt.Errorf("failed to read config: %w", err)
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// keyValues collects repeated key=value flags
+type keyValues map[string]string
+
+func (kv keyValues) String() string {
+	pairs := make([]string, 0, len(kv))
+	for k, v := range kv {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (kv keyValues) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("expected key=value, got %q", s)
+	}
+	kv[k] = v
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+func templateList(a *app, args []string) error {
+	fs := a.flags("template list")
+	if _, err := a.parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	templates, err := a.client.ListTemplates(a.ctx)
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]string, 0, len(templates))
+	for _, t := range templates {
+		rows = append(rows, []string{t.Name, t.BaseImage, t.TTL.String(), strings.Join(t.Services, ","), t.Description})
+	}
+	return a.print(templates, []string{"NAME", "IMAGE", "TTL", "SERVICES", "DESCRIPTION"}, rows)
+}
+
+// catalogBrowse lists the domains, the projects of a domain, or the tasks of
+// a project, or shows a task, depending on how deep the argument goes
+func catalogBrowse(a *app, args []string) error {
+	fs := a.flags("catalog browse")
+	pos, err := a.parse(fs, args, -1)
+	if err != nil {
+		return err
+	}
+	if len(pos) > 1 {
+		return usageErrorf("%s takes at most 1 argument, got %d", fs.Name(), len(pos))
+	}
+
+	var path string
+	var parts []string
+	if len(pos) == 1 {
+		path = strings.Trim(pos[0], "/")
+	}
+	if path != "" {
+		parts = strings.Split(path, "/")
+	}
+	switch len(parts) {
+	case 0:
+		domains, err := a.client.ListDomains(a.ctx)
+		if err != nil {
+			return err
+		}
+		rows := make([][]string, 0, len(domains))
+		for _, d := range domains {
+			rows = append(rows, []string{d.ID, d.Name, strconv.Itoa(d.ProjectsCount), strconv.Itoa(d.TasksCount)})
+		}
+		return a.print(domains, []string{"ID", "NAME", "PROJECTS", "TASKS"}, rows)
+	case 1:
+		projects, err := a.client.ListProjects(a.ctx, path)
+		if err != nil {
+			return err
+		}
+		rows := make([][]string, 0, len(projects))
+		for _, p := range projects {
+			rows = append(rows, []string{p.ID, p.Name, strconv.Itoa(p.TasksCount), p.Description})
+		}
+		return a.print(projects, []string{"ID", "NAME", "TASKS", "DESCRIPTION"}, rows)
+	case 2:
+		tasks, err := a.client.ListTasks(a.ctx, path)
+		if err != nil {
+			return err
+		}
+		rows := make([][]string, 0, len(tasks))
+		for _, t := range tasks {
+			rows = append(rows, []string{t.ID, t.Title, t.Difficulty, (time.Duration(t.TimeLimit) * time.Second).String()})
+		}
+		return a.print(tasks, []string{"ID", "TITLE", "DIFFICULTY", "TIME LIMIT"}, rows)
+	case 3:
+		t, err := a.client.GetTask(a.ctx, path)
+		if err != nil {
+			return err
+		}
+		return a.print(t, []string{"ID", "TITLE", "DIFFICULTY", "TIME LIMIT", "SKILLS"},
+			[][]string{{t.ID, t.Title, t.Difficulty, (time.Duration(t.TimeLimit) * time.Second).String(), strings.Join(t.Skills, ",")}})
+	}
+	return usageErrorf("expected domain[/project[/task]], got %q", path)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+
+	"github.com/terra-clan/sandbox-engine/pkg/client"
+)
+
+// Exit codes, by class of error, so scripts can tell failures apart
+const (
+	exitOK          = 0
+	exitError       = 1 // anything else, including an unreachable server
+	exitUsage       = 2 // bad flags or arguments, or no API key
+	exitNotFound    = 3 // 404
+	exitAuth        = 4 // 401, 403
+	exitConflict    = 5 // 409, 410: the resource is in the wrong state
+	exitInvalid     = 6 // 400, 411, 413, 422: the server rejected the request
+	exitRateLimited = 7 // 429
+	exitServer      = 8 // 5xx
+	exitShell       = 9 // the shell of a terminal exited non-zero; its status is printed
+)
+
+// usageError is a mistake in the command line
+type usageError struct{ err error }
+
+func (e usageError) Error() string { return e.err.Error() }
+
+func usageErrorf(format string, args ...any) error {
+	return usageError{fmt.Errorf(format, args...)}
+}
+
+// exitStatus is the non-zero status of a command sbctl ran, e.g. the shell of
+// a terminal. It ends sbctl with exitShell rather than the status itself,
+// which could pass for one of the codes above.
+type exitStatus int
+
+func (e exitStatus) Error() string { return fmt.Sprintf("shell exited with status %d", int(e)) }
+
+func exitCode(err error) int {
+	var usage usageError
+	var status exitStatus
+	var httpErr *client.HTTPError
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return exitOK
+	case errors.As(err, &usage):
+		return exitUsage
+	case errors.As(err, &status):
+		return exitShell
+	case errors.As(err, &httpErr):
+		return statusExitCode(httpErr.StatusCode)
+	}
+	return exitError
+}
+
+func statusExitCode(status int) int {
+	switch status {
+	case http.StatusNotFound:
+		return exitNotFound
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return exitAuth
+	case http.StatusConflict, http.StatusGone:
+		return exitConflict
+	case http.StatusBadRequest, http.StatusLengthRequired, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		return exitInvalid
+	case http.StatusTooManyRequests:
+		return exitRateLimited
+	}
+	if status >= 500 {
+		return exitServer
+	}
+	return exitError
+}
+
+// describe renders err for the terminal, with an API error as its code and
+// message rather than the raw response
+func describe(err error) string {
+	var httpErr *client.HTTPError
+	if !errors.As(err, &httpErr) || httpErr.Code == "" {
+		return err.Error()
+	}
+	return fmt.Sprintf("%s (%s, HTTP %d)", httpErr.Message, httpErr.Code, httpErr.StatusCode)
+}
@@ -0,0 +1,108 @@
+// Command sbctl operates a sandbox-engine server from the command line
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+const usage = `Usage: sbctl <command> [flags] [args]
+
+Commands:
+  sandbox create|list|get|delete|stop|extend|logs
+  session create|list|revoke
+  template list
+  catalog browse [domain[/project[/task]]]
+  terminal <sandbox id>
+
+Every command takes --url, --api-key, --config and --json. The URL and key
+default to SBCTL_URL and SBCTL_API_KEY, then to url and api_key in
+~/.sbctl.yaml. Run "sbctl <command> -h" for a command's flags.
+
+Exit codes: 1 error, 2 usage, 3 not found, 4 auth, 5 conflict, 6 invalid,
+7 rate limited, 8 server error, 9 the terminal's shell exited non-zero.
+`
+
+// commands maps a command to its handler, or to a group of subcommands
+var commands = map[string]any{
+	"sandbox": map[string]func(*app, []string) error{
+		"create": sandboxCreate,
+		"list":   sandboxList,
+		"get":    sandboxGet,
+		"delete": sandboxDelete,
+		"stop":   sandboxStop,
+		"extend": sandboxExtend,
+		"logs":   sandboxLogs,
+	},
+	"session": map[string]func(*app, []string) error{
+		"create": sessionCreate,
+		"list":   sessionList,
+		"revoke": sessionRevoke,
+	},
+	"template": map[string]func(*app, []string) error{
+		"list": templateList,
+	},
+	"catalog": map[string]func(*app, []string) error{
+		"browse": catalogBrowse,
+	},
+	"terminal": terminal,
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	a := &app{
+		ctx:    ctx,
+		stdin:  os.Stdin,
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+		getenv: os.Getenv,
+	}
+	code := a.run(os.Args[1:])
+	stop()
+	os.Exit(code)
+}
+
+// run executes the command in args and returns the process exit code
+func (a *app) run(args []string) int {
+	err := a.dispatch(args)
+	if err != nil && exitCode(err) != exitOK {
+		fmt.Fprintf(a.stderr, "sbctl: %s\n", describe(err))
+	}
+	return exitCode(err)
+}
+
+func (a *app) dispatch(args []string) error {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		io.WriteString(a.stderr, usage)
+		if len(args) == 0 {
+			return usageErrorf("no command given")
+		}
+		return nil
+	}
+
+	switch cmd := commands[args[0]].(type) {
+	case func(*app, []string) error:
+		return cmd(a, args[1:])
+	case map[string]func(*app, []string) error:
+		names := make([]string, 0, len(cmd))
+		for name := range cmd {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if len(args) < 2 {
+			return usageErrorf("%s needs a subcommand: %s", args[0], strings.Join(names, ", "))
+		}
+		sub, ok := cmd[args[1]]
+		if !ok {
+			return usageErrorf("unknown %s subcommand %q, expected one of %s", args[0], args[1], strings.Join(names, ", "))
+		}
+		return sub(a, args[2:])
+	}
+	return usageErrorf("unknown command %q", args[0])
+}
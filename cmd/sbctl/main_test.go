@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newTestApp returns an app writing to buffers, with env as its environment
+// and no config file
+func newTestApp(t *testing.T, env map[string]string) (*app, *bytes.Buffer, *bytes.Buffer) {
+	var stdout, stderr bytes.Buffer
+	a := &app{
+		ctx:    context.Background(),
+		stdin:  strings.NewReader(""),
+		stdout: &stdout,
+		stderr: &stderr,
+		getenv: func(k string) string { return env[k] },
+	}
+	t.Setenv("HOME", t.TempDir())
+	return a, &stdout, &stderr
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sbctl.yaml")
+	os.WriteFile(path, []byte("url: http://file:8080\napi_key: file-key\n"), 0o600)
+
+	a, _, _ := newTestApp(t, map[string]string{"SBCTL_API_KEY": "env-key"})
+	if _, err := a.parse(a.flags("test"), []string{"--config", path}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if a.url != "http://file:8080" || a.apiKey != "env-key" {
+		t.Errorf("expected the env over the file, got %s %s", a.url, a.apiKey)
+	}
+
+	a, _, _ = newTestApp(t, map[string]string{"SBCTL_URL": "http://env:8080"})
+	if _, err := a.parse(a.flags("test"), []string{"--config", path, "--url", "http://flag:8080/", "--api-key", "flag-key"}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if a.url != "http://flag:8080" || a.apiKey != "flag-key" {
+		t.Errorf("expected the flags over the env, got %s %s", a.url, a.apiKey)
+	}
+
+	a, _, _ = newTestApp(t, nil)
+	if _, err := a.parse(a.flags("test"), nil, 0); exitCode(err) != exitUsage {
+		t.Errorf("expected a usage error without an API key, got %v", err)
+	}
+	if _, err := a.parse(a.flags("test"), []string{"--config", filepath.Join(t.TempDir(), "missing.yaml"), "--api-key", "k"}, 0); err == nil {
+		t.Error("expected an error for a missing --config file")
+	}
+}
+
+func TestSandboxList(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer key" || r.URL.Query().Get("status") != "running" {
+			t.Errorf("unexpected request %s %v", r.URL, r.Header)
+		}
+		json.NewEncoder(w).Encode(map[string]any{"success": true, "data": map[string]any{
+			"sandboxes": []map[string]any{{"id": "sb-1", "template_id": "python", "status": "running", "expires_at": time.Now().Add(time.Hour)}},
+			"total":     1,
+		}})
+	}))
+	defer ts.Close()
+
+	a, stdout, _ := newTestApp(t, map[string]string{"SBCTL_URL": ts.URL, "SBCTL_API_KEY": "key"})
+	if code := a.run([]string{"sandbox", "list", "--status", "running"}); code != exitOK {
+		t.Fatalf("expected exit 0, got %d", code)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "ID") || !strings.HasPrefix(lines[1], "sb-1") || !strings.Contains(lines[1], "python") {
+		t.Errorf("unexpected table %q", stdout)
+	}
+
+	a, stdout, _ = newTestApp(t, map[string]string{"SBCTL_URL": ts.URL, "SBCTL_API_KEY": "key"})
+	if code := a.run([]string{"sandbox", "list", "--json", "--status=running"}); code != exitOK {
+		t.Fatalf("expected exit 0, got %d", code)
+	}
+	var sandboxes []map[string]any
+	if err := json.Unmarshal(stdout.Bytes(), &sandboxes); err != nil || len(sandboxes) != 1 || sandboxes[0]["id"] != "sb-1" {
+		t.Errorf("unexpected JSON %q (%v)", stdout, err)
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status, code := http.StatusNotFound, "not_found"
+		if strings.HasSuffix(r.URL.Path, "/revoke") {
+			status, code = http.StatusConflict, "session_revoked"
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]any{"success": false, "error": map[string]string{"code": code, "message": "nope"}})
+	}))
+	defer ts.Close()
+
+	for _, tc := range []struct {
+		args []string
+		want int
+	}{
+		{args: []string{"sandbox", "get", "sb-1"}, want: exitNotFound},
+		{args: []string{"session", "revoke", "s-1"}, want: exitConflict},
+		{args: []string{"sandbox", "get"}, want: exitUsage},
+		{args: []string{"sandbox", "explode"}, want: exitUsage},
+		{args: []string{"sandbox", "create"}, want: exitUsage},
+		{args: []string{"sandbox", "get", "-h"}, want: exitOK},
+	} {
+		a, _, stderr := newTestApp(t, map[string]string{"SBCTL_URL": ts.URL, "SBCTL_API_KEY": "key"})
+		if code := a.run(tc.args); code != tc.want {
+			t.Errorf("%v: expected exit %d, got %d: %s", tc.args, tc.want, code, stderr)
+		}
+		if tc.want == exitNotFound && !strings.Contains(stderr.String(), "nope (not_found, HTTP 404)") {
+			t.Errorf("expected the API error described, got %q", stderr)
+		}
+	}
+}
+
+func TestTerminal(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	inputs := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/ws/terminal/sb-1" || r.Header.Get("Authorization") != "Bearer key" {
+			http.Error(w, "sandbox not found", http.StatusNotFound)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var msg terminalMessage
+		if conn.ReadJSON(&msg); msg.Type != "binary" || !msg.Enabled {
+			t.Errorf("expected binary output asked for first, got %+v", msg)
+		}
+		conn.ReadJSON(&msg)
+		inputs <- msg.Data
+		conn.WriteMessage(websocket.BinaryMessage, []byte("hello\xff"))
+		code := 3
+		conn.WriteJSON(terminalMessage{Type: "exit", Code: &code})
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "shell exited"))
+		conn.ReadMessage()
+	}))
+	defer ts.Close()
+
+	a, stdout, stderr := newTestApp(t, map[string]string{"SBCTL_URL": ts.URL, "SBCTL_API_KEY": "key"})
+	a.stdin = strings.NewReader("exit 3\n")
+	// The shell's status 3 would read as not found; it is reported instead
+	if code := a.run([]string{"terminal", "sb-1"}); code != exitShell || !strings.Contains(stderr.String(), "shell exited with status 3") {
+		t.Errorf("expected the shell's exit reported, got %d: %s", code, stderr)
+	}
+	if got := <-inputs; got != "exit 3\n" {
+		t.Errorf("expected stdin sent as input, got %q", got)
+	}
+	if stdout.String() != "hello\xff" {
+		t.Errorf("expected the output written as is, got %q", stdout)
+	}
+
+	a, _, _ = newTestApp(t, map[string]string{"SBCTL_URL": ts.URL, "SBCTL_API_KEY": "key"})
+	if code := a.run([]string{"terminal", "sb-2"}); code != exitNotFound {
+		t.Errorf("expected a refused upgrade to map to its status, got %d", code)
+	}
+}
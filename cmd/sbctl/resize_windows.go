@@ -0,0 +1,7 @@
+package main
+
+// notifyResize does nothing on Windows, which has no SIGWINCH; the terminal
+// keeps the size it had when it connected
+func notifyResize(resize func()) (stop func()) {
+	return func() {}
+}
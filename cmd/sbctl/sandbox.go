@@ -0,0 +1,162 @@
+package main
+
+import (
+	"io"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/pkg/client"
+)
+
+var sandboxHeaders = []string{"ID", "TEMPLATE", "USER", "STATUS", "EXPIRES"}
+
+func sandboxRow(sb *client.Sandbox) []string {
+	return []string{sb.ID, sb.TemplateID, orDash(sb.UserID), sb.Status, formatTime(sb.ExpiresAt)}
+}
+
+func (a *app) printSandbox(sb *client.Sandbox) error {
+	return a.print(sb, sandboxHeaders, [][]string{sandboxRow(sb)})
+}
+
+func sandboxCreate(a *app, args []string) error {
+	fs := a.flags("sandbox create")
+	req := client.CreateSandboxRequest{Env: keyValues{}, Metadata: keyValues{}}
+	fs.StringVar(&req.TemplateID, "template", "", "template to create the sandbox from (required)")
+	fs.StringVar(&req.UserID, "user", "", "user the sandbox is for")
+	ttl := fs.Duration("ttl", 0, "time to live, e.g. 2h (default the template's)")
+	fs.Var(keyValues(req.Env), "env", "environment variable as KEY=VALUE, repeatable")
+	fs.Var(keyValues(req.Metadata), "metadata", "metadata as key=value, repeatable")
+	wait := fs.Bool("wait", false, "wait until the sandbox is running")
+	if _, err := a.parse(fs, args, 0); err != nil {
+		return err
+	}
+	if req.TemplateID == "" {
+		return usageErrorf("--template is required")
+	}
+	if *ttl > 0 {
+		req.TTL = ttl
+	}
+
+	create := a.client.CreateSandbox
+	if *wait {
+		create = a.client.CreateAndWait
+	}
+	sb, err := create(a.ctx, req)
+	if err != nil {
+		return err
+	}
+	return a.printSandbox(sb)
+}
+
+func sandboxList(a *app, args []string) error {
+	fs := a.flags("sandbox list")
+	var opts client.ListOptions
+	fs.StringVar(&opts.Status, "status", "", "only sandboxes with this status")
+	fs.StringVar(&opts.TemplateID, "template", "", "only sandboxes of this template")
+	fs.StringVar(&opts.UserID, "user", "", "only sandboxes of this user")
+	fs.IntVar(&opts.Limit, "limit", 50, "most sandboxes to list")
+	all := fs.Bool("all", false, "list every matching sandbox, ignoring --limit")
+	if _, err := a.parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	var sandboxes []*client.Sandbox
+	if *all {
+		opts.Limit = 0
+		it := a.client.Sandboxes(a.ctx, opts)
+		for it.Next() {
+			sandboxes = append(sandboxes, it.Value())
+		}
+		if err := it.Err(); err != nil {
+			return err
+		}
+	} else {
+		list, err := a.client.ListSandboxes(a.ctx, opts)
+		if err != nil {
+			return err
+		}
+		sandboxes = list.Sandboxes
+	}
+
+	rows := make([][]string, 0, len(sandboxes))
+	for _, sb := range sandboxes {
+		rows = append(rows, sandboxRow(sb))
+	}
+	return a.print(sandboxes, sandboxHeaders, rows)
+}
+
+func sandboxGet(a *app, args []string) error {
+	fs := a.flags("sandbox get")
+	pos, err := a.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	sb, err := a.client.GetSandbox(a.ctx, pos[0])
+	if err != nil {
+		return err
+	}
+	return a.printSandbox(sb)
+}
+
+func sandboxDelete(a *app, args []string) error {
+	fs := a.flags("sandbox delete")
+	pos, err := a.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	if err := a.client.DeleteSandbox(a.ctx, pos[0]); err != nil {
+		return err
+	}
+	return a.printDone(pos[0], "Deleted sandbox "+pos[0])
+}
+
+func sandboxStop(a *app, args []string) error {
+	fs := a.flags("sandbox stop")
+	pos, err := a.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	if err := a.client.StopSandbox(a.ctx, pos[0]); err != nil {
+		return err
+	}
+	return a.printDone(pos[0], "Stopped sandbox "+pos[0])
+}
+
+func sandboxExtend(a *app, args []string) error {
+	fs := a.flags("sandbox extend")
+	by := fs.Duration("by", time.Hour, "how much longer the sandbox lives")
+	pos, err := a.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	if *by <= 0 {
+		return usageErrorf("--by must be positive")
+	}
+
+	sb, err := a.client.ExtendTTL(a.ctx, pos[0], *by)
+	if err != nil {
+		return err
+	}
+	return a.printSandbox(sb)
+}
+
+func sandboxLogs(a *app, args []string) error {
+	fs := a.flags("sandbox logs")
+	tail := fs.Int("tail", 100, "lines from the end of the log")
+	pos, err := a.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	logs, err := a.client.GetLogs(a.ctx, pos[0], *tail)
+	if err != nil {
+		return err
+	}
+	if a.json {
+		return a.print(map[string]string{"id": pos[0], "logs": logs}, nil, nil)
+	}
+	_, err = io.WriteString(a.stdout, logs)
+	return err
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"github.com/terra-clan/sandbox-engine/pkg/client"
+)
+
+var sessionHeaders = []string{"ID", "TEMPLATE", "STATUS", "SANDBOX", "EXPIRES"}
+
+func sessionRow(s *client.Session) []string {
+	return []string{s.ID, s.TemplateID, string(s.Status), orDash(s.SandboxID), formatTimePtr(s.ExpiresAt)}
+}
+
+func sessionCreate(a *app, args []string) error {
+	fs := a.flags("session create")
+	req := client.CreateSessionRequest{Env: keyValues{}, Metadata: keyValues{}}
+	fs.StringVar(&req.TemplateID, "template", "", "template of the session's sandbox (default the task's project template)")
+	fs.StringVar(&req.TaskID, "task", "", "catalog task as domain/project/task")
+	ttl := fs.Duration("ttl", 0, "how long the session lasts once activated (default the task's time limit)")
+	joinTTL := fs.Duration("join-ttl", 0, "how long the candidate has to activate it (default SESSION_JOIN_TTL)")
+	fs.Var(keyValues(req.Env), "env", "environment variable as KEY=VALUE, repeatable")
+	fs.Var(keyValues(req.Metadata), "metadata", "metadata as key=value, repeatable")
+	fs.StringVar(&req.TaskDescription, "description", "", "task description shown to the candidate")
+	fs.StringVar(&req.UniqueKey, "unique-key", "", "key that at most one live session may have")
+	if _, err := a.parse(fs, args, 0); err != nil {
+		return err
+	}
+	if req.TemplateID == "" && req.TaskID == "" {
+		return usageErrorf("--template or --task is required")
+	}
+	req.TTL, req.JoinTTL = int(ttl.Seconds()), int(joinTTL.Seconds())
+
+	resp, err := a.client.CreateSession(a.ctx, req)
+	if err != nil {
+		return err
+	}
+	return a.print(resp, []string{"ID", "TEMPLATE", "STATUS", "JOIN URL"},
+		[][]string{{resp.ID, resp.TemplateID, string(resp.Status), resp.JoinURL}})
+}
+
+func sessionList(a *app, args []string) error {
+	fs := a.flags("session list")
+	var opts client.SessionListOptions
+	fs.StringVar(&opts.Status, "status", "", "only sessions with this status, or invalid_template")
+	fs.StringVar(&opts.TemplateID, "template", "", "only sessions of this template")
+	fs.StringVar(&opts.CreatedBy, "created-by", "", "only sessions created by this API client")
+	fs.IntVar(&opts.Limit, "limit", 50, "most sessions to list")
+	all := fs.Bool("all", false, "list every matching session, ignoring --limit")
+	if _, err := a.parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	var sessions []*client.Session
+	if *all {
+		opts.Limit = 0
+		it := a.client.Sessions(a.ctx, opts)
+		for it.Next() {
+			sessions = append(sessions, it.Value())
+		}
+		if err := it.Err(); err != nil {
+			return err
+		}
+	} else {
+		list, err := a.client.ListSessions(a.ctx, opts)
+		if err != nil {
+			return err
+		}
+		sessions = list.Sessions
+	}
+
+	rows := make([][]string, 0, len(sessions))
+	for _, s := range sessions {
+		rows = append(rows, sessionRow(s))
+	}
+	return a.print(sessions, sessionHeaders, rows)
+}
+
+func sessionRevoke(a *app, args []string) error {
+	fs := a.flags("session revoke")
+	pos, err := a.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	session, err := a.client.RevokeSession(a.ctx, pos[0])
+	if err != nil {
+		return err
+	}
+	return a.print(session, sessionHeaders, [][]string{sessionRow(session)})
+}
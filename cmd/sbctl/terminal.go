@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/moby/term"
+
+	"github.com/terra-clan/sandbox-engine/pkg/client"
+)
+
+// terminalMessage is the JSON framing of the terminal WebSocket
+type terminalMessage struct {
+	Type    string `json:"type"`
+	Data    string `json:"data,omitempty"`
+	Cols    int    `json:"cols,omitempty"`
+	Rows    int    `json:"rows,omitempty"`
+	Code    *int   `json:"code,omitempty"`
+	Enabled bool   `json:"enabled,omitempty"`
+}
+
+// terminal attaches the local terminal to the shell of a sandbox over the
+// WebSocket terminal, in raw mode, until the shell ends. A shell that exits
+// non-zero ends sbctl with exitShell, reporting its status on stderr.
+func terminal(a *app, args []string) error {
+	fs := a.flags("terminal")
+	fresh := fs.Bool("new", false, "start a fresh shell instead of reattaching to the sandbox's")
+	observe := fs.Bool("observe", false, "watch the sandbox's shell read-only")
+	pos, err := a.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	conn, err := a.dialTerminal(pos[0], *fresh, *observe)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// An interrupt ends the read below
+	defer context.AfterFunc(a.ctx, func() { conn.Close() })()
+
+	var writeMu sync.Mutex
+	send := func(msg terminalMessage) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return conn.WriteJSON(msg)
+	}
+
+	// Binary frames carry the output as is, where JSON would mangle bytes
+	// that aren't UTF-8
+	if err := send(terminalMessage{Type: "binary", Enabled: true}); err != nil {
+		return fmt.Errorf("failed to set up terminal: %w", err)
+	}
+
+	inFd, inTerminal := term.GetFdInfo(a.stdin)
+	if inTerminal && !*observe {
+		state, err := term.MakeRaw(inFd)
+		if err != nil {
+			return fmt.Errorf("failed to put the terminal in raw mode: %w", err)
+		}
+		defer term.RestoreTerminal(inFd, state)
+	}
+
+	if outFd, ok := term.GetFdInfo(a.stdout); ok && !*observe {
+		resize := func() {
+			if ws, err := term.GetWinsize(outFd); err == nil && ws.Width > 0 && ws.Height > 0 {
+				send(terminalMessage{Type: "resize", Cols: int(ws.Width), Rows: int(ws.Height)})
+			}
+		}
+		resize()
+		stop := notifyResize(resize)
+		defer stop()
+	}
+
+	// Keystrokes go to the shell; the goroutine is left blocked on stdin
+	// when the shell ends first
+	if !*observe {
+		go func() {
+			buf := make([]byte, 4096)
+			for {
+				n, err := a.stdin.Read(buf)
+				if n > 0 && send(terminalMessage{Type: "input", Data: string(buf[:n])}) != nil {
+					return
+				}
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	return a.readTerminal(conn)
+}
+
+// dialTerminal opens the terminal WebSocket of a sandbox, authenticating with
+// the API key header so the key stays out of the URL
+func (a *app) dialTerminal(id string, fresh, observe bool) (*websocket.Conn, error) {
+	u, err := url.Parse(a.url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL %q: %w", a.url, err)
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	default:
+		u.Scheme = "ws"
+	}
+	u.Path = strings.TrimRight(u.Path, "/") + "/api/v1/ws/terminal/" + url.PathEscape(id)
+	query := url.Values{}
+	if fresh {
+		query.Set("new", "true")
+	}
+	if observe {
+		query.Set("mode", "observe")
+	}
+	u.RawQuery = query.Encode()
+
+	header := http.Header{"Authorization": {"Bearer " + a.apiKey}}
+	conn, resp, err := websocket.DefaultDialer.DialContext(a.ctx, u.String(), header)
+	if err != nil {
+		// The server refuses an upgrade with a plain status, e.g. 404 or 429
+		if resp != nil {
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			return nil, &client.HTTPError{StatusCode: resp.StatusCode, Body: body}
+		}
+		return nil, fmt.Errorf("failed to connect to terminal: %w", err)
+	}
+	return conn, nil
+}
+
+// readTerminal writes the shell's output to stdout until the server closes
+// the connection
+func (a *app) readTerminal(conn *websocket.Conn) error {
+	var exit error
+	for {
+		typ, data, err := conn.ReadMessage()
+		if err != nil {
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) && closeErr.Code == websocket.CloseNormalClosure {
+				if closeErr.Text == "terminal attached elsewhere" {
+					return errors.New("terminal attached elsewhere")
+				}
+				return exit
+			}
+			if a.ctx.Err() != nil {
+				return exit
+			}
+			return fmt.Errorf("terminal connection lost: %w", err)
+		}
+		if typ == websocket.BinaryMessage {
+			a.stdout.Write(data)
+			continue
+		}
+
+		var msg terminalMessage
+		if json.Unmarshal(data, &msg) != nil {
+			continue
+		}
+		switch msg.Type {
+		case "output":
+			io.WriteString(a.stdout, msg.Data)
+		case "error":
+			return errors.New(msg.Data)
+		case "exit":
+			if msg.Code != nil && *msg.Code != 0 {
+				exit = exitStatus(*msg.Code)
+			}
+		case "sandbox_stopped":
+			exit = errors.New("sandbox stopped")
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+// lastUsedInterval is the minimum time between last_used_at writes for a key
+const lastUsedInterval = time.Minute
+
+// clientCache holds API client lookups by key hash for a short TTL. A nil
+// client is a cached miss, so repeated invalid keys don't reach the database.
+type clientCache struct {
+	ttl     time.Duration
+	maxSize int
+
+	mu       sync.Mutex
+	entries  map[[sha256.Size]byte]clientCacheEntry
+	lastUsed map[[sha256.Size]byte]time.Time
+}
+
+type clientCacheEntry struct {
+	client  *models.ApiClient
+	expires time.Time
+}
+
+// newClientCache returns nil when ttl is not positive, which disables caching
+func newClientCache(ttl time.Duration, maxSize int) *clientCache {
+	if ttl <= 0 {
+		return nil
+	}
+	if maxSize <= 0 {
+		maxSize = 1000
+	}
+	return &clientCache{
+		ttl:      ttl,
+		maxSize:  maxSize,
+		entries:  make(map[[sha256.Size]byte]clientCacheEntry),
+		lastUsed: make(map[[sha256.Size]byte]time.Time),
+	}
+}
+
+// get returns the cached lookup for apiKey and whether there was one
+func (c *clientCache) get(apiKey string) (*models.ApiClient, bool) {
+	if c == nil {
+		return nil, false
+	}
+	key := sha256.Sum256([]byte(apiKey))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.client, true
+}
+
+// put caches a lookup result; client is nil for an unknown key
+func (c *clientCache) put(apiKey string, client *models.ApiClient) {
+	if c == nil {
+		return
+	}
+	key := sha256.Sum256([]byte(apiKey))
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.maxSize {
+		c.evict(now)
+	}
+	c.entries[key] = clientCacheEntry{client: client, expires: now.Add(c.ttl)}
+}
+
+// evict drops expired entries, or an arbitrary one if none have expired.
+// Called with mu held.
+func (c *clientCache) evict(now time.Time) {
+	for key, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+	if len(c.entries) < c.maxSize {
+		return
+	}
+	for key := range c.entries {
+		delete(c.entries, key)
+		return
+	}
+}
+
+// flush drops every cached lookup so client changes take effect at once
+func (c *clientCache) flush() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.entries)
+	clear(c.lastUsed)
+}
+
+// shouldRecordUse reports whether last_used_at is due for apiKey, and if so
+// marks it written. Without a cache every use is recorded.
+func (c *clientCache) shouldRecordUse(apiKey string) bool {
+	if c == nil {
+		return true
+	}
+	key := sha256.Sum256([]byte(apiKey))
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if last, ok := c.lastUsed[key]; ok && now.Sub(last) < lastUsedInterval {
+		return false
+	}
+	// Only valid keys get here, but rotated ones linger until a flush
+	if len(c.lastUsed) >= c.maxSize {
+		for k, last := range c.lastUsed {
+			if now.Sub(last) >= lastUsedInterval {
+				delete(c.lastUsed, k)
+			}
+		}
+	}
+	c.lastUsed[key] = now
+	return true
+}
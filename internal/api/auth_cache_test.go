@@ -0,0 +1,105 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/storage"
+)
+
+// countingRepo counts client lookups and last_used_at writes
+type countingRepo struct {
+	storage.Repository
+	clients  map[string]*models.ApiClient
+	lookups  int
+	lastUsed chan string
+
+	jwtClients map[string]int // client IDs by JWT subject
+}
+
+func (r *countingRepo) GetClientByApiKey(ctx context.Context, apiKey string) (*models.ApiClient, error) {
+	r.lookups++
+	return r.clients[apiKey], nil
+}
+
+func (r *countingRepo) EnsureJWTClient(ctx context.Context, subject, name string) (int, error) {
+	if r.jwtClients == nil {
+		r.jwtClients = make(map[string]int)
+	}
+	if _, ok := r.jwtClients[subject]; !ok {
+		r.jwtClients[subject] = 100 + len(r.jwtClients)
+	}
+	return r.jwtClients[subject], nil
+}
+
+func (r *countingRepo) UpdateClientLastUsed(ctx context.Context, apiKey string) error {
+	r.lastUsed <- apiKey
+	return nil
+}
+
+func TestAuthCache(t *testing.T) {
+	repo := &countingRepo{
+		clients:  map[string]*models.ApiClient{"sk_valid": {Name: "test", ApiKey: "sk_valid", IsActive: true}},
+		lastUsed: make(chan string, 10),
+	}
+	auth := NewAuthMiddleware(repo, time.Minute, 10)
+	handler := auth.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	status := func(apiKey string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-API-Key", apiKey)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	for i := 0; i < 3; i++ {
+		if code := status("sk_valid"); code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", code)
+		}
+		if code := status("sk_invalid"); code != http.StatusUnauthorized {
+			t.Fatalf("expected 401, got %d", code)
+		}
+	}
+	if repo.lookups != 2 {
+		t.Errorf("expected one lookup per key, got %d", repo.lookups)
+	}
+	<-repo.lastUsed
+	if len(repo.lastUsed) != 0 {
+		t.Errorf("expected last_used_at written once, got %d more", len(repo.lastUsed))
+	}
+
+	// A deactivation is visible once the cache is flushed
+	repo.clients["sk_valid"] = &models.ApiClient{Name: "test", ApiKey: "sk_valid", IsActive: false}
+	auth.FlushCache()
+	if code := status("sk_valid"); code != http.StatusUnauthorized {
+		t.Errorf("expected the deactivated client rejected, got %d", code)
+	}
+}
+
+func TestClientCacheBoundedAndExpiring(t *testing.T) {
+	cache := newClientCache(time.Minute, 2)
+	for _, key := range []string{"a", "b", "c"} {
+		cache.put(key, nil)
+	}
+	if len(cache.entries) != 2 {
+		t.Errorf("expected the cache capped at 2 entries, got %d", len(cache.entries))
+	}
+	if _, ok := cache.get("c"); !ok {
+		t.Error("expected the newest entry kept")
+	}
+
+	cache.entries[[32]byte{}] = clientCacheEntry{expires: time.Now().Add(-time.Second)}
+	cache.put("d", nil)
+	if _, ok := cache.entries[[32]byte{}]; ok {
+		t.Error("expected the expired entry evicted first")
+	}
+
+	if newClientCache(0, 10) != nil {
+		t.Error("expected a zero TTL to disable the cache")
+	}
+}
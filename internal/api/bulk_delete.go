@@ -0,0 +1,115 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/sandbox"
+)
+
+// bulkDeleteResultDTO is the outcome of deleting one sandbox in a bulk delete
+type bulkDeleteResultDTO struct {
+	SandboxID string `json:"sandbox_id"`
+	Success   bool   `json:"success"`
+	// Pending marks a deletion that continues in the background
+	Pending bool   `json:"pending,omitempty"`
+	Code    string `json:"code,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// bulkDeleteResponseDTO reports a bulk delete, one result per selected sandbox
+type bulkDeleteResponseDTO struct {
+	Results []bulkDeleteResultDTO `json:"results"`
+	Total   int                   `json:"total"`
+	Deleted int                   `json:"deleted"`
+	Failed  int                   `json:"failed"`
+}
+
+// handleBulkDeleteSandboxes deletes sandboxes selected by ID or by filter.
+// Clients without sandboxes:admin only reach their own sandboxes; foreign IDs
+// are reported as not found.
+func (s *Server) handleBulkDeleteSandboxes(w http.ResponseWriter, r *http.Request) {
+	var req models.BulkDeleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_request", "invalid JSON body")
+		return
+	}
+
+	if len(req.IDs) > 0 && req.HasFilter() {
+		respondError(w, http.StatusBadRequest, "validation_error", "ids can't be combined with filters")
+		return
+	}
+	if len(req.IDs) == 0 && !req.HasFilter() {
+		respondError(w, http.StatusBadRequest, "validation_error", "ids or at least one filter is required")
+		return
+	}
+	for _, id := range req.IDs {
+		if id == "" {
+			respondError(w, http.StatusBadRequest, "validation_error", "ids must not be empty")
+			return
+		}
+	}
+
+	opts := sandbox.BulkDeleteOptions{
+		IDs: req.IDs,
+		Filters: models.ListFilters{
+			UserID:     req.UserID,
+			TemplateID: req.TemplateID,
+			Status:     req.Status,
+
+			OwnerClientID: sandboxOwnerScope(r),
+		},
+	}
+	if req.CreatedBefore != nil {
+		opts.Filters.CreatedBefore = *req.CreatedBefore
+	}
+
+	results, err := s.sandboxManager.BulkDelete(r.Context(), opts)
+	if err != nil {
+		if errors.Is(err, sandbox.ErrTooManySandboxes) {
+			respondError(w, http.StatusBadRequest, "too_many_sandboxes", err.Error())
+			return
+		}
+		slog.Error("failed to bulk delete sandboxes", "error", err)
+		respondError(w, http.StatusInternalServerError, "internal_error", "failed to delete sandboxes")
+		return
+	}
+
+	resp := bulkDeleteResponseDTO{Results: make([]bulkDeleteResultDTO, 0, len(results)), Total: len(results)}
+	for _, res := range results {
+		dto := toBulkDeleteResultDTO(res)
+		if dto.Success {
+			resp.Deleted++
+		} else {
+			resp.Failed++
+		}
+		resp.Results = append(resp.Results, dto)
+	}
+
+	slog.Info("bulk deleted sandboxes", "total", resp.Total, "deleted", resp.Deleted, "failed", resp.Failed)
+	respondJSON(w, http.StatusOK, resp)
+}
+
+func toBulkDeleteResultDTO(res *sandbox.BulkDeleteResult) bulkDeleteResultDTO {
+	dto := bulkDeleteResultDTO{SandboxID: res.SandboxID}
+	switch {
+	case res.Err == nil:
+		dto.Success = true
+	case errors.Is(res.Err, sandbox.ErrOperationPending):
+		dto.Success = true
+		dto.Pending = true
+	case errors.Is(res.Err, sandbox.ErrSandboxNotFound):
+		dto.Code, dto.Error = "not_found", "sandbox not found"
+	case errors.Is(res.Err, sandbox.ErrOperationInProgress):
+		dto.Code, dto.Error = "operation_in_progress", "another operation is already running for this sandbox"
+	case errors.Is(res.Err, sandbox.ErrDeprovisionFailed):
+		dto.Code, dto.Error = "deprovision_failed", "sandbox services failed to deprovision; the deletion will be retried"
+	default:
+		slog.Error("failed to delete sandbox", "error", res.Err, "id", res.SandboxID)
+		dto.Code, dto.Error = "internal_error", "failed to delete sandbox"
+	}
+	return dto
+}
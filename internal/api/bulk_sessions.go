@@ -0,0 +1,103 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/sandbox"
+)
+
+// handleBulkCreateSessions creates one session per entry for a cohort of
+// candidates. Shared settings are checked once; entries that fail are
+// reported next to the ones that were created.
+func (s *Server) handleBulkCreateSessions(w http.ResponseWriter, r *http.Request) {
+	var req models.BulkCreateSessionsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_request", "invalid JSON body")
+		return
+	}
+
+	if len(req.Entries) == 0 {
+		respondError(w, http.StatusBadRequest, "validation_error", "entries must not be empty")
+		return
+	}
+	if req.UniqueKey != "" {
+		respondError(w, http.StatusBadRequest, "validation_error", "unique_key is set per entry")
+		return
+	}
+	if msg := validateCreateSession(req.CreateSessionRequest); msg != "" {
+		respondError(w, http.StatusBadRequest, "validation_error", msg)
+		return
+	}
+
+	createdBy := ""
+	if client := ClientFromContext(r.Context()); client != nil {
+		createdBy, req.OwnerClientID = client.Name, client.ID
+	}
+
+	results, err := s.sandboxManager.CreateSessions(r.Context(), req, createdBy)
+	if err != nil {
+		switch {
+		case errors.Is(err, sandbox.ErrPrewarmTooLate), errors.Is(err, sandbox.ErrCallbackNotAllowed):
+			respondError(w, http.StatusBadRequest, "validation_error", err.Error())
+		case errors.Is(err, sandbox.ErrTooManySessions):
+			respondError(w, http.StatusBadRequest, "too_many_sessions", err.Error())
+		case errors.Is(err, sandbox.ErrTemplateNotFound):
+			respondError(w, http.StatusNotFound, "template_not_found", "template not found")
+		case errors.Is(err, sandbox.ErrTemplateDisabled):
+			respondError(w, http.StatusConflict, "template_disabled", "template is disabled")
+		case errors.Is(err, sandbox.ErrTaskNotFound):
+			respondError(w, http.StatusNotFound, "task_not_found", "task not found")
+		default:
+			if respondTTLCapError(w, err) {
+				return
+			}
+			slog.Error("failed to bulk create sessions", "error", err)
+			respondError(w, http.StatusInternalServerError, "internal_error", "failed to create sessions")
+		}
+		return
+	}
+
+	resp := models.BulkCreateSessionsResponse{Results: make([]models.BulkCreateSessionResult, 0, len(results)), Total: len(results)}
+	for i, res := range results {
+		result := models.BulkCreateSessionResult{Index: i}
+		if res.Err == nil {
+			result.Success = true
+			result.Session = s.toCreateSessionResponse(res.Session)
+			resp.Created++
+		} else {
+			result.Code, result.Error = bulkSessionError(res.Err, i)
+			resp.Failed++
+		}
+		resp.Results = append(resp.Results, result)
+	}
+
+	slog.Info("bulk created sessions", "total", resp.Total, "created", resp.Created, "failed", resp.Failed)
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// bulkSessionError maps the failure of one entry to the code and message
+// creating that session alone would answer with
+func bulkSessionError(err error, index int) (code, msg string) {
+	var capErr *sandbox.TTLCapError
+	switch {
+	case errors.Is(err, sandbox.ErrTemplateNotFound):
+		return "template_not_found", "template not found"
+	case errors.Is(err, sandbox.ErrTemplateDisabled):
+		return "template_disabled", "template is disabled"
+	case errors.Is(err, sandbox.ErrTaskNotFound):
+		return "task_not_found", "task not found"
+	case errors.Is(err, sandbox.ErrSessionConflict):
+		return "session_conflict", "another session for this candidate is already active"
+	case errors.Is(err, sandbox.ErrPrewarmTooLate), errors.Is(err, sandbox.ErrCallbackNotAllowed):
+		return "validation_error", err.Error()
+	case errors.As(err, &capErr):
+		return "ttl_exceeds_max", capErr.Error()
+	default:
+		slog.Error("failed to create session", "error", err, "index", index)
+		return "internal_error", "failed to create session"
+	}
+}
@@ -1,9 +1,18 @@
 package api
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/templates"
 )
 
 // Catalog handlers — hierarchical browsing of domains/projects/tasks
@@ -11,7 +20,7 @@ import (
 func (s *Server) handleListDomains(w http.ResponseWriter, r *http.Request) {
 	domains := s.templateLoader.ListDomains()
 	respondJSON(w, http.StatusOK, map[string]interface{}{
-		"domains": domains,
+		"domains": renderList(r, domains, toDomainDTO),
 		"total":   len(domains),
 	})
 }
@@ -23,7 +32,7 @@ func (s *Server) handleGetDomain(w http.ResponseWriter, r *http.Request) {
 		respondError(w, http.StatusNotFound, "not_found", "domain not found")
 		return
 	}
-	respondJSON(w, http.StatusOK, domain)
+	respondJSON(w, http.StatusOK, render(r, domain, toDomainDTO))
 }
 
 func (s *Server) handleListProjects(w http.ResponseWriter, r *http.Request) {
@@ -34,7 +43,7 @@ func (s *Server) handleListProjects(w http.ResponseWriter, r *http.Request) {
 	}
 	projects := s.templateLoader.ListProjects(domainID)
 	respondJSON(w, http.StatusOK, map[string]interface{}{
-		"projects": projects,
+		"projects": renderList(r, projects, toProjectDTO),
 		"total":    len(projects),
 	})
 }
@@ -49,7 +58,7 @@ func (s *Server) handleGetProject(w http.ResponseWriter, r *http.Request) {
 		respondError(w, http.StatusNotFound, "not_found", "project not found")
 		return
 	}
-	respondJSON(w, http.StatusOK, project)
+	respondJSON(w, http.StatusOK, render(r, project, toProjectDTO))
 }
 
 func (s *Server) handleListTasks(w http.ResponseWriter, r *http.Request) {
@@ -63,9 +72,15 @@ func (s *Server) handleListTasks(w http.ResponseWriter, r *http.Request) {
 	}
 
 	tasks := s.templateLoader.ListTasks(projectID)
+
+	resp := make([]taskResponse, 0, len(tasks))
+	for _, t := range tasks {
+		resp = append(resp, s.taskWithUsage(r, t))
+	}
+
 	respondJSON(w, http.StatusOK, map[string]interface{}{
-		"tasks": tasks,
-		"total": len(tasks),
+		"tasks": renderList(r, resp, toTaskDTO),
+		"total": len(resp),
 	})
 }
 
@@ -80,5 +95,77 @@ func (s *Server) handleGetTask(w http.ResponseWriter, r *http.Request) {
 		respondError(w, http.StatusNotFound, "not_found", "task not found")
 		return
 	}
-	respondJSON(w, http.StatusOK, task)
+	respondJSON(w, http.StatusOK, render(r, s.taskWithUsage(r, task), toTaskDTO))
+}
+
+// taskResponse is a catalog task plus a summary of its recent usage
+type taskResponse struct {
+	*models.CatalogTask
+	Usage *models.UsageSummary `json:"usage"`
+}
+
+func (s *Server) taskWithUsage(r *http.Request, t *models.CatalogTask) taskResponse {
+	return taskResponse{
+		CatalogTask: localizeTask(r, t),
+		Usage:       usageOrEmpty(s.sandboxManager.TaskUsageSummary(r.Context(), t.ID)),
+	}
+}
+
+// handleExportCatalog sends the catalog as loaded as a tar.gz of its
+// domain/project/task YAML tree
+func (s *Server) handleExportCatalog(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	if err := s.templateLoader.ExportCatalog(&buf); err != nil {
+		slog.Error("failed to export catalog", "error", err)
+		respondError(w, http.StatusInternalServerError, "internal_error", "failed to export catalog")
+		return
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="catalog-%s.tar.gz"`, time.Now().UTC().Format("20060102-150405")))
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// handleImportCatalog checks a catalog archive against the live catalog and
+// reports what it changes, replacing the catalog with it when apply is true
+func (s *Server) handleImportCatalog(w http.ResponseWriter, r *http.Request) {
+	apply := false
+	if v := r.URL.Query().Get("apply"); v != "" {
+		var err error
+		if apply, err = strconv.ParseBool(v); err != nil {
+			respondError(w, http.StatusBadRequest, "validation_error", "apply must be true or false")
+			return
+		}
+	}
+
+	body := http.MaxBytesReader(w, r.Body, templates.MaxCatalogArchiveSize)
+	summary, err := s.templateLoader.ImportCatalog(body, apply)
+	var importErr *templates.ImportError
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &importErr):
+		respondJSON(w, http.StatusUnprocessableEntity, importErr)
+		return
+	case errors.As(err, &tooLarge):
+		respondError(w, http.StatusRequestEntityTooLarge, "validation_error", fmt.Sprintf("archive exceeds %d MB", templates.MaxCatalogArchiveSize>>20))
+		return
+	case errors.Is(err, templates.ErrInvalidArchive):
+		respondError(w, http.StatusBadRequest, "invalid_archive", err.Error())
+		return
+	case errors.Is(err, templates.ErrNoTemplatesDir):
+		respondError(w, http.StatusConflict, "not_loaded", err.Error())
+		return
+	case err != nil:
+		slog.Error("failed to import catalog", "error", err)
+		respondError(w, http.StatusInternalServerError, "import_failed", err.Error())
+		return
+	}
+
+	if apply {
+		slog.Info("catalog imported", "domains", summary.Domains, "projects", summary.Projects, "tasks", summary.Tasks)
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"applied": apply,
+		"summary": summary,
+	})
 }
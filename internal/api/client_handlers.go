@@ -0,0 +1,268 @@
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+// clientDTO is the v1 rendering of an API client. The key is masked except in
+// the responses that issue it: create and rotate.
+type clientDTO struct {
+	ID          int               `json:"id"`
+	Name        string            `json:"name"`
+	ApiKey      string            `json:"api_key"`
+	IsActive    bool              `json:"is_active"`
+	Permissions []string          `json:"permissions"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	LastUsedAt  *time.Time        `json:"last_used_at"`
+
+	ExpiresAt    *time.Time `json:"expires_at"` // null for keys that never expire
+	AllowedCIDRs []string   `json:"allowed_cidrs,omitempty"`
+}
+
+func newClientDTO(c *models.ApiClient, revealKey bool) clientDTO {
+	key := c.MaskedApiKey()
+	if revealKey {
+		key = c.ApiKey
+	}
+	permissions := c.Permissions
+	if permissions == nil {
+		permissions = []string{}
+	}
+	return clientDTO{
+		ID:          c.ID,
+		Name:        c.Name,
+		ApiKey:      key,
+		IsActive:    c.IsActive,
+		Permissions: permissions,
+		Metadata:    c.Metadata,
+		CreatedAt:   c.CreatedAt,
+		LastUsedAt:  c.LastUsedAt,
+
+		ExpiresAt:    c.ExpiresAt,
+		AllowedCIDRs: c.AllowedCIDRs,
+	}
+}
+
+// validatePermissions returns a message for the first malformed permission
+func validatePermissions(permissions []string) string {
+	for _, p := range permissions {
+		if !models.ValidPermission(p) {
+			return "invalid permission " + strconv.Quote(p) + " (expected * or <resource>:<action>)"
+		}
+	}
+	return ""
+}
+
+// validateCIDRs returns a message for the first entry that isn't a CIDR prefix
+func validateCIDRs(cidrs []string) string {
+	for _, cidr := range cidrs {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			return "invalid allowed_cidrs entry " + strconv.Quote(cidr) + " (expected a CIDR such as 10.0.0.0/8 or 2001:db8::/32)"
+		}
+	}
+	return ""
+}
+
+// loadClient resolves {id} to a client, writing the error response on failure
+func (s *Server) loadClient(w http.ResponseWriter, r *http.Request) *models.ApiClient {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id <= 0 {
+		respondError(w, http.StatusBadRequest, "validation_error", "client id must be a positive integer")
+		return nil
+	}
+
+	client, err := s.repo.GetClient(r.Context(), id)
+	if err != nil {
+		slog.Error("failed to get api client", "error", err, "client_id", id)
+		respondError(w, http.StatusInternalServerError, "internal_error", "failed to get client")
+		return nil
+	}
+	if client == nil {
+		respondError(w, http.StatusNotFound, "not_found", "client not found")
+		return nil
+	}
+	return client
+}
+
+func (s *Server) handleListClients(w http.ResponseWriter, r *http.Request) {
+	clients, err := s.repo.ListClients(r.Context())
+	if err != nil {
+		slog.Error("failed to list api clients", "error", err)
+		respondError(w, http.StatusInternalServerError, "internal_error", "failed to list clients")
+		return
+	}
+
+	dtos := make([]clientDTO, 0, len(clients))
+	for _, c := range clients {
+		dtos = append(dtos, newClientDTO(c, false))
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"clients": dtos,
+		"total":   len(dtos),
+	})
+}
+
+func (s *Server) handleCreateClient(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateClientRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_request", "invalid JSON body")
+		return
+	}
+	if req.Name == "" {
+		respondError(w, http.StatusBadRequest, "validation_error", "name is required")
+		return
+	}
+	if msg := validatePermissions(req.Permissions); msg != "" {
+		respondError(w, http.StatusBadRequest, "validation_error", msg)
+		return
+	}
+	if msg := validateCIDRs(req.AllowedCIDRs); msg != "" {
+		respondError(w, http.StatusBadRequest, "validation_error", msg)
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		respondError(w, http.StatusBadRequest, "validation_error", "expires_at must be in the future")
+		return
+	}
+
+	apiKey, err := models.GenerateApiKey()
+	if err != nil {
+		slog.Error("failed to generate api key", "error", err)
+		respondError(w, http.StatusInternalServerError, "internal_error", "failed to create client")
+		return
+	}
+
+	client := &models.ApiClient{
+		Name:         req.Name,
+		ApiKey:       apiKey,
+		IsActive:     true,
+		Permissions:  req.Permissions,
+		Metadata:     req.Metadata,
+		ExpiresAt:    req.ExpiresAt,
+		AllowedCIDRs: req.AllowedCIDRs,
+	}
+	if err := s.repo.CreateClient(r.Context(), client); err != nil {
+		slog.Error("failed to create api client", "error", err, "name", req.Name)
+		respondError(w, http.StatusInternalServerError, "internal_error", "failed to create client")
+		return
+	}
+
+	slog.Info("api client created", "client_id", client.ID, "name", client.Name, "key_prefix", client.MaskedApiKey())
+	respondJSON(w, http.StatusCreated, newClientDTO(client, true))
+}
+
+func (s *Server) handleUpdateClient(w http.ResponseWriter, r *http.Request) {
+	client := s.loadClient(w, r)
+	if client == nil {
+		return
+	}
+
+	var req models.UpdateClientRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_request", "invalid JSON body")
+		return
+	}
+	if req.Name != nil {
+		if *req.Name == "" {
+			respondError(w, http.StatusBadRequest, "validation_error", "name must not be empty")
+			return
+		}
+		client.Name = *req.Name
+	}
+	if req.Permissions != nil {
+		if msg := validatePermissions(req.Permissions); msg != "" {
+			respondError(w, http.StatusBadRequest, "validation_error", msg)
+			return
+		}
+		client.Permissions = req.Permissions
+	}
+	if req.IsActive != nil {
+		client.IsActive = *req.IsActive
+	}
+	if req.Metadata != nil {
+		client.Metadata = req.Metadata
+	}
+	if req.ExpiresAt != nil && req.ClearExpiry {
+		respondError(w, http.StatusBadRequest, "validation_error", "expires_at and clear_expiry are mutually exclusive")
+		return
+	}
+	if req.ExpiresAt != nil {
+		client.ExpiresAt = req.ExpiresAt
+	}
+	if req.ClearExpiry {
+		client.ExpiresAt = nil
+	}
+	if req.AllowedCIDRs != nil {
+		if msg := validateCIDRs(req.AllowedCIDRs); msg != "" {
+			respondError(w, http.StatusBadRequest, "validation_error", msg)
+			return
+		}
+		client.AllowedCIDRs = req.AllowedCIDRs
+	}
+
+	if err := s.repo.UpdateClient(r.Context(), client); err != nil {
+		slog.Error("failed to update api client", "error", err, "client_id", client.ID)
+		respondError(w, http.StatusInternalServerError, "internal_error", "failed to update client")
+		return
+	}
+
+	s.authMiddleware.FlushCache()
+
+	respondJSON(w, http.StatusOK, newClientDTO(client, false))
+}
+
+func (s *Server) handleDeactivateClient(w http.ResponseWriter, r *http.Request) {
+	client := s.loadClient(w, r)
+	if client == nil {
+		return
+	}
+
+	client.IsActive = false
+	if err := s.repo.UpdateClient(r.Context(), client); err != nil {
+		slog.Error("failed to deactivate api client", "error", err, "client_id", client.ID)
+		respondError(w, http.StatusInternalServerError, "internal_error", "failed to deactivate client")
+		return
+	}
+
+	s.authMiddleware.FlushCache()
+	slog.Info("api client deactivated", "client_id", client.ID, "name", client.Name)
+	respondJSON(w, http.StatusOK, map[string]string{
+		"message": "client deactivated",
+	})
+}
+
+func (s *Server) handleRotateClientKey(w http.ResponseWriter, r *http.Request) {
+	client := s.loadClient(w, r)
+	if client == nil {
+		return
+	}
+
+	apiKey, err := models.GenerateApiKey()
+	if err != nil {
+		slog.Error("failed to generate api key", "error", err)
+		respondError(w, http.StatusInternalServerError, "internal_error", "failed to rotate key")
+		return
+	}
+
+	// The old key stops authenticating as soon as the row is updated
+	if err := s.repo.RotateClientKey(r.Context(), client.ID, apiKey); err != nil {
+		slog.Error("failed to rotate api key", "error", err, "client_id", client.ID)
+		respondError(w, http.StatusInternalServerError, "internal_error", "failed to rotate key")
+		return
+	}
+	client.ApiKey = apiKey
+
+	s.authMiddleware.FlushCache()
+	slog.Info("api key rotated", "client_id", client.ID, "name", client.Name, "key_prefix", client.MaskedApiKey())
+	respondJSON(w, http.StatusOK, newClientDTO(client, true))
+}
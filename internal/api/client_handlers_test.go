@@ -0,0 +1,176 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/config"
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+// statusWithKey sends a GET authenticated with apiKey and returns the status
+func statusWithKey(router http.Handler, apiKey, path string) int {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestClientFlow(t *testing.T) {
+	router := newMemoryServer(t)
+
+	var created clientDTO
+	req := models.CreateClientRequest{Name: "grader", Permissions: []string{"templates:read"}}
+	if code := call(t, router, http.MethodPost, "/api/v1/clients", req, &created); code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d", code)
+	}
+	if !strings.HasPrefix(created.ApiKey, models.ApiKeyPrefix) || len(created.ApiKey) != 51 {
+		t.Fatalf("expected a full generated key, got %q", created.ApiKey)
+	}
+	if code := statusWithKey(router, created.ApiKey, "/api/v1/templates"); code != http.StatusOK {
+		t.Errorf("new key: expected 200, got %d", code)
+	}
+	if code := statusWithKey(router, created.ApiKey, "/api/v1/clients"); code != http.StatusForbidden {
+		t.Errorf("new key on clients: expected 403, got %d", code)
+	}
+
+	var list struct {
+		Clients []clientDTO `json:"clients"`
+	}
+	if code := call(t, router, http.MethodGet, "/api/v1/clients", nil, &list); code != http.StatusOK || len(list.Clients) != 2 {
+		t.Fatalf("list: expected two clients, got %d with %d", code, len(list.Clients))
+	}
+	for _, c := range list.Clients {
+		if c.ApiKey == created.ApiKey || c.ApiKey == memoryTestKey {
+			t.Errorf("list: expected masked keys, got %q", c.ApiKey)
+		}
+	}
+
+	path := "/api/v1/clients/" + strconv.Itoa(created.ID)
+	var rotated clientDTO
+	if code := call(t, router, http.MethodPost, path+"/rotate", nil, &rotated); code != http.StatusOK || rotated.ApiKey == created.ApiKey {
+		t.Fatalf("rotate: expected a new key, got %d with %q", code, rotated.ApiKey)
+	}
+	if code := statusWithKey(router, created.ApiKey, "/api/v1/templates"); code != http.StatusUnauthorized {
+		t.Errorf("old key: expected 401, got %d", code)
+	}
+	if code := statusWithKey(router, rotated.ApiKey, "/api/v1/templates"); code != http.StatusOK {
+		t.Errorf("rotated key: expected 200, got %d", code)
+	}
+
+	update := map[string]any{"permissions": []string{"sessions bad"}}
+	if code := call(t, router, http.MethodPatch, path, update, nil); code != http.StatusBadRequest {
+		t.Errorf("update with a malformed permission: expected 400, got %d", code)
+	}
+
+	if code := call(t, router, http.MethodDelete, path, nil, nil); code != http.StatusOK {
+		t.Fatalf("deactivate: expected 200, got %d", code)
+	}
+	if code := statusWithKey(router, rotated.ApiKey, "/api/v1/templates"); code != http.StatusUnauthorized {
+		t.Errorf("deactivated key: expected 401, got %d", code)
+	}
+	if code := call(t, router, http.MethodDelete, "/api/v1/clients/9999", nil, nil); code != http.StatusNotFound {
+		t.Errorf("deactivate missing: expected 404, got %d", code)
+	}
+}
+
+// authErrorFrom sends a GET with apiKey from a proxy forwarding for forwardedFor
+func authErrorFrom(router http.Handler, apiKey, forwardedFor string) (int, string) {
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/templates", nil)
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	var body AuthError
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	return rec.Code, body.Error
+}
+
+func TestClientExpiryAndAllowedCIDRs(t *testing.T) {
+	// Requests come from 192.0.2.1, the proxy
+	router, _ := newMemoryServerConfig(t, config.ServerConfig{TrustedProxies: []string{"192.0.2.0/24"}})
+
+	var created clientDTO
+	req := models.CreateClientRequest{
+		Name:         "grader",
+		Permissions:  []string{"templates:read"},
+		AllowedCIDRs: []string{"10.0.0.0/8", "2001:db8::/32"},
+	}
+	if code := call(t, router, http.MethodPost, "/api/v1/clients", req, &created); code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d", code)
+	}
+	if len(created.AllowedCIDRs) != 2 || created.ExpiresAt != nil {
+		t.Fatalf("expected the allowlist and no expiry, got %+v", created)
+	}
+
+	for _, tc := range []struct {
+		forwardedFor string
+		want         int
+	}{
+		{"10.1.2.3", http.StatusOK},
+		{"192.168.1.10, 2001:db8:1::7", http.StatusOK},    // the hop the proxy saw is the client
+		{"10.1.2.3, 192.168.1.10", http.StatusForbidden},  // earlier hops are the client's to write
+		{"198.51.100.7, 192.0.2.9", http.StatusForbidden}, // trusted hops are skipped
+		{"2001:db9::7", http.StatusForbidden},
+		{"192.168.1.10", http.StatusForbidden},
+		{"", http.StatusForbidden}, // the test remote address, 192.0.2.1
+	} {
+		code, errCode := authErrorFrom(router, created.ApiKey, tc.forwardedFor)
+		if code != tc.want {
+			t.Errorf("from %q: expected %d, got %d", tc.forwardedFor, tc.want, code)
+		}
+		if code == http.StatusForbidden && errCode != "ip not allowed" {
+			t.Errorf("from %q: expected ip not allowed, got %q", tc.forwardedFor, errCode)
+		}
+	}
+
+	path := "/api/v1/clients/" + strconv.Itoa(created.ID)
+	if code := call(t, router, http.MethodPatch, path, map[string]any{"allowed_cidrs": []string{"10.0.0.300/8"}}, nil); code != http.StatusBadRequest {
+		t.Errorf("update with a malformed CIDR: expected 400, got %d", code)
+	}
+	var updated clientDTO
+	if code := call(t, router, http.MethodPatch, path, map[string]any{"allowed_cidrs": []string{}}, &updated); code != http.StatusOK || len(updated.AllowedCIDRs) != 0 {
+		t.Fatalf("clear allowlist: expected an empty list, got %d with %v", code, updated.AllowedCIDRs)
+	}
+	if code, _ := authErrorFrom(router, created.ApiKey, "192.168.1.10"); code != http.StatusOK {
+		t.Errorf("cleared allowlist: expected 200, got %d", code)
+	}
+
+	// Without the proxy trusted, a forwarded address can't get past the allowlist
+	direct := newMemoryServer(t)
+	var spoofed clientDTO
+	if code := call(t, direct, http.MethodPost, "/api/v1/clients", req, &spoofed); code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d", code)
+	}
+	if code, errCode := authErrorFrom(direct, spoofed.ApiKey, "10.1.2.3"); code != http.StatusForbidden || errCode != "ip not allowed" {
+		t.Errorf("spoofed X-Forwarded-For: expected 403 ip not allowed, got %d %q", code, errCode)
+	}
+
+	past := time.Now().Add(-time.Minute)
+	if code := call(t, router, http.MethodPost, "/api/v1/clients", models.CreateClientRequest{Name: "late", ExpiresAt: &past}, nil); code != http.StatusBadRequest {
+		t.Errorf("create already expired: expected 400, got %d", code)
+	}
+	// Updates may backdate the expiry, which revokes the key at once
+	if code := call(t, router, http.MethodPatch, path, map[string]any{"expires_at": past}, &updated); code != http.StatusOK || updated.ExpiresAt == nil {
+		t.Fatalf("set expiry: expected it set, got %d with %v", code, updated.ExpiresAt)
+	}
+	if code, errCode := authErrorFrom(router, created.ApiKey, ""); code != http.StatusUnauthorized || errCode != "key expired" {
+		t.Errorf("expired key: expected 401 key expired, got %d %q", code, errCode)
+	}
+
+	if code := call(t, router, http.MethodPatch, path, map[string]any{"clear_expiry": true}, &updated); code != http.StatusOK || updated.ExpiresAt != nil {
+		t.Fatalf("clear expiry: expected none, got %d with %v", code, updated.ExpiresAt)
+	}
+	if code, _ := authErrorFrom(router, created.ApiKey, ""); code != http.StatusOK {
+		t.Errorf("unexpired key: expected 200, got %d", code)
+	}
+}
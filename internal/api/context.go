@@ -8,7 +8,10 @@ import (
 
 type contextKey string
 
-const clientContextKey contextKey = "api_client"
+const (
+	clientContextKey contextKey = "api_client"
+	styleContextKey  contextKey = "api_style"
+)
 
 // ClientFromContext extracts ApiClient from context
 func ClientFromContext(ctx context.Context) *models.ApiClient {
@@ -23,3 +26,16 @@ func ClientFromContext(ctx context.Context) *models.ApiClient {
 func ContextWithClient(ctx context.Context, client *models.ApiClient) context.Context {
 	return context.WithValue(ctx, clientContextKey, client)
 }
+
+// styleFromContext returns the response style chosen for the request (v1 by default)
+func styleFromContext(ctx context.Context) apiStyle {
+	if style, ok := ctx.Value(styleContextKey).(apiStyle); ok {
+		return style
+	}
+	return styleV1
+}
+
+// contextWithStyle records the response style for the request
+func contextWithStyle(ctx context.Context, style apiStyle) context.Context {
+	return context.WithValue(ctx, styleContextKey, style)
+}
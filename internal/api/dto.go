@@ -0,0 +1,414 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/sandbox"
+)
+
+// Response DTOs decouple the API from the internal models. The v1 style uses
+// snake_case everywhere, omits empty strings and collections, and renders
+// optional timestamps as explicit nulls. The legacy style serializes the
+// internal models as before (camelCase catalog, duration nanoseconds) for
+// clients that haven't migrated yet.
+
+// apiStyle selects how responses are serialized
+type apiStyle string
+
+const (
+	styleV1     apiStyle = "v1"
+	styleLegacy apiStyle = "legacy"
+)
+
+// mediaTypeLegacy selects the legacy style through the Accept header
+const mediaTypeLegacy = "application/vnd.sandbox-engine.legacy+json"
+
+// apiStyleMiddleware resolves the response style from ?api_style= or, failing
+// that, the Accept header. The default is v1.
+func apiStyleMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		style := styleV1
+		switch v := r.URL.Query().Get("api_style"); v {
+		case "":
+			if strings.Contains(r.Header.Get("Accept"), mediaTypeLegacy) {
+				style = styleLegacy
+			}
+		case string(styleV1), string(styleLegacy):
+			style = apiStyle(v)
+		default:
+			respondError(w, http.StatusBadRequest, "validation_error", "api_style must be v1 or legacy")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(contextWithStyle(r.Context(), style)))
+	})
+}
+
+// render returns v mapped to its v1 DTO, or v itself for legacy clients
+func render[T, D any](r *http.Request, v T, toDTO func(T) D) any {
+	if styleFromContext(r.Context()) == styleLegacy {
+		return v
+	}
+	return toDTO(v)
+}
+
+// renderList is render for a slice; the v1 style never returns null for an empty list
+func renderList[T, D any](r *http.Request, vs []T, toDTO func(T) D) any {
+	if styleFromContext(r.Context()) == styleLegacy {
+		return vs
+	}
+	out := make([]D, 0, len(vs))
+	for _, v := range vs {
+		out = append(out, toDTO(v))
+	}
+	return out
+}
+
+// seconds renders a duration as whole seconds
+func seconds(d time.Duration) int64 {
+	return int64(d / time.Second)
+}
+
+// --- Sandboxes ---
+
+type sandboxDTO struct {
+	ID             string                 `json:"id"`
+	TemplateID     string                 `json:"template_id"`
+	UserID         string                 `json:"user_id"`
+	Status         models.SandboxStatus   `json:"status"`
+	StatusMessage  string                 `json:"status_message,omitempty"`
+	Host           string                 `json:"host,omitempty"`
+	OwnerClientID  int                    `json:"owner_client_id,omitempty"`
+	ContainerID    string                 `json:"container_id,omitempty"`
+	Services       map[string]*serviceDTO `json:"services,omitempty"`
+	Endpoints      map[string]string      `json:"endpoints,omitempty"`
+	Metadata       map[string]string      `json:"metadata,omitempty"`
+	CreatedAt      time.Time              `json:"created_at"`
+	StartedAt      *time.Time             `json:"started_at"` // null until the sandbox first runs
+	ExpiresAt      time.Time              `json:"expires_at"`
+	LastActivityAt *time.Time             `json:"last_activity_at"`     // null without terminal input
+	DeletedAt      *time.Time             `json:"deleted_at,omitempty"` // set when listed with include_deleted
+
+	ExpiryBehavior *models.ExpiryBehavior `json:"expiry_behavior,omitempty"` // omitted once failed, expired or deleted
+
+	// TerminalConnections counts the sandbox's open terminals on the replica
+	// that answered; it is only set on single sandbox responses
+	TerminalConnections *int `json:"terminal_connections,omitempty"`
+}
+
+func toSandboxDTO(sb *models.Sandbox) *sandboxDTO {
+	dto := &sandboxDTO{
+		ID:             sb.ID,
+		TemplateID:     sb.TemplateID,
+		UserID:         sb.UserID,
+		Status:         sb.Status,
+		StatusMessage:  sb.StatusMsg,
+		Host:           sb.Host,
+		OwnerClientID:  sb.OwnerClientID,
+		ContainerID:    sb.ContainerID,
+		Endpoints:      sb.Endpoints,
+		Metadata:       sb.Metadata,
+		CreatedAt:      sb.CreatedAt,
+		StartedAt:      sb.StartedAt,
+		ExpiresAt:      sb.ExpiresAt,
+		LastActivityAt: sb.LastActivityAt,
+		DeletedAt:      sb.DeletedAt,
+		ExpiryBehavior: sandbox.SandboxExpiry(sb),
+	}
+	if len(sb.Services) > 0 {
+		dto.Services = make(map[string]*serviceDTO, len(sb.Services))
+		for name, svc := range sb.Services {
+			dto.Services[name] = toServiceDTO(svc)
+		}
+	}
+	return dto
+}
+
+type serviceDTO struct {
+	Name          string                     `json:"name"`
+	Type          string                     `json:"type"`
+	Status        string                     `json:"status"`
+	StatusMessage string                     `json:"status_message,omitempty"`
+	Credentials   *models.ServiceCredentials `json:"credentials,omitempty"`
+	CreatedAt     time.Time                  `json:"created_at"`
+	LastCheckedAt *time.Time                 `json:"last_checked_at"` // null until checked
+}
+
+func toServiceDTO(svc *models.ServiceInstance) *serviceDTO {
+	return &serviceDTO{
+		Name:          svc.Name,
+		Type:          svc.Type,
+		Status:        svc.Status,
+		StatusMessage: svc.StatusMsg,
+		Credentials:   svc.Credentials,
+		CreatedAt:     svc.CreatedAt,
+		LastCheckedAt: svc.LastCheckedAt,
+	}
+}
+
+type eventDTO struct {
+	ID        int64                   `json:"id"`
+	Type      models.SandboxEventType `json:"type"`
+	Message   string                  `json:"message,omitempty"`
+	CreatedAt time.Time               `json:"created_at"`
+}
+
+func toEventDTO(ev *models.SandboxEvent) *eventDTO {
+	return &eventDTO{
+		ID:        ev.ID,
+		Type:      ev.Type,
+		Message:   ev.Message,
+		CreatedAt: ev.CreatedAt,
+	}
+}
+
+// --- Sessions ---
+
+type sessionDTO struct {
+	ID              string                `json:"id"`
+	Token           string                `json:"token"`
+	TemplateID      string                `json:"template_id"`
+	Status          models.SessionStatus  `json:"status"`
+	StatusMessage   string                `json:"status_message,omitempty"`
+	TTLSeconds      int                   `json:"ttl_seconds"`
+	SandboxID       string                `json:"sandbox_id,omitempty"`
+	TaskDescription string                `json:"task_description,omitempty"`
+	Env             map[string]string     `json:"env,omitempty"`
+	Metadata        map[string]string     `json:"metadata,omitempty"`
+	Services        []string              `json:"services,omitempty"`
+	UniqueKey       string                `json:"unique_key,omitempty"`
+	OnConflict      models.ConflictPolicy `json:"on_conflict,omitempty"`
+	CreatedBy       string                `json:"created_by,omitempty"`
+	CreatedAt       time.Time             `json:"created_at"`
+	ActivatedAt     *time.Time            `json:"activated_at"` // null until the candidate starts
+	ExpiresAt       *time.Time            `json:"expires_at"`   // null until activation
+	JoinBy          *time.Time            `json:"join_by,omitempty"`
+	PrewarmAt       *time.Time            `json:"prewarm_at,omitempty"`
+	RevokedAt       *time.Time            `json:"revoked_at,omitempty"`
+	RevokedBy       string                `json:"revoked_by,omitempty"`
+	SubmittedAt     *time.Time            `json:"submitted_at,omitempty"`
+
+	CallbackURL        string                     `json:"callback_url,omitempty"`
+	CallbackDeliveries []*models.CallbackDelivery `json:"callback_deliveries,omitempty"`
+
+	RecordTerminal bool `json:"record_terminal,omitempty"`
+}
+
+func toSessionDTO(s *models.Session) *sessionDTO {
+	return &sessionDTO{
+		ID:              s.ID,
+		Token:           s.Token,
+		TemplateID:      s.TemplateID,
+		Status:          s.Status,
+		StatusMessage:   s.StatusMessage,
+		TTLSeconds:      s.TTLSeconds,
+		SandboxID:       s.SandboxID,
+		TaskDescription: s.TaskDescription,
+		Env:             s.Env,
+		Metadata:        s.Metadata,
+		Services:        s.Services,
+		UniqueKey:       s.UniqueKey,
+		OnConflict:      s.OnConflict,
+		CreatedBy:       s.CreatedBy,
+		CreatedAt:       s.CreatedAt,
+		ActivatedAt:     s.ActivatedAt,
+		ExpiresAt:       s.ExpiresAt,
+		JoinBy:          s.JoinBy,
+		PrewarmAt:       s.PrewarmAt,
+		RevokedAt:       s.RevokedAt,
+		RevokedBy:       s.RevokedBy,
+		SubmittedAt:     s.SubmittedAt,
+
+		CallbackURL:        s.CallbackURL,
+		CallbackDeliveries: s.CallbackDeliveries,
+
+		RecordTerminal: s.RecordTerminal,
+	}
+}
+
+// --- Templates ---
+
+type templateDTO struct {
+	Name                 string                      `json:"name"`
+	Description          string                      `json:"description,omitempty"`
+	Descriptions         models.LocalizedText        `json:"descriptions,omitempty"`
+	BaseImage            string                      `json:"base_image"`
+	ImageReady           bool                        `json:"image_ready"`
+	TTLSeconds           int64                       `json:"ttl_seconds"`
+	MaxTTLSeconds        int64                       `json:"max_ttl_seconds,omitempty"`
+	Services             []string                    `json:"services,omitempty"`
+	Resources            resourcesDTO                `json:"resources"`
+	Env                  map[string]string           `json:"env,omitempty"`
+	Expose               []models.Port               `json:"expose,omitempty"`
+	Volumes              []models.Volume             `json:"volumes,omitempty"`
+	Commands             *commandsDTO                `json:"commands,omitempty"`
+	Command              []string                    `json:"command,omitempty"`
+	Entrypoint           []string                    `json:"entrypoint,omitempty"`
+	AllowCommandOverride bool                        `json:"allow_command_override"`
+	CredentialsDelivery  models.CredentialsDelivery  `json:"credentials_delivery"`
+	CredentialsFile      *models.CredentialsFileSpec `json:"credentials_file,omitempty"`
+	Labels               map[string]string           `json:"labels,omitempty"`
+	Annotations          map[string]string           `json:"annotations,omitempty"`
+	AutoExtend           *autoExtendDTO              `json:"auto_extend,omitempty"`
+	Tags                 []string                    `json:"tags,omitempty"`
+	Icon                 string                      `json:"icon,omitempty"`
+	Language             string                      `json:"language,omitempty"`
+	Enabled              bool                        `json:"enabled"`
+	Usage                *models.UsageSummary        `json:"usage"`
+}
+
+type resourcesDTO struct {
+	CPULimit      string `json:"cpu_limit,omitempty"`
+	MemoryLimit   string `json:"memory_limit,omitempty"`
+	CPURequest    string `json:"cpu_request,omitempty"`
+	MemoryRequest string `json:"memory_request,omitempty"`
+	DiskLimit     string `json:"disk_limit,omitempty"`
+	GPUs          string `json:"gpus,omitempty"`
+}
+
+type commandsDTO struct {
+	Init        []string `json:"init,omitempty"`
+	Start       []string `json:"start,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+	Healthcheck string   `json:"healthcheck,omitempty"`
+}
+
+type autoExtendDTO struct {
+	Enabled       *bool `json:"enabled"` // null inherits the engine default
+	WindowSeconds int64 `json:"window_seconds,omitempty"`
+	StepSeconds   int64 `json:"step_seconds,omitempty"`
+	MaxTTLSeconds int64 `json:"max_ttl_seconds,omitempty"`
+}
+
+func toTemplateDTO(t templateResponse) *templateDTO {
+	dto := &templateDTO{
+		Name:                 t.Name,
+		Description:          t.Description,
+		Descriptions:         t.Descriptions,
+		BaseImage:            t.BaseImage,
+		ImageReady:           t.ImageReady,
+		TTLSeconds:           seconds(t.TTL),
+		MaxTTLSeconds:        seconds(t.MaxTTL),
+		Services:             t.Services,
+		Resources:            resourcesDTO(t.Resources),
+		Env:                  t.Env,
+		Expose:               t.Expose,
+		Volumes:              t.Volumes,
+		Command:              t.Command,
+		Entrypoint:           t.Entrypoint,
+		AllowCommandOverride: t.AllowCommandOverride,
+		CredentialsDelivery:  t.CredentialsDelivery,
+		CredentialsFile:      t.CredentialsFile,
+		Labels:               t.Labels,
+		Annotations:          t.Annotations,
+		Tags:                 t.Tags,
+		Icon:                 t.Icon,
+		Language:             t.Language,
+		Enabled:              t.IsEnabled(),
+		Usage:                t.Usage,
+	}
+
+	c := t.Commands
+	if len(c.Init) > 0 || len(c.Start) > 0 || len(c.Stop) > 0 || c.Healthcheck != "" {
+		dto.Commands = &commandsDTO{
+			Init:        c.Init,
+			Start:       c.Start,
+			Stop:        c.Stop,
+			Healthcheck: c.Healthcheck,
+		}
+	}
+
+	if p := t.AutoExtend; p != nil {
+		dto.AutoExtend = &autoExtendDTO{
+			Enabled:       p.Enabled,
+			WindowSeconds: seconds(p.Window),
+			StepSeconds:   seconds(p.Step),
+			MaxTTLSeconds: seconds(p.MaxTTL),
+		}
+	}
+
+	return dto
+}
+
+// --- Catalog ---
+
+type domainDTO struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	Description   string `json:"description,omitempty"`
+	ProjectsCount int    `json:"projects_count"`
+	TasksCount    int    `json:"tasks_count"`
+}
+
+func toDomainDTO(d *models.Domain) *domainDTO {
+	return &domainDTO{
+		ID:            d.ID,
+		Name:          d.Name,
+		Description:   d.Description,
+		ProjectsCount: d.ProjectsCount,
+		TasksCount:    d.TasksCount,
+	}
+}
+
+type projectDTO struct {
+	ID          string `json:"id"`
+	DomainID    string `json:"domain_id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	TasksCount  int    `json:"tasks_count"`
+}
+
+func toProjectDTO(p *models.CatalogProject) *projectDTO {
+	return &projectDTO{
+		ID:          p.ID,
+		DomainID:    p.DomainID,
+		Name:        p.Name,
+		Description: p.Description,
+		TasksCount:  p.TasksCount,
+	}
+}
+
+type taskDTO struct {
+	ID               string               `json:"id"`
+	Code             string               `json:"code"`
+	Title            string               `json:"title"`
+	Description      string               `json:"description,omitempty"`
+	Titles           models.LocalizedText `json:"titles,omitempty"`
+	Descriptions     models.LocalizedText `json:"descriptions,omitempty"`
+	Difficulty       string               `json:"difficulty,omitempty"`
+	RequiredLevel    *string              `json:"required_level"` // null when open to all levels
+	TimeLimitSeconds int                  `json:"time_limit_seconds"`
+	Skills           []string             `json:"skills,omitempty"`
+	DomainID         string               `json:"domain_id"`
+	ProjectID        string               `json:"project_id"`
+	StarterRepo      *models.StarterRepo  `json:"starter_repo,omitempty"`
+	SetupCommands    []string             `json:"setup_commands,omitempty"`
+	Env              map[string]string    `json:"env,omitempty"`
+	Grading          *models.GradingSpec  `json:"grading,omitempty"`
+	Usage            *models.UsageSummary `json:"usage"`
+}
+
+func toTaskDTO(t taskResponse) *taskDTO {
+	return &taskDTO{
+		ID:               t.ID,
+		Code:             t.Code,
+		Title:            t.Title,
+		Description:      t.Description,
+		Titles:           t.Titles,
+		Descriptions:     t.Descriptions,
+		Difficulty:       t.Difficulty,
+		RequiredLevel:    t.RequiredLevel,
+		TimeLimitSeconds: t.TimeLimit,
+		Skills:           t.Skills,
+		DomainID:         t.DomainID,
+		ProjectID:        t.ProjectID,
+		StarterRepo:      t.StarterRepo,
+		SetupCommands:    t.SetupCommands,
+		Env:              t.Env,
+		Grading:          t.Grading,
+		Usage:            t.Usage,
+	}
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+func TestToSandboxDTO(t *testing.T) {
+	lastChecked := time.Date(2026, 3, 1, 12, 5, 0, 0, time.UTC)
+	sb := &models.Sandbox{
+		ID:        "sb-1",
+		Status:    models.StatusPending,
+		StatusMsg: "pulling image",
+		Services: map[string]*models.ServiceInstance{
+			"redis": {Name: "redis", Status: models.ServiceStatusUnhealthy, StatusMsg: "timeout", LastCheckedAt: &lastChecked},
+		},
+		Endpoints: map[string]string{},
+	}
+
+	dto := toSandboxDTO(sb)
+	if dto.StatusMessage != "pulling image" || dto.Services["redis"].StatusMessage != "timeout" || dto.Services["redis"].LastCheckedAt != &lastChecked {
+		t.Fatalf("unexpected mapping %+v", dto)
+	}
+
+	data, err := json.Marshal(dto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := string(data)
+
+	// Unset timestamps are explicit nulls, empty collections are omitted
+	for _, want := range []string{`"started_at":null`, `"last_activity_at":null`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %s in %s", want, body)
+		}
+	}
+	for _, unwanted := range []string{`"endpoints"`, `"metadata"`, `"container_id"`} {
+		if strings.Contains(body, unwanted) {
+			t.Errorf("expected %s to be omitted from %s", unwanted, body)
+		}
+	}
+}
+
+func TestToTemplateDTO(t *testing.T) {
+	enabled := true
+	tmpl := templateResponse{
+		Template: &models.Template{
+			Name:      "python",
+			BaseImage: "python:3.12",
+			TTL:       90 * time.Minute,
+			Resources: models.Resources{CPULimit: "1", MemoryLimit: "512m"},
+			AutoExtend: &models.AutoExtendPolicy{
+				Enabled: &enabled,
+				Window:  5 * time.Minute,
+				MaxTTL:  4 * time.Hour,
+			},
+		},
+		ImageReady: true,
+	}
+
+	dto := toTemplateDTO(tmpl)
+	if dto.TTLSeconds != 5400 || !dto.ImageReady || dto.Resources.CPULimit != "1" {
+		t.Errorf("unexpected mapping %+v", dto)
+	}
+	if dto.AutoExtend == nil || dto.AutoExtend.WindowSeconds != 300 || dto.AutoExtend.StepSeconds != 0 || dto.AutoExtend.MaxTTLSeconds != 14400 {
+		t.Errorf("unexpected auto_extend %+v", dto.AutoExtend)
+	}
+	if dto.Commands != nil {
+		t.Errorf("expected no commands section without lifecycle commands, got %+v", dto.Commands)
+	}
+
+	tmpl.Commands = models.Commands{Init: []string{"make deps"}}
+	if dto := toTemplateDTO(tmpl); dto.Commands == nil || dto.Commands.Init[0] != "make deps" {
+		t.Errorf("expected init commands, got %+v", dto.Commands)
+	}
+}
+
+func TestToCatalogDTOs(t *testing.T) {
+	level := "senior"
+	task := toTaskDTO(taskResponse{
+		CatalogTask: &models.CatalogTask{
+			ID:            "fintech/trading/limit-orders",
+			Code:          "limit-orders",
+			RequiredLevel: &level,
+			TimeLimit:     5400,
+			DomainID:      "fintech",
+			ProjectID:     "fintech/trading",
+		},
+		Usage: &models.UsageSummary{Usage30d: 3},
+	})
+	if task.TimeLimitSeconds != 5400 || task.RequiredLevel != &level || task.DomainID != "fintech" || task.Usage.Usage30d != 3 {
+		t.Errorf("unexpected task mapping %+v", task)
+	}
+
+	domain := toDomainDTO(&models.Domain{ID: "fintech", ProjectsCount: 2, TasksCount: 5})
+	data, err := json.Marshal(domain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); got != `{"id":"fintech","name":"","projects_count":2,"tasks_count":5}` {
+		t.Errorf("unexpected domain JSON %s", got)
+	}
+
+	project := toProjectDTO(&models.CatalogProject{ID: "fintech/trading", DomainID: "fintech", Name: "Trading", TasksCount: 2})
+	if project.DomainID != "fintech" || project.TasksCount != 2 {
+		t.Errorf("unexpected project mapping %+v", project)
+	}
+}
+
+func TestToSessionDTO(t *testing.T) {
+	activated := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	dto := toSessionDTO(&models.Session{
+		ID:          "sess-1",
+		Status:      models.SessionActive,
+		TTLSeconds:  600,
+		Metadata:    map[string]string{},
+		ActivatedAt: &activated,
+	})
+
+	data, err := json.Marshal(dto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := string(data)
+
+	if !strings.Contains(body, `"activated_at":"2026-03-01T12:00:00Z"`) || !strings.Contains(body, `"expires_at":null`) {
+		t.Errorf("unexpected timestamps in %s", body)
+	}
+	if strings.Contains(body, `"metadata"`) || strings.Contains(body, `"task_description"`) {
+		t.Errorf("expected empty fields to be omitted from %s", body)
+	}
+}
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/sandbox"
+)
+
+// runningSandbox returns the sandbox of the request, answering 409 when it
+// isn't running and so has no container to reach
+func (s *Server) runningSandbox(w http.ResponseWriter, r *http.Request) *models.Sandbox {
+	id := chi.URLParam(r, "id")
+	sb, err := s.sandboxManager.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sandbox.ErrSandboxNotFound) {
+			respondError(w, http.StatusNotFound, "not_found", "sandbox not found")
+			return nil
+		}
+		slog.Error("failed to get sandbox", "error", err, "id", id)
+		respondError(w, http.StatusInternalServerError, "internal_error", "failed to get sandbox")
+		return nil
+	}
+	if sb.Status != models.StatusRunning {
+		respondError(w, http.StatusConflict, "sandbox_not_running", "sandbox is not running")
+		return nil
+	}
+	return sb
+}
+
+// handleExec runs a command in a sandbox to completion. A command that exits
+// non-zero is a successful request; its exit code is in the result.
+func (s *Server) handleExec(w http.ResponseWriter, r *http.Request) {
+	var req models.ExecRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_request", "invalid JSON body")
+		return
+	}
+	if len(req.Command) == 0 {
+		respondError(w, http.StatusBadRequest, "validation_error", "command is required")
+		return
+	}
+	if req.TimeoutSeconds < 0 || req.TimeoutSeconds > int(sandbox.ExecMaxTimeout.Seconds()) {
+		respondError(w, http.StatusBadRequest, "validation_error", fmt.Sprintf("timeout_seconds must be between 0 and %d", int(sandbox.ExecMaxTimeout.Seconds())))
+		return
+	}
+
+	sb := s.runningSandbox(w, r)
+	if sb == nil {
+		return
+	}
+
+	result, err := s.sandboxManager.Exec(r.Context(), sb, req)
+	if err != nil {
+		if errors.Is(err, sandbox.ErrExecTimeout) {
+			respondError(w, http.StatusGatewayTimeout, "exec_timeout", "command did not finish within its timeout")
+			return
+		}
+		slog.Error("failed to exec in sandbox", "error", err, "id", sb.ID)
+		respondError(w, http.StatusInternalServerError, "internal_error", "failed to run command")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, result)
+}
+
+// filePath returns the ?path= of a file request, answering 400 when it isn't
+// an absolute file path
+func filePath(w http.ResponseWriter, r *http.Request) (string, bool) {
+	p, err := sandbox.ValidateFilePath(r.URL.Query().Get("path"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "validation_error", err.Error())
+		return "", false
+	}
+	return p, true
+}
+
+// handleUploadFile writes the request body to ?path= in a sandbox. The body
+// is streamed into the container, so its Content-Length must be known.
+func (s *Server) handleUploadFile(w http.ResponseWriter, r *http.Request) {
+	p, ok := filePath(w, r)
+	if !ok {
+		return
+	}
+	if r.ContentLength < 0 {
+		respondError(w, http.StatusLengthRequired, "length_required", "Content-Length is required")
+		return
+	}
+	if maxBytes := int64(s.config.FileUploadMaxMB) << 20; r.ContentLength > maxBytes {
+		respondError(w, http.StatusRequestEntityTooLarge, "file_too_large", fmt.Sprintf("file exceeds %d MB", s.config.FileUploadMaxMB))
+		return
+	}
+
+	sb := s.runningSandbox(w, r)
+	if sb == nil {
+		return
+	}
+
+	if err := s.sandboxManager.UploadFile(r.Context(), sb, p, r.Body, r.ContentLength); err != nil {
+		slog.Error("failed to upload file", "error", err, "id", sb.ID, "path", p)
+		respondError(w, http.StatusInternalServerError, "internal_error", "failed to upload file")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"path": p,
+		"size": r.ContentLength,
+	})
+}
+
+// handleDownloadFile streams the file at ?path= in a sandbox
+func (s *Server) handleDownloadFile(w http.ResponseWriter, r *http.Request) {
+	p, ok := filePath(w, r)
+	if !ok {
+		return
+	}
+
+	sb := s.runningSandbox(w, r)
+	if sb == nil {
+		return
+	}
+
+	content, size, err := s.sandboxManager.DownloadFile(r.Context(), sb.ContainerID, p)
+	if err != nil {
+		switch {
+		case errors.Is(err, sandbox.ErrFileNotFound):
+			respondError(w, http.StatusNotFound, "not_found", "file not found")
+		case errors.Is(err, sandbox.ErrNotAFile):
+			respondError(w, http.StatusBadRequest, "not_a_file", "path is not a regular file")
+		default:
+			slog.Error("failed to download file", "error", err, "id", sb.ID, "path", p)
+			respondError(w, http.StatusInternalServerError, "internal_error", "failed to download file")
+		}
+		return
+	}
+	defer content.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	if _, err := io.Copy(w, content); err != nil {
+		slog.Debug("file download interrupted", "error", err, "id", sb.ID, "path", p)
+	}
+}
+
+// handleSandboxStats samples a sandbox's resource usage
+func (s *Server) handleSandboxStats(w http.ResponseWriter, r *http.Request) {
+	sb := s.runningSandbox(w, r)
+	if sb == nil {
+		return
+	}
+
+	stats, err := s.sandboxManager.Stats(r.Context(), sb.ContainerID)
+	if err != nil {
+		slog.Error("failed to get sandbox stats", "error", err, "id", sb.ID)
+		respondError(w, http.StatusInternalServerError, "internal_error", "failed to get sandbox stats")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, stats)
+}
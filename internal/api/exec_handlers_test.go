@@ -0,0 +1,195 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/config"
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/sandbox"
+)
+
+// execManager runs commands and keeps files for a single sandbox
+type execManager struct {
+	sandbox.Manager
+	sb       *models.Sandbox
+	result   *models.ExecResult
+	execErr  error
+	req      models.ExecRequest
+	files    map[string]string
+	uploaded int64
+}
+
+func (m *execManager) Get(ctx context.Context, id string) (*models.Sandbox, error) {
+	return m.sb, nil
+}
+
+func (m *execManager) Exec(ctx context.Context, sb *models.Sandbox, req models.ExecRequest) (*models.ExecResult, error) {
+	m.req = req
+	return m.result, m.execErr
+}
+
+func (m *execManager) UploadFile(ctx context.Context, sb *models.Sandbox, filePath string, r io.Reader, size int64) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m.files[filePath], m.uploaded = string(data), size
+	return nil
+}
+
+func (m *execManager) DownloadFile(ctx context.Context, containerID, filePath string) (io.ReadCloser, int64, error) {
+	data, ok := m.files[filePath]
+	if !ok {
+		return nil, 0, sandbox.ErrFileNotFound
+	}
+	return io.NopCloser(strings.NewReader(data)), int64(len(data)), nil
+}
+
+func newExecServer() (*Server, *execManager) {
+	manager := &execManager{
+		sb:    &models.Sandbox{ID: "sb-1", ContainerID: "ctr-1", Status: models.StatusRunning},
+		files: map[string]string{},
+	}
+	return &Server{sandboxManager: manager, config: config.ServerConfig{FileUploadMaxMB: 1}}, manager
+}
+
+func TestExecNonZeroExit(t *testing.T) {
+	s, manager := newExecServer()
+	manager.result = &models.ExecResult{ExitCode: 2, Stderr: "no such file"}
+
+	rec := httptest.NewRecorder()
+	s.handleExec(rec, httptest.NewRequest(http.MethodPost, "/exec", strings.NewReader(`{"command":["ls","/nope"],"timeout_seconds":5}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected a non-zero exit to be a 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		Data models.ExecResult `json:"data"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if body.Data.ExitCode != 2 || body.Data.Stderr != "no such file" {
+		t.Errorf("unexpected result %+v", body.Data)
+	}
+	if strings.Join(manager.req.Command, " ") != "ls /nope" || manager.req.TimeoutSeconds != 5 {
+		t.Errorf("unexpected request %+v", manager.req)
+	}
+}
+
+func TestExecErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		body string
+		err  error
+		want int
+	}{
+		{name: "no command", body: `{"command":[]}`, want: http.StatusBadRequest},
+		{name: "timeout too long", body: `{"command":["true"],"timeout_seconds":3600}`, want: http.StatusBadRequest},
+		{name: "timed out", body: `{"command":["sleep","100"]}`, err: sandbox.ErrExecTimeout, want: http.StatusGatewayTimeout},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, manager := newExecServer()
+			manager.execErr = tc.err
+
+			rec := httptest.NewRecorder()
+			s.handleExec(rec, httptest.NewRequest(http.MethodPost, "/exec", strings.NewReader(tc.body)))
+			if rec.Code != tc.want {
+				t.Errorf("expected %d, got %d: %s", tc.want, rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestExecStoppedSandbox(t *testing.T) {
+	s, manager := newExecServer()
+	manager.sb.Status = models.StatusStopped
+
+	rec := httptest.NewRecorder()
+	s.handleExec(rec, httptest.NewRequest(http.MethodPost, "/exec", strings.NewReader(`{"command":["true"]}`)))
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "sandbox_not_running") {
+		t.Errorf("expected 409 sandbox_not_running, got %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestUploadDownloadFile(t *testing.T) {
+	s, manager := newExecServer()
+
+	rec := httptest.NewRecorder()
+	s.handleUploadFile(rec, httptest.NewRequest(http.MethodPut, "/files?path=/app/../app/main.py", strings.NewReader("print(1)\n")))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if manager.files["/app/main.py"] != "print(1)\n" || manager.uploaded != 9 {
+		t.Errorf("expected the file at the cleaned path, got %v (%d)", manager.files, manager.uploaded)
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleDownloadFile(rec, httptest.NewRequest(http.MethodGet, "/files?path=/app/main.py", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "print(1)\n" {
+		t.Fatalf("expected the file back, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec.Header().Get("Content-Length") != "9" || rec.Header().Get("Content-Type") != "application/octet-stream" {
+		t.Errorf("unexpected headers %v", rec.Header())
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleDownloadFile(rec, httptest.NewRequest(http.MethodGet, "/files?path=/missing", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing file, got %d", rec.Code)
+	}
+}
+
+func TestUploadFileLimits(t *testing.T) {
+	s, _ := newExecServer()
+
+	rec := httptest.NewRecorder()
+	s.handleUploadFile(rec, httptest.NewRequest(http.MethodPut, "/files?path=relative.txt", strings.NewReader("x")))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a relative path, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, "/files?path=/a.txt", strings.NewReader("x"))
+	req.ContentLength = -1
+	s.handleUploadFile(rec, req)
+	if rec.Code != http.StatusLengthRequired {
+		t.Errorf("expected 411 without a Content-Length, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleUploadFile(rec, httptest.NewRequest(http.MethodPut, "/files?path=/a.txt", strings.NewReader(strings.Repeat("x", 1<<20+1))))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 over FILE_UPLOAD_MAX_MB, got %d", rec.Code)
+	}
+}
+
+func TestLongRequestOutlivesServerTimeouts(t *testing.T) {
+	var deadline time.Time
+	handler := longRequest(time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, _ = r.Context().Deadline()
+		time.Sleep(200 * time.Millisecond)
+		io.WriteString(w, "done")
+	}))
+	srv := httptest.NewUnstartedServer(handler)
+	srv.Config.WriteTimeout = 50 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("expected the request to outlive the write timeout, got %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "done" {
+		t.Errorf("expected the full response, got %q", body)
+	}
+	if until := time.Until(deadline); until <= 0 || until > time.Second {
+		t.Errorf("expected the context to end within the route's deadline, got %v", until)
+	}
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/sandbox"
+)
+
+// handleGradeSandbox runs the grading command of a running sandbox's task. A
+// failed grade is a successful request; the verdict is in the result.
+func (s *Server) handleGradeSandbox(w http.ResponseWriter, r *http.Request) {
+	sb := s.runningSandbox(w, r)
+	if sb == nil {
+		return
+	}
+
+	res, err := s.sandboxManager.GradeSandbox(r.Context(), sb)
+	if err != nil {
+		if errors.Is(err, sandbox.ErrGradingNotConfigured) {
+			respondError(w, http.StatusConflict, "grading_not_configured", "sandbox has no task with grading")
+			return
+		}
+		slog.Error("failed to grade sandbox", "error", err, "id", sb.ID)
+		respondError(w, http.StatusInternalServerError, "internal_error", "failed to grade sandbox")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, res)
+}
+
+// handleListGradingResults lists the grading runs of a sandbox, also after
+// the sandbox is cleaned up
+func (s *Server) handleListGradingResults(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	results, err := s.sandboxManager.ListGradingResults(r.Context(), id)
+	if err != nil {
+		slog.Error("failed to list grading results", "error", err, "id", id)
+		respondError(w, http.StatusInternalServerError, "internal_error", "failed to list grading results")
+		return
+	}
+
+	// Like recordings, results keep the owner of their sandbox
+	visible := make([]*models.GradingResult, 0, len(results))
+	for _, res := range results {
+		if canAccessSandbox(r, &models.Sandbox{OwnerClientID: res.OwnerClientID}) {
+			visible = append(visible, res)
+		}
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"results": visible,
+		"total":   len(visible),
+	})
+}
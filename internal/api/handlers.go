@@ -3,15 +3,20 @@ package api
 import (
 	"encoding/json"
 	"errors"
+	"io"
 	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 
 	"github.com/terra-clan/sandbox-engine/internal/models"
 	"github.com/terra-clan/sandbox-engine/internal/sandbox"
+	"github.com/terra-clan/sandbox-engine/internal/storage"
+	"github.com/terra-clan/sandbox-engine/internal/templates"
+	"github.com/terra-clan/sandbox-engine/internal/version"
 )
 
 // Response helpers
@@ -25,6 +30,8 @@ type apiResponse struct {
 type apiError struct {
 	Code    string `json:"code"`
 	Message string `json:"message"`
+	// MaxTTLSeconds is the allowed maximum of a ttl_exceeds_max error
+	MaxTTLSeconds int64 `json:"max_ttl_seconds,omitempty"`
 }
 
 func respondJSON(w http.ResponseWriter, status int, data interface{}) {
@@ -58,6 +65,22 @@ func respondError(w http.ResponseWriter, status int, code, message string) {
 	}
 }
 
+// respondTTLCapError reports err with 422 and the allowed maximum when it is a
+// *sandbox.TTLCapError, and reports whether it was
+func respondTTLCapError(w http.ResponseWriter, err error) bool {
+	var capErr *sandbox.TTLCapError
+	if !errors.As(err, &capErr) {
+		return false
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	resp := apiResponse{Error: &apiError{Code: "ttl_exceeds_max", Message: capErr.Error(), MaxTTLSeconds: seconds(capErr.Max)}}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.Error("failed to encode error response", "error", err)
+	}
+	return true
+}
+
 // Health handlers
 
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
@@ -74,13 +97,102 @@ func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	respondJSON(w, http.StatusOK, map[string]string{
+	resp := map[string]string{
 		"status": "ready",
+	}
+
+	// A replica outage degrades reads to the primary but doesn't make us unready
+	if rr, ok := s.repo.(storage.ReadReplica); ok && rr.HasReadReplica() {
+		resp["read_replica"] = "up"
+		if err := rr.PingReplica(r.Context()); err != nil {
+			resp["read_replica"] = "down"
+		}
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// handleHealthDetails reports each dependency separately. It always answers 200,
+// with status "degraded" when a component is down; /ready stays the strict probe.
+func (s *Server) handleHealthDetails(w http.ResponseWriter, r *http.Request) {
+	components := s.sandboxManager.HealthDetails(r.Context())
+
+	status := "healthy"
+	for _, c := range components {
+		if c.Status != models.HealthUp {
+			status = "degraded"
+			break
+		}
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"status":     status,
+		"components": components,
+		"time":       time.Now().UTC().Format(time.RFC3339),
 	})
 }
 
+// versionDTO is the build of the engine with the service providers it offers
+type versionDTO struct {
+	version.Info
+	Services []string `json:"services"`
+}
+
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, versionDTO{Info: version.Get(), Services: s.sandboxManager.ServiceProviders()})
+}
+
 // Sandbox handlers
 
+// sandboxAdminPermission lets a client reach sandboxes created by other clients
+const sandboxAdminPermission = "sandboxes:admin"
+
+// canAccessSandbox reports whether the requesting client created sb or holds
+// sandboxAdminPermission
+func canAccessSandbox(r *http.Request, sb *models.Sandbox) bool {
+	client := ClientFromContext(r.Context())
+	if client == nil {
+		return false
+	}
+	return client.HasPermission(sandboxAdminPermission) || (sb.OwnerClientID != 0 && sb.OwnerClientID == client.ID)
+}
+
+// sandboxOwnerScope returns the owner filter for listings: the requesting
+// client's ID, or 0 (any owner) for clients with sandboxAdminPermission
+func sandboxOwnerScope(r *http.Request) int {
+	client := ClientFromContext(r.Context())
+	if client != nil && client.HasPermission(sandboxAdminPermission) {
+		return 0
+	}
+	if client == nil || client.ID == 0 {
+		return -1 // matches nothing
+	}
+	return client.ID
+}
+
+// requireSandboxOwner rejects requests for a sandbox the client can't access.
+// Foreign sandboxes are reported as not found so their IDs can't be probed.
+func (s *Server) requireSandboxOwner(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		sb, err := s.sandboxManager.Get(r.Context(), id)
+		if err != nil {
+			if errors.Is(err, sandbox.ErrSandboxNotFound) {
+				respondError(w, http.StatusNotFound, "not_found", "sandbox not found")
+				return
+			}
+			slog.Error("failed to get sandbox", "error", err, "id", id)
+			respondError(w, http.StatusInternalServerError, "internal_error", "failed to get sandbox")
+			return
+		}
+		if !canAccessSandbox(r, sb) {
+			respondError(w, http.StatusNotFound, "not_found", "sandbox not found")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (s *Server) handleCreateSandbox(w http.ResponseWriter, r *http.Request) {
 	var req models.CreateRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -88,8 +200,8 @@ func (s *Server) handleCreateSandbox(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.TemplateID == "" {
-		respondError(w, http.StatusBadRequest, "validation_error", "template_id is required")
+	if req.TemplateID == "" && req.TaskID == "" {
+		respondError(w, http.StatusBadRequest, "validation_error", "template_id or task_id is required")
 		return
 	}
 
@@ -98,22 +210,62 @@ func (s *Server) handleCreateSandbox(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if issues := templates.ValidateCommand("command", req.Command); len(issues) > 0 {
+		respondError(w, http.StatusBadRequest, "validation_error", issues[0].String())
+		return
+	}
+
+	var ownerClientID int
+	if client := ClientFromContext(r.Context()); client != nil {
+		ownerClientID = client.ID
+	}
+
 	sb, err := s.sandboxManager.Create(r.Context(), req.TemplateID, req.UserID, sandbox.CreateOptions{
-		TTL:      req.TTL,
-		Env:      req.Env,
-		Metadata: req.Metadata,
+		TTL:           req.TTL,
+		Env:           req.Env,
+		Metadata:      req.Metadata,
+		Command:       req.Command,
+		TaskID:        req.TaskID,
+		OwnerClientID: ownerClientID,
 	})
 	if err != nil {
 		if errors.Is(err, sandbox.ErrTemplateNotFound) {
 			respondError(w, http.StatusNotFound, "template_not_found", "template not found")
 			return
 		}
+		if errors.Is(err, sandbox.ErrTemplateDisabled) {
+			respondError(w, http.StatusConflict, "template_disabled", "template is disabled")
+			return
+		}
+		if errors.Is(err, sandbox.ErrTaskNotFound) {
+			respondError(w, http.StatusNotFound, "task_not_found", "task not found")
+			return
+		}
+		if errors.Is(err, sandbox.ErrCommandOverrideNotAllowed) {
+			respondError(w, http.StatusBadRequest, "command_override_not_allowed", "template does not allow overriding the command")
+			return
+		}
+		if errors.Is(err, sandbox.ErrShuttingDown) {
+			respondError(w, http.StatusServiceUnavailable, "shutting_down", "engine is shutting down, retry shortly")
+			return
+		}
+		if errors.Is(err, sandbox.ErrNoSchedulableHost) {
+			respondError(w, http.StatusServiceUnavailable, "no_capacity", "no docker host is accepting new sandboxes")
+			return
+		}
+		if errors.Is(err, sandbox.ErrNoGPUAvailable) {
+			respondError(w, http.StatusConflict, "no_gpu_available", err.Error())
+			return
+		}
+		if respondTTLCapError(w, err) {
+			return
+		}
 		slog.Error("failed to create sandbox", "error", err)
 		respondError(w, http.StatusInternalServerError, "internal_error", "failed to create sandbox")
 		return
 	}
 
-	respondJSON(w, http.StatusCreated, sb)
+	respondJSON(w, http.StatusCreated, render(r, sb, toSandboxDTO))
 }
 
 func (s *Server) handleGetSandbox(w http.ResponseWriter, r *http.Request) {
@@ -134,9 +286,16 @@ func (s *Server) handleGetSandbox(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	respondJSON(w, http.StatusOK, sb)
+	resp := render(r, sb, toSandboxDTO)
+	if dto, ok := resp.(*sandboxDTO); ok {
+		terminals := s.terminals.count(sb.ID)
+		dto.TerminalConnections = &terminals
+	}
+	respondJSON(w, http.StatusOK, resp)
 }
 
+// handleDeleteSandbox deletes a sandbox. force=true, for sandboxes:admin
+// only, removes it even when its services fail to deprovision.
 func (s *Server) handleDeleteSandbox(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	if id == "" {
@@ -144,11 +303,42 @@ func (s *Server) handleDeleteSandbox(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := s.sandboxManager.Delete(r.Context(), id); err != nil {
+	deleteSandbox := s.sandboxManager.Delete
+	if v := r.URL.Query().Get("force"); v != "" {
+		force, err := strconv.ParseBool(v)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "validation_error", "force must be true or false")
+			return
+		}
+		if force {
+			if client := ClientFromContext(r.Context()); client == nil || !client.HasPermission(sandboxAdminPermission) {
+				respondError(w, http.StatusForbidden, "permission_denied", "force requires the sandboxes:admin permission")
+				return
+			}
+			deleteSandbox = s.sandboxManager.ForceDelete
+		}
+	}
+
+	if err := deleteSandbox(r.Context(), id); err != nil {
 		if errors.Is(err, sandbox.ErrSandboxNotFound) {
 			respondError(w, http.StatusNotFound, "not_found", "sandbox not found")
 			return
 		}
+		if errors.Is(err, sandbox.ErrOperationInProgress) {
+			respondError(w, http.StatusConflict, "operation_in_progress", "another operation is already running for this sandbox")
+			return
+		}
+		if errors.Is(err, sandbox.ErrOperationPending) {
+			respondJSON(w, http.StatusAccepted, map[string]string{
+				"message": "sandbox deletion in progress",
+			})
+			return
+		}
+		if errors.Is(err, sandbox.ErrDeprovisionFailed) {
+			slog.Warn("sandbox services failed to deprovision", "error", err, "id", id)
+			respondError(w, http.StatusServiceUnavailable, "deprovision_failed", "sandbox services failed to deprovision; the deletion will be retried")
+			return
+		}
 		slog.Error("failed to delete sandbox", "error", err, "id", id)
 		respondError(w, http.StatusInternalServerError, "internal_error", "failed to delete sandbox")
 		return
@@ -159,6 +349,45 @@ func (s *Server) handleDeleteSandbox(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+func (s *Server) handleStartSandbox(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		respondError(w, http.StatusBadRequest, "validation_error", "sandbox id is required")
+		return
+	}
+
+	if err := s.sandboxManager.Start(r.Context(), id); err != nil {
+		switch {
+		case errors.Is(err, sandbox.ErrSandboxNotFound):
+			respondError(w, http.StatusNotFound, "not_found", "sandbox not found")
+		case errors.Is(err, sandbox.ErrSandboxRunning):
+			respondError(w, http.StatusConflict, "already_running", "sandbox is already running")
+		case errors.Is(err, sandbox.ErrSandboxExpired):
+			respondError(w, http.StatusConflict, "sandbox_expired", "sandbox has expired, extend its TTL first")
+		case errors.Is(err, sandbox.ErrSandboxNotActive):
+			respondError(w, http.StatusConflict, "sandbox_not_active", "only stopped sandboxes can be started")
+		case errors.Is(err, sandbox.ErrOperationInProgress):
+			respondError(w, http.StatusConflict, "operation_in_progress", "another operation is already running for this sandbox")
+		default:
+			slog.Error("failed to start sandbox", "error", err, "id", id)
+			respondError(w, http.StatusInternalServerError, "internal_error", "failed to start sandbox")
+		}
+		return
+	}
+
+	sb, err := s.sandboxManager.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sandbox.ErrSandboxNotFound) {
+			respondError(w, http.StatusNotFound, "not_found", "sandbox not found")
+			return
+		}
+		slog.Error("failed to get sandbox", "error", err, "id", id)
+		respondError(w, http.StatusInternalServerError, "internal_error", "failed to get sandbox")
+		return
+	}
+	respondJSON(w, http.StatusOK, render(r, sb, toSandboxDTO))
+}
+
 func (s *Server) handleStopSandbox(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	if id == "" {
@@ -171,6 +400,20 @@ func (s *Server) handleStopSandbox(w http.ResponseWriter, r *http.Request) {
 			respondError(w, http.StatusNotFound, "not_found", "sandbox not found")
 			return
 		}
+		if errors.Is(err, sandbox.ErrOperationInProgress) {
+			respondError(w, http.StatusConflict, "operation_in_progress", "another operation is already running for this sandbox")
+			return
+		}
+		if errors.Is(err, sandbox.ErrSandboxStopped) {
+			respondError(w, http.StatusConflict, "already_stopped", "sandbox is already stopped")
+			return
+		}
+		if errors.Is(err, sandbox.ErrOperationPending) {
+			respondJSON(w, http.StatusAccepted, map[string]string{
+				"message": "sandbox stop in progress",
+			})
+			return
+		}
 		slog.Error("failed to stop sandbox", "error", err, "id", id)
 		respondError(w, http.StatusInternalServerError, "internal_error", "failed to stop sandbox")
 		return
@@ -181,6 +424,33 @@ func (s *Server) handleStopSandbox(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// metadataParamPrefix marks list query parameters that filter on sandbox metadata
+const metadataParamPrefix = "metadata."
+
+// parseCreatedRange reads the created_after and created_before parameters
+// of a listing. It returns why they are invalid, or "".
+func parseCreatedRange(r *http.Request, after, before *time.Time) string {
+	for _, bound := range []struct {
+		param string
+		dst   *time.Time
+	}{
+		{"created_after", after},
+		{"created_before", before},
+	} {
+		if v := r.URL.Query().Get(bound.param); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return bound.param + " must be an RFC 3339 timestamp"
+			}
+			*bound.dst = parsed
+		}
+	}
+	if !after.IsZero() && !before.IsZero() && !after.Before(*before) {
+		return "created_after must be before created_before"
+	}
+	return ""
+}
+
 func (s *Server) handleListSandboxes(w http.ResponseWriter, r *http.Request) {
 	filters := models.ListFilters{
 		UserID:     r.URL.Query().Get("user_id"),
@@ -188,6 +458,8 @@ func (s *Server) handleListSandboxes(w http.ResponseWriter, r *http.Request) {
 		Status:     models.SandboxStatus(r.URL.Query().Get("status")),
 		Limit:      50, // default
 		Offset:     0,
+
+		OwnerClientID: sandboxOwnerScope(r),
 	}
 
 	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
@@ -202,6 +474,50 @@ func (s *Server) handleListSandboxes(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if msg := parseCreatedRange(r, &filters.CreatedAfter, &filters.CreatedBefore); msg != "" {
+		respondError(w, http.StatusBadRequest, "validation_error", msg)
+		return
+	}
+
+	if v := r.URL.Query().Get("include_deleted"); v != "" {
+		includeDeleted, err := strconv.ParseBool(v)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "validation_error", "include_deleted must be true or false")
+			return
+		}
+		filters.IncludeDeleted = includeDeleted
+	}
+
+	filters.Sort = models.SandboxSort(r.URL.Query().Get("sort"))
+	if !filters.Sort.Valid() {
+		respondError(w, http.StatusBadRequest, "validation_error", "sort must be one of created_at, expires_at, status, user_id")
+		return
+	}
+	switch r.URL.Query().Get("order") {
+	case "", "desc":
+	case "asc":
+		filters.Ascending = true
+	default:
+		respondError(w, http.StatusBadRequest, "validation_error", "order must be asc or desc")
+		return
+	}
+
+	// metadata.<key>=<value> parameters match metadata entries
+	for param, values := range r.URL.Query() {
+		key, ok := strings.CutPrefix(param, metadataParamPrefix)
+		if !ok {
+			continue
+		}
+		if key == "" {
+			respondError(w, http.StatusBadRequest, "validation_error", "metadata filter needs a key (metadata.<key>=<value>)")
+			return
+		}
+		if filters.Metadata == nil {
+			filters.Metadata = make(map[string]string)
+		}
+		filters.Metadata[key] = values[0]
+	}
+
 	sandboxes, err := s.sandboxManager.List(r.Context(), filters)
 	if err != nil {
 		slog.Error("failed to list sandboxes", "error", err)
@@ -209,9 +525,18 @@ func (s *Server) handleListSandboxes(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	total, err := s.sandboxManager.Count(r.Context(), filters)
+	if err != nil {
+		slog.Error("failed to count sandboxes", "error", err)
+		respondError(w, http.StatusInternalServerError, "internal_error", "failed to list sandboxes")
+		return
+	}
+
 	respondJSON(w, http.StatusOK, map[string]interface{}{
-		"sandboxes": sandboxes,
-		"total":     len(sandboxes),
+		"sandboxes": renderList(r, sandboxes, toSandboxDTO),
+		"total":     total,
+		"limit":     filters.Limit,
+		"offset":    filters.Offset,
 	})
 }
 
@@ -238,14 +563,26 @@ func (s *Server) handleExtendTTL(w http.ResponseWriter, r *http.Request) {
 			respondError(w, http.StatusNotFound, "not_found", "sandbox not found")
 			return
 		}
+		if errors.Is(err, sandbox.ErrSandboxNotActive) {
+			respondError(w, http.StatusConflict, "sandbox_not_active", "sandbox has failed or expired")
+			return
+		}
+		if respondTTLCapError(w, err) {
+			return
+		}
 		slog.Error("failed to extend TTL", "error", err, "id", id)
 		respondError(w, http.StatusInternalServerError, "internal_error", "failed to extend TTL")
 		return
 	}
 
 	// Get updated sandbox
-	sb, _ := s.sandboxManager.Get(r.Context(), id)
-	respondJSON(w, http.StatusOK, sb)
+	sb, err := s.sandboxManager.Get(r.Context(), id)
+	if err != nil {
+		slog.Error("failed to get sandbox", "error", err, "id", id)
+		respondError(w, http.StatusInternalServerError, "internal_error", "failed to get sandbox")
+		return
+	}
+	respondJSON(w, http.StatusOK, render(r, sb, toSandboxDTO))
 }
 
 func (s *Server) handleGetLogs(w http.ResponseWriter, r *http.Request) {
@@ -255,14 +592,13 @@ func (s *Server) handleGetLogs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tail := 100 // default
-	if tailStr := r.URL.Query().Get("tail"); tailStr != "" {
-		if t, err := strconv.Atoi(tailStr); err == nil && t > 0 {
-			tail = t
-		}
+	opts, msg := parseLogOptions(r)
+	if msg != "" {
+		respondError(w, http.StatusBadRequest, "validation_error", msg)
+		return
 	}
 
-	logs, err := s.sandboxManager.GetLogs(r.Context(), id, tail)
+	logs, err := s.sandboxManager.GetLogs(r.Context(), id, opts)
 	if err != nil {
 		if errors.Is(err, sandbox.ErrSandboxNotFound) {
 			respondError(w, http.StatusNotFound, "not_found", "sandbox not found")
@@ -278,13 +614,154 @@ func (s *Server) handleGetLogs(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// Service handlers
+
+// Service credentials are always redacted here; the full credentials are only
+// returned with the sandbox itself
+
+func (s *Server) handleListServices(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	services, err := s.sandboxManager.GetServices(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sandbox.ErrSandboxNotFound) {
+			respondError(w, http.StatusNotFound, "not_found", "sandbox not found")
+			return
+		}
+		slog.Error("failed to get services", "error", err, "id", id)
+		respondError(w, http.StatusInternalServerError, "internal_error", "failed to get services")
+		return
+	}
+
+	redacted := make([]*models.ServiceInstance, 0, len(services))
+	for _, svc := range services {
+		redacted = append(redacted, svc.Redacted())
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"services": renderList(r, redacted, toServiceDTO),
+		"total":    len(redacted),
+	})
+}
+
+func (s *Server) handleListEvents(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	events, err := s.sandboxManager.ListEvents(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sandbox.ErrSandboxNotFound) {
+			respondError(w, http.StatusNotFound, "not_found", "sandbox not found")
+			return
+		}
+		slog.Error("failed to list events", "error", err, "id", id)
+		respondError(w, http.StatusInternalServerError, "internal_error", "failed to list events")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"events": renderList(r, events, toEventDTO),
+		"total":  len(events),
+	})
+}
+
+func (s *Server) handleGetService(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	name := chi.URLParam(r, "name")
+
+	svc, err := s.sandboxManager.GetService(r.Context(), id, name)
+	if err != nil {
+		s.respondServiceError(w, err, id, name, "failed to get service")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, render(r, svc.Redacted(), toServiceDTO))
+}
+
+func (s *Server) handleCheckService(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	name := chi.URLParam(r, "name")
+
+	svc, err := s.sandboxManager.CheckService(r.Context(), id, name)
+	if err != nil {
+		s.respondServiceError(w, err, id, name, "failed to check service")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, render(r, svc.Redacted(), toServiceDTO))
+}
+
+// handleRotateService rotates the credentials of a service and returns them;
+// this is the only service response that isn't redacted, as the new
+// credentials are otherwise only returned with the sandbox
+func (s *Server) handleRotateService(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	name := chi.URLParam(r, "name")
+
+	svc, err := s.sandboxManager.RotateServiceCredentials(r.Context(), id, name)
+	if err != nil {
+		s.respondServiceError(w, err, id, name, "failed to rotate service credentials")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, render(r, svc, toServiceDTO))
+}
+
+// respondServiceError maps service lookup errors to API responses
+func (s *Server) respondServiceError(w http.ResponseWriter, err error, id, name, msg string) {
+	switch {
+	case errors.Is(err, sandbox.ErrSandboxNotFound):
+		respondError(w, http.StatusNotFound, "not_found", "sandbox not found")
+	case errors.Is(err, sandbox.ErrServiceNotFound):
+		respondError(w, http.StatusNotFound, "not_found", "service not found")
+	case errors.Is(err, sandbox.ErrCheckUnsupported):
+		respondError(w, http.StatusNotImplemented, "not_supported", "service does not support health checks")
+	case errors.Is(err, sandbox.ErrRotateUnsupported):
+		respondError(w, http.StatusNotImplemented, "not_supported", "service does not support credential rotation")
+	case errors.Is(err, sandbox.ErrOperationInProgress):
+		respondError(w, http.StatusConflict, "operation_in_progress", "another operation is already running for this sandbox")
+	case errors.Is(err, sandbox.ErrServiceNotReady):
+		respondError(w, http.StatusConflict, "service_not_ready", "service is still provisioning")
+	default:
+		slog.Error(msg, "error", err, "id", id, "service", name)
+		respondError(w, http.StatusInternalServerError, "internal_error", msg)
+	}
+}
+
 // Template handlers
 
+// templateResponse is a template plus whether its image is already pulled
+// and a summary of its recent usage
+type templateResponse struct {
+	*models.Template
+	ImageReady bool                 `json:"image_ready"`
+	Usage      *models.UsageSummary `json:"usage"`
+}
+
+func (s *Server) templateWithImageState(r *http.Request, t *models.Template) templateResponse {
+	return templateResponse{
+		Template:   localizeTemplate(r, t.Redacted()),
+		ImageReady: s.sandboxManager.ImageReady(r.Context(), t.BaseImage),
+		Usage:      usageOrEmpty(s.sandboxManager.TemplateUsageSummary(r.Context(), t.Name)),
+	}
+}
+
+// handleListTemplates lists the enabled templates, optionally only those with
+// a tag or language
 func (s *Server) handleListTemplates(w http.ResponseWriter, r *http.Request) {
 	templates := s.templateLoader.List()
+	tag, language := r.URL.Query().Get("tag"), r.URL.Query().Get("language")
+
+	resp := make([]templateResponse, 0, len(templates))
+	for _, t := range templates {
+		if !t.IsEnabled() || (tag != "" && !t.HasTag(tag)) || (language != "" && !strings.EqualFold(t.Language, language)) {
+			continue
+		}
+		resp = append(resp, s.templateWithImageState(r, t))
+	}
+
 	respondJSON(w, http.StatusOK, map[string]interface{}{
-		"templates": templates,
-		"total":     len(templates),
+		"templates": renderList(r, resp, toTemplateDTO),
+		"total":     len(resp),
 	})
 }
 
@@ -301,5 +778,142 @@ func (s *Server) handleGetTemplate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	respondJSON(w, http.StatusOK, template)
+	respondJSON(w, http.StatusOK, render(r, s.templateWithImageState(r, template), toTemplateDTO))
+}
+
+// handleTemplateValidation reports the templates that failed validation, and
+// the files and names the last load skipped
+func (s *Server) handleTemplateValidation(w http.ResponseWriter, r *http.Request) {
+	invalid := s.templateLoader.Invalid()
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"templates": invalid,
+		"total":     len(invalid),
+		"skipped":   s.templateLoader.Skipped(),
+		"conflicts": s.templateLoader.Conflicts(),
+	})
+}
+
+// handleReloadTemplates loads TEMPLATES_DIR and the stored templates again and
+// reports what changed
+func (s *Server) handleReloadTemplates(w http.ResponseWriter, r *http.Request) {
+	summary, err := s.templateLoader.Reload()
+	if errors.Is(err, templates.ErrNoTemplatesDir) {
+		respondError(w, http.StatusConflict, "not_loaded", err.Error())
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "reload_failed", err.Error())
+		return
+	}
+	respondJSON(w, http.StatusOK, summary)
+}
+
+// maxTemplateSize bounds the body of a template request
+const maxTemplateSize = 1 << 20
+
+// readTemplate reads a template document from the request body, responding
+// with the error when it can't
+func readTemplate(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxTemplateSize+1))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_request", "failed to read request body")
+		return nil, false
+	}
+	if len(data) > maxTemplateSize {
+		respondError(w, http.StatusRequestEntityTooLarge, "validation_error", "template is too large")
+		return nil, false
+	}
+	return data, true
+}
+
+// handleValidateTemplate checks a template document without loading it.
+// The body is the template YAML (JSON is accepted as a YAML subset).
+func (s *Server) handleValidateTemplate(w http.ResponseWriter, r *http.Request) {
+	data, ok := readTemplate(w, r)
+	if !ok {
+		return
+	}
+
+	template, issues, err := s.templateLoader.Validate(data)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "validation_error", err.Error())
+		return
+	}
+	if issues == nil {
+		issues = []templates.Issue{}
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"name":   template.Name,
+		"valid":  !templates.HasErrors(issues),
+		"issues": issues,
+	})
+}
+
+// handleCreateTemplate stores a template document, which must pass
+// validation; it is loaded at once and survives restarts
+func (s *Server) handleCreateTemplate(w http.ResponseWriter, r *http.Request) {
+	data, ok := readTemplate(w, r)
+	if !ok {
+		return
+	}
+
+	template, _, err := s.sandboxManager.CreateTemplate(r.Context(), data)
+	if err != nil {
+		respondTemplateError(w, err, "failed to create template")
+		return
+	}
+
+	slog.Info("template created", "name", template.Name)
+	respondJSON(w, http.StatusCreated, render(r, s.templateWithImageState(r, template), toTemplateDTO))
+}
+
+// handleUpdateTemplate replaces a template created through the API
+func (s *Server) handleUpdateTemplate(w http.ResponseWriter, r *http.Request) {
+	data, ok := readTemplate(w, r)
+	if !ok {
+		return
+	}
+
+	template, _, err := s.sandboxManager.UpdateTemplate(r.Context(), chi.URLParam(r, "name"), data)
+	if err != nil {
+		respondTemplateError(w, err, "failed to update template")
+		return
+	}
+
+	slog.Info("template updated", "name", template.Name)
+	respondJSON(w, http.StatusOK, render(r, s.templateWithImageState(r, template), toTemplateDTO))
+}
+
+// handleDeleteTemplate deletes a template created through the API
+func (s *Server) handleDeleteTemplate(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if err := s.sandboxManager.DeleteTemplate(r.Context(), name); err != nil {
+		respondTemplateError(w, err, "failed to delete template")
+		return
+	}
+
+	slog.Info("template deleted", "name", name)
+	respondJSON(w, http.StatusOK, map[string]string{
+		"message": "template deleted",
+	})
+}
+
+// respondTemplateError maps the errors of creating, updating and deleting templates
+func respondTemplateError(w http.ResponseWriter, err error, msg string) {
+	switch {
+	case errors.Is(err, sandbox.ErrTemplateInvalid):
+		respondError(w, http.StatusBadRequest, "validation_error", err.Error())
+	case errors.Is(err, sandbox.ErrTemplateNotFound):
+		respondError(w, http.StatusNotFound, "not_found", "template not found")
+	case errors.Is(err, sandbox.ErrTemplateExists):
+		respondError(w, http.StatusConflict, "template_exists", err.Error())
+	case errors.Is(err, sandbox.ErrTemplateReadOnly):
+		respondError(w, http.StatusConflict, "template_read_only", err.Error())
+	case errors.Is(err, sandbox.ErrTemplateInUse):
+		respondError(w, http.StatusConflict, "template_in_use", err.Error())
+	default:
+		slog.Error(msg, "error", err)
+		respondError(w, http.StatusInternalServerError, "internal_error", msg)
+	}
 }
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/sandbox"
+)
+
+func (s *Server) handleListHosts(w http.ResponseWriter, r *http.Request) {
+	hosts, err := s.sandboxManager.ListHosts(r.Context())
+	if err != nil {
+		slog.Error("failed to list hosts", "error", err)
+		respondError(w, http.StatusInternalServerError, "internal_error", "failed to list hosts")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"hosts": hosts,
+		"total": len(hosts),
+	})
+}
+
+func (s *Server) handleDrainHost(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	var req models.DrainHostRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid_request", "invalid JSON body")
+			return
+		}
+	}
+	if req.Mode != "" && !req.Mode.IsValid() {
+		respondError(w, http.StatusBadRequest, "validation_error", "mode must be at_expiry or immediate")
+		return
+	}
+
+	host, sandboxes, err := s.sandboxManager.DrainHost(r.Context(), name, req.Mode)
+	if err != nil {
+		if errors.Is(err, sandbox.ErrHostNotFound) {
+			respondError(w, http.StatusNotFound, "not_found", "host not found")
+			return
+		}
+		slog.Error("failed to drain host", "error", err, "host", name)
+		respondError(w, http.StatusInternalServerError, "internal_error", "failed to drain host")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"host":      host,
+		"sandboxes": sandboxes,
+	})
+}
+
+func (s *Server) handleUndrainHost(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	host, err := s.sandboxManager.UndrainHost(r.Context(), name)
+	if err != nil {
+		if errors.Is(err, sandbox.ErrHostNotFound) {
+			respondError(w, http.StatusNotFound, "not_found", "host not found")
+			return
+		}
+		slog.Error("failed to undrain host", "error", err, "host", name)
+		respondError(w, http.StatusInternalServerError, "internal_error", "failed to undrain host")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, host)
+}
+
+// handleListOrphans reports drift between the database and Docker without removing anything
+func (s *Server) handleListOrphans(w http.ResponseWriter, r *http.Request) {
+	report, err := s.sandboxManager.PruneOrphans(r.Context(), true)
+	if err != nil {
+		slog.Error("failed to find orphans", "error", err)
+		respondError(w, http.StatusInternalServerError, "internal_error", "failed to find orphaned resources")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, report)
+}
+
+// handlePruneOrphans removes the resources handleListOrphans reports.
+// ?dry_run=true only reports them.
+func (s *Server) handlePruneOrphans(w http.ResponseWriter, r *http.Request) {
+	dryRun, ok := parseDryRun(w, r)
+	if !ok {
+		return
+	}
+
+	report, err := s.sandboxManager.PruneOrphans(r.Context(), dryRun)
+	if err != nil {
+		slog.Error("failed to prune orphans", "error", err)
+		respondError(w, http.StatusInternalServerError, "internal_error", "failed to prune orphaned resources")
+		return
+	}
+
+	slog.Info("pruned orphaned resources", "dry_run", dryRun,
+		"containers", len(report.Containers), "sandboxes", len(report.Sandboxes))
+	respondJSON(w, http.StatusOK, report)
+}
+
+// handleReconcileProviders deprovisions the service resources of sandboxes
+// that no longer exist. ?dry_run=true only reports them.
+func (s *Server) handleReconcileProviders(w http.ResponseWriter, r *http.Request) {
+	dryRun, ok := parseDryRun(w, r)
+	if !ok {
+		return
+	}
+
+	report, err := s.sandboxManager.ReconcileProviders(r.Context(), dryRun)
+	if err != nil {
+		slog.Error("failed to reconcile providers", "error", err)
+		respondError(w, http.StatusInternalServerError, "internal_error", "failed to reconcile service providers")
+		return
+	}
+
+	for _, p := range report.Providers {
+		slog.Info("reconciled provider", "provider", p.Provider, "dry_run", dryRun, "orphans", len(p.Orphans), "error", p.Error)
+	}
+	respondJSON(w, http.StatusOK, report)
+}
+
+// parseDryRun reads the dry_run query parameter, false when absent. An
+// invalid value is answered with 400 and ok false.
+func parseDryRun(w http.ResponseWriter, r *http.Request) (dryRun, ok bool) {
+	v := r.URL.Query().Get("dry_run")
+	if v == "" {
+		return false, true
+	}
+	dryRun, err := strconv.ParseBool(v)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "validation_error", "dry_run must be true or false")
+		return false, false
+	}
+	return dryRun, true
+}
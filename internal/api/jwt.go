@@ -0,0 +1,228 @@
+package api
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/terra-clan/sandbox-engine/internal/config"
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+const (
+	// jwksRefresh is how long fetched JWKS keys are used before refetching
+	jwksRefresh = 10 * time.Minute
+	// jwksMinRefetch rate-limits refetches triggered by an unknown key ID
+	jwksMinRefetch = time.Minute
+)
+
+// jwtPrincipalPrefix starts the name of clients authenticated by JWT
+const jwtPrincipalPrefix = "jwt:"
+
+// maxClientNameLength is the width of the api_clients name column
+const maxClientNameLength = 100
+
+// jwtClaims are the claims read from gateway tokens
+type jwtClaims struct {
+	Scope string `json:"scope"`
+	jwt.RegisteredClaims
+}
+
+// jwtVerifier validates JWT bearer tokens and maps them to clients
+type jwtVerifier struct {
+	cfg     config.JWTConfig
+	methods []string
+	jwks    *jwksCache
+}
+
+func newJWTVerifier(cfg config.JWTConfig) *jwtVerifier {
+	v := &jwtVerifier{cfg: cfg}
+	if cfg.HMACSecret != "" {
+		v.methods = append(v.methods, jwt.SigningMethodHS256.Alg())
+	}
+	if cfg.JWKSURL != "" {
+		v.methods = append(v.methods, jwt.SigningMethodRS256.Alg())
+		v.jwks = &jwksCache{url: cfg.JWKSURL, client: &http.Client{Timeout: 10 * time.Second}}
+	}
+	return v
+}
+
+// looksLikeJWT reports whether token has the three-segment JWT shape; API
+// keys never contain dots
+func looksLikeJWT(token string) bool {
+	return !strings.HasPrefix(token, models.ApiKeyPrefix) && strings.Count(token, ".") == 2
+}
+
+// verify validates token and returns the client it authenticates. Its name
+// is "jwt:<sub>" and its permissions are the entries of the scope claim that
+// are valid permissions; the middleware sets its ID (see jwtClientID).
+func (v *jwtVerifier) verify(ctx context.Context, token string) (*models.ApiClient, error) {
+	opts := []jwt.ParserOption{jwt.WithValidMethods(v.methods), jwt.WithExpirationRequired()}
+	if v.cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(v.cfg.Issuer))
+	}
+	if v.cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(v.cfg.Audience))
+	}
+
+	var claims jwtClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(t *jwt.Token) (interface{}, error) {
+		switch t.Method.Alg() {
+		case jwt.SigningMethodHS256.Alg():
+			return []byte(v.cfg.HMACSecret), nil
+		case jwt.SigningMethodRS256.Alg():
+			kid, _ := t.Header["kid"].(string)
+			return v.jwks.key(ctx, kid)
+		}
+		return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("token has no sub claim")
+	}
+
+	var permissions []string
+	for _, scope := range strings.Fields(claims.Scope) {
+		if models.ValidPermission(scope) {
+			permissions = append(permissions, scope)
+		}
+	}
+	return &models.ApiClient{
+		Name:        jwtPrincipalPrefix + claims.Subject,
+		IsActive:    true,
+		Permissions: permissions,
+		Metadata:    map[string]string{"sub": claims.Subject},
+	}, nil
+}
+
+// jwksCache fetches and caches the RSA keys of a JWKS endpoint by key ID
+type jwksCache struct {
+	url    string
+	client *http.Client
+
+	// fetches joins concurrent refetches into one request
+	fetches singleflight.Group
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetched   time.Time
+	attempted time.Time // last fetch started, failed ones included
+}
+
+// key returns the key with kid, fetching the set when it is stale or when kid
+// is unknown (the issuer rotated keys), at most once per jwksMinRefetch. The
+// fetch runs outside the lock, shared by every request waiting on it.
+func (c *jwksCache) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	c.mu.Lock()
+	key, ok := c.keys[kid]
+	fresh := ok && time.Since(c.fetched) < jwksRefresh
+	c.mu.Unlock()
+	if fresh {
+		return key, nil
+	}
+
+	var (
+		keys map[string]*rsa.PublicKey
+		err  error
+	)
+	select {
+	case res := <-c.fetches.DoChan("", func() (interface{}, error) { return c.refresh() }):
+		keys, err = res.Val.(map[string]*rsa.PublicKey), res.Err
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		if ok {
+			// Keep verifying with the old set while the endpoint is down
+			return key, nil
+		}
+		return nil, err
+	}
+
+	if key, ok = keys[kid]; !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	return key, nil
+}
+
+// refresh fetches the key set unless a fetch started less than
+// jwksMinRefetch ago, in which case the cached set is returned as is
+func (c *jwksCache) refresh() (map[string]*rsa.PublicKey, error) {
+	c.mu.Lock()
+	if time.Since(c.attempted) < jwksMinRefetch {
+		keys := c.keys
+		c.mu.Unlock()
+		return keys, nil
+	}
+	c.attempted = time.Now()
+	c.mu.Unlock()
+
+	// Not the request's context: the fetch is shared with other requests
+	keys, err := c.fetch(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.keys = keys
+	c.fetched = time.Now()
+	return keys, nil
+}
+
+func (c *jwksCache) fetch(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build jwks request: %w", err)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch jwks: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch jwks: status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("failed to decode jwks: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jwks modulus for key %q: %w", k.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jwks exponent for key %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	return keys, nil
+}
@@ -0,0 +1,205 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/terra-clan/sandbox-engine/internal/config"
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+const testJWTSecret = "test-secret"
+
+func signHS256(t *testing.T, claims jwtClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testJWTSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func gatewayClaims(scope string) jwtClaims {
+	return jwtClaims{
+		Scope: scope,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "gateway",
+			Audience:  jwt.ClaimStrings{"sandbox-engine"},
+			Subject:   "user-42",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	}
+}
+
+func TestJWTAuthentication(t *testing.T) {
+	repo := &countingRepo{
+		clients:  map[string]*models.ApiClient{"sk_valid": {Name: "test", ApiKey: "sk_valid", IsActive: true, Permissions: []string{"sandboxes:read"}}},
+		lastUsed: make(chan string, 10),
+	}
+	auth := NewAuthMiddleware(repo, 0, 0)
+	auth.EnableJWT(config.JWTConfig{Issuer: "gateway", Audience: "sandbox-engine", HMACSecret: testJWTSecret})
+
+	var principal *models.ApiClient
+	handler := auth.Authenticate(auth.RequirePermission("sandboxes:read")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal = ClientFromContext(r.Context())
+	})))
+	status := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := status(signHS256(t, gatewayClaims("openid sandboxes:read"))); code != http.StatusOK {
+		t.Fatalf("valid token: expected 200, got %d", code)
+	}
+	if principal.Name != "jwt:user-42" || len(principal.Permissions) != 1 || principal.ID != 100 {
+		t.Errorf("unexpected principal %+v", principal)
+	}
+	other := gatewayClaims("sandboxes:read")
+	other.Subject = "user-43"
+	if code := status(signHS256(t, other)); code != http.StatusOK || principal.ID != 101 {
+		t.Errorf("expected another subject to get a client of its own, got %d with %+v", code, principal)
+	}
+	status(signHS256(t, gatewayClaims("sandboxes:read")))
+	if principal.ID != 100 {
+		t.Errorf("expected the same subject to keep its client, got %+v", principal)
+	}
+	if repo.lookups != 0 {
+		t.Errorf("expected no API key lookup for a JWT, got %d", repo.lookups)
+	}
+
+	if code := status(signHS256(t, gatewayClaims("sessions:read"))); code != http.StatusForbidden {
+		t.Errorf("token without the scope: expected 403, got %d", code)
+	}
+
+	wrongAudience := gatewayClaims("sandboxes:read")
+	wrongAudience.Audience = jwt.ClaimStrings{"other"}
+	expired := gatewayClaims("sandboxes:read")
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+	for name, token := range map[string]string{
+		"wrong audience": signHS256(t, wrongAudience),
+		"expired":        signHS256(t, expired),
+		"malformed":      "a.b.c",
+	} {
+		if code := status(token); code != http.StatusUnauthorized {
+			t.Errorf("%s: expected 401, got %d", name, code)
+		}
+	}
+
+	// API keys keep working
+	if code := status("sk_valid"); code != http.StatusOK {
+		t.Errorf("api key: expected 200, got %d", code)
+	}
+}
+
+func TestJWTJWKS(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kid": "k1",
+			"kty": "RSA",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	defer jwks.Close()
+
+	verifier := newJWTVerifier(config.JWTConfig{JWKSURL: jwks.URL})
+	sign := func(kid string) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, gatewayClaims("sessions:*"))
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	client, err := verifier.verify(context.Background(), sign("k1"))
+	if err != nil || !client.HasPermission("sessions:write") {
+		t.Fatalf("expected the RS256 token accepted, got %+v, %v", client, err)
+	}
+	if _, err := verifier.verify(context.Background(), sign("k2")); err == nil {
+		t.Error("expected an unknown key id rejected")
+	}
+	// HS256 isn't accepted without a secret
+	if _, err := verifier.verify(context.Background(), signHS256(t, gatewayClaims("sessions:*"))); err == nil {
+		t.Error("expected an HS256 token rejected")
+	}
+}
+
+func TestJWKSFetchesOnce(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fetches atomic.Int32
+	var healthy atomic.Bool
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kid": "k1",
+			"kty": "RSA",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	defer jwks.Close()
+
+	verifier := newJWTVerifier(config.JWTConfig{JWKSURL: jwks.URL})
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, gatewayClaims("sessions:*"))
+	token.Header["kid"] = "k1"
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Concurrent requests share one fetch, and its failure holds off refetches
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := verifier.verify(context.Background(), signed); err == nil {
+				t.Error("expected the token rejected while the endpoint fails")
+			}
+		}()
+	}
+	wg.Wait()
+	healthy.Store(true)
+	if _, err := verifier.verify(context.Background(), signed); err == nil {
+		t.Error("expected no refetch right after a failed fetch")
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("expected a single fetch, got %d", n)
+	}
+
+	verifier.jwks.mu.Lock()
+	verifier.jwks.attempted = time.Now().Add(-jwksMinRefetch)
+	verifier.jwks.mu.Unlock()
+	if _, err := verifier.verify(context.Background(), signed); err != nil {
+		t.Errorf("expected the token accepted once the endpoint recovers, got %v", err)
+	}
+}
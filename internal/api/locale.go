@@ -0,0 +1,91 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+// requestLocales returns the locales a request asks for, best first: the
+// lang query parameter, then the Accept-Language header by quality
+func requestLocales(r *http.Request) []string {
+	var locales []string
+	if lang := strings.TrimSpace(r.URL.Query().Get("lang")); lang != "" {
+		locales = append(locales, lang)
+	}
+	return append(locales, parseAcceptLanguage(r.Header.Get("Accept-Language"))...)
+}
+
+// parseAcceptLanguage returns the language tags of an Accept-Language
+// header by descending quality, leaving out * and those with q=0
+func parseAcceptLanguage(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q <= 0 {
+			continue
+		}
+		tags = append(tags, weighted{tag: tag, q: q})
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	out := make([]string, 0, len(tags))
+	for _, t := range tags {
+		out = append(out, t.tag)
+	}
+	return out
+}
+
+// localizeTask returns t with its title and description in the locale the
+// request asks for, or t itself when it has none of them
+func localizeTask(r *http.Request, t *models.CatalogTask) *models.CatalogTask {
+	if t == nil || (t.Titles == nil && t.Descriptions == nil) {
+		return t
+	}
+	locales := requestLocales(r)
+	if len(locales) == 0 {
+		return t
+	}
+	localized := *t
+	if title, ok := t.Titles.Pick(locales); ok {
+		localized.Title = title
+	}
+	if description, ok := t.Descriptions.Pick(locales); ok {
+		localized.Description = description
+	}
+	return &localized
+}
+
+// localizeTemplate returns t with its description in the locale the request
+// asks for, or t itself when it has none of them
+func localizeTemplate(r *http.Request, t *models.Template) *models.Template {
+	if t == nil || t.Descriptions == nil {
+		return t
+	}
+	description, ok := t.Descriptions.Pick(requestLocales(r))
+	if !ok {
+		return t
+	}
+	localized := *t
+	localized.Description = description
+	return &localized
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+func TestRequestLocales(t *testing.T) {
+	tests := []struct {
+		url    string
+		header string
+		want   []string
+	}{
+		{"/", "", []string{}},
+		{"/", "ru-RU,ru;q=0.9,en;q=0.8", []string{"ru-RU", "ru", "en"}},
+		{"/", "en;q=0.5, de, *;q=0.1, fr;q=0", []string{"de", "en"}},
+		{"/?lang=kk", "ru", []string{"kk", "ru"}},
+		{"/", "en;q=abc, ru", []string{"ru"}},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+		if tt.header != "" {
+			r.Header.Set("Accept-Language", tt.header)
+		}
+		if got := requestLocales(r); !slices.Equal(got, tt.want) {
+			t.Errorf("%s %q: expected %v, got %v", tt.url, tt.header, tt.want, got)
+		}
+	}
+
+	text := models.LocalizedText{"en": "Cart", "ru": "Корзина"}
+	if got, ok := text.Pick([]string{"de", "ru-RU"}); !ok || got != "Корзина" {
+		t.Errorf("expected ru-RU to match ru, got %q", got)
+	}
+	if _, ok := text.Pick([]string{"de"}); ok {
+		t.Error("expected no match for de")
+	}
+}
+
+func TestLocalizedTemplate(t *testing.T) {
+	router := newMemoryServer(t)
+	doc := map[string]any{"name": "api-shop", "base_image": "node:20", "description": map[string]string{"en": "Shop API", "ru": "API магазина"}}
+	if code := call(t, router, http.MethodPost, "/api/v1/templates", doc, nil); code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d", code)
+	}
+
+	for query, want := range map[string]string{"": "Shop API", "?lang=ru": "API магазина", "?lang=de": "Shop API"} {
+		var tmpl struct {
+			Description  string               `json:"description"`
+			Descriptions models.LocalizedText `json:"descriptions"`
+		}
+		if code := call(t, router, http.MethodGet, "/api/v1/templates/api-shop"+query, nil, &tmpl); code != http.StatusOK {
+			t.Fatalf("get%s: expected 200, got %d", query, code)
+		}
+		if tmpl.Description != want || len(tmpl.Descriptions) != 2 {
+			t.Errorf("get%s: expected %q and both locales, got %+v", query, want, tmpl)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/sandbox"
+)
+
+const (
+	// logBacklogLines is how many recent lines a log feed keeps for viewers
+	// that join after it started, and the largest tail a viewer can ask for
+	logBacklogLines = 1000
+	// logViewerBuffer is how many lines may queue for one viewer before it is
+	// dropped as too slow
+	logViewerBuffer = 256
+	// maxLogLineBytes splits longer lines so a log without newlines can't
+	// grow the line buffer without bound
+	maxLogLineBytes = 16 << 10
+)
+
+// LogMessage is a message of the log viewer WebSocket
+type LogMessage struct {
+	Type   string `json:"type"`             // connected, log, end or error
+	Stream string `json:"stream,omitempty"` // stdout or stderr, for log messages
+	Data   string `json:"data,omitempty"`
+}
+
+// logHub shares one followed Docker log stream per sandbox among all of its
+// viewers. The stream starts with the first viewer and stops with the last.
+type logHub struct {
+	follow func(ctx context.Context, id string, opts sandbox.LogOptions, stdout, stderr io.Writer) error
+
+	mu    sync.Mutex
+	feeds map[string]*logFeed
+}
+
+// logFeed is the followed log of one sandbox
+type logFeed struct {
+	sandboxID string
+	cancel    context.CancelFunc
+
+	mu      sync.Mutex
+	backlog []LogMessage
+	viewers map[*logViewer]struct{}
+	ended   bool
+}
+
+// logViewer receives a feed's lines on ch, which is closed when the feed
+// ends or the viewer falls too far behind
+type logViewer struct {
+	feed    *logFeed
+	ch      chan LogMessage
+	dropped bool // set before ch is closed for a slow viewer
+}
+
+func newLogHub(manager sandbox.Manager) *logHub {
+	return &logHub{
+		follow: manager.FollowLogs,
+		feeds:  make(map[string]*logFeed),
+	}
+}
+
+// subscribe joins the feed of sandboxID, starting it if this is the first
+// viewer, and returns the last tail lines it has seen. The first viewer's tail
+// is also how far back the feed starts reading.
+func (h *logHub) subscribe(sandboxID string, tail int) ([]LogMessage, *logViewer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	feed := h.feeds[sandboxID]
+	start := feed == nil
+	var ctx context.Context
+	if start {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(context.Background())
+		feed = &logFeed{sandboxID: sandboxID, cancel: cancel, viewers: make(map[*logViewer]struct{})}
+		h.feeds[sandboxID] = feed
+	}
+
+	v := &logViewer{feed: feed, ch: make(chan LogMessage, logViewerBuffer)}
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+	feed.viewers[v] = struct{}{}
+	if start {
+		// The viewer is registered first so it sees every line on ch
+		go h.run(ctx, feed, tail)
+	}
+
+	backlog := feed.backlog
+	if len(backlog) > tail {
+		backlog = backlog[len(backlog)-tail:]
+	}
+	return append([]LogMessage(nil), backlog...), v
+}
+
+// unsubscribe removes v from its feed, stopping the feed when no viewers remain
+func (h *logHub) unsubscribe(v *logViewer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	feed := v.feed
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+	if _, ok := feed.viewers[v]; ok {
+		delete(feed.viewers, v)
+		close(v.ch)
+	}
+	if len(feed.viewers) == 0 && !feed.ended {
+		feed.cancel()
+		if h.feeds[feed.sandboxID] == feed {
+			delete(h.feeds, feed.sandboxID)
+		}
+	}
+}
+
+// run follows the sandbox log into feed until the container stops or the
+// last viewer leaves
+func (h *logHub) run(ctx context.Context, feed *logFeed, tail int) {
+	stdout := &logLineWriter{stream: "stdout", emit: feed.publish}
+	stderr := &logLineWriter{stream: "stderr", emit: feed.publish}
+	err := h.follow(ctx, feed.sandboxID, sandbox.LogOptions{Tail: tail}, stdout, stderr)
+	if err != nil {
+		slog.Warn("log feed ended with an error", "sandbox_id", feed.sandboxID, "error", err)
+	}
+	stdout.flush()
+	stderr.flush()
+
+	// Viewers arriving from now on start a new feed
+	h.mu.Lock()
+	if h.feeds[feed.sandboxID] == feed {
+		delete(h.feeds, feed.sandboxID)
+	}
+	h.mu.Unlock()
+
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+	feed.ended = true
+	for v := range feed.viewers {
+		delete(feed.viewers, v)
+		close(v.ch)
+	}
+	feed.cancel()
+}
+
+// publish records msg in the backlog and sends it to every viewer, dropping
+// viewers whose queue is full rather than stalling the others
+func (f *logFeed) publish(msg LogMessage) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.backlog) == logBacklogLines {
+		f.backlog = f.backlog[1:]
+	}
+	f.backlog = append(f.backlog, msg)
+
+	for v := range f.viewers {
+		select {
+		case v.ch <- msg:
+		default:
+			v.dropped = true
+			delete(f.viewers, v)
+			close(v.ch)
+		}
+	}
+}
+
+// logLineWriter splits one output stream into log messages, one per line
+type logLineWriter struct {
+	stream string
+	emit   func(LogMessage)
+	buf    []byte
+}
+
+func (w *logLineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.send(w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+	for len(w.buf) >= maxLogLineBytes {
+		w.send(w.buf[:maxLogLineBytes])
+		w.buf = w.buf[maxLogLineBytes:]
+	}
+	// Compact so the buffer doesn't keep growing behind the slice
+	w.buf = append([]byte(nil), w.buf...)
+	return len(p), nil
+}
+
+// flush sends a trailing line without a newline
+func (w *logLineWriter) flush() {
+	if len(w.buf) > 0 {
+		w.send(w.buf)
+		w.buf = nil
+	}
+}
+
+func (w *logLineWriter) send(line []byte) {
+	// TTY output ends lines with \r\n
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	w.emit(LogMessage{Type: "log", Stream: w.stream, Data: string(line)})
+}
+
+// handleLogsWS streams a sandbox log to an API client
+func (s *Server) handleLogsWS(w http.ResponseWriter, r *http.Request) {
+	sb := s.wsSandbox(w, r)
+	if sb == nil {
+		return
+	}
+	if !canAccessSandbox(r, sb) {
+		http.Error(w, "sandbox not found", http.StatusNotFound)
+		return
+	}
+	s.serveLogs(w, r, sb)
+}
+
+// handleSessionLogsWS streams a session sandbox log to a session token holder
+func (s *Server) handleSessionLogsWS(w http.ResponseWriter, r *http.Request) {
+	if s.authorizeSessionSandbox(w, r) == nil {
+		return
+	}
+	if sb := s.wsSandbox(w, r); sb != nil {
+		s.serveLogs(w, r, sb)
+	}
+}
+
+// logsWSHandler routes the log viewer to session token or API key auth.
+// The API key path runs the usual authenticated middleware chain.
+func (s *Server) logsWSHandler() http.HandlerFunc {
+	withSession := s.rateLimiter.ByIP(http.HandlerFunc(s.handleSessionLogsWS))
+	withAPIKey := s.authMiddleware.Authenticate(
+		s.rateLimiter.ByClient(
+			s.authMiddleware.RequirePermission("sandboxes:read")(http.HandlerFunc(s.handleLogsWS))))
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("session_token") {
+			withSession.ServeHTTP(w, r)
+			return
+		}
+		withAPIKey.ServeHTTP(w, r)
+	}
+}
+
+// serveLogs sends the last ?tail= lines (default 100) of sb's log over the
+// WebSocket and then follows it; callers have authorized access to sb
+func (s *Server) serveLogs(w http.ResponseWriter, r *http.Request, sb *models.Sandbox) {
+	tail := defaultLogTail
+	if tailStr := r.URL.Query().Get("tail"); tailStr != "" {
+		if t, err := strconv.Atoi(tailStr); err == nil && t >= 0 {
+			tail = min(t, logBacklogLines)
+		}
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.Error("failed to upgrade to websocket", "error", err)
+		return
+	}
+	defer conn.Close()
+
+	backlog, viewer := s.logHub.subscribe(sb.ID, tail)
+	defer s.logHub.unsubscribe(viewer)
+
+	slog.Info("log viewer connected", "sandbox_id", sb.ID)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var writeMu sync.Mutex
+	var wg sync.WaitGroup
+	send := func(msg LogMessage) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		return sendLogMessage(conn, msg)
+	}
+
+	keepAlive(ctx, cancel, &wg, conn, &writeMu, sb.ID)
+
+	// Viewers don't send anything, but reading handles pongs and notices the close
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer cancel()
+		defer conn.Close() // unblocks the reader
+
+		if send(LogMessage{Type: "connected", Data: "Following sandbox logs"}) != nil {
+			return
+		}
+		for _, msg := range backlog {
+			if send(msg) != nil {
+				return
+			}
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-viewer.ch:
+				if !ok {
+					if viewer.dropped {
+						send(LogMessage{Type: "error", Data: "viewer fell behind the log, reconnect to resume"})
+					} else {
+						send(LogMessage{Type: "end", Data: "log stream ended"})
+					}
+					writeMu.Lock()
+					conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+					conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+					writeMu.Unlock()
+					return
+				}
+				if send(msg) != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	wg.Wait()
+	slog.Info("log viewer disconnected", "sandbox_id", sb.ID)
+}
+
+func sendLogMessage(conn *websocket.Conn, msg LogMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		slog.Error("failed to marshal log message", "error", err)
+		return err
+	}
+	return conn.WriteMessage(websocket.TextMessage, data)
+}
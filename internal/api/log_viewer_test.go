@@ -0,0 +1,229 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/sandbox"
+)
+
+// followManager follows a log that prints lines and then waits for more
+type followManager struct {
+	sandbox.Manager
+	lines   []string
+	follows atomic.Int32
+	tails   chan int
+	stopped chan struct{}
+}
+
+func newFollowManager(lines ...string) *followManager {
+	return &followManager{lines: lines, tails: make(chan int, 10), stopped: make(chan struct{}, 10)}
+}
+
+func (m *followManager) FollowLogs(ctx context.Context, id string, opts sandbox.LogOptions, stdout, stderr io.Writer) error {
+	m.follows.Add(1)
+	m.tails <- opts.Tail
+	for _, line := range m.lines {
+		fmt.Fprintln(stdout, line)
+	}
+	fmt.Fprint(stderr, "warn\r\n")
+	<-ctx.Done()
+	m.stopped <- struct{}{}
+	return nil
+}
+
+func (m *followManager) Get(ctx context.Context, id string) (*models.Sandbox, error) {
+	return &models.Sandbox{ID: id, Status: "running"}, nil
+}
+
+func (m *followManager) GetSessionByToken(ctx context.Context, token string) (*models.Session, error) {
+	if token != "good" {
+		return nil, sandbox.ErrSessionNotFound
+	}
+	return &models.Session{Token: token, SandboxID: "sb-1", Status: models.SessionActive}, nil
+}
+
+func receive(t *testing.T, ch <-chan LogMessage) LogMessage {
+	t.Helper()
+	select {
+	case msg, ok := <-ch:
+		if !ok {
+			t.Fatal("viewer channel closed")
+		}
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a log line")
+	}
+	return LogMessage{}
+}
+
+func waitFor(t *testing.T, ch <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the feed to stop")
+	}
+}
+
+func TestLogHubSharesOneFeed(t *testing.T) {
+	manager := newFollowManager("one", "two", "three")
+	hub := newLogHub(manager)
+
+	backlog, first := hub.subscribe("sb-1", 50)
+	if len(backlog) != 0 {
+		t.Fatalf("expected an empty backlog for the first viewer, got %v", backlog)
+	}
+	if tail := <-manager.tails; tail != 50 {
+		t.Errorf("expected the first viewer's tail to be followed, got %d", tail)
+	}
+	var got []LogMessage
+	for range 4 {
+		got = append(got, receive(t, first.ch))
+	}
+	// stdout and stderr are written in turn, so the order is fixed
+	want := []LogMessage{
+		{Type: "log", Stream: "stdout", Data: "one"},
+		{Type: "log", Stream: "stdout", Data: "two"},
+		{Type: "log", Stream: "stdout", Data: "three"},
+		{Type: "log", Stream: "stderr", Data: "warn"},
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("line %d: expected %+v, got %+v", i, want[i], got[i])
+		}
+	}
+
+	// A second viewer joins the running feed and gets its tail of the backlog
+	backlog, second := hub.subscribe("sb-1", 2)
+	if len(backlog) != 2 || backlog[0].Data != "three" || backlog[1].Data != "warn" {
+		t.Errorf("expected the last two lines, got %+v", backlog)
+	}
+	if n := manager.follows.Load(); n != 1 {
+		t.Errorf("expected one Docker follow for two viewers, got %d", n)
+	}
+
+	hub.unsubscribe(first)
+	select {
+	case <-manager.stopped:
+		t.Fatal("feed stopped while a viewer remained")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	hub.unsubscribe(second)
+	waitFor(t, manager.stopped)
+
+	// The next viewer starts a fresh feed
+	_, third := hub.subscribe("sb-1", 10)
+	receive(t, third.ch)
+	if n := manager.follows.Load(); n != 2 {
+		t.Errorf("expected a new follow after the feed stopped, got %d", n)
+	}
+	hub.unsubscribe(third)
+	waitFor(t, manager.stopped)
+}
+
+func TestLogHubDropsSlowViewer(t *testing.T) {
+	feed := &logFeed{sandboxID: "sb-1", viewers: make(map[*logViewer]struct{})}
+	slow := &logViewer{feed: feed, ch: make(chan LogMessage, logViewerBuffer)}
+	feed.viewers[slow] = struct{}{}
+
+	for i := range logViewerBuffer + 1 {
+		feed.publish(LogMessage{Type: "log", Stream: "stdout", Data: fmt.Sprint(i)})
+	}
+
+	if !slow.dropped {
+		t.Fatal("expected the viewer to be dropped once its queue filled")
+	}
+	if len(feed.viewers) != 0 {
+		t.Errorf("expected the viewer to be removed from the feed")
+	}
+	n := 0
+	for range slow.ch {
+		n++
+	}
+	if n != logViewerBuffer {
+		t.Errorf("expected %d queued lines before the drop, got %d", logViewerBuffer, n)
+	}
+}
+
+func TestLogLineWriterSplitsLines(t *testing.T) {
+	var got []string
+	w := &logLineWriter{stream: "stdout", emit: func(msg LogMessage) { got = append(got, msg.Data) }}
+
+	fmt.Fprint(w, "par")
+	fmt.Fprint(w, "tial\nnext\r\n")
+	fmt.Fprint(w, strings.Repeat("x", maxLogLineBytes+1))
+	w.flush()
+
+	want := []string{"partial", "next", strings.Repeat("x", maxLogLineBytes), "x"}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d lines, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("line %d: expected %d bytes, got %d", i, len(want[i]), len(got[i]))
+		}
+	}
+}
+
+func TestSessionLogsWS(t *testing.T) {
+	manager := newFollowManager("ready")
+	s := &Server{sandboxManager: manager, logHub: newLogHub(manager)}
+	router := chi.NewRouter()
+	router.Get("/ws/logs/{id}", s.handleSessionLogsWS)
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws/logs/sb-1?session_token=good&tail=10"
+
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+
+			var msg LogMessage
+			if err := conn.ReadJSON(&msg); err != nil || msg.Type != "connected" {
+				t.Errorf("expected a connected message, got %+v (%v)", msg, err)
+				return
+			}
+			if err := conn.ReadJSON(&msg); err != nil || msg != (LogMessage{Type: "log", Stream: "stdout", Data: "ready"}) {
+				t.Errorf("expected the log line, got %+v (%v)", msg, err)
+			}
+		}()
+	}
+	wg.Wait()
+	waitFor(t, manager.stopped)
+
+	if n := manager.follows.Load(); n > 2 {
+		t.Errorf("expected at most one follow per concurrent feed, got %d", n)
+	}
+
+	// A token for another sandbox is refused before the upgrade
+	_, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws/logs/sb-2?session_token=good", nil)
+	if err == nil {
+		t.Fatal("expected the dial to fail")
+	}
+	if resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected 403 for another sandbox")
+	}
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/sandbox"
+)
+
+// logExportManifestEntry describes the export result of one sandbox
+type logExportManifestEntry struct {
+	SandboxID string `json:"sandbox_id"`
+	Success   bool   `json:"success"`
+	Bytes     int    `json:"bytes"`
+	Truncated bool   `json:"truncated,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// handleExportLogs streams a tar.gz with the log tail of every matching sandbox
+// plus a manifest.json with per-sandbox results
+func (s *Server) handleExportLogs(w http.ResponseWriter, r *http.Request) {
+	var req models.LogExportRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid_request", "invalid JSON body")
+			return
+		}
+	}
+
+	if req.TailBytes < 0 {
+		respondError(w, http.StatusBadRequest, "validation_error", "tail_bytes must not be negative")
+		return
+	}
+
+	filters := models.ListFilters{
+		UserID:     req.UserID,
+		TemplateID: req.TemplateID,
+		Status:     req.Status,
+
+		OwnerClientID: sandboxOwnerScope(r),
+	}
+	opts := sandbox.LogExportOptions{MaxBytes: req.TailBytes}
+
+	archive := newLogArchive(w)
+	started := false
+
+	// Headers go out with the first entry so listing errors can still be reported as JSON
+	start := func() {
+		if started {
+			return
+		}
+		started = true
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="sandbox-logs-%s.tar.gz"`, time.Now().UTC().Format("20060102-150405")))
+		w.WriteHeader(http.StatusOK)
+	}
+
+	err := s.sandboxManager.ExportLogs(r.Context(), filters, opts, func(res *sandbox.LogExport) error {
+		start()
+		return archive.add(res)
+	})
+
+	if err != nil && !started {
+		if errors.Is(err, sandbox.ErrTooManySandboxes) {
+			respondError(w, http.StatusBadRequest, "too_many_sandboxes",
+				fmt.Sprintf("more than %d sandboxes match, narrow the filters", sandbox.MaxLogExportSandboxes))
+			return
+		}
+		slog.Error("failed to export logs", "error", err)
+		respondError(w, http.StatusInternalServerError, "internal_error", "failed to export logs")
+		return
+	}
+
+	if err != nil {
+		// The archive is already partially sent; the client sees a truncated stream
+		slog.Error("log export aborted", "error", err)
+		return
+	}
+
+	start()
+	if err := archive.close(); err != nil {
+		slog.Error("failed to finish log export", "error", err)
+	}
+}
+
+// logArchive writes log exports as a streamed tar.gz, flushing after each entry
+type logArchive struct {
+	w        io.Writer
+	gz       *gzip.Writer
+	tw       *tar.Writer
+	manifest []logExportManifestEntry
+}
+
+func newLogArchive(w io.Writer) *logArchive {
+	gz := gzip.NewWriter(w)
+	return &logArchive{
+		w:        w,
+		gz:       gz,
+		tw:       tar.NewWriter(gz),
+		manifest: make([]logExportManifestEntry, 0),
+	}
+}
+
+// add writes one sandbox log as <sandbox-id>.log; failures only go to the manifest
+func (a *logArchive) add(res *sandbox.LogExport) error {
+	entry := logExportManifestEntry{
+		SandboxID: res.SandboxID,
+		Success:   res.Err == nil,
+		Bytes:     len(res.Data),
+		Truncated: res.Truncated,
+	}
+	if res.Err != nil {
+		entry.Error = res.Err.Error()
+		entry.Bytes = 0
+	}
+	a.manifest = append(a.manifest, entry)
+
+	if res.Err != nil {
+		return nil
+	}
+
+	if err := a.writeFile(res.SandboxID+".log", res.Data); err != nil {
+		return err
+	}
+	return a.flush()
+}
+
+// close writes the manifest and terminates the archive
+func (a *logArchive) close() error {
+	manifest, err := json.MarshalIndent(map[string]interface{}{
+		"generated_at": time.Now().UTC(),
+		"sandboxes":    a.manifest,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+
+	if err := a.writeFile("manifest.json", manifest); err != nil {
+		return err
+	}
+	if err := a.tw.Close(); err != nil {
+		return fmt.Errorf("failed to close tar: %w", err)
+	}
+	if err := a.gz.Close(); err != nil {
+		return fmt.Errorf("failed to close gzip: %w", err)
+	}
+	return a.flush()
+}
+
+func (a *logArchive) writeFile(name string, data []byte) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}
+	if err := a.tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to write tar header: %w", err)
+	}
+	if _, err := a.tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// flush pushes buffered output to the client so large exports stream
+func (a *logArchive) flush() error {
+	if err := a.tw.Flush(); err != nil {
+		return err
+	}
+	if err := a.gz.Flush(); err != nil {
+		return err
+	}
+	if f, ok := a.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
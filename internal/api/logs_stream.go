@@ -0,0 +1,107 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/terra-clan/sandbox-engine/internal/sandbox"
+)
+
+// defaultLogTail is how many lines the log endpoints return without tail or since
+const defaultLogTail = 100
+
+// parseLogOptions reads tail, since and timestamps from the query, returning a
+// message for the first invalid one. since is an RFC 3339 time or a duration
+// back from now ("10m"); with since and no tail every line since then is returned.
+func parseLogOptions(r *http.Request) (sandbox.LogOptions, string) {
+	q := r.URL.Query()
+	opts := sandbox.LogOptions{Tail: defaultLogTail}
+
+	if sinceStr := q.Get("since"); sinceStr != "" {
+		if d, err := time.ParseDuration(sinceStr); err == nil && d > 0 {
+			opts.Since = time.Now().Add(-d)
+		} else if t, err := time.Parse(time.RFC3339, sinceStr); err == nil {
+			opts.Since = t
+		} else {
+			return opts, "since must be an RFC 3339 time or a positive duration such as 10m"
+		}
+		opts.Tail = 0
+	}
+
+	// Invalid tails fall back to the default, as they always have
+	if tailStr := q.Get("tail"); tailStr != "" {
+		if t, err := strconv.Atoi(tailStr); err == nil && t > 0 {
+			opts.Tail = t
+		}
+	}
+
+	if tsStr := q.Get("timestamps"); tsStr != "" {
+		ts, err := strconv.ParseBool(tsStr)
+		if err != nil {
+			return opts, "timestamps must be true or false"
+		}
+		opts.Timestamps = ts
+	}
+
+	return opts, ""
+}
+
+// handleStreamLogs streams a sandbox log as chunked plain text, following new
+// output until the client disconnects or the container stops
+func (s *Server) handleStreamLogs(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	opts, msg := parseLogOptions(r)
+	if msg != "" {
+		respondError(w, http.StatusBadRequest, "validation_error", msg)
+		return
+	}
+
+	// Disconnecting cancels the request context, which ends the Docker stream
+	logs, err := s.sandboxManager.StreamLogs(r.Context(), id, opts)
+	if err != nil {
+		if errors.Is(err, sandbox.ErrSandboxNotFound) {
+			respondError(w, http.StatusNotFound, "not_found", "sandbox not found")
+			return
+		}
+		slog.Error("failed to stream logs", "error", err, "id", id)
+		respondError(w, http.StatusInternalServerError, "internal_error", "failed to stream logs")
+		return
+	}
+	defer logs.Close()
+
+	// The server's write timeout would cut the stream off
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		slog.Warn("failed to clear write deadline for log stream", "error", err, "id", id)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, readErr := logs.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return
+			}
+			rc.Flush()
+		}
+		if readErr != nil {
+			if readErr != io.EOF && r.Context().Err() == nil {
+				slog.Warn("log stream ended with an error", "error", readErr, "id", id)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/terra-clan/sandbox-engine/internal/sandbox"
+)
+
+func TestParseLogOptions(t *testing.T) {
+	for _, tc := range []struct {
+		query string
+		tail  int
+		since bool
+		ts    bool
+		bad   bool
+	}{
+		{query: "", tail: defaultLogTail},
+		{query: "tail=20&timestamps=true", tail: 20, ts: true},
+		{query: "tail=-1", tail: defaultLogTail},
+		{query: "since=10m", since: true},
+		{query: "since=2026-01-02T15:04:05Z&tail=5", tail: 5, since: true},
+		{query: "since=yesterday", bad: true},
+		{query: "timestamps=sometimes", bad: true},
+	} {
+		opts, msg := parseLogOptions(httptest.NewRequest(http.MethodGet, "/logs?"+tc.query, nil))
+		if (msg != "") != tc.bad {
+			t.Errorf("%q: expected bad=%v, got %q", tc.query, tc.bad, msg)
+			continue
+		}
+		if tc.bad {
+			continue
+		}
+		if opts.Tail != tc.tail || opts.Since.IsZero() == tc.since || opts.Timestamps != tc.ts {
+			t.Errorf("%q: unexpected options %+v", tc.query, opts)
+		}
+	}
+}
+
+// streamManager follows a log that writes one line and then waits for more
+type streamManager struct {
+	sandbox.Manager
+	opts   sandbox.LogOptions
+	closed chan struct{}
+}
+
+func (m *streamManager) StreamLogs(ctx context.Context, id string, opts sandbox.LogOptions) (io.ReadCloser, error) {
+	if id != "sb-1" {
+		return nil, sandbox.ErrSandboxNotFound
+	}
+	m.opts = opts
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte("line 1\n"))
+		<-ctx.Done()
+		pw.CloseWithError(ctx.Err())
+	}()
+	return &closeNotifier{ReadCloser: pr, closed: m.closed}, nil
+}
+
+type closeNotifier struct {
+	io.ReadCloser
+	closed chan struct{}
+}
+
+func (c *closeNotifier) Close() error {
+	close(c.closed)
+	return c.ReadCloser.Close()
+}
+
+func TestStreamLogsUntilDisconnect(t *testing.T) {
+	manager := &streamManager{closed: make(chan struct{})}
+	s := &Server{sandboxManager: manager}
+	router := chi.NewRouter()
+	router.Get("/sandboxes/{id}/logs/stream", s.handleStreamLogs)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/sandboxes/sb-1/logs/stream?timestamps=true", nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+
+	done := make(chan struct{})
+	go func() {
+		router.ServeHTTP(rec, req)
+		close(done)
+	}()
+
+	// The client goes away while the log is still being followed
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the stream to end when the client disconnected")
+	}
+	select {
+	case <-manager.closed:
+	default:
+		t.Error("expected the log stream closed")
+	}
+
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("expected a 200 text stream, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if rec.Body.String() != "line 1\n" || !manager.opts.Timestamps {
+		t.Errorf("unexpected body %q with options %+v", rec.Body, manager.opts)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/sandboxes/missing/logs/stream", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("missing sandbox: expected 404, got %d", rec.Code)
+	}
+}
+
+func TestStreamLogsRoute(t *testing.T) {
+	router := newMemoryServer(t)
+
+	// Ownership is checked before the stream opens
+	if code := call(t, router, http.MethodGet, "/api/v1/sandboxes/missing/logs/stream", nil, nil); code != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing sandbox, got %d", code)
+	}
+}
@@ -0,0 +1,1122 @@
+package api
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/config"
+	"github.com/terra-clan/sandbox-engine/internal/metrics"
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/sandbox"
+	"github.com/terra-clan/sandbox-engine/internal/services"
+	"github.com/terra-clan/sandbox-engine/internal/storage"
+	"github.com/terra-clan/sandbox-engine/internal/templates"
+	"github.com/terra-clan/sandbox-engine/internal/version"
+)
+
+const memoryTestKey = "sk_test_memory"
+
+// newMemoryServer wires the real manager and router to an in-memory
+// repository. Docker is unreachable, so provisioning fails and rolls back.
+func newMemoryServer(t *testing.T) http.Handler {
+	t.Helper()
+	router, _ := newMemoryServerRepo(t)
+	return router
+}
+
+// newMemoryServerRepo is newMemoryServer also returning its repository
+func newMemoryServerRepo(t *testing.T) (http.Handler, *storage.MemoryRepository) {
+	t.Helper()
+	// Cache lookups as in production, so the client flow checks invalidation
+	return newMemoryServerConfig(t, config.ServerConfig{AuthCacheTTL: time.Minute})
+}
+
+// newMemoryServerConfig is newMemoryServerRepo with a server configuration
+func newMemoryServerConfig(t *testing.T, cfg config.ServerConfig) (http.Handler, *storage.MemoryRepository) {
+	t.Helper()
+
+	repo := storage.NewMemoryRepository()
+	loader := templates.NewLoader()
+	loader.SetDocumentSource(repo)
+	if err := loader.LoadFromDir(filepath.Join("testdata", "catalog")); err != nil {
+		t.Fatal(err)
+	}
+
+	repo.AddClient(&models.ApiClient{
+		Name:        "test",
+		ApiKey:      memoryTestKey,
+		IsActive:    true,
+		Permissions: []string{"sandboxes:*", "sessions:*", "templates:*", "clients:admin"},
+	})
+
+	dockerCfg := config.DockerConfig{Host: "unix:///nonexistent/docker.sock", HostName: "default"}
+	manager, err := sandbox.NewManager(dockerCfg, config.TraefikConfig{}, config.SandboxConfig{}, services.NewRegistry(), loader, repo, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { manager.Close() })
+
+	return NewServer(cfg, manager, loader, repo, nil, nil).Router(), repo
+}
+
+// call sends an authenticated request and decodes the data envelope into out
+func call(t *testing.T, router http.Handler, method, path string, body any, out any) int {
+	t.Helper()
+	return callAs(t, router, memoryTestKey, method, path, body, out)
+}
+
+// callAs is call authenticated with apiKey
+func callAs(t *testing.T, router http.Handler, apiKey, method, path string, body any, out any) int {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if out != nil && rec.Code < 300 {
+		envelope := struct {
+			Data json.RawMessage `json:"data"`
+		}{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &envelope); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		if err := json.Unmarshal(envelope.Data, out); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return rec.Code
+}
+
+func TestSandboxFlow(t *testing.T) {
+	router := newMemoryServer(t)
+
+	var created struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	}
+	if code := call(t, router, http.MethodPost, "/api/v1/sandboxes", models.CreateRequest{TemplateID: "demo-shop", UserID: "user-1"}, &created); code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d", code)
+	}
+	if created.ID == "" {
+		t.Fatal("expected a sandbox id")
+	}
+
+	var list struct {
+		Total int `json:"total"`
+	}
+	if code := call(t, router, http.MethodGet, "/api/v1/sandboxes", nil, &list); code != http.StatusOK || list.Total != 1 {
+		t.Errorf("list: expected one sandbox, got %d with total %d", code, list.Total)
+	}
+
+	// Provisioning fails without Docker; wait for the rollback before deleting
+	path := "/api/v1/sandboxes/" + created.ID
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var got struct {
+			Status string `json:"status"`
+		}
+		if code := call(t, router, http.MethodGet, path, nil, &got); code != http.StatusOK {
+			t.Fatalf("get: expected 200, got %d", code)
+		}
+		if got.Status == string(models.StatusFailed) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the sandbox to fail provisioning, still %s", got.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if code := call(t, router, http.MethodDelete, path, nil, nil); code != http.StatusOK {
+		t.Fatalf("delete: expected 200, got %d", code)
+	}
+	if code := call(t, router, http.MethodGet, path, nil, nil); code != http.StatusNotFound {
+		t.Errorf("get after delete: expected 404, got %d", code)
+	}
+
+	// The record is kept for listings that ask for deleted sandboxes
+	if code := call(t, router, http.MethodGet, "/api/v1/sandboxes", nil, &list); code != http.StatusOK || list.Total != 0 {
+		t.Errorf("list after delete: expected no sandboxes, got %d with total %d", code, list.Total)
+	}
+	var deleted struct {
+		Sandboxes []struct {
+			DeletedAt *time.Time `json:"deleted_at"`
+		} `json:"sandboxes"`
+	}
+	if code := call(t, router, http.MethodGet, "/api/v1/sandboxes?include_deleted=true", nil, &deleted); code != http.StatusOK || len(deleted.Sandboxes) != 1 || deleted.Sandboxes[0].DeletedAt == nil {
+		t.Errorf("list with include_deleted: expected the deleted sandbox, got %d with %+v", code, deleted.Sandboxes)
+	}
+}
+
+func TestSessionFlow(t *testing.T) {
+	router, repo := newMemoryServerRepo(t)
+
+	req := models.CreateSessionRequest{TemplateID: "demo-shop", TTL: 3600, JoinTTL: 86400}
+	var created struct {
+		ID     string     `json:"id"`
+		Token  string     `json:"token"`
+		JoinBy *time.Time `json:"join_by"`
+	}
+	if code := call(t, router, http.MethodPost, "/api/v1/sessions", req, &created); code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d", code)
+	}
+	if created.JoinBy == nil {
+		t.Error("create: expected the join deadline")
+	}
+
+	// The session's sandbox will belong to the client that created it
+	client, err := repo.GetClientByApiKey(context.Background(), memoryTestKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored, err := repo.GetSessionByID(context.Background(), created.ID); err != nil || stored.OwnerClientID != client.ID {
+		t.Errorf("create: expected the session owned by client %d, got %+v, %v", client.ID, stored, err)
+	}
+
+	// The invite page shows the deadline
+	var joined models.JoinSessionResponse
+	if code := call(t, router, http.MethodGet, "/api/v1/join/"+created.Token, nil, &joined); code != http.StatusOK || joined.JoinBy == nil || !joined.JoinBy.Equal(*created.JoinBy) {
+		t.Errorf("join: expected the join deadline, got %d with %v", code, joined.JoinBy)
+	}
+
+	path := "/api/v1/sessions/" + created.ID
+	var got struct {
+		Status string `json:"status"`
+	}
+	if code := call(t, router, http.MethodGet, path, nil, &got); code != http.StatusOK || got.Status != string(models.SessionReady) {
+		t.Errorf("get: expected a ready session, got %d with %q", code, got.Status)
+	}
+
+	var list struct {
+		Sessions []json.RawMessage `json:"sessions"`
+	}
+	if code := call(t, router, http.MethodGet, "/api/v1/sessions", nil, &list); code != http.StatusOK || len(list.Sessions) != 1 {
+		t.Errorf("list: expected one session, got %d with %d", code, len(list.Sessions))
+	}
+
+	// Only active sessions have a clock to extend
+	if code := call(t, router, http.MethodPost, path+"/extend", models.ExtendRequest{Duration: time.Hour}, nil); code != http.StatusConflict {
+		t.Errorf("extend a ready session: expected 409, got %d", code)
+	}
+
+	if code := call(t, router, http.MethodDelete, path, nil, nil); code != http.StatusOK {
+		t.Fatalf("delete: expected 200, got %d", code)
+	}
+	if code := call(t, router, http.MethodGet, path, nil, nil); code != http.StatusNotFound {
+		t.Errorf("get after delete: expected 404, got %d", code)
+	}
+}
+
+func TestSessionWithTask(t *testing.T) {
+	router := newMemoryServer(t)
+
+	// The template and the ttl come from the task
+	var created models.CreateSessionResponse
+	if code := call(t, router, http.MethodPost, "/api/v1/sessions", models.CreateSessionRequest{TaskID: "demo/shop/checkout"}, &created); code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d", code)
+	}
+	if created.TemplateID != "demo/shop" {
+		t.Errorf("expected the task's project template, got %q", created.TemplateID)
+	}
+
+	var session struct {
+		TTLSeconds int               `json:"ttl_seconds"`
+		Metadata   map[string]string `json:"metadata"`
+	}
+	call(t, router, http.MethodGet, "/api/v1/sessions/"+created.ID, nil, &session)
+	if session.TTLSeconds != 3600 || session.Metadata[models.MetadataTaskID] != "demo/shop/checkout" {
+		t.Errorf("expected the task's time limit and task_id metadata, got %+v", session)
+	}
+
+	var joined models.JoinSessionResponse
+	if code := call(t, router, http.MethodGet, "/api/v1/join/"+created.Token, nil, &joined); code != http.StatusOK {
+		t.Fatalf("join: expected 200, got %d", code)
+	}
+	if joined.Task == nil || joined.Task.Title != "Checkout flow" || joined.Task.TimeLimit != 3600 || len(joined.Task.Skills) != 2 {
+		t.Errorf("expected the task on the join response, got %+v", joined.Task)
+	}
+	// The workspace setup stays with the catalog
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/join/"+created.Token, nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if body := rec.Body.String(); strings.Contains(body, "secret") || strings.Contains(body, "npm ci") {
+		t.Errorf("expected no workspace setup on the join response, got %s", body)
+	}
+	var task struct {
+		StarterRepo *models.StarterRepo `json:"starter_repo"`
+	}
+	if call(t, router, http.MethodGet, "/api/v1/catalog/domains/demo/projects/shop/tasks/checkout", nil, &task); task.StarterRepo == nil {
+		t.Error("expected the starter repo on the catalog task")
+	}
+
+	if code := call(t, router, http.MethodPost, "/api/v1/sessions", models.CreateSessionRequest{TaskID: "demo/shop/missing"}, nil); code != http.StatusNotFound {
+		t.Errorf("unknown task: expected 404, got %d", code)
+	}
+	if code := call(t, router, http.MethodPost, "/api/v1/sessions", models.CreateSessionRequest{TemplateID: "demo-shop"}, nil); code != http.StatusBadRequest {
+		t.Errorf("no ttl and no task: expected 400, got %d", code)
+	}
+}
+
+func TestSessionCallbackURL(t *testing.T) {
+	router := newMemoryServer(t)
+
+	bad := models.CreateSessionRequest{TemplateID: "demo-shop", TTL: 3600, CallbackURL: "ftp://ats.example.com/hook"}
+	if code := call(t, router, http.MethodPost, "/api/v1/sessions", bad, nil); code != http.StatusBadRequest {
+		t.Errorf("non-http callback_url: expected 400, got %d", code)
+	}
+
+	req := models.CreateSessionRequest{TemplateID: "demo-shop", TTL: 3600, CallbackURL: "https://ats.example.com/hook"}
+	var created models.CreateSessionResponse
+	if code := call(t, router, http.MethodPost, "/api/v1/sessions", req, &created); code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d", code)
+	}
+	if len(created.CallbackSecret) != 48 {
+		t.Errorf("expected a callback secret at creation, got %q", created.CallbackSecret)
+	}
+
+	var got map[string]any
+	call(t, router, http.MethodGet, "/api/v1/sessions/"+created.ID, nil, &got)
+	if got["callback_url"] != req.CallbackURL || got["callback_secret"] != nil {
+		t.Errorf("expected the callback URL but not the secret, got %v", got)
+	}
+}
+
+func TestBulkCreateSessions(t *testing.T) {
+	router := newMemoryServer(t)
+
+	req := models.BulkCreateSessionsRequest{
+		CreateSessionRequest: models.CreateSessionRequest{TemplateID: "demo-shop", TTL: 3600, Metadata: map[string]string{"cohort": "2026-10"}},
+		Entries: []models.BulkSessionEntry{
+			{Metadata: map[string]string{"candidate": "a"}, UniqueKey: "a"},
+			{Metadata: map[string]string{"candidate": "b", "cohort": "late"}},
+			{},
+		},
+	}
+	var resp models.BulkCreateSessionsResponse
+	if code := call(t, router, http.MethodPost, "/api/v1/sessions/bulk", req, &resp); code != http.StatusOK {
+		t.Fatalf("bulk create: expected 200, got %d", code)
+	}
+	if resp.Total != 3 || resp.Created != 3 || resp.Failed != 0 || len(resp.Results) != 3 {
+		t.Fatalf("expected 3 sessions created, got %+v", resp)
+	}
+
+	wantMetadata := []map[string]string{
+		{"cohort": "2026-10", "candidate": "a"},
+		{"cohort": "late", "candidate": "b"},
+		{"cohort": "2026-10"},
+	}
+	tokens := make(map[string]bool)
+	for i, res := range resp.Results {
+		if res.Index != i || !res.Success || res.Session == nil || res.Session.JoinURL == "" {
+			t.Fatalf("entry %d: expected a session with a join URL, got %+v", i, res)
+		}
+		tokens[res.Session.Token] = true
+
+		var session struct {
+			Metadata map[string]string `json:"metadata"`
+		}
+		call(t, router, http.MethodGet, "/api/v1/sessions/"+res.Session.ID, nil, &session)
+		if !maps.Equal(session.Metadata, wantMetadata[i]) {
+			t.Errorf("entry %d: expected metadata %v, got %v", i, wantMetadata[i], session.Metadata)
+		}
+	}
+	if len(tokens) != 3 {
+		t.Errorf("expected distinct tokens, got %v", tokens)
+	}
+
+	tooMany := req
+	tooMany.Entries = make([]models.BulkSessionEntry, sandbox.DefaultBulkSessionMax+1)
+	if code := call(t, router, http.MethodPost, "/api/v1/sessions/bulk", tooMany, nil); code != http.StatusBadRequest {
+		t.Errorf("over the cap: expected 400, got %d", code)
+	}
+	missing := req
+	missing.TemplateID = "missing"
+	if code := call(t, router, http.MethodPost, "/api/v1/sessions/bulk", missing, nil); code != http.StatusNotFound {
+		t.Errorf("unknown template: expected 404, got %d", code)
+	}
+	empty := req
+	empty.Entries = nil
+	if code := call(t, router, http.MethodPost, "/api/v1/sessions/bulk", empty, nil); code != http.StatusBadRequest {
+		t.Errorf("no entries: expected 400, got %d", code)
+	}
+
+	var list struct {
+		Total int `json:"total"`
+	}
+	call(t, router, http.MethodGet, "/api/v1/sessions", nil, &list)
+	if list.Total != 3 {
+		t.Errorf("expected the failed batches to create nothing, got %d sessions", list.Total)
+	}
+}
+
+func TestListSessionsFilters(t *testing.T) {
+	router := newMemoryServer(t)
+
+	for _, req := range []models.CreateSessionRequest{
+		{TemplateID: "demo-shop", TTL: 3600},
+		{TemplateID: "demo-shop", TTL: 3600},
+		{TaskID: "demo/shop/checkout"},
+	} {
+		if code := call(t, router, http.MethodPost, "/api/v1/sessions", req, nil); code != http.StatusCreated {
+			t.Fatalf("create: expected 201, got %d", code)
+		}
+	}
+
+	var list struct {
+		Sessions []struct {
+			ID string `json:"id"`
+		} `json:"sessions"`
+		Total int `json:"total"`
+	}
+	// The total counts every match, not just the page
+	if code := call(t, router, http.MethodGet, "/api/v1/sessions?template_id=demo-shop&created_by=test&limit=1", nil, &list); code != http.StatusOK {
+		t.Fatalf("list: expected 200, got %d", code)
+	}
+	if len(list.Sessions) != 1 || list.Total != 2 {
+		t.Errorf("expected one of 2 demo-shop sessions, got %d of %d", len(list.Sessions), list.Total)
+	}
+
+	future := url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339))
+	call(t, router, http.MethodGet, "/api/v1/sessions?created_after="+future, nil, &list)
+	if list.Total != 0 {
+		t.Errorf("expected no sessions created in the future, got %d", list.Total)
+	}
+	call(t, router, http.MethodGet, "/api/v1/sessions?created_by=someone-else", nil, &list)
+	if list.Total != 0 {
+		t.Errorf("expected no sessions of another creator, got %d", list.Total)
+	}
+
+	if code := call(t, router, http.MethodGet, "/api/v1/sessions?created_before=yesterday", nil, nil); code != http.StatusBadRequest {
+		t.Errorf("bad timestamp: expected 400, got %d", code)
+	}
+}
+
+func TestActivateSessionConcurrent(t *testing.T) {
+	router := newMemoryServer(t)
+
+	var created struct {
+		ID    string `json:"id"`
+		Token string `json:"token"`
+	}
+	req := models.CreateSessionRequest{TemplateID: "demo-shop", TTL: 3600}
+	if code := call(t, router, http.MethodPost, "/api/v1/sessions", req, &created); code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d", code)
+	}
+
+	// A retrying browser, or the join link open in several tabs
+	const attempts = 50
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for range attempts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if code := call(t, router, http.MethodPost, "/api/v1/join/"+created.Token+"/activate", nil, nil); code != http.StatusOK {
+				t.Errorf("activate: expected 200, got %d", code)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	// Wait for provisioning to settle; without Docker the sandbox fails
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var got struct {
+			Status string `json:"status"`
+		}
+		call(t, router, http.MethodGet, "/api/v1/sessions/"+created.ID, nil, &got)
+		if got.Status != string(models.SessionProvisioning) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("session still provisioning")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	var list struct {
+		Total int `json:"total"`
+	}
+	if code := call(t, router, http.MethodGet, "/api/v1/sandboxes", nil, &list); code != http.StatusOK || list.Total != 1 {
+		t.Errorf("expected exactly one sandbox for the session, got %d with total %d", code, list.Total)
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	router := newMemoryServer(t)
+
+	var created struct {
+		ID    string `json:"id"`
+		Token string `json:"token"`
+	}
+	req := models.CreateSessionRequest{TemplateID: "demo-shop", TTL: 3600}
+	if code := call(t, router, http.MethodPost, "/api/v1/sessions", req, &created); code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d", code)
+	}
+
+	path := "/api/v1/sessions/" + created.ID
+	var revoked struct {
+		Status    string     `json:"status"`
+		RevokedBy string     `json:"revoked_by"`
+		RevokedAt *time.Time `json:"revoked_at"`
+	}
+	if code := call(t, router, http.MethodPost, path+"/revoke", nil, &revoked); code != http.StatusOK {
+		t.Fatalf("revoke: expected 200, got %d", code)
+	}
+	if revoked.Status != string(models.SessionRevoked) || revoked.RevokedBy == "" || revoked.RevokedAt == nil {
+		t.Errorf("expected a revoked session recording who and when, got %+v", revoked)
+	}
+
+	if code := call(t, router, http.MethodPost, path+"/revoke", nil, nil); code != http.StatusConflict {
+		t.Errorf("revoke twice: expected 409, got %d", code)
+	}
+	if code := call(t, router, http.MethodGet, "/api/v1/join/"+created.Token, nil, nil); code != http.StatusGone {
+		t.Errorf("join: expected 410, got %d", code)
+	}
+	if code := call(t, router, http.MethodPost, "/api/v1/join/"+created.Token+"/activate", nil, nil); code != http.StatusGone {
+		t.Errorf("activate: expected 410, got %d", code)
+	}
+
+	// The record stays, and shows up under its status
+	if code := call(t, router, http.MethodGet, path, nil, nil); code != http.StatusOK {
+		t.Errorf("get after revoke: expected 200, got %d", code)
+	}
+	var list struct {
+		Sessions []json.RawMessage `json:"sessions"`
+	}
+	if code := call(t, router, http.MethodGet, "/api/v1/sessions?status=revoked", nil, &list); code != http.StatusOK || len(list.Sessions) != 1 {
+		t.Errorf("list revoked: expected one session, got %d with %d", code, len(list.Sessions))
+	}
+
+	if code := call(t, router, http.MethodPost, "/api/v1/sessions/missing/revoke", nil, nil); code != http.StatusNotFound {
+		t.Errorf("revoke missing: expected 404, got %d", code)
+	}
+}
+
+func TestSubmitSessionNotActive(t *testing.T) {
+	router := newMemoryServer(t)
+
+	var created struct {
+		ID    string `json:"id"`
+		Token string `json:"token"`
+	}
+	req := models.CreateSessionRequest{TemplateID: "demo-shop", TTL: 3600}
+	if code := call(t, router, http.MethodPost, "/api/v1/sessions", req, &created); code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d", code)
+	}
+
+	if code := call(t, router, http.MethodPost, "/api/v1/join/"+created.Token+"/submit", nil, nil); code != http.StatusConflict {
+		t.Errorf("submit ready: expected 409, got %d", code)
+	}
+	if code := call(t, router, http.MethodPost, "/api/v1/join/missing/submit", nil, nil); code != http.StatusNotFound {
+		t.Errorf("submit missing: expected 404, got %d", code)
+	}
+	if code := call(t, router, http.MethodGet, "/api/v1/sessions/"+created.ID+"/submission", nil, nil); code != http.StatusNotFound {
+		t.Errorf("download before submitting: expected 404, got %d", code)
+	}
+
+	// Revoked sessions answer 410 like join and activate
+	if code := call(t, router, http.MethodPost, "/api/v1/sessions/"+created.ID+"/revoke", nil, nil); code != http.StatusOK {
+		t.Fatalf("revoke: expected 200, got %d", code)
+	}
+	if code := call(t, router, http.MethodPost, "/api/v1/join/"+created.Token+"/submit", nil, nil); code != http.StatusGone {
+		t.Errorf("submit revoked: expected 410, got %d", code)
+	}
+}
+
+func TestSandboxOwnership(t *testing.T) {
+	router := newMemoryServer(t)
+
+	// Two clients without sandboxes:admin
+	var keys [2]string
+	for i := range keys {
+		var created struct {
+			ApiKey string `json:"api_key"`
+		}
+		req := models.CreateClientRequest{Name: "client", Permissions: []string{"sandboxes:read", "sandboxes:write"}}
+		if code := call(t, router, http.MethodPost, "/api/v1/clients", req, &created); code != http.StatusCreated {
+			t.Fatalf("create client: expected 201, got %d", code)
+		}
+		keys[i] = created.ApiKey
+	}
+	owner, other := keys[0], keys[1]
+
+	var created struct {
+		ID string `json:"id"`
+	}
+	if code := callAs(t, router, owner, http.MethodPost, "/api/v1/sandboxes", models.CreateRequest{TemplateID: "demo-shop", UserID: "user-1"}, &created); code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d", code)
+	}
+	path := "/api/v1/sandboxes/" + created.ID
+
+	if code := callAs(t, router, owner, http.MethodGet, path, nil, nil); code != http.StatusOK {
+		t.Errorf("owner get: expected 200, got %d", code)
+	}
+	if code := call(t, router, http.MethodGet, path, nil, nil); code != http.StatusOK {
+		t.Errorf("admin get: expected 200, got %d", code)
+	}
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		if code := callAs(t, router, other, method, path, nil, nil); code != http.StatusNotFound {
+			t.Errorf("other client %s: expected 404, got %d", method, code)
+		}
+	}
+
+	var list struct {
+		Total int `json:"total"`
+	}
+	if code := callAs(t, router, other, http.MethodGet, "/api/v1/sandboxes", nil, &list); code != http.StatusOK || list.Total != 0 {
+		t.Errorf("other client list: expected no sandboxes, got %d with total %d", code, list.Total)
+	}
+	if code := callAs(t, router, owner, http.MethodGet, "/api/v1/sandboxes", nil, &list); code != http.StatusOK || list.Total != 1 {
+		t.Errorf("owner list: expected one sandbox, got %d with total %d", code, list.Total)
+	}
+
+	// Forcing a delete past deprovision failures is for admins
+	if code := callAs(t, router, owner, http.MethodDelete, path+"?force=true", nil, nil); code != http.StatusForbidden {
+		t.Errorf("owner force delete: expected 403, got %d", code)
+	}
+	if code := callAs(t, router, owner, http.MethodDelete, path+"?force=maybe", nil, nil); code != http.StatusBadRequest {
+		t.Errorf("bad force: expected 400, got %d", code)
+	}
+	if code := call(t, router, http.MethodDelete, path+"?force=true", nil, nil); code != http.StatusOK {
+		t.Errorf("admin force delete: expected 200, got %d", code)
+	}
+}
+
+func TestJWTSandboxOwnership(t *testing.T) {
+	router, _ := newMemoryServerConfig(t, config.ServerConfig{
+		AuthCacheTTL: time.Minute,
+		JWT:          config.JWTConfig{Issuer: "gateway", Audience: "sandbox-engine", HMACSecret: testJWTSecret},
+	})
+	token := func(subject string) string {
+		claims := gatewayClaims("sandboxes:read sandboxes:write")
+		claims.Subject = subject
+		return signHS256(t, claims)
+	}
+	owner, other := token("user-42"), token("user-43")
+
+	var created struct {
+		ID string `json:"id"`
+	}
+	if code := callAs(t, router, owner, http.MethodPost, "/api/v1/sandboxes", models.CreateRequest{TemplateID: "demo-shop", UserID: "user-1"}, &created); code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d", code)
+	}
+	path := "/api/v1/sandboxes/" + created.ID
+
+	if code := callAs(t, router, owner, http.MethodGet, path, nil, nil); code != http.StatusOK {
+		t.Errorf("owner get: expected 200, got %d", code)
+	}
+	if code := callAs(t, router, other, http.MethodGet, path, nil, nil); code != http.StatusNotFound {
+		t.Errorf("other subject get: expected 404, got %d", code)
+	}
+	var list struct {
+		Total int `json:"total"`
+	}
+	if code := callAs(t, router, owner, http.MethodGet, "/api/v1/sandboxes", nil, &list); code != http.StatusOK || list.Total != 1 {
+		t.Errorf("owner list: expected one sandbox, got %d with total %d", code, list.Total)
+	}
+	if code := callAs(t, router, owner, http.MethodDelete, path, nil, nil); code != http.StatusOK {
+		t.Errorf("owner delete: expected 200, got %d", code)
+	}
+}
+
+// waitForFailed polls a sandbox until provisioning has failed and rolled back
+func waitForFailed(t *testing.T, router http.Handler, apiKey, id string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var got struct {
+			Status string `json:"status"`
+		}
+		if code := callAs(t, router, apiKey, http.MethodGet, "/api/v1/sandboxes/"+id, nil, &got); code != http.StatusOK {
+			t.Fatalf("get: expected 200, got %d", code)
+		}
+		if got.Status == string(models.StatusFailed) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the sandbox to fail provisioning, still %s", got.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBulkDeleteSandboxes(t *testing.T) {
+	router := newMemoryServer(t)
+
+	var client struct {
+		ApiKey string `json:"api_key"`
+	}
+	req := models.CreateClientRequest{Name: "client", Permissions: []string{"sandboxes:read", "sandboxes:write"}}
+	if code := call(t, router, http.MethodPost, "/api/v1/clients", req, &client); code != http.StatusCreated {
+		t.Fatalf("create client: expected 201, got %d", code)
+	}
+
+	create := func(apiKey, userID string) string {
+		var created struct {
+			ID string `json:"id"`
+		}
+		if code := callAs(t, router, apiKey, http.MethodPost, "/api/v1/sandboxes", models.CreateRequest{TemplateID: "demo-shop", UserID: userID}, &created); code != http.StatusCreated {
+			t.Fatalf("create: expected 201, got %d", code)
+		}
+		waitForFailed(t, router, apiKey, created.ID)
+		return created.ID
+	}
+	mine, theirs, other := create(client.ApiKey, "user-1"), create(memoryTestKey, "user-1"), create(memoryTestKey, "user-2")
+
+	type result struct {
+		SandboxID string `json:"sandbox_id"`
+		Success   bool   `json:"success"`
+		Code      string `json:"code"`
+	}
+	var resp struct {
+		Results []result `json:"results"`
+		Total   int      `json:"total"`
+		Deleted int      `json:"deleted"`
+		Failed  int      `json:"failed"`
+	}
+
+	// Without sandboxes:admin, foreign and missing IDs look the same
+	body := models.BulkDeleteRequest{IDs: []string{mine, theirs, "missing", mine}}
+	if code := callAs(t, router, client.ApiKey, http.MethodPost, "/api/v1/sandboxes/bulk-delete", body, &resp); code != http.StatusOK {
+		t.Fatalf("bulk delete by ids: expected 200, got %d", code)
+	}
+	want := []result{{SandboxID: mine, Success: true}, {SandboxID: theirs, Code: "not_found"}, {SandboxID: "missing", Code: "not_found"}}
+	if len(resp.Results) != len(want) || resp.Total != 3 || resp.Deleted != 1 || resp.Failed != 2 {
+		t.Fatalf("unexpected bulk delete response: %+v", resp)
+	}
+	for i := range want {
+		if resp.Results[i] != want[i] {
+			t.Errorf("result %d: expected %+v, got %+v", i, want[i], resp.Results[i])
+		}
+	}
+	if code := call(t, router, http.MethodGet, "/api/v1/sandboxes/"+theirs, nil, nil); code != http.StatusOK {
+		t.Errorf("foreign sandbox: expected it to survive, got %d", code)
+	}
+
+	// Filters reach every owner with sandboxes:admin
+	resp.Results = nil
+	if code := call(t, router, http.MethodPost, "/api/v1/sandboxes/bulk-delete", models.BulkDeleteRequest{UserID: "user-1"}, &resp); code != http.StatusOK {
+		t.Fatalf("bulk delete by filter: expected 200, got %d", code)
+	}
+	if len(resp.Results) != 1 || resp.Results[0].SandboxID != theirs || !resp.Results[0].Success {
+		t.Errorf("expected only the remaining user-1 sandbox to be deleted, got %+v", resp.Results)
+	}
+	if code := call(t, router, http.MethodGet, "/api/v1/sandboxes/"+other, nil, nil); code != http.StatusOK {
+		t.Errorf("unmatched sandbox: expected it to survive, got %d", code)
+	}
+
+	ids := make([]string, sandbox.DefaultBulkDeleteMax+1)
+	for i := range ids {
+		ids[i] = strconv.Itoa(i)
+	}
+	for name, body := range map[string]models.BulkDeleteRequest{
+		"empty":    {},
+		"both":     {IDs: []string{other}, UserID: "user-2"},
+		"blank id": {IDs: []string{""}},
+		"too many": {IDs: ids},
+	} {
+		if code := call(t, router, http.MethodPost, "/api/v1/sandboxes/bulk-delete", body, nil); code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", name, code)
+		}
+	}
+}
+
+func TestHealthDetails(t *testing.T) {
+	router := newMemoryServer(t)
+
+	var health struct {
+		Status     string                             `json:"status"`
+		Components map[string]*models.ComponentHealth `json:"components"`
+	}
+	if code := call(t, router, http.MethodGet, "/health/details", nil, &health); code != http.StatusOK {
+		t.Fatalf("expected 200 while degraded, got %d", code)
+	}
+
+	// Docker is unreachable, the in-memory repository is always up
+	if health.Status != "degraded" {
+		t.Errorf("expected degraded, got %s", health.Status)
+	}
+	if c := health.Components["docker"]; c == nil || c.Status != models.HealthDown || c.Error == "" {
+		t.Errorf("expected docker down with an error, got %+v", c)
+	}
+	if c := health.Components["repository"]; c == nil || c.Status != models.HealthUp {
+		t.Errorf("expected the repository up, got %+v", c)
+	}
+}
+
+func TestMetricsRequirePermission(t *testing.T) {
+	repo := storage.NewMemoryRepository()
+	repo.AddClient(&models.ApiClient{Name: "prometheus", ApiKey: "sk_test_prometheus", IsActive: true, Permissions: []string{"metrics:read"}})
+	repo.AddClient(&models.ApiClient{Name: "ats", ApiKey: "sk_test_ats", IsActive: true, Permissions: []string{"sessions:*"}})
+	m, err := metrics.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := NewServer(config.ServerConfig{}, &tokenManager{}, templates.NewLoader(), repo, m, nil).Router()
+
+	for _, tc := range []struct {
+		key  string
+		want int
+	}{
+		{"", http.StatusUnauthorized},
+		{"sk_test_ats", http.StatusForbidden},
+		{"sk_test_prometheus", http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if tc.key != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.key)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("key %q: expected %d, got %d", tc.key, tc.want, rec.Code)
+		}
+	}
+}
+
+func TestVersion(t *testing.T) {
+	router := newMemoryServer(t)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 without credentials, got %d", rec.Code)
+	}
+	var body struct {
+		Data versionDTO `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Data.Version == "" || body.Data.GoVersion == "" || body.Data.Services == nil {
+		t.Errorf("unexpected version %+v", body.Data)
+	}
+
+	// Every response names the build, errors included
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/sandboxes", nil))
+	if got := rec.Header().Get(versionHeaderName); got != version.String() {
+		t.Errorf("expected %s %q, got %q", versionHeaderName, version.String(), got)
+	}
+}
+
+func TestListRecordingsAfterCleanup(t *testing.T) {
+	router, repo := newMemoryServerRepo(t)
+	repo.AddClient(&models.ApiClient{Name: "reviewer", ApiKey: "sk_test_reviewer", IsActive: true, Permissions: []string{"recordings:read"}})
+	reviewer, err := repo.GetClientByApiKey(context.Background(), "sk_test_reviewer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The sandbox is long gone; one recording is the reviewer's, one isn't
+	for _, owner := range []int{reviewer.ID, reviewer.ID + 100} {
+		rec := &models.TerminalRecording{SandboxID: "gone", OwnerClientID: owner, StartedAt: time.Now()}
+		if err := repo.CreateTerminalRecording(context.Background(), rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if code := call(t, router, http.MethodGet, "/api/v1/sandboxes/gone/recordings", nil, nil); code != http.StatusForbidden {
+		t.Errorf("without recordings:read: expected 403, got %d", code)
+	}
+
+	var list struct {
+		Recordings []*models.TerminalRecording `json:"recordings"`
+		Total      int                         `json:"total"`
+	}
+	if code := callAs(t, router, "sk_test_reviewer", http.MethodGet, "/api/v1/sandboxes/gone/recordings", nil, &list); code != http.StatusOK {
+		t.Fatalf("list: expected 200, got %d", code)
+	}
+	if list.Total != 1 || len(list.Recordings) != 1 || list.Recordings[0].ID != 1 {
+		t.Errorf("expected the reviewer's recording only, got %+v", list)
+	}
+
+	if code := callAs(t, router, "sk_test_reviewer", http.MethodGet, "/api/v1/sandboxes/gone/recordings/2", nil, nil); code != http.StatusNotFound {
+		t.Errorf("foreign recording: expected 404, got %d", code)
+	}
+}
+
+func TestTerminalPermission(t *testing.T) {
+	router, repo := newMemoryServerRepo(t)
+	repo.AddClient(&models.ApiClient{Name: "ops", ApiKey: "sk_test_ops", IsActive: true, Permissions: []string{"sandboxes:read", "sandboxes:write"}})
+	repo.AddClient(&models.ApiClient{Name: "dev", ApiKey: "sk_test_dev", IsActive: true, Permissions: []string{"sandboxes:terminal"}})
+	dev, err := repo.GetClientByApiKey(context.Background(), "sk_test_dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sb := &models.Sandbox{ID: "sb-other", TemplateID: "t", Status: models.StatusRunning, OwnerClientID: dev.ID + 100, CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+	if err := repo.CreateSandbox(context.Background(), sb); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reading and writing sandboxes doesn't grant a shell
+	if code := callAs(t, router, "sk_test_ops", http.MethodGet, "/api/v1/ws/terminal/sb-other", nil, nil); code != http.StatusForbidden {
+		t.Errorf("without sandboxes:terminal: expected 403, got %d", code)
+	}
+	// The permission only reaches the client's own sandboxes
+	if code := callAs(t, router, "sk_test_dev", http.MethodGet, "/api/v1/ws/terminal/sb-other", nil, nil); code != http.StatusNotFound {
+		t.Errorf("foreign sandbox: expected 404, got %d", code)
+	}
+	// sandboxes:* includes the permission, and sandboxes:admin reaches any sandbox;
+	// a plain GET then fails the WebSocket upgrade
+	if code := call(t, router, http.MethodGet, "/api/v1/ws/terminal/sb-other", nil, nil); code != http.StatusBadRequest {
+		t.Errorf("with sandboxes:*: expected the upgrade to be attempted (400), got %d", code)
+	}
+}
+
+func TestTemplateWrites(t *testing.T) {
+	router, repo := newMemoryServerRepo(t)
+	doc := map[string]any{"name": "api-python", "base_image": "python:3.12", "ttl": "30m"}
+
+	var created struct {
+		Name string `json:"name"`
+	}
+	if code := call(t, router, http.MethodPost, "/api/v1/templates", doc, &created); code != http.StatusCreated || created.Name != "api-python" {
+		t.Fatalf("create: expected 201, got %d with %+v", code, created)
+	}
+	if code := call(t, router, http.MethodGet, "/api/v1/templates/api-python", nil, nil); code != http.StatusOK {
+		t.Errorf("get: expected the template loaded at once, got %d", code)
+	}
+	if code := call(t, router, http.MethodPost, "/api/v1/templates", doc, nil); code != http.StatusConflict {
+		t.Errorf("create again: expected 409, got %d", code)
+	}
+	if code := call(t, router, http.MethodPost, "/api/v1/templates", map[string]any{"name": "demo-shop", "base_image": "alpine"}, nil); code != http.StatusConflict {
+		t.Errorf("create over a file: expected 409, got %d", code)
+	}
+	if code := call(t, router, http.MethodPost, "/api/v1/templates", map[string]any{"name": "bad/name", "base_image": "alpine"}, nil); code != http.StatusBadRequest {
+		t.Errorf("bad name: expected 400, got %d", code)
+	}
+	if code := call(t, router, http.MethodPost, "/api/v1/templates", map[string]any{"name": "bad-ports", "base_image": "alpine", "expose": []int{99999}}, nil); code != http.StatusBadRequest {
+		t.Errorf("invalid template: expected 400, got %d", code)
+	}
+
+	doc["ttl"] = "2h"
+	if code := call(t, router, http.MethodPut, "/api/v1/templates/api-python", doc, nil); code != http.StatusOK {
+		t.Fatalf("update: expected 200, got %d", code)
+	}
+	if code := call(t, router, http.MethodPut, "/api/v1/templates/other", doc, nil); code != http.StatusNotFound {
+		t.Errorf("update missing: expected 404, got %d", code)
+	}
+	if code := call(t, router, http.MethodPut, "/api/v1/templates/demo-shop", map[string]any{"name": "demo-shop", "base_image": "alpine"}, nil); code != http.StatusConflict {
+		t.Errorf("update a file: expected 409, got %d", code)
+	}
+	doc["name"] = "renamed"
+	if code := call(t, router, http.MethodPut, "/api/v1/templates/api-python", doc, nil); code != http.StatusBadRequest {
+		t.Errorf("rename: expected 400, got %d", code)
+	}
+
+	// A restart loads the stored template again
+	loader := templates.NewLoader()
+	loader.SetDocumentSource(repo)
+	if err := loader.LoadFromDir(filepath.Join("testdata", "catalog")); err != nil {
+		t.Fatal(err)
+	}
+	if tmpl := loader.Get("api-python"); tmpl == nil || tmpl.TTL != 2*time.Hour || !loader.IsStored("api-python") {
+		t.Errorf("expected the updated template after a restart, got %+v", tmpl)
+	}
+
+	sb := &models.Sandbox{ID: "sb-api", TemplateID: "api-python", Status: models.StatusStopped, CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+	if err := repo.CreateSandbox(context.Background(), sb); err != nil {
+		t.Fatal(err)
+	}
+	if code := call(t, router, http.MethodDelete, "/api/v1/templates/api-python", nil, nil); code != http.StatusConflict {
+		t.Errorf("delete in use: expected 409, got %d", code)
+	}
+	sb.Status = models.StatusExpired
+	if err := repo.UpdateSandbox(context.Background(), sb); err != nil {
+		t.Fatal(err)
+	}
+	if code := call(t, router, http.MethodDelete, "/api/v1/templates/api-python", nil, nil); code != http.StatusOK {
+		t.Fatalf("delete: expected 200, got %d", code)
+	}
+	if code := call(t, router, http.MethodGet, "/api/v1/templates/api-python", nil, nil); code != http.StatusNotFound {
+		t.Errorf("get deleted: expected 404, got %d", code)
+	}
+	if code := call(t, router, http.MethodDelete, "/api/v1/templates/demo-shop", nil, nil); code != http.StatusConflict {
+		t.Errorf("delete a file: expected 409, got %d", code)
+	}
+}
+
+func TestTemplatePresentation(t *testing.T) {
+	router := newMemoryServer(t)
+	for _, doc := range []map[string]any{
+		{"name": "api-python", "base_image": "python:3.12", "tags": []string{"backend", "Python"}, "language": "python", "icon": "python"},
+		{"name": "api-legacy", "base_image": "python:2.7", "tags": []string{"backend"}, "language": "python", "enabled": false},
+	} {
+		if code := call(t, router, http.MethodPost, "/api/v1/templates", doc, nil); code != http.StatusCreated {
+			t.Fatalf("create %s: expected 201, got %d", doc["name"], code)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"?tag=python", []string{"api-python"}},
+		{"?tag=backend&language=Python", []string{"api-python"}},
+		{"?language=go", nil},
+	}
+	for _, tt := range tests {
+		var list struct {
+			Templates []struct {
+				Name string `json:"name"`
+			} `json:"templates"`
+		}
+		if code := call(t, router, http.MethodGet, "/api/v1/templates"+tt.query, nil, &list); code != http.StatusOK {
+			t.Fatalf("list%s: expected 200, got %d", tt.query, code)
+		}
+		var names []string
+		for _, tmpl := range list.Templates {
+			names = append(names, tmpl.Name)
+		}
+		slices.Sort(names)
+		if !slices.Equal(names, tt.want) {
+			t.Errorf("list%s: expected %v, got %v", tt.query, tt.want, names)
+		}
+	}
+
+	var all struct {
+		Templates []struct {
+			Name string `json:"name"`
+		} `json:"templates"`
+	}
+	call(t, router, http.MethodGet, "/api/v1/templates", nil, &all)
+	for _, tmpl := range all.Templates {
+		if tmpl.Name == "api-legacy" {
+			t.Error("list: expected the disabled template left out")
+		}
+	}
+
+	// A disabled template still resolves, but starts nothing new
+	var legacy struct {
+		Enabled bool `json:"enabled"`
+	}
+	if code := call(t, router, http.MethodGet, "/api/v1/templates/api-legacy", nil, &legacy); code != http.StatusOK || legacy.Enabled {
+		t.Errorf("get disabled: expected 200 and enabled false, got %d with %+v", code, legacy)
+	}
+	if code := call(t, router, http.MethodPost, "/api/v1/sessions", models.CreateSessionRequest{TemplateID: "api-legacy", TTL: 3600}, nil); code != http.StatusConflict {
+		t.Errorf("session from a disabled template: expected 409, got %d", code)
+	}
+	if code := call(t, router, http.MethodPost, "/api/v1/sandboxes", models.CreateRequest{TemplateID: "api-legacy", UserID: "u-1"}, nil); code != http.StatusConflict {
+		t.Errorf("sandbox from a disabled template: expected 409, got %d", code)
+	}
+
+	var session struct {
+		Token string `json:"token"`
+	}
+	if code := call(t, router, http.MethodPost, "/api/v1/sessions", models.CreateSessionRequest{TemplateID: "api-python", TTL: 3600}, &session); code != http.StatusCreated {
+		t.Fatalf("create session: expected 201, got %d", code)
+	}
+	var joined models.JoinSessionResponse
+	if code := call(t, router, http.MethodGet, "/api/v1/join/"+session.Token, nil, &joined); code != http.StatusOK || joined.Template == nil ||
+		joined.Template.Language != "python" || !slices.Equal(joined.Template.Tags, []string{"backend", "Python"}) {
+		t.Errorf("join: expected the template language and tags, got %d with %+v", code, joined.Template)
+	}
+}
+
+func TestGradingPermissions(t *testing.T) {
+	router, repo := newMemoryServerRepo(t)
+
+	var session struct {
+		ID    string `json:"id"`
+		Token string `json:"token"`
+	}
+	if code := call(t, router, http.MethodPost, "/api/v1/sessions", models.CreateSessionRequest{TemplateID: "demo-shop", TTL: 3600}, &session); code != http.StatusCreated {
+		t.Fatalf("create session: expected 201, got %d", code)
+	}
+	// A result of a sandbox already cleaned up
+	if err := repo.CreateGradingResult(context.Background(), &models.GradingResult{SandboxID: "sb-gone", SessionID: session.ID, TaskID: "demo/shop/cart", Passed: true}); err != nil {
+		t.Fatal(err)
+	}
+	path := "/api/v1/sandboxes/sb-gone/grade"
+
+	var list struct {
+		Results []*models.GradingResult `json:"results"`
+	}
+	if code := call(t, router, http.MethodGet, path, nil, &list); code != http.StatusOK || len(list.Results) != 1 || !list.Results[0].Passed {
+		t.Errorf("admin list: expected the stored result, got %d with %+v", code, list.Results)
+	}
+
+	var client struct {
+		ApiKey string `json:"api_key"`
+	}
+	req := models.CreateClientRequest{Name: "ci", Permissions: []string{"sandboxes:read", "sandboxes:write"}}
+	if code := call(t, router, http.MethodPost, "/api/v1/clients", req, &client); code != http.StatusCreated {
+		t.Fatalf("create client: expected 201, got %d", code)
+	}
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		if code := callAs(t, router, client.ApiKey, method, path, nil, nil); code != http.StatusForbidden {
+			t.Errorf("%s without sandboxes:grade: expected 403, got %d", method, code)
+		}
+		if code := callAs(t, router, session.Token, method, path, nil, nil); code != http.StatusUnauthorized {
+			t.Errorf("%s with a session token: expected 401, got %d", method, code)
+		}
+	}
+}
+
+func TestCatalogExportEndpoint(t *testing.T) {
+	router, repo := newMemoryServerRepo(t)
+	repo.AddClient(&models.ApiClient{Name: "ops", ApiKey: "sk_test_ops", IsActive: true, Permissions: []string{"admin:read"}})
+
+	// The archive carries grading and setup, so templates:read isn't enough
+	if code := call(t, router, http.MethodGet, "/api/v1/catalog/export", nil, nil); code != http.StatusForbidden {
+		t.Errorf("export without admin:read: expected 403, got %d", code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/catalog/export", nil)
+	req.Header.Set("Authorization", "Bearer sk_test_ops")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/gzip" {
+		t.Fatalf("export: expected 200 and a tar.gz, got %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+	if want := []string{"demo/domain.yaml", "demo/shop/tasks/checkout.yaml", "demo/shop/template.yaml"}; !slices.Equal(names, want) {
+		t.Errorf("expected %v exported, got %v", want, names)
+	}
+
+	// Importing replaces the catalog, so it takes admin:write
+	if code := call(t, router, http.MethodPost, "/api/v1/catalog/import", nil, nil); code != http.StatusForbidden {
+		t.Errorf("import without admin:write: expected 403, got %d", code)
+	}
+}
@@ -5,24 +5,82 @@ import (
 	"encoding/json"
 	"log/slog"
 	"net/http"
+	"net/netip"
 	"strings"
+	"time"
 
+	"github.com/terra-clan/sandbox-engine/internal/config"
+	"github.com/terra-clan/sandbox-engine/internal/models"
 	"github.com/terra-clan/sandbox-engine/internal/storage"
 )
 
 // AuthMiddleware handles API key authentication
 type AuthMiddleware struct {
-	repo storage.Repository
+	repo  storage.Repository
+	cache *clientCache
+	jwt   *jwtVerifier
+	// jwtClients caches the client IDs of JWT subjects, keyed by subject
+	jwtClients *clientCache
 }
 
-// NewAuthMiddleware creates new auth middleware
-func NewAuthMiddleware(repo storage.Repository) *AuthMiddleware {
-	return &AuthMiddleware{repo: repo}
+// NewAuthMiddleware creates new auth middleware. Client lookups are cached
+// for cacheTTL (0 disables the cache), at most cacheSize keys.
+func NewAuthMiddleware(repo storage.Repository, cacheTTL time.Duration, cacheSize int) *AuthMiddleware {
+	return &AuthMiddleware{
+		repo:       repo,
+		cache:      newClientCache(cacheTTL, cacheSize),
+		jwtClients: newClientCache(cacheTTL, cacheSize),
+	}
+}
+
+// EnableJWT accepts JWT bearer tokens verified with cfg alongside API keys
+func (m *AuthMiddleware) EnableJWT(cfg config.JWTConfig) {
+	m.jwt = newJWTVerifier(cfg)
+}
+
+// FlushCache drops cached client lookups, so a deactivated client or rotated
+// key is rejected on this replica at once rather than after the cache TTL
+func (m *AuthMiddleware) FlushCache() {
+	m.cache.flush()
+}
+
+// lookupClient resolves apiKey through the cache; nil is an unknown key
+func (m *AuthMiddleware) lookupClient(ctx context.Context, apiKey string) (*models.ApiClient, error) {
+	if client, ok := m.cache.get(apiKey); ok {
+		return client, nil
+	}
+	client, err := m.repo.GetClientByApiKey(ctx, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	m.cache.put(apiKey, client)
+	return client, nil
+}
+
+// jwtClientID returns the ID of the client row standing for a JWT subject,
+// so its sandboxes have an owner and its terminals and requests count
+// against a client of its own
+func (m *AuthMiddleware) jwtClientID(ctx context.Context, client *models.ApiClient) (int, error) {
+	subject := client.Metadata["sub"]
+	if cached, ok := m.jwtClients.get(subject); ok {
+		return cached.ID, nil
+	}
+	name := client.Name
+	if len(name) > maxClientNameLength {
+		name = name[:maxClientNameLength]
+	}
+	id, err := m.repo.EnsureJWTClient(ctx, subject, name)
+	if err != nil {
+		return 0, err
+	}
+	m.jwtClients.put(subject, &models.ApiClient{ID: id})
+	return id, nil
 }
 
 // Authenticate verifies API key from Authorization header
 // Supports formats: "Bearer sk_xxx" or "sk_xxx" in Authorization header
 // Also supports X-API-Key header and ?token= query parameter (for WebSocket)
+// With JWT enabled, a token shaped like a JWT is verified as one instead
 func (m *AuthMiddleware) Authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		apiKey := extractAPIKey(r)
@@ -31,8 +89,25 @@ func (m *AuthMiddleware) Authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		if m.jwt != nil && looksLikeJWT(apiKey) {
+			client, err := m.jwt.verify(r.Context(), apiKey)
+			if err != nil {
+				slog.Warn("invalid jwt attempt", "error", err, "remote_addr", r.RemoteAddr)
+				writeAuthError(w, http.StatusUnauthorized, "invalid token", "the provided bearer token is not valid")
+				return
+			}
+			if client.ID, err = m.jwtClientID(r.Context(), client); err != nil {
+				slog.Error("failed to look up jwt client", "error", err, "client", client.Name)
+				writeAuthError(w, http.StatusInternalServerError, "authentication error", "internal server error")
+				return
+			}
+			slog.Debug("authenticated request", "client", client.Name)
+			next.ServeHTTP(w, r.WithContext(ContextWithClient(r.Context(), client)))
+			return
+		}
+
 		// Lookup client by API key
-		client, err := m.repo.GetClientByApiKey(r.Context(), apiKey)
+		client, err := m.lookupClient(r.Context(), apiKey)
 		if err != nil {
 			slog.Error("failed to lookup api client", "error", err, "key_prefix", maskKey(apiKey))
 			writeAuthError(w, http.StatusInternalServerError, "authentication error", "internal server error")
@@ -51,14 +126,34 @@ func (m *AuthMiddleware) Authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		// Update last_used_at asynchronously (don't block request)
-		go func() {
-			ctx, cancel := context.WithTimeout(context.Background(), 5000000000) // 5 seconds
-			defer cancel()
-			if err := m.repo.UpdateClientLastUsed(ctx, apiKey); err != nil {
-				slog.Error("failed to update client last_used_at", "error", err, "client", client.Name)
+		if client.Expired(time.Now()) {
+			slog.Warn("expired api key attempt", "client", client.Name, "key_prefix", maskKey(apiKey))
+			writeAuthError(w, http.StatusUnauthorized, "key expired", "this api key has expired")
+			return
+		}
+
+		if len(client.AllowedCIDRs) > 0 {
+			// realIP has replaced RemoteAddr with the forwarded client address
+			// only when the request came through a trusted proxy
+			addr, err := netip.ParseAddr(clientIP(r))
+			if err != nil || !client.AllowsAddr(addr) {
+				slog.Warn("api key used from a disallowed address", "client", client.Name, "remote_addr", r.RemoteAddr)
+				writeAuthError(w, http.StatusForbidden, "ip not allowed", "this api key is not allowed from your address")
+				return
 			}
-		}()
+		}
+
+		// Update last_used_at asynchronously (don't block request), at most
+		// once per lastUsedInterval per key
+		if m.cache.shouldRecordUse(apiKey) {
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 5000000000) // 5 seconds
+				defer cancel()
+				if err := m.repo.UpdateClientLastUsed(ctx, apiKey); err != nil {
+					slog.Error("failed to update client last_used_at", "error", err, "client", client.Name)
+				}
+			}()
+		}
 
 		slog.Debug("authenticated request", "client", client.Name, "key_prefix", client.MaskedApiKey())
 
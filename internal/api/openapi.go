@@ -0,0 +1,1131 @@
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/templates"
+)
+
+// The OpenAPI document is built from the route table below, with the request
+// and response schemas generated from the Go types the handlers decode and
+// encode. Schemas describe the v1 style; the legacy style isn't documented.
+// A route added to setupRouter must be added here too (see TestOpenAPICoversRoutes).
+
+type openAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       openAPIInfo                             `json:"info"`
+	Tags       []openAPITag                            `json:"tags"`
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components openAPIComponents                       `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description"`
+}
+
+type openAPITag struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type openAPIComponents struct {
+	Schemas         map[string]*jsonSchema           `json:"schemas"`
+	SecuritySchemes map[string]openAPISecurityScheme `json:"securitySchemes"`
+}
+
+type openAPISecurityScheme struct {
+	Type        string `json:"type"`
+	Scheme      string `json:"scheme,omitempty"`
+	In          string `json:"in,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description"`
+}
+
+type openAPIOperation struct {
+	OperationID string                      `json:"operationId"`
+	Summary     string                      `json:"summary"`
+	Description string                      `json:"description,omitempty"`
+	Tags        []string                    `json:"tags"`
+	Parameters  []openAPIParameter          `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*openAPIResponse `json:"responses"`
+	Security    []map[string][]string       `json:"security,omitempty"`
+	Permission  string                      `json:"x-required-permission,omitempty"`
+
+	auth   authMode // who may call the operation; filled into Security
+	errors []int    // error statuses beyond the ones every operation of auth can return
+}
+
+type openAPIParameter struct {
+	Name        string      `json:"name"`
+	In          string      `json:"in"`
+	Description string      `json:"description,omitempty"`
+	Required    bool        `json:"required,omitempty"`
+	Schema      *jsonSchema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Schema *jsonSchema `json:"schema"`
+}
+
+// jsonSchema is the subset of JSON Schema the document uses
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref,omitempty"`
+	Type                 any                    `json:"type,omitempty"` // a type name, or a list of them
+	Format               string                 `json:"format,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties any                    `json:"additionalProperties,omitempty"` // false or a schema
+	Items                *jsonSchema            `json:"items,omitempty"`
+	AnyOf                []*jsonSchema          `json:"anyOf,omitempty"`
+	Enum                 []any                  `json:"enum,omitempty"`
+}
+
+// authMode is how an operation authenticates
+type authMode int
+
+const (
+	authAPIKey  authMode = iota // API key (header or ?token=) or JWT
+	authPublic                  // nothing, or a token in the path
+	authSession                 // ?session_token=
+	authEither                  // API key, JWT or ?session_token=
+)
+
+const schemaRefPrefix = "#/components/schemas/"
+
+var (
+	timeType     = reflect.TypeFor[time.Time]()
+	durationType = reflect.TypeFor[time.Duration]()
+)
+
+// schemaGenerator derives component schemas from Go types, following the
+// encoding/json rules for field names, omitempty and embedding
+type schemaGenerator struct {
+	schemas map[string]*jsonSchema
+	names   map[reflect.Type]string
+}
+
+func newSchemaGenerator() *schemaGenerator {
+	return &schemaGenerator{
+		schemas: make(map[string]*jsonSchema),
+		names:   make(map[reflect.Type]string),
+	}
+}
+
+// schemaFor returns the schema of T, or of what T points to
+func schemaFor[T any](g *schemaGenerator) *jsonSchema {
+	t := reflect.TypeFor[T]()
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return g.of(t)
+}
+
+func (g *schemaGenerator) of(t reflect.Type) *jsonSchema {
+	switch t {
+	case timeType:
+		return &jsonSchema{Type: "string", Format: "date-time"}
+	case durationType:
+		return &jsonSchema{Type: "integer", Description: "Duration in nanoseconds"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return nullable(g.of(t.Elem()))
+	case reflect.String:
+		return &jsonSchema{Type: "string"}
+	case reflect.Bool:
+		return &jsonSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &jsonSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &jsonSchema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &jsonSchema{Type: "string", Format: "byte"}
+		}
+		return &jsonSchema{Type: "array", Items: g.of(derefType(t.Elem()))}
+	case reflect.Map:
+		return &jsonSchema{Type: "object", AdditionalProperties: g.of(derefType(t.Elem()))}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		return g.ref(t)
+	}
+	// interface{} and anything else JSON can hold
+	return &jsonSchema{}
+}
+
+// ref registers t as a component schema and returns a reference to it
+func (g *schemaGenerator) ref(t reflect.Type) *jsonSchema {
+	name, ok := g.names[t]
+	if !ok {
+		name = schemaName(t)
+		if _, taken := g.schemas[name]; taken {
+			pkg := t.PkgPath()
+			name = exportName(pkg[strings.LastIndex(pkg, "/")+1:]) + name
+		}
+		g.names[t] = name
+		g.schemas[name] = nil // reserved while the fields are generated
+		g.schemas[name] = g.object(t)
+	}
+	return &jsonSchema{Ref: schemaRefPrefix + name}
+}
+
+// object generates the schema of a struct. Fields without omitempty are
+// required, and unknown fields are rejected so the schema can't fall behind.
+func (g *schemaGenerator) object(t reflect.Type) *jsonSchema {
+	s := &jsonSchema{Type: "object", Properties: make(map[string]*jsonSchema), AdditionalProperties: false}
+	g.addFields(s, t)
+	return s
+}
+
+func (g *schemaGenerator) addFields(s *jsonSchema, t reflect.Type) {
+	var embedded []reflect.Type
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" && opts == "" {
+			continue
+		}
+		if f.Anonymous && name == "" {
+			if ft := derefType(f.Type); ft.Kind() == reflect.Struct {
+				embedded = append(embedded, ft)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if _, ok := s.Properties[name]; ok {
+			continue
+		}
+
+		omitempty := strings.Contains(","+opts+",", ",omitempty,")
+		s.Properties[name] = g.field(f.Type, omitempty)
+		if !omitempty {
+			s.Required = append(s.Required, name)
+		}
+	}
+
+	// Promoted fields lose to the outer struct's fields of the same name
+	for _, et := range embedded {
+		g.addFields(s, et)
+	}
+}
+
+// field is the schema of a struct field. An omitempty field is left out
+// instead of null; slices and maps without it are null when nil.
+func (g *schemaGenerator) field(t reflect.Type, omitempty bool) *jsonSchema {
+	if omitempty {
+		return g.of(derefType(t))
+	}
+	s := g.of(t)
+	if k := t.Kind(); (k == reflect.Slice && t.Elem().Kind() != reflect.Uint8) || k == reflect.Map {
+		s = nullable(s)
+	}
+	return s
+}
+
+func derefType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
+
+// nullable allows s or null
+func nullable(s *jsonSchema) *jsonSchema {
+	switch typ := s.Type.(type) {
+	case string:
+		n := *s
+		n.Type = []string{typ, "null"}
+		return &n
+	case nil:
+		if s.Ref == "" {
+			return s // already anything
+		}
+	default:
+		return s
+	}
+	return &jsonSchema{AnyOf: []*jsonSchema{s, {Type: "null"}}}
+}
+
+// schemaName names the component of t: sandboxDTO is Sandbox, models.Session is Session
+func schemaName(t reflect.Type) string {
+	return exportName(strings.TrimSuffix(t.Name(), "DTO"))
+}
+
+func exportName(s string) string {
+	if s == "" {
+		return s
+	}
+	r := []rune(s)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
+
+// Schema helpers for the route table
+
+func stringSchema() *jsonSchema  { return &jsonSchema{Type: "string"} }
+func integerSchema() *jsonSchema { return &jsonSchema{Type: "integer"} }
+func booleanSchema() *jsonSchema { return &jsonSchema{Type: "boolean"} }
+
+func arrayOf(items *jsonSchema) *jsonSchema {
+	return &jsonSchema{Type: "array", Items: items}
+}
+
+// objectOf is a closed object whose properties are all required
+func objectOf(props map[string]*jsonSchema) *jsonSchema {
+	s := &jsonSchema{Type: "object", Properties: props, AdditionalProperties: false}
+	for name := range props {
+		s.Required = append(s.Required, name)
+	}
+	sort.Strings(s.Required)
+	return s
+}
+
+// listOf is the shape of list responses: the items under key plus their total
+func listOf(key string, items *jsonSchema) *jsonSchema {
+	return objectOf(map[string]*jsonSchema{key: arrayOf(items), "total": integerSchema()})
+}
+
+// messageSchema is the {"message": ...} body of actions without a resource to return
+func messageSchema() *jsonSchema {
+	return objectOf(map[string]*jsonSchema{"message": stringSchema()})
+}
+
+// envelope wraps data in the success envelope written by respondJSON
+func envelope(data *jsonSchema) *jsonSchema {
+	return objectOf(map[string]*jsonSchema{"success": booleanSchema(), "data": data})
+}
+
+func dataResponse(description string, data *jsonSchema) *openAPIResponse {
+	return &openAPIResponse{
+		Description: description,
+		Content:     map[string]openAPIMediaType{"application/json": {Schema: envelope(data)}},
+	}
+}
+
+func jsonBody(schema *jsonSchema, required bool) *openAPIRequestBody {
+	return &openAPIRequestBody{
+		Required: required,
+		Content:  map[string]openAPIMediaType{"application/json": {Schema: schema}},
+	}
+}
+
+func queryParam(name, description string, schema *jsonSchema) openAPIParameter {
+	return openAPIParameter{Name: name, In: "query", Description: description, Schema: schema}
+}
+
+// webSocketResponses are the responses of a WebSocket upgrade
+func webSocketResponses(description string) map[string]*openAPIResponse {
+	return map[string]*openAPIResponse{
+		"101": {Description: description},
+	}
+}
+
+// errorDescriptions describe the error statuses the handlers return
+var errorDescriptions = map[int]string{
+	http.StatusBadRequest:            "Invalid request",
+	http.StatusUnauthorized:          "Missing, invalid or expired credentials",
+	http.StatusForbidden:             "Permission denied or address not allowed",
+	http.StatusNotFound:              "Not found",
+	http.StatusConflict:              "Conflicts with the current state",
+	http.StatusLengthRequired:        "Content-Length required",
+	http.StatusRequestEntityTooLarge: "Request body too large",
+	http.StatusUnprocessableEntity:   "Exceeds a limit; the error says the allowed maximum",
+	http.StatusTooManyRequests:       "Rate limited; see Retry-After",
+	http.StatusInternalServerError:   "Internal error",
+	http.StatusNotImplemented:        "Not supported",
+	http.StatusServiceUnavailable:    "Temporarily unavailable; retry later",
+	http.StatusGatewayTimeout:        "Timed out",
+}
+
+// pathParamDescriptions describe the path parameters by name
+var pathParamDescriptions = map[string]string{
+	"token":       "Session token",
+	"name":        "Name",
+	"domainId":    "Catalog domain ID",
+	"projectName": "Project name within the domain",
+	"taskCode":    "Task code within the project",
+}
+
+// openAPISpec is the document served at /api/v1/openapi.json, encoded once
+var openAPISpec = sync.OnceValues(func() ([]byte, error) {
+	return json.Marshal(buildOpenAPI(newSchemaGenerator()))
+})
+
+// openAPIBuilder collects the operations of the document
+type openAPIBuilder struct {
+	g   *schemaGenerator
+	doc *openAPIDocument
+}
+
+// add registers op, filling in its path parameters, security and the error
+// responses shared by every operation
+func (b *openAPIBuilder) add(method, path string, op *openAPIOperation) {
+	var params []openAPIParameter
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		name, ok := strings.CutPrefix(seg, "{")
+		if !ok {
+			continue
+		}
+		name = strings.TrimSuffix(name, "}")
+		p := openAPIParameter{Name: name, In: "path", Required: true, Schema: stringSchema(), Description: pathParamDescriptions[name]}
+		if name == "id" {
+			// {id} is named after the collection before it
+			switch segments[i-1] {
+			case "clients":
+				p.Description, p.Schema = "API client ID", integerSchema()
+			case "sessions":
+				p.Description = "Session ID"
+			default:
+				p.Description = "Sandbox ID"
+			}
+		}
+		params = append(params, p)
+	}
+	op.Parameters = append(params, op.Parameters...)
+
+	errs := append([]int{http.StatusInternalServerError}, op.errors...)
+	switch op.auth {
+	case authAPIKey:
+		op.Security = []map[string][]string{{"bearerAuth": {}}, {"apiKeyHeader": {}}, {"apiKeyQuery": {}}}
+		errs = append(errs, http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests)
+	case authSession:
+		op.Security = []map[string][]string{{"sessionToken": {}}}
+		errs = append(errs, http.StatusUnauthorized, http.StatusTooManyRequests)
+	case authEither:
+		op.Security = []map[string][]string{{"bearerAuth": {}}, {"apiKeyHeader": {}}, {"apiKeyQuery": {}}, {"sessionToken": {}}}
+		errs = append(errs, http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests)
+	}
+	_, webSocket := op.Responses["101"]
+	for _, status := range errs {
+		key := strconv.Itoa(status)
+		if _, ok := op.Responses[key]; ok {
+			continue
+		}
+		content := map[string]openAPIMediaType{"application/json": {Schema: &jsonSchema{Ref: schemaRefPrefix + "ErrorResponse"}}}
+		if webSocket && slices.Contains(op.errors, status) {
+			// WebSocket handlers reject the upgrade in plain text
+			content = map[string]openAPIMediaType{"text/plain": {Schema: stringSchema()}}
+		}
+		op.Responses[key] = &openAPIResponse{Description: errorDescriptions[status], Content: content}
+	}
+
+	item := b.doc.Paths[path]
+	if item == nil {
+		item = make(map[string]*openAPIOperation)
+		b.doc.Paths[path] = item
+	}
+	item[strings.ToLower(method)] = op
+}
+
+// buildOpenAPI assembles the document from the route table, generating its
+// schemas with g
+func buildOpenAPI(g *schemaGenerator) *openAPIDocument {
+	b := &openAPIBuilder{g: g, doc: &openAPIDocument{
+		OpenAPI: "3.1.0",
+		Info: openAPIInfo{
+			Title:   "Sandbox Engine API",
+			Version: "1.0.0",
+			Description: "Provisions isolated Docker sandboxes from templates, either directly or through lazy sessions a candidate starts from a join link. " +
+				"Responses are wrapped in {\"success\": ..., \"data\": ...}; errors carry {\"error\": {\"code\", \"message\"}}. " +
+				"?api_style=legacy selects the pre-v1 serialization, which this document doesn't describe.",
+		},
+		Tags: []openAPITag{
+			{Name: "health", Description: "Liveness and readiness probes"},
+			{Name: "sandboxes", Description: "Sandboxes, their logs, events and services"},
+			{Name: "sessions", Description: "Lazy sessions and the public join flow"},
+			{Name: "templates", Description: "Sandbox templates"},
+			{Name: "catalog", Description: "Catalog of domains, projects and tasks"},
+			{Name: "admin", Description: "Docker hosts and usage reports"},
+			{Name: "clients", Description: "API clients and their keys"},
+			{Name: "docs", Description: "This document"},
+		},
+		Paths: make(map[string]map[string]*openAPIOperation),
+	}}
+
+	sandboxSchema := schemaFor[sandboxDTO](g)
+	serviceSchema := schemaFor[serviceDTO](g)
+	sessionSchema := schemaFor[sessionDTO](g)
+	clientSchema := schemaFor[clientDTO](g)
+	hostSchema := schemaFor[models.HostInfo](g)
+	templateSchema := schemaFor[templateDTO](g)
+	g.ref(reflect.TypeFor[apiError]())
+	g.schemas["ErrorResponse"] = objectOf(map[string]*jsonSchema{
+		"success": booleanSchema(),
+		"error":   {Ref: schemaRefPrefix + "ApiError"},
+	})
+
+	pagination := []openAPIParameter{
+		queryParam("limit", "Page size (default 50)", integerSchema()),
+		queryParam("offset", "Items to skip", integerSchema()),
+	}
+	// langParam picks the locale of localized titles and descriptions
+	langParam := queryParam("lang", "Locale of localized titles and descriptions, over the Accept-Language header; text missing in it falls back to TEMPLATES_DEFAULT_LOCALE", stringSchema())
+	logParams := []openAPIParameter{
+		queryParam("tail", "Lines from the end of the log (default 100, or every line with since)", integerSchema()),
+		queryParam("since", "Only lines after this RFC 3339 time, or this duration back from now (10m)", stringSchema()),
+		queryParam("timestamps", "Prefix each line with its timestamp", booleanSchema()),
+	}
+
+	// Health
+
+	b.add("GET", "/health", &openAPIOperation{
+		OperationID: "getHealth", Summary: "Liveness probe", Tags: []string{"health"}, auth: authPublic,
+		Responses: map[string]*openAPIResponse{
+			"200": dataResponse("The engine is up", objectOf(map[string]*jsonSchema{"status": stringSchema(), "time": {Type: "string", Format: "date-time"}})),
+		},
+	})
+	ready := objectOf(map[string]*jsonSchema{"status": stringSchema()})
+	ready.Properties["read_replica"] = &jsonSchema{Type: "string", Description: "up or down, when a read replica is configured"}
+	b.add("GET", "/ready", &openAPIOperation{
+		OperationID: "getReady", Summary: "Readiness probe", Tags: []string{"health"}, auth: authPublic,
+		Responses: map[string]*openAPIResponse{"200": dataResponse("The engine can serve requests", ready)},
+		errors:    []int{http.StatusServiceUnavailable},
+	})
+	b.add("GET", "/health/details", &openAPIOperation{
+		OperationID: "getHealthDetails", Summary: "Per-dependency health", Tags: []string{"health"}, auth: authPublic,
+		Description: "Checks Docker, the repository, the read replica and every service provider in parallel. " +
+			"Answers 200 even when components are down; status is then degraded.",
+		Responses: map[string]*openAPIResponse{
+			"200": dataResponse("The health of each component", objectOf(map[string]*jsonSchema{
+				"status":     {Type: "string", Description: "healthy or degraded"},
+				"components": {Type: "object", AdditionalProperties: schemaFor[models.ComponentHealth](g), Description: "docker, repository, read_replica and service:<name>"},
+				"time":       {Type: "string", Format: "date-time"},
+			})),
+		},
+	})
+
+	b.add("GET", "/version", &openAPIOperation{
+		OperationID: "getVersion", Summary: "Build information", Tags: []string{"health"}, auth: authPublic,
+		Description: "Every response also carries the version and commit in the X-Sandbox-Engine-Version header.",
+		Responses:   map[string]*openAPIResponse{"200": dataResponse("The engine build and its service providers", schemaFor[versionDTO](g))},
+	})
+
+	// Sandboxes
+
+	b.add("GET", "/api/v1/sandboxes", &openAPIOperation{
+		OperationID: "listSandboxes", Summary: "List sandboxes", Tags: []string{"sandboxes"}, Permission: "sandboxes:read",
+		Description: "Lists the caller's sandboxes, or every sandbox with sandboxes:admin. metadata.<key>=<value> parameters match metadata entries.",
+		Parameters: append([]openAPIParameter{
+			queryParam("user_id", "Only sandboxes of this user", stringSchema()),
+			queryParam("template_id", "Only sandboxes of this template", stringSchema()),
+			queryParam("status", "Only sandboxes in this status", stringSchema()),
+			queryParam("created_after", "Only sandboxes created after this RFC 3339 time", &jsonSchema{Type: "string", Format: "date-time"}),
+			queryParam("created_before", "Only sandboxes created before this RFC 3339 time", &jsonSchema{Type: "string", Format: "date-time"}),
+			queryParam("include_deleted", "Include soft-deleted sandboxes", booleanSchema()),
+			queryParam("sort", "Field to order by, created_at by default", &jsonSchema{Type: "string", Enum: []any{"created_at", "expires_at", "status", "user_id"}}),
+			queryParam("order", "Sort direction, desc by default", &jsonSchema{Type: "string", Enum: []any{"asc", "desc"}}),
+		}, pagination...),
+		Responses: map[string]*openAPIResponse{
+			"200": dataResponse("A page of sandboxes", objectOf(map[string]*jsonSchema{
+				"sandboxes": arrayOf(sandboxSchema),
+				"total":     integerSchema(),
+				"limit":     integerSchema(),
+				"offset":    integerSchema(),
+			})),
+		},
+		errors: []int{http.StatusBadRequest},
+	})
+	b.add("POST", "/api/v1/sandboxes", &openAPIOperation{
+		OperationID: "createSandbox", Summary: "Create a sandbox", Tags: []string{"sandboxes"}, Permission: "sandboxes:write",
+		Description: "Provisioning continues in the background; poll the sandbox until it is running. " +
+			"A ttl beyond the template's max_ttl or SANDBOX_MAX_TTL fails with 422 and the allowed maximum.",
+		RequestBody: jsonBody(schemaFor[models.CreateRequest](g), true),
+		Responses:   map[string]*openAPIResponse{"201": dataResponse("The sandbox, still provisioning", sandboxSchema)},
+		errors:      []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusServiceUnavailable, http.StatusUnprocessableEntity},
+	})
+	b.add("POST", "/api/v1/sandboxes/bulk-delete", &openAPIOperation{
+		OperationID: "bulkDeleteSandboxes", Summary: "Delete many sandboxes", Tags: []string{"sandboxes"}, Permission: "sandboxes:write",
+		Description: "Deletes the listed ids, or every sandbox matching the filters, up to SANDBOX_BULK_DELETE_MAX. " +
+			"Without sandboxes:admin only the caller's sandboxes are reached; others are reported as not_found.",
+		RequestBody: jsonBody(schemaFor[models.BulkDeleteRequest](g), true),
+		Responses:   map[string]*openAPIResponse{"200": dataResponse("One result per selected sandbox", schemaFor[bulkDeleteResponseDTO](g))},
+		errors:      []int{http.StatusBadRequest},
+	})
+	b.add("POST", "/api/v1/sandboxes/logs/export", &openAPIOperation{
+		OperationID: "exportSandboxLogs", Summary: "Export the logs of many sandboxes", Tags: []string{"sandboxes"}, Permission: "sandboxes:read",
+		Description: "Streams a tar.gz with one log file per matching sandbox and a manifest.json of per-sandbox results.",
+		RequestBody: jsonBody(schemaFor[models.LogExportRequest](g), false),
+		Responses: map[string]*openAPIResponse{
+			"200": {Description: "The archive", Content: map[string]openAPIMediaType{"application/gzip": {Schema: &jsonSchema{Type: "string", Format: "binary"}}}},
+		},
+		errors: []int{http.StatusBadRequest},
+	})
+	b.add("GET", "/api/v1/sandboxes/{id}", &openAPIOperation{
+		OperationID: "getSandbox", Summary: "Get a sandbox", Tags: []string{"sandboxes"}, Permission: "sandboxes:read",
+		Responses: map[string]*openAPIResponse{"200": dataResponse("The sandbox", sandboxSchema)},
+		errors:    []int{http.StatusNotFound},
+	})
+	b.add("DELETE", "/api/v1/sandboxes/{id}", &openAPIOperation{
+		OperationID: "deleteSandbox", Summary: "Delete a sandbox", Tags: []string{"sandboxes"}, Permission: "sandboxes:write",
+		Description: "A service that fails to deprovision keeps the sandbox in status deleting, with the failure in its status_message, and the cleanup worker retries the delete; the response is a 503 deprovision_failed.",
+		Parameters:  []openAPIParameter{queryParam("force", "Delete the sandbox even when its services fail to deprovision; requires sandboxes:admin", booleanSchema())},
+		Responses: map[string]*openAPIResponse{
+			"200": dataResponse("The sandbox was deleted", messageSchema()),
+			"202": dataResponse("Deletion continues in the background", messageSchema()),
+		},
+		errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusServiceUnavailable},
+	})
+	b.add("POST", "/api/v1/sandboxes/{id}/extend", &openAPIOperation{
+		OperationID: "extendSandbox", Summary: "Extend a sandbox's TTL", Tags: []string{"sandboxes"}, Permission: "sandboxes:write",
+		RequestBody: jsonBody(schemaFor[models.ExtendRequest](g), true),
+		Responses:   map[string]*openAPIResponse{"200": dataResponse("The sandbox with its new expiry", sandboxSchema)},
+		errors:      []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity},
+	})
+	b.add("POST", "/api/v1/sandboxes/{id}/start", &openAPIOperation{
+		OperationID: "startSandbox", Summary: "Start a stopped sandbox", Tags: []string{"sandboxes"}, Permission: "sandboxes:write",
+		Responses: map[string]*openAPIResponse{"200": dataResponse("The started sandbox", sandboxSchema)},
+		errors:    []int{http.StatusNotFound, http.StatusConflict},
+	})
+	b.add("POST", "/api/v1/sandboxes/{id}/stop", &openAPIOperation{
+		OperationID: "stopSandbox", Summary: "Stop a sandbox", Tags: []string{"sandboxes"}, Permission: "sandboxes:write",
+		Responses: map[string]*openAPIResponse{
+			"200": dataResponse("The sandbox was stopped", messageSchema()),
+			"202": dataResponse("Stopping continues in the background", messageSchema()),
+		},
+		errors: []int{http.StatusNotFound, http.StatusConflict},
+	})
+	b.add("GET", "/api/v1/sandboxes/{id}/logs", &openAPIOperation{
+		OperationID: "getSandboxLogs", Summary: "Get a sandbox's log", Tags: []string{"sandboxes"}, Permission: "sandboxes:read",
+		Parameters: logParams,
+		Responses:  map[string]*openAPIResponse{"200": dataResponse("The log", objectOf(map[string]*jsonSchema{"logs": stringSchema()}))},
+		errors:     []int{http.StatusBadRequest, http.StatusNotFound},
+	})
+	b.add("GET", "/api/v1/sandboxes/{id}/logs/stream", &openAPIOperation{
+		OperationID: "streamSandboxLogs", Summary: "Follow a sandbox's log", Tags: []string{"sandboxes"}, Permission: "sandboxes:read",
+		Description: "Streams the log as chunked plain text until the client disconnects or the container stops.",
+		Parameters:  logParams,
+		Responses: map[string]*openAPIResponse{
+			"200": {Description: "The log, followed", Content: map[string]openAPIMediaType{"text/plain": {Schema: stringSchema()}}},
+		},
+		errors: []int{http.StatusBadRequest, http.StatusNotFound},
+	})
+	b.add("GET", "/api/v1/sandboxes/{id}/events", &openAPIOperation{
+		OperationID: "listSandboxEvents", Summary: "List a sandbox's lifecycle events", Tags: []string{"sandboxes"}, Permission: "sandboxes:read",
+		Responses: map[string]*openAPIResponse{"200": dataResponse("The events, oldest first", listOf("events", schemaFor[eventDTO](g)))},
+		errors:    []int{http.StatusNotFound},
+	})
+	b.add("GET", "/api/v1/sandboxes/{id}/recordings", &openAPIOperation{
+		OperationID: "listSandboxRecordings", Summary: "List a sandbox's terminal recordings", Tags: []string{"sandboxes"}, Permission: "recordings:read",
+		Description: "Recordings are kept after the sandbox is deleted; clients without sandboxes:admin see those of their own sandboxes.",
+		Responses:   map[string]*openAPIResponse{"200": dataResponse("The recordings, oldest first", listOf("recordings", schemaFor[models.TerminalRecording](g)))},
+	})
+	b.add("GET", "/api/v1/sandboxes/{id}/recordings/{recordingId}", &openAPIOperation{
+		OperationID: "getSandboxRecording", Summary: "Download a terminal recording", Tags: []string{"sandboxes"}, Permission: "recordings:read",
+		Description: "Returns the asciicast v2 file, playable with asciinema. A recording that hit TERMINAL_RECORDING_MAX_MB ends with a truncated marker.",
+		Responses: map[string]*openAPIResponse{
+			"200": {Description: "The recording", Content: map[string]openAPIMediaType{"application/x-asciicast": {Schema: &jsonSchema{Type: "string", Format: "binary"}}}},
+		},
+		errors: []int{http.StatusBadRequest, http.StatusNotFound},
+	})
+	b.add("POST", "/api/v1/sandboxes/{id}/grade", &openAPIOperation{
+		OperationID: "gradeSandbox", Summary: "Grade a sandbox's task", Tags: []string{"sandboxes"}, Permission: "sandboxes:grade",
+		Description: "Runs the grading command of the sandbox's catalog task and stores the result, with its JUnit report parsed when the task sets junit_path. A failing or timed-out command is a 200 with passed false; each output stream keeps its last 64 KB.",
+		Responses:   map[string]*openAPIResponse{"200": dataResponse("The grading result", schemaFor[models.GradingResult](g))},
+		errors:      []int{http.StatusNotFound, http.StatusConflict},
+	})
+	b.add("GET", "/api/v1/sandboxes/{id}/grade", &openAPIOperation{
+		OperationID: "listSandboxGradingResults", Summary: "List a sandbox's grading results", Tags: []string{"sandboxes"}, Permission: "sandboxes:grade",
+		Description: "Results are kept after the sandbox is deleted; clients without sandboxes:admin see those of their own sandboxes.",
+		Responses:   map[string]*openAPIResponse{"200": dataResponse("The grading results, oldest first", listOf("results", schemaFor[models.GradingResult](g)))},
+	})
+	b.add("GET", "/api/v1/sandboxes/{id}/services", &openAPIOperation{
+		OperationID: "listSandboxServices", Summary: "List a sandbox's services", Tags: []string{"sandboxes"}, Permission: "sandboxes:read",
+		Description: "Credentials are redacted; the full credentials are only returned with the sandbox.",
+		Responses:   map[string]*openAPIResponse{"200": dataResponse("The services", listOf("services", serviceSchema))},
+		errors:      []int{http.StatusNotFound},
+	})
+	b.add("GET", "/api/v1/sandboxes/{id}/services/{name}", &openAPIOperation{
+		OperationID: "getSandboxService", Summary: "Get a sandbox service", Tags: []string{"sandboxes"}, Permission: "sandboxes:read",
+		Responses: map[string]*openAPIResponse{"200": dataResponse("The service, credentials redacted", serviceSchema)},
+		errors:    []int{http.StatusNotFound},
+	})
+	b.add("POST", "/api/v1/sandboxes/{id}/services/{name}/healthcheck", &openAPIOperation{
+		OperationID: "checkSandboxService", Summary: "Run a service health check", Tags: []string{"sandboxes"}, Permission: "sandboxes:write",
+		Responses: map[string]*openAPIResponse{"200": dataResponse("The service after the check", serviceSchema)},
+		errors:    []int{http.StatusNotFound, http.StatusConflict, http.StatusNotImplemented},
+	})
+	b.add("POST", "/api/v1/sandboxes/{id}/services/{name}/rotate", &openAPIOperation{
+		OperationID: "rotateSandboxServiceCredentials", Summary: "Rotate a service's credentials", Tags: []string{"sandboxes"}, Permission: "sandboxes:write",
+		Description: "Sets a new password and disconnects sessions using the old one. The container's env vars keep the old credentials; the new ones are written to the template's credentials file, or /run/sandbox/credentials.json without one.",
+		Responses:   map[string]*openAPIResponse{"200": dataResponse("The service with its new credentials, not redacted", serviceSchema)},
+		errors:      []int{http.StatusNotFound, http.StatusConflict, http.StatusNotImplemented},
+	})
+	pathParam := queryParam("path", "Absolute path of the file in the container", stringSchema())
+	pathParam.Required = true
+	b.add("POST", "/api/v1/sandboxes/{id}/exec", &openAPIOperation{
+		OperationID: "execSandbox", Summary: "Run a command in a sandbox", Tags: []string{"sandboxes"}, Permission: "sandboxes:terminal",
+		Description: "Runs the command to completion as the template's terminal user. A non-zero exit is a 200 with its exit_code; each output stream keeps its first 1 MB.",
+		RequestBody: jsonBody(schemaFor[models.ExecRequest](g), true),
+		Responses:   map[string]*openAPIResponse{"200": dataResponse("How the command ended", schemaFor[models.ExecResult](g))},
+		errors:      []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusGatewayTimeout},
+	})
+	b.add("GET", "/api/v1/sandboxes/{id}/files", &openAPIOperation{
+		OperationID: "downloadSandboxFile", Summary: "Download a file from a sandbox", Tags: []string{"sandboxes"}, Permission: "sandboxes:read",
+		Parameters: []openAPIParameter{pathParam},
+		Responses: map[string]*openAPIResponse{
+			"200": {Description: "The file", Content: map[string]openAPIMediaType{"application/octet-stream": {Schema: &jsonSchema{Type: "string", Format: "binary"}}}},
+		},
+		errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict},
+	})
+	b.add("PUT", "/api/v1/sandboxes/{id}/files", &openAPIOperation{
+		OperationID: "uploadSandboxFile", Summary: "Upload a file into a sandbox", Tags: []string{"sandboxes"}, Permission: "sandboxes:write",
+		Description: "Writes the body to the path, creating missing directories and replacing an existing file. The body needs a Content-Length of at most FILE_UPLOAD_MAX_MB.",
+		Parameters:  []openAPIParameter{pathParam},
+		RequestBody: &openAPIRequestBody{Required: true, Content: map[string]openAPIMediaType{
+			"application/octet-stream": {Schema: &jsonSchema{Type: "string", Format: "binary"}},
+		}},
+		Responses: map[string]*openAPIResponse{"200": dataResponse("The file written", objectOf(map[string]*jsonSchema{"path": stringSchema(), "size": integerSchema()}))},
+		errors:    []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusLengthRequired, http.StatusRequestEntityTooLarge},
+	})
+	b.add("GET", "/api/v1/sandboxes/{id}/stats", &openAPIOperation{
+		OperationID: "getSandboxStats", Summary: "Sample a sandbox's resource usage", Tags: []string{"sandboxes"}, Permission: "sandboxes:read",
+		Description: "Takes about a second, for Docker to sample the CPU usage twice.",
+		Responses:   map[string]*openAPIResponse{"200": dataResponse("The usage", schemaFor[models.SandboxStats](g))},
+		errors:      []int{http.StatusNotFound, http.StatusConflict},
+	})
+
+	// WebSockets
+
+	b.add("GET", "/api/v1/ws/terminal/{id}", &openAPIOperation{
+		OperationID: "sandboxTerminal", Summary: "Attach to a sandbox shell", Tags: []string{"sandboxes"}, Permission: "sandboxes:terminal",
+		Description: "WebSocket carrying terminal input, output and resize messages. Browsers pass the API key as ?token=.",
+		Parameters: []openAPIParameter{
+			queryParam("new", "Open a fresh shell instead of reattaching to the running one", booleanSchema()),
+			queryParam("connect", "Send a connect message first, within 5s, which may choose the command to run", booleanSchema()),
+			queryParam("mode", "observe to watch the shell read-only", &jsonSchema{Type: "string", Enum: []any{"observe"}}),
+		},
+		Responses: webSocketResponses("Switching to the WebSocket protocol"),
+		errors:    []int{http.StatusBadRequest, http.StatusNotFound, http.StatusTooManyRequests},
+	})
+	b.add("GET", "/api/v1/ws/session-terminal/{id}", &openAPIOperation{
+		OperationID: "sessionTerminal", Summary: "Attach to a session sandbox shell", Tags: []string{"sessions"}, auth: authSession,
+		Responses: webSocketResponses("Switching to the WebSocket protocol"),
+		errors:    []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound},
+	})
+	b.add("GET", "/api/v1/ws/logs/{id}", &openAPIOperation{
+		OperationID: "sandboxLogViewer", Summary: "Follow a sandbox's log over a WebSocket", Tags: []string{"sandboxes"}, auth: authEither,
+		Description: "Pushes {\"type\": \"log\", \"stream\": \"stdout\", \"data\": ...} messages, starting from the last tail lines. " +
+			"Needs sandboxes:read with an API key, or the session token of the session that owns the sandbox.",
+		Parameters: []openAPIParameter{queryParam("tail", "Lines of backlog to send first (default 100, at most 1000)", integerSchema())},
+		Responses:  webSocketResponses("Switching to the WebSocket protocol"),
+		errors:     []int{http.StatusBadRequest, http.StatusNotFound},
+	})
+
+	// Sessions
+
+	b.add("GET", "/api/v1/sessions", &openAPIOperation{
+		OperationID: "listSessions", Summary: "List sessions", Tags: []string{"sessions"}, Permission: "sessions:read",
+		Parameters: append([]openAPIParameter{
+			queryParam("status", "Only sessions in this status (ready, provisioning, active, expired, failed, revoked, submitted), or invalid_template", stringSchema()),
+			queryParam("template_id", "Only sessions of this template", stringSchema()),
+			queryParam("created_by", "Only sessions created by this API client", stringSchema()),
+			queryParam("created_after", "Only sessions created after this RFC 3339 time", &jsonSchema{Type: "string", Format: "date-time"}),
+			queryParam("created_before", "Only sessions created before this RFC 3339 time", &jsonSchema{Type: "string", Format: "date-time"}),
+		}, pagination...),
+		Responses: map[string]*openAPIResponse{
+			"200": dataResponse("A page of sessions", objectOf(map[string]*jsonSchema{
+				"sessions": arrayOf(sessionSchema),
+				"total":    integerSchema(),
+				"limit":    integerSchema(),
+				"offset":   integerSchema(),
+			})),
+		},
+		errors: []int{http.StatusBadRequest},
+	})
+	b.add("POST", "/api/v1/sessions", &openAPIOperation{
+		OperationID: "createSession", Summary: "Create a session", Tags: []string{"sessions"}, Permission: "sessions:write",
+		Description: "No container is created until the candidate activates the session from its join URL.",
+		RequestBody: jsonBody(schemaFor[models.CreateSessionRequest](g), true),
+		Responses:   map[string]*openAPIResponse{"201": dataResponse("The session and its join URL", schemaFor[models.CreateSessionResponse](g))},
+		errors:      []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity},
+	})
+	b.add("POST", "/api/v1/sessions/bulk", &openAPIOperation{
+		OperationID: "bulkCreateSessions", Summary: "Create a session per candidate", Tags: []string{"sessions"}, Permission: "sessions:write",
+		Description: "Creates one session per entry with the shared settings, up to SESSION_BULK_CREATE_MAX. " +
+			"Entry metadata is merged over the shared metadata; entries that fail are reported without undoing the others.",
+		RequestBody: jsonBody(schemaFor[models.BulkCreateSessionsRequest](g), true),
+		Responses:   map[string]*openAPIResponse{"200": dataResponse("One result per entry", schemaFor[models.BulkCreateSessionsResponse](g))},
+		errors:      []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity},
+	})
+	b.add("GET", "/api/v1/sessions/{id}", &openAPIOperation{
+		OperationID: "getSession", Summary: "Get a session", Tags: []string{"sessions"}, Permission: "sessions:read",
+		Responses: map[string]*openAPIResponse{"200": dataResponse("The session", sessionSchema)},
+		errors:    []int{http.StatusNotFound},
+	})
+	b.add("DELETE", "/api/v1/sessions/{id}", &openAPIOperation{
+		OperationID: "deleteSession", Summary: "Delete a session and its sandbox", Tags: []string{"sessions"}, Permission: "sessions:write",
+		Responses: map[string]*openAPIResponse{"200": dataResponse("The session was deleted", messageSchema())},
+		errors:    []int{http.StatusNotFound},
+	})
+	b.add("POST", "/api/v1/sessions/{id}/extend", &openAPIOperation{
+		OperationID: "extendSession", Summary: "Give an active session more time", Tags: []string{"sessions"}, Permission: "sessions:write",
+		Description: "Extends the session expiry and its sandbox's TTL together; the join page shows the new expiry.",
+		RequestBody: jsonBody(schemaFor[models.ExtendRequest](g), true),
+		Responses:   map[string]*openAPIResponse{"200": dataResponse("The session with its new expiry", sessionSchema)},
+		errors:      []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity},
+	})
+	b.add("POST", "/api/v1/sessions/{id}/revoke", &openAPIOperation{
+		OperationID: "revokeSession", Summary: "Revoke a session, keeping its record", Tags: []string{"sessions"}, Permission: "sessions:write",
+		Description: "Deletes the session's sandbox and invalidates its join token; the record stays, with revoked_by and revoked_at, " +
+			"until SESSION_REVOKED_RETENTION passes.",
+		Responses: map[string]*openAPIResponse{"200": dataResponse("The revoked session", sessionSchema)},
+		errors:    []int{http.StatusNotFound, http.StatusConflict},
+	})
+	b.add("GET", "/api/v1/sessions/{id}/submission", &openAPIOperation{
+		OperationID: "getSessionSubmission", Summary: "Download a session's submission", Tags: []string{"sessions"}, Permission: "sessions:read",
+		Description: "Returns the tar of the workspace the candidate handed in.",
+		Responses: map[string]*openAPIResponse{
+			"200": {Description: "The archive", Content: map[string]openAPIMediaType{"application/x-tar": {Schema: &jsonSchema{Type: "string", Format: "binary"}}}},
+		},
+		errors: []int{http.StatusNotFound},
+	})
+	b.add("GET", "/api/v1/sessions/{id}/report", &openAPIOperation{
+		OperationID: "getSessionReport", Summary: "Summarize a session's activity", Tags: []string{"sessions"}, Permission: "sessions:read",
+		Description: "Join time, terminal connections, extensions and the sandbox's status and events, from persisted records, so it stays available after cleanup.",
+		Responses:   map[string]*openAPIResponse{"200": dataResponse("The report", schemaFor[models.SessionReport](g))},
+		errors:      []int{http.StatusNotFound},
+	})
+	b.add("GET", "/api/v1/join/{token}", &openAPIOperation{
+		OperationID: "joinSession", Summary: "Describe a session to its candidate", Tags: []string{"sessions"}, auth: authPublic,
+		Parameters: []openAPIParameter{langParam},
+		Responses: map[string]*openAPIResponse{
+			"200": dataResponse("The session as the join page shows it", schemaFor[models.JoinSessionResponse](g)),
+			"410": dataResponse("The session was revoked; only its status is returned", schemaFor[models.JoinSessionResponse](g)),
+		},
+		errors: []int{http.StatusNotFound, http.StatusTooManyRequests},
+	})
+	b.add("POST", "/api/v1/join/{token}/activate", &openAPIOperation{
+		OperationID: "activateSession", Summary: "Start a session's sandbox", Tags: []string{"sessions"}, auth: authPublic,
+		Responses: map[string]*openAPIResponse{
+			"200": dataResponse("The activated session", schemaFor[models.ActivateSessionResponse](g)),
+			"410": dataResponse("The session was revoked; only its status is returned", schemaFor[models.ActivateSessionResponse](g)),
+		},
+		errors: []int{http.StatusNotFound, http.StatusConflict, http.StatusTooManyRequests, http.StatusServiceUnavailable},
+	})
+	b.add("POST", "/api/v1/join/{token}/submit", &openAPIOperation{
+		OperationID: "submitSession", Summary: "Hand in a session's workspace", Tags: []string{"sessions"}, auth: authPublic,
+		Description: "Archives the workspace, marks the session submitted and deletes its sandbox; the terminal refuses new connections. A workspace over SESSION_SUBMISSION_MAX_MB is refused with 413 and the session stays active.",
+		Responses: map[string]*openAPIResponse{
+			"200": dataResponse("The submitted session", schemaFor[models.SubmitSessionResponse](g)),
+			"410": dataResponse("The session was revoked; only its status is returned", schemaFor[models.SubmitSessionResponse](g)),
+		},
+		errors: []int{http.StatusNotFound, http.StatusConflict, http.StatusRequestEntityTooLarge, http.StatusTooManyRequests},
+	})
+
+	// Templates
+
+	b.add("GET", "/api/v1/templates", &openAPIOperation{
+		OperationID: "listTemplates", Summary: "List templates", Tags: []string{"templates"}, Permission: "templates:read",
+		Description: "Disabled templates are left out; they still resolve by name for the sandboxes and sessions using them.",
+		Parameters: []openAPIParameter{
+			queryParam("tag", "Only templates with this tag", stringSchema()),
+			queryParam("language", "Only templates of this language", stringSchema()),
+			langParam,
+		},
+		Responses: map[string]*openAPIResponse{"200": dataResponse("The enabled templates", listOf("templates", templateSchema))},
+	})
+	templateDocument := &openAPIRequestBody{Required: true, Content: map[string]openAPIMediaType{
+		"application/yaml": {Schema: stringSchema()},
+		"application/json": {Schema: &jsonSchema{Type: "object"}},
+	}}
+	b.add("POST", "/api/v1/templates", &openAPIOperation{
+		OperationID: "createTemplate", Summary: "Create a template", Tags: []string{"templates"}, Permission: "templates:write",
+		Description: "The body is a template document, like the files of TEMPLATES_DIR. It must pass validation; " +
+			"it is stored in the database, loaded at once and kept across restarts.",
+		RequestBody: templateDocument,
+		Responses:   map[string]*openAPIResponse{"201": dataResponse("The template created", templateSchema)},
+		errors:      []int{http.StatusBadRequest, http.StatusConflict, http.StatusRequestEntityTooLarge},
+	})
+	b.add("POST", "/api/v1/templates/validate", &openAPIOperation{
+		OperationID: "validateTemplate", Summary: "Validate a template document", Tags: []string{"templates"}, Permission: "templates:read",
+		RequestBody: templateDocument,
+		Responses: map[string]*openAPIResponse{
+			"200": dataResponse("The issues found; valid is false when any is an error", objectOf(map[string]*jsonSchema{
+				"name":   stringSchema(),
+				"valid":  booleanSchema(),
+				"issues": arrayOf(schemaFor[templates.Issue](g)),
+			})),
+		},
+		errors: []int{http.StatusBadRequest, http.StatusRequestEntityTooLarge},
+	})
+	b.add("GET", "/api/v1/templates/validation", &openAPIOperation{
+		OperationID: "listInvalidTemplates", Summary: "List templates that failed validation", Tags: []string{"templates"}, Permission: "templates:read",
+		Description: "Templates with validation errors, such as an unknown service, are left out of the template list; " +
+			"each is reported here with the first error as its reason. Files the last load could not parse at all, " +
+			"such as one with an unparseable ttl or resource, are listed under skipped. A template name defined by two files, " +
+			"or a catalog project ID colliding with a template name, is listed under conflicts: the first definition is loaded, " +
+			"the file of the second is skipped.",
+		Responses: map[string]*openAPIResponse{"200": dataResponse("The invalid templates, skipped files and name conflicts", objectOf(map[string]*jsonSchema{
+			"templates": arrayOf(schemaFor[templates.InvalidTemplate](g)),
+			"total":     integerSchema(),
+			"skipped":   arrayOf(schemaFor[templates.SkippedFile](g)),
+			"conflicts": arrayOf(schemaFor[templates.Conflict](g)),
+		}))},
+	})
+	b.add("POST", "/api/v1/templates/reload", &openAPIOperation{
+		OperationID: "reloadTemplates", Summary: "Reload templates and the catalog", Tags: []string{"templates"}, Permission: "admin:write",
+		Description: "Loads TEMPLATES_DIR and the stored templates again and swaps the result in at once. Removed templates leave the catalog; " +
+			"existing sandboxes keep running.",
+		Responses: map[string]*openAPIResponse{"200": dataResponse("What the reload added, changed and removed, and the files it skipped", schemaFor[templates.ReloadSummary](g))},
+		errors:    []int{http.StatusConflict, http.StatusInternalServerError},
+	})
+	b.add("GET", "/api/v1/templates/{name}", &openAPIOperation{
+		OperationID: "getTemplate", Summary: "Get a template", Tags: []string{"templates"}, Permission: "templates:read",
+		Parameters: []openAPIParameter{langParam},
+		Responses:  map[string]*openAPIResponse{"200": dataResponse("The template", templateSchema)},
+		errors:     []int{http.StatusNotFound},
+	})
+	b.add("PUT", "/api/v1/templates/{name}", &openAPIOperation{
+		OperationID: "updateTemplate", Summary: "Update a template", Tags: []string{"templates"}, Permission: "templates:write",
+		Description: "Replaces a template created through the API; the document must keep its name. " +
+			"Templates of TEMPLATES_DIR are read-only.",
+		RequestBody: templateDocument,
+		Responses:   map[string]*openAPIResponse{"200": dataResponse("The template updated", templateSchema)},
+		errors:      []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusRequestEntityTooLarge},
+	})
+	b.add("DELETE", "/api/v1/templates/{name}", &openAPIOperation{
+		OperationID: "deleteTemplate", Summary: "Delete a template", Tags: []string{"templates"}, Permission: "templates:write",
+		Description: "Deletes a template created through the API. It fails with template_in_use while pending, " +
+			"running or stopped sandboxes use it.",
+		Responses: map[string]*openAPIResponse{"200": dataResponse("The template was deleted", messageSchema())},
+		errors:    []int{http.StatusNotFound, http.StatusConflict},
+	})
+
+	// Catalog
+
+	domainSchema := schemaFor[domainDTO](g)
+	projectSchema := schemaFor[projectDTO](g)
+	taskSchema := schemaFor[taskDTO](g)
+	b.add("GET", "/api/v1/catalog/domains", &openAPIOperation{
+		OperationID: "listDomains", Summary: "List catalog domains", Tags: []string{"catalog"}, Permission: "templates:read",
+		Responses: map[string]*openAPIResponse{"200": dataResponse("The domains", listOf("domains", domainSchema))},
+	})
+	b.add("GET", "/api/v1/catalog/export", &openAPIOperation{
+		OperationID: "exportCatalog", Summary: "Export the catalog", Tags: []string{"catalog"}, Permission: "admin:read",
+		Description: "A tar.gz of the domain.yaml, template.yaml and task files of the catalog as loaded, laid out like TEMPLATES_DIR; files that failed to load are left out. " +
+			"The files are raw, grading, setup commands, env and starter repos included, so the archive takes admin:read.",
+		Responses: map[string]*openAPIResponse{
+			"200": {Description: "The archive", Content: map[string]openAPIMediaType{"application/gzip": {Schema: &jsonSchema{Type: "string", Format: "binary"}}}},
+		},
+	})
+	b.add("POST", "/api/v1/catalog/import", &openAPIOperation{
+		OperationID: "importCatalog", Summary: "Import a catalog archive", Tags: []string{"catalog"}, Permission: "admin:write",
+		Description: "Loads an archive as exportCatalog writes it into a staging loader in place of the catalog of TEMPLATES_DIR and reports what it changes. " +
+			"An archive with a file that fails to load or a template that fails validation is refused with 422 and those files. " +
+			"With apply=true the archive's domains replace the catalog domains of TEMPLATES_DIR, and the result is swapped in at once. " +
+			"Only the TEMPLATES_DIR of the replica serving the request changes.",
+		Parameters: []openAPIParameter{queryParam("apply", "Replace the catalog with the archive instead of only reporting the changes", booleanSchema())},
+		RequestBody: &openAPIRequestBody{Required: true, Content: map[string]openAPIMediaType{
+			"application/gzip": {Schema: &jsonSchema{Type: "string", Format: "binary"}},
+		}},
+		Responses: map[string]*openAPIResponse{
+			"200": dataResponse("What the import changes, or changed when applied", objectOf(map[string]*jsonSchema{
+				"applied": booleanSchema(),
+				"summary": schemaFor[templates.ReloadSummary](g),
+			})),
+			"422": dataResponse("The files of the archive that failed to load or validate", schemaFor[templates.ImportError](g)),
+		},
+		errors: []int{http.StatusBadRequest, http.StatusConflict, http.StatusRequestEntityTooLarge},
+	})
+	b.add("GET", "/api/v1/catalog/domains/{domainId}", &openAPIOperation{
+		OperationID: "getDomain", Summary: "Get a catalog domain", Tags: []string{"catalog"}, Permission: "templates:read",
+		Responses: map[string]*openAPIResponse{"200": dataResponse("The domain", domainSchema)},
+		errors:    []int{http.StatusNotFound},
+	})
+	b.add("GET", "/api/v1/catalog/domains/{domainId}/projects", &openAPIOperation{
+		OperationID: "listProjects", Summary: "List a domain's projects", Tags: []string{"catalog"}, Permission: "templates:read",
+		Responses: map[string]*openAPIResponse{"200": dataResponse("The projects", listOf("projects", projectSchema))},
+		errors:    []int{http.StatusNotFound},
+	})
+	b.add("GET", "/api/v1/catalog/domains/{domainId}/projects/{projectName}", &openAPIOperation{
+		OperationID: "getProject", Summary: "Get a catalog project", Tags: []string{"catalog"}, Permission: "templates:read",
+		Responses: map[string]*openAPIResponse{"200": dataResponse("The project", projectSchema)},
+		errors:    []int{http.StatusNotFound},
+	})
+	b.add("GET", "/api/v1/catalog/domains/{domainId}/projects/{projectName}/tasks", &openAPIOperation{
+		OperationID: "listTasks", Summary: "List a project's tasks", Tags: []string{"catalog"}, Permission: "templates:read",
+		Parameters: []openAPIParameter{langParam},
+		Responses:  map[string]*openAPIResponse{"200": dataResponse("The tasks", listOf("tasks", taskSchema))},
+		errors:     []int{http.StatusNotFound},
+	})
+	b.add("GET", "/api/v1/catalog/domains/{domainId}/projects/{projectName}/tasks/{taskCode}", &openAPIOperation{
+		OperationID: "getTask", Summary: "Get a catalog task", Tags: []string{"catalog"}, Permission: "templates:read",
+		Parameters: []openAPIParameter{langParam},
+		Responses:  map[string]*openAPIResponse{"200": dataResponse("The task", taskSchema)},
+		errors:     []int{http.StatusNotFound},
+	})
+
+	// Admin
+
+	b.add("GET", "/api/v1/admin/templates/usage", &openAPIOperation{
+		OperationID: "getTemplateUsage", Summary: "Report template and task usage", Tags: []string{"admin"}, Permission: "admin:read",
+		Parameters: []openAPIParameter{
+			queryParam("from", "First UTC day (YYYY-MM-DD, default 30 days before to)", &jsonSchema{Type: "string", Format: "date"}),
+			queryParam("to", "Last UTC day (YYYY-MM-DD, default today)", &jsonSchema{Type: "string", Format: "date"}),
+		},
+		Responses: map[string]*openAPIResponse{"200": dataResponse("The usage report", schemaFor[models.UsageReport](g))},
+		errors:    []int{http.StatusBadRequest},
+	})
+	b.add("GET", "/api/v1/admin/hosts", &openAPIOperation{
+		OperationID: "listHosts", Summary: "List Docker hosts", Tags: []string{"admin"}, Permission: "admin:read",
+		Responses: map[string]*openAPIResponse{"200": dataResponse("The hosts", listOf("hosts", hostSchema))},
+	})
+	b.add("POST", "/api/v1/admin/hosts/{name}/drain", &openAPIOperation{
+		OperationID: "drainHost", Summary: "Stop scheduling sandboxes on a host", Tags: []string{"admin"}, Permission: "admin:write",
+		RequestBody: jsonBody(schemaFor[models.DrainHostRequest](g), false),
+		Responses: map[string]*openAPIResponse{
+			"200": dataResponse("The host and the sandboxes still on it", objectOf(map[string]*jsonSchema{
+				"host":      hostSchema,
+				"sandboxes": nullable(arrayOf(schemaFor[models.Sandbox](g))),
+			})),
+		},
+		errors: []int{http.StatusBadRequest, http.StatusNotFound},
+	})
+	b.add("POST", "/api/v1/admin/hosts/{name}/undrain", &openAPIOperation{
+		OperationID: "undrainHost", Summary: "Resume scheduling sandboxes on a host", Tags: []string{"admin"}, Permission: "admin:write",
+		Responses: map[string]*openAPIResponse{"200": dataResponse("The host", hostSchema)},
+		errors:    []int{http.StatusNotFound},
+	})
+	orphanReportSchema := schemaFor[models.OrphanReport](g)
+	b.add("GET", "/api/v1/admin/orphans", &openAPIOperation{
+		OperationID: "listOrphans", Summary: "List drift between the database and Docker", Tags: []string{"admin"}, Permission: "admin:read",
+		Description: "Managed containers without a sandbox, and running or stopped sandboxes whose container is gone.",
+		Responses:   map[string]*openAPIResponse{"200": dataResponse("What a prune would remove", orphanReportSchema)},
+	})
+	b.add("POST", "/api/v1/admin/orphans/prune", &openAPIOperation{
+		OperationID: "pruneOrphans", Summary: "Remove orphaned resources", Tags: []string{"admin"}, Permission: "admin:write",
+		Description: "Removes orphaned containers and deletes sandboxes whose container is gone.",
+		Parameters:  []openAPIParameter{queryParam("dry_run", "Only report what would be removed", booleanSchema())},
+		Responses:   map[string]*openAPIResponse{"200": dataResponse("What was removed, with per-resource errors", orphanReportSchema)},
+		errors:      []int{http.StatusBadRequest},
+	})
+	b.add("POST", "/api/v1/admin/providers/reconcile", &openAPIOperation{
+		OperationID: "reconcileProviders", Summary: "Deprovision service resources of deleted sandboxes", Tags: []string{"admin"}, Permission: "admin:write",
+		Description: "Lists the databases, key prefixes and buckets each service provider holds and deprovisions those whose sandbox no longer exists. A provider that can't be listed is reported with its error and skipped.",
+		Parameters:  []openAPIParameter{queryParam("dry_run", "Only report what would be deprovisioned", booleanSchema())},
+		Responses:   map[string]*openAPIResponse{"200": dataResponse("The orphans of each provider, with per-resource errors", schemaFor[models.ProviderReconcileReport](g))},
+		errors:      []int{http.StatusBadRequest},
+	})
+
+	// Clients
+
+	b.add("GET", "/api/v1/clients", &openAPIOperation{
+		OperationID: "listClients", Summary: "List API clients", Tags: []string{"clients"}, Permission: "clients:admin",
+		Responses: map[string]*openAPIResponse{"200": dataResponse("The clients, keys masked", listOf("clients", clientSchema))},
+	})
+	b.add("POST", "/api/v1/clients", &openAPIOperation{
+		OperationID: "createClient", Summary: "Create an API client", Tags: []string{"clients"}, Permission: "clients:admin",
+		RequestBody: jsonBody(schemaFor[models.CreateClientRequest](g), true),
+		Responses:   map[string]*openAPIResponse{"201": dataResponse("The client with its full key, shown only here", clientSchema)},
+		errors:      []int{http.StatusBadRequest},
+	})
+	b.add("PATCH", "/api/v1/clients/{id}", &openAPIOperation{
+		OperationID: "updateClient", Summary: "Update an API client", Tags: []string{"clients"}, Permission: "clients:admin",
+		RequestBody: jsonBody(schemaFor[models.UpdateClientRequest](g), true),
+		Responses:   map[string]*openAPIResponse{"200": dataResponse("The client, key masked", clientSchema)},
+		errors:      []int{http.StatusBadRequest, http.StatusNotFound},
+	})
+	b.add("DELETE", "/api/v1/clients/{id}", &openAPIOperation{
+		OperationID: "deactivateClient", Summary: "Deactivate an API client", Tags: []string{"clients"}, Permission: "clients:admin",
+		Responses: map[string]*openAPIResponse{"200": dataResponse("The client was deactivated", messageSchema())},
+		errors:    []int{http.StatusBadRequest, http.StatusNotFound},
+	})
+	b.add("POST", "/api/v1/clients/{id}/rotate", &openAPIOperation{
+		OperationID: "rotateClientKey", Summary: "Issue a new key for an API client", Tags: []string{"clients"}, Permission: "clients:admin",
+		Description: "The old key stops working immediately.",
+		Responses:   map[string]*openAPIResponse{"200": dataResponse("The client with its new key, shown only here", clientSchema)},
+		errors:      []int{http.StatusBadRequest, http.StatusNotFound},
+	})
+
+	// Docs
+
+	b.add("GET", "/api/v1/openapi.json", &openAPIOperation{
+		OperationID: "getOpenAPI", Summary: "This document", Tags: []string{"docs"}, auth: authPublic,
+		Responses: map[string]*openAPIResponse{
+			"200": {Description: "The OpenAPI document", Content: map[string]openAPIMediaType{"application/json": {Schema: &jsonSchema{Type: "object"}}}},
+		},
+	})
+	b.add("GET", "/api/v1/docs", &openAPIOperation{
+		OperationID: "getAPIDocs", Summary: "Browsable API reference", Tags: []string{"docs"}, auth: authPublic,
+		Responses: map[string]*openAPIResponse{
+			"200": {Description: "An HTML page rendering this document", Content: map[string]openAPIMediaType{"text/html": {Schema: stringSchema()}}},
+		},
+	})
+
+	b.doc.Components = openAPIComponents{
+		Schemas: g.schemas,
+		SecuritySchemes: map[string]openAPISecurityScheme{
+			"bearerAuth":   {Type: "http", Scheme: "bearer", Description: "An API key (sk_...) or, when enabled, a JWT"},
+			"apiKeyHeader": {Type: "apiKey", In: "header", Name: "X-API-Key", Description: "An API key"},
+			"apiKeyQuery":  {Type: "apiKey", In: "query", Name: "token", Description: "An API key, for WebSockets and other clients that can't set headers"},
+			"sessionToken": {Type: "apiKey", In: "query", Name: "session_token", Description: "The token of the session that owns the sandbox"},
+		},
+	}
+	return b.doc
+}
+
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	spec, err := openAPISpec()
+	if err != nil {
+		slog.Error("failed to encode openapi document", "error", err)
+		respondError(w, http.StatusInternalServerError, "internal_error", "failed to build API document")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(spec)
+}
+
+// apiDocsPage renders openapi.json with Redoc
+const apiDocsPage = `<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>Sandbox Engine API</title>
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+</head>
+<body>
+  <redoc spec-url="openapi.json"></redoc>
+  <script src="https://cdn.redoc.ly/redoc/v2.1.5/bundles/redoc.standalone.js"></script>
+</body>
+</html>
+`
+
+func (s *Server) handleAPIDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(apiDocsPage))
+}
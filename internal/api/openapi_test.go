@@ -0,0 +1,424 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+// validateJSON checks v, decoded with UseNumber, against s
+func validateJSON(doc *openAPIDocument, s *jsonSchema, v any, at string) error {
+	if s.Ref != "" {
+		target := doc.Components.Schemas[strings.TrimPrefix(s.Ref, schemaRefPrefix)]
+		if target == nil {
+			return fmt.Errorf("%s: unresolved %s", at, s.Ref)
+		}
+		return validateJSON(doc, target, v, at)
+	}
+	if len(s.AnyOf) > 0 {
+		var errs []string
+		for _, alt := range s.AnyOf {
+			err := validateJSON(doc, alt, v, at)
+			if err == nil {
+				return nil
+			}
+			errs = append(errs, err.Error())
+		}
+		return fmt.Errorf("%s: matches no alternative (%s)", at, strings.Join(errs, "; "))
+	}
+	if s.Type != nil && !typeMatches(s.Type, v) {
+		return fmt.Errorf("%s: expected %v, got %T", at, s.Type, v)
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s: missing required %q", at, name)
+			}
+		}
+		for key, val := range v {
+			if prop, ok := s.Properties[key]; ok {
+				if err := validateJSON(doc, prop, val, at+"."+key); err != nil {
+					return err
+				}
+				continue
+			}
+			switch extra := s.AdditionalProperties.(type) {
+			case bool:
+				if !extra {
+					return fmt.Errorf("%s: unexpected property %q", at, key)
+				}
+			case *jsonSchema:
+				if err := validateJSON(doc, extra, val, at+"."+key); err != nil {
+					return err
+				}
+			}
+		}
+	case []any:
+		if s.Items != nil {
+			for i, item := range v {
+				if err := validateJSON(doc, s.Items, item, at+"["+strconv.Itoa(i)+"]"); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func typeMatches(typ any, v any) bool {
+	var types []string
+	switch typ := typ.(type) {
+	case string:
+		types = []string{typ}
+	case []string:
+		types = typ
+	}
+	for _, t := range types {
+		switch v := v.(type) {
+		case nil:
+			if t == "null" {
+				return true
+			}
+		case bool:
+			if t == "boolean" {
+				return true
+			}
+		case string:
+			if t == "string" {
+				return true
+			}
+		case json.Number:
+			if t == "number" || (t == "integer" && !strings.ContainsAny(v.String(), ".eE")) {
+				return true
+			}
+		case []any:
+			if t == "array" {
+				return true
+			}
+		case map[string]any:
+			if t == "object" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeJSON(t *testing.T, data []byte) any {
+	t.Helper()
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// responseSchema is the documented JSON schema of a response
+func responseSchema(t *testing.T, doc *openAPIDocument, method, path string, status int) *jsonSchema {
+	t.Helper()
+	op := doc.Paths[path][strings.ToLower(method)]
+	if op == nil {
+		t.Fatalf("%s %s is not documented", method, path)
+	}
+	resp := op.Responses[strconv.Itoa(status)]
+	if resp == nil {
+		t.Fatalf("%s %s doesn't document status %d", method, path, status)
+	}
+	return resp.Content["application/json"].Schema
+}
+
+// fillExample sets every field reachable from v to a non-zero value, so
+// optional fields and nested types are checked too
+func fillExample(v reflect.Value, depth int) {
+	if depth > 6 {
+		return
+	}
+	if v.Type() == timeType {
+		v.Set(reflect.ValueOf(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)))
+		return
+	}
+	switch v.Kind() {
+	case reflect.Pointer:
+		v.Set(reflect.New(v.Type().Elem()))
+		fillExample(v.Elem(), depth+1)
+	case reflect.Struct:
+		for i := range v.NumField() {
+			if f := v.Field(i); f.CanSet() {
+				fillExample(f, depth+1)
+			}
+		}
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			v.SetBytes([]byte("x"))
+			return
+		}
+		v.Set(reflect.MakeSlice(v.Type(), 1, 1))
+		fillExample(v.Index(0), depth+1)
+	case reflect.Map:
+		m := reflect.MakeMap(v.Type())
+		val := reflect.New(v.Type().Elem()).Elem()
+		fillExample(val, depth+1)
+		m.SetMapIndex(reflect.ValueOf("key").Convert(v.Type().Key()), val)
+		v.Set(m)
+	case reflect.String:
+		v.SetString("x")
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(1)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(1)
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(1.5)
+	case reflect.Interface:
+		v.Set(reflect.ValueOf("x"))
+	}
+}
+
+func TestOpenAPICoversRoutes(t *testing.T) {
+	doc := buildOpenAPI(newSchemaGenerator())
+	router := newMemoryServer(t).(chi.Routes)
+
+	routes := make(map[string]bool)
+	err := chi.Walk(router, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		if route == "/metrics" {
+			return nil // Prometheus exposition, not part of the API
+		}
+		if len(route) > 1 {
+			route = strings.TrimSuffix(route, "/")
+		}
+		routes[method+" "+route] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	documented := make(map[string]bool)
+	for path, item := range doc.Paths {
+		for method := range item {
+			documented[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+
+	var missing, stale []string
+	for route := range routes {
+		if !documented[route] {
+			missing = append(missing, route)
+		}
+	}
+	for route := range documented {
+		if !routes[route] {
+			stale = append(stale, route)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(stale)
+	if len(missing) > 0 {
+		t.Errorf("routes missing from the OpenAPI document: %v", missing)
+	}
+	if len(stale) > 0 {
+		t.Errorf("documented operations with no route: %v", stale)
+	}
+}
+
+func TestOpenAPISchemasMatchTypes(t *testing.T) {
+	g := newSchemaGenerator()
+	doc := buildOpenAPI(g)
+
+	if len(g.names) < 20 {
+		t.Fatalf("expected the document to reference the response types, got %d schemas", len(g.names))
+	}
+	for typ, name := range g.names {
+		ref := &jsonSchema{Ref: schemaRefPrefix + name}
+		t.Run(name, func(t *testing.T) {
+			for _, example := range []struct {
+				kind  string
+				value reflect.Value
+			}{
+				{"zero", reflect.New(typ)},
+				{"filled", func() reflect.Value {
+					v := reflect.New(typ)
+					fillExample(v.Elem(), 0)
+					return v
+				}()},
+			} {
+				data, err := json.Marshal(example.value.Interface())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := validateJSON(doc, ref, decodeJSON(t, data), name); err != nil {
+					t.Errorf("%s example: %v\n%s", example.kind, err, data)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateJSONRejectsDrift(t *testing.T) {
+	doc := buildOpenAPI(newSchemaGenerator())
+	ref := &jsonSchema{Ref: schemaRefPrefix + "Domain"}
+
+	valid := map[string]any{"id": "demo", "name": "Demo", "projects_count": json.Number("1"), "tasks_count": json.Number("1")}
+	if err := validateJSON(doc, ref, valid, "domain"); err != nil {
+		t.Fatalf("expected a valid domain, got %v", err)
+	}
+
+	for name, mutate := range map[string]func(map[string]any){
+		"unknown field":    func(m map[string]any) { m["owner"] = "x" },
+		"missing required": func(m map[string]any) { delete(m, "tasks_count") },
+		"wrong type":       func(m map[string]any) { m["projects_count"] = "1" },
+	} {
+		m := make(map[string]any, len(valid))
+		for k, v := range valid {
+			m[k] = v
+		}
+		mutate(m)
+		if err := validateJSON(doc, ref, m, "domain"); err == nil {
+			t.Errorf("%s: expected a validation error", name)
+		}
+	}
+}
+
+func TestOpenAPIGoldenResponses(t *testing.T) {
+	doc := buildOpenAPI(newSchemaGenerator())
+
+	documented := map[string]string{
+		"sandbox":  "/api/v1/sandboxes/{id}",
+		"services": "/api/v1/sandboxes/{id}/services",
+		"events":   "/api/v1/sandboxes/{id}/events",
+		"session":  "/api/v1/sessions/{id}",
+		"template": "/api/v1/templates/{name}",
+		"domains":  "/api/v1/catalog/domains",
+		"project":  "/api/v1/catalog/domains/{domainId}/projects/{projectName}",
+		"task":     "/api/v1/catalog/domains/{domainId}/projects/{projectName}/tasks/{taskCode}",
+	}
+	for name, path := range documented {
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", "golden", name+".v1.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			schema := responseSchema(t, doc, http.MethodGet, path, http.StatusOK)
+			if err := validateJSON(doc, schema, decodeJSON(t, data), "response"); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestOpenAPILiveResponses(t *testing.T) {
+	doc := buildOpenAPI(newSchemaGenerator())
+	router := newMemoryServer(t)
+
+	send := func(method, path string, body any) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if body != nil {
+			if err := json.NewEncoder(&buf).Encode(body); err != nil {
+				t.Fatal(err)
+			}
+		}
+		req := httptest.NewRequest(method, path, &buf)
+		req.Header.Set("Authorization", "Bearer "+memoryTestKey)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	check := func(rec *httptest.ResponseRecorder, method, route string, status int) any {
+		t.Helper()
+		if rec.Code != status {
+			t.Fatalf("%s %s: expected %d, got %d: %s", method, route, status, rec.Code, rec.Body)
+		}
+		v := decodeJSON(t, rec.Body.Bytes())
+		if err := validateJSON(doc, responseSchema(t, doc, method, route, status), v, method+" "+route); err != nil {
+			t.Error(err)
+		}
+		return v
+	}
+
+	rec := send(http.MethodPost, "/api/v1/sandboxes", models.CreateRequest{TemplateID: "demo-shop", UserID: "user-1", Metadata: map[string]string{"team": "a"}})
+	created := check(rec, http.MethodPost, "/api/v1/sandboxes", http.StatusCreated)
+	sandboxID := created.(map[string]any)["data"].(map[string]any)["id"].(string)
+
+	session := check(send(http.MethodPost, "/api/v1/sessions", models.CreateSessionRequest{TemplateID: "demo-shop", TTL: 3600}), http.MethodPost, "/api/v1/sessions", http.StatusCreated)
+	sessionData := session.(map[string]any)["data"].(map[string]any)
+
+	tests := []struct {
+		method, path, route string
+		body                any
+		status              int
+	}{
+		{http.MethodGet, "/health", "/health", nil, http.StatusOK},
+		{http.MethodGet, "/ready", "/ready", nil, http.StatusServiceUnavailable}, // no Docker
+		{http.MethodGet, "/health/details", "/health/details", nil, http.StatusOK},
+		{http.MethodGet, "/version", "/version", nil, http.StatusOK},
+		{http.MethodGet, "/api/v1/sandboxes", "/api/v1/sandboxes", nil, http.StatusOK},
+		{http.MethodGet, "/api/v1/sandboxes/" + sandboxID, "/api/v1/sandboxes/{id}", nil, http.StatusOK},
+		{http.MethodGet, "/api/v1/sandboxes/" + sandboxID + "/events", "/api/v1/sandboxes/{id}/events", nil, http.StatusOK},
+		{http.MethodPost, "/api/v1/sandboxes/" + sandboxID + "/grade", "/api/v1/sandboxes/{id}/grade", nil, http.StatusConflict},
+		{http.MethodGet, "/api/v1/sandboxes/" + sandboxID + "/grade", "/api/v1/sandboxes/{id}/grade", nil, http.StatusOK},
+		{http.MethodGet, "/api/v1/sandboxes/missing", "/api/v1/sandboxes/{id}", nil, http.StatusNotFound},
+		{http.MethodPost, "/api/v1/sandboxes", "/api/v1/sandboxes", models.CreateRequest{}, http.StatusBadRequest},
+		{http.MethodGet, "/api/v1/sessions", "/api/v1/sessions", nil, http.StatusOK},
+		{http.MethodGet, "/api/v1/sessions/" + sessionData["id"].(string), "/api/v1/sessions/{id}", nil, http.StatusOK},
+		{http.MethodGet, "/api/v1/join/" + sessionData["token"].(string), "/api/v1/join/{token}", nil, http.StatusOK},
+		{http.MethodGet, "/api/v1/templates", "/api/v1/templates", nil, http.StatusOK},
+		{http.MethodPost, "/api/v1/templates/validate", "/api/v1/templates/validate", map[string]any{"name": "x", "base_image": "alpine"}, http.StatusOK},
+		{http.MethodGet, "/api/v1/templates/validation", "/api/v1/templates/validation", nil, http.StatusOK},
+		{http.MethodPost, "/api/v1/templates", "/api/v1/templates", map[string]any{"name": "api-x", "base_image": "alpine"}, http.StatusCreated},
+		{http.MethodPut, "/api/v1/templates/demo-shop", "/api/v1/templates/{name}", map[string]any{"name": "demo-shop", "base_image": "alpine"}, http.StatusConflict},
+		{http.MethodDelete, "/api/v1/templates/api-x", "/api/v1/templates/{name}", nil, http.StatusOK},
+		{http.MethodGet, "/api/v1/catalog/domains/demo", "/api/v1/catalog/domains/{domainId}", nil, http.StatusOK},
+		{http.MethodGet, "/api/v1/catalog/domains/demo/projects", "/api/v1/catalog/domains/{domainId}/projects", nil, http.StatusOK},
+		{http.MethodGet, "/api/v1/catalog/domains/demo/projects/shop/tasks", "/api/v1/catalog/domains/{domainId}/projects/{projectName}/tasks", nil, http.StatusOK},
+		{http.MethodPost, "/api/v1/clients", "/api/v1/clients", models.CreateClientRequest{Name: "ci", Permissions: []string{"sandboxes:read"}}, http.StatusCreated},
+		{http.MethodGet, "/api/v1/clients", "/api/v1/clients", nil, http.StatusOK},
+	}
+	for _, tt := range tests {
+		check(send(tt.method, tt.path, tt.body), tt.method, tt.route, tt.status)
+	}
+}
+
+func TestOpenAPIEndpoints(t *testing.T) {
+	router := newMemoryServer(t)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/openapi.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 without credentials, got %d", rec.Code)
+	}
+	var doc struct {
+		OpenAPI string                     `json:"openapi"`
+		Paths   map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.OpenAPI != "3.1.0" || doc.Paths["/api/v1/sandboxes"] == nil {
+		t.Errorf("unexpected document: openapi %q with %d paths", doc.OpenAPI, len(doc.Paths))
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/docs", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `spec-url="openapi.json"`) {
+		t.Errorf("expected the docs page, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/terra-clan/sandbox-engine/internal/config"
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+// rateLimitMetadataKey in api_clients.metadata overrides the global limit
+// for one client: "<rps>" or "<rps>/<burst>", where an rps of 0 is unlimited
+const rateLimitMetadataKey = "rate_limit"
+
+// rateLimitKeyPrefix namespaces bucket keys in the shared Redis
+const rateLimitKeyPrefix = "sandbox-engine:ratelimit:"
+
+// rateLimit is a token bucket: Rate tokens per second, holding at most Burst
+type rateLimit struct {
+	Rate  int
+	Burst int
+}
+
+// rateLimitStore takes one token from the bucket at key, returning whether
+// the request is allowed and, if not, how long until a token is available
+type rateLimitStore interface {
+	take(ctx context.Context, key string, limit rateLimit) (bool, time.Duration, error)
+}
+
+// RateLimiter limits requests per authenticated client, or per IP on public
+// routes. Buckets live in Redis so the limit holds across engine replicas.
+type RateLimiter struct {
+	store  rateLimitStore
+	global rateLimit
+}
+
+// NewRateLimiter returns a limiter backed by client. With a zero RPS the
+// global limit is off, but clients with a rate_limit override are still limited.
+func NewRateLimiter(client *redis.Client, cfg config.RateLimitConfig) *RateLimiter {
+	return newRateLimiter(&redisRateLimitStore{client: client}, cfg)
+}
+
+func newRateLimiter(store rateLimitStore, cfg config.RateLimitConfig) *RateLimiter {
+	burst := cfg.Burst
+	if burst < cfg.RPS {
+		burst = cfg.RPS
+	}
+	return &RateLimiter{
+		store:  store,
+		global: rateLimit{Rate: cfg.RPS, Burst: burst},
+	}
+}
+
+// ByClient limits requests by the authenticated client; it must run after
+// AuthMiddleware.Authenticate
+func (l *RateLimiter) ByClient(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := ClientFromContext(r.Context())
+		if client == nil {
+			l.limit(w, r, next, "ip:"+clientIP(r), l.global)
+			return
+		}
+		l.limit(w, r, next, "client:"+strconv.Itoa(client.ID), l.clientLimit(client))
+	})
+}
+
+// ByIP limits requests by remote address, for routes without an API key
+func (l *RateLimiter) ByIP(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l.limit(w, r, next, "ip:"+clientIP(r), l.global)
+	})
+}
+
+// limit takes a token for key and serves the request or rejects it with 429.
+// Redis errors let the request through: an outage shouldn't take the API down.
+func (l *RateLimiter) limit(w http.ResponseWriter, r *http.Request, next http.Handler, key string, limit rateLimit) {
+	if limit.Rate <= 0 {
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	allowed, retryAfter, err := l.store.take(r.Context(), rateLimitKeyPrefix+key, limit)
+	if err != nil {
+		slog.Warn("rate limit check failed, allowing request", "error", err, "key", key)
+		next.ServeHTTP(w, r)
+		return
+	}
+	if !allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		respondError(w, http.StatusTooManyRequests, "rate_limited", "too many requests, retry later")
+		return
+	}
+	next.ServeHTTP(w, r)
+}
+
+// clientLimit returns the client's rate_limit override, or the global limit
+func (l *RateLimiter) clientLimit(client *models.ApiClient) rateLimit {
+	value, ok := client.Metadata[rateLimitMetadataKey]
+	if !ok {
+		return l.global
+	}
+	limit, err := parseRateLimit(value)
+	if err != nil {
+		slog.Warn("invalid client rate_limit, using the global limit", "client", client.Name, "value", value, "error", err)
+		return l.global
+	}
+	return limit
+}
+
+// parseRateLimit parses "<rps>" or "<rps>/<burst>"; the burst defaults to the rate
+func parseRateLimit(value string) (rateLimit, error) {
+	rateStr, burstStr, hasBurst := strings.Cut(value, "/")
+	rate, err := strconv.Atoi(strings.TrimSpace(rateStr))
+	if err != nil || rate < 0 {
+		return rateLimit{}, fmt.Errorf("rate must be a non-negative integer")
+	}
+	burst := rate
+	if hasBurst {
+		burst, err = strconv.Atoi(strings.TrimSpace(burstStr))
+		if err != nil || burst < rate {
+			return rateLimit{}, fmt.Errorf("burst must be an integer no smaller than the rate")
+		}
+	}
+	return rateLimit{Rate: rate, Burst: burst}, nil
+}
+
+// clientIP returns the request's remote IP (already resolved by realIP)
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// tokenBucketScript refills the bucket by the time elapsed since its last
+// request and takes a token. It reads the clock from Redis, so replicas with
+// skewed clocks share one timeline. Returns {allowed, seconds until a token}.
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) + tonumber(t[2]) / 1000000
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = (1 - tokens) / rate
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+redis.call('EXPIRE', KEYS[1], math.ceil(burst / rate) + 1)
+return {allowed, tostring(wait)}
+`)
+
+// redisRateLimitStore keeps token buckets in Redis
+type redisRateLimitStore struct {
+	client *redis.Client
+}
+
+func (s *redisRateLimitStore) take(ctx context.Context, key string, limit rateLimit) (bool, time.Duration, error) {
+	res, err := tokenBucketScript.Run(ctx, s.client, []string{key}, limit.Rate, limit.Burst).Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	if len(res) != 2 {
+		return false, 0, fmt.Errorf("unexpected rate limit result %v", res)
+	}
+	allowed, _ := res[0].(int64)
+	waitStr, _ := res[1].(string)
+	wait, err := strconv.ParseFloat(waitStr, 64)
+	if err != nil {
+		return false, 0, fmt.Errorf("unexpected rate limit wait %v", res[1])
+	}
+	return allowed == 1, time.Duration(wait * float64(time.Second)), nil
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/config"
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+// countingStore allows limit.Burst requests per key and never refills
+type countingStore struct {
+	taken map[string]int
+	err   error
+}
+
+func (s *countingStore) take(ctx context.Context, key string, limit rateLimit) (bool, time.Duration, error) {
+	if s.err != nil {
+		return false, 0, s.err
+	}
+	if s.taken[key] >= limit.Burst {
+		return false, 1500 * time.Millisecond, nil
+	}
+	s.taken[key]++
+	return true, 0, nil
+}
+
+func TestRateLimiterByClient(t *testing.T) {
+	store := &countingStore{taken: make(map[string]int)}
+	limiter := newRateLimiter(store, config.RateLimitConfig{RPS: 1, Burst: 2})
+	handler := limiter.ByClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	send := func(client *models.ApiClient) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/sandboxes", nil)
+		req = req.WithContext(ContextWithClient(req.Context(), client))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	limited := &models.ApiClient{ID: 1, Name: "limited"}
+	for i := 0; i < 2; i++ {
+		if rec := send(limited); rec.Code != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d", i, rec.Code)
+		}
+	}
+	rec := send(limited)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "2" {
+		t.Errorf("expected 429 with Retry-After 2, got %d with %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	// Buckets are per client, and the metadata override replaces the global limit
+	if rec := send(&models.ApiClient{ID: 2}); rec.Code != http.StatusOK {
+		t.Errorf("another client: expected 200, got %d", rec.Code)
+	}
+	unlimited := &models.ApiClient{ID: 3, Metadata: map[string]string{rateLimitMetadataKey: "0"}}
+	for i := 0; i < 5; i++ {
+		if rec := send(unlimited); rec.Code != http.StatusOK {
+			t.Fatalf("unlimited client: expected 200, got %d", rec.Code)
+		}
+	}
+
+	// A Redis outage lets requests through
+	store.err = errors.New("connection refused")
+	if rec := send(limited); rec.Code != http.StatusOK {
+		t.Errorf("store error: expected 200, got %d", rec.Code)
+	}
+}
+
+func TestRateLimiterByIP(t *testing.T) {
+	store := &countingStore{taken: make(map[string]int)}
+	limiter := newRateLimiter(store, config.RateLimitConfig{RPS: 1})
+	handler := limiter.ByIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	codes := make([]int, 0, 3)
+	for _, addr := range []string{"10.0.0.1:5000", "10.0.0.1:5001", "10.0.0.2:5000"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/join/tok", nil)
+		req.RemoteAddr = addr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		codes = append(codes, rec.Code)
+	}
+	if codes[0] != http.StatusOK || codes[1] != http.StatusTooManyRequests || codes[2] != http.StatusOK {
+		t.Errorf("expected the second request from 10.0.0.1 limited, got %v", codes)
+	}
+
+	// A nil limiter (disabled) passes requests through
+	var disabled *RateLimiter
+	rec := httptest.NewRecorder()
+	disabled.ByIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("disabled limiter: expected 200, got %d", rec.Code)
+	}
+}
+
+func TestParseRateLimit(t *testing.T) {
+	for value, want := range map[string]rateLimit{
+		"5":    {Rate: 5, Burst: 5},
+		"5/20": {Rate: 5, Burst: 20},
+		"0":    {},
+	} {
+		if got, err := parseRateLimit(value); err != nil || got != want {
+			t.Errorf("parseRateLimit(%q) = %+v, %v; want %+v", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"", "fast", "-1", "5/2", "5/x"} {
+		if _, err := parseRateLimit(value); err == nil {
+			t.Errorf("parseRateLimit(%q): expected an error", value)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// realIP replaces RemoteAddr with the client address a trusted proxy
+// forwarded, like middleware.RealIP, but only for requests whose peer is in
+// one of trusted. Anyone else could send the headers to pick the address
+// rate limits and API key allowlists see, so theirs are ignored.
+func realIP(trusted []string) func(http.Handler) http.Handler {
+	var prefixes []netip.Prefix
+	for _, cidr := range trusted {
+		// Config validation has rejected malformed entries
+		if prefix, err := netip.ParsePrefix(cidr); err == nil {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	isTrusted := func(addr netip.Addr) bool {
+		addr = addr.Unmap()
+		for _, prefix := range prefixes {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if peer, err := netip.ParseAddr(clientIP(r)); err == nil && isTrusted(peer) {
+				if ip := forwardedIP(r, isTrusted); ip != "" {
+					r.RemoteAddr = ip
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// forwardedIP returns the client address of a request from a trusted proxy:
+// the last X-Forwarded-For entry not itself a trusted proxy, since earlier
+// ones were written by the client, else X-Real-IP
+func forwardedIP(r *http.Request, isTrusted func(netip.Addr) bool) string {
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			return ""
+		}
+		if !isTrusted(addr) || i == 0 {
+			return addr.String()
+		}
+	}
+
+	if addr, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return addr.String()
+	}
+	return ""
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/sandbox"
+)
+
+// canAccessRecording applies the sandbox ownership rule to a recording, which
+// keeps the owner of its sandbox for when the sandbox is gone
+func canAccessRecording(r *http.Request, rec *models.TerminalRecording) bool {
+	return canAccessSandbox(r, &models.Sandbox{OwnerClientID: rec.OwnerClientID})
+}
+
+// handleListRecordings lists the terminal recordings of a sandbox, also after
+// the sandbox is cleaned up
+func (s *Server) handleListRecordings(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	recs, err := s.sandboxManager.ListRecordings(r.Context(), id)
+	if err != nil {
+		slog.Error("failed to list recordings", "error", err, "id", id)
+		respondError(w, http.StatusInternalServerError, "internal_error", "failed to list recordings")
+		return
+	}
+
+	visible := make([]*models.TerminalRecording, 0, len(recs))
+	for _, rec := range recs {
+		if canAccessRecording(r, rec) {
+			visible = append(visible, rec)
+		}
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"recordings": visible,
+		"total":      len(visible),
+	})
+}
+
+// handleGetRecording downloads a terminal recording as an asciicast v2 file
+func (s *Server) handleGetRecording(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	recordingID, err := strconv.ParseInt(chi.URLParam(r, "recordingId"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "validation_error", "recording id must be a number")
+		return
+	}
+
+	rec, f, err := s.sandboxManager.OpenRecording(r.Context(), id, recordingID)
+	if err != nil {
+		if errors.Is(err, sandbox.ErrRecordingNotFound) {
+			respondError(w, http.StatusNotFound, "not_found", "recording not found")
+			return
+		}
+		slog.Error("failed to open recording", "error", err, "id", id, "recording_id", recordingID)
+		respondError(w, http.StatusInternalServerError, "internal_error", "failed to get recording")
+		return
+	}
+	defer f.Close()
+	if !canAccessRecording(r, rec) {
+		respondError(w, http.StatusNotFound, "not_found", "recording not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-asciicast")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("%s-%d.cast", rec.SandboxID, rec.ID)))
+	http.ServeContent(w, r, "", rec.StartedAt, f)
+}
@@ -0,0 +1,231 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/sandbox"
+	"github.com/terra-clan/sandbox-engine/internal/templates"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden response files")
+
+// responseManager serves fixed sandboxes and sessions to the handlers
+type responseManager struct {
+	sandbox.Manager
+	sandboxes map[string]*models.Sandbox
+	sessions  map[string]*models.Session
+}
+
+func (m *responseManager) Get(ctx context.Context, id string) (*models.Sandbox, error) {
+	if sb, ok := m.sandboxes[id]; ok {
+		return sb, nil
+	}
+	return nil, sandbox.ErrSandboxNotFound
+}
+
+func (m *responseManager) GetServices(ctx context.Context, id string) ([]*models.ServiceInstance, error) {
+	sb, err := m.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	var services []*models.ServiceInstance
+	for _, svc := range sb.Services {
+		services = append(services, svc)
+	}
+	return services, nil
+}
+
+func (m *responseManager) GetSessionByID(ctx context.Context, id string) (*models.Session, error) {
+	if s, ok := m.sessions[id]; ok {
+		return s, nil
+	}
+	return nil, sandbox.ErrSessionNotFound
+}
+
+func (m *responseManager) ListEvents(ctx context.Context, id string) ([]*models.SandboxEvent, error) {
+	sb, err := m.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return []*models.SandboxEvent{
+		{ID: 1, SandboxID: sb.ID, Type: models.EventCreated, Message: "template demo-shop on host default", CreatedAt: sb.CreatedAt},
+		{ID: 2, SandboxID: sb.ID, Type: models.EventStarted, CreatedAt: *sb.StartedAt},
+	}, nil
+}
+
+func (m *responseManager) ImageReady(ctx context.Context, image string) bool { return true }
+
+func (m *responseManager) TemplateUsageSummary(ctx context.Context, templateID string) *models.UsageSummary {
+	lastUsed := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	return &models.UsageSummary{LastUsedAt: &lastUsed, Usage30d: 12}
+}
+
+func (m *responseManager) TaskUsageSummary(ctx context.Context, taskID string) *models.UsageSummary {
+	return nil
+}
+
+func newResponseRouter(t *testing.T) http.Handler {
+	t.Helper()
+
+	loader := templates.NewLoader()
+	if err := loader.LoadFromDir(filepath.Join("testdata", "catalog")); err != nil {
+		t.Fatal(err)
+	}
+
+	created := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	started := created.Add(20 * time.Second)
+
+	manager := &responseManager{
+		sandboxes: map[string]*models.Sandbox{
+			"sb-running": {
+				ID:          "sb-running",
+				TemplateID:  "demo-shop",
+				UserID:      "user-1",
+				Status:      models.StatusRunning,
+				CreatedAt:   created,
+				StartedAt:   &started,
+				ExpiresAt:   created.Add(2 * time.Hour),
+				ContainerID: "c0ffee",
+				Host:        "default",
+				Services: map[string]*models.ServiceInstance{
+					"postgres": {
+						Name:        "postgres",
+						Type:        "postgres",
+						Status:      models.ServiceStatusReady,
+						Credentials: &models.ServiceCredentials{Host: "db", Port: 5432, Username: "sb", Password: "secret", Database: "sb"},
+						CreatedAt:   created,
+					},
+				},
+				Endpoints: map[string]string{"main": "https://sb-running.example.com"},
+				Metadata:  map[string]string{},
+			},
+		},
+		sessions: map[string]*models.Session{
+			"sess-ready": {
+				ID:         "sess-ready",
+				Token:      "tok",
+				TemplateID: "demo-shop",
+				Status:     models.SessionReady,
+				TTLSeconds: 3600,
+				// Empty collections must not show up in v1 responses
+				Env:       map[string]string{},
+				Services:  []string{},
+				CreatedAt: created,
+				CreatedBy: "terra-admin",
+			},
+		},
+	}
+
+	s := &Server{sandboxManager: manager, templateLoader: loader, terminals: newTerminalLimiter(0, 0, nil)}
+
+	r := chi.NewRouter()
+	r.Use(apiStyleMiddleware)
+	r.Get("/sandboxes/{id}", s.handleGetSandbox)
+	r.Get("/sandboxes/{id}/services", s.handleListServices)
+	r.Get("/sandboxes/{id}/events", s.handleListEvents)
+	r.Get("/sessions/{id}", s.handleGetSession)
+	r.Get("/templates/{name}", s.handleGetTemplate)
+	r.Get("/catalog/domains", s.handleListDomains)
+	r.Get("/catalog/domains/{domainId}/projects/{projectName}", s.handleGetProject)
+	r.Get("/catalog/domains/{domainId}/projects/{projectName}/tasks/{taskCode}", s.handleGetTask)
+	return r
+}
+
+func TestResponseGolden(t *testing.T) {
+	router := newResponseRouter(t)
+
+	paths := map[string]string{
+		"sandbox":  "/sandboxes/sb-running",
+		"services": "/sandboxes/sb-running/services",
+		"events":   "/sandboxes/sb-running/events",
+		"session":  "/sessions/sess-ready",
+		"template": "/templates/demo-shop",
+		"domains":  "/catalog/domains",
+		"project":  "/catalog/domains/demo/projects/shop",
+		"task":     "/catalog/domains/demo/projects/shop/tasks/checkout",
+	}
+
+	for name, path := range paths {
+		for _, style := range []apiStyle{styleV1, styleLegacy} {
+			t.Run(name+"/"+string(style), func(t *testing.T) {
+				req := httptest.NewRequest(http.MethodGet, path+"?api_style="+string(style), nil)
+				rec := httptest.NewRecorder()
+				router.ServeHTTP(rec, req)
+
+				if rec.Code != http.StatusOK {
+					t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+				}
+
+				var indented bytes.Buffer
+				if err := json.Indent(&indented, rec.Body.Bytes(), "", "  "); err != nil {
+					t.Fatal(err)
+				}
+				indented.WriteByte('\n')
+
+				golden := filepath.Join("testdata", "golden", name+"."+string(style)+".json")
+				if *updateGolden {
+					if err := os.WriteFile(golden, indented.Bytes(), 0o644); err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+
+				want, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatalf("missing golden file (run with -update): %v", err)
+				}
+				if !bytes.Equal(indented.Bytes(), want) {
+					t.Errorf("response differs from %s:\n%s", golden, indented.String())
+				}
+			})
+		}
+	}
+}
+
+func TestAPIStyleSelection(t *testing.T) {
+	router := newResponseRouter(t)
+
+	tests := []struct {
+		name   string
+		query  string
+		accept string
+		status int
+		key    string // a catalog field only the chosen style renders
+	}{
+		{name: "default", status: http.StatusOK, key: "projects_count"},
+		{name: "query legacy", query: "?api_style=legacy", status: http.StatusOK, key: "projectsCount"},
+		{name: "accept legacy", accept: mediaTypeLegacy, status: http.StatusOK, key: "projectsCount"},
+		{name: "query wins over accept", query: "?api_style=v1", accept: mediaTypeLegacy, status: http.StatusOK, key: "projects_count"},
+		{name: "unknown style", query: "?api_style=v0", status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/catalog/domains"+tt.query, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("expected %d, got %d", tt.status, rec.Code)
+			}
+			if tt.key != "" && !bytes.Contains(rec.Body.Bytes(), []byte(`"`+tt.key+`"`)) {
+				t.Errorf("expected %q in %s", tt.key, rec.Body)
+			}
+		})
+	}
+}
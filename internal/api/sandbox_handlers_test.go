@@ -0,0 +1,171 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/sandbox"
+)
+
+// listManager pages through a fixed set of sandboxes
+type listManager struct {
+	sandbox.Manager
+	sandboxes []*models.Sandbox
+	filters   models.ListFilters // the last filters listed with
+}
+
+func (m *listManager) matching(filters models.ListFilters) []*models.Sandbox {
+	var matched []*models.Sandbox
+	for _, sb := range m.sandboxes {
+		if filters.UserID == "" || sb.UserID == filters.UserID {
+			matched = append(matched, sb)
+		}
+	}
+	return matched
+}
+
+func (m *listManager) List(ctx context.Context, filters models.ListFilters) ([]*models.Sandbox, error) {
+	m.filters = filters
+	matched := m.matching(filters)
+	if filters.Offset >= len(matched) {
+		return nil, nil
+	}
+	matched = matched[filters.Offset:]
+	if filters.Limit < len(matched) {
+		matched = matched[:filters.Limit]
+	}
+	return matched, nil
+}
+
+func (m *listManager) Count(ctx context.Context, filters models.ListFilters) (int, error) {
+	return len(m.matching(filters)), nil
+}
+
+func TestListSandboxesTotal(t *testing.T) {
+	manager := &listManager{}
+	for i := 0; i < 5; i++ {
+		manager.sandboxes = append(manager.sandboxes, &models.Sandbox{ID: fmt.Sprintf("sb-%d", i), UserID: "user-1"})
+	}
+	manager.sandboxes = append(manager.sandboxes, &models.Sandbox{ID: "sb-other", UserID: "user-2"})
+
+	s := &Server{sandboxManager: manager}
+
+	rec := httptest.NewRecorder()
+	s.handleListSandboxes(rec, httptest.NewRequest(http.MethodGet, "/sandboxes?user_id=user-1&limit=2&offset=4", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+
+	var body struct {
+		Data struct {
+			Sandboxes []json.RawMessage `json:"sandboxes"`
+			Total     int               `json:"total"`
+			Limit     int               `json:"limit"`
+			Offset    int               `json:"offset"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+
+	// The last page holds one sandbox, but total counts every match
+	if len(body.Data.Sandboxes) != 1 || body.Data.Total != 5 || body.Data.Limit != 2 || body.Data.Offset != 4 {
+		t.Errorf("unexpected page %+v", body.Data)
+	}
+}
+
+func TestListSandboxesFilters(t *testing.T) {
+	manager := &listManager{}
+	s := &Server{sandboxManager: manager}
+
+	rec := httptest.NewRecorder()
+	s.handleListSandboxes(rec, httptest.NewRequest(http.MethodGet,
+		"/sandboxes?created_after=2026-03-01T00:00:00Z&created_before=2026-03-02T00:00:00Z&metadata.cohort=backend-2024&sort=expires_at&order=asc", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+
+	f := manager.filters
+	if !f.CreatedAfter.Equal(time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)) || !f.CreatedBefore.Equal(time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected creation range %v..%v", f.CreatedAfter, f.CreatedBefore)
+	}
+	if len(f.Metadata) != 1 || f.Metadata["cohort"] != "backend-2024" {
+		t.Errorf("unexpected metadata filter %v", f.Metadata)
+	}
+	if f.Sort != models.SortExpiresAt || !f.Ascending {
+		t.Errorf("unexpected sort %q ascending=%v", f.Sort, f.Ascending)
+	}
+
+	for _, query := range []string{
+		"created_after=yesterday",
+		"created_after=2026-03-02T00:00:00Z&created_before=2026-03-01T00:00:00Z",
+		"metadata.=x",
+		"sort=id",
+		"sort=created_at&order=up",
+	} {
+		rec := httptest.NewRecorder()
+		s.handleListSandboxes(rec, httptest.NewRequest(http.MethodGet, "/sandboxes?"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, rec.Code)
+		}
+	}
+}
+
+// startManager starts any sandbox; sandboxes missing from it are gone by
+// the time the started sandbox is read back
+type startManager struct {
+	sandbox.Manager
+	sandboxes map[string]*models.Sandbox
+}
+
+func (m *startManager) Start(ctx context.Context, id string) error {
+	return nil
+}
+
+func (m *startManager) Get(ctx context.Context, id string) (*models.Sandbox, error) {
+	sb, ok := m.sandboxes[id]
+	if !ok {
+		return nil, sandbox.ErrSandboxNotFound
+	}
+	return sb, nil
+}
+
+func TestStartSandboxRespondsWithDTO(t *testing.T) {
+	manager := &startManager{sandboxes: map[string]*models.Sandbox{
+		"sb-1": {ID: "sb-1", Status: models.StatusRunning},
+	}}
+	s := &Server{sandboxManager: manager}
+	r := chi.NewRouter()
+	r.Post("/sandboxes/{id}/start", s.handleStartSandbox)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/sandboxes/sb-1/start", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	// The v1 DTO always carries last_activity_at; the raw model omits it
+	if _, ok := body.Data["last_activity_at"]; !ok || string(body.Data["id"]) != `"sb-1"` {
+		t.Errorf("expected a sandbox DTO, got %s", rec.Body)
+	}
+
+	// A sandbox deleted right after starting is not reported as null
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/sandboxes/sb-gone/start", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d: %s", rec.Code, rec.Body)
+	}
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"errors"
 	"io/fs"
 	"log/slog"
 	"net/http"
@@ -12,11 +13,16 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/terra-clan/sandbox-engine/internal/config"
+	"github.com/terra-clan/sandbox-engine/internal/metrics"
 	"github.com/terra-clan/sandbox-engine/internal/sandbox"
 	"github.com/terra-clan/sandbox-engine/internal/storage"
 	"github.com/terra-clan/sandbox-engine/internal/templates"
+	"github.com/terra-clan/sandbox-engine/internal/tracing"
+	"github.com/terra-clan/sandbox-engine/internal/version"
 )
 
 // Server represents the HTTP API server
@@ -26,6 +32,12 @@ type Server struct {
 	sandboxManager sandbox.Manager
 	templateLoader *templates.Loader
 	authMiddleware *AuthMiddleware
+	metrics        *metrics.Metrics
+	repo           storage.Repository
+	rateLimiter    *RateLimiter
+	logHub         *logHub
+	shells         *shellHub
+	terminals      *terminalLimiter
 }
 
 // NewServer creates a new API server
@@ -34,12 +46,25 @@ func NewServer(
 	manager sandbox.Manager,
 	loader *templates.Loader,
 	repo storage.Repository,
+	m *metrics.Metrics,
+	limiter *RateLimiter,
 ) *Server {
+	auth := NewAuthMiddleware(repo, cfg.AuthCacheTTL, cfg.AuthCacheSize)
+	if cfg.JWT.Enabled() {
+		auth.EnableJWT(cfg.JWT)
+	}
+
 	s := &Server{
 		config:         cfg,
 		sandboxManager: manager,
 		templateLoader: loader,
-		authMiddleware: NewAuthMiddleware(repo),
+		authMiddleware: auth,
+		metrics:        m,
+		repo:           repo,
+		rateLimiter:    limiter,
+		logHub:         newLogHub(manager),
+		shells:         newShellHub(cfg.TerminalDetachGrace),
+		terminals:      newTerminalLimiter(cfg.TerminalMaxPerSandbox, cfg.TerminalMaxPerClient, m),
 	}
 	s.setupRouter()
 	return s
@@ -56,16 +81,18 @@ func (s *Server) setupRouter() {
 
 	// Base middleware stack (no timeout - WebSocket needs long connections)
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
+	r.Use(realIP(s.config.TrustedProxies))
+	r.Use(tracingMiddleware)
 	r.Use(s.loggingMiddleware)
 	r.Use(middleware.Recoverer)
+	r.Use(versionHeader)
 
 	// CORS configuration
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-Request-ID"},
-		ExposedHeaders:   []string{"X-Request-ID"},
+		ExposedHeaders:   []string{"X-Request-ID", versionHeaderName},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
@@ -73,27 +100,58 @@ func (s *Server) setupRouter() {
 	// Health check (outside versioned API - public)
 	r.Get("/health", s.handleHealth)
 	r.Get("/ready", s.handleReady)
+	// Each call pings every dependency, so unlike the probes it is limited
+	r.With(s.rateLimiter.ByIP).Get("/health/details", s.handleHealthDetails)
+	r.Get("/version", s.handleVersion)
+	// Prometheus scrapes with an API key as its bearer token
+	r.With(s.authMiddleware.Authenticate, s.authMiddleware.RequirePermission("metrics:read")).Handle("/metrics", s.metrics.Handler())
 
 	// API v1 routes
 	r.Route("/api/v1", func(r chi.Router) {
+		// ?api_style=legacy keeps the pre-DTO serialization
+		r.Use(apiStyleMiddleware)
+
 		// --- Public routes (no API key required) ---
 
 		// Join endpoints — session token is the auth
 		r.Route("/join/{token}", func(r chi.Router) {
+			r.Use(s.rateLimiter.ByIP)
 			r.Use(middleware.Timeout(60 * time.Second))
 			r.Get("/", s.handleJoinSession)
 			r.Post("/activate", s.handleActivateSession)
+			r.Post("/submit", s.handleSubmitSession)
 		})
 
 		// WebSocket terminal with session token auth (public)
-		r.Get("/ws/session-terminal/{id}", s.handleSessionTerminalWS)
+		r.With(s.rateLimiter.ByIP).Get("/ws/session-terminal/{id}", s.handleSessionTerminalWS)
+
+		// WebSocket log viewer - session token or API key, checked by the handler
+		r.Get("/ws/logs/{id}", s.logsWSHandler())
+
+		// API contract and its rendered reference
+		r.Get("/openapi.json", s.handleOpenAPI)
+		r.Get("/docs", s.handleAPIDocs)
 
 		// --- Authenticated routes (API key required) ---
 		r.Group(func(r chi.Router) {
 			r.Use(s.authMiddleware.Authenticate)
+			r.Use(s.rateLimiter.ByClient)
 
 			// WebSocket terminal - NO timeout (needs long-lived connections)
-			r.Get("/ws/terminal/{id}", s.handleTerminalWS)
+			r.With(s.authMiddleware.RequirePermission("sandboxes:terminal")).Get("/ws/terminal/{id}", s.handleTerminalWS)
+
+			// Bulk log export - NO timeout (streams for as long as Docker takes)
+			r.With(s.authMiddleware.RequirePermission("sandboxes:read")).Post("/sandboxes/logs/export", s.handleExportLogs)
+
+			// Live log tail - NO timeout (follows the container until the client leaves)
+			r.With(s.authMiddleware.RequirePermission("sandboxes:read"), s.requireSandboxOwner).Get("/sandboxes/{id}/logs/stream", s.handleStreamLogs)
+
+			// Grading, exec and file transfers - their own longer deadline (commands
+			// may run up to the exec maximum, files may be FileUploadMaxMB)
+			r.With(s.authMiddleware.RequirePermission("sandboxes:grade"), s.requireSandboxOwner, longRequest(execRouteTimeout)).Post("/sandboxes/{id}/grade", s.handleGradeSandbox)
+			r.With(s.authMiddleware.RequirePermission("sandboxes:terminal"), s.requireSandboxOwner, longRequest(execRouteTimeout)).Post("/sandboxes/{id}/exec", s.handleExec)
+			r.With(s.authMiddleware.RequirePermission("sandboxes:read"), s.requireSandboxOwner, longRequest(fileTransferTimeout)).Get("/sandboxes/{id}/files", s.handleDownloadFile)
+			r.With(s.authMiddleware.RequirePermission("sandboxes:write"), s.requireSandboxOwner, longRequest(fileTransferTimeout)).Put("/sandboxes/{id}/files", s.handleUploadFile)
 
 			// REST API routes - with timeout
 			r.Group(func(r chi.Router) {
@@ -103,13 +161,28 @@ func (s *Server) setupRouter() {
 				r.Route("/sandboxes", func(r chi.Router) {
 					r.With(s.authMiddleware.RequirePermission("sandboxes:read")).Get("/", s.handleListSandboxes)
 					r.With(s.authMiddleware.RequirePermission("sandboxes:write")).Post("/", s.handleCreateSandbox)
+					r.With(s.authMiddleware.RequirePermission("sandboxes:write")).Post("/bulk-delete", s.handleBulkDeleteSandboxes)
+
+					// Recordings and grading results outlive their sandbox, so they check the owner they kept
+					r.With(s.authMiddleware.RequirePermission("recordings:read")).Get("/{id}/recordings", s.handleListRecordings)
+					r.With(s.authMiddleware.RequirePermission("recordings:read")).Get("/{id}/recordings/{recordingId}", s.handleGetRecording)
+					r.With(s.authMiddleware.RequirePermission("sandboxes:grade")).Get("/{id}/grade", s.handleListGradingResults)
 
 					r.Route("/{id}", func(r chi.Router) {
+						r.Use(s.requireSandboxOwner)
+
 						r.With(s.authMiddleware.RequirePermission("sandboxes:read")).Get("/", s.handleGetSandbox)
 						r.With(s.authMiddleware.RequirePermission("sandboxes:write")).Delete("/", s.handleDeleteSandbox)
 						r.With(s.authMiddleware.RequirePermission("sandboxes:write")).Post("/extend", s.handleExtendTTL)
+						r.With(s.authMiddleware.RequirePermission("sandboxes:write")).Post("/start", s.handleStartSandbox)
 						r.With(s.authMiddleware.RequirePermission("sandboxes:write")).Post("/stop", s.handleStopSandbox)
 						r.With(s.authMiddleware.RequirePermission("sandboxes:read")).Get("/logs", s.handleGetLogs)
+						r.With(s.authMiddleware.RequirePermission("sandboxes:read")).Get("/events", s.handleListEvents)
+						r.With(s.authMiddleware.RequirePermission("sandboxes:read")).Get("/services", s.handleListServices)
+						r.With(s.authMiddleware.RequirePermission("sandboxes:read")).Get("/services/{name}", s.handleGetService)
+						r.With(s.authMiddleware.RequirePermission("sandboxes:write")).Post("/services/{name}/healthcheck", s.handleCheckService)
+						r.With(s.authMiddleware.RequirePermission("sandboxes:write")).Post("/services/{name}/rotate", s.handleRotateService)
+						r.With(s.authMiddleware.RequirePermission("sandboxes:read")).Get("/stats", s.handleSandboxStats)
 					})
 				})
 
@@ -117,22 +190,57 @@ func (s *Server) setupRouter() {
 				r.Route("/sessions", func(r chi.Router) {
 					r.With(s.authMiddleware.RequirePermission("sessions:read")).Get("/", s.handleListSessions)
 					r.With(s.authMiddleware.RequirePermission("sessions:write")).Post("/", s.handleCreateSession)
+					r.With(s.authMiddleware.RequirePermission("sessions:write")).Post("/bulk", s.handleBulkCreateSessions)
 
 					r.Route("/{id}", func(r chi.Router) {
 						r.With(s.authMiddleware.RequirePermission("sessions:read")).Get("/", s.handleGetSession)
 						r.With(s.authMiddleware.RequirePermission("sessions:write")).Delete("/", s.handleDeleteSession)
+						r.With(s.authMiddleware.RequirePermission("sessions:write")).Post("/extend", s.handleExtendSession)
+						r.With(s.authMiddleware.RequirePermission("sessions:write")).Post("/revoke", s.handleRevokeSession)
+						r.With(s.authMiddleware.RequirePermission("sessions:read")).Get("/submission", s.handleGetSessionSubmission)
+						r.With(s.authMiddleware.RequirePermission("sessions:read")).Get("/report", s.handleGetSessionReport)
 					})
 				})
 
 				// Templates
 				r.Route("/templates", func(r chi.Router) {
 					r.With(s.authMiddleware.RequirePermission("templates:read")).Get("/", s.handleListTemplates)
+					r.With(s.authMiddleware.RequirePermission("templates:read")).Post("/validate", s.handleValidateTemplate)
+					r.With(s.authMiddleware.RequirePermission("templates:read")).Get("/validation", s.handleTemplateValidation)
+					r.With(s.authMiddleware.RequirePermission("admin:write")).Post("/reload", s.handleReloadTemplates)
+					r.With(s.authMiddleware.RequirePermission("templates:write")).Post("/", s.handleCreateTemplate)
 					r.With(s.authMiddleware.RequirePermission("templates:read")).Get("/{name}", s.handleGetTemplate)
+					r.With(s.authMiddleware.RequirePermission("templates:write")).Put("/{name}", s.handleUpdateTemplate)
+					r.With(s.authMiddleware.RequirePermission("templates:write")).Delete("/{name}", s.handleDeleteTemplate)
+				})
+
+				// Admin: template and catalog task usage
+				r.With(s.authMiddleware.RequirePermission("admin:read")).Get("/admin/templates/usage", s.handleTemplateUsage)
+
+				// Admin: Docker host maintenance
+				r.Route("/admin/hosts", func(r chi.Router) {
+					r.With(s.authMiddleware.RequirePermission("admin:read")).Get("/", s.handleListHosts)
+					r.With(s.authMiddleware.RequirePermission("admin:write")).Post("/{name}/drain", s.handleDrainHost)
+					r.With(s.authMiddleware.RequirePermission("admin:write")).Post("/{name}/undrain", s.handleUndrainHost)
+				})
+				r.With(s.authMiddleware.RequirePermission("admin:read")).Get("/admin/orphans", s.handleListOrphans)
+				r.With(s.authMiddleware.RequirePermission("admin:write")).Post("/admin/orphans/prune", s.handlePruneOrphans)
+				r.With(s.authMiddleware.RequirePermission("admin:write")).Post("/admin/providers/reconcile", s.handleReconcileProviders)
+
+				// Admin: API clients and their keys
+				r.Route("/clients", func(r chi.Router) {
+					r.With(s.authMiddleware.RequirePermission("clients:admin")).Get("/", s.handleListClients)
+					r.With(s.authMiddleware.RequirePermission("clients:admin")).Post("/", s.handleCreateClient)
+					r.With(s.authMiddleware.RequirePermission("clients:admin")).Patch("/{id}", s.handleUpdateClient)
+					r.With(s.authMiddleware.RequirePermission("clients:admin")).Delete("/{id}", s.handleDeactivateClient)
+					r.With(s.authMiddleware.RequirePermission("clients:admin")).Post("/{id}/rotate", s.handleRotateClientKey)
 				})
 
 				// Catalog (hierarchical: domains → projects → tasks)
 				r.Route("/catalog", func(r chi.Router) {
 					r.With(s.authMiddleware.RequirePermission("templates:read")).Get("/domains", s.handleListDomains)
+					r.With(s.authMiddleware.RequirePermission("admin:read")).Get("/export", s.handleExportCatalog)
+					r.With(s.authMiddleware.RequirePermission("admin:write")).Post("/import", s.handleImportCatalog)
 
 					r.Route("/domains/{domainId}", func(r chi.Router) {
 						r.With(s.authMiddleware.RequirePermission("templates:read")).Get("/", s.handleGetDomain)
@@ -220,6 +328,72 @@ func (s *Server) serveSPA(r *chi.Mux) {
 	})
 }
 
+// quietPath reports whether requests to path are left out of logs and traces:
+// WebSockets live too long to be one span, probes are too frequent to be useful
+func quietPath(path string) bool {
+	return strings.Contains(path, "/ws/") || path == "/health" || path == "/ready" || path == "/metrics"
+}
+
+const (
+	// execRouteTimeout bounds grading and exec requests: the longest command
+	// plus time to set it up and collect its results
+	execRouteTimeout = sandbox.ExecMaxTimeout + 30*time.Second
+	// fileTransferTimeout bounds a file upload or download
+	fileTransferTimeout = 10 * time.Minute
+	// longRequestGrace lets a request that ran out its deadline still write
+	// its response
+	longRequestGrace = 10 * time.Second
+)
+
+// longRequest gives a route that outlives the REST timeout its own deadline:
+// the request's context ends after timeout, and the server's read and write
+// timeouts are pushed out past it so the body and response aren't cut off
+func longRequest(timeout time.Duration) func(http.Handler) http.Handler {
+	withTimeout := middleware.Timeout(timeout)
+	return func(next http.Handler) http.Handler {
+		h := withTimeout(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			deadline := time.Now().Add(timeout + longRequestGrace)
+			rc := http.NewResponseController(w)
+			if err := rc.SetReadDeadline(deadline); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				slog.Warn("failed to extend read deadline", "error", err, "path", r.URL.Path)
+			}
+			if err := rc.SetWriteDeadline(deadline); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				slog.Warn("failed to extend write deadline", "error", err, "path", r.URL.Path)
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// versionHeaderName carries the engine build on every response
+const versionHeaderName = "X-Sandbox-Engine-Version"
+
+// versionHeader sets versionHeaderName so clients can tell which build answered
+func versionHeader(next http.Handler) http.Handler {
+	value := version.String()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(versionHeaderName, value)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// tracingMiddleware starts a server span per request, continuing the caller's
+// trace from traceparent, and renames it after the matched route once routing
+// has run so spans group by endpoint rather than by sandbox ID
+func tracingMiddleware(next http.Handler) http.Handler {
+	named := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			trace.SpanFromContext(r.Context()).SetName(r.Method + " " + rctx.RoutePattern())
+		}
+	})
+	return otelhttp.NewHandler(named, "http request",
+		otelhttp.WithFilter(func(r *http.Request) bool { return !quietPath(r.URL.Path) }),
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string { return r.Method }),
+	)
+}
+
 // loggingMiddleware logs HTTP requests using slog
 func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -228,7 +402,7 @@ func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
 
 		defer func() {
 			// Skip noisy logging for WebSocket and health checks
-			if strings.Contains(r.URL.Path, "/ws/") || r.URL.Path == "/health" || r.URL.Path == "/ready" {
+			if quietPath(r.URL.Path) {
 				return
 			}
 			slog.Info("http request",
@@ -238,6 +412,7 @@ func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
 				"bytes", ww.BytesWritten(),
 				"duration_ms", time.Since(start).Milliseconds(),
 				"request_id", middleware.GetReqID(r.Context()),
+				"trace_id", tracing.TraceID(r.Context()),
 				"remote_addr", r.RemoteAddr,
 			)
 		}()
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"strconv"
 
 	"github.com/go-chi/chi/v5"
@@ -23,20 +24,15 @@ func (s *Server) handleCreateSession(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.TemplateID == "" {
-		respondError(w, http.StatusBadRequest, "validation_error", "template_id is required")
-		return
-	}
-
-	if req.TTL <= 0 {
-		respondError(w, http.StatusBadRequest, "validation_error", "ttl must be positive (seconds)")
+	if msg := validateCreateSession(req); msg != "" {
+		respondError(w, http.StatusBadRequest, "validation_error", msg)
 		return
 	}
 
 	// Identify who created the session
 	createdBy := ""
 	if client := ClientFromContext(r.Context()); client != nil {
-		createdBy = client.Name
+		createdBy, req.OwnerClientID = client.Name, client.ID
 	}
 
 	session, err := s.sandboxManager.CreateSession(r.Context(), req, createdBy)
@@ -45,54 +41,116 @@ func (s *Server) handleCreateSession(w http.ResponseWriter, r *http.Request) {
 			respondError(w, http.StatusNotFound, "template_not_found", "template not found")
 			return
 		}
+		if errors.Is(err, sandbox.ErrTemplateDisabled) {
+			respondError(w, http.StatusConflict, "template_disabled", "template is disabled")
+			return
+		}
+		if errors.Is(err, sandbox.ErrTaskNotFound) {
+			respondError(w, http.StatusNotFound, "task_not_found", "task not found")
+			return
+		}
+		if errors.Is(err, sandbox.ErrPrewarmTooLate) || errors.Is(err, sandbox.ErrCallbackNotAllowed) {
+			respondError(w, http.StatusBadRequest, "validation_error", err.Error())
+			return
+		}
+		if respondTTLCapError(w, err) {
+			return
+		}
 		slog.Error("failed to create session", "error", err)
 		respondError(w, http.StatusInternalServerError, "internal_error", "failed to create session")
 		return
 	}
 
-	// Build join URL
-	domain := s.config.Host
-	if domain == "0.0.0.0" {
-		domain = "localhost"
+	respondJSON(w, http.StatusCreated, s.toCreateSessionResponse(session))
+}
+
+// validateCreateSession returns why req can't create a session, or ""
+func validateCreateSession(req models.CreateSessionRequest) string {
+	if req.TemplateID == "" && req.TaskID == "" {
+		return "template_id or task_id is required"
+	}
+
+	// A task's time limit stands in for a missing ttl
+	if req.TTL < 0 || (req.TTL == 0 && req.TaskID == "") {
+		return "ttl must be positive (seconds)"
+	}
+
+	if req.JoinTTL < 0 {
+		return "join_ttl_seconds must not be negative"
 	}
-	joinURL := fmt.Sprintf("http://%s:%d/join/%s", domain, s.config.Port, session.Token)
 
-	respondJSON(w, http.StatusCreated, models.CreateSessionResponse{
+	if req.OnConflict != "" && !req.OnConflict.IsValid() {
+		return "on_conflict must be reject or supersede"
+	}
+
+	if req.CallbackURL != "" {
+		u, err := url.Parse(req.CallbackURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "callback_url must be an absolute http or https URL"
+		}
+	}
+	return ""
+}
+
+// toCreateSessionResponse describes a new session with its join URL
+func (s *Server) toCreateSessionResponse(session *models.Session) *models.CreateSessionResponse {
+	joinURL := s.config.BaseURL() + "/join/" + session.Token
+
+	return &models.CreateSessionResponse{
 		ID:         session.ID,
 		Token:      session.Token,
 		TemplateID: session.TemplateID,
 		Status:     session.Status,
 		JoinURL:    joinURL,
+		JoinBy:     session.JoinBy,
 		CreatedAt:  session.CreatedAt,
-	})
+
+		CallbackSecret: session.CallbackSecret,
+	}
 }
 
 func (s *Server) handleListSessions(w http.ResponseWriter, r *http.Request) {
-	status := r.URL.Query().Get("status")
-	limit := 50
-	offset := 0
+	filters := models.SessionFilters{
+		Status:     r.URL.Query().Get("status"),
+		TemplateID: r.URL.Query().Get("template_id"),
+		CreatedBy:  r.URL.Query().Get("created_by"),
+		Limit:      50,
+	}
 
 	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
 		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
-			limit = l
+			filters.Limit = l
 		}
 	}
 	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
 		if o, err := strconv.Atoi(offsetStr); err == nil && o >= 0 {
-			offset = o
+			filters.Offset = o
 		}
 	}
+	if msg := parseCreatedRange(r, &filters.CreatedAfter, &filters.CreatedBefore); msg != "" {
+		respondError(w, http.StatusBadRequest, "validation_error", msg)
+		return
+	}
 
-	sessions, err := s.sandboxManager.ListSessions(r.Context(), status, limit, offset)
+	sessions, err := s.sandboxManager.ListSessions(r.Context(), filters)
 	if err != nil {
 		slog.Error("failed to list sessions", "error", err)
 		respondError(w, http.StatusInternalServerError, "internal_error", "failed to list sessions")
 		return
 	}
 
+	total, err := s.sandboxManager.CountSessions(r.Context(), filters)
+	if err != nil {
+		slog.Error("failed to count sessions", "error", err)
+		respondError(w, http.StatusInternalServerError, "internal_error", "failed to list sessions")
+		return
+	}
+
 	respondJSON(w, http.StatusOK, map[string]interface{}{
-		"sessions": sessions,
-		"total":    len(sessions),
+		"sessions": renderList(r, sessions, toSessionDTO),
+		"total":    total,
+		"limit":    filters.Limit,
+		"offset":   filters.Offset,
 	})
 }
 
@@ -114,7 +172,48 @@ func (s *Server) handleGetSession(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	respondJSON(w, http.StatusOK, session)
+	respondJSON(w, http.StatusOK, render(r, session, toSessionDTO))
+}
+
+// handleExtendSession gives an active session more time; the candidate's
+// join page picks up the new expiry on its next poll
+func (s *Server) handleExtendSession(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		respondError(w, http.StatusBadRequest, "validation_error", "session id is required")
+		return
+	}
+
+	var req models.ExtendRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_request", "invalid JSON body")
+		return
+	}
+
+	if req.Duration <= 0 {
+		respondError(w, http.StatusBadRequest, "validation_error", "duration must be positive")
+		return
+	}
+
+	session, err := s.sandboxManager.ExtendSession(r.Context(), id, req.Duration)
+	if err != nil {
+		if errors.Is(err, sandbox.ErrSessionNotFound) {
+			respondError(w, http.StatusNotFound, "not_found", "session not found")
+			return
+		}
+		if errors.Is(err, sandbox.ErrSessionNotActive) {
+			respondError(w, http.StatusConflict, "session_not_active", "only active sessions can be extended")
+			return
+		}
+		if respondTTLCapError(w, err) {
+			return
+		}
+		slog.Error("failed to extend session", "error", err, "id", id)
+		respondError(w, http.StatusInternalServerError, "internal_error", "failed to extend session")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, render(r, session, toSessionDTO))
 }
 
 func (s *Server) handleDeleteSession(w http.ResponseWriter, r *http.Request) {
@@ -139,6 +238,37 @@ func (s *Server) handleDeleteSession(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// handleRevokeSession ends a session's access but keeps its record, unlike delete
+func (s *Server) handleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		respondError(w, http.StatusBadRequest, "validation_error", "session id is required")
+		return
+	}
+
+	revokedBy := ""
+	if client := ClientFromContext(r.Context()); client != nil {
+		revokedBy = client.Name
+	}
+
+	session, err := s.sandboxManager.RevokeSession(r.Context(), id, revokedBy)
+	if err != nil {
+		if errors.Is(err, sandbox.ErrSessionNotFound) {
+			respondError(w, http.StatusNotFound, "not_found", "session not found")
+			return
+		}
+		if errors.Is(err, sandbox.ErrSessionRevoked) {
+			respondError(w, http.StatusConflict, "session_revoked", "session is already revoked")
+			return
+		}
+		slog.Error("failed to revoke session", "error", err, "id", id)
+		respondError(w, http.StatusInternalServerError, "internal_error", "failed to revoke session")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, render(r, session, toSessionDTO))
+}
+
 // --- Public handlers (session token = auth) ---
 
 func (s *Server) handleJoinSession(w http.ResponseWriter, r *http.Request) {
@@ -159,21 +289,54 @@ func (s *Server) handleJoinSession(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// A revoked invitation is gone for good; only the status is disclosed
+	if session.Status == models.SessionRevoked {
+		respondJSON(w, http.StatusGone, models.JoinSessionResponse{Status: session.Status})
+		return
+	}
+
 	resp := models.JoinSessionResponse{
 		Status:          session.Status,
 		Metadata:        session.Metadata,
 		TaskDescription: session.TaskDescription,
+		ExpiryBehavior:  sandbox.SessionExpiry(session),
+	}
+
+	if taskID := session.TaskID(); taskID != "" {
+		resp.Task = localizeTask(r, s.templateLoader.GetTask(taskID))
 	}
 
 	// Populate template info
-	tmpl := s.templateLoader.Get(session.TemplateID)
+	tmpl := localizeTemplate(r, s.templateLoader.Get(session.TemplateID))
 	if tmpl != nil {
 		resp.Template = &models.TemplateInfo{
 			Name:        tmpl.Name,
 			Description: tmpl.Description,
+			Language:    tmpl.Language,
+			Tags:        tmpl.Tags,
 		}
 	}
 
+	if session.IsActivatable() {
+		resp.JoinBy = session.JoinBy
+	}
+	resp.SubmittedAt = session.SubmittedAt
+
+	// Past the join deadline the token no longer starts anything, even
+	// before the cleaner expires the session
+	if session.JoinExpired() {
+		resp.Status = models.SessionExpired
+		resp.JoinBy = nil
+		resp.ExpiryBehavior = nil
+	}
+
+	// The invitation can't be used once its template is gone
+	if session.TemplateMissing() || (tmpl == nil && session.IsActivatable()) {
+		resp.Status = models.SessionInvalid
+		resp.Reason = models.ReasonTemplateMissing
+		resp.ExpiryBehavior = nil
+	}
+
 	// If active, include sandbox info
 	if session.Status == models.SessionActive && session.SandboxID != "" {
 		sb, err := s.sandboxManager.Get(r.Context(), session.SandboxID)
@@ -222,48 +385,188 @@ func (s *Server) handleActivateSession(w http.ResponseWriter, r *http.Request) {
 			respondError(w, http.StatusConflict, "not_ready", "session is not in ready state")
 			return
 		}
+		if errors.Is(err, sandbox.ErrSessionConflict) {
+			respondError(w, http.StatusConflict, "session_conflict", "another session for this candidate is already active")
+			return
+		}
+		if errors.Is(err, sandbox.ErrSessionChanged) {
+			respondError(w, http.StatusConflict, "session_changed", "session changed concurrently, retry")
+			return
+		}
+		if errors.Is(err, sandbox.ErrShuttingDown) {
+			respondError(w, http.StatusServiceUnavailable, "shutting_down", "engine is shutting down, retry shortly")
+			return
+		}
 		slog.Error("failed to activate session", "error", err)
 		respondError(w, http.StatusInternalServerError, "internal_error", "failed to activate session")
 		return
 	}
 
+	if session.Status == models.SessionRevoked {
+		respondJSON(w, http.StatusGone, models.ActivateSessionResponse{Status: session.Status})
+		return
+	}
+
 	respondJSON(w, http.StatusOK, models.ActivateSessionResponse{
-		Status:    session.Status,
-		SandboxID: session.SandboxID,
+		Status:        session.Status,
+		StatusMessage: session.StatusMessage,
+		SandboxID:     session.SandboxID,
 	})
 }
 
+// handleSubmitSession hands in the candidate's workspace, ending the session
+func (s *Server) handleSubmitSession(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+	if token == "" {
+		respondError(w, http.StatusBadRequest, "validation_error", "session token is required")
+		return
+	}
+
+	session, err := s.sandboxManager.SubmitSession(r.Context(), token)
+	if err != nil {
+		switch {
+		case errors.Is(err, sandbox.ErrSessionNotFound):
+			respondError(w, http.StatusNotFound, "not_found", "session not found")
+		case errors.Is(err, sandbox.ErrSessionRevoked):
+			respondJSON(w, http.StatusGone, models.SubmitSessionResponse{Status: models.SessionRevoked})
+		case errors.Is(err, sandbox.ErrSessionSubmitted):
+			respondError(w, http.StatusConflict, "already_submitted", "session has already been submitted")
+		case errors.Is(err, sandbox.ErrSessionNotActive):
+			respondError(w, http.StatusConflict, "not_active", "session is not active")
+		case errors.Is(err, sandbox.ErrSessionChanged):
+			respondError(w, http.StatusConflict, "session_changed", "session changed concurrently, retry")
+		case errors.Is(err, sandbox.ErrSubmissionTooLarge):
+			respondError(w, http.StatusRequestEntityTooLarge, "submission_too_large", "workspace exceeds the submission size limit")
+		case errors.Is(err, sandbox.ErrOperationInProgress):
+			respondError(w, http.StatusConflict, "operation_in_progress", "another operation is already running for this sandbox")
+		case errors.Is(err, sandbox.ErrSandboxNotFound):
+			respondError(w, http.StatusNotFound, "not_found", "sandbox not found")
+		default:
+			slog.Error("failed to submit session", "error", err)
+			respondError(w, http.StatusInternalServerError, "internal_error", "failed to submit session")
+		}
+		return
+	}
+
+	if session.Status == models.SessionRevoked {
+		respondJSON(w, http.StatusGone, models.SubmitSessionResponse{Status: session.Status})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, models.SubmitSessionResponse{
+		Status:      session.Status,
+		SubmittedAt: session.SubmittedAt,
+	})
+}
+
+// handleGetSessionSubmission downloads the workspace archive of a submitted session
+func (s *Server) handleGetSessionSubmission(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		respondError(w, http.StatusBadRequest, "validation_error", "session id is required")
+		return
+	}
+
+	session, f, err := s.sandboxManager.OpenSubmission(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sandbox.ErrSessionNotFound) {
+			respondError(w, http.StatusNotFound, "not_found", "session not found")
+			return
+		}
+		if errors.Is(err, sandbox.ErrNoSubmission) {
+			respondError(w, http.StatusNotFound, "submission_not_found", "session has no submission")
+			return
+		}
+		slog.Error("failed to open session submission", "error", err, "id", id)
+		respondError(w, http.StatusInternalServerError, "internal_error", "failed to get submission")
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "submission-"+session.ID+".tar"))
+	http.ServeContent(w, r, "", *session.SubmittedAt, f)
+}
+
+// handleGetSessionReport summarizes a session's activity, e.g. for review
+// after an interview. It still answers once the sandbox is cleaned up.
+func (s *Server) handleGetSessionReport(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		respondError(w, http.StatusBadRequest, "validation_error", "session id is required")
+		return
+	}
+
+	report, err := s.sandboxManager.SessionReport(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sandbox.ErrSessionNotFound) {
+			respondError(w, http.StatusNotFound, "not_found", "session not found")
+			return
+		}
+		slog.Error("failed to build session report", "error", err, "id", id)
+		respondError(w, http.StatusInternalServerError, "internal_error", "failed to build session report")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, report)
+}
+
 // handleSessionTerminalWS handles WebSocket terminal with session token auth
 func (s *Server) handleSessionTerminalWS(w http.ResponseWriter, r *http.Request) {
+	session := s.authorizeSessionSandbox(w, r)
+	if session == nil {
+		return
+	}
+	sb := s.wsSandbox(w, r)
+	if sb == nil {
+		return
+	}
+	release := s.acquireTerminal(w, r, sb.ID)
+	if release == nil {
+		return
+	}
+	defer release()
+	s.serveTerminal(w, r, sb, session)
+}
+
+// authorizeSessionSandbox returns the active session of ?session_token= if
+// its sandbox is {id}, writing the error response and returning nil if not
+func (s *Server) authorizeSessionSandbox(w http.ResponseWriter, r *http.Request) *models.Session {
 	sandboxID := chi.URLParam(r, "id")
 	sessionToken := r.URL.Query().Get("session_token")
 
 	if sandboxID == "" {
 		http.Error(w, "sandbox id required", http.StatusBadRequest)
-		return
+		return nil
 	}
 	if sessionToken == "" {
 		http.Error(w, "session_token required", http.StatusUnauthorized)
-		return
+		return nil
 	}
 
 	// Validate session token and match sandbox
 	session, err := s.sandboxManager.GetSessionByToken(r.Context(), sessionToken)
 	if err != nil {
 		http.Error(w, "invalid session token", http.StatusUnauthorized)
-		return
+		return nil
 	}
 
+	if session.Status == models.SessionRevoked {
+		http.Error(w, "session has been revoked", http.StatusGone)
+		return nil
+	}
+	if session.Status == models.SessionSubmitted {
+		http.Error(w, "session has been submitted", http.StatusGone)
+		return nil
+	}
 	if session.Status != models.SessionActive {
 		http.Error(w, "session is not active", http.StatusBadRequest)
-		return
+		return nil
 	}
 
 	if session.SandboxID != sandboxID {
 		http.Error(w, "sandbox does not belong to this session", http.StatusForbidden)
-		return
+		return nil
 	}
-
-	// Delegate to the existing terminal handler
-	s.handleTerminalWS(w, r)
+	return session
 }
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/terra-clan/sandbox-engine/internal/config"
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/sandbox"
+	"github.com/terra-clan/sandbox-engine/internal/templates"
+)
+
+// tokenManager serves sessions by token
+type tokenManager struct {
+	sandbox.Manager
+	sessions map[string]*models.Session
+}
+
+func (m *tokenManager) GetSessionByToken(ctx context.Context, token string) (*models.Session, error) {
+	if s, ok := m.sessions[token]; ok {
+		return s, nil
+	}
+	return nil, sandbox.ErrSessionNotFound
+}
+
+// bulkManager fails bulk creates entry by entry with errs
+type bulkManager struct {
+	sandbox.Manager
+	errs []error
+}
+
+func (m *bulkManager) CreateSessions(ctx context.Context, req models.BulkCreateSessionsRequest, createdBy string) ([]*sandbox.BulkSessionResult, error) {
+	results := make([]*sandbox.BulkSessionResult, 0, len(m.errs))
+	for _, err := range m.errs {
+		results = append(results, &sandbox.BulkSessionResult{Err: err})
+	}
+	return results, nil
+}
+
+func TestBulkCreateSessionsEntryErrors(t *testing.T) {
+	manager := &bulkManager{errs: []error{
+		fmt.Errorf("failed to get template: %w", sandbox.ErrTemplateNotFound),
+		sandbox.ErrTemplateDisabled,
+		sandbox.ErrTaskNotFound,
+		sandbox.ErrSessionConflict,
+		fmt.Errorf("%w: prewarm_at is past the join deadline", sandbox.ErrPrewarmTooLate),
+		&sandbox.TTLCapError{Requested: 2 * time.Hour, Max: time.Hour},
+		errors.New("connection reset"),
+	}}
+	s := &Server{sandboxManager: manager}
+
+	body, _ := json.Marshal(models.BulkCreateSessionsRequest{
+		CreateSessionRequest: models.CreateSessionRequest{TemplateID: "demo-shop", TTL: 3600},
+		Entries:              make([]models.BulkSessionEntry, len(manager.errs)),
+	})
+	rec := httptest.NewRecorder()
+	s.handleBulkCreateSessions(rec, httptest.NewRequest(http.MethodPost, "/api/v1/sessions/bulk", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+
+	var resp struct {
+		Data models.BulkCreateSessionsResponse `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"template_not_found", "template_disabled", "task_not_found", "session_conflict", "validation_error", "ttl_exceeds_max", "internal_error"}
+	if resp.Data.Failed != len(want) || len(resp.Data.Results) != len(want) {
+		t.Fatalf("expected %d failed entries, got %+v", len(want), resp.Data)
+	}
+	for i, res := range resp.Data.Results {
+		if res.Success || res.Code != want[i] || res.Error == "" {
+			t.Errorf("entry %d: expected %s, got %+v", i, want[i], res)
+		}
+	}
+}
+
+func TestJoinSessionTemplateMissing(t *testing.T) {
+	loader := templates.NewLoader()
+	if err := loader.LoadFromDir(filepath.Join("testdata", "catalog")); err != nil {
+		t.Fatal(err)
+	}
+
+	manager := &tokenManager{sessions: map[string]*models.Session{
+		"ready":   {Token: "ready", TemplateID: "demo-shop", Status: models.SessionReady},
+		"removed": {Token: "removed", TemplateID: "gone", Status: models.SessionReady},
+		"failed": {
+			Token:         "failed",
+			TemplateID:    "gone",
+			Status:        models.SessionFailed,
+			StatusMessage: models.StatusMsgTemplateMissing + "gone",
+		},
+		"expired": {Token: "expired", TemplateID: "gone", Status: models.SessionExpired},
+	}}
+
+	s := &Server{sandboxManager: manager, templateLoader: loader}
+	r := chi.NewRouter()
+	r.Get("/join/{token}", s.handleJoinSession)
+
+	tests := []struct {
+		token  string
+		status models.SessionStatus
+		reason string
+		expiry bool
+	}{
+		{token: "ready", status: models.SessionReady, expiry: true},
+		{token: "removed", status: models.SessionInvalid, reason: models.ReasonTemplateMissing},
+		{token: "failed", status: models.SessionInvalid, reason: models.ReasonTemplateMissing},
+		{token: "expired", status: models.SessionExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.token, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/join/"+tt.token, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+			}
+
+			var body struct {
+				Data models.JoinSessionResponse `json:"data"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Data.Status != tt.status || body.Data.Reason != tt.reason {
+				t.Errorf("expected %s/%q, got %s/%q", tt.status, tt.reason, body.Data.Status, body.Data.Reason)
+			}
+			// Only a usable session can still expire
+			if (body.Data.ExpiryBehavior != nil) != tt.expiry {
+				t.Errorf("expected expiry behavior %v, got %+v", tt.expiry, body.Data.ExpiryBehavior)
+			}
+		})
+	}
+}
+
+func TestSessionJoinURL(t *testing.T) {
+	session := &models.Session{ID: "s-1", Token: "tok"}
+	tests := []struct {
+		name string
+		cfg  config.ServerConfig
+		want string
+	}{
+		{"listen address", config.ServerConfig{Host: "0.0.0.0", Port: 8080}, "http://localhost:8080/join/tok"},
+		{"public base", config.ServerConfig{Host: "0.0.0.0", Port: 8080, PublicBaseURL: "https://sandbox.example.com/engine/"}, "https://sandbox.example.com/engine/join/tok"},
+	}
+	for _, tt := range tests {
+		s := &Server{config: tt.cfg}
+		if got := s.toCreateSessionResponse(session).JoinURL; got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.want, got)
+		}
+	}
+}
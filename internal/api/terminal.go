@@ -3,14 +3,17 @@ package api
 import (
 	"context"
 	"encoding/json"
-	"io"
 	"log/slog"
+	"math"
 	"net/http"
 	"sync"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/gorilla/websocket"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/sandbox"
 )
 
 const (
@@ -20,6 +23,12 @@ const (
 	pongTimeout = 10 * time.Second
 	// Write deadline for WebSocket messages
 	writeTimeout = 10 * time.Second
+	// Minimum time between auto-extend checks triggered by terminal input
+	activityCheckInterval = 30 * time.Second
+	// Longest a session terminal goes without re-reading the session expiry
+	countdownRecheck = time.Minute
+	// How long a terminal opened with ?connect=true waits for the connect message
+	connectTimeout = 5 * time.Second
 )
 
 var upgrader = websocket.Upgrader{
@@ -31,26 +40,78 @@ var upgrader = websocket.Upgrader{
 }
 
 type TerminalMessage struct {
-	Type string `json:"type"`
-	Data string `json:"data,omitempty"`
-	Cols int    `json:"cols,omitempty"`
-	Rows int    `json:"rows,omitempty"`
+	Type      string     `json:"type"`
+	Data      string     `json:"data,omitempty"`
+	Cols      int        `json:"cols,omitempty"`
+	Rows      int        `json:"rows,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// SecondsRemaining is set on ttl_warning messages
+	SecondsRemaining int `json:"seconds_remaining,omitempty"`
+	// Command is the command a connect message asks the terminal to run
+	Command []string `json:"command,omitempty"`
+	// Code is the exit status of the shell on exit messages
+	Code *int `json:"code,omitempty"`
+	// Enabled switches binary output on or off in binary messages
+	Enabled bool `json:"enabled,omitempty"`
 }
 
-func (s *Server) handleTerminalWS(w http.ResponseWriter, r *http.Request) {
+// wsSandbox resolves the {id} of a WebSocket route, writing a plain-text
+// error before the upgrade if it can't
+func (s *Server) wsSandbox(w http.ResponseWriter, r *http.Request) *models.Sandbox {
 	sandboxID := chi.URLParam(r, "id")
 	if sandboxID == "" {
 		http.Error(w, "sandbox id required", http.StatusBadRequest)
-		return
+		return nil
 	}
 
 	sb, err := s.sandboxManager.Get(r.Context(), sandboxID)
 	if err != nil {
 		slog.Error("failed to get sandbox", "id", sandboxID, "error", err)
+		http.Error(w, "sandbox not found", http.StatusNotFound)
+		return nil
+	}
+	return sb
+}
+
+// handleTerminalWS attaches an API client with sandboxes:terminal to the
+// shell of a sandbox it owns, or with ?mode=observe lets it watch the shell
+// without controlling it
+func (s *Server) handleTerminalWS(w http.ResponseWriter, r *http.Request) {
+	mode := r.URL.Query().Get("mode")
+	if mode != "" && mode != "observe" {
+		http.Error(w, "mode must be observe", http.StatusBadRequest)
+		return
+	}
+
+	sb := s.wsSandbox(w, r)
+	if sb == nil {
+		return
+	}
+	if !canAccessSandbox(r, sb) {
 		http.Error(w, "sandbox not found", http.StatusNotFound)
 		return
 	}
 
+	release := s.acquireTerminal(w, r, sb.ID)
+	if release == nil {
+		return
+	}
+	defer release()
+
+	if mode == "observe" {
+		s.observeTerminal(w, r, sb)
+		return
+	}
+	s.serveTerminal(w, r, sb, nil)
+}
+
+// serveTerminal proxies a shell in sb over the WebSocket; callers have
+// authorized access to sb. A reconnecting terminal reattaches to the shell the
+// last one left, replaying its scrollback, unless ?new=true asks for a fresh
+// shell. A terminal opened for a session also counts down to the session's
+// expiry.
+func (s *Server) serveTerminal(w http.ResponseWriter, r *http.Request, sb *models.Sandbox, session *models.Session) {
+	sandboxID := sb.ID
 	if sb.Status != "running" {
 		http.Error(w, "sandbox is not running", http.StatusBadRequest)
 		return
@@ -67,139 +128,462 @@ func (s *Server) handleTerminalWS(w http.ResponseWriter, r *http.Request) {
 
 	execCtx := context.Background()
 
-	execID, execConn, err := s.sandboxManager.ExecAttach(execCtx, sb.ContainerID)
+	// A client opting in with ?connect=true sends a connect message first,
+	// which may choose the command where the template allows it; any other
+	// first message is handled once the shell is attached. A timed out read
+	// leaves the connection unusable, so a client that sends nothing in time
+	// is disconnected. Other clients attach right away, as before.
+	var command []string
+	var pending *TerminalMessage
+	if r.URL.Query().Get("connect") == "true" {
+		conn.SetReadDeadline(time.Now().Add(connectTimeout))
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			s.sendTerminalError(conn, "expected a connect message")
+			return
+		}
+		conn.SetReadDeadline(time.Time{})
+
+		var msg TerminalMessage
+		if json.Unmarshal(message, &msg) == nil {
+			if msg.Type == "connect" {
+				command = msg.Command
+			} else {
+				pending = &msg
+			}
+		}
+	}
+	opts, err := s.sandboxManager.TerminalOptions(sb, command)
 	if err != nil {
-		slog.Error("failed to create exec session", "error", err)
-		s.sendTerminalError(conn, "failed to connect to container")
+		s.sendTerminalError(conn, "terminal commands are not allowed for this template")
 		return
 	}
-	defer execConn.Close()
 
-	slog.Info("exec session created", "sandbox_id", sandboxID, "exec_id", execID)
+	// Reattach to the sandbox's shell unless a fresh one is asked for; a
+	// command always runs in a new one
+	fresh := r.URL.Query().Get("new") == "true" || len(command) > 0
+	sh, att, replay, err := s.shells.attach(sandboxID, fresh, func() (*shell, error) {
+		execID, execConn, err := s.sandboxManager.ExecAttach(execCtx, sb.ContainerID, opts)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("exec session created", "sandbox_id", sandboxID, "exec_id", execID)
+
+		// Set initial terminal size (80x24 default)
+		if err := s.sandboxManager.ExecResize(execCtx, execID, 24, 80); err != nil {
+			slog.Warn("failed to set initial terminal size", "error", err)
+		}
 
-	// Set initial terminal size (80x24 default)
-	if err := s.sandboxManager.ExecResize(execCtx, execID, 24, 80); err != nil {
-		slog.Warn("failed to set initial terminal size", "error", err)
+		// A shell whose recording can't be started still opens
+		recorder, err := s.sandboxManager.StartRecording(execCtx, sb, session, 80, 24)
+		if err != nil {
+			slog.Error("failed to start terminal recording", "error", err, "sandbox_id", sandboxID)
+		}
+		return &shell{execID: execID, conn: execConn, recorder: recorder}, nil
+	})
+	if err != nil {
+		slog.Error("failed to create exec session", "error", err)
+		s.sendTerminalError(conn, "failed to connect to container")
+		return
 	}
+	defer s.shells.detach(sh, att)
 
+	connected := "Connected to sandbox terminal"
+	if len(replay) > 0 {
+		connected = "Reconnected to sandbox terminal"
+		slog.Info("terminal reattached to exec session", "sandbox_id", sandboxID, "exec_id", sh.execID)
+	}
 	s.sendTerminalMessage(conn, TerminalMessage{
 		Type: "connected",
-		Data: "Connected to sandbox terminal",
+		Data: connected,
 	})
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Set up pong handler — reset read deadline when pong received
-	conn.SetReadDeadline(time.Now().Add(pingInterval + pongTimeout))
-	conn.SetPongHandler(func(string) error {
-		conn.SetReadDeadline(time.Now().Add(pingInterval + pongTimeout))
-		return nil
-	})
+	// Opening a terminal counts as activity for idle detection
+	if err := s.sandboxManager.RecordActivity(ctx, sandboxID); err != nil {
+		slog.Warn("failed to record terminal activity", "sandbox_id", sandboxID, "error", err)
+	}
 
 	var writeMu sync.Mutex
 	var wg sync.WaitGroup
+	output := &terminalOutput{conn: conn, mu: &writeMu}
 
-	// Ping goroutine — keeps connection alive through Cloudflare/nginx
+	keepAlive(ctx, cancel, &wg, conn, &writeMu, sandboxID)
+	if session != nil {
+		// Kept for the session's activity report
+		defer s.sandboxManager.TrackSessionConnection(ctx, session.ID)()
+
+		s.sessionCountdown(ctx, &wg, conn, &writeMu, session.ID, sandboxID, func() {
+			cancel()
+			s.shells.close(sh)
+			conn.Close()
+		})
+	}
+
+	// Shell output (the replayed scrollback first) -> send to WebSocket
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		defer cancel()
-		ticker := time.NewTicker(pingInterval)
-		defer ticker.Stop()
+		if len(replay) > 0 && output.send(replay) != nil {
+			return
+		}
 		for {
 			select {
 			case <-ctx.Done():
 				return
-			case <-ticker.C:
+			case <-sh.exited:
+				s.sendShellExit(conn, &writeMu, sb, sh)
+				return
+			case <-att.done:
+				// Another terminal attached to the shell
 				writeMu.Lock()
-				conn.SetWriteDeadline(time.Now().Add(writeTimeout))
-				err := conn.WriteMessage(websocket.PingMessage, nil)
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseNormalClosure, "terminal attached elsewhere"),
+					time.Now().Add(writeTimeout))
 				writeMu.Unlock()
-				if err != nil {
-					slog.Debug("ping failed", "sandbox_id", sandboxID, "error", err)
+				return
+			case data := <-att.out:
+				if output.send(data) != nil {
 					return
 				}
 			}
 		}
 	}()
 
-	// Read from container -> send to WebSocket
+	// Read from WebSocket -> send to container
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		defer cancel()
-		buf := make([]byte, 4096)
+		var lastActivityCheck time.Time
+		handle := func(msg TerminalMessage) {
+			switch msg.Type {
+			case "input":
+				sh.conn.Write([]byte(msg.Data))
+				sh.recorder.Input([]byte(msg.Data))
+				if time.Since(lastActivityCheck) >= activityCheckInterval {
+					lastActivityCheck = time.Now()
+					s.handleTerminalActivity(conn, &writeMu, sandboxID)
+				}
+			case "binary":
+				output.setBinary(msg.Enabled)
+			case "resize":
+				if msg.Cols > 0 && msg.Rows > 0 {
+					if err := s.sandboxManager.ExecResize(execCtx, sh.execID, uint(msg.Rows), uint(msg.Cols)); err != nil {
+						slog.Debug("failed to resize terminal", "error", err, "cols", msg.Cols, "rows", msg.Rows)
+					} else {
+						sh.recorder.Resize(msg.Cols, msg.Rows)
+						slog.Debug("terminal resized", "cols", msg.Cols, "rows", msg.Rows)
+					}
+				}
+			}
+		}
+
+		if pending != nil {
+			handle(*pending)
+		}
 		for {
 			select {
 			case <-ctx.Done():
 				return
 			default:
-				n, err := execConn.Read(buf)
+				_, message, err := conn.ReadMessage()
 				if err != nil {
-					if err != io.EOF {
-						slog.Debug("exec read error", "error", err)
+					if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+						slog.Debug("websocket read error", "error", err)
 					}
 					return
 				}
-				if n > 0 {
+
+				var msg TerminalMessage
+				if err := json.Unmarshal(message, &msg); err != nil {
+					slog.Debug("invalid message format", "error", err)
+					continue
+				}
+				handle(msg)
+			}
+		}
+	}()
+
+	wg.Wait()
+	slog.Info("terminal websocket disconnected", "sandbox_id", sandboxID)
+}
+
+// sendShellExit tells a terminal how its shell ended, with its exit status
+// or, when the sandbox stopped under it, sandbox_stopped, then closes the
+// connection normally
+func (s *Server) sendShellExit(conn *websocket.Conn, writeMu *sync.Mutex, sb *models.Sandbox, sh *shell) {
+	reason := "shell exited"
+	exit, err := s.sandboxManager.ExecExit(context.Background(), sb.ContainerID, sh.execID)
+	if err != nil {
+		slog.Warn("failed to inspect terminal exec", "error", err, "sandbox_id", sb.ID, "exec_id", sh.execID)
+	}
+
+	writeMu.Lock()
+	defer writeMu.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	switch {
+	case exit == nil:
+	case exit.ContainerStopped:
+		reason = "sandbox stopped"
+		s.sendTerminalMessage(conn, TerminalMessage{Type: "sandbox_stopped"})
+	default:
+		s.sendTerminalMessage(conn, TerminalMessage{Type: "exit", Code: &exit.Code})
+	}
+	conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, reason),
+		time.Now().Add(writeTimeout))
+}
+
+// observeTerminal streams the output of the sandbox's shell over the
+// WebSocket, starting with its scrollback, alongside the terminal in control.
+// Input and resize messages are ignored. An observer that falls behind is
+// disconnected rather than holding the shell up.
+func (s *Server) observeTerminal(w http.ResponseWriter, r *http.Request, sb *models.Sandbox) {
+	sandboxID := sb.ID
+	if sb.Status != "running" {
+		http.Error(w, "sandbox is not running", http.StatusBadRequest)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.Error("failed to upgrade to websocket", "error", err)
+		return
+	}
+	defer conn.Close()
+
+	sh, o, replay := s.shells.observe(sandboxID)
+	if sh == nil {
+		s.sendTerminalError(conn, "no terminal to observe")
+		return
+	}
+	defer s.shells.unobserve(sh, o)
+
+	slog.Info("terminal observer connected", "sandbox_id", sandboxID, "exec_id", sh.execID)
+	s.sendTerminalMessage(conn, TerminalMessage{
+		Type: "connected",
+		Data: "Observing sandbox terminal",
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var writeMu sync.Mutex
+	var wg sync.WaitGroup
+	output := &terminalOutput{conn: conn, mu: &writeMu}
+
+	keepAlive(ctx, cancel, &wg, conn, &writeMu, sandboxID)
+
+	// Shell output -> send to WebSocket
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer cancel()
+		defer conn.Close() // ends the reader below
+		if len(replay) > 0 && output.send(replay) != nil {
+			return
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-sh.exited:
+				s.sendShellExit(conn, &writeMu, sb, sh)
+				return
+			case data, ok := <-o.out:
+				if !ok {
+					slog.Info("slow terminal observer dropped", "sandbox_id", sandboxID)
 					writeMu.Lock()
 					conn.SetWriteDeadline(time.Now().Add(writeTimeout))
-					err := s.sendTerminalMessage(conn, TerminalMessage{
-						Type: "output",
-						Data: string(buf[:n]),
-					})
+					s.sendTerminalError(conn, "observer fell behind the terminal output")
 					writeMu.Unlock()
-					if err != nil {
-						return
-					}
+					return
+				}
+				if output.send(data) != nil {
+					return
 				}
 			}
 		}
 	}()
 
-	// Read from WebSocket -> send to container
+	// Read from WebSocket so pongs arrive; observers can only choose the
+	// output framing, not type or resize
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer cancel()
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var msg TerminalMessage
+			if json.Unmarshal(message, &msg) == nil && msg.Type == "binary" {
+				output.setBinary(msg.Enabled)
+			}
+		}
+	}()
+
+	wg.Wait()
+	slog.Info("terminal observer disconnected", "sandbox_id", sandboxID)
+}
+
+// keepAlive expects a pong within pongTimeout of each ping and starts a
+// goroutine in wg that pings conn every pingInterval, cancelling when a ping
+// fails; this keeps connections alive through Cloudflare/nginx. Call it before
+// the connection's reader starts, which must keep reading for pongs to arrive.
+func keepAlive(ctx context.Context, cancel context.CancelFunc, wg *sync.WaitGroup, conn *websocket.Conn, writeMu *sync.Mutex, sandboxID string) {
+	// Reset the read deadline whenever a pong arrives
+	conn.SetReadDeadline(time.Now().Add(pingInterval + pongTimeout))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(pingInterval + pongTimeout))
+		return nil
+	})
+
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		defer cancel()
+		ticker := time.NewTicker(pingInterval)
+		defer ticker.Stop()
 		for {
 			select {
 			case <-ctx.Done():
 				return
-			default:
-				_, message, err := conn.ReadMessage()
+			case <-ticker.C:
+				writeMu.Lock()
+				conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+				err := conn.WriteMessage(websocket.PingMessage, nil)
+				writeMu.Unlock()
 				if err != nil {
-					if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-						slog.Debug("websocket read error", "error", err)
-					}
+					slog.Debug("ping failed", "sandbox_id", sandboxID, "error", err)
 					return
 				}
+			}
+		}
+	}()
+}
 
-				var msg TerminalMessage
-				if err := json.Unmarshal(message, &msg); err != nil {
-					slog.Debug("invalid message format", "error", err)
+// sessionCountdown starts a goroutine in wg that sends ttl_warning at each of
+// the configured times before the session expires, and that sends
+// session_expired and ends the connection with end once the cleaner expires
+// the session. The expiry is re-read at least every countdownRecheck, so
+// extensions move the warnings.
+func (s *Server) sessionCountdown(ctx context.Context, wg *sync.WaitGroup, conn *websocket.Conn, writeMu *sync.Mutex, sessionID, sandboxID string, end func()) {
+	notices, unsubscribe := s.sandboxManager.SubscribeSandbox(sandboxID)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer unsubscribe()
+
+		var expiresAt time.Time
+		warned := time.Duration(math.MaxInt64) // smallest threshold warned about for expiresAt
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case notice := <-notices:
+				if notice != sandbox.NoticeSessionExpired {
 					continue
 				}
+				writeMu.Lock()
+				conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+				s.sendTerminalMessage(conn, TerminalMessage{Type: "session_expired"})
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseNormalClosure, "session expired"),
+					time.Now().Add(writeTimeout))
+				writeMu.Unlock()
+				slog.Info("session terminal closed on expiry", "session_id", sessionID, "sandbox_id", sandboxID)
+				end()
+				return
+			case <-timer.C:
+			}
 
-				switch msg.Type {
-				case "input":
-					execConn.Write([]byte(msg.Data))
-				case "resize":
-					if msg.Cols > 0 && msg.Rows > 0 {
-						if err := s.sandboxManager.ExecResize(execCtx, execID, uint(msg.Rows), uint(msg.Cols)); err != nil {
-							slog.Debug("failed to resize terminal", "error", err, "cols", msg.Cols, "rows", msg.Rows)
-						} else {
-							slog.Debug("terminal resized", "cols", msg.Cols, "rows", msg.Rows)
-						}
-					}
+			session, err := s.sandboxManager.GetSessionByID(ctx, sessionID)
+			if err != nil || session.ExpiresAt == nil {
+				timer.Reset(countdownRecheck)
+				continue
+			}
+			if !session.ExpiresAt.Equal(expiresAt) {
+				expiresAt = *session.ExpiresAt
+				warned = math.MaxInt64
+			}
+
+			remaining := time.Until(expiresAt)
+			due, wait := nextTTLWarning(s.config.SessionTTLWarnings, warned, remaining)
+			if due > 0 {
+				warned = due
+				if remaining > 0 {
+					writeMu.Lock()
+					conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+					s.sendTerminalMessage(conn, TerminalMessage{
+						Type:             "ttl_warning",
+						SecondsRemaining: int(math.Ceil(remaining.Seconds())),
+					})
+					writeMu.Unlock()
 				}
 			}
+			if wait <= 0 || wait > countdownRecheck {
+				wait = countdownRecheck
+			}
+			timer.Reset(wait)
 		}
 	}()
+}
 
-	wg.Wait()
-	slog.Info("terminal websocket disconnected", "sandbox_id", sandboxID)
+// nextTTLWarning looks at the thresholds below warned: due is the smallest one
+// that remaining has reached, and wait is how long until remaining reaches
+// the next one. Either is 0 when there is none.
+func nextTTLWarning(thresholds []time.Duration, warned, remaining time.Duration) (due, wait time.Duration) {
+	for _, t := range thresholds {
+		if t >= warned {
+			continue
+		}
+		if remaining <= t {
+			if due == 0 || t < due {
+				due = t
+			}
+		} else if w := remaining - t; wait == 0 || w < wait {
+			wait = w
+		}
+	}
+	return due, wait
+}
+
+// handleTerminalActivity records activity for idle detection, applies the
+// auto-extend policy and notifies the client when the sandbox TTL was extended,
+// so the countdown UI can update
+func (s *Server) handleTerminalActivity(conn *websocket.Conn, writeMu *sync.Mutex, sandboxID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+
+	if err := s.sandboxManager.RecordActivity(ctx, sandboxID); err != nil {
+		slog.Warn("failed to record terminal activity", "sandbox_id", sandboxID, "error", err)
+	}
+
+	expiresAt, err := s.sandboxManager.AutoExtendTTL(ctx, sandboxID)
+	if err != nil {
+		slog.Warn("failed to auto-extend sandbox TTL", "sandbox_id", sandboxID, "error", err)
+		return
+	}
+	if expiresAt == nil {
+		return
+	}
+
+	writeMu.Lock()
+	defer writeMu.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	s.sendTerminalMessage(conn, TerminalMessage{
+		Type:      "ttl_extended",
+		ExpiresAt: expiresAt,
+	})
 }
 
 func (s *Server) sendTerminalMessage(conn *websocket.Conn, msg TerminalMessage) error {
@@ -215,6 +599,39 @@ func (s *Server) sendTerminalMessage(conn *websocket.Conn, msg TerminalMessage)
 	return nil
 }
 
+// terminalOutput sends shell output to a terminal WebSocket as output
+// messages or, once the client switched to binary mode, as binary frames of
+// the raw bytes. mu serializes the writes to the connection.
+type terminalOutput struct {
+	conn   *websocket.Conn
+	mu     *sync.Mutex
+	binary bool // guarded by mu
+}
+
+func (o *terminalOutput) send(data []byte) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if o.binary {
+		return o.conn.WriteMessage(websocket.BinaryMessage, data)
+	}
+	data, err := json.Marshal(TerminalMessage{Type: "output", Data: string(data)})
+	if err != nil {
+		return err
+	}
+	return o.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// setBinary switches the framing of output and acknowledges it with a binary
+// message; output sent after the acknowledgement uses the new framing
+func (o *terminalOutput) setBinary(enabled bool) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.binary = enabled
+	o.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return o.conn.WriteJSON(TerminalMessage{Type: "binary", Enabled: enabled})
+}
+
 func (s *Server) sendTerminalError(conn *websocket.Conn, message string) {
 	s.sendTerminalMessage(conn, TerminalMessage{
 		Type: "error",
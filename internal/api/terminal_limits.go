@@ -0,0 +1,96 @@
+package api
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/terra-clan/sandbox-engine/internal/metrics"
+)
+
+// terminalLimiter counts the open terminal WebSockets of this replica, per
+// sandbox and per API client, and turns away connections over the limits.
+// A limit of 0 is no limit.
+type terminalLimiter struct {
+	maxPerSandbox int
+	maxPerClient  int
+	metrics       *metrics.Metrics
+
+	mu        sync.Mutex
+	total     int
+	sandboxes map[string]int
+	clients   map[int]int
+}
+
+func newTerminalLimiter(maxPerSandbox, maxPerClient int, m *metrics.Metrics) *terminalLimiter {
+	return &terminalLimiter{
+		maxPerSandbox: maxPerSandbox,
+		maxPerClient:  maxPerClient,
+		metrics:       m,
+		sandboxes:     make(map[string]int),
+		clients:       make(map[int]int),
+	}
+}
+
+// acquire counts a terminal of clientID (0 for session terminals, which have
+// no client) on sandboxID, returning the function that stops counting it. It
+// reports which limit is reached instead when the terminal is over one.
+func (l *terminalLimiter) acquire(sandboxID string, clientID int) (release func(), limit string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch {
+	case l.maxPerSandbox > 0 && l.sandboxes[sandboxID] >= l.maxPerSandbox:
+		l.metrics.TerminalRejected("sandbox")
+		return nil, "sandbox"
+	case clientID != 0 && l.maxPerClient > 0 && l.clients[clientID] >= l.maxPerClient:
+		l.metrics.TerminalRejected("client")
+		return nil, "client"
+	}
+
+	l.sandboxes[sandboxID]++
+	if clientID != 0 {
+		l.clients[clientID]++
+	}
+	l.total++
+	l.metrics.TerminalConnections(l.total)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			if l.sandboxes[sandboxID]--; l.sandboxes[sandboxID] == 0 {
+				delete(l.sandboxes, sandboxID)
+			}
+			if clientID != 0 {
+				if l.clients[clientID]--; l.clients[clientID] == 0 {
+					delete(l.clients, clientID)
+				}
+			}
+			l.total--
+			l.metrics.TerminalConnections(l.total)
+		})
+	}, ""
+}
+
+// count returns the number of open terminals on sandboxID
+func (l *terminalLimiter) count(sandboxID string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.sandboxes[sandboxID]
+}
+
+// acquireTerminal counts a terminal about to be upgraded, answering 429 before
+// the upgrade when it is over a limit. Callers defer the returned release, so
+// every way out of the connection, panics included, stops counting it.
+func (s *Server) acquireTerminal(w http.ResponseWriter, r *http.Request, sandboxID string) func() {
+	clientID := 0
+	if client := ClientFromContext(r.Context()); client != nil {
+		clientID = client.ID
+	}
+	release, limit := s.terminals.acquire(sandboxID, clientID)
+	if release == nil {
+		w.Header().Set("Retry-After", "30")
+		http.Error(w, "too many terminal connections for this "+limit, http.StatusTooManyRequests)
+	}
+	return release
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+func TestTerminalLimiter(t *testing.T) {
+	l := newTerminalLimiter(2, 3, nil)
+
+	a1, _ := l.acquire("sb-a", 1)
+	a2, _ := l.acquire("sb-a", 1)
+	if a1 == nil || a2 == nil {
+		t.Fatal("expected two terminals on the sandbox")
+	}
+	if release, limit := l.acquire("sb-a", 2); release != nil || limit != "sandbox" {
+		t.Fatalf("expected the sandbox limit, got %q", limit)
+	}
+
+	b1, _ := l.acquire("sb-b", 1)
+	if b1 == nil {
+		t.Fatal("expected a terminal on another sandbox")
+	}
+	if release, limit := l.acquire("sb-c", 1); release != nil || limit != "client" {
+		t.Fatalf("expected the client limit, got %q", limit)
+	}
+	// Session terminals have no client and only count against the sandbox
+	if release, _ := l.acquire("sb-c", 0); release == nil {
+		t.Fatal("expected a session terminal")
+	}
+
+	// Releasing twice counts once
+	a1()
+	a1()
+	if n := l.count("sb-a"); n != 1 {
+		t.Errorf("expected 1 terminal on sb-a, got %d", n)
+	}
+	if release, _ := l.acquire("sb-a", 1); release == nil {
+		t.Error("expected room on sb-a after a release")
+	}
+}
+
+func TestAcquireTerminalOverLimit(t *testing.T) {
+	s := &Server{terminals: newTerminalLimiter(1, 0, nil)}
+	req := httptest.NewRequest(http.MethodGet, "/ws/terminal/sb-1", nil)
+	req = req.WithContext(ContextWithClient(req.Context(), &models.ApiClient{ID: 7}))
+
+	release := s.acquireTerminal(httptest.NewRecorder(), req, "sb-1")
+	if release == nil {
+		t.Fatal("expected the first terminal admitted")
+	}
+	defer release()
+
+	rec := httptest.NewRecorder()
+	if s.acquireTerminal(rec, req, "sb-1") != nil {
+		t.Fatal("expected the second terminal turned away")
+	}
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("expected 429 with Retry-After, got %d %v", rec.Code, rec.Header())
+	}
+}
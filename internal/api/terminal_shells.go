@@ -0,0 +1,272 @@
+package api
+
+import (
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/terra-clan/sandbox-engine/internal/sandbox"
+)
+
+// shellScrollback bounds the output a shell keeps to replay on reattach
+const shellScrollback = 64 << 10
+
+// shellObserverBuffer is how many output chunks an observer may fall behind
+// before it is dropped
+const shellObserverBuffer = 256
+
+// shellHub keeps sandbox terminal shells alive across WebSocket reconnects, so
+// a dropped connection doesn't lose the candidate's running process. Each
+// sandbox has at most one shell to reattach to. A shell's output is read
+// continuously into its scrollback and forwarded to the terminal attached to
+// it, if any, and to its read-only observers; a shell left detached for the
+// grace period is closed.
+type shellHub struct {
+	grace time.Duration
+
+	mu     sync.Mutex
+	shells map[string]*shell
+}
+
+// shell is an exec session running a login shell in a sandbox
+type shell struct {
+	sandboxID string
+	execID    string
+	conn      io.ReadWriteCloser
+	recorder  *sandbox.TerminalRecorder // nil when the shell isn't recorded
+	exited    chan struct{}             // closed once the exec's output has ended
+
+	mu         sync.Mutex
+	scrollback []byte
+	attached   *shellAttachment
+	observers  map[*shellObserver]struct{}
+	reap       *time.Timer
+	closed     bool
+}
+
+// shellAttachment receives a shell's output on out until done is closed, when
+// the terminal detaches or another one attaches to the shell
+type shellAttachment struct {
+	out  chan []byte
+	done chan struct{}
+}
+
+// shellObserver receives a shell's output on out without controlling it. out
+// is closed when the observer falls shellObserverBuffer chunks behind, so a
+// slow observer never stalls the terminal in control.
+type shellObserver struct {
+	out chan []byte
+}
+
+func newShellHub(grace time.Duration) *shellHub {
+	return &shellHub{
+		grace:  grace,
+		shells: make(map[string]*shell),
+	}
+}
+
+// attach attaches a terminal to the shell of sandboxID, detaching the terminal
+// attached to it before. Unless fresh is set, an existing shell is reused and
+// its scrollback returned to replay; otherwise, or when there is none, a new
+// shell is opened with open and replaces the sandbox's shell.
+func (h *shellHub) attach(sandboxID string, fresh bool, open func() (*shell, error)) (*shell, *shellAttachment, []byte, error) {
+	if !fresh {
+		h.mu.Lock()
+		sh := h.shells[sandboxID]
+		h.mu.Unlock()
+		if sh != nil {
+			if att, replay, ok := sh.attach(); ok {
+				return sh, att, replay, nil
+			}
+		}
+	}
+
+	sh, err := open()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	sh.sandboxID, sh.exited = sandboxID, make(chan struct{})
+	att, _, _ := sh.attach()
+
+	h.mu.Lock()
+	old := h.shells[sandboxID]
+	h.shells[sandboxID] = sh
+	h.mu.Unlock()
+	if old != nil {
+		// A replaced shell can't be reattached to; it lives while still attached
+		old.mu.Lock()
+		detached := old.attached == nil
+		old.mu.Unlock()
+		if detached {
+			h.close(old)
+		}
+	}
+
+	go h.pump(sh)
+	return sh, att, nil, nil
+}
+
+// observe adds an observer to the shell of sandboxID, returning the shell and
+// its scrollback to replay, or a nil shell when the sandbox has none
+func (h *shellHub) observe(sandboxID string) (*shell, *shellObserver, []byte) {
+	h.mu.Lock()
+	sh := h.shells[sandboxID]
+	h.mu.Unlock()
+	if sh == nil {
+		return nil, nil, nil
+	}
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if sh.closed {
+		return nil, nil, nil
+	}
+	o := &shellObserver{out: make(chan []byte, shellObserverBuffer)}
+	if sh.observers == nil {
+		sh.observers = make(map[*shellObserver]struct{})
+	}
+	sh.observers[o] = struct{}{}
+	return sh, o, append([]byte(nil), sh.scrollback...)
+}
+
+// unobserve removes an observer from its shell
+func (h *shellHub) unobserve(sh *shell, o *shellObserver) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	delete(sh.observers, o)
+}
+
+// detach ends att, keeping its shell for the grace period while it is still
+// the sandbox's shell
+func (h *shellHub) detach(sh *shell, att *shellAttachment) {
+	h.mu.Lock()
+	current := h.shells[sh.sandboxID] == sh
+	h.mu.Unlock()
+
+	sh.mu.Lock()
+	if sh.attached != att {
+		// Another terminal attached to the shell
+		sh.mu.Unlock()
+		return
+	}
+	sh.attached = nil
+	close(att.done)
+	if current && h.grace > 0 && !sh.closed {
+		sh.reap = time.AfterFunc(h.grace, func() { h.reap(sh) })
+		sh.mu.Unlock()
+		return
+	}
+	sh.mu.Unlock()
+	h.close(sh)
+}
+
+// reap closes sh if no terminal reattached to it within the grace period
+func (h *shellHub) reap(sh *shell) {
+	sh.mu.Lock()
+	detached := sh.attached == nil
+	sh.mu.Unlock()
+	if detached {
+		slog.Info("detached terminal shell closed", "sandbox_id", sh.sandboxID, "exec_id", sh.execID)
+		h.close(sh)
+	}
+}
+
+// close ends sh's exec session and forgets it
+func (h *shellHub) close(sh *shell) {
+	sh.mu.Lock()
+	if sh.closed {
+		sh.mu.Unlock()
+		return
+	}
+	sh.closed = true
+	if sh.reap != nil {
+		sh.reap.Stop()
+	}
+	sh.mu.Unlock()
+	sh.conn.Close()
+	sh.recorder.Close()
+
+	h.mu.Lock()
+	if h.shells[sh.sandboxID] == sh {
+		delete(h.shells, sh.sandboxID)
+	}
+	h.mu.Unlock()
+}
+
+// pump reads sh's output until the exec ends, e.g. the candidate exits the
+// shell or the sandbox stops
+func (h *shellHub) pump(sh *shell) {
+	defer close(sh.exited)
+	defer h.close(sh)
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := sh.conn.Read(buf)
+		if n > 0 {
+			data := append([]byte(nil), buf[:n]...)
+			sh.recorder.Output(data)
+			sh.mu.Lock()
+			sh.scrollback = appendScrollback(sh.scrollback, data)
+			att := sh.attached
+			for o := range sh.observers {
+				select {
+				case o.out <- data:
+				default:
+					close(o.out)
+					delete(sh.observers, o)
+				}
+			}
+			sh.mu.Unlock()
+
+			// Output the terminal detached from in the meantime is in the scrollback
+			if att != nil {
+				select {
+				case att.out <- data:
+				case <-att.done:
+				}
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				slog.Debug("exec read error", "error", err, "sandbox_id", sh.sandboxID)
+			}
+			return
+		}
+	}
+}
+
+// attach makes a new attachment the shell's, returning its scrollback, or
+// reports false when the shell has been closed
+func (sh *shell) attach() (*shellAttachment, []byte, bool) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if sh.closed {
+		return nil, nil, false
+	}
+	if sh.reap != nil {
+		sh.reap.Stop()
+		sh.reap = nil
+	}
+	if sh.attached != nil {
+		close(sh.attached.done)
+	}
+
+	sh.attached = &shellAttachment{out: make(chan []byte), done: make(chan struct{})}
+	return sh.attached, append([]byte(nil), sh.scrollback...), true
+}
+
+// appendScrollback appends data to buf, keeping the last shellScrollback bytes
+// and never starting in the middle of a UTF-8 sequence
+func appendScrollback(buf, data []byte) []byte {
+	buf = append(buf, data...)
+	if len(buf) <= shellScrollback {
+		return buf
+	}
+	buf = buf[len(buf)-shellScrollback:]
+	for i := 0; i < utf8.UTFMax && len(buf) > 0 && !utf8.RuneStart(buf[0]); i++ {
+		buf = buf[1:]
+	}
+	return buf
+}
@@ -0,0 +1,533 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/terra-clan/sandbox-engine/internal/config"
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/sandbox"
+	"github.com/terra-clan/sandbox-engine/internal/templates"
+)
+
+func TestNextTTLWarning(t *testing.T) {
+	thresholds := []time.Duration{15 * time.Minute, 5 * time.Minute, time.Minute}
+	none := time.Duration(1<<63 - 1)
+
+	tests := []struct {
+		name      string
+		warned    time.Duration
+		remaining time.Duration
+		due, wait time.Duration
+	}{
+		{"before the first", none, 20 * time.Minute, 0, 5 * time.Minute},
+		{"at the first", none, 15 * time.Minute, 15 * time.Minute, 10 * time.Minute},
+		{"joined late", none, 3 * time.Minute, 5 * time.Minute, 2 * time.Minute},
+		{"already warned", 5 * time.Minute, 3 * time.Minute, 0, 2 * time.Minute},
+		{"after the last", time.Minute, 30 * time.Second, 0, 0},
+	}
+	for _, tt := range tests {
+		due, wait := nextTTLWarning(thresholds, tt.warned, tt.remaining)
+		if due != tt.due || wait != tt.wait {
+			t.Errorf("%s: expected due %s wait %s, got %s %s", tt.name, tt.due, tt.wait, due, wait)
+		}
+	}
+}
+
+// countdownManager serves one session and relays notices to its subscribers
+type countdownManager struct {
+	sandbox.Manager
+	session *models.Session
+
+	mu   sync.Mutex
+	subs []chan sandbox.SandboxNotice
+}
+
+func (m *countdownManager) GetSessionByID(ctx context.Context, id string) (*models.Session, error) {
+	return m.session, nil
+}
+
+func (m *countdownManager) SubscribeSandbox(sandboxID string) (<-chan sandbox.SandboxNotice, func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ch := make(chan sandbox.SandboxNotice, 1)
+	m.subs = append(m.subs, ch)
+	return ch, func() {}
+}
+
+func (m *countdownManager) NotifySandbox(sandboxID string, notice sandbox.SandboxNotice) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, ch := range m.subs {
+		ch <- notice
+	}
+}
+
+func TestSessionCountdown(t *testing.T) {
+	expiresAt := time.Now().Add(90 * time.Second)
+	manager := &countdownManager{session: &models.Session{ID: "s-1", SandboxID: "sb-1", ExpiresAt: &expiresAt}}
+	s := &Server{
+		config:         config.ServerConfig{SessionTTLWarnings: []time.Duration{5 * time.Minute, time.Minute}},
+		sandboxManager: manager,
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var wg sync.WaitGroup
+		var writeMu sync.Mutex
+		s.sessionCountdown(ctx, &wg, conn, &writeMu, "s-1", "sb-1", cancel)
+		wg.Wait()
+	}))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	// Connected inside the 5m window: warned at once with the time left
+	var msg TerminalMessage
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "ttl_warning" || msg.SecondsRemaining < 85 || msg.SecondsRemaining > 90 {
+		t.Fatalf("expected a ttl_warning with about 90s left, got %+v", msg)
+	}
+
+	manager.NotifySandbox("sb-1", sandbox.NoticeSessionExpired)
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "session_expired" {
+		t.Fatalf("expected session_expired, got %+v", msg)
+	}
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("expected the connection closed normally, got %v", err)
+	}
+}
+
+// shellManager opens execs over in-memory pipes, keeping the container ends
+type shellManager struct {
+	sandbox.Manager
+
+	mu    sync.Mutex
+	execs []net.Conn
+	opts  []sandbox.ExecAttachOptions
+	exit  sandbox.ExecExit
+}
+
+func (m *shellManager) TerminalOptions(sb *models.Sandbox, command []string) (sandbox.ExecAttachOptions, error) {
+	return sandbox.ExecAttachOptions{Cmd: command}, nil
+}
+
+func (m *shellManager) ExecAttach(ctx context.Context, containerID string, opts sandbox.ExecAttachOptions) (string, io.ReadWriteCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	server, container := net.Pipe()
+	m.execs = append(m.execs, container)
+	m.opts = append(m.opts, opts)
+	return fmt.Sprintf("exec-%d", len(m.execs)), server, nil
+}
+
+func (m *shellManager) ExecResize(ctx context.Context, execID string, height, width uint) error {
+	return nil
+}
+
+func (m *shellManager) ExecExit(ctx context.Context, containerID, execID string) (*sandbox.ExecExit, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	exit := m.exit
+	return &exit, nil
+}
+
+func (m *shellManager) RecordActivity(ctx context.Context, id string) error {
+	return nil
+}
+
+func (m *shellManager) StartRecording(ctx context.Context, sb *models.Sandbox, session *models.Session, cols, rows int) (*sandbox.TerminalRecorder, error) {
+	return nil, nil
+}
+
+func (m *shellManager) AutoExtendTTL(ctx context.Context, id string) (*time.Time, error) {
+	return nil, nil
+}
+
+func (m *shellManager) exec(i int) net.Conn {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if i >= len(m.execs) {
+		return nil
+	}
+	return m.execs[i]
+}
+
+func (m *shellManager) options(i int) sandbox.ExecAttachOptions {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.opts[i]
+}
+
+func TestTerminalReattach(t *testing.T) {
+	manager := &shellManager{}
+	s := &Server{sandboxManager: manager, templateLoader: templates.NewLoader(), shells: newShellHub(50 * time.Millisecond)}
+	sb := &models.Sandbox{ID: "sb-1", ContainerID: "c-1", Status: models.StatusRunning}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.serveTerminal(w, r, sb, nil)
+	}))
+	defer srv.Close()
+
+	dial := func(query string) *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		return conn
+	}
+	expect := func(conn *websocket.Conn, typ, data string) {
+		t.Helper()
+		var msg TerminalMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Type != typ || msg.Data != data {
+			t.Fatalf("expected %s %q, got %+v", typ, data, msg)
+		}
+	}
+	expectClosed := func(exec net.Conn) {
+		t.Helper()
+		exec.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := exec.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatalf("expected the exec closed, got %v", err)
+		}
+	}
+
+	first := dial("")
+	expect(first, "connected", "Connected to sandbox terminal")
+	manager.exec(0).Write([]byte("$ "))
+	expect(first, "output", "$ ")
+	first.Close()
+
+	// The reconnect gets the same shell and its output so far
+	second := dial("")
+	defer second.Close()
+	expect(second, "connected", "Reconnected to sandbox terminal")
+	expect(second, "output", "$ ")
+	second.WriteJSON(TerminalMessage{Type: "input", Data: "ls\n"})
+	buf := make([]byte, 16)
+	n, err := manager.exec(0).Read(buf)
+	if err != nil || string(buf[:n]) != "ls\n" {
+		t.Fatalf("expected the input on the first exec, got %q, %v", buf[:n], err)
+	}
+
+	// ?new=true opens a fresh shell; the replaced one ends with its terminal
+	third := dial("?new=true")
+	expect(third, "connected", "Connected to sandbox terminal")
+	if manager.exec(1) == nil {
+		t.Fatal("expected a second exec")
+	}
+	second.Close()
+	expectClosed(manager.exec(0))
+
+	// A shell nobody reattaches to is closed after the grace period
+	third.Close()
+	expectClosed(manager.exec(1))
+}
+
+func TestTerminalObserve(t *testing.T) {
+	manager := &shellManager{}
+	s := &Server{sandboxManager: manager, templateLoader: templates.NewLoader(), shells: newShellHub(time.Minute)}
+	sb := &models.Sandbox{ID: "sb-1", ContainerID: "c-1", Status: models.StatusRunning}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("mode") == "observe" {
+			s.observeTerminal(w, r, sb)
+			return
+		}
+		s.serveTerminal(w, r, sb, nil)
+	}))
+	defer srv.Close()
+
+	dial := func(query string) *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		return conn
+	}
+	expect := func(conn *websocket.Conn, typ, data string) {
+		t.Helper()
+		var msg TerminalMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Type != typ || msg.Data != data {
+			t.Fatalf("expected %s %q, got %+v", typ, data, msg)
+		}
+	}
+
+	// Without a shell there is nothing to observe
+	early := dial("?mode=observe")
+	expect(early, "error", "no terminal to observe")
+	early.Close()
+
+	terminal := dial("")
+	defer terminal.Close()
+	expect(terminal, "connected", "Connected to sandbox terminal")
+	manager.exec(0).Write([]byte("$ "))
+	expect(terminal, "output", "$ ")
+
+	observer := dial("?mode=observe")
+	defer observer.Close()
+	expect(observer, "connected", "Observing sandbox terminal")
+	expect(observer, "output", "$ ")
+
+	// Observer input never reaches the shell
+	observer.WriteJSON(TerminalMessage{Type: "input", Data: "rm -rf /\n"})
+	terminal.WriteJSON(TerminalMessage{Type: "input", Data: "ls\n"})
+	buf := make([]byte, 16)
+	n, err := manager.exec(0).Read(buf)
+	if err != nil || string(buf[:n]) != "ls\n" {
+		t.Fatalf("expected only the terminal's input, got %q, %v", buf[:n], err)
+	}
+
+	// Both see live output
+	manager.exec(0).Write([]byte("file.txt\n"))
+	expect(terminal, "output", "file.txt\n")
+	expect(observer, "output", "file.txt\n")
+}
+
+func TestTerminalConnectCommand(t *testing.T) {
+	loader := templates.NewLoader()
+	loader.Add(&models.Template{Name: "repl", Terminal: &models.TerminalSpec{AllowCommand: true}})
+	manager := &shellManager{}
+	s := &Server{sandboxManager: manager, templateLoader: loader, shells: newShellHub(time.Minute)}
+	sb := &models.Sandbox{ID: "sb-1", ContainerID: "c-1", TemplateID: "repl", Status: models.StatusRunning}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.serveTerminal(w, r, sb, nil)
+	}))
+	defer srv.Close()
+
+	dial := func(query string, first TerminalMessage) *websocket.Conn {
+		t.Helper()
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		conn.WriteJSON(first)
+		var msg TerminalMessage
+		if err := conn.ReadJSON(&msg); err != nil || msg.Type != "connected" {
+			t.Fatalf("expected connected, got %+v, %v", msg, err)
+		}
+		return conn
+	}
+
+	// The connect message picks the command
+	repl := dial("?connect=true", TerminalMessage{Type: "connect", Command: []string{"python3"}})
+	defer repl.Close()
+	if opts := manager.options(0); !slices.Equal(opts.Cmd, []string{"python3"}) {
+		t.Fatalf("expected python3 to run, got %+v", opts)
+	}
+
+	// Any other first message opens the default shell and is then handled
+	shell := dial("?connect=true&new=true", TerminalMessage{Type: "input", Data: "ls\n"})
+	defer shell.Close()
+	buf := make([]byte, 16)
+	n, err := manager.exec(1).Read(buf)
+	if err != nil || string(buf[:n]) != "ls\n" {
+		t.Fatalf("expected the first input on the shell, got %q, %v", buf[:n], err)
+	}
+	if opts := manager.options(1); opts.Cmd != nil {
+		t.Errorf("expected the default shell, got %+v", opts)
+	}
+
+	// Without ?connect=true the terminal attaches before the client says anything
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"?new=true", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var msg TerminalMessage
+	if err := conn.ReadJSON(&msg); err != nil || msg.Type != "connected" {
+		t.Fatalf("expected connected without a connect message, got %+v, %v", msg, err)
+	}
+}
+
+func TestTerminalShellExit(t *testing.T) {
+	manager := &shellManager{}
+	s := &Server{sandboxManager: manager, templateLoader: templates.NewLoader(), shells: newShellHub(time.Minute)}
+	sb := &models.Sandbox{ID: "sb-1", ContainerID: "c-1", Status: models.StatusRunning}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.serveTerminal(w, r, sb, nil)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		exit sandbox.ExecExit
+		want string
+	}{
+		{exit: sandbox.ExecExit{Code: 0}, want: `{"type":"exit","code":0}`},
+		{exit: sandbox.ExecExit{Code: 137}, want: `{"type":"exit","code":137}`},
+		{exit: sandbox.ExecExit{Code: 137, ContainerStopped: true}, want: `{"type":"sandbox_stopped"}`},
+	}
+	for i, tt := range tests {
+		manager.mu.Lock()
+		manager.exit = tt.exit
+		manager.mu.Unlock()
+
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var connected TerminalMessage
+		if err := conn.ReadJSON(&connected); err != nil {
+			t.Fatal(err)
+		}
+
+		// The shell ends on the container side
+		manager.exec(i).Close()
+		_, data, err := conn.ReadMessage()
+		if err != nil || string(data) != tt.want {
+			t.Errorf("expected %s, got %s, %v", tt.want, data, err)
+		}
+		if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+			t.Errorf("expected a normal close, got %v", err)
+		}
+		conn.Close()
+	}
+}
+
+func TestTerminalBinaryOutput(t *testing.T) {
+	manager := &shellManager{}
+	s := &Server{sandboxManager: manager, templateLoader: templates.NewLoader(), shells: newShellHub(time.Minute)}
+	sb := &models.Sandbox{ID: "sb-1", ContainerID: "c-1", Status: models.StatusRunning}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.serveTerminal(w, r, sb, nil)
+	}))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+	var msg TerminalMessage
+	if err := conn.ReadJSON(&msg); err != nil || msg.Type != "connected" {
+		t.Fatalf("expected connected, got %+v, %v", msg, err)
+	}
+	conn.WriteJSON(TerminalMessage{Type: "binary", Enabled: true})
+	if err := conn.ReadJSON(&msg); err != nil || msg.Type != "binary" || !msg.Enabled {
+		t.Fatalf("expected binary mode acknowledged, got %+v, %v", msg, err)
+	}
+
+	// Arbitrary bytes, invalid UTF-8 included, come through unchanged
+	want := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(want)
+	go manager.exec(0).Write(want)
+
+	var got []byte
+	for len(got) < len(want) {
+		typ, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read failed after %d bytes: %v", len(got), err)
+		}
+		if typ != websocket.BinaryMessage {
+			t.Fatalf("expected binary frames, got a text frame %q", data)
+		}
+		got = append(got, data...)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("1MB of output differs after the round trip (%d bytes received)", len(got))
+	}
+
+	// Control messages stay JSON text frames
+	conn.WriteJSON(TerminalMessage{Type: "binary", Enabled: false})
+	if typ, data, err := conn.ReadMessage(); err != nil || typ != websocket.TextMessage || !strings.Contains(string(data), `"binary"`) {
+		t.Fatalf("expected a text acknowledgement, got %d %q, %v", typ, data, err)
+	}
+}
+
+func TestShellObserverDropped(t *testing.T) {
+	h := newShellHub(time.Minute)
+	server, container := net.Pipe()
+	defer container.Close()
+	sh, att, _, err := h.attach("sb-1", false, func() (*shell, error) {
+		return &shell{execID: "exec-1", conn: server}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for range att.out {
+		}
+	}()
+
+	_, o, _ := h.observe("sb-1")
+	// The pump is done with a chunk once it reads the next, so the chunk over
+	// the buffer has been handed out when the write after it returns
+	for i := 0; i < shellObserverBuffer+2; i++ {
+		container.Write([]byte("x"))
+	}
+
+	// The terminal in control kept getting output while the observer was full
+	deadline := time.After(5 * time.Second)
+	for n := 0; ; n++ {
+		select {
+		case _, ok := <-o.out:
+			if !ok {
+				if n != shellObserverBuffer {
+					t.Errorf("expected %d chunks before the drop, got %d", shellObserverBuffer, n)
+				}
+				sh.mu.Lock()
+				defer sh.mu.Unlock()
+				if len(sh.observers) != 0 {
+					t.Error("expected the observer removed from the shell")
+				}
+				return
+			}
+		case <-deadline:
+			t.Fatalf("expected the slow observer dropped, got %d chunks", n)
+		}
+	}
+}
+
+func TestAppendScrollback(t *testing.T) {
+	// Dropping the first byte would split the é, so all of it is dropped
+	buf := appendScrollback([]byte("é"+strings.Repeat("a", shellScrollback-2)), []byte("!"))
+	if len(buf) != shellScrollback-1 || buf[0] != 'a' || buf[len(buf)-1] != '!' {
+		t.Errorf("expected the last bytes from a rune start, got %d bytes starting %q", len(buf), buf[:2])
+	}
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/terra-clan/sandbox-engine/internal/config"
+	"github.com/terra-clan/sandbox-engine/internal/tracing"
+)
+
+func TestTracingMiddleware(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	prev, prevProp := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prev)
+		otel.SetTextMapPropagator(prevProp)
+	})
+	// Without an endpoint Setup only installs the traceparent propagator
+	if _, err := tracing.Setup(context.Background(), config.TracingConfig{}); err != nil {
+		t.Fatal(err)
+	}
+
+	router := newMemoryServer(t)
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/templates/python-basic", nil)
+	req.Header.Set("Authorization", "Bearer "+memoryTestKey)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	health := httptest.NewRequest(http.MethodGet, "/health", nil)
+	router.ServeHTTP(httptest.NewRecorder(), health)
+
+	var server []sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.SpanKind().String() == "server" {
+			server = append(server, span)
+		}
+	}
+	if len(server) != 1 {
+		t.Fatalf("expected one server span (health checks are not traced), got %d", len(server))
+	}
+	span := server[0]
+	if span.Name() != "GET /api/v1/templates/{name}" {
+		t.Errorf("expected the span named after the route, got %q", span.Name())
+	}
+	if span.SpanContext().TraceID().String() != traceID || !span.Parent().IsRemote() {
+		t.Errorf("expected the incoming trace continued, got trace %s", span.SpanContext().TraceID())
+	}
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+const (
+	// usageDateLayout is the format of the from/to query parameters
+	usageDateLayout = "2006-01-02"
+	// defaultUsageDays is the report range when from is omitted
+	defaultUsageDays = 30
+)
+
+// usageOrEmpty shows templates and tasks that were never used as zero usage
+func usageOrEmpty(summary *models.UsageSummary) *models.UsageSummary {
+	if summary == nil {
+		return &models.UsageSummary{}
+	}
+	return summary
+}
+
+// handleTemplateUsage reports template and task usage for a range of UTC days.
+// from and to (YYYY-MM-DD, inclusive) default to the last 30 days.
+func (s *Server) handleTemplateUsage(w http.ResponseWriter, r *http.Request) {
+	to := time.Now().UTC().Truncate(24 * time.Hour)
+	if v := r.URL.Query().Get("to"); v != "" {
+		parsed, err := time.Parse(usageDateLayout, v)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "validation_error", "to must be a date (YYYY-MM-DD)")
+			return
+		}
+		to = parsed
+	}
+
+	from := to.AddDate(0, 0, -(defaultUsageDays - 1))
+	if v := r.URL.Query().Get("from"); v != "" {
+		parsed, err := time.Parse(usageDateLayout, v)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "validation_error", "from must be a date (YYYY-MM-DD)")
+			return
+		}
+		from = parsed
+	}
+
+	if from.After(to) {
+		respondError(w, http.StatusBadRequest, "validation_error", "from must not be after to")
+		return
+	}
+
+	report, err := s.sandboxManager.TemplateUsage(r.Context(), from, to)
+	if err != nil {
+		slog.Error("failed to get template usage", "error", err)
+		respondError(w, http.StatusInternalServerError, "internal_error", "failed to get template usage")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, report)
+}
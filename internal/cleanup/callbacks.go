@@ -0,0 +1,72 @@
+package cleanup
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/sandbox"
+)
+
+const (
+	// callbackRetryInterval is how often due callback deliveries are retried
+	callbackRetryInterval = 5 * time.Second
+
+	// callbackBatchSize is how many due deliveries one retry claims
+	callbackBatchSize = 50
+)
+
+// CallbackRetrier retries the session callback deliveries of the outbox that
+// failed or were cut off by a restart, until they are delivered or give up
+type CallbackRetrier struct {
+	manager  sandbox.Manager
+	interval time.Duration
+}
+
+// NewCallbackRetrier creates a new callback retry worker
+func NewCallbackRetrier(manager sandbox.Manager) *CallbackRetrier {
+	return &CallbackRetrier{manager: manager, interval: callbackRetryInterval}
+}
+
+// Start begins the callback retry worker in a goroutine
+func (r *CallbackRetrier) Start(ctx context.Context) {
+	go r.run(ctx)
+}
+
+// run is the main loop for the callback retry worker
+func (r *CallbackRetrier) run(ctx context.Context) {
+	slog.Info("callback retry worker started", "interval", r.interval)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	r.retry(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("callback retry worker stopped")
+			return
+		case <-ticker.C:
+			r.retry(ctx)
+		}
+	}
+}
+
+// retry makes the next attempt of due deliveries in batches until a batch is
+// not full. Claimed deliveries are rescheduled, so the loop ends.
+func (r *CallbackRetrier) retry(ctx context.Context) {
+	for ctx.Err() == nil {
+		n, err := r.manager.RetryCallbacks(ctx, callbackBatchSize)
+		if err != nil {
+			slog.Error("failed to retry session callbacks", "error", err)
+			return
+		}
+		if n > 0 {
+			slog.Debug("session callbacks retried", "count", n)
+		}
+		if n < callbackBatchSize {
+			return
+		}
+	}
+}
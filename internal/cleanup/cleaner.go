@@ -2,27 +2,105 @@ package cleanup
 
 import (
 	"context"
+	"errors"
 	"log/slog"
+	"sync"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/terra-clan/sandbox-engine/internal/config"
+	"github.com/terra-clan/sandbox-engine/internal/metrics"
+	"github.com/terra-clan/sandbox-engine/internal/models"
 	"github.com/terra-clan/sandbox-engine/internal/sandbox"
+	"github.com/terra-clan/sandbox-engine/internal/tracing"
+)
+
+// Default batching for expired sandboxes and sessions
+const (
+	defaultBatchSize   = 200
+	defaultCycleBudget = 2 * time.Minute
 )
 
-// Cleaner handles periodic cleanup of expired sandboxes
+// Default parallelism for deleting expired sandboxes
+const (
+	defaultConcurrency    = 5
+	defaultSandboxTimeout = time.Minute
+)
+
+// clientSweepInterval is how often expired API clients are deactivated
+const clientSweepInterval = 24 * time.Hour
+
+// Cleaner handles periodic cleanup of expired and idle sandboxes
 type Cleaner struct {
-	manager  sandbox.Manager
-	interval time.Duration
+	manager          sandbox.Manager
+	metrics          *metrics.Metrics
+	interval         time.Duration
+	idleTimeout      time.Duration
+	eventRetention   time.Duration
+	deletedRetention time.Duration
+	revokedRetention time.Duration
+	archiveRetention time.Duration
+	recordRetention  time.Duration
+	batchSize        int
+	cycleBudget      time.Duration
+	concurrency      int
+	sandboxTimeout   time.Duration
+	now              func() time.Time
+
+	lastClientSweep time.Time
 }
 
-// NewCleaner creates a new cleanup worker
-func NewCleaner(manager sandbox.Manager, interval time.Duration) *Cleaner {
+// sandboxOutcome is what became of one expired sandbox in a cycle
+type sandboxOutcome int
+
+const (
+	// sandboxDeleted is a sandbox gone, or going in the background
+	sandboxDeleted sandboxOutcome = iota
+	// sandboxSkipped is a sandbox not due yet or busy with another operation
+	sandboxSkipped
+	// sandboxFailed is a sandbox whose delete failed or timed out
+	sandboxFailed
+)
+
+// NewCleaner creates a new cleanup worker. engineMetrics may be nil.
+func NewCleaner(manager sandbox.Manager, cfg config.CleanupConfig, engineMetrics *metrics.Metrics) *Cleaner {
+	interval := cfg.Interval
 	if interval <= 0 {
 		interval = 5 * time.Minute
 	}
+	batchSize := cfg.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+	cycleBudget := cfg.CycleBudget
+	if cycleBudget <= 0 {
+		cycleBudget = defaultCycleBudget
+	}
+	concurrency := cfg.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+	sandboxTimeout := cfg.SandboxTimeout
+	if sandboxTimeout <= 0 {
+		sandboxTimeout = defaultSandboxTimeout
+	}
 
 	return &Cleaner{
-		manager:  manager,
-		interval: interval,
+		manager:          manager,
+		metrics:          engineMetrics,
+		interval:         interval,
+		idleTimeout:      cfg.IdleTimeout,
+		eventRetention:   cfg.EventRetention,
+		deletedRetention: cfg.DeletedRetention,
+		revokedRetention: cfg.RevokedRetention,
+		archiveRetention: cfg.SubmissionRetention,
+		recordRetention:  cfg.RecordingRetention,
+		batchSize:        batchSize,
+		cycleBudget:      cycleBudget,
+		concurrency:      concurrency,
+		sandboxTimeout:   sandboxTimeout,
+		now:              time.Now,
 	}
 }
 
@@ -33,86 +111,496 @@ func (c *Cleaner) Start(ctx context.Context) {
 
 // run is the main loop for the cleanup worker
 func (c *Cleaner) run(ctx context.Context) {
-	slog.Info("cleanup worker started", "interval", c.interval)
+	slog.Info("cleanup worker started", "interval", c.interval, "idle_timeout", c.idleTimeout)
+	c.loop(ctx, c.cleanup)
+	slog.Info("cleanup worker stopped")
+}
 
+// loop runs cycle right away and then every interval until ctx is done. The
+// interval counts from the end of a cycle: the ticker is reset after each
+// one, so a cycle running longer than the interval doesn't leave a tick
+// behind that starts the next one at once.
+func (c *Cleaner) loop(ctx context.Context, cycle func(context.Context)) {
 	ticker := time.NewTicker(c.interval)
 	defer ticker.Stop()
 
-	// Run immediately on start
-	c.cleanup(ctx)
+	cycle(ctx)
+	ticker.Reset(c.interval)
 
 	for {
 		select {
 		case <-ctx.Done():
-			slog.Info("cleanup worker stopped")
 			return
 		case <-ticker.C:
-			c.cleanup(ctx)
+			cycle(ctx)
+			ticker.Reset(c.interval)
 		}
 	}
 }
 
-// cleanup finds and removes expired sandboxes and sessions
+// cleanup finds and removes expired sandboxes and sessions, and stops idle
+// sandboxes
 func (c *Cleaner) cleanup(ctx context.Context) {
 	slog.Debug("running cleanup cycle")
 
-	c.cleanupSandboxes(ctx)
-	c.cleanupSessions(ctx)
+	start := c.now()
+	deadline := start.Add(c.cycleBudget)
+
+	c.failStuckProvisioning(ctx)
+	c.stopIdleSandboxes(ctx)
+	c.retryDeletions(ctx, deadline)
+	outcomes := c.cleanupSandboxes(ctx, deadline)
+	c.cleanupSessions(ctx, deadline)
+	c.purgeEvents(ctx)
+	c.purgeDeletedSandboxes(ctx)
+	c.purgeRevokedSessions(ctx)
+	c.purgeSubmissions(ctx)
+	c.purgeRecordings(ctx)
+	c.purgeCallbackDeliveries(ctx)
+	c.deactivateExpiredClients(ctx)
+	c.reportBacklog(ctx)
+	c.reportCycle(start, outcomes)
+}
+
+// cleanupSandboxes deletes expired sandboxes in batches, oldest first, until
+// a batch comes back empty or the cycle deadline passes. It returns the
+// outcome of each sandbox found; one failing to delete comes back in later
+// batches, and its last outcome counts.
+func (c *Cleaner) cleanupSandboxes(ctx context.Context, deadline time.Time) map[string]sandboxOutcome {
+	outcomes := make(map[string]sandboxOutcome)
+	for batch := 1; ctx.Err() == nil; batch++ {
+		expired, err := c.manager.GetExpired(ctx, c.batchSize)
+		if err != nil {
+			slog.Error("failed to get expired sandboxes", "error", err)
+			return outcomes
+		}
+
+		if len(expired) == 0 {
+			if batch == 1 {
+				slog.Debug("no expired sandboxes found")
+			}
+			return outcomes
+		}
+
+		deleted := 0
+		for i, o := range c.deleteBatch(ctx, expired, c.deleteExpiredSandbox) {
+			outcomes[expired[i].ID] = o
+			if o == sandboxDeleted {
+				deleted++
+			}
+		}
+
+		slog.Info("expired sandbox batch cleaned up", "batch", batch, "count", len(expired), "deleted", deleted)
+
+		// Sandboxes that failed to delete come back first in the next batch;
+		// leave them to the next cycle rather than spinning on them
+		if deleted == 0 {
+			slog.Warn("no expired sandboxes in batch could be deleted, retrying next cycle", "count", len(expired))
+			return outcomes
+		}
+		if c.budgetSpent(deadline, "sandboxes", batch) {
+			return outcomes
+		}
+	}
+	return outcomes
+}
+
+// retryDeletions retries the delete of sandboxes left deleting because their
+// services failed to deprovision, in batches like cleanupSandboxes. Those
+// still failing stay deleting until the next cycle.
+func (c *Cleaner) retryDeletions(ctx context.Context, deadline time.Time) {
+	for batch := 1; ctx.Err() == nil; batch++ {
+		deleting, err := c.manager.GetDeleting(ctx, c.batchSize)
+		if err != nil {
+			slog.Error("failed to get deleting sandboxes", "error", err)
+			return
+		}
+		if len(deleting) == 0 {
+			return
+		}
+
+		deleted := 0
+		for _, o := range c.deleteBatch(ctx, deleting, c.retryDeletion) {
+			if o == sandboxDeleted {
+				deleted++
+			}
+		}
+
+		slog.Info("retried sandbox deletions", "batch", batch, "count", len(deleting), "deleted", deleted)
+
+		// Still failing ones come back in the next batch
+		if deleted == 0 || c.budgetSpent(deadline, "deletions", batch) {
+			return
+		}
+	}
 }
 
-// cleanupSandboxes finds and removes expired sandboxes
-func (c *Cleaner) cleanupSandboxes(ctx context.Context) {
-	expired, err := c.manager.GetExpired(ctx)
+// retryDeletion retries the delete of one deleting sandbox
+func (c *Cleaner) retryDeletion(ctx context.Context, sb *models.Sandbox) sandboxOutcome {
+	err := c.manager.Delete(ctx, sb.ID)
+	switch {
+	case err == nil, errors.Is(err, sandbox.ErrOperationPending):
+		return sandboxDeleted
+	case errors.Is(err, sandbox.ErrOperationInProgress):
+		return sandboxSkipped
+	}
+	slog.Warn("sandbox delete still failing", "error", err, "id", sb.ID)
+	return sandboxFailed
+}
+
+// deleteBatch runs del on a batch of sandboxes, c.concurrency at a time and
+// each within c.sandboxTimeout, and returns their outcomes in batch order
+func (c *Cleaner) deleteBatch(ctx context.Context, batch []*models.Sandbox, del func(context.Context, *models.Sandbox) sandboxOutcome) []sandboxOutcome {
+	outcomes := make([]sandboxOutcome, len(batch))
+	slots := make(chan struct{}, c.concurrency)
+
+	var wg sync.WaitGroup
+	for i, sb := range batch {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			ctx, cancel := context.WithTimeout(ctx, c.sandboxTimeout)
+			defer cancel()
+			outcomes[i] = del(ctx, sb)
+		}()
+	}
+	wg.Wait()
+
+	return outcomes
+}
+
+// deleteExpiredSandbox applies the expiry behavior to one expired sandbox
+func (c *Cleaner) deleteExpiredSandbox(ctx context.Context, sb *models.Sandbox) sandboxOutcome {
+	// Act on the same resolution the API shows users
+	expiry := sandbox.SandboxExpiry(sb)
+	if !sandbox.ExpiryDue(expiry, sb.ExpiresAt, c.now()) {
+		return sandboxSkipped
+	}
+	if expiry.Action != models.ExpiryDelete {
+		slog.Error("unsupported expiry action", "action", expiry.Action, "id", sb.ID)
+		return sandboxFailed
+	}
+
+	ctx, span := tracing.Start(ctx, "cleanup.delete_sandbox",
+		attribute.String("sandbox.id", sb.ID),
+		attribute.String("sandbox.template", sb.TemplateID),
+	)
+	var err error
+	defer func() { tracing.End(span, err) }()
+
+	slog.Info("deleting expired sandbox",
+		"id", sb.ID,
+		"user", sb.UserID,
+		"template", sb.TemplateID,
+		"expired_at", sb.ExpiresAt,
+	)
+
+	c.manager.RecordEvent(ctx, sb.ID, models.EventExpired, "expired at "+sb.ExpiresAt.UTC().Format(time.RFC3339))
+
+	if err = c.manager.Delete(ctx, sb.ID); err != nil {
+		switch {
+		case errors.Is(err, sandbox.ErrOperationPending):
+			// Deletion continues in the background
+			err = nil
+			return sandboxDeleted
+		case errors.Is(err, sandbox.ErrOperationInProgress):
+			err = nil
+			return sandboxSkipped
+		}
+		slog.Error("failed to delete expired sandbox",
+			"error", err,
+			"id", sb.ID,
+		)
+		return sandboxFailed
+	}
+
+	slog.Info("expired sandbox deleted", "id", sb.ID)
+	return sandboxDeleted
+}
+
+// failStuckProvisioning marks sandboxes stuck in pending as failed
+func (c *Cleaner) failStuckProvisioning(ctx context.Context) {
+	recovered, err := c.manager.FailStuckProvisioning(ctx)
 	if err != nil {
-		slog.Error("failed to get expired sandboxes", "error", err)
+		slog.Error("failed to recover stuck sandboxes", "error", err)
 		return
 	}
 
-	if len(expired) == 0 {
-		slog.Debug("no expired sandboxes found")
+	if recovered > 0 {
+		slog.Info("stuck pending sandboxes marked failed", "count", recovered)
+	}
+}
+
+// stopIdleSandboxes stops running sandboxes without terminal activity for longer
+// than the idle timeout. They are stopped rather than deleted so they can be resumed.
+func (c *Cleaner) stopIdleSandboxes(ctx context.Context) {
+	if c.idleTimeout <= 0 {
 		return
 	}
 
-	slog.Info("found expired sandboxes", "count", len(expired))
+	idle, err := c.manager.GetIdle(ctx, c.idleTimeout)
+	if err != nil {
+		slog.Error("failed to get idle sandboxes", "error", err)
+		return
+	}
 
-	for _, sb := range expired {
-		slog.Info("deleting expired sandbox",
+	for _, sb := range idle {
+		slog.Info("stopping idle sandbox",
 			"id", sb.ID,
 			"user", sb.UserID,
 			"template", sb.TemplateID,
-			"expired_at", sb.ExpiresAt,
+			"last_activity_at", sb.LastActivityAt,
 		)
 
-		if err := c.manager.Delete(ctx, sb.ID); err != nil {
-			slog.Error("failed to delete expired sandbox",
+		if err := c.manager.StopIdle(ctx, sb.ID); err != nil {
+			if errors.Is(err, sandbox.ErrOperationPending) || errors.Is(err, sandbox.ErrOperationInProgress) {
+				continue
+			}
+			slog.Error("failed to stop idle sandbox",
 				"error", err,
 				"id", sb.ID,
 			)
 			continue
 		}
 
-		slog.Info("expired sandbox deleted", "id", sb.ID)
+		slog.Info("idle sandbox stopped", "id", sb.ID)
 	}
 }
 
-// cleanupSessions finds and expires active sessions past their TTL
-func (c *Cleaner) cleanupSessions(ctx context.Context) {
-	expiredSessions, err := c.manager.GetExpiredSessions(ctx)
+// cleanupSessions expires active sessions past their TTL in batches, oldest
+// first, until a batch comes back empty or the cycle deadline passes
+func (c *Cleaner) cleanupSessions(ctx context.Context, deadline time.Time) {
+	for batch := 1; ctx.Err() == nil; batch++ {
+		expiredSessions, err := c.manager.GetExpiredSessions(ctx, c.batchSize)
+		if err != nil {
+			slog.Error("failed to get expired sessions", "error", err)
+			return
+		}
+
+		if len(expiredSessions) == 0 {
+			return
+		}
+
+		expired := 0
+		for _, session := range expiredSessions {
+			if session.Status == models.SessionReady {
+				// Never started before its join deadline; the record stays
+				ok, err := c.manager.ExpireUnjoinedSession(ctx, session.ID)
+				if err != nil {
+					slog.Error("failed to expire unjoined session", "session_id", session.ID, "error", err)
+					continue
+				}
+				if ok {
+					expired++
+				}
+				continue
+			}
+
+			expiry := sandbox.SessionExpiry(session)
+			if session.ExpiresAt == nil || !sandbox.ExpiryDue(expiry, *session.ExpiresAt, c.now()) {
+				continue
+			}
+			if expiry.Action != models.ExpiryDelete {
+				slog.Error("unsupported expiry action", "action", expiry.Action, "session_id", session.ID)
+				continue
+			}
+
+			slog.Info("expiring session", "session_id", session.ID)
+
+			// Expire the session, notifying its terminals and callback, and
+			// delete its sandbox; the record stays for its report. A session
+			// extended, submitted or revoked since the query is left alone.
+			ok, err := c.manager.ExpireSession(ctx, session.ID)
+			if err != nil {
+				slog.Error("failed to expire session", "session_id", session.ID, "error", err)
+				continue
+			}
+			if ok {
+				expired++
+			}
+		}
+
+		slog.Info("expired session batch cleaned up", "batch", batch, "count", len(expiredSessions), "expired", expired)
+
+		if expired == 0 {
+			slog.Warn("no expired sessions in batch could be expired, retrying next cycle", "count", len(expiredSessions))
+			return
+		}
+		if c.budgetSpent(deadline, "sessions", batch) {
+			return
+		}
+	}
+}
+
+// budgetSpent reports whether the cycle deadline has passed, logging that the rest waits
+func (c *Cleaner) budgetSpent(deadline time.Time, kind string, batches int) bool {
+	if c.now().Before(deadline) {
+		return false
+	}
+	slog.Warn("cleanup cycle budget spent, leaving the rest for the next cycle",
+		"kind", kind,
+		"batches", batches,
+		"budget", c.cycleBudget,
+	)
+	return true
+}
+
+// reportCycle logs and exports how many expired sandboxes a cycle found,
+// deleted and failed to delete, and how long it took
+func (c *Cleaner) reportCycle(start time.Time, outcomes map[string]sandboxOutcome) {
+	var deleted, failed int
+	for _, o := range outcomes {
+		switch o {
+		case sandboxDeleted:
+			deleted++
+		case sandboxFailed:
+			failed++
+		}
+	}
+	duration := c.now().Sub(start)
+
+	c.metrics.CleanupCycle(len(outcomes), deleted, failed, duration)
+	slog.Info("cleanup cycle finished",
+		"found", len(outcomes),
+		"deleted", deleted,
+		"failed", failed,
+		"duration", duration,
+	)
+}
+
+// reportBacklog exports how many expired sandboxes and sessions are still waiting
+func (c *Cleaner) reportBacklog(ctx context.Context) {
+	sandboxes, sessions, err := c.manager.CountExpired(ctx)
 	if err != nil {
-		slog.Error("failed to get expired sessions", "error", err)
+		slog.Error("failed to count cleanup backlog", "error", err)
 		return
 	}
 
-	for _, session := range expiredSessions {
-		slog.Info("expiring session", "session_id", session.ID)
+	c.metrics.CleanupBacklog(sandboxes, sessions)
+	if sandboxes > 0 || sessions > 0 {
+		slog.Info("cleanup backlog remaining", "sandboxes", sandboxes, "sessions", sessions)
+	}
+}
 
-		// Delete session (which also cleans up its sandbox)
-		if err := c.manager.DeleteSession(ctx, session.ID); err != nil {
-			slog.Error("failed to expire session", "session_id", session.ID, "error", err)
-		}
+// purgeEvents deletes the events of sandboxes deleted longer ago than the retention period
+func (c *Cleaner) purgeEvents(ctx context.Context) {
+	if c.eventRetention <= 0 {
+		return
+	}
+
+	purged, err := c.manager.PurgeEvents(ctx, c.eventRetention)
+	if err != nil {
+		slog.Error("failed to purge sandbox events", "error", err)
+		return
+	}
+
+	if purged > 0 {
+		slog.Info("purged sandbox events", "count", purged, "retention", c.eventRetention)
+	}
+}
+
+// purgeDeletedSandboxes removes the records of sandboxes deleted longer ago than the retention period
+func (c *Cleaner) purgeDeletedSandboxes(ctx context.Context) {
+	if c.deletedRetention <= 0 {
+		return
+	}
+
+	purged, err := c.manager.PurgeDeleted(ctx, c.deletedRetention)
+	if err != nil {
+		slog.Error("failed to purge deleted sandboxes", "error", err)
+		return
+	}
+
+	if purged > 0 {
+		slog.Info("purged deleted sandboxes", "count", purged, "retention", c.deletedRetention)
+	}
+}
+
+// purgeRevokedSessions removes the records of sessions revoked longer ago than the retention period
+func (c *Cleaner) purgeRevokedSessions(ctx context.Context) {
+	if c.revokedRetention <= 0 {
+		return
+	}
+
+	purged, err := c.manager.PurgeRevokedSessions(ctx, c.revokedRetention)
+	if err != nil {
+		slog.Error("failed to purge revoked sessions", "error", err)
+		return
+	}
+
+	if purged > 0 {
+		slog.Info("purged revoked sessions", "count", purged, "retention", c.revokedRetention)
+	}
+}
+
+// purgeSubmissions removes the workspace archives of sessions submitted longer ago than the retention period
+func (c *Cleaner) purgeSubmissions(ctx context.Context) {
+	if c.archiveRetention <= 0 {
+		return
+	}
+
+	purged, err := c.manager.PurgeSubmissions(ctx, c.archiveRetention)
+	if err != nil {
+		slog.Error("failed to purge session submissions", "error", err)
+		return
+	}
+
+	if purged > 0 {
+		slog.Info("purged session submissions", "count", purged, "retention", c.archiveRetention)
+	}
+}
+
+// purgeRecordings removes the terminal recordings that ended longer ago than the retention period
+func (c *Cleaner) purgeRecordings(ctx context.Context) {
+	if c.recordRetention <= 0 {
+		return
+	}
+
+	purged, err := c.manager.PurgeRecordings(ctx, c.recordRetention)
+	if err != nil {
+		slog.Error("failed to purge terminal recordings", "error", err)
+		return
+	}
+
+	if purged > 0 {
+		slog.Info("purged terminal recordings", "count", purged, "retention", c.recordRetention)
+	}
+}
+
+// purgeCallbackDeliveries removes the finished callback deliveries of deleted
+// sessions. They outlive their session so the expiry callback still goes out.
+func (c *Cleaner) purgeCallbackDeliveries(ctx context.Context) {
+	purged, err := c.manager.PurgeCallbackDeliveries(ctx)
+	if err != nil {
+		slog.Error("failed to purge callback deliveries", "error", err)
+		return
+	}
+
+	if purged > 0 {
+		slog.Info("purged callback deliveries", "count", purged)
+	}
+}
+
+// deactivateExpiredClients flips API clients past their expiry to inactive,
+// once per clientSweepInterval. Authentication already rejects expired keys;
+// the sweep makes that visible in the client list.
+func (c *Cleaner) deactivateExpiredClients(ctx context.Context) {
+	now := c.now()
+	if !c.lastClientSweep.IsZero() && now.Sub(c.lastClientSweep) < clientSweepInterval {
+		return
+	}
+
+	clients, err := c.manager.DeactivateExpiredClients(ctx)
+	if err != nil {
+		slog.Error("failed to deactivate expired api clients", "error", err)
+		return
 	}
+	c.lastClientSweep = now
 
-	if len(expiredSessions) > 0 {
-		slog.Info("expired sessions cleaned up", "count", len(expiredSessions))
+	for _, client := range clients {
+		slog.Info("api client expired", "client_id", client.ID, "name", client.Name, "expired_at", client.ExpiresAt)
 	}
 }
@@ -0,0 +1,391 @@
+package cleanup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/config"
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/sandbox"
+)
+
+// expiryManager serves expired sandboxes and sessions oldest first and
+// removes them on delete, like the repository-backed manager
+type expiryManager struct {
+	sandbox.Manager
+	mu        sync.Mutex
+	sandboxes map[string]*models.Sandbox
+	sessions  map[string]*models.Session
+	failing   map[string]bool
+	onDelete  func()
+	delay     time.Duration // how long each delete takes
+
+	active, maxActive int       // deletes running at once
+	deadline          time.Time // of the last delete's context
+
+	batches  []int // sizes of the GetExpired batches served
+	deleted  []string
+	expired  []string        // sessions expired past their TTL
+	unjoined []string        // sessions expired past their join deadline
+	left     map[string]bool // sessions no longer active when expired
+}
+
+func newExpiryManager(sandboxes, sessions int) *expiryManager {
+	m := &expiryManager{
+		sandboxes: make(map[string]*models.Sandbox),
+		sessions:  make(map[string]*models.Session),
+		failing:   make(map[string]bool),
+		left:      make(map[string]bool),
+	}
+	base := time.Now().Add(-time.Hour)
+	for i := 0; i < sandboxes; i++ {
+		id := fmt.Sprintf("sb-%03d", i)
+		m.sandboxes[id] = &models.Sandbox{ID: id, ExpiresAt: base.Add(time.Duration(i) * time.Second)}
+	}
+	for i := 0; i < sessions; i++ {
+		id := fmt.Sprintf("sess-%03d", i)
+		expires := base.Add(time.Duration(i) * time.Second)
+		m.sessions[id] = &models.Session{ID: id, Status: models.SessionActive, SandboxID: "sb-" + id, ExpiresAt: &expires}
+	}
+	return m
+}
+
+func (m *expiryManager) GetExpired(ctx context.Context, limit int) ([]*models.Sandbox, error) {
+	var expired []*models.Sandbox
+	for _, sb := range m.sandboxes {
+		expired = append(expired, sb)
+	}
+	sort.Slice(expired, func(i, j int) bool { return expired[i].ExpiresAt.Before(expired[j].ExpiresAt) })
+	if len(expired) > limit {
+		expired = expired[:limit]
+	}
+	m.batches = append(m.batches, len(expired))
+	return expired, nil
+}
+
+func (m *expiryManager) GetExpiredSessions(ctx context.Context, limit int) ([]*models.Session, error) {
+	var expired []*models.Session
+	for _, s := range m.sessions {
+		expired = append(expired, s)
+	}
+	sort.Slice(expired, func(i, j int) bool { return sessionDeadline(expired[i]).Before(sessionDeadline(expired[j])) })
+	if len(expired) > limit {
+		expired = expired[:limit]
+	}
+	return expired, nil
+}
+
+// sessionDeadline is the expiry of an active session or the join deadline of a ready one
+func sessionDeadline(s *models.Session) time.Time {
+	if s.Status == models.SessionReady {
+		return *s.JoinBy
+	}
+	return *s.ExpiresAt
+}
+
+func (m *expiryManager) CountExpired(ctx context.Context) (int, int, error) {
+	return len(m.sandboxes), len(m.sessions), nil
+}
+
+func (m *expiryManager) RecordEvent(ctx context.Context, id string, typ models.SandboxEventType, msg string) {
+}
+
+func (m *expiryManager) Delete(ctx context.Context, id string) error {
+	m.mu.Lock()
+	m.active++
+	m.maxActive = max(m.maxActive, m.active)
+	m.mu.Unlock()
+	time.Sleep(m.delay)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.active--
+	m.deadline, _ = ctx.Deadline()
+	if m.failing[id] {
+		return errors.New("docker unavailable")
+	}
+	delete(m.sandboxes, id)
+	m.deleted = append(m.deleted, id)
+	if m.onDelete != nil {
+		m.onDelete()
+	}
+	return nil
+}
+
+func (m *expiryManager) ExpireSession(ctx context.Context, id string) (bool, error) {
+	delete(m.sessions, id)
+	if m.left[id] {
+		return false, nil
+	}
+	m.expired = append(m.expired, id)
+	return true, nil
+}
+
+func (m *expiryManager) ExpireUnjoinedSession(ctx context.Context, id string) (bool, error) {
+	delete(m.sessions, id)
+	m.unjoined = append(m.unjoined, id)
+	return true, nil
+}
+
+func newTestCleaner(m *expiryManager, batchSize int) *Cleaner {
+	// One at a time keeps the order of deletes predictable
+	return NewCleaner(m, config.CleanupConfig{BatchSize: batchSize, CycleBudget: time.Minute, Concurrency: 1}, nil)
+}
+
+func TestCleanupSandboxesInBatches(t *testing.T) {
+	m := newExpiryManager(7, 0)
+	c := newTestCleaner(m, 3)
+
+	c.cleanupSandboxes(context.Background(), time.Now().Add(time.Minute))
+
+	if len(m.sandboxes) != 0 {
+		t.Fatalf("expected all expired sandboxes deleted, %d left", len(m.sandboxes))
+	}
+	if want := []int{3, 3, 1, 0}; fmt.Sprint(m.batches) != fmt.Sprint(want) {
+		t.Errorf("expected batches %v, got %v", want, m.batches)
+	}
+	if m.deleted[0] != "sb-000" || m.deleted[6] != "sb-006" {
+		t.Errorf("expected oldest expiry deleted first, got %v", m.deleted)
+	}
+}
+
+func TestCleanupSandboxesStopsWhenBudgetSpent(t *testing.T) {
+	m := newExpiryManager(10, 0)
+	c := newTestCleaner(m, 2)
+
+	// Each delete advances the clock by 10s against a 30s budget
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	m.onDelete = func() { now = now.Add(10 * time.Second) }
+
+	c.cleanupSandboxes(context.Background(), now.Add(30*time.Second))
+
+	if len(m.batches) != 2 || len(m.sandboxes) != 6 {
+		t.Errorf("expected 2 batches and 6 sandboxes left, got batches %v and %d left", m.batches, len(m.sandboxes))
+	}
+}
+
+func TestCleanupSandboxesStopsWhenBatchMakesNoProgress(t *testing.T) {
+	m := newExpiryManager(5, 0)
+	m.failing["sb-000"] = true
+	m.failing["sb-001"] = true
+	c := newTestCleaner(m, 2)
+
+	c.cleanupSandboxes(context.Background(), time.Now().Add(time.Minute))
+
+	// The failing sandboxes are the oldest, so they fill the first batch
+	if len(m.batches) != 1 || len(m.sandboxes) != 5 {
+		t.Errorf("expected a single batch without deletes, got batches %v and %d left", m.batches, len(m.sandboxes))
+	}
+
+	m.failing = map[string]bool{"sb-001": true}
+	outcomes := c.cleanupSandboxes(context.Background(), time.Now().Add(time.Minute))
+
+	if _, ok := m.sandboxes["sb-001"]; !ok || len(m.sandboxes) != 1 {
+		t.Errorf("expected only the failing sandbox left, got %d", len(m.sandboxes))
+	}
+	// The failing sandbox came back in every batch but is counted once
+	if len(outcomes) != 5 || outcomes["sb-001"] != sandboxFailed || outcomes["sb-000"] != sandboxDeleted {
+		t.Errorf("expected 5 sandboxes with sb-001 failed, got %v", outcomes)
+	}
+}
+
+func TestCleanupSandboxesConcurrently(t *testing.T) {
+	m := newExpiryManager(9, 0)
+	m.delay = 20 * time.Millisecond
+	c := NewCleaner(m, config.CleanupConfig{BatchSize: 9, CycleBudget: time.Minute, Concurrency: 3}, nil)
+
+	c.cleanupSandboxes(context.Background(), time.Now().Add(time.Minute))
+
+	if len(m.sandboxes) != 0 {
+		t.Fatalf("expected all expired sandboxes deleted, %d left", len(m.sandboxes))
+	}
+	if m.maxActive != 3 {
+		t.Errorf("expected 3 deletes at once, got %d", m.maxActive)
+	}
+}
+
+func TestCleanupSandboxDeadline(t *testing.T) {
+	m := newExpiryManager(1, 0)
+	c := NewCleaner(m, config.CleanupConfig{SandboxTimeout: 30 * time.Second}, nil)
+
+	c.deleteBatch(context.Background(), []*models.Sandbox{m.sandboxes["sb-000"]}, c.deleteExpiredSandbox)
+
+	if left := time.Until(m.deadline); left <= 0 || left > 30*time.Second {
+		t.Errorf("expected the delete bounded by the per-sandbox timeout, got a deadline in %v", left)
+	}
+}
+
+func TestCleanupLoopWaitsAfterLongCycle(t *testing.T) {
+	c := NewCleaner(newExpiryManager(0, 0), config.CleanupConfig{Interval: 20 * time.Millisecond}, nil)
+
+	// Each cycle outlasts the interval, so the ticker fires during it
+	var starts, ends []time.Time
+	ctx, cancel := context.WithCancel(context.Background())
+	c.loop(ctx, func(context.Context) {
+		starts = append(starts, time.Now())
+		time.Sleep(50 * time.Millisecond)
+		ends = append(ends, time.Now())
+		if len(ends) == 4 {
+			cancel()
+		}
+	})
+
+	for i := 1; i < len(starts); i++ {
+		if gap := starts[i].Sub(ends[i-1]); gap < c.interval {
+			t.Errorf("cycle %d started %v after the previous one ended, want at least the interval", i, gap)
+		}
+	}
+}
+
+func TestCleanupSessionsInBatches(t *testing.T) {
+	m := newExpiryManager(0, 5)
+	c := newTestCleaner(m, 2)
+
+	c.cleanupSessions(context.Background(), time.Now().Add(time.Minute))
+
+	if len(m.sessions) != 0 || len(m.expired) != 5 {
+		t.Errorf("expected all expired sessions expired, %d left", len(m.sessions))
+	}
+}
+
+func TestCleanupSessionsCountsOnlyExpired(t *testing.T) {
+	m := newExpiryManager(0, 4)
+	// Extended, submitted or revoked since the query
+	m.left["sess-000"] = true
+	m.left["sess-001"] = true
+	c := newTestCleaner(m, 2)
+
+	c.cleanupSessions(context.Background(), time.Now().Add(time.Minute))
+
+	// The first batch expires none of its sessions, so the cycle stops there
+	if len(m.expired) != 0 || len(m.sessions) != 2 {
+		t.Errorf("expected no session expired and 2 left for the next cycle, got %v and %d left", m.expired, len(m.sessions))
+	}
+}
+
+func TestCleanupSessionsExpiresUnjoined(t *testing.T) {
+	m := newExpiryManager(0, 1)
+	joinBy := time.Now().Add(-2 * time.Hour)
+	m.sessions["ready"] = &models.Session{ID: "ready", Status: models.SessionReady, JoinBy: &joinBy}
+	c := newTestCleaner(m, 10)
+
+	c.cleanupSessions(context.Background(), time.Now().Add(time.Minute))
+
+	if len(m.sessions) != 0 {
+		t.Errorf("expected all expired sessions cleaned up, %d left", len(m.sessions))
+	}
+	if len(m.unjoined) != 1 || m.unjoined[0] != "ready" {
+		t.Errorf("expected the ready session expired rather than deleted, got %v", m.unjoined)
+	}
+}
+
+func TestNewCleanerDefaults(t *testing.T) {
+	c := NewCleaner(newExpiryManager(0, 0), config.CleanupConfig{}, nil)
+	if c.batchSize != defaultBatchSize || c.cycleBudget != defaultCycleBudget {
+		t.Errorf("expected default batching, got size %d and budget %v", c.batchSize, c.cycleBudget)
+	}
+
+	// A nil metrics registry must not break the backlog report
+	c.reportBacklog(context.Background())
+}
+
+func TestCleanupFollowsResolvedExpiry(t *testing.T) {
+	m := newExpiryManager(0, 0)
+	expired := time.Now().Add(-time.Minute)
+	m.sandboxes["sb-stopped"] = &models.Sandbox{ID: "sb-stopped", Status: models.StatusStopped, ExpiresAt: expired}
+	m.sandboxes["sb-failed"] = &models.Sandbox{ID: "sb-failed", Status: models.StatusFailed, ExpiresAt: expired.Add(-time.Minute)}
+	c := newTestCleaner(m, 10)
+
+	c.cleanupSandboxes(context.Background(), time.Now().Add(time.Minute))
+
+	// The cleaner deletes exactly what the API tells users will be deleted
+	if _, ok := m.sandboxes["sb-stopped"]; ok {
+		t.Error("expected the stopped sandbox deleted, as its expiry behavior promises")
+	}
+	if _, ok := m.sandboxes["sb-failed"]; !ok {
+		t.Error("expected the failed sandbox, which reports no expiry behavior, to be left alone")
+	}
+}
+
+// deletingManager serves its sandboxes as deleting rather than expired
+type deletingManager struct {
+	*expiryManager
+}
+
+func (m *deletingManager) GetDeleting(ctx context.Context, limit int) ([]*models.Sandbox, error) {
+	return m.expiryManager.GetExpired(ctx, limit)
+}
+
+func TestRetryDeletions(t *testing.T) {
+	m := &deletingManager{expiryManager: newExpiryManager(3, 0)}
+	m.failing["sb-001"] = true
+	c := newTestCleaner(m.expiryManager, 10)
+	c.manager = m
+
+	c.retryDeletions(context.Background(), time.Now().Add(time.Minute))
+
+	if _, ok := m.sandboxes["sb-001"]; !ok || len(m.sandboxes) != 1 {
+		t.Errorf("expected only the still failing sandbox left, got %d", len(m.sandboxes))
+	}
+	// The second batch holds only the failing sandbox and ends the retries
+	if len(m.batches) != 2 || m.batches[1] != 1 {
+		t.Errorf("expected a batch more for the failing sandbox, got %v", m.batches)
+	}
+	if left := time.Until(m.deadline); left <= 0 || left > c.sandboxTimeout {
+		t.Errorf("expected the retry bounded by the per-sandbox timeout, got a deadline in %v", left)
+	}
+}
+
+func TestRetryDeletionsStopsWhenBudgetSpent(t *testing.T) {
+	m := &deletingManager{expiryManager: newExpiryManager(10, 0)}
+	c := newTestCleaner(m.expiryManager, 2)
+	c.manager = m
+
+	// Each delete advances the clock by 10s against a 30s budget
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	m.onDelete = func() { now = now.Add(10 * time.Second) }
+
+	c.retryDeletions(context.Background(), now.Add(30*time.Second))
+
+	if len(m.batches) != 2 || len(m.sandboxes) != 6 {
+		t.Errorf("expected 2 batches and 6 sandboxes left, got batches %v and %d left", m.batches, len(m.sandboxes))
+	}
+}
+
+// clientSweepManager counts expired-client sweeps
+type clientSweepManager struct {
+	*expiryManager
+	sweeps int
+}
+
+func (m *clientSweepManager) DeactivateExpiredClients(ctx context.Context) ([]*models.ApiClient, error) {
+	m.sweeps++
+	return []*models.ApiClient{{ID: 7, Name: "ci"}}, nil
+}
+
+func TestDeactivateExpiredClientsDaily(t *testing.T) {
+	m := &clientSweepManager{expiryManager: newExpiryManager(0, 0)}
+	c := NewCleaner(m, config.CleanupConfig{}, nil)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	c.deactivateExpiredClients(context.Background())
+	now = now.Add(time.Hour)
+	c.deactivateExpiredClients(context.Background())
+	if m.sweeps != 1 {
+		t.Fatalf("expected one sweep within a day, got %d", m.sweeps)
+	}
+
+	now = now.Add(clientSweepInterval)
+	c.deactivateExpiredClients(context.Background())
+	if m.sweeps != 2 {
+		t.Errorf("expected a second sweep after a day, got %d", m.sweeps)
+	}
+}
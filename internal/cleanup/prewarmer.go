@@ -0,0 +1,74 @@
+package cleanup
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/config"
+	"github.com/terra-clan/sandbox-engine/internal/sandbox"
+)
+
+// prewarmBatchSize is how many due sessions one prewarm query fetches
+const prewarmBatchSize = 50
+
+// Prewarmer creates the sandboxes of sessions with a prewarm time once it has
+// come. Pre-warmed sandboxes of candidates who never join are torn down by the
+// Cleaner with the session at its join deadline.
+type Prewarmer struct {
+	manager  sandbox.Manager
+	interval time.Duration
+}
+
+// NewPrewarmer creates a new prewarm worker
+func NewPrewarmer(manager sandbox.Manager, cfg config.SandboxConfig) *Prewarmer {
+	interval := cfg.SessionPrewarmInterval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	return &Prewarmer{manager: manager, interval: interval}
+}
+
+// Start begins the prewarm worker in a goroutine
+func (p *Prewarmer) Start(ctx context.Context) {
+	go p.run(ctx)
+}
+
+// run is the main loop for the prewarm worker
+func (p *Prewarmer) run(ctx context.Context) {
+	slog.Info("prewarm worker started", "interval", p.interval)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	p.prewarm(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("prewarm worker stopped")
+			return
+		case <-ticker.C:
+			p.prewarm(ctx)
+		}
+	}
+}
+
+// prewarm creates the sandboxes of due sessions in batches until a batch is
+// not full. Sessions whose sandbox could not be created stay due, so a batch
+// that created nothing waits for the next tick.
+func (p *Prewarmer) prewarm(ctx context.Context) {
+	for ctx.Err() == nil {
+		n, err := p.manager.PrewarmSessions(ctx, prewarmBatchSize)
+		if err != nil {
+			slog.Error("failed to prewarm sessions", "error", err)
+			return
+		}
+		if n > 0 {
+			slog.Info("session sandboxes prewarmed", "count", n)
+		}
+		if n < prewarmBatchSize {
+			return
+		}
+	}
+}
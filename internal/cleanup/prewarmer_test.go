@@ -0,0 +1,40 @@
+package cleanup
+
+import (
+	"context"
+	"testing"
+
+	"github.com/terra-clan/sandbox-engine/internal/config"
+	"github.com/terra-clan/sandbox-engine/internal/sandbox"
+)
+
+// prewarmManager prewarms from a fixed number of due sessions
+type prewarmManager struct {
+	sandbox.Manager
+	due   int
+	calls int
+}
+
+func (m *prewarmManager) PrewarmSessions(ctx context.Context, limit int) (int, error) {
+	m.calls++
+	n := min(m.due, limit)
+	m.due -= n
+	return n, nil
+}
+
+func TestPrewarmDrainsDueSessions(t *testing.T) {
+	manager := &prewarmManager{due: 2*prewarmBatchSize + 1}
+	p := NewPrewarmer(manager, config.SandboxConfig{})
+
+	p.prewarm(context.Background())
+
+	if manager.due != 0 || manager.calls != 3 {
+		t.Errorf("expected every due session prewarmed in 3 batches, got %d left after %d", manager.due, manager.calls)
+	}
+
+	// A round that prewarms nothing ends at once, leaving failures for the next tick
+	p.prewarm(context.Background())
+	if manager.calls != 4 {
+		t.Errorf("expected one query for an empty round, got %d", manager.calls-3)
+	}
+}
@@ -0,0 +1,292 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	// maxLabelKeys bounds how many annotation keys can become metric labels
+	maxLabelKeys = 5
+	// maxValuesPerKey bounds distinct values per annotation label; extra values collapse into overflowValue
+	maxValuesPerKey = 50
+	// overflowValue replaces annotation values once a key hits maxValuesPerKey
+	overflowValue = "other"
+)
+
+var labelNameRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// unboundedKeys are annotation keys that identify individual entities and
+// would explode metric cardinality
+var unboundedKeys = map[string]bool{
+	"id":           true,
+	"sandbox_id":   true,
+	"session_id":   true,
+	"container_id": true,
+	"user":         true,
+	"user_id":      true,
+	"email":        true,
+	"token":        true,
+	"name":         true,
+}
+
+// reservedKeys are labels already used by the engine's own metrics
+var reservedKeys = map[string]bool{
+	"template": true,
+	"status":   true,
+}
+
+// Metrics holds the engine's Prometheus collectors
+type Metrics struct {
+	registry  *prometheus.Registry
+	labelKeys []string
+	guard     *cardinalityGuard
+
+	sandboxesCreated *prometheus.CounterVec
+	sandboxesStarted *prometheus.CounterVec
+	sandboxesFailed  *prometheus.CounterVec
+	cleanupBacklog   *prometheus.GaugeVec
+	cleanupCycle     *prometheus.GaugeVec
+	cleanupDuration  prometheus.Histogram
+
+	terminalConnections prometheus.Gauge
+	terminalsRejected   *prometheus.CounterVec
+}
+
+// New creates the metrics registry. labelKeys are template annotation keys
+// propagated as metric labels; they are validated against cardinality rules.
+func New(labelKeys []string) (*Metrics, error) {
+	if err := ValidateLabelKeys(labelKeys); err != nil {
+		return nil, err
+	}
+
+	labels := append([]string{"template"}, metricLabelNames(labelKeys)...)
+
+	m := &Metrics{
+		registry:  prometheus.NewRegistry(),
+		labelKeys: labelKeys,
+		guard:     newCardinalityGuard(maxValuesPerKey),
+		sandboxesCreated: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "sandbox_engine",
+			Name:      "sandboxes_created_total",
+			Help:      "Sandboxes created, by template and annotation labels.",
+		}, labels),
+		sandboxesStarted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "sandbox_engine",
+			Name:      "sandboxes_started_total",
+			Help:      "Sandboxes that reached running, by template and annotation labels.",
+		}, labels),
+		sandboxesFailed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "sandbox_engine",
+			Name:      "sandboxes_failed_total",
+			Help:      "Sandboxes that failed provisioning, by template and annotation labels.",
+		}, labels),
+		cleanupBacklog: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "sandbox_engine",
+			Name:      "cleanup_backlog",
+			Help:      "Expired sandboxes and sessions not yet cleaned up, by kind, as of the last cleanup cycle.",
+		}, []string{"kind"}),
+		cleanupCycle: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "sandbox_engine",
+			Name:      "cleanup_cycle_sandboxes",
+			Help:      "Expired sandboxes found, deleted and failed to delete in the last cleanup cycle, by result.",
+		}, []string{"result"}),
+		cleanupDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "sandbox_engine",
+			Name:      "cleanup_cycle_duration_seconds",
+			Help:      "How long cleanup cycles take.",
+			Buckets:   []float64{1, 5, 15, 30, 60, 120, 300, 600},
+		}),
+		terminalConnections: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "sandbox_engine",
+			Name:      "terminal_connections",
+			Help:      "Open terminal WebSockets on this replica.",
+		}),
+		terminalsRejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "sandbox_engine",
+			Name:      "terminal_connections_rejected_total",
+			Help:      "Terminal WebSockets turned away over a connection limit, by limit (sandbox or client).",
+		}, []string{"limit"}),
+	}
+
+	m.registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		m.sandboxesCreated,
+		m.sandboxesStarted,
+		m.sandboxesFailed,
+		m.cleanupBacklog,
+		m.cleanupCycle,
+		m.cleanupDuration,
+		m.terminalConnections,
+		m.terminalsRejected,
+	)
+
+	return m, nil
+}
+
+// ValidateLabelKeys rejects annotation keys that can't safely become metric labels
+func ValidateLabelKeys(keys []string) error {
+	if len(keys) > maxLabelKeys {
+		return fmt.Errorf("too many metric label keys: %d (max %d)", len(keys), maxLabelKeys)
+	}
+
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		name := labelName(key)
+		switch {
+		case key == "":
+			return fmt.Errorf("empty metric label key")
+		case !labelNameRe.MatchString(name) || strings.HasPrefix(name, "__"):
+			return fmt.Errorf("metric label key %q is not a valid label name", key)
+		case reservedKeys[name]:
+			return fmt.Errorf("metric label key %q is reserved", key)
+		case unboundedKeys[name]:
+			return fmt.Errorf("metric label key %q is unbounded and would explode cardinality", key)
+		case seen[name]:
+			return fmt.Errorf("duplicate metric label key %q", key)
+		}
+		seen[name] = true
+	}
+
+	return nil
+}
+
+// Handler returns the HTTP handler serving the metrics
+func (m *Metrics) Handler() http.Handler {
+	if m == nil {
+		return http.NotFoundHandler()
+	}
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Registry returns the underlying registry for registering extra collectors
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+// SandboxCreated records a sandbox creation
+func (m *Metrics) SandboxCreated(template string, annotations map[string]string) {
+	if m == nil {
+		return
+	}
+	m.sandboxesCreated.WithLabelValues(m.labelValues(template, annotations)...).Inc()
+}
+
+// SandboxStarted records a sandbox reaching running
+func (m *Metrics) SandboxStarted(template string, annotations map[string]string) {
+	if m == nil {
+		return
+	}
+	m.sandboxesStarted.WithLabelValues(m.labelValues(template, annotations)...).Inc()
+}
+
+// SandboxFailed records a sandbox provisioning failure
+func (m *Metrics) SandboxFailed(template string, annotations map[string]string) {
+	if m == nil {
+		return
+	}
+	m.sandboxesFailed.WithLabelValues(m.labelValues(template, annotations)...).Inc()
+}
+
+// CleanupBacklog records how many expired sandboxes and sessions await cleanup
+func (m *Metrics) CleanupBacklog(sandboxes, sessions int) {
+	if m == nil {
+		return
+	}
+	m.cleanupBacklog.WithLabelValues("sandboxes").Set(float64(sandboxes))
+	m.cleanupBacklog.WithLabelValues("sessions").Set(float64(sessions))
+}
+
+// CleanupCycle records the expired sandboxes of a finished cleanup cycle and
+// how long the cycle took
+func (m *Metrics) CleanupCycle(found, deleted, failed int, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.cleanupCycle.WithLabelValues("found").Set(float64(found))
+	m.cleanupCycle.WithLabelValues("deleted").Set(float64(deleted))
+	m.cleanupCycle.WithLabelValues("failed").Set(float64(failed))
+	m.cleanupDuration.Observe(duration.Seconds())
+}
+
+// TerminalConnections records how many terminal WebSockets are open
+func (m *Metrics) TerminalConnections(n int) {
+	if m == nil {
+		return
+	}
+	m.terminalConnections.Set(float64(n))
+}
+
+// TerminalRejected records a terminal WebSocket turned away over limit
+func (m *Metrics) TerminalRejected(limit string) {
+	if m == nil {
+		return
+	}
+	m.terminalsRejected.WithLabelValues(limit).Inc()
+}
+
+// labelValues builds label values in label order, bounded by the cardinality guard
+func (m *Metrics) labelValues(template string, annotations map[string]string) []string {
+	values := make([]string, 0, len(m.labelKeys)+1)
+	values = append(values, template)
+	for _, key := range m.labelKeys {
+		values = append(values, m.guard.value(key, annotations[key]))
+	}
+	return values
+}
+
+// labelName converts an annotation key (e.g. "cost-center") into a label name
+func labelName(key string) string {
+	return strings.NewReplacer("-", "_", ".", "_", "/", "_").Replace(key)
+}
+
+func metricLabelNames(keys []string) []string {
+	names := make([]string, len(keys))
+	for i, key := range keys {
+		names[i] = labelName(key)
+	}
+	return names
+}
+
+// cardinalityGuard caps distinct values seen per label key
+type cardinalityGuard struct {
+	mu     sync.Mutex
+	max    int
+	values map[string]map[string]bool
+}
+
+func newCardinalityGuard(max int) *cardinalityGuard {
+	return &cardinalityGuard{
+		max:    max,
+		values: make(map[string]map[string]bool),
+	}
+}
+
+// value returns v if it is already known or there is room for it, otherwise overflowValue
+func (g *cardinalityGuard) value(key, v string) string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	seen, ok := g.values[key]
+	if !ok {
+		seen = make(map[string]bool)
+		g.values[key] = seen
+	}
+
+	if seen[v] {
+		return v
+	}
+	if len(seen) >= g.max {
+		return overflowValue
+	}
+	seen[v] = true
+	return v
+}
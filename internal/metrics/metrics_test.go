@@ -0,0 +1,135 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestValidateLabelKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    []string
+		wantErr string
+	}{
+		{name: "empty list", keys: nil},
+		{name: "valid keys", keys: []string{"team", "cost-center"}},
+		{name: "unbounded key", keys: []string{"user_id"}, wantErr: "unbounded"},
+		{name: "unbounded after normalization", keys: []string{"sandbox-id"}, wantErr: "unbounded"},
+		{name: "reserved key", keys: []string{"template"}, wantErr: "reserved"},
+		{name: "invalid name", keys: []string{"9lives"}, wantErr: "not a valid label name"},
+		{name: "double underscore", keys: []string{"__internal"}, wantErr: "not a valid label name"},
+		{name: "duplicate after normalization", keys: []string{"cost-center", "cost_center"}, wantErr: "duplicate"},
+		{name: "too many keys", keys: []string{"a", "b", "c", "d", "e", "f"}, wantErr: "too many"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateLabelKeys(tt.keys)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	if _, err := New([]string{"email"}); err == nil {
+		t.Error("New should reject unbounded label keys")
+	}
+}
+
+func TestAnnotationsPropagateToLabels(t *testing.T) {
+	m, err := New([]string{"team", "cost-center"})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	annotations := map[string]string{"team": "payments", "cost-center": "1234", "ignored": "x"}
+	m.SandboxCreated("python-base", annotations)
+	m.SandboxCreated("python-base", annotations)
+	m.SandboxFailed("python-base", map[string]string{"team": "payments"})
+
+	if got := testutil.ToFloat64(m.sandboxesCreated.WithLabelValues("python-base", "payments", "1234")); got != 2 {
+		t.Errorf("expected 2 creations for payments/1234, got %v", got)
+	}
+	// Missing annotations become empty label values
+	if got := testutil.ToFloat64(m.sandboxesFailed.WithLabelValues("python-base", "payments", "")); got != 1 {
+		t.Errorf("expected 1 failure for payments with no cost center, got %v", got)
+	}
+}
+
+func TestCardinalityGuardCollapsesOverflow(t *testing.T) {
+	m, err := New([]string{"team"})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	for i := 0; i < maxValuesPerKey+10; i++ {
+		m.SandboxCreated("tmpl", map[string]string{"team": fmt.Sprintf("team-%d", i)})
+	}
+
+	if got := testutil.CollectAndCount(m.sandboxesCreated); got != maxValuesPerKey+1 {
+		t.Errorf("expected %d series (cap + overflow), got %d", maxValuesPerKey+1, got)
+	}
+	if got := testutil.ToFloat64(m.sandboxesCreated.WithLabelValues("tmpl", overflowValue)); got != 10 {
+		t.Errorf("expected 10 overflowed creations, got %v", got)
+	}
+
+	// Values seen before the cap keep their own series
+	m.SandboxCreated("tmpl", map[string]string{"team": "team-0"})
+	if got := testutil.ToFloat64(m.sandboxesCreated.WithLabelValues("tmpl", "team-0")); got != 2 {
+		t.Errorf("expected known value to keep counting, got %v", got)
+	}
+}
+
+func TestCleanupBacklog(t *testing.T) {
+	m, err := New(nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	m.CleanupBacklog(12, 3)
+	m.CleanupBacklog(4, 0)
+
+	if got := testutil.ToFloat64(m.cleanupBacklog.WithLabelValues("sandboxes")); got != 4 {
+		t.Errorf("expected sandbox backlog 4, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.cleanupBacklog.WithLabelValues("sessions")); got != 0 {
+		t.Errorf("expected session backlog 0, got %v", got)
+	}
+}
+
+func TestCleanupCycle(t *testing.T) {
+	m, err := New(nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	m.CleanupCycle(10, 8, 1, 3*time.Second)
+
+	for result, want := range map[string]float64{"found": 10, "deleted": 8, "failed": 1} {
+		if got := testutil.ToFloat64(m.cleanupCycle.WithLabelValues(result)); got != want {
+			t.Errorf("expected %s %v, got %v", result, want, got)
+		}
+	}
+	if n := testutil.CollectAndCount(m.cleanupDuration); n != 1 {
+		t.Errorf("expected the cycle duration observed, got %d series", n)
+	}
+}
+
+func TestNilMetricsIsNoop(t *testing.T) {
+	var m *Metrics
+	m.SandboxCreated("tmpl", nil)
+	m.SandboxStarted("tmpl", nil)
+	m.SandboxFailed("tmpl", nil)
+	m.CleanupBacklog(1, 1)
+	m.CleanupCycle(1, 1, 0, time.Second)
+}
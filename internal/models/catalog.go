@@ -1,5 +1,10 @@
 package models
 
+import (
+	"sort"
+	"strings"
+)
+
 // Domain represents a top-level assessment category (e.g., fintech, ecommerce)
 type Domain struct {
 	ID            string `json:"id"`
@@ -30,4 +35,70 @@ type CatalogTask struct {
 	Skills        []string `json:"skills"`
 	DomainID      string   `json:"domainId"`
 	ProjectID     string   `json:"projectId"`
+
+	// Titles and Descriptions hold every locale of a task written with
+	// several; Title and Description are in the catalog's default locale
+	Titles       LocalizedText `json:"titles,omitempty"`
+	Descriptions LocalizedText `json:"descriptions,omitempty"`
+
+	// Workspace setup, run in the sandbox after its container starts. Like
+	// Grading it is kept out of this JSON, which candidates see when they
+	// join: a repo URL may carry a token. The catalog DTO shows it.
+	StarterRepo   *StarterRepo      `json:"-"`
+	SetupCommands []string          `json:"-"` // shell commands, in the repo when there is one
+	Env           map[string]string `json:"-"` // over the template's env, under the request's
+
+	Grading *GradingSpec `json:"-"`
+}
+
+// StarterRepo is the git repository cloned into a task's workspace
+type StarterRepo struct {
+	URL  string `yaml:"url" json:"url"`
+	Ref  string `yaml:"ref" json:"ref,omitempty"`   // branch or tag; the default branch when empty
+	Path string `yaml:"path" json:"path,omitempty"` // clone destination; DefaultStarterPath when empty
+}
+
+// DefaultStarterPath is where a starter repo is cloned when it sets no path
+const DefaultStarterPath = "/workspace"
+
+// LocalizedText is a title or description by locale, e.g. {"en": ..., "ru": ...}
+type LocalizedText map[string]string
+
+// Pick returns the text of the first of locales it has, matching a regional
+// locale like ru-RU by its language too. ok is false when none matches.
+func (t LocalizedText) Pick(locales []string) (text string, ok bool) {
+	for _, locale := range locales {
+		for _, l := range []string{locale, baseLanguage(locale)} {
+			for k, v := range t {
+				if strings.EqualFold(k, l) {
+					return v, true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// Fallback returns the text in locale, or else in the first locale by name
+func (t LocalizedText) Fallback(locale string) string {
+	if text, ok := t.Pick([]string{locale}); ok {
+		return text
+	}
+	keys := make([]string, 0, len(t))
+	for k := range t {
+		keys = append(keys, k)
+	}
+	if len(keys) == 0 {
+		return ""
+	}
+	sort.Strings(keys)
+	return t[keys[0]]
+}
+
+// baseLanguage returns the language of a locale: ru for ru-RU or ru_RU
+func baseLanguage(locale string) string {
+	if i := strings.IndexAny(locale, "-_"); i > 0 {
+		return locale[:i]
+	}
+	return locale
 }
@@ -1,10 +1,16 @@
 package models
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/netip"
 	"strings"
 	"time"
 )
 
+// ApiKeyPrefix starts every generated API key
+const ApiKeyPrefix = "sk_"
+
 // ApiClient represents an authenticated API client
 type ApiClient struct {
 	ID          int               `json:"id"`
@@ -15,6 +21,31 @@ type ApiClient struct {
 	LastUsedAt  *time.Time        `json:"last_used_at,omitempty"`
 	Permissions []string          `json:"permissions"`
 	Metadata    map[string]string `json:"metadata,omitempty"`
+
+	// ExpiresAt is when the key stops authenticating; nil never expires
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// AllowedCIDRs restricts the addresses the key is accepted from; empty allows any
+	AllowedCIDRs []string `json:"allowed_cidrs,omitempty"`
+}
+
+// Expired reports whether the key has expired at now
+func (c *ApiClient) Expired(now time.Time) bool {
+	return c.ExpiresAt != nil && !now.Before(*c.ExpiresAt)
+}
+
+// AllowsAddr reports whether the key may be used from addr. Malformed
+// entries match nothing, so a bad allowlist fails closed.
+func (c *ApiClient) AllowsAddr(addr netip.Addr) bool {
+	if len(c.AllowedCIDRs) == 0 {
+		return true
+	}
+	addr = addr.Unmap()
+	for _, cidr := range c.AllowedCIDRs {
+		if prefix, err := netip.ParsePrefix(cidr); err == nil && prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
 }
 
 // HasPermission checks if client has specific permission
@@ -54,3 +85,46 @@ func (c *ApiClient) MaskedApiKey() string {
 	}
 	return c.ApiKey[:8] + "..."
 }
+
+// GenerateApiKey creates a random API key: ApiKeyPrefix and 48 hex characters
+func GenerateApiKey() (string, error) {
+	bytes := make([]byte, 24)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return ApiKeyPrefix + hex.EncodeToString(bytes), nil
+}
+
+// ValidPermission reports whether p is "*" or a "<resource>:<action>" pair,
+// where the action may be the "*" wildcard
+func ValidPermission(p string) bool {
+	if p == "*" {
+		return true
+	}
+	resource, action, ok := strings.Cut(p, ":")
+	if !ok || resource == "" || action == "" || strings.ContainsAny(resource, "*: \t") {
+		return false
+	}
+	return action == "*" || !strings.ContainsAny(action, "*: \t")
+}
+
+// CreateClientRequest represents a request to create an API client
+type CreateClientRequest struct {
+	Name         string            `json:"name"`
+	Permissions  []string          `json:"permissions"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	ExpiresAt    *time.Time        `json:"expires_at,omitempty"`
+	AllowedCIDRs []string          `json:"allowed_cidrs,omitempty"`
+}
+
+// UpdateClientRequest changes the fields of an API client that are set. An
+// empty allowed_cidrs list lifts the restriction; clear_expiry removes the expiry.
+type UpdateClientRequest struct {
+	Name         *string           `json:"name,omitempty"`
+	Permissions  []string          `json:"permissions,omitempty"`
+	IsActive     *bool             `json:"is_active,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	ExpiresAt    *time.Time        `json:"expires_at,omitempty"`
+	ClearExpiry  bool              `json:"clear_expiry,omitempty"`
+	AllowedCIDRs []string          `json:"allowed_cidrs,omitempty"`
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CredentialsDelivery selects how service credentials reach the container
+type CredentialsDelivery string
+
+const (
+	// CredentialsEnv injects credentials as <SERVICE>_* env vars (default)
+	CredentialsEnv CredentialsDelivery = "env"
+	// CredentialsFile writes credentials to a file readable only by the sandbox user
+	CredentialsFile CredentialsDelivery = "file"
+	// CredentialsBoth does both
+	CredentialsBoth CredentialsDelivery = "both"
+)
+
+// Credentials file formats
+const (
+	CredentialsFormatJSON = "json"
+	CredentialsFormatINI  = "ini"
+)
+
+// DefaultCredentialsUser owns the credentials file; it is the coder user of the workspace images
+const DefaultCredentialsUser = "1000:1000"
+
+// CredentialsFileSpec places the credentials file inside the container
+type CredentialsFileSpec struct {
+	Path   string `yaml:"path" json:"path"`
+	Format string `yaml:"format" json:"format"`
+	// User is the uid[:gid] owning the file
+	User string `yaml:"user" json:"user"`
+}
+
+// InEnv reports whether credentials are injected as env vars
+func (d CredentialsDelivery) InEnv() bool {
+	return d == "" || d == CredentialsEnv || d == CredentialsBoth
+}
+
+// InFile reports whether credentials are written to a file
+func (d CredentialsDelivery) InFile() bool {
+	return d == CredentialsFile || d == CredentialsBoth
+}
+
+// Owner parses User into a uid and gid; a bare uid doubles as the gid
+func (f CredentialsFileSpec) Owner() (uid, gid int, err error) {
+	uidPart, gidPart, hasGID := strings.Cut(f.User, ":")
+	if uid, err = strconv.Atoi(uidPart); err != nil || uid < 0 {
+		return 0, 0, fmt.Errorf("user must be a numeric uid[:gid], got %q", f.User)
+	}
+	if !hasGID {
+		return uid, uid, nil
+	}
+	if gid, err = strconv.Atoi(gidPart); err != nil || gid < 0 {
+		return 0, 0, fmt.Errorf("user must be a numeric uid[:gid], got %q", f.User)
+	}
+	return uid, gid, nil
+}
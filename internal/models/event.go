@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// SandboxEventType names a sandbox lifecycle transition
+type SandboxEventType string
+
+const (
+	EventCreated            SandboxEventType = "created"
+	EventServiceProvisioned SandboxEventType = "service_provisioned"
+	EventServiceSeeded      SandboxEventType = "service_seeded"
+	EventCredentialsRotated SandboxEventType = "credentials_rotated"
+	EventImagePulled        SandboxEventType = "image_pulled"
+	EventTaskSetup          SandboxEventType = "task_setup"
+	EventStarted            SandboxEventType = "started"
+	EventTTLExtended        SandboxEventType = "ttl_extended"
+	EventGraded             SandboxEventType = "graded"
+	EventStopped            SandboxEventType = "stopped"
+	EventExpired            SandboxEventType = "expired"
+	EventFailed             SandboxEventType = "failed"
+	EventDeleteFailed       SandboxEventType = "delete_failed"
+	EventDeleted            SandboxEventType = "deleted"
+)
+
+// SandboxEvent is a timestamped lifecycle event of a sandbox. Events outlive
+// the sandbox so failures can be investigated after cleanup.
+type SandboxEvent struct {
+	ID        int64            `json:"id"`
+	SandboxID string           `json:"sandbox_id"`
+	Type      SandboxEventType `json:"type"`
+	Message   string           `json:"message,omitempty"`
+	CreatedAt time.Time        `json:"created_at"`
+}
@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// ExecRequest runs a command in a sandbox to completion
+type ExecRequest struct {
+	Command        []string          `json:"command"`
+	WorkingDir     string            `json:"working_dir,omitempty"`
+	Env            map[string]string `json:"env,omitempty"`
+	TimeoutSeconds int               `json:"timeout_seconds,omitempty"` // 0 uses the default of 60
+}
+
+// ExecResult is how a command ended. A non-zero exit code is a result, not an
+// error.
+type ExecResult struct {
+	ExitCode   int    `json:"exit_code"`
+	Stdout     string `json:"stdout"`
+	Stderr     string `json:"stderr"`
+	Truncated  bool   `json:"truncated,omitempty"` // output past the per-stream cap was dropped
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// SandboxStats is a snapshot of a sandbox container's resource usage
+type SandboxStats struct {
+	CPUPercent       float64   `json:"cpu_percent"` // of one CPU, so up to 100 per CPU
+	MemoryBytes      uint64    `json:"memory_bytes"`
+	MemoryLimitBytes uint64    `json:"memory_limit_bytes"`
+	NetworkRxBytes   uint64    `json:"network_rx_bytes"`
+	NetworkTxBytes   uint64    `json:"network_tx_bytes"`
+	PIDs             uint64    `json:"pids"`
+	ReadAt           time.Time `json:"read_at"`
+}
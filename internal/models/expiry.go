@@ -0,0 +1,18 @@
+package models
+
+// ExpiryAction is what the cleanup worker does with a sandbox once its TTL runs out
+type ExpiryAction string
+
+const (
+	// ExpiryDelete removes the container and its services; nothing is kept
+	ExpiryDelete ExpiryAction = "delete"
+)
+
+// ExpiryBehavior describes what happens at expiry, so frontends can tell users
+// whether their work survives
+type ExpiryBehavior struct {
+	Action             ExpiryAction `json:"action"`
+	GracePeriodSeconds int          `json:"grace_period_seconds"` // delay between expiry and the action
+	Archive            bool         `json:"archive"`              // workspace archived before removal
+	RetentionSeconds   int          `json:"retention_seconds"`    // how long an archive is kept
+}
@@ -0,0 +1,63 @@
+package models
+
+import "time"
+
+// GradingSpec is how a task's solution is graded: a shell command run in the
+// sandbox, and optionally the test report it writes
+type GradingSpec struct {
+	Command        string `yaml:"command" json:"command"`
+	WorkingDir     string `yaml:"working_dir" json:"working_dir,omitempty"` // the starter repo's path when empty
+	TimeoutSeconds int    `yaml:"timeout" json:"timeout_seconds,omitempty"` // the longest exec timeout when 0
+	// JUnitPath is the JUnit XML report the command writes, relative to WorkingDir unless absolute
+	JUnitPath string `yaml:"junit_path" json:"junit_path,omitempty"`
+}
+
+// GradingResult is one grading run of a sandbox. Results outlive the sandbox
+// so a candidate's solution can be scored after cleanup.
+type GradingResult struct {
+	ID        int64  `json:"id"`
+	SandboxID string `json:"sandbox_id"`
+	SessionID string `json:"session_id,omitempty"`
+	TaskID    string `json:"task_id"`
+	// OwnerClientID is the owner of the sandbox, kept for access checks once it is gone
+	OwnerClientID int `json:"-"`
+
+	// Passed is set when the command exited 0 and the report, if any, has no failures
+	Passed     bool   `json:"passed"`
+	ExitCode   int    `json:"exit_code"`
+	Stdout     string `json:"stdout"`
+	Stderr     string `json:"stderr"`
+	Truncated  bool   `json:"truncated,omitempty"` // the start of the output was dropped
+	DurationMs int64  `json:"duration_ms"`
+	// JUnit is the parsed report of the task's junit_path
+	JUnit *JUnitReport `json:"junit,omitempty"`
+	// Error is why grading did not complete: a timeout, or an unreadable report
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// JUnitReport sums up a JUnit XML report
+type JUnitReport struct {
+	Tests    int         `json:"tests"`
+	Failures int         `json:"failures"`
+	Errors   int         `json:"errors"`
+	Skipped  int         `json:"skipped"`
+	Cases    []JUnitCase `json:"cases"`
+}
+
+// JUnitCase statuses
+const (
+	JUnitPassed  = "passed"
+	JUnitFailed  = "failed"
+	JUnitError   = "error"
+	JUnitSkipped = "skipped"
+)
+
+// JUnitCase is one test case of a JUnit report
+type JUnitCase struct {
+	Name      string  `json:"name"`
+	ClassName string  `json:"classname,omitempty"`
+	Time      float64 `json:"time"` // seconds
+	Status    string  `json:"status"`
+	Message   string  `json:"message,omitempty"` // of a failure, error or skip
+}
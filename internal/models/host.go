@@ -0,0 +1,100 @@
+package models
+
+import "time"
+
+// DrainMode controls what happens to sandboxes already on a drained host
+type DrainMode string
+
+const (
+	DrainAtExpiry DrainMode = "at_expiry" // Keep running until their TTL elapses
+	DrainStopNow  DrainMode = "immediate" // Stop them right away
+)
+
+// IsValid reports whether m is a known drain mode
+func (m DrainMode) IsValid() bool {
+	return m == DrainAtExpiry || m == DrainStopNow
+}
+
+// HostState is the persisted scheduling state of a Docker host
+type HostState struct {
+	Name      string    `json:"name"`
+	Draining  bool      `json:"draining"`
+	DrainMode DrainMode `json:"drain_mode,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// HostInfo describes a Docker host for the admin API
+type HostInfo struct {
+	Name        string     `json:"name"`
+	Endpoint    string     `json:"endpoint"`
+	Sandboxes   int        `json:"sandboxes"`           // sandboxes holding resources on the host
+	Capacity    int        `json:"capacity"`            // 0 means unlimited
+	Available   int        `json:"available,omitempty"` // free slots, only set when capacity is limited
+	Draining    bool       `json:"draining"`
+	DrainMode   DrainMode  `json:"drain_mode,omitempty"`
+	DrainedAt   *time.Time `json:"drained_at,omitempty"`
+	Schedulable bool       `json:"schedulable"`
+}
+
+// DrainHostRequest is the body of a host drain request
+type DrainHostRequest struct {
+	// Mode is at_expiry (default) or immediate
+	Mode DrainMode `json:"mode,omitempty"`
+}
+
+// Component health statuses
+const (
+	HealthUp   = "up"
+	HealthDown = "down"
+)
+
+// ComponentHealth is the result of checking one dependency of the engine
+type ComponentHealth struct {
+	Status    string `json:"status"` // up or down
+	LatencyMS int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ManagedContainer is a Docker container labelled as created by the engine
+type ManagedContainer struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	SandboxID string    `json:"sandbox_id,omitempty"`
+	Image     string    `json:"image"`
+	State     string    `json:"state"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OrphanResource is one piece of drift between the database and Docker
+type OrphanResource struct {
+	ID        string `json:"id"` // the container ID, or the sandbox ID
+	Name      string `json:"name,omitempty"`
+	SandboxID string `json:"sandbox_id,omitempty"`
+	State     string `json:"state,omitempty"`
+	Removed   bool   `json:"removed"`
+	Error     string `json:"error,omitempty"`
+}
+
+// OrphanReport lists the drift found on the Docker host. On a dry run nothing
+// is removed; otherwise Removed and Error tell how each removal went.
+type OrphanReport struct {
+	DryRun     bool              `json:"dry_run"`
+	Containers []*OrphanResource `json:"containers"` // managed containers without a sandbox
+	Sandboxes  []*OrphanResource `json:"sandboxes"`  // sandboxes whose container is gone
+}
+
+// ProviderOrphans are the resources a service provider holds for sandboxes
+// that no longer exist; ID and SandboxID are the sandbox ID as recovered
+// from the resource names
+type ProviderOrphans struct {
+	Provider string            `json:"provider"`
+	Orphans  []*OrphanResource `json:"orphans"`
+	Error    string            `json:"error,omitempty"` // listing the provider's resources failed
+}
+
+// ProviderReconcileReport lists the orphaned resources of each service
+// provider, removed unless DryRun
+type ProviderReconcileReport struct {
+	DryRun    bool               `json:"dry_run"`
+	Providers []*ProviderOrphans `json:"providers"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// TerminalRecording is an asciicast v2 recording of one terminal shell.
+// Recordings outlive the sandbox so what a candidate did can be reviewed
+// after cleanup.
+type TerminalRecording struct {
+	ID        int64  `json:"id"`
+	SandboxID string `json:"sandbox_id"`
+	SessionID string `json:"session_id,omitempty"`
+	// OwnerClientID is the owner of the sandbox, kept for access checks once it is gone
+	OwnerClientID int `json:"-"`
+
+	StartedAt time.Time  `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"` // null while the shell is open
+	SizeBytes int64      `json:"size_bytes"`
+	// Truncated is set when the recording reached the size cap and stopped
+	Truncated bool `json:"truncated,omitempty"`
+	// Input is set when typed input was recorded along with the output
+	Input bool `json:"input,omitempty"`
+}
@@ -1,6 +1,11 @@
 package models
 
 import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -13,11 +18,22 @@ const (
 	StatusStopped  SandboxStatus = "stopped"
 	StatusFailed   SandboxStatus = "failed"
 	StatusExpired  SandboxStatus = "expired"
+	// StatusDeleting is a deleted sandbox whose services failed to
+	// deprovision; the cleanup worker retries the delete
+	StatusDeleting SandboxStatus = "deleting"
 )
 
-// IsTerminal returns true if the status is a terminal state
+// Status messages distinguishing why a sandbox was stopped
+const (
+	StatusMsgStoppedByUser = "stopped by user"
+	StatusMsgStoppedIdle   = "stopped after idle timeout"
+	StatusMsgStoppedDrain  = "stopped for host maintenance"
+)
+
+// IsTerminal returns true if the status is a terminal state.
+// Stopped sandboxes are not terminal: they keep their container and can be started again.
 func (s SandboxStatus) IsTerminal() bool {
-	return s == StatusStopped || s == StatusFailed || s == StatusExpired
+	return s == StatusFailed || s == StatusExpired || s == StatusDeleting
 }
 
 // IsRunning returns true if the sandbox is currently running
@@ -39,6 +55,18 @@ type Sandbox struct {
 	Services    map[string]*ServiceInstance `json:"services,omitempty"`
 	Endpoints   map[string]string           `json:"endpoints,omitempty"`
 	Metadata    map[string]string           `json:"metadata,omitempty"`
+
+	// LastActivityAt is the last time terminal input was seen
+	LastActivityAt *time.Time `json:"last_activity_at,omitempty"`
+
+	// Host is the Docker host the sandbox was placed on
+	Host string `json:"host,omitempty"`
+
+	// DeletedAt is set once the sandbox is deleted; its record is kept until purged
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+
+	// OwnerClientID is the API client that created the sandbox (0 if unknown)
+	OwnerClientID int `json:"owner_client_id,omitempty"`
 }
 
 // ServiceInstance represents a provisioned service for a sandbox
@@ -48,6 +76,76 @@ type ServiceInstance struct {
 	Status      string             `json:"status"`
 	Credentials *ServiceCredentials `json:"credentials,omitempty"`
 	CreatedAt   time.Time          `json:"created_at"`
+
+	// Result of the last connectivity check
+	StatusMsg     string     `json:"status_message,omitempty"`
+	LastCheckedAt *time.Time `json:"last_checked_at,omitempty"`
+
+	// Mode is dedicated for a service running in a container of the
+	// sandbox's own, ContainerID that container once created
+	Mode        ServiceMode `json:"mode,omitempty"`
+	ContainerID string      `json:"container_id,omitempty"`
+}
+
+// IsDedicated reports whether the instance runs in a container of its own
+func (s *ServiceInstance) IsDedicated() bool {
+	return s.Mode == ServiceDedicated
+}
+
+// ServiceMode selects where a template service is provisioned
+type ServiceMode string
+
+const (
+	// ServiceShared is a database, keyspace or bucket on the engine's shared server
+	ServiceShared ServiceMode = "shared"
+	// ServiceDedicated is a service container of the sandbox's own, on its
+	// network, with superuser credentials
+	ServiceDedicated ServiceMode = "dedicated"
+)
+
+// ServiceSpec is how a template provisions one of its services
+type ServiceSpec struct {
+	Mode ServiceMode `json:"mode,omitempty"`
+	// Version is the image tag of a dedicated service container
+	Version string `json:"version,omitempty"`
+}
+
+// ServiceConfig holds the per-service options of a template
+type ServiceConfig struct {
+	// Seed is a fixture file loaded into the service once provisioned, as
+	// written in the template: relative to the template's directory
+	Seed string `yaml:"seed" json:"seed,omitempty"`
+	// SeedPath is the resolved path of Seed
+	SeedPath string `yaml:"-" json:"-"`
+	// Options are passed to the provider of a shared service, which
+	// documents the keys it takes
+	Options map[string]string `yaml:"options" json:"options,omitempty"`
+}
+
+// DedicatedServiceImages are the images of the services that can run
+// dedicated, with the tag used without a version
+var DedicatedServiceImages = map[string]struct{ Image, DefaultVersion string }{
+	"postgres": {Image: "postgres", DefaultVersion: "16"},
+	"redis":    {Image: "redis", DefaultVersion: "7"},
+}
+
+// Service instance statuses
+const (
+	ServiceStatusReady     = "ready"
+	ServiceStatusUnhealthy = "unhealthy"
+
+	// ServiceStatusProvisioning is planned with the sandbox but not provisioned yet
+	ServiceStatusProvisioning = "provisioning"
+)
+
+// redactedValue replaces secrets in API responses
+const redactedValue = "[REDACTED]"
+
+// Redacted returns a copy of the instance with secrets removed from its credentials
+func (s *ServiceInstance) Redacted() *ServiceInstance {
+	out := *s
+	out.Credentials = s.Credentials.Redacted()
+	return &out
 }
 
 // ServiceCredentials holds connection information for a service
@@ -60,6 +158,26 @@ type ServiceCredentials struct {
 	Namespace string `json:"namespace,omitempty"`
 	Prefix    string `json:"prefix,omitempty"`
 	URI       string `json:"uri,omitempty"`
+	// Token is the session token of temporary credentials, e.g. an S3 key pair
+	Token string `json:"token,omitempty"`
+}
+
+// Redacted returns a copy of the credentials with the password masked,
+// both in its own field and inside the URI, and the token masked
+func (c *ServiceCredentials) Redacted() *ServiceCredentials {
+	if c == nil {
+		return nil
+	}
+
+	out := *c
+	if out.Password != "" {
+		out.URI = strings.ReplaceAll(out.URI, ":"+out.Password+"@", ":"+redactedValue+"@")
+		out.Password = redactedValue
+	}
+	if out.Token != "" {
+		out.Token = redactedValue
+	}
+	return &out
 }
 
 // IsExpired checks if the sandbox has expired
@@ -67,6 +185,15 @@ func (s *Sandbox) IsExpired() bool {
 	return time.Now().After(s.ExpiresAt)
 }
 
+// LifetimeStart is when the sandbox started, or was created before it did;
+// TTL caps measure its lifetime from there
+func (s *Sandbox) LifetimeStart() time.Time {
+	if s.StartedAt != nil {
+		return *s.StartedAt
+	}
+	return s.CreatedAt
+}
+
 // Template represents a sandbox template configuration
 type Template struct {
 	Name        string            `yaml:"name" json:"name"`
@@ -80,6 +207,148 @@ type Template struct {
 	Volumes     []Volume          `yaml:"volumes" json:"volumes"`
 	Commands    Commands          `yaml:"commands" json:"commands"`
 	Labels      map[string]string `yaml:"labels" json:"labels"`
+	// Annotations are engine-level metadata (team, cost center...) that never reach Docker
+	Annotations map[string]string `yaml:"annotations" json:"annotations,omitempty"`
+	AutoExtend  *AutoExtendPolicy `yaml:"auto_extend" json:"auto_extend,omitempty"`
+
+	// MaxTTL caps the lifetime of the template's sandboxes and sessions,
+	// extensions included; SANDBOX_MAX_TTL wins when stricter
+	MaxTTL time.Duration `yaml:"max_ttl" json:"max_ttl,omitempty"`
+
+	// Command and Entrypoint replace the image's CMD and ENTRYPOINT when set
+	Command    []string `yaml:"command" json:"command,omitempty"`
+	Entrypoint []string `yaml:"entrypoint" json:"entrypoint,omitempty"`
+	// AllowCommandOverride lets create requests replace Command
+	AllowCommandOverride bool `yaml:"allow_command_override" json:"allow_command_override,omitempty"`
+
+	// CredentialsDelivery selects env vars, a file, or both for service credentials
+	CredentialsDelivery CredentialsDelivery `yaml:"credentials_delivery" json:"credentials_delivery,omitempty"`
+	// CredentialsFile is set whenever credentials are delivered in a file
+	CredentialsFile *CredentialsFileSpec `yaml:"credentials_file" json:"credentials_file,omitempty"`
+
+	// Recording records the terminals of the template's sandboxes for playback
+	Recording *RecordingPolicy `yaml:"recording" json:"recording,omitempty"`
+
+	// Terminal configures the shell that terminals open in the template's sandboxes
+	Terminal *TerminalSpec `yaml:"terminal" json:"terminal,omitempty"`
+
+	// Tags, Icon (a URL or an icon key) and Language drive the template picker
+	Tags     []string `yaml:"tags" json:"tags,omitempty"`
+	Icon     string   `yaml:"icon" json:"icon,omitempty"`
+	Language string   `yaml:"language" json:"language,omitempty"`
+	// Enabled false hides the template from the list and from new sandboxes
+	// and sessions; nil enables it
+	Enabled *bool `yaml:"enabled" json:"enabled,omitempty"`
+
+	// Descriptions holds every locale of a description written with several;
+	// Description is in the catalog's default locale
+	Descriptions LocalizedText `yaml:"-" json:"descriptions,omitempty"`
+
+	// ServiceSpecs holds the services listed with a mode or version, by name;
+	// the others are shared
+	ServiceSpecs map[string]ServiceSpec `yaml:"-" json:"service_specs,omitempty"`
+
+	// ServicesConfig holds the options of services, by name
+	ServicesConfig map[string]ServiceConfig `yaml:"-" json:"services_config,omitempty"`
+
+	// Resolved holds the fields whose value took deployment variables at
+	// load, as written with their placeholders, by field path: base_image,
+	// env.NAME, command[0]...
+	Resolved map[string]string `yaml:"-" json:"-"`
+}
+
+// Redacted returns a copy of the template with the fields that took
+// deployment variables shown as written, so values such as registry
+// credentials stay out of API responses
+func (t *Template) Redacted() *Template {
+	if len(t.Resolved) == 0 {
+		return t
+	}
+
+	out := *t
+	out.Env, out.Labels, out.Annotations = maps.Clone(t.Env), maps.Clone(t.Labels), maps.Clone(t.Annotations)
+	lists := map[string]*[]string{
+		"command":        &out.Command,
+		"entrypoint":     &out.Entrypoint,
+		"commands.init":  &out.Commands.Init,
+		"commands.start": &out.Commands.Start,
+		"commands.stop":  &out.Commands.Stop,
+	}
+	for _, list := range lists {
+		*list = slices.Clone(*list)
+	}
+
+	for field, written := range t.Resolved {
+		if key, ok := strings.CutPrefix(field, "env."); ok {
+			out.Env[key] = written
+		} else if key, ok := strings.CutPrefix(field, "labels."); ok {
+			out.Labels[key] = written
+		} else if key, ok := strings.CutPrefix(field, "annotations."); ok {
+			out.Annotations[key] = written
+		} else if name, index, ok := strings.Cut(field, "["); ok {
+			i, err := strconv.Atoi(strings.TrimSuffix(index, "]"))
+			if list := lists[name]; list != nil && err == nil && i >= 0 && i < len(*list) {
+				(*list)[i] = written
+			}
+		}
+		switch field {
+		case "base_image":
+			out.BaseImage = written
+		case "commands.healthcheck":
+			out.Commands.Healthcheck = written
+		}
+	}
+	return &out
+}
+
+// ServiceSpec returns how the template provisions service name
+func (t *Template) ServiceSpec(name string) ServiceSpec {
+	spec := t.ServiceSpecs[name]
+	if spec.Mode == "" {
+		spec.Mode = ServiceShared
+	}
+	return spec
+}
+
+// IsEnabled reports whether new sandboxes and sessions may use the template
+func (t *Template) IsEnabled() bool {
+	return t.Enabled == nil || *t.Enabled
+}
+
+// HasTag reports whether the template is tagged tag, ignoring case
+func (t *Template) HasTag(tag string) bool {
+	for _, tt := range t.Tags {
+		if strings.EqualFold(tt, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// TerminalSpec defines the terminal shell. Without Shell, bash is started as a
+// login shell, or /bin/sh -l on images without bash; without User, the
+// image's default user runs it.
+type TerminalSpec struct {
+	Shell []string `yaml:"shell" json:"shell,omitempty"`
+	User  string   `yaml:"user" json:"user,omitempty"`
+	// AllowCommand lets terminal clients ask for another command to run
+	AllowCommand bool `yaml:"allow_command" json:"allow_command,omitempty"`
+}
+
+// RecordingPolicy defines terminal recording. Output is recorded when enabled;
+// Input also records what was typed, passwords included.
+type RecordingPolicy struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	Input   bool `yaml:"input" json:"input,omitempty"`
+}
+
+// AutoExtendPolicy defines automatic TTL extension on terminal activity.
+// Zero values fall back to the global configuration.
+type AutoExtendPolicy struct {
+	Enabled *bool         `yaml:"enabled" json:"enabled,omitempty"`
+	Window  time.Duration `yaml:"window" json:"window,omitempty"`
+	Step    time.Duration `yaml:"step" json:"step,omitempty"`
+	MaxTTL  time.Duration `yaml:"max_ttl" json:"max_ttl,omitempty"`
 }
 
 // Resources defines resource limits for a sandbox
@@ -89,6 +358,27 @@ type Resources struct {
 	CPURequest    string `yaml:"cpu_request" json:"cpu_request"`
 	MemoryRequest string `yaml:"memory_request" json:"memory_request"`
 	DiskLimit     string `yaml:"disk_limit" json:"disk_limit"`
+
+	// GPUs is a device count or "all"; empty requests none
+	GPUs string `yaml:"gpus" json:"gpus,omitempty"`
+}
+
+// GPUsAll requests every GPU on the host
+const GPUsAll = "all"
+
+// GPUCount parses GPUs: 0 when none are requested, -1 for all
+func (r Resources) GPUCount() (int, error) {
+	switch r.GPUs {
+	case "":
+		return 0, nil
+	case GPUsAll:
+		return -1, nil
+	}
+	n, err := strconv.Atoi(r.GPUs)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("gpus must be a positive count or %q, got %q", GPUsAll, r.GPUs)
+	}
+	return n, nil
 }
 
 // Port defines an exposed port configuration
@@ -123,15 +413,82 @@ type ListFilters struct {
 	Status     SandboxStatus
 	Limit      int
 	Offset     int
+
+	Host string
+	// DefaultHost is the host of sandboxes without a recorded one, which were
+	// created before placement was tracked; empty leaves them out of Host
+	DefaultHost string
+
+	// CreatedAfter (inclusive) and CreatedBefore (exclusive) bound created_at; zero leaves a side open
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	// Metadata matches sandboxes whose metadata contains every given key/value pair
+	Metadata map[string]string
+
+	// IncludeDeleted also returns deleted sandboxes whose records aren't purged yet
+	IncludeDeleted bool
+
+	// OwnerClientID limits results to sandboxes created by the client; 0 matches any owner
+	OwnerClientID int
+
+	// Sort orders listings, newest first by default; Ascending reverses the direction
+	Sort      SandboxSort
+	Ascending bool
+}
+
+// SandboxSort is a field sandbox listings can be ordered by
+type SandboxSort string
+
+const (
+	SortCreatedAt SandboxSort = "created_at"
+	SortExpiresAt SandboxSort = "expires_at"
+	SortStatus    SandboxSort = "status"
+	SortUserID    SandboxSort = "user_id"
+)
+
+// SandboxSorts lists every supported sort field
+var SandboxSorts = []SandboxSort{SortCreatedAt, SortExpiresAt, SortStatus, SortUserID}
+
+// Valid reports whether s is a supported sort field; empty means the default
+func (s SandboxSort) Valid() bool {
+	return s == "" || slices.Contains(SandboxSorts, s)
 }
 
 // CreateRequest represents a request to create a sandbox
 type CreateRequest struct {
-	TemplateID string            `json:"template_id"`
+	TemplateID string            `json:"template_id"` // defaults to the task's project template
+	TaskID     string            `json:"task_id,omitempty"`
 	UserID     string            `json:"user_id"`
 	TTL        *time.Duration    `json:"ttl,omitempty"`
 	Env        map[string]string `json:"env,omitempty"`
 	Metadata   map[string]string `json:"metadata,omitempty"`
+
+	// Command overrides the template command; the template must allow it
+	Command []string `json:"command,omitempty"`
+}
+
+// LogExportRequest selects sandboxes for a bulk log export (same filters as list)
+type LogExportRequest struct {
+	UserID     string        `json:"user_id,omitempty"`
+	TemplateID string        `json:"template_id,omitempty"`
+	Status     SandboxStatus `json:"status,omitempty"`
+	// TailBytes is how many bytes from the end of each log to include
+	TailBytes int64 `json:"tail_bytes,omitempty"`
+}
+
+// BulkDeleteRequest selects sandboxes to delete: explicit IDs, or every
+// sandbox matching the filter fields
+type BulkDeleteRequest struct {
+	IDs           []string      `json:"ids,omitempty"`
+	UserID        string        `json:"user_id,omitempty"`
+	TemplateID    string        `json:"template_id,omitempty"`
+	Status        SandboxStatus `json:"status,omitempty"`
+	CreatedBefore *time.Time    `json:"created_before,omitempty"`
+}
+
+// HasFilter reports whether any filter field is set
+func (r BulkDeleteRequest) HasFilter() bool {
+	return r.UserID != "" || r.TemplateID != "" || r.Status != "" || r.CreatedBefore != nil
 }
 
 // ExtendRequest represents a request to extend sandbox TTL
@@ -1,8 +1,11 @@
 package models
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
+	"strings"
 	"time"
 )
 
@@ -15,6 +18,38 @@ const (
 	SessionActive       SessionStatus = "active"        // Sandbox running, timer ticking
 	SessionExpired      SessionStatus = "expired"       // TTL elapsed
 	SessionFailed       SessionStatus = "failed"        // Error during provisioning
+	SessionRevoked      SessionStatus = "revoked"       // Access cut by an admin, record kept
+	SessionSubmitted    SessionStatus = "submitted"     // Candidate handed in, workspace archived
+
+	// SessionInvalid is only reported by join: the session can never start
+	// (its template is gone). It is not stored.
+	SessionInvalid SessionStatus = "invalid"
+)
+
+// SessionFilterInvalidTemplate is the ListSessions status filter for ready or failed
+// sessions whose template no longer exists
+const SessionFilterInvalidTemplate = "invalid_template"
+
+// SessionFilters selects sessions in listings, like ListFilters for sandboxes
+type SessionFilters struct {
+	// Status is a session status, or SessionFilterInvalidTemplate
+	Status     string
+	TemplateID string
+	CreatedBy  string
+	Limit      int
+	Offset     int
+
+	// CreatedAfter (inclusive) and CreatedBefore (exclusive) bound created_at; zero leaves a side open
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+
+	// KnownTemplates are the loaded templates, which SessionFilterInvalidTemplate matches against
+	KnownTemplates []string
+}
+
+// Join reasons for an invalid session
+const (
+	ReasonTemplateMissing = "template_missing"
 )
 
 // Session represents a deferred sandbox session.
@@ -35,11 +70,80 @@ type Session struct {
 	ActivatedAt   *time.Time        `json:"activated_at,omitempty"`
 	ExpiresAt     *time.Time        `json:"expires_at,omitempty"`
 	CreatedBy     string            `json:"created_by,omitempty"`
+
+	// JoinBy is the deadline for activation; a session still ready afterwards
+	// expires. Nil means no deadline.
+	JoinBy *time.Time `json:"join_by,omitempty"`
+
+	// PrewarmAt is when the sandbox of a ready session is created ahead of
+	// activation; the session stays ready with sandbox_id set until joined
+	PrewarmAt *time.Time `json:"prewarm_at,omitempty"`
+
+	// RevokedAt and RevokedBy record who revoked the session, and when
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	RevokedBy string     `json:"revoked_by,omitempty"`
+
+	// SubmittedAt is when the candidate handed in the workspace
+	SubmittedAt *time.Time `json:"submitted_at,omitempty"`
+
+	// ExtendedSeconds is the time added by extensions
+	ExtendedSeconds int `json:"extended_seconds,omitempty"`
+	// SandboxStatus is the last status of the session's sandbox, recorded
+	// when the sandbox is deleted
+	SandboxStatus SandboxStatus `json:"sandbox_status,omitempty"`
+
+	// UniqueKey allows at most one live (provisioning or active) session per key
+	UniqueKey  string         `json:"unique_key,omitempty"`
+	OnConflict ConflictPolicy `json:"on_conflict,omitempty"`
+
+	// CallbackURL receives a signed POST on each CallbackEvent; CallbackSecret
+	// is the HMAC key, only shown in the create response
+	CallbackURL    string `json:"callback_url,omitempty"`
+	CallbackSecret string `json:"-"`
+	// CallbackDeliveries are filled in by the session lookup, for debugging callbacks
+	CallbackDeliveries []*CallbackDelivery `json:"callback_deliveries,omitempty"`
+
+	// RecordTerminal records the session's terminals even when its template doesn't
+	RecordTerminal bool `json:"record_terminal,omitempty"`
+
+	// OwnerClientID is the API client that created the session (0 for none);
+	// the session's sandbox is created on its behalf
+	OwnerClientID int `json:"-"`
+}
+
+// ConflictPolicy decides what activation does when another session with the
+// same unique key is already live
+type ConflictPolicy string
+
+const (
+	ConflictReject    ConflictPolicy = "reject"    // Refuse to activate the new session
+	ConflictSupersede ConflictPolicy = "supersede" // Expire the older session, then activate
+)
+
+// IsValid reports whether p is a known policy
+func (p ConflictPolicy) IsValid() bool {
+	return p == ConflictReject || p == ConflictSupersede
+}
+
+// StatusMsgSuperseded prefixes the status message of a session expired by a newer one
+const StatusMsgSuperseded = "superseded by session "
+
+// StatusMsgTemplateMissing prefixes the status message of a session failed because
+// its template was removed before activation
+const StatusMsgTemplateMissing = "template not found: "
+
+// StatusMsgJoinDeadline is the status message of a session expired because it
+// was not started before its join deadline
+const StatusMsgJoinDeadline = "not started before the join deadline"
+
+// TemplateMissing reports whether the session failed because its template is gone
+func (s *Session) TemplateMissing() bool {
+	return s.Status == SessionFailed && strings.HasPrefix(s.StatusMessage, StatusMsgTemplateMissing)
 }
 
 // IsTerminal returns true if the session is in a final state
 func (s *Session) IsTerminal() bool {
-	return s.Status == SessionExpired || s.Status == SessionFailed
+	return s.Status == SessionExpired || s.Status == SessionFailed || s.Status == SessionRevoked || s.Status == SessionSubmitted
 }
 
 // IsActivatable returns true if the session can be activated
@@ -47,6 +151,16 @@ func (s *Session) IsActivatable() bool {
 	return s.Status == SessionReady
 }
 
+// TaskID returns the catalog task the session was created for, if any
+func (s *Session) TaskID() string {
+	return s.Metadata[MetadataTaskID]
+}
+
+// JoinExpired reports whether the session is still ready past its join deadline
+func (s *Session) JoinExpired() bool {
+	return s.Status == SessionReady && s.JoinBy != nil && time.Now().After(*s.JoinBy)
+}
+
 // IsExpired checks if the session TTL has elapsed
 func (s *Session) IsExpired() bool {
 	if s.ExpiresAt == nil {
@@ -78,12 +192,27 @@ func GenerateSessionToken() (string, error) {
 
 // CreateSessionRequest represents a request to create a session
 type CreateSessionRequest struct {
-	TemplateID      string            `json:"template_id"`
-	TTL             int               `json:"ttl"`                          // seconds
+	TemplateID      string            `json:"template_id"`                  // defaults to the task's project template
+	TaskID          string            `json:"task_id,omitempty"`            // catalog task, stored as metadata task_id
+	TTL             int               `json:"ttl"`                          // seconds; defaults to the task's time limit
+	JoinTTL         int               `json:"join_ttl_seconds,omitempty"`   // seconds to activate; 0 uses SESSION_JOIN_TTL
+	PrewarmAt       *time.Time        `json:"prewarm_at,omitempty"`         // creates the sandbox ahead of activation
 	Env             map[string]string `json:"env,omitempty"`
 	Metadata        map[string]string `json:"metadata,omitempty"`
 	Services        []string          `json:"services,omitempty"`
 	TaskDescription string            `json:"task_description,omitempty"`
+
+	// UniqueKey (e.g. a hash of the candidate's email) prevents two live sessions for the same key
+	UniqueKey string `json:"unique_key,omitempty"`
+	// OnConflict is reject (default) or supersede
+	OnConflict ConflictPolicy `json:"on_conflict,omitempty"`
+	// CallbackURL is POSTed to when the session is activated, expires, is submitted or fails
+	CallbackURL string `json:"callback_url,omitempty"`
+	// RecordTerminal records the candidate's terminal output for playback
+	RecordTerminal bool `json:"record_terminal,omitempty"`
+
+	// OwnerClientID is the API client creating the session, set by the handler
+	OwnerClientID int `json:"-"`
 }
 
 // CreateSessionResponse is returned after creating a session
@@ -93,23 +222,166 @@ type CreateSessionResponse struct {
 	TemplateID string        `json:"template_id"`
 	Status     SessionStatus `json:"status"`
 	JoinURL    string        `json:"join_url"`
+	JoinBy     *time.Time    `json:"join_by,omitempty"`
 	CreatedAt  time.Time     `json:"created_at"`
+
+	// CallbackSecret signs the session's callbacks; it is not shown again
+	CallbackSecret string `json:"callback_secret,omitempty"`
+}
+
+// BulkCreateSessionsRequest creates one session per entry, for a cohort of
+// candidates. Everything but the entries is shared by all sessions.
+type BulkCreateSessionsRequest struct {
+	CreateSessionRequest
+	Entries []BulkSessionEntry `json:"entries"`
+}
+
+// BulkSessionEntry is what sets one session of a bulk create apart
+type BulkSessionEntry struct {
+	Metadata  map[string]string `json:"metadata,omitempty"` // merged over the shared metadata
+	UniqueKey string            `json:"unique_key,omitempty"`
+}
+
+// BulkCreateSessionResult is the outcome of one entry of a bulk create
+type BulkCreateSessionResult struct {
+	Index   int                    `json:"index"` // position in the request's entries
+	Success bool                   `json:"success"`
+	Session *CreateSessionResponse `json:"session,omitempty"`
+	Code    string                 `json:"code,omitempty"`
+	Error   string                 `json:"error,omitempty"`
+}
+
+// BulkCreateSessionsResponse reports a bulk create, one result per entry in request order
+type BulkCreateSessionsResponse struct {
+	Results []BulkCreateSessionResult `json:"results"`
+	Total   int                       `json:"total"`
+	Created int                       `json:"created"`
+	Failed  int                       `json:"failed"`
+}
+
+// SessionConnection is one terminal connection of a session. DisconnectedAt
+// is nil while it is open, or when the server stopped before recording it.
+type SessionConnection struct {
+	ID             int64      `json:"id"`
+	SessionID      string     `json:"session_id"`
+	ConnectedAt    time.Time  `json:"connected_at"`
+	DisconnectedAt *time.Time `json:"disconnected_at,omitempty"`
+}
+
+// CallbackEvent is a session transition reported to the session's callback URL
+type CallbackEvent string
+
+const (
+	CallbackActivated CallbackEvent = "activated"
+	CallbackExpired   CallbackEvent = "expired"
+	CallbackSubmitted CallbackEvent = "submitted"
+	CallbackFailed    CallbackEvent = "failed"
+)
+
+// Status is the session status the event reports
+func (e CallbackEvent) Status() SessionStatus {
+	switch e {
+	case CallbackActivated:
+		return SessionActive
+	case CallbackExpired:
+		return SessionExpired
+	case CallbackSubmitted:
+		return SessionSubmitted
+	}
+	return SessionFailed
+}
+
+// CallbackSignatureHeader carries the signature of a callback body, see SignCallback
+const CallbackSignatureHeader = "X-Sandbox-Signature"
+
+// SignCallback returns the CallbackSignatureHeader value for body: "sha256="
+// and the hex HMAC-SHA256 of body keyed with the session's callback secret
+func SignCallback(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// CallbackPayload is the body POSTed to a session's callback URL
+type CallbackPayload struct {
+	Event     CallbackEvent `json:"event"`
+	SessionID string        `json:"session_id"`
+	Status    SessionStatus `json:"status"`
+	SandboxID string        `json:"sandbox_id,omitempty"`
+	Timestamp time.Time     `json:"timestamp"`
+}
+
+// CallbackDelivery is one callback in the delivery outbox. It keeps what to
+// POST, so retries survive a restart; a delivery that is neither delivered
+// nor has a next attempt has given up.
+type CallbackDelivery struct {
+	ID          int64         `json:"id"`
+	SessionID   string        `json:"-"`
+	Event       CallbackEvent `json:"event"`
+	Delivered   bool          `json:"delivered"`
+	Attempts    int           `json:"attempts"`
+	StatusCode  int           `json:"status_code,omitempty"` // of the last attempt
+	Error       string        `json:"error,omitempty"`
+	AttemptedAt time.Time     `json:"attempted_at"` // of the last attempt
+
+	// NextAttemptAt is when the delivery is tried again, while it is pending
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+
+	URL     string `json:"-"`
+	Secret  string `json:"-"`
+	Payload []byte `json:"-"`
+}
+
+// SessionReport summarizes what happened in a session, e.g. after an
+// interview. It is built from persisted records only, so it stays available
+// after the sandbox is gone.
+type SessionReport struct {
+	SessionID     string        `json:"session_id"`
+	Status        SessionStatus `json:"status"`
+	CreatedAt     time.Time     `json:"created_at"`
+	JoinedAt      *time.Time    `json:"joined_at,omitempty"`
+	ExpiresAt     *time.Time    `json:"expires_at,omitempty"`
+	SubmittedAt   *time.Time    `json:"submitted_at,omitempty"`
+	RevokedAt     *time.Time    `json:"revoked_at,omitempty"`
+	SandboxID     string        `json:"sandbox_id,omitempty"`
+	SandboxStatus SandboxStatus `json:"sandbox_status,omitempty"` // live status, or the last one once deleted
+
+	ExtendedSeconds  int `json:"extended_seconds"`
+	ConnectedSeconds int `json:"connected_seconds"` // overlapping connections count once
+	Connections      int `json:"connections"`
+	Reconnects       int `json:"reconnects"` // connections after the first
+
+	TerminalConnections []*SessionConnection `json:"terminal_connections"`
+	// Events are the sandbox's lifecycle events, until the event retention purges them
+	Events []*SandboxEvent `json:"events"`
+	// Grading are the grading runs of the sandbox, oldest first
+	Grading []*GradingResult `json:"grading"`
+	// Recordings are the terminal recordings of the session, oldest first
+	Recordings []*TerminalRecording `json:"recordings"`
 }
 
 // JoinSessionResponse is returned for public join endpoint
 type JoinSessionResponse struct {
 	Status          SessionStatus     `json:"status"`
 	Template        *TemplateInfo     `json:"template,omitempty"`
+	Reason          string            `json:"reason,omitempty"` // set when status is invalid
 	Metadata        map[string]string `json:"metadata,omitempty"`
 	TaskDescription string            `json:"task_description,omitempty"`
 	Sandbox         *SandboxInfo      `json:"sandbox,omitempty"`
+	Task            *CatalogTask      `json:"task,omitempty"`
+	JoinBy          *time.Time        `json:"join_by,omitempty"` // set while the session waits to be started
+	SubmittedAt     *time.Time        `json:"submitted_at,omitempty"`
+
+	// ExpiryBehavior tells the candidate what happens when time runs out; omitted once the session ended
+	ExpiryBehavior *ExpiryBehavior `json:"expiry_behavior,omitempty"`
 }
 
 // TemplateInfo is a subset of template data for the join response
 type TemplateInfo struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
-	Language    string `json:"language,omitempty"`
+	Language    string   `json:"language,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
 }
 
 // SandboxInfo holds sandbox details returned after activation
@@ -131,6 +403,13 @@ type ServiceInfo struct {
 
 // ActivateSessionResponse is returned when activating a session
 type ActivateSessionResponse struct {
-	Status    SessionStatus `json:"status"`
-	SandboxID string        `json:"sandbox_id,omitempty"`
+	Status        SessionStatus `json:"status"`
+	StatusMessage string        `json:"status_message,omitempty"`
+	SandboxID     string        `json:"sandbox_id,omitempty"`
+}
+
+// SubmitSessionResponse is returned when the candidate hands in a session
+type SubmitSessionResponse struct {
+	Status      SessionStatus `json:"status"`
+	SubmittedAt *time.Time    `json:"submitted_at,omitempty"`
 }
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// TemplateDocument is a template created through the API, stored as the YAML
+// or JSON document it was created from so it loads like a template file
+type TemplateDocument struct {
+	Name      string    `json:"name"`
+	Document  string    `json:"document"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
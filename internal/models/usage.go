@@ -0,0 +1,53 @@
+package models
+
+import "time"
+
+// MetadataTaskID is the sandbox/session metadata key naming the catalog task
+// (e.g. "fintech/python-trading/limit-orders") the sandbox was created for
+const MetadataTaskID = "task_id"
+
+// Sandbox metadata keys carrying the task's skills (comma-separated) and time
+// limit in seconds
+const (
+	MetadataTaskSkills    = "task_skills"
+	MetadataTaskTimeLimit = "task_time_limit"
+)
+
+// UsageRecord is the usage contribution of one sandbox, written once when it
+// reaches a terminal transition
+type UsageRecord struct {
+	SandboxID      string
+	TemplateID     string
+	TaskID         string    // empty when the sandbox isn't tied to a catalog task
+	UsedAt         time.Time // sandbox creation time; its UTC date is the aggregation day
+	Succeeded      bool      // the sandbox ran
+	Failed         bool      // provisioning failed
+	SessionSeconds int64     // how long it ran, for successful runs
+}
+
+// TemplateUsage is aggregated usage of a template, or of one of its catalog tasks
+type TemplateUsage struct {
+	TemplateID        string           `json:"template_id"`
+	TaskID            string           `json:"task_id,omitempty"`
+	Creations         int64            `json:"creations"`
+	Successes         int64            `json:"successes"`
+	Failures          int64            `json:"failures"`
+	AvgSessionSeconds float64          `json:"avg_session_seconds"`
+	SessionSeconds    int64            `json:"-"`
+	LastUsedAt        *time.Time       `json:"last_used_at,omitempty"`
+	Tasks             []*TemplateUsage `json:"tasks,omitempty"`
+}
+
+// UsageReport is the usage of all templates over a date range
+type UsageReport struct {
+	From      time.Time        `json:"from"`
+	To        time.Time        `json:"to"`
+	Templates []*TemplateUsage `json:"templates"`
+	Totals    TemplateUsage    `json:"totals"`
+}
+
+// UsageSummary is the short usage field shown on template and task responses
+type UsageSummary struct {
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Usage30d   int64      `json:"usage_30d"`
+}
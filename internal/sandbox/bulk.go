@@ -0,0 +1,168 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+const (
+	// DefaultBulkDeleteMax is the largest bulk delete when SandboxConfig.BulkDeleteMax is unset
+	DefaultBulkDeleteMax = 100
+	// DefaultBulkSessionMax is the largest bulk session create when SandboxConfig.BulkSessionMax is unset
+	DefaultBulkSessionMax = 100
+	// bulkDeleteConcurrency is how many sandboxes a bulk delete tears down at once
+	bulkDeleteConcurrency = 8
+)
+
+// BulkDeleteOptions selects the sandboxes of a bulk delete: IDs when set,
+// otherwise every sandbox matching Filters. Filters.OwnerClientID scopes IDs
+// too, so sandboxes of other owners are reported as not found.
+type BulkDeleteOptions struct {
+	IDs     []string
+	Filters models.ListFilters
+}
+
+// BulkDeleteResult is the outcome of deleting one sandbox. Err is
+// ErrOperationPending when the deletion continues in the background.
+type BulkDeleteResult struct {
+	SandboxID string
+	Err       error
+}
+
+// BulkDelete deletes the selected sandboxes, a few at a time, and returns one
+// result per sandbox in selection order. Selecting more than the configured
+// maximum fails with ErrTooManySandboxes before anything is deleted.
+func (m *DockerManager) BulkDelete(ctx context.Context, opts BulkDeleteOptions) ([]*BulkDeleteResult, error) {
+	limit := m.sandboxConfig.BulkDeleteMax
+	if limit <= 0 {
+		limit = DefaultBulkDeleteMax
+	}
+
+	ids := uniqueIDs(opts.IDs)
+	if len(opts.IDs) == 0 {
+		// Fetch one past the cap to detect overflow
+		filters := opts.Filters
+		filters.Limit = limit + 1
+		filters.Offset = 0
+		sandboxes, err := m.repo.ListSandboxes(ctx, filters)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list sandboxes: %w", err)
+		}
+		for _, sb := range sandboxes {
+			ids = append(ids, sb.ID)
+		}
+	}
+	if len(ids) > limit {
+		return nil, fmt.Errorf("%w: a bulk delete covers at most %d", ErrTooManySandboxes, limit)
+	}
+
+	owner := opts.Filters.OwnerClientID
+	return bulkDelete(ctx, ids, bulkDeleteConcurrency, func(ctx context.Context, id string) error {
+		if owner != 0 {
+			sb, err := m.repo.GetSandbox(ctx, id)
+			if err != nil {
+				return fmt.Errorf("failed to get sandbox: %w", err)
+			}
+			if sb == nil || sb.OwnerClientID != owner {
+				return ErrSandboxNotFound
+			}
+		}
+		return m.Delete(ctx, id)
+	}), nil
+}
+
+// ErrTooManySessions is returned when a bulk create has more entries than allowed
+var ErrTooManySessions = errors.New("too many sessions requested")
+
+// BulkSessionResult is the outcome of creating one session of a bulk create
+type BulkSessionResult struct {
+	Session *models.Session
+	Err     error
+}
+
+// CreateSessions creates one session per entry of req and returns one result
+// per entry in request order. Each session is a single insert, so an entry
+// that fails leaves nothing behind and doesn't stop the others. A missing
+// template or task, or more entries than the configured maximum, fails the
+// whole call before anything is created.
+func (m *DockerManager) CreateSessions(ctx context.Context, req models.BulkCreateSessionsRequest, createdBy string) ([]*BulkSessionResult, error) {
+	limit := m.sandboxConfig.BulkSessionMax
+	if limit <= 0 {
+		limit = DefaultBulkSessionMax
+	}
+	if len(req.Entries) > limit {
+		return nil, fmt.Errorf("%w: a bulk create makes at most %d", ErrTooManySessions, limit)
+	}
+
+	shared := req.CreateSessionRequest
+	task, tmpl, err := m.sessionTemplate(&shared)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := m.sessionTTL(shared, task, tmpl); err != nil {
+		return nil, err
+	}
+	if err := checkPrewarm(shared, m.joinDeadline(shared, time.Now())); err != nil {
+		return nil, err
+	}
+	if shared.CallbackURL != "" {
+		if err := checkCallbackURL(shared.CallbackURL, m.callbackNets); err != nil {
+			return nil, err
+		}
+	}
+
+	results := make([]*BulkSessionResult, 0, len(req.Entries))
+	for _, entry := range req.Entries {
+		one := shared
+		one.Env = maps.Clone(shared.Env)
+		one.Metadata = maps.Clone(shared.Metadata)
+		if one.Metadata == nil {
+			one.Metadata = make(map[string]string, len(entry.Metadata))
+		}
+		maps.Copy(one.Metadata, entry.Metadata)
+		one.UniqueKey = entry.UniqueKey
+
+		session, err := m.CreateSession(ctx, one, createdBy)
+		results = append(results, &BulkSessionResult{Session: session, Err: err})
+	}
+	return results, nil
+}
+
+// bulkDelete runs del for every ID with at most concurrency running at once
+func bulkDelete(ctx context.Context, ids []string, concurrency int, del func(ctx context.Context, id string) error) []*BulkDeleteResult {
+	results := make([]*BulkDeleteResult, len(ids))
+	slots := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			results[i] = &BulkDeleteResult{SandboxID: id, Err: del(ctx, id)}
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
+
+// uniqueIDs drops repeated IDs, keeping the first occurrence
+func uniqueIDs(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	out := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out
+}
@@ -0,0 +1,56 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBulkDeleteBoundsConcurrency(t *testing.T) {
+	ids := make([]string, 20)
+	for i := range ids {
+		ids[i] = fmt.Sprint("sb-", i)
+	}
+
+	var running, peak atomic.Int32
+	results := bulkDelete(context.Background(), ids, 3, func(ctx context.Context, id string) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		if id == "sb-7" {
+			return ErrSandboxNotFound
+		}
+		return nil
+	})
+
+	if p := peak.Load(); p > 3 {
+		t.Errorf("expected at most 3 concurrent deletions, got %d", p)
+	}
+	if len(results) != len(ids) {
+		t.Fatalf("expected %d results, got %d", len(ids), len(results))
+	}
+	for i, res := range results {
+		if res.SandboxID != ids[i] {
+			t.Errorf("result %d: expected %s, got %s", i, ids[i], res.SandboxID)
+		}
+		if wantErr := ids[i] == "sb-7"; wantErr != errors.Is(res.Err, ErrSandboxNotFound) {
+			t.Errorf("result %d: unexpected error %v", i, res.Err)
+		}
+	}
+}
+
+func TestUniqueIDs(t *testing.T) {
+	got := uniqueIDs([]string{"a", "b", "a", "c", "b"})
+	if fmt.Sprint(got) != "[a b c]" {
+		t.Errorf("expected [a b c], got %v", got)
+	}
+}
@@ -0,0 +1,267 @@
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+// callbackRetry spaces the attempts of a session callback: one right away
+// and three retries
+var callbackRetry = retryPolicy{
+	attempts:  4,
+	baseDelay: 2 * time.Second,
+	maxDelay:  30 * time.Second,
+}
+
+const (
+	// callbackTimeout bounds one delivery attempt
+	callbackTimeout = 10 * time.Second
+
+	// callbackLease is how long an attempt owns its delivery. A delivery whose
+	// attempt never reported back, e.g. because the engine restarted, is due
+	// again after it.
+	callbackLease = time.Minute
+)
+
+// defaultCallbackClient posts callbacks for a manager built without one; it
+// allows no internal networks
+var defaultCallbackClient = newCallbackClient(nil)
+
+// newCallbackClient returns the client that posts session callbacks. It
+// refuses to connect to internal addresses outside allowed. The check runs
+// on the dialed address, so it also covers redirects and DNS answers that
+// change after the URL was accepted.
+func newCallbackClient(allowed []netip.Prefix) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: callbackTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			addr, err := netip.ParseAddr(host)
+			if err != nil {
+				return err
+			}
+			if !callbackAddrAllowed(addr, allowed) {
+				return fmt.Errorf("%w: %s", ErrCallbackNotAllowed, addr)
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: callbackTimeout,
+		Transport: &http.Transport{
+			// No proxy: it would connect to the target on the callback's behalf
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: callbackTimeout,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+}
+
+// callbackNetworks parses the allowed networks of the config, which has
+// validated them
+func callbackNetworks(cidrs []string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, cidr := range cidrs {
+		if prefix, err := netip.ParsePrefix(cidr); err == nil {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
+}
+
+// callbackAddrAllowed reports whether a callback may connect to addr: any
+// public address, and loopback, private or link-local ones in allowed
+func callbackAddrAllowed(addr netip.Addr, allowed []netip.Prefix) bool {
+	addr = addr.Unmap()
+	internal := addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() || addr.IsMulticast() || addr.IsUnspecified()
+	if !internal {
+		return true
+	}
+	for _, prefix := range allowed {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCallbackURL rejects a callback URL whose host is an internal address
+// outside allowed. Host names are checked when they are dialed.
+func checkCallbackURL(rawURL string, allowed []netip.Prefix) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCallbackNotAllowed, err)
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	addr, err := netip.ParseAddr(host)
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		addr, err = netip.AddrFrom4([4]byte{127, 0, 0, 1}), nil
+	}
+	if err == nil && !callbackAddrAllowed(addr, allowed) {
+		return ErrCallbackNotAllowed
+	}
+	return nil
+}
+
+// callbackHTTP returns the client that posts session callbacks
+func (m *DockerManager) callbackHTTP() *http.Client {
+	if m.callbackClient != nil {
+		return m.callbackClient
+	}
+	return defaultCallbackClient
+}
+
+// SendSessionCallback reports a session transition to the session's callback
+// URL, if it has one. The delivery is stored in the outbox and tried once in
+// the background; RetryCallbacks retries it. Like events, callbacks are best
+// effort and never fail the transition.
+func (m *DockerManager) SendSessionCallback(ctx context.Context, session *models.Session, event models.CallbackEvent) {
+	if session.CallbackURL == "" {
+		return
+	}
+
+	payload := models.CallbackPayload{
+		Event:     event,
+		SessionID: session.ID,
+		Status:    event.Status(),
+		SandboxID: session.SandboxID,
+		Timestamp: time.Now(),
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("failed to marshal callback payload", "error", err, "session_id", session.ID, "event", event)
+		return
+	}
+
+	// Due again after the lease, in case the first attempt never reports back
+	next := payload.Timestamp.Add(callbackLease)
+	d := &models.CallbackDelivery{
+		SessionID:     session.ID,
+		Event:         event,
+		AttemptedAt:   payload.Timestamp,
+		NextAttemptAt: &next,
+		URL:           session.CallbackURL,
+		Secret:        session.CallbackSecret,
+		Payload:       body,
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	if err := m.repo.RecordCallbackDelivery(ctx, d); err != nil {
+		// Still tried once, just without retries
+		slog.Warn("failed to record callback delivery", "error", err, "session_id", d.SessionID, "event", d.Event)
+	}
+	go m.attemptCallback(ctx, d)
+}
+
+// RetryCallbacks makes the next attempt of up to limit pending deliveries
+// that are due, returning how many it tried
+func (m *DockerManager) RetryCallbacks(ctx context.Context, limit int) (int, error) {
+	deliveries, err := m.repo.ClaimCallbackDeliveries(ctx, time.Now(), callbackLease, limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to claim callback deliveries: %w", err)
+	}
+
+	var wg sync.WaitGroup
+	for _, d := range deliveries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.attemptCallback(ctx, d)
+		}()
+	}
+	wg.Wait()
+
+	return len(deliveries), nil
+}
+
+// PurgeCallbackDeliveries removes the finished deliveries of deleted sessions
+func (m *DockerManager) PurgeCallbackDeliveries(ctx context.Context) (int64, error) {
+	return m.repo.PurgeCallbackDeliveries(ctx)
+}
+
+// attemptCallback makes one delivery attempt and stores its outcome: done
+// when acknowledged with a 2xx, else scheduled with backoff until the
+// attempts of callbackRetry are used up
+func (m *DockerManager) attemptCallback(ctx context.Context, d *models.CallbackDelivery) {
+	d.Attempts++
+	d.AttemptedAt = time.Now()
+
+	var err error
+	d.StatusCode, err = m.postCallback(ctx, d)
+	switch {
+	case err == nil:
+		d.Delivered, d.Error, d.NextAttemptAt = true, "", nil
+		slog.Info("session callback delivered", "session_id", d.SessionID, "event", d.Event, "attempts", d.Attempts)
+	case d.Attempts >= callbackRetry.attempts || errors.Is(err, ErrCallbackNotAllowed):
+		d.Error, d.NextAttemptAt = err.Error(), nil
+		slog.Warn("session callback failed", "session_id", d.SessionID, "event", d.Event, "attempts", d.Attempts, "error", err)
+	default:
+		next := d.AttemptedAt.Add(callbackBackoff(d.Attempts))
+		d.Error, d.NextAttemptAt = err.Error(), &next
+	}
+
+	// Not in the outbox when recording it failed
+	if d.ID == 0 {
+		return
+	}
+	if err := m.repo.UpdateCallbackDelivery(ctx, d); err != nil {
+		slog.Warn("failed to update callback delivery", "error", err, "session_id", d.SessionID, "event", d.Event, "delivered", d.Delivered)
+	}
+}
+
+// callbackBackoff is the wait after the given failed attempt: the base delay
+// of callbackRetry, doubled per attempt up to its max delay
+func callbackBackoff(attempts int) time.Duration {
+	delay := callbackRetry.baseDelay
+	for i := 1; i < attempts && delay < callbackRetry.maxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, callbackRetry.maxDelay)
+}
+
+// postCallback POSTs the payload of d, signed with its secret, returning the
+// response status
+func (m *DockerManager) postCallback(ctx context.Context, d *models.CallbackDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sandbox-Event", string(d.Event))
+	req.Header.Set(models.CallbackSignatureHeader, models.SignCallback(d.Secret, d.Payload))
+
+	resp, err := m.callbackHTTP().Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("callback answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
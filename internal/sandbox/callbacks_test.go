@@ -0,0 +1,306 @@
+package sandbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/storage"
+)
+
+// testCallbackManager posts callbacks to the loopback test servers
+func testCallbackManager(repo storage.Repository) *DockerManager {
+	loopback := []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8"), netip.MustParsePrefix("::1/128")}
+	return &DockerManager{repo: repo, callbackNets: loopback, callbackClient: newCallbackClient(loopback)}
+}
+
+// waitDelivery polls the single delivery of a session until cond holds
+func waitDelivery(t *testing.T, repo storage.Repository, sessionID string, cond func(d *models.CallbackDelivery) bool) *models.CallbackDelivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		deliveries, err := repo.ListCallbackDeliveries(context.Background(), sessionID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deliveries) == 1 && cond(deliveries[0]) {
+			return deliveries[0]
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("delivery didn't reach the expected state, got %v", deliveries)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// makeDue moves the next attempt of d into the past
+func makeDue(t *testing.T, repo storage.Repository, d *models.CallbackDelivery) {
+	t.Helper()
+	past := time.Now().Add(-time.Second)
+	d.NextAttemptAt = &past
+	if err := repo.UpdateCallbackDelivery(context.Background(), d); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCallbackRetriesFromOutbox(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewMemoryRepository()
+	m := testCallbackManager(repo)
+
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(models.CallbackSignatureHeader) != models.SignCallback("secret", body) {
+			t.Errorf("signature doesn't match the body")
+		}
+		var payload models.CallbackPayload
+		if err := json.Unmarshal(body, &payload); err != nil || payload.Event != models.CallbackExpired || payload.Status != models.SessionExpired {
+			t.Errorf("unexpected payload %s", body)
+		}
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+
+	session := &models.Session{ID: "s-1", Token: "tok", Status: models.SessionActive, CallbackURL: srv.URL, CallbackSecret: "secret"}
+	if err := repo.CreateSession(ctx, session); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first attempt fails and schedules a retry with backoff
+	m.SendSessionCallback(ctx, session, models.CallbackExpired)
+	d := waitDelivery(t, repo, "s-1", func(d *models.CallbackDelivery) bool { return d.Attempts == 1 })
+	if d.Delivered || d.StatusCode != http.StatusBadGateway || d.NextAttemptAt == nil || d.NextAttemptAt.Sub(d.AttemptedAt) != callbackRetry.baseDelay {
+		t.Fatalf("expected a retry scheduled after the first attempt, got %+v", d)
+	}
+
+	// The session is deleted while the delivery is pending
+	if err := repo.DeleteSession(ctx, "s-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Not due yet
+	if n, err := m.RetryCallbacks(ctx, 10); err != nil || n != 0 {
+		t.Fatalf("expected nothing due, got %d, %v", n, err)
+	}
+
+	for attempt := 2; attempt <= 3; attempt++ {
+		makeDue(t, repo, d)
+		if n, err := m.RetryCallbacks(ctx, 10); err != nil || n != 1 {
+			t.Fatalf("expected the delivery retried, got %d, %v", n, err)
+		}
+		d = waitDelivery(t, repo, "s-1", func(d *models.CallbackDelivery) bool { return d.Attempts == attempt })
+	}
+	if !d.Delivered || d.StatusCode != http.StatusOK || d.Error != "" || d.NextAttemptAt != nil {
+		t.Fatalf("expected delivery on the third attempt, got %+v", d)
+	}
+
+	if n, err := m.PurgeCallbackDeliveries(ctx); err != nil || n != 1 {
+		t.Errorf("expected the finished delivery of the deleted session purged, got %d, %v", n, err)
+	}
+}
+
+func TestCallbackGivesUp(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewMemoryRepository()
+	m := testCallbackManager(repo)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	due := time.Now().Add(-time.Second)
+	d := &models.CallbackDelivery{SessionID: "s-1", Event: models.CallbackExpired, Attempts: callbackRetry.attempts - 1, NextAttemptAt: &due, URL: failing.URL, Payload: []byte(`{}`)}
+	if err := repo.RecordCallbackDelivery(ctx, d); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := m.RetryCallbacks(ctx, 10); err != nil || n != 1 {
+		t.Fatalf("expected the delivery retried, got %d, %v", n, err)
+	}
+	got := waitDelivery(t, repo, "s-1", func(d *models.CallbackDelivery) bool { return d.Attempts == callbackRetry.attempts })
+	if got.Delivered || got.NextAttemptAt != nil || got.StatusCode != http.StatusInternalServerError || got.Error == "" {
+		t.Errorf("expected the delivery given up after its last attempt, got %+v", got)
+	}
+}
+
+func TestCallbackBlocksInternalAddresses(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewMemoryRepository()
+	m := &DockerManager{repo: repo}
+
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	defer srv.Close()
+
+	// A host name that resolves to loopback is caught when it is dialed
+	hostURL := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+	due := time.Now().Add(-time.Second)
+	d := &models.CallbackDelivery{SessionID: "s-1", Event: models.CallbackExpired, NextAttemptAt: &due, URL: hostURL, Payload: []byte(`{}`)}
+	if err := repo.RecordCallbackDelivery(ctx, d); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.RetryCallbacks(ctx, 10); err != nil {
+		t.Fatal(err)
+	}
+	got := waitDelivery(t, repo, "s-1", func(d *models.CallbackDelivery) bool { return d.Attempts == 1 })
+	if got.Delivered || got.NextAttemptAt != nil || !strings.Contains(got.Error, "loopback") {
+		t.Errorf("expected the loopback callback refused without retries, got %+v", got)
+	}
+	if calls.Load() != 0 {
+		t.Error("expected the loopback server never called")
+	}
+}
+
+func TestCheckCallbackURL(t *testing.T) {
+	allowed := []netip.Prefix{netip.MustParsePrefix("10.1.0.0/16")}
+	tests := []struct {
+		url     string
+		blocked bool
+	}{
+		{"https://ats.example.com/hook", false},
+		{"http://8.8.8.8/hook", false},
+		{"http://127.0.0.1:8080/hook", true},
+		{"http://localhost/hook", true},
+		{"http://api.localhost./hook", true},
+		{"http://[::1]/hook", true},
+		{"http://[::ffff:127.0.0.1]/hook", true},
+		{"http://169.254.169.254/latest/meta-data", true},
+		{"http://192.168.1.10/hook", true},
+		{"http://10.2.0.1/hook", true},
+		{"http://10.1.0.5/hook", false},
+		{"http://0.0.0.0/hook", true},
+	}
+	for _, tt := range tests {
+		err := checkCallbackURL(tt.url, allowed)
+		if blocked := errors.Is(err, ErrCallbackNotAllowed); blocked != tt.blocked {
+			t.Errorf("%s: blocked = %v, want %v (%v)", tt.url, blocked, tt.blocked, err)
+		}
+	}
+}
+
+func TestSendSessionCallback(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewMemoryRepository()
+	m := testCallbackManager(repo)
+
+	received := make(chan models.CallbackPayload, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload models.CallbackPayload
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		received <- payload
+	}))
+	defer srv.Close()
+
+	session := &models.Session{ID: "s-1", Token: "tok", Status: models.SessionActive, SandboxID: "sb-1", CallbackURL: srv.URL, CallbackSecret: "secret"}
+	if err := repo.CreateSession(ctx, session); err != nil {
+		t.Fatal(err)
+	}
+
+	// No URL, no callback
+	m.SendSessionCallback(ctx, &models.Session{ID: "s-2"}, models.CallbackExpired)
+
+	m.SendSessionCallback(ctx, session, models.CallbackExpired)
+	select {
+	case payload := <-received:
+		if payload.Event != models.CallbackExpired || payload.Status != models.SessionExpired || payload.SandboxID != "sb-1" {
+			t.Errorf("unexpected payload %+v", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the callback delivered")
+	}
+
+	// The outcome shows on the session
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, err := m.GetSessionByID(ctx, "s-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got.CallbackDeliveries) == 1 && got.CallbackDeliveries[0].Delivered {
+			if d := got.CallbackDeliveries[0]; d.Event != models.CallbackExpired || d.Attempts != 1 || d.NextAttemptAt != nil {
+				t.Errorf("expected one successful delivery, got %+v", d)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the delivery recorded on the session")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestExpireSessionNotifiesOnlyWhenExpired(t *testing.T) {
+	ctx := context.Background()
+	mem := storage.NewMemoryRepository()
+	repo := &staleSessionRepo{Repository: mem}
+	m := testCallbackManager(repo)
+	m.ops = newOperationTracker()
+
+	received := make(chan models.CallbackPayload, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload models.CallbackPayload
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		received <- payload
+	}))
+	defer srv.Close()
+
+	past := time.Now().Add(-time.Minute)
+	for _, id := range []string{"s-1", "s-2"} {
+		s := &models.Session{ID: id, Token: "tok-" + id, Status: models.SessionActive, SandboxID: "sb-" + id, ExpiresAt: &past, CallbackURL: srv.URL}
+		if err := mem.CreateSession(ctx, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	notices, done := m.SubscribeSandbox("sb-s-1")
+	defer done()
+	raced, doneRaced := m.SubscribeSandbox("sb-s-2")
+	defer doneRaced()
+
+	if expired, err := m.ExpireSession(ctx, "s-1"); err != nil || !expired {
+		t.Fatalf("expected the session expired, got %v %v", expired, err)
+	}
+	select {
+	case n := <-notices:
+		if n != NoticeSessionExpired {
+			t.Errorf("expected a session_expired notice, got %s", n)
+		}
+	default:
+		t.Error("expected the terminals told the session expired")
+	}
+	select {
+	case payload := <-received:
+		if payload.Event != models.CallbackExpired || payload.SessionID != "s-1" {
+			t.Errorf("unexpected payload %+v", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the expiry callback delivered")
+	}
+
+	// A session revoked between the read and the transition gets neither
+	repo.race = func() { revokeSession(t, mem, "s-2") }
+	if expired, err := m.ExpireSession(ctx, "s-2"); err != nil || expired {
+		t.Fatalf("expected the revoked session left alone, got %v %v", expired, err)
+	}
+	select {
+	case n := <-raced:
+		t.Errorf("expected no notice for the revoked session, got %s", n)
+	case payload := <-received:
+		t.Errorf("expected no callback for the revoked session, got %+v", payload)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,135 @@
+package sandbox
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/tracing"
+)
+
+// containerRuntime is the container side of provisioning and its rollback
+type containerRuntime interface {
+	// ensureImage makes the image available locally
+	ensureImage(ctx context.Context, image string) error
+	// create creates the sandbox container and returns its ID
+	create(ctx context.Context, sb *models.Sandbox, tmpl *models.Template, env []string) (string, error)
+	// createService creates the container of a dedicated service and returns its ID
+	createService(ctx context.Context, sb *models.Sandbox, svc *dedicatedService) (string, error)
+	// lookup returns the ID of an existing container by name or ID
+	lookup(ctx context.Context, nameOrID string) (string, error)
+	start(ctx context.Context, id string) error
+	// stop stops a container, killing it after stopTimeout
+	stop(ctx context.Context, id string) error
+	// copyFile writes a file into a container, replacing any existing one
+	copyFile(ctx context.Context, id string, f containerFile) error
+	// extract unpacks a tar stream at / in a container
+	extract(ctx context.Context, id string, content io.Reader) error
+	// archive returns a tar stream of path inside a container
+	archive(ctx context.Context, id, path string) (io.ReadCloser, error)
+	// stat fails with a not found error when path doesn't exist in a container
+	stat(ctx context.Context, id, path string) error
+	// remove force-removes a container by name or ID
+	remove(ctx context.Context, nameOrID string) error
+	// exec runs a command in sb's container to completion
+	exec(ctx context.Context, sb *models.Sandbox, req models.ExecRequest) (*models.ExecResult, error)
+	// execInput runs a command in a container with input on its stdin
+	execInput(ctx context.Context, id string, cmd, env []string, input io.Reader) (*models.ExecResult, error)
+}
+
+// dockerRuntime implements containerRuntime with the manager's Docker client
+type dockerRuntime struct {
+	m *DockerManager
+}
+
+func (r dockerRuntime) ensureImage(ctx context.Context, image string) error {
+	return r.m.images.ensure(ctx, image)
+}
+
+func (r dockerRuntime) create(ctx context.Context, sb *models.Sandbox, tmpl *models.Template, env []string) (string, error) {
+	return r.m.createContainer(ctx, sb, tmpl, env)
+}
+
+func (r dockerRuntime) createService(ctx context.Context, sb *models.Sandbox, svc *dedicatedService) (string, error) {
+	return r.m.createServiceContainer(ctx, sb, svc)
+}
+
+func (r dockerRuntime) lookup(ctx context.Context, nameOrID string) (string, error) {
+	info, err := r.m.docker.ContainerInspect(ctx, nameOrID)
+	if err != nil {
+		return "", err
+	}
+	return info.ID, nil
+}
+
+func (r dockerRuntime) start(ctx context.Context, id string) error {
+	ctx, span := tracing.Start(ctx, "docker.start_container", attribute.String("container.id", id))
+	err := r.m.docker.ContainerStart(ctx, id, container.StartOptions{})
+	tracing.End(span, err)
+	return err
+}
+
+func (r dockerRuntime) stop(ctx context.Context, id string) error {
+	timeout := int(stopTimeout.Seconds())
+	return r.m.docker.ContainerStop(ctx, id, container.StopOptions{Timeout: &timeout})
+}
+
+func (r dockerRuntime) remove(ctx context.Context, nameOrID string) error {
+	return r.m.docker.ContainerRemove(ctx, nameOrID, container.RemoveOptions{Force: true})
+}
+
+func (r dockerRuntime) exec(ctx context.Context, sb *models.Sandbox, req models.ExecRequest) (*models.ExecResult, error) {
+	return r.m.Exec(ctx, sb, req)
+}
+
+func (r dockerRuntime) execInput(ctx context.Context, id string, cmd, env []string, input io.Reader) (*models.ExecResult, error) {
+	return r.m.execInput(ctx, id, cmd, env, input)
+}
+
+func (r dockerRuntime) copyFile(ctx context.Context, id string, f containerFile) error {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	// Extracted relative to /; Docker creates missing parent directories
+	hdr := &tar.Header{
+		Name:    strings.TrimPrefix(f.path, "/"),
+		Mode:    f.mode,
+		Uid:     f.uid,
+		Gid:     f.gid,
+		Size:    int64(len(f.content)),
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to archive %s: %w", f.path, err)
+	}
+	if _, err := tw.Write(f.content); err != nil {
+		return fmt.Errorf("failed to archive %s: %w", f.path, err)
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to archive %s: %w", f.path, err)
+	}
+
+	return r.m.docker.CopyToContainer(ctx, id, "/", &buf, types.CopyToContainerOptions{})
+}
+
+func (r dockerRuntime) extract(ctx context.Context, id string, content io.Reader) error {
+	return r.m.docker.CopyToContainer(ctx, id, "/", content, types.CopyToContainerOptions{})
+}
+
+func (r dockerRuntime) stat(ctx context.Context, id, path string) error {
+	_, err := r.m.docker.ContainerStatPath(ctx, id, path)
+	return err
+}
+
+func (r dockerRuntime) archive(ctx context.Context, id, path string) (io.ReadCloser, error) {
+	rc, _, err := r.m.docker.CopyFromContainer(ctx, id, path)
+	return rc, err
+}
@@ -0,0 +1,116 @@
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+// envCredentialsFile points processes at the credentials file; exec sessions
+// inherit the container env, so they see the path but not the secrets
+const envCredentialsFile = "SANDBOX_CREDENTIALS_FILE"
+
+// credentialsFileMode keeps the file readable by its owner only
+const credentialsFileMode = 0o400
+
+// containerFile is a single file to copy into a container
+type containerFile struct {
+	path     string
+	content  []byte
+	mode     int64
+	uid, gid int
+}
+
+// writeCredentialsFile renders the sandbox's service credentials into the
+// template's credentials file and copies it into the container. It is a no-op
+// unless the template delivers credentials in a file; the copy replaces any
+// previous file, so it also refreshes the file after credentials change.
+func (m *DockerManager) writeCredentialsFile(ctx context.Context, sb *models.Sandbox, tmpl *models.Template) error {
+	if !tmpl.CredentialsDelivery.InFile() || tmpl.CredentialsFile == nil {
+		return nil
+	}
+	spec := tmpl.CredentialsFile
+
+	content, err := renderCredentials(sb.Services, spec.Format)
+	if err != nil {
+		return err
+	}
+	uid, gid, err := spec.Owner()
+	if err != nil {
+		return err
+	}
+
+	return m.containers.copyFile(ctx, sb.ContainerID, containerFile{
+		path:    spec.Path,
+		content: content,
+		mode:    credentialsFileMode,
+		uid:     uid,
+		gid:     gid,
+	})
+}
+
+// renderCredentials renders service credentials keyed by service name
+func renderCredentials(svcs map[string]*models.ServiceInstance, format string) ([]byte, error) {
+	names := make([]string, 0, len(svcs))
+	for name, svc := range svcs {
+		if svc.Credentials != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	switch format {
+	case models.CredentialsFormatJSON:
+		creds := make(map[string]*models.ServiceCredentials, len(names))
+		for _, name := range names {
+			creds[name] = svcs[name].Credentials
+		}
+		data, err := json.MarshalIndent(creds, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to render credentials: %w", err)
+		}
+		return append(data, '\n'), nil
+
+	case models.CredentialsFormatINI:
+		var buf bytes.Buffer
+		for i, name := range names {
+			if i > 0 {
+				buf.WriteByte('\n')
+			}
+			c := svcs[name].Credentials
+			fmt.Fprintf(&buf, "[%s]\n", name)
+			for _, kv := range [][2]string{
+				{"host", c.Host},
+				{"port", strconv.Itoa(c.Port)},
+				{"username", c.Username},
+				{"password", c.Password},
+				{"database", c.Database},
+				{"namespace", c.Namespace},
+				{"prefix", c.Prefix},
+				{"uri", c.URI},
+				{"token", c.Token},
+			} {
+				if kv[1] != "" {
+					fmt.Fprintf(&buf, "%s = %s\n", kv[0], iniValue(kv[1]))
+				}
+			}
+		}
+		return buf.Bytes(), nil
+	}
+
+	return nil, fmt.Errorf("unknown credentials format %q", format)
+}
+
+// iniValue quotes values that ini parsers would otherwise trim or split
+func iniValue(v string) string {
+	if strings.ContainsAny(v, ";#\"\n") || strings.TrimSpace(v) != v {
+		return strconv.Quote(v)
+	}
+	return v
+}
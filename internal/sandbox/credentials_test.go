@@ -0,0 +1,107 @@
+package sandbox
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/terra-clan/sandbox-engine/internal/config"
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/services"
+	"github.com/terra-clan/sandbox-engine/internal/templates"
+)
+
+func TestProvisionCredentialsDelivery(t *testing.T) {
+	spec := &models.CredentialsFileSpec{Path: "/run/sandbox/credentials.json", Format: models.CredentialsFormatJSON, User: "1000:1001"}
+
+	tests := []struct {
+		name     string
+		delivery models.CredentialsDelivery
+		wantEnv  bool
+		wantFile bool
+	}{
+		{name: "default", wantEnv: true},
+		{name: "env", delivery: models.CredentialsEnv, wantEnv: true},
+		{name: "file", delivery: models.CredentialsFile, wantFile: true},
+		{name: "both", delivery: models.CredentialsBoth, wantEnv: true, wantFile: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := services.NewRegistry()
+			registry.Register("postgres", &recordingProvider{live: map[string]bool{}})
+			runtime := &fakeRuntime{containers: map[string]string{}}
+
+			m := &DockerManager{
+				traefikConfig:   config.TraefikConfig{Enabled: true},
+				serviceRegistry: registry,
+				templateLoader:  templates.NewLoader(),
+				repo:            &provisionRepo{services: map[string]int{}},
+				containers:      runtime,
+			}
+
+			sb := &models.Sandbox{ID: "sb-1", Services: make(map[string]*models.ServiceInstance)}
+			tmpl := &models.Template{Name: "tmpl", BaseImage: "alpine", CredentialsDelivery: tt.delivery}
+			if tt.delivery.InFile() {
+				tmpl.CredentialsFile = spec
+			}
+
+			if err := m.provision(context.Background(), sb, tmpl, nil, []string{"postgres"}); err != nil {
+				t.Fatalf("provision failed: %v", err)
+			}
+
+			env := strings.Join(runtime.env, "\n")
+			if got := strings.Contains(env, "POSTGRES_HOST="); got != tt.wantEnv {
+				t.Errorf("expected credentials in env %v, got env %q", tt.wantEnv, runtime.env)
+			}
+			if got := strings.Contains(env, envCredentialsFile+"="+spec.Path); got != tt.wantFile {
+				t.Errorf("expected %s in env %v, got env %q", envCredentialsFile, tt.wantFile, runtime.env)
+			}
+
+			f, ok := runtime.files[spec.Path]
+			if ok != tt.wantFile {
+				t.Fatalf("expected credentials file %v, got files %v", tt.wantFile, runtime.files)
+			}
+			if !ok {
+				return
+			}
+			if f.mode != 0o400 || f.uid != 1000 || f.gid != 1001 {
+				t.Errorf("unexpected file mode/owner %o %d:%d", f.mode, f.uid, f.gid)
+			}
+			if !strings.Contains(string(f.content), `"host": "postgres"`) {
+				t.Errorf("expected postgres credentials in file, got %s", f.content)
+			}
+		})
+	}
+}
+
+func TestRenderCredentialsINI(t *testing.T) {
+	svcs := map[string]*models.ServiceInstance{
+		"redis":    {Credentials: &models.ServiceCredentials{Host: "cache", Port: 6379, Password: "p;ss"}},
+		"postgres": {Credentials: &models.ServiceCredentials{Host: "db", Port: 5432, Username: "sb", Database: "app"}},
+		"pending":  {},
+	}
+
+	got, err := renderCredentials(svcs, models.CredentialsFormatINI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[postgres]
+host = db
+port = 5432
+username = sb
+database = app
+
+[redis]
+host = cache
+port = 6379
+password = "p;ss"
+`
+	if string(got) != want {
+		t.Errorf("unexpected ini:\n%s", got)
+	}
+
+	if _, err := renderCredentials(svcs, "toml"); err == nil {
+		t.Error("expected an unknown format to fail")
+	}
+}
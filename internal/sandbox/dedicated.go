@@ -0,0 +1,300 @@
+package sandbox
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/errdefs"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/tracing"
+)
+
+// serviceLabel names the service a dedicated service container runs
+const serviceLabel = "sandbox.service"
+
+// dedicatedReadyInterval is how often a starting service container is probed
+const dedicatedReadyInterval = 500 * time.Millisecond
+
+// dedicatedService is a service container to launch for a sandbox, with the
+// credentials it is started with and how to tell it is ready
+type dedicatedService struct {
+	name  string
+	image string
+	env   []string
+	cmd   []string
+	creds *models.ServiceCredentials
+
+	// resources are the CPU and memory limits of the sandbox's template
+	resources container.Resources
+
+	// probe is run in the container until it exits 0 with probeOutput in
+	// its stdout
+	probe       []string
+	probeOutput string
+}
+
+// serviceContainerName returns the Docker container name of a sandbox's
+// dedicated service, which is also its host name on the sandbox network
+func serviceContainerName(sandboxID, service string) string {
+	return fmt.Sprintf("sandbox-%s-%s", sandboxID, service)
+}
+
+// newDedicatedService builds the container of service name for a sandbox,
+// with generated credentials and the template's resource limits. The
+// services and their images are those of models.DedicatedServiceImages.
+func newDedicatedService(sandboxID, name string, spec models.ServiceSpec, limits models.Resources) (*dedicatedService, error) {
+	image, ok := models.DedicatedServiceImages[name]
+	if !ok {
+		return nil, fmt.Errorf("%s can't run dedicated", name)
+	}
+	version := spec.Version
+	if version == "" {
+		version = image.DefaultVersion
+	}
+	password, err := generateServicePassword()
+	if err != nil {
+		return nil, err
+	}
+	host := serviceContainerName(sandboxID, name)
+
+	svc := &dedicatedService{name: name, image: image.Image + ":" + version, resources: containerResources(limits)}
+	switch name {
+	case "postgres":
+		// POSTGRES_USER is a superuser, free to create extensions and roles
+		const user, database = "sandbox", "sandbox"
+		svc.env = []string{"POSTGRES_USER=" + user, "POSTGRES_PASSWORD=" + password, "POSTGRES_DB=" + database}
+		svc.creds = &models.ServiceCredentials{
+			Host:     host,
+			Port:     5432,
+			Username: user,
+			Password: password,
+			Database: database,
+			URI:      fmt.Sprintf("postgres://%s:%s@%s:5432/%s?sslmode=disable", user, password, host, database),
+		}
+	case "redis":
+		svc.cmd = []string{"redis-server", "--requirepass", password}
+		svc.creds = &models.ServiceCredentials{
+			Host:     host,
+			Port:     6379,
+			Password: password,
+			URI:      fmt.Sprintf("redis://:%s@%s:6379", password, host),
+		}
+	default:
+		return nil, fmt.Errorf("%s can't run dedicated", name)
+	}
+	svc.setProbe()
+	return svc, nil
+}
+
+// setProbe sets the readiness probe of the service from its credentials
+func (svc *dedicatedService) setProbe() {
+	switch svc.name {
+	case "postgres":
+		// TCP only answers once the image's init scripts are done
+		svc.probe = []string{"pg_isready", "-h", "127.0.0.1", "-U", svc.creds.Username, "-d", svc.creds.Database}
+	case "redis":
+		svc.probe = []string{"redis-cli", "--no-auth-warning", "-a", svc.creds.Password, "ping"}
+		svc.probeOutput = "PONG"
+	}
+}
+
+// generateServicePassword creates a random password for a dedicated service
+func generateServicePassword() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate password: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// provisionDedicated launches the dedicated container of service name on the
+// sandbox network and waits for it to be ready. Its record is saved with the
+// container ID as soon as it exists; on failure the container is removed.
+func (m *DockerManager) provisionDedicated(ctx context.Context, sb *models.Sandbox, name string, spec models.ServiceSpec, limits models.Resources) (*models.ServiceInstance, error) {
+	svc, err := newDedicatedService(sb.ID, name, spec, limits)
+	if err != nil {
+		return nil, err
+	}
+
+	err = provisionRetry.do(ctx, "pull "+svc.image, func(ctx context.Context) error {
+		return m.containers.ensureImage(ctx, svc.image)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to pull image: %w", err)
+	}
+
+	var containerID string
+	retried := false
+	err = provisionRetry.do(ctx, "create "+name+" container", func(ctx context.Context) error {
+		var err error
+		containerID, err = m.containers.createService(ctx, sb, svc)
+		if err != nil && retried && errdefs.IsConflict(err) {
+			// The previous attempt created the container but its response was lost
+			if existing, lookupErr := m.containers.lookup(ctx, serviceContainerName(sb.ID, name)); lookupErr == nil {
+				containerID, err = existing, nil
+			}
+		}
+		retried = true
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create container: %w", err)
+	}
+
+	instance := &models.ServiceInstance{
+		Name:        name,
+		Type:        name,
+		Status:      models.ServiceStatusProvisioning,
+		CreatedAt:   time.Now(),
+		Mode:        models.ServiceDedicated,
+		ContainerID: containerID,
+	}
+	if err := m.repo.CreateService(ctx, sb.ID, instance); err != nil {
+		slog.Error("failed to save service to database", "error", err, "sandbox", sb.ID, "service", name)
+	}
+
+	if err := m.startDedicated(ctx, sb, svc, containerID); err != nil {
+		if rmErr := m.containers.remove(context.WithoutCancel(ctx), containerID); rmErr != nil && !errdefs.IsNotFound(rmErr) {
+			slog.Warn("failed to remove service container after failed provisioning", "error", rmErr, "container", containerID, "sandbox", sb.ID)
+		}
+		return nil, err
+	}
+
+	instance.Status = models.ServiceStatusReady
+	instance.Credentials = svc.creds
+	return instance, nil
+}
+
+// restartDedicated starts the stopped containers of a sandbox's dedicated
+// services and waits until they are ready again
+func (m *DockerManager) restartDedicated(ctx context.Context, sb *models.Sandbox) error {
+	for _, inst := range sb.Services {
+		if !inst.IsDedicated() || inst.ContainerID == "" {
+			continue
+		}
+		svc := &dedicatedService{name: inst.Name, creds: inst.Credentials}
+		if svc.creds != nil {
+			svc.setProbe()
+		}
+		if err := m.startDedicated(ctx, sb, svc, inst.ContainerID); err != nil {
+			return fmt.Errorf("failed to start dedicated %s: %w", inst.Name, err)
+		}
+	}
+	return nil
+}
+
+// dedicatedCount returns how many dedicated services a sandbox has
+func dedicatedCount(sb *models.Sandbox) int {
+	n := 0
+	for _, inst := range sb.Services {
+		if inst.IsDedicated() {
+			n++
+		}
+	}
+	return n
+}
+
+// stopDedicated stops the containers of a sandbox's dedicated services. A
+// container that fails to stop is logged; it is removed with the sandbox.
+func (m *DockerManager) stopDedicated(ctx context.Context, sb *models.Sandbox) {
+	for _, inst := range sb.Services {
+		if !inst.IsDedicated() || inst.ContainerID == "" {
+			continue
+		}
+		if err := m.containers.stop(ctx, inst.ContainerID); err != nil && !errdefs.IsNotFound(err) {
+			slog.Warn("failed to stop service container", "error", err, "container", inst.ContainerID, "sandbox", sb.ID, "service", inst.Name)
+		}
+	}
+}
+
+// startDedicated starts a created service container and waits until its
+// probe passes; without a probe it doesn't wait
+func (m *DockerManager) startDedicated(ctx context.Context, sb *models.Sandbox, svc *dedicatedService, containerID string) error {
+	err := provisionRetry.do(ctx, "start "+svc.name+" container", func(ctx context.Context) error {
+		return m.containers.start(ctx, containerID)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to start container: %w", err)
+	}
+	if svc.probe == nil {
+		return nil
+	}
+
+	// Probes run in the service container, not the sandbox's
+	target := &models.Sandbox{ID: sb.ID, ContainerID: containerID}
+	var last string
+	for {
+		res, err := m.containers.exec(ctx, target, models.ExecRequest{Command: svc.probe, TimeoutSeconds: 5})
+		switch {
+		case err != nil:
+			last = err.Error()
+		case res.ExitCode == 0 && strings.Contains(res.Stdout, svc.probeOutput):
+			return nil
+		default:
+			last = strings.TrimSpace(res.Stdout + res.Stderr)
+		}
+
+		select {
+		case <-time.After(dedicatedReadyInterval):
+		case <-ctx.Done():
+			return fmt.Errorf("%s container not ready: %w (last probe: %s)", svc.name, ctx.Err(), last)
+		}
+	}
+}
+
+// removeDedicated removes the container of a dedicated service instance,
+// found by name when its ID was never recorded. A missing one is removed.
+func (m *DockerManager) removeDedicated(ctx context.Context, sandboxID string, svc *models.ServiceInstance) error {
+	target := svc.ContainerID
+	if target == "" {
+		target = serviceContainerName(sandboxID, svc.Name)
+	}
+	if err := m.containers.remove(ctx, target); err != nil && !errdefs.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// createServiceContainer creates the container of a dedicated service on the
+// sandbox network
+func (m *DockerManager) createServiceContainer(ctx context.Context, sb *models.Sandbox, svc *dedicatedService) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "docker.create_service_container",
+		attribute.String("sandbox.id", sb.ID),
+		attribute.String("service.name", svc.name),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	containerConfig := &container.Config{
+		Image: svc.image,
+		Cmd:   svc.cmd,
+		Env:   svc.env,
+		Labels: map[string]string{
+			sandboxIDLabel: sb.ID,
+			serviceLabel:   svc.name,
+			managedLabel:   "true",
+		},
+	}
+	hostConfig := &container.HostConfig{
+		NetworkMode: container.NetworkMode(m.config.Network),
+		Resources:   svc.resources,
+		// Back after a daemon restart, but not after Stop
+		RestartPolicy: container.RestartPolicy{
+			Name: container.RestartPolicyUnlessStopped,
+		},
+	}
+
+	resp, err := m.docker.ContainerCreate(ctx, containerConfig, hostConfig, &network.NetworkingConfig{}, nil, serviceContainerName(sb.ID, svc.name))
+	if err != nil {
+		return "", fmt.Errorf("failed to create container: %w", err)
+	}
+	return resp.ID, nil
+}
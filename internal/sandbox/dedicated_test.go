@@ -0,0 +1,169 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/config"
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/services"
+	"github.com/terra-clan/sandbox-engine/internal/storage"
+	"github.com/terra-clan/sandbox-engine/internal/templates"
+)
+
+func TestProvisionDedicatedService(t *testing.T) {
+	probes := 0
+	runtime := &fakeRuntime{
+		containers: map[string]string{},
+		execFunc: func(req models.ExecRequest) *models.ExecResult {
+			if req.Command[0] != "pg_isready" {
+				return &models.ExecResult{}
+			}
+			// Not accepting connections until the second probe
+			if probes++; probes < 2 {
+				return &models.ExecResult{ExitCode: 2, Stdout: "no response"}
+			}
+			return &models.ExecResult{Stdout: "accepting connections"}
+		},
+	}
+	repo := &provisionRepo{services: map[string]int{}}
+	m := &DockerManager{
+		traefikConfig:   config.TraefikConfig{Enabled: true},
+		serviceRegistry: services.NewRegistry(),
+		templateLoader:  templates.NewLoader(),
+		repo:            repo,
+		containers:      runtime,
+	}
+
+	sb := &models.Sandbox{ID: "sb-1", Status: models.StatusPending, Services: make(map[string]*models.ServiceInstance)}
+	tmpl := &models.Template{
+		Name:         "tmpl",
+		BaseImage:    "alpine",
+		Services:     []string{"postgres"},
+		ServiceSpecs: map[string]models.ServiceSpec{"postgres": {Mode: models.ServiceDedicated, Version: "15"}},
+		Resources:    models.Resources{CPULimit: "0.5", MemoryLimit: "1Gi"},
+	}
+	if err := m.provision(context.Background(), sb, tmpl, nil, tmpl.Services); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(runtime.services) != 1 || runtime.services[0].image != "postgres:15" {
+		t.Fatalf("expected a postgres:15 container, got %+v", runtime.services)
+	}
+	if res := runtime.services[0].resources; res.NanoCPUs != 5e8 || res.Memory != 1<<30 {
+		t.Errorf("expected the template's limits on the service container, got %d CPUs and %d bytes", res.NanoCPUs, res.Memory)
+	}
+	svc := sb.Services["postgres"]
+	if svc == nil || !svc.IsDedicated() || svc.ContainerID == "" || svc.Status != models.ServiceStatusReady {
+		t.Fatalf("expected a ready dedicated instance, got %+v", svc)
+	}
+	creds := svc.Credentials
+	if creds.Host != "sandbox-sb-1-postgres" || creds.Port != 5432 || creds.Password == "" ||
+		!slices.Contains(runtime.services[0].env, "POSTGRES_PASSWORD="+creds.Password) {
+		t.Errorf("expected the container's generated credentials, got %+v", creds)
+	}
+	if probes != 2 {
+		t.Errorf("expected provisioning to wait for the probe, got %d probes", probes)
+	}
+	if !slices.Contains(runtime.env, "POSTGRES_HOST=sandbox-sb-1-postgres") {
+		t.Errorf("expected the sandbox env to point at the service container, got %v", runtime.env)
+	}
+
+	// Tearing down removes the service container with the sandbox's
+	m.teardownProvisioning(context.Background(), sb)
+	if len(runtime.containers) != 0 {
+		t.Errorf("expected no containers left, got %v", runtime.containers)
+	}
+}
+
+func TestProvisionDedicatedServiceFailures(t *testing.T) {
+	tests := []struct {
+		name      string
+		timeout   time.Duration
+		startErr  error
+		wantError string
+	}{
+		{name: "never ready", timeout: 1200 * time.Millisecond, wantError: "not ready"},
+		{name: "start", timeout: time.Minute, startErr: errors.New("boom"), wantError: "boom"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runtime := &fakeRuntime{
+				containers: map[string]string{},
+				startErr:   tt.startErr,
+				execFunc: func(req models.ExecRequest) *models.ExecResult {
+					return &models.ExecResult{Stdout: "LOADING"}
+				},
+			}
+			m := &DockerManager{
+				serviceRegistry: services.NewRegistry(),
+				templateLoader:  templates.NewLoader(),
+				repo:            &provisionRepo{services: map[string]int{}},
+				containers:      runtime,
+			}
+			sb := &models.Sandbox{ID: "sb-1", Services: make(map[string]*models.ServiceInstance)}
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+			_, err := m.provisionDedicated(ctx, sb, "redis", models.ServiceSpec{Mode: models.ServiceDedicated}, models.Resources{})
+			if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+				t.Fatalf("expected an error with %q, got %v", tt.wantError, err)
+			}
+			if len(runtime.containers) != 0 {
+				t.Errorf("expected the service container removed, got %v", runtime.containers)
+			}
+		})
+	}
+}
+
+func TestStopStartDedicatedServices(t *testing.T) {
+	ctx := context.Background()
+	var probes []string
+	runtime := &fakeRuntime{
+		containers: map[string]string{},
+		execFunc: func(req models.ExecRequest) *models.ExecResult {
+			probes = append(probes, strings.Join(req.Command, " "))
+			return &models.ExecResult{Stdout: "PONG"}
+		},
+	}
+	repo := storage.NewMemoryRepository()
+	m := &DockerManager{
+		traefikConfig:   config.TraefikConfig{Enabled: true},
+		serviceRegistry: services.NewRegistry(),
+		templateLoader:  templates.NewLoader(),
+		repo:            repo,
+		ops:             newOperationTracker(),
+		containers:      runtime,
+	}
+
+	sb := &models.Sandbox{ID: "sb-1", ContainerID: "ctr-sb", Status: models.StatusRunning, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := repo.CreateSandbox(ctx, sb); err != nil {
+		t.Fatal(err)
+	}
+	redis := &models.ServiceInstance{Name: "redis", Type: "redis", Status: models.ServiceStatusReady, Mode: models.ServiceDedicated, ContainerID: "ctr-redis", Credentials: &models.ServiceCredentials{Password: "pw"}}
+	if err := repo.CreateService(ctx, "sb-1", redis); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Stop(ctx, "sb-1"); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(runtime.stopped, []string{"ctr-sb", "ctr-redis"}) {
+		t.Errorf("expected the sandbox and its service container stopped, got %v", runtime.stopped)
+	}
+
+	if err := m.Start(ctx, "sb-1"); err != nil {
+		t.Fatal(err)
+	}
+	// The service is up and answering before the sandbox starts
+	if !slices.Equal(runtime.started, []string{"ctr-redis", "ctr-sb"}) {
+		t.Errorf("expected the service container started before the sandbox, got %v", runtime.started)
+	}
+	if len(probes) != 1 || probes[0] != "redis-cli --no-auth-warning -a pw ping" {
+		t.Errorf("expected the service probed with its password, got %v", probes)
+	}
+}
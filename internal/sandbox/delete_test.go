@@ -0,0 +1,221 @@
+package sandbox
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/services"
+	"github.com/terra-clan/sandbox-engine/internal/storage"
+	"github.com/terra-clan/sandbox-engine/internal/templates"
+)
+
+// deprovisionProvider is a service provider whose deprovision result is set
+type deprovisionProvider struct {
+	basicProvider
+	err   error
+	calls int
+}
+
+func (p *deprovisionProvider) Deprovision(ctx context.Context, sandboxID, serviceName string) error {
+	p.calls++
+	return p.err
+}
+
+func TestDeleteKeepsSandboxWhenDeprovisionFails(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewMemoryRepository()
+	for _, id := range []string{"sb-1", "sb-2"} {
+		if err := repo.CreateSandbox(ctx, &models.Sandbox{ID: id, Status: models.StatusRunning, ExpiresAt: time.Now().Add(-time.Minute)}); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"postgres", "redis"} {
+			if err := repo.CreateService(ctx, id, &models.ServiceInstance{Name: name, Type: name, Status: models.ServiceStatusReady}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	postgres := &deprovisionProvider{err: errors.New("database is being accessed by other users")}
+	redis := &deprovisionProvider{}
+	registry := services.NewRegistry()
+	registry.Register("postgres", postgres)
+	registry.Register("redis", redis)
+	m := &DockerManager{
+		serviceRegistry: registry,
+		templateLoader:  templates.NewLoader(),
+		repo:            repo,
+		ops:             newOperationTracker(),
+	}
+
+	if err := m.Delete(ctx, "sb-1"); !errors.Is(err, ErrDeprovisionFailed) {
+		t.Fatalf("expected ErrDeprovisionFailed, got %v", err)
+	}
+	sb, _ := repo.GetSandbox(ctx, "sb-1")
+	if sb == nil || sb.Status != models.StatusDeleting || !strings.Contains(sb.StatusMsg, "postgres: database is being accessed") {
+		t.Fatalf("expected the sandbox kept deleting with the failure, got %+v", sb)
+	}
+	if redis.calls != 1 {
+		t.Errorf("expected the other services deprovisioned all the same, got %d calls", redis.calls)
+	}
+	events, _ := repo.ListEvents(ctx, "sb-1")
+	if len(events) != 1 || events[0].Type != models.EventDeleteFailed {
+		t.Errorf("expected a delete_failed event, got %+v", events)
+	}
+
+	// The cleanup worker finds it for a retry, not as an expired sandbox
+	if deleting, err := m.GetDeleting(ctx, 10); err != nil || len(deleting) != 1 || deleting[0].ID != "sb-1" {
+		t.Errorf("expected the sandbox waiting for a retry, got %v, %v", deleting, err)
+	}
+	if expired, _ := m.GetExpired(ctx, 10); len(expired) != 1 || expired[0].ID != "sb-2" {
+		t.Errorf("expected only the other sandbox expired, got %v", expired)
+	}
+
+	postgres.err = nil
+	if err := m.Delete(ctx, "sb-1"); err != nil {
+		t.Fatalf("expected the retry to delete the sandbox, got %v", err)
+	}
+	if sb, _ := repo.GetSandbox(ctx, "sb-1"); sb != nil {
+		t.Errorf("expected the sandbox deleted, got %s", sb.Status)
+	}
+
+	postgres.err = errors.New("connection refused")
+	if err := m.ForceDelete(ctx, "sb-2"); err != nil {
+		t.Fatalf("expected a forced delete to succeed, got %v", err)
+	}
+	if sb, _ := repo.GetSandbox(ctx, "sb-2"); sb != nil {
+		t.Errorf("expected the sandbox deleted, got %s", sb.Status)
+	}
+}
+
+// fakePostgres speaks enough of the Postgres wire protocol for the provider's
+// deprovision: trust auth, then every statement succeeds, or fails with
+// "55006 database is being accessed by other users" while fail is set and it
+// drops a database
+type fakePostgres struct {
+	addr string
+	fail atomic.Bool
+}
+
+func newFakePostgres(t *testing.T) *fakePostgres {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	f := &fakePostgres{addr: l.Addr().String()}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakePostgres) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	send := func(t byte, body ...string) {
+		b := []byte{t, 0, 0, 0, 0}
+		for _, s := range body {
+			b = append(b, s...)
+		}
+		binary.BigEndian.PutUint32(b[1:], uint32(len(b)-1))
+		conn.Write(b)
+	}
+	ready := func() { send('Z', "I") }
+
+	var n uint32
+	if binary.Read(r, binary.BigEndian, &n) != nil || n < 4 {
+		return
+	}
+	if _, err := io.CopyN(io.Discard, r, int64(n-4)); err != nil {
+		return
+	}
+	send('R', "\x00\x00\x00\x00")
+	ready()
+
+	for {
+		t, err := r.ReadByte()
+		if err != nil || binary.Read(r, binary.BigEndian, &n) != nil || n < 4 {
+			return
+		}
+		body := make([]byte, n-4)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return
+		}
+		switch t {
+		case 'Q':
+			q := strings.TrimRight(string(body), "\x00")
+			switch {
+			case q == ";":
+				send('I')
+			case f.fail.Load() && strings.HasPrefix(q, "DROP DATABASE"):
+				send('E', "SERROR\x00", "C55006\x00", "Mdatabase is being accessed by other users\x00", "\x00")
+			default:
+				send('C', "DROP\x00")
+			}
+			ready()
+		case 'S':
+			// The extended protocol's terminate query, whose result is ignored
+			send('E', "SERROR\x00", "C0A000\x00", "Mnot supported\x00", "\x00")
+			ready()
+		case 'X':
+			return
+		}
+	}
+}
+
+func TestDeleteKeepsSandboxWhenDropDatabaseFails(t *testing.T) {
+	ctx := context.Background()
+	pg := newFakePostgres(t)
+	pg.fail.Store(true)
+	provider, err := services.NewPostgresProvider("postgres://admin@"+pg.addr+"/postgres?sslmode=disable", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := storage.NewMemoryRepository()
+	if err := repo.CreateSandbox(ctx, &models.Sandbox{ID: "sb-1", Status: models.StatusRunning}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.CreateService(ctx, "sb-1", &models.ServiceInstance{Name: "postgres", Type: "postgres", Status: models.ServiceStatusReady}); err != nil {
+		t.Fatal(err)
+	}
+	registry := services.NewRegistry()
+	registry.Register("postgres", provider)
+	m := &DockerManager{
+		serviceRegistry: registry,
+		templateLoader:  templates.NewLoader(),
+		repo:            repo,
+		ops:             newOperationTracker(),
+	}
+
+	if err := m.Delete(ctx, "sb-1"); !errors.Is(err, ErrDeprovisionFailed) {
+		t.Fatalf("expected ErrDeprovisionFailed, got %v", err)
+	}
+	sb, _ := repo.GetSandbox(ctx, "sb-1")
+	if sb == nil || sb.Status != models.StatusDeleting || !strings.Contains(sb.StatusMsg, "failed to drop database") {
+		t.Fatalf("expected the sandbox kept deleting with the failed drop, got %+v", sb)
+	}
+
+	pg.fail.Store(false)
+	if err := m.Delete(ctx, "sb-1"); err != nil {
+		t.Fatalf("expected the retry to delete the sandbox, got %v", err)
+	}
+	if sb, _ := repo.GetSandbox(ctx, "sb-1"); sb != nil {
+		t.Errorf("expected the sandbox deleted, got %s", sb.Status)
+	}
+}
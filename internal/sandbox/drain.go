@@ -0,0 +1,196 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+// ErrShuttingDown is returned for new provisioning work once the manager is closing
+var ErrShuttingDown = errors.New("sandbox manager is shutting down")
+
+// defaultDrainTimeout bounds how long Close waits for in-flight provisioning
+const defaultDrainTimeout = 30 * time.Second
+
+// statusMsgAbandoned is recorded on sandboxes and sessions still provisioning at shutdown
+const statusMsgAbandoned = "provisioning abandoned at shutdown"
+
+// Kinds of tracked provisioning work
+const (
+	provisionSandboxKind = "sandbox"
+	provisionSessionKind = "session"
+)
+
+// provisionKey identifies one piece of in-flight provisioning
+type provisionKey struct {
+	kind string
+	id   string
+}
+
+// provisionTracker tracks in-flight provisioning goroutines so shutdown can
+// drain them, and rejects new work once closed
+type provisionTracker struct {
+	mu      sync.Mutex
+	closed  bool
+	running map[provisionKey]int // concurrent callers may register the same key
+	wg      sync.WaitGroup
+}
+
+func newProvisionTracker() *provisionTracker {
+	return &provisionTracker{
+		running: make(map[provisionKey]int),
+	}
+}
+
+// add registers work for key. It returns false once the tracker is closed.
+func (t *provisionTracker) add(key provisionKey) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return false
+	}
+	t.running[key]++
+	t.wg.Add(1)
+	return true
+}
+
+// done marks the work for key as finished
+func (t *provisionTracker) done(key provisionKey) {
+	t.mu.Lock()
+	if t.running[key]--; t.running[key] <= 0 {
+		delete(t.running, key)
+	}
+	t.mu.Unlock()
+	t.wg.Done()
+}
+
+// close stops accepting new work
+func (t *provisionTracker) close() {
+	t.mu.Lock()
+	t.closed = true
+	t.mu.Unlock()
+}
+
+// wait blocks until all work finishes or the timeout elapses.
+// Returns false if the timeout elapsed first.
+func (t *provisionTracker) wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// pending returns the work still running, sorted for stable logging
+func (t *provisionTracker) pending() []provisionKey {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	keys := make([]provisionKey, 0, len(t.running))
+	for k := range t.running {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].kind != keys[j].kind {
+			return keys[i].kind < keys[j].kind
+		}
+		return keys[i].id < keys[j].id
+	})
+	return keys
+}
+
+// beginProvision registers provisioning work before any state is written for it.
+// It returns ErrShuttingDown once Close has started. The caller must either
+// hand the key to runProvision or release it with m.provisioning.done.
+func (m *DockerManager) beginProvision(kind, id string) (provisionKey, error) {
+	key := provisionKey{kind: kind, id: id}
+	if !m.provisioning.add(key) {
+		return key, ErrShuttingDown
+	}
+	return key, nil
+}
+
+// runProvision runs fn for registered work in a goroutine bound to the manager's
+// lifetime. Only the trace of ctx carries over, so the work shows up in the
+// caller's trace without being cancelled with the caller.
+func (m *DockerManager) runProvision(ctx context.Context, key provisionKey, fn func(ctx context.Context)) {
+	workCtx := trace.ContextWithSpanContext(m.workCtx, trace.SpanContextFromContext(ctx))
+	go func() {
+		defer m.provisioning.done(key)
+		fn(workCtx)
+	}()
+}
+
+// drain stops new provisioning and waits up to the drain timeout for in-flight
+// provisioning to finish. Work still running afterwards is cancelled and marked
+// failed, so it isn't mistaken for live provisioning after a restart.
+func (m *DockerManager) drain() {
+	m.provisioning.close()
+	defer m.cancelWork()
+
+	timeout := m.sandboxConfig.DrainTimeout
+	if timeout <= 0 {
+		timeout = defaultDrainTimeout
+	}
+
+	if m.provisioning.wait(timeout) {
+		return
+	}
+
+	abandoned := m.provisioning.pending()
+	slog.Warn("provisioning still in flight at shutdown, abandoning", "count", len(abandoned), "drain_timeout", timeout)
+
+	m.cancelWork()
+
+	ctx, cancel := context.WithTimeout(context.Background(), phaseMargin)
+	defer cancel()
+
+	for _, key := range abandoned {
+		slog.Warn("abandoned provisioning", "kind", key.kind, "id", key.id)
+
+		switch key.kind {
+		case provisionSandboxKind:
+			m.updateStatus(ctx, key.id, models.StatusFailed, statusMsgAbandoned)
+		case provisionSessionKind:
+			m.failSession(ctx, key.id, statusMsgAbandoned)
+		}
+	}
+}
+
+// failSession marks a session still provisioning failed with msg
+func (m *DockerManager) failSession(ctx context.Context, id, msg string) {
+	session, err := m.repo.GetSessionByID(ctx, id)
+	if err != nil || session == nil {
+		slog.Error("failed to get session for status update", "error", err, "id", id)
+		return
+	}
+
+	session.Status = models.SessionFailed
+	session.StatusMessage = msg
+
+	updated, err := m.repo.UpdateSessionFrom(ctx, session, models.SessionProvisioning)
+	if err != nil {
+		slog.Error("failed to update session status", "error", err, "id", id)
+		return
+	}
+	if !updated {
+		// Provisioning finished, or the session was revoked, meanwhile
+		return
+	}
+	m.SendSessionCallback(ctx, session, models.CallbackFailed)
+}
@@ -0,0 +1,40 @@
+package sandbox
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProvisionTrackerDrain(t *testing.T) {
+	tr := newProvisionTracker()
+
+	fast := provisionKey{kind: provisionSandboxKind, id: "fast"}
+	slow := provisionKey{kind: provisionSessionKind, id: "slow"}
+	if !tr.add(fast) || !tr.add(slow) {
+		t.Fatal("add rejected before close")
+	}
+
+	tr.close()
+	if tr.add(provisionKey{kind: provisionSandboxKind, id: "late"}) {
+		t.Fatal("expected add to be rejected after close")
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		tr.done(fast)
+	}()
+
+	if tr.wait(100 * time.Millisecond) {
+		t.Fatal("expected wait to time out while slow work is running")
+	}
+
+	pending := tr.pending()
+	if len(pending) != 1 || pending[0] != slow {
+		t.Fatalf("expected only slow work pending, got %v", pending)
+	}
+
+	tr.done(slow)
+	if !tr.wait(time.Second) {
+		t.Fatal("expected wait to return once all work is done")
+	}
+}
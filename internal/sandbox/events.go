@@ -0,0 +1,55 @@
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+// RecordEvent appends a lifecycle event to a sandbox's history. Events are
+// best effort: a failed write is logged and never fails the transition.
+func (m *DockerManager) RecordEvent(ctx context.Context, id string, typ models.SandboxEventType, msg string) {
+	if err := m.repo.AppendEvent(context.WithoutCancel(ctx), newEvent(id, typ, msg)); err != nil {
+		slog.Warn("failed to record sandbox event", "error", err, "id", id, "type", typ)
+	}
+}
+
+// newEvent builds an event for sandbox id happening now
+func newEvent(id string, typ models.SandboxEventType, msg string) *models.SandboxEvent {
+	return &models.SandboxEvent{
+		SandboxID: id,
+		Type:      typ,
+		Message:   msg,
+		CreatedAt: time.Now(),
+	}
+}
+
+// ListEvents returns a sandbox's events, oldest first. Events of deleted
+// sandboxes stay available until the retention period has passed.
+func (m *DockerManager) ListEvents(ctx context.Context, id string) ([]*models.SandboxEvent, error) {
+	events, err := m.repo.ListEvents(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list events: %w", err)
+	}
+	if len(events) > 0 {
+		return events, nil
+	}
+
+	// Sandboxes created before events were recorded have none
+	if _, err := m.Get(ctx, id); err != nil {
+		return nil, err
+	}
+	return []*models.SandboxEvent{}, nil
+}
+
+// PurgeEvents deletes the events of sandboxes deleted more than retention ago
+func (m *DockerManager) PurgeEvents(ctx context.Context, retention time.Duration) (int64, error) {
+	n, err := m.repo.DeleteEventsBefore(ctx, time.Now().Add(-retention))
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge events: %w", err)
+	}
+	return n, nil
+}
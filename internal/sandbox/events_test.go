@@ -0,0 +1,100 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/storage"
+)
+
+// eventRepo stores events in memory, optionally failing appends
+type eventRepo struct {
+	storage.Repository
+	sandboxes map[string]*models.Sandbox
+	events    []*models.SandboxEvent
+	appendErr error
+	cutoff    time.Time
+}
+
+func (r *eventRepo) AppendEvent(ctx context.Context, ev *models.SandboxEvent) error {
+	if r.appendErr != nil {
+		return r.appendErr
+	}
+	ev.ID = int64(len(r.events) + 1)
+	r.events = append(r.events, ev)
+	return nil
+}
+
+func (r *eventRepo) ListEvents(ctx context.Context, sandboxID string) ([]*models.SandboxEvent, error) {
+	var events []*models.SandboxEvent
+	for _, ev := range r.events {
+		if ev.SandboxID == sandboxID {
+			events = append(events, ev)
+		}
+	}
+	return events, nil
+}
+
+func (r *eventRepo) GetSandbox(ctx context.Context, id string) (*models.Sandbox, error) {
+	return r.sandboxes[id], nil
+}
+
+func (r *eventRepo) DeleteEventsBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	r.cutoff = cutoff
+	return 0, nil
+}
+
+func TestListEvents(t *testing.T) {
+	repo := &eventRepo{sandboxes: map[string]*models.Sandbox{"live": {ID: "live"}}}
+	m := &DockerManager{repo: repo}
+	ctx := context.Background()
+
+	// The deleted sandbox's record is gone but its events remain
+	m.RecordEvent(ctx, "gone", models.EventCreated, "")
+	m.RecordEvent(ctx, "gone", models.EventExpired, "")
+	m.RecordEvent(ctx, "gone", models.EventDeleted, "")
+
+	events, err := m.ListEvents(ctx, "gone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 || events[0].Type != models.EventCreated || events[2].Type != models.EventDeleted {
+		t.Fatalf("unexpected events %+v", events)
+	}
+
+	events, err = m.ListEvents(ctx, "live")
+	if err != nil || events == nil || len(events) != 0 {
+		t.Fatalf("expected an empty list for a sandbox without events, got %v, %v", events, err)
+	}
+
+	if _, err := m.ListEvents(ctx, "unknown"); !errors.Is(err, ErrSandboxNotFound) {
+		t.Fatalf("expected ErrSandboxNotFound, got %v", err)
+	}
+}
+
+func TestRecordEventIsBestEffort(t *testing.T) {
+	repo := &eventRepo{appendErr: errors.New("db down")}
+	m := &DockerManager{repo: repo}
+
+	// Must not panic or block; the failure is only logged
+	m.RecordEvent(context.Background(), "sb-1", models.EventStarted, "")
+	if len(repo.events) != 0 {
+		t.Fatalf("expected no events, got %v", repo.events)
+	}
+}
+
+func TestPurgeEventsCutoff(t *testing.T) {
+	repo := &eventRepo{}
+	m := &DockerManager{repo: repo}
+
+	before := time.Now()
+	if _, err := m.PurgeEvents(context.Background(), 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if want := before.Add(-24 * time.Hour); repo.cutoff.Before(want.Add(-time.Second)) || repo.cutoff.After(want.Add(time.Second)) {
+		t.Errorf("expected cutoff near %s, got %s", want, repo.cutoff)
+	}
+}
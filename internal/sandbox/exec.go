@@ -0,0 +1,232 @@
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+const (
+	// execDefaultTimeout bounds a command whose request sets no timeout
+	execDefaultTimeout = 60 * time.Second
+	// ExecMaxTimeout is the longest timeout a command may ask for
+	ExecMaxTimeout = 10 * time.Minute
+	// execOutputMax caps what is kept of each of a command's output streams
+	execOutputMax = 1 << 20
+)
+
+// Exec runs a command in sb's container to completion, as the template's
+// terminal user, and returns its exit code and output. It fails with
+// ErrExecTimeout when the command outlives its timeout; the command itself is
+// left running, as Docker can't kill an exec.
+func (m *DockerManager) Exec(ctx context.Context, sb *models.Sandbox, req models.ExecRequest) (*models.ExecResult, error) {
+	timeout := execDefaultTimeout
+	if req.TimeoutSeconds > 0 {
+		timeout = time.Duration(req.TimeoutSeconds) * time.Second
+	}
+	var user string
+	if tmpl := m.templateLoader.Get(sb.TemplateID); tmpl != nil && tmpl.Terminal != nil {
+		user = tmpl.Terminal.User
+	}
+	env := make([]string, 0, len(req.Env))
+	for k, v := range req.Env {
+		env = append(env, k+"="+v)
+	}
+	sort.Strings(env)
+
+	started := time.Now()
+	execResp, err := m.docker.ContainerExecCreate(ctx, sb.ContainerID, types.ExecConfig{
+		User:         user,
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          req.Command,
+		WorkingDir:   req.WorkingDir,
+		Env:          env,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create exec: %w", err)
+	}
+
+	attach, err := m.docker.ContainerExecAttach(ctx, execResp.ID, types.ExecStartCheck{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to attach exec: %w", err)
+	}
+	defer attach.Close()
+
+	stdout := &cappedBuffer{max: execOutputMax}
+	stderr := &cappedBuffer{max: execOutputMax}
+	copied := make(chan error, 1)
+	go func() {
+		_, err := stdcopy.StdCopy(stdout, stderr, attach.Reader)
+		copied <- err
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-copied:
+		if err != nil {
+			return nil, fmt.Errorf("failed to read exec output: %w", err)
+		}
+	case <-timer.C:
+		return nil, ErrExecTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	exit, err := m.ExecExit(ctx, sb.ContainerID, execResp.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &models.ExecResult{
+		ExitCode:   exit.Code,
+		Stdout:     stdout.buf.String(),
+		Stderr:     stderr.buf.String(),
+		Truncated:  stdout.truncated || stderr.truncated,
+		DurationMs: time.Since(started).Milliseconds(),
+	}, nil
+}
+
+// execInput runs cmd in a container as its default user with input on its
+// stdin, streamed until EOF, and returns its exit code and output. It is
+// bounded by ctx alone.
+func (m *DockerManager) execInput(ctx context.Context, containerID string, cmd, env []string, input io.Reader) (*models.ExecResult, error) {
+	started := time.Now()
+	execResp, err := m.docker.ContainerExecCreate(ctx, containerID, types.ExecConfig{
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          cmd,
+		Env:          env,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create exec: %w", err)
+	}
+
+	attach, err := m.docker.ContainerExecAttach(ctx, execResp.ID, types.ExecStartCheck{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to attach exec: %w", err)
+	}
+	defer attach.Close()
+
+	stdout := &cappedBuffer{max: execOutputMax}
+	stderr := &cappedBuffer{max: execOutputMax}
+	copied := make(chan error, 1)
+	go func() {
+		_, err := stdcopy.StdCopy(stdout, stderr, attach.Reader)
+		copied <- err
+	}()
+
+	// A command that exits early stops reading, which fails the copy; its
+	// exit code and output explain why
+	written := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(attach.Conn, input)
+		if closeErr := attach.CloseWrite(); err == nil {
+			err = closeErr
+		}
+		written <- err
+	}()
+
+	select {
+	case err := <-copied:
+		if err != nil {
+			return nil, fmt.Errorf("failed to read exec output: %w", err)
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	exit, err := m.ExecExit(ctx, containerID, execResp.ID)
+	if err != nil {
+		return nil, err
+	}
+	// Unblocks a write the command was no longer reading
+	attach.Close()
+	if writeErr := <-written; writeErr != nil && exit.Code == 0 {
+		return nil, fmt.Errorf("failed to write exec input: %w", writeErr)
+	}
+	return &models.ExecResult{
+		ExitCode:   exit.Code,
+		Stdout:     stdout.buf.String(),
+		Stderr:     stderr.buf.String(),
+		Truncated:  stdout.truncated || stderr.truncated,
+		DurationMs: time.Since(started).Milliseconds(),
+	}, nil
+}
+
+// Stats samples the resource usage of a container. Docker takes two samples
+// about a second apart to work out the CPU usage, so this blocks that long.
+func (m *DockerManager) Stats(ctx context.Context, containerID string) (*models.SandboxStats, error) {
+	resp, err := m.docker.ContainerStats(ctx, containerID, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get container stats: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var v types.StatsJSON
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return nil, fmt.Errorf("failed to decode container stats: %w", err)
+	}
+	return statsFromDocker(&v), nil
+}
+
+// statsFromDocker maps Docker's stats to ours, the way docker stats shows them
+func statsFromDocker(v *types.StatsJSON) *models.SandboxStats {
+	stats := &models.SandboxStats{
+		MemoryBytes:      v.MemoryStats.Usage,
+		MemoryLimitBytes: v.MemoryStats.Limit,
+		PIDs:             v.PidsStats.Current,
+		ReadAt:           v.Read,
+	}
+
+	cpuDelta := float64(v.CPUStats.CPUUsage.TotalUsage) - float64(v.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(v.CPUStats.SystemUsage) - float64(v.PreCPUStats.SystemUsage)
+	cpus := float64(v.CPUStats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(v.CPUStats.CPUUsage.PercpuUsage))
+	}
+	if cpuDelta > 0 && systemDelta > 0 {
+		stats.CPUPercent = cpuDelta / systemDelta * cpus * 100
+	}
+
+	// Page cache the kernel can reclaim isn't counted, on cgroup v1 or v2
+	for _, key := range []string{"total_inactive_file", "inactive_file"} {
+		if cache, ok := v.MemoryStats.Stats[key]; ok && cache < stats.MemoryBytes {
+			stats.MemoryBytes -= cache
+			break
+		}
+	}
+
+	for _, n := range v.Networks {
+		stats.NetworkRxBytes += n.RxBytes
+		stats.NetworkTxBytes += n.TxBytes
+	}
+	return stats
+}
+
+// cappedBuffer keeps the first max bytes written to it and drops the rest, so
+// a chatty command can't exhaust memory
+type cappedBuffer struct {
+	max       int
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.buf.Len(); len(p) > room {
+		b.buf.Write(p[:max(room, 0)])
+		b.truncated = true
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
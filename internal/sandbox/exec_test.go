@@ -0,0 +1,68 @@
+package sandbox
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestStatsFromDocker(t *testing.T) {
+	var v types.StatsJSON
+	v.Read = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	v.PreCPUStats.CPUUsage.TotalUsage = 1_000
+	v.PreCPUStats.SystemUsage = 100_000
+	v.CPUStats.CPUUsage.TotalUsage = 6_000
+	v.CPUStats.SystemUsage = 120_000
+	v.CPUStats.OnlineCPUs = 2
+	v.MemoryStats.Usage = 300 << 20
+	v.MemoryStats.Limit = 1 << 30
+	v.MemoryStats.Stats = map[string]uint64{"inactive_file": 100 << 20}
+	v.PidsStats.Current = 7
+	v.Networks = map[string]types.NetworkStats{
+		"eth0": {RxBytes: 1000, TxBytes: 200},
+		"eth1": {RxBytes: 24, TxBytes: 3},
+	}
+
+	stats := statsFromDocker(&v)
+	// 5000 of 20000 system ticks on 2 CPUs
+	if math.Abs(stats.CPUPercent-50) > 1e-9 {
+		t.Errorf("expected 50%% CPU, got %f", stats.CPUPercent)
+	}
+	if stats.MemoryBytes != 200<<20 || stats.MemoryLimitBytes != 1<<30 {
+		t.Errorf("expected the page cache left out of memory, got %d of %d", stats.MemoryBytes, stats.MemoryLimitBytes)
+	}
+	if stats.NetworkRxBytes != 1024 || stats.NetworkTxBytes != 203 || stats.PIDs != 7 || !stats.ReadAt.Equal(v.Read) {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestStatsFromDockerFirstSample(t *testing.T) {
+	var v types.StatsJSON
+	v.CPUStats.CPUUsage.TotalUsage = 6_000
+	v.CPUStats.CPUUsage.PercpuUsage = []uint64{3_000, 3_000}
+
+	if stats := statsFromDocker(&v); stats.CPUPercent != 0 {
+		t.Errorf("expected no CPU usage without a previous sample, got %f", stats.CPUPercent)
+	}
+}
+
+func TestCappedBuffer(t *testing.T) {
+	b := &cappedBuffer{max: 10}
+	for _, chunk := range []string{"hello ", "world", "!"} {
+		if n, err := b.Write([]byte(chunk)); n != len(chunk) || err != nil {
+			t.Fatalf("expected the whole chunk accepted, got %d %v", n, err)
+		}
+	}
+	if b.buf.String() != "hello worl" || !b.truncated {
+		t.Errorf("expected the first 10 bytes kept and truncated set, got %q %v", b.buf.String(), b.truncated)
+	}
+
+	b = &cappedBuffer{max: 10}
+	b.Write([]byte(strings.Repeat("a", 10)))
+	if b.truncated {
+		t.Error("expected output of exactly the cap not to be truncated")
+	}
+}
@@ -0,0 +1,41 @@
+package sandbox
+
+import (
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+// deleteOnExpiry is the expiry behavior the engine implements today: no grace
+// period and no archive, the next cleanup cycle deletes the sandbox outright
+func deleteOnExpiry() *models.ExpiryBehavior {
+	return &models.ExpiryBehavior{Action: models.ExpiryDelete}
+}
+
+// SandboxExpiry resolves what the cleanup worker does with sb once its TTL runs
+// out, or nil when expiry no longer applies. The cleaner acts on this result,
+// so API responses built from it can't promise anything the cleaner won't do.
+func SandboxExpiry(sb *models.Sandbox) *models.ExpiryBehavior {
+	if sb.Status.IsTerminal() || sb.DeletedAt != nil {
+		return nil
+	}
+	return deleteOnExpiry()
+}
+
+// SessionExpiry resolves what happens to a session's sandbox when the session
+// runs out, or nil once the session has ended
+func SessionExpiry(s *models.Session) *models.ExpiryBehavior {
+	if s.IsTerminal() {
+		return nil
+	}
+	return deleteOnExpiry()
+}
+
+// ExpiryDue reports whether the expiry action is due for something that
+// expired at expiresAt, honoring the grace period
+func ExpiryDue(expiry *models.ExpiryBehavior, expiresAt, now time.Time) bool {
+	if expiry == nil {
+		return false
+	}
+	return !now.Before(expiresAt.Add(time.Duration(expiry.GracePeriodSeconds) * time.Second))
+}
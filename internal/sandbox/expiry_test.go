@@ -0,0 +1,56 @@
+package sandbox
+
+import (
+	"testing"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+func TestSandboxExpiry(t *testing.T) {
+	for _, status := range []models.SandboxStatus{models.StatusPending, models.StatusRunning, models.StatusStopped} {
+		expiry := SandboxExpiry(&models.Sandbox{Status: status})
+		if expiry == nil || expiry.Action != models.ExpiryDelete || expiry.Archive {
+			t.Errorf("%s: expected delete without archive, got %+v", status, expiry)
+		}
+	}
+	for _, status := range []models.SandboxStatus{models.StatusFailed, models.StatusExpired} {
+		if expiry := SandboxExpiry(&models.Sandbox{Status: status}); expiry != nil {
+			t.Errorf("%s: expected no expiry behavior, got %+v", status, expiry)
+		}
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	if expiry := SessionExpiry(&models.Session{Status: models.SessionReady}); expiry == nil || expiry.Action != models.ExpiryDelete {
+		t.Errorf("expected a ready session's sandbox to be deleted at expiry, got %+v", expiry)
+	}
+	if expiry := SessionExpiry(&models.Session{Status: models.SessionExpired}); expiry != nil {
+		t.Errorf("expected no expiry behavior for an ended session, got %+v", expiry)
+	}
+}
+
+func TestExpiryDue(t *testing.T) {
+	expiresAt := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	grace := &models.ExpiryBehavior{Action: models.ExpiryDelete, GracePeriodSeconds: 60}
+
+	tests := []struct {
+		name   string
+		expiry *models.ExpiryBehavior
+		now    time.Time
+		want   bool
+	}{
+		{name: "no behavior", expiry: nil, now: expiresAt.Add(time.Hour), want: false},
+		{name: "at expiry", expiry: deleteOnExpiry(), now: expiresAt, want: true},
+		{name: "within grace", expiry: grace, now: expiresAt.Add(30 * time.Second), want: false},
+		{name: "grace elapsed", expiry: grace, now: expiresAt.Add(time.Minute), want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExpiryDue(tt.expiry, expiresAt, tt.now); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package sandbox
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/errdefs"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+// ValidateFilePath checks that p is an absolute path to a file, not a
+// directory, and returns it cleaned
+func ValidateFilePath(p string) (string, error) {
+	if !strings.HasPrefix(p, "/") {
+		return "", errors.New("path must be absolute")
+	}
+	clean := path.Clean(p)
+	if clean == "/" || strings.HasSuffix(p, "/") {
+		return "", errors.New("path must name a file")
+	}
+	return clean, nil
+}
+
+// UploadFile writes size bytes from r to filePath in sb's container, creating
+// missing directories and replacing an existing file. The file belongs to the
+// template's terminal user, who runs commands and the terminal. The content
+// is streamed into the container rather than buffered.
+func (m *DockerManager) UploadFile(ctx context.Context, sb *models.Sandbox, filePath string, r io.Reader, size int64) error {
+	owner := m.fileOwner(ctx, sb)
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeFileTar(pw, filePath, owner, r, size))
+	}()
+
+	err := m.containers.extract(ctx, sb.ContainerID, pr)
+	// Unblock the writer if the container stopped reading early
+	pr.CloseWithError(errors.New("upload ended"))
+	if err != nil {
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+	return nil
+}
+
+// fileIDs is the numeric owner of an uploaded file
+type fileIDs struct {
+	uid, gid int
+}
+
+// idOutputPattern matches the start of id's output, e.g. "uid=1000(app) gid=1000(app)"
+var idOutputPattern = regexp.MustCompile(`^uid=(\d+)(?:\([^)]*\))? gid=(\d+)`)
+
+// fileOwner asks sb's container with id who commands run as. Images without
+// id fall back to a numeric terminal user ("uid" or "uid:gid"), and to root
+// when the user is a name.
+func (m *DockerManager) fileOwner(ctx context.Context, sb *models.Sandbox) fileIDs {
+	res, err := m.containers.exec(ctx, sb, models.ExecRequest{Command: []string{"id"}, TimeoutSeconds: 10})
+	if err == nil && res.ExitCode == 0 {
+		if match := idOutputPattern.FindStringSubmatch(res.Stdout); match != nil {
+			uid, _ := strconv.Atoi(match[1])
+			gid, _ := strconv.Atoi(match[2])
+			return fileIDs{uid, gid}
+		}
+	}
+
+	var user string
+	if tmpl := m.templateLoader.Get(sb.TemplateID); tmpl != nil && tmpl.Terminal != nil {
+		user = tmpl.Terminal.User
+	}
+	if owner, ok := numericUser(user); ok {
+		return owner
+	}
+	if user != "" {
+		slog.Warn("failed to resolve terminal user, uploading as root", "error", err, "id", sb.ID, "user", user)
+	}
+	return fileIDs{}
+}
+
+// numericUser parses a user given as "uid" or "uid:gid"; like Docker, a
+// missing group is 0
+func numericUser(user string) (fileIDs, bool) {
+	name, group, _ := strings.Cut(user, ":")
+	uid, err := strconv.Atoi(name)
+	if err != nil || uid < 0 {
+		return fileIDs{}, false
+	}
+	gid := 0
+	if group != "" {
+		if gid, err = strconv.Atoi(group); err != nil || gid < 0 {
+			return fileIDs{}, false
+		}
+	}
+	return fileIDs{uid, gid}, true
+}
+
+// writeFileTar writes a tar stream holding a single file owned by owner,
+// extracted relative to /
+func writeFileTar(w io.Writer, filePath string, owner fileIDs, r io.Reader, size int64) error {
+	tw := tar.NewWriter(w)
+	hdr := &tar.Header{
+		Name:    strings.TrimPrefix(filePath, "/"),
+		Mode:    0o644,
+		Uid:     owner.uid,
+		Gid:     owner.gid,
+		Size:    size,
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	n, err := io.Copy(tw, io.LimitReader(r, size))
+	if err != nil {
+		return err
+	}
+	if n < size {
+		return fmt.Errorf("file ended after %d of %d bytes", n, size)
+	}
+	return tw.Close()
+}
+
+// DownloadFile opens filePath in a container, returning its content and
+// size; the caller closes the content. It fails with ErrFileNotFound when
+// there is no such path and ErrNotAFile when it isn't a regular file.
+func (m *DockerManager) DownloadFile(ctx context.Context, containerID, filePath string) (io.ReadCloser, int64, error) {
+	rc, err := m.containers.archive(ctx, containerID, filePath)
+	if errdefs.IsNotFound(err) {
+		return nil, 0, ErrFileNotFound
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to download file: %w", err)
+	}
+
+	tr := tar.NewReader(rc)
+	hdr, err := tr.Next()
+	if err != nil {
+		rc.Close()
+		return nil, 0, fmt.Errorf("failed to read file archive: %w", err)
+	}
+	if hdr.Typeflag != tar.TypeReg {
+		rc.Close()
+		return nil, 0, ErrNotAFile
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(tr, hdr.Size), rc}, hdr.Size, nil
+}
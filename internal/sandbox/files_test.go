@@ -0,0 +1,119 @@
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/templates"
+)
+
+func TestValidateFilePath(t *testing.T) {
+	for p, want := range map[string]string{
+		"/app/main.py":      "/app/main.py",
+		"/app/../etc/hosts": "/etc/hosts",
+		"/workspace//a.txt": "/workspace/a.txt",
+		"app/main.py":       "",
+		"":                  "",
+		"/":                 "",
+		"/app/":             "",
+		"/../":              "",
+	} {
+		got, err := ValidateFilePath(p)
+		if want == "" {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %q", p, got)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Errorf("%q: expected %q, got %q (%v)", p, want, got, err)
+		}
+	}
+}
+
+func TestUploadFile(t *testing.T) {
+	runtime := &fakeRuntime{execFunc: func(req models.ExecRequest) *models.ExecResult {
+		return &models.ExecResult{Stdout: "uid=1000(app) gid=1001(staff) groups=1001(staff)\n"}
+	}}
+	m := &DockerManager{containers: runtime, templateLoader: templates.NewLoader()}
+
+	content := strings.Repeat("x", 100<<10)
+	if err := m.UploadFile(context.Background(), &models.Sandbox{ContainerID: "ctr-1"}, "/workspace/data.txt", strings.NewReader(content), int64(len(content))); err != nil {
+		t.Fatal(err)
+	}
+	if got := runtime.workspace["workspace/data.txt"]; got != content {
+		t.Errorf("expected the file extracted relative to /, got %d bytes", len(got))
+	}
+	if got := runtime.owners["workspace/data.txt"]; got != "1000:1001" {
+		t.Errorf("expected the file owned by the terminal user, got %s", got)
+	}
+}
+
+func TestUploadFileOwnerFallback(t *testing.T) {
+	noID := func(req models.ExecRequest) *models.ExecResult {
+		return &models.ExecResult{ExitCode: 127, Stderr: "id: not found"}
+	}
+	for user, want := range map[string]string{
+		"":          "0:0",
+		"1000":      "1000:0",
+		"1000:1000": "1000:1000",
+		"app":       "0:0",
+	} {
+		loader := templates.NewLoader()
+		loader.Add(&models.Template{Name: "tpl", Terminal: &models.TerminalSpec{User: user}})
+		runtime := &fakeRuntime{execFunc: noID}
+		m := &DockerManager{containers: runtime, templateLoader: loader}
+
+		if err := m.UploadFile(context.Background(), &models.Sandbox{TemplateID: "tpl"}, "/a.txt", strings.NewReader("a"), 1); err != nil {
+			t.Fatal(err)
+		}
+		if got := runtime.owners["a.txt"]; got != want {
+			t.Errorf("user %q: expected owner %s, got %s", user, want, got)
+		}
+	}
+}
+
+func TestUploadFileShort(t *testing.T) {
+	m := &DockerManager{containers: &fakeRuntime{}, templateLoader: templates.NewLoader()}
+
+	err := m.UploadFile(context.Background(), &models.Sandbox{}, "/a.txt", strings.NewReader("abc"), 10)
+	if err == nil || !strings.Contains(err.Error(), "3 of 10") {
+		t.Errorf("expected a short body to fail the upload, got %v", err)
+	}
+}
+
+func TestDownloadFile(t *testing.T) {
+	runtime := &fakeRuntime{workspace: map[string]string{"main.py": "print('hi')\n"}}
+	m := &DockerManager{containers: runtime}
+
+	rc, size, err := m.DownloadFile(context.Background(), "ctr-1", "/app/main.py")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	var buf bytes.Buffer
+	io.Copy(&buf, rc)
+	if buf.String() != "print('hi')\n" || size != int64(buf.Len()) {
+		t.Errorf("unexpected download %q of size %d", buf.String(), size)
+	}
+}
+
+func TestDownloadFileErrors(t *testing.T) {
+	runtime := &fakeRuntime{
+		workspace: map[string]string{"app/": ""},
+		missing:   map[string]bool{"/missing": true},
+	}
+	m := &DockerManager{containers: runtime}
+
+	if _, _, err := m.DownloadFile(context.Background(), "ctr-1", "/missing"); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("expected ErrFileNotFound, got %v", err)
+	}
+	if _, _, err := m.DownloadFile(context.Background(), "ctr-1", "/app"); !errors.Is(err, ErrNotAFile) {
+		t.Errorf("expected ErrNotAFile for a directory, got %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/docker/docker/api/types/container"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+// ErrNoGPUAvailable is returned when a template requests GPUs the host can't grant
+var ErrNoGPUAvailable = errors.New("no GPU available")
+
+// metaGPUs records the GPUs a sandbox was granted, so live GPU sandboxes can be counted per host
+const metaGPUs = "resources.gpus"
+
+// gpuDriver and gpuCapabilities select NVIDIA GPUs through the container toolkit
+const gpuDriver = "nvidia"
+
+var gpuCapabilities = [][]string{{"gpu"}}
+
+// reserveGPU checks that the host can take another GPU sandbox. For templates
+// requesting GPUs it holds the GPU lock until release is called, so concurrent
+// creates can't both take the last slot before the sandbox is stored.
+func (m *DockerManager) reserveGPU(ctx context.Context, tmpl *models.Template, host string) (release func(), err error) {
+	count, err := tmpl.Resources.GPUCount()
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return func() {}, nil
+	}
+	if !m.config.GPUEnabled {
+		return nil, fmt.Errorf("%w: GPU scheduling is disabled", ErrNoGPUAvailable)
+	}
+
+	m.gpuMu.Lock()
+	if limit := m.config.HostMaxGPUSandboxes; limit > 0 {
+		counts, err := m.repo.CountGPUSandboxesByHost(ctx, m.hosts()[0].name)
+		if err != nil {
+			m.gpuMu.Unlock()
+			return nil, fmt.Errorf("failed to count GPU sandboxes: %w", err)
+		}
+		if counts[host] >= limit {
+			m.gpuMu.Unlock()
+			return nil, fmt.Errorf("%w: host %s runs %d of %d GPU sandboxes", ErrNoGPUAvailable, host, counts[host], limit)
+		}
+	}
+	return m.gpuMu.Unlock, nil
+}
+
+// deviceRequests translates a template's GPU request into Docker device requests
+func deviceRequests(tmpl *models.Template) []container.DeviceRequest {
+	count, err := tmpl.Resources.GPUCount()
+	if err != nil || count == 0 {
+		return nil
+	}
+	return []container.DeviceRequest{{
+		Driver:       gpuDriver,
+		Count:        count, // -1 is all GPUs
+		Capabilities: gpuCapabilities,
+	}}
+}
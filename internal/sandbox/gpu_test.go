@@ -0,0 +1,79 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/terra-clan/sandbox-engine/internal/config"
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/storage"
+)
+
+// gpuRepo reports fixed GPU sandbox counts per host
+type gpuRepo struct {
+	storage.Repository
+	counts map[string]int
+}
+
+func (r *gpuRepo) CountGPUSandboxesByHost(ctx context.Context, defaultHost string) (map[string]int, error) {
+	return r.counts, nil
+}
+
+func TestReserveGPU(t *testing.T) {
+	gpu := &models.Template{Resources: models.Resources{GPUs: "1"}}
+
+	tests := []struct {
+		name    string
+		tmpl    *models.Template
+		cfg     config.DockerConfig
+		running int
+		wantErr bool
+	}{
+		{name: "no gpus requested", tmpl: &models.Template{}},
+		{name: "scheduling disabled", tmpl: gpu, wantErr: true},
+		{name: "unlimited", tmpl: gpu, cfg: config.DockerConfig{GPUEnabled: true}, running: 10},
+		{name: "below limit", tmpl: gpu, cfg: config.DockerConfig{GPUEnabled: true, HostMaxGPUSandboxes: 2}, running: 1},
+		{name: "limit reached", tmpl: gpu, cfg: config.DockerConfig{GPUEnabled: true, HostMaxGPUSandboxes: 2}, running: 2, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &DockerManager{
+				config: tt.cfg,
+				repo:   &gpuRepo{counts: map[string]int{defaultHostName: tt.running}},
+			}
+
+			release, err := m.reserveGPU(context.Background(), tt.tmpl, defaultHostName)
+			if tt.wantErr {
+				if !errors.Is(err, ErrNoGPUAvailable) {
+					t.Fatalf("expected ErrNoGPUAvailable, got %v", err)
+				}
+				if !m.gpuMu.TryLock() {
+					t.Fatal("expected the GPU lock to be released on error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			release()
+			if !m.gpuMu.TryLock() {
+				t.Fatal("expected release to unlock the GPU lock")
+			}
+		})
+	}
+}
+
+func TestDeviceRequests(t *testing.T) {
+	if reqs := deviceRequests(&models.Template{}); reqs != nil {
+		t.Errorf("expected no device requests without gpus, got %+v", reqs)
+	}
+
+	for gpus, want := range map[string]int{"2": 2, "all": -1} {
+		reqs := deviceRequests(&models.Template{Resources: models.Resources{GPUs: gpus}})
+		if len(reqs) != 1 || reqs[0].Driver != "nvidia" || reqs[0].Count != want || reqs[0].Capabilities[0][0] != "gpu" {
+			t.Errorf("gpus %q: unexpected device requests %+v", gpus, reqs)
+		}
+	}
+}
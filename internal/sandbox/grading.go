@@ -0,0 +1,239 @@
+package sandbox
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"strings"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+const (
+	// gradingOutputMax caps what is kept of each output stream of a grading
+	// run; the end is kept, where test runners print their summary
+	gradingOutputMax = 64 << 10
+	// junitReportMax caps the JUnit report read from a sandbox
+	junitReportMax = 8 << 20
+	// junitMessageMax caps the failure message kept per test case
+	junitMessageMax = 1024
+)
+
+// GradeSandbox runs the grading command of sb's task and stores the result,
+// with the task's JUnit report parsed when it has one. A command that exits
+// non-zero or times out is a failed grade, not an error. It fails with
+// ErrGradingNotConfigured when the sandbox has no task or its task no grading.
+func (m *DockerManager) GradeSandbox(ctx context.Context, sb *models.Sandbox) (*models.GradingResult, error) {
+	var task *models.CatalogTask
+	if id := sb.Metadata[models.MetadataTaskID]; id != "" {
+		task = m.templateLoader.GetTask(id)
+	}
+	if task == nil || task.Grading == nil {
+		return nil, ErrGradingNotConfigured
+	}
+	spec := task.Grading
+
+	workDir := spec.WorkingDir
+	if workDir == "" && task.StarterRepo != nil {
+		workDir = task.StarterRepo.Path
+	}
+	timeout := spec.TimeoutSeconds
+	if timeout <= 0 || timeout > int(ExecMaxTimeout.Seconds()) {
+		timeout = int(ExecMaxTimeout.Seconds())
+	}
+
+	res := &models.GradingResult{SandboxID: sb.ID, TaskID: task.ID, OwnerClientID: sb.OwnerClientID}
+
+	// A session binds at most one sandbox. Sandboxes of sessions created
+	// before sessions kept their creator have no owner; the result takes the
+	// session's.
+	session, err := m.repo.GetSessionBySandboxID(ctx, sb.ID)
+	if err != nil {
+		slog.Warn("failed to look up the session of a graded sandbox", "error", err, "id", sb.ID)
+	} else if session != nil {
+		res.SessionID = session.ID
+		if res.OwnerClientID == 0 {
+			res.OwnerClientID = session.OwnerClientID
+		}
+	}
+
+	// Whatever sits at the report path was not written by this run, e.g. a
+	// passing report planted by the candidate
+	report := spec.JUnitPath
+	if report != "" && !path.IsAbs(report) {
+		report = path.Join(workDir, report)
+	}
+	if report != "" {
+		if err := m.removeStaleReport(ctx, sb, report); err != nil {
+			return nil, err
+		}
+	}
+
+	exec, err := m.containers.exec(ctx, sb, models.ExecRequest{Command: []string{"sh", "-c", spec.Command}, WorkingDir: workDir, TimeoutSeconds: timeout})
+	switch {
+	case errors.Is(err, ErrExecTimeout):
+		res.ExitCode = -1
+		res.DurationMs = int64(timeout) * 1000
+		res.Error = fmt.Sprintf("grading command did not finish within %ds", timeout)
+	case err != nil:
+		return nil, fmt.Errorf("failed to run grading command: %w", err)
+	default:
+		var stdoutCut, stderrCut bool
+		res.ExitCode, res.DurationMs = exec.ExitCode, exec.DurationMs
+		res.Stdout, stdoutCut = outputTail(exec.Stdout)
+		res.Stderr, stderrCut = outputTail(exec.Stderr)
+		res.Truncated = exec.Truncated || stdoutCut || stderrCut
+	}
+
+	if report != "" && res.Error == "" {
+		if res.JUnit, err = m.readJUnitReport(ctx, sb, report); err != nil {
+			res.Error = err.Error()
+		}
+	}
+	res.Passed = res.Error == "" && res.ExitCode == 0 && (res.JUnit == nil || res.JUnit.Failures+res.JUnit.Errors == 0)
+
+	// The run is stored even when the client gave up waiting for it
+	if err := m.repo.CreateGradingResult(context.WithoutCancel(ctx), res); err != nil {
+		return nil, fmt.Errorf("failed to store grading result: %w", err)
+	}
+	m.RecordEvent(ctx, sb.ID, models.EventGraded, gradeSummary(res))
+	return res, nil
+}
+
+// ListGradingResults returns the grading runs of a sandbox, also after it is
+// cleaned up
+func (m *DockerManager) ListGradingResults(ctx context.Context, sandboxID string) ([]*models.GradingResult, error) {
+	results, err := m.repo.ListGradingResults(ctx, sandboxID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list grading results: %w", err)
+	}
+	return results, nil
+}
+
+// removeStaleReport deletes what is at the JUnit report path p before a
+// grading run, so only a report the run writes is read
+func (m *DockerManager) removeStaleReport(ctx context.Context, sb *models.Sandbox, p string) error {
+	res, err := m.containers.exec(ctx, sb, models.ExecRequest{Command: []string{"rm", "-rf", "--", p}, TimeoutSeconds: 30})
+	if err != nil {
+		return fmt.Errorf("failed to remove stale junit report: %w", err)
+	}
+	if res.ExitCode != 0 {
+		return fmt.Errorf("failed to remove stale junit report %s: %s", p, strings.TrimSpace(res.Stderr))
+	}
+	return nil
+}
+
+// readJUnitReport reads and parses the JUnit XML report at p in a sandbox
+func (m *DockerManager) readJUnitReport(ctx context.Context, sb *models.Sandbox, p string) (*models.JUnitReport, error) {
+	rc, size, err := m.DownloadFile(ctx, sb.ContainerID, p)
+	if errors.Is(err, ErrFileNotFound) || errors.Is(err, ErrNotAFile) {
+		return nil, fmt.Errorf("junit report %s: %w", p, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	if size > junitReportMax {
+		return nil, fmt.Errorf("junit report %s exceeds %d MB", p, junitReportMax>>20)
+	}
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read junit report %s: %w", p, err)
+	}
+	report, err := parseJUnit(data)
+	if err != nil {
+		return nil, fmt.Errorf("junit report %s: %w", p, err)
+	}
+	return report, nil
+}
+
+// junitSuite is a <testsuites> or <testsuite> element; suites may nest
+type junitSuite struct {
+	Suites []junitSuite `xml:"testsuite"`
+	Cases  []junitCase  `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure"`
+	Error     *junitMessage `xml:"error"`
+	Skipped   *junitMessage `xml:"skipped"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// parseJUnit sums up a JUnit XML report. The counts come from the test cases
+// rather than the suites' attributes, which not every runner writes.
+func parseJUnit(data []byte) (*models.JUnitReport, error) {
+	var root junitSuite
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("invalid JUnit XML: %w", err)
+	}
+	report := &models.JUnitReport{Cases: []models.JUnitCase{}}
+	addJUnitSuite(report, root)
+	return report, nil
+}
+
+func addJUnitSuite(report *models.JUnitReport, suite junitSuite) {
+	for _, c := range suite.Cases {
+		tc := models.JUnitCase{Name: c.Name, ClassName: c.ClassName, Time: c.Time, Status: models.JUnitPassed}
+		var msg *junitMessage
+		switch {
+		case c.Failure != nil:
+			tc.Status, msg = models.JUnitFailed, c.Failure
+			report.Failures++
+		case c.Error != nil:
+			tc.Status, msg = models.JUnitError, c.Error
+			report.Errors++
+		case c.Skipped != nil:
+			tc.Status, msg = models.JUnitSkipped, c.Skipped
+			report.Skipped++
+		}
+		if msg != nil {
+			tc.Message = msg.Message
+			if tc.Message == "" {
+				tc.Message = strings.TrimSpace(msg.Text)
+			}
+			if len(tc.Message) > junitMessageMax {
+				tc.Message = tc.Message[:junitMessageMax] + "..."
+			}
+		}
+		report.Tests++
+		report.Cases = append(report.Cases, tc)
+	}
+	for _, s := range suite.Suites {
+		addJUnitSuite(report, s)
+	}
+}
+
+// outputTail returns the last gradingOutputMax bytes of s, and whether any
+// were dropped
+func outputTail(s string) (string, bool) {
+	if len(s) <= gradingOutputMax {
+		return s, false
+	}
+	return s[len(s)-gradingOutputMax:], true
+}
+
+// gradeSummary is the event message of a grading run
+func gradeSummary(res *models.GradingResult) string {
+	verdict := "failed"
+	if res.Passed {
+		verdict = "passed"
+	}
+	msg := fmt.Sprintf("%s: %s, exit code %d", res.TaskID, verdict, res.ExitCode)
+	if res.JUnit != nil {
+		msg += fmt.Sprintf(", %d/%d tests passed", res.JUnit.Tests-res.JUnit.Failures-res.JUnit.Errors-res.JUnit.Skipped, res.JUnit.Tests)
+	}
+	return msg
+}
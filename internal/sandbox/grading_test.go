@@ -0,0 +1,165 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/storage"
+	"github.com/terra-clan/sandbox-engine/internal/templates"
+)
+
+const junitFixture = `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="cart" tests="2">
+    <testcase name="adds items" classname="cart" time="0.25"/>
+    <testcase name="applies coupons" classname="cart" time="0.5">
+      <failure message="expected 90, got 100">AssertionError</failure>
+    </testcase>
+  </testsuite>
+  <testsuite name="checkout">
+    <testcase name="pays" classname="checkout"><error>TypeError: card is undefined</error></testcase>
+    <testcase name="refunds" classname="checkout"><skipped/></testcase>
+  </testsuite>
+</testsuites>
+`
+
+func TestParseJUnit(t *testing.T) {
+	report, err := parseJUnit([]byte(junitFixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Tests != 4 || report.Failures != 1 || report.Errors != 1 || report.Skipped != 1 {
+		t.Errorf("unexpected counts %+v", report)
+	}
+	want := []models.JUnitCase{
+		{Name: "adds items", ClassName: "cart", Time: 0.25, Status: models.JUnitPassed},
+		{Name: "applies coupons", ClassName: "cart", Time: 0.5, Status: models.JUnitFailed, Message: "expected 90, got 100"},
+		{Name: "pays", ClassName: "checkout", Status: models.JUnitError, Message: "TypeError: card is undefined"},
+		{Name: "refunds", ClassName: "checkout", Status: models.JUnitSkipped},
+	}
+	if !reflect.DeepEqual(report.Cases, want) {
+		t.Errorf("unexpected cases %+v", report.Cases)
+	}
+
+	// A single suite is a report too
+	report, err = parseJUnit([]byte(`<testsuite><testcase name="ok"/></testsuite>`))
+	if err != nil || report.Tests != 1 || report.Failures != 0 {
+		t.Errorf("expected one passing test, got %+v, %v", report, err)
+	}
+	if _, err := parseJUnit([]byte("not xml")); err == nil {
+		t.Error("expected invalid XML to fail")
+	}
+}
+
+func TestGradeSandbox(t *testing.T) {
+	ctx := context.Background()
+	loader := taskLoader(t, `starter_repo:
+  url: https://git.example.com/cart.git
+grading:
+  command: npm test
+  timeout: 120
+  junit_path: reports/junit.xml
+`)
+
+	tests := []struct {
+		name      string
+		exitCode  int
+		report    string // written by the grading command
+		planted   bool   // a passing report sits at the path beforehand
+		sbOwner   int
+		wantPass  bool
+		wantError string
+	}{
+		{name: "passing", report: `<testsuite><testcase name="adds items"/></testsuite>`, sbOwner: 3, wantPass: true},
+		{name: "failing tests", exitCode: 1, report: junitFixture, sbOwner: 3},
+		{name: "missing report", sbOwner: 3, wantError: "file not found"},
+		{name: "planted report", planted: true, sbOwner: 3, wantError: "file not found"},
+		{name: "session owner", report: `<testsuite><testcase name="adds items"/></testsuite>`, wantPass: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const reportPath = "/workspace/reports/junit.xml"
+			runtime := &fakeRuntime{containers: map[string]string{}, workspace: map[string]string{}, missing: map[string]bool{reportPath: true}}
+			if tt.planted {
+				runtime.workspace["junit.xml"] = `<testsuite><testcase name="adds items"/></testsuite>`
+				delete(runtime.missing, reportPath)
+			}
+			runtime.execFunc = func(req models.ExecRequest) *models.ExecResult {
+				if reflect.DeepEqual(req.Command, []string{"rm", "-rf", "--", reportPath}) {
+					delete(runtime.workspace, "junit.xml")
+					runtime.missing[reportPath] = true
+					return &models.ExecResult{}
+				}
+				if tt.report != "" {
+					runtime.workspace["junit.xml"] = tt.report
+					delete(runtime.missing, reportPath)
+				}
+				return &models.ExecResult{ExitCode: tt.exitCode, Stdout: "running tests", DurationMs: 900}
+			}
+			repo := storage.NewMemoryRepository()
+			m := &DockerManager{templateLoader: loader, repo: repo, containers: runtime}
+
+			sb := &models.Sandbox{ID: "sb-1", ContainerID: "ctr-1", OwnerClientID: tt.sbOwner, Metadata: map[string]string{models.MetadataTaskID: "shop/api/cart"}}
+			if err := repo.CreateSandbox(ctx, sb); err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.CreateSession(ctx, &models.Session{ID: "s-1", Token: "tok", Status: models.SessionActive, SandboxID: "sb-1", OwnerClientID: 3}); err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := m.GradeSandbox(ctx, sb)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(runtime.execs) != 2 {
+				t.Fatalf("expected the stale report removed before grading, got %+v", runtime.execs)
+			}
+			if req := runtime.execs[1]; req.WorkingDir != "/workspace" || req.TimeoutSeconds != 120 || !reflect.DeepEqual(req.Command, []string{"sh", "-c", "npm test"}) {
+				t.Errorf("expected the grading command run in the starter repo, got %+v", req)
+			}
+			if res.Passed != tt.wantPass || res.ExitCode != tt.exitCode || res.Stdout != "running tests" || res.SessionID != "s-1" || res.OwnerClientID != 3 {
+				t.Errorf("unexpected result %+v", res)
+			}
+			if tt.wantError != "" {
+				if res.JUnit != nil || !strings.Contains(res.Error, tt.wantError) {
+					t.Errorf("expected the error %q, got %+v", tt.wantError, res)
+				}
+			} else if res.JUnit == nil || res.Error != "" {
+				t.Errorf("expected the report parsed, got %+v", res)
+			}
+
+			// Results outlive the sandbox and show in the session report
+			if err := m.deleteRecords(ctx, sb); err != nil {
+				t.Fatal(err)
+			}
+			results, err := m.ListGradingResults(ctx, "sb-1")
+			if err != nil || len(results) != 1 || results[0].ID != res.ID {
+				t.Fatalf("expected the result stored, got %v, %v", results, err)
+			}
+			report, err := m.SessionReport(ctx, "s-1")
+			if err != nil || len(report.Grading) != 1 {
+				t.Errorf("expected the result in the session report, got %+v, %v", report, err)
+			}
+		})
+	}
+}
+
+func TestGradeSandboxNotConfigured(t *testing.T) {
+	loader := taskLoader(t, "")
+	m := &DockerManager{templateLoader: loader, containers: &fakeRuntime{}}
+
+	for _, metadata := range []map[string]string{nil, {models.MetadataTaskID: "shop/api/cart"}, {models.MetadataTaskID: "shop/api/gone"}} {
+		sb := &models.Sandbox{ID: "sb-1", Metadata: metadata}
+		if _, err := m.GradeSandbox(context.Background(), sb); !errors.Is(err, ErrGradingNotConfigured) {
+			t.Errorf("%v: expected ErrGradingNotConfigured, got %v", metadata, err)
+		}
+	}
+	m = &DockerManager{templateLoader: templates.NewLoader()}
+	if _, err := m.GradeSandbox(context.Background(), &models.Sandbox{}); !errors.Is(err, ErrGradingNotConfigured) {
+		t.Errorf("expected ErrGradingNotConfigured without a catalog, got %v", err)
+	}
+}
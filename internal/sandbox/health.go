@@ -0,0 +1,71 @@
+package sandbox
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/storage"
+)
+
+// healthCheckTimeout bounds each dependency check of HealthDetails
+const healthCheckTimeout = 2 * time.Second
+
+// HealthDetails checks every dependency in parallel: the Docker daemon, the
+// repository (and its read replica, if any) and each service provider, keyed
+// "service:<name>". Unlike Ping it never fails; down components are marked.
+func (m *DockerManager) HealthDetails(ctx context.Context) map[string]*models.ComponentHealth {
+	checks := map[string]func(ctx context.Context) error{
+		"docker": func(ctx context.Context) error {
+			_, err := m.docker.Ping(ctx)
+			return err
+		},
+		"repository": m.repo.Ping,
+	}
+	if rr, ok := m.repo.(storage.ReadReplica); ok && rr.HasReadReplica() {
+		checks["read_replica"] = rr.PingReplica
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]*models.ComponentHealth, len(checks))
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			defer cancel()
+			start := time.Now()
+			health := componentHealth(check(ctx), time.Since(start))
+			mu.Lock()
+			results[name] = health
+			mu.Unlock()
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+		defer cancel()
+		for name, res := range m.serviceRegistry.HealthCheckAll(ctx) {
+			health := componentHealth(res.Err, res.Latency)
+			mu.Lock()
+			results["service:"+name] = health
+			mu.Unlock()
+		}
+	}()
+
+	wg.Wait()
+	return results
+}
+
+func componentHealth(err error, latency time.Duration) *models.ComponentHealth {
+	health := &models.ComponentHealth{Status: models.HealthUp, LatencyMS: latency.Milliseconds()}
+	if err != nil {
+		health.Status = models.HealthDown
+		health.Error = err.Error()
+	}
+	return health
+}
@@ -0,0 +1,219 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/storage"
+)
+
+// Host errors
+var (
+	ErrHostNotFound      = errors.New("docker host not found")
+	ErrNoSchedulableHost = errors.New("no docker host is accepting new sandboxes")
+)
+
+// defaultHostName names the Docker endpoint when DOCKER_HOST_NAME is unset
+const defaultHostName = "default"
+
+// dockerHost is a Docker endpoint sandboxes can be placed on
+type dockerHost struct {
+	name     string
+	endpoint string
+	capacity int // 0 is unlimited
+}
+
+// hosts returns the Docker hosts known to the manager.
+// The engine drives a single endpoint for now; placement and draining work per host
+// so more endpoints only need to be added here.
+func (m *DockerManager) hosts() []dockerHost {
+	name := m.config.HostName
+	if name == "" {
+		name = defaultHostName
+	}
+	return []dockerHost{{name: name, endpoint: m.config.Host, capacity: m.config.HostCapacity}}
+}
+
+// findHost returns the known host named name
+func (m *DockerManager) findHost(name string) (dockerHost, bool) {
+	for _, h := range m.hosts() {
+		if h.name == name {
+			return h, true
+		}
+	}
+	return dockerHost{}, false
+}
+
+// summarizeHosts combines host configuration, persisted drain state and
+// sandbox counts into the admin view of each host
+func summarizeHosts(hosts []dockerHost, states map[string]*models.HostState, counts map[string]int) []*models.HostInfo {
+	infos := make([]*models.HostInfo, 0, len(hosts))
+	for _, h := range hosts {
+		info := &models.HostInfo{
+			Name:      h.name,
+			Endpoint:  h.endpoint,
+			Sandboxes: counts[h.name],
+			Capacity:  h.capacity,
+		}
+
+		if st := states[h.name]; st != nil && st.Draining {
+			info.Draining = true
+			info.DrainMode = st.DrainMode
+			drainedAt := st.UpdatedAt
+			info.DrainedAt = &drainedAt
+		}
+
+		full := false
+		if h.capacity > 0 {
+			info.Available = h.capacity - info.Sandboxes
+			if info.Available <= 0 {
+				info.Available = 0
+				full = true
+			}
+		}
+		info.Schedulable = !info.Draining && !full
+
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// pickHost returns the schedulable host with the fewest sandboxes
+func pickHost(hosts []*models.HostInfo) (string, error) {
+	var best *models.HostInfo
+	for _, h := range hosts {
+		if !h.Schedulable {
+			continue
+		}
+		if best == nil || h.Sandboxes < best.Sandboxes || (h.Sandboxes == best.Sandboxes && h.Name < best.Name) {
+			best = h
+		}
+	}
+	if best == nil {
+		return "", ErrNoSchedulableHost
+	}
+	return best.Name, nil
+}
+
+// ListHosts returns all Docker hosts with their sandbox counts and drain state
+func (m *DockerManager) ListHosts(ctx context.Context) ([]*models.HostInfo, error) {
+	hosts := m.hosts()
+
+	states, err := m.repo.GetHostStates(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get host states: %w", err)
+	}
+
+	counts, err := m.repo.CountSandboxesByHost(ctx, hosts[0].name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count sandboxes: %w", err)
+	}
+
+	infos := summarizeHosts(hosts, states, counts)
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos, nil
+}
+
+// placeSandbox picks the host for a new sandbox
+func (m *DockerManager) placeSandbox(ctx context.Context) (string, error) {
+	hosts, err := m.ListHosts(ctx)
+	if err != nil {
+		return "", err
+	}
+	return pickHost(hosts)
+}
+
+// DrainHost marks a host unschedulable and returns the sandboxes still on it.
+// With DrainStopNow, its running sandboxes are stopped as well; otherwise they
+// keep running until they expire.
+func (m *DockerManager) DrainHost(ctx context.Context, name string, mode models.DrainMode) (*models.HostInfo, []*models.Sandbox, error) {
+	if _, ok := m.findHost(name); !ok {
+		return nil, nil, ErrHostNotFound
+	}
+	if mode == "" {
+		mode = models.DrainAtExpiry
+	}
+
+	if err := m.repo.SetHostState(ctx, &models.HostState{Name: name, Draining: true, DrainMode: mode}); err != nil {
+		return nil, nil, fmt.Errorf("failed to drain host: %w", err)
+	}
+
+	// Listing right after the state change must not lag behind it
+	sandboxes, err := m.repo.ListSandboxes(storage.WithPrimary(ctx), models.ListFilters{Host: name, DefaultHost: m.hosts()[0].name})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list sandboxes on host: %w", err)
+	}
+
+	remaining := make([]*models.Sandbox, 0, len(sandboxes))
+	stopped := 0
+	for _, sb := range sandboxes {
+		if sb.Status.IsTerminal() {
+			continue
+		}
+		remaining = append(remaining, sb)
+
+		if mode != models.DrainStopNow || sb.Status != models.StatusRunning {
+			continue
+		}
+		if err := m.stop(ctx, sb.ID, models.StatusMsgStoppedDrain); err != nil && !errors.Is(err, ErrOperationPending) {
+			slog.Warn("failed to stop sandbox on drained host", "error", err, "id", sb.ID, "host", name)
+			continue
+		}
+		stopped++
+	}
+
+	slog.Info("docker host drained", "host", name, "mode", mode, "sandboxes", len(remaining), "stopped", stopped)
+
+	info, err := m.hostInfo(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return info, remaining, nil
+}
+
+// UndrainHost makes a drained host schedulable again
+func (m *DockerManager) UndrainHost(ctx context.Context, name string) (*models.HostInfo, error) {
+	if _, ok := m.findHost(name); !ok {
+		return nil, ErrHostNotFound
+	}
+
+	if err := m.repo.SetHostState(ctx, &models.HostState{Name: name}); err != nil {
+		return nil, fmt.Errorf("failed to undrain host: %w", err)
+	}
+
+	slog.Info("docker host undrained", "host", name)
+	return m.hostInfo(ctx, name)
+}
+
+// hostInfo returns the admin view of a single host
+func (m *DockerManager) hostInfo(ctx context.Context, name string) (*models.HostInfo, error) {
+	infos, err := m.ListHosts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		if info.Name == name {
+			return info, nil
+		}
+	}
+	return nil, ErrHostNotFound
+}
+
+// AssignUnplacedSandboxes records the current host on sandboxes created before
+// placement was tracked, so draining and host listings include them
+func (m *DockerManager) AssignUnplacedSandboxes(ctx context.Context) {
+	host := m.hosts()[0].name
+
+	n, err := m.repo.AssignUnplacedSandboxes(ctx, host)
+	if err != nil {
+		slog.Warn("failed to assign host to existing sandboxes", "error", err)
+		return
+	}
+	if n > 0 {
+		slog.Info("existing sandboxes assigned to host", "host", host, "count", n)
+	}
+}
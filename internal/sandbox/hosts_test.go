@@ -0,0 +1,102 @@
+package sandbox
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+func TestSummarizeHosts(t *testing.T) {
+	drainedAt := time.Now()
+	hosts := []dockerHost{
+		{name: "a", endpoint: "tcp://a:2375", capacity: 10},
+		{name: "b", endpoint: "tcp://b:2375", capacity: 4},
+		{name: "c", endpoint: "tcp://c:2375"},
+	}
+	states := map[string]*models.HostState{
+		"c": {Name: "c", Draining: true, DrainMode: models.DrainAtExpiry, UpdatedAt: drainedAt},
+		"a": {Name: "a", Draining: false}, // undrained hosts keep a row
+	}
+	counts := map[string]int{"a": 3, "b": 6, "c": 2, "gone": 5}
+
+	infos := summarizeHosts(hosts, states, counts)
+	if len(infos) != 3 {
+		t.Fatalf("expected 3 hosts, got %d", len(infos))
+	}
+
+	a, b, c := infos[0], infos[1], infos[2]
+
+	if a.Sandboxes != 3 || a.Available != 7 || !a.Schedulable || a.Draining {
+		t.Errorf("host a: unexpected %+v", a)
+	}
+	// Over capacity: no negative availability
+	if b.Sandboxes != 6 || b.Available != 0 || b.Schedulable {
+		t.Errorf("host b: unexpected %+v", b)
+	}
+	// Unlimited but draining
+	if c.Available != 0 || c.Schedulable || !c.Draining || c.DrainMode != models.DrainAtExpiry || c.DrainedAt == nil || !c.DrainedAt.Equal(drainedAt) {
+		t.Errorf("host c: unexpected %+v", c)
+	}
+}
+
+func TestPickHost(t *testing.T) {
+	tests := []struct {
+		name  string
+		hosts []*models.HostInfo
+		want  string
+	}{
+		{
+			name: "fewest sandboxes",
+			hosts: []*models.HostInfo{
+				{Name: "a", Sandboxes: 5, Schedulable: true},
+				{Name: "b", Sandboxes: 2, Schedulable: true},
+			},
+			want: "b",
+		},
+		{
+			name: "skips unschedulable",
+			hosts: []*models.HostInfo{
+				{Name: "a", Sandboxes: 0, Draining: true},
+				{Name: "b", Sandboxes: 9, Schedulable: true},
+			},
+			want: "b",
+		},
+		{
+			name: "ties broken by name",
+			hosts: []*models.HostInfo{
+				{Name: "b", Sandboxes: 1, Schedulable: true},
+				{Name: "a", Sandboxes: 1, Schedulable: true},
+			},
+			want: "a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := pickHost(tt.hosts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestPickHostNoneSchedulable(t *testing.T) {
+	hosts := summarizeHosts(
+		[]dockerHost{{name: "only", capacity: 1}},
+		map[string]*models.HostState{},
+		map[string]int{"only": 1},
+	)
+
+	if _, err := pickHost(hosts); !errors.Is(err, ErrNoSchedulableHost) {
+		t.Fatalf("expected ErrNoSchedulableHost for a full host, got %v", err)
+	}
+	if _, err := pickHost(nil); !errors.Is(err, ErrNoSchedulableHost) {
+		t.Fatalf("expected ErrNoSchedulableHost without hosts, got %v", err)
+	}
+}
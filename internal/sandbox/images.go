@@ -0,0 +1,275 @@
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/client"
+
+	"github.com/terra-clan/sandbox-engine/internal/config"
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/templates"
+)
+
+// defaultPrePullWorkers is the pre-pull concurrency when none is configured
+const defaultPrePullWorkers = 3
+
+// imagePuller deduplicates concurrent pulls of the same image and remembers
+// which images are known to be present locally
+type imagePuller struct {
+	pull func(ctx context.Context, image string) error
+
+	mu       sync.Mutex
+	inFlight map[string]*pullCall
+	ready    map[string]bool
+}
+
+// pullCall is a pull in progress that other callers can wait on
+type pullCall struct {
+	done chan struct{}
+	err  error
+}
+
+func newImagePuller(pull func(ctx context.Context, image string) error) *imagePuller {
+	return &imagePuller{
+		pull:     pull,
+		inFlight: make(map[string]*pullCall),
+		ready:    make(map[string]bool),
+	}
+}
+
+// ensure pulls image unless another caller is already pulling it, in which
+// case it waits for that pull and returns its result
+func (p *imagePuller) ensure(ctx context.Context, image string) error {
+	p.mu.Lock()
+	if call, ok := p.inFlight[image]; ok {
+		p.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	call := &pullCall{done: make(chan struct{})}
+	p.inFlight[image] = call
+	p.mu.Unlock()
+
+	call.err = p.pull(ctx, image)
+
+	p.mu.Lock()
+	delete(p.inFlight, image)
+	if call.err == nil {
+		p.ready[image] = true
+	}
+	p.mu.Unlock()
+	close(call.done)
+
+	return call.err
+}
+
+// state reports whether image is known to be present and whether a pull is running
+func (p *imagePuller) state(image string) (ready, pulling bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, pulling = p.inFlight[image]
+	return p.ready[image], pulling
+}
+
+// markReady records that image is present locally
+func (p *imagePuller) markReady(image string) {
+	p.mu.Lock()
+	p.ready[image] = true
+	p.mu.Unlock()
+}
+
+// prePull pulls images with at most workers concurrent pulls
+func (p *imagePuller) prePull(ctx context.Context, images []string, workers int) {
+	if workers <= 0 {
+		workers = defaultPrePullWorkers
+	}
+
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for image := range jobs {
+				start := time.Now()
+				if err := p.ensure(ctx, image); err != nil {
+					slog.Warn("image pre-pull failed", "image", image, "error", err)
+					continue
+				}
+				slog.Info("image pre-pulled", "image", image, "duration", time.Since(start).Round(time.Millisecond))
+			}
+		}()
+	}
+
+	for _, image := range images {
+		select {
+		case jobs <- image:
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// templateImages returns the distinct base images of templates, sorted
+func templateImages(tmpls []*models.Template) []string {
+	seen := make(map[string]bool)
+	var images []string
+	for _, t := range tmpls {
+		if t.BaseImage == "" || seen[t.BaseImage] {
+			continue
+		}
+		seen[t.BaseImage] = true
+		images = append(images, t.BaseImage)
+	}
+	sort.Strings(images)
+	return images
+}
+
+// PrePullImages pulls the base images of all loaded templates in the background.
+// It is a no-op unless pre-pulling is enabled.
+func (m *DockerManager) PrePullImages(ctx context.Context) {
+	if !m.config.PrePull || m.config.PullPolicy == "never" {
+		return
+	}
+
+	images := templateImages(m.templateLoader.List())
+	if len(images) == 0 {
+		return
+	}
+
+	slog.Info("pre-pulling template images", "count", len(images), "workers", m.config.PrePullWorkers)
+
+	go func() {
+		start := time.Now()
+		m.images.prePull(ctx, images, m.config.PrePullWorkers)
+		slog.Info("template image pre-pull finished", "count", len(images), "duration", time.Since(start).Round(time.Second))
+	}()
+}
+
+// ImageReady reports whether a template image is present locally, so creating
+// a sandbox from it won't wait on a pull
+func (m *DockerManager) ImageReady(ctx context.Context, image string) bool {
+	ready, pulling := m.images.state(image)
+	if ready {
+		return true
+	}
+	if pulling {
+		return false
+	}
+
+	// Not seen yet: the image may have been pulled outside the engine
+	if _, _, err := m.docker.ImageInspectWithRaw(ctx, image); err == nil {
+		m.images.markReady(image)
+		return true
+	}
+	return false
+}
+
+// imageCheckTimeout bounds the lookup of one image by the image validator
+const imageCheckTimeout = 15 * time.Second
+
+// imageFailureTTL is how long the image validator reuses a failed lookup, so
+// a reload doesn't wait on the registry again for every broken template
+const imageFailureTTL = 5 * time.Minute
+
+// imageValidator reports template base images that can't be resolved
+type imageValidator struct {
+	local  func(ctx context.Context, image string) error
+	remote func(ctx context.Context, image string) error // nil when images are never pulled
+	now    func() time.Time
+
+	mu       sync.Mutex
+	resolved map[string]bool
+	failed   map[string]imageFailure
+}
+
+// imageFailure is a failed image lookup and when it was made
+type imageFailure struct {
+	err error
+	at  time.Time
+}
+
+// NewImageValidator returns a template validator that checks base images
+// resolve: present locally, or with a manifest in their registry unless the
+// pull policy is never. It has its own Docker client since templates load
+// before the manager exists.
+func NewImageValidator(cfg config.DockerConfig) (templates.Validator, error) {
+	cli, err := client.NewClientWithOpts(
+		client.WithHost(cfg.Host),
+		client.WithAPIVersionNegotiation(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create docker client: %w", err)
+	}
+
+	v := &imageValidator{
+		local: func(ctx context.Context, image string) error {
+			_, _, err := cli.ImageInspectWithRaw(ctx, image)
+			return err
+		},
+		now:      time.Now,
+		resolved: make(map[string]bool),
+		failed:   make(map[string]imageFailure),
+	}
+	if cfg.PullPolicy != "never" {
+		v.remote = func(ctx context.Context, image string) error {
+			_, err := cli.DistributionInspect(ctx, image, "")
+			return err
+		}
+	}
+	return v, nil
+}
+
+// ValidateTemplate implements templates.Validator. Images found once are not
+// looked up again, and those not found aren't for imageFailureTTL.
+func (v *imageValidator) ValidateTemplate(tmpl *models.Template) []templates.Issue {
+	v.mu.Lock()
+	resolved := v.resolved[tmpl.BaseImage]
+	failure, failed := v.failed[tmpl.BaseImage]
+	v.mu.Unlock()
+	if resolved {
+		return nil
+	}
+	if failed && v.now().Sub(failure.at) < imageFailureTTL {
+		return imageIssues(tmpl.BaseImage, failure.err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), imageCheckTimeout)
+	defer cancel()
+
+	err := v.local(ctx, tmpl.BaseImage)
+	if err != nil && v.remote != nil {
+		err = v.remote(ctx, tmpl.BaseImage)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if err != nil {
+		v.failed[tmpl.BaseImage] = imageFailure{err: err, at: v.now()}
+		return imageIssues(tmpl.BaseImage, err)
+	}
+	delete(v.failed, tmpl.BaseImage)
+	v.resolved[tmpl.BaseImage] = true
+	return nil
+}
+
+// imageIssues reports an image that failed to resolve
+func imageIssues(image string, err error) []templates.Issue {
+	return []templates.Issue{{
+		Field:    "base_image",
+		Severity: templates.SeverityError,
+		Message:  fmt.Sprintf("image %s does not resolve: %v", image, err),
+	}}
+}
@@ -0,0 +1,154 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+func TestImagePullerDeduplicates(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	p := newImagePuller(func(ctx context.Context, image string) error {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := p.ensure(context.Background(), "workspace:latest"); err != nil {
+				t.Errorf("ensure failed: %v", err)
+			}
+		}()
+	}
+
+	// Wait until the first pull is running before releasing it
+	for {
+		if _, pulling := p.state("workspace:latest"); pulling {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// Give the other callers time to join the in-flight pull
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Fatalf("expected a single pull, got %d", calls)
+	}
+	if ready, pulling := p.state("workspace:latest"); !ready || pulling {
+		t.Fatalf("expected image ready after pull, got ready=%v pulling=%v", ready, pulling)
+	}
+}
+
+func TestImagePullerFailureNotReady(t *testing.T) {
+	p := newImagePuller(func(ctx context.Context, image string) error {
+		return errors.New("registry unreachable")
+	})
+
+	if err := p.ensure(context.Background(), "broken:1"); err == nil {
+		t.Fatalf("expected pull error")
+	}
+	if ready, _ := p.state("broken:1"); ready {
+		t.Fatalf("failed pull must not mark the image ready")
+	}
+}
+
+func TestPrePullBoundedWorkers(t *testing.T) {
+	var active, peak int32
+	p := newImagePuller(func(ctx context.Context, image string) error {
+		n := atomic.AddInt32(&active, 1)
+		defer atomic.AddInt32(&active, -1)
+		for {
+			cur := atomic.LoadInt32(&peak)
+			if n <= cur || atomic.CompareAndSwapInt32(&peak, cur, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		return nil
+	})
+
+	images := templateImages([]*models.Template{
+		{BaseImage: "a"}, {BaseImage: "b"}, {BaseImage: "a"}, {BaseImage: "c"}, {BaseImage: "d"}, {BaseImage: ""},
+	})
+	if len(images) != 4 {
+		t.Fatalf("expected 4 distinct images, got %v", images)
+	}
+
+	p.prePull(context.Background(), images, 2)
+
+	if peak > 2 {
+		t.Fatalf("expected at most 2 concurrent pulls, got %d", peak)
+	}
+	for _, image := range images {
+		if ready, _ := p.state(image); !ready {
+			t.Fatalf("expected %s ready", image)
+		}
+	}
+}
+
+func TestImageValidator(t *testing.T) {
+	var remoteCalls int32
+	notFound := errors.New("not found")
+	now := time.Now()
+	v := &imageValidator{
+		now: func() time.Time { return now },
+		local: func(ctx context.Context, image string) error {
+			if image == "local:1" {
+				return nil
+			}
+			return notFound
+		},
+		remote: func(ctx context.Context, image string) error {
+			atomic.AddInt32(&remoteCalls, 1)
+			if image == "remote:1" {
+				return nil
+			}
+			return notFound
+		},
+		resolved: make(map[string]bool),
+		failed:   make(map[string]imageFailure),
+	}
+
+	for _, image := range []string{"local:1", "remote:1", "remote:1"} {
+		if issues := v.ValidateTemplate(&models.Template{BaseImage: image}); len(issues) != 0 {
+			t.Errorf("%s: unexpected issues %v", image, issues)
+		}
+	}
+	if remoteCalls != 1 {
+		t.Errorf("expected a resolved image not looked up again, got %d registry lookups", remoteCalls)
+	}
+
+	issues := v.ValidateTemplate(&models.Template{BaseImage: "typo:1"})
+	if len(issues) != 1 || issues[0].Field != "base_image" {
+		t.Errorf("expected a base_image issue, got %v", issues)
+	}
+
+	// A failed lookup is reused until it is imageFailureTTL old
+	atomic.StoreInt32(&remoteCalls, 0)
+	for _, elapsed := range []time.Duration{0, imageFailureTTL - time.Second, imageFailureTTL} {
+		now = now.Add(elapsed)
+		if issues := v.ValidateTemplate(&models.Template{BaseImage: "typo:1"}); len(issues) != 1 {
+			t.Errorf("after %v: expected the base_image issue, got %v", elapsed, issues)
+		}
+	}
+	if remoteCalls != 1 {
+		t.Errorf("expected one registry lookup after the failure expired, got %d", remoteCalls)
+	}
+
+	v.remote = nil
+	if issues := v.ValidateTemplate(&models.Template{BaseImage: "remote:2"}); len(issues) != 1 {
+		t.Errorf("expected only local images to resolve without a registry lookup, got %v", issues)
+	}
+}
@@ -0,0 +1,313 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+// ErrTooManySandboxes is returned when a bulk operation matches more sandboxes than allowed
+var ErrTooManySandboxes = errors.New("too many sandboxes selected")
+
+const (
+	// MaxLogExportSandboxes caps how many sandboxes a single log export may cover
+	MaxLogExportSandboxes = 200
+	// DefaultLogExportBytes is how much of each container's log tail is exported by default
+	DefaultLogExportBytes = 1 << 20
+	// maxLogExportBytes bounds the per-sandbox tail so memory stays bounded
+	maxLogExportBytes = 16 << 20
+	// defaultLogExportConcurrency is how many containers are read from Docker at once
+	defaultLogExportConcurrency = 4
+)
+
+// LogOptions selects the part of a sandbox log to read
+type LogOptions struct {
+	// Tail is how many lines from the end are returned (0 returns them all)
+	Tail int
+	// Since drops lines logged before it, when set
+	Since time.Time
+	// Timestamps prefixes each line with its RFC 3339 timestamp
+	Timestamps bool
+}
+
+// dockerOptions converts opts to Docker log options
+func (opts LogOptions) dockerOptions(follow bool) container.LogsOptions {
+	options := container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     follow,
+		Timestamps: opts.Timestamps,
+	}
+	if opts.Tail > 0 {
+		options.Tail = strconv.Itoa(opts.Tail)
+	}
+	if !opts.Since.IsZero() {
+		options.Since = opts.Since.UTC().Format(time.RFC3339Nano)
+	}
+	return options
+}
+
+// StreamLogs opens the log of a sandbox container and keeps it open, returning
+// new output as the container writes it. Closing the reader, or cancelling ctx,
+// stops the Docker stream. The output is the plain log text, demultiplexed for
+// containers without a TTY.
+func (m *DockerManager) StreamLogs(ctx context.Context, id string, opts LogOptions) (io.ReadCloser, error) {
+	return m.openLogs(ctx, id, opts, true)
+}
+
+// FollowLogs copies the log of sandbox id into stdout and stderr, following
+// new output until ctx is cancelled or the container stops. TTY containers
+// have a single stream, which all goes to stdout.
+func (m *DockerManager) FollowLogs(ctx context.Context, id string, opts LogOptions, stdout, stderr io.Writer) error {
+	logs, tty, err := m.openRawLogs(ctx, id, opts, true)
+	if err != nil {
+		return err
+	}
+	defer logs.Close()
+
+	if tty {
+		_, err = io.Copy(stdout, logs)
+	} else {
+		_, err = stdcopy.StdCopy(stdout, stderr, logs)
+	}
+	if err != nil && ctx.Err() == nil {
+		return fmt.Errorf("failed to read logs: %w", err)
+	}
+	return nil
+}
+
+// openLogs opens the log stream of sandbox id as plain text
+func (m *DockerManager) openLogs(ctx context.Context, id string, opts LogOptions, follow bool) (io.ReadCloser, error) {
+	logs, tty, err := m.openRawLogs(ctx, id, opts, follow)
+	if err != nil {
+		return nil, err
+	}
+	return demuxLogs(logs, tty), nil
+}
+
+// openRawLogs opens the Docker log stream of sandbox id and reports whether
+// the container has a TTY, i.e. whether the stream is raw rather than framed
+func (m *DockerManager) openRawLogs(ctx context.Context, id string, opts LogOptions, follow bool) (io.ReadCloser, bool, error) {
+	sb, err := m.repo.GetSandbox(ctx, id)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get sandbox: %w", err)
+	}
+	if sb == nil {
+		return nil, false, ErrSandboxNotFound
+	}
+	if sb.ContainerID == "" {
+		return io.NopCloser(strings.NewReader("")), true, nil
+	}
+
+	info, err := m.docker.ContainerInspect(ctx, sb.ContainerID)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to inspect container: %w", err)
+	}
+
+	logs, err := m.docker.ContainerLogs(ctx, sb.ContainerID, opts.dockerOptions(follow))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get logs: %w", err)
+	}
+	return logs, info.Config != nil && info.Config.Tty, nil
+}
+
+// demuxLogs returns the log text of a Docker log stream. TTY containers log
+// raw output; others multiplex stdout and stderr behind 8-byte frame headers,
+// which are stripped here with both streams merged in order.
+func demuxLogs(logs io.ReadCloser, tty bool) io.ReadCloser {
+	if tty {
+		return logs
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := stdcopy.StdCopy(pw, pw, logs)
+		pw.CloseWithError(err)
+	}()
+	return &demuxedLogs{PipeReader: pr, logs: logs}
+}
+
+// demuxedLogs closes the Docker stream along with the demultiplexed reader,
+// which also ends the copying goroutine
+type demuxedLogs struct {
+	*io.PipeReader
+	logs io.Closer
+}
+
+func (d *demuxedLogs) Close() error {
+	d.PipeReader.Close()
+	return d.logs.Close()
+}
+
+// LogExportOptions controls a bulk log export
+type LogExportOptions struct {
+	// MaxBytes is how many bytes from the end of each log are kept
+	MaxBytes int64
+	// Concurrency is the number of logs fetched from Docker in parallel
+	Concurrency int
+}
+
+// LogExport is the result of exporting one sandbox's logs
+type LogExport struct {
+	SandboxID string
+	Data      []byte
+	Truncated bool // earlier output was dropped to stay within MaxBytes
+	Err       error
+}
+
+// logOpener opens the log stream of a sandbox container
+type logOpener func(ctx context.Context, sb *models.Sandbox) (io.ReadCloser, error)
+
+// ExportLogs reads the log tail of every sandbox matching filters and passes the
+// results to yield one at a time, in listing order. Logs are fetched with bounded
+// concurrency, and fetching stalls while yield is busy, so at most Concurrency
+// results are held in memory.
+func (m *DockerManager) ExportLogs(ctx context.Context, filters models.ListFilters, opts LogExportOptions, yield func(*LogExport) error) error {
+	// Fetch one past the cap to detect overflow
+	filters.Limit = MaxLogExportSandboxes + 1
+	sandboxes, err := m.repo.ListSandboxes(ctx, filters)
+	if err != nil {
+		return fmt.Errorf("failed to list sandboxes: %w", err)
+	}
+
+	if len(sandboxes) > MaxLogExportSandboxes {
+		return ErrTooManySandboxes
+	}
+
+	return exportLogs(ctx, sandboxes, opts, m.openContainerLogs, yield)
+}
+
+// openContainerLogs opens the full log stream of a sandbox container
+func (m *DockerManager) openContainerLogs(ctx context.Context, sb *models.Sandbox) (io.ReadCloser, error) {
+	if sb.ContainerID == "" {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+
+	return m.docker.ContainerLogs(ctx, sb.ContainerID, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+	})
+}
+
+// exportLogs implements ExportLogs over an arbitrary log source
+func exportLogs(ctx context.Context, sandboxes []*models.Sandbox, opts LogExportOptions, open logOpener, yield func(*LogExport) error) error {
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultLogExportBytes
+	}
+	if opts.MaxBytes > maxLogExportBytes {
+		opts.MaxBytes = maxLogExportBytes
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = defaultLogExportConcurrency
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Slots are taken when a fetch starts and returned once its result is yielded
+	slots := make(chan struct{}, opts.Concurrency)
+	results := make(chan chan *LogExport, opts.Concurrency)
+
+	var wg sync.WaitGroup
+	go func() {
+		defer close(results)
+		for _, sb := range sandboxes {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			out := make(chan *LogExport, 1)
+			results <- out
+
+			wg.Add(1)
+			go func(sb *models.Sandbox) {
+				defer wg.Done()
+				out <- readLogTail(ctx, sb, opts.MaxBytes, open)
+			}(sb)
+		}
+	}()
+
+	var yieldErr error
+	for out := range results {
+		res := <-out
+		<-slots
+
+		if yieldErr != nil {
+			continue // drain so the producer can exit
+		}
+		if err := yield(res); err != nil {
+			yieldErr = err
+			cancel()
+		}
+	}
+
+	wg.Wait()
+
+	if yieldErr != nil {
+		return yieldErr
+	}
+	return ctx.Err()
+}
+
+// readLogTail reads a sandbox log stream keeping only the last maxBytes
+func readLogTail(ctx context.Context, sb *models.Sandbox, maxBytes int64, open logOpener) *LogExport {
+	res := &LogExport{SandboxID: sb.ID}
+
+	rc, err := open(ctx, sb)
+	if err != nil {
+		res.Err = fmt.Errorf("failed to open logs: %w", err)
+		return res
+	}
+	defer rc.Close()
+
+	tail := &tailBuffer{max: int(maxBytes)}
+	if _, err := io.Copy(tail, rc); err != nil {
+		res.Err = fmt.Errorf("failed to read logs: %w", err)
+		return res
+	}
+
+	res.Data = tail.Bytes()
+	res.Truncated = tail.dropped
+	return res
+}
+
+// tailBuffer is an io.Writer that retains only the last max bytes written.
+// It compacts lazily so that long logs are not copied on every write.
+type tailBuffer struct {
+	max     int
+	buf     []byte
+	dropped bool
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > 2*t.max {
+		t.compact()
+	}
+	return len(p), nil
+}
+
+// Bytes returns the retained tail
+func (t *tailBuffer) Bytes() []byte {
+	t.compact()
+	return t.buf
+}
+
+func (t *tailBuffer) compact() {
+	if over := len(t.buf) - t.max; over > 0 {
+		t.buf = append(t.buf[:0], t.buf[over:]...)
+		t.dropped = true
+	}
+}
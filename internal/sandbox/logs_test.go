@@ -0,0 +1,199 @@
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/pkg/stdcopy"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+// failingReader returns some data and then an error
+type failingReader struct {
+	data []byte
+	done bool
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.done {
+		return 0, errors.New("connection reset")
+	}
+	r.done = true
+	return copy(p, r.data), nil
+}
+
+func fakeLogs(logs map[string]string, failing map[string]bool) logOpener {
+	return func(ctx context.Context, sb *models.Sandbox) (io.ReadCloser, error) {
+		if failing[sb.ID] {
+			return io.NopCloser(&failingReader{data: []byte("partial")}), nil
+		}
+		data, ok := logs[sb.ID]
+		if !ok {
+			return nil, errors.New("no such container")
+		}
+		return io.NopCloser(strings.NewReader(data)), nil
+	}
+}
+
+func TestExportLogs(t *testing.T) {
+	sandboxes := []*models.Sandbox{{ID: "a"}, {ID: "b"}, {ID: "c"}, {ID: "d"}}
+	open := fakeLogs(map[string]string{
+		"a": "hello",
+		"b": "0123456789",
+	}, map[string]bool{"c": true})
+
+	var got []*LogExport
+	err := exportLogs(context.Background(), sandboxes, LogExportOptions{MaxBytes: 4, Concurrency: 2}, open, func(res *LogExport) error {
+		got = append(got, res)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+
+	if len(got) != 4 {
+		t.Fatalf("expected 4 results, got %d", len(got))
+	}
+	for i, sb := range sandboxes {
+		if got[i].SandboxID != sb.ID {
+			t.Fatalf("results out of order: %d is %s", i, got[i].SandboxID)
+		}
+	}
+
+	if string(got[0].Data) != "ello" {
+		t.Fatalf("unexpected tail for a: %q", got[0].Data)
+	}
+	if string(got[1].Data) != "6789" || !got[1].Truncated {
+		t.Fatalf("expected truncated tail 6789 for b, got %q truncated=%v", got[1].Data, got[1].Truncated)
+	}
+	if got[2].Err == nil {
+		t.Fatalf("expected mid-stream error for c")
+	}
+	if got[3].Err == nil {
+		t.Fatalf("expected open error for d")
+	}
+}
+
+func TestExportLogsBoundedConcurrency(t *testing.T) {
+	var sandboxes []*models.Sandbox
+	for _, id := range []string{"1", "2", "3", "4", "5", "6", "7", "8"} {
+		sandboxes = append(sandboxes, &models.Sandbox{ID: id})
+	}
+
+	var active, peak int32
+	open := func(ctx context.Context, sb *models.Sandbox) (io.ReadCloser, error) {
+		n := atomic.AddInt32(&active, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		return &countingCloser{Reader: strings.NewReader("x"), active: &active}, nil
+	}
+
+	err := exportLogs(context.Background(), sandboxes, LogExportOptions{Concurrency: 2}, open, func(res *LogExport) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	if peak > 2 {
+		t.Fatalf("expected at most 2 concurrent reads, got %d", peak)
+	}
+}
+
+func TestExportLogsStopsOnYieldError(t *testing.T) {
+	sandboxes := []*models.Sandbox{{ID: "a"}, {ID: "b"}, {ID: "c"}}
+	open := fakeLogs(map[string]string{"a": "1", "b": "2", "c": "3"}, nil)
+
+	errWrite := errors.New("client went away")
+	calls := 0
+	err := exportLogs(context.Background(), sandboxes, LogExportOptions{Concurrency: 1}, open, func(res *LogExport) error {
+		calls++
+		return errWrite
+	})
+	if !errors.Is(err, errWrite) {
+		t.Fatalf("expected yield error, got %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected yield to stop after the error, got %d calls", calls)
+	}
+}
+
+func TestTailBuffer(t *testing.T) {
+	tail := &tailBuffer{max: 5}
+	for i := 0; i < 100; i++ {
+		tail.Write([]byte("ab"))
+	}
+	if got := tail.Bytes(); !bytes.Equal(got, []byte("babab")) || !tail.dropped {
+		t.Fatalf("unexpected tail %q dropped=%v", got, tail.dropped)
+	}
+}
+
+type countingCloser struct {
+	io.Reader
+	active *int32
+}
+
+func (c *countingCloser) Close() error {
+	atomic.AddInt32(c.active, -1)
+	return nil
+}
+
+func TestDemuxLogs(t *testing.T) {
+	var muxed bytes.Buffer
+	stdcopy.NewStdWriter(&muxed, stdcopy.Stdout).Write([]byte("out 1\n"))
+	stdcopy.NewStdWriter(&muxed, stdcopy.Stderr).Write([]byte("err 1\n"))
+	stdcopy.NewStdWriter(&muxed, stdcopy.Stdout).Write([]byte("out 2\n"))
+
+	rc := demuxLogs(io.NopCloser(bytes.NewReader(muxed.Bytes())), false)
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "out 1\nerr 1\nout 2\n" {
+		t.Errorf("expected the frames merged in order without headers, got %q", data)
+	}
+
+	// TTY output is raw and passed through untouched
+	raw := demuxLogs(io.NopCloser(strings.NewReader("\x01raw")), true)
+	if data, _ := io.ReadAll(raw); string(data) != "\x01raw" {
+		t.Errorf("expected raw TTY output, got %q", data)
+	}
+}
+
+func TestDemuxLogsCloseStopsStream(t *testing.T) {
+	pr, pw := io.Pipe()
+	rc := demuxLogs(pr, false)
+
+	// A followed log that never ends; closing must not wait for it
+	go stdcopy.NewStdWriter(pw, stdcopy.Stdout).Write([]byte("line\n"))
+	buf := make([]byte, 16)
+	if n, err := rc.Read(buf); err != nil || string(buf[:n]) != "line\n" {
+		t.Fatalf("expected the first line, got %q, %v", buf[:n], err)
+	}
+	rc.Close()
+	if _, err := pw.Write([]byte("more")); err == nil {
+		t.Error("expected the Docker stream closed")
+	}
+}
+
+func TestLogOptionsDockerOptions(t *testing.T) {
+	since := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	opts := LogOptions{Since: since, Timestamps: true}.dockerOptions(true)
+	if !opts.Follow || !opts.Timestamps || opts.Tail != "" || opts.Since != "2026-01-02T15:04:05Z" {
+		t.Errorf("unexpected options %+v", opts)
+	}
+	if opts := (LogOptions{Tail: 50}).dockerOptions(false); opts.Follow || opts.Tail != "50" || opts.Since != "" {
+		t.Errorf("unexpected options %+v", opts)
+	}
+}
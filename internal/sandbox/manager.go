@@ -2,60 +2,183 @@ package sandbox
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"net/http"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
 	"github.com/docker/go-connections/nat"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
 
 	"github.com/terra-clan/sandbox-engine/internal/config"
+	"github.com/terra-clan/sandbox-engine/internal/metrics"
 	"github.com/terra-clan/sandbox-engine/internal/models"
 	"github.com/terra-clan/sandbox-engine/internal/services"
 	"github.com/terra-clan/sandbox-engine/internal/storage"
 	"github.com/terra-clan/sandbox-engine/internal/templates"
+	"github.com/terra-clan/sandbox-engine/internal/tracing"
 )
 
 // Common errors
 var (
-	ErrSandboxNotFound  = errors.New("sandbox not found")
-	ErrTemplateNotFound = errors.New("template not found")
-	ErrSandboxExpired   = errors.New("sandbox has expired")
-	ErrSandboxStopped   = errors.New("sandbox is already stopped")
-	ErrSessionNotFound  = errors.New("session not found")
-	ErrSessionNotReady  = errors.New("session is not in ready state")
+	ErrSandboxNotFound      = errors.New("sandbox not found")
+	ErrTemplateNotFound     = errors.New("template not found")
+	ErrTemplateDisabled     = errors.New("template is disabled")
+	ErrTaskNotFound         = errors.New("task not found")
+	ErrSandboxExpired       = errors.New("sandbox has expired")
+	ErrSandboxStopped       = errors.New("sandbox is already stopped")
+	ErrSandboxNotActive     = errors.New("sandbox is not active")
+	ErrSandboxRunning       = errors.New("sandbox is already running")
+	ErrSessionNotFound      = errors.New("session not found")
+	ErrSessionNotReady      = errors.New("session is not in ready state")
+	ErrSessionConflict      = errors.New("another session with the same unique key is active")
+	ErrSessionNotActive     = errors.New("session is not active")
+	ErrSessionRevoked       = errors.New("session has been revoked")
+	ErrSessionChanged       = errors.New("session changed concurrently, try again")
+	ErrSessionSubmitted     = errors.New("session has already been submitted")
+	ErrNoSubmission         = errors.New("session has no submission")
+	ErrSubmissionTooLarge   = errors.New("workspace exceeds the submission size limit")
+	ErrPrewarmTooLate       = errors.New("prewarm_at must be before the join deadline")
+	ErrCallbackNotAllowed   = errors.New("callback_url must not point to a loopback, private or link-local address")
+	ErrServiceNotFound      = errors.New("service not found")
+	ErrCheckUnsupported     = errors.New("service provider does not support credential checks")
+	ErrServiceNotReady      = errors.New("service is still provisioning")
+	ErrRotateUnsupported    = errors.New("service provider does not support credential rotation")
+	ErrDeprovisionFailed    = errors.New("failed to deprovision sandbox services")
+	ErrRecordingNotFound    = errors.New("recording not found")
+	ErrTerminalCommand      = errors.New("template does not allow terminal commands")
+	ErrFileNotFound         = errors.New("file not found")
+	ErrNotAFile             = errors.New("path is not a regular file")
+	ErrExecTimeout          = errors.New("command timed out")
+	ErrTemplateExists       = errors.New("template already exists")
+	ErrTemplateReadOnly     = errors.New("template is defined in the templates directory")
+	ErrTemplateInvalid      = errors.New("template is invalid")
+	ErrTemplateInUse        = errors.New("template is used by active sandboxes")
+	ErrGradingNotConfigured = errors.New("sandbox has no task with grading")
+
+	ErrCommandOverrideNotAllowed = errors.New("template does not allow overriding the command")
 )
 
 // Manager defines the interface for sandbox management
 type Manager interface {
 	Create(ctx context.Context, templateID, userID string, opts CreateOptions) (*models.Sandbox, error)
 	Get(ctx context.Context, id string) (*models.Sandbox, error)
+	Start(ctx context.Context, id string) error
 	Stop(ctx context.Context, id string) error
+	StopIdle(ctx context.Context, id string) error
 	Delete(ctx context.Context, id string) error
+	ForceDelete(ctx context.Context, id string) error
+	BulkDelete(ctx context.Context, opts BulkDeleteOptions) ([]*BulkDeleteResult, error)
+	PurgeDeleted(ctx context.Context, retention time.Duration) (int64, error)
+	DeactivateExpiredClients(ctx context.Context) ([]*models.ApiClient, error)
 	List(ctx context.Context, filters models.ListFilters) ([]*models.Sandbox, error)
+	Count(ctx context.Context, filters models.ListFilters) (int, error)
 	ExtendTTL(ctx context.Context, id string, duration time.Duration) error
-	GetLogs(ctx context.Context, id string, tail int) (string, error)
-	ExecAttach(ctx context.Context, containerID string) (string, io.ReadWriteCloser, error)
+	AutoExtendTTL(ctx context.Context, id string) (*time.Time, error)
+	GetLogs(ctx context.Context, id string, opts LogOptions) (string, error)
+	StreamLogs(ctx context.Context, id string, opts LogOptions) (io.ReadCloser, error)
+	FollowLogs(ctx context.Context, id string, opts LogOptions, stdout, stderr io.Writer) error
+	ImageReady(ctx context.Context, image string) bool
+	ExportLogs(ctx context.Context, filters models.ListFilters, opts LogExportOptions, yield func(*LogExport) error) error
+	GetServices(ctx context.Context, id string) ([]*models.ServiceInstance, error)
+	GetService(ctx context.Context, id, name string) (*models.ServiceInstance, error)
+	CheckService(ctx context.Context, id, name string) (*models.ServiceInstance, error)
+	RotateServiceCredentials(ctx context.Context, id, name string) (*models.ServiceInstance, error)
+	TerminalOptions(sb *models.Sandbox, command []string) (ExecAttachOptions, error)
+	ExecAttach(ctx context.Context, containerID string, opts ExecAttachOptions) (string, io.ReadWriteCloser, error)
 	ExecResize(ctx context.Context, execID string, height, width uint) error
+	ExecExit(ctx context.Context, containerID, execID string) (*ExecExit, error)
+	Exec(ctx context.Context, sb *models.Sandbox, req models.ExecRequest) (*models.ExecResult, error)
+	UploadFile(ctx context.Context, sb *models.Sandbox, filePath string, r io.Reader, size int64) error
+	DownloadFile(ctx context.Context, containerID, filePath string) (io.ReadCloser, int64, error)
+	Stats(ctx context.Context, containerID string) (*models.SandboxStats, error)
 	Ping(ctx context.Context) error
-	GetExpired(ctx context.Context) ([]*models.Sandbox, error)
+	ListManagedContainers(ctx context.Context) ([]*models.ManagedContainer, error)
+	PruneOrphans(ctx context.Context, dryRun bool) (*models.OrphanReport, error)
+	ReconcileProviders(ctx context.Context, dryRun bool) (*models.ProviderReconcileReport, error)
+	HealthDetails(ctx context.Context) map[string]*models.ComponentHealth
+	ServiceProviders() []string
+	GetExpired(ctx context.Context, limit int) ([]*models.Sandbox, error)
+	GetDeleting(ctx context.Context, limit int) ([]*models.Sandbox, error)
+	CountExpired(ctx context.Context) (sandboxes, sessions int, err error)
+	GetIdle(ctx context.Context, idleFor time.Duration) ([]*models.Sandbox, error)
+	FailStuckProvisioning(ctx context.Context) (int, error)
+	RecordActivity(ctx context.Context, id string) error
 	Close() error
 
+	// Sandbox events
+	RecordEvent(ctx context.Context, id string, typ models.SandboxEventType, msg string)
+	ListEvents(ctx context.Context, id string) ([]*models.SandboxEvent, error)
+	PurgeEvents(ctx context.Context, retention time.Duration) (int64, error)
+
+	// Docker hosts
+	ListHosts(ctx context.Context) ([]*models.HostInfo, error)
+	DrainHost(ctx context.Context, name string, mode models.DrainMode) (*models.HostInfo, []*models.Sandbox, error)
+	UndrainHost(ctx context.Context, name string) (*models.HostInfo, error)
+
+	// Templates created through the API
+	CreateTemplate(ctx context.Context, data []byte) (*models.Template, []templates.Issue, error)
+	UpdateTemplate(ctx context.Context, name string, data []byte) (*models.Template, []templates.Issue, error)
+	DeleteTemplate(ctx context.Context, name string) error
+
+	// Template usage
+	TemplateUsage(ctx context.Context, from, to time.Time) (*models.UsageReport, error)
+	TemplateUsageSummary(ctx context.Context, templateID string) *models.UsageSummary
+	TaskUsageSummary(ctx context.Context, taskID string) *models.UsageSummary
+
 	// Sessions
 	CreateSession(ctx context.Context, req models.CreateSessionRequest, createdBy string) (*models.Session, error)
+	CreateSessions(ctx context.Context, req models.BulkCreateSessionsRequest, createdBy string) ([]*BulkSessionResult, error)
 	GetSessionByToken(ctx context.Context, token string) (*models.Session, error)
 	GetSessionByID(ctx context.Context, id string) (*models.Session, error)
 	ActivateSession(ctx context.Context, token string) (*models.Session, error)
 	DeleteSession(ctx context.Context, id string) error
-	ListSessions(ctx context.Context, status string, limit, offset int) ([]*models.Session, error)
-	GetExpiredSessions(ctx context.Context) ([]*models.Session, error)
+	ExtendSession(ctx context.Context, id string, duration time.Duration) (*models.Session, error)
+	RevokeSession(ctx context.Context, id, revokedBy string) (*models.Session, error)
+	SubmitSession(ctx context.Context, token string) (*models.Session, error)
+	OpenSubmission(ctx context.Context, id string) (*models.Session, *os.File, error)
+	ExpireUnjoinedSession(ctx context.Context, id string) (bool, error)
+	ExpireSession(ctx context.Context, id string) (bool, error)
+	PurgeRevokedSessions(ctx context.Context, retention time.Duration) (int64, error)
+	PurgeSubmissions(ctx context.Context, retention time.Duration) (int64, error)
+	RetryCallbacks(ctx context.Context, limit int) (int, error)
+	PurgeCallbackDeliveries(ctx context.Context) (int64, error)
+	ListSessions(ctx context.Context, filters models.SessionFilters) ([]*models.Session, error)
+	CountSessions(ctx context.Context, filters models.SessionFilters) (int, error)
+	GetExpiredSessions(ctx context.Context, limit int) ([]*models.Session, error)
+	PrewarmSessions(ctx context.Context, limit int) (int, error)
+	SendSessionCallback(ctx context.Context, session *models.Session, event models.CallbackEvent)
+	SessionReport(ctx context.Context, id string) (*models.SessionReport, error)
+
+	// Live connections
+	SubscribeSandbox(sandboxID string) (<-chan SandboxNotice, func())
+	NotifySandbox(sandboxID string, notice SandboxNotice)
+	TrackSessionConnection(ctx context.Context, sessionID string) func()
+
+	// Grading
+	GradeSandbox(ctx context.Context, sb *models.Sandbox) (*models.GradingResult, error)
+	ListGradingResults(ctx context.Context, sandboxID string) ([]*models.GradingResult, error)
+
+	// Terminal recordings
+	StartRecording(ctx context.Context, sb *models.Sandbox, session *models.Session, cols, rows int) (*TerminalRecorder, error)
+	ListRecordings(ctx context.Context, sandboxID string) ([]*models.TerminalRecording, error)
+	OpenRecording(ctx context.Context, sandboxID string, id int64) (*models.TerminalRecording, *os.File, error)
+	PurgeRecordings(ctx context.Context, retention time.Duration) (int64, error)
 }
 
 // CreateOptions holds optional parameters for sandbox creation
@@ -64,6 +187,15 @@ type CreateOptions struct {
 	Env      map[string]string
 	Metadata map[string]string
 	Services []string // Override template services; if empty, uses template's list
+	Command  []string // Override template command; requires allow_command_override
+	TaskID   string   // Catalog task to set the workspace up for; also read from the task_id metadata key
+
+	// OwnerClientID is the API client creating the sandbox (0 for none)
+	OwnerClientID int
+
+	// FromSession starts the sandbox of an existing session, which a disabled
+	// template does not stop
+	FromSession bool
 }
 
 // DockerManager implements Manager using Docker
@@ -71,18 +203,41 @@ type DockerManager struct {
 	docker          *client.Client
 	config          config.DockerConfig
 	traefikConfig   config.TraefikConfig
+	sandboxConfig   config.SandboxConfig
 	serviceRegistry *services.Registry
 	templateLoader  *templates.Loader
 	repo            storage.Repository
+	metrics         *metrics.Metrics
+	ops             *operationTracker
+	images          *imagePuller
+	containers      containerRuntime
+	usage           usageSummaries
+
+	// gpuMu serializes GPU slot checks with storing the sandbox that takes the slot
+	gpuMu sync.Mutex
+
+	// workCtx bounds background provisioning; it is cancelled when Close gives up draining
+	workCtx      context.Context
+	cancelWork   context.CancelFunc
+	provisioning *provisionTracker
+
+	// notices reach the live connections of a sandbox
+	notices noticeHub
+
+	// callbackClient posts session callbacks to public addresses and callbackNets
+	callbackClient *http.Client
+	callbackNets   []netip.Prefix
 }
 
 // NewManager creates a new DockerManager
 func NewManager(
 	cfg config.DockerConfig,
 	traefikCfg config.TraefikConfig,
+	sandboxCfg config.SandboxConfig,
 	registry *services.Registry,
 	loader *templates.Loader,
 	repo storage.Repository,
+	engineMetrics *metrics.Metrics,
 ) (*DockerManager, error) {
 	cli, err := client.NewClientWithOpts(
 		client.WithHost(cfg.Host),
@@ -92,14 +247,30 @@ func NewManager(
 		return nil, fmt.Errorf("failed to create docker client: %w", err)
 	}
 
-	return &DockerManager{
+	m := &DockerManager{
 		docker:          cli,
 		config:          cfg,
 		traefikConfig:   traefikCfg,
+		sandboxConfig:   sandboxCfg,
 		serviceRegistry: registry,
 		templateLoader:  loader,
 		repo:            repo,
-	}, nil
+		metrics:         engineMetrics,
+		ops:             newOperationTracker(),
+		provisioning:    newProvisionTracker(),
+	}
+	m.workCtx, m.cancelWork = context.WithCancel(context.Background())
+	m.images = newImagePuller(m.pullImage)
+	m.containers = dockerRuntime{m: m}
+	m.callbackNets = callbackNetworks(sandboxCfg.CallbackAllowedNetworks)
+	m.callbackClient = newCallbackClient(m.callbackNets)
+
+	// Templates added by a reload get their images pre-pulled too
+	loader.OnLoad(func() {
+		m.PrePullImages(m.workCtx)
+	})
+
+	return m, nil
 }
 
 // Ping checks if the manager is operational
@@ -117,18 +288,48 @@ func (m *DockerManager) Ping(ctx context.Context) error {
 	return nil
 }
 
+// ServiceProviders returns the names of the registered service providers, sorted
+func (m *DockerManager) ServiceProviders() []string {
+	names := m.serviceRegistry.List()
+	sort.Strings(names)
+	return names
+}
+
 // Create creates a new sandbox from a template
 func (m *DockerManager) Create(ctx context.Context, templateID, userID string, opts CreateOptions) (*models.Sandbox, error) {
+	task, err := m.createTask(opts)
+	if err != nil {
+		return nil, err
+	}
+	if templateID == "" && task != nil {
+		// Projects are registered as templates under their ID
+		templateID = task.ProjectID
+	}
+
 	// Get template
 	tmpl := m.templateLoader.Get(templateID)
 	if tmpl == nil {
 		return nil, ErrTemplateNotFound
 	}
+	if !tmpl.IsEnabled() && !opts.FromSession {
+		return nil, ErrTemplateDisabled
+	}
+
+	if opts.Command != nil {
+		if !tmpl.AllowCommandOverride {
+			return nil, ErrCommandOverrideNotAllowed
+		}
+		// Provision from a copy so the cached template is untouched
+		override := *tmpl
+		override.Command = opts.Command
+		tmpl = &override
+	}
 
 	// Generate sandbox ID
 	id := uuid.New().String()[:12]
 
-	// Calculate TTL
+	// Calculate TTL. A requested one over the cap is rejected, a default one
+	// cut to it; a session's was checked when it was created.
 	ttl := tmpl.TTL
 	if opts.TTL != nil {
 		ttl = *opts.TTL
@@ -136,6 +337,13 @@ func (m *DockerManager) Create(ctx context.Context, templateID, userID string, o
 	if ttl == 0 {
 		ttl = 1 * time.Hour // default
 	}
+	limit := m.ttlCap(tmpl)
+	if limit > 0 && ttl > limit && !opts.FromSession {
+		if opts.TTL != nil {
+			return nil, &TTLCapError{Requested: ttl, Max: limit}
+		}
+		ttl = limit
+	}
 
 	now := time.Now()
 	sb := &models.Sandbox{
@@ -147,12 +355,31 @@ func (m *DockerManager) Create(ctx context.Context, templateID, userID string, o
 		ExpiresAt:  now.Add(ttl),
 		Services:   make(map[string]*models.ServiceInstance),
 		Endpoints:  make(map[string]string),
-		Metadata:   opts.Metadata,
+		Metadata:   buildMetadata(opts.Metadata, tmpl),
+
+		OwnerClientID: opts.OwnerClientID,
+	}
+	if task != nil {
+		sb.Metadata = withTaskMetadata(sb.Metadata, task)
+		opts.Env = withTaskEnv(opts.Env, task)
+	}
+	recordTTLCap(sb, limit)
+
+	host, err := m.placeSandbox(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sb.Host = host
+
+	release, err := m.reserveGPU(ctx, tmpl, host)
+	if err != nil {
+		return nil, err
 	}
+	defer release()
 
-	// Store sandbox in database
-	if err := m.repo.CreateSandbox(ctx, sb); err != nil {
-		return nil, fmt.Errorf("failed to create sandbox: %w", err)
+	key, err := m.beginProvision(provisionSandboxKind, id)
+	if err != nil {
+		return nil, err
 	}
 
 	// Determine which services to provision: session override > template default
@@ -161,13 +388,24 @@ func (m *DockerManager) Create(ctx context.Context, templateID, userID string, o
 		serviceList = opts.Services
 	}
 
+	if err := m.storeNewSandbox(ctx, sb, tmpl, serviceList); err != nil {
+		m.provisioning.done(key)
+		return nil, err
+	}
+	m.RecordEvent(ctx, id, models.EventCreated, fmt.Sprintf("template %s on host %s", templateID, host))
+
 	// Provision services asynchronously
-	go m.provisionSandbox(context.Background(), sb, tmpl, opts.Env, serviceList)
+	m.runProvision(ctx, key, func(ctx context.Context) {
+		m.provisionSandbox(ctx, sb, tmpl, opts.Env, serviceList)
+	})
+
+	m.metrics.SandboxCreated(templateID, tmpl.Annotations)
 
 	slog.Info("sandbox created",
 		"id", id,
 		"template", templateID,
 		"user", userID,
+		"host", host,
 		"services", serviceList,
 		"expires_at", sb.ExpiresAt,
 	)
@@ -175,87 +413,337 @@ func (m *DockerManager) Create(ctx context.Context, templateID, userID string, o
 	return sb, nil
 }
 
-// provisionSandbox handles async provisioning of sandbox resources
+// storeNewSandbox stores sb with a record for each planned service in one
+// transaction, so a crash mid-provisioning can't leave services that Delete
+// doesn't know about. A template created through the API is locked shared
+// meanwhile, so DeleteTemplate either sees the sandbox or ran before it.
+func (m *DockerManager) storeNewSandbox(ctx context.Context, sb *models.Sandbox, tmpl *models.Template, serviceList []string) error {
+	return m.repo.WithTx(ctx, func(tx storage.Repository) error {
+		// A template gone from the loader was stored and deleted meanwhile
+		if m.templateLoader.IsStored(sb.TemplateID) || m.templateLoader.Get(sb.TemplateID) == nil {
+			found, err := tx.LockTemplateDocument(ctx, sb.TemplateID, false)
+			if err != nil {
+				return err
+			}
+			if !found {
+				return ErrTemplateNotFound
+			}
+		}
+		if err := tx.CreateSandbox(ctx, sb); err != nil {
+			return fmt.Errorf("failed to create sandbox: %w", err)
+		}
+		for _, name := range serviceList {
+			planned := &models.ServiceInstance{
+				Name:      name,
+				Type:      name,
+				Status:    models.ServiceStatusProvisioning,
+				CreatedAt: sb.CreatedAt,
+			}
+			if spec := tmpl.ServiceSpec(name); spec.Mode == models.ServiceDedicated {
+				planned.Mode = spec.Mode
+			}
+			if err := tx.CreateService(ctx, sb.ID, planned); err != nil {
+				return fmt.Errorf("failed to create service %s: %w", name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// annotationPrefix namespaces template annotations inside sandbox metadata
+const annotationPrefix = "annotations."
+
+// Metadata keys showing the container command and entrypoint (JSON arrays)
+const (
+	metaCommand    = "container.command"
+	metaEntrypoint = "container.entrypoint"
+)
+
+// buildMetadata copies request metadata and adds the template's annotations
+// and the container command as system metadata, so they are visible on the
+// sandbox record
+func buildMetadata(requested map[string]string, tmpl *models.Template) map[string]string {
+	if len(requested) == 0 && len(tmpl.Annotations) == 0 && tmpl.Command == nil && tmpl.Entrypoint == nil && tmpl.Resources.GPUs == "" {
+		return requested
+	}
+
+	metadata := make(map[string]string, len(requested)+len(tmpl.Annotations)+3)
+	for k, v := range requested {
+		metadata[k] = v
+	}
+	for k, v := range tmpl.Annotations {
+		metadata[annotationPrefix+k] = v
+	}
+	if tmpl.Command != nil {
+		metadata[metaCommand] = encodeArgs(tmpl.Command)
+	}
+	if tmpl.Entrypoint != nil {
+		metadata[metaEntrypoint] = encodeArgs(tmpl.Entrypoint)
+	}
+	if tmpl.Resources.GPUs != "" {
+		metadata[metaGPUs] = tmpl.Resources.GPUs
+	}
+	return metadata
+}
+
+// encodeArgs renders a command as a JSON array, keeping argument boundaries visible
+func encodeArgs(args []string) string {
+	data, _ := json.Marshal(args)
+	return string(data)
+}
+
+// provisionSandbox handles async provisioning of sandbox resources.
+// The whole flow is bounded by the provisioning timeout; on timeout the sandbox
+// is marked failed; on any failure whatever was already created is torn down
+// before the sandbox is marked failed.
 func (m *DockerManager) provisionSandbox(ctx context.Context, sb *models.Sandbox, tmpl *models.Template, extraEnv map[string]string, serviceList []string) {
+	ctx, span := tracing.Start(ctx, "sandbox.provision",
+		attribute.String("sandbox.id", sb.ID),
+		attribute.String("sandbox.template", sb.TemplateID),
+	)
+	var err error
+	defer func() { tracing.End(span, err) }()
+
+	timeout := m.provisionTimeout()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// Status updates after a timeout must not use the expired context
+	bgCtx := context.WithoutCancel(ctx)
+
+	// Run the steps separately so a step that ignores its context can't block the timeout
+	done := make(chan error, 1)
+	go func() {
+		done <- m.provision(ctx, sb, tmpl, extraEnv, serviceList)
+	}()
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		slog.Error("sandbox provisioning timed out", "id", sb.ID, "timeout", timeout)
+		m.updateStatus(bgCtx, sb.ID, models.StatusFailed, fmt.Sprintf("provisioning timed out after %s", timeout))
+
+		// sb is owned by the provisioning goroutine until it returns
+		go func() {
+			<-done
+			m.rollback(bgCtx, sb, fmt.Sprintf("provisioning timed out after %s", timeout))
+		}()
+		return
+	}
+
+	if err != nil {
+		if errors.Is(ctx.Err(), context.Canceled) {
+			// Abandoned at shutdown; Close records the failure
+			return
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("provisioning timed out after %s: %w", timeout, err)
+		}
+		m.failProvisioning(bgCtx, sb, err.Error())
+		return
+	}
+
+	now := time.Now()
+	sb.StartedAt = &now
+	sb.Status = models.StatusRunning
+	sb.StatusMsg = ""
+
+	// Update sandbox in database
+	if err := m.repo.UpdateSandbox(ctx, sb); err != nil {
+		slog.Error("failed to update sandbox in database", "error", err, "id", sb.ID)
+	}
+
+	m.metrics.SandboxStarted(sb.TemplateID, tmpl.Annotations)
+	m.RecordEvent(ctx, sb.ID, models.EventStarted, "")
+
+	slog.Info("sandbox started", "id", sb.ID, "container", sb.ContainerID, "endpoints", sb.Endpoints)
+}
+
+// provisionTimeout returns the configured provisioning timeout
+func (m *DockerManager) provisionTimeout() time.Duration {
+	if m.sandboxConfig.ProvisionTimeout > 0 {
+		return m.sandboxConfig.ProvisionTimeout
+	}
+	return defaultProvisionTimeout
+}
+
+// provision runs the provisioning steps: services, seeds, image, container.
+// Each step is retried on its own while it fails transiently, so a retry never
+// repeats a step that already succeeded.
+func (m *DockerManager) provision(ctx context.Context, sb *models.Sandbox, tmpl *models.Template, extraEnv map[string]string, serviceList []string) error {
 	// Provision required services
 	for _, serviceName := range serviceList {
+		if spec := tmpl.ServiceSpec(serviceName); spec.Mode == models.ServiceDedicated {
+			spanCtx, span := tracing.Start(ctx, "service.provision",
+				attribute.String("service.name", serviceName),
+				attribute.String("service.mode", string(spec.Mode)),
+			)
+			svcInstance, err := m.provisionDedicated(spanCtx, sb, serviceName, spec, tmpl.Resources)
+			tracing.End(span, err)
+			if err != nil {
+				return fmt.Errorf("failed to provision dedicated %s: %w", serviceName, err)
+			}
+			if err := m.repo.CreateService(ctx, sb.ID, svcInstance); err != nil {
+				slog.Error("failed to save service to database", "error", err, "sandbox", sb.ID, "service", serviceName)
+			}
+			sb.Services[serviceName] = svcInstance
+			m.RecordEvent(ctx, sb.ID, models.EventServiceProvisioned, serviceName+" (dedicated)")
+			continue
+		}
+
 		provider := m.serviceRegistry.Get(serviceName)
 		if provider == nil {
-			m.updateStatus(ctx, sb.ID, models.StatusFailed, fmt.Sprintf("unknown service: %s", serviceName))
-			return
+			return fmt.Errorf("unknown service: %s", serviceName)
 		}
 
-		creds, err := provider.Provision(ctx, sb.ID, serviceName)
+		var creds *models.ServiceCredentials
+		spanCtx, span := tracing.Start(ctx, "service.provision", attribute.String("service.name", serviceName))
+		err := provisionRetry.do(spanCtx, "provision "+serviceName, func(ctx context.Context) error {
+			var err error
+			creds, err = provider.Provision(ctx, sb.ID, serviceName, tmpl.ServicesConfig[serviceName].Options)
+			return err
+		})
+		tracing.End(span, err)
 		if err != nil {
-			m.updateStatus(ctx, sb.ID, models.StatusFailed, fmt.Sprintf("failed to provision %s: %v", serviceName, err))
-			return
+			return fmt.Errorf("failed to provision %s: %w", serviceName, err)
 		}
 
 		svcInstance := &models.ServiceInstance{
 			Name:        serviceName,
 			Type:        serviceName,
-			Status:      "ready",
+			Status:      models.ServiceStatusReady,
 			Credentials: creds,
 			CreatedAt:   time.Now(),
 		}
 
-		// Store service in database
+		// Replace the planned record with the provisioned instance
 		if err := m.repo.CreateService(ctx, sb.ID, svcInstance); err != nil {
 			slog.Error("failed to save service to database", "error", err, "sandbox", sb.ID, "service", serviceName)
 		}
 
 		sb.Services[serviceName] = svcInstance
+		m.RecordEvent(ctx, sb.ID, models.EventServiceProvisioned, serviceName)
+	}
+
+	// Load fixtures once every service is up, before the sandbox can use them
+	if err := m.seedServices(ctx, sb, tmpl, serviceList); err != nil {
+		return err
 	}
 
 	// Pull image if needed
-	if err := m.pullImage(ctx, tmpl.BaseImage); err != nil {
-		m.updateStatus(ctx, sb.ID, models.StatusFailed, fmt.Sprintf("failed to pull image: %v", err))
-		return
+	err := provisionRetry.do(ctx, "pull image", func(ctx context.Context) error {
+		return m.containers.ensureImage(ctx, tmpl.BaseImage)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to pull image: %w", err)
 	}
+	m.RecordEvent(ctx, sb.ID, models.EventImagePulled, tmpl.BaseImage)
 
 	// Build environment variables
 	env := m.buildEnv(sb, tmpl, extraEnv)
 
 	// Create container
-	containerID, err := m.createContainer(ctx, sb, tmpl, env)
+	var containerID string
+	retried := false
+	err = provisionRetry.do(ctx, "create container", func(ctx context.Context) error {
+		var err error
+		containerID, err = m.containers.create(ctx, sb, tmpl, env)
+		if err != nil && retried && errdefs.IsConflict(err) {
+			// The previous attempt created the container but its response was lost
+			if existing, lookupErr := m.containers.lookup(ctx, containerName(sb.ID)); lookupErr == nil {
+				containerID, err = existing, nil
+			}
+		}
+		retried = true
+		return err
+	})
 	if err != nil {
-		m.updateStatus(ctx, sb.ID, models.StatusFailed, fmt.Sprintf("failed to create container: %v", err))
-		return
+		return fmt.Errorf("failed to create container: %w", err)
 	}
 
 	sb.ContainerID = containerID
 
+	// Credentials file must be in place before the main process starts
+	err = provisionRetry.do(ctx, "write credentials", func(ctx context.Context) error {
+		return m.writeCredentialsFile(ctx, sb, tmpl)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write credentials file: %w", err)
+	}
+
 	// Build endpoints for Traefik routing
 	sb.Endpoints = m.buildEndpoints(sb, tmpl)
 
 	// Start container
-	if err := m.docker.ContainerStart(ctx, containerID, container.StartOptions{}); err != nil {
-		m.updateStatus(ctx, sb.ID, models.StatusFailed, fmt.Sprintf("failed to start container: %v", err))
-		return
+	err = provisionRetry.do(ctx, "start container", func(ctx context.Context) error {
+		return m.containers.start(ctx, containerID)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to start container: %w", err)
 	}
 
-	now := time.Now()
-	sb.StartedAt = &now
-	sb.Status = models.StatusRunning
-	sb.StatusMsg = ""
+	// Without Traefik, endpoints point at the ephemeral host ports Docker assigned
+	if !m.traefikConfig.Enabled {
+		endpoints, err := m.buildHostEndpoints(ctx, containerID, tmpl)
+		if err != nil {
+			slog.Warn("failed to resolve published ports", "error", err, "id", sb.ID)
+		} else {
+			sb.Endpoints = endpoints
+		}
+	}
 
-	// Update sandbox in database
-	if err := m.repo.UpdateSandbox(ctx, sb); err != nil {
-		slog.Error("failed to update sandbox in database", "error", err, "id", sb.ID)
+	// Clone the task's starter repo and run its setup commands
+	if task := m.templateLoader.GetTask(sb.Metadata[models.MetadataTaskID]); task != nil && (task.StarterRepo != nil || len(task.SetupCommands) > 0) {
+		if err := m.setupTask(ctx, sb, task); err != nil {
+			return err
+		}
+		m.RecordEvent(ctx, sb.ID, models.EventTaskSetup, task.ID)
+	}
+
+	return nil
+}
+
+// FailStuckProvisioning marks sandboxes that have been pending for longer than
+// the provisioning timeout as failed, e.g. after a crash mid-provisioning,
+// and tears down what they had created. Returns how many were recovered.
+func (m *DockerManager) FailStuckProvisioning(ctx context.Context) (int, error) {
+	// Must see sandboxes created moments ago, so skip the replica
+	pending, err := m.repo.ListSandboxes(storage.WithPrimary(ctx), models.ListFilters{Status: models.StatusPending})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list pending sandboxes: %w", err)
+	}
+
+	timeout := m.provisionTimeout()
+	cutoff := time.Now().Add(-timeout)
+	recovered := 0
+
+	for _, sb := range pending {
+		if sb.CreatedAt.After(cutoff) {
+			continue
+		}
+
+		slog.Warn("sandbox stuck in pending, marking failed", "id", sb.ID, "created_at", sb.CreatedAt)
+		m.failProvisioning(ctx, sb, fmt.Sprintf("provisioning did not finish within %s", timeout))
+		recovered++
 	}
 
-	slog.Info("sandbox started", "id", sb.ID, "container", containerID, "endpoints", sb.Endpoints)
+	return recovered, nil
 }
 
 // pullImage pulls a Docker image if not present
-func (m *DockerManager) pullImage(ctx context.Context, imageName string) error {
+func (m *DockerManager) pullImage(ctx context.Context, imageName string) (err error) {
 	if m.config.PullPolicy == "never" {
 		return nil
 	}
 
+	ctx, span := tracing.Start(ctx, "docker.pull_image", attribute.String("image", imageName))
+	defer func() { tracing.End(span, err) }()
+
 	// Check if image exists
-	_, _, err := m.docker.ImageInspectWithRaw(ctx, imageName)
+	_, _, err = m.docker.ImageInspectWithRaw(ctx, imageName)
 	if err == nil && m.config.PullPolicy == "if-not-present" {
+		span.SetAttributes(attribute.Bool("image.cached", true))
 		return nil
 	}
 
@@ -275,15 +763,17 @@ func (m *DockerManager) pullImage(ctx context.Context, imageName string) error {
 func (m *DockerManager) buildEnv(sb *models.Sandbox, tmpl *models.Template, extraEnv map[string]string) []string {
 	env := make([]string, 0)
 
-	// Template env
+	// Template env, with the runtime placeholders resolved
+	runtime := templates.RuntimeValues(sb.ID, sb.UserID, sb.TemplateID)
 	for k, v := range tmpl.Env {
+		v, _ = templates.Substitute(v, runtime, nil)
 		env = append(env, fmt.Sprintf("%s=%s", k, v))
 	}
 
-	// Service credentials as env
+	// Service credentials as env, unless the template only delivers them in a file
 	for name, svc := range sb.Services {
 		prefix := strings.ToUpper(name)
-		if svc.Credentials != nil {
+		if svc.Credentials != nil && tmpl.CredentialsDelivery.InEnv() {
 			env = append(env, fmt.Sprintf("%s_HOST=%s", prefix, svc.Credentials.Host))
 			env = append(env, fmt.Sprintf("%s_PORT=%d", prefix, svc.Credentials.Port))
 			if svc.Credentials.Username != "" {
@@ -301,6 +791,9 @@ func (m *DockerManager) buildEnv(sb *models.Sandbox, tmpl *models.Template, extr
 			if svc.Credentials.Prefix != "" {
 				env = append(env, fmt.Sprintf("%s_PREFIX=%s", prefix, svc.Credentials.Prefix))
 			}
+			if svc.Credentials.Token != "" {
+				env = append(env, fmt.Sprintf("%s_TOKEN=%s", prefix, svc.Credentials.Token))
+			}
 		}
 	}
 
@@ -312,6 +805,9 @@ func (m *DockerManager) buildEnv(sb *models.Sandbox, tmpl *models.Template, extr
 	// Sandbox metadata
 	env = append(env, fmt.Sprintf("SANDBOX_ID=%s", sb.ID))
 	env = append(env, fmt.Sprintf("SANDBOX_USER_ID=%s", sb.UserID))
+	if tmpl.CredentialsDelivery.InFile() && tmpl.CredentialsFile != nil {
+		env = append(env, fmt.Sprintf("%s=%s", envCredentialsFile, tmpl.CredentialsFile.Path))
+	}
 
 	return env
 }
@@ -323,7 +819,7 @@ func (m *DockerManager) buildTraefikLabels(sb *models.Sandbox, tmpl *models.Temp
 	}
 
 	sandboxHost := fmt.Sprintf("%s.%s", sb.ID, m.traefikConfig.Domain)
-	routerName := fmt.Sprintf("sandbox-%s", sb.ID)
+	routerName := templates.RouterName(sb.ID, "")
 
 	labels := map[string]string{
 		"traefik.enable":         "true",
@@ -347,7 +843,7 @@ func (m *DockerManager) buildTraefikLabels(sb *models.Sandbox, tmpl *models.Temp
 	// Add labels for each exposed port from template
 	for _, port := range tmpl.Expose {
 		if port.Public {
-			portRouterName := fmt.Sprintf("sandbox-%s-%s", sb.ID, port.Name)
+			portRouterName := templates.RouterName(sb.ID, port.Name)
 			portHost := fmt.Sprintf("%s-%s.%s", sb.ID, port.Name, m.traefikConfig.Domain)
 
 			labels[fmt.Sprintf("traefik.http.routers.%s.rule", portRouterName)] = fmt.Sprintf("Host(`%s`)", portHost)
@@ -387,31 +883,71 @@ func (m *DockerManager) buildEndpoints(sb *models.Sandbox, tmpl *models.Template
 	return endpoints
 }
 
+// buildHostEndpoints reads the host ports Docker assigned to the exposed ports
+// and turns them into endpoint URLs (used when Traefik is disabled)
+func (m *DockerManager) buildHostEndpoints(ctx context.Context, containerID string, tmpl *models.Template) (map[string]string, error) {
+	info, err := m.docker.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect container: %w", err)
+	}
+
+	endpoints := make(map[string]string)
+	if info.NetworkSettings == nil {
+		return endpoints, nil
+	}
+
+	for _, port := range tmpl.Expose {
+		bindings := info.NetworkSettings.Ports[containerPort(port)]
+		if len(bindings) == 0 || bindings[0].HostPort == "" {
+			continue
+		}
+		endpoints[port.Name] = fmt.Sprintf("http://%s:%s", m.config.PublicHost, bindings[0].HostPort)
+	}
+
+	return endpoints, nil
+}
+
+// containerPort returns the Docker port key for an exposed template port
+func containerPort(port models.Port) nat.Port {
+	protocol := port.Protocol
+	if protocol == "" {
+		protocol = "tcp"
+	}
+	return nat.Port(fmt.Sprintf("%d/%s", port.Container, protocol))
+}
+
+// containerName returns the Docker container name of a sandbox
+func containerName(sandboxID string) string {
+	return fmt.Sprintf("sandbox-%s", sandboxID)
+}
+
 // createContainer creates a Docker container for the sandbox
-func (m *DockerManager) createContainer(ctx context.Context, sb *models.Sandbox, tmpl *models.Template, env []string) (string, error) {
-	containerName := fmt.Sprintf("sandbox-%s", sb.ID)
+func (m *DockerManager) createContainer(ctx context.Context, sb *models.Sandbox, tmpl *models.Template, env []string) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "docker.create_container", attribute.String("sandbox.id", sb.ID))
+	defer func() { tracing.End(span, err) }()
 
-	// Build port bindings
+	name := containerName(sb.ID)
+
+	// Build port bindings (publish to ephemeral host ports when Traefik is disabled)
 	exposedPorts := nat.PortSet{}
+	portBindings := nat.PortMap{}
 	for _, port := range tmpl.Expose {
-		p := nat.Port(fmt.Sprintf("%d/%s", port.Container, port.Protocol))
+		p := containerPort(port)
 		exposedPorts[p] = struct{}{}
+		if !m.traefikConfig.Enabled {
+			portBindings[p] = []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: ""}}
+		}
 	}
 
-	// Resource limits
-	resources := container.Resources{}
-	if tmpl.Resources.MemoryLimit != "" {
-		// Parse memory limit (e.g., "512m", "1g")
-		// Simplified - in production use units parsing
-		resources.Memory = 512 * 1024 * 1024 // 512MB default
-	}
+	resources := containerResources(tmpl.Resources)
+	resources.DeviceRequests = deviceRequests(tmpl)
 
 	// Labels for metadata
 	labels := map[string]string{
-		"sandbox.id":       sb.ID,
+		sandboxIDLabel:     sb.ID,
 		"sandbox.user":     sb.UserID,
 		"sandbox.template": sb.TemplateID,
-		"sandbox.managed":  "true",
+		managedLabel:       "true",
 	}
 	// Add template labels
 	for k, v := range tmpl.Labels {
@@ -430,13 +966,16 @@ func (m *DockerManager) createContainer(ctx context.Context, sb *models.Sandbox,
 		AttachStdout: true,
 		AttachStderr: true,
 		Image:        tmpl.BaseImage,
+		Cmd:          tmpl.Command,
+		Entrypoint:   tmpl.Entrypoint,
 		Env:          env,
 		ExposedPorts: exposedPorts,
 		Labels:       labels,
 	}
 
 	hostConfig := &container.HostConfig{
-		Binds: []string{"claude-auth:/home/coder/.claude"},
+		Binds:        []string{"claude-auth:/home/coder/.claude"},
+		PortBindings: portBindings,
 		Resources:    resources,
 		NetworkMode:  container.NetworkMode(m.config.Network),
 		AutoRemove:   false,
@@ -447,7 +986,7 @@ func (m *DockerManager) createContainer(ctx context.Context, sb *models.Sandbox,
 
 	networkConfig := &network.NetworkingConfig{}
 
-	resp, err := m.docker.ContainerCreate(ctx, containerConfig, hostConfig, networkConfig, nil, containerName)
+	resp, err := m.docker.ContainerCreate(ctx, containerConfig, hostConfig, networkConfig, nil, name)
 	if err != nil {
 		return "", fmt.Errorf("failed to create container: %w", err)
 	}
@@ -455,6 +994,19 @@ func (m *DockerManager) createContainer(ctx context.Context, sb *models.Sandbox,
 	return resp.ID, nil
 }
 
+// containerResources returns the CPU and memory limits of a template's
+// resources. The loader has validated them; an empty one is no limit.
+func containerResources(r models.Resources) container.Resources {
+	var resources container.Resources
+	if cpus, err := strconv.ParseFloat(r.CPULimit, 64); err == nil && cpus > 0 {
+		resources.NanoCPUs = int64(cpus * 1e9)
+	}
+	if memory, err := templates.ParseSize(r.MemoryLimit); err == nil && memory > 0 {
+		resources.Memory = memory
+	}
+	return resources
+}
+
 // updateStatus updates sandbox status in database
 func (m *DockerManager) updateStatus(ctx context.Context, id string, status models.SandboxStatus, msg string) {
 	sb, err := m.repo.GetSandbox(ctx, id)
@@ -469,6 +1021,16 @@ func (m *DockerManager) updateStatus(ctx context.Context, id string, status mode
 	if err := m.repo.UpdateSandbox(ctx, sb); err != nil {
 		slog.Error("failed to update sandbox status", "error", err, "id", id, "status", status)
 	}
+
+	if status == models.StatusFailed {
+		var annotations map[string]string
+		if tmpl := m.templateLoader.Get(sb.TemplateID); tmpl != nil {
+			annotations = tmpl.Annotations
+		}
+		m.metrics.SandboxFailed(sb.TemplateID, annotations)
+		m.recordUsage(ctx, sb)
+		m.RecordEvent(ctx, id, models.EventFailed, msg)
+	}
 }
 
 // Get retrieves a sandbox by ID
@@ -485,8 +1047,14 @@ func (m *DockerManager) Get(ctx context.Context, id string) (*models.Sandbox, er
 	return sb, nil
 }
 
-// Stop stops a running sandbox
-func (m *DockerManager) Stop(ctx context.Context, id string) error {
+// Start starts a stopped sandbox again using its existing container
+func (m *DockerManager) Start(ctx context.Context, id string) error {
+	release, err := m.ops.begin(id, "start")
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	sb, err := m.repo.GetSandbox(ctx, id)
 	if err != nil {
 		return fmt.Errorf("failed to get sandbox: %w", err)
@@ -496,61 +1064,281 @@ func (m *DockerManager) Stop(ctx context.Context, id string) error {
 		return ErrSandboxNotFound
 	}
 
-	if sb.Status.IsTerminal() {
-		return ErrSandboxStopped
+	switch {
+	case sb.Status.IsRunning():
+		return ErrSandboxRunning
+	case sb.Status != models.StatusStopped:
+		return ErrSandboxNotActive
+	case time.Now().After(sb.ExpiresAt):
+		return ErrSandboxExpired
+	case sb.ContainerID == "":
+		return ErrSandboxNotActive
+	}
+
+	// Services are up before the sandbox's processes connect to them
+	if err := m.restartDedicated(ctx, sb); err != nil {
+		return err
+	}
+	if err := m.containers.start(ctx, sb.ContainerID); err != nil {
+		return fmt.Errorf("failed to start container: %w", err)
 	}
 
-	if sb.ContainerID != "" {
-		timeout := 30
-		if err := m.docker.ContainerStop(ctx, sb.ContainerID, container.StopOptions{Timeout: &timeout}); err != nil {
-			slog.Warn("failed to stop container", "error", err, "container", sb.ContainerID)
+	// Published host ports are reassigned on every start
+	if !m.traefikConfig.Enabled {
+		if tmpl := m.templateLoader.Get(sb.TemplateID); tmpl != nil {
+			endpoints, err := m.buildHostEndpoints(ctx, sb.ContainerID, tmpl)
+			if err != nil {
+				slog.Warn("failed to resolve published ports", "error", err, "id", sb.ID)
+			} else {
+				sb.Endpoints = endpoints
+			}
 		}
 	}
 
-	sb.Status = models.StatusStopped
+	sb.Status = models.StatusRunning
+	sb.StatusMsg = ""
+
 	if err := m.repo.UpdateSandbox(ctx, sb); err != nil {
 		return fmt.Errorf("failed to update sandbox status: %w", err)
 	}
 
-	slog.Info("sandbox stopped", "id", id)
+	// Reset the idle clock so the idle reaper doesn't stop it again right away
+	if err := m.repo.TouchSandboxActivity(ctx, sb.ID, time.Now()); err != nil {
+		slog.Warn("failed to record activity on start", "error", err, "id", sb.ID)
+	}
+
+	m.RecordEvent(ctx, id, models.EventStarted, "started again")
+	slog.Info("sandbox started again", "id", id, "container", sb.ContainerID)
+
 	return nil
 }
 
-// Delete removes a sandbox and all its resources
-func (m *DockerManager) Delete(ctx context.Context, id string) error {
+// Stop stops a running sandbox.
+// If the request deadline can't cover the container stop, the stop continues
+// in the background and ErrOperationPending is returned.
+func (m *DockerManager) Stop(ctx context.Context, id string) error {
+	return m.stop(ctx, id, models.StatusMsgStoppedByUser)
+}
+
+// StopIdle stops a sandbox that has had no terminal activity for too long.
+// The container and services are kept so the sandbox can be resumed.
+func (m *DockerManager) StopIdle(ctx context.Context, id string) error {
+	return m.stop(ctx, id, models.StatusMsgStoppedIdle)
+}
+
+// stop stops a sandbox, recording why in its status message
+func (m *DockerManager) stop(ctx context.Context, id, reason string) error {
+	release, err := m.ops.begin(id, "stop")
+	if err != nil {
+		return err
+	}
+
 	sb, err := m.repo.GetSandbox(ctx, id)
 	if err != nil {
+		release()
 		return fmt.Errorf("failed to get sandbox: %w", err)
 	}
 
 	if sb == nil {
+		release()
 		return ErrSandboxNotFound
 	}
 
-	// Stop container if running
-	if sb.ContainerID != "" {
-		timeout := 10
-		_ = m.docker.ContainerStop(ctx, sb.ContainerID, container.StopOptions{Timeout: &timeout})
-		_ = m.docker.ContainerRemove(ctx, sb.ContainerID, container.RemoveOptions{Force: true})
+	if sb.Status == models.StatusStopped || sb.Status.IsTerminal() {
+		release()
+		return ErrSandboxStopped
+	}
+
+	return m.ops.runPhases(ctx, id, "stop", release, []phase{
+		{
+			name: "container",
+			// The sandbox's container, then each dedicated service's
+			budget: stopTimeout*time.Duration(1+dedicatedCount(sb)) + phaseMargin,
+			run: func(ctx context.Context) error {
+				if sb.ContainerID != "" {
+					if err := m.containers.stop(ctx, sb.ContainerID); err != nil {
+						slog.Warn("failed to stop container", "error", err, "container", sb.ContainerID)
+					}
+				}
+				m.stopDedicated(ctx, sb)
+				return nil
+			},
+		},
+		{
+			name:   "database",
+			budget: phaseMargin,
+			run: func(ctx context.Context) error {
+				sb.Status = models.StatusStopped
+				sb.StatusMsg = reason
+				if err := m.repo.UpdateSandbox(ctx, sb); err != nil {
+					return fmt.Errorf("failed to update sandbox status: %w", err)
+				}
+				m.RecordEvent(ctx, id, models.EventStopped, reason)
+				slog.Info("sandbox stopped", "id", id, "reason", reason)
+				return nil
+			},
+		},
+	})
+}
+
+// Delete removes a sandbox and all its resources. Its record is kept, marked
+// deleted, until PurgeDeleted removes it.
+// Phases that don't fit in the request deadline continue in the background
+// and ErrOperationPending is returned.
+// A service that still fails to deprovision after retries leaves the sandbox
+// in StatusDeleting for the cleanup worker, and ErrDeprovisionFailed is returned.
+func (m *DockerManager) Delete(ctx context.Context, id string) error {
+	return m.delete(ctx, id, false)
+}
+
+// ForceDelete deletes a sandbox like Delete, but also when services fail to
+// deprovision; what they leave behind is found by ReconcileProviders
+func (m *DockerManager) ForceDelete(ctx context.Context, id string) error {
+	return m.delete(ctx, id, true)
+}
+
+func (m *DockerManager) delete(ctx context.Context, id string, force bool) error {
+	release, err := m.ops.begin(id, "delete")
+	if err != nil {
+		return err
+	}
+
+	sb, err := m.repo.GetSandbox(ctx, id)
+	if err != nil {
+		release()
+		return fmt.Errorf("failed to get sandbox: %w", err)
+	}
+
+	if sb == nil {
+		release()
+		return ErrSandboxNotFound
 	}
 
-	// Deprovision services
+	return m.ops.runPhases(ctx, id, "delete", release, []phase{
+		{
+			name:   "container",
+			budget: deleteStopTimeout + phaseMargin,
+			run: func(ctx context.Context) error {
+				// Stop container if running
+				if sb.ContainerID != "" {
+					timeout := int(deleteStopTimeout.Seconds())
+					_ = m.docker.ContainerStop(ctx, sb.ContainerID, container.StopOptions{Timeout: &timeout})
+					_ = m.docker.ContainerRemove(ctx, sb.ContainerID, container.RemoveOptions{Force: true})
+				}
+				return nil
+			},
+		},
+		{
+			name:   "services",
+			budget: time.Duration(len(sb.Services)+1) * phaseMargin,
+			run: func(ctx context.Context) error {
+				err := m.deprovisionServices(ctx, sb)
+				if err == nil {
+					return nil
+				}
+				if force {
+					slog.Warn("force deleting sandbox with services left", "error", err, "sandbox", id)
+					return nil
+				}
+				return m.markDeleting(ctx, sb, err)
+			},
+		},
+		{
+			name:   "database",
+			budget: phaseMargin,
+			run: func(ctx context.Context) error {
+				m.recordUsage(ctx, sb)
+
+				if err := m.deleteRecords(ctx, sb); err != nil {
+					return fmt.Errorf("failed to delete sandbox from database: %w", err)
+				}
+				slog.Info("sandbox deleted", "id", id)
+				return nil
+			},
+		},
+	})
+}
+
+// deprovisionServices releases every service of a sandbox, retrying transient
+// failures, and returns the failures of those that still hold resources
+func (m *DockerManager) deprovisionServices(ctx context.Context, sb *models.Sandbox) error {
+	names := make([]string, 0, len(sb.Services))
 	for name := range sb.Services {
-		provider := m.serviceRegistry.Get(name)
-		if provider != nil {
-			if err := provider.Deprovision(ctx, id, name); err != nil {
-				slog.Warn("failed to deprovision service", "error", err, "service", name, "sandbox", id)
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		svc := sb.Services[name]
+		err := deprovisionRetry.do(ctx, "deprovision "+name, func(ctx context.Context) error {
+			if svc.IsDedicated() {
+				return m.removeDedicated(ctx, sb.ID, svc)
+			}
+			if provider := m.serviceRegistry.Get(name); provider != nil {
+				return provider.Deprovision(ctx, sb.ID, name)
 			}
+			return nil
+		})
+		if err != nil {
+			slog.Warn("failed to deprovision service", "error", err, "service", name, "sandbox", sb.ID)
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
 		}
 	}
+	return errors.Join(errs...)
+}
 
-	// Delete from database (services will be cascade deleted)
-	if err := m.repo.DeleteSandbox(ctx, id); err != nil {
-		return fmt.Errorf("failed to delete sandbox from database: %w", err)
+// markDeleting keeps a sandbox whose services failed to deprovision in
+// StatusDeleting with the failure, so the cleanup worker retries its delete
+func (m *DockerManager) markDeleting(ctx context.Context, sb *models.Sandbox, cause error) error {
+	// The request may have run out while retrying; the state must still be kept
+	ctx = context.WithoutCancel(ctx)
+	msg := strings.ReplaceAll(cause.Error(), "\n", "; ")
+
+	sb.Status = models.StatusDeleting
+	sb.StatusMsg = msg
+	if err := m.repo.UpdateSandbox(ctx, sb); err != nil {
+		return fmt.Errorf("failed to update sandbox status: %w", err)
 	}
+	m.RecordEvent(ctx, sb.ID, models.EventDeleteFailed, msg)
+	return fmt.Errorf("%w: %s", ErrDeprovisionFailed, msg)
+}
 
-	slog.Info("sandbox deleted", "id", id)
-	return nil
+// deleteRecords deletes a sandbox's service records, marks the sandbox deleted,
+// keeps its last status on its session and appends its deleted event in one
+// transaction, so the history never misses a deletion that happened
+func (m *DockerManager) deleteRecords(ctx context.Context, sb *models.Sandbox) error {
+	return m.repo.WithTx(ctx, func(tx storage.Repository) error {
+		if err := tx.DeleteServices(ctx, sb.ID); err != nil {
+			return err
+		}
+		if err := tx.DeleteSandbox(ctx, sb.ID, false); err != nil {
+			return err
+		}
+		if err := tx.SetSessionSandboxStatus(ctx, sb.ID, sb.Status); err != nil {
+			return err
+		}
+		return tx.AppendEvent(ctx, newEvent(sb.ID, models.EventDeleted, ""))
+	})
+}
+
+// PurgeDeleted removes the records of sandboxes deleted more than retention ago
+func (m *DockerManager) PurgeDeleted(ctx context.Context, retention time.Duration) (int64, error) {
+	n, err := m.repo.PurgeDeletedSandboxes(ctx, time.Now().Add(-retention))
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge deleted sandboxes: %w", err)
+	}
+	return n, nil
+}
+
+// DeactivateExpiredClients marks active API clients past their expiry inactive
+// and returns them
+func (m *DockerManager) DeactivateExpiredClients(ctx context.Context) ([]*models.ApiClient, error) {
+	clients, err := m.repo.DeactivateExpiredClients(ctx, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to deactivate expired clients: %w", err)
+	}
+	return clients, nil
 }
 
 // List returns sandboxes matching filters
@@ -563,7 +1351,18 @@ func (m *DockerManager) List(ctx context.Context, filters models.ListFilters) ([
 	return sandboxes, nil
 }
 
-// ExtendTTL extends the sandbox expiration time
+// Count returns how many sandboxes match filters, regardless of pagination
+func (m *DockerManager) Count(ctx context.Context, filters models.ListFilters) (int, error) {
+	count, err := m.repo.CountSandboxes(ctx, filters)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count sandboxes: %w", err)
+	}
+
+	return count, nil
+}
+
+// ExtendTTL extends the sandbox expiration time. It fails with a
+// *TTLCapError when the sandbox would outlive its TTL cap.
 func (m *DockerManager) ExtendTTL(ctx context.Context, id string, duration time.Duration) error {
 	sb, err := m.repo.GetSandbox(ctx, id)
 	if err != nil {
@@ -574,45 +1373,168 @@ func (m *DockerManager) ExtendTTL(ctx context.Context, id string, duration time.
 		return ErrSandboxNotFound
 	}
 
+	// Stopped sandboxes can be extended so they can be started again before expiring
 	if sb.Status.IsTerminal() {
-		return ErrSandboxStopped
+		return ErrSandboxNotActive
 	}
 
-	sb.ExpiresAt = sb.ExpiresAt.Add(duration)
+	expiresAt := sb.ExpiresAt.Add(duration)
+	limit := m.ttlCap(m.templateLoader.Get(sb.TemplateID))
+	if lifetime := expiresAt.Sub(sb.LifetimeStart()); limit > 0 && lifetime > limit {
+		return &TTLCapError{Requested: lifetime.Round(time.Second), Max: limit}
+	}
+	sb.ExpiresAt = expiresAt
+	recordTTLCap(sb, limit)
 
 	if err := m.repo.UpdateSandbox(ctx, sb); err != nil {
 		return fmt.Errorf("failed to update sandbox TTL: %w", err)
 	}
+	m.extendSandboxSession(ctx, id, sb.ExpiresAt, duration)
 
+	m.RecordEvent(ctx, id, models.EventTTLExtended, fmt.Sprintf("extended by %s to %s", duration, sb.ExpiresAt.UTC().Format(time.RFC3339)))
 	slog.Info("sandbox TTL extended", "id", id, "new_expires_at", sb.ExpiresAt)
 
 	return nil
 }
 
-// GetLogs retrieves container logs
-func (m *DockerManager) GetLogs(ctx context.Context, id string, tail int) (string, error) {
+// Metadata keys recording automatic TTL extensions
+const (
+	metaAutoExtendCount = "auto_extend_count"
+	metaAutoExtendTotal = "auto_extend_total"
+	metaAutoExtendLast  = "auto_extend_last_at"
+)
+
+// autoExtendPolicy resolves the effective auto-extend policy for a template,
+// with template values overriding the global configuration
+func (m *DockerManager) autoExtendPolicy(tmpl *models.Template) config.AutoExtendConfig {
+	policy := m.sandboxConfig.AutoExtend
+	if tmpl == nil || tmpl.AutoExtend == nil {
+		return policy
+	}
+
+	override := tmpl.AutoExtend
+	if override.Enabled != nil {
+		policy.Enabled = *override.Enabled
+	}
+	if override.Window > 0 {
+		policy.Window = override.Window
+	}
+	if override.Step > 0 {
+		policy.Step = override.Step
+	}
+	if override.MaxTTL > 0 {
+		policy.MaxTTL = override.MaxTTL
+	}
+	return policy
+}
+
+// AutoExtendTTL extends a running sandbox's TTL in response to terminal activity
+// if it is within the policy window of expiry and below the hard cap.
+// Returns the new expiry time, or nil if no extension was granted. The write
+// only lands while the sandbox still has the expiry it was read with, so of
+// concurrent extensions one is granted and a stopped sandbox stays stopped.
+func (m *DockerManager) AutoExtendTTL(ctx context.Context, id string) (*time.Time, error) {
 	sb, err := m.repo.GetSandbox(ctx, id)
 	if err != nil {
-		return "", fmt.Errorf("failed to get sandbox: %w", err)
+		return nil, fmt.Errorf("failed to get sandbox: %w", err)
 	}
 
 	if sb == nil {
-		return "", ErrSandboxNotFound
+		return nil, ErrSandboxNotFound
+	}
+
+	if !sb.Status.IsRunning() {
+		return nil, nil
+	}
+
+	tmpl := m.templateLoader.Get(sb.TemplateID)
+	policy := m.autoExtendPolicy(tmpl)
+	if !policy.Enabled || policy.Step <= 0 {
+		return nil, nil
+	}
+	limit := m.ttlCap(tmpl)
+	if limit > 0 && (policy.MaxTTL <= 0 || limit < policy.MaxTTL) {
+		policy.MaxTTL = limit
+	}
+
+	if time.Until(sb.ExpiresAt) > policy.Window {
+		return nil, nil
+	}
+
+	// Cap total lifetime, measured from when the sandbox started
+	newExpiry := sb.ExpiresAt.Add(policy.Step)
+	if policy.MaxTTL > 0 {
+		if end := sb.LifetimeStart().Add(policy.MaxTTL); newExpiry.After(end) {
+			newExpiry = end
+		}
+	}
+	if !newExpiry.After(sb.ExpiresAt) {
+		return nil, nil
+	}
+
+	granted := newExpiry.Sub(sb.ExpiresAt)
+	from := sb.ExpiresAt
+	sb.ExpiresAt = newExpiry
+
+	// Record the extension for auditing
+	if sb.Metadata == nil {
+		sb.Metadata = make(map[string]string)
+	}
+	count, _ := strconv.Atoi(sb.Metadata[metaAutoExtendCount])
+	total, _ := time.ParseDuration(sb.Metadata[metaAutoExtendTotal])
+	sb.Metadata[metaAutoExtendCount] = strconv.Itoa(count + 1)
+	sb.Metadata[metaAutoExtendTotal] = (total + granted).String()
+	sb.Metadata[metaAutoExtendLast] = time.Now().UTC().Format(time.RFC3339)
+	recordTTLCap(sb, limit)
+
+	extended, err := m.repo.ExtendSandboxFrom(ctx, sb, from)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update sandbox TTL: %w", err)
+	}
+	if !extended {
+		return nil, nil
 	}
 
-	if sb.ContainerID == "" {
-		return "", nil
+	m.extendSandboxSession(ctx, id, newExpiry, granted)
+
+	m.RecordEvent(ctx, id, models.EventTTLExtended, fmt.Sprintf("auto-extended by %s to %s", granted, newExpiry.UTC().Format(time.RFC3339)))
+	slog.Info("sandbox TTL auto-extended", "id", id, "granted", granted, "new_expires_at", newExpiry)
+	return &newExpiry, nil
+}
+
+// extendSandboxSession keeps the session owning an extended sandbox in sync:
+// its expiry, which also drives cleanup, moves to expiresAt, and the time
+// granted counts towards its ExtendedSeconds like a session extension. The
+// write is conditional on the session still being active, so a revoke,
+// submission or expiry racing the extension wins and the session isn't
+// extended.
+func (m *DockerManager) extendSandboxSession(ctx context.Context, sandboxID string, expiresAt time.Time, granted time.Duration) {
+	session, err := m.repo.GetSessionBySandboxID(ctx, sandboxID)
+	if err != nil {
+		slog.Warn("failed to get session of extended sandbox", "error", err, "sandbox_id", sandboxID)
+		return
+	}
+	if session == nil || session.ExpiresAt == nil || session.Status != models.SessionActive {
+		return
 	}
 
-	options := container.LogsOptions{
-		ShowStdout: true,
-		ShowStderr: true,
-		Tail:       fmt.Sprintf("%d", tail),
+	session.ExpiresAt = &expiresAt
+	session.ExtendedSeconds += int(granted.Seconds())
+	extended, err := m.repo.UpdateSessionFrom(ctx, session, models.SessionActive)
+	if err != nil {
+		slog.Warn("failed to extend session expiry", "error", err, "session_id", session.ID)
+		return
+	}
+	if !extended {
+		slog.Info("session no longer active, expiry not extended", "session_id", session.ID, "sandbox_id", sandboxID)
 	}
+}
 
-	logs, err := m.docker.ContainerLogs(ctx, sb.ContainerID, options)
+// GetLogs retrieves container logs
+func (m *DockerManager) GetLogs(ctx context.Context, id string, opts LogOptions) (string, error) {
+	logs, err := m.openLogs(ctx, id, opts, false)
 	if err != nil {
-		return "", fmt.Errorf("failed to get logs: %w", err)
+		return "", err
 	}
 	defer logs.Close()
 
@@ -624,9 +1546,99 @@ func (m *DockerManager) GetLogs(ctx context.Context, id string, tail int) (strin
 	return string(data), nil
 }
 
-// GetExpired returns all expired sandboxes
-func (m *DockerManager) GetExpired(ctx context.Context) ([]*models.Sandbox, error) {
-	sandboxes, err := m.repo.GetExpiredSandboxes(ctx)
+// GetServices returns the service instances of a sandbox
+func (m *DockerManager) GetServices(ctx context.Context, id string) ([]*models.ServiceInstance, error) {
+	sb, err := m.repo.GetSandbox(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sandbox: %w", err)
+	}
+
+	if sb == nil {
+		return nil, ErrSandboxNotFound
+	}
+
+	services, err := m.repo.GetServices(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get services: %w", err)
+	}
+
+	return services, nil
+}
+
+// GetService returns a single service instance of a sandbox
+func (m *DockerManager) GetService(ctx context.Context, id, name string) (*models.ServiceInstance, error) {
+	services, err := m.GetServices(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, svc := range services {
+		if svc.Name == name {
+			return svc, nil
+		}
+	}
+
+	return nil, ErrServiceNotFound
+}
+
+// CheckService runs the provider connectivity check against the instance
+// credentials and stores the resulting status
+func (m *DockerManager) CheckService(ctx context.Context, id, name string) (*models.ServiceInstance, error) {
+	svc, err := m.GetService(ctx, id, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if svc.IsDedicated() {
+		// The engine may not reach the sandbox network; its container runs no check
+		return nil, ErrCheckUnsupported
+	}
+	provider := m.serviceRegistry.Get(svc.Type)
+	if provider == nil {
+		return nil, ErrCheckUnsupported
+	}
+	if svc.Status == models.ServiceStatusProvisioning {
+		return nil, ErrServiceNotReady
+	}
+
+	if err := checkServiceInstance(ctx, provider, svc); err != nil {
+		return nil, err
+	}
+
+	if err := m.repo.UpdateService(ctx, id, svc); err != nil {
+		return nil, fmt.Errorf("failed to update service status: %w", err)
+	}
+
+	slog.Info("service checked", "sandbox_id", id, "service", name, "status", svc.Status)
+
+	return svc, nil
+}
+
+// checkServiceInstance runs the credential check and records the outcome on svc.
+// A failing check is not an error: it marks the instance unhealthy.
+func checkServiceInstance(ctx context.Context, provider services.Provider, svc *models.ServiceInstance) error {
+	checker, ok := provider.(services.CredentialChecker)
+	if !ok {
+		return ErrCheckUnsupported
+	}
+
+	now := time.Now()
+	svc.LastCheckedAt = &now
+
+	if err := checker.CheckCredentials(ctx, svc.Credentials); err != nil {
+		svc.Status = models.ServiceStatusUnhealthy
+		svc.StatusMsg = err.Error()
+		return nil
+	}
+
+	svc.Status = models.ServiceStatusReady
+	svc.StatusMsg = ""
+	return nil
+}
+
+// GetExpired returns up to limit expired sandboxes, oldest first, without their services
+func (m *DockerManager) GetExpired(ctx context.Context, limit int) ([]*models.Sandbox, error) {
+	sandboxes, err := m.repo.GetExpiredSandboxes(ctx, limit)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get expired sandboxes: %w", err)
 	}
@@ -634,14 +1646,59 @@ func (m *DockerManager) GetExpired(ctx context.Context) ([]*models.Sandbox, erro
 	return sandboxes, nil
 }
 
+// GetDeleting returns up to limit sandboxes whose delete is waiting for a
+// retry, oldest first
+func (m *DockerManager) GetDeleting(ctx context.Context, limit int) ([]*models.Sandbox, error) {
+	sandboxes, err := m.repo.ListSandboxes(ctx, models.ListFilters{Status: models.StatusDeleting, Limit: limit, Ascending: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deleting sandboxes: %w", err)
+	}
+
+	return sandboxes, nil
+}
+
+// CountExpired returns how many expired sandboxes and sessions are waiting for cleanup
+func (m *DockerManager) CountExpired(ctx context.Context) (sandboxes, sessions int, err error) {
+	sandboxes, err = m.repo.CountExpiredSandboxes(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to count expired sandboxes: %w", err)
+	}
+	sessions, err = m.repo.CountExpiredSessions(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to count expired sessions: %w", err)
+	}
+	return sandboxes, sessions, nil
+}
+
+// GetIdle returns running sandboxes with no terminal activity for at least idleFor
+func (m *DockerManager) GetIdle(ctx context.Context, idleFor time.Duration) ([]*models.Sandbox, error) {
+	sandboxes, err := m.repo.GetIdleSandboxes(ctx, time.Now().Add(-idleFor))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get idle sandboxes: %w", err)
+	}
+
+	return sandboxes, nil
+}
+
+// RecordActivity marks terminal activity on a sandbox
+func (m *DockerManager) RecordActivity(ctx context.Context, id string) error {
+	return m.repo.TouchSandboxActivity(ctx, id, time.Now())
+}
+
 // ExecAttach creates an interactive exec session to a container
-func (m *DockerManager) ExecAttach(ctx context.Context, containerID string) (string, io.ReadWriteCloser, error) {
+func (m *DockerManager) ExecAttach(ctx context.Context, containerID string, opts ExecAttachOptions) (string, io.ReadWriteCloser, error) {
+	cmd := opts.Cmd
+	if len(cmd) == 0 {
+		cmd = loginShell(ctx, m.containers, containerID)
+	}
+
 	execConfig := types.ExecConfig{
+		User:         opts.User,
 		AttachStdin:  true,
 		AttachStdout: true,
 		AttachStderr: true,
 		Tty:          true,
-		Cmd:          []string{"/bin/bash", "--login"},
+		Cmd:          cmd,
 		Env: []string{
 			"TERM=xterm-256color",
 			"COLORTERM=truecolor",
@@ -665,6 +1722,14 @@ func (m *DockerManager) ExecAttach(ctx context.Context, containerID string) (str
 
 // Close cleans up manager resources
 func (m *DockerManager) Close() error {
+	// Stop accepting new sandboxes and let in-flight provisioning finish or roll back
+	m.drain()
+
+	// Let detached teardown work finish before closing its dependencies
+	if !m.ops.wait(backgroundTimeout) {
+		slog.Warn("background operations still running at shutdown")
+	}
+
 	// Close database connection
 	if err := m.repo.Close(); err != nil {
 		slog.Warn("failed to close repository", "error", err)
@@ -685,10 +1750,9 @@ func (m *DockerManager) ExecResize(ctx context.Context, execID string, height, w
 
 // CreateSession creates a deferred sandbox session (no container yet)
 func (m *DockerManager) CreateSession(ctx context.Context, req models.CreateSessionRequest, createdBy string) (*models.Session, error) {
-	// Validate template exists
-	tmpl := m.templateLoader.Get(req.TemplateID)
-	if tmpl == nil {
-		return nil, ErrTemplateNotFound
+	task, tmpl, err := m.sessionTemplate(&req)
+	if err != nil {
+		return nil, err
 	}
 
 	token, err := models.GenerateSessionToken()
@@ -696,15 +1760,23 @@ func (m *DockerManager) CreateSession(ctx context.Context, req models.CreateSess
 		return nil, fmt.Errorf("failed to generate session token: %w", err)
 	}
 
-	ttl := req.TTL
-	if ttl == 0 {
-		ttl = int(tmpl.TTL.Seconds())
-	}
-	if ttl == 0 {
-		ttl = 3600 // 1 hour default
+	ttl, err := m.sessionTTL(req, task, tmpl)
+	if err != nil {
+		return nil, err
 	}
 
 	id := uuid.New().String()
+	now := time.Now()
+
+	joinBy := m.joinDeadline(req, now)
+	if err := checkPrewarm(req, joinBy); err != nil {
+		return nil, err
+	}
+	if req.CallbackURL != "" {
+		if err := checkCallbackURL(req.CallbackURL, m.callbackNets); err != nil {
+			return nil, err
+		}
+	}
 
 	session := &models.Session{
 		ID:              id,
@@ -716,8 +1788,25 @@ func (m *DockerManager) CreateSession(ctx context.Context, req models.CreateSess
 		Services:        req.Services,
 		TTLSeconds:      ttl,
 		TaskDescription: req.TaskDescription,
-		CreatedAt:       time.Now(),
+		CreatedAt:       now,
 		CreatedBy:       createdBy,
+		UniqueKey:       req.UniqueKey,
+		JoinBy:          joinBy,
+		PrewarmAt:       req.PrewarmAt,
+		CallbackURL:     req.CallbackURL,
+		RecordTerminal:  req.RecordTerminal,
+		OwnerClientID:   req.OwnerClientID,
+	}
+	if session.CallbackURL != "" {
+		if session.CallbackSecret, err = models.GenerateSessionToken(); err != nil {
+			return nil, fmt.Errorf("failed to generate callback secret: %w", err)
+		}
+	}
+	if session.UniqueKey != "" {
+		session.OnConflict = req.OnConflict
+		if session.OnConflict == "" {
+			session.OnConflict = models.ConflictReject
+		}
 	}
 
 	if session.Env == nil {
@@ -726,15 +1815,65 @@ func (m *DockerManager) CreateSession(ctx context.Context, req models.CreateSess
 	if session.Metadata == nil {
 		session.Metadata = make(map[string]string)
 	}
+	if task != nil {
+		// Carried into the sandbox's metadata, which attributes its usage to the task
+		session.Metadata[models.MetadataTaskID] = task.ID
+	}
 
 	if err := m.repo.CreateSession(ctx, session); err != nil {
 		return nil, fmt.Errorf("failed to create session: %w", err)
 	}
 
-	slog.Info("session created", "id", id, "template", req.TemplateID, "ttl", ttl)
+	slog.Info("session created", "id", id, "template", req.TemplateID, "task", req.TaskID, "ttl", ttl)
 	return session, nil
 }
 
+// joinDeadline is the join deadline of a session created at now, nil for none
+func (m *DockerManager) joinDeadline(req models.CreateSessionRequest, now time.Time) *time.Time {
+	joinTTL := time.Duration(req.JoinTTL) * time.Second
+	if joinTTL == 0 {
+		joinTTL = m.sandboxConfig.SessionJoinTTL
+	}
+	if joinTTL <= 0 {
+		return nil
+	}
+	joinBy := now.Add(joinTTL)
+	return &joinBy
+}
+
+// checkPrewarm rejects a prewarm time the join deadline would cut off, since
+// the sandbox would be torn down as soon as it was created
+func checkPrewarm(req models.CreateSessionRequest, joinBy *time.Time) error {
+	if req.PrewarmAt != nil && joinBy != nil && !req.PrewarmAt.Before(*joinBy) {
+		return ErrPrewarmTooLate
+	}
+	return nil
+}
+
+// sessionTemplate resolves the catalog task and enabled template of a new
+// session, defaulting req.TemplateID to the task's project template
+func (m *DockerManager) sessionTemplate(req *models.CreateSessionRequest) (*models.CatalogTask, *models.Template, error) {
+	var task *models.CatalogTask
+	if req.TaskID != "" {
+		if task = m.templateLoader.GetTask(req.TaskID); task == nil {
+			return nil, nil, ErrTaskNotFound
+		}
+		if req.TemplateID == "" {
+			// Projects are registered as templates under their ID
+			req.TemplateID = task.ProjectID
+		}
+	}
+
+	tmpl := m.templateLoader.Get(req.TemplateID)
+	if tmpl == nil {
+		return nil, nil, ErrTemplateNotFound
+	}
+	if !tmpl.IsEnabled() {
+		return nil, nil, ErrTemplateDisabled
+	}
+	return task, tmpl, nil
+}
+
 // GetSessionByToken retrieves a session by its join token
 func (m *DockerManager) GetSessionByToken(ctx context.Context, token string) (*models.Session, error) {
 	session, err := m.repo.GetSessionByToken(ctx, token)
@@ -747,7 +1886,7 @@ func (m *DockerManager) GetSessionByToken(ctx context.Context, token string) (*m
 	return session, nil
 }
 
-// GetSessionByID retrieves a session by ID
+// GetSessionByID retrieves a session by ID, with its callback deliveries
 func (m *DockerManager) GetSessionByID(ctx context.Context, id string) (*models.Session, error) {
 	session, err := m.repo.GetSessionByID(ctx, id)
 	if err != nil {
@@ -756,6 +1895,11 @@ func (m *DockerManager) GetSessionByID(ctx context.Context, id string) (*models.
 	if session == nil {
 		return nil, ErrSessionNotFound
 	}
+	if session.CallbackURL != "" {
+		if session.CallbackDeliveries, err = m.repo.ListCallbackDeliveries(ctx, id); err != nil {
+			return nil, fmt.Errorf("failed to list callback deliveries: %w", err)
+		}
+	}
 	return session, nil
 }
 
@@ -769,11 +1913,35 @@ func (m *DockerManager) ActivateSession(ctx context.Context, token string) (*mod
 		return nil, ErrSessionNotFound
 	}
 
+	if session.JoinExpired() {
+		// The cleaner hasn't got to it yet
+		if _, err := m.ExpireUnjoinedSession(ctx, session.ID); err != nil {
+			return nil, err
+		}
+		return m.GetSessionByID(ctx, session.ID)
+	}
+
 	if !session.IsActivatable() {
 		// Already activated or failed — return current state
 		return session, nil
 	}
 
+	// A reload may have dropped the template since the session was created;
+	// fail the session for good instead of failing every activation attempt
+	if m.templateLoader.Get(session.TemplateID) == nil {
+		if err := m.failSessionTemplateMissing(ctx, session); err != nil {
+			return nil, err
+		}
+		return session, nil
+	}
+
+	key, err := m.beginProvision(provisionSessionKind, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	session.TTLSeconds = m.activationTTL(session)
+
 	// Transition to provisioning
 	session.Status = models.SessionProvisioning
 	now := time.Now()
@@ -781,51 +1949,127 @@ func (m *DockerManager) ActivateSession(ctx context.Context, token string) (*mod
 	expiresAt := now.Add(time.Duration(session.TTLSeconds) * time.Second)
 	session.ExpiresAt = &expiresAt
 
-	if err := m.repo.UpdateSession(ctx, session); err != nil {
-		return nil, fmt.Errorf("failed to update session: %w", err)
+	claimed, err := m.claimSession(ctx, session)
+	if err != nil {
+		m.provisioning.done(key)
+		return nil, err
+	}
+	if !claimed {
+		// A concurrent activation (a retry, a second tab) won; report its state
+		m.provisioning.done(key)
+		current, err := m.repo.GetSessionByID(ctx, session.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get session: %w", err)
+		}
+		if current == nil {
+			return nil, ErrSessionNotFound
+		}
+		return current, nil
 	}
 
 	// Create sandbox in background
-	go m.provisionSessionSandbox(context.Background(), session)
+	m.runProvision(ctx, key, func(ctx context.Context) {
+		m.provisionSessionSandbox(ctx, session)
+	})
 
 	slog.Info("session activated", "id", session.ID, "token", session.Token)
 	return session, nil
 }
 
-// provisionSessionSandbox creates a sandbox for an activated session
+// activationTTL is the runtime of a session being activated: its TTL, capped
+// by the time limit its catalog task and the TTL cap its template have at
+// activation
+func (m *DockerManager) activationTTL(session *models.Session) int {
+	ttl := session.TTLSeconds
+	task := m.templateLoader.GetTask(session.TaskID())
+	if task != nil && task.TimeLimit > 0 && task.TimeLimit < ttl {
+		ttl = task.TimeLimit
+	}
+	if limit := int(m.ttlCap(m.templateLoader.Get(session.TemplateID)).Seconds()); limit > 0 && limit < ttl {
+		ttl = limit
+	}
+	return ttl
+}
+
+// provisionSessionSandbox creates a sandbox for an activated session, or binds
+// it to the one pre-warmed for it
 func (m *DockerManager) provisionSessionSandbox(ctx context.Context, session *models.Session) {
+	if sb := m.prewarmedSandbox(ctx, session); sb != nil {
+		slog.Info("binding session to prewarmed sandbox", "session_id", session.ID, "sandbox_id", sb.ID)
+		m.awaitSessionSandbox(ctx, session, sb.ID, true)
+		return
+	}
+
 	ttl := time.Duration(session.TTLSeconds) * time.Second
 
 	sb, err := m.Create(ctx, session.TemplateID, session.CreatedBy, CreateOptions{
-		TTL:      &ttl,
-		Env:      session.Env,
-		Metadata: session.Metadata,
-		Services: session.Services,
+		TTL:           &ttl,
+		Env:           session.Env,
+		Metadata:      session.Metadata,
+		Services:      session.Services,
+		FromSession:   true,
+		OwnerClientID: session.OwnerClientID,
 	})
 
+	if errors.Is(err, ErrTemplateNotFound) {
+		// Removed between activation and provisioning
+		if err := m.failSessionTemplateMissing(ctx, session); err != nil {
+			slog.Error("failed to update session", "error", err, "session_id", session.ID)
+		}
+		return
+	}
 	if err != nil {
 		slog.Error("failed to create sandbox for session", "error", err, "session_id", session.ID)
 		session.Status = models.SessionFailed
 		session.StatusMessage = fmt.Sprintf("failed to create sandbox: %v", err)
-		m.repo.UpdateSession(ctx, session)
+		m.updateProvisioningSession(ctx, session)
 		return
 	}
 
 	session.SandboxID = sb.ID
-	m.repo.UpdateSession(ctx, session)
+	if !m.updateProvisioningSession(ctx, session) {
+		// Revoked or failed while the sandbox was being created
+		if err := m.Delete(ctx, sb.ID); err != nil && !errors.Is(err, ErrOperationPending) {
+			slog.Warn("failed to delete revoked session sandbox", "error", err, "sandbox_id", sb.ID)
+		}
+		return
+	}
 
-	// Wait for sandbox to become running
-	for i := 0; i < 30; i++ {
-		time.Sleep(1 * time.Second)
+	m.awaitSessionSandbox(ctx, session, sb.ID, false)
+}
 
-		sb, err = m.repo.GetSandbox(ctx, sb.ID)
+// awaitSessionSandbox makes the session active once its sandbox runs, or fails
+// it. A prewarmed sandbox was created with a TTL covering the wait for the
+// candidate; it is cut to the session's expiry so the clock starts at activation.
+func (m *DockerManager) awaitSessionSandbox(ctx context.Context, session *models.Session, sandboxID string, prewarmed bool) {
+	// Wait for sandbox to become running; provisioning fails the sandbox itself on timeout,
+	// the extra margin only covers the final status write
+	deadline := time.Now().Add(m.provisionTimeout() + phaseMargin)
+	for first := true; time.Now().Before(deadline); first = false {
+		// A prewarmed sandbox is usually running already
+		if !first || !prewarmed {
+			select {
+			case <-time.After(1 * time.Second):
+			case <-ctx.Done():
+				// Shutdown gave up waiting; Close marks the session failed
+				return
+			}
+		}
+
+		sb, err := m.repo.GetSandbox(ctx, sandboxID)
 		if err != nil || sb == nil {
 			continue
 		}
 
 		if sb.Status == models.StatusRunning {
+			if prewarmed && session.ExpiresAt != nil {
+				sb.ExpiresAt = *session.ExpiresAt
+				if err := m.repo.UpdateSandbox(ctx, sb); err != nil {
+					slog.Error("failed to update prewarmed sandbox TTL", "error", err, "sandbox_id", sb.ID)
+				}
+			}
 			session.Status = models.SessionActive
-			m.repo.UpdateSession(ctx, session)
+			m.updateProvisioningSession(ctx, session)
 			slog.Info("session sandbox ready", "session_id", session.ID, "sandbox_id", sb.ID)
 			return
 		}
@@ -833,7 +2077,7 @@ func (m *DockerManager) provisionSessionSandbox(ctx context.Context, session *mo
 		if sb.Status == models.StatusFailed {
 			session.Status = models.SessionFailed
 			session.StatusMessage = sb.StatusMsg
-			m.repo.UpdateSession(ctx, session)
+			m.updateProvisioningSession(ctx, session)
 			slog.Error("session sandbox failed", "session_id", session.ID, "sandbox_id", sb.ID)
 			return
 		}
@@ -842,10 +2086,33 @@ func (m *DockerManager) provisionSessionSandbox(ctx context.Context, session *mo
 	// Timeout
 	session.Status = models.SessionFailed
 	session.StatusMessage = "sandbox provisioning timed out"
-	m.repo.UpdateSession(ctx, session)
+	m.updateProvisioningSession(ctx, session)
 	slog.Error("session sandbox timed out", "session_id", session.ID)
 }
 
+// updateProvisioningSession stores a provisioning update while the stored
+// session is still provisioning, so a revoke (or a shutdown failing it) in
+// the meantime isn't overwritten. It reports whether the update was stored.
+func (m *DockerManager) updateProvisioningSession(ctx context.Context, session *models.Session) bool {
+	updated, err := m.repo.UpdateSessionFrom(ctx, session, models.SessionProvisioning)
+	if err != nil {
+		slog.Error("failed to update session", "error", err, "session_id", session.ID)
+		return true
+	}
+	if !updated {
+		slog.Info("session left provisioning meanwhile", "session_id", session.ID)
+		return false
+	}
+
+	switch session.Status {
+	case models.SessionActive:
+		m.SendSessionCallback(ctx, session, models.CallbackActivated)
+	case models.SessionFailed:
+		m.SendSessionCallback(ctx, session, models.CallbackFailed)
+	}
+	return true
+}
+
 // DeleteSession deletes a session and its sandbox if exists
 func (m *DockerManager) DeleteSession(ctx context.Context, id string) error {
 	session, err := m.repo.GetSessionByID(ctx, id)
@@ -858,7 +2125,7 @@ func (m *DockerManager) DeleteSession(ctx context.Context, id string) error {
 
 	// Delete sandbox if it was created
 	if session.SandboxID != "" {
-		if err := m.Delete(ctx, session.SandboxID); err != nil {
+		if err := m.Delete(ctx, session.SandboxID); err != nil && !errors.Is(err, ErrOperationPending) {
 			slog.Warn("failed to delete session sandbox", "error", err, "sandbox_id", session.SandboxID)
 		}
 	}
@@ -866,19 +2133,246 @@ func (m *DockerManager) DeleteSession(ctx context.Context, id string) error {
 	if err := m.repo.DeleteSession(ctx, id); err != nil {
 		return fmt.Errorf("failed to delete session: %w", err)
 	}
+	if err := m.submissions().remove(id); err != nil {
+		slog.Warn("failed to remove session submission", "error", err, "id", id)
+	}
 
 	slog.Info("session deleted", "id", id)
 	return nil
 }
 
+// RevokeSession ends a session for good while keeping its record: the join
+// token stops working at once, then the sandbox is deleted. The record stays
+// until PurgeRevokedSessions removes it.
+func (m *DockerManager) RevokeSession(ctx context.Context, id, revokedBy string) (*models.Session, error) {
+	session, err := m.repo.GetSessionByID(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	if session == nil {
+		return nil, ErrSessionNotFound
+	}
+	if session.Status == models.SessionRevoked {
+		return nil, ErrSessionRevoked
+	}
+
+	// Conditional, and the sandbox is read in the same statement, so one that
+	// prewarming attached after the read above is deleted too
+	now := time.Now()
+	sandboxID, revoked, err := m.repo.RevokeSession(ctx, id, revokedBy, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to revoke session: %w", err)
+	}
+	if !revoked {
+		return nil, ErrSessionRevoked
+	}
+	session.Status = models.SessionRevoked
+	session.RevokedAt = &now
+	session.RevokedBy = revokedBy
+	session.SandboxID = sandboxID
+
+	// A session still provisioning has no sandbox yet; provisionSessionSandbox
+	// deletes it once created
+	if session.SandboxID != "" {
+		if err := m.Delete(ctx, session.SandboxID); err != nil && !errors.Is(err, ErrOperationPending) && !errors.Is(err, ErrSandboxNotFound) {
+			slog.Warn("failed to delete revoked session sandbox", "error", err, "sandbox_id", session.SandboxID)
+		}
+	}
+
+	slog.Info("session revoked", "id", id, "by", revokedBy, "sandbox_id", session.SandboxID)
+	return session, nil
+}
+
+// PurgeRevokedSessions removes the records of sessions revoked more than retention ago
+func (m *DockerManager) PurgeRevokedSessions(ctx context.Context, retention time.Duration) (int64, error) {
+	n, err := m.repo.PurgeRevokedSessions(ctx, time.Now().Add(-retention))
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge revoked sessions: %w", err)
+	}
+	return n, nil
+}
+
+// ExtendSession gives an active session more time. The session expiry and
+// its sandbox's TTL move together in one transaction, since both drive cleanup.
+// It fails with a *TTLCapError when the session would outlive its TTL cap,
+// and with ErrSessionNotActive when the session leaves active before the write.
+func (m *DockerManager) ExtendSession(ctx context.Context, id string, duration time.Duration) (*models.Session, error) {
+	var extended *models.Session
+	err := m.repo.WithTx(ctx, func(tx storage.Repository) error {
+		session, err := tx.GetSessionByID(ctx, id)
+		if err != nil {
+			return fmt.Errorf("failed to get session: %w", err)
+		}
+		if session == nil {
+			return ErrSessionNotFound
+		}
+		if session.Status != models.SessionActive || session.ExpiresAt == nil {
+			return ErrSessionNotActive
+		}
+		limit := m.ttlCap(m.templateLoader.Get(session.TemplateID))
+		if runtime := time.Duration(session.TTLSeconds+session.ExtendedSeconds)*time.Second + duration; limit > 0 && runtime > limit {
+			return &TTLCapError{Requested: runtime, Max: limit}
+		}
+
+		expiresAt := session.ExpiresAt.Add(duration)
+		session.ExpiresAt = &expiresAt
+		session.ExtendedSeconds += int(duration.Seconds())
+		// The read above takes no lock: a revoke, submission or expiry
+		// committed since then makes the update miss instead of being undone
+		updated, err := tx.UpdateSessionFrom(ctx, session, models.SessionActive)
+		if err != nil {
+			return fmt.Errorf("failed to update session expiry: %w", err)
+		}
+		if !updated {
+			return ErrSessionNotActive
+		}
+
+		if session.SandboxID != "" {
+			sb, err := tx.GetSandbox(ctx, session.SandboxID)
+			if err != nil {
+				return fmt.Errorf("failed to get sandbox: %w", err)
+			}
+			if sb != nil {
+				sb.ExpiresAt = sb.ExpiresAt.Add(duration)
+				recordTTLCap(sb, limit)
+				if err := tx.UpdateSandbox(ctx, sb); err != nil {
+					return fmt.Errorf("failed to update sandbox TTL: %w", err)
+				}
+				msg := fmt.Sprintf("session extended by %s to %s", duration, sb.ExpiresAt.UTC().Format(time.RFC3339))
+				if err := tx.AppendEvent(ctx, newEvent(sb.ID, models.EventTTLExtended, msg)); err != nil {
+					return err
+				}
+			}
+		}
+
+		extended = session
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("session extended", "id", id, "sandbox_id", extended.SandboxID, "new_expires_at", extended.ExpiresAt)
+	return extended, nil
+}
+
+// ExpireUnjoinedSession expires a ready session whose join deadline passed,
+// so its token stops working. It reports false when the session left ready
+// first, e.g. because the candidate started it in the meantime.
+func (m *DockerManager) ExpireUnjoinedSession(ctx context.Context, id string) (bool, error) {
+	expired, err := m.repo.ClaimSession(ctx, &models.Session{
+		ID:            id,
+		Status:        models.SessionExpired,
+		StatusMessage: models.StatusMsgJoinDeadline,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to expire session: %w", err)
+	}
+
+	if !expired {
+		return false, nil
+	}
+	slog.Info("session join deadline passed", "id", id)
+
+	session, err := m.repo.GetSessionByID(ctx, id)
+	if err != nil || session == nil {
+		slog.Warn("failed to get expired session", "error", err, "id", id)
+		return true, nil
+	}
+	m.SendSessionCallback(ctx, session, models.CallbackExpired)
+
+	// Don't keep paying for a sandbox pre-warmed for a candidate who never came
+	if session.SandboxID != "" {
+		if err := m.Delete(ctx, session.SandboxID); err != nil && !errors.Is(err, ErrOperationPending) && !errors.Is(err, ErrSandboxNotFound) {
+			slog.Warn("failed to delete prewarmed session sandbox", "error", err, "sandbox_id", session.SandboxID)
+		}
+	}
+	return true, nil
+}
+
+// ExpireSession ends an active session past its TTL: the session becomes
+// expired and its sandbox is deleted. Unlike DeleteSession the record stays,
+// with its terminal connections, so the session report outlives the expiry.
+// Only once the session is expired are its terminals told and its callback
+// sent. It reports false when the session is no longer active.
+func (m *DockerManager) ExpireSession(ctx context.Context, id string) (bool, error) {
+	session, err := m.repo.GetSessionByID(ctx, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to get session: %w", err)
+	}
+	if session == nil || session.Status != models.SessionActive {
+		return false, nil
+	}
+
+	session.Status = models.SessionExpired
+	expired, err := m.repo.UpdateSessionFrom(ctx, session, models.SessionActive)
+	if err != nil {
+		return false, fmt.Errorf("failed to expire session: %w", err)
+	}
+	if !expired {
+		return false, nil
+	}
+	slog.Info("session expired", "id", id, "sandbox_id", session.SandboxID)
+	m.SendSessionCallback(ctx, session, models.CallbackExpired)
+
+	if session.SandboxID != "" {
+		// Tell open terminals before the sandbox goes away
+		m.NotifySandbox(session.SandboxID, NoticeSessionExpired)
+		if err := m.Delete(ctx, session.SandboxID); err != nil && !errors.Is(err, ErrOperationPending) && !errors.Is(err, ErrSandboxNotFound) {
+			slog.Warn("failed to delete expired session sandbox", "error", err, "sandbox_id", session.SandboxID)
+		}
+	}
+	return true, nil
+}
+
 // ListSessions returns sessions matching filters
-func (m *DockerManager) ListSessions(ctx context.Context, status string, limit, offset int) ([]*models.Session, error) {
-	return m.repo.ListSessions(ctx, status, limit, offset)
+func (m *DockerManager) ListSessions(ctx context.Context, filters models.SessionFilters) ([]*models.Session, error) {
+	sessions, err := m.repo.ListSessions(ctx, m.sessionFilters(filters))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// CountSessions returns how many sessions match filters, regardless of pagination
+func (m *DockerManager) CountSessions(ctx context.Context, filters models.SessionFilters) (int, error) {
+	count, err := m.repo.CountSessions(ctx, m.sessionFilters(filters))
+	if err != nil {
+		return 0, fmt.Errorf("failed to count sessions: %w", err)
+	}
+	return count, nil
+}
+
+// sessionFilters fills in the loaded templates for SessionFilterInvalidTemplate,
+// by every ID sessions can reference them with
+func (m *DockerManager) sessionFilters(filters models.SessionFilters) models.SessionFilters {
+	if filters.Status == models.SessionFilterInvalidTemplate {
+		filters.KnownTemplates = m.templateLoader.IDs()
+	}
+	return filters
+}
+
+// failSessionTemplateMissing fails a session whose template no longer exists
+func (m *DockerManager) failSessionTemplateMissing(ctx context.Context, session *models.Session) error {
+	slog.Warn("session template no longer exists", "session_id", session.ID, "template", session.TemplateID)
+
+	from := session.Status
+	session.Status = models.SessionFailed
+	session.StatusMessage = models.StatusMsgTemplateMissing + session.TemplateID
+	updated, err := m.repo.UpdateSessionFrom(ctx, session, from)
+	if err != nil {
+		return fmt.Errorf("failed to update session: %w", err)
+	}
+	if !updated {
+		return ErrSessionChanged
+	}
+	m.SendSessionCallback(ctx, session, models.CallbackFailed)
+	return nil
 }
 
-// GetExpiredSessions returns all active sessions past their TTL
-func (m *DockerManager) GetExpiredSessions(ctx context.Context) ([]*models.Session, error) {
-	sessions, err := m.repo.GetExpiredSessions(ctx)
+// GetExpiredSessions returns up to limit active sessions past their TTL, oldest first
+func (m *DockerManager) GetExpiredSessions(ctx context.Context, limit int) ([]*models.Session, error) {
+	sessions, err := m.repo.GetExpiredSessions(ctx, limit)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get expired sessions: %w", err)
 	}
@@ -0,0 +1,657 @@
+package sandbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
+
+	"github.com/terra-clan/sandbox-engine/internal/config"
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/storage"
+	"github.com/terra-clan/sandbox-engine/internal/templates"
+)
+
+// basicProvider is a service provider without credential checks
+type basicProvider struct{}
+
+func (p *basicProvider) Provision(ctx context.Context, sandboxID, serviceName string, opts map[string]string) (*models.ServiceCredentials, error) {
+	return &models.ServiceCredentials{}, nil
+}
+
+func (p *basicProvider) Deprovision(ctx context.Context, sandboxID, serviceName string) error {
+	return nil
+}
+
+func (p *basicProvider) Type() string { return "fake" }
+
+func (p *basicProvider) HealthCheck(ctx context.Context) error { return nil }
+
+func (p *basicProvider) ListProvisioned(ctx context.Context) ([]string, error) { return nil, nil }
+
+// fakeProvider is a service provider whose credential check result is fixed
+type fakeProvider struct {
+	basicProvider
+	checkErr error
+}
+
+func (p *fakeProvider) CheckCredentials(ctx context.Context, creds *models.ServiceCredentials) error {
+	return p.checkErr
+}
+
+func TestCheckServiceInstance(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("pass", func(t *testing.T) {
+		svc := &models.ServiceInstance{Name: "postgres", Status: models.ServiceStatusUnhealthy, StatusMsg: "old failure"}
+		if err := checkServiceInstance(ctx, &fakeProvider{}, svc); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if svc.Status != models.ServiceStatusReady || svc.StatusMsg != "" {
+			t.Fatalf("expected ready with no message, got %q %q", svc.Status, svc.StatusMsg)
+		}
+		if svc.LastCheckedAt == nil {
+			t.Fatalf("expected LastCheckedAt to be set")
+		}
+	})
+
+	t.Run("fail", func(t *testing.T) {
+		svc := &models.ServiceInstance{Name: "redis", Status: models.ServiceStatusReady}
+		if err := checkServiceInstance(ctx, &fakeProvider{checkErr: errors.New("auth failed")}, svc); err != nil {
+			t.Fatalf("a failing check should not be an error, got %v", err)
+		}
+		if svc.Status != models.ServiceStatusUnhealthy || svc.StatusMsg != "auth failed" {
+			t.Fatalf("expected unhealthy with message, got %q %q", svc.Status, svc.StatusMsg)
+		}
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		svc := &models.ServiceInstance{Name: "other", Status: models.ServiceStatusReady}
+		if err := checkServiceInstance(ctx, &basicProvider{}, svc); !errors.Is(err, ErrCheckUnsupported) {
+			t.Fatalf("expected ErrCheckUnsupported, got %v", err)
+		}
+		if svc.Status != models.ServiceStatusReady || svc.LastCheckedAt != nil {
+			t.Fatalf("unsupported check must not change the instance")
+		}
+	})
+}
+
+func TestServiceInstanceRedacted(t *testing.T) {
+	svc := &models.ServiceInstance{
+		Name: "postgres",
+		Credentials: &models.ServiceCredentials{
+			Username: "u",
+			Password: "secret",
+			URI:      "postgres://u:secret@db:5432/x",
+			Token:    "session",
+		},
+	}
+
+	out := svc.Redacted()
+	if out.Credentials.Password == "secret" || out.Credentials.URI != "postgres://u:[REDACTED]@db:5432/x" || out.Credentials.Token == "session" {
+		t.Fatalf("credentials not redacted: %+v", out.Credentials)
+	}
+	if svc.Credentials.Password != "secret" {
+		t.Fatalf("Redacted must not modify the original")
+	}
+
+	if (&models.ServiceInstance{}).Redacted().Credentials != nil {
+		t.Fatalf("nil credentials should stay nil")
+	}
+}
+
+func TestBuildMetadataRecordsCommand(t *testing.T) {
+	tmpl := &models.Template{
+		Command:    []string{"npm", "run", "dev"},
+		Entrypoint: []string{"/bin/sh", "-c"},
+	}
+
+	metadata := buildMetadata(map[string]string{"owner": "qa"}, tmpl)
+	if metadata["owner"] != "qa" {
+		t.Errorf("expected request metadata to be kept, got %v", metadata)
+	}
+	if got := metadata[metaCommand]; got != `["npm","run","dev"]` {
+		t.Errorf("unexpected command metadata %q", got)
+	}
+	if got := metadata[metaEntrypoint]; got != `["/bin/sh","-c"]` {
+		t.Errorf("unexpected entrypoint metadata %q", got)
+	}
+
+	if metadata := buildMetadata(nil, &models.Template{}); metadata != nil {
+		t.Errorf("expected no metadata without a command, got %v", metadata)
+	}
+}
+
+func TestCreateRejectsCommandOverride(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fixed.yaml")
+	if err := os.WriteFile(path, []byte("name: fixed\nbase_image: alpine\ncommand: [\"sleep\", \"infinity\"]\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	loader := templates.NewLoader()
+	if err := loader.LoadFromFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &DockerManager{templateLoader: loader}
+	_, err := m.Create(context.Background(), "fixed", "user", CreateOptions{Command: []string{"bash"}})
+	if !errors.Is(err, ErrCommandOverrideNotAllowed) {
+		t.Fatalf("expected ErrCommandOverrideNotAllowed, got %v", err)
+	}
+}
+
+func TestExtendSession(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewMemoryRepository()
+	m := &DockerManager{repo: repo, templateLoader: templates.NewLoader()}
+
+	expires := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := repo.CreateSandbox(ctx, &models.Sandbox{ID: "sb-1", Status: models.StatusRunning, ExpiresAt: expires}); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []*models.Session{
+		{ID: "active", Token: "t1", Status: models.SessionActive, SandboxID: "sb-1", ExpiresAt: &expires},
+		{ID: "ready", Token: "t2", Status: models.SessionReady},
+	} {
+		if err := repo.CreateSession(ctx, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	session, err := m.ExtendSession(ctx, "active", 30*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := expires.Add(30 * time.Minute)
+	if session.ExpiresAt == nil || !session.ExpiresAt.Equal(want) {
+		t.Errorf("expected the session to expire at %s, got %v", want, session.ExpiresAt)
+	}
+	if sb, _ := repo.GetSandbox(ctx, "sb-1"); sb == nil || !sb.ExpiresAt.Equal(want) {
+		t.Errorf("expected the sandbox TTL extended too, got %+v", sb)
+	}
+	if events, _ := repo.ListEvents(ctx, "sb-1"); len(events) != 1 || events[0].Type != models.EventTTLExtended {
+		t.Errorf("expected a ttl_extended event, got %+v", events)
+	}
+
+	if _, err := m.ExtendSession(ctx, "ready", time.Minute); !errors.Is(err, ErrSessionNotActive) {
+		t.Errorf("expected ErrSessionNotActive for a ready session, got %v", err)
+	}
+	if _, err := m.ExtendSession(ctx, "missing", time.Minute); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("expected ErrSessionNotFound, got %v", err)
+	}
+}
+
+func TestExtendSessionLosesToRevoke(t *testing.T) {
+	ctx := context.Background()
+	mem := storage.NewMemoryRepository()
+	repo := &staleSessionRepo{Repository: mem}
+	m := &DockerManager{repo: repo, templateLoader: templates.NewLoader()}
+
+	expires := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := mem.CreateSandbox(ctx, &models.Sandbox{ID: "sb-1", Status: models.StatusRunning, ExpiresAt: expires}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.CreateSession(ctx, &models.Session{ID: "s-1", Token: "tok", Status: models.SessionActive, SandboxID: "sb-1", ExpiresAt: &expires}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The session is revoked after the extension read it as active
+	repo.race = func() { revokeSession(t, mem, "s-1") }
+	if _, err := m.ExtendSession(ctx, "s-1", 30*time.Minute); !errors.Is(err, ErrSessionNotActive) {
+		t.Fatalf("expected ErrSessionNotActive, got %v", err)
+	}
+
+	s, _ := mem.GetSessionByID(ctx, "s-1")
+	if s.Status != models.SessionRevoked || s.RevokedBy != "admin" || !s.ExpiresAt.Equal(expires) || s.ExtendedSeconds != 0 {
+		t.Errorf("expected the revoke kept and the session not extended, got %+v", s)
+	}
+	if sb, _ := mem.GetSandbox(ctx, "sb-1"); !sb.ExpiresAt.Equal(expires) {
+		t.Errorf("expected the sandbox TTL untouched, got %s", sb.ExpiresAt)
+	}
+}
+
+func TestAutoExtendTTL(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewMemoryRepository()
+	off := false
+	loader := templates.NewLoader()
+	loader.Add(&models.Template{Name: "quiet", AutoExtend: &models.AutoExtendPolicy{Enabled: &off}})
+	m := &DockerManager{repo: repo, templateLoader: loader}
+	m.sandboxConfig.AutoExtend = config.AutoExtendConfig{Enabled: true, Window: 10 * time.Minute, Step: 15 * time.Minute, MaxTTL: 2 * time.Hour}
+
+	started := time.Now().Add(-time.Hour).Truncate(time.Second)
+	create := func(id, template string, status models.SandboxStatus, expiresIn time.Duration) time.Time {
+		t.Helper()
+		expiresAt := time.Now().Add(expiresIn).Truncate(time.Second)
+		sb := &models.Sandbox{ID: id, TemplateID: template, Status: status, StartedAt: &started, ExpiresAt: expiresAt}
+		if err := repo.CreateSandbox(ctx, sb); err != nil {
+			t.Fatal(err)
+		}
+		return expiresAt
+	}
+
+	due := create("due", "", models.StatusRunning, 5*time.Minute)
+	create("far", "", models.StatusRunning, time.Hour)
+	create("stopped", "", models.StatusStopped, 5*time.Minute)
+	create("quiet", "quiet", models.StatusRunning, 5*time.Minute)
+	create("capped", "", models.StatusRunning, 5*time.Minute)
+
+	newExpiry, err := m.AutoExtendTTL(ctx, "due")
+	if err != nil || newExpiry == nil || !newExpiry.Equal(due.Add(15*time.Minute)) {
+		t.Fatalf("expected a 15m extension, got %v, %v", newExpiry, err)
+	}
+	if sb, _ := repo.GetSandbox(ctx, "due"); sb.Metadata[metaAutoExtendCount] != "1" || sb.Metadata[metaAutoExtendTotal] != "15m0s" {
+		t.Errorf("expected the extension recorded, got %v", sb.Metadata)
+	}
+
+	// Outside the window, not running or disabled by the template
+	for _, id := range []string{"far", "stopped", "quiet"} {
+		if newExpiry, err := m.AutoExtendTTL(ctx, id); err != nil || newExpiry != nil {
+			t.Errorf("%s: expected no extension, got %v, %v", id, newExpiry, err)
+		}
+	}
+
+	// The lifetime cap cuts the step short
+	capped, _ := repo.GetSandbox(ctx, "capped")
+	earlier := started.Add(-50 * time.Minute)
+	capped.StartedAt = &earlier
+	if err := repo.UpdateSandbox(ctx, capped); err != nil {
+		t.Fatal(err)
+	}
+	newExpiry, err = m.AutoExtendTTL(ctx, "capped")
+	if err != nil || newExpiry == nil || !newExpiry.Equal(earlier.Add(2*time.Hour)) {
+		t.Errorf("expected the extension capped at the max TTL, got %v, %v", newExpiry, err)
+	}
+
+	if _, err := m.AutoExtendTTL(ctx, "missing"); !errors.Is(err, ErrSandboxNotFound) {
+		t.Errorf("expected ErrSandboxNotFound, got %v", err)
+	}
+}
+
+func TestAutoExtendTTLConcurrent(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewMemoryRepository()
+	m := &DockerManager{repo: repo, templateLoader: templates.NewLoader()}
+	m.sandboxConfig.AutoExtend = config.AutoExtendConfig{Enabled: true, Window: time.Hour, Step: 15 * time.Minute}
+
+	expiresAt := time.Now().Add(5 * time.Minute).Truncate(time.Second)
+	if err := repo.CreateSandbox(ctx, &models.Sandbox{ID: "sb-1", Status: models.StatusRunning, ExpiresAt: expiresAt}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Extensions racing on the same read lose rather than overwrite each other
+	var granted atomic.Int32
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if newExpiry, err := m.AutoExtendTTL(ctx, "sb-1"); err != nil {
+				t.Error(err)
+			} else if newExpiry != nil {
+				granted.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	sb, _ := repo.GetSandbox(ctx, "sb-1")
+	n := int(granted.Load())
+	if n == 0 || sb.Metadata[metaAutoExtendCount] != strconv.Itoa(n) || !sb.ExpiresAt.Equal(expiresAt.Add(time.Duration(n)*15*time.Minute)) {
+		t.Errorf("expected %d extensions of 15m each, got expiry %s and %v", n, sb.ExpiresAt.Sub(expiresAt), sb.Metadata)
+	}
+}
+
+// staleSessionRepo runs race once, right after the next session read, so the
+// caller writes from a read a concurrent change has already made stale.
+// Transactions run on it directly and aren't rolled back.
+type staleSessionRepo struct {
+	storage.Repository
+	race func()
+}
+
+func (r *staleSessionRepo) afterRead() {
+	if race := r.race; race != nil {
+		r.race = nil
+		race()
+	}
+}
+
+func (r *staleSessionRepo) GetSessionByID(ctx context.Context, id string) (*models.Session, error) {
+	defer r.afterRead()
+	return r.Repository.GetSessionByID(ctx, id)
+}
+
+func (r *staleSessionRepo) GetSessionBySandboxID(ctx context.Context, sandboxID string) (*models.Session, error) {
+	defer r.afterRead()
+	return r.Repository.GetSessionBySandboxID(ctx, sandboxID)
+}
+
+func (r *staleSessionRepo) WithTx(ctx context.Context, fn func(storage.Repository) error) error {
+	return fn(r)
+}
+
+// revokeSession revokes a session behind the manager's back
+func revokeSession(t *testing.T, repo storage.Repository, id string) {
+	t.Helper()
+	s, err := repo.GetSessionByID(context.Background(), id)
+	if err != nil || s == nil {
+		t.Fatalf("failed to get session %s: %v", id, err)
+	}
+	now := time.Now()
+	s.Status, s.RevokedAt, s.RevokedBy = models.SessionRevoked, &now, "admin"
+	if err := repo.UpdateSession(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAutoExtendTTLKeepsRevokedSession(t *testing.T) {
+	ctx := context.Background()
+	mem := storage.NewMemoryRepository()
+	repo := &staleSessionRepo{Repository: mem}
+	m := &DockerManager{repo: repo, templateLoader: templates.NewLoader()}
+	m.sandboxConfig.AutoExtend = config.AutoExtendConfig{Enabled: true, Window: time.Hour, Step: 15 * time.Minute}
+
+	expiresAt := time.Now().Add(5 * time.Minute).Truncate(time.Second)
+	if err := mem.CreateSandbox(ctx, &models.Sandbox{ID: "sb-1", Status: models.StatusRunning, ExpiresAt: expiresAt}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.CreateSession(ctx, &models.Session{ID: "s-1", Token: "tok", Status: models.SessionActive, SandboxID: "sb-1", ExpiresAt: &expiresAt}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The session is revoked between its read and the extension's write
+	repo.race = func() { revokeSession(t, mem, "s-1") }
+	if newExpiry, err := m.AutoExtendTTL(ctx, "sb-1"); err != nil || newExpiry == nil {
+		t.Fatalf("expected the sandbox auto-extended, got %v, %v", newExpiry, err)
+	}
+
+	s, _ := mem.GetSessionByID(ctx, "s-1")
+	if s.Status != models.SessionRevoked || s.RevokedBy != "admin" || s.RevokedAt == nil {
+		t.Errorf("expected the revoke kept, got %+v", s)
+	}
+	if !s.ExpiresAt.Equal(expiresAt) || s.ExtendedSeconds != 0 {
+		t.Errorf("expected the revoked session not extended, got expiry %s and %ds", s.ExpiresAt, s.ExtendedSeconds)
+	}
+}
+
+func TestTTLCaps(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	for name, doc := range map[string]string{
+		"capped.yaml": "name: capped\nbase_image: alpine\nmax_ttl: 2h\n",
+		"loose.yaml":  "name: loose\nbase_image: alpine\nttl: 12h\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(doc), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	loader := templates.NewLoader()
+	if err := loader.LoadFromDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	repo := storage.NewMemoryRepository()
+	m := &DockerManager{repo: repo, templateLoader: loader}
+	m.sandboxConfig.MaxTTL = 8 * time.Hour
+
+	// The stricter cap wins; a requested TTL over it is rejected
+	var capErr *TTLCapError
+	day := 24 * time.Hour
+	if _, err := m.Create(ctx, "capped", "u-1", CreateOptions{TTL: &day}); !errors.As(err, &capErr) || capErr.Max != 2*time.Hour {
+		t.Errorf("expected a TTLCapError with the template cap, got %v", err)
+	}
+	if _, err := m.CreateSession(ctx, models.CreateSessionRequest{TemplateID: "loose", TTL: 9 * 3600}, ""); !errors.As(err, &capErr) || capErr.Max != 8*time.Hour {
+		t.Errorf("expected a TTLCapError with the global cap, got %v", err)
+	}
+	// A default TTL over the cap is cut to it
+	s, err := m.CreateSession(ctx, models.CreateSessionRequest{TemplateID: "loose"}, "")
+	if err != nil || s.TTLSeconds != 8*3600 {
+		t.Fatalf("expected the template TTL cut to 8h, got %+v, %v", s, err)
+	}
+
+	started := time.Now().Add(-time.Hour)
+	sb := &models.Sandbox{ID: "sb-1", TemplateID: "capped", Status: models.StatusRunning, CreatedAt: started, StartedAt: &started, ExpiresAt: started.Add(90 * time.Minute)}
+	if err := repo.CreateSandbox(ctx, sb); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ExtendTTL(ctx, "sb-1", time.Hour); !errors.As(err, &capErr) || capErr.Requested != 150*time.Minute {
+		t.Errorf("expected an extension past 2h rejected, got %v", err)
+	}
+	if err := m.ExtendTTL(ctx, "sb-1", 30*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := repo.GetSandbox(ctx, "sb-1"); got.Metadata[metaTTLCap] != "2h0m0s" {
+		t.Errorf("expected the applied cap recorded, got %v", got.Metadata)
+	}
+
+	expires := time.Now().Add(time.Hour)
+	if err := repo.CreateSession(ctx, &models.Session{ID: "active", Token: "tok", TemplateID: "capped", Status: models.SessionActive, TTLSeconds: 3600, ExpiresAt: &expires}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.ExtendSession(ctx, "active", 90*time.Minute); !errors.As(err, &capErr) {
+		t.Errorf("expected a session extension past the cap rejected, got %v", err)
+	}
+	if _, err := m.ExtendSession(ctx, "active", time.Hour); err != nil {
+		t.Errorf("expected an extension up to the cap, got %v", err)
+	}
+}
+
+func TestSessionJoinDeadline(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewMemoryRepository()
+	m := &DockerManager{repo: repo, templateLoader: loaderWith(t, "python")}
+	m.sandboxConfig.SessionJoinTTL = 24 * time.Hour
+
+	s, err := m.CreateSession(ctx, models.CreateSessionRequest{TemplateID: "python", TTL: 3600}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.JoinBy == nil || time.Until(*s.JoinBy) < 23*time.Hour {
+		t.Errorf("expected the default join deadline a day out, got %v", s.JoinBy)
+	}
+	s, err = m.CreateSession(ctx, models.CreateSessionRequest{TemplateID: "python", TTL: 3600, JoinTTL: 60}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.JoinBy == nil || time.Until(*s.JoinBy) > time.Minute {
+		t.Errorf("expected the requested join deadline a minute out, got %v", s.JoinBy)
+	}
+
+	passed := time.Now().Add(-time.Minute)
+	if err := repo.CreateSession(ctx, &models.Session{ID: "late", Token: "late", TemplateID: "python", Status: models.SessionReady, JoinBy: &passed}); err != nil {
+		t.Fatal(err)
+	}
+	if expired, err := repo.GetExpiredSessions(ctx, 10); err != nil || len(expired) != 1 || expired[0].ID != "late" {
+		t.Fatalf("expected the late session due for cleanup, got %v, %v", expired, err)
+	}
+
+	// Activating past the deadline expires the session instead
+	got, err := m.ActivateSession(ctx, "late")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != models.SessionExpired || got.StatusMessage != models.StatusMsgJoinDeadline {
+		t.Errorf("expected the late session expired, got %s %q", got.Status, got.StatusMessage)
+	}
+	if expired, _ := m.ExpireUnjoinedSession(ctx, "late"); expired {
+		t.Error("expected an expired session not to expire again")
+	}
+}
+
+func TestSessionPrewarm(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewMemoryRepository()
+	m := &DockerManager{repo: repo, templateLoader: loaderWith(t, "python")}
+	m.sandboxConfig.SessionJoinTTL = time.Hour
+
+	late := time.Now().Add(2 * time.Hour)
+	if _, err := m.CreateSession(ctx, models.CreateSessionRequest{TemplateID: "python", TTL: 3600, PrewarmAt: &late}, ""); !errors.Is(err, ErrPrewarmTooLate) {
+		t.Errorf("expected ErrPrewarmTooLate past the join deadline, got %v", err)
+	}
+	soon := time.Now().Add(30 * time.Minute)
+	s, err := m.CreateSession(ctx, models.CreateSessionRequest{TemplateID: "python", TTL: 3600, PrewarmAt: &soon}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.PrewarmAt == nil || !s.PrewarmAt.Equal(soon) || s.SandboxID != "" {
+		t.Errorf("expected a ready session waiting to be prewarmed, got %+v", s)
+	}
+
+	// Nothing to bind to until a sandbox is attached
+	if sb := m.prewarmedSandbox(ctx, s); sb != nil {
+		t.Errorf("expected no prewarmed sandbox yet, got %+v", sb)
+	}
+
+	if err := repo.CreateSandbox(ctx, &models.Sandbox{ID: "sb-1", Status: models.StatusRunning, ExpiresAt: late}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := repo.AttachSessionSandbox(ctx, s.ID, "sb-1"); err != nil || !ok {
+		t.Fatalf("expected the sandbox attached, got %v, %v", ok, err)
+	}
+
+	// Activation read the session before the sandbox was attached
+	sb := m.prewarmedSandbox(ctx, s)
+	if sb == nil || sb.ID != "sb-1" || s.SandboxID != "sb-1" {
+		t.Errorf("expected activation to bind to the prewarmed sandbox, got %+v", sb)
+	}
+
+	// The wait for the candidate counts, up to the TTL cap
+	if ttl := m.prewarmTTL(s); ttl <= time.Hour || ttl > 2*time.Hour {
+		t.Errorf("expected the TTL to cover the join wait, got %s", ttl)
+	}
+	m.sandboxConfig.MaxTTL = 90 * time.Minute
+	if ttl := m.prewarmTTL(s); ttl != 90*time.Minute {
+		t.Errorf("expected the TTL cut to the cap, got %s", ttl)
+	}
+}
+
+func TestProvisioningUpdateAfterRevoke(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewMemoryRepository()
+	m := &DockerManager{repo: repo}
+
+	s := &models.Session{ID: "s-1", Token: "tok", TemplateID: "python", Status: models.SessionProvisioning, TTLSeconds: 3600, CreatedAt: time.Now()}
+	if err := repo.CreateSession(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := repo.RevokeSession(ctx, s.ID, "admin", time.Now()); err != nil || !ok {
+		t.Fatalf("expected the session revoked, got %v, %v", ok, err)
+	}
+
+	// Provisioning still holds the session as it was before the revoke
+	s.Status = models.SessionActive
+	s.SandboxID = "sb-1"
+	if m.updateProvisioningSession(ctx, s) {
+		t.Error("expected the update of a revoked session refused")
+	}
+	if got, _ := repo.GetSessionByID(ctx, s.ID); got.Status != models.SessionRevoked || got.SandboxID != "" {
+		t.Errorf("expected the revoke kept, got %s with sandbox %q", got.Status, got.SandboxID)
+	}
+}
+
+func TestActivationTTL(t *testing.T) {
+	loader := templates.NewLoader()
+	if err := loader.LoadFromDir(filepath.Join("..", "api", "testdata", "catalog")); err != nil {
+		t.Fatal(err)
+	}
+	m := &DockerManager{templateLoader: loader}
+	task := map[string]string{models.MetadataTaskID: "demo/shop/checkout"} // time_limit: 3600
+
+	for _, tc := range []struct {
+		name     string
+		session  *models.Session
+		expected int
+	}{
+		{"capped by the task", &models.Session{TTLSeconds: 7200, Metadata: task}, 3600},
+		{"shorter than the task", &models.Session{TTLSeconds: 1800, Metadata: task}, 1800},
+		{"without a task", &models.Session{TTLSeconds: 7200}, 7200},
+	} {
+		if got := m.activationTTL(tc.session); got != tc.expected {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.expected, got)
+		}
+	}
+}
+
+func TestHostPorts(t *testing.T) {
+	ctx := context.Background()
+
+	// A Docker daemon that assigns the next free host port to each published port
+	var created container.HostConfig
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/containers/create"):
+			var body struct{ HostConfig container.HostConfig }
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Error(err)
+			}
+			created = body.HostConfig
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"Id":"ctr-1"}`))
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/containers/ctr-1/json"):
+			ports := nat.PortMap{}
+			for i, p := range slices.Sorted(maps.Keys(created.PortBindings)) {
+				ports[p] = []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: strconv.Itoa(32768 + i)}}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(types.ContainerJSON{NetworkSettings: &types.NetworkSettings{NetworkSettingsBase: types.NetworkSettingsBase{Ports: ports}}})
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	cli, err := client.NewClientWithOpts(client.WithHost("tcp://" + srv.Listener.Addr().String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &DockerManager{docker: cli, config: config.DockerConfig{PublicHost: "sandbox.example.com"}}
+	tmpl := &models.Template{Expose: []models.Port{{Name: "web", Container: 8080}, {Name: "dns", Container: 53, Protocol: "udp"}}}
+
+	// Without Traefik every exposed port is published to an ephemeral host port
+	id, err := m.createContainer(ctx, &models.Sandbox{ID: "sb-1"}, tmpl, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := nat.PortMap{
+		"8080/tcp": {{HostIP: "0.0.0.0"}},
+		"53/udp":   {{HostIP: "0.0.0.0"}},
+	}
+	if !maps.EqualFunc(created.PortBindings, want, slices.Equal) {
+		t.Errorf("expected ephemeral bindings %v, got %v", want, created.PortBindings)
+	}
+
+	endpoints, err := m.buildHostEndpoints(ctx, id, tmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantEndpoints := map[string]string{"dns": "http://sandbox.example.com:32768", "web": "http://sandbox.example.com:32769"}
+	if !maps.Equal(endpoints, wantEndpoints) {
+		t.Errorf("expected endpoints %v, got %v", wantEndpoints, endpoints)
+	}
+
+	// Traefik routes to the container, so nothing is published
+	m.traefikConfig.Enabled = true
+	if _, err := m.createContainer(ctx, &models.Sandbox{ID: "sb-2"}, tmpl, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(created.PortBindings) != 0 {
+		t.Errorf("expected no bindings behind Traefik, got %v", created.PortBindings)
+	}
+}
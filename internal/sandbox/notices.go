@@ -0,0 +1,71 @@
+package sandbox
+
+import "sync"
+
+// SandboxNotice is pushed to the live connections of a sandbox, such as its
+// terminals
+type SandboxNotice string
+
+const (
+	// NoticeSessionExpired is sent when ExpireSession expires the sandbox's session
+	NoticeSessionExpired SandboxNotice = "session_expired"
+)
+
+// noticeHub fans notices out to the subscribers of each sandbox. The zero
+// value is ready to use.
+type noticeHub struct {
+	mu   sync.Mutex
+	subs map[string]map[chan SandboxNotice]struct{}
+}
+
+// subscribe returns a channel receiving the notices of a sandbox and a
+// function ending the subscription
+func (h *noticeHub) subscribe(sandboxID string) (<-chan SandboxNotice, func()) {
+	ch := make(chan SandboxNotice, 1)
+
+	h.mu.Lock()
+	if h.subs == nil {
+		h.subs = make(map[string]map[chan SandboxNotice]struct{})
+	}
+	if h.subs[sandboxID] == nil {
+		h.subs[sandboxID] = make(map[chan SandboxNotice]struct{})
+	}
+	h.subs[sandboxID][ch] = struct{}{}
+	h.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			delete(h.subs[sandboxID], ch)
+			if len(h.subs[sandboxID]) == 0 {
+				delete(h.subs, sandboxID)
+			}
+		})
+	}
+}
+
+// publish delivers a notice to every subscriber of a sandbox without
+// blocking; a subscriber that has not read its previous notice misses it
+func (h *noticeHub) publish(sandboxID string, notice SandboxNotice) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs[sandboxID] {
+		select {
+		case ch <- notice:
+		default:
+		}
+	}
+}
+
+// SubscribeSandbox returns the notices of a sandbox until the returned
+// function is called
+func (m *DockerManager) SubscribeSandbox(sandboxID string) (<-chan SandboxNotice, func()) {
+	return m.notices.subscribe(sandboxID)
+}
+
+// NotifySandbox pushes a notice to the live connections of a sandbox
+func (m *DockerManager) NotifySandbox(sandboxID string, notice SandboxNotice) {
+	m.notices.publish(sandboxID, notice)
+}
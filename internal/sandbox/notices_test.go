@@ -0,0 +1,44 @@
+package sandbox
+
+import "testing"
+
+func TestNoticeHub(t *testing.T) {
+	var h noticeHub
+	a, unsubscribeA := h.subscribe("sb-1")
+	b, unsubscribeB := h.subscribe("sb-1")
+	other, unsubscribeOther := h.subscribe("sb-2")
+	defer unsubscribeB()
+	defer unsubscribeOther()
+
+	h.publish("sb-1", NoticeSessionExpired)
+	// A full subscriber misses notices instead of blocking the publisher
+	h.publish("sb-1", NoticeSessionExpired)
+
+	for name, ch := range map[string]<-chan SandboxNotice{"a": a, "b": b} {
+		select {
+		case n := <-ch:
+			if n != NoticeSessionExpired {
+				t.Errorf("%s: expected %s, got %s", name, NoticeSessionExpired, n)
+			}
+		default:
+			t.Errorf("%s: expected a notice", name)
+		}
+	}
+	select {
+	case n := <-other:
+		t.Errorf("expected no notice for another sandbox, got %s", n)
+	default:
+	}
+
+	unsubscribeA()
+	unsubscribeA()
+	h.publish("sb-1", NoticeSessionExpired)
+	select {
+	case <-a:
+		t.Error("expected no notice after unsubscribing")
+	default:
+	}
+	if len(h.subs["sb-1"]) != 1 {
+		t.Errorf("expected one subscriber left, got %d", len(h.subs["sb-1"]))
+	}
+}
@@ -0,0 +1,168 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Operation errors
+var (
+	// ErrOperationInProgress is returned when another mutating operation is already running for the sandbox
+	ErrOperationInProgress = errors.New("operation already in progress")
+	// ErrOperationPending is returned when the request deadline is too short and the work continues in the background
+	ErrOperationPending = errors.New("operation continues in background")
+)
+
+const (
+	// stopTimeout is how long Docker waits for a container to stop gracefully in Stop
+	stopTimeout = 30 * time.Second
+	// deleteStopTimeout is the graceful stop timeout used by Delete
+	deleteStopTimeout = 10 * time.Second
+	// phaseMargin is extra budget kept for database writes after a Docker call
+	phaseMargin = 5 * time.Second
+	// backgroundTimeout bounds detached teardown work
+	backgroundTimeout = 2 * time.Minute
+	// defaultProvisionTimeout bounds provisioning when no timeout is configured
+	defaultProvisionTimeout = 5 * time.Minute
+)
+
+// operationTracker serializes mutating operations per sandbox and tracks
+// detached background work so shutdown can wait for it
+type operationTracker struct {
+	mu       sync.Mutex
+	inFlight map[string]string // sandbox ID -> operation name
+	// closed is set once wait has begun; detach refuses work from then on,
+	// since adding to wg while it is waited on races
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func newOperationTracker() *operationTracker {
+	return &operationTracker{
+		inFlight: make(map[string]string),
+	}
+}
+
+// begin marks an operation as running for the sandbox.
+// The returned release func must be called once the operation (including any detached part) completes.
+func (t *operationTracker) begin(id, op string) (func(), error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if current, ok := t.inFlight[id]; ok {
+		slog.Debug("operation rejected, another one is running", "id", id, "op", op, "running", current)
+		return nil, ErrOperationInProgress
+	}
+	t.inFlight[id] = op
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.mu.Lock()
+			delete(t.inFlight, id)
+			t.mu.Unlock()
+		})
+	}, nil
+}
+
+// detach runs fn with a context that survives the request but is still bounded
+// and tracked, so Close waits for it. release is called when fn returns.
+// Once shutdown is waiting, it runs nothing and returns false.
+func (t *operationTracker) detach(ctx context.Context, id, op string, release func(), fn func(ctx context.Context)) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return false
+	}
+
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		defer release()
+
+		bgCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), backgroundTimeout)
+		defer cancel()
+
+		slog.Info("operation continues in background", "id", id, "op", op)
+		fn(bgCtx)
+	}()
+	return true
+}
+
+// wait blocks until all detached operations finish or the timeout elapses.
+// Returns false if the timeout elapsed first. Nothing is detached after it
+// has begun.
+func (t *operationTracker) wait(timeout time.Duration) bool {
+	t.mu.Lock()
+	t.closed = true
+	t.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// hasBudget reports whether ctx has at least need time left before its deadline.
+// Contexts without a deadline always have budget; cancelled contexts never do.
+func hasBudget(ctx context.Context, need time.Duration) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return true
+	}
+	return time.Until(deadline) >= need
+}
+
+// phase is one step of a multi-step mutating operation
+type phase struct {
+	name   string
+	budget time.Duration // minimum remaining context time needed to run the step in-request
+	run    func(ctx context.Context) error
+}
+
+// runPhases executes phases in order while the request context has budget.
+// When a phase doesn't fit in the remaining budget, it and all following phases
+// are handed to a tracked background context and ErrOperationPending is returned.
+// During shutdown they run in the request instead, however little time it has.
+// release is always called exactly once when the work is done.
+func (t *operationTracker) runPhases(ctx context.Context, id, op string, release func(), phases []phase) error {
+	for i, p := range phases {
+		if !hasBudget(ctx, p.budget) {
+			remaining := phases[i:]
+			detached := t.detach(ctx, id, op, release, func(bgCtx context.Context) {
+				for _, p := range remaining {
+					if err := p.run(bgCtx); err != nil {
+						slog.Error("background operation failed", "id", id, "op", op, "phase", p.name, "error", err)
+						return
+					}
+				}
+				slog.Info("background operation completed", "id", id, "op", op)
+			})
+			if detached {
+				return ErrOperationPending
+			}
+		}
+
+		if err := p.run(ctx); err != nil {
+			release()
+			return err
+		}
+	}
+
+	release()
+	return nil
+}
@@ -0,0 +1,167 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOperationTrackerRejectsOverlap(t *testing.T) {
+	ops := newOperationTracker()
+
+	release, err := ops.begin("sb1", "stop")
+	if err != nil {
+		t.Fatalf("first begin failed: %v", err)
+	}
+
+	if _, err := ops.begin("sb1", "delete"); !errors.Is(err, ErrOperationInProgress) {
+		t.Fatalf("expected ErrOperationInProgress, got %v", err)
+	}
+
+	// Other sandboxes are independent
+	other, err := ops.begin("sb2", "delete")
+	if err != nil {
+		t.Fatalf("begin on other sandbox failed: %v", err)
+	}
+	other()
+
+	release()
+	release() // idempotent
+
+	again, err := ops.begin("sb1", "delete")
+	if err != nil {
+		t.Fatalf("begin after release failed: %v", err)
+	}
+	again()
+}
+
+func TestRunPhasesWithinBudget(t *testing.T) {
+	ops := newOperationTracker()
+	release, _ := ops.begin("sb1", "delete")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	var ran []string
+	err := ops.runPhases(ctx, "sb1", "delete", release, []phase{
+		{name: "a", budget: time.Second, run: func(context.Context) error { ran = append(ran, "a"); return nil }},
+		{name: "b", budget: time.Second, run: func(context.Context) error { ran = append(ran, "b"); return nil }},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(ran) != 2 {
+		t.Fatalf("expected both phases to run inline, got %v", ran)
+	}
+
+	if _, err := ops.begin("sb1", "stop"); err != nil {
+		t.Fatalf("operation not released: %v", err)
+	}
+}
+
+func TestRunPhasesDetachesOnTightDeadline(t *testing.T) {
+	ops := newOperationTracker()
+	release, _ := ops.begin("sb1", "delete")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	var inline, background atomic.Int32
+	gate := make(chan struct{})
+
+	err := ops.runPhases(ctx, "sb1", "delete", release, []phase{
+		{name: "quick", budget: time.Second, run: func(context.Context) error {
+			inline.Add(1)
+			return nil
+		}},
+		{name: "slow", budget: time.Minute, run: func(bgCtx context.Context) error {
+			<-gate
+			if bgCtx.Err() != nil {
+				t.Error("background context should outlive the request context")
+			}
+			background.Add(1)
+			return nil
+		}},
+	})
+	if !errors.Is(err, ErrOperationPending) {
+		t.Fatalf("expected ErrOperationPending, got %v", err)
+	}
+	if inline.Load() != 1 {
+		t.Fatal("phase with enough budget should run inline")
+	}
+
+	// Retries are rejected while the background part is still running
+	if _, err := ops.begin("sb1", "delete"); !errors.Is(err, ErrOperationInProgress) {
+		t.Fatalf("expected ErrOperationInProgress while detached, got %v", err)
+	}
+
+	// Cancelling the request must not cancel the detached work
+	cancel()
+	close(gate)
+
+	if !ops.wait(5 * time.Second) {
+		t.Fatal("background operation did not finish")
+	}
+	if background.Load() != 1 {
+		t.Fatal("detached phase did not run")
+	}
+	if _, err := ops.begin("sb1", "delete"); err != nil {
+		t.Fatalf("operation not released after background completion: %v", err)
+	}
+}
+
+func TestRunPhasesDuringShutdown(t *testing.T) {
+	ops := newOperationTracker()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	slow := []phase{{name: "slow", budget: time.Minute, run: func(context.Context) error { return nil }}}
+
+	// Operations racing the shutdown either detach before it waits or run inline
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id := fmt.Sprintf("sb%d", i)
+			release, _ := ops.begin(id, "delete")
+			if err := ops.runPhases(ctx, id, "delete", release, slow); err != nil && !errors.Is(err, ErrOperationPending) {
+				t.Errorf("%s: unexpected error %v", id, err)
+			}
+		}()
+	}
+	if !ops.wait(5 * time.Second) {
+		t.Fatal("background operations did not finish")
+	}
+	wg.Wait()
+
+	// Once shutdown waits, a short deadline no longer detaches
+	release, _ := ops.begin("sb1", "delete")
+	if err := ops.runPhases(ctx, "sb1", "delete", release, slow); err != nil {
+		t.Fatalf("expected the phase run inline, got %v", err)
+	}
+	if _, err := ops.begin("sb1", "delete"); err != nil {
+		t.Fatalf("operation not released after running inline: %v", err)
+	}
+}
+
+func TestHasBudget(t *testing.T) {
+	if !hasBudget(context.Background(), time.Hour) {
+		t.Error("context without deadline should always have budget")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if hasBudget(ctx, time.Minute) {
+		t.Error("expected no budget for a 1s deadline")
+	}
+
+	cancelled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+	if hasBudget(cancelled, 0) {
+		t.Error("cancelled context should have no budget")
+	}
+}
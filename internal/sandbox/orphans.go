@@ -0,0 +1,166 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/errdefs"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+// Labels the engine puts on the Docker resources it creates
+const (
+	managedLabel   = "sandbox.managed"
+	sandboxIDLabel = "sandbox.id"
+)
+
+// errOrphanReclaimed reports a candidate that stopped being an orphan before it was pruned
+var errOrphanReclaimed = errors.New("no longer orphaned, left in place")
+
+func managedFilter() filters.Args {
+	return filters.NewArgs(filters.Arg("label", managedLabel+"=true"))
+}
+
+// ListManagedContainers returns every container labelled sandbox.managed=true,
+// stopped ones included
+func (m *DockerManager) ListManagedContainers(ctx context.Context) ([]*models.ManagedContainer, error) {
+	list, err := m.docker.ContainerList(ctx, container.ListOptions{All: true, Filters: managedFilter()})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	containers := make([]*models.ManagedContainer, 0, len(list))
+	for _, c := range list {
+		var name string
+		if len(c.Names) > 0 {
+			name = strings.TrimPrefix(c.Names[0], "/")
+		}
+		containers = append(containers, &models.ManagedContainer{
+			ID:        c.ID,
+			Name:      name,
+			SandboxID: c.Labels[sandboxIDLabel],
+			Image:     c.Image,
+			State:     c.State,
+			CreatedAt: time.Unix(c.Created, 0).UTC(),
+		})
+	}
+	return containers, nil
+}
+
+// PruneOrphans finds managed containers without a live sandbox, and sandboxes
+// holding resources whose container is gone. Unless dryRun, it removes the
+// containers and deletes the sandboxes. Each candidate is checked again right
+// before removal, so a sandbox created or provisioned meanwhile is left alone.
+// Sandboxes placed before hosts were recorded count as on the default host.
+func (m *DockerManager) PruneOrphans(ctx context.Context, dryRun bool) (*models.OrphanReport, error) {
+	containers, err := m.ListManagedContainers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	host := m.hosts()[0].name
+	sandboxes, err := m.repo.ListSandboxes(ctx, models.ListFilters{Host: host, DefaultHost: host})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sandboxes: %w", err)
+	}
+
+	report := findOrphans(containers, sandboxes)
+	report.DryRun = dryRun
+	if dryRun {
+		return report, nil
+	}
+
+	for _, o := range report.Containers {
+		prune(o, "container", func() error {
+			live, err := m.sandboxExists(ctx, o.SandboxID)
+			if err != nil {
+				return err
+			}
+			if live {
+				return errOrphanReclaimed
+			}
+			return m.docker.ContainerRemove(ctx, o.ID, container.RemoveOptions{Force: true})
+		})
+	}
+	for _, o := range report.Sandboxes {
+		prune(o, "sandbox", func() error {
+			if _, err := m.docker.ContainerInspect(ctx, o.Name); err == nil {
+				return errOrphanReclaimed
+			} else if !errdefs.IsNotFound(err) {
+				return fmt.Errorf("failed to inspect container: %w", err)
+			}
+			if err := m.Delete(ctx, o.ID); err != nil && !errors.Is(err, ErrOperationPending) {
+				return err
+			}
+			return nil
+		})
+	}
+	return report, nil
+}
+
+// sandboxExists reports whether id names a sandbox that isn't deleted
+func (m *DockerManager) sandboxExists(ctx context.Context, id string) (bool, error) {
+	if id == "" {
+		return false, nil
+	}
+	sb, err := m.repo.GetSandbox(ctx, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to get sandbox: %w", err)
+	}
+	return sb != nil, nil
+}
+
+// prune runs remove for o and records the outcome
+func prune(o *models.OrphanResource, kind string, remove func() error) {
+	if err := remove(); err != nil {
+		o.Error = err.Error()
+		slog.Warn("failed to prune orphan", "kind", kind, "id", o.ID, "error", err)
+		return
+	}
+	o.Removed = true
+	slog.Info("pruned orphan", "kind", kind, "id", o.ID, "name", o.Name)
+}
+
+// findOrphans compares the managed containers with the sandboxes of the host
+func findOrphans(containers []*models.ManagedContainer, sandboxes []*models.Sandbox) *models.OrphanReport {
+	live := make(map[string]*models.Sandbox, len(sandboxes))
+	for _, sb := range sandboxes {
+		live[sb.ID] = sb
+	}
+
+	report := &models.OrphanReport{
+		Containers: make([]*models.OrphanResource, 0),
+		Sandboxes:  make([]*models.OrphanResource, 0),
+	}
+
+	present := make(map[string]bool, 2*len(containers))
+	for _, c := range containers {
+		present[c.ID] = true
+		present[c.Name] = true
+		if live[c.SandboxID] == nil {
+			report.Containers = append(report.Containers, &models.OrphanResource{ID: c.ID, Name: c.Name, SandboxID: c.SandboxID, State: c.State})
+		}
+	}
+
+	for _, sb := range sandboxes {
+		// Pending sandboxes get their container during provisioning; failed and
+		// expired ones no longer hold one
+		if sb.Status != models.StatusRunning && sb.Status != models.StatusStopped {
+			continue
+		}
+		name := containerName(sb.ID)
+		if (sb.ContainerID != "" && present[sb.ContainerID]) || present[name] {
+			continue
+		}
+		report.Sandboxes = append(report.Sandboxes, &models.OrphanResource{ID: sb.ID, Name: name, SandboxID: sb.ID, State: string(sb.Status)})
+	}
+
+	return report
+}
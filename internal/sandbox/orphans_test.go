@@ -0,0 +1,103 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/services"
+	"github.com/terra-clan/sandbox-engine/internal/storage"
+	"github.com/terra-clan/sandbox-engine/internal/templates"
+)
+
+func TestFindOrphans(t *testing.T) {
+	sandboxes := []*models.Sandbox{
+		{ID: "live", Status: models.StatusRunning, ContainerID: "c-live"},
+		{ID: "renamed", Status: models.StatusStopped, ContainerID: "c-old"}, // found by name
+		{ID: "gone", Status: models.StatusRunning, ContainerID: "c-gone"},
+		{ID: "pending", Status: models.StatusPending},
+	}
+	containers := []*models.ManagedContainer{
+		{ID: "c-live", Name: containerName("live"), SandboxID: "live"},
+		{ID: "c-new", Name: containerName("renamed"), SandboxID: "renamed"},
+		{ID: "c-stray", Name: containerName("deleted"), SandboxID: "deleted", State: "exited"},
+	}
+
+	report := findOrphans(containers, sandboxes)
+
+	if len(report.Containers) != 1 || report.Containers[0].ID != "c-stray" || report.Containers[0].SandboxID != "deleted" {
+		t.Errorf("expected the stray container, got %+v", report.Containers)
+	}
+	if len(report.Sandboxes) != 1 || report.Sandboxes[0].ID != "gone" {
+		t.Errorf("expected the sandbox without a container, got %+v", report.Sandboxes)
+	}
+
+	// Nothing to report still encodes as empty lists
+	report = findOrphans(nil, nil)
+	if report.Containers == nil || report.Sandboxes == nil {
+		t.Error("expected empty, non-nil lists")
+	}
+}
+
+// listingProvider holds resources for a fixed set of sandbox IDs
+type listingProvider struct {
+	basicProvider
+
+	provisioned   []string
+	listErr       error
+	deprovisioned []string
+}
+
+func (p *listingProvider) ListProvisioned(ctx context.Context) ([]string, error) {
+	return p.provisioned, p.listErr
+}
+
+func (p *listingProvider) Deprovision(ctx context.Context, sandboxID, serviceName string) error {
+	p.deprovisioned = append(p.deprovisioned, sandboxID)
+	return nil
+}
+
+func TestReconcileProviders(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewMemoryRepository()
+	// Resource names fold case and - into _, which come back as -
+	for _, id := range []string{"Live-1", "live_2"} {
+		if err := repo.CreateSandbox(ctx, &models.Sandbox{ID: id, Status: models.StatusRunning}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	postgres := &listingProvider{provisioned: []string{"live-1", "live-2", "gone-1"}}
+	redis := &listingProvider{listErr: errors.New("connection refused")}
+	registry := services.NewRegistry()
+	registry.Register("postgres", postgres)
+	registry.Register("redis", redis)
+	m := &DockerManager{serviceRegistry: registry, templateLoader: templates.NewLoader(), repo: repo}
+
+	report, err := m.ReconcileProviders(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.DryRun || len(report.Providers) != 2 || report.Providers[0].Provider != "postgres" {
+		t.Fatalf("expected a dry run report per provider, got %+v", report)
+	}
+	if orphans := report.Providers[0].Orphans; len(orphans) != 1 || orphans[0].SandboxID != "gone-1" || orphans[0].Removed {
+		t.Errorf("expected only the deleted sandbox reported, got %+v", orphans)
+	}
+	if report.Providers[1].Error != "connection refused" || len(report.Providers[1].Orphans) != 0 {
+		t.Errorf("expected the listing error reported, got %+v", report.Providers[1])
+	}
+	if len(postgres.deprovisioned) != 0 {
+		t.Errorf("expected a dry run to deprovision nothing, got %v", postgres.deprovisioned)
+	}
+
+	report, err = m.ReconcileProviders(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(postgres.deprovisioned, []string{"gone-1"}) || !report.Providers[0].Orphans[0].Removed {
+		t.Errorf("expected the orphan deprovisioned, got %v", postgres.deprovisioned)
+	}
+}
@@ -0,0 +1,110 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+// PrewarmSessions creates the sandboxes of up to limit ready sessions whose
+// prewarm time has come and reports how many it created. The sessions stay
+// ready with the sandbox attached until the candidate joins.
+func (m *DockerManager) PrewarmSessions(ctx context.Context, limit int) (int, error) {
+	sessions, err := m.repo.GetPrewarmDueSessions(ctx, limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get prewarm due sessions: %w", err)
+	}
+
+	prewarmed := 0
+	for _, session := range sessions {
+		if m.prewarmSession(ctx, session) {
+			prewarmed++
+		}
+	}
+	return prewarmed, nil
+}
+
+// prewarmSession creates the sandbox of a ready session and attaches it. A
+// failed create is left for the next round. The attach only succeeds while
+// the session is still ready, so a sandbox created for a session revoked or
+// activated meanwhile is deleted.
+func (m *DockerManager) prewarmSession(ctx context.Context, session *models.Session) bool {
+	ttl := m.prewarmTTL(session)
+	sb, err := m.Create(ctx, session.TemplateID, session.CreatedBy, CreateOptions{
+		TTL:           &ttl,
+		Env:           session.Env,
+		Metadata:      session.Metadata,
+		Services:      session.Services,
+		FromSession:   true,
+		OwnerClientID: session.OwnerClientID,
+	})
+	if errors.Is(err, ErrTemplateNotFound) {
+		if err := m.failSessionTemplateMissing(ctx, session); err != nil {
+			slog.Error("failed to update session", "error", err, "session_id", session.ID)
+		}
+		return false
+	}
+	if err != nil {
+		slog.Error("failed to prewarm session sandbox", "error", err, "session_id", session.ID)
+		return false
+	}
+
+	attached, err := m.repo.AttachSessionSandbox(ctx, session.ID, sb.ID)
+	if err != nil {
+		slog.Error("failed to attach prewarmed sandbox", "error", err, "session_id", session.ID)
+	}
+	if err != nil || !attached {
+		// Activated, expired or revoked while the sandbox was being created
+		if err := m.Delete(ctx, sb.ID); err != nil && !errors.Is(err, ErrOperationPending) {
+			slog.Warn("failed to delete unattached prewarmed sandbox", "error", err, "sandbox_id", sb.ID)
+		}
+		return false
+	}
+
+	slog.Info("session sandbox prewarmed", "session_id", session.ID, "sandbox_id", sb.ID, "prewarm_at", session.PrewarmAt)
+	return true
+}
+
+// prewarmTTL is the TTL of a prewarmed sandbox: the wait for the candidate and
+// then the session's TTL, cut to the template's TTL cap. Activation moves the
+// expiry to the session's, so the cut only matters for a candidate who is late.
+func (m *DockerManager) prewarmTTL(session *models.Session) time.Duration {
+	ttl := time.Duration(session.TTLSeconds) * time.Second
+	if session.JoinBy != nil {
+		ttl += time.Until(*session.JoinBy)
+	}
+	if limit := m.ttlCap(m.templateLoader.Get(session.TemplateID)); limit > 0 && ttl > limit {
+		ttl = limit
+	}
+	return ttl
+}
+
+// prewarmedSandbox returns the sandbox pre-warmed for an activated session, or
+// nil when there is none to bind to. The stored session is read again to pick
+// up a sandbox attached after activation read it. A prewarmed sandbox that
+// failed or was stopped while waiting is deleted so a fresh one is created.
+func (m *DockerManager) prewarmedSandbox(ctx context.Context, session *models.Session) *models.Sandbox {
+	stored, err := m.repo.GetSessionByID(ctx, session.ID)
+	if err != nil || stored == nil || stored.SandboxID == "" {
+		return nil
+	}
+
+	sb, err := m.repo.GetSandbox(ctx, stored.SandboxID)
+	if err != nil || sb == nil {
+		return nil
+	}
+	if sb.Status != models.StatusPending && sb.Status != models.StatusRunning {
+		slog.Info("prewarmed sandbox unusable, creating a new one", "session_id", session.ID, "sandbox_id", sb.ID, "status", sb.Status)
+		if err := m.Delete(ctx, sb.ID); err != nil && !errors.Is(err, ErrOperationPending) {
+			slog.Warn("failed to delete prewarmed sandbox", "error", err, "sandbox_id", sb.ID)
+		}
+		return nil
+	}
+
+	session.SandboxID = sb.ID
+	return sb
+}
@@ -0,0 +1,61 @@
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/services"
+	"github.com/terra-clan/sandbox-engine/internal/storage"
+)
+
+// ReconcileProviders finds the resources the service providers hold for
+// sandboxes without a live row, deleted ones included, and unless dryRun
+// deprovisions them. Providers are listed before sandboxes: a sandbox's row
+// is saved before its services are provisioned, so resources provisioned
+// meanwhile belong to a sandbox the listing already has.
+func (m *DockerManager) ReconcileProviders(ctx context.Context, dryRun bool) (*models.ProviderReconcileReport, error) {
+	names := m.serviceRegistry.List()
+	sort.Strings(names)
+
+	report := &models.ProviderReconcileReport{DryRun: dryRun, Providers: make([]*models.ProviderOrphans, 0, len(names))}
+	provisioned := make(map[string][]string, len(names))
+	for _, name := range names {
+		result := &models.ProviderOrphans{Provider: name, Orphans: make([]*models.OrphanResource, 0)}
+		report.Providers = append(report.Providers, result)
+		ids, err := m.serviceRegistry.Get(name).ListProvisioned(ctx)
+		if err != nil {
+			result.Error = err.Error()
+			slog.Warn("failed to list provisioned resources", "provider", name, "error", err)
+			continue
+		}
+		provisioned[name] = ids
+	}
+
+	// Providers are shared by every host's sandboxes
+	sandboxes, err := m.repo.ListSandboxes(storage.WithPrimary(ctx), models.ListFilters{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sandboxes: %w", err)
+	}
+	live := make(map[string]bool, len(sandboxes))
+	for _, sb := range sandboxes {
+		live[services.NormalizeSandboxID(sb.ID)] = true
+	}
+
+	for _, result := range report.Providers {
+		provider := m.serviceRegistry.Get(result.Provider)
+		for _, id := range provisioned[result.Provider] {
+			if live[services.NormalizeSandboxID(id)] {
+				continue
+			}
+			o := &models.OrphanResource{ID: id, SandboxID: id}
+			result.Orphans = append(result.Orphans, o)
+			if !dryRun {
+				prune(o, result.Provider, func() error { return provider.Deprovision(ctx, id, result.Provider) })
+			}
+		}
+	}
+	return report, nil
+}
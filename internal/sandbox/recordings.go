@@ -0,0 +1,270 @@
+package sandbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/storage"
+)
+
+// recordingTruncatedNotice is shown by players where a recording hit its size cap
+const recordingTruncatedNotice = "\r\n\x1b[0m[recording truncated: size limit reached]\r\n"
+
+// recordingStore keeps terminal recordings on disk, one asciicast file per
+// recording under a directory per sandbox. Deleting a sandbox leaves them.
+type recordingStore struct {
+	dir string
+}
+
+func (m *DockerManager) recordings() recordingStore {
+	return recordingStore{dir: m.sandboxConfig.RecordingsDir}
+}
+
+func (s recordingStore) path(rec *models.TerminalRecording) string {
+	return filepath.Join(s.dir, rec.SandboxID, strconv.FormatInt(rec.ID, 10)+".cast")
+}
+
+// TerminalRecorder writes what a terminal shell printed, and what was typed
+// into it if the recording has input, as an asciicast v2 file. Once the file
+// reaches the size cap a truncation marker is written and further events are
+// dropped. Methods are safe for concurrent use and do nothing on a nil
+// recorder, which stands for a shell that isn't recorded.
+type TerminalRecorder struct {
+	repo     storage.Repository
+	rec      *models.TerminalRecording
+	maxBytes int64
+
+	mu     sync.Mutex
+	f      *os.File
+	closed bool
+}
+
+// StartRecording starts recording a new shell in sb when its template or its
+// session asks for it, returning a nil recorder otherwise. session may be nil
+// for terminals opened through the API; the sandbox's session is looked up.
+func (m *DockerManager) StartRecording(ctx context.Context, sb *models.Sandbox, session *models.Session, cols, rows int) (*TerminalRecorder, error) {
+	var policy models.RecordingPolicy
+	if tmpl := m.templateLoader.Get(sb.TemplateID); tmpl != nil && tmpl.Recording != nil {
+		policy = *tmpl.Recording
+	}
+	if session == nil {
+		var err error
+		if session, err = m.repo.GetSessionBySandboxID(ctx, sb.ID); err != nil {
+			return nil, fmt.Errorf("failed to get session: %w", err)
+		}
+	}
+	if session != nil && session.RecordTerminal {
+		policy.Enabled = true
+	}
+	if !policy.Enabled {
+		return nil, nil
+	}
+
+	maxBytes, err := m.recordingBudget(ctx, sb.ID)
+	if err != nil {
+		return nil, err
+	}
+	if maxBytes <= 0 {
+		slog.Warn("terminal not recorded: sandbox recording limit reached", "sandbox_id", sb.ID)
+		return nil, nil
+	}
+
+	rec := &models.TerminalRecording{
+		SandboxID:     sb.ID,
+		OwnerClientID: sb.OwnerClientID,
+		StartedAt:     time.Now(),
+		Input:         policy.Input,
+	}
+	if session != nil {
+		rec.SessionID = session.ID
+	}
+	if err := m.repo.CreateTerminalRecording(ctx, rec); err != nil {
+		return nil, err
+	}
+
+	path := m.recordings().path(rec)
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create recording directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recording: %w", err)
+	}
+
+	r := &TerminalRecorder{repo: m.repo, rec: rec, maxBytes: maxBytes, f: f}
+	header, _ := json.Marshal(map[string]any{
+		"version":   2,
+		"width":     cols,
+		"height":    rows,
+		"timestamp": rec.StartedAt.Unix(),
+		"title":     sb.ID,
+		"env":       map[string]string{"TERM": "xterm-256color", "SHELL": "/bin/bash"},
+	})
+	r.mu.Lock()
+	r.writeLine(header)
+	r.mu.Unlock()
+
+	slog.Info("terminal recording started", "sandbox_id", sb.ID, "recording_id", rec.ID, "input", rec.Input)
+	return r, nil
+}
+
+// recordingBudget returns the size cap of a new recording of the sandbox: the
+// per-recording cap, cut to what the sandbox's finished recordings left of
+// its total cap
+func (m *DockerManager) recordingBudget(ctx context.Context, sandboxID string) (int64, error) {
+	maxBytes := int64(m.sandboxConfig.RecordingMaxMB) << 20
+	if m.sandboxConfig.RecordingSandboxMaxMB <= 0 {
+		return maxBytes, nil
+	}
+
+	recs, err := m.repo.ListTerminalRecordings(ctx, sandboxID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list terminal recordings: %w", err)
+	}
+	left := int64(m.sandboxConfig.RecordingSandboxMaxMB) << 20
+	for _, rec := range recs {
+		left -= rec.SizeBytes
+	}
+	return min(maxBytes, left), nil
+}
+
+// Output records what the shell printed
+func (r *TerminalRecorder) Output(data []byte) {
+	r.event("o", string(data))
+}
+
+// Input records what was typed, when the recording includes input
+func (r *TerminalRecorder) Input(data []byte) {
+	if r != nil && r.rec.Input {
+		r.event("i", string(data))
+	}
+}
+
+// Resize records a change of the terminal size
+func (r *TerminalRecorder) Resize(cols, rows int) {
+	r.event("r", fmt.Sprintf("%dx%d", cols, rows))
+}
+
+// event appends an asciicast event, truncating the recording at the size cap
+func (r *TerminalRecorder) event(code, data string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil || r.rec.Truncated {
+		return
+	}
+
+	elapsed := math.Round(time.Since(r.rec.StartedAt).Seconds()*1e6) / 1e6
+	line, _ := json.Marshal([]any{elapsed, code, data})
+	if r.rec.SizeBytes+int64(len(line))+1 > r.maxBytes {
+		r.rec.Truncated = true
+		marker, _ := json.Marshal([]any{elapsed, "m", "truncated"})
+		notice, _ := json.Marshal([]any{elapsed, "o", recordingTruncatedNotice})
+		r.writeLine(marker)
+		r.writeLine(notice)
+		slog.Warn("terminal recording truncated", "sandbox_id", r.rec.SandboxID, "recording_id", r.rec.ID, "bytes", r.rec.SizeBytes)
+		return
+	}
+	r.writeLine(line)
+}
+
+// writeLine writes one line of the file; a failed write stops the recording.
+// Callers hold mu.
+func (r *TerminalRecorder) writeLine(line []byte) {
+	n, err := r.f.Write(append(line, '\n'))
+	r.rec.SizeBytes += int64(n)
+	if err != nil {
+		slog.Error("failed to write terminal recording", "error", err, "sandbox_id", r.rec.SandboxID, "recording_id", r.rec.ID)
+		r.f.Close()
+		r.f = nil
+	}
+}
+
+// Close ends the recording and stores its size
+func (r *TerminalRecorder) Close() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	r.closed = true
+	if r.f != nil {
+		r.f.Close()
+		r.f = nil
+	}
+
+	now := time.Now()
+	r.rec.EndedAt = &now
+	if err := r.repo.FinishTerminalRecording(context.Background(), r.rec); err != nil {
+		slog.Warn("failed to finish terminal recording", "error", err, "recording_id", r.rec.ID)
+	}
+	slog.Info("terminal recording finished", "sandbox_id", r.rec.SandboxID, "recording_id", r.rec.ID, "bytes", r.rec.SizeBytes, "truncated", r.rec.Truncated)
+}
+
+// ListRecordings returns the terminal recordings of a sandbox, which are kept
+// after the sandbox is deleted
+func (m *DockerManager) ListRecordings(ctx context.Context, sandboxID string) ([]*models.TerminalRecording, error) {
+	recs, err := m.repo.ListTerminalRecordings(ctx, sandboxID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list terminal recordings: %w", err)
+	}
+	return recs, nil
+}
+
+// PurgeRecordings removes the terminal recordings that ended more than
+// retention ago, with their files, returning how many were removed
+func (m *DockerManager) PurgeRecordings(ctx context.Context, retention time.Duration) (int64, error) {
+	recs, err := m.repo.PurgeTerminalRecordings(ctx, time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+
+	store := m.recordings()
+	for _, rec := range recs {
+		path := store.path(rec)
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			slog.Warn("failed to remove terminal recording", "error", err, "path", path)
+		}
+		// The sandbox's directory goes with its last recording
+		_ = os.Remove(filepath.Dir(path))
+	}
+	return int64(len(recs)), nil
+}
+
+// OpenRecording returns a recording of the sandbox with its asciicast file;
+// the caller closes the file. It fails with ErrRecordingNotFound when there is
+// no such recording or its file is gone.
+func (m *DockerManager) OpenRecording(ctx context.Context, sandboxID string, id int64) (*models.TerminalRecording, *os.File, error) {
+	rec, err := m.repo.GetTerminalRecording(ctx, id)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get terminal recording: %w", err)
+	}
+	if rec == nil || rec.SandboxID != sandboxID {
+		return nil, nil, ErrRecordingNotFound
+	}
+
+	f, err := os.Open(m.recordings().path(rec))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, ErrRecordingNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open terminal recording: %w", err)
+	}
+	return rec, f, nil
+}
@@ -0,0 +1,148 @@
+package sandbox
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/config"
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/storage"
+)
+
+func TestTerminalRecording(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewMemoryRepository()
+	m := &DockerManager{
+		repo:           repo,
+		templateLoader: loaderWith(t, "python"),
+		sandboxConfig:  config.SandboxConfig{RecordingsDir: t.TempDir(), RecordingMaxMB: 1},
+	}
+	sb := &models.Sandbox{ID: "sb-1", TemplateID: "python", OwnerClientID: 7}
+
+	// Neither the template nor a session asks for a recording
+	if r, err := m.StartRecording(ctx, sb, nil, 80, 24); err != nil || r != nil {
+		t.Fatalf("expected no recorder, got %v, %v", r, err)
+	}
+
+	session := &models.Session{ID: "s-1", RecordTerminal: true}
+	r, err := m.StartRecording(ctx, sb, session, 80, 24)
+	if err != nil || r == nil {
+		t.Fatalf("expected a recorder, got %v, %v", r, err)
+	}
+	r.Output([]byte("$ "))
+	r.Input([]byte("ls\n")) // input is recorded only when the template asks
+	r.Resize(120, 40)
+	r.maxBytes = r.rec.SizeBytes + 40
+	r.Output([]byte("a line long enough to pass the size cap\r\n"))
+	r.Output([]byte("dropped"))
+	r.Close()
+
+	recs, err := m.ListRecordings(ctx, "sb-1")
+	if err != nil || len(recs) != 1 {
+		t.Fatalf("expected one recording, got %v, %v", recs, err)
+	}
+	if rec := recs[0]; rec.SessionID != "s-1" || rec.OwnerClientID != 7 || rec.EndedAt == nil || !rec.Truncated || rec.SizeBytes == 0 {
+		t.Errorf("expected a finished, truncated recording of the session, got %+v", rec)
+	}
+
+	if _, _, err := m.OpenRecording(ctx, "sb-2", recs[0].ID); !errors.Is(err, ErrRecordingNotFound) {
+		t.Errorf("expected ErrRecordingNotFound for another sandbox, got %v", err)
+	}
+	_, f, err := m.OpenRecording(ctx, "sb-1", recs[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Scan()
+	var header map[string]any
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil || header["version"] != float64(2) || header["width"] != float64(80) {
+		t.Fatalf("expected an asciicast v2 header, got %s", scanner.Bytes())
+	}
+	var codes []string
+	for scanner.Scan() {
+		var event []any
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || len(event) != 3 {
+			t.Fatalf("invalid event %s", scanner.Bytes())
+		}
+		codes = append(codes, event[1].(string)+":"+event[2].(string))
+	}
+	want := []string{"o:$ ", "r:120x40", "m:truncated", "o:" + recordingTruncatedNotice}
+	if len(codes) != len(want) {
+		t.Fatalf("expected events %q, got %q", want, codes)
+	}
+	for i := range want {
+		if codes[i] != want[i] {
+			t.Errorf("event %d: expected %q, got %q", i, want[i], codes[i])
+		}
+	}
+}
+
+func TestRecordingSandboxBudget(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewMemoryRepository()
+	m := &DockerManager{
+		repo:           repo,
+		templateLoader: loaderWith(t, "python"),
+		sandboxConfig:  config.SandboxConfig{RecordingsDir: t.TempDir(), RecordingMaxMB: 2, RecordingSandboxMaxMB: 3},
+	}
+	sb := &models.Sandbox{ID: "sb-1", TemplateID: "python"}
+	session := &models.Session{ID: "s-1", RecordTerminal: true}
+
+	// Earlier shells of the sandbox used 2 MB of its 3
+	if err := repo.CreateTerminalRecording(ctx, &models.TerminalRecording{SandboxID: "sb-1", SizeBytes: 2 << 20}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := m.StartRecording(ctx, sb, session, 80, 24)
+	if err != nil || r == nil || r.maxBytes != 1<<20 {
+		t.Fatalf("expected a recorder capped to the 1 MB left, got %v, %v", r, err)
+	}
+	r.rec.SizeBytes = 1 << 20
+	r.Close()
+
+	if r, err := m.StartRecording(ctx, sb, session, 80, 24); err != nil || r != nil {
+		t.Errorf("expected no recorder once the sandbox cap is used up, got %v, %v", r, err)
+	}
+}
+
+func TestPurgeRecordings(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewMemoryRepository()
+	m := &DockerManager{
+		repo:           repo,
+		templateLoader: loaderWith(t, "python"),
+		sandboxConfig:  config.SandboxConfig{RecordingsDir: t.TempDir(), RecordingMaxMB: 1},
+	}
+	sb := &models.Sandbox{ID: "sb-1", TemplateID: "python"}
+
+	r, err := m.StartRecording(ctx, sb, &models.Session{ID: "s-1", RecordTerminal: true}, 80, 24)
+	if err != nil || r == nil {
+		t.Fatalf("expected a recorder, got %v, %v", r, err)
+	}
+	r.Close()
+	path := m.recordings().path(r.rec)
+
+	// Kept within the retention
+	if n, err := m.PurgeRecordings(ctx, time.Hour); err != nil || n != 0 {
+		t.Fatalf("expected nothing purged, got %d, %v", n, err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected the recording kept, got %v", err)
+	}
+
+	if n, err := m.PurgeRecordings(ctx, -time.Minute); err != nil || n != 1 {
+		t.Fatalf("expected the recording purged, got %d, %v", n, err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected the recording file removed, got %v", err)
+	}
+	if recs, _ := m.ListRecordings(ctx, "sb-1"); len(recs) != 0 {
+		t.Errorf("expected no recordings left, got %v", recs)
+	}
+}
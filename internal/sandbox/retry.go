@@ -0,0 +1,120 @@
+package sandbox
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// retryPolicy retries an operation with exponential backoff while it fails transiently
+type retryPolicy struct {
+	attempts  int
+	baseDelay time.Duration
+	maxDelay  time.Duration
+}
+
+// provisionRetry is the policy for individual provisioning steps
+var provisionRetry = retryPolicy{
+	attempts:  3,
+	baseDelay: 500 * time.Millisecond,
+	maxDelay:  5 * time.Second,
+}
+
+// deprovisionRetry is the policy for releasing a service on delete; a service
+// still failing keeps the sandbox deleting until the cleanup worker retries
+var deprovisionRetry = retryPolicy{
+	attempts:  3,
+	baseDelay: time.Second,
+	maxDelay:  5 * time.Second,
+}
+
+// do runs fn until it succeeds, fails with a non-transient error, the attempts
+// are used up or ctx is done. The returned error carries the attempt count.
+func (p retryPolicy) do(ctx context.Context, step string, fn func(ctx context.Context) error) error {
+	delay := p.baseDelay
+
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			if attempt > 1 {
+				slog.Info("provisioning step succeeded after retry", "step", step, "attempt", attempt)
+			}
+			return nil
+		}
+
+		if attempt >= p.attempts || !isTransient(err) || ctx.Err() != nil {
+			return fmt.Errorf("%w (attempt %d/%d)", err, attempt, p.attempts)
+		}
+
+		slog.Warn("transient provisioning failure, retrying", "step", step, "attempt", attempt, "delay", delay, "error", err)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return fmt.Errorf("%w (attempt %d/%d)", err, attempt, p.attempts)
+		}
+
+		delay *= 2
+		if delay > p.maxDelay {
+			delay = p.maxDelay
+		}
+	}
+}
+
+// transientSQLStates are PostgreSQL error codes worth retrying:
+// connection exceptions, too many connections and server starting up/shutting down
+var transientSQLStates = []string{"08", "53300", "57P01", "57P03"}
+
+// isTransient reports whether err looks like a momentary network, timeout or
+// daemon-busy failure rather than a problem retrying can't fix
+func isTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	// The overall provisioning deadline or a cancellation is final
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	// Docker daemon unreachable, busy or slow
+	if client.IsErrConnectionFailed(err) || errdefs.IsUnavailable(err) || errdefs.IsDeadline(err) {
+		return true
+	}
+
+	// Postgres connection blips
+	if pgconn.Timeout(err) || errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+	var sqlErr interface{ SQLState() string }
+	if errors.As(err, &sqlErr) {
+		state := sqlErr.SQLState()
+		for _, prefix := range transientSQLStates {
+			if strings.HasPrefix(state, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Generic network failures
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,105 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/errdefs"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var testRetry = retryPolicy{attempts: 3, baseDelay: time.Millisecond, maxDelay: 2 * time.Millisecond}
+
+func TestRetryRecoversFromTransientFailures(t *testing.T) {
+	calls := 0
+	err := testRetry.do(context.Background(), "step", func(context.Context) error {
+		calls++
+		if calls < 3 {
+			return fmt.Errorf("dial: %w", syscall.ECONNREFUSED)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected success on third attempt, got %v", err)
+	}
+	if calls != 3 {
+		t.Fatalf("expected 3 calls, got %d", calls)
+	}
+}
+
+func TestRetryGivesUpWithAttemptCount(t *testing.T) {
+	cause := errdefs.Unavailable(errors.New("daemon busy"))
+
+	calls := 0
+	err := testRetry.do(context.Background(), "step", func(context.Context) error {
+		calls++
+		return cause
+	})
+	if calls != 3 {
+		t.Fatalf("expected 3 calls, got %d", calls)
+	}
+	if !errors.Is(err, cause) {
+		t.Fatalf("expected the last error to be wrapped, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "attempt 3/3") {
+		t.Fatalf("expected attempt count in %q", err)
+	}
+}
+
+func TestRetrySkipsPermanentFailures(t *testing.T) {
+	calls := 0
+	err := testRetry.do(context.Background(), "step", func(context.Context) error {
+		calls++
+		return errdefs.InvalidParameter(errors.New("bad image reference"))
+	})
+	if calls != 1 {
+		t.Fatalf("expected a single call for a permanent error, got %d", calls)
+	}
+	if !strings.Contains(err.Error(), "attempt 1/3") {
+		t.Fatalf("expected attempt count in %q", err)
+	}
+}
+
+func TestRetryStopsWhenContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	calls := 0
+	err := retryPolicy{attempts: 3, baseDelay: time.Hour, maxDelay: time.Hour}.do(ctx, "step", func(context.Context) error {
+		calls++
+		cancel()
+		return syscall.ECONNRESET
+	})
+	if err == nil || calls != 1 {
+		t.Fatalf("expected to stop after cancellation, calls=%d err=%v", calls, err)
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"connection refused", fmt.Errorf("x: %w", syscall.ECONNREFUSED), true},
+		{"docker unavailable", errdefs.Unavailable(errors.New("busy")), true},
+		{"docker conflict", errdefs.Conflict(errors.New("name in use")), false},
+		{"postgres too many connections", &pgconn.PgError{Code: "53300"}, true},
+		{"postgres connection failure", &pgconn.PgError{Code: "08006"}, true},
+		{"postgres duplicate database", &pgconn.PgError{Code: "42P04"}, false},
+		{"deadline", context.DeadlineExceeded, false},
+		{"plain error", errors.New("unknown service"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransient(tt.err); got != tt.want {
+				t.Errorf("isTransient(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
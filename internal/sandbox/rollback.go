@@ -0,0 +1,138 @@
+package sandbox
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/errdefs"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/storage"
+)
+
+// Metadata keys recording what a failed provisioning rolled back
+const (
+	metaRollbackServices  = "rollback.services"
+	metaRollbackContainer = "rollback.container"
+	metaRollbackErrors    = "rollback.errors"
+)
+
+// rollbackReport describes what teardownProvisioning cleaned up
+type rollbackReport struct {
+	services  []string
+	container string
+	errors    []string
+}
+
+// apply records the report in the sandbox metadata
+func (r rollbackReport) apply(sb *models.Sandbox) {
+	if len(r.services) == 0 && r.container == "" && len(r.errors) == 0 {
+		return
+	}
+	if sb.Metadata == nil {
+		sb.Metadata = make(map[string]string)
+	}
+	if len(r.services) > 0 {
+		sb.Metadata[metaRollbackServices] = strings.Join(r.services, ",")
+	}
+	if r.container != "" {
+		sb.Metadata[metaRollbackContainer] = r.container
+	}
+	if len(r.errors) > 0 {
+		sb.Metadata[metaRollbackErrors] = strings.Join(r.errors, "; ")
+	}
+}
+
+// failProvisioning rolls back whatever a failed provisioning created and only
+// then marks the sandbox failed, with the rollback recorded in its metadata
+func (m *DockerManager) failProvisioning(ctx context.Context, sb *models.Sandbox, msg string) {
+	m.rollback(ctx, sb, msg)
+	m.RecordEvent(ctx, sb.ID, models.EventFailed, msg)
+
+	var annotations map[string]string
+	if tmpl := m.templateLoader.Get(sb.TemplateID); tmpl != nil {
+		annotations = tmpl.Annotations
+	}
+	m.metrics.SandboxFailed(sb.TemplateID, annotations)
+}
+
+// rollback tears down the sandbox's resources and saves it as failed with msg
+func (m *DockerManager) rollback(ctx context.Context, sb *models.Sandbox, msg string) {
+	report := m.teardownProvisioning(ctx, sb)
+	report.apply(sb)
+
+	sb.Status = models.StatusFailed
+	sb.StatusMsg = msg
+
+	// Service records (planned ones included) go with the failed status, so a
+	// failed sandbox never lists services that were torn down
+	err := m.repo.WithTx(ctx, func(tx storage.Repository) error {
+		if err := tx.DeleteServices(ctx, sb.ID); err != nil {
+			return err
+		}
+		return tx.UpdateSandbox(ctx, sb)
+	})
+	if err != nil {
+		slog.Error("failed to update sandbox status", "error", err, "id", sb.ID, "status", sb.Status)
+	}
+	m.recordUsage(ctx, sb)
+}
+
+// teardownProvisioning removes the container and services a failed
+// provisioning left behind. Records are left to the caller.
+func (m *DockerManager) teardownProvisioning(ctx context.Context, sb *models.Sandbox) rollbackReport {
+	ctx, cancel := context.WithTimeout(ctx, backgroundTimeout)
+	defer cancel()
+
+	var report rollbackReport
+
+	// A create whose response was lost leaves a container without a recorded ID
+	target := sb.ContainerID
+	if target == "" {
+		target = containerName(sb.ID)
+	}
+	if err := m.containers.remove(ctx, target); err != nil {
+		if !errdefs.IsNotFound(err) {
+			slog.Warn("failed to remove container after failed provisioning", "error", err, "container", target, "sandbox", sb.ID)
+			report.errors = append(report.errors, "container: "+err.Error())
+		}
+	} else {
+		report.container = target
+	}
+
+	names := make([]string, 0, len(sb.Services))
+	for name := range sb.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if svc := sb.Services[name]; svc.IsDedicated() {
+			if err := m.removeDedicated(ctx, sb.ID, svc); err != nil {
+				slog.Warn("failed to remove service container after failed provisioning", "error", err, "service", name, "sandbox", sb.ID)
+				report.errors = append(report.errors, name+": "+err.Error())
+				continue
+			}
+			report.services = append(report.services, name)
+			continue
+		}
+		provider := m.serviceRegistry.Get(name)
+		if provider == nil {
+			report.errors = append(report.errors, name+": unknown service")
+			continue
+		}
+		if err := provider.Deprovision(ctx, sb.ID, name); err != nil {
+			slog.Warn("failed to deprovision service after failed provisioning", "error", err, "service", name, "sandbox", sb.ID)
+			report.errors = append(report.errors, name+": "+err.Error())
+			continue
+		}
+		report.services = append(report.services, name)
+	}
+
+	sb.Services = make(map[string]*models.ServiceInstance)
+
+	slog.Info("partially provisioned resources torn down", "sandbox", sb.ID, "services", report.services, "container", report.container)
+	return report
+}
@@ -0,0 +1,403 @@
+package sandbox
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/errdefs"
+
+	"github.com/terra-clan/sandbox-engine/internal/config"
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/services"
+	"github.com/terra-clan/sandbox-engine/internal/storage"
+	"github.com/terra-clan/sandbox-engine/internal/templates"
+)
+
+// recordingProvider tracks which sandboxes hold a provisioned resource
+type recordingProvider struct {
+	basicProvider
+
+	mu           sync.Mutex
+	provisionErr error
+	live         map[string]bool
+}
+
+func (p *recordingProvider) Provision(ctx context.Context, sandboxID, serviceName string, opts map[string]string) (*models.ServiceCredentials, error) {
+	if p.provisionErr != nil {
+		return nil, p.provisionErr
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.live[sandboxID] = true
+	return &models.ServiceCredentials{Host: serviceName}, nil
+}
+
+func (p *recordingProvider) Deprovision(ctx context.Context, sandboxID, serviceName string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.live, sandboxID)
+	return nil
+}
+
+func (p *recordingProvider) leaked() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.live)
+}
+
+// fakeRuntime is an in-memory container runtime that can fail any step
+type fakeRuntime struct {
+	mu         sync.Mutex
+	imageErr   error
+	createErr  error
+	startErr   error
+	nextID     int
+	containers map[string]string // name -> ID
+	env        []string          // env of the last created container
+	services   []*dedicatedService
+	started    []string // IDs of started containers
+	stopped    []string // IDs of stopped containers
+	files      map[string]containerFile
+	workspace  map[string]string // archived files by path
+	owners     map[string]string // "uid:gid" of extracted files by path
+	missing    map[string]bool   // paths stat reports as not found
+	execs      []models.ExecRequest
+	execFunc   func(req models.ExecRequest) *models.ExecResult // nil succeeds
+	inputs     map[string]string                               // stdin of execInput commands by name
+	inputFunc  func(cmd []string, input string) *models.ExecResult
+}
+
+func (r *fakeRuntime) ensureImage(ctx context.Context, image string) error {
+	return r.imageErr
+}
+
+func (r *fakeRuntime) create(ctx context.Context, sb *models.Sandbox, tmpl *models.Template, env []string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.createErr != nil {
+		return "", r.createErr
+	}
+	r.nextID++
+	r.env = env
+	id := fmt.Sprintf("ctr-%d", r.nextID)
+	r.containers[containerName(sb.ID)] = id
+	return id, nil
+}
+
+func (r *fakeRuntime) createService(ctx context.Context, sb *models.Sandbox, svc *dedicatedService) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.createErr != nil {
+		return "", r.createErr
+	}
+	r.nextID++
+	r.services = append(r.services, svc)
+	id := fmt.Sprintf("ctr-%d", r.nextID)
+	r.containers[serviceContainerName(sb.ID, svc.name)] = id
+	return id, nil
+}
+
+func (r *fakeRuntime) lookup(ctx context.Context, nameOrID string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if id, ok := r.containers[nameOrID]; ok {
+		return id, nil
+	}
+	return "", errdefs.NotFound(errors.New("no such container"))
+}
+
+func (r *fakeRuntime) start(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.startErr != nil {
+		return r.startErr
+	}
+	r.started = append(r.started, id)
+	return nil
+}
+
+func (r *fakeRuntime) stop(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stopped = append(r.stopped, id)
+	return nil
+}
+
+func (r *fakeRuntime) copyFile(ctx context.Context, id string, f containerFile) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.files == nil {
+		r.files = map[string]containerFile{}
+	}
+	r.files[f.path] = f
+	return nil
+}
+
+func (r *fakeRuntime) extract(ctx context.Context, id string, content io.Reader) error {
+	tr := tar.NewReader(content)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		r.mu.Lock()
+		if r.workspace == nil {
+			r.workspace = map[string]string{}
+			r.owners = map[string]string{}
+		}
+		r.workspace[hdr.Name] = string(data)
+		r.owners[hdr.Name] = fmt.Sprintf("%d:%d", hdr.Uid, hdr.Gid)
+		r.mu.Unlock()
+	}
+}
+
+func (r *fakeRuntime) stat(ctx context.Context, id, path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.missing[path] {
+		return errdefs.NotFound(errors.New("no such file"))
+	}
+	return nil
+}
+
+func (r *fakeRuntime) archive(ctx context.Context, id, path string) (io.ReadCloser, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.missing[path] {
+		return nil, errdefs.NotFound(errors.New("no such file"))
+	}
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range r.workspace {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content))}); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return io.NopCloser(&buf), nil
+}
+
+func (r *fakeRuntime) remove(ctx context.Context, nameOrID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name, id := range r.containers {
+		if name == nameOrID || id == nameOrID {
+			delete(r.containers, name)
+			return nil
+		}
+	}
+	return errdefs.NotFound(errors.New("no such container"))
+}
+
+func (r *fakeRuntime) exec(ctx context.Context, sb *models.Sandbox, req models.ExecRequest) (*models.ExecResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.execs = append(r.execs, req)
+	if r.execFunc != nil {
+		return r.execFunc(req), nil
+	}
+	return &models.ExecResult{}, nil
+}
+
+func (r *fakeRuntime) execInput(ctx context.Context, id string, cmd, env []string, input io.Reader) (*models.ExecResult, error) {
+	data, err := io.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.inputs == nil {
+		r.inputs = make(map[string]string)
+	}
+	r.inputs[cmd[0]] = string(data)
+	if r.inputFunc != nil {
+		return r.inputFunc(cmd, string(data)), nil
+	}
+	return &models.ExecResult{}, nil
+}
+
+// provisionRepo records the services and the last saved state of a sandbox
+type provisionRepo struct {
+	storage.Repository
+
+	mu       sync.Mutex
+	services map[string]int
+	saved    *models.Sandbox
+	usage    []*models.UsageRecord
+	events   []models.SandboxEventType
+}
+
+// WithTx runs fn directly; the fake has nothing to roll back
+func (r *provisionRepo) WithTx(ctx context.Context, fn func(storage.Repository) error) error {
+	return fn(r)
+}
+
+func (r *provisionRepo) AppendEvent(ctx context.Context, ev *models.SandboxEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, ev.Type)
+	return nil
+}
+
+func (r *provisionRepo) RecordTemplateUsage(ctx context.Context, rec *models.UsageRecord) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.usage = append(r.usage, rec)
+	return true, nil
+}
+
+func (r *provisionRepo) CreateService(ctx context.Context, sandboxID string, svc *models.ServiceInstance) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.services[sandboxID]++
+	return nil
+}
+
+func (r *provisionRepo) DeleteServices(ctx context.Context, sandboxID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.services, sandboxID)
+	return nil
+}
+
+func (r *provisionRepo) UpdateSandbox(ctx context.Context, sb *models.Sandbox) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	saved := *sb
+	r.saved = &saved
+	return nil
+}
+
+func TestProvisionRollsBackOnFailure(t *testing.T) {
+	boom := errors.New("boom")
+
+	tests := []struct {
+		name          string
+		failService   bool
+		imageErr      error
+		createErr     error
+		startErr      error
+		wantServices  string
+		wantContainer bool
+	}{
+		{name: "second service", failService: true, wantServices: "postgres"},
+		{name: "image", imageErr: boom, wantServices: "postgres,redis"},
+		{name: "create", createErr: boom, wantServices: "postgres,redis"},
+		{name: "start", startErr: boom, wantServices: "postgres,redis", wantContainer: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			postgres := &recordingProvider{live: map[string]bool{}}
+			redis := &recordingProvider{live: map[string]bool{}}
+			if tt.failService {
+				redis.provisionErr = boom
+			}
+
+			registry := services.NewRegistry()
+			registry.Register("postgres", postgres)
+			registry.Register("redis", redis)
+
+			runtime := &fakeRuntime{
+				imageErr:   tt.imageErr,
+				createErr:  tt.createErr,
+				startErr:   tt.startErr,
+				containers: map[string]string{},
+			}
+			repo := &provisionRepo{services: map[string]int{}}
+
+			m := &DockerManager{
+				traefikConfig:   config.TraefikConfig{Enabled: true},
+				sandboxConfig:   config.SandboxConfig{ProvisionTimeout: time.Minute},
+				serviceRegistry: registry,
+				templateLoader:  templates.NewLoader(),
+				repo:            repo,
+				containers:      runtime,
+			}
+
+			sb := &models.Sandbox{
+				ID:       "sb-1",
+				Status:   models.StatusPending,
+				Services: make(map[string]*models.ServiceInstance),
+			}
+			tmpl := &models.Template{Name: "tmpl", BaseImage: "alpine"}
+
+			m.provisionSandbox(context.Background(), sb, tmpl, nil, []string{"postgres", "redis"})
+
+			if n := postgres.leaked() + redis.leaked(); n != 0 {
+				t.Errorf("expected no provisioned services left, %d leaked", n)
+			}
+			if len(runtime.containers) != 0 {
+				t.Errorf("expected no containers left, got %v", runtime.containers)
+			}
+			if len(repo.services) != 0 {
+				t.Errorf("expected service records to be deleted, got %v", repo.services)
+			}
+
+			saved := repo.saved
+			if saved == nil {
+				t.Fatal("expected the sandbox to be saved")
+			}
+			if saved.Status != models.StatusFailed || !strings.Contains(saved.StatusMsg, "boom") {
+				t.Errorf("expected failed with the cause, got %s %q", saved.Status, saved.StatusMsg)
+			}
+			if got := saved.Metadata[metaRollbackServices]; got != tt.wantServices {
+				t.Errorf("expected rolled back services %q, got %q", tt.wantServices, got)
+			}
+			if got := saved.Metadata[metaRollbackContainer]; (got != "") != tt.wantContainer {
+				t.Errorf("unexpected rolled back container %q", got)
+			}
+			if got := saved.Metadata[metaRollbackErrors]; got != "" {
+				t.Errorf("expected a clean rollback, got errors %q", got)
+			}
+			if len(repo.usage) != 1 || !repo.usage[0].Failed {
+				t.Errorf("expected the failure to be counted in template usage, got %+v", repo.usage)
+			}
+			if n := len(repo.events); n == 0 || repo.events[n-1] != models.EventFailed {
+				t.Errorf("expected a trailing failed event, got %v", repo.events)
+			}
+		})
+	}
+}
+
+func TestRollbackRemovesContainerWithoutRecordedID(t *testing.T) {
+	runtime := &fakeRuntime{containers: map[string]string{containerName("sb-1"): "ctr-lost"}}
+	repo := &provisionRepo{services: map[string]int{}}
+
+	m := &DockerManager{
+		serviceRegistry: services.NewRegistry(),
+		templateLoader:  templates.NewLoader(),
+		repo:            repo,
+		containers:      runtime,
+	}
+
+	sb := &models.Sandbox{ID: "sb-1", Status: models.StatusPending}
+	m.failProvisioning(context.Background(), sb, "failed")
+
+	if len(runtime.containers) != 0 {
+		t.Fatalf("expected the orphaned container to be removed, got %v", runtime.containers)
+	}
+	if got := repo.saved.Metadata[metaRollbackContainer]; got != containerName("sb-1") {
+		t.Fatalf("expected the container to be recorded, got %q", got)
+	}
+}
@@ -0,0 +1,99 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/services"
+)
+
+// rotatedCredentialsFile is where rotated credentials are written for
+// templates that deliver them in env vars only, which go stale on rotation
+var rotatedCredentialsFile = models.CredentialsFileSpec{
+	Path:   "/run/sandbox/credentials.json",
+	Format: models.CredentialsFormatJSON,
+	User:   models.DefaultCredentialsUser,
+}
+
+// RotateServiceCredentials replaces the credentials of a sandbox service
+// through its provider, stores them and refreshes the credentials file in the
+// container. The env vars of the running container keep the old values.
+func (m *DockerManager) RotateServiceCredentials(ctx context.Context, id, name string) (*models.ServiceInstance, error) {
+	release, err := m.ops.begin(id, "rotate")
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	sb, err := m.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	svcs, err := m.repo.GetServices(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get services: %w", err)
+	}
+	var svc *models.ServiceInstance
+	sb.Services = make(map[string]*models.ServiceInstance, len(svcs))
+	for _, s := range svcs {
+		sb.Services[s.Name] = s
+		if s.Name == name {
+			svc = s
+		}
+	}
+	if svc == nil {
+		return nil, ErrServiceNotFound
+	}
+
+	if svc.IsDedicated() {
+		// Its password is set when the container is created
+		return nil, ErrRotateUnsupported
+	}
+	rotator, ok := m.serviceRegistry.Get(svc.Type).(services.CredentialRotator)
+	if !ok {
+		return nil, ErrRotateUnsupported
+	}
+	if svc.Status == models.ServiceStatusProvisioning {
+		return nil, ErrServiceNotReady
+	}
+
+	creds, err := rotator.RotateCredentials(ctx, id, name, svc.Credentials)
+	if errors.Is(err, services.ErrRotationUnsupported) {
+		return nil, ErrRotateUnsupported
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate credentials: %w", err)
+	}
+
+	// The old password no longer works, so the new one must be kept even if
+	// the request is cancelled from here on
+	ctx = context.WithoutCancel(ctx)
+	svc.Credentials = creds
+	if err := m.repo.UpdateService(ctx, id, svc); err != nil {
+		return nil, fmt.Errorf("failed to update service: %w", err)
+	}
+	m.RecordEvent(ctx, id, models.EventCredentialsRotated, name)
+
+	if sb.ContainerID != "" {
+		if err := m.writeCredentialsFile(ctx, sb, rotationFileTemplate(m.templateLoader.Get(sb.TemplateID))); err != nil {
+			slog.Warn("failed to refresh credentials file", "error", err, "sandbox_id", id, "service", name)
+		}
+	}
+
+	slog.Info("service credentials rotated", "sandbox_id", id, "service", name)
+
+	return svc, nil
+}
+
+// rotationFileTemplate returns tmpl when it delivers credentials in a file,
+// and otherwise a template writing them to rotatedCredentialsFile
+func rotationFileTemplate(tmpl *models.Template) *models.Template {
+	if tmpl != nil && tmpl.CredentialsDelivery.InFile() && tmpl.CredentialsFile != nil {
+		return tmpl
+	}
+	spec := rotatedCredentialsFile
+	return &models.Template{CredentialsDelivery: models.CredentialsFile, CredentialsFile: &spec}
+}
@@ -0,0 +1,111 @@
+package sandbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/services"
+	"github.com/terra-clan/sandbox-engine/internal/storage"
+	"github.com/terra-clan/sandbox-engine/internal/templates"
+)
+
+// rotatingProvider is a service provider that rotates to a fixed password
+type rotatingProvider struct {
+	basicProvider
+	err error
+}
+
+func (p *rotatingProvider) RotateCredentials(ctx context.Context, sandboxID, serviceName string, creds *models.ServiceCredentials) (*models.ServiceCredentials, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	rotated := *creds
+	rotated.Password = "rotated"
+	return &rotated, nil
+}
+
+func TestRotateServiceCredentials(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewMemoryRepository()
+	if err := repo.CreateSandbox(ctx, &models.Sandbox{ID: "sb-1", Status: models.StatusRunning, ContainerID: "c-1"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, svc := range []*models.ServiceInstance{
+		{Name: "postgres", Type: "postgres", Status: models.ServiceStatusReady, Credentials: &models.ServiceCredentials{Username: "u", Password: "old"}},
+		{Name: "redis", Type: "redis", Status: models.ServiceStatusReady, Credentials: &models.ServiceCredentials{Password: "shared"}},
+		{Name: "minio", Type: "minio", Status: models.ServiceStatusReady},
+		{Name: "cache", Type: "redis", Mode: models.ServiceDedicated, Status: models.ServiceStatusReady},
+	} {
+		if err := repo.CreateService(ctx, "sb-1", svc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	registry := services.NewRegistry()
+	registry.Register("postgres", &rotatingProvider{})
+	registry.Register("redis", &rotatingProvider{err: services.ErrRotationUnsupported})
+	registry.Register("minio", &basicProvider{})
+	runtime := &fakeRuntime{containers: map[string]string{}}
+	m := &DockerManager{
+		serviceRegistry: registry,
+		templateLoader:  templates.NewLoader(),
+		repo:            repo,
+		containers:      runtime,
+		ops:             newOperationTracker(),
+	}
+
+	svc, err := m.RotateServiceCredentials(ctx, "sb-1", "postgres")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svc.Credentials.Password != "rotated" || svc.Credentials.Username != "u" {
+		t.Fatalf("expected the rotated credentials, got %+v", svc.Credentials)
+	}
+	if stored, _ := m.GetService(ctx, "sb-1", "postgres"); stored.Credentials.Password != "rotated" {
+		t.Errorf("expected the rotated credentials stored, got %+v", stored.Credentials)
+	}
+
+	// A template delivering credentials in env vars gets the default file
+	f, ok := runtime.files[rotatedCredentialsFile.Path]
+	if !ok || f.mode != credentialsFileMode || f.uid != 1000 {
+		t.Fatalf("expected the credentials file written, got %v", runtime.files)
+	}
+	var written map[string]*models.ServiceCredentials
+	if err := json.Unmarshal(f.content, &written); err != nil {
+		t.Fatal(err)
+	}
+	if written["postgres"].Password != "rotated" || written["redis"].Password != "shared" {
+		t.Errorf("expected every service's current credentials in the file, got %s", f.content)
+	}
+
+	events, _ := repo.ListEvents(ctx, "sb-1")
+	if len(events) != 1 || events[0].Type != models.EventCredentialsRotated || events[0].Message != "postgres" {
+		t.Errorf("expected a credentials_rotated event, got %+v", events)
+	}
+
+	for _, name := range []string{"redis", "minio", "cache"} {
+		if _, err := m.RotateServiceCredentials(ctx, "sb-1", name); !errors.Is(err, ErrRotateUnsupported) {
+			t.Errorf("%s: expected ErrRotateUnsupported, got %v", name, err)
+		}
+	}
+	if _, err := m.RotateServiceCredentials(ctx, "sb-1", "missing"); !errors.Is(err, ErrServiceNotFound) {
+		t.Errorf("expected ErrServiceNotFound, got %v", err)
+	}
+}
+
+func TestRotationFileTemplate(t *testing.T) {
+	spec := &models.CredentialsFileSpec{Path: "/etc/app/creds.ini", Format: models.CredentialsFormatINI, User: "1001"}
+	inFile := &models.Template{CredentialsDelivery: models.CredentialsBoth, CredentialsFile: spec}
+	if got := rotationFileTemplate(inFile); got != inFile {
+		t.Errorf("expected the template's own credentials file, got %+v", got.CredentialsFile)
+	}
+	for _, tmpl := range []*models.Template{nil, {CredentialsDelivery: models.CredentialsEnv}} {
+		got := rotationFileTemplate(tmpl)
+		if !got.CredentialsDelivery.InFile() || *got.CredentialsFile != rotatedCredentialsFile {
+			t.Errorf("expected the default credentials file, got %+v", got.CredentialsFile)
+		}
+	}
+}
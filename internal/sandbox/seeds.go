@@ -0,0 +1,109 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/services"
+	"github.com/terra-clan/sandbox-engine/internal/tracing"
+)
+
+// seedServices loads the seed file of each provisioned service the template
+// configures one for. Seeds aren't retried: a failed one is partly applied.
+func (m *DockerManager) seedServices(ctx context.Context, sb *models.Sandbox, tmpl *models.Template, serviceList []string) error {
+	for _, name := range serviceList {
+		cfg := tmpl.ServicesConfig[name]
+		svc := sb.Services[name]
+		if cfg.Seed == "" || svc == nil {
+			continue
+		}
+
+		spanCtx, span := tracing.Start(ctx, "service.seed", attribute.String("service.name", name))
+		err := m.seedService(spanCtx, svc, cfg)
+		tracing.End(span, err)
+		if err != nil {
+			return fmt.Errorf("failed to seed %s from %s: %w", name, cfg.Seed, err)
+		}
+		m.RecordEvent(ctx, sb.ID, models.EventServiceSeeded, name)
+	}
+	return nil
+}
+
+// seedService streams a seed file into a service instance: through its
+// provider for a shared instance, into its container for a dedicated one
+func (m *DockerManager) seedService(ctx context.Context, svc *models.ServiceInstance, cfg models.ServiceConfig) error {
+	if cfg.SeedPath == "" {
+		return fmt.Errorf("seed file not found next to the template")
+	}
+	f, err := os.Open(cfg.SeedPath)
+	if err != nil {
+		return fmt.Errorf("failed to open seed: %w", err)
+	}
+	defer f.Close()
+
+	if svc.IsDedicated() {
+		return m.seedDedicated(ctx, svc, f)
+	}
+	seeder, ok := m.serviceRegistry.Get(svc.Name).(services.Seeder)
+	if !ok {
+		return fmt.Errorf("the %s provider can't load seeds", svc.Name)
+	}
+	return seeder.Seed(ctx, svc.Credentials, f)
+}
+
+// seedDedicated pipes a seed to the service's own client in its container:
+// psql for a SQL script, redis-cli --pipe for Redis commands, encoded as
+// they are read
+func (m *DockerManager) seedDedicated(ctx context.Context, svc *models.ServiceInstance, seed io.Reader) error {
+	creds := svc.Credentials
+	var cmd, env []string
+	input := seed
+	encoded := make(chan error, 1)
+	switch svc.Name {
+	case "postgres":
+		cmd = []string{"psql", "-X", "-q", "-v", "ON_ERROR_STOP=1", "-U", creds.Username, "-d", creds.Database, "-f", "-"}
+		env = []string{"PGPASSWORD=" + creds.Password}
+		encoded <- nil
+	case "redis":
+		// The whole keyspace is the sandbox's, so keys take no prefix
+		pr, pw := io.Pipe()
+		go func() {
+			err := services.EncodeRedisSeed(pw, seed, "")
+			pw.CloseWithError(err)
+			encoded <- err
+		}()
+		cmd = []string{"redis-cli", "--pipe"}
+		env = []string{"REDISCLI_AUTH=" + creds.Password}
+		input = pr
+	default:
+		return fmt.Errorf("%s can't be seeded", svc.Name)
+	}
+
+	result, err := m.containers.execInput(ctx, svc.ContainerID, cmd, env, input)
+	// Unblocks an encoder the command stopped reading from
+	if pr, ok := input.(*io.PipeReader); ok {
+		pr.Close()
+	}
+	// A malformed seed explains a failed command best
+	if encodeErr := <-encoded; encodeErr != nil && !errors.Is(encodeErr, io.ErrClosedPipe) {
+		return encodeErr
+	}
+	if err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		output := strings.TrimSpace(result.Stdout + "\n" + result.Stderr)
+		if len(output) > setupOutputMax {
+			output = "..." + output[len(output)-setupOutputMax:]
+		}
+		return fmt.Errorf("%s exited with %d: %s", cmd[0], result.ExitCode, output)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/config"
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/services"
+	"github.com/terra-clan/sandbox-engine/internal/templates"
+)
+
+// seedingProvider is a recordingProvider that also loads seeds
+type seedingProvider struct {
+	recordingProvider
+
+	seedErr error
+	seeded  string
+}
+
+func (p *seedingProvider) Seed(ctx context.Context, creds *models.ServiceCredentials, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	p.seeded = string(data)
+	return p.seedErr
+}
+
+// writeSeed writes a seed file and returns its service config
+func writeSeed(t *testing.T, name, content string) models.ServiceConfig {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return models.ServiceConfig{Seed: "fixtures/" + name, SeedPath: path}
+}
+
+func newSeedManager(postgres *seedingProvider, runtime *fakeRuntime, repo *provisionRepo) *DockerManager {
+	registry := services.NewRegistry()
+	registry.Register("postgres", postgres)
+	return &DockerManager{
+		traefikConfig:   config.TraefikConfig{Enabled: true},
+		sandboxConfig:   config.SandboxConfig{ProvisionTimeout: time.Minute},
+		serviceRegistry: registry,
+		templateLoader:  templates.NewLoader(),
+		repo:            repo,
+		containers:      runtime,
+	}
+}
+
+func TestProvisionSeedsServices(t *testing.T) {
+	postgres := &seedingProvider{recordingProvider: recordingProvider{live: map[string]bool{}}}
+	runtime := &fakeRuntime{
+		containers: map[string]string{},
+		// Answers the dedicated redis readiness probe
+		execFunc: func(req models.ExecRequest) *models.ExecResult { return &models.ExecResult{Stdout: "PONG"} },
+	}
+	repo := &provisionRepo{services: map[string]int{}}
+	m := newSeedManager(postgres, runtime, repo)
+
+	sb := &models.Sandbox{ID: "sb-1", Status: models.StatusPending, Services: make(map[string]*models.ServiceInstance)}
+	tmpl := &models.Template{
+		Name:         "tmpl",
+		BaseImage:    "alpine",
+		Services:     []string{"postgres", "redis"},
+		ServiceSpecs: map[string]models.ServiceSpec{"redis": {Mode: models.ServiceDedicated}},
+		ServicesConfig: map[string]models.ServiceConfig{
+			"postgres": writeSeed(t, "orders.sql", "CREATE TABLE orders (id int);"),
+			"redis":    writeSeed(t, "cache.redis", "SET {prefix}greeting hi"),
+		},
+	}
+	if err := m.provision(context.Background(), sb, tmpl, nil, tmpl.Services); err != nil {
+		t.Fatal(err)
+	}
+
+	if postgres.seeded != "CREATE TABLE orders (id int);" {
+		t.Errorf("expected the shared postgres seeded through its provider, got %q", postgres.seeded)
+	}
+	// A dedicated redis takes unprefixed keys through redis-cli --pipe
+	if got, want := runtime.inputs["redis-cli"], "*3\r\n$3\r\nSET\r\n$8\r\ngreeting\r\n$2\r\nhi\r\n"; got != want {
+		t.Errorf("expected %q piped to redis-cli, got %q", want, got)
+	}
+	seeded := 0
+	for _, ev := range repo.events {
+		if ev == models.EventServiceSeeded {
+			seeded++
+		}
+	}
+	if seeded != 2 {
+		t.Errorf("expected two service_seeded events, got %v", repo.events)
+	}
+}
+
+func TestProvisionSeedFailure(t *testing.T) {
+	tests := []struct {
+		name      string
+		spec      models.ServiceSpec
+		seedErr   error
+		seed      string
+		wantError string
+	}{
+		{
+			name:      "shared",
+			seedErr:   errors.New(`statement 2 (line 3): pq: relation "missing" does not exist`),
+			seed:      "SELECT 1;",
+			wantError: `statement 2 (line 3): pq: relation "missing" does not exist`,
+		},
+		{
+			name:      "dedicated",
+			spec:      models.ServiceSpec{Mode: models.ServiceDedicated},
+			seed:      "SELECT * FROM missing;",
+			wantError: `psql exited with 3: psql:<stdin>:1: ERROR:  relation "missing" does not exist`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			postgres := &seedingProvider{recordingProvider: recordingProvider{live: map[string]bool{}}, seedErr: tt.seedErr}
+			runtime := &fakeRuntime{
+				containers: map[string]string{},
+				execFunc: func(req models.ExecRequest) *models.ExecResult {
+					return &models.ExecResult{Stdout: "accepting connections"}
+				},
+				inputFunc: func(cmd []string, input string) *models.ExecResult {
+					return &models.ExecResult{ExitCode: 3, Stderr: `psql:<stdin>:1: ERROR:  relation "missing" does not exist`}
+				},
+			}
+			repo := &provisionRepo{services: map[string]int{}}
+			m := newSeedManager(postgres, runtime, repo)
+
+			sb := &models.Sandbox{ID: "sb-1", Status: models.StatusPending, Services: make(map[string]*models.ServiceInstance)}
+			tmpl := &models.Template{
+				Name:           "tmpl",
+				BaseImage:      "alpine",
+				Services:       []string{"postgres"},
+				ServiceSpecs:   map[string]models.ServiceSpec{"postgres": tt.spec},
+				ServicesConfig: map[string]models.ServiceConfig{"postgres": writeSeed(t, "orders.sql", tt.seed)},
+			}
+			m.provisionSandbox(context.Background(), sb, tmpl, nil, tmpl.Services)
+
+			saved := repo.saved
+			if saved == nil || saved.Status != models.StatusFailed {
+				t.Fatalf("expected the sandbox failed, got %+v", saved)
+			}
+			if !strings.Contains(saved.StatusMsg, "failed to seed postgres from fixtures/orders.sql") || !strings.Contains(saved.StatusMsg, tt.wantError) {
+				t.Errorf("expected the seed error in the status, got %q", saved.StatusMsg)
+			}
+			if slices.Contains(repo.events, models.EventServiceSeeded) || slices.Contains(repo.events, models.EventStarted) {
+				t.Errorf("expected the sandbox never seeded nor started, got %v", repo.events)
+			}
+			if len(runtime.containers) != 0 || postgres.leaked() != 0 {
+				t.Errorf("expected everything torn down, got containers %v and %d services", runtime.containers, postgres.leaked())
+			}
+		})
+	}
+}
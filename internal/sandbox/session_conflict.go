@@ -0,0 +1,79 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/storage"
+)
+
+// maxClaimAttempts bounds how often activation retries after superseding a
+// conflicting session, in case another activation takes the key in between
+const maxClaimAttempts = 3
+
+// claimSession persists the transition of a ready session to provisioning and
+// reports whether this call made it; false means a concurrent activation of
+// the same session got there first. The database also allows one live session
+// per unique key, so concurrent activations for the same key can't both win;
+// the loser is rejected or supersedes the holder according to the session's
+// conflict policy.
+func (m *DockerManager) claimSession(ctx context.Context, session *models.Session) (bool, error) {
+	for attempt := 1; ; attempt++ {
+		claimed, err := m.repo.ClaimSession(ctx, session)
+		if err == nil {
+			return claimed, nil
+		}
+		if !errors.Is(err, storage.ErrUniqueKeyConflict) {
+			return false, fmt.Errorf("failed to claim session: %w", err)
+		}
+
+		if session.OnConflict != models.ConflictSupersede || attempt == maxClaimAttempts {
+			slog.Info("session activation rejected, unique key in use", "id", session.ID, "attempt", attempt)
+			return false, ErrSessionConflict
+		}
+
+		holder, err := m.repo.GetLiveSessionByUniqueKey(ctx, session.UniqueKey)
+		if err != nil {
+			return false, fmt.Errorf("failed to get conflicting session: %w", err)
+		}
+		if holder == nil || holder.ID == session.ID {
+			// Released in the meantime, try again
+			continue
+		}
+
+		if err := m.supersedeSession(ctx, holder, session.ID); err != nil {
+			return false, err
+		}
+	}
+}
+
+// supersedeSession expires a live session in favour of a newer one for the
+// same unique key and deletes its sandbox
+func (m *DockerManager) supersedeSession(ctx context.Context, old *models.Session, newID string) error {
+	from := old.Status
+	old.Status = models.SessionExpired
+	old.StatusMessage = models.StatusMsgSuperseded + newID
+
+	updated, err := m.repo.UpdateSessionFrom(ctx, old, from)
+	if err != nil {
+		return fmt.Errorf("failed to expire superseded session: %w", err)
+	}
+	if !updated {
+		// It moved on meanwhile; the caller looks the key up again
+		return nil
+	}
+
+	slog.Info("session superseded", "id", old.ID, "by", newID)
+	m.SendSessionCallback(ctx, old, models.CallbackExpired)
+
+	if old.SandboxID != "" {
+		if err := m.Delete(ctx, old.SandboxID); err != nil && !errors.Is(err, ErrOperationPending) && !errors.Is(err, ErrSandboxNotFound) {
+			slog.Warn("failed to delete superseded session sandbox", "error", err, "sandbox_id", old.SandboxID)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,259 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/storage"
+)
+
+// sessionRepo is an in-memory session store that enforces the partial unique
+// index on live sessions like the database does
+type sessionRepo struct {
+	storage.Repository
+
+	mu       sync.Mutex
+	sessions map[string]models.Session
+}
+
+func newSessionRepo(sessions ...*models.Session) *sessionRepo {
+	r := &sessionRepo{sessions: make(map[string]models.Session)}
+	for _, s := range sessions {
+		r.sessions[s.ID] = *s
+	}
+	return r
+}
+
+func isLive(s models.Session) bool {
+	return s.Status == models.SessionProvisioning || s.Status == models.SessionActive
+}
+
+func (r *sessionRepo) UpdateSession(ctx context.Context, s *models.Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.keyTaken(s) {
+		return storage.ErrUniqueKeyConflict
+	}
+	r.sessions[s.ID] = *s
+	return nil
+}
+
+func (r *sessionRepo) UpdateSessionFrom(ctx context.Context, s *models.Session, from models.SessionStatus) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.sessions[s.ID].Status != from {
+		return false, nil
+	}
+	if r.keyTaken(s) {
+		return false, storage.ErrUniqueKeyConflict
+	}
+	r.sessions[s.ID] = *s
+	return true, nil
+}
+
+func (r *sessionRepo) ClaimSession(ctx context.Context, s *models.Session) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.sessions[s.ID].Status != models.SessionReady {
+		return false, nil
+	}
+	if r.keyTaken(s) {
+		return false, storage.ErrUniqueKeyConflict
+	}
+	r.sessions[s.ID] = *s
+	return true, nil
+}
+
+// keyTaken reports whether another live session holds the unique key of s
+func (r *sessionRepo) keyTaken(s *models.Session) bool {
+	if s.UniqueKey == "" || !isLive(*s) {
+		return false
+	}
+	for id, other := range r.sessions {
+		if id != s.ID && other.UniqueKey == s.UniqueKey && isLive(other) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *sessionRepo) GetSessionByID(ctx context.Context, id string) (*models.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if s, ok := r.sessions[id]; ok {
+		return &s, nil
+	}
+	return nil, nil
+}
+
+func (r *sessionRepo) GetLiveSessionByUniqueKey(ctx context.Context, key string) (*models.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, s := range r.sessions {
+		if s.UniqueKey == key && isLive(s) {
+			s := s
+			return &s, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *sessionRepo) live(key string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var ids []string
+	for id, s := range r.sessions {
+		if s.UniqueKey == key && isLive(s) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func readySessions(n int, policy models.ConflictPolicy) []*models.Session {
+	sessions := make([]*models.Session, n)
+	for i := range sessions {
+		sessions[i] = &models.Session{
+			ID:         fmt.Sprintf("s%d", i),
+			Status:     models.SessionReady,
+			UniqueKey:  "candidate",
+			OnConflict: policy,
+		}
+	}
+	return sessions
+}
+
+// claimAll activates every session concurrently and returns the errors
+func claimAll(m *DockerManager, sessions []*models.Session) []error {
+	errs := make([]error, len(sessions))
+	var wg sync.WaitGroup
+	for i, s := range sessions {
+		wg.Add(1)
+		go func(i int, s models.Session) {
+			defer wg.Done()
+			s.Status = models.SessionProvisioning
+			_, errs[i] = m.claimSession(context.Background(), &s)
+		}(i, *s)
+	}
+	wg.Wait()
+	return errs
+}
+
+func TestClaimSessionRejectConcurrent(t *testing.T) {
+	sessions := readySessions(10, models.ConflictReject)
+	repo := newSessionRepo(sessions...)
+	m := &DockerManager{repo: repo}
+
+	won := 0
+	for _, err := range claimAll(m, sessions) {
+		switch {
+		case err == nil:
+			won++
+		case !errors.Is(err, ErrSessionConflict):
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if won != 1 {
+		t.Fatalf("expected exactly one activation to win, got %d", won)
+	}
+	if live := repo.live("candidate"); len(live) != 1 {
+		t.Fatalf("expected one live session, got %v", live)
+	}
+}
+
+func TestClaimSessionSupersede(t *testing.T) {
+	sessions := readySessions(2, models.ConflictSupersede)
+	repo := newSessionRepo(sessions...)
+	m := &DockerManager{repo: repo}
+
+	first, second := *sessions[0], *sessions[1]
+	first.Status = models.SessionProvisioning
+	second.Status = models.SessionProvisioning
+
+	if claimed, err := m.claimSession(context.Background(), &first); err != nil || !claimed {
+		t.Fatalf("first activation failed: %v", err)
+	}
+	if claimed, err := m.claimSession(context.Background(), &second); err != nil || !claimed {
+		t.Fatalf("superseding activation failed: %v", err)
+	}
+
+	if live := repo.live("candidate"); len(live) != 1 || live[0] != second.ID {
+		t.Fatalf("expected only the newer session live, got %v", live)
+	}
+
+	old := repo.sessions[first.ID]
+	if old.Status != models.SessionExpired || old.StatusMessage != models.StatusMsgSuperseded+second.ID {
+		t.Fatalf("expected older session expired as superseded, got %s %q", old.Status, old.StatusMessage)
+	}
+}
+
+func TestClaimSessionSupersedeConcurrent(t *testing.T) {
+	sessions := readySessions(10, models.ConflictSupersede)
+	repo := newSessionRepo(sessions...)
+	m := &DockerManager{repo: repo}
+
+	for _, err := range claimAll(m, sessions) {
+		if err != nil && !errors.Is(err, ErrSessionConflict) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if live := repo.live("candidate"); len(live) != 1 {
+		t.Fatalf("expected one live session, got %v", live)
+	}
+}
+
+func TestClaimSessionWithoutKey(t *testing.T) {
+	a := &models.Session{ID: "a", Status: models.SessionReady}
+	b := &models.Session{ID: "b", Status: models.SessionReady}
+	m := &DockerManager{repo: newSessionRepo(a, b)}
+
+	a.Status, b.Status = models.SessionProvisioning, models.SessionProvisioning
+	if _, err := m.claimSession(context.Background(), a); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := m.claimSession(context.Background(), b); err != nil {
+		t.Fatalf("sessions without a unique key must not conflict: %v", err)
+	}
+}
+
+func TestClaimSessionOnce(t *testing.T) {
+	repo := newSessionRepo(&models.Session{ID: "s", Status: models.SessionReady})
+	m := &DockerManager{repo: repo}
+
+	// A retried activate, or the join link open in several tabs
+	const attempts = 10
+	var claims atomic.Int32
+	var wg sync.WaitGroup
+	for range attempts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s := models.Session{ID: "s", Status: models.SessionProvisioning}
+			claimed, err := m.claimSession(context.Background(), &s)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if claimed {
+				claims.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := claims.Load(); n != 1 {
+		t.Fatalf("expected exactly one activation to claim the session, got %d", n)
+	}
+}
@@ -0,0 +1,147 @@
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+// TrackSessionConnection records that a terminal of the session connected and
+// returns the function recording its end. Like events, connections are best
+// effort: a failed write is logged and never fails the terminal.
+func (m *DockerManager) TrackSessionConnection(ctx context.Context, sessionID string) func() {
+	ctx = context.WithoutCancel(ctx)
+	conn := &models.SessionConnection{SessionID: sessionID, ConnectedAt: time.Now()}
+	if err := m.repo.OpenSessionConnection(ctx, conn); err != nil {
+		slog.Warn("failed to record session connection", "error", err, "session_id", sessionID)
+		return func() {}
+	}
+
+	return func() {
+		if err := m.repo.CloseSessionConnection(ctx, conn.ID, time.Now()); err != nil {
+			slog.Warn("failed to record session disconnection", "error", err, "session_id", sessionID)
+		}
+	}
+}
+
+// SessionReport summarizes a session's activity from its persisted records:
+// when it was joined, how long and how often its terminal was connected, how
+// much time extensions added, and its sandbox's status and events.
+func (m *DockerManager) SessionReport(ctx context.Context, id string) (*models.SessionReport, error) {
+	session, err := m.repo.GetSessionByID(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	if session == nil {
+		return nil, ErrSessionNotFound
+	}
+
+	conns, err := m.repo.ListSessionConnections(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list session connections: %w", err)
+	}
+
+	report := &models.SessionReport{
+		SessionID:           session.ID,
+		Status:              session.Status,
+		CreatedAt:           session.CreatedAt,
+		JoinedAt:            session.ActivatedAt,
+		ExpiresAt:           session.ExpiresAt,
+		SubmittedAt:         session.SubmittedAt,
+		RevokedAt:           session.RevokedAt,
+		SandboxID:           session.SandboxID,
+		SandboxStatus:       session.SandboxStatus,
+		ExtendedSeconds:     session.ExtendedSeconds,
+		ConnectedSeconds:    int(connectedTime(conns, openUntil(session, time.Now())).Seconds()),
+		Connections:         len(conns),
+		Reconnects:          max(len(conns)-1, 0),
+		TerminalConnections: conns,
+		Events:              []*models.SandboxEvent{},
+		Grading:             []*models.GradingResult{},
+		Recordings:          []*models.TerminalRecording{},
+	}
+	if report.TerminalConnections == nil {
+		report.TerminalConnections = []*models.SessionConnection{}
+	}
+
+	if session.SandboxID != "" {
+		sb, err := m.repo.GetSandbox(ctx, session.SandboxID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get sandbox: %w", err)
+		}
+		if sb != nil {
+			report.SandboxStatus = sb.Status
+		}
+
+		events, err := m.repo.ListEvents(ctx, session.SandboxID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list events: %w", err)
+		}
+		if events != nil {
+			report.Events = events
+		}
+
+		results, err := m.repo.ListGradingResults(ctx, session.SandboxID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list grading results: %w", err)
+		}
+		for _, res := range results {
+			if res.SessionID == session.ID {
+				report.Grading = append(report.Grading, res)
+			}
+		}
+
+		recs, err := m.repo.ListTerminalRecordings(ctx, session.SandboxID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list terminal recordings: %w", err)
+		}
+		for _, rec := range recs {
+			if rec.SessionID == session.ID {
+				report.Recordings = append(report.Recordings, rec)
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// openUntil is when connections without a recorded end are taken to have
+// ended: now while the session is active, otherwise when the session ended
+func openUntil(session *models.Session, now time.Time) time.Time {
+	if session.Status == models.SessionActive {
+		return now
+	}
+	for _, end := range []*time.Time{session.SubmittedAt, session.RevokedAt, session.ExpiresAt} {
+		if end != nil && end.Before(now) {
+			return *end
+		}
+	}
+	return now
+}
+
+// connectedTime is how long at least one of conns was open. conns are
+// ordered by connection time; overlapping connections count once.
+func connectedTime(conns []*models.SessionConnection, openUntil time.Time) time.Duration {
+	var total time.Duration
+	var start, end time.Time
+	for _, c := range conns {
+		to := openUntil
+		if c.DisconnectedAt != nil {
+			to = *c.DisconnectedAt
+		}
+		if !to.After(c.ConnectedAt) {
+			continue
+		}
+
+		if c.ConnectedAt.After(end) {
+			total += end.Sub(start)
+			start, end = c.ConnectedAt, to
+		} else if to.After(end) {
+			end = to
+		}
+	}
+	return total + end.Sub(start)
+}
@@ -0,0 +1,162 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/config"
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/storage"
+	"github.com/terra-clan/sandbox-engine/internal/templates"
+)
+
+func TestConnectedTime(t *testing.T) {
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(minutes int) *time.Time {
+		ts := base.Add(time.Duration(minutes) * time.Minute)
+		return &ts
+	}
+	conn := func(from int, to *time.Time) *models.SessionConnection {
+		return &models.SessionConnection{ConnectedAt: *at(from), DisconnectedAt: to}
+	}
+
+	tests := []struct {
+		name  string
+		conns []*models.SessionConnection
+		want  time.Duration
+	}{
+		{"none", nil, 0},
+		{"one", []*models.SessionConnection{conn(0, at(10))}, 10 * time.Minute},
+		{"reconnected", []*models.SessionConnection{conn(0, at(10)), conn(20, at(25))}, 15 * time.Minute},
+		{"two tabs", []*models.SessionConnection{conn(0, at(10)), conn(5, at(15))}, 15 * time.Minute},
+		{"nested", []*models.SessionConnection{conn(0, at(30)), conn(5, at(15))}, 30 * time.Minute},
+		{"still open", []*models.SessionConnection{conn(0, at(10)), conn(50, nil)}, 20 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := connectedTime(tt.conns, *at(60)); got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestSessionReport(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewMemoryRepository()
+	m := &DockerManager{repo: repo, templateLoader: templates.NewLoader()}
+
+	activatedAt := time.Now().Add(-time.Hour)
+	expiresAt := time.Now().Add(time.Hour)
+	sb := &models.Sandbox{ID: "sb-1", Status: models.StatusRunning, ExpiresAt: expiresAt}
+	if err := repo.CreateSandbox(ctx, sb); err != nil {
+		t.Fatal(err)
+	}
+	session := &models.Session{ID: "s-1", Token: "tok", Status: models.SessionActive, SandboxID: "sb-1", ActivatedAt: &activatedAt, ExpiresAt: &expiresAt}
+	if err := repo.CreateSession(ctx, session); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.SessionReport(ctx, "missing"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("expected ErrSessionNotFound, got %v", err)
+	}
+
+	m.TrackSessionConnection(ctx, "s-1")()
+	m.TrackSessionConnection(ctx, "s-1")()
+	if _, err := m.ExtendSession(ctx, "s-1", 10*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range []*models.TerminalRecording{{SandboxID: "sb-1", SessionID: "s-1"}, {SandboxID: "sb-1", SessionID: "other"}} {
+		if err := repo.CreateTerminalRecording(ctx, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.deleteRecords(ctx, sb); err != nil {
+		t.Fatal(err)
+	}
+
+	// The sandbox is gone; the report comes from what was persisted
+	report, err := m.SessionReport(ctx, "s-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.JoinedAt == nil || !report.JoinedAt.Equal(activatedAt) {
+		t.Errorf("expected the activation time as join time, got %v", report.JoinedAt)
+	}
+	if report.Connections != 2 || report.Reconnects != 1 || len(report.TerminalConnections) != 2 || report.TerminalConnections[1].DisconnectedAt == nil {
+		t.Errorf("expected two closed connections, got %+v", report)
+	}
+	if report.ExtendedSeconds != 600 {
+		t.Errorf("expected 600s extended, got %d", report.ExtendedSeconds)
+	}
+	if report.SandboxStatus != models.StatusRunning {
+		t.Errorf("expected the sandbox's last status, got %q", report.SandboxStatus)
+	}
+	if len(report.Events) != 2 || report.Events[0].Type != models.EventTTLExtended || report.Events[1].Type != models.EventDeleted {
+		t.Errorf("expected the extension and deletion events, got %+v", report.Events)
+	}
+	if len(report.Recordings) != 1 || report.Recordings[0].SessionID != "s-1" {
+		t.Errorf("expected the session's recording, got %+v", report.Recordings)
+	}
+}
+
+func TestSessionReportCountsSandboxExtensions(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewMemoryRepository()
+	m := &DockerManager{repo: repo, templateLoader: templates.NewLoader()}
+	m.sandboxConfig.AutoExtend = config.AutoExtendConfig{Enabled: true, Window: time.Hour, Step: 15 * time.Minute}
+
+	expiresAt := time.Now().Add(30 * time.Minute)
+	if err := repo.CreateSandbox(ctx, &models.Sandbox{ID: "sb-1", Status: models.StatusRunning, ExpiresAt: expiresAt}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.CreateSession(ctx, &models.Session{ID: "s-1", Token: "tok", Status: models.SessionActive, SandboxID: "sb-1", ExpiresAt: &expiresAt}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.ExtendTTL(ctx, "sb-1", 5*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	newExpiry, err := m.AutoExtendTTL(ctx, "sb-1")
+	if err != nil || newExpiry == nil {
+		t.Fatalf("expected an auto-extension, got %v %v", newExpiry, err)
+	}
+
+	report, err := m.SessionReport(ctx, "s-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.ExtendedSeconds != 20*60 {
+		t.Errorf("expected the sandbox extension and auto-extension counted, got %ds", report.ExtendedSeconds)
+	}
+	if report.ExpiresAt == nil || !report.ExpiresAt.Equal(*newExpiry) {
+		t.Errorf("expected the session expiry to follow the sandbox, got %v", report.ExpiresAt)
+	}
+}
+
+func TestExpireSessionKeepsReport(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewMemoryRepository()
+	m := &DockerManager{repo: repo, templateLoader: templates.NewLoader()}
+
+	expiresAt := time.Now().Add(-time.Minute)
+	if err := repo.CreateSession(ctx, &models.Session{ID: "s-1", Token: "tok", Status: models.SessionActive, ExpiresAt: &expiresAt}); err != nil {
+		t.Fatal(err)
+	}
+	m.TrackSessionConnection(ctx, "s-1")()
+
+	if expired, err := m.ExpireSession(ctx, "s-1"); err != nil || !expired {
+		t.Fatalf("expected the session expired, got %v %v", expired, err)
+	}
+	if expired, err := m.ExpireSession(ctx, "s-1"); err != nil || expired {
+		t.Errorf("expected a second expiry to find the session no longer active, got %v %v", expired, err)
+	}
+
+	report, err := m.SessionReport(ctx, "s-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Status != models.SessionExpired || report.Connections != 1 {
+		t.Errorf("expected the report of the expired session with its connection, got %+v", report)
+	}
+}
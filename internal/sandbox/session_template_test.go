@@ -0,0 +1,132 @@
+package sandbox
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/templates"
+)
+
+// tokenSessionRepo looks sessions up by token and records the known templates
+// passed to the missing-template listing
+type tokenSessionRepo struct {
+	*sessionRepo
+	known []string
+}
+
+func (r *tokenSessionRepo) GetSessionByToken(ctx context.Context, token string) (*models.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range r.sessions {
+		if s.Token == token {
+			s := s
+			return &s, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *tokenSessionRepo) ListSessions(ctx context.Context, filters models.SessionFilters) ([]*models.Session, error) {
+	r.known = filters.KnownTemplates
+	return nil, nil
+}
+
+func loaderWith(t *testing.T, names ...string) *templates.Loader {
+	t.Helper()
+	loader := templates.NewLoader()
+	for _, name := range names {
+		path := filepath.Join(t.TempDir(), name+".yaml")
+		if err := os.WriteFile(path, []byte("name: "+name+"\nbase_image: alpine\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := loader.LoadFromFile(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return loader
+}
+
+func TestActivateSessionTemplateRemoved(t *testing.T) {
+	repo := &tokenSessionRepo{sessionRepo: newSessionRepo(&models.Session{
+		ID:         "s1",
+		Token:      "tok",
+		TemplateID: "python",
+		Status:     models.SessionReady,
+	})}
+
+	loader := loaderWith(t, "python")
+	m := &DockerManager{templateLoader: loader, repo: repo}
+
+	// Removed by a reload after the session was created
+	loader.Remove("python")
+
+	for attempt := 0; attempt < 2; attempt++ {
+		session, err := m.ActivateSession(context.Background(), "tok")
+		if err != nil {
+			t.Fatalf("attempt %d: unexpected error %v", attempt, err)
+		}
+		if !session.TemplateMissing() || session.StatusMessage != models.StatusMsgTemplateMissing+"python" {
+			t.Fatalf("attempt %d: expected a template-missing failure, got %s %q", attempt, session.Status, session.StatusMessage)
+		}
+	}
+
+	stored := repo.sessions["s1"]
+	if stored.Status != models.SessionFailed || stored.ActivatedAt != nil {
+		t.Errorf("expected the stored session to fail without activating, got %+v", stored)
+	}
+}
+
+func TestProvisionSessionTemplateRemoved(t *testing.T) {
+	session := &models.Session{ID: "s1", TemplateID: "python", Status: models.SessionProvisioning, TTLSeconds: 60}
+	repo := &tokenSessionRepo{sessionRepo: newSessionRepo(session)}
+	m := &DockerManager{templateLoader: loaderWith(t), repo: repo}
+
+	m.provisionSessionSandbox(context.Background(), session)
+
+	if stored := repo.sessions["s1"]; !stored.TemplateMissing() {
+		t.Errorf("expected a template-missing failure, got %s %q", stored.Status, stored.StatusMessage)
+	}
+}
+
+func TestListSessionsInvalidTemplate(t *testing.T) {
+	repo := &tokenSessionRepo{sessionRepo: newSessionRepo()}
+	m := &DockerManager{templateLoader: loaderWith(t, "go", "python"), repo: repo}
+
+	if _, err := m.ListSessions(context.Background(), models.SessionFilters{Status: models.SessionFilterInvalidTemplate, Limit: 50}); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(repo.known)
+	if got := strings.Join(repo.known, ","); got != "go,python" {
+		t.Errorf("expected the loaded templates to be passed as known, got %q", got)
+	}
+
+	// Catalog projects are known by their project ID too
+	dir := t.TempDir()
+	project := filepath.Join(dir, "backend", "shop")
+	if err := os.MkdirAll(project, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "backend", "domain.yaml"), []byte("name: Backend\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(project, "template.yaml"), []byte("name: shop-api\nbase_image: node:20\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	loader := templates.NewLoader()
+	if err := loader.LoadFromDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	m.templateLoader = loader
+	if _, err := m.ListSessions(context.Background(), models.SessionFilters{Status: models.SessionFilterInvalidTemplate, Limit: 50}); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(repo.known)
+	if got := strings.Join(repo.known, ","); got != "backend/shop,shop-api" {
+		t.Errorf("expected the project ID known along with the template name, got %q", got)
+	}
+}
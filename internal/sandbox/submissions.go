@@ -0,0 +1,256 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+// submissionWorkspace is the directory handed in on submission, the WORKDIR
+// of the workspace images
+const submissionWorkspace = "/workspace"
+
+// submissionStore keeps the workspace archives of submitted sessions on the
+// local disk, one tar per session. Archives aren't replicated: only the
+// replica that took a submission can serve it, unless SubmissionsDir is a
+// shared volume.
+type submissionStore struct {
+	dir     string
+	maxSize int64
+}
+
+func (m *DockerManager) submissions() submissionStore {
+	return submissionStore{dir: m.sandboxConfig.SubmissionsDir, maxSize: int64(m.sandboxConfig.SubmissionMaxMB) << 20}
+}
+
+func (s submissionStore) path(sessionID string) string {
+	return filepath.Join(s.dir, sessionID+".tar")
+}
+
+// save stores the archive read from r, replacing any previous one. It is
+// written to a temporary file first, so a failed copy leaves no partial
+// archive. An archive over maxSize fails with ErrSubmissionTooLarge.
+func (s submissionStore) save(sessionID string, r io.Reader) (int64, error) {
+	if err := os.MkdirAll(s.dir, 0o750); err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(s.dir, sessionID+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, io.LimitReader(r, s.maxSize+1))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	if n > s.maxSize {
+		return 0, ErrSubmissionTooLarge
+	}
+	return n, os.Rename(tmp.Name(), s.path(sessionID))
+}
+
+// open returns the archive of a session, or ErrNoSubmission
+func (s submissionStore) open(sessionID string) (*os.File, error) {
+	f, err := os.Open(s.path(sessionID))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNoSubmission
+	}
+	return f, err
+}
+
+// remove deletes the archive of a session, if any
+func (s submissionStore) remove(sessionID string) error {
+	if err := os.Remove(s.path(sessionID)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// purge deletes the archives written before cutoff and returns how many
+func (s submissionStore) purge(cutoff time.Time) (int64, error) {
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".tar" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.dir, entry.Name())); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// SubmitSession hands in an active session: its workspace is archived into
+// the submission store, the session becomes submitted and its sandbox is
+// deleted. A revoked session is returned as is, like ActivateSession does.
+func (m *DockerManager) SubmitSession(ctx context.Context, token string) (*models.Session, error) {
+	session, err := m.repo.GetSessionByToken(ctx, token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	if session == nil {
+		return nil, ErrSessionNotFound
+	}
+	if session.Status == models.SessionRevoked {
+		return session, nil
+	}
+	if session.Status == models.SessionSubmitted {
+		return nil, ErrSessionSubmitted
+	}
+	if session.Status != models.SessionActive || session.SandboxID == "" {
+		return nil, ErrSessionNotActive
+	}
+
+	sb, err := m.repo.GetSandbox(ctx, session.SandboxID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sandbox: %w", err)
+	}
+	if sb == nil || sb.ContainerID == "" {
+		return nil, ErrSandboxNotFound
+	}
+
+	submitted, err := m.captureSubmission(ctx, session.ID, sb)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.Delete(ctx, sb.ID); err != nil && !errors.Is(err, ErrOperationPending) && !errors.Is(err, ErrSandboxNotFound) {
+		slog.Warn("failed to delete submitted session sandbox", "error", err, "sandbox_id", sb.ID)
+	}
+	return submitted, nil
+}
+
+// captureSubmission archives the workspace of sb and marks the session
+// submitted. It holds the sandbox's operation slot, so the sandbox can't be
+// deleted mid-archive and a second submission waits its turn.
+func (m *DockerManager) captureSubmission(ctx context.Context, sessionID string, sb *models.Sandbox) (*models.Session, error) {
+	release, err := m.ops.begin(sb.ID, "submit")
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// Re-read under the slot: a concurrent submission may have won already
+	session, err := m.activeSession(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := m.containers.archive(ctx, sb.ContainerID, submissionWorkspace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to archive workspace: %w", err)
+	}
+	store := m.submissions()
+	size, err := store.save(session.ID, rc)
+	rc.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to store submission: %w", err)
+	}
+
+	now := time.Now()
+	session.Status = models.SessionSubmitted
+	session.SubmittedAt = &now
+	updated, err := m.repo.UpdateSessionFrom(ctx, session, models.SessionActive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update session: %w", err)
+	}
+	if !updated {
+		// Revoked or expired while archiving: the archive is no submission,
+		// unless another replica submitted the session meanwhile
+		_, err := m.activeSession(ctx, sessionID)
+		if err == nil {
+			err = ErrSessionChanged
+		}
+		if !errors.Is(err, ErrSessionSubmitted) {
+			if removeErr := store.remove(sessionID); removeErr != nil {
+				slog.Warn("failed to remove submission", "error", removeErr, "session_id", sessionID)
+			}
+		}
+		return nil, err
+	}
+
+	slog.Info("session submitted", "id", session.ID, "sandbox_id", sb.ID, "bytes", size)
+	m.SendSessionCallback(ctx, session, models.CallbackSubmitted)
+	return session, nil
+}
+
+// activeSession returns a session that must still be active
+func (m *DockerManager) activeSession(ctx context.Context, id string) (*models.Session, error) {
+	session, err := m.repo.GetSessionByID(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	if session == nil {
+		return nil, ErrSessionNotFound
+	}
+	switch session.Status {
+	case models.SessionActive:
+		return session, nil
+	case models.SessionSubmitted:
+		return nil, ErrSessionSubmitted
+	case models.SessionRevoked:
+		return nil, ErrSessionRevoked
+	default:
+		return nil, ErrSessionNotActive
+	}
+}
+
+// PurgeSubmissions removes the workspace archives stored more than retention
+// ago on this replica. Their sessions stay submitted; OpenSubmission then
+// fails with ErrNoSubmission.
+func (m *DockerManager) PurgeSubmissions(ctx context.Context, retention time.Duration) (int64, error) {
+	n, err := m.submissions().purge(time.Now().Add(-retention))
+	if err != nil {
+		return n, fmt.Errorf("failed to purge submissions: %w", err)
+	}
+	return n, nil
+}
+
+// OpenSubmission returns a submitted session with its workspace archive; the
+// caller closes the file. It fails with ErrNoSubmission when nothing was
+// handed in or the archive is gone.
+func (m *DockerManager) OpenSubmission(ctx context.Context, id string) (*models.Session, *os.File, error) {
+	session, err := m.repo.GetSessionByID(ctx, id)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	if session == nil {
+		return nil, nil, ErrSessionNotFound
+	}
+	if session.SubmittedAt == nil {
+		return nil, nil, ErrNoSubmission
+	}
+
+	f, err := m.submissions().open(id)
+	if err != nil {
+		if errors.Is(err, ErrNoSubmission) {
+			return nil, nil, err
+		}
+		return nil, nil, fmt.Errorf("failed to open submission: %w", err)
+	}
+	return session, f, nil
+}
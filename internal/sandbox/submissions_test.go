@@ -0,0 +1,168 @@
+package sandbox
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/client"
+
+	"github.com/terra-clan/sandbox-engine/internal/config"
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/services"
+	"github.com/terra-clan/sandbox-engine/internal/storage"
+	"github.com/terra-clan/sandbox-engine/internal/templates"
+)
+
+func TestSubmitSession(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewMemoryRepository()
+
+	// Deleting the sandbox afterwards talks to Docker; an unreachable daemon is enough
+	cli, err := client.NewClientWithOpts(client.WithHost("unix:///nonexistent/docker.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &DockerManager{
+		docker:          cli,
+		sandboxConfig:   config.SandboxConfig{SubmissionsDir: t.TempDir(), SubmissionMaxMB: 1},
+		serviceRegistry: services.NewRegistry(),
+		templateLoader:  templates.NewLoader(),
+		repo:            repo,
+		ops:             newOperationTracker(),
+		containers:      &fakeRuntime{workspace: map[string]string{"workspace/main.go": "package main"}},
+	}
+
+	if err := repo.CreateSandbox(ctx, &models.Sandbox{ID: "sb-1", ContainerID: "ctr-1", Status: models.StatusRunning, Services: map[string]*models.ServiceInstance{}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.CreateSession(ctx, &models.Session{ID: "s-1", Token: "tok", Status: models.SessionActive, SandboxID: "sb-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.CreateSession(ctx, &models.Session{ID: "s-2", Token: "ready", Status: models.SessionReady}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := m.OpenSubmission(ctx, "s-1"); !errors.Is(err, ErrNoSubmission) {
+		t.Errorf("before submitting: expected ErrNoSubmission, got %v", err)
+	}
+	if _, err := m.SubmitSession(ctx, "ready"); !errors.Is(err, ErrSessionNotActive) {
+		t.Errorf("submit ready: expected ErrSessionNotActive, got %v", err)
+	}
+
+	s, err := m.SubmitSession(ctx, "tok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Status != models.SessionSubmitted || s.SubmittedAt == nil {
+		t.Errorf("expected a submitted session with its time, got %s %v", s.Status, s.SubmittedAt)
+	}
+	if _, err := m.SubmitSession(ctx, "tok"); !errors.Is(err, ErrSessionSubmitted) {
+		t.Errorf("submit twice: expected ErrSessionSubmitted, got %v", err)
+	}
+	if sb, _ := repo.GetSandbox(ctx, "sb-1"); sb != nil {
+		t.Errorf("expected the sandbox to be deleted, got %s", sb.Status)
+	}
+
+	_, f, err := m.OpenSubmission(ctx, "s-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(f)
+	hdr, err := tr.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Name != "workspace/main.go" {
+		t.Errorf("expected the workspace in the archive, got %s", hdr.Name)
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		t.Errorf("expected a single file, got %v", err)
+	}
+	f.Close()
+
+	if err := m.DeleteSession(ctx, "s-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.submissions().open("s-1"); !errors.Is(err, ErrNoSubmission) {
+		t.Errorf("expected the archive to go with the session, got %v", err)
+	}
+}
+
+// revokingRuntime revokes the session while its workspace is archived
+type revokingRuntime struct {
+	*fakeRuntime
+	repo storage.Repository
+}
+
+func (r revokingRuntime) archive(ctx context.Context, id, path string) (io.ReadCloser, error) {
+	if _, _, err := r.repo.RevokeSession(ctx, "s-1", "admin", time.Now()); err != nil {
+		return nil, err
+	}
+	return r.fakeRuntime.archive(ctx, id, path)
+}
+
+func TestSubmitSessionRevokedWhileArchiving(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewMemoryRepository()
+	m := &DockerManager{
+		sandboxConfig: config.SandboxConfig{SubmissionsDir: t.TempDir(), SubmissionMaxMB: 1},
+		repo:          repo,
+		ops:           newOperationTracker(),
+		containers:    revokingRuntime{&fakeRuntime{workspace: map[string]string{"workspace/main.go": "package main"}}, repo},
+	}
+	if err := repo.CreateSandbox(ctx, &models.Sandbox{ID: "sb-1", ContainerID: "ctr-1", Status: models.StatusRunning}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.CreateSession(ctx, &models.Session{ID: "s-1", Token: "tok", Status: models.SessionActive, SandboxID: "sb-1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.captureSubmission(ctx, "s-1", &models.Sandbox{ID: "sb-1", ContainerID: "ctr-1"}); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("expected ErrSessionRevoked, got %v", err)
+	}
+	if s, _ := repo.GetSessionByID(ctx, "s-1"); s.Status != models.SessionRevoked || s.SubmittedAt != nil {
+		t.Errorf("expected the revoke to stand, got %s %v", s.Status, s.SubmittedAt)
+	}
+	if _, err := m.submissions().open("s-1"); !errors.Is(err, ErrNoSubmission) {
+		t.Errorf("expected the archive to be removed, got %v", err)
+	}
+}
+
+func TestSubmissionStoreLimits(t *testing.T) {
+	store := submissionStore{dir: t.TempDir(), maxSize: 10}
+
+	if _, err := store.save("big", strings.NewReader(strings.Repeat("x", 11))); !errors.Is(err, ErrSubmissionTooLarge) {
+		t.Errorf("expected ErrSubmissionTooLarge past the cap, got %v", err)
+	}
+	if _, err := store.open("big"); !errors.Is(err, ErrNoSubmission) {
+		t.Errorf("expected no archive kept past the cap, got %v", err)
+	}
+	if n, err := store.save("old", strings.NewReader("0123456789")); err != nil || n != 10 {
+		t.Fatalf("expected an archive at the cap to be kept, got %d %v", n, err)
+	}
+	if _, err := store.save("new", strings.NewReader("x")); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(store.path("old"), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := store.purge(time.Now().Add(-24 * time.Hour))
+	if err != nil || n != 1 {
+		t.Fatalf("expected one archive purged, got %d %v", n, err)
+	}
+	if _, err := store.open("old"); !errors.Is(err, ErrNoSubmission) {
+		t.Errorf("expected the old archive purged, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(store.dir, "new.tar")); err != nil {
+		t.Errorf("expected the recent archive kept, got %v", err)
+	}
+}
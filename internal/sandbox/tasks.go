@@ -0,0 +1,106 @@
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+// setupOutputMax caps the command output kept in the status message of a
+// sandbox whose task setup failed
+const setupOutputMax = 2048
+
+// createTask resolves the catalog task of a new sandbox, from opts.TaskID or
+// the task_id metadata a session passes on. Only an explicit TaskID must
+// exist: a session's task may have been dropped by a reload since.
+func (m *DockerManager) createTask(opts CreateOptions) (*models.CatalogTask, error) {
+	if opts.TaskID != "" {
+		task := m.templateLoader.GetTask(opts.TaskID)
+		if task == nil {
+			return nil, ErrTaskNotFound
+		}
+		return task, nil
+	}
+	if id := opts.Metadata[models.MetadataTaskID]; id != "" {
+		return m.templateLoader.GetTask(id), nil
+	}
+	return nil, nil
+}
+
+// withTaskMetadata returns metadata with the task's ID, skills and time limit added
+func withTaskMetadata(metadata map[string]string, task *models.CatalogTask) map[string]string {
+	out := make(map[string]string, len(metadata)+3)
+	for k, v := range metadata {
+		out[k] = v
+	}
+	out[models.MetadataTaskID] = task.ID
+	if len(task.Skills) > 0 {
+		out[models.MetadataTaskSkills] = strings.Join(task.Skills, ",")
+	}
+	if task.TimeLimit > 0 {
+		out[models.MetadataTaskTimeLimit] = strconv.Itoa(task.TimeLimit)
+	}
+	return out
+}
+
+// withTaskEnv returns the task's env overridden by the requested env
+func withTaskEnv(requested map[string]string, task *models.CatalogTask) map[string]string {
+	if len(task.Env) == 0 {
+		return requested
+	}
+	env := make(map[string]string, len(task.Env)+len(requested))
+	for k, v := range task.Env {
+		env[k] = v
+	}
+	for k, v := range requested {
+		env[k] = v
+	}
+	return env
+}
+
+// setupTask prepares the workspace of a started sandbox for its task: the
+// starter repo is cloned, then the setup commands run in it. The first
+// command to fail fails provisioning with its output.
+func (m *DockerManager) setupTask(ctx context.Context, sb *models.Sandbox, task *models.CatalogTask) error {
+	timeout := int(ExecMaxTimeout.Seconds())
+	var workDir string
+	if repo := task.StarterRepo; repo != nil {
+		clone := []string{"git", "clone", "--depth", "1"}
+		if repo.Ref != "" {
+			clone = append(clone, "--branch", repo.Ref)
+		}
+		clone = append(clone, "--", repo.URL, repo.Path)
+		if err := m.runSetupStep(ctx, sb, "clone starter repo", models.ExecRequest{Command: clone, TimeoutSeconds: timeout}); err != nil {
+			return err
+		}
+		workDir = repo.Path
+	}
+
+	for i, cmd := range task.SetupCommands {
+		req := models.ExecRequest{Command: []string{"sh", "-c", cmd}, WorkingDir: workDir, TimeoutSeconds: timeout}
+		if err := m.runSetupStep(ctx, sb, fmt.Sprintf("setup command %d (%s)", i+1, cmd), req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runSetupStep runs one task setup command; a non-zero exit is an error
+// carrying the end of its output
+func (m *DockerManager) runSetupStep(ctx context.Context, sb *models.Sandbox, step string, req models.ExecRequest) error {
+	result, err := m.containers.exec(ctx, sb, req)
+	if err != nil {
+		return fmt.Errorf("task setup: %s: %w", step, err)
+	}
+	if result.ExitCode != 0 {
+		output := strings.TrimSpace(result.Stdout + "\n" + result.Stderr)
+		if len(output) > setupOutputMax {
+			output = "..." + output[len(output)-setupOutputMax:]
+		}
+		return fmt.Errorf("task setup: %s exited with %d: %s", step, result.ExitCode, output)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/config"
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/services"
+	"github.com/terra-clan/sandbox-engine/internal/templates"
+)
+
+// taskLoader loads a catalog with the task shop/api/cart, set up by task
+func taskLoader(t *testing.T, task string) *templates.Loader {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{
+		"shop/domain.yaml":         "name: Shop\n",
+		"shop/api/template.yaml":   "name: shop-api\nbase_image: node:20\n",
+		"shop/api/tasks/cart.yaml": "title: Cart\n" + task,
+	}
+	for name, doc := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(doc), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	loader := templates.NewLoader()
+	if err := loader.LoadFromDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	return loader
+}
+
+func TestTaskMetadataAndEnv(t *testing.T) {
+	task := &models.CatalogTask{ID: "shop/api/cart", Skills: []string{"node", "sql"}, TimeLimit: 1800, Env: map[string]string{"MODE": "task", "LEVEL": "1"}}
+
+	requested := map[string]string{"team": "a"}
+	metadata := withTaskMetadata(requested, task)
+	want := map[string]string{"team": "a", models.MetadataTaskID: "shop/api/cart", models.MetadataTaskSkills: "node,sql", models.MetadataTaskTimeLimit: "1800"}
+	if !reflect.DeepEqual(metadata, want) {
+		t.Errorf("expected metadata %v, got %v", want, metadata)
+	}
+	if len(requested) != 1 {
+		t.Errorf("expected the requested metadata untouched, got %v", requested)
+	}
+
+	env := withTaskEnv(map[string]string{"MODE": "request"}, task)
+	if !reflect.DeepEqual(env, map[string]string{"MODE": "request", "LEVEL": "1"}) {
+		t.Errorf("expected the request to override the task env, got %v", env)
+	}
+
+	m := &DockerManager{templateLoader: templates.NewLoader()}
+	if _, err := m.createTask(CreateOptions{TaskID: "missing"}); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("expected ErrTaskNotFound, got %v", err)
+	}
+	if task, err := m.createTask(CreateOptions{Metadata: map[string]string{models.MetadataTaskID: "missing"}}); task != nil || err != nil {
+		t.Errorf("expected a session's missing task ignored, got %v %v", task, err)
+	}
+}
+
+func TestProvisionTaskSetup(t *testing.T) {
+	loader := taskLoader(t, `starter_repo:
+  url: https://git.example.com/cart.git
+  ref: main
+setup_commands:
+  - npm ci
+  - npm test
+`)
+
+	tests := []struct {
+		name     string
+		failCmd  string
+		wantExec int
+	}{
+		{name: "ok", wantExec: 3},
+		{name: "failing command", failCmd: "npm ci", wantExec: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runtime := &fakeRuntime{containers: map[string]string{}}
+			runtime.execFunc = func(req models.ExecRequest) *models.ExecResult {
+				if len(req.Command) == 3 && req.Command[2] == tt.failCmd {
+					return &models.ExecResult{ExitCode: 1, Stderr: "npm ERR! missing package.json"}
+				}
+				return &models.ExecResult{}
+			}
+			repo := &provisionRepo{services: map[string]int{}}
+			m := &DockerManager{
+				traefikConfig:   config.TraefikConfig{Enabled: true},
+				sandboxConfig:   config.SandboxConfig{ProvisionTimeout: time.Minute},
+				serviceRegistry: services.NewRegistry(),
+				templateLoader:  loader,
+				repo:            repo,
+				containers:      runtime,
+			}
+
+			sb := &models.Sandbox{
+				ID:       "sb-1",
+				Status:   models.StatusPending,
+				Services: make(map[string]*models.ServiceInstance),
+				Metadata: map[string]string{models.MetadataTaskID: "shop/api/cart"},
+			}
+			m.provisionSandbox(context.Background(), sb, loader.Get("shop-api"), nil, nil)
+
+			if len(runtime.execs) != tt.wantExec {
+				t.Fatalf("expected %d commands, got %+v", tt.wantExec, runtime.execs)
+			}
+			clone := runtime.execs[0]
+			if want := []string{"git", "clone", "--depth", "1", "--branch", "main", "--", "https://git.example.com/cart.git", "/workspace"}; !reflect.DeepEqual(clone.Command, want) {
+				t.Errorf("unexpected clone %v", clone.Command)
+			}
+			if setup := runtime.execs[1]; setup.WorkingDir != "/workspace" || !reflect.DeepEqual(setup.Command, []string{"sh", "-c", "npm ci"}) {
+				t.Errorf("expected the setup command run in the repo, got %+v", setup)
+			}
+
+			saved := repo.saved
+			if tt.failCmd == "" {
+				if saved == nil || saved.Status != models.StatusRunning {
+					t.Fatalf("expected the sandbox running, got %+v", saved)
+				}
+				return
+			}
+			if saved == nil || saved.Status != models.StatusFailed || !strings.Contains(saved.StatusMsg, "missing package.json") {
+				t.Fatalf("expected the sandbox failed with the command output, got %+v", saved)
+			}
+			if len(runtime.containers) != 0 {
+				t.Errorf("expected the container removed, got %v", runtime.containers)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/storage"
+	"github.com/terra-clan/sandbox-engine/internal/templates"
+)
+
+// templateNamePattern restricts the names of templates created through the API,
+// which end up in URLs and container labels
+var templateNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// activeTemplateStatuses are the statuses of sandboxes that keep a template in use
+var activeTemplateStatuses = []models.SandboxStatus{models.StatusPending, models.StatusRunning, models.StatusStopped}
+
+// CreateTemplate validates a template YAML document and stores it. The name
+// must not be taken, by the templates directory or the API; the insert itself
+// refuses a taken name, so of two concurrent creates only one wins.
+func (m *DockerManager) CreateTemplate(ctx context.Context, data []byte) (*models.Template, []templates.Issue, error) {
+	tmpl, issues, err := m.checkTemplate(data)
+	if err != nil {
+		return nil, issues, err
+	}
+	if m.templateLoader.Get(tmpl.Name) != nil || m.templateLoader.IsStored(tmpl.Name) {
+		return nil, issues, ErrTemplateExists
+	}
+
+	created, err := m.repo.CreateTemplateDocument(ctx, &models.TemplateDocument{Name: tmpl.Name, Document: string(data)})
+	if err != nil {
+		return nil, issues, err
+	}
+	if !created {
+		return nil, issues, ErrTemplateExists
+	}
+	m.reloadTemplates()
+	return m.templateLoader.Get(tmpl.Name), issues, nil
+}
+
+// UpdateTemplate replaces a template created through the API; the document
+// must keep the template's name. Templates of the directory are read-only.
+func (m *DockerManager) UpdateTemplate(ctx context.Context, name string, data []byte) (*models.Template, []templates.Issue, error) {
+	if err := m.storedTemplate(ctx, name); err != nil {
+		return nil, nil, err
+	}
+	tmpl, issues, err := m.checkTemplate(data)
+	if err != nil {
+		return nil, issues, err
+	}
+	if tmpl.Name != name {
+		return nil, issues, fmt.Errorf("%w: name %q does not match %q; templates cannot be renamed", ErrTemplateInvalid, tmpl.Name, name)
+	}
+
+	if err := m.saveTemplate(ctx, name, data); err != nil {
+		return nil, issues, err
+	}
+	return m.templateLoader.Get(name), issues, nil
+}
+
+// DeleteTemplate deletes a template created through the API, unless pending,
+// running or stopped sandboxes still use it. The document is locked for the
+// count and the delete, and storeNewSandbox holds it shared, so a sandbox
+// can't be created from it in between.
+func (m *DockerManager) DeleteTemplate(ctx context.Context, name string) error {
+	if err := m.storedTemplate(ctx, name); err != nil {
+		return err
+	}
+
+	err := m.repo.WithTx(ctx, func(tx storage.Repository) error {
+		found, err := tx.LockTemplateDocument(ctx, name, true)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return ErrTemplateNotFound
+		}
+		for _, status := range activeTemplateStatuses {
+			count, err := tx.CountSandboxes(ctx, models.ListFilters{TemplateID: name, Status: status})
+			if err != nil {
+				return fmt.Errorf("failed to count sandboxes: %w", err)
+			}
+			if count > 0 {
+				return fmt.Errorf("%w: %d %s", ErrTemplateInUse, count, status)
+			}
+		}
+		return tx.DeleteTemplateDocument(ctx, name)
+	})
+	if err != nil {
+		return err
+	}
+	m.reloadTemplates()
+	return nil
+}
+
+// checkTemplate parses and validates a template document like the loader
+// does; any error-level issue rejects it
+func (m *DockerManager) checkTemplate(data []byte) (*models.Template, []templates.Issue, error) {
+	tmpl, issues, err := m.templateLoader.Validate(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrTemplateInvalid, err)
+	}
+	if !templateNamePattern.MatchString(tmpl.Name) {
+		return nil, issues, fmt.Errorf("%w: name %q may only contain letters, digits, '.', '_' and '-'", ErrTemplateInvalid, tmpl.Name)
+	}
+	for _, issue := range issues {
+		if issue.Severity == templates.SeverityError {
+			return nil, issues, fmt.Errorf("%w: %s", ErrTemplateInvalid, issue)
+		}
+	}
+	return tmpl, issues, nil
+}
+
+// storedTemplate checks that name is a template created through the API
+func (m *DockerManager) storedTemplate(ctx context.Context, name string) error {
+	doc, err := m.repo.GetTemplateDocument(ctx, name)
+	if err != nil {
+		return fmt.Errorf("failed to look up template: %w", err)
+	}
+	if doc == nil {
+		if m.templateLoader.Get(name) != nil {
+			return ErrTemplateReadOnly
+		}
+		return ErrTemplateNotFound
+	}
+	if m.templateLoader.Get(name) != nil && !m.templateLoader.IsStored(name) {
+		// A file of the same name shadows the stored document
+		return ErrTemplateReadOnly
+	}
+	return nil
+}
+
+// saveTemplate stores a template document and reloads the templates so it
+// takes effect on this instance at once
+func (m *DockerManager) saveTemplate(ctx context.Context, name string, data []byte) error {
+	if err := m.repo.SaveTemplateDocument(ctx, &models.TemplateDocument{Name: name, Document: string(data)}); err != nil {
+		return err
+	}
+	m.reloadTemplates()
+	return nil
+}
+
+// reloadTemplates reloads the templates after a stored document changed
+func (m *DockerManager) reloadTemplates() {
+	if _, err := m.templateLoader.Reload(); err != nil {
+		slog.Warn("failed to reload templates", "error", err)
+	}
+}
@@ -0,0 +1,65 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/storage"
+	"github.com/terra-clan/sandbox-engine/internal/templates"
+)
+
+func newTemplateManager(t *testing.T) (*DockerManager, *storage.MemoryRepository) {
+	t.Helper()
+	repo := storage.NewMemoryRepository()
+	loader := templates.NewLoader()
+	loader.SetDocumentSource(repo)
+	if err := loader.LoadFromDir(""); err != nil {
+		t.Fatal(err)
+	}
+	return &DockerManager{repo: repo, templateLoader: loader}, repo
+}
+
+func TestCreateTemplateTakenName(t *testing.T) {
+	ctx := context.Background()
+	m, repo := newTemplateManager(t)
+
+	// Stored by another replica, not yet reloaded here
+	if _, err := repo.CreateTemplateDocument(ctx, &models.TemplateDocument{Name: "py", Document: "name: py\nbase_image: python:3.12\n"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := m.CreateTemplate(ctx, []byte("name: py\nbase_image: python:3.13\n")); !errors.Is(err, ErrTemplateExists) {
+		t.Fatalf("expected ErrTemplateExists, got %v", err)
+	}
+	if doc, _ := repo.GetTemplateDocument(ctx, "py"); doc.Document != "name: py\nbase_image: python:3.12\n" {
+		t.Errorf("expected the first document kept, got %q", doc.Document)
+	}
+}
+
+func TestDeleteTemplateLocksOutNewSandboxes(t *testing.T) {
+	ctx := context.Background()
+	m, _ := newTemplateManager(t)
+	if _, _, err := m.CreateTemplate(ctx, []byte("name: py\nbase_image: python:3.12\n")); err != nil {
+		t.Fatal(err)
+	}
+	tmpl := m.templateLoader.Get("py")
+
+	if err := m.storeNewSandbox(ctx, &models.Sandbox{ID: "sb-1", TemplateID: "py", Status: models.StatusRunning}, tmpl, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.DeleteTemplate(ctx, "py"); !errors.Is(err, ErrTemplateInUse) {
+		t.Fatalf("expected ErrTemplateInUse with a running sandbox, got %v", err)
+	}
+
+	if err := m.repo.UpdateSandbox(ctx, &models.Sandbox{ID: "sb-1", TemplateID: "py", Status: models.StatusExpired}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.DeleteTemplate(ctx, "py"); err != nil {
+		t.Fatal(err)
+	}
+	// A create that looked the template up before the delete can't store a sandbox after it
+	if err := m.storeNewSandbox(ctx, &models.Sandbox{ID: "sb-2", TemplateID: "py"}, tmpl, nil); !errors.Is(err, ErrTemplateNotFound) {
+		t.Errorf("expected ErrTemplateNotFound, got %v", err)
+	}
+}
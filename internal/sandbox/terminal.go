@@ -0,0 +1,102 @@
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/docker/docker/errdefs"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+var (
+	// bashShell is the terminal shell of templates that don't choose one
+	bashShell = []string{"/bin/bash", "--login"}
+	// posixShell replaces bashShell on images without bash, e.g. Alpine
+	posixShell = []string{"/bin/sh", "-l"}
+)
+
+// ExecAttachOptions selects what a terminal exec runs. An empty Cmd starts
+// a login shell, bash when the container has it; an empty User is the
+// image's default user.
+type ExecAttachOptions struct {
+	Cmd  []string
+	User string
+}
+
+// ExecExit is how a terminal exec ended
+type ExecExit struct {
+	Code int
+	// ContainerStopped is set when the container is gone or no longer
+	// running, e.g. the sandbox was stopped, expired or deleted
+	ContainerStopped bool
+}
+
+// execExitPolls bounds how often ExecExit waits for Docker to see the exec end
+const execExitPolls = 10
+
+// TerminalOptions returns the exec options of a terminal in sb from its
+// template's terminal spec. A non-empty command replaces the shell, which
+// fails with ErrTerminalCommand unless the template allows it.
+func (m *DockerManager) TerminalOptions(sb *models.Sandbox, command []string) (ExecAttachOptions, error) {
+	var spec models.TerminalSpec
+	if tmpl := m.templateLoader.Get(sb.TemplateID); tmpl != nil && tmpl.Terminal != nil {
+		spec = *tmpl.Terminal
+	}
+	opts := ExecAttachOptions{Cmd: spec.Shell, User: spec.User}
+	if len(command) > 0 {
+		if !spec.AllowCommand {
+			return ExecAttachOptions{}, ErrTerminalCommand
+		}
+		opts.Cmd = command
+	}
+	return opts, nil
+}
+
+// loginShell returns bashShell, or posixShell when the container has no
+// bash. If that can't be told, bash is tried.
+func loginShell(ctx context.Context, rt containerRuntime, containerID string) []string {
+	err := rt.stat(ctx, containerID, bashShell[0])
+	if errdefs.IsNotFound(err) {
+		return posixShell
+	}
+	if err != nil {
+		slog.Warn("failed to look for bash in container", "error", err, "container_id", containerID)
+	}
+	return bashShell
+}
+
+// ExecExit inspects an exec whose output has ended. Docker may report it
+// running for a moment after that, so it is polled briefly.
+func (m *DockerManager) ExecExit(ctx context.Context, containerID, execID string) (*ExecExit, error) {
+	exit := &ExecExit{}
+	for i := 0; ; i++ {
+		info, err := m.docker.ContainerExecInspect(ctx, execID)
+		if err != nil && !errdefs.IsNotFound(err) {
+			return nil, fmt.Errorf("failed to inspect exec: %w", err)
+		}
+		if err != nil || !info.Running || i == execExitPolls {
+			// A removed container takes its execs with it
+			exit.Code = info.ExitCode
+			break
+		}
+		select {
+		case <-time.After(50 * time.Millisecond):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	info, err := m.docker.ContainerInspect(ctx, containerID)
+	switch {
+	case errdefs.IsNotFound(err):
+		exit.ContainerStopped = true
+	case err != nil:
+		return nil, fmt.Errorf("failed to inspect container: %w", err)
+	default:
+		exit.ContainerStopped = info.State == nil || !info.State.Running
+	}
+	return exit, nil
+}
@@ -0,0 +1,100 @@
+package sandbox
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/docker/docker/client"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/templates"
+)
+
+func TestLoginShell(t *testing.T) {
+	ctx := context.Background()
+
+	if cmd := loginShell(ctx, &fakeRuntime{}, "ctr-1"); !slices.Equal(cmd, bashShell) {
+		t.Errorf("expected bash, got %v", cmd)
+	}
+
+	// A busybox image has /bin/sh but no bash
+	busybox := &fakeRuntime{missing: map[string]bool{"/bin/bash": true}}
+	if cmd := loginShell(ctx, busybox, "ctr-1"); !slices.Equal(cmd, posixShell) {
+		t.Errorf("expected the sh fallback, got %v", cmd)
+	}
+}
+
+func TestLoginShellDocker(t *testing.T) {
+	ctx := context.Background()
+
+	// Docker answers a stat of a missing path with a bodiless 404
+	var hasBash atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead || !strings.HasSuffix(r.URL.Path, "/containers/ctr-1/archive") || r.URL.Query().Get("path") != "/bin/bash" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if !hasBash.Load() {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		stat, _ := json.Marshal(map[string]any{"name": "bash", "size": 1234, "mode": 0o755})
+		w.Header().Set("X-Docker-Container-Path-Stat", base64.StdEncoding.EncodeToString(stat))
+	}))
+	defer srv.Close()
+
+	cli, err := client.NewClientWithOpts(client.WithHost("tcp://" + srv.Listener.Addr().String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := dockerRuntime{m: &DockerManager{docker: cli}}
+
+	// A busybox image has /bin/sh but no bash
+	if cmd := loginShell(ctx, rt, "ctr-1"); !slices.Equal(cmd, posixShell) {
+		t.Errorf("without bash: expected the sh fallback, got %v", cmd)
+	}
+	hasBash.Store(true)
+	if cmd := loginShell(ctx, rt, "ctr-1"); !slices.Equal(cmd, bashShell) {
+		t.Errorf("with bash: expected bash, got %v", cmd)
+	}
+}
+
+func TestTerminalOptions(t *testing.T) {
+	loader := templates.NewLoader()
+	loader.Add(&models.Template{Name: "plain"})
+	loader.Add(&models.Template{Name: "zsh", Terminal: &models.TerminalSpec{Shell: []string{"/bin/zsh", "-l"}, User: "coder"}})
+	loader.Add(&models.Template{Name: "repl", Terminal: &models.TerminalSpec{User: "coder", AllowCommand: true}})
+	m := &DockerManager{templateLoader: loader}
+
+	tests := []struct {
+		template string
+		command  []string
+		want     ExecAttachOptions
+		err      error
+	}{
+		{template: "plain"},
+		{template: "zsh", want: ExecAttachOptions{Cmd: []string{"/bin/zsh", "-l"}, User: "coder"}},
+		{template: "zsh", command: []string{"python3"}, err: ErrTerminalCommand},
+		{template: "repl", command: []string{"python3"}, want: ExecAttachOptions{Cmd: []string{"python3"}, User: "coder"}},
+		{template: "gone"},
+	}
+	for _, tt := range tests {
+		opts, err := m.TerminalOptions(&models.Sandbox{TemplateID: tt.template}, tt.command)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s %v: expected error %v, got %v", tt.template, tt.command, tt.err, err)
+			continue
+		}
+		if !slices.Equal(opts.Cmd, tt.want.Cmd) || opts.User != tt.want.User {
+			t.Errorf("%s %v: expected %+v, got %+v", tt.template, tt.command, tt.want, opts)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package sandbox
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+// metaTTLCap records the lifetime cap applied to a sandbox, so an extension
+// cut short can be explained
+const metaTTLCap = "ttl_cap"
+
+// TTLCapError is a TTL or extension taking a sandbox or session past the
+// longest lifetime its template and SANDBOX_MAX_TTL allow
+type TTLCapError struct {
+	Requested time.Duration
+	Max       time.Duration
+}
+
+func (e *TTLCapError) Error() string {
+	return fmt.Sprintf("ttl of %s exceeds the maximum of %s", e.Requested, e.Max)
+}
+
+// ttlCap is the longest lifetime a sandbox of tmpl may have: the stricter of
+// the template's max_ttl and SANDBOX_MAX_TTL, or 0 for no cap
+func (m *DockerManager) ttlCap(tmpl *models.Template) time.Duration {
+	limit := m.sandboxConfig.MaxTTL
+	if tmpl != nil && tmpl.MaxTTL > 0 && (limit <= 0 || tmpl.MaxTTL < limit) {
+		limit = tmpl.MaxTTL
+	}
+	return max(limit, 0)
+}
+
+// recordTTLCap stores limit in the sandbox metadata, when there is one
+func recordTTLCap(sb *models.Sandbox, limit time.Duration) {
+	if limit <= 0 {
+		return
+	}
+	if sb.Metadata == nil {
+		sb.Metadata = make(map[string]string)
+	}
+	sb.Metadata[metaTTLCap] = limit.String()
+}
+
+// sessionTTL is the TTL in seconds of a new session: the requested one, else
+// the task's time limit, the template's TTL or an hour. A requested TTL over
+// the cap is rejected; a default one is cut to it.
+func (m *DockerManager) sessionTTL(req models.CreateSessionRequest, task *models.CatalogTask, tmpl *models.Template) (int, error) {
+	ttl := req.TTL
+	if ttl == 0 && task != nil {
+		ttl = task.TimeLimit
+	}
+	if ttl == 0 {
+		ttl = int(tmpl.TTL.Seconds())
+	}
+	if ttl == 0 {
+		ttl = 3600 // 1 hour default
+	}
+
+	limit := m.ttlCap(tmpl)
+	if limit <= 0 || time.Duration(ttl)*time.Second <= limit {
+		return ttl, nil
+	}
+	if req.TTL != 0 {
+		return 0, &TTLCapError{Requested: time.Duration(ttl) * time.Second, Max: limit}
+	}
+	return int(limit.Seconds()), nil
+}
@@ -0,0 +1,133 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/storage"
+	"github.com/terra-clan/sandbox-engine/internal/templates"
+)
+
+// txRepo keeps sandbox, service and event records and restores them when a
+// WithTx callback fails, like a database transaction
+type txRepo struct {
+	storage.Repository
+	sandboxes  map[string]bool
+	services   map[string]string // sandboxID/name -> status
+	events     []models.SandboxEventType
+	lastStatus map[string]models.SandboxStatus // sandbox ID -> status kept on its session
+	failOn     string                          // service name whose CreateService fails
+	failEvents bool
+}
+
+func newTxRepo() *txRepo {
+	return &txRepo{sandboxes: make(map[string]bool), services: make(map[string]string), lastStatus: make(map[string]models.SandboxStatus)}
+}
+
+func (r *txRepo) WithTx(ctx context.Context, fn func(storage.Repository) error) error {
+	sandboxes, services, lastStatus, events := maps.Clone(r.sandboxes), maps.Clone(r.services), maps.Clone(r.lastStatus), len(r.events)
+	if err := fn(r); err != nil {
+		r.sandboxes, r.services, r.lastStatus, r.events = sandboxes, services, lastStatus, r.events[:events]
+		return err
+	}
+	return nil
+}
+
+func (r *txRepo) CreateSandbox(ctx context.Context, sb *models.Sandbox) error {
+	r.sandboxes[sb.ID] = true
+	return nil
+}
+
+func (r *txRepo) DeleteSandbox(ctx context.Context, id string, hard bool) error {
+	delete(r.sandboxes, id)
+	return nil
+}
+
+func (r *txRepo) SetSessionSandboxStatus(ctx context.Context, sandboxID string, status models.SandboxStatus) error {
+	r.lastStatus[sandboxID] = status
+	return nil
+}
+
+func (r *txRepo) CreateService(ctx context.Context, sandboxID string, svc *models.ServiceInstance) error {
+	if svc.Name == r.failOn {
+		return errors.New("connection reset")
+	}
+	r.services[sandboxID+"/"+svc.Name] = svc.Status
+	return nil
+}
+
+func (r *txRepo) DeleteServices(ctx context.Context, sandboxID string) error {
+	for key := range r.services {
+		if strings.HasPrefix(key, sandboxID+"/") {
+			delete(r.services, key)
+		}
+	}
+	return nil
+}
+
+func (r *txRepo) AppendEvent(ctx context.Context, ev *models.SandboxEvent) error {
+	if r.failEvents {
+		return errors.New("disk full")
+	}
+	r.events = append(r.events, ev.Type)
+	return nil
+}
+
+func TestStoreNewSandboxPlansServices(t *testing.T) {
+	repo := newTxRepo()
+	loader := templates.NewLoader()
+	loader.Add(&models.Template{Name: "py"})
+	m := &DockerManager{repo: repo, templateLoader: loader}
+	sb := &models.Sandbox{ID: "sb-1", TemplateID: "py", CreatedAt: time.Now()}
+
+	if err := m.storeNewSandbox(context.Background(), sb, &models.Template{}, []string{"postgres", "redis"}); err != nil {
+		t.Fatal(err)
+	}
+	if !repo.sandboxes["sb-1"] || repo.services["sb-1/postgres"] != models.ServiceStatusProvisioning || repo.services["sb-1/redis"] != models.ServiceStatusProvisioning {
+		t.Errorf("expected the sandbox with planned services, got %v %v", repo.sandboxes, repo.services)
+	}
+
+	// A failing service record leaves neither the sandbox nor the other services behind
+	repo = newTxRepo()
+	repo.failOn = "redis"
+	m.repo = repo
+	if err := m.storeNewSandbox(context.Background(), sb, &models.Template{}, []string{"postgres", "redis"}); err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(repo.sandboxes) != 0 || len(repo.services) != 0 {
+		t.Errorf("expected the transaction rolled back, got %v %v", repo.sandboxes, repo.services)
+	}
+}
+
+func TestDeleteRecordsWithEvent(t *testing.T) {
+	repo := newTxRepo()
+	repo.sandboxes["sb-1"] = true
+	repo.services["sb-1/postgres"] = models.ServiceStatusReady
+	m := &DockerManager{repo: repo}
+	sb := &models.Sandbox{ID: "sb-1", Status: models.StatusRunning}
+
+	// Without the deleted event the records stay
+	repo.failEvents = true
+	if err := m.deleteRecords(context.Background(), sb); err == nil {
+		t.Fatal("expected an error")
+	}
+	if !repo.sandboxes["sb-1"] || len(repo.services) != 1 || len(repo.lastStatus) != 0 {
+		t.Errorf("expected records kept after a failed delete, got %v %v %v", repo.sandboxes, repo.services, repo.lastStatus)
+	}
+
+	repo.failEvents = false
+	if err := m.deleteRecords(context.Background(), sb); err != nil {
+		t.Fatal(err)
+	}
+	if len(repo.sandboxes) != 0 || len(repo.services) != 0 || len(repo.events) != 1 || repo.events[0] != models.EventDeleted {
+		t.Errorf("expected records gone with a deleted event, got %v %v %v", repo.sandboxes, repo.services, repo.events)
+	}
+	if repo.lastStatus["sb-1"] != models.StatusRunning {
+		t.Errorf("expected the last status kept for the session, got %v", repo.lastStatus)
+	}
+}
@@ -0,0 +1,205 @@
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+const (
+	// usageSummaryTTL is how long the usage summaries shown on templates and tasks are cached
+	usageSummaryTTL = 5 * time.Minute
+	// usageSummaryWindow is the window of the usage_30d summary field
+	usageSummaryWindow = 30 * 24 * time.Hour
+)
+
+// usageSummaries are the cached per-template and per-task usage summaries
+type usageSummaries struct {
+	mu        sync.Mutex
+	templates map[string]*models.UsageSummary
+	tasks     map[string]*models.UsageSummary
+	expires   time.Time
+}
+
+// usageRecord builds the usage contribution of sb at its terminal transition
+func usageRecord(sb *models.Sandbox, templateID string, now time.Time) *models.UsageRecord {
+	rec := &models.UsageRecord{
+		SandboxID:  sb.ID,
+		TemplateID: templateID,
+		TaskID:     sb.Metadata[models.MetadataTaskID],
+		UsedAt:     sb.CreatedAt,
+		Failed:     sb.Status == models.StatusFailed,
+	}
+	if rec.UsedAt.IsZero() {
+		rec.UsedAt = now
+	}
+
+	if !rec.Failed && sb.StartedAt != nil {
+		rec.Succeeded = true
+		rec.SessionSeconds = int64(now.Sub(*sb.StartedAt).Seconds())
+		if rec.SessionSeconds < 0 {
+			rec.SessionSeconds = 0
+		}
+	}
+
+	return rec
+}
+
+// recordUsage counts sb in the template usage stats. It runs at terminal
+// transitions; the repository ignores sandboxes that were already counted.
+func (m *DockerManager) recordUsage(ctx context.Context, sb *models.Sandbox) {
+	// Catalog templates are also reachable by project ID; count them under their name
+	templateID := sb.TemplateID
+	if tmpl := m.templateLoader.Get(sb.TemplateID); tmpl != nil {
+		templateID = tmpl.Name
+	}
+
+	if _, err := m.repo.RecordTemplateUsage(ctx, usageRecord(sb, templateID, time.Now())); err != nil {
+		slog.Warn("failed to record template usage", "error", err, "sandbox", sb.ID, "template", templateID)
+	}
+}
+
+// TemplateUsage reports template and task usage for the days from..to (inclusive, UTC)
+func (m *DockerManager) TemplateUsage(ctx context.Context, from, to time.Time) (*models.UsageReport, error) {
+	rows, err := m.repo.GetTemplateUsage(ctx, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get template usage: %w", err)
+	}
+	return buildUsageReport(rows, from, to), nil
+}
+
+// buildUsageReport rolls per-task rows up into per-template rows, with the
+// task breakdown attached, and computes the totals
+func buildUsageReport(rows []*models.TemplateUsage, from, to time.Time) *models.UsageReport {
+	report := &models.UsageReport{From: from, To: to, Templates: []*models.TemplateUsage{}}
+	byTemplate := make(map[string]*models.TemplateUsage)
+
+	for _, row := range rows {
+		tmpl, ok := byTemplate[row.TemplateID]
+		if !ok {
+			tmpl = &models.TemplateUsage{TemplateID: row.TemplateID}
+			byTemplate[row.TemplateID] = tmpl
+			report.Templates = append(report.Templates, tmpl)
+		}
+		addUsage(tmpl, row)
+		addUsage(&report.Totals, row)
+
+		if row.TaskID != "" {
+			task := *row
+			task.AvgSessionSeconds = avgSession(&task)
+			tmpl.Tasks = append(tmpl.Tasks, &task)
+		}
+	}
+
+	sort.Slice(report.Templates, func(i, j int) bool {
+		return report.Templates[i].TemplateID < report.Templates[j].TemplateID
+	})
+	for _, tmpl := range report.Templates {
+		tmpl.AvgSessionSeconds = avgSession(tmpl)
+	}
+	report.Totals.AvgSessionSeconds = avgSession(&report.Totals)
+
+	return report
+}
+
+// addUsage adds the counters of row to dst
+func addUsage(dst, row *models.TemplateUsage) {
+	dst.Creations += row.Creations
+	dst.Successes += row.Successes
+	dst.Failures += row.Failures
+	dst.SessionSeconds += row.SessionSeconds
+	if row.LastUsedAt != nil && (dst.LastUsedAt == nil || row.LastUsedAt.After(*dst.LastUsedAt)) {
+		lastUsed := *row.LastUsedAt
+		dst.LastUsedAt = &lastUsed
+	}
+}
+
+// avgSession is the average duration of the successful runs counted in u
+func avgSession(u *models.TemplateUsage) float64 {
+	if u.Successes == 0 {
+		return 0
+	}
+	return float64(u.SessionSeconds) / float64(u.Successes)
+}
+
+// TemplateUsageSummary returns the usage summary of a template; nil if it was never used
+func (m *DockerManager) TemplateUsageSummary(ctx context.Context, templateID string) *models.UsageSummary {
+	templates, _ := m.loadUsageSummaries(ctx)
+	return templates[templateID]
+}
+
+// TaskUsageSummary returns the usage summary of a catalog task; nil if it was never used
+func (m *DockerManager) TaskUsageSummary(ctx context.Context, taskID string) *models.UsageSummary {
+	_, tasks := m.loadUsageSummaries(ctx)
+	return tasks[taskID]
+}
+
+// loadUsageSummaries returns the cached summaries, refreshing them once they expire.
+// A failed refresh keeps serving the previous summaries.
+func (m *DockerManager) loadUsageSummaries(ctx context.Context) (map[string]*models.UsageSummary, map[string]*models.UsageSummary) {
+	c := &m.usage
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(c.expires) {
+		return c.templates, c.tasks
+	}
+
+	allTime, err := m.repo.GetTemplateUsage(ctx, time.Time{}, now)
+	if err != nil {
+		slog.Warn("failed to load template usage summaries", "error", err)
+		return c.templates, c.tasks
+	}
+	recent, err := m.repo.GetTemplateUsage(ctx, now.Add(-usageSummaryWindow), now)
+	if err != nil {
+		slog.Warn("failed to load template usage summaries", "error", err)
+		return c.templates, c.tasks
+	}
+
+	c.templates, c.tasks = summarizeUsage(allTime, recent)
+	c.expires = now.Add(usageSummaryTTL)
+	return c.templates, c.tasks
+}
+
+// summarizeUsage builds per-template and per-task summaries from all-time rows
+// (for last_used_at) and rows of the summary window (for usage_30d)
+func summarizeUsage(allTime, recent []*models.TemplateUsage) (templates, tasks map[string]*models.UsageSummary) {
+	templates = make(map[string]*models.UsageSummary)
+	tasks = make(map[string]*models.UsageSummary)
+
+	summary := func(set map[string]*models.UsageSummary, key string) *models.UsageSummary {
+		s, ok := set[key]
+		if !ok {
+			s = &models.UsageSummary{}
+			set[key] = s
+		}
+		return s
+	}
+	lastUsed := func(s *models.UsageSummary, at *time.Time) {
+		if at != nil && (s.LastUsedAt == nil || at.After(*s.LastUsedAt)) {
+			t := *at
+			s.LastUsedAt = &t
+		}
+	}
+
+	for _, row := range allTime {
+		lastUsed(summary(templates, row.TemplateID), row.LastUsedAt)
+		if row.TaskID != "" {
+			lastUsed(summary(tasks, row.TaskID), row.LastUsedAt)
+		}
+	}
+	for _, row := range recent {
+		summary(templates, row.TemplateID).Usage30d += row.Creations
+		if row.TaskID != "" {
+			summary(tasks, row.TaskID).Usage30d += row.Creations
+		}
+	}
+
+	return templates, tasks
+}
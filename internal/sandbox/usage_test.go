@@ -0,0 +1,133 @@
+package sandbox
+
+import (
+	"testing"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+func TestUsageRecord(t *testing.T) {
+	created := time.Date(2026, 3, 1, 23, 30, 0, 0, time.UTC)
+	started := created.Add(time.Minute)
+	now := started.Add(90 * time.Second)
+
+	tests := []struct {
+		name          string
+		sb            models.Sandbox
+		wantSucceeded bool
+		wantFailed    bool
+		wantSeconds   int64
+	}{
+		{
+			name:          "ran",
+			sb:            models.Sandbox{Status: models.StatusRunning, StartedAt: &started},
+			wantSucceeded: true,
+			wantSeconds:   90,
+		},
+		{
+			name:       "failed",
+			sb:         models.Sandbox{Status: models.StatusFailed},
+			wantFailed: true,
+		},
+		{
+			name:       "failed after start",
+			sb:         models.Sandbox{Status: models.StatusFailed, StartedAt: &started},
+			wantFailed: true,
+		},
+		{
+			name: "deleted while pending",
+			sb:   models.Sandbox{Status: models.StatusPending},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sb := tt.sb
+			sb.ID = "sb-1"
+			sb.CreatedAt = created
+			sb.Metadata = map[string]string{models.MetadataTaskID: "fintech/trading/limit-orders"}
+
+			rec := usageRecord(&sb, "python-trading", now)
+			if rec.Succeeded != tt.wantSucceeded || rec.Failed != tt.wantFailed || rec.SessionSeconds != tt.wantSeconds {
+				t.Errorf("unexpected record %+v", rec)
+			}
+			if rec.TemplateID != "python-trading" || rec.TaskID != "fintech/trading/limit-orders" || !rec.UsedAt.Equal(created) {
+				t.Errorf("unexpected identity %+v", rec)
+			}
+		})
+	}
+}
+
+func TestBuildUsageReport(t *testing.T) {
+	day1 := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	day2 := day1.Add(24 * time.Hour)
+
+	rows := []*models.TemplateUsage{
+		{TemplateID: "python", Creations: 2, Successes: 1, Failures: 1, SessionSeconds: 60, LastUsedAt: &day1},
+		{TemplateID: "python", TaskID: "a/b/c", Creations: 3, Successes: 3, SessionSeconds: 300, LastUsedAt: &day2},
+		{TemplateID: "go", Creations: 1, Failures: 1, LastUsedAt: &day1},
+	}
+
+	report := buildUsageReport(rows, day1, day2)
+
+	if len(report.Templates) != 2 || report.Templates[0].TemplateID != "go" || report.Templates[1].TemplateID != "python" {
+		t.Fatalf("expected go and python sorted, got %+v", report.Templates)
+	}
+
+	python := report.Templates[1]
+	if python.Creations != 5 || python.Successes != 4 || python.Failures != 1 || python.AvgSessionSeconds != 90 {
+		t.Errorf("python: unexpected %+v", python)
+	}
+	if python.LastUsedAt == nil || !python.LastUsedAt.Equal(day2) {
+		t.Errorf("python: expected last use on day 2, got %v", python.LastUsedAt)
+	}
+	if len(python.Tasks) != 1 || python.Tasks[0].TaskID != "a/b/c" || python.Tasks[0].AvgSessionSeconds != 100 {
+		t.Errorf("python: unexpected task breakdown %+v", python.Tasks)
+	}
+
+	if len(report.Templates[0].Tasks) != 0 {
+		t.Errorf("go: expected no task breakdown, got %+v", report.Templates[0].Tasks)
+	}
+
+	totals := report.Totals
+	if totals.Creations != 6 || totals.Successes != 4 || totals.Failures != 2 || totals.AvgSessionSeconds != 90 {
+		t.Errorf("totals: unexpected %+v", totals)
+	}
+}
+
+func TestBuildUsageReportEmpty(t *testing.T) {
+	report := buildUsageReport(nil, time.Now(), time.Now())
+	if report.Templates == nil || len(report.Templates) != 0 || report.Totals.Creations != 0 {
+		t.Fatalf("expected an empty report, got %+v", report)
+	}
+}
+
+func TestSummarizeUsage(t *testing.T) {
+	old := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	recent := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	allTime := []*models.TemplateUsage{
+		{TemplateID: "python", Creations: 10, LastUsedAt: &old},
+		{TemplateID: "python", TaskID: "a/b/c", Creations: 4, LastUsedAt: &recent},
+		{TemplateID: "retired", Creations: 7, LastUsedAt: &old},
+	}
+	window := []*models.TemplateUsage{
+		{TemplateID: "python", TaskID: "a/b/c", Creations: 2, LastUsedAt: &recent},
+	}
+
+	templates, tasks := summarizeUsage(allTime, window)
+
+	if s := templates["python"]; s == nil || s.Usage30d != 2 || !s.LastUsedAt.Equal(recent) {
+		t.Errorf("python: unexpected %+v", s)
+	}
+	if s := templates["retired"]; s == nil || s.Usage30d != 0 || !s.LastUsedAt.Equal(old) {
+		t.Errorf("retired: unexpected %+v", s)
+	}
+	if s := tasks["a/b/c"]; s == nil || s.Usage30d != 2 || !s.LastUsedAt.Equal(recent) {
+		t.Errorf("task: unexpected %+v", s)
+	}
+	if len(tasks) != 1 {
+		t.Errorf("expected only the catalog task, got %v", tasks)
+	}
+}
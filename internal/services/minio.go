@@ -0,0 +1,359 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/tags"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+// MinioOptions configures the object storage provider
+type MinioOptions struct {
+	// Endpoint is the host:port of the S3 API
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+	Region    string
+}
+
+// minioSandboxTag is the bucket tag naming the sandbox a bucket was
+// provisioned for. Only tagged buckets are the engine's.
+const minioSandboxTag = "sandbox-engine-sandbox-id"
+
+// MinioProvider implements Provider for MinIO object storage. Each sandbox
+// gets a bucket and a service account of the admin user, limited by an
+// inline policy to that bucket and deleted on Deprovision.
+type MinioProvider struct {
+	BaseProvider
+	client *minio.Client
+	admin  *minioAdmin
+	opts   MinioOptions
+	host   string
+	port   int
+}
+
+// NewMinioProvider creates a new object storage provider
+func NewMinioProvider(opts MinioOptions) (*MinioProvider, error) {
+	client, err := minio.New(opts.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(opts.AccessKey, opts.SecretKey, ""),
+		Secure: opts.UseSSL,
+		Region: opts.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create minio client: %w", err)
+	}
+
+	// Parse address
+	host, portStr, err := net.SplitHostPort(opts.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid minio endpoint %q: %w", opts.Endpoint, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid minio endpoint %q: %w", opts.Endpoint, err)
+	}
+
+	p := &MinioProvider{
+		BaseProvider: BaseProvider{serviceType: "minio"},
+		client:       client,
+		opts:         opts,
+		host:         host,
+		port:         port,
+	}
+	p.admin = &minioAdmin{
+		endpoint:  p.endpointURL(),
+		accessKey: opts.AccessKey,
+		secretKey: opts.SecretKey,
+		region:    opts.Region,
+		client:    &http.Client{Timeout: minioAdminTimeout},
+	}
+	if err := p.HealthCheck(context.Background()); err != nil {
+		return nil, fmt.Errorf("failed to connect to minio: %w", err)
+	}
+	return p, nil
+}
+
+// Provision creates the sandbox's bucket and a service account restricted to it
+func (p *MinioProvider) Provision(ctx context.Context, sandboxID, serviceName string, opts map[string]string) (*models.ServiceCredentials, error) {
+	bucket := sandboxBucket(sandboxID)
+
+	slog.Info("provisioning minio bucket",
+		"sandbox_id", sandboxID,
+		"bucket", bucket,
+	)
+
+	exists, err := p.client.BucketExists(ctx, bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check bucket: %w", err)
+	}
+	if !exists {
+		if err := p.client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{Region: p.opts.Region}); err != nil {
+			return nil, fmt.Errorf("failed to create bucket: %w", err)
+		}
+	}
+
+	bucketTags, err := tags.NewTags(map[string]string{minioSandboxTag: sandboxID}, false)
+	if err == nil {
+		err = p.client.SetBucketTagging(ctx, bucket, bucketTags)
+	}
+	if err != nil {
+		if !exists {
+			if err := p.client.RemoveBucket(context.WithoutCancel(ctx), bucket); err != nil {
+				slog.Warn("failed to remove bucket after tagging failure", "bucket", bucket, "error", err)
+			}
+		}
+		return nil, fmt.Errorf("failed to tag bucket: %w", err)
+	}
+
+	accessKey := sandboxAccessKey(sandboxID)
+	secretKey, err := p.bucketKey(ctx, sandboxID, bucket, accessKey)
+	if err != nil {
+		// Rollback: remove the bucket, still empty
+		if err := p.client.RemoveBucket(context.WithoutCancel(ctx), bucket); err != nil {
+			slog.Warn("failed to remove bucket after key failure", "bucket", bucket, "error", err)
+		}
+		return nil, fmt.Errorf("failed to create bucket key: %w", err)
+	}
+
+	return &models.ServiceCredentials{
+		Host:      p.host,
+		Port:      p.port,
+		Username:  accessKey,
+		Password:  secretKey,
+		Database:  bucket,
+		Namespace: bucket,
+		URI:       p.endpointURL(),
+	}, nil
+}
+
+// bucketKey creates the service account accessKey with a new secret key and
+// an inline policy that allows only bucket and its objects, returning the
+// secret key. An account left by an earlier attempt is replaced.
+func (p *MinioProvider) bucketKey(ctx context.Context, sandboxID, bucket, accessKey string) (string, error) {
+	policy, err := bucketPolicy(bucket)
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate secret key: %w", err)
+	}
+	secretKey := hex.EncodeToString(b)
+
+	if err := p.admin.deleteServiceAccount(ctx, accessKey); err != nil {
+		return "", err
+	}
+	if err := p.admin.addServiceAccount(ctx, accessKey, secretKey, policy, "sandbox "+sandboxID); err != nil {
+		return "", err
+	}
+	return secretKey, nil
+}
+
+// Deprovision deletes the sandbox's service account, which revokes its key
+// pair, then empties and deletes its bucket. Objects are listed and deleted
+// in batches of up to 1000 per request, versions included.
+func (p *MinioProvider) Deprovision(ctx context.Context, sandboxID, serviceName string) error {
+	bucket := sandboxBucket(sandboxID)
+
+	slog.Info("deprovisioning minio bucket",
+		"sandbox_id", sandboxID,
+		"bucket", bucket,
+	)
+
+	if err := p.admin.deleteServiceAccount(ctx, sandboxAccessKey(sandboxID)); err != nil {
+		return fmt.Errorf("failed to delete service account: %w", err)
+	}
+
+	exists, err := p.client.BucketExists(ctx, bucket)
+	if err != nil {
+		return fmt.Errorf("failed to check bucket: %w", err)
+	}
+	if !exists {
+		return nil
+	}
+
+	// Forward listed objects to the batched delete, keeping the first list error
+	objects := make(chan minio.ObjectInfo)
+	var listErr error
+	var listed int
+	go func() {
+		defer close(objects)
+		for obj := range p.client.ListObjects(ctx, bucket, minio.ListObjectsOptions{Recursive: true, WithVersions: true}) {
+			if obj.Err != nil {
+				listErr = obj.Err
+				return
+			}
+			select {
+			case objects <- obj:
+				listed++
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var removeErr error
+	for res := range p.client.RemoveObjects(ctx, bucket, objects, minio.RemoveObjectsOptions{}) {
+		if removeErr == nil {
+			removeErr = fmt.Errorf("failed to delete object %s: %w", res.ObjectName, res.Err)
+		}
+	}
+	if listErr != nil {
+		return fmt.Errorf("failed to list objects: %w", listErr)
+	}
+	if removeErr != nil {
+		return removeErr
+	}
+
+	if err := p.client.RemoveBucket(ctx, bucket); err != nil {
+		return fmt.Errorf("failed to delete bucket: %w", err)
+	}
+
+	slog.Info("minio bucket deprovisioned",
+		"sandbox_id", sandboxID,
+		"objects_deleted", listed,
+	)
+
+	return nil
+}
+
+// ListProvisioned returns the sandboxes with a bucket, read from the tag set
+// at Provision. A sandbox-* bucket without the tag, or whose tag names
+// another bucket, isn't the engine's and is left out.
+func (p *MinioProvider) ListProvisioned(ctx context.Context) ([]string, error) {
+	buckets, err := p.client.ListBuckets(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list buckets: %w", err)
+	}
+	var ids []string
+	for _, b := range buckets {
+		if !strings.HasPrefix(b.Name, "sandbox-") {
+			continue
+		}
+		bucketTags, err := p.client.GetBucketTagging(ctx, b.Name)
+		if err != nil {
+			if minio.ToErrorResponse(err).Code == "NoSuchTagSet" {
+				continue
+			}
+			return nil, fmt.Errorf("failed to read tags of bucket %s: %w", b.Name, err)
+		}
+		if id := bucketSandboxID(b.Name, bucketTags.ToMap()); id != "" {
+			ids = append(ids, NormalizeSandboxID(id))
+		}
+	}
+	return ids, nil
+}
+
+// bucketSandboxID returns the sandbox a bucket's tags name, or "" when the
+// tag is missing or the sandbox's bucket would be another one
+func bucketSandboxID(bucket string, bucketTags map[string]string) string {
+	id := bucketTags[minioSandboxTag]
+	if id == "" || sandboxBucket(id) != bucket {
+		return ""
+	}
+	return id
+}
+
+// HealthCheck verifies the admin credentials work by listing the buckets
+func (p *MinioProvider) HealthCheck(ctx context.Context) error {
+	_, err := p.client.ListBuckets(ctx)
+	return err
+}
+
+// CheckCredentials connects with the instance key pair and verifies it
+// reaches the sandbox's bucket
+func (p *MinioProvider) CheckCredentials(ctx context.Context, creds *models.ServiceCredentials) error {
+	if creds == nil || creds.Username == "" || creds.Database == "" {
+		return fmt.Errorf("no credentials")
+	}
+
+	client, err := minio.New(fmt.Sprintf("%s:%d", creds.Host, creds.Port), &minio.Options{
+		Creds:  credentials.NewStaticV4(creds.Username, creds.Password, creds.Token),
+		Secure: p.opts.UseSSL,
+		Region: p.opts.Region,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create client: %w", err)
+	}
+
+	exists, err := client.BucketExists(ctx, creds.Database)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("bucket %s is not provisioned", creds.Database)
+	}
+
+	return nil
+}
+
+// endpointURL is the URL of the S3 API, which also serves the admin API
+func (p *MinioProvider) endpointURL() string {
+	scheme := "http"
+	if p.opts.UseSSL {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s", scheme, p.opts.Endpoint)
+}
+
+// sandboxAccessKey names the service account of a sandbox. Access keys are
+// at most 20 characters, so it is derived from a hash of the ID.
+func sandboxAccessKey(sandboxID string) string {
+	sum := sha256.Sum256([]byte(sandboxID))
+	return "sandbox" + hex.EncodeToString(sum[:])[:13]
+}
+
+// sandboxBucket names the bucket of a sandbox; bucket names are at most 63
+// lowercase letters, digits and hyphens
+func sandboxBucket(sandboxID string) string {
+	name := "sandbox-" + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' {
+			return r
+		}
+		if r >= 'A' && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return '-'
+	}, sandboxID)
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	return strings.TrimRight(name, "-")
+}
+
+// bucketPolicy allows every S3 action on bucket and its objects, and nothing
+// else. The bucket's tags are denied, so a sandbox can't disown its bucket.
+func bucketPolicy(bucket string) (string, error) {
+	resources := []string{"arn:aws:s3:::" + bucket, "arn:aws:s3:::" + bucket + "/*"}
+	policy := map[string]any{
+		"Version": "2012-10-17",
+		"Statement": []map[string]any{{
+			"Effect":   "Allow",
+			"Action":   []string{"s3:*"},
+			"Resource": resources,
+		}, {
+			"Effect":   "Deny",
+			"Action":   []string{"s3:PutBucketTagging", "s3:DeleteBucketTagging"},
+			"Resource": resources,
+		}},
+	}
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return "", fmt.Errorf("failed to render bucket policy: %w", err)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/signer"
+	"golang.org/x/crypto/argon2"
+)
+
+// minioAdminTimeout bounds one admin API request
+const minioAdminTimeout = 30 * time.Second
+
+// minioAdmin calls the two MinIO admin API routes the provider needs, adding
+// and deleting service accounts, the way madmin-go does: requests are signed
+// like S3 ones and bodies are encrypted with the admin secret key
+type minioAdmin struct {
+	// endpoint is scheme://host:port of the server
+	endpoint  string
+	accessKey string
+	secretKey string
+	region    string
+	client    *http.Client
+}
+
+// minioAdminError is the JSON error body of the admin API
+type minioAdminError struct {
+	Code    string `json:"Code"`
+	Message string `json:"Message"`
+}
+
+func (e *minioAdminError) Error() string {
+	return fmt.Sprintf("minio admin: %s: %s", e.Code, e.Message)
+}
+
+// addServiceAccount creates a service account of the admin user with the
+// given key pair, limited by policy
+func (a *minioAdmin) addServiceAccount(ctx context.Context, accessKey, secretKey, policy, description string) error {
+	body, err := json.Marshal(map[string]any{
+		"policy":      json.RawMessage(policy),
+		"accessKey":   accessKey,
+		"secretKey":   secretKey,
+		"description": description,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode service account: %w", err)
+	}
+	body, err = encryptAdminData(a.secretKey, body)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt service account: %w", err)
+	}
+	return a.do(ctx, http.MethodPut, "add-service-accounts", nil, body)
+}
+
+// deleteServiceAccount deletes a service account; a missing one is deleted
+func (a *minioAdmin) deleteServiceAccount(ctx context.Context, accessKey string) error {
+	err := a.do(ctx, http.MethodDelete, "delete-service-accounts", url.Values{"accessKey": {accessKey}}, nil)
+	var e *minioAdminError
+	if errors.As(err, &e) && strings.Contains(e.Code, "NotFound") {
+		return nil
+	}
+	return err
+}
+
+// do sends a signed admin API request, returning a *minioAdminError for an
+// error response
+func (a *minioAdmin) do(ctx context.Context, method, route string, query url.Values, body []byte) error {
+	u := a.endpoint + "/minio/admin/v3/" + route
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	sum := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+	req.ContentLength = int64(len(body))
+
+	region := a.region
+	if region == "" {
+		region = "us-east-1"
+	}
+	req = signer.SignV4(*req, a.accessKey, a.secretKey, "", region)
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return nil
+	}
+	e := &minioAdminError{}
+	if json.Unmarshal(data, e) != nil || e.Code == "" {
+		e.Code, e.Message = resp.Status, strings.TrimSpace(string(data))
+	}
+	return e
+}
+
+// adminFragmentSize is the plaintext size of one sealed fragment of an
+// encrypted admin body
+const adminFragmentSize = 1 << 14
+
+// adminAESGCM is the ID of AES-256-GCM in an encrypted admin body
+const adminAESGCM = 0x00
+
+// encryptAdminData encrypts an admin request body like madmin's EncryptData:
+// salt | AEAD ID | nonce | fragments, with the key derived from password by
+// Argon2id. Fragments follow the sio stream format: each is sealed with the
+// nonce and its sequence number, and authenticates a flag, set on the last
+// one, and the tag of the (empty) associated data of the stream.
+func encryptAdminData(password string, data []byte) ([]byte, error) {
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(argon2.IDKey([]byte(password), salt, 1, 64*1024, 4, 32))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// The last 4 bytes of the nonce are the fragment's sequence number
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce[:len(nonce)-4]); err != nil {
+		return nil, err
+	}
+	out := append(salt, adminAESGCM)
+	out = append(out, nonce[:len(nonce)-4]...)
+
+	ad := aead.Seal([]byte{0x00}, nonce, nil, nil)
+	for seq := uint32(1); ; seq++ {
+		n := min(len(data), adminFragmentSize)
+		last := n == len(data)
+		if last {
+			ad[0] = 0x80
+		}
+		binary.LittleEndian.PutUint32(nonce[len(nonce)-4:], seq)
+		out = aead.Seal(out, nonce, data[:n], ad)
+		if data = data[n:]; last {
+			return out, nil
+		}
+	}
+}
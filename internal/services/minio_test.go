@@ -0,0 +1,246 @@
+package services
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// fakeMinio serves the S3 and admin API routes the provider uses, keeping
+// buckets and service accounts in memory
+type fakeMinio struct {
+	t         *testing.T
+	secretKey string
+
+	mu       sync.Mutex
+	buckets  map[string]bool
+	accounts map[string]map[string]any // decrypted add-service-accounts bodies by access key
+}
+
+func newFakeMinio(t *testing.T) (*fakeMinio, *MinioProvider) {
+	f := &fakeMinio{t: t, secretKey: "admin-secret", buckets: map[string]bool{}, accounts: map[string]map[string]any{}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	p, err := NewMinioProvider(MinioOptions{
+		Endpoint:  strings.TrimPrefix(srv.URL, "http://"),
+		AccessKey: "admin",
+		SecretKey: f.secretKey,
+		Region:    "us-east-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, p
+}
+
+func (f *fakeMinio) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=admin/") {
+		f.t.Errorf("unsigned request %s %s", r.Method, r.URL)
+	}
+
+	if route, ok := strings.CutPrefix(r.URL.Path, "/minio/admin/v3/"); ok {
+		switch {
+		case route == "add-service-accounts" && r.Method == http.MethodPut:
+			body, _ := io.ReadAll(r.Body)
+			data, err := decryptAdminData(f.secretKey, body)
+			if err != nil {
+				f.t.Errorf("failed to decrypt service account: %v", err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			var account map[string]any
+			if err := json.Unmarshal(data, &account); err != nil {
+				f.t.Errorf("invalid service account %s", data)
+			}
+			f.accounts[account["accessKey"].(string)] = account
+		case route == "delete-service-accounts" && r.Method == http.MethodDelete:
+			key := r.URL.Query().Get("accessKey")
+			if f.accounts[key] == nil {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`{"Code":"XMinioAdminServiceAccountNotFound","Message":"no such account"}`))
+				return
+			}
+			delete(f.accounts, key)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+		return
+	}
+
+	bucket := strings.Trim(r.URL.Path, "/")
+	query := r.URL.Query()
+	switch {
+	case bucket == "" && r.Method == http.MethodGet:
+		_, _ = w.Write([]byte(`<ListAllMyBucketsResult><Buckets></Buckets></ListAllMyBucketsResult>`))
+	case query.Has("tagging") || r.Method == http.MethodPut:
+		f.buckets[bucket] = true
+	case r.Method == http.MethodHead:
+		if !f.buckets[bucket] {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case query.Has("versions"):
+		_, _ = w.Write([]byte(`<ListVersionsResult><Name>` + bucket + `</Name><IsTruncated>false</IsTruncated></ListVersionsResult>`))
+	case r.Method == http.MethodDelete:
+		delete(f.buckets, bucket)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		f.t.Errorf("unexpected request %s %s", r.Method, r.URL)
+	}
+}
+
+// decryptAdminData reverses encryptAdminData the way the MinIO server does
+func decryptAdminData(password string, data []byte) ([]byte, error) {
+	if len(data) < 32+1+8 || data[32] != adminAESGCM {
+		return nil, errors.New("malformed header")
+	}
+	block, err := aes.NewCipher(argon2.IDKey([]byte(password), data[:32], 1, 64*1024, 4, 32))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	copy(nonce, data[33:41])
+	ad := aead.Seal([]byte{0x00}, nonce, nil, nil)
+
+	var plaintext []byte
+	rest := data[41:]
+	for seq := uint32(1); ; seq++ {
+		n := min(len(rest), adminFragmentSize+aead.Overhead())
+		if n == len(rest) {
+			ad[0] = 0x80
+		}
+		binary.LittleEndian.PutUint32(nonce[len(nonce)-4:], seq)
+		plaintext, err = aead.Open(plaintext, nonce, rest[:n], ad)
+		if err != nil {
+			return nil, err
+		}
+		if rest = rest[n:]; len(rest) == 0 {
+			return plaintext, nil
+		}
+	}
+}
+
+func TestMinioServiceAccounts(t *testing.T) {
+	ctx := context.Background()
+	f, p := newFakeMinio(t)
+
+	creds, err := p.Provision(ctx, "Sb_1", "minio", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.Username != sandboxAccessKey("Sb_1") || creds.Password == "" || creds.Token != "" || creds.Database != "sandbox-sb-1" {
+		t.Fatalf("unexpected credentials %+v", creds)
+	}
+	account := f.accounts[creds.Username]
+	if account == nil || account["secretKey"] != creds.Password {
+		t.Fatalf("expected a service account with the returned key pair, got %v", f.accounts)
+	}
+	policy, _ := json.Marshal(account["policy"])
+	if want, _ := bucketPolicy("sandbox-sb-1"); string(policy) != want {
+		t.Errorf("expected the bucket policy on the account, got %s", policy)
+	}
+
+	// Provisioning again replaces the account and its secret
+	again, err := p.Provision(ctx, "Sb_1", "minio", nil)
+	if err != nil || again.Password == creds.Password || len(f.accounts) != 1 {
+		t.Fatalf("expected the account replaced, got %+v, %v and %d accounts", again, err, len(f.accounts))
+	}
+
+	// Deprovision revokes the key pair with the bucket, and is idempotent
+	for range 2 {
+		if err := p.Deprovision(ctx, "Sb_1", "minio"); err != nil {
+			t.Fatal(err)
+		}
+		if len(f.accounts) != 0 || len(f.buckets) != 0 {
+			t.Fatalf("expected the account and bucket gone, got %v and %v", f.accounts, f.buckets)
+		}
+	}
+}
+
+func TestEncryptAdminData(t *testing.T) {
+	// Several fragments, the last one partial
+	data := []byte(strings.Repeat("policy", 6000))
+	encrypted, err := encryptAdminData("secret", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decryptAdminData("secret", encrypted)
+	if err != nil || string(got) != string(data) {
+		t.Fatalf("expected the data back, got %d bytes, %v", len(got), err)
+	}
+	if _, err := decryptAdminData("other", encrypted); err == nil {
+		t.Error("expected another password to fail")
+	}
+
+	// Dropping the last fragment is detected
+	if _, err := decryptAdminData("secret", encrypted[:41+2*(adminFragmentSize+16)]); err == nil {
+		t.Error("expected a truncated body to fail")
+	}
+}
+
+func TestSandboxAccessKey(t *testing.T) {
+	long := strings.Repeat("a", 70)
+	if key := sandboxAccessKey(long); len(key) > 20 || key != sandboxAccessKey(long) || key == sandboxAccessKey("b") {
+		t.Errorf("expected a stable, distinct access key of at most 20 characters, got %q", key)
+	}
+}
+
+func TestBucketSandboxID(t *testing.T) {
+	long := strings.Repeat("a", 70)
+	tests := []struct {
+		bucket string
+		tags   map[string]string
+		want   string
+	}{
+		{"sandbox-ab-cd", map[string]string{minioSandboxTag: "Ab_Cd"}, "Ab_Cd"},
+		{sandboxBucket(long), map[string]string{minioSandboxTag: long}, long},
+		{"sandbox-ab-cd", nil, ""},
+		{"sandbox-ab-cd", map[string]string{"owner": "ab-cd"}, ""},
+		{"sandbox-ab-cd", map[string]string{minioSandboxTag: "other"}, ""},
+	}
+	for _, tt := range tests {
+		if got := bucketSandboxID(tt.bucket, tt.tags); got != tt.want {
+			t.Errorf("%s %v: got %q, want %q", tt.bucket, tt.tags, got, tt.want)
+		}
+	}
+}
+
+func TestBucketPolicyDeniesTagging(t *testing.T) {
+	data, err := bucketPolicy("sandbox-x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var policy struct {
+		Statement []struct {
+			Effect string
+			Action []string
+		}
+	}
+	if err := json.Unmarshal([]byte(data), &policy); err != nil {
+		t.Fatal(err)
+	}
+	for _, st := range policy.Statement {
+		if st.Effect == "Deny" && strings.Join(st.Action, ",") == "s3:PutBucketTagging,s3:DeleteBucketTagging" {
+			return
+		}
+	}
+	t.Fatalf("tagging isn't denied: %s", data)
+}
@@ -5,15 +5,85 @@ import (
 	"crypto/rand"
 	"database/sql"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"net/url"
+	"regexp"
+	"slices"
 	"strings"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 
 	"github.com/terra-clan/sandbox-engine/internal/models"
 )
 
+// ErrInvalidSandboxID is a sandbox ID that can't name a database or user
+var ErrInvalidSandboxID = errors.New("invalid sandbox id")
+
+// sandboxIDPattern is what a sandbox ID may contain to name a database and a
+// user; it is checked even though every name is quoted
+var sandboxIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// maxIdentifierLength is the longest Postgres identifier, in bytes
+const maxIdentifierLength = 63
+
+// Validation of the provisioning options; names are quoted all the same
+var (
+	postgresSchemaPattern    = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+	postgresExtensionPattern = regexp.MustCompile(`^[a-z0-9_-]+$`)
+	postgresEncodingPattern  = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+)
+
+// postgresOptions are the provisioning options of the Postgres provider
+type postgresOptions struct {
+	// Extensions are created in the database
+	Extensions []string
+	// Schema is created owned by the sandbox user, first on its search_path
+	Schema string
+	// Encoding is the database encoding, which copies template0 instead of template1
+	Encoding string
+}
+
+// parsePostgresOptions reads options: extensions (comma separated, each one
+// of allowed), schema and encoding
+func parsePostgresOptions(opts map[string]string, allowed []string) (postgresOptions, error) {
+	var o postgresOptions
+	for key, value := range opts {
+		switch key {
+		case "extensions":
+			for _, ext := range strings.Split(value, ",") {
+				if ext = strings.TrimSpace(ext); ext == "" {
+					continue
+				}
+				if !postgresExtensionPattern.MatchString(ext) || len(ext) > maxIdentifierLength {
+					return o, fmt.Errorf("invalid extension name %q", ext)
+				}
+				// Extensions are created as the admin user, so only vetted ones
+				if !slices.Contains(allowed, ext) {
+					return o, fmt.Errorf("extension %q is not allowed (allowed: %s)", ext, strings.Join(allowed, ", "))
+				}
+				o.Extensions = append(o.Extensions, ext)
+			}
+		case "schema":
+			if !postgresSchemaPattern.MatchString(value) || len(value) > maxIdentifierLength || strings.HasPrefix(value, "pg_") {
+				return o, fmt.Errorf("invalid schema name %q: lowercase letters, digits and _, not starting with pg_", value)
+			}
+			o.Schema = value
+		case "encoding":
+			if !postgresEncodingPattern.MatchString(value) {
+				return o, fmt.Errorf("invalid encoding %q", value)
+			}
+			o.Encoding = value
+		default:
+			return o, fmt.Errorf("unknown postgres option %q (available: encoding, extensions, schema)", key)
+		}
+	}
+	slices.Sort(o.Extensions)
+	return o, nil
+}
+
 // PostgresProvider implements Provider for PostgreSQL
 type PostgresProvider struct {
 	BaseProvider
@@ -21,10 +91,14 @@ type PostgresProvider struct {
 	host     string
 	port     int
 	adminDSN string
+
+	// allowedExtensions are those the extensions option may create
+	allowedExtensions []string
 }
 
-// NewPostgresProvider creates a new PostgreSQL provider
-func NewPostgresProvider(dsn string) (*PostgresProvider, error) {
+// NewPostgresProvider creates a new PostgreSQL provider whose extensions
+// option takes only allowedExtensions
+func NewPostgresProvider(dsn string, allowedExtensions []string) (*PostgresProvider, error) {
 	db, err := sql.Open("postgres", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to postgres: %w", err)
@@ -59,14 +133,22 @@ func NewPostgresProvider(dsn string) (*PostgresProvider, error) {
 		host:         host,
 		port:         port,
 		adminDSN:     dsn,
+
+		allowedExtensions: allowedExtensions,
 	}, nil
 }
 
 // Provision creates a database and user for the sandbox
-func (p *PostgresProvider) Provision(ctx context.Context, sandboxID, serviceName string) (*models.ServiceCredentials, error) {
+func (p *PostgresProvider) Provision(ctx context.Context, sandboxID, serviceName string, opts map[string]string) (*models.ServiceCredentials, error) {
 	// Generate unique database and user names
-	dbName := fmt.Sprintf("sandbox_%s", strings.ReplaceAll(sandboxID, "-", "_"))
-	userName := fmt.Sprintf("sandbox_user_%s", strings.ReplaceAll(sandboxID, "-", "_"))
+	dbName, userName, err := postgresNames(sandboxID)
+	if err != nil {
+		return nil, err
+	}
+	options, err := parsePostgresOptions(opts, p.allowedExtensions)
+	if err != nil {
+		return nil, err
+	}
 	password := generatePassword(16)
 
 	slog.Info("provisioning postgres database",
@@ -76,25 +158,30 @@ func (p *PostgresProvider) Provision(ctx context.Context, sandboxID, serviceName
 	)
 
 	// Create user
-	createUserSQL := fmt.Sprintf("CREATE USER %s WITH PASSWORD '%s'", userName, password)
-	if _, err := p.db.ExecContext(ctx, createUserSQL); err != nil {
+	if _, err := p.db.ExecContext(ctx, createUserSQL(userName, password)); err != nil {
 		return nil, fmt.Errorf("failed to create user: %w", err)
 	}
 
 	// Create database
-	createDBSQL := fmt.Sprintf("CREATE DATABASE %s OWNER %s", dbName, userName)
-	if _, err := p.db.ExecContext(ctx, createDBSQL); err != nil {
+	if _, err := p.db.ExecContext(ctx, createDatabaseSQL(dbName, userName, options.Encoding)); err != nil {
 		// Cleanup user on failure
-		_, _ = p.db.ExecContext(ctx, fmt.Sprintf("DROP USER IF EXISTS %s", userName))
+		_, _ = p.db.ExecContext(ctx, dropUserSQL(userName))
 		return nil, fmt.Errorf("failed to create database: %w", err)
 	}
 
 	// Grant privileges
-	grantSQL := fmt.Sprintf("GRANT ALL PRIVILEGES ON DATABASE %s TO %s", dbName, userName)
-	if _, err := p.db.ExecContext(ctx, grantSQL); err != nil {
+	if _, err := p.db.ExecContext(ctx, grantSQL(dbName, userName)); err != nil {
 		slog.Warn("failed to grant privileges", "error", err)
 	}
 
+	if err := p.applyOptions(ctx, dbName, userName, options); err != nil {
+		// Cleanup: a sandbox relying on the options can't run without them
+		cleanupCtx := context.WithoutCancel(ctx)
+		_, _ = p.db.ExecContext(cleanupCtx, dropDatabaseSQL(dbName))
+		_, _ = p.db.ExecContext(cleanupCtx, dropUserSQL(userName))
+		return nil, err
+	}
+
 	// Build connection URI
 	uri := fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=disable",
 		userName, password, p.host, p.port, dbName)
@@ -109,10 +196,68 @@ func (p *PostgresProvider) Provision(ctx context.Context, sandboxID, serviceName
 	}, nil
 }
 
-// Deprovision removes the database and user
+// applyOptions creates the extensions and schema of options in the sandbox
+// database. It connects there as the admin user, since creating most
+// extensions takes privileges the sandbox user lacks.
+func (p *PostgresProvider) applyOptions(ctx context.Context, dbName, userName string, options postgresOptions) error {
+	if len(options.Extensions) == 0 && options.Schema == "" {
+		return nil
+	}
+
+	dsn, err := databaseDSN(p.adminDSN, dbName)
+	if err != nil {
+		return err
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return fmt.Errorf("failed to open connection: %w", err)
+	}
+	defer db.Close()
+
+	for _, ext := range options.Extensions {
+		if _, err := db.ExecContext(ctx, createExtensionSQL(ext)); err != nil {
+			return fmt.Errorf("failed to create extension %s: %w", ext, err)
+		}
+	}
+	if options.Schema != "" {
+		if _, err := db.ExecContext(ctx, createSchemaSQL(options.Schema, userName)); err != nil {
+			return fmt.Errorf("failed to create schema %s: %w", options.Schema, err)
+		}
+		if _, err := db.ExecContext(ctx, searchPathSQL(userName, dbName, options.Schema)); err != nil {
+			return fmt.Errorf("failed to set search_path: %w", err)
+		}
+	}
+	return nil
+}
+
+// ValidateOptions checks the Postgres provisioning options
+func (p *PostgresProvider) ValidateOptions(opts map[string]string) error {
+	_, err := parsePostgresOptions(opts, p.allowedExtensions)
+	return err
+}
+
+// databaseDSN returns dsn connecting to database dbName instead
+func databaseDSN(dsn, dbName string) (string, error) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return "", fmt.Errorf("invalid postgres dsn: %w", err)
+		}
+		u.Path = "/" + dbName
+		return u.String(), nil
+	}
+	// In a key=value DSN the last setting of a key wins
+	return dsn + " dbname=" + dbName, nil
+}
+
+// Deprovision removes the database and user. Both drops use IF EXISTS, so
+// one already gone is not an error; any other failure is returned for the
+// delete to be retried.
 func (p *PostgresProvider) Deprovision(ctx context.Context, sandboxID, serviceName string) error {
-	dbName := fmt.Sprintf("sandbox_%s", strings.ReplaceAll(sandboxID, "-", "_"))
-	userName := fmt.Sprintf("sandbox_user_%s", strings.ReplaceAll(sandboxID, "-", "_"))
+	dbName, userName, err := postgresNames(sandboxID)
+	if err != nil {
+		return err
+	}
 
 	slog.Info("deprovisioning postgres database",
 		"sandbox_id", sandboxID,
@@ -121,33 +266,220 @@ func (p *PostgresProvider) Deprovision(ctx context.Context, sandboxID, serviceNa
 	)
 
 	// Terminate existing connections
-	terminateSQL := fmt.Sprintf(`
+	const terminateSQL = `
 		SELECT pg_terminate_backend(pid)
 		FROM pg_stat_activity
-		WHERE datname = '%s' AND pid <> pg_backend_pid()
-	`, dbName)
-	_, _ = p.db.ExecContext(ctx, terminateSQL)
+		WHERE datname = $1 AND pid <> pg_backend_pid()
+	`
+	_, _ = p.db.ExecContext(ctx, terminateSQL, dbName)
 
 	// Drop database
-	dropDBSQL := fmt.Sprintf("DROP DATABASE IF EXISTS %s", dbName)
-	if _, err := p.db.ExecContext(ctx, dropDBSQL); err != nil {
-		slog.Warn("failed to drop database", "error", err, "database", dbName)
+	if _, err := p.db.ExecContext(ctx, dropDatabaseSQL(dbName)); err != nil {
+		return fmt.Errorf("failed to drop database: %w", err)
 	}
 
 	// Drop user
-	dropUserSQL := fmt.Sprintf("DROP USER IF EXISTS %s", userName)
-	if _, err := p.db.ExecContext(ctx, dropUserSQL); err != nil {
-		slog.Warn("failed to drop user", "error", err, "user", userName)
+	if _, err := p.db.ExecContext(ctx, dropUserSQL(userName)); err != nil {
+		return fmt.Errorf("failed to drop user: %w", err)
 	}
 
 	return nil
 }
 
+// RotateCredentials sets a new password for the sandbox user and terminates
+// its sessions, which are still authenticated with the old one
+func (p *PostgresProvider) RotateCredentials(ctx context.Context, sandboxID, serviceName string, creds *models.ServiceCredentials) (*models.ServiceCredentials, error) {
+	dbName, userName, err := postgresNames(sandboxID)
+	if err != nil {
+		return nil, err
+	}
+	password := generatePassword(16)
+
+	slog.Info("rotating postgres credentials",
+		"sandbox_id", sandboxID,
+		"user", userName,
+	)
+
+	if _, err := p.db.ExecContext(ctx, alterUserPasswordSQL(userName, password)); err != nil {
+		return nil, fmt.Errorf("failed to set password: %w", err)
+	}
+
+	const terminateSQL = `
+		SELECT pg_terminate_backend(pid)
+		FROM pg_stat_activity
+		WHERE usename = $1 AND pid <> pg_backend_pid()
+	`
+	if _, err := p.db.ExecContext(ctx, terminateSQL, userName); err != nil {
+		slog.Warn("failed to terminate sessions after rotation", "error", err, "user", userName)
+	}
+
+	return &models.ServiceCredentials{
+		Host:     p.host,
+		Port:     p.port,
+		Username: userName,
+		Password: password,
+		Database: dbName,
+		URI: fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=disable",
+			userName, password, p.host, p.port, dbName),
+	}, nil
+}
+
+// ListProvisioned returns the sandboxes with a database owned by their user,
+// or a user left behind when dropping it failed. Other databases named
+// sandbox_* on the server are left out.
+func (p *PostgresProvider) ListProvisioned(ctx context.Context) ([]string, error) {
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT substr(d.datname, 9) FROM pg_database d JOIN pg_roles r ON r.oid = d.datdba
+		WHERE d.datname LIKE 'sandbox\_%' AND r.rolname = 'sandbox_user_' || substr(d.datname, 9)
+		UNION
+		SELECT substr(rolname, 14) FROM pg_roles WHERE rolname LIKE 'sandbox\_user\_%'`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list databases: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var suffix string
+		if err := rows.Scan(&suffix); err != nil {
+			return nil, fmt.Errorf("failed to list databases: %w", err)
+		}
+		ids = append(ids, NormalizeSandboxID(suffix))
+	}
+	return ids, rows.Err()
+}
+
 // HealthCheck verifies PostgreSQL connectivity
 func (p *PostgresProvider) HealthCheck(ctx context.Context) error {
 	return p.db.PingContext(ctx)
 }
 
+// CheckCredentials connects to the sandbox database with its own user
+func (p *PostgresProvider) CheckCredentials(ctx context.Context, creds *models.ServiceCredentials) error {
+	if creds == nil || creds.URI == "" {
+		return fmt.Errorf("no credentials")
+	}
+
+	db, err := sql.Open("postgres", creds.URI)
+	if err != nil {
+		return fmt.Errorf("failed to open connection: %w", err)
+	}
+	defer db.Close()
+
+	if err := db.PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+
+	return nil
+}
+
+// Seed runs the statements of a SQL script in the sandbox database, as its
+// own user, one at a time as they are read. It stops at the first failing
+// statement; those before it stay applied.
+func (p *PostgresProvider) Seed(ctx context.Context, creds *models.ServiceCredentials, r io.Reader) error {
+	if creds == nil || creds.URI == "" {
+		return fmt.Errorf("no credentials")
+	}
+
+	db, err := sql.Open("postgres", creds.URI)
+	if err != nil {
+		return fmt.Errorf("failed to open connection: %w", err)
+	}
+	defer db.Close()
+
+	// One connection, so SET and temporary tables carry over between statements
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer conn.Close()
+
+	scanner := newSQLScanner(r)
+	for n := 1; ; n++ {
+		stmt, err := scanner.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read seed: %w", err)
+		}
+		if copiesFromStdin(stmt) {
+			return fmt.Errorf("statement %d (line %d): COPY ... FROM stdin is only supported in a dedicated postgres service; use INSERT", n, scanner.line)
+		}
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("statement %d (line %d): %w", n, scanner.line, err)
+		}
+	}
+}
+
+// postgresNames returns the database and user names of a sandbox. Names
+// used to be unquoted and so folded to lowercase; they are lowercased to
+// keep matching the databases of existing sandboxes.
+func postgresNames(sandboxID string) (dbName, userName string, err error) {
+	if !sandboxIDPattern.MatchString(sandboxID) {
+		return "", "", fmt.Errorf("%w %q: only letters, digits, - and _ are allowed", ErrInvalidSandboxID, sandboxID)
+	}
+	suffix := strings.ToLower(strings.ReplaceAll(sandboxID, "-", "_"))
+	dbName = "sandbox_" + suffix
+	userName = "sandbox_user_" + suffix
+	if len(userName) > maxIdentifierLength {
+		return "", "", fmt.Errorf("%w %q: longer than %d bytes as a user name", ErrInvalidSandboxID, sandboxID, maxIdentifierLength)
+	}
+	return dbName, userName, nil
+}
+
+// createUserSQL creates a user with a password. DDL takes no bind
+// parameters, so names are quoted as identifiers and the password as a
+// literal, here and in the statements below.
+func createUserSQL(userName, password string) string {
+	return fmt.Sprintf("CREATE USER %s WITH PASSWORD %s", pq.QuoteIdentifier(userName), pq.QuoteLiteral(password))
+}
+
+// createDatabaseSQL creates a database owned by a user, in encoding unless
+// empty. Only template0 can be copied to another encoding.
+func createDatabaseSQL(dbName, userName, encoding string) string {
+	stmt := fmt.Sprintf("CREATE DATABASE %s OWNER %s", pq.QuoteIdentifier(dbName), pq.QuoteIdentifier(userName))
+	if encoding != "" {
+		stmt += fmt.Sprintf(" ENCODING %s TEMPLATE template0", pq.QuoteLiteral(encoding))
+	}
+	return stmt
+}
+
+// createExtensionSQL creates an extension in the current database
+func createExtensionSQL(name string) string {
+	return fmt.Sprintf("CREATE EXTENSION IF NOT EXISTS %s", pq.QuoteIdentifier(name))
+}
+
+// createSchemaSQL creates a schema owned by a user in the current database
+func createSchemaSQL(schema, userName string) string {
+	return fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s AUTHORIZATION %s", pq.QuoteIdentifier(schema), pq.QuoteIdentifier(userName))
+}
+
+// searchPathSQL puts a schema first on a user's search_path in a database
+func searchPathSQL(userName, dbName, schema string) string {
+	return fmt.Sprintf("ALTER ROLE %s IN DATABASE %s SET search_path = %s, public", pq.QuoteIdentifier(userName), pq.QuoteIdentifier(dbName), pq.QuoteIdentifier(schema))
+}
+
+// grantSQL grants a user all privileges on a database
+func grantSQL(dbName, userName string) string {
+	return fmt.Sprintf("GRANT ALL PRIVILEGES ON DATABASE %s TO %s", pq.QuoteIdentifier(dbName), pq.QuoteIdentifier(userName))
+}
+
+// dropDatabaseSQL drops a database, if it exists
+func dropDatabaseSQL(dbName string) string {
+	return fmt.Sprintf("DROP DATABASE IF EXISTS %s", pq.QuoteIdentifier(dbName))
+}
+
+// alterUserPasswordSQL sets the password of a user
+func alterUserPasswordSQL(userName, password string) string {
+	return fmt.Sprintf("ALTER USER %s WITH PASSWORD %s", pq.QuoteIdentifier(userName), pq.QuoteLiteral(password))
+}
+
+// dropUserSQL drops a user, if it exists
+func dropUserSQL(userName string) string {
+	return fmt.Sprintf("DROP USER IF EXISTS %s", pq.QuoteIdentifier(userName))
+}
+
 // generatePassword creates a random password
 func generatePassword(length int) string {
 	bytes := make([]byte, length)
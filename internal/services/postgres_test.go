@@ -0,0 +1,94 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPostgresNames(t *testing.T) {
+	dbName, userName, err := postgresNames("Ab12-cd_34")
+	if err != nil || dbName != "sandbox_ab12_cd_34" || userName != "sandbox_user_ab12_cd_34" {
+		t.Fatalf("unexpected names %q, %q, %v", dbName, userName, err)
+	}
+
+	hostile := []string{
+		"",
+		"x'; DROP DATABASE postgres; --",
+		`x"; DROP USER admin; --`,
+		"x;y",
+		"x y",
+		"песочница",
+		"x\u0000y",
+		"a/../b",
+		"0123456789012345678901234567890123456789012345678901", // too long as sandbox_user_<id>
+	}
+	for _, id := range hostile {
+		if _, _, err := postgresNames(id); !errors.Is(err, ErrInvalidSandboxID) {
+			t.Errorf("%q: expected ErrInvalidSandboxID, got %v", id, err)
+		}
+	}
+}
+
+func TestPostgresDDLQuoting(t *testing.T) {
+	tests := []struct{ got, want string }{
+		{createUserSQL("sandbox_user_x", "p'ass"), `CREATE USER "sandbox_user_x" WITH PASSWORD 'p''ass'`},
+		{createUserSQL(`u"; DROP USER admin; --`, `'; DROP DATABASE postgres; --`), `CREATE USER "u""; DROP USER admin; --" WITH PASSWORD '''; DROP DATABASE postgres; --'`},
+		{createUserSQL("u", `back\slash`), `CREATE USER "u" WITH PASSWORD  E'back\\slash'`},
+		{createDatabaseSQL("sandbox_x", "sandbox_user_x", ""), `CREATE DATABASE "sandbox_x" OWNER "sandbox_user_x"`},
+		{createDatabaseSQL("sandbox_x", "sandbox_user_x", "LATIN1"), `CREATE DATABASE "sandbox_x" OWNER "sandbox_user_x" ENCODING 'LATIN1' TEMPLATE template0`},
+		{createExtensionSQL("uuid-ossp"), `CREATE EXTENSION IF NOT EXISTS "uuid-ossp"`},
+		{createSchemaSQL("app", "sandbox_user_x"), `CREATE SCHEMA IF NOT EXISTS "app" AUTHORIZATION "sandbox_user_x"`},
+		{searchPathSQL("sandbox_user_x", "sandbox_x", "app"), `ALTER ROLE "sandbox_user_x" IN DATABASE "sandbox_x" SET search_path = "app", public`},
+		{grantSQL(`d"b`, "u"), `GRANT ALL PRIVILEGES ON DATABASE "d""b" TO "u"`},
+		{dropDatabaseSQL("sandbox_é"), `DROP DATABASE IF EXISTS "sandbox_é"`},
+		{dropUserSQL(`u"`), `DROP USER IF EXISTS "u"""`},
+		{alterUserPasswordSQL("sandbox_user_x", "p'ass"), `ALTER USER "sandbox_user_x" WITH PASSWORD 'p''ass'`},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("expected %s, got %s", tt.want, tt.got)
+		}
+	}
+}
+
+func TestParsePostgresOptions(t *testing.T) {
+	allowed := []string{"pg_trgm", "uuid-ossp"}
+	o, err := parsePostgresOptions(map[string]string{"extensions": "pg_trgm, uuid-ossp,,", "schema": "app", "encoding": "UTF8"}, allowed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(o, postgresOptions{Extensions: []string{"pg_trgm", "uuid-ossp"}, Schema: "app", Encoding: "UTF8"}) {
+		t.Errorf("unexpected options %+v", o)
+	}
+
+	invalid := []struct {
+		opts map[string]string
+		want string
+	}{
+		{map[string]string{"size": "1G"}, "unknown postgres option"},
+		{map[string]string{"schema": "pg_catalog"}, "invalid schema"},
+		{map[string]string{"encoding": "UTF8'; DROP DATABASE x; --"}, "invalid encoding"},
+		{map[string]string{"extensions": `pg_trgm, "x"`}, "invalid extension"},
+		{map[string]string{"extensions": "pg_trgm, plpython3u"}, `extension "plpython3u" is not allowed`},
+		{map[string]string{"extensions": "pg_trgm, dblink"}, `extension "dblink" is not allowed`},
+	}
+	for _, tt := range invalid {
+		if _, err := parsePostgresOptions(tt.opts, allowed); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%v: expected %q, got %v", tt.opts, tt.want, err)
+		}
+	}
+}
+
+func TestDatabaseDSN(t *testing.T) {
+	tests := []struct{ dsn, want string }{
+		{"postgres://admin:pw@db:5432/postgres?sslmode=disable", "postgres://admin:pw@db:5432/sandbox_x?sslmode=disable"},
+		{"host=db user=admin dbname=postgres", "host=db user=admin dbname=postgres dbname=sandbox_x"},
+	}
+	for _, tt := range tests {
+		if got, err := databaseDSN(tt.dsn, "sandbox_x"); err != nil || got != tt.want {
+			t.Errorf("%s: expected %s, got %s, %v", tt.dsn, tt.want, got, err)
+		}
+	}
+}
@@ -2,14 +2,17 @@ package services
 
 import (
 	"context"
+	"errors"
+	"strings"
 
 	"github.com/terra-clan/sandbox-engine/internal/models"
 )
 
 // Provider defines the interface for service provisioning
 type Provider interface {
-	// Provision creates resources for a sandbox
-	Provision(ctx context.Context, sandboxID, serviceName string) (*models.ServiceCredentials, error)
+	// Provision creates resources for a sandbox. opts are the template's
+	// services_config options for the service, nil without any.
+	Provision(ctx context.Context, sandboxID, serviceName string, opts map[string]string) (*models.ServiceCredentials, error)
 
 	// Deprovision removes all resources for a sandbox
 	Deprovision(ctx context.Context, sandboxID, serviceName string) error
@@ -19,6 +22,46 @@ type Provider interface {
 
 	// HealthCheck checks if the service is available
 	HealthCheck(ctx context.Context) error
+
+	// ListProvisioned returns the IDs of the sandboxes the provider holds
+	// resources for, recovered from the resource names. Names lose case and
+	// the difference between - and _, so compare them with NormalizeSandboxID.
+	ListProvisioned(ctx context.Context) ([]string, error)
+}
+
+// NormalizeSandboxID folds a sandbox ID the way resource names do, so an ID
+// returned by ListProvisioned matches the sandbox it was provisioned for.
+// Deprovision accepts either form.
+func NormalizeSandboxID(sandboxID string) string {
+	return strings.ToLower(strings.ReplaceAll(sandboxID, "_", "-"))
+}
+
+// CredentialChecker is implemented by providers that can verify the credentials
+// of a provisioned instance, not just the shared service
+type CredentialChecker interface {
+	// CheckCredentials connects with the instance credentials and returns an error if that fails
+	CheckCredentials(ctx context.Context, creds *models.ServiceCredentials) error
+}
+
+// OptionValidator is implemented by providers that take provisioning
+// options. Templates setting options on a provider without it fail
+// validation, as do options it rejects.
+type OptionValidator interface {
+	// ValidateOptions returns an error for an unknown option or a bad value
+	ValidateOptions(opts map[string]string) error
+}
+
+// ErrRotationUnsupported is returned by a CredentialRotator that can't
+// rotate the credentials of an instance, such as those shared by every sandbox
+var ErrRotationUnsupported = errors.New("credential rotation not supported")
+
+// CredentialRotator is implemented by providers that can replace the password
+// of a provisioned instance while keeping its data
+type CredentialRotator interface {
+	// RotateCredentials sets a new password for the sandbox's instance, whose
+	// current credentials are creds, disconnects the sessions using the old
+	// one and returns the new credentials
+	RotateCredentials(ctx context.Context, sandboxID, serviceName string, creds *models.ServiceCredentials) (*models.ServiceCredentials, error)
 }
 
 // BaseProvider provides common functionality for providers
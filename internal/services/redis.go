@@ -3,6 +3,7 @@ package services
 import (
 	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"strings"
 
@@ -11,13 +12,20 @@ import (
 	"github.com/terra-clan/sandbox-engine/internal/models"
 )
 
-// RedisProvider implements Provider for Redis
+// RedisProvider implements Provider for Redis. On Redis 6 and later each
+// sandbox gets an ACL user limited to its key prefix; older servers only get
+// the prefix, with the shared password.
 type RedisProvider struct {
 	BaseProvider
 	client   *redis.Client
 	host     string
 	port     int
 	password string
+
+	// acl is set when the server supports ACL users, aclChannels when it
+	// also supports channel patterns (Redis 6.2)
+	acl         bool
+	aclChannels bool
 }
 
 // NewRedisProvider creates a new Redis provider
@@ -41,24 +49,49 @@ func NewRedisProvider(address, password string) (*RedisProvider, error) {
 		fmt.Sscanf(parts[1], "%d", &port)
 	}
 
-	return &RedisProvider{
+	p := &RedisProvider{
 		BaseProvider: BaseProvider{serviceType: "redis"},
 		client:       client,
 		host:         host,
 		port:         port,
 		password:     password,
-	}, nil
+	}
+	p.detectACL(context.Background())
+	return p, nil
+}
+
+// detectACL checks whether the server supports ACL users and channel patterns
+func (p *RedisProvider) detectACL(ctx context.Context) {
+	if err := p.client.Do(ctx, "ACL", "WHOAMI").Err(); err != nil {
+		slog.Warn("redis ACLs unavailable, sandboxes share the redis password and are separated by key prefix only", "error", err)
+		return
+	}
+	p.acl = true
+
+	info, err := p.client.Info(ctx, "server").Result()
+	if err != nil {
+		slog.Warn("failed to read redis version, pub/sub is denied to sandbox users", "error", err)
+		return
+	}
+	p.aclChannels = versionAtLeast(infoField(info, "redis_version"), 6, 2)
+}
+
+// Client returns the connection, shared with the API rate limiter
+func (p *RedisProvider) Client() *redis.Client {
+	return p.client
 }
 
 // Provision creates a key prefix for the sandbox
 // Redis doesn't have true database isolation like PostgreSQL,
-// so we use key prefixes for logical separation
-func (p *RedisProvider) Provision(ctx context.Context, sandboxID, serviceName string) (*models.ServiceCredentials, error) {
-	prefix := fmt.Sprintf("sandbox:%s:", strings.ReplaceAll(sandboxID, "-", "_"))
+// so we use key prefixes for logical separation. With ACLs the prefix is
+// enforced by a user of the sandbox's own that can only reach its keys.
+func (p *RedisProvider) Provision(ctx context.Context, sandboxID, serviceName string, opts map[string]string) (*models.ServiceCredentials, error) {
+	prefix := redisPrefix(sandboxID)
 
 	slog.Info("provisioning redis namespace",
 		"sandbox_id", sandboxID,
 		"prefix", prefix,
+		"acl", p.acl,
 	)
 
 	// Store a marker key to track provisioned sandboxes
@@ -67,30 +100,72 @@ func (p *RedisProvider) Provision(ctx context.Context, sandboxID, serviceName st
 		return nil, fmt.Errorf("failed to provision redis namespace: %w", err)
 	}
 
-	// Build connection URI
-	uri := fmt.Sprintf("redis://%s:%d", p.host, p.port)
-	if p.password != "" {
-		uri = fmt.Sprintf("redis://:%s@%s:%d", p.password, p.host, p.port)
+	if !p.acl {
+		// Build connection URI
+		uri := fmt.Sprintf("redis://%s:%d", p.host, p.port)
+		if p.password != "" {
+			uri = fmt.Sprintf("redis://:%s@%s:%d", p.password, p.host, p.port)
+		}
+
+		return &models.ServiceCredentials{
+			Host:     p.host,
+			Port:     p.port,
+			Password: p.password,
+			Prefix:   prefix,
+			URI:      uri,
+		}, nil
+	}
+
+	userName := redisUser(sandboxID)
+	password := generatePassword(16)
+	if err := p.client.Do(ctx, p.aclRules(userName, password, prefix)...).Err(); err != nil {
+		// Cleanup marker on failure
+		_ = p.client.Del(context.WithoutCancel(ctx), markerKey).Err()
+		return nil, fmt.Errorf("failed to create redis user: %w", err)
 	}
 
 	return &models.ServiceCredentials{
 		Host:     p.host,
 		Port:     p.port,
-		Password: p.password,
+		Username: userName,
+		Password: password,
 		Prefix:   prefix,
-		URI:      uri,
+		URI:      fmt.Sprintf("redis://%s:%s@%s:%d", userName, password, p.host, p.port),
 	}, nil
 }
 
-// Deprovision removes all keys with the sandbox prefix
+// aclRules builds the ACL SETUSER command of a sandbox user: every command
+// but the admin and dangerous ones (FLUSHALL, FLUSHDB, KEYS, CONFIG, ...),
+// on the keys and channels under prefix only. Without channel patterns
+// pub/sub is denied instead.
+func (p *RedisProvider) aclRules(userName, password, prefix string) []any {
+	args := []any{"ACL", "SETUSER", userName, "reset", "on", ">" + password, "~" + prefix + "*"}
+	if p.aclChannels {
+		args = append(args, "resetchannels", "&"+prefix+"*")
+	}
+	args = append(args, "+@all", "-@admin", "-@dangerous")
+	if !p.aclChannels {
+		args = append(args, "-@pubsub")
+	}
+	return args
+}
+
+// Deprovision removes the sandbox's ACL user, which also disconnects its
+// clients, and then all keys with the sandbox prefix
 func (p *RedisProvider) Deprovision(ctx context.Context, sandboxID, serviceName string) error {
-	prefix := fmt.Sprintf("sandbox:%s:", strings.ReplaceAll(sandboxID, "-", "_"))
+	prefix := redisPrefix(sandboxID)
 
 	slog.Info("deprovisioning redis namespace",
 		"sandbox_id", sandboxID,
 		"prefix", prefix,
 	)
 
+	if p.acl {
+		if err := p.client.Do(ctx, "ACL", "DELUSER", redisUser(sandboxID)).Err(); err != nil {
+			return fmt.Errorf("failed to delete redis user: %w", err)
+		}
+	}
+
 	// Find all keys with this prefix
 	pattern := fmt.Sprintf("%s*", prefix)
 	var cursor uint64
@@ -123,11 +198,193 @@ func (p *RedisProvider) Deprovision(ctx context.Context, sandboxID, serviceName
 	return nil
 }
 
+// RotateCredentials sets a new password for the sandbox's ACL user and
+// disconnects its clients. Without ACLs sandboxes share the server password,
+// which can't be rotated for one of them.
+func (p *RedisProvider) RotateCredentials(ctx context.Context, sandboxID, serviceName string, creds *models.ServiceCredentials) (*models.ServiceCredentials, error) {
+	if !p.acl {
+		return nil, ErrRotationUnsupported
+	}
+	userName := redisUser(sandboxID)
+	password := generatePassword(16)
+
+	slog.Info("rotating redis credentials",
+		"sandbox_id", sandboxID,
+		"user", userName,
+	)
+
+	// resetpass drops the old password, SETUSER leaves the other rules as they are
+	if err := p.client.Do(ctx, "ACL", "SETUSER", userName, "resetpass", ">"+password).Err(); err != nil {
+		return nil, fmt.Errorf("failed to set password: %w", err)
+	}
+	if err := p.client.Do(ctx, "CLIENT", "KILL", "USER", userName).Err(); err != nil {
+		slog.Warn("failed to disconnect clients after rotation", "error", err, "user", userName)
+	}
+
+	return &models.ServiceCredentials{
+		Host:     p.host,
+		Port:     p.port,
+		Username: userName,
+		Password: password,
+		Prefix:   redisPrefix(sandboxID),
+		URI:      fmt.Sprintf("redis://%s:%s@%s:%d", userName, password, p.host, p.port),
+	}, nil
+}
+
+// ListProvisioned returns the sandboxes with a namespace marker, and with
+// ACLs, those with a sandbox user. Keys left without a marker aren't found.
+func (p *RedisProvider) ListProvisioned(ctx context.Context) ([]string, error) {
+	seen := make(map[string]bool)
+	var ids []string
+	add := func(id string) {
+		if id = NormalizeSandboxID(id); id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	var cursor uint64
+	for {
+		keys, next, err := p.client.Scan(ctx, cursor, "sandbox:*:__provisioned__", 1000).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan markers: %w", err)
+		}
+		for _, key := range keys {
+			add(strings.TrimSuffix(strings.TrimPrefix(key, "sandbox:"), ":__provisioned__"))
+		}
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+
+	if p.acl {
+		rules, err := p.client.Do(ctx, "ACL", "LIST").StringSlice()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list users: %w", err)
+		}
+		for _, rule := range rules {
+			add(aclUserSandbox(rule))
+		}
+	}
+	return ids, nil
+}
+
+// aclUserSandbox returns the sandbox of an ACL LIST entry, or "" when it
+// isn't a sandbox_* user whose only key pattern is that sandbox's prefix.
+// Another sandbox_* user, not created by Provision, isn't the engine's.
+func aclUserSandbox(rule string) string {
+	fields := strings.Fields(rule)
+	if len(fields) < 2 || fields[0] != "user" {
+		return ""
+	}
+	id, ok := strings.CutPrefix(fields[1], "sandbox_")
+	if !ok || id == "" {
+		return ""
+	}
+	var patterns []string
+	for _, f := range fields[2:] {
+		if strings.HasPrefix(f, "~") || strings.HasPrefix(f, "%") || f == "allkeys" {
+			patterns = append(patterns, f)
+		}
+	}
+	if len(patterns) != 1 || patterns[0] != "~"+redisPrefix(id)+"*" {
+		return ""
+	}
+	return id
+}
+
 // HealthCheck verifies Redis connectivity
 func (p *RedisProvider) HealthCheck(ctx context.Context) error {
 	return p.client.Ping(ctx).Err()
 }
 
+// CheckCredentials connects with the instance credentials and verifies the
+// namespace marker written during provisioning is still present
+func (p *RedisProvider) CheckCredentials(ctx context.Context, creds *models.ServiceCredentials) error {
+	if creds == nil || creds.Prefix == "" {
+		return fmt.Errorf("no credentials")
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:     fmt.Sprintf("%s:%d", creds.Host, creds.Port),
+		Username: creds.Username,
+		Password: creds.Password,
+		DB:       0,
+	})
+	defer client.Close()
+
+	n, err := client.Exists(ctx, fmt.Sprintf("%s__provisioned__", creds.Prefix)).Result()
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("namespace %s is not provisioned", creds.Prefix)
+	}
+
+	return nil
+}
+
+// Seed runs the commands of a seed, one per line, with the instance
+// credentials. {prefix} is replaced by the instance key prefix, which under
+// ACLs is the only key pattern the commands may touch.
+func (p *RedisProvider) Seed(ctx context.Context, creds *models.ServiceCredentials, r io.Reader) error {
+	if creds == nil || creds.Prefix == "" {
+		return fmt.Errorf("no credentials")
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:     fmt.Sprintf("%s:%d", creds.Host, creds.Port),
+		Username: creds.Username,
+		Password: creds.Password,
+		DB:       0,
+	})
+	defer client.Close()
+
+	return readSeedCommands(r, creds.Prefix, func(line int, args []any) error {
+		// A nil reply, as from reading a missing key, is no failure
+		if err := client.Do(ctx, args...).Err(); err != nil && err != redis.Nil {
+			return err
+		}
+		return nil
+	})
+}
+
+// redisPrefix is the key prefix of a sandbox. It folds case and -/_ like
+// NormalizeSandboxID, so either form of an ID names the same keys.
+func redisPrefix(sandboxID string) string {
+	return fmt.Sprintf("sandbox:%s:", redisName(sandboxID))
+}
+
+// redisUser is the ACL user of a sandbox
+func redisUser(sandboxID string) string {
+	return fmt.Sprintf("sandbox_%s", redisName(sandboxID))
+}
+
+// redisName folds a sandbox ID for key prefixes and user names
+func redisName(sandboxID string) string {
+	return strings.ToLower(strings.ReplaceAll(sandboxID, "-", "_"))
+}
+
+// infoField returns a field of an INFO reply
+func infoField(info, field string) string {
+	for _, line := range strings.Split(info, "\n") {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(line), field+":"); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// versionAtLeast reports whether a major.minor[.patch] version is at least
+// major.minor
+func versionAtLeast(version string, major, minor int) bool {
+	var gotMajor, gotMinor int
+	if _, err := fmt.Sscanf(version, "%d.%d", &gotMajor, &gotMinor); err != nil {
+		return false
+	}
+	return gotMajor > major || gotMajor == major && gotMinor >= minor
+}
+
 // Close closes the Redis connection
 func (p *RedisProvider) Close() error {
 	return p.client.Close()
@@ -0,0 +1,171 @@
+//go:build integration
+
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+// newTestRedisProvider connects to the Redis of TEST_REDIS_ADDRESS, as
+// started by make services-up, or skips the test
+func newTestRedisProvider(t *testing.T) *RedisProvider {
+	t.Helper()
+	address := os.Getenv("TEST_REDIS_ADDRESS")
+	if address == "" {
+		t.Skip("TEST_REDIS_ADDRESS not set")
+	}
+	p, err := NewRedisProvider(address, os.Getenv("TEST_REDIS_PASSWORD"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+// sandboxClient connects with the credentials of a sandbox
+func sandboxClient(t *testing.T, creds *models.ServiceCredentials) *redis.Client {
+	t.Helper()
+	client := redis.NewClient(&redis.Options{
+		Addr:     fmt.Sprintf("%s:%d", creds.Host, creds.Port),
+		Username: creds.Username,
+		Password: creds.Password,
+	})
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestRedisACLIsolation(t *testing.T) {
+	p := newTestRedisProvider(t)
+	if !p.acl {
+		t.Skip("redis has no ACL support")
+	}
+	ctx := context.Background()
+
+	a, err := p.Provision(ctx, "acl-a", "redis", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Deprovision(context.Background(), "acl-a", "redis") })
+	b, err := p.Provision(ctx, "acl-b", "redis", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Deprovision(context.Background(), "acl-b", "redis") })
+
+	if a.Username != "sandbox_acl_a" || a.Password == p.password || !strings.HasPrefix(a.URI, "redis://sandbox_acl_a:") {
+		t.Fatalf("expected a dedicated user, got %+v", a.Redacted())
+	}
+	if err := p.CheckCredentials(ctx, a); err != nil {
+		t.Fatalf("check credentials: %v", err)
+	}
+
+	clientA := sandboxClient(t, a)
+	if err := clientA.Set(ctx, a.Prefix+"k", "a", 0).Err(); err != nil {
+		t.Fatalf("own key: %v", err)
+	}
+	if err := sandboxClient(t, b).Set(ctx, b.Prefix+"k", "b", 0).Err(); err != nil {
+		t.Fatalf("own key: %v", err)
+	}
+	if err := clientA.Get(ctx, b.Prefix+"k").Err(); err == nil || !strings.Contains(err.Error(), "NOPERM") {
+		t.Errorf("expected NOPERM reading another sandbox's key, got %v", err)
+	}
+	if err := clientA.Del(ctx, b.Prefix+"k").Err(); err == nil || !strings.Contains(err.Error(), "NOPERM") {
+		t.Errorf("expected NOPERM deleting another sandbox's key, got %v", err)
+	}
+	if err := clientA.FlushAll(ctx).Err(); err == nil || !strings.Contains(err.Error(), "NOPERM") {
+		t.Errorf("expected NOPERM flushing, got %v", err)
+	}
+	if err := clientA.Publish(ctx, b.Prefix+"events", "x").Err(); err == nil || !strings.Contains(err.Error(), "NOPERM") {
+		t.Errorf("expected NOPERM publishing to another sandbox's channel, got %v", err)
+	}
+
+	if err := p.Deprovision(ctx, "acl-a", "redis"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sandboxClient(t, a).Ping(ctx).Err(); err == nil {
+		t.Error("expected the deleted user unable to connect")
+	}
+	if n, err := p.client.Exists(ctx, a.Prefix+"k").Result(); err != nil || n != 0 {
+		t.Errorf("expected the keys deleted, got %d, %v", n, err)
+	}
+	if n, err := p.client.Exists(ctx, b.Prefix+"k").Result(); err != nil || n != 1 {
+		t.Errorf("expected the other sandbox's keys kept, got %d, %v", n, err)
+	}
+	if err := p.Deprovision(ctx, "acl-a", "redis"); err != nil {
+		t.Errorf("expected deprovision to be idempotent, got %v", err)
+	}
+}
+
+func TestRedisPrefixFallback(t *testing.T) {
+	p := newTestRedisProvider(t)
+	// The behavior of a server without ACLs
+	p.acl, p.aclChannels = false, false
+	ctx := context.Background()
+
+	creds, err := p.Provision(ctx, "prefix-a", "redis", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Deprovision(context.Background(), "prefix-a", "redis") })
+	if creds.Username != "" || creds.Password != p.password || creds.Prefix != "sandbox:prefix_a:" {
+		t.Fatalf("expected the shared password and a prefix, got %+v", creds.Redacted())
+	}
+	if err := p.CheckCredentials(ctx, creds); err != nil {
+		t.Fatalf("check credentials: %v", err)
+	}
+
+	if err := sandboxClient(t, creds).Set(ctx, creds.Prefix+"k", "v", 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Deprovision(ctx, "prefix-a", "redis"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := p.client.Exists(ctx, creds.Prefix+"k", creds.Prefix+"__provisioned__").Result(); err != nil || n != 0 {
+		t.Errorf("expected the keys deleted, got %d, %v", n, err)
+	}
+}
+
+func TestRedisRotateCredentials(t *testing.T) {
+	p := newTestRedisProvider(t)
+	if !p.acl {
+		t.Skip("redis has no ACL support")
+	}
+	ctx := context.Background()
+
+	old, err := p.Provision(ctx, "rotate-a", "redis", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Deprovision(context.Background(), "rotate-a", "redis") })
+	if err := sandboxClient(t, old).Set(ctx, old.Prefix+"k", "v", 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	creds, err := p.RotateCredentials(ctx, "rotate-a", "redis", old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.Password == old.Password || creds.Username != old.Username || creds.Prefix != old.Prefix {
+		t.Fatalf("expected a new password for the same user, got %+v", creds.Redacted())
+	}
+	if err := sandboxClient(t, old).Ping(ctx).Err(); err == nil {
+		t.Error("expected the old password rejected")
+	}
+	if v, err := sandboxClient(t, creds).Get(ctx, creds.Prefix+"k").Result(); err != nil || v != "v" {
+		t.Errorf("expected the keys kept and reachable, got %q, %v", v, err)
+	}
+
+	p.acl = false
+	if _, err := p.RotateCredentials(ctx, "rotate-a", "redis", creds); !errors.Is(err, ErrRotationUnsupported) {
+		t.Errorf("expected ErrRotationUnsupported without ACLs, got %v", err)
+	}
+}
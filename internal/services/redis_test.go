@@ -0,0 +1,35 @@
+package services
+
+import "testing"
+
+func TestRedisNamesFoldCase(t *testing.T) {
+	if got := redisPrefix("Ab-CD_1"); got != "sandbox:ab_cd_1:" {
+		t.Errorf("unexpected prefix %q", got)
+	}
+	if redisPrefix("AB-cd") != redisPrefix(NormalizeSandboxID("AB-cd")) {
+		t.Error("normalized ID names other keys")
+	}
+	if got := redisUser("Ab-CD"); got != "sandbox_ab_cd" {
+		t.Errorf("unexpected user %q", got)
+	}
+}
+
+func TestACLUserSandbox(t *testing.T) {
+	tests := []struct{ rule, want string }{
+		{"user sandbox_ab_cd on #5e88 ~sandbox:ab_cd:* resetchannels &sandbox:ab_cd:* -@all +@all -@admin -@dangerous", "ab_cd"},
+		{"user sandbox_x on #5e88 ~sandbox:x:* resetchannels -@all +@all -@pubsub", "x"},
+		{"user default on nopass ~* &* +@all", ""},
+		{"user sandbox_x on #5e88 ~* +@all", ""},
+		{"user sandbox_x on #5e88 ~sandbox:y:* +@all", ""},
+		{"user sandbox_x on #5e88 ~sandbox:x:* ~other:* +@all", ""},
+		{"user sandbox_x on #5e88 ~sandbox:x:* %R~other:* +@all", ""},
+		{"user sandbox_x on #5e88 allkeys +@all", ""},
+		{"user sandbox_ on #5e88 ~sandbox::* +@all", ""},
+		{"user app on #5e88 ~sandbox:app:* +@all", ""},
+	}
+	for _, tt := range tests {
+		if got := aclUserSandbox(tt.rule); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.rule, got, tt.want)
+		}
+	}
+}
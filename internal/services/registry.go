@@ -2,7 +2,9 @@ package services
 
 import (
 	"context"
+	"fmt"
 	"sync"
+	"time"
 )
 
 // Registry manages service providers
@@ -44,15 +46,48 @@ func (r *Registry) List() []string {
 	return names
 }
 
-// HealthCheckAll checks health of all registered providers
-func (r *Registry) HealthCheckAll(ctx context.Context) map[string]error {
+// ValidateOptions checks the provisioning options of provider name with its
+// OptionValidator. An unregistered provider accepts anything, as there is
+// nothing to check against.
+func (r *Registry) ValidateOptions(name string, opts map[string]string) error {
+	provider := r.Get(name)
+	if provider == nil || len(opts) == 0 {
+		return nil
+	}
+	v, ok := provider.(OptionValidator)
+	if !ok {
+		return fmt.Errorf("%s takes no options", name)
+	}
+	return v.ValidateOptions(opts)
+}
+
+// HealthResult is the outcome of one provider health check
+type HealthResult struct {
+	Err     error
+	Latency time.Duration
+}
+
+// HealthCheckAll checks health of all registered providers in parallel, so
+// the slowest provider bounds the call
+func (r *Registry) HealthCheckAll(ctx context.Context) map[string]HealthResult {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	results := make(map[string]error)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]HealthResult, len(r.providers))
 	for name, provider := range r.providers {
-		results[name] = provider.HealthCheck(ctx)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			err := provider.HealthCheck(ctx)
+			mu.Lock()
+			results[name] = HealthResult{Err: err, Latency: time.Since(start)}
+			mu.Unlock()
+		}()
 	}
+	wg.Wait()
 	return results
 }
 
@@ -0,0 +1,31 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegistryValidateOptions(t *testing.T) {
+	r := NewRegistry()
+	r.Register("postgres", &PostgresProvider{BaseProvider: BaseProvider{serviceType: "postgres"}, allowedExtensions: []string{"pg_trgm"}})
+	r.Register("redis", &RedisProvider{BaseProvider: BaseProvider{serviceType: "redis"}})
+
+	tests := []struct {
+		service string
+		opts    map[string]string
+		want    string // empty for valid
+	}{
+		{"postgres", map[string]string{"extensions": "pg_trgm", "schema": "app"}, ""},
+		{"postgres", map[string]string{"quota": "1G"}, `unknown postgres option "quota"`},
+		{"postgres", map[string]string{"extensions": "dblink"}, `extension "dblink" is not allowed`},
+		{"redis", nil, ""},
+		{"redis", map[string]string{"maxmemory-policy": "allkeys-lru"}, "redis takes no options"},
+		{"kafka", map[string]string{"x": "y"}, ""}, // reported as an unknown service instead
+	}
+	for _, tt := range tests {
+		err := r.ValidateOptions(tt.service, tt.opts)
+		if tt.want == "" && err != nil || tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("%s %v: expected %q, got %v", tt.service, tt.opts, tt.want, err)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+// Seeder is implemented by providers that can load a fixture into a
+// provisioned instance, read from r as it is applied
+type Seeder interface {
+	// Seed applies the fixture with the instance credentials
+	Seed(ctx context.Context, creds *models.ServiceCredentials, r io.Reader) error
+}
+
+// SeedPrefix is replaced by the instance key prefix in Redis seeds, so
+// fixtures write keys the sandbox can reach
+const SeedPrefix = "{prefix}"
+
+// maxSeedLine bounds a single Redis seed command
+const maxSeedLine = 16 << 20
+
+// copyFromStdinPattern matches a COPY reading its rows from the client
+var copyFromStdinPattern = regexp.MustCompile(`(?is)^copy\s.*\sfrom\s+stdin\b`)
+
+// copiesFromStdin reports whether a statement, as sqlScanner returns it, is a
+// COPY ... FROM stdin. Its rows follow in the script, which only psql reads.
+func copiesFromStdin(stmt string) bool {
+	for {
+		stmt = strings.TrimSpace(stmt)
+		switch {
+		case strings.HasPrefix(stmt, "--"):
+			_, rest, ok := strings.Cut(stmt, "\n")
+			if !ok {
+				return false
+			}
+			stmt = rest
+		case strings.HasPrefix(stmt, "/*"):
+			// Block comments nest
+			depth, i := 0, 0
+			for ; i < len(stmt)-1; i++ {
+				switch stmt[i : i+2] {
+				case "/*":
+					depth++
+					i++
+				case "*/":
+					depth--
+					i++
+				}
+				if depth == 0 {
+					break
+				}
+			}
+			if depth > 0 {
+				return false
+			}
+			stmt = stmt[i+1:]
+		default:
+			return copyFromStdinPattern.MatchString(stmt)
+		}
+	}
+}
+
+// sqlScanner splits a SQL script into statements at top-level semicolons,
+// reading it as it goes. Quoted strings and identifiers, dollar-quoted
+// bodies and comments are kept whole.
+type sqlScanner struct {
+	r    *bufio.Reader
+	line int // line the last statement starts on
+	next int // line the current statement's text starts on
+}
+
+func newSQLScanner(r io.Reader) *sqlScanner {
+	return &sqlScanner{r: bufio.NewReader(r), next: 1}
+}
+
+// Next returns the next statement without its semicolon, or io.EOF.
+// Statements of only comments are skipped.
+func (s *sqlScanner) Next() (string, error) {
+	var stmt strings.Builder
+	var (
+		quote       rune   // ' or " while in a quoted string or identifier
+		escapes     bool   // in an E'...' string, where \ escapes
+		dollar      string // closing tag while in a dollar-quoted body
+		bodyStart   int    // where the dollar-quoted body starts
+		lineComment bool
+		blockDepth  int
+		hasCode     bool
+		prev        rune // 0 after a character that completed a pair
+	)
+	s.line = 0
+
+	for {
+		c, _, err := s.r.ReadRune()
+		if errors.Is(err, io.EOF) {
+			if quote != 0 || dollar != "" || blockDepth > 0 {
+				return "", fmt.Errorf("line %d: unterminated string or comment", s.line)
+			}
+			if hasCode {
+				return strings.TrimSpace(stmt.String()), nil
+			}
+			return "", io.EOF
+		}
+		if err != nil {
+			return "", err
+		}
+
+		inCode := quote == 0 && dollar == "" && !lineComment && blockDepth == 0
+		if inCode && c == ';' {
+			if hasCode {
+				s.next += strings.Count(stmt.String(), "\n")
+				return strings.TrimSpace(stmt.String()), nil
+			}
+			s.next += strings.Count(stmt.String(), "\n")
+			stmt.Reset()
+			prev = 0
+			continue
+		}
+		stmt.WriteRune(c)
+
+		completed := false
+		switch {
+		case lineComment:
+			lineComment = c != '\n'
+		case blockDepth > 0:
+			if prev == '*' && c == '/' {
+				blockDepth--
+				completed = true
+			} else if prev == '/' && c == '*' {
+				blockDepth++
+				completed = true
+			}
+		case quote != 0:
+			if escapes && prev == '\\' {
+				completed = true // an escaped character never closes the string
+			} else if c == quote {
+				quote = 0
+			}
+		case dollar != "":
+			if c == '$' && stmt.Len()-len(dollar) >= bodyStart && strings.HasSuffix(stmt.String(), dollar) {
+				dollar = ""
+			}
+		case c == '-' && prev == '-':
+			lineComment = true
+			completed = true
+		case c == '*' && prev == '/':
+			blockDepth = 1
+			completed = true
+		default:
+			if !hasCode && !isSpace(c) && c != '-' && c != '/' {
+				hasCode = true
+				s.line = s.next + strings.Count(stmt.String(), "\n")
+			}
+			switch c {
+			case '\'', '"':
+				quote = c
+				escapes = c == '\'' && (prev == 'E' || prev == 'e')
+			case '$':
+				if tag, ok := openingDollarTag(stmt.String()); ok {
+					dollar, bodyStart = tag, stmt.Len()
+					completed = true
+				}
+			}
+		}
+		if completed {
+			prev = 0
+		} else {
+			prev = c
+		}
+	}
+}
+
+// openingDollarTag reports whether text ends with a dollar quote opening,
+// $$ or $tag$, and returns it
+func openingDollarTag(text string) (string, bool) {
+	end := len(text) - 1
+	start := strings.LastIndexByte(text[:end], '$')
+	if start < 0 {
+		return "", false
+	}
+	tag := text[start+1 : end]
+	for i, r := range tag {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || i > 0 && r >= '0' && r <= '9') {
+			return "", false
+		}
+	}
+	// A tag directly after an identifier character is part of that identifier
+	if start > 0 && isIdentChar(rune(text[start-1])) {
+		return "", false
+	}
+	return text[start:], true
+}
+
+func isIdentChar(r rune) bool {
+	return r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9'
+}
+
+func isSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\r'
+}
+
+// readSeedCommands calls fn with the arguments of each command of a Redis
+// seed, one redis-cli style command per line, with SeedPrefix replaced by
+// prefix. Blank lines and lines starting with # are skipped.
+func readSeedCommands(r io.Reader, prefix string, fn func(line int, args []any) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), maxSeedLine)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		words, err := splitCommandLine(text)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		args := make([]any, len(words))
+		for i, w := range words {
+			args[i] = strings.ReplaceAll(w, SeedPrefix, prefix)
+		}
+		if err := fn(line, args); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// splitCommandLine splits a command line into words like redis-cli: double
+// quotes take \ escapes (\n, \t, \", \\), single quotes are literal
+func splitCommandLine(text string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '"':
+			inWord = true
+			for i++; ; i++ {
+				if i >= len(text) {
+					return nil, errors.New("unbalanced quotes")
+				}
+				if text[i] == '"' {
+					break
+				}
+				if text[i] == '\\' && i+1 < len(text) {
+					i++
+					switch text[i] {
+					case 'n':
+						word.WriteByte('\n')
+					case 't':
+						word.WriteByte('\t')
+					case 'r':
+						word.WriteByte('\r')
+					default:
+						word.WriteByte(text[i])
+					}
+					continue
+				}
+				word.WriteByte(text[i])
+			}
+		case c == '\'':
+			inWord = true
+			end := strings.IndexByte(text[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.New("unbalanced quotes")
+			}
+			word.WriteString(text[i+1 : i+1+end])
+			i += end + 1
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			inWord = true
+			word.WriteByte(c)
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// EncodeRedisSeed writes the commands of a Redis seed to w in the Redis
+// protocol, as redis-cli --pipe reads them, with SeedPrefix replaced by
+// prefix. The seed is read and written a command at a time.
+func EncodeRedisSeed(w io.Writer, r io.Reader, prefix string) error {
+	bw := bufio.NewWriter(w)
+	err := readSeedCommands(r, prefix, func(_ int, args []any) error {
+		fmt.Fprintf(bw, "*%d\r\n", len(args))
+		for _, arg := range args {
+			s := arg.(string)
+			fmt.Fprintf(bw, "$%d\r\n%s\r\n", len(s), s)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSQLScanner(t *testing.T) {
+	script := `-- schema
+CREATE TABLE t (id int, note text);
+
+INSERT INTO t VALUES (1, 'semi; colon'), (2, 'it''s');
+INSERT INTO t VALUES (3, E'esc\'; aped');
+/* block; /* nested; */ still */ SELECT "odd;name" FROM t;
+CREATE FUNCTION f() RETURNS int AS $$ SELECT 1; $$ LANGUAGE sql;
+CREATE FUNCTION g() RETURNS int AS $body$ SELECT $$;$$; $body$ LANGUAGE sql;
+SELECT $1, a$b$c FROM t;
+-- trailing comment only;
+SELECT 'last'`
+
+	want := []struct {
+		line int
+		stmt string
+	}{
+		{2, "-- schema\nCREATE TABLE t (id int, note text)"},
+		{4, "INSERT INTO t VALUES (1, 'semi; colon'), (2, 'it''s')"},
+		{5, `INSERT INTO t VALUES (3, E'esc\'; aped')`},
+		{6, `/* block; /* nested; */ still */ SELECT "odd;name" FROM t`},
+		{7, "CREATE FUNCTION f() RETURNS int AS $$ SELECT 1; $$ LANGUAGE sql"},
+		{8, "CREATE FUNCTION g() RETURNS int AS $body$ SELECT $$;$$; $body$ LANGUAGE sql"},
+		{9, "SELECT $1, a$b$c FROM t"},
+		{11, "-- trailing comment only;\nSELECT 'last'"},
+	}
+
+	s := newSQLScanner(strings.NewReader(script))
+	for i, w := range want {
+		stmt, err := s.Next()
+		if err != nil {
+			t.Fatalf("statement %d: %v", i+1, err)
+		}
+		if stmt != w.stmt || s.line != w.line {
+			t.Errorf("statement %d: expected %q on line %d, got %q on line %d", i+1, w.stmt, w.line, stmt, s.line)
+		}
+	}
+	if stmt, err := s.Next(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %q, %v", stmt, err)
+	}
+
+	for _, bad := range []string{"SELECT 'open", "SELECT $$ open", "/* open"} {
+		if _, err := newSQLScanner(strings.NewReader(bad)).Next(); err == nil || err == io.EOF {
+			t.Errorf("%q: expected an unterminated error, got %v", bad, err)
+		}
+	}
+}
+
+func TestCopiesFromStdin(t *testing.T) {
+	tests := []struct {
+		stmt string
+		want bool
+	}{
+		{"COPY orders (id, note) FROM stdin", true},
+		{"copy orders from STDIN with (format csv)", true},
+		{"-- rows\n/* a /* nested */ comment */\nCOPY orders\nFROM\tstdin", true},
+		{"COPY orders TO stdout", false},
+		{"COPY orders FROM '/tmp/orders.csv'", false},
+		{"SELECT 'COPY t FROM stdin'", false},
+		{"/* COPY t FROM stdin */ SELECT 1", false},
+		{"-- COPY t FROM stdin", false},
+	}
+	for _, tt := range tests {
+		if got := copiesFromStdin(tt.stmt); got != tt.want {
+			t.Errorf("%q: got %v, want %v", tt.stmt, got, tt.want)
+		}
+	}
+}
+
+func TestReadSeedCommands(t *testing.T) {
+	seed := `# fixtures
+SET {prefix}greeting "hello world"
+
+HSET {prefix}user:1 name 'O"Brien' bio "line\nbreak \"quoted\""
+RPUSH {prefix}queue a b`
+
+	var got [][]any
+	var lines []int
+	err := readSeedCommands(strings.NewReader(seed), "sandbox:x:", func(line int, args []any) error {
+		lines = append(lines, line)
+		got = append(got, args)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]any{
+		{"SET", "sandbox:x:greeting", "hello world"},
+		{"HSET", "sandbox:x:user:1", "name", `O"Brien`, "bio", "line\nbreak \"quoted\""},
+		{"RPUSH", "sandbox:x:queue", "a", "b"},
+	}
+	if !reflect.DeepEqual(got, want) || !reflect.DeepEqual(lines, []int{2, 4, 5}) {
+		t.Errorf("expected %q on lines 2, 4, 5, got %q on %v", want, got, lines)
+	}
+
+	err = readSeedCommands(strings.NewReader("SET a b\nSET \"open"), "", func(int, []any) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected an error on line 2, got %v", err)
+	}
+}
+
+func TestEncodeRedisSeed(t *testing.T) {
+	var buf strings.Builder
+	if err := EncodeRedisSeed(&buf, strings.NewReader("SET {prefix}k \"a b\"\n\nDEL {prefix}k"), ""); err != nil {
+		t.Fatal(err)
+	}
+	want := "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$3\r\na b\r\n*2\r\n$3\r\nDEL\r\n$1\r\nk\r\n"
+	if buf.String() != want {
+		t.Errorf("expected %q, got %q", want, buf.String())
+	}
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+func TestSandboxFilterWhere(t *testing.T) {
+	after := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	before := after.Add(24 * time.Hour)
+
+	where, args := sandboxFilterWhere(models.ListFilters{
+		TemplateID:    "python",
+		CreatedAfter:  after,
+		CreatedBefore: before,
+		Metadata:      map[string]string{"cohort": "backend-2024"},
+		Limit:         10, // pagination is left to the caller
+	})
+
+	want := ` WHERE 1=1 AND deleted_at IS NULL AND template_id = $1 AND created_at >= $2 AND created_at < $3 AND metadata @> $4::jsonb`
+	if where != want {
+		t.Errorf("unexpected WHERE clause:\n got %s\nwant %s", where, want)
+	}
+	if len(args) != 4 || args[1] != after || args[2] != before || args[3] != `{"cohort":"backend-2024"}` {
+		t.Errorf("unexpected args %v", args)
+	}
+
+	if where, args := sandboxFilterWhere(models.ListFilters{IncludeDeleted: true}); where != ` WHERE 1=1` || len(args) != 0 {
+		t.Errorf("expected no conditions without filters, got %q %v", where, args)
+	}
+}
+
+func TestSessionFilterWhere(t *testing.T) {
+	after := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	where, args := sessionFilterWhere(models.SessionFilters{
+		Status:       "active",
+		TemplateID:   "python",
+		CreatedBy:    "recruiting",
+		CreatedAfter: after,
+	})
+	want := ` WHERE 1=1 AND status = $1 AND template_id = $2 AND created_by = $3 AND created_at >= $4`
+	if where != want {
+		t.Errorf("unexpected WHERE clause:\n got %s\nwant %s", where, want)
+	}
+	if len(args) != 4 || args[2] != "recruiting" || args[3] != after {
+		t.Errorf("unexpected args %v", args)
+	}
+
+	// The missing-template filter takes two arguments, and later ones follow them
+	where, args = sessionFilterWhere(models.SessionFilters{Status: models.SessionFilterInvalidTemplate, KnownTemplates: []string{"go"}, CreatedBy: "recruiting"})
+	want = ` WHERE 1=1 AND ((status = 'ready' AND NOT (template_id = ANY($1))) OR (status = 'failed' AND status_message LIKE $2 || '%')) AND created_by = $3`
+	if where != want || len(args) != 3 {
+		t.Errorf("unexpected missing-template clause:\n got %s %v\nwant %s", where, args, want)
+	}
+}
+
+func TestSandboxOrderBy(t *testing.T) {
+	for _, tc := range []struct {
+		filters models.ListFilters
+		want    string
+	}{
+		{models.ListFilters{}, " ORDER BY created_at DESC, id ASC"},
+		{models.ListFilters{Sort: models.SortCreatedAt, Ascending: true}, " ORDER BY created_at ASC, id ASC"},
+		{models.ListFilters{Sort: models.SortExpiresAt, Ascending: true}, " ORDER BY expires_at ASC, created_at DESC, id ASC"},
+		{models.ListFilters{Sort: models.SortStatus}, " ORDER BY status DESC, created_at DESC, id ASC"},
+		// Unknown fields never reach the SQL
+		{models.ListFilters{Sort: "id; DROP TABLE sandboxes"}, " ORDER BY created_at DESC, id ASC"},
+	} {
+		if got := sandboxOrderBy(tc.filters); got != tc.want {
+			t.Errorf("sort %q: expected %q, got %q", tc.filters.Sort, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,1730 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+// MemoryRepository implements Repository in memory, for tests of the manager
+// and handlers. It follows the PostgreSQL implementation: missing rows are
+// nil, nil on reads and errors on updates and deletes, filters, ordering and
+// pagination match the SQL, and callers get copies, never the stored records.
+type MemoryRepository struct {
+	mu    sync.Mutex
+	state memoryState
+
+	// txMu serializes WithTx callbacks
+	txMu sync.Mutex
+}
+
+// memoryState holds the tables. Stored records are replaced, never modified in
+// place, so a shallow copy of the maps is a consistent snapshot.
+type memoryState struct {
+	sandboxes     map[string]*models.Sandbox
+	usageRecorded map[string]bool
+	services      map[string]map[string]*models.ServiceInstance // sandbox ID -> name -> service
+	hosts         map[string]*models.HostState
+	events        []*models.SandboxEvent
+	lastEventID   int64
+	usage         map[usageKey]*models.TemplateUsage
+	sessions      map[string]*models.Session
+	connections   []*models.SessionConnection
+	lastConnID    int64
+	deliveries    []*models.CallbackDelivery
+	lastDelivID   int64
+	recordings    []*models.TerminalRecording
+	lastRecID     int64
+	grading       []*models.GradingResult
+	lastGradeID   int64
+	clients       map[string]*models.ApiClient // by API key
+	jwtClients    map[string]string            // API key by JWT subject
+	lastClientID  int
+	templates     map[string]*models.TemplateDocument
+}
+
+// usageKey is the primary key of template_usage_daily
+type usageKey struct {
+	templateID, taskID, day string
+}
+
+// NewMemoryRepository creates an empty in-memory repository
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{state: memoryState{
+		sandboxes:     make(map[string]*models.Sandbox),
+		usageRecorded: make(map[string]bool),
+		services:      make(map[string]map[string]*models.ServiceInstance),
+		hosts:         make(map[string]*models.HostState),
+		usage:         make(map[usageKey]*models.TemplateUsage),
+		sessions:      make(map[string]*models.Session),
+		clients:       make(map[string]*models.ApiClient),
+		jwtClients:    make(map[string]string),
+		templates:     make(map[string]*models.TemplateDocument),
+	}}
+}
+
+// snapshot copies the state for a rollback
+func (s memoryState) snapshot() memoryState {
+	snap := s
+	snap.sandboxes = maps.Clone(s.sandboxes)
+	snap.usageRecorded = maps.Clone(s.usageRecorded)
+	snap.services = make(map[string]map[string]*models.ServiceInstance, len(s.services))
+	for id, services := range s.services {
+		snap.services[id] = maps.Clone(services)
+	}
+	snap.hosts = maps.Clone(s.hosts)
+	snap.events = slices.Clone(s.events)
+	snap.usage = maps.Clone(s.usage)
+	snap.sessions = maps.Clone(s.sessions)
+	snap.connections = slices.Clone(s.connections)
+	snap.deliveries = slices.Clone(s.deliveries)
+	snap.recordings = slices.Clone(s.recordings)
+	snap.grading = slices.Clone(s.grading)
+	snap.clients = maps.Clone(s.clients)
+	snap.jwtClients = maps.Clone(s.jwtClients)
+	snap.templates = maps.Clone(s.templates)
+	return snap
+}
+
+// WithTx runs fn with a repository over the same records and restores the
+// records it had before fn when fn fails. Transactions run one at a time;
+// writes made outside them meanwhile are rolled back too, which tests don't rely on.
+func (r *MemoryRepository) WithTx(ctx context.Context, fn func(Repository) error) error {
+	r.txMu.Lock()
+	defer r.txMu.Unlock()
+
+	r.mu.Lock()
+	snap := r.state.snapshot()
+	r.mu.Unlock()
+
+	if err := fn(memoryTx{r}); err != nil {
+		r.mu.Lock()
+		r.state = snap
+		r.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// memoryTx is the repository handed to WithTx callbacks
+type memoryTx struct {
+	*MemoryRepository
+}
+
+// WithTx joins the transaction already running
+func (tx memoryTx) WithTx(ctx context.Context, fn func(Repository) error) error {
+	return fn(tx)
+}
+
+// Ping always succeeds
+func (r *MemoryRepository) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Close is a no-op
+func (r *MemoryRepository) Close() error {
+	return nil
+}
+
+// --- Copies ---
+
+func cloneTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	return &c
+}
+
+// cloneSandbox copies sb without its services, which are stored separately
+func cloneSandbox(sb *models.Sandbox) *models.Sandbox {
+	c := *sb
+	c.StartedAt = cloneTime(sb.StartedAt)
+	c.LastActivityAt = cloneTime(sb.LastActivityAt)
+	c.DeletedAt = cloneTime(sb.DeletedAt)
+	c.Metadata = maps.Clone(sb.Metadata)
+	c.Endpoints = maps.Clone(sb.Endpoints)
+	c.Services = nil
+	return &c
+}
+
+func cloneService(svc *models.ServiceInstance) *models.ServiceInstance {
+	c := *svc
+	if svc.Credentials != nil {
+		creds := *svc.Credentials
+		c.Credentials = &creds
+	}
+	c.LastCheckedAt = cloneTime(svc.LastCheckedAt)
+	return &c
+}
+
+func cloneSession(s *models.Session) *models.Session {
+	c := *s
+	c.Env = maps.Clone(s.Env)
+	c.Metadata = maps.Clone(s.Metadata)
+	c.Services = slices.Clone(s.Services)
+	c.ActivatedAt = cloneTime(s.ActivatedAt)
+	c.ExpiresAt = cloneTime(s.ExpiresAt)
+	c.RevokedAt = cloneTime(s.RevokedAt)
+	c.JoinBy = cloneTime(s.JoinBy)
+	c.SubmittedAt = cloneTime(s.SubmittedAt)
+	c.PrewarmAt = cloneTime(s.PrewarmAt)
+	return &c
+}
+
+func cloneConnection(c *models.SessionConnection) *models.SessionConnection {
+	cc := *c
+	cc.DisconnectedAt = cloneTime(c.DisconnectedAt)
+	return &cc
+}
+
+func cloneRecording(rec *models.TerminalRecording) *models.TerminalRecording {
+	c := *rec
+	c.EndedAt = cloneTime(rec.EndedAt)
+	return &c
+}
+
+func cloneGradingResult(res *models.GradingResult) *models.GradingResult {
+	c := *res
+	if res.JUnit != nil {
+		junit := *res.JUnit
+		junit.Cases = slices.Clone(res.JUnit.Cases)
+		c.JUnit = &junit
+	}
+	return &c
+}
+
+func cloneClient(c *models.ApiClient) *models.ApiClient {
+	cc := *c
+	cc.LastUsedAt = cloneTime(c.LastUsedAt)
+	cc.Permissions = slices.Clone(c.Permissions)
+	cc.Metadata = maps.Clone(c.Metadata)
+	cc.ExpiresAt = cloneTime(c.ExpiresAt)
+	cc.AllowedCIDRs = slices.Clone(c.AllowedCIDRs)
+	return &cc
+}
+
+// paginate applies LIMIT and OFFSET
+func paginate[T any](items []T, limit, offset int) []T {
+	if offset > 0 {
+		if offset >= len(items) {
+			return nil
+		}
+		items = items[offset:]
+	}
+	if limit > 0 && limit < len(items) {
+		items = items[:limit]
+	}
+	return items
+}
+
+// holdsResources is the status NOT IN ('failed', 'expired', 'deleting') AND deleted_at IS NULL condition
+func holdsResources(sb *models.Sandbox) bool {
+	return sb.Status != models.StatusFailed && sb.Status != models.StatusExpired && sb.Status != models.StatusDeleting && sb.DeletedAt == nil
+}
+
+// --- Sandboxes ---
+
+// CreateSandbox creates a new sandbox record
+func (r *MemoryRepository) CreateSandbox(ctx context.Context, sb *models.Sandbox) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.state.sandboxes[sb.ID]; ok {
+		return fmt.Errorf("failed to create sandbox: duplicate id %s", sb.ID)
+	}
+	r.state.sandboxes[sb.ID] = cloneSandbox(sb)
+	return nil
+}
+
+// withServices copies a stored sandbox with its services attached, like loadServices
+func (r *MemoryRepository) withServices(sb *models.Sandbox) *models.Sandbox {
+	c := cloneSandbox(sb)
+	c.Services = make(map[string]*models.ServiceInstance)
+	for name, svc := range r.state.services[sb.ID] {
+		c.Services[name] = cloneService(svc)
+	}
+	return c
+}
+
+// GetSandbox retrieves a sandbox by ID. Deleted sandboxes are not found.
+func (r *MemoryRepository) GetSandbox(ctx context.Context, id string) (*models.Sandbox, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sb, ok := r.state.sandboxes[id]
+	if !ok || sb.DeletedAt != nil {
+		return nil, nil // Not found
+	}
+	return r.withServices(sb), nil
+}
+
+// UpdateSandbox updates the columns PostgresRepository.UpdateSandbox writes
+func (r *MemoryRepository) UpdateSandbox(ctx context.Context, sb *models.Sandbox) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.state.sandboxes[sb.ID]
+	if !ok || stored.DeletedAt != nil {
+		return fmt.Errorf("sandbox not found: %s", sb.ID)
+	}
+
+	updated := cloneSandbox(stored)
+	updated.Status = sb.Status
+	updated.StatusMsg = sb.StatusMsg
+	updated.ContainerID = sb.ContainerID
+	updated.StartedAt = cloneTime(sb.StartedAt)
+	updated.ExpiresAt = sb.ExpiresAt
+	updated.Metadata = maps.Clone(sb.Metadata)
+	updated.Endpoints = maps.Clone(sb.Endpoints)
+	r.state.sandboxes[sb.ID] = updated
+	return nil
+}
+
+// DeleteSandbox marks a sandbox deleted. hard deletes it instead, and its
+// services with it like the foreign key.
+func (r *MemoryRepository) DeleteSandbox(ctx context.Context, id string, hard bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sb, ok := r.state.sandboxes[id]
+	if !ok || (!hard && sb.DeletedAt != nil) {
+		return fmt.Errorf("sandbox not found: %s", id)
+	}
+	if hard {
+		r.purge(id)
+		return nil
+	}
+
+	updated := cloneSandbox(sb)
+	now := time.Now()
+	updated.DeletedAt = &now
+	r.state.sandboxes[id] = updated
+	return nil
+}
+
+// purge removes a sandbox row and what cascades from it
+func (r *MemoryRepository) purge(id string) {
+	delete(r.state.sandboxes, id)
+	delete(r.state.usageRecorded, id)
+	delete(r.state.services, id)
+}
+
+// PurgeDeletedSandboxes removes the records of sandboxes deleted before cutoff
+func (r *MemoryRepository) PurgeDeletedSandboxes(ctx context.Context, cutoff time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var n int64
+	for id, sb := range r.state.sandboxes {
+		if sb.DeletedAt != nil && sb.DeletedAt.Before(cutoff) {
+			r.purge(id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// matchesFilters is sandboxFilterWhere for one sandbox
+func matchesFilters(sb *models.Sandbox, filters models.ListFilters) bool {
+	if !filters.IncludeDeleted && sb.DeletedAt != nil {
+		return false
+	}
+	if filters.UserID != "" && sb.UserID != filters.UserID {
+		return false
+	}
+	if filters.TemplateID != "" && sb.TemplateID != filters.TemplateID {
+		return false
+	}
+	if filters.Status != "" && sb.Status != filters.Status {
+		return false
+	}
+	if host := cmp.Or(sb.Host, filters.DefaultHost); filters.Host != "" && host != filters.Host {
+		return false
+	}
+	if filters.OwnerClientID != 0 && sb.OwnerClientID != filters.OwnerClientID {
+		return false
+	}
+	if !filters.CreatedAfter.IsZero() && sb.CreatedAt.Before(filters.CreatedAfter) {
+		return false
+	}
+	if !filters.CreatedBefore.IsZero() && !sb.CreatedAt.Before(filters.CreatedBefore) {
+		return false
+	}
+	for key, value := range filters.Metadata {
+		if v, ok := sb.Metadata[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// filterSandboxes returns the stored sandboxes matching filters in sandboxOrderBy order
+func (r *MemoryRepository) filterSandboxes(filters models.ListFilters) []*models.Sandbox {
+	var matched []*models.Sandbox
+	for _, sb := range r.state.sandboxes {
+		if matchesFilters(sb, filters) {
+			matched = append(matched, sb)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+		var c int
+		switch filters.Sort {
+		case models.SortExpiresAt:
+			c = a.ExpiresAt.Compare(b.ExpiresAt)
+		case models.SortStatus:
+			c = strings.Compare(string(a.Status), string(b.Status))
+		case models.SortUserID:
+			c = strings.Compare(a.UserID, b.UserID)
+		default:
+			c = a.CreatedAt.Compare(b.CreatedAt)
+		}
+		if !filters.Ascending {
+			c = -c
+		}
+		if c == 0 && filters.Sort != "" && filters.Sort != models.SortCreatedAt {
+			c = b.CreatedAt.Compare(a.CreatedAt)
+		}
+		if c != 0 {
+			return c < 0
+		}
+		return a.ID < b.ID
+	})
+	return matched
+}
+
+// ListSandboxes returns sandboxes matching filters
+func (r *MemoryRepository) ListSandboxes(ctx context.Context, filters models.ListFilters) ([]*models.Sandbox, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var sandboxes []*models.Sandbox
+	for _, sb := range paginate(r.filterSandboxes(filters), filters.Limit, filters.Offset) {
+		sandboxes = append(sandboxes, r.withServices(sb))
+	}
+	return sandboxes, nil
+}
+
+// CountSandboxes counts sandboxes matching filters, ignoring Limit and Offset
+func (r *MemoryRepository) CountSandboxes(ctx context.Context, filters models.ListFilters) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.filterSandboxes(filters)), nil
+}
+
+// expiredSandboxes returns the sandboxes matching expiredSandboxesWhere, oldest expiry first
+func (r *MemoryRepository) expiredSandboxes() []*models.Sandbox {
+	now := time.Now()
+	var expired []*models.Sandbox
+	for _, sb := range r.state.sandboxes {
+		if holdsResources(sb) && sb.ExpiresAt.Before(now) {
+			expired = append(expired, sb)
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool { return expired[i].ExpiresAt.Before(expired[j].ExpiresAt) })
+	return expired
+}
+
+// GetExpiredSandboxes returns up to limit expired sandboxes, oldest expiry first.
+// Services are not loaded, as in PostgresRepository.
+func (r *MemoryRepository) GetExpiredSandboxes(ctx context.Context, limit int) ([]*models.Sandbox, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var sandboxes []*models.Sandbox
+	for _, sb := range paginate(r.expiredSandboxes(), limit, 0) {
+		sandboxes = append(sandboxes, cloneSandbox(sb))
+	}
+	return sandboxes, nil
+}
+
+// CountExpiredSandboxes counts expired sandboxes not yet cleaned up
+func (r *MemoryRepository) CountExpiredSandboxes(ctx context.Context) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.expiredSandboxes()), nil
+}
+
+// lastActive is COALESCE(last_activity_at, started_at, created_at)
+func lastActive(sb *models.Sandbox) time.Time {
+	if sb.LastActivityAt != nil {
+		return *sb.LastActivityAt
+	}
+	if sb.StartedAt != nil {
+		return *sb.StartedAt
+	}
+	return sb.CreatedAt
+}
+
+// GetIdleSandboxes returns running sandboxes with no terminal activity since the given time
+func (r *MemoryRepository) GetIdleSandboxes(ctx context.Context, idleSince time.Time) ([]*models.Sandbox, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var idle []*models.Sandbox
+	for _, sb := range r.state.sandboxes {
+		if sb.Status == models.StatusRunning && sb.DeletedAt == nil && lastActive(sb).Before(idleSince) {
+			idle = append(idle, sb)
+		}
+	}
+	sort.Slice(idle, func(i, j int) bool { return lastActive(idle[i]).Before(lastActive(idle[j])) })
+
+	var sandboxes []*models.Sandbox
+	for _, sb := range idle {
+		sandboxes = append(sandboxes, r.withServices(sb))
+	}
+	return sandboxes, nil
+}
+
+// TouchSandboxActivity records terminal activity for a sandbox
+func (r *MemoryRepository) TouchSandboxActivity(ctx context.Context, id string, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if sb, ok := r.state.sandboxes[id]; ok && sb.DeletedAt == nil {
+		updated := cloneSandbox(sb)
+		updated.LastActivityAt = &at
+		r.state.sandboxes[id] = updated
+	}
+	return nil
+}
+
+// ExtendSandboxFrom writes the expiry and metadata of sb while the sandbox is
+// running and its stored expiry is still from
+func (r *MemoryRepository) ExtendSandboxFrom(ctx context.Context, sb *models.Sandbox, from time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.state.sandboxes[sb.ID]
+	if !ok || stored.DeletedAt != nil || !stored.Status.IsRunning() || !stored.ExpiresAt.Equal(from) {
+		return false, nil
+	}
+
+	updated := cloneSandbox(stored)
+	updated.ExpiresAt = sb.ExpiresAt
+	updated.Metadata = maps.Clone(sb.Metadata)
+	r.state.sandboxes[sb.ID] = updated
+	return true, nil
+}
+
+// --- Services ---
+
+// CreateService creates a service instance for a sandbox, or updates the
+// status and credentials of an existing one
+func (r *MemoryRepository) CreateService(ctx context.Context, sandboxID string, svc *models.ServiceInstance) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.state.sandboxes[sandboxID]; !ok {
+		return fmt.Errorf("failed to create service: sandbox not found: %s", sandboxID)
+	}
+
+	services := r.state.services[sandboxID]
+	if services == nil {
+		services = make(map[string]*models.ServiceInstance)
+		r.state.services[sandboxID] = services
+	}
+
+	if existing, ok := services[svc.Name]; ok {
+		updated := cloneService(existing)
+		updated.Status = svc.Status
+		updated.Credentials = cloneService(svc).Credentials
+		updated.Mode = svc.Mode
+		updated.ContainerID = svc.ContainerID
+		services[svc.Name] = updated
+		return nil
+	}
+
+	stored := cloneService(svc)
+	stored.StatusMsg = ""
+	stored.LastCheckedAt = nil
+	services[svc.Name] = stored
+	return nil
+}
+
+// GetServices retrieves all services for a sandbox, ordered by name
+func (r *MemoryRepository) GetServices(ctx context.Context, sandboxID string) ([]*models.ServiceInstance, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var services []*models.ServiceInstance
+	for _, svc := range r.state.services[sandboxID] {
+		services = append(services, cloneService(svc))
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	return services, nil
+}
+
+// UpdateService updates a service instance
+func (r *MemoryRepository) UpdateService(ctx context.Context, sandboxID string, svc *models.ServiceInstance) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.state.services[sandboxID][svc.Name]
+	if !ok {
+		return fmt.Errorf("service not found: %s/%s", sandboxID, svc.Name)
+	}
+
+	update := cloneService(svc)
+	updated := cloneService(existing)
+	updated.Status = update.Status
+	updated.Credentials = update.Credentials
+	updated.StatusMsg = update.StatusMsg
+	updated.LastCheckedAt = update.LastCheckedAt
+	r.state.services[sandboxID][svc.Name] = updated
+	return nil
+}
+
+// DeleteServices deletes all services for a sandbox
+func (r *MemoryRepository) DeleteServices(ctx context.Context, sandboxID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.state.services, sandboxID)
+	return nil
+}
+
+// --- Docker hosts ---
+
+// GetHostStates returns the scheduling state of all known hosts, keyed by name
+func (r *MemoryRepository) GetHostStates(ctx context.Context) (map[string]*models.HostState, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	states := make(map[string]*models.HostState, len(r.state.hosts))
+	for name, st := range r.state.hosts {
+		c := *st
+		states[name] = &c
+	}
+	return states, nil
+}
+
+// SetHostState creates or updates the scheduling state of a host
+func (r *MemoryRepository) SetHostState(ctx context.Context, st *models.HostState) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	st.UpdatedAt = time.Now()
+	c := *st
+	r.state.hosts[st.Name] = &c
+	return nil
+}
+
+// AssignUnplacedSandboxes records host on sandboxes created before placement was tracked
+func (r *MemoryRepository) AssignUnplacedSandboxes(ctx context.Context, host string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var n int64
+	for id, sb := range r.state.sandboxes {
+		if sb.Host == "" && sb.DeletedAt == nil {
+			updated := cloneSandbox(sb)
+			updated.Host = host
+			r.state.sandboxes[id] = updated
+			n++
+		}
+	}
+	return n, nil
+}
+
+// countByHost counts sandboxes holding resources that match per host,
+// counting unplaced ones on defaultHost
+func (r *MemoryRepository) countByHost(defaultHost string, match func(*models.Sandbox) bool) map[string]int {
+	counts := make(map[string]int)
+	for _, sb := range r.state.sandboxes {
+		if !holdsResources(sb) || !match(sb) {
+			continue
+		}
+		host := sb.Host
+		if host == "" {
+			host = defaultHost
+		}
+		counts[host]++
+	}
+	return counts
+}
+
+// CountSandboxesByHost counts sandboxes still holding resources per host
+func (r *MemoryRepository) CountSandboxesByHost(ctx context.Context, defaultHost string) (map[string]int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.countByHost(defaultHost, func(*models.Sandbox) bool { return true }), nil
+}
+
+// CountGPUSandboxesByHost counts sandboxes whose metadata records a resources.gpus grant per host
+func (r *MemoryRepository) CountGPUSandboxesByHost(ctx context.Context, defaultHost string) (map[string]int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.countByHost(defaultHost, func(sb *models.Sandbox) bool {
+		_, ok := sb.Metadata["resources.gpus"]
+		return ok
+	}), nil
+}
+
+// --- Sandbox events ---
+
+// AppendEvent records a sandbox lifecycle event, assigning its ID
+func (r *MemoryRepository) AppendEvent(ctx context.Context, ev *models.SandboxEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.state.lastEventID++
+	ev.ID = r.state.lastEventID
+	c := *ev
+	r.state.events = append(r.state.events, &c)
+	return nil
+}
+
+// ListEvents returns a sandbox's events, oldest first
+func (r *MemoryRepository) ListEvents(ctx context.Context, sandboxID string) ([]*models.SandboxEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var events []*models.SandboxEvent
+	for _, ev := range r.state.events {
+		if ev.SandboxID == sandboxID {
+			c := *ev
+			events = append(events, &c)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		if !events[i].CreatedAt.Equal(events[j].CreatedAt) {
+			return events[i].CreatedAt.Before(events[j].CreatedAt)
+		}
+		return events[i].ID < events[j].ID
+	})
+	return events, nil
+}
+
+// DeleteEventsBefore deletes all events of deleted sandboxes, marked or
+// purged, whose last event was recorded before cutoff
+func (r *MemoryRepository) DeleteEventsBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	last := make(map[string]time.Time)
+	for _, ev := range r.state.events {
+		if ev.CreatedAt.After(last[ev.SandboxID]) {
+			last[ev.SandboxID] = ev.CreatedAt
+		}
+	}
+
+	var kept []*models.SandboxEvent
+	var n int64
+	for _, ev := range r.state.events {
+		sb, exists := r.state.sandboxes[ev.SandboxID]
+		if (!exists || sb.DeletedAt != nil) && last[ev.SandboxID].Before(cutoff) {
+			n++
+			continue
+		}
+		kept = append(kept, ev)
+	}
+	r.state.events = kept
+	return n, nil
+}
+
+// --- Template usage ---
+
+// RecordTemplateUsage adds a sandbox's usage to the daily counters of its
+// template and task, at most once per sandbox. Returns whether it was counted.
+func (r *MemoryRepository) RecordTemplateUsage(ctx context.Context, rec *models.UsageRecord) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.state.sandboxes[rec.SandboxID]; !ok || r.state.usageRecorded[rec.SandboxID] {
+		return false, nil
+	}
+	r.state.usageRecorded[rec.SandboxID] = true
+
+	key := usageKey{templateID: rec.TemplateID, taskID: rec.TaskID, day: usageDay(rec.UsedAt)}
+	u := models.TemplateUsage{TemplateID: rec.TemplateID, TaskID: rec.TaskID, LastUsedAt: cloneTime(&rec.UsedAt)}
+	if existing, ok := r.state.usage[key]; ok {
+		u = *existing
+		if rec.UsedAt.After(*u.LastUsedAt) {
+			u.LastUsedAt = cloneTime(&rec.UsedAt)
+		}
+	}
+
+	u.Creations++
+	if rec.Succeeded {
+		u.Successes++
+	}
+	if rec.Failed {
+		u.Failures++
+	}
+	u.SessionSeconds += rec.SessionSeconds
+	r.state.usage[key] = &u
+
+	return true, nil
+}
+
+// GetTemplateUsage sums the daily counters per template and task for days in [from, to]
+func (r *MemoryRepository) GetTemplateUsage(ctx context.Context, from, to time.Time) ([]*models.TemplateUsage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	fromDay, toDay := usageDay(from), usageDay(to)
+	sums := make(map[usageKey]*models.TemplateUsage)
+	for key, daily := range r.state.usage {
+		if key.day < fromDay || key.day > toDay {
+			continue
+		}
+		total := usageKey{templateID: key.templateID, taskID: key.taskID}
+		sum, ok := sums[total]
+		if !ok {
+			sum = &models.TemplateUsage{TemplateID: key.templateID, TaskID: key.taskID, LastUsedAt: cloneTime(daily.LastUsedAt)}
+			sums[total] = sum
+		}
+		sum.Creations += daily.Creations
+		sum.Successes += daily.Successes
+		sum.Failures += daily.Failures
+		sum.SessionSeconds += daily.SessionSeconds
+		if daily.LastUsedAt.After(*sum.LastUsedAt) {
+			sum.LastUsedAt = cloneTime(daily.LastUsedAt)
+		}
+	}
+
+	var usage []*models.TemplateUsage
+	for _, u := range sums {
+		usage = append(usage, u)
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].TemplateID != usage[j].TemplateID {
+			return usage[i].TemplateID < usage[j].TemplateID
+		}
+		return usage[i].TaskID < usage[j].TaskID
+	})
+	return usage, nil
+}
+
+// --- Sessions ---
+
+// isLive reports whether a session counts against its unique key
+func isLive(s *models.Session) bool {
+	return s.Status == models.SessionProvisioning || s.Status == models.SessionActive
+}
+
+// uniqueKeyTaken reports whether another live session holds s's unique key,
+// which idx_sessions_unique_key_active forbids
+func (r *MemoryRepository) uniqueKeyTaken(s *models.Session) bool {
+	if s.UniqueKey == "" || !isLive(s) {
+		return false
+	}
+	for _, other := range r.state.sessions {
+		if other.ID != s.ID && other.UniqueKey == s.UniqueKey && isLive(other) {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateSession creates a new session record
+func (r *MemoryRepository) CreateSession(ctx context.Context, s *models.Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.state.sessions[s.ID]; ok {
+		return fmt.Errorf("failed to create session: duplicate id %s", s.ID)
+	}
+	for _, other := range r.state.sessions {
+		if other.Token == s.Token {
+			return errors.New("failed to create session: duplicate token")
+		}
+	}
+	if r.uniqueKeyTaken(s) {
+		return errors.New("failed to create session: unique key already live")
+	}
+
+	r.state.sessions[s.ID] = cloneSession(s)
+	return nil
+}
+
+// findSession returns a copy of the first session matching, or nil
+func (r *MemoryRepository) findSession(match func(*models.Session) bool) *models.Session {
+	for _, s := range r.state.sessions {
+		if match(s) {
+			return cloneSession(s)
+		}
+	}
+	return nil
+}
+
+// GetSessionByToken retrieves a session by its join token
+func (r *MemoryRepository) GetSessionByToken(ctx context.Context, token string) (*models.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.findSession(func(s *models.Session) bool { return s.Token == token }), nil
+}
+
+// GetSessionByID retrieves a session by its ID
+func (r *MemoryRepository) GetSessionByID(ctx context.Context, id string) (*models.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if s, ok := r.state.sessions[id]; ok {
+		return cloneSession(s), nil
+	}
+	return nil, nil
+}
+
+// GetSessionBySandboxID retrieves the session bound to a sandbox
+func (r *MemoryRepository) GetSessionBySandboxID(ctx context.Context, sandboxID string) (*models.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// An empty sandbox_id is stored as NULL, which matches nothing
+	if sandboxID == "" {
+		return nil, nil
+	}
+	return r.findSession(func(s *models.Session) bool { return s.SandboxID == sandboxID }), nil
+}
+
+// GetLiveSessionByUniqueKey returns the provisioning or active session holding
+// a unique key, or nil if none does
+func (r *MemoryRepository) GetLiveSessionByUniqueKey(ctx context.Context, uniqueKey string) (*models.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if uniqueKey == "" {
+		return nil, nil
+	}
+	return r.findSession(func(s *models.Session) bool { return s.UniqueKey == uniqueKey && isLive(s) }), nil
+}
+
+// UpdateSession updates the columns PostgresRepository.UpdateSession writes. It returns
+// ErrUniqueKeyConflict if the new status would give the unique key a second live session.
+func (r *MemoryRepository) UpdateSession(ctx context.Context, s *models.Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.state.sessions[s.ID]; !ok {
+		return fmt.Errorf("session not found: %s", s.ID)
+	}
+	return r.updateSessionLocked(s)
+}
+
+// UpdateSessionFrom updates a session like UpdateSession while its stored
+// status is still from, like PostgresRepository.UpdateSessionFrom
+func (r *MemoryRepository) UpdateSessionFrom(ctx context.Context, s *models.Session, from models.SessionStatus) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if stored, ok := r.state.sessions[s.ID]; !ok || stored.Status != from {
+		return false, nil
+	}
+	if err := r.updateSessionLocked(s); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// updateSessionLocked writes s over its stored session; r.mu must be held
+func (r *MemoryRepository) updateSessionLocked(s *models.Session) error {
+	stored := r.state.sessions[s.ID]
+
+	updated := cloneSession(stored)
+	updated.Status = s.Status
+	updated.StatusMessage = s.StatusMessage
+	updated.SandboxID = s.SandboxID
+	updated.ActivatedAt = cloneTime(s.ActivatedAt)
+	updated.ExpiresAt = cloneTime(s.ExpiresAt)
+	updated.Env = maps.Clone(s.Env)
+	updated.Metadata = maps.Clone(s.Metadata)
+	updated.TaskDescription = s.TaskDescription
+	updated.RevokedAt = cloneTime(s.RevokedAt)
+	updated.RevokedBy = s.RevokedBy
+	updated.SubmittedAt = cloneTime(s.SubmittedAt)
+	updated.ExtendedSeconds = s.ExtendedSeconds
+
+	if r.uniqueKeyTaken(updated) {
+		return ErrUniqueKeyConflict
+	}
+
+	r.state.sessions[s.ID] = updated
+	return nil
+}
+
+// ClaimSession moves a ready session to s.Status like PostgresRepository.ClaimSession
+func (r *MemoryRepository) ClaimSession(ctx context.Context, s *models.Session) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.state.sessions[s.ID]
+	if !ok || stored.Status != models.SessionReady {
+		return false, nil
+	}
+
+	updated := cloneSession(stored)
+	updated.Status = s.Status
+	updated.StatusMessage = s.StatusMessage
+	updated.ActivatedAt = cloneTime(s.ActivatedAt)
+	updated.ExpiresAt = cloneTime(s.ExpiresAt)
+
+	if r.uniqueKeyTaken(updated) {
+		return false, ErrUniqueKeyConflict
+	}
+
+	r.state.sessions[s.ID] = updated
+	return true, nil
+}
+
+// DeleteSession deletes a session by ID
+func (r *MemoryRepository) DeleteSession(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.state.sessions[id]; !ok {
+		return fmt.Errorf("session not found: %s", id)
+	}
+	delete(r.state.sessions, id)
+	r.deleteConnections(id)
+	return nil
+}
+
+// PurgeRevokedSessions removes sessions revoked before cutoff
+func (r *MemoryRepository) PurgeRevokedSessions(ctx context.Context, cutoff time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var n int64
+	for id, s := range r.state.sessions {
+		if s.Status == models.SessionRevoked && s.RevokedAt != nil && s.RevokedAt.Before(cutoff) {
+			delete(r.state.sessions, id)
+			r.deleteConnections(id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// listSessions returns copies of the matching sessions, newest first, paginated
+func (r *MemoryRepository) listSessions(match func(*models.Session) bool, limit, offset int) []*models.Session {
+	var matched []*models.Session
+	for _, s := range r.state.sessions {
+		if match(s) {
+			matched = append(matched, s)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		if !matched[i].CreatedAt.Equal(matched[j].CreatedAt) {
+			return matched[i].CreatedAt.After(matched[j].CreatedAt)
+		}
+		return matched[i].ID < matched[j].ID
+	})
+
+	var sessions []*models.Session
+	for _, s := range paginate(matched, limit, offset) {
+		sessions = append(sessions, cloneSession(s))
+	}
+	return sessions
+}
+
+// ListSessions returns sessions matching filters, newest first
+func (r *MemoryRepository) ListSessions(ctx context.Context, filters models.SessionFilters) ([]*models.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.listSessions(func(s *models.Session) bool {
+		return matchesSessionFilters(s, filters)
+	}, filters.Limit, filters.Offset), nil
+}
+
+// CountSessions counts sessions matching filters, ignoring Limit and Offset
+func (r *MemoryRepository) CountSessions(ctx context.Context, filters models.SessionFilters) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for _, s := range r.state.sessions {
+		if matchesSessionFilters(s, filters) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// matchesSessionFilters is sessionFilterWhere for one session
+func matchesSessionFilters(s *models.Session, filters models.SessionFilters) bool {
+	switch filters.Status {
+	case "":
+	case models.SessionFilterInvalidTemplate:
+		missing := s.Status == models.SessionReady && !slices.Contains(filters.KnownTemplates, s.TemplateID)
+		failed := s.Status == models.SessionFailed && strings.HasPrefix(s.StatusMessage, models.StatusMsgTemplateMissing)
+		if !missing && !failed {
+			return false
+		}
+	default:
+		if string(s.Status) != filters.Status {
+			return false
+		}
+	}
+	if filters.TemplateID != "" && s.TemplateID != filters.TemplateID {
+		return false
+	}
+	if filters.CreatedBy != "" && s.CreatedBy != filters.CreatedBy {
+		return false
+	}
+	if !filters.CreatedAfter.IsZero() && s.CreatedAt.Before(filters.CreatedAfter) {
+		return false
+	}
+	if !filters.CreatedBefore.IsZero() && !s.CreatedAt.Before(filters.CreatedBefore) {
+		return false
+	}
+	return true
+}
+
+// expiredSessions returns active sessions past their expiry, oldest expiry first
+func (r *MemoryRepository) expiredSessions() []*models.Session {
+	now := time.Now()
+	var expired []*models.Session
+	for _, s := range r.state.sessions {
+		if d := cleanupDeadline(s); d != nil && d.Before(now) {
+			expired = append(expired, s)
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool { return cleanupDeadline(expired[i]).Before(*cleanupDeadline(expired[j])) })
+	return expired
+}
+
+// cleanupDeadline is expiredSessionsWhere for one session: the expiry of an
+// active session, the join deadline of a ready one, nil for the rest
+func cleanupDeadline(s *models.Session) *time.Time {
+	switch s.Status {
+	case models.SessionActive:
+		return s.ExpiresAt
+	case models.SessionReady:
+		return s.JoinBy
+	}
+	return nil
+}
+
+// GetExpiredSessions returns up to limit sessions past their TTL or join
+// deadline, oldest deadline first
+func (r *MemoryRepository) GetExpiredSessions(ctx context.Context, limit int) ([]*models.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var sessions []*models.Session
+	for _, s := range paginate(r.expiredSessions(), limit, 0) {
+		sessions = append(sessions, cloneSession(s))
+	}
+	return sessions, nil
+}
+
+// CountExpiredSessions counts sessions past their TTL or join deadline not yet expired by the cleaner
+func (r *MemoryRepository) CountExpiredSessions(ctx context.Context) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.expiredSessions()), nil
+}
+
+// SetSessionSandboxStatus records the last status of a sandbox on the session
+// it belongs to, if any
+func (r *MemoryRepository) SetSessionSandboxStatus(ctx context.Context, sandboxID string, status models.SandboxStatus) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, s := range r.state.sessions {
+		if s.SandboxID == sandboxID {
+			updated := cloneSession(s)
+			updated.SandboxStatus = status
+			r.state.sessions[id] = updated
+		}
+	}
+	return nil
+}
+
+// GetPrewarmDueSessions returns up to limit ready sessions without a sandbox
+// whose prewarm time has come, skipping those past their join deadline,
+// earliest first
+func (r *MemoryRepository) GetPrewarmDueSessions(ctx context.Context, limit int) ([]*models.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	var due []*models.Session
+	for _, s := range r.state.sessions {
+		if s.Status != models.SessionReady || s.SandboxID != "" || s.PrewarmAt == nil || s.PrewarmAt.After(now) {
+			continue
+		}
+		if s.JoinBy != nil && !s.JoinBy.After(now) {
+			continue
+		}
+		due = append(due, s)
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].PrewarmAt.Before(*due[j].PrewarmAt) })
+
+	var sessions []*models.Session
+	for _, s := range paginate(due, limit, 0) {
+		sessions = append(sessions, cloneSession(s))
+	}
+	return sessions, nil
+}
+
+// AttachSessionSandbox sets the sandbox of a session still ready without one.
+// It reports false when the session was activated, expired or revoked first.
+func (r *MemoryRepository) AttachSessionSandbox(ctx context.Context, id, sandboxID string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.state.sessions[id]
+	if !ok || stored.Status != models.SessionReady || stored.SandboxID != "" {
+		return false, nil
+	}
+	updated := cloneSession(stored)
+	updated.SandboxID = sandboxID
+	r.state.sessions[id] = updated
+	return true, nil
+}
+
+// RevokeSession revokes a session not revoked yet and returns the sandbox it
+// had at that moment, so one attached meanwhile isn't missed. It reports
+// false when the session is gone or was revoked first.
+func (r *MemoryRepository) RevokeSession(ctx context.Context, id, revokedBy string, at time.Time) (string, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.state.sessions[id]
+	if !ok || stored.Status == models.SessionRevoked {
+		return "", false, nil
+	}
+	updated := cloneSession(stored)
+	updated.Status = models.SessionRevoked
+	updated.RevokedAt = &at
+	updated.RevokedBy = revokedBy
+	r.state.sessions[id] = updated
+	return updated.SandboxID, true, nil
+}
+
+// --- Session connections ---
+
+// OpenSessionConnection records a terminal connection of a session, assigning its ID
+func (r *MemoryRepository) OpenSessionConnection(ctx context.Context, c *models.SessionConnection) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.state.sessions[c.SessionID]; !ok {
+		return fmt.Errorf("session not found: %s", c.SessionID)
+	}
+	r.state.lastConnID++
+	c.ID = r.state.lastConnID
+	r.state.connections = append(r.state.connections, cloneConnection(c))
+	return nil
+}
+
+// CloseSessionConnection records when a terminal connection ended, ignoring
+// a connection that is gone with its session
+func (r *MemoryRepository) CloseSessionConnection(ctx context.Context, id int64, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, c := range r.state.connections {
+		if c.ID == id {
+			closed := cloneConnection(c)
+			closed.DisconnectedAt = &at
+			r.state.connections[i] = closed
+		}
+	}
+	return nil
+}
+
+// ListSessionConnections returns a session's terminal connections, oldest first
+func (r *MemoryRepository) ListSessionConnections(ctx context.Context, sessionID string) ([]*models.SessionConnection, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var conns []*models.SessionConnection
+	for _, c := range r.state.connections {
+		if c.SessionID == sessionID {
+			conns = append(conns, cloneConnection(c))
+		}
+	}
+	sort.SliceStable(conns, func(i, j int) bool {
+		if !conns[i].ConnectedAt.Equal(conns[j].ConnectedAt) {
+			return conns[i].ConnectedAt.Before(conns[j].ConnectedAt)
+		}
+		return conns[i].ID < conns[j].ID
+	})
+	return conns, nil
+}
+
+// deleteConnections drops a deleted session's connections, like the foreign
+// key cascade does. Callback deliveries stay until they are purged.
+func (r *MemoryRepository) deleteConnections(sessionID string) {
+	r.state.connections = slices.DeleteFunc(r.state.connections, func(c *models.SessionConnection) bool {
+		return c.SessionID == sessionID
+	})
+}
+
+// --- Session callback deliveries ---
+
+// copyDelivery copies d, including its next attempt time
+func copyDelivery(d *models.CallbackDelivery) *models.CallbackDelivery {
+	c := *d
+	if d.NextAttemptAt != nil {
+		next := *d.NextAttemptAt
+		c.NextAttemptAt = &next
+	}
+	return &c
+}
+
+// RecordCallbackDelivery adds a session callback to the delivery outbox, assigning its ID
+func (r *MemoryRepository) RecordCallbackDelivery(ctx context.Context, d *models.CallbackDelivery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.state.lastDelivID++
+	d.ID = r.state.lastDelivID
+	r.state.deliveries = append(r.state.deliveries, copyDelivery(d))
+	return nil
+}
+
+// UpdateCallbackDelivery stores the outcome of a delivery attempt and when to try next
+func (r *MemoryRepository) UpdateCallbackDelivery(ctx context.Context, d *models.CallbackDelivery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, stored := range r.state.deliveries {
+		if stored.ID == d.ID {
+			updated := copyDelivery(d)
+			updated.URL, updated.Secret, updated.Payload = stored.URL, stored.Secret, stored.Payload
+			r.state.deliveries[i] = updated
+			return nil
+		}
+	}
+	return nil
+}
+
+// ClaimCallbackDeliveries returns up to limit of the pending deliveries due at
+// now, the longest due first, and pushes their next attempt back by lease
+func (r *MemoryRepository) ClaimCallbackDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*models.CallbackDelivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var due []int
+	for i, d := range r.state.deliveries {
+		if d.NextAttemptAt != nil && !d.NextAttemptAt.After(now) {
+			due = append(due, i)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return r.state.deliveries[due[i]].NextAttemptAt.Before(*r.state.deliveries[due[j]].NextAttemptAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	// Replaced rather than changed in place, so a transaction snapshot keeps the old row
+	claimed := make([]*models.CallbackDelivery, 0, len(due))
+	for _, i := range due {
+		next := now.Add(lease)
+		d := copyDelivery(r.state.deliveries[i])
+		d.NextAttemptAt = &next
+		r.state.deliveries[i] = d
+		claimed = append(claimed, copyDelivery(d))
+	}
+	return claimed, nil
+}
+
+// PurgeCallbackDeliveries removes the finished deliveries of deleted sessions
+func (r *MemoryRepository) PurgeCallbackDeliveries(ctx context.Context) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	before := len(r.state.deliveries)
+	r.state.deliveries = slices.DeleteFunc(r.state.deliveries, func(d *models.CallbackDelivery) bool {
+		_, ok := r.state.sessions[d.SessionID]
+		return d.NextAttemptAt == nil && !ok
+	})
+	return int64(before - len(r.state.deliveries)), nil
+}
+
+// ListCallbackDeliveries returns the callback deliveries of a session, oldest first
+func (r *MemoryRepository) ListCallbackDeliveries(ctx context.Context, sessionID string) ([]*models.CallbackDelivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var deliveries []*models.CallbackDelivery
+	for _, d := range r.state.deliveries {
+		if d.SessionID == sessionID {
+			deliveries = append(deliveries, copyDelivery(d))
+		}
+	}
+	return deliveries, nil
+}
+
+// --- Terminal recordings ---
+
+// CreateTerminalRecording stores a started recording, assigning its ID
+func (r *MemoryRepository) CreateTerminalRecording(ctx context.Context, rec *models.TerminalRecording) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.state.lastRecID++
+	rec.ID = r.state.lastRecID
+	r.state.recordings = append(r.state.recordings, cloneRecording(rec))
+	return nil
+}
+
+// FinishTerminalRecording stores the end, size and truncation of a recording
+func (r *MemoryRepository) FinishTerminalRecording(ctx context.Context, rec *models.TerminalRecording) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, stored := range r.state.recordings {
+		if stored.ID == rec.ID {
+			finished := cloneRecording(stored)
+			finished.EndedAt, finished.SizeBytes, finished.Truncated = cloneTime(rec.EndedAt), rec.SizeBytes, rec.Truncated
+			r.state.recordings[i] = finished
+		}
+	}
+	return nil
+}
+
+// ListTerminalRecordings returns the recordings of a sandbox, oldest first
+func (r *MemoryRepository) ListTerminalRecordings(ctx context.Context, sandboxID string) ([]*models.TerminalRecording, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var recs []*models.TerminalRecording
+	for _, rec := range r.state.recordings {
+		if rec.SandboxID == sandboxID {
+			recs = append(recs, cloneRecording(rec))
+		}
+	}
+	return recs, nil
+}
+
+// PurgeTerminalRecordings deletes the recordings that ended before cutoff, and
+// those never finished that started before it, returning them
+func (r *MemoryRepository) PurgeTerminalRecordings(ctx context.Context, cutoff time.Time) ([]*models.TerminalRecording, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var purged []*models.TerminalRecording
+	r.state.recordings = slices.DeleteFunc(r.state.recordings, func(rec *models.TerminalRecording) bool {
+		at := rec.StartedAt
+		if rec.EndedAt != nil {
+			at = *rec.EndedAt
+		}
+		if at.Before(cutoff) {
+			purged = append(purged, cloneRecording(rec))
+			return true
+		}
+		return false
+	})
+	return purged, nil
+}
+
+// GetTerminalRecording retrieves a recording by ID
+func (r *MemoryRepository) GetTerminalRecording(ctx context.Context, id int64) (*models.TerminalRecording, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, rec := range r.state.recordings {
+		if rec.ID == id {
+			return cloneRecording(rec), nil
+		}
+	}
+	return nil, nil
+}
+
+// --- Grading results ---
+
+// CreateGradingResult stores a grading run, assigning its ID and creation time
+func (r *MemoryRepository) CreateGradingResult(ctx context.Context, res *models.GradingResult) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.state.lastGradeID++
+	res.ID = r.state.lastGradeID
+	res.CreatedAt = time.Now()
+	r.state.grading = append(r.state.grading, cloneGradingResult(res))
+	return nil
+}
+
+// ListGradingResults returns the grading runs of a sandbox, oldest first
+func (r *MemoryRepository) ListGradingResults(ctx context.Context, sandboxID string) ([]*models.GradingResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var results []*models.GradingResult
+	for _, res := range r.state.grading {
+		if res.SandboxID == sandboxID {
+			results = append(results, cloneGradingResult(res))
+		}
+	}
+	return results, nil
+}
+
+// --- Template documents ---
+
+// ListTemplateDocuments returns the templates created through the API, by name
+func (r *MemoryRepository) ListTemplateDocuments(ctx context.Context) ([]*models.TemplateDocument, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var docs []*models.TemplateDocument
+	for _, doc := range r.state.templates {
+		c := *doc
+		docs = append(docs, &c)
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].Name < docs[j].Name })
+	return docs, nil
+}
+
+// GetTemplateDocument retrieves a template created through the API by name
+func (r *MemoryRepository) GetTemplateDocument(ctx context.Context, name string) (*models.TemplateDocument, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if doc, ok := r.state.templates[name]; ok {
+		c := *doc
+		return &c, nil
+	}
+	return nil, nil
+}
+
+// SaveTemplateDocument creates or replaces a template document, setting its
+// creation and update times
+func (r *MemoryRepository) SaveTemplateDocument(ctx context.Context, doc *models.TemplateDocument) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	doc.CreatedAt, doc.UpdatedAt = now, now
+	if prev, ok := r.state.templates[doc.Name]; ok {
+		doc.CreatedAt = prev.CreatedAt
+	}
+	c := *doc
+	r.state.templates[doc.Name] = &c
+	return nil
+}
+
+// CreateTemplateDocument inserts a template document unless its name is taken
+func (r *MemoryRepository) CreateTemplateDocument(ctx context.Context, doc *models.TemplateDocument) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.state.templates[doc.Name]; ok {
+		return false, nil
+	}
+	now := time.Now()
+	doc.CreatedAt, doc.UpdatedAt = now, now
+	c := *doc
+	r.state.templates[doc.Name] = &c
+	return true, nil
+}
+
+// LockTemplateDocument reports whether a template document exists;
+// transactions hold txMu, so nothing needs locking
+func (r *MemoryRepository) LockTemplateDocument(ctx context.Context, name string, forUpdate bool) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.state.templates[name]
+	return ok, nil
+}
+
+// DeleteTemplateDocument deletes a template document
+func (r *MemoryRepository) DeleteTemplateDocument(ctx context.Context, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.state.templates, name)
+	return nil
+}
+
+// --- API clients ---
+
+// AddClient stores an API client, standing in for the rows migrations seed.
+// A client without an ID is assigned the next one.
+func (r *MemoryRepository) AddClient(c *models.ApiClient) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if c.ID == 0 {
+		r.state.lastClientID++
+		c.ID = r.state.lastClientID
+	}
+	r.state.clients[c.ApiKey] = cloneClient(c)
+}
+
+// findClient returns the stored client with the given ID
+func (r *MemoryRepository) findClient(id int) (*models.ApiClient, bool) {
+	for _, c := range r.state.clients {
+		if c.ID == id {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// GetClientByApiKey retrieves an API client by its key
+func (r *MemoryRepository) GetClientByApiKey(ctx context.Context, apiKey string) (*models.ApiClient, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if c, ok := r.state.clients[apiKey]; ok {
+		return cloneClient(c), nil
+	}
+	return nil, nil // Not found
+}
+
+// GetClient retrieves an API client by ID
+func (r *MemoryRepository) GetClient(ctx context.Context, id int) (*models.ApiClient, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if c, ok := r.findClient(id); ok {
+		return cloneClient(c), nil
+	}
+	return nil, nil // Not found
+}
+
+// ListClients returns all API clients, oldest first
+func (r *MemoryRepository) ListClients(ctx context.Context) ([]*models.ApiClient, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var clients []*models.ApiClient
+	for _, c := range r.state.clients {
+		clients = append(clients, cloneClient(c))
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ID < clients[j].ID })
+	return clients, nil
+}
+
+// CreateClient creates an API client, setting its ID and creation time
+func (r *MemoryRepository) CreateClient(ctx context.Context, c *models.ApiClient) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.state.clients[c.ApiKey]; ok {
+		return fmt.Errorf("failed to create api client: duplicate api key")
+	}
+	r.state.lastClientID++
+	c.ID = r.state.lastClientID
+	c.CreatedAt = time.Now()
+	stored := cloneClient(c)
+	stored.LastUsedAt = nil
+	r.state.clients[c.ApiKey] = stored
+	return nil
+}
+
+// UpdateClient updates the columns PostgresRepository.UpdateClient writes
+func (r *MemoryRepository) UpdateClient(ctx context.Context, c *models.ApiClient) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.findClient(c.ID)
+	if !ok {
+		return fmt.Errorf("api client not found: %d", c.ID)
+	}
+
+	update := cloneClient(c)
+	updated := cloneClient(stored)
+	updated.Name = update.Name
+	updated.IsActive = update.IsActive
+	updated.Permissions = update.Permissions
+	updated.Metadata = update.Metadata
+	updated.ExpiresAt = update.ExpiresAt
+	updated.AllowedCIDRs = update.AllowedCIDRs
+	r.state.clients[updated.ApiKey] = updated
+	return nil
+}
+
+// RotateClientKey replaces the key of an API client, keeping last_used_at
+func (r *MemoryRepository) RotateClientKey(ctx context.Context, id int, apiKey string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.findClient(id)
+	if !ok {
+		return fmt.Errorf("api client not found: %d", id)
+	}
+	if _, taken := r.state.clients[apiKey]; taken {
+		return fmt.Errorf("failed to rotate api client key: duplicate api key")
+	}
+
+	updated := cloneClient(stored)
+	updated.ApiKey = apiKey
+	delete(r.state.clients, stored.ApiKey)
+	r.state.clients[apiKey] = updated
+	return nil
+}
+
+// DeactivateExpiredClients marks active clients whose key expired by now as
+// inactive and returns them, oldest first
+func (r *MemoryRepository) DeactivateExpiredClients(ctx context.Context, now time.Time) ([]*models.ApiClient, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var clients []*models.ApiClient
+	for key, c := range r.state.clients {
+		if !c.IsActive || c.ExpiresAt == nil || c.ExpiresAt.After(now) {
+			continue
+		}
+		updated := cloneClient(c)
+		updated.IsActive = false
+		r.state.clients[key] = updated
+		clients = append(clients, cloneClient(updated))
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ID < clients[j].ID })
+	return clients, nil
+}
+
+// UpdateClientLastUsed updates the last_used_at timestamp for a client
+func (r *MemoryRepository) UpdateClientLastUsed(ctx context.Context, apiKey string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if c, ok := r.state.clients[apiKey]; ok {
+		updated := cloneClient(c)
+		now := time.Now()
+		updated.LastUsedAt = &now
+		r.state.clients[apiKey] = updated
+	}
+	return nil
+}
+
+// EnsureJWTClient returns the ID of the client of a JWT subject, creating it
+// inactive with no permissions on first use
+func (r *MemoryRepository) EnsureJWTClient(ctx context.Context, subject, name string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if apiKey, ok := r.state.jwtClients[subject]; ok {
+		return r.state.clients[apiKey].ID, nil
+	}
+	apiKey, err := models.GenerateApiKey()
+	if err != nil {
+		return 0, fmt.Errorf("failed to generate api key: %w", err)
+	}
+	r.state.lastClientID++
+	r.state.clients[apiKey] = &models.ApiClient{
+		ID:          r.state.lastClientID,
+		Name:        name,
+		ApiKey:      apiKey,
+		CreatedAt:   time.Now(),
+		Permissions: []string{},
+	}
+	r.state.jwtClients[subject] = apiKey
+	return r.state.lastClientID, nil
+}
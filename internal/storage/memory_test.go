@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+func TestMemoryNotFound(t *testing.T) {
+	repo := NewMemoryRepository()
+	ctx := context.Background()
+
+	if sb, err := repo.GetSandbox(ctx, "missing"); sb != nil || err != nil {
+		t.Errorf("expected nil, nil for a missing sandbox, got %v, %v", sb, err)
+	}
+	if s, err := repo.GetSessionByToken(ctx, "missing"); s != nil || err != nil {
+		t.Errorf("expected nil, nil for a missing session, got %v, %v", s, err)
+	}
+	if c, err := repo.GetClientByApiKey(ctx, "missing"); c != nil || err != nil {
+		t.Errorf("expected nil, nil for a missing client, got %v, %v", c, err)
+	}
+
+	// Writes to missing rows fail, as they do on PostgreSQL
+	if err := repo.UpdateSandbox(ctx, &models.Sandbox{ID: "missing"}); err == nil {
+		t.Error("expected an error updating a missing sandbox")
+	}
+	if err := repo.DeleteSession(ctx, "missing"); err == nil {
+		t.Error("expected an error deleting a missing session")
+	}
+	if err := repo.CreateService(ctx, "missing", &models.ServiceInstance{Name: "redis"}); err == nil {
+		t.Error("expected the sandbox foreign key enforced")
+	}
+}
+
+func TestMemoryReturnsCopies(t *testing.T) {
+	repo := NewMemoryRepository()
+	ctx := context.Background()
+
+	sb := &models.Sandbox{ID: "sb-1", Metadata: map[string]string{"cohort": "a"}}
+	if err := repo.CreateSandbox(ctx, sb); err != nil {
+		t.Fatal(err)
+	}
+	sb.Metadata["cohort"] = "changed"
+
+	got, _ := repo.GetSandbox(ctx, "sb-1")
+	got.Metadata["cohort"] = "changed too"
+
+	if again, _ := repo.GetSandbox(ctx, "sb-1"); again.Metadata["cohort"] != "a" {
+		t.Errorf("expected the stored record untouched, got %v", again.Metadata)
+	}
+}
+
+func TestMemoryListSandboxes(t *testing.T) {
+	repo := NewMemoryRepository()
+	ctx := context.Background()
+
+	base := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		sb := &models.Sandbox{
+			ID:        fmt.Sprintf("sb-%d", i),
+			UserID:    "user-1",
+			Status:    models.StatusRunning,
+			CreatedAt: base.Add(time.Duration(i) * time.Hour),
+			ExpiresAt: time.Now().Add(time.Duration(2*i-3) * time.Hour),
+			Metadata:  map[string]string{"cohort": []string{"a", "b"}[i%2]},
+		}
+		if err := repo.CreateSandbox(ctx, sb); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	filters := models.ListFilters{Metadata: map[string]string{"cohort": "a"}, CreatedBefore: base.Add(4 * time.Hour), Limit: 1, Offset: 1}
+	sandboxes, _ := repo.ListSandboxes(ctx, filters)
+	// Cohort a before the fifth hour is sb-0 and sb-2; newest first, the second page holds sb-0
+	if len(sandboxes) != 1 || sandboxes[0].ID != "sb-0" {
+		t.Errorf("unexpected page %v", sandboxes)
+	}
+	if count, _ := repo.CountSandboxes(ctx, filters); count != 2 {
+		t.Errorf("expected 2 matches, got %d", count)
+	}
+
+	// Soonest expiry first, as the admin UI shows what expires next
+	sandboxes, _ = repo.ListSandboxes(ctx, models.ListFilters{Sort: models.SortExpiresAt, Ascending: true, Limit: 2})
+	if len(sandboxes) != 2 || sandboxes[0].ID != "sb-0" || sandboxes[1].ID != "sb-1" {
+		t.Errorf("expected sb-0, sb-1 by expiry, got %v", sandboxes)
+	}
+
+	expired, _ := repo.GetExpiredSandboxes(ctx, 1)
+	if len(expired) != 1 || expired[0].ID != "sb-0" {
+		t.Errorf("expected the oldest expiry first, got %v", expired)
+	}
+	if count, _ := repo.CountExpiredSandboxes(ctx); count != 2 {
+		t.Errorf("expected 2 expired sandboxes, got %d", count)
+	}
+}
+
+func TestMemorySoftDelete(t *testing.T) {
+	repo := NewMemoryRepository()
+	ctx := context.Background()
+
+	if err := repo.CreateSandbox(ctx, &models.Sandbox{ID: "sb-1", UserID: "user-1", Status: models.StatusRunning}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.CreateService(ctx, "sb-1", &models.ServiceInstance{Name: "redis"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.DeleteSandbox(ctx, "sb-1", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if sb, _ := repo.GetSandbox(ctx, "sb-1"); sb != nil {
+		t.Error("expected a deleted sandbox not found")
+	}
+	if counts, _ := repo.CountSandboxesByHost(ctx, "default"); counts["default"] != 0 {
+		t.Errorf("expected a deleted sandbox not counted on its host, got %v", counts)
+	}
+	listed, _ := repo.ListSandboxes(ctx, models.ListFilters{IncludeDeleted: true})
+	if len(listed) != 1 || listed[0].DeletedAt == nil {
+		t.Fatalf("expected the deleted sandbox listed, got %v", listed)
+	}
+
+	// A hard delete removes a marked row and cascades to its services
+	if err := repo.DeleteSandbox(ctx, "sb-1", true); err != nil {
+		t.Fatal(err)
+	}
+	if listed, _ := repo.ListSandboxes(ctx, models.ListFilters{IncludeDeleted: true}); len(listed) != 0 {
+		t.Errorf("expected the row gone, got %v", listed)
+	}
+	if services, _ := repo.GetServices(ctx, "sb-1"); len(services) != 0 {
+		t.Errorf("expected services deleted with their sandbox, got %d", len(services))
+	}
+}
+
+func TestMemorySessionUniqueKey(t *testing.T) {
+	repo := NewMemoryRepository()
+	ctx := context.Background()
+
+	for _, id := range []string{"s1", "s2"} {
+		s := &models.Session{ID: id, Token: "tok-" + id, Status: models.SessionReady, UniqueKey: "candidate@example.com"}
+		if err := repo.CreateSession(ctx, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s1, _ := repo.GetSessionByID(ctx, "s1")
+	s1.Status = models.SessionActive
+	if err := repo.UpdateSession(ctx, s1); err != nil {
+		t.Fatal(err)
+	}
+
+	s2, _ := repo.GetSessionByID(ctx, "s2")
+	s2.Status = models.SessionProvisioning
+	if err := repo.UpdateSession(ctx, s2); !errors.Is(err, ErrUniqueKeyConflict) {
+		t.Errorf("expected ErrUniqueKeyConflict, got %v", err)
+	}
+}
+
+func TestMemoryWithTxRollsBack(t *testing.T) {
+	repo := NewMemoryRepository()
+	ctx := context.Background()
+
+	err := repo.WithTx(ctx, func(tx Repository) error {
+		if err := tx.CreateSandbox(ctx, &models.Sandbox{ID: "sb-1"}); err != nil {
+			return err
+		}
+		// Nested calls join the transaction
+		return tx.WithTx(ctx, func(tx Repository) error {
+			if err := tx.AppendEvent(ctx, &models.SandboxEvent{SandboxID: "sb-1", Type: models.EventCreated}); err != nil {
+				return err
+			}
+			return errors.New("provisioning failed")
+		})
+	})
+	if err == nil {
+		t.Fatal("expected the callback error")
+	}
+
+	if sb, _ := repo.GetSandbox(ctx, "sb-1"); sb != nil {
+		t.Error("expected the sandbox rolled back")
+	}
+	if events, _ := repo.ListEvents(ctx, "sb-1"); len(events) != 0 {
+		t.Errorf("expected the event rolled back, got %d", len(events))
+	}
+}
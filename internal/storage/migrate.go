@@ -2,18 +2,19 @@ package storage
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
+	"io/fs"
 	"log/slog"
 	"os"
-	"path/filepath"
 	"sort"
 	"strings"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-// RunMigrations executes all .sql migrations from the given directory
-func RunMigrations(ctx context.Context, pool *pgxpool.Pool, migrationsDir string) error {
+// RunMigrations executes all .sql migrations at the root of fsys
+func RunMigrations(ctx context.Context, pool *pgxpool.Pool, fsys fs.FS) error {
 	// Ensure migrations table exists
 	if err := createMigrationsTable(ctx, pool); err != nil {
 		return fmt.Errorf("failed to create migrations table: %w", err)
@@ -26,20 +27,11 @@ func RunMigrations(ctx context.Context, pool *pgxpool.Pool, migrationsDir string
 	}
 
 	// Read migration files
-	files, err := os.ReadDir(migrationsDir)
+	migrations, err := migrationFiles(fsys)
 	if err != nil {
-		return fmt.Errorf("failed to read migrations directory: %w", err)
+		return err
 	}
 
-	// Filter and sort .sql files
-	var migrations []string
-	for _, f := range files {
-		if !f.IsDir() && strings.HasSuffix(f.Name(), ".sql") {
-			migrations = append(migrations, f.Name())
-		}
-	}
-	sort.Strings(migrations)
-
 	// Apply pending migrations
 	for _, migration := range migrations {
 		if applied[migration] {
@@ -50,7 +42,7 @@ func RunMigrations(ctx context.Context, pool *pgxpool.Pool, migrationsDir string
 		slog.Info("applying migration", "migration", migration)
 
 		// Read migration file
-		content, err := os.ReadFile(filepath.Join(migrationsDir, migration))
+		content, err := fs.ReadFile(fsys, migration)
 		if err != nil {
 			return fmt.Errorf("failed to read migration %s: %w", migration, err)
 		}
@@ -83,6 +75,30 @@ func RunMigrations(ctx context.Context, pool *pgxpool.Pool, migrationsDir string
 	return nil
 }
 
+// RunMigrationsFromDir is RunMigrations for a migrations directory on disk
+func RunMigrationsFromDir(ctx context.Context, pool *pgxpool.Pool, migrationsDir string) error {
+	return RunMigrations(ctx, pool, os.DirFS(migrationsDir))
+}
+
+// migrationFiles returns the names of the .sql files at the root of fsys, in the
+// order they apply. Subdirectories, such as the SQLite set in sqlite/, are skipped.
+func migrationFiles(fsys fs.FS) ([]string, error) {
+	files, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations directory: %w", err)
+	}
+
+	var migrations []string
+	for _, f := range files {
+		if !f.IsDir() && strings.HasSuffix(f.Name(), ".sql") {
+			migrations = append(migrations, f.Name())
+		}
+	}
+	sort.Strings(migrations)
+
+	return migrations, nil
+}
+
 // createMigrationsTable creates the schema_migrations table if it doesn't exist
 func createMigrationsTable(ctx context.Context, pool *pgxpool.Pool) error {
 	query := `
@@ -116,12 +132,97 @@ func getAppliedMigrations(ctx context.Context, pool *pgxpool.Pool) (map[string]b
 }
 
 // MigrateFromDSN is a convenience function to run migrations with a DSN
-func MigrateFromDSN(ctx context.Context, dsn, migrationsDir string) error {
+func MigrateFromDSN(ctx context.Context, dsn string, fsys fs.FS) error {
 	pool, err := pgxpool.New(ctx, dsn)
 	if err != nil {
 		return fmt.Errorf("failed to connect to database: %w", err)
 	}
 	defer pool.Close()
 
-	return RunMigrations(ctx, pool, migrationsDir)
+	return RunMigrations(ctx, pool, fsys)
+}
+
+// RunSqliteMigrations executes all .sql migrations at the root of fsys against
+// a SQLite database, recording them in schema_migrations like RunMigrations
+func RunSqliteMigrations(ctx context.Context, db *sql.DB, fsys fs.FS) error {
+	query := `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			name VARCHAR(255) PRIMARY KEY,
+			applied_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+		)
+	`
+	if _, err := db.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("failed to create migrations table: %w", err)
+	}
+
+	applied := make(map[string]bool)
+	rows, err := db.QueryContext(ctx, `SELECT name FROM schema_migrations`)
+	if err != nil {
+		return fmt.Errorf("failed to get applied migrations: %w", err)
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to get applied migrations: %w", err)
+		}
+		applied[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to get applied migrations: %w", err)
+	}
+
+	migrations, err := migrationFiles(fsys)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range migrations {
+		if applied[migration] {
+			slog.Debug("migration already applied", "migration", migration)
+			continue
+		}
+
+		slog.Info("applying migration", "migration", migration)
+
+		content, err := fs.ReadFile(fsys, migration)
+		if err != nil {
+			return fmt.Errorf("failed to read migration %s: %w", migration, err)
+		}
+
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction for %s: %w", migration, err)
+		}
+
+		if _, err := tx.ExecContext(ctx, string(content)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to execute migration %s: %w", migration, err)
+		}
+
+		if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (name) VALUES (?)`, migration); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record migration %s: %w", migration, err)
+		}
+
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration %s: %w", migration, err)
+		}
+
+		slog.Info("migration applied successfully", "migration", migration)
+	}
+
+	return nil
+}
+
+// MigrateSqlite is MigrateFromDSN for a SQLite database file
+func MigrateSqlite(ctx context.Context, path string, fsys fs.FS) error {
+	db, err := openSqlite(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return RunSqliteMigrations(ctx, db, fsys)
 }
@@ -0,0 +1,26 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/terra-clan/sandbox-engine/migrations"
+)
+
+func TestEmbeddedMigrationsMatchDirectory(t *testing.T) {
+	embedded, err := migrationFiles(migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	onDisk, err := migrationFiles(os.DirFS(filepath.Join("..", "..", "migrations")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The sqlite/ set is a subdirectory and never runs against PostgreSQL
+	if len(embedded) == 0 || !slices.Equal(embedded, onDisk) {
+		t.Errorf("expected the embedded set to match the directory, got %v and %v", embedded, onDisk)
+	}
+}
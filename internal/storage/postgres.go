@@ -6,9 +6,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"github.com/terra-clan/sandbox-engine/internal/models"
@@ -17,11 +19,20 @@ import (
 // PostgresRepository implements Repository using PostgreSQL
 type PostgresRepository struct {
 	pool *pgxpool.Pool
+	// db runs statements: the pool, or the transaction of a repository handed out by WithTx
+	db   dbConn
+	inTx bool
+
+	// Optional read replica for stale-tolerant reads (listings, reports)
+	replica       *pgxpool.Pool
+	reads         *poolSelector
+	stopMonitorFn context.CancelFunc
 }
 
 // PostgresConfig holds PostgreSQL connection configuration
 type PostgresConfig struct {
 	DSN          string
+	ReadDSN      string // optional read replica
 	MaxOpenConns int32
 	MaxIdleConns int32
 	MaxLifetime  time.Duration
@@ -29,7 +40,83 @@ type PostgresConfig struct {
 
 // NewPostgresRepository creates a new PostgreSQL repository
 func NewPostgresRepository(ctx context.Context, cfg PostgresConfig) (*PostgresRepository, error) {
-	poolConfig, err := pgxpool.ParseConfig(cfg.DSN)
+	pool, err := newPool(ctx, cfg.DSN, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Test connection
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	r := &PostgresRepository{pool: pool, db: pool, reads: newPoolSelector(pool, nil)}
+
+	if cfg.ReadDSN != "" {
+		replica, err := newPool(ctx, cfg.ReadDSN, cfg)
+		if err != nil {
+			pool.Close()
+			return nil, fmt.Errorf("read replica: %w", err)
+		}
+
+		r.replica = replica
+		r.reads = newPoolSelector(pool, replica)
+
+		// An unreachable replica is not fatal: reads use the primary until it recovers
+		if err := r.reads.checkReplica(ctx); err != nil {
+			slog.Warn("read replica not reachable at startup", "error", err)
+		}
+
+		monitorCtx, cancel := context.WithCancel(context.Background())
+		r.stopMonitorFn = cancel
+		go r.reads.monitor(monitorCtx, replicaCheckInterval)
+	}
+
+	return r, nil
+}
+
+// dbConn is what repository statements run on, satisfied by both the pool and pgx.Tx
+type dbConn interface {
+	querier
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// WithTx runs fn with a repository whose statements share one transaction,
+// committing when fn returns nil and rolling back otherwise. Reads inside fn
+// go to the primary so they see the transaction's writes; calls on a
+// repository that is already in a transaction join it.
+func (r *PostgresRepository) WithTx(ctx context.Context, fn func(Repository) error) error {
+	if r.inTx {
+		return fn(r)
+	}
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	// A no-op once committed
+	defer func() { _ = tx.Rollback(context.WithoutCancel(ctx)) }()
+
+	txRepo := &PostgresRepository{
+		pool:  r.pool,
+		db:    tx,
+		inTx:  true,
+		reads: newPoolSelector(tx, nil),
+	}
+	if err := fn(txRepo); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// newPool creates a connection pool for dsn using the shared pool settings
+func newPool(ctx context.Context, dsn string, cfg PostgresConfig) (*pgxpool.Pool, error) {
+	poolConfig, err := pgxpool.ParseConfig(dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse DSN: %w", err)
 	}
@@ -58,22 +145,37 @@ func NewPostgresRepository(ctx context.Context, cfg PostgresConfig) (*PostgresRe
 		return nil, fmt.Errorf("failed to create connection pool: %w", err)
 	}
 
-	// Test connection
-	if err := pool.Ping(ctx); err != nil {
-		pool.Close()
-		return nil, fmt.Errorf("failed to ping database: %w", err)
-	}
-
-	return &PostgresRepository{pool: pool}, nil
+	return pool, nil
 }
 
-// Ping checks database connectivity
+// Ping checks database connectivity.
+// Only the primary is checked; a replica outage degrades reads but is not fatal.
 func (r *PostgresRepository) Ping(ctx context.Context) error {
 	return r.pool.Ping(ctx)
 }
 
-// Close closes the database connection pool
+// HasReadReplica reports whether a read replica is configured
+func (r *PostgresRepository) HasReadReplica() bool {
+	return r.replica != nil
+}
+
+// PingReplica checks read replica connectivity and updates read routing
+func (r *PostgresRepository) PingReplica(ctx context.Context) error {
+	return r.reads.checkReplica(ctx)
+}
+
+// Close closes the database connection pools. It is a no-op inside WithTx,
+// where the pools belong to the outer repository.
 func (r *PostgresRepository) Close() error {
+	if r.inTx {
+		return nil
+	}
+	if r.stopMonitorFn != nil {
+		r.stopMonitorFn()
+	}
+	if r.replica != nil {
+		r.replica.Close()
+	}
 	r.pool.Close()
 	return nil
 }
@@ -91,11 +193,11 @@ func (r *PostgresRepository) CreateSandbox(ctx context.Context, sb *models.Sandb
 	}
 
 	query := `
-		INSERT INTO sandboxes (id, template_id, user_id, status, status_message, container_id, created_at, started_at, expires_at, metadata, endpoints)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		INSERT INTO sandboxes (id, template_id, user_id, status, status_message, container_id, created_at, started_at, expires_at, metadata, endpoints, host, owner_client_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
 	`
 
-	_, err = r.pool.Exec(ctx, query,
+	_, err = r.db.Exec(ctx, query,
 		sb.ID,
 		sb.TemplateID,
 		sb.UserID,
@@ -107,6 +209,8 @@ func (r *PostgresRepository) CreateSandbox(ctx context.Context, sb *models.Sandb
 		sb.ExpiresAt,
 		metadataJSON,
 		endpointsJSON,
+		nullString(sb.Host),
+		nullInt(sb.OwnerClientID),
 	)
 
 	if err != nil {
@@ -116,21 +220,19 @@ func (r *PostgresRepository) CreateSandbox(ctx context.Context, sb *models.Sandb
 	return nil
 }
 
-// GetSandbox retrieves a sandbox by ID
-func (r *PostgresRepository) GetSandbox(ctx context.Context, id string) (*models.Sandbox, error) {
-	query := `
-		SELECT id, template_id, user_id, status, status_message, container_id, created_at, started_at, expires_at, metadata, endpoints
-		FROM sandboxes
-		WHERE id = $1
-	`
+// sandboxColumns is the column list shared by all sandbox SELECTs (see scanSandbox)
+const sandboxColumns = `id, template_id, user_id, status, status_message, container_id, created_at, started_at, expires_at, metadata, endpoints, last_activity_at, host, deleted_at, owner_client_id`
 
+// scanSandbox scans a row selected with sandboxColumns (services are not loaded)
+func scanSandbox(row pgx.Row) (*models.Sandbox, error) {
 	var sb models.Sandbox
 	var statusStr string
-	var statusMsg, containerID sql.NullString
-	var startedAt sql.NullTime
+	var statusMsg, containerID, host sql.NullString
+	var ownerClientID sql.NullInt64
+	var startedAt, lastActivityAt, deletedAt sql.NullTime
 	var metadataJSON, endpointsJSON []byte
 
-	err := r.pool.QueryRow(ctx, query, id).Scan(
+	err := row.Scan(
 		&sb.ID,
 		&sb.TemplateID,
 		&sb.UserID,
@@ -142,22 +244,30 @@ func (r *PostgresRepository) GetSandbox(ctx context.Context, id string) (*models
 		&sb.ExpiresAt,
 		&metadataJSON,
 		&endpointsJSON,
+		&lastActivityAt,
+		&host,
+		&deletedAt,
+		&ownerClientID,
 	)
-
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, nil // Not found
-		}
-		return nil, fmt.Errorf("failed to get sandbox: %w", err)
+		return nil, err
 	}
 
 	sb.Status = models.SandboxStatus(statusStr)
 	sb.StatusMsg = statusMsg.String
 	sb.ContainerID = containerID.String
+	sb.Host = host.String
+	sb.OwnerClientID = int(ownerClientID.Int64)
 
 	if startedAt.Valid {
 		sb.StartedAt = &startedAt.Time
 	}
+	if lastActivityAt.Valid {
+		sb.LastActivityAt = &lastActivityAt.Time
+	}
+	if deletedAt.Valid {
+		sb.DeletedAt = &deletedAt.Time
+	}
 
 	if err := json.Unmarshal(metadataJSON, &sb.Metadata); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal metadata: %w", err)
@@ -167,18 +277,99 @@ func (r *PostgresRepository) GetSandbox(ctx context.Context, id string) (*models
 		return nil, fmt.Errorf("failed to unmarshal endpoints: %w", err)
 	}
 
-	// Load services
-	services, err := r.GetServices(ctx, id)
+	return &sb, nil
+}
+
+// loadServices attaches service instances to a sandbox
+func (r *PostgresRepository) loadServices(ctx context.Context, db querier, sb *models.Sandbox) error {
+	services, err := getServices(ctx, db, sb.ID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get services: %w", err)
+		return err
 	}
 
 	sb.Services = make(map[string]*models.ServiceInstance)
 	for _, svc := range services {
 		sb.Services[svc.Name] = svc
 	}
+	return nil
+}
 
-	return &sb, nil
+// querySandboxes runs a sandbox SELECT on the primary and scans all rows, loading services for each
+func (r *PostgresRepository) querySandboxes(ctx context.Context, query string, args ...interface{}) ([]*models.Sandbox, error) {
+	return r.scanSandboxes(ctx, r.db, query, args...)
+}
+
+// readSandboxes is querySandboxes for stale-tolerant reads that may be served by the replica
+func (r *PostgresRepository) readSandboxes(ctx context.Context, query string, args ...interface{}) ([]*models.Sandbox, error) {
+	db := r.reads.reader(ctx)
+	sandboxes, err := r.scanSandboxes(ctx, db, query, args...)
+	if err != nil && db != r.reads.primary && ctx.Err() == nil {
+		r.reads.markReplicaDown(err)
+		return r.scanSandboxes(ctx, r.db, query, args...)
+	}
+	return sandboxes, err
+}
+
+// scanSandboxes runs a sandbox SELECT on db and scans all rows, loading services from the same pool
+func (r *PostgresRepository) scanSandboxes(ctx context.Context, db querier, query string, args ...interface{}) ([]*models.Sandbox, error) {
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	sandboxes, err := scanSandboxRows(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, sb := range sandboxes {
+		if err := r.loadServices(ctx, db, sb); err != nil {
+			return nil, fmt.Errorf("failed to get services for sandbox %s: %w", sb.ID, err)
+		}
+	}
+
+	return sandboxes, nil
+}
+
+// scanSandboxRows scans and closes rows without loading services
+func scanSandboxRows(rows pgx.Rows) ([]*models.Sandbox, error) {
+	defer rows.Close()
+
+	var sandboxes []*models.Sandbox
+
+	for rows.Next() {
+		sb, err := scanSandbox(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan sandbox: %w", err)
+		}
+		sandboxes = append(sandboxes, sb)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating sandboxes: %w", err)
+	}
+
+	return sandboxes, nil
+}
+
+// GetSandbox retrieves a sandbox by ID. Deleted sandboxes are not found.
+func (r *PostgresRepository) GetSandbox(ctx context.Context, id string) (*models.Sandbox, error) {
+	query := `SELECT ` + sandboxColumns + ` FROM sandboxes WHERE id = $1 AND deleted_at IS NULL`
+
+	sb, err := scanSandbox(r.db.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil // Not found
+		}
+		return nil, fmt.Errorf("failed to get sandbox: %w", err)
+	}
+
+	// Load services
+	if err := r.loadServices(ctx, r.db, sb); err != nil {
+		return nil, fmt.Errorf("failed to get services: %w", err)
+	}
+
+	return sb, nil
 }
 
 // UpdateSandbox updates an existing sandbox
@@ -196,10 +387,10 @@ func (r *PostgresRepository) UpdateSandbox(ctx context.Context, sb *models.Sandb
 	query := `
 		UPDATE sandboxes
 		SET status = $2, status_message = $3, container_id = $4, started_at = $5, expires_at = $6, metadata = $7, endpoints = $8
-		WHERE id = $1
+		WHERE id = $1 AND deleted_at IS NULL
 	`
 
-	result, err := r.pool.Exec(ctx, query,
+	result, err := r.db.Exec(ctx, query,
 		sb.ID,
 		string(sb.Status),
 		nullString(sb.StatusMsg),
@@ -221,11 +412,16 @@ func (r *PostgresRepository) UpdateSandbox(ctx context.Context, sb *models.Sandb
 	return nil
 }
 
-// DeleteSandbox deletes a sandbox by ID
-func (r *PostgresRepository) DeleteSandbox(ctx context.Context, id string) error {
-	query := `DELETE FROM sandboxes WHERE id = $1`
+// DeleteSandbox marks a sandbox deleted, keeping its record until
+// PurgeDeletedSandboxes removes it. hard deletes the row outright, whether or
+// not it was already marked.
+func (r *PostgresRepository) DeleteSandbox(ctx context.Context, id string, hard bool) error {
+	query := `UPDATE sandboxes SET deleted_at = NOW() WHERE id = $1 AND deleted_at IS NULL`
+	if hard {
+		query = `DELETE FROM sandboxes WHERE id = $1`
+	}
 
-	result, err := r.pool.Exec(ctx, query, id)
+	result, err := r.db.Exec(ctx, query, id)
 	if err != nil {
 		return fmt.Errorf("failed to delete sandbox: %w", err)
 	}
@@ -237,35 +433,112 @@ func (r *PostgresRepository) DeleteSandbox(ctx context.Context, id string) error
 	return nil
 }
 
-// ListSandboxes returns sandboxes matching filters
-func (r *PostgresRepository) ListSandboxes(ctx context.Context, filters models.ListFilters) ([]*models.Sandbox, error) {
-	query := `
-		SELECT id, template_id, user_id, status, status_message, container_id, created_at, started_at, expires_at, metadata, endpoints
-		FROM sandboxes
-		WHERE 1=1
-	`
+// PurgeDeletedSandboxes removes the records of sandboxes deleted before cutoff
+func (r *PostgresRepository) PurgeDeletedSandboxes(ctx context.Context, cutoff time.Time) (int64, error) {
+	result, err := r.db.Exec(ctx, `DELETE FROM sandboxes WHERE deleted_at < $1`, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge deleted sandboxes: %w", err)
+	}
+	return result.RowsAffected(), nil
+}
+
+// sandboxFilterWhere builds the WHERE clause shared by ListSandboxes and CountSandboxes.
+// Pagination is left to the caller; placeholders continue from len(args)+1.
+func sandboxFilterWhere(filters models.ListFilters) (string, []interface{}) {
+	where := ` WHERE 1=1`
 	args := make([]interface{}, 0)
 	argNum := 1
 
+	if !filters.IncludeDeleted {
+		where += " AND deleted_at IS NULL"
+	}
+
 	if filters.UserID != "" {
-		query += fmt.Sprintf(" AND user_id = $%d", argNum)
+		where += fmt.Sprintf(" AND user_id = $%d", argNum)
 		args = append(args, filters.UserID)
 		argNum++
 	}
 
 	if filters.TemplateID != "" {
-		query += fmt.Sprintf(" AND template_id = $%d", argNum)
+		where += fmt.Sprintf(" AND template_id = $%d", argNum)
 		args = append(args, filters.TemplateID)
 		argNum++
 	}
 
 	if filters.Status != "" {
-		query += fmt.Sprintf(" AND status = $%d", argNum)
+		where += fmt.Sprintf(" AND status = $%d", argNum)
 		args = append(args, string(filters.Status))
 		argNum++
 	}
 
-	query += " ORDER BY created_at DESC"
+	if filters.Host != "" {
+		where += fmt.Sprintf(" AND COALESCE(host, $%d) = $%d", argNum, argNum+1)
+		args = append(args, filters.DefaultHost, filters.Host)
+		argNum += 2
+	}
+
+	if filters.OwnerClientID != 0 {
+		where += fmt.Sprintf(" AND owner_client_id = $%d", argNum)
+		args = append(args, filters.OwnerClientID)
+		argNum++
+	}
+
+	if !filters.CreatedAfter.IsZero() {
+		where += fmt.Sprintf(" AND created_at >= $%d", argNum)
+		args = append(args, filters.CreatedAfter)
+		argNum++
+	}
+
+	if !filters.CreatedBefore.IsZero() {
+		where += fmt.Sprintf(" AND created_at < $%d", argNum)
+		args = append(args, filters.CreatedBefore)
+		argNum++
+	}
+
+	if len(filters.Metadata) > 0 {
+		// A map[string]string always marshals
+		metadataJSON, _ := json.Marshal(filters.Metadata)
+		where += fmt.Sprintf(" AND metadata @> $%d::jsonb", argNum)
+		args = append(args, string(metadataJSON))
+	}
+
+	return where, args
+}
+
+// sandboxSortColumns maps sort fields to columns, so user input never reaches the SQL
+var sandboxSortColumns = map[models.SandboxSort]string{
+	models.SortCreatedAt: "created_at",
+	models.SortExpiresAt: "expires_at",
+	models.SortStatus:    "status",
+	models.SortUserID:    "user_id",
+}
+
+// sandboxOrderBy builds the ORDER BY clause of sandbox listings, shared with
+// SQLite. Ties fall back to newest first, then ID, so pages are stable.
+func sandboxOrderBy(filters models.ListFilters) string {
+	column, ok := sandboxSortColumns[filters.Sort]
+	if !ok {
+		column = "created_at"
+	}
+	direction := "DESC"
+	if filters.Ascending {
+		direction = "ASC"
+	}
+
+	orderBy := " ORDER BY " + column + " " + direction
+	if column != "created_at" {
+		orderBy += ", created_at DESC"
+	}
+	return orderBy + ", id ASC"
+}
+
+// ListSandboxes returns sandboxes matching filters
+func (r *PostgresRepository) ListSandboxes(ctx context.Context, filters models.ListFilters) ([]*models.Sandbox, error) {
+	where, args := sandboxFilterWhere(filters)
+	query := `SELECT ` + sandboxColumns + ` FROM sandboxes` + where
+	argNum := len(args) + 1
+
+	query += sandboxOrderBy(filters)
 
 	if filters.Limit > 0 {
 		query += fmt.Sprintf(" LIMIT $%d", argNum)
@@ -278,152 +551,121 @@ func (r *PostgresRepository) ListSandboxes(ctx context.Context, filters models.L
 		args = append(args, filters.Offset)
 	}
 
-	rows, err := r.pool.Query(ctx, query, args...)
+	// Listings tolerate replica lag; callers needing read-your-writes use WithPrimary
+	sandboxes, err := r.readSandboxes(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list sandboxes: %w", err)
 	}
-	defer rows.Close()
-
-	var sandboxes []*models.Sandbox
-
-	for rows.Next() {
-		var sb models.Sandbox
-		var statusStr string
-		var statusMsg, containerID sql.NullString
-		var startedAt sql.NullTime
-		var metadataJSON, endpointsJSON []byte
 
-		err := rows.Scan(
-			&sb.ID,
-			&sb.TemplateID,
-			&sb.UserID,
-			&statusStr,
-			&statusMsg,
-			&containerID,
-			&sb.CreatedAt,
-			&startedAt,
-			&sb.ExpiresAt,
-			&metadataJSON,
-			&endpointsJSON,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan sandbox: %w", err)
-		}
-
-		sb.Status = models.SandboxStatus(statusStr)
-		sb.StatusMsg = statusMsg.String
-		sb.ContainerID = containerID.String
-
-		if startedAt.Valid {
-			sb.StartedAt = &startedAt.Time
-		}
-
-		if err := json.Unmarshal(metadataJSON, &sb.Metadata); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal metadata: %w", err)
-		}
-
-		if err := json.Unmarshal(endpointsJSON, &sb.Endpoints); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal endpoints: %w", err)
-		}
-
-		// Load services for this sandbox
-		services, err := r.GetServices(ctx, sb.ID)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get services for sandbox %s: %w", sb.ID, err)
-		}
+	return sandboxes, nil
+}
 
-		sb.Services = make(map[string]*models.ServiceInstance)
-		for _, svc := range services {
-			sb.Services[svc.Name] = svc
-		}
+// CountSandboxes counts sandboxes matching filters, ignoring Limit and Offset
+func (r *PostgresRepository) CountSandboxes(ctx context.Context, filters models.ListFilters) (int, error) {
+	where, args := sandboxFilterWhere(filters)
 
-		sandboxes = append(sandboxes, &sb)
+	// Counts back listings, so they tolerate the same replica lag
+	rows, err := r.reads.query(ctx, `SELECT COUNT(*) FROM sandboxes`+where, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count sandboxes: %w", err)
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating sandboxes: %w", err)
+	count, err := pgx.CollectOneRow(rows, pgx.RowTo[int])
+	if err != nil {
+		return 0, fmt.Errorf("failed to count sandboxes: %w", err)
 	}
 
-	return sandboxes, nil
+	return count, nil
 }
 
-// GetExpiredSandboxes returns all non-terminal sandboxes that have expired
-func (r *PostgresRepository) GetExpiredSandboxes(ctx context.Context) ([]*models.Sandbox, error) {
-	query := `
-		SELECT id, template_id, user_id, status, status_message, container_id, created_at, started_at, expires_at, metadata, endpoints
+// expiredSandboxesWhere matches sandboxes past their TTL that still hold resources.
+// Stopped sandboxes are included: they keep their container and services until expiry.
+// Deleting ones are not: the cleanup worker retries their delete separately.
+const expiredSandboxesWhere = `status NOT IN ('failed', 'expired', 'deleting') AND expires_at < NOW() AND deleted_at IS NULL`
+
+// GetExpiredSandboxes returns up to limit expired sandboxes, oldest expiry first.
+// Services are not loaded: the cleaner only needs IDs, and Delete reloads the sandbox.
+func (r *PostgresRepository) GetExpiredSandboxes(ctx context.Context, limit int) ([]*models.Sandbox, error) {
+	query := `SELECT ` + sandboxColumns + `
 		FROM sandboxes
-		WHERE status NOT IN ('stopped', 'failed', 'expired')
-		  AND expires_at < NOW()
+		WHERE ` + expiredSandboxesWhere + `
 		ORDER BY expires_at ASC
+		LIMIT $1
 	`
 
-	rows, err := r.pool.Query(ctx, query)
+	rows, err := r.db.Query(ctx, query, limit)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get expired sandboxes: %w", err)
 	}
-	defer rows.Close()
 
-	var sandboxes []*models.Sandbox
+	sandboxes, err := scanSandboxRows(rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get expired sandboxes: %w", err)
+	}
 
-	for rows.Next() {
-		var sb models.Sandbox
-		var statusStr string
-		var statusMsg, containerID sql.NullString
-		var startedAt sql.NullTime
-		var metadataJSON, endpointsJSON []byte
+	return sandboxes, nil
+}
 
-		err := rows.Scan(
-			&sb.ID,
-			&sb.TemplateID,
-			&sb.UserID,
-			&statusStr,
-			&statusMsg,
-			&containerID,
-			&sb.CreatedAt,
-			&startedAt,
-			&sb.ExpiresAt,
-			&metadataJSON,
-			&endpointsJSON,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan sandbox: %w", err)
-		}
+// CountExpiredSandboxes counts expired sandboxes not yet cleaned up
+func (r *PostgresRepository) CountExpiredSandboxes(ctx context.Context) (int, error) {
+	var count int
+	err := r.db.QueryRow(ctx, `SELECT COUNT(*) FROM sandboxes WHERE `+expiredSandboxesWhere).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count expired sandboxes: %w", err)
+	}
+	return count, nil
+}
 
-		sb.Status = models.SandboxStatus(statusStr)
-		sb.StatusMsg = statusMsg.String
-		sb.ContainerID = containerID.String
+// GetIdleSandboxes returns running sandboxes with no terminal activity since the given time.
+// Sandboxes that never saw activity are measured from when they started.
+func (r *PostgresRepository) GetIdleSandboxes(ctx context.Context, idleSince time.Time) ([]*models.Sandbox, error) {
+	query := `SELECT ` + sandboxColumns + `
+		FROM sandboxes
+		WHERE status = 'running' AND deleted_at IS NULL
+		  AND COALESCE(last_activity_at, started_at, created_at) < $1
+		ORDER BY COALESCE(last_activity_at, started_at, created_at) ASC
+	`
 
-		if startedAt.Valid {
-			sb.StartedAt = &startedAt.Time
-		}
+	sandboxes, err := r.querySandboxes(ctx, query, idleSince)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get idle sandboxes: %w", err)
+	}
 
-		if err := json.Unmarshal(metadataJSON, &sb.Metadata); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal metadata: %w", err)
-		}
+	return sandboxes, nil
+}
 
-		if err := json.Unmarshal(endpointsJSON, &sb.Endpoints); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal endpoints: %w", err)
-		}
+// TouchSandboxActivity records terminal activity for a sandbox
+func (r *PostgresRepository) TouchSandboxActivity(ctx context.Context, id string, at time.Time) error {
+	query := `UPDATE sandboxes SET last_activity_at = $2 WHERE id = $1 AND deleted_at IS NULL`
 
-		// Load services
-		services, err := r.GetServices(ctx, sb.ID)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get services for sandbox %s: %w", sb.ID, err)
-		}
+	if _, err := r.db.Exec(ctx, query, id, at); err != nil {
+		return fmt.Errorf("failed to update sandbox activity: %w", err)
+	}
 
-		sb.Services = make(map[string]*models.ServiceInstance)
-		for _, svc := range services {
-			sb.Services[svc.Name] = svc
-		}
+	return nil
+}
 
-		sandboxes = append(sandboxes, &sb)
+// ExtendSandboxFrom writes the expiry and metadata of sb while the sandbox is
+// running and its stored expiry is still from. It reports false when another
+// extension or transition got there first or the sandbox is gone.
+func (r *PostgresRepository) ExtendSandboxFrom(ctx context.Context, sb *models.Sandbox, from time.Time) (bool, error) {
+	metadataJSON, err := json.Marshal(sb.Metadata)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal metadata: %w", err)
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating expired sandboxes: %w", err)
+	query := `
+		UPDATE sandboxes
+		SET expires_at = $2, metadata = $3
+		WHERE id = $1 AND expires_at = $4 AND status = $5 AND deleted_at IS NULL
+	`
+
+	result, err := r.db.Exec(ctx, query, sb.ID, sb.ExpiresAt, metadataJSON, from, string(models.StatusRunning))
+	if err != nil {
+		return false, fmt.Errorf("failed to extend sandbox: %w", err)
 	}
 
-	return sandboxes, nil
+	return result.RowsAffected() > 0, nil
 }
 
 // CreateService creates a new service instance for a sandbox
@@ -434,19 +676,22 @@ func (r *PostgresRepository) CreateService(ctx context.Context, sandboxID string
 	}
 
 	query := `
-		INSERT INTO sandbox_services (sandbox_id, service_name, service_type, status, credentials, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
+		INSERT INTO sandbox_services (sandbox_id, service_name, service_type, status, credentials, created_at, mode, container_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 		ON CONFLICT (sandbox_id, service_name) DO UPDATE
-		SET status = EXCLUDED.status, credentials = EXCLUDED.credentials
+		SET status = EXCLUDED.status, credentials = EXCLUDED.credentials,
+			mode = EXCLUDED.mode, container_id = EXCLUDED.container_id
 	`
 
-	_, err = r.pool.Exec(ctx, query,
+	_, err = r.db.Exec(ctx, query,
 		sandboxID,
 		svc.Name,
 		svc.Type,
 		svc.Status,
 		credentialsJSON,
 		svc.CreatedAt,
+		nullString(string(svc.Mode)),
+		nullString(svc.ContainerID),
 	)
 
 	if err != nil {
@@ -458,13 +703,19 @@ func (r *PostgresRepository) CreateService(ctx context.Context, sandboxID string
 
 // GetServices retrieves all services for a sandbox
 func (r *PostgresRepository) GetServices(ctx context.Context, sandboxID string) ([]*models.ServiceInstance, error) {
+	return getServices(ctx, r.db, sandboxID)
+}
+
+// getServices retrieves the services of a sandbox from db
+func getServices(ctx context.Context, db querier, sandboxID string) ([]*models.ServiceInstance, error) {
 	query := `
-		SELECT service_name, service_type, status, credentials, created_at
+		SELECT service_name, service_type, status, credentials, created_at, status_message, last_checked_at, mode, container_id
 		FROM sandbox_services
 		WHERE sandbox_id = $1
+		ORDER BY service_name
 	`
 
-	rows, err := r.pool.Query(ctx, query, sandboxID)
+	rows, err := db.Query(ctx, query, sandboxID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get services: %w", err)
 	}
@@ -475,6 +726,8 @@ func (r *PostgresRepository) GetServices(ctx context.Context, sandboxID string)
 	for rows.Next() {
 		var svc models.ServiceInstance
 		var credentialsJSON []byte
+		var statusMsg, mode, containerID sql.NullString
+		var lastCheckedAt sql.NullTime
 
 		err := rows.Scan(
 			&svc.Name,
@@ -482,11 +735,22 @@ func (r *PostgresRepository) GetServices(ctx context.Context, sandboxID string)
 			&svc.Status,
 			&credentialsJSON,
 			&svc.CreatedAt,
+			&statusMsg,
+			&lastCheckedAt,
+			&mode,
+			&containerID,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan service: %w", err)
 		}
 
+		svc.StatusMsg = statusMsg.String
+		svc.Mode = models.ServiceMode(mode.String)
+		svc.ContainerID = containerID.String
+		if lastCheckedAt.Valid {
+			svc.LastCheckedAt = &lastCheckedAt.Time
+		}
+
 		if credentialsJSON != nil {
 			if err := json.Unmarshal(credentialsJSON, &svc.Credentials); err != nil {
 				return nil, fmt.Errorf("failed to unmarshal credentials: %w", err)
@@ -512,11 +776,12 @@ func (r *PostgresRepository) UpdateService(ctx context.Context, sandboxID string
 
 	query := `
 		UPDATE sandbox_services
-		SET status = $3, credentials = $4
+		SET status = $3, credentials = $4, status_message = $5, last_checked_at = $6
 		WHERE sandbox_id = $1 AND service_name = $2
 	`
 
-	result, err := r.pool.Exec(ctx, query, sandboxID, svc.Name, svc.Status, credentialsJSON)
+	result, err := r.db.Exec(ctx, query, sandboxID, svc.Name, svc.Status, credentialsJSON,
+		nullString(svc.StatusMsg), svc.LastCheckedAt)
 	if err != nil {
 		return fmt.Errorf("failed to update service: %w", err)
 	}
@@ -532,7 +797,7 @@ func (r *PostgresRepository) UpdateService(ctx context.Context, sandboxID string
 func (r *PostgresRepository) DeleteServices(ctx context.Context, sandboxID string) error {
 	query := `DELETE FROM sandbox_services WHERE sandbox_id = $1`
 
-	_, err := r.pool.Exec(ctx, query, sandboxID)
+	_, err := r.db.Exec(ctx, query, sandboxID)
 	if err != nil {
 		return fmt.Errorf("failed to delete services: %w", err)
 	}
@@ -540,19 +805,16 @@ func (r *PostgresRepository) DeleteServices(ctx context.Context, sandboxID strin
 	return nil
 }
 
-// GetClientByApiKey retrieves an API client by its key
-func (r *PostgresRepository) GetClientByApiKey(ctx context.Context, apiKey string) (*models.ApiClient, error) {
-	query := `
-		SELECT id, name, api_key, is_active, created_at, last_used_at, permissions, metadata
-		FROM api_clients
-		WHERE api_key = $1
-	`
+// clientColumns is the column list shared by all api_clients SELECTs (see scanClient)
+const clientColumns = `id, name, api_key, is_active, created_at, last_used_at, permissions, metadata, expires_at, allowed_cidrs`
 
+// scanClient scans a row selected with clientColumns
+func scanClient(row pgx.Row) (*models.ApiClient, error) {
 	var client models.ApiClient
-	var lastUsedAt sql.NullTime
-	var permissionsJSON, metadataJSON []byte
+	var lastUsedAt, expiresAt sql.NullTime
+	var permissionsJSON, metadataJSON, cidrsJSON []byte
 
-	err := r.pool.QueryRow(ctx, query, apiKey).Scan(
+	err := row.Scan(
 		&client.ID,
 		&client.Name,
 		&client.ApiKey,
@@ -561,18 +823,22 @@ func (r *PostgresRepository) GetClientByApiKey(ctx context.Context, apiKey strin
 		&lastUsedAt,
 		&permissionsJSON,
 		&metadataJSON,
+		&expiresAt,
+		&cidrsJSON,
 	)
-
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, nil // Not found
-		}
-		return nil, fmt.Errorf("failed to get api client: %w", err)
+		return nil, err
 	}
 
 	if lastUsedAt.Valid {
 		client.LastUsedAt = &lastUsedAt.Time
 	}
+	if expiresAt.Valid {
+		client.ExpiresAt = &expiresAt.Time
+	}
+	if err := unmarshalClientCIDRs(cidrsJSON, &client); err != nil {
+		return nil, err
+	}
 
 	// Parse permissions JSON array
 	if permissionsJSON != nil {
@@ -591,91 +857,350 @@ func (r *PostgresRepository) GetClientByApiKey(ctx context.Context, apiKey strin
 	return &client, nil
 }
 
-// UpdateClientLastUsed updates the last_used_at timestamp for a client
-func (r *PostgresRepository) UpdateClientLastUsed(ctx context.Context, apiKey string) error {
-	query := `UPDATE api_clients SET last_used_at = NOW() WHERE api_key = $1`
-
-	_, err := r.pool.Exec(ctx, query, apiKey)
+// getClient runs a single-client SELECT, returning nil, nil when no row matches
+func (r *PostgresRepository) getClient(ctx context.Context, query string, arg interface{}) (*models.ApiClient, error) {
+	client, err := scanClient(r.db.QueryRow(ctx, query, arg))
 	if err != nil {
-		return fmt.Errorf("failed to update client last_used_at: %w", err)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil // Not found
+		}
+		return nil, fmt.Errorf("failed to get api client: %w", err)
+	}
+	return client, nil
+}
+
+// GetClientByApiKey retrieves an API client by its key
+func (r *PostgresRepository) GetClientByApiKey(ctx context.Context, apiKey string) (*models.ApiClient, error) {
+	return r.getClient(ctx, `SELECT `+clientColumns+` FROM api_clients WHERE api_key = $1`, apiKey)
+}
+
+// GetClient retrieves an API client by ID
+func (r *PostgresRepository) GetClient(ctx context.Context, id int) (*models.ApiClient, error) {
+	return r.getClient(ctx, `SELECT `+clientColumns+` FROM api_clients WHERE id = $1`, id)
+}
+
+// ListClients returns all API clients, oldest first
+func (r *PostgresRepository) ListClients(ctx context.Context) ([]*models.ApiClient, error) {
+	rows, err := r.db.Query(ctx, `SELECT `+clientColumns+` FROM api_clients ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list api clients: %w", err)
+	}
+	defer rows.Close()
+
+	var clients []*models.ApiClient
+	for rows.Next() {
+		client, err := scanClient(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan api client: %w", err)
+		}
+		clients = append(clients, client)
+	}
+
+	return clients, rows.Err()
+}
+
+// marshalClient encodes the JSON columns of an API client
+func marshalClient(c *models.ApiClient) (permissionsJSON, metadataJSON, cidrsJSON []byte, err error) {
+	permissions := c.Permissions
+	if permissions == nil {
+		permissions = []string{}
+	}
+	if permissionsJSON, err = json.Marshal(permissions); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to marshal permissions: %w", err)
+	}
+
+	metadata := c.Metadata
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	if metadataJSON, err = json.Marshal(metadata); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+
+	cidrs := c.AllowedCIDRs
+	if cidrs == nil {
+		cidrs = []string{}
+	}
+	if cidrsJSON, err = json.Marshal(cidrs); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to marshal allowed cidrs: %w", err)
 	}
 
+	return permissionsJSON, metadataJSON, cidrsJSON, nil
+}
+
+// unmarshalClientCIDRs decodes the allowed_cidrs column into c
+func unmarshalClientCIDRs(cidrsJSON []byte, c *models.ApiClient) error {
+	if len(cidrsJSON) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(cidrsJSON, &c.AllowedCIDRs); err != nil {
+		return fmt.Errorf("failed to unmarshal allowed cidrs: %w", err)
+	}
+	if len(c.AllowedCIDRs) == 0 {
+		c.AllowedCIDRs = nil
+	}
 	return nil
 }
 
-// --- Sessions ---
+// CreateClient creates an API client, setting its ID and creation time
+func (r *PostgresRepository) CreateClient(ctx context.Context, c *models.ApiClient) error {
+	permissionsJSON, metadataJSON, cidrsJSON, err := marshalClient(c)
+	if err != nil {
+		return err
+	}
 
-// CreateSession creates a new session record
-func (r *PostgresRepository) CreateSession(ctx context.Context, s *models.Session) error {
-	envJSON, err := json.Marshal(s.Env)
+	query := `
+		INSERT INTO api_clients (name, api_key, is_active, permissions, metadata, expires_at, allowed_cidrs)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at
+	`
+
+	err = r.db.QueryRow(ctx, query, c.Name, c.ApiKey, c.IsActive, permissionsJSON, metadataJSON, nullTime(c.ExpiresAt), cidrsJSON).Scan(&c.ID, &c.CreatedAt)
 	if err != nil {
-		return fmt.Errorf("failed to marshal env: %w", err)
+		return fmt.Errorf("failed to create api client: %w", err)
 	}
 
-	metadataJSON, err := json.Marshal(s.Metadata)
+	return nil
+}
+
+// UpdateClient updates the name, active flag, permissions, metadata, expiry
+// and allowed CIDRs of an API client. Its key only changes through RotateClientKey.
+func (r *PostgresRepository) UpdateClient(ctx context.Context, c *models.ApiClient) error {
+	permissionsJSON, metadataJSON, cidrsJSON, err := marshalClient(c)
 	if err != nil {
-		return fmt.Errorf("failed to marshal metadata: %w", err)
+		return err
 	}
 
-	servicesJSON, err := json.Marshal(s.Services)
+	query := `
+		UPDATE api_clients
+		SET name = $2, is_active = $3, permissions = $4, metadata = $5, expires_at = $6, allowed_cidrs = $7
+		WHERE id = $1
+	`
+
+	result, err := r.db.Exec(ctx, query, c.ID, c.Name, c.IsActive, permissionsJSON, metadataJSON, nullTime(c.ExpiresAt), cidrsJSON)
 	if err != nil {
-		return fmt.Errorf("failed to marshal services: %w", err)
+		return fmt.Errorf("failed to update api client: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("api client not found: %d", c.ID)
+	}
+
+	return nil
+}
+
+// RotateClientKey replaces the key of an API client. The old key stops
+// authenticating at once; last_used_at is kept.
+func (r *PostgresRepository) RotateClientKey(ctx context.Context, id int, apiKey string) error {
+	result, err := r.db.Exec(ctx, `UPDATE api_clients SET api_key = $2 WHERE id = $1`, id, apiKey)
+	if err != nil {
+		return fmt.Errorf("failed to rotate api client key: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("api client not found: %d", id)
+	}
+
+	return nil
+}
+
+// DeactivateExpiredClients marks active clients whose key expired by now as
+// inactive and returns them
+func (r *PostgresRepository) DeactivateExpiredClients(ctx context.Context, now time.Time) ([]*models.ApiClient, error) {
+	query := `
+		UPDATE api_clients SET is_active = false
+		WHERE is_active AND expires_at IS NOT NULL AND expires_at <= $1
+		RETURNING ` + clientColumns
+
+	rows, err := r.db.Query(ctx, query, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to deactivate expired api clients: %w", err)
+	}
+	defer rows.Close()
+
+	var clients []*models.ApiClient
+	for rows.Next() {
+		client, err := scanClient(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan api client: %w", err)
+		}
+		clients = append(clients, client)
+	}
+
+	return clients, rows.Err()
+}
+
+// UpdateClientLastUsed updates the last_used_at timestamp for a client
+func (r *PostgresRepository) UpdateClientLastUsed(ctx context.Context, apiKey string) error {
+	query := `UPDATE api_clients SET last_used_at = NOW() WHERE api_key = $1`
+
+	_, err := r.db.Exec(ctx, query, apiKey)
+	if err != nil {
+		return fmt.Errorf("failed to update client last_used_at: %w", err)
+	}
+
+	return nil
+}
+
+// EnsureJWTClient returns the ID of the client row of a JWT subject, creating
+// it on first use. The row is inactive with no permissions, so its random key
+// never authenticates; last_used_at records the subject's latest lookup.
+func (r *PostgresRepository) EnsureJWTClient(ctx context.Context, subject, name string) (int, error) {
+	apiKey, err := models.GenerateApiKey()
+	if err != nil {
+		return 0, fmt.Errorf("failed to generate api key: %w", err)
 	}
 
 	query := `
-		INSERT INTO sessions (id, token, template_id, status, status_message, env, metadata, services, ttl_seconds, sandbox_id, task_description, created_at, activated_at, expires_at, created_by)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		INSERT INTO api_clients (name, api_key, is_active, permissions, jwt_subject, last_used_at)
+		VALUES ($1, $2, false, '[]', $3, NOW())
+		ON CONFLICT (jwt_subject) DO UPDATE SET last_used_at = NOW()
+		RETURNING id
 	`
 
-	_, err = r.pool.Exec(ctx, query,
-		s.ID,
-		s.Token,
-		s.TemplateID,
-		string(s.Status),
-		nullString(s.StatusMessage),
-		envJSON,
-		metadataJSON,
-		servicesJSON,
-		s.TTLSeconds,
-		nullString(s.SandboxID),
-		s.TaskDescription,
-		s.CreatedAt,
-		nullTime(s.ActivatedAt),
-		nullTime(s.ExpiresAt),
-		nullString(s.CreatedBy),
-	)
+	var id int
+	if err := r.db.QueryRow(ctx, query, name, apiKey, subject).Scan(&id); err != nil {
+		return 0, fmt.Errorf("failed to ensure jwt client: %w", err)
+	}
+	return id, nil
+}
+
+// --- Docker hosts ---
+
+// GetHostStates returns the persisted scheduling state of all known hosts, keyed by name
+func (r *PostgresRepository) GetHostStates(ctx context.Context) (map[string]*models.HostState, error) {
+	query := `SELECT name, draining, drain_mode, updated_at FROM docker_hosts`
 
+	rows, err := r.db.Query(ctx, query)
 	if err != nil {
-		return fmt.Errorf("failed to create session: %w", err)
+		return nil, fmt.Errorf("failed to get host states: %w", err)
+	}
+	defer rows.Close()
+
+	states := make(map[string]*models.HostState)
+	for rows.Next() {
+		var st models.HostState
+		var mode sql.NullString
+		if err := rows.Scan(&st.Name, &st.Draining, &mode, &st.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan host state: %w", err)
+		}
+		st.DrainMode = models.DrainMode(mode.String)
+		states[st.Name] = &st
+	}
+
+	return states, rows.Err()
+}
+
+// SetHostState creates or updates the scheduling state of a host
+func (r *PostgresRepository) SetHostState(ctx context.Context, st *models.HostState) error {
+	query := `
+		INSERT INTO docker_hosts (name, draining, drain_mode, updated_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (name) DO UPDATE
+		SET draining = EXCLUDED.draining, drain_mode = EXCLUDED.drain_mode, updated_at = NOW()
+		RETURNING updated_at
+	`
+
+	if err := r.db.QueryRow(ctx, query, st.Name, st.Draining, nullString(string(st.DrainMode))).Scan(&st.UpdatedAt); err != nil {
+		return fmt.Errorf("failed to set host state: %w", err)
 	}
 
 	return nil
 }
 
-// GetSessionByToken retrieves a session by its join token
-func (r *PostgresRepository) GetSessionByToken(ctx context.Context, token string) (*models.Session, error) {
-	return r.getSession(ctx, "token", token)
+// AssignUnplacedSandboxes records host on sandboxes created before placement was tracked
+func (r *PostgresRepository) AssignUnplacedSandboxes(ctx context.Context, host string) (int64, error) {
+	result, err := r.db.Exec(ctx, `UPDATE sandboxes SET host = $1 WHERE host IS NULL AND deleted_at IS NULL`, host)
+	if err != nil {
+		return 0, fmt.Errorf("failed to assign unplaced sandboxes: %w", err)
+	}
+	return result.RowsAffected(), nil
 }
 
-// GetSessionByID retrieves a session by its ID
-func (r *PostgresRepository) GetSessionByID(ctx context.Context, id string) (*models.Session, error) {
-	return r.getSession(ctx, "id", id)
+// CountSandboxesByHost counts sandboxes still holding resources per host.
+// Sandboxes created before placement was recorded are counted on defaultHost.
+func (r *PostgresRepository) CountSandboxesByHost(ctx context.Context, defaultHost string) (map[string]int, error) {
+	query := `
+		SELECT COALESCE(host, $1), COUNT(*)
+		FROM sandboxes
+		WHERE status NOT IN ('failed', 'expired', 'deleting') AND deleted_at IS NULL
+		GROUP BY 1
+	`
+
+	rows, err := r.db.Query(ctx, query, defaultHost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count sandboxes by host: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var host string
+		var n int
+		if err := rows.Scan(&host, &n); err != nil {
+			return nil, fmt.Errorf("failed to scan host count: %w", err)
+		}
+		counts[host] = n
+	}
+
+	return counts, rows.Err()
 }
 
-func (r *PostgresRepository) getSession(ctx context.Context, field, value string) (*models.Session, error) {
-	query := fmt.Sprintf(`
-		SELECT id, token, template_id, status, status_message, env, metadata, services, ttl_seconds, sandbox_id, task_description, created_at, activated_at, expires_at, created_by
-		FROM sessions
-		WHERE %s = $1
-	`, field)
+// CountGPUSandboxesByHost counts sandboxes holding GPUs per host, i.e. those
+// whose metadata records a resources.gpus grant
+func (r *PostgresRepository) CountGPUSandboxesByHost(ctx context.Context, defaultHost string) (map[string]int, error) {
+	query := `
+		SELECT COALESCE(host, $1), COUNT(*)
+		FROM sandboxes
+		WHERE status NOT IN ('failed', 'expired', 'deleting') AND deleted_at IS NULL AND metadata ? 'resources.gpus'
+		GROUP BY 1
+	`
+
+	rows, err := r.db.Query(ctx, query, defaultHost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count GPU sandboxes by host: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var host string
+		var n int
+		if err := rows.Scan(&host, &n); err != nil {
+			return nil, fmt.Errorf("failed to scan host count: %w", err)
+		}
+		counts[host] = n
+	}
+
+	return counts, rows.Err()
+}
 
+// --- Sessions ---
+
+// ErrUniqueKeyConflict is returned when a session can't become provisioning or
+// active because another session with the same unique key already is
+var ErrUniqueKeyConflict = errors.New("another session with this unique key is active")
+
+const (
+	// sessionUniqueKeyIndex is the partial unique index enforcing ErrUniqueKeyConflict
+	sessionUniqueKeyIndex = "idx_sessions_unique_key_active"
+	// uniqueViolation is the PostgreSQL unique_violation error code
+	uniqueViolation = "23505"
+)
+
+// sessionColumns is the column list shared by all session SELECTs (see scanSession)
+const sessionColumns = `id, token, template_id, status, status_message, env, metadata, services, ttl_seconds, sandbox_id, task_description, created_at, activated_at, expires_at, created_by, unique_key, on_conflict, revoked_at, revoked_by, join_by, submitted_at, extended_seconds, sandbox_status, prewarm_at, callback_url, callback_secret, record_terminal, owner_client_id`
+
+// scanSession scans a row selected with sessionColumns
+func scanSession(row pgx.Row) (*models.Session, error) {
 	var s models.Session
 	var statusStr string
-	var statusMsg, sandboxID, createdBy sql.NullString
-	var activatedAt, expiresAt sql.NullTime
+	var statusMsg, sandboxID, createdBy, uniqueKey, onConflict, revokedBy, sandboxStatus, callbackURL, callbackSecret sql.NullString
+	var activatedAt, expiresAt, revokedAt, joinBy, submittedAt, prewarmAt sql.NullTime
+	var ownerClientID sql.NullInt64
 	var envJSON, metadataJSON, servicesJSON []byte
 
-	err := r.pool.QueryRow(ctx, query, value).Scan(
+	err := row.Scan(
 		&s.ID,
 		&s.Token,
 		&s.TemplateID,
@@ -691,19 +1216,35 @@ func (r *PostgresRepository) getSession(ctx context.Context, field, value string
 		&activatedAt,
 		&expiresAt,
 		&createdBy,
+		&uniqueKey,
+		&onConflict,
+		&revokedAt,
+		&revokedBy,
+		&joinBy,
+		&submittedAt,
+		&s.ExtendedSeconds,
+		&sandboxStatus,
+		&prewarmAt,
+		&callbackURL,
+		&callbackSecret,
+		&s.RecordTerminal,
+		&ownerClientID,
 	)
-
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, nil
-		}
-		return nil, fmt.Errorf("failed to get session: %w", err)
+		return nil, err
 	}
 
 	s.Status = models.SessionStatus(statusStr)
 	s.StatusMessage = statusMsg.String
 	s.SandboxID = sandboxID.String
 	s.CreatedBy = createdBy.String
+	s.OwnerClientID = int(ownerClientID.Int64)
+	s.RevokedBy = revokedBy.String
+	s.UniqueKey = uniqueKey.String
+	s.OnConflict = models.ConflictPolicy(onConflict.String)
+	s.SandboxStatus = models.SandboxStatus(sandboxStatus.String)
+	s.CallbackURL = callbackURL.String
+	s.CallbackSecret = callbackSecret.String
 
 	if activatedAt.Valid {
 		s.ActivatedAt = &activatedAt.Time
@@ -711,6 +1252,18 @@ func (r *PostgresRepository) getSession(ctx context.Context, field, value string
 	if expiresAt.Valid {
 		s.ExpiresAt = &expiresAt.Time
 	}
+	if revokedAt.Valid {
+		s.RevokedAt = &revokedAt.Time
+	}
+	if joinBy.Valid {
+		s.JoinBy = &joinBy.Time
+	}
+	if submittedAt.Valid {
+		s.SubmittedAt = &submittedAt.Time
+	}
+	if prewarmAt.Valid {
+		s.PrewarmAt = &prewarmAt.Time
+	}
 
 	if envJSON != nil {
 		if err := json.Unmarshal(envJSON, &s.Env); err != nil {
@@ -733,8 +1286,24 @@ func (r *PostgresRepository) getSession(ctx context.Context, field, value string
 	return &s, nil
 }
 
-// UpdateSession updates an existing session
-func (r *PostgresRepository) UpdateSession(ctx context.Context, s *models.Session) error {
+// scanSessions scans all rows selected with sessionColumns
+func scanSessions(rows pgx.Rows) ([]*models.Session, error) {
+	defer rows.Close()
+
+	var sessions []*models.Session
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		sessions = append(sessions, s)
+	}
+
+	return sessions, rows.Err()
+}
+
+// CreateSession creates a new session record
+func (r *PostgresRepository) CreateSession(ctx context.Context, s *models.Session) error {
 	envJSON, err := json.Marshal(s.Env)
 	if err != nil {
 		return fmt.Errorf("failed to marshal env: %w", err)
@@ -745,218 +1314,846 @@ func (r *PostgresRepository) UpdateSession(ctx context.Context, s *models.Sessio
 		return fmt.Errorf("failed to marshal metadata: %w", err)
 	}
 
+	servicesJSON, err := json.Marshal(s.Services)
+	if err != nil {
+		return fmt.Errorf("failed to marshal services: %w", err)
+	}
+
 	query := `
-		UPDATE sessions
-		SET status = $2, status_message = $3, sandbox_id = $4, activated_at = $5, expires_at = $6, env = $7, metadata = $8, task_description = $9
-		WHERE id = $1
+		INSERT INTO sessions (id, token, template_id, status, status_message, env, metadata, services, ttl_seconds, sandbox_id, task_description, created_at, activated_at, expires_at, created_by, unique_key, on_conflict, join_by, prewarm_at, callback_url, callback_secret, record_terminal, owner_client_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23)
 	`
 
-	result, err := r.pool.Exec(ctx, query,
+	_, err = r.db.Exec(ctx, query,
 		s.ID,
+		s.Token,
+		s.TemplateID,
 		string(s.Status),
 		nullString(s.StatusMessage),
-		nullString(s.SandboxID),
-		nullTime(s.ActivatedAt),
-		nullTime(s.ExpiresAt),
 		envJSON,
 		metadataJSON,
+		servicesJSON,
+		s.TTLSeconds,
+		nullString(s.SandboxID),
 		s.TaskDescription,
+		s.CreatedAt,
+		nullTime(s.ActivatedAt),
+		nullTime(s.ExpiresAt),
+		nullString(s.CreatedBy),
+		nullString(s.UniqueKey),
+		nullString(string(s.OnConflict)),
+		nullTime(s.JoinBy),
+		nullTime(s.PrewarmAt),
+		nullString(s.CallbackURL),
+		nullString(s.CallbackSecret),
+		s.RecordTerminal,
+		nullInt(s.OwnerClientID),
 	)
 
 	if err != nil {
-		return fmt.Errorf("failed to update session: %w", err)
-	}
-
-	if result.RowsAffected() == 0 {
-		return fmt.Errorf("session not found: %s", s.ID)
+		return fmt.Errorf("failed to create session: %w", err)
 	}
 
 	return nil
 }
 
-// DeleteSession deletes a session by ID
-func (r *PostgresRepository) DeleteSession(ctx context.Context, id string) error {
-	query := `DELETE FROM sessions WHERE id = $1`
+// GetSessionByToken retrieves a session by its join token
+func (r *PostgresRepository) GetSessionByToken(ctx context.Context, token string) (*models.Session, error) {
+	return r.getSession(ctx, "token", token)
+}
 
-	result, err := r.pool.Exec(ctx, query, id)
-	if err != nil {
-		return fmt.Errorf("failed to delete session: %w", err)
-	}
+// GetSessionByID retrieves a session by its ID
+func (r *PostgresRepository) GetSessionByID(ctx context.Context, id string) (*models.Session, error) {
+	return r.getSession(ctx, "id", id)
+}
 
-	if result.RowsAffected() == 0 {
-		return fmt.Errorf("session not found: %s", id)
+// GetSessionBySandboxID retrieves the session bound to a sandbox
+func (r *PostgresRepository) GetSessionBySandboxID(ctx context.Context, sandboxID string) (*models.Session, error) {
+	return r.getSession(ctx, "sandbox_id", sandboxID)
+}
+
+func (r *PostgresRepository) getSession(ctx context.Context, field, value string) (*models.Session, error) {
+	query := fmt.Sprintf(`SELECT `+sessionColumns+` FROM sessions WHERE %s = $1`, field)
+
+	s, err := scanSession(r.db.QueryRow(ctx, query, value))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get session: %w", err)
 	}
 
-	return nil
+	return s, nil
 }
 
-// ListSessions returns sessions with optional status filter
-func (r *PostgresRepository) ListSessions(ctx context.Context, status string, limit, offset int) ([]*models.Session, error) {
-	query := `
-		SELECT id, token, template_id, status, status_message, env, metadata, services, ttl_seconds, sandbox_id, task_description, created_at, activated_at, expires_at, created_by
+// GetLiveSessionByUniqueKey returns the provisioning or active session holding
+// a unique key, or nil if none does
+func (r *PostgresRepository) GetLiveSessionByUniqueKey(ctx context.Context, uniqueKey string) (*models.Session, error) {
+	query := `SELECT ` + sessionColumns + `
 		FROM sessions
-		WHERE 1=1
+		WHERE unique_key = $1
+		  AND status IN ('provisioning', 'active')
 	`
-	args := make([]interface{}, 0)
-	argNum := 1
 
-	if status != "" {
-		query += fmt.Sprintf(" AND status = $%d", argNum)
-		args = append(args, status)
-		argNum++
+	s, err := scanSession(r.db.QueryRow(ctx, query, uniqueKey))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get session by unique key: %w", err)
 	}
 
-	query += " ORDER BY created_at DESC"
+	return s, nil
+}
 
-	if limit > 0 {
+// UpdateSession updates an existing session. It returns ErrUniqueKeyConflict
+// if the new status would give the session's unique key a second live session.
+func (r *PostgresRepository) UpdateSession(ctx context.Context, s *models.Session) error {
+	updated, err := r.updateSession(ctx, s, "")
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return fmt.Errorf("session not found: %s", s.ID)
+	}
+	return nil
+}
+
+// UpdateSessionFrom updates a session like UpdateSession while its stored
+// status is still from. It reports false when another transition got there
+// first or the session is gone.
+func (r *PostgresRepository) UpdateSessionFrom(ctx context.Context, s *models.Session, from models.SessionStatus) (bool, error) {
+	return r.updateSession(ctx, s, from)
+}
+
+// updateSession writes s, only while its stored status is from unless from is empty
+func (r *PostgresRepository) updateSession(ctx context.Context, s *models.Session, from models.SessionStatus) (bool, error) {
+	envJSON, err := json.Marshal(s.Env)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal env: %w", err)
+	}
+
+	metadataJSON, err := json.Marshal(s.Metadata)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+
+	query := `
+		UPDATE sessions
+		SET status = $2, status_message = $3, sandbox_id = $4, activated_at = $5, expires_at = $6, env = $7, metadata = $8, task_description = $9,
+		    revoked_at = $10, revoked_by = $11, submitted_at = $12, extended_seconds = $13
+		WHERE id = $1 AND ($14 = '' OR status = $14)
+	`
+
+	result, err := r.db.Exec(ctx, query,
+		s.ID,
+		string(s.Status),
+		nullString(s.StatusMessage),
+		nullString(s.SandboxID),
+		nullTime(s.ActivatedAt),
+		nullTime(s.ExpiresAt),
+		envJSON,
+		metadataJSON,
+		s.TaskDescription,
+		nullTime(s.RevokedAt),
+		nullString(s.RevokedBy),
+		nullTime(s.SubmittedAt),
+		s.ExtendedSeconds,
+		string(from),
+	)
+
+	if err != nil {
+		if isSessionUniqueKeyViolation(err) {
+			return false, ErrUniqueKeyConflict
+		}
+		return false, fmt.Errorf("failed to update session: %w", err)
+	}
+
+	return result.RowsAffected() == 1, nil
+}
+
+// ClaimSession moves a ready session to s.Status, writing its status message,
+// activation and expiry times, and reports whether it did. Only one of several
+// concurrent claims for the same session succeeds; the others find it no
+// longer ready. It returns ErrUniqueKeyConflict like UpdateSession.
+func (r *PostgresRepository) ClaimSession(ctx context.Context, s *models.Session) (bool, error) {
+	query := `
+		UPDATE sessions
+		SET status = $2, status_message = $3, activated_at = $4, expires_at = $5
+		WHERE id = $1 AND status = 'ready'
+	`
+
+	result, err := r.db.Exec(ctx, query, s.ID, string(s.Status), nullString(s.StatusMessage), nullTime(s.ActivatedAt), nullTime(s.ExpiresAt))
+	if err != nil {
+		if isSessionUniqueKeyViolation(err) {
+			return false, ErrUniqueKeyConflict
+		}
+		return false, fmt.Errorf("failed to claim session: %w", err)
+	}
+
+	return result.RowsAffected() == 1, nil
+}
+
+// isSessionUniqueKeyViolation reports whether err comes from sessionUniqueKeyIndex
+func isSessionUniqueKeyViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolation && pgErr.ConstraintName == sessionUniqueKeyIndex
+}
+
+// DeleteSession deletes a session by ID
+func (r *PostgresRepository) DeleteSession(ctx context.Context, id string) error {
+	query := `DELETE FROM sessions WHERE id = $1`
+
+	result, err := r.db.Exec(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("session not found: %s", id)
+	}
+
+	return nil
+}
+
+// PurgeRevokedSessions removes sessions revoked before cutoff
+func (r *PostgresRepository) PurgeRevokedSessions(ctx context.Context, cutoff time.Time) (int64, error) {
+	result, err := r.db.Exec(ctx, `DELETE FROM sessions WHERE status = 'revoked' AND revoked_at < $1`, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge revoked sessions: %w", err)
+	}
+	return result.RowsAffected(), nil
+}
+
+// sessionFilterWhere builds the WHERE clause and its args for filters
+func sessionFilterWhere(filters models.SessionFilters) (string, []interface{}) {
+	where := ` WHERE 1=1`
+	args := make([]interface{}, 0)
+	argNum := 1
+
+	switch filters.Status {
+	case "":
+	case models.SessionFilterInvalidTemplate:
+		// Ready sessions whose template is gone, and those already failed for it
+		where += fmt.Sprintf(" AND ((status = 'ready' AND NOT (template_id = ANY($%d))) OR (status = 'failed' AND status_message LIKE $%d || '%%'))", argNum, argNum+1)
+		args = append(args, filters.KnownTemplates, models.StatusMsgTemplateMissing)
+		argNum += 2
+	default:
+		where += fmt.Sprintf(" AND status = $%d", argNum)
+		args = append(args, filters.Status)
+		argNum++
+	}
+
+	if filters.TemplateID != "" {
+		where += fmt.Sprintf(" AND template_id = $%d", argNum)
+		args = append(args, filters.TemplateID)
+		argNum++
+	}
+
+	if filters.CreatedBy != "" {
+		where += fmt.Sprintf(" AND created_by = $%d", argNum)
+		args = append(args, filters.CreatedBy)
+		argNum++
+	}
+
+	if !filters.CreatedAfter.IsZero() {
+		where += fmt.Sprintf(" AND created_at >= $%d", argNum)
+		args = append(args, filters.CreatedAfter)
+		argNum++
+	}
+
+	if !filters.CreatedBefore.IsZero() {
+		where += fmt.Sprintf(" AND created_at < $%d", argNum)
+		args = append(args, filters.CreatedBefore)
+	}
+
+	return where, args
+}
+
+// ListSessions returns sessions matching filters, newest first
+func (r *PostgresRepository) ListSessions(ctx context.Context, filters models.SessionFilters) ([]*models.Session, error) {
+	where, args := sessionFilterWhere(filters)
+	query := `SELECT ` + sessionColumns + ` FROM sessions` + where + ` ORDER BY created_at DESC`
+	argNum := len(args) + 1
+
+	if filters.Limit > 0 {
 		query += fmt.Sprintf(" LIMIT $%d", argNum)
-		args = append(args, limit)
+		args = append(args, filters.Limit)
 		argNum++
 	}
 
-	if offset > 0 {
+	if filters.Offset > 0 {
 		query += fmt.Sprintf(" OFFSET $%d", argNum)
-		args = append(args, offset)
+		args = append(args, filters.Offset)
 	}
 
-	rows, err := r.pool.Query(ctx, query, args...)
+	// Listings tolerate replica lag
+	rows, err := r.reads.query(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list sessions: %w", err)
 	}
-	defer rows.Close()
 
-	var sessions []*models.Session
+	return scanSessions(rows)
+}
 
-	for rows.Next() {
-		var s models.Session
-		var statusStr string
-		var statusMsg, sandboxID, createdBy sql.NullString
-		var activatedAt, expiresAt sql.NullTime
-		var envJSON, metadataJSON, servicesJSON []byte
+// CountSessions counts sessions matching filters, ignoring Limit and Offset
+func (r *PostgresRepository) CountSessions(ctx context.Context, filters models.SessionFilters) (int, error) {
+	where, args := sessionFilterWhere(filters)
 
-		err := rows.Scan(
-			&s.ID,
-			&s.Token,
-			&s.TemplateID,
-			&statusStr,
-			&statusMsg,
-			&envJSON,
-			&metadataJSON,
-			&servicesJSON,
-			&s.TTLSeconds,
-			&sandboxID,
-			&s.TaskDescription,
-			&s.CreatedAt,
-			&activatedAt,
-			&expiresAt,
-			&createdBy,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan session: %w", err)
-		}
+	// Counts back listings, so they tolerate the same replica lag
+	rows, err := r.reads.query(ctx, `SELECT COUNT(*) FROM sessions`+where, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count sessions: %w", err)
+	}
 
-		s.Status = models.SessionStatus(statusStr)
-		s.StatusMessage = statusMsg.String
-		s.SandboxID = sandboxID.String
-		s.CreatedBy = createdBy.String
+	count, err := pgx.CollectOneRow(rows, pgx.RowTo[int])
+	if err != nil {
+		return 0, fmt.Errorf("failed to count sessions: %w", err)
+	}
 
-		if servicesJSON != nil {
-			_ = json.Unmarshal(servicesJSON, &s.Services)
-		}
+	return count, nil
+}
 
-		if activatedAt.Valid {
-			s.ActivatedAt = &activatedAt.Time
-		}
-		if expiresAt.Valid {
-			s.ExpiresAt = &expiresAt.Time
-		}
+// expiredSessionsWhere matches the sessions the cleaner expires: active ones
+// past their TTL and ready ones past their join deadline. Ready sessions have
+// no expires_at, so COALESCE(expires_at, join_by) orders both by deadline.
+const expiredSessionsWhere = `(status = 'active' AND expires_at < $1) OR (status = 'ready' AND join_by < $1)`
+
+// GetExpiredSessions returns up to limit sessions past their TTL or join
+// deadline, oldest deadline first
+func (r *PostgresRepository) GetExpiredSessions(ctx context.Context, limit int) ([]*models.Session, error) {
+	query := `SELECT ` + sessionColumns + `
+		FROM sessions
+		WHERE ` + expiredSessionsWhere + `
+		ORDER BY COALESCE(expires_at, join_by) ASC
+		LIMIT $2
+	`
+
+	rows, err := r.db.Query(ctx, query, time.Now(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get expired sessions: %w", err)
+	}
+
+	return scanSessions(rows)
+}
+
+// CountExpiredSessions counts sessions past their TTL or join deadline not yet expired by the cleaner
+func (r *PostgresRepository) CountExpiredSessions(ctx context.Context) (int, error) {
+	var count int
+	err := r.db.QueryRow(ctx, `SELECT COUNT(*) FROM sessions WHERE `+expiredSessionsWhere, time.Now()).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count expired sessions: %w", err)
+	}
+	return count, nil
+}
+
+// GetPrewarmDueSessions returns up to limit ready sessions without a sandbox
+// whose prewarm time has come, skipping those past their join deadline,
+// earliest first
+func (r *PostgresRepository) GetPrewarmDueSessions(ctx context.Context, limit int) ([]*models.Session, error) {
+	query := `SELECT ` + sessionColumns + `
+		FROM sessions
+		WHERE status = 'ready' AND sandbox_id IS NULL AND prewarm_at <= $1 AND (join_by IS NULL OR join_by > $1)
+		ORDER BY prewarm_at ASC
+		LIMIT $2
+	`
+
+	rows, err := r.db.Query(ctx, query, time.Now(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get prewarm due sessions: %w", err)
+	}
+
+	return scanSessions(rows)
+}
+
+// AttachSessionSandbox sets the sandbox of a session still ready without one.
+// It reports false when the session was activated, expired or revoked first.
+func (r *PostgresRepository) AttachSessionSandbox(ctx context.Context, id, sandboxID string) (bool, error) {
+	result, err := r.db.Exec(ctx, `UPDATE sessions SET sandbox_id = $2 WHERE id = $1 AND status = 'ready' AND sandbox_id IS NULL`, id, sandboxID)
+	if err != nil {
+		return false, fmt.Errorf("failed to attach session sandbox: %w", err)
+	}
+	return result.RowsAffected() == 1, nil
+}
+
+// RevokeSession revokes a session not revoked yet and returns the sandbox it
+// had at that moment, so one attached meanwhile isn't missed. It reports
+// false when the session is gone or was revoked first.
+func (r *PostgresRepository) RevokeSession(ctx context.Context, id, revokedBy string, at time.Time) (string, bool, error) {
+	var sandboxID *string
+	err := r.db.QueryRow(ctx, `
+		UPDATE sessions SET status = 'revoked', revoked_at = $2, revoked_by = $3
+		WHERE id = $1 AND status <> 'revoked'
+		RETURNING sandbox_id
+	`, id, at, nullString(revokedBy)).Scan(&sandboxID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to revoke session: %w", err)
+	}
+	if sandboxID == nil {
+		return "", true, nil
+	}
+	return *sandboxID, true, nil
+}
+
+// SetSessionSandboxStatus records the last status of a sandbox on the session
+// it belongs to, if any
+func (r *PostgresRepository) SetSessionSandboxStatus(ctx context.Context, sandboxID string, status models.SandboxStatus) error {
+	if _, err := r.db.Exec(ctx, `UPDATE sessions SET sandbox_status = $2 WHERE sandbox_id = $1`, sandboxID, string(status)); err != nil {
+		return fmt.Errorf("failed to set session sandbox status: %w", err)
+	}
+	return nil
+}
+
+// OpenSessionConnection records a terminal connection of a session, assigning its ID
+func (r *PostgresRepository) OpenSessionConnection(ctx context.Context, c *models.SessionConnection) error {
+	query := `
+		INSERT INTO session_connections (session_id, connected_at)
+		VALUES ($1, $2)
+		RETURNING id
+	`
+
+	if err := r.db.QueryRow(ctx, query, c.SessionID, c.ConnectedAt).Scan(&c.ID); err != nil {
+		return fmt.Errorf("failed to open session connection: %w", err)
+	}
+	return nil
+}
 
-		if envJSON != nil {
-			json.Unmarshal(envJSON, &s.Env)
+// CloseSessionConnection records when a terminal connection ended. A
+// connection that is gone, with its session, is ignored.
+func (r *PostgresRepository) CloseSessionConnection(ctx context.Context, id int64, at time.Time) error {
+	if _, err := r.db.Exec(ctx, `UPDATE session_connections SET disconnected_at = $2 WHERE id = $1`, id, at); err != nil {
+		return fmt.Errorf("failed to close session connection: %w", err)
+	}
+	return nil
+}
+
+// ListSessionConnections returns a session's terminal connections, oldest first
+func (r *PostgresRepository) ListSessionConnections(ctx context.Context, sessionID string) ([]*models.SessionConnection, error) {
+	query := `
+		SELECT id, session_id, connected_at, disconnected_at
+		FROM session_connections
+		WHERE session_id = $1
+		ORDER BY connected_at, id
+	`
+
+	rows, err := r.reads.query(ctx, query, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list session connections: %w", err)
+	}
+	defer rows.Close()
+
+	var conns []*models.SessionConnection
+	for rows.Next() {
+		var c models.SessionConnection
+		var disconnectedAt sql.NullTime
+		if err := rows.Scan(&c.ID, &c.SessionID, &c.ConnectedAt, &disconnectedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan session connection: %w", err)
 		}
-		if metadataJSON != nil {
-			json.Unmarshal(metadataJSON, &s.Metadata)
+		if disconnectedAt.Valid {
+			c.DisconnectedAt = &disconnectedAt.Time
 		}
+		conns = append(conns, &c)
+	}
+
+	return conns, rows.Err()
+}
+
+// RecordCallbackDelivery adds a session callback to the delivery outbox, assigning its ID
+func (r *PostgresRepository) RecordCallbackDelivery(ctx context.Context, d *models.CallbackDelivery) error {
+	query := `
+		INSERT INTO session_callback_deliveries (session_id, event, delivered, attempts, status_code, error, attempted_at, url, secret, payload, next_attempt_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id
+	`
 
-		sessions = append(sessions, &s)
+	err := r.db.QueryRow(ctx, query,
+		d.SessionID, string(d.Event), d.Delivered, d.Attempts, nullInt(d.StatusCode), nullString(d.Error), d.AttemptedAt,
+		d.URL, d.Secret, string(d.Payload), d.NextAttemptAt,
+	).Scan(&d.ID)
+	if err != nil {
+		return fmt.Errorf("failed to record callback delivery: %w", err)
 	}
+	return nil
+}
 
-	return sessions, rows.Err()
+// UpdateCallbackDelivery stores the outcome of a delivery attempt and when to try next
+func (r *PostgresRepository) UpdateCallbackDelivery(ctx context.Context, d *models.CallbackDelivery) error {
+	query := `
+		UPDATE session_callback_deliveries
+		SET delivered = $2, attempts = $3, status_code = $4, error = $5, attempted_at = $6, next_attempt_at = $7
+		WHERE id = $1
+	`
+
+	_, err := r.db.Exec(ctx, query, d.ID, d.Delivered, d.Attempts, nullInt(d.StatusCode), nullString(d.Error), d.AttemptedAt, d.NextAttemptAt)
+	if err != nil {
+		return fmt.Errorf("failed to update callback delivery: %w", err)
+	}
+	return nil
 }
 
-// GetExpiredSessions returns active sessions that have expired
-func (r *PostgresRepository) GetExpiredSessions(ctx context.Context) ([]*models.Session, error) {
+// ClaimCallbackDeliveries returns up to limit of the pending deliveries due at
+// now, the longest due first, and pushes their next attempt back by lease so no other
+// replica claims them while they are tried
+func (r *PostgresRepository) ClaimCallbackDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*models.CallbackDelivery, error) {
 	query := `
-		SELECT id, token, template_id, status, status_message, env, metadata, services, ttl_seconds, sandbox_id, task_description, created_at, activated_at, expires_at, created_by
-		FROM sessions
-		WHERE status = 'active'
-		  AND expires_at < NOW()
-		ORDER BY expires_at ASC
+		UPDATE session_callback_deliveries
+		SET next_attempt_at = $2
+		WHERE id IN (
+			SELECT id FROM session_callback_deliveries
+			WHERE next_attempt_at <= $1
+			ORDER BY next_attempt_at
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, session_id, event, delivered, attempts, status_code, error, attempted_at, url, secret, payload, next_attempt_at
 	`
 
-	rows, err := r.pool.Query(ctx, query)
+	rows, err := r.db.Query(ctx, query, now, now.Add(lease), limit)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get expired sessions: %w", err)
+		return nil, fmt.Errorf("failed to claim callback deliveries: %w", err)
 	}
 	defer rows.Close()
 
-	var sessions []*models.Session
+	var deliveries []*models.CallbackDelivery
+	for rows.Next() {
+		var d models.CallbackDelivery
+		var event, payload string
+		var statusCode sql.NullInt64
+		var errMsg sql.NullString
+		if err := rows.Scan(&d.ID, &d.SessionID, &event, &d.Delivered, &d.Attempts, &statusCode, &errMsg, &d.AttemptedAt, &d.URL, &d.Secret, &payload, &d.NextAttemptAt); err != nil {
+			return nil, fmt.Errorf("failed to scan callback delivery: %w", err)
+		}
+		d.Event = models.CallbackEvent(event)
+		d.StatusCode = int(statusCode.Int64)
+		d.Error = errMsg.String
+		d.Payload = []byte(payload)
+		deliveries = append(deliveries, &d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// PurgeCallbackDeliveries removes the finished deliveries of deleted sessions.
+// Deliveries don't cascade with their session, so the callback of an expiry
+// is still delivered after the session is gone.
+func (r *PostgresRepository) PurgeCallbackDeliveries(ctx context.Context) (int64, error) {
+	query := `
+		DELETE FROM session_callback_deliveries d
+		WHERE d.next_attempt_at IS NULL
+		  AND NOT EXISTS (SELECT 1 FROM sessions s WHERE s.id = d.session_id)
+	`
+
+	result, err := r.db.Exec(ctx, query)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge callback deliveries: %w", err)
+	}
+	return result.RowsAffected(), nil
+}
+
+// ListCallbackDeliveries returns the callback deliveries of a session, oldest first
+func (r *PostgresRepository) ListCallbackDeliveries(ctx context.Context, sessionID string) ([]*models.CallbackDelivery, error) {
+	query := `
+		SELECT id, session_id, event, delivered, attempts, status_code, error, attempted_at, next_attempt_at
+		FROM session_callback_deliveries
+		WHERE session_id = $1
+		ORDER BY id
+	`
+
+	rows, err := r.reads.query(ctx, query, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list callback deliveries: %w", err)
+	}
+	defer rows.Close()
 
+	var deliveries []*models.CallbackDelivery
 	for rows.Next() {
-		var s models.Session
-		var statusStr string
-		var statusMsg, sandboxID, createdBy sql.NullString
-		var activatedAt, expiresAt sql.NullTime
-		var envJSON, metadataJSON, servicesJSON []byte
+		var d models.CallbackDelivery
+		var event string
+		var statusCode sql.NullInt64
+		var errMsg sql.NullString
+		if err := rows.Scan(&d.ID, &d.SessionID, &event, &d.Delivered, &d.Attempts, &statusCode, &errMsg, &d.AttemptedAt, &d.NextAttemptAt); err != nil {
+			return nil, fmt.Errorf("failed to scan callback delivery: %w", err)
+		}
+		d.Event = models.CallbackEvent(event)
+		d.StatusCode = int(statusCode.Int64)
+		d.Error = errMsg.String
+		deliveries = append(deliveries, &d)
+	}
 
-		err := rows.Scan(
-			&s.ID,
-			&s.Token,
-			&s.TemplateID,
-			&statusStr,
-			&statusMsg,
-			&envJSON,
-			&metadataJSON,
-			&servicesJSON,
-			&s.TTLSeconds,
-			&sandboxID,
-			&s.TaskDescription,
-			&s.CreatedAt,
-			&activatedAt,
-			&expiresAt,
-			&createdBy,
-		)
+	return deliveries, rows.Err()
+}
+
+// --- Terminal recordings ---
+
+// CreateTerminalRecording stores a started recording, assigning its ID
+func (r *PostgresRepository) CreateTerminalRecording(ctx context.Context, rec *models.TerminalRecording) error {
+	query := `
+		INSERT INTO terminal_recordings (sandbox_id, session_id, owner_client_id, started_at, input)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`
+
+	err := r.db.QueryRow(ctx, query, rec.SandboxID, nullString(rec.SessionID), nullInt(rec.OwnerClientID), rec.StartedAt, rec.Input).Scan(&rec.ID)
+	if err != nil {
+		return fmt.Errorf("failed to create terminal recording: %w", err)
+	}
+	return nil
+}
+
+// FinishTerminalRecording stores the end, size and truncation of a recording
+func (r *PostgresRepository) FinishTerminalRecording(ctx context.Context, rec *models.TerminalRecording) error {
+	query := `
+		UPDATE terminal_recordings
+		SET ended_at = $2, size_bytes = $3, truncated = $4
+		WHERE id = $1
+	`
+
+	if _, err := r.db.Exec(ctx, query, rec.ID, nullTime(rec.EndedAt), rec.SizeBytes, rec.Truncated); err != nil {
+		return fmt.Errorf("failed to finish terminal recording: %w", err)
+	}
+	return nil
+}
+
+// ListTerminalRecordings returns the recordings of a sandbox, oldest first
+func (r *PostgresRepository) ListTerminalRecordings(ctx context.Context, sandboxID string) ([]*models.TerminalRecording, error) {
+	query := `SELECT ` + recordingColumns + ` FROM terminal_recordings WHERE sandbox_id = $1 ORDER BY id`
+
+	rows, err := r.reads.query(ctx, query, sandboxID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list terminal recordings: %w", err)
+	}
+	defer rows.Close()
+
+	var recs []*models.TerminalRecording
+	for rows.Next() {
+		rec, err := scanRecording(rows)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan session: %w", err)
+			return nil, fmt.Errorf("failed to scan terminal recording: %w", err)
 		}
+		recs = append(recs, rec)
+	}
+
+	return recs, rows.Err()
+}
 
-		s.Status = models.SessionStatus(statusStr)
-		s.StatusMessage = statusMsg.String
-		s.SandboxID = sandboxID.String
-		s.CreatedBy = createdBy.String
+// GetTerminalRecording retrieves a recording by ID
+func (r *PostgresRepository) GetTerminalRecording(ctx context.Context, id int64) (*models.TerminalRecording, error) {
+	query := `SELECT ` + recordingColumns + ` FROM terminal_recordings WHERE id = $1`
 
-		if servicesJSON != nil {
-			_ = json.Unmarshal(servicesJSON, &s.Services)
-		}
+	rec, err := scanRecording(r.db.QueryRow(ctx, query, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get terminal recording: %w", err)
+	}
+	return rec, nil
+}
+
+// PurgeTerminalRecordings deletes the recordings that ended before cutoff, and
+// those never finished that started before it, returning them so their files
+// can be removed
+func (r *PostgresRepository) PurgeTerminalRecordings(ctx context.Context, cutoff time.Time) ([]*models.TerminalRecording, error) {
+	query := `DELETE FROM terminal_recordings WHERE COALESCE(ended_at, started_at) < $1 RETURNING ` + recordingColumns
+
+	rows, err := r.db.Query(ctx, query, cutoff)
+	if err != nil {
+		return nil, fmt.Errorf("failed to purge terminal recordings: %w", err)
+	}
+	defer rows.Close()
 
-		if activatedAt.Valid {
-			s.ActivatedAt = &activatedAt.Time
+	var recs []*models.TerminalRecording
+	for rows.Next() {
+		rec, err := scanRecording(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan terminal recording: %w", err)
 		}
-		if expiresAt.Valid {
-			s.ExpiresAt = &expiresAt.Time
+		recs = append(recs, rec)
+	}
+
+	return recs, rows.Err()
+}
+
+// recordingColumns are the terminal_recordings columns scanRecording reads
+const recordingColumns = `id, sandbox_id, session_id, owner_client_id, started_at, ended_at, size_bytes, truncated, input`
+
+// scanRecording scans a row selected with recordingColumns
+func scanRecording(row pgx.Row) (*models.TerminalRecording, error) {
+	var rec models.TerminalRecording
+	var sessionID sql.NullString
+	var ownerClientID sql.NullInt64
+	var endedAt sql.NullTime
+	if err := row.Scan(&rec.ID, &rec.SandboxID, &sessionID, &ownerClientID, &rec.StartedAt, &endedAt, &rec.SizeBytes, &rec.Truncated, &rec.Input); err != nil {
+		return nil, err
+	}
+	rec.SessionID = sessionID.String
+	rec.OwnerClientID = int(ownerClientID.Int64)
+	if endedAt.Valid {
+		rec.EndedAt = &endedAt.Time
+	}
+	return &rec, nil
+}
+
+// --- Grading results ---
+
+// CreateGradingResult stores a grading run, assigning its ID and creation time
+func (r *PostgresRepository) CreateGradingResult(ctx context.Context, res *models.GradingResult) error {
+	var junitJSON []byte
+	if res.JUnit != nil {
+		var err error
+		if junitJSON, err = json.Marshal(res.JUnit); err != nil {
+			return fmt.Errorf("failed to marshal junit report: %w", err)
 		}
+	}
+
+	query := `
+		INSERT INTO grading_results (sandbox_id, session_id, task_id, owner_client_id, passed, exit_code, stdout, stderr, truncated, duration_ms, junit, error)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		RETURNING id, created_at
+	`
+
+	err := r.db.QueryRow(ctx, query, res.SandboxID, nullString(res.SessionID), res.TaskID, nullInt(res.OwnerClientID),
+		res.Passed, res.ExitCode, res.Stdout, res.Stderr, res.Truncated, res.DurationMs, junitJSON, res.Error,
+	).Scan(&res.ID, &res.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create grading result: %w", err)
+	}
+	return nil
+}
+
+// ListGradingResults returns the grading runs of a sandbox, oldest first
+func (r *PostgresRepository) ListGradingResults(ctx context.Context, sandboxID string) ([]*models.GradingResult, error) {
+	query := `SELECT ` + gradingColumns + ` FROM grading_results WHERE sandbox_id = $1 ORDER BY id`
 
-		if envJSON != nil {
-			json.Unmarshal(envJSON, &s.Env)
+	rows, err := r.reads.query(ctx, query, sandboxID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list grading results: %w", err)
+	}
+	defer rows.Close()
+
+	var results []*models.GradingResult
+	for rows.Next() {
+		var res models.GradingResult
+		var sessionID sql.NullString
+		var ownerClientID sql.NullInt64
+		var junitJSON []byte
+		if err := rows.Scan(&res.ID, &res.SandboxID, &sessionID, &res.TaskID, &ownerClientID, &res.Passed, &res.ExitCode,
+			&res.Stdout, &res.Stderr, &res.Truncated, &res.DurationMs, &junitJSON, &res.Error, &res.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan grading result: %w", err)
+		}
+		res.SessionID = sessionID.String
+		res.OwnerClientID = int(ownerClientID.Int64)
+		if junitJSON != nil {
+			if err := json.Unmarshal(junitJSON, &res.JUnit); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal junit report: %w", err)
+			}
 		}
-		if metadataJSON != nil {
-			json.Unmarshal(metadataJSON, &s.Metadata)
+		results = append(results, &res)
+	}
+
+	return results, rows.Err()
+}
+
+// gradingColumns are the grading_results columns the Scan calls read
+const gradingColumns = `id, sandbox_id, session_id, task_id, owner_client_id, passed, exit_code, stdout, stderr, truncated, duration_ms, junit, error, created_at`
+
+// --- Template documents ---
+
+// ListTemplateDocuments returns the templates created through the API, by name
+func (r *PostgresRepository) ListTemplateDocuments(ctx context.Context) ([]*models.TemplateDocument, error) {
+	rows, err := r.db.Query(ctx, `SELECT name, document, created_at, updated_at FROM templates ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list templates: %w", err)
+	}
+	defer rows.Close()
+
+	var docs []*models.TemplateDocument
+	for rows.Next() {
+		var doc models.TemplateDocument
+		if err := rows.Scan(&doc.Name, &doc.Document, &doc.CreatedAt, &doc.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan template: %w", err)
 		}
+		docs = append(docs, &doc)
+	}
+
+	return docs, rows.Err()
+}
+
+// GetTemplateDocument retrieves a template created through the API by name
+func (r *PostgresRepository) GetTemplateDocument(ctx context.Context, name string) (*models.TemplateDocument, error) {
+	var doc models.TemplateDocument
+	err := r.db.QueryRow(ctx, `SELECT name, document, created_at, updated_at FROM templates WHERE name = $1`, name).
+		Scan(&doc.Name, &doc.Document, &doc.CreatedAt, &doc.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get template: %w", err)
+	}
+	return &doc, nil
+}
+
+// SaveTemplateDocument creates or replaces a template document, setting its
+// creation and update times
+func (r *PostgresRepository) SaveTemplateDocument(ctx context.Context, doc *models.TemplateDocument) error {
+	query := `
+		INSERT INTO templates (name, document, created_at, updated_at)
+		VALUES ($1, $2, $3, $3)
+		ON CONFLICT (name) DO UPDATE SET document = EXCLUDED.document, updated_at = EXCLUDED.updated_at
+		RETURNING created_at, updated_at
+	`
 
-		sessions = append(sessions, &s)
+	if err := r.db.QueryRow(ctx, query, doc.Name, doc.Document, time.Now()).Scan(&doc.CreatedAt, &doc.UpdatedAt); err != nil {
+		return fmt.Errorf("failed to save template: %w", err)
 	}
+	return nil
+}
 
-	return sessions, rows.Err()
+// CreateTemplateDocument inserts a template document unless its name is taken
+func (r *PostgresRepository) CreateTemplateDocument(ctx context.Context, doc *models.TemplateDocument) (bool, error) {
+	query := `
+		INSERT INTO templates (name, document, created_at, updated_at)
+		VALUES ($1, $2, $3, $3)
+		ON CONFLICT (name) DO NOTHING
+		RETURNING created_at, updated_at
+	`
+
+	err := r.db.QueryRow(ctx, query, doc.Name, doc.Document, time.Now()).Scan(&doc.CreatedAt, &doc.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to create template: %w", err)
+	}
+	return true, nil
+}
+
+// LockTemplateDocument locks a template document FOR SHARE or FOR UPDATE
+func (r *PostgresRepository) LockTemplateDocument(ctx context.Context, name string, forUpdate bool) (bool, error) {
+	lock := "FOR SHARE"
+	if forUpdate {
+		lock = "FOR UPDATE"
+	}
+	var found int
+	err := r.db.QueryRow(ctx, `SELECT 1 FROM templates WHERE name = $1 `+lock, name).Scan(&found)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to lock template: %w", err)
+	}
+	return true, nil
+}
+
+// DeleteTemplateDocument deletes a template document
+func (r *PostgresRepository) DeleteTemplateDocument(ctx context.Context, name string) error {
+	if _, err := r.db.Exec(ctx, `DELETE FROM templates WHERE name = $1`, name); err != nil {
+		return fmt.Errorf("failed to delete template: %w", err)
+	}
+	return nil
 }
 
 // Helper functions for nullable values
@@ -968,9 +2165,155 @@ func nullString(s string) sql.NullString {
 	return sql.NullString{String: s, Valid: true}
 }
 
+func nullInt(n int) sql.NullInt64 {
+	if n == 0 {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: int64(n), Valid: true}
+}
+
 func nullTime(t *time.Time) sql.NullTime {
 	if t == nil {
 		return sql.NullTime{}
 	}
 	return sql.NullTime{Time: *t, Valid: true}
 }
+
+// RecordTemplateUsage adds a sandbox's usage to the daily counters of its
+// template and task. Each sandbox is counted at most once: the claim on
+// sandboxes.usage_recorded and the upsert run as one statement, so repeating
+// the call is a no-op. Returns whether the usage was counted.
+func (r *PostgresRepository) RecordTemplateUsage(ctx context.Context, rec *models.UsageRecord) (bool, error) {
+	query := `
+		WITH claimed AS (
+			UPDATE sandboxes SET usage_recorded = true
+			WHERE id = $1 AND NOT usage_recorded
+			RETURNING id
+		)
+		INSERT INTO template_usage_daily (template_id, task_id, day, creations, successes, failures, session_seconds, last_used_at)
+		SELECT $2, $3, $4::date, 1, $5::bigint, $6::bigint, $7::bigint, $8::timestamptz FROM claimed
+		ON CONFLICT (template_id, task_id, day) DO UPDATE SET
+			creations = template_usage_daily.creations + EXCLUDED.creations,
+			successes = template_usage_daily.successes + EXCLUDED.successes,
+			failures = template_usage_daily.failures + EXCLUDED.failures,
+			session_seconds = template_usage_daily.session_seconds + EXCLUDED.session_seconds,
+			last_used_at = GREATEST(template_usage_daily.last_used_at, EXCLUDED.last_used_at)
+	`
+
+	var successes, failures int64
+	if rec.Succeeded {
+		successes = 1
+	}
+	if rec.Failed {
+		failures = 1
+	}
+
+	// Days are passed as dates so the server's time zone can't shift them
+	result, err := r.db.Exec(ctx, query,
+		rec.SandboxID, rec.TemplateID, rec.TaskID, usageDay(rec.UsedAt),
+		successes, failures, rec.SessionSeconds, rec.UsedAt,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to record template usage: %w", err)
+	}
+
+	return result.RowsAffected() > 0, nil
+}
+
+// GetTemplateUsage sums the daily counters per template and task for days in [from, to]
+func (r *PostgresRepository) GetTemplateUsage(ctx context.Context, from, to time.Time) ([]*models.TemplateUsage, error) {
+	query := `
+		SELECT template_id, task_id, SUM(creations), SUM(successes), SUM(failures), SUM(session_seconds), MAX(last_used_at)
+		FROM template_usage_daily
+		WHERE day >= $1::date AND day <= $2::date
+		GROUP BY template_id, task_id
+		ORDER BY template_id, task_id
+	`
+
+	rows, err := r.reads.query(ctx, query, usageDay(from), usageDay(to))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get template usage: %w", err)
+	}
+	defer rows.Close()
+
+	var usage []*models.TemplateUsage
+	for rows.Next() {
+		var u models.TemplateUsage
+		var lastUsed time.Time
+		if err := rows.Scan(&u.TemplateID, &u.TaskID, &u.Creations, &u.Successes, &u.Failures, &u.SessionSeconds, &lastUsed); err != nil {
+			return nil, fmt.Errorf("failed to scan template usage: %w", err)
+		}
+		u.LastUsedAt = &lastUsed
+		usage = append(usage, &u)
+	}
+
+	return usage, rows.Err()
+}
+
+// usageDay is the UTC aggregation day of t as a date literal
+func usageDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// AppendEvent records a sandbox lifecycle event
+func (r *PostgresRepository) AppendEvent(ctx context.Context, ev *models.SandboxEvent) error {
+	query := `
+		INSERT INTO sandbox_events (sandbox_id, type, message, created_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`
+
+	if err := r.db.QueryRow(ctx, query, ev.SandboxID, ev.Type, ev.Message, ev.CreatedAt).Scan(&ev.ID); err != nil {
+		return fmt.Errorf("failed to append sandbox event: %w", err)
+	}
+	return nil
+}
+
+// ListEvents returns a sandbox's events, oldest first
+func (r *PostgresRepository) ListEvents(ctx context.Context, sandboxID string) ([]*models.SandboxEvent, error) {
+	query := `
+		SELECT id, sandbox_id, type, message, created_at
+		FROM sandbox_events
+		WHERE sandbox_id = $1
+		ORDER BY created_at, id
+	`
+
+	rows, err := r.reads.query(ctx, query, sandboxID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sandbox events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*models.SandboxEvent
+	for rows.Next() {
+		var ev models.SandboxEvent
+		if err := rows.Scan(&ev.ID, &ev.SandboxID, &ev.Type, &ev.Message, &ev.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan sandbox event: %w", err)
+		}
+		events = append(events, &ev)
+	}
+
+	return events, rows.Err()
+}
+
+// DeleteEventsBefore deletes all events of deleted sandboxes whose last event
+// (normally "deleted") was recorded before cutoff. Events of sandboxes that
+// aren't deleted are kept regardless of age.
+func (r *PostgresRepository) DeleteEventsBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	query := `
+		DELETE FROM sandbox_events
+		WHERE sandbox_id IN (
+			SELECT e.sandbox_id
+			FROM sandbox_events e
+			WHERE NOT EXISTS (SELECT 1 FROM sandboxes s WHERE s.id = e.sandbox_id AND s.deleted_at IS NULL)
+			GROUP BY e.sandbox_id
+			HAVING MAX(e.created_at) < $1
+		)
+	`
+
+	result, err := r.db.Exec(ctx, query, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete sandbox events: %w", err)
+	}
+	return result.RowsAffected(), nil
+}
@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// replicaCheckInterval is how often the read replica is pinged to detect recovery
+const replicaCheckInterval = 10 * time.Second
+
+// querier is the subset of a connection pool or transaction used for reads
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// queryPool is a querier that can be health checked
+type queryPool interface {
+	querier
+	Ping(ctx context.Context) error
+}
+
+// ReadReplica is implemented by repositories that can serve reads from a replica
+type ReadReplica interface {
+	// HasReadReplica reports whether a read replica is configured
+	HasReadReplica() bool
+	// PingReplica checks read replica connectivity
+	PingReplica(ctx context.Context) error
+}
+
+type primaryKey struct{}
+
+// WithPrimary marks ctx so reads that would normally go to the replica are
+// served by the primary. Use it for read-your-writes, e.g. listing right after a create.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// usePrimary reports whether ctx requested primary reads
+func usePrimary(ctx context.Context) bool {
+	v, _ := ctx.Value(primaryKey{}).(bool)
+	return v
+}
+
+// poolSelector routes stale-tolerant reads to the replica and falls back to
+// the primary while the replica is unreachable
+type poolSelector struct {
+	primary   querier
+	replica   queryPool // nil when no replica is configured
+	replicaUp atomic.Bool
+}
+
+func newPoolSelector(primary querier, replica queryPool) *poolSelector {
+	s := &poolSelector{
+		primary: primary,
+		replica: replica,
+	}
+	s.replicaUp.Store(replica != nil)
+	return s
+}
+
+// reader returns the pool to serve a stale-tolerant read from
+func (s *poolSelector) reader(ctx context.Context) querier {
+	if s.replica == nil || !s.replicaUp.Load() || usePrimary(ctx) {
+		return s.primary
+	}
+	return s.replica
+}
+
+// query runs a stale-tolerant read, retrying on the primary if the replica fails
+func (s *poolSelector) query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	db := s.reader(ctx)
+
+	rows, err := db.Query(ctx, sql, args...)
+	if err != nil && db != s.primary && ctx.Err() == nil {
+		s.markReplicaDown(err)
+		return s.primary.Query(ctx, sql, args...)
+	}
+
+	return rows, err
+}
+
+// markReplicaDown stops routing reads to the replica until the next successful check
+func (s *poolSelector) markReplicaDown(err error) {
+	if s.replicaUp.Swap(false) {
+		slog.Warn("read replica unavailable, falling back to primary", "error", err)
+	}
+}
+
+// checkReplica pings the replica and updates its routing state
+func (s *poolSelector) checkReplica(ctx context.Context) error {
+	if s.replica == nil {
+		return nil
+	}
+
+	if err := s.replica.Ping(ctx); err != nil {
+		s.markReplicaDown(err)
+		return err
+	}
+
+	if !s.replicaUp.Swap(true) {
+		slog.Info("read replica available again")
+	}
+	return nil
+}
+
+// monitor periodically checks the replica until ctx is done
+func (s *poolSelector) monitor(ctx context.Context, interval time.Duration) {
+	if s.replica == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			checkCtx, cancel := context.WithTimeout(ctx, interval)
+			s.checkReplica(checkCtx)
+			cancel()
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// fakePool records queries and fails them while down is set
+type fakePool struct {
+	name    string
+	down    bool
+	queries int
+}
+
+var errPoolDown = errors.New("connection refused")
+
+func (p *fakePool) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	p.queries++
+	if p.down {
+		return nil, errPoolDown
+	}
+	return nil, nil
+}
+
+func (p *fakePool) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	p.queries++
+	return nil
+}
+
+func (p *fakePool) Ping(ctx context.Context) error {
+	if p.down {
+		return errPoolDown
+	}
+	return nil
+}
+
+func TestPoolSelectorRouting(t *testing.T) {
+	primary := &fakePool{name: "primary"}
+	replica := &fakePool{name: "replica"}
+	ctx := context.Background()
+
+	s := newPoolSelector(primary, replica)
+
+	if got := s.reader(ctx); got != replica {
+		t.Fatalf("expected reads on replica, got %v", got.(*fakePool).name)
+	}
+
+	if got := s.reader(WithPrimary(ctx)); got != primary {
+		t.Fatalf("expected WithPrimary to force primary, got %v", got.(*fakePool).name)
+	}
+
+	if _, err := s.query(ctx, "SELECT 1"); err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if replica.queries != 1 || primary.queries != 0 {
+		t.Fatalf("expected query on replica only, got primary=%d replica=%d", primary.queries, replica.queries)
+	}
+}
+
+func TestPoolSelectorWithoutReplica(t *testing.T) {
+	primary := &fakePool{name: "primary"}
+	s := newPoolSelector(primary, nil)
+
+	if got := s.reader(context.Background()); got != primary {
+		t.Fatalf("expected primary without replica")
+	}
+	if err := s.checkReplica(context.Background()); err != nil {
+		t.Fatalf("checkReplica without replica should be a no-op, got %v", err)
+	}
+}
+
+func TestPoolSelectorFallback(t *testing.T) {
+	primary := &fakePool{name: "primary"}
+	replica := &fakePool{name: "replica", down: true}
+	ctx := context.Background()
+
+	s := newPoolSelector(primary, replica)
+
+	// Failed replica query is retried on the primary and the replica is marked down
+	if _, err := s.query(ctx, "SELECT 1"); err != nil {
+		t.Fatalf("expected fallback to succeed, got %v", err)
+	}
+	if replica.queries != 1 || primary.queries != 1 {
+		t.Fatalf("expected one query on each pool, got primary=%d replica=%d", primary.queries, replica.queries)
+	}
+	if got := s.reader(ctx); got != primary {
+		t.Fatalf("expected reads on primary while replica is down")
+	}
+
+	// Still down: check keeps it out of rotation
+	if err := s.checkReplica(ctx); err == nil {
+		t.Fatalf("expected checkReplica to report the outage")
+	}
+
+	// Recovery puts the replica back
+	replica.down = false
+	if err := s.checkReplica(ctx); err != nil {
+		t.Fatalf("checkReplica failed after recovery: %v", err)
+	}
+	if got := s.reader(ctx); got != replica {
+		t.Fatalf("expected reads on replica after recovery")
+	}
+}
+
+func TestPoolSelectorPrimaryErrorsPropagate(t *testing.T) {
+	primary := &fakePool{name: "primary", down: true}
+	s := newPoolSelector(primary, nil)
+
+	if _, err := s.query(context.Background(), "SELECT 1"); !errors.Is(err, errPoolDown) {
+		t.Fatalf("expected primary error, got %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"time"
 
 	"github.com/terra-clan/sandbox-engine/internal/models"
 )
@@ -12,9 +13,15 @@ type Repository interface {
 	CreateSandbox(ctx context.Context, sb *models.Sandbox) error
 	GetSandbox(ctx context.Context, id string) (*models.Sandbox, error)
 	UpdateSandbox(ctx context.Context, sb *models.Sandbox) error
-	DeleteSandbox(ctx context.Context, id string) error
+	DeleteSandbox(ctx context.Context, id string, hard bool) error
+	PurgeDeletedSandboxes(ctx context.Context, cutoff time.Time) (int64, error)
 	ListSandboxes(ctx context.Context, filters models.ListFilters) ([]*models.Sandbox, error)
-	GetExpiredSandboxes(ctx context.Context) ([]*models.Sandbox, error)
+	CountSandboxes(ctx context.Context, filters models.ListFilters) (int, error)
+	GetExpiredSandboxes(ctx context.Context, limit int) ([]*models.Sandbox, error)
+	CountExpiredSandboxes(ctx context.Context) (int, error)
+	GetIdleSandboxes(ctx context.Context, idleSince time.Time) ([]*models.Sandbox, error)
+	TouchSandboxActivity(ctx context.Context, id string, at time.Time) error
+	ExtendSandboxFrom(ctx context.Context, sb *models.Sandbox, from time.Time) (bool, error)
 
 	// Services
 	CreateService(ctx context.Context, sandboxID string, svc *models.ServiceInstance) error
@@ -22,18 +29,93 @@ type Repository interface {
 	UpdateService(ctx context.Context, sandboxID string, svc *models.ServiceInstance) error
 	DeleteServices(ctx context.Context, sandboxID string) error
 
+	// Docker hosts
+	GetHostStates(ctx context.Context) (map[string]*models.HostState, error)
+	SetHostState(ctx context.Context, st *models.HostState) error
+	CountSandboxesByHost(ctx context.Context, defaultHost string) (map[string]int, error)
+	CountGPUSandboxesByHost(ctx context.Context, defaultHost string) (map[string]int, error)
+	AssignUnplacedSandboxes(ctx context.Context, host string) (int64, error)
+
+	// Sandbox events
+	AppendEvent(ctx context.Context, ev *models.SandboxEvent) error
+	ListEvents(ctx context.Context, sandboxID string) ([]*models.SandboxEvent, error)
+	DeleteEventsBefore(ctx context.Context, cutoff time.Time) (int64, error)
+
+	// Template usage
+	RecordTemplateUsage(ctx context.Context, rec *models.UsageRecord) (bool, error)
+	GetTemplateUsage(ctx context.Context, from, to time.Time) ([]*models.TemplateUsage, error)
+
 	// Sessions
 	CreateSession(ctx context.Context, s *models.Session) error
 	GetSessionByToken(ctx context.Context, token string) (*models.Session, error)
 	GetSessionByID(ctx context.Context, id string) (*models.Session, error)
+	GetSessionBySandboxID(ctx context.Context, sandboxID string) (*models.Session, error)
+	GetLiveSessionByUniqueKey(ctx context.Context, uniqueKey string) (*models.Session, error)
 	UpdateSession(ctx context.Context, s *models.Session) error
+	UpdateSessionFrom(ctx context.Context, s *models.Session, from models.SessionStatus) (bool, error)
+	ClaimSession(ctx context.Context, s *models.Session) (bool, error)
 	DeleteSession(ctx context.Context, id string) error
-	ListSessions(ctx context.Context, status string, limit, offset int) ([]*models.Session, error)
-	GetExpiredSessions(ctx context.Context) ([]*models.Session, error)
+	PurgeRevokedSessions(ctx context.Context, cutoff time.Time) (int64, error)
+	ListSessions(ctx context.Context, filters models.SessionFilters) ([]*models.Session, error)
+	CountSessions(ctx context.Context, filters models.SessionFilters) (int, error)
+	GetExpiredSessions(ctx context.Context, limit int) ([]*models.Session, error)
+	CountExpiredSessions(ctx context.Context) (int, error)
+	SetSessionSandboxStatus(ctx context.Context, sandboxID string, status models.SandboxStatus) error
+	GetPrewarmDueSessions(ctx context.Context, limit int) ([]*models.Session, error)
+	AttachSessionSandbox(ctx context.Context, id, sandboxID string) (bool, error)
+	RevokeSession(ctx context.Context, id, revokedBy string, at time.Time) (string, bool, error)
+
+	// Session terminal connections
+	OpenSessionConnection(ctx context.Context, c *models.SessionConnection) error
+	CloseSessionConnection(ctx context.Context, id int64, at time.Time) error
+	ListSessionConnections(ctx context.Context, sessionID string) ([]*models.SessionConnection, error)
+
+	// Session callback deliveries
+	RecordCallbackDelivery(ctx context.Context, d *models.CallbackDelivery) error
+	UpdateCallbackDelivery(ctx context.Context, d *models.CallbackDelivery) error
+	ClaimCallbackDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*models.CallbackDelivery, error)
+	PurgeCallbackDeliveries(ctx context.Context) (int64, error)
+	ListCallbackDeliveries(ctx context.Context, sessionID string) ([]*models.CallbackDelivery, error)
+
+	// Terminal recordings
+	CreateTerminalRecording(ctx context.Context, rec *models.TerminalRecording) error
+	FinishTerminalRecording(ctx context.Context, rec *models.TerminalRecording) error
+	ListTerminalRecordings(ctx context.Context, sandboxID string) ([]*models.TerminalRecording, error)
+	GetTerminalRecording(ctx context.Context, id int64) (*models.TerminalRecording, error)
+	PurgeTerminalRecordings(ctx context.Context, cutoff time.Time) ([]*models.TerminalRecording, error)
+
+	// Grading results
+	CreateGradingResult(ctx context.Context, res *models.GradingResult) error
+	ListGradingResults(ctx context.Context, sandboxID string) ([]*models.GradingResult, error)
+
+	// Templates created through the API
+	ListTemplateDocuments(ctx context.Context) ([]*models.TemplateDocument, error)
+	GetTemplateDocument(ctx context.Context, name string) (*models.TemplateDocument, error)
+	SaveTemplateDocument(ctx context.Context, doc *models.TemplateDocument) error
+	// CreateTemplateDocument inserts doc and reports false, storing nothing,
+	// when a document of its name exists
+	CreateTemplateDocument(ctx context.Context, doc *models.TemplateDocument) (bool, error)
+	// LockTemplateDocument reports whether a document named name exists and,
+	// in a transaction, locks it until the transaction ends: shared to keep it
+	// from being deleted, or forUpdate to delete it
+	LockTemplateDocument(ctx context.Context, name string, forUpdate bool) (bool, error)
+	DeleteTemplateDocument(ctx context.Context, name string) error
 
 	// API Clients
 	GetClientByApiKey(ctx context.Context, apiKey string) (*models.ApiClient, error)
+	GetClient(ctx context.Context, id int) (*models.ApiClient, error)
+	ListClients(ctx context.Context) ([]*models.ApiClient, error)
+	CreateClient(ctx context.Context, c *models.ApiClient) error
+	UpdateClient(ctx context.Context, c *models.ApiClient) error
+	RotateClientKey(ctx context.Context, id int, apiKey string) error
+	DeactivateExpiredClients(ctx context.Context, now time.Time) ([]*models.ApiClient, error)
 	UpdateClientLastUsed(ctx context.Context, apiKey string) error
+	// EnsureJWTClient returns the ID of the client row of a JWT subject,
+	// creating it inactive, with name and a random key, on first use
+	EnsureJWTClient(ctx context.Context, subject, name string) (int, error)
+
+	// Transactions
+	WithTx(ctx context.Context, fn func(Repository) error) error
 
 	// Health
 	Ping(ctx context.Context) error
@@ -0,0 +1,2174 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+// SqliteRepository implements Repository using a SQLite database file, for
+// single-node installs and local development. The schema is migrations/sqlite:
+// JSONB columns are TEXT holding the same JSON, and timestamps are UTC text in
+// sqliteTimeFormat so they compare in time order.
+type SqliteRepository struct {
+	pool *sql.DB
+	// db runs statements: the pool, or the transaction of a repository handed out by WithTx
+	db   sqliteConn
+	inTx bool
+}
+
+// sqliteConn is what SqliteRepository statements run on, satisfied by both *sql.DB and *sql.Tx
+type sqliteConn interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// sqliteRow is a single result row, satisfied by both *sql.Row and *sql.Rows
+type sqliteRow interface {
+	Scan(dest ...any) error
+}
+
+// NewSqliteRepository opens the SQLite database at path, creating the file if needed
+func NewSqliteRepository(ctx context.Context, path string) (*SqliteRepository, error) {
+	db, err := openSqlite(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	return &SqliteRepository{pool: db, db: db}, nil
+}
+
+// openSqlite opens path with foreign keys enforced. SQLite has a single writer,
+// so the pool holds one connection: statements queue in database/sql instead
+// of failing with SQLITE_BUSY.
+func openSqlite(path string) (*sql.DB, error) {
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	dsn := path + sep + "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	return db, nil
+}
+
+// WithTx runs fn with a repository whose statements share one transaction,
+// committing when fn returns nil and rolling back otherwise. Calls on a
+// repository that is already in a transaction join it.
+func (r *SqliteRepository) WithTx(ctx context.Context, fn func(Repository) error) error {
+	return r.withTx(ctx, func(tx *SqliteRepository) error { return fn(tx) })
+}
+
+func (r *SqliteRepository) withTx(ctx context.Context, fn func(*SqliteRepository) error) error {
+	if r.inTx {
+		return fn(r)
+	}
+
+	tx, err := r.pool.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	// A no-op once committed
+	defer func() { _ = tx.Rollback() }()
+
+	if err := fn(&SqliteRepository{pool: r.pool, db: tx, inTx: true}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Ping checks database connectivity
+func (r *SqliteRepository) Ping(ctx context.Context) error {
+	return r.pool.PingContext(ctx)
+}
+
+// Close closes the database. It is a no-op inside WithTx, where the
+// database belongs to the outer repository.
+func (r *SqliteRepository) Close() error {
+	if r.inTx {
+		return nil
+	}
+	return r.pool.Close()
+}
+
+// --- Timestamps ---
+
+// sqliteTimeFormat is how timestamps are stored: UTC and fixed width, so text
+// comparison and ORDER BY follow time order
+const sqliteTimeFormat = "2006-01-02 15:04:05.000000000"
+
+// sqliteTimeArg formats t for a TIMESTAMP column or comparison
+func sqliteTimeArg(t time.Time) string {
+	return t.UTC().Format(sqliteTimeFormat)
+}
+
+// sqliteNullTimeArg is sqliteTimeArg for nullable columns
+func sqliteNullTimeArg(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return sqliteTimeArg(*t)
+}
+
+// sqliteTime scans a nullable TIMESTAMP column. The driver hands back either
+// text or, for columns it recognizes as timestamps, an already parsed time.
+type sqliteTime struct {
+	Time  time.Time
+	Valid bool
+}
+
+func (t *sqliteTime) Scan(value any) error {
+	switch v := value.(type) {
+	case nil:
+		t.Time, t.Valid = time.Time{}, false
+		return nil
+	case time.Time:
+		t.Time, t.Valid = v.UTC(), true
+		return nil
+	case []byte:
+		return t.parse(string(v))
+	case string:
+		return t.parse(v)
+	}
+	return fmt.Errorf("unsupported timestamp value %T", value)
+}
+
+func (t *sqliteTime) parse(s string) error {
+	// Also accepts the shorter strftime('%Y-%m-%d %H:%M:%f') of column defaults
+	parsed, err := time.Parse("2006-01-02 15:04:05.999999999", s)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %q: %w", s, err)
+	}
+	t.Time, t.Valid = parsed, true
+	return nil
+}
+
+// ptr returns the time, or nil for NULL
+func (t sqliteTime) ptr() *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+// --- Sandboxes ---
+
+// CreateSandbox creates a new sandbox record
+func (r *SqliteRepository) CreateSandbox(ctx context.Context, sb *models.Sandbox) error {
+	metadataJSON, err := json.Marshal(sb.Metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+
+	endpointsJSON, err := json.Marshal(sb.Endpoints)
+	if err != nil {
+		return fmt.Errorf("failed to marshal endpoints: %w", err)
+	}
+
+	query := `
+		INSERT INTO sandboxes (id, template_id, user_id, status, status_message, container_id, created_at, started_at, expires_at, metadata, endpoints, host, owner_client_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	_, err = r.db.ExecContext(ctx, query,
+		sb.ID,
+		sb.TemplateID,
+		sb.UserID,
+		string(sb.Status),
+		nullString(sb.StatusMsg),
+		nullString(sb.ContainerID),
+		sqliteTimeArg(sb.CreatedAt),
+		sqliteNullTimeArg(sb.StartedAt),
+		sqliteTimeArg(sb.ExpiresAt),
+		string(metadataJSON),
+		string(endpointsJSON),
+		nullString(sb.Host),
+		nullInt(sb.OwnerClientID),
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to create sandbox: %w", err)
+	}
+
+	return nil
+}
+
+// scanSqliteSandbox scans a row selected with sandboxColumns (services are not loaded)
+func scanSqliteSandbox(row sqliteRow) (*models.Sandbox, error) {
+	var sb models.Sandbox
+	var statusStr string
+	var statusMsg, containerID, host sql.NullString
+	var ownerClientID sql.NullInt64
+	var createdAt, startedAt, expiresAt, lastActivityAt, deletedAt sqliteTime
+	var metadataJSON, endpointsJSON []byte
+
+	err := row.Scan(
+		&sb.ID,
+		&sb.TemplateID,
+		&sb.UserID,
+		&statusStr,
+		&statusMsg,
+		&containerID,
+		&createdAt,
+		&startedAt,
+		&expiresAt,
+		&metadataJSON,
+		&endpointsJSON,
+		&lastActivityAt,
+		&host,
+		&deletedAt,
+		&ownerClientID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	sb.Status = models.SandboxStatus(statusStr)
+	sb.StatusMsg = statusMsg.String
+	sb.ContainerID = containerID.String
+	sb.Host = host.String
+	sb.OwnerClientID = int(ownerClientID.Int64)
+	sb.CreatedAt = createdAt.Time
+	sb.ExpiresAt = expiresAt.Time
+	sb.StartedAt = startedAt.ptr()
+	sb.LastActivityAt = lastActivityAt.ptr()
+	sb.DeletedAt = deletedAt.ptr()
+
+	if err := json.Unmarshal(metadataJSON, &sb.Metadata); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal metadata: %w", err)
+	}
+
+	if err := json.Unmarshal(endpointsJSON, &sb.Endpoints); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal endpoints: %w", err)
+	}
+
+	return &sb, nil
+}
+
+// querySandboxes runs a sandbox SELECT and scans all rows, loading services for each
+func (r *SqliteRepository) querySandboxes(ctx context.Context, query string, args ...interface{}) ([]*models.Sandbox, error) {
+	sandboxes, err := r.querySandboxRows(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Rows are closed by now: the pool's one connection can't serve a second query meanwhile
+	for _, sb := range sandboxes {
+		if err := r.loadServices(ctx, sb); err != nil {
+			return nil, fmt.Errorf("failed to get services for sandbox %s: %w", sb.ID, err)
+		}
+	}
+
+	return sandboxes, nil
+}
+
+// querySandboxRows runs a sandbox SELECT and scans all rows without loading services
+func (r *SqliteRepository) querySandboxRows(ctx context.Context, query string, args ...interface{}) ([]*models.Sandbox, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sandboxes []*models.Sandbox
+
+	for rows.Next() {
+		sb, err := scanSqliteSandbox(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan sandbox: %w", err)
+		}
+		sandboxes = append(sandboxes, sb)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating sandboxes: %w", err)
+	}
+
+	return sandboxes, nil
+}
+
+// loadServices attaches service instances to a sandbox
+func (r *SqliteRepository) loadServices(ctx context.Context, sb *models.Sandbox) error {
+	services, err := r.GetServices(ctx, sb.ID)
+	if err != nil {
+		return err
+	}
+
+	sb.Services = make(map[string]*models.ServiceInstance)
+	for _, svc := range services {
+		sb.Services[svc.Name] = svc
+	}
+	return nil
+}
+
+// GetSandbox retrieves a sandbox by ID. Deleted sandboxes are not found.
+func (r *SqliteRepository) GetSandbox(ctx context.Context, id string) (*models.Sandbox, error) {
+	query := `SELECT ` + sandboxColumns + ` FROM sandboxes WHERE id = ? AND deleted_at IS NULL`
+
+	sb, err := scanSqliteSandbox(r.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil // Not found
+		}
+		return nil, fmt.Errorf("failed to get sandbox: %w", err)
+	}
+
+	// Load services
+	if err := r.loadServices(ctx, sb); err != nil {
+		return nil, fmt.Errorf("failed to get services: %w", err)
+	}
+
+	return sb, nil
+}
+
+// UpdateSandbox updates an existing sandbox
+func (r *SqliteRepository) UpdateSandbox(ctx context.Context, sb *models.Sandbox) error {
+	metadataJSON, err := json.Marshal(sb.Metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+
+	endpointsJSON, err := json.Marshal(sb.Endpoints)
+	if err != nil {
+		return fmt.Errorf("failed to marshal endpoints: %w", err)
+	}
+
+	query := `
+		UPDATE sandboxes
+		SET status = ?, status_message = ?, container_id = ?, started_at = ?, expires_at = ?, metadata = ?, endpoints = ?
+		WHERE id = ? AND deleted_at IS NULL
+	`
+
+	result, err := r.db.ExecContext(ctx, query,
+		string(sb.Status),
+		nullString(sb.StatusMsg),
+		nullString(sb.ContainerID),
+		sqliteNullTimeArg(sb.StartedAt),
+		sqliteTimeArg(sb.ExpiresAt),
+		string(metadataJSON),
+		string(endpointsJSON),
+		sb.ID,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to update sandbox: %w", err)
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("sandbox not found: %s", sb.ID)
+	}
+
+	return nil
+}
+
+// DeleteSandbox marks a sandbox deleted, or deletes its row when hard (see PostgresRepository.DeleteSandbox)
+func (r *SqliteRepository) DeleteSandbox(ctx context.Context, id string, hard bool) error {
+	query, args := `UPDATE sandboxes SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL`, []interface{}{sqliteTimeArg(time.Now()), id}
+	if hard {
+		query, args = `DELETE FROM sandboxes WHERE id = ?`, []interface{}{id}
+	}
+
+	result, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to delete sandbox: %w", err)
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("sandbox not found: %s", id)
+	}
+
+	return nil
+}
+
+// PurgeDeletedSandboxes removes the records of sandboxes deleted before cutoff
+func (r *SqliteRepository) PurgeDeletedSandboxes(ctx context.Context, cutoff time.Time) (int64, error) {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM sandboxes WHERE deleted_at < ?`, sqliteTimeArg(cutoff))
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge deleted sandboxes: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// sqliteSandboxFilterWhere is sandboxFilterWhere for SQLite. A metadata filter
+// matches sandboxes having every given key with the given value, like jsonb @>.
+func sqliteSandboxFilterWhere(filters models.ListFilters) (string, []interface{}) {
+	where := ` WHERE 1=1`
+	args := make([]interface{}, 0)
+
+	if !filters.IncludeDeleted {
+		where += " AND deleted_at IS NULL"
+	}
+
+	if filters.UserID != "" {
+		where += " AND user_id = ?"
+		args = append(args, filters.UserID)
+	}
+
+	if filters.TemplateID != "" {
+		where += " AND template_id = ?"
+		args = append(args, filters.TemplateID)
+	}
+
+	if filters.Status != "" {
+		where += " AND status = ?"
+		args = append(args, string(filters.Status))
+	}
+
+	if filters.Host != "" {
+		where += " AND COALESCE(host, ?) = ?"
+		args = append(args, filters.DefaultHost, filters.Host)
+	}
+
+	if filters.OwnerClientID != 0 {
+		where += " AND owner_client_id = ?"
+		args = append(args, filters.OwnerClientID)
+	}
+
+	if !filters.CreatedAfter.IsZero() {
+		where += " AND created_at >= ?"
+		args = append(args, sqliteTimeArg(filters.CreatedAfter))
+	}
+
+	if !filters.CreatedBefore.IsZero() {
+		where += " AND created_at < ?"
+		args = append(args, sqliteTimeArg(filters.CreatedBefore))
+	}
+
+	// Sorted so equal filters build equal statements
+	keys := make([]string, 0, len(filters.Metadata))
+	for key := range filters.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		where += " AND EXISTS (SELECT 1 FROM json_each(metadata) WHERE key = ? AND value = ?)"
+		args = append(args, key, filters.Metadata[key])
+	}
+
+	return where, args
+}
+
+// sqliteLimitOffset appends LIMIT and OFFSET to query. SQLite only accepts
+// OFFSET after a LIMIT, where -1 means no limit.
+func sqliteLimitOffset(query string, args []interface{}, limit, offset int) (string, []interface{}) {
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	} else if offset > 0 {
+		query += " LIMIT -1"
+	}
+
+	if offset > 0 {
+		query += " OFFSET ?"
+		args = append(args, offset)
+	}
+
+	return query, args
+}
+
+// ListSandboxes returns sandboxes matching filters
+func (r *SqliteRepository) ListSandboxes(ctx context.Context, filters models.ListFilters) ([]*models.Sandbox, error) {
+	where, args := sqliteSandboxFilterWhere(filters)
+	query := `SELECT ` + sandboxColumns + ` FROM sandboxes` + where + sandboxOrderBy(filters)
+	query, args = sqliteLimitOffset(query, args, filters.Limit, filters.Offset)
+
+	sandboxes, err := r.querySandboxes(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sandboxes: %w", err)
+	}
+
+	return sandboxes, nil
+}
+
+// CountSandboxes counts sandboxes matching filters, ignoring Limit and Offset
+func (r *SqliteRepository) CountSandboxes(ctx context.Context, filters models.ListFilters) (int, error) {
+	where, args := sqliteSandboxFilterWhere(filters)
+
+	var count int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sandboxes`+where, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count sandboxes: %w", err)
+	}
+
+	return count, nil
+}
+
+// sqliteExpiredSandboxesWhere is expiredSandboxesWhere with the current time as the only argument
+const sqliteExpiredSandboxesWhere = `status NOT IN ('failed', 'expired', 'deleting') AND deleted_at IS NULL AND expires_at < ?`
+
+// GetExpiredSandboxes returns up to limit expired sandboxes, oldest expiry first.
+// Services are not loaded: the cleaner only needs IDs, and Delete reloads the sandbox.
+func (r *SqliteRepository) GetExpiredSandboxes(ctx context.Context, limit int) ([]*models.Sandbox, error) {
+	query := `SELECT ` + sandboxColumns + `
+		FROM sandboxes
+		WHERE ` + sqliteExpiredSandboxesWhere + `
+		ORDER BY expires_at ASC
+		LIMIT ?
+	`
+
+	sandboxes, err := r.querySandboxRows(ctx, query, sqliteTimeArg(time.Now()), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get expired sandboxes: %w", err)
+	}
+
+	return sandboxes, nil
+}
+
+// CountExpiredSandboxes counts expired sandboxes not yet cleaned up
+func (r *SqliteRepository) CountExpiredSandboxes(ctx context.Context) (int, error) {
+	var count int
+	err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sandboxes WHERE `+sqliteExpiredSandboxesWhere, sqliteTimeArg(time.Now())).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count expired sandboxes: %w", err)
+	}
+	return count, nil
+}
+
+// GetIdleSandboxes returns running sandboxes with no terminal activity since the given time.
+// Sandboxes that never saw activity are measured from when they started.
+func (r *SqliteRepository) GetIdleSandboxes(ctx context.Context, idleSince time.Time) ([]*models.Sandbox, error) {
+	query := `SELECT ` + sandboxColumns + `
+		FROM sandboxes
+		WHERE status = 'running' AND deleted_at IS NULL
+		  AND COALESCE(last_activity_at, started_at, created_at) < ?
+		ORDER BY COALESCE(last_activity_at, started_at, created_at) ASC
+	`
+
+	sandboxes, err := r.querySandboxes(ctx, query, sqliteTimeArg(idleSince))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get idle sandboxes: %w", err)
+	}
+
+	return sandboxes, nil
+}
+
+// TouchSandboxActivity records terminal activity for a sandbox
+func (r *SqliteRepository) TouchSandboxActivity(ctx context.Context, id string, at time.Time) error {
+	query := `UPDATE sandboxes SET last_activity_at = ? WHERE id = ? AND deleted_at IS NULL`
+
+	if _, err := r.db.ExecContext(ctx, query, sqliteTimeArg(at), id); err != nil {
+		return fmt.Errorf("failed to update sandbox activity: %w", err)
+	}
+
+	return nil
+}
+
+// ExtendSandboxFrom extends a running sandbox whose expiry is still from (see PostgresRepository.ExtendSandboxFrom)
+func (r *SqliteRepository) ExtendSandboxFrom(ctx context.Context, sb *models.Sandbox, from time.Time) (bool, error) {
+	metadataJSON, err := json.Marshal(sb.Metadata)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+
+	query := `
+		UPDATE sandboxes
+		SET expires_at = ?, metadata = ?
+		WHERE id = ? AND expires_at = ? AND status = ? AND deleted_at IS NULL
+	`
+
+	result, err := r.db.ExecContext(ctx, query, sqliteTimeArg(sb.ExpiresAt), string(metadataJSON), sb.ID, sqliteTimeArg(from), string(models.StatusRunning))
+	if err != nil {
+		return false, fmt.Errorf("failed to extend sandbox: %w", err)
+	}
+
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// --- Services ---
+
+// CreateService creates a new service instance for a sandbox
+func (r *SqliteRepository) CreateService(ctx context.Context, sandboxID string, svc *models.ServiceInstance) error {
+	credentialsJSON, err := json.Marshal(svc.Credentials)
+	if err != nil {
+		return fmt.Errorf("failed to marshal credentials: %w", err)
+	}
+
+	query := `
+		INSERT INTO sandbox_services (sandbox_id, service_name, service_type, status, credentials, created_at, mode, container_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (sandbox_id, service_name) DO UPDATE
+		SET status = excluded.status, credentials = excluded.credentials,
+			mode = excluded.mode, container_id = excluded.container_id
+	`
+
+	_, err = r.db.ExecContext(ctx, query,
+		sandboxID,
+		svc.Name,
+		svc.Type,
+		svc.Status,
+		string(credentialsJSON),
+		sqliteTimeArg(svc.CreatedAt),
+		nullString(string(svc.Mode)),
+		nullString(svc.ContainerID),
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to create service: %w", err)
+	}
+
+	return nil
+}
+
+// GetServices retrieves all services for a sandbox
+func (r *SqliteRepository) GetServices(ctx context.Context, sandboxID string) ([]*models.ServiceInstance, error) {
+	query := `
+		SELECT service_name, service_type, status, credentials, created_at, status_message, last_checked_at, mode, container_id
+		FROM sandbox_services
+		WHERE sandbox_id = ?
+		ORDER BY service_name
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, sandboxID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get services: %w", err)
+	}
+	defer rows.Close()
+
+	var services []*models.ServiceInstance
+
+	for rows.Next() {
+		var svc models.ServiceInstance
+		var credentialsJSON []byte
+		var statusMsg, mode, containerID sql.NullString
+		var createdAt, lastCheckedAt sqliteTime
+
+		err := rows.Scan(
+			&svc.Name,
+			&svc.Type,
+			&svc.Status,
+			&credentialsJSON,
+			&createdAt,
+			&statusMsg,
+			&lastCheckedAt,
+			&mode,
+			&containerID,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan service: %w", err)
+		}
+
+		svc.StatusMsg = statusMsg.String
+		svc.Mode = models.ServiceMode(mode.String)
+		svc.ContainerID = containerID.String
+		svc.CreatedAt = createdAt.Time
+		svc.LastCheckedAt = lastCheckedAt.ptr()
+
+		if credentialsJSON != nil {
+			if err := json.Unmarshal(credentialsJSON, &svc.Credentials); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal credentials: %w", err)
+			}
+		}
+
+		services = append(services, &svc)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating services: %w", err)
+	}
+
+	return services, nil
+}
+
+// UpdateService updates a service instance
+func (r *SqliteRepository) UpdateService(ctx context.Context, sandboxID string, svc *models.ServiceInstance) error {
+	credentialsJSON, err := json.Marshal(svc.Credentials)
+	if err != nil {
+		return fmt.Errorf("failed to marshal credentials: %w", err)
+	}
+
+	query := `
+		UPDATE sandbox_services
+		SET status = ?, credentials = ?, status_message = ?, last_checked_at = ?
+		WHERE sandbox_id = ? AND service_name = ?
+	`
+
+	result, err := r.db.ExecContext(ctx, query, svc.Status, string(credentialsJSON),
+		nullString(svc.StatusMsg), sqliteNullTimeArg(svc.LastCheckedAt), sandboxID, svc.Name)
+	if err != nil {
+		return fmt.Errorf("failed to update service: %w", err)
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("service not found: %s/%s", sandboxID, svc.Name)
+	}
+
+	return nil
+}
+
+// DeleteServices deletes all services for a sandbox
+func (r *SqliteRepository) DeleteServices(ctx context.Context, sandboxID string) error {
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM sandbox_services WHERE sandbox_id = ?`, sandboxID); err != nil {
+		return fmt.Errorf("failed to delete services: %w", err)
+	}
+
+	return nil
+}
+
+// --- API clients ---
+
+// scanSqliteClient scans a row selected with clientColumns
+func scanSqliteClient(row sqliteRow) (*models.ApiClient, error) {
+	var client models.ApiClient
+	var createdAt, lastUsedAt, expiresAt sqliteTime
+	var permissionsJSON, metadataJSON, cidrsJSON []byte
+
+	err := row.Scan(
+		&client.ID,
+		&client.Name,
+		&client.ApiKey,
+		&client.IsActive,
+		&createdAt,
+		&lastUsedAt,
+		&permissionsJSON,
+		&metadataJSON,
+		&expiresAt,
+		&cidrsJSON,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	client.CreatedAt = createdAt.Time
+	client.LastUsedAt = lastUsedAt.ptr()
+	client.ExpiresAt = expiresAt.ptr()
+	if err := unmarshalClientCIDRs(cidrsJSON, &client); err != nil {
+		return nil, err
+	}
+
+	// Parse permissions JSON array
+	if permissionsJSON != nil {
+		if err := json.Unmarshal(permissionsJSON, &client.Permissions); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal permissions: %w", err)
+		}
+	}
+
+	// Parse metadata JSON object
+	if metadataJSON != nil {
+		if err := json.Unmarshal(metadataJSON, &client.Metadata); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal metadata: %w", err)
+		}
+	}
+
+	return &client, nil
+}
+
+// getClient runs a single-client SELECT, returning nil, nil when no row matches
+func (r *SqliteRepository) getClient(ctx context.Context, query string, arg interface{}) (*models.ApiClient, error) {
+	client, err := scanSqliteClient(r.db.QueryRowContext(ctx, query, arg))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil // Not found
+		}
+		return nil, fmt.Errorf("failed to get api client: %w", err)
+	}
+	return client, nil
+}
+
+// GetClientByApiKey retrieves an API client by its key
+func (r *SqliteRepository) GetClientByApiKey(ctx context.Context, apiKey string) (*models.ApiClient, error) {
+	return r.getClient(ctx, `SELECT `+clientColumns+` FROM api_clients WHERE api_key = ?`, apiKey)
+}
+
+// GetClient retrieves an API client by ID
+func (r *SqliteRepository) GetClient(ctx context.Context, id int) (*models.ApiClient, error) {
+	return r.getClient(ctx, `SELECT `+clientColumns+` FROM api_clients WHERE id = ?`, id)
+}
+
+// ListClients returns all API clients, oldest first
+func (r *SqliteRepository) ListClients(ctx context.Context) ([]*models.ApiClient, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+clientColumns+` FROM api_clients ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list api clients: %w", err)
+	}
+	defer rows.Close()
+
+	var clients []*models.ApiClient
+	for rows.Next() {
+		client, err := scanSqliteClient(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan api client: %w", err)
+		}
+		clients = append(clients, client)
+	}
+
+	return clients, rows.Err()
+}
+
+// CreateClient creates an API client, setting its ID and creation time
+func (r *SqliteRepository) CreateClient(ctx context.Context, c *models.ApiClient) error {
+	permissionsJSON, metadataJSON, cidrsJSON, err := marshalClient(c)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	query := `
+		INSERT INTO api_clients (name, api_key, is_active, created_at, permissions, metadata, expires_at, allowed_cidrs)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	result, err := r.db.ExecContext(ctx, query, c.Name, c.ApiKey, c.IsActive, sqliteTimeArg(now), string(permissionsJSON), string(metadataJSON),
+		sqliteNullTimeArg(c.ExpiresAt), string(cidrsJSON))
+	if err != nil {
+		return fmt.Errorf("failed to create api client: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to create api client: %w", err)
+	}
+	c.ID = int(id)
+	c.CreatedAt = now
+
+	return nil
+}
+
+// UpdateClient updates the name, active flag, permissions, metadata, expiry
+// and allowed CIDRs of an API client
+func (r *SqliteRepository) UpdateClient(ctx context.Context, c *models.ApiClient) error {
+	permissionsJSON, metadataJSON, cidrsJSON, err := marshalClient(c)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE api_clients
+		SET name = ?, is_active = ?, permissions = ?, metadata = ?, expires_at = ?, allowed_cidrs = ?
+		WHERE id = ?
+	`
+
+	result, err := r.db.ExecContext(ctx, query, c.Name, c.IsActive, string(permissionsJSON), string(metadataJSON),
+		sqliteNullTimeArg(c.ExpiresAt), string(cidrsJSON), c.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update api client: %w", err)
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("api client not found: %d", c.ID)
+	}
+
+	return nil
+}
+
+// RotateClientKey replaces the key of an API client, keeping last_used_at
+func (r *SqliteRepository) RotateClientKey(ctx context.Context, id int, apiKey string) error {
+	result, err := r.db.ExecContext(ctx, `UPDATE api_clients SET api_key = ? WHERE id = ?`, apiKey, id)
+	if err != nil {
+		return fmt.Errorf("failed to rotate api client key: %w", err)
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("api client not found: %d", id)
+	}
+
+	return nil
+}
+
+// DeactivateExpiredClients marks active clients whose key expired by now as
+// inactive and returns them
+func (r *SqliteRepository) DeactivateExpiredClients(ctx context.Context, now time.Time) ([]*models.ApiClient, error) {
+	query := `
+		UPDATE api_clients SET is_active = 0
+		WHERE is_active AND expires_at IS NOT NULL AND expires_at <= ?
+		RETURNING ` + clientColumns
+
+	rows, err := r.db.QueryContext(ctx, query, sqliteTimeArg(now))
+	if err != nil {
+		return nil, fmt.Errorf("failed to deactivate expired api clients: %w", err)
+	}
+	defer rows.Close()
+
+	var clients []*models.ApiClient
+	for rows.Next() {
+		client, err := scanSqliteClient(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan api client: %w", err)
+		}
+		clients = append(clients, client)
+	}
+
+	return clients, rows.Err()
+}
+
+// UpdateClientLastUsed updates the last_used_at timestamp for a client
+func (r *SqliteRepository) UpdateClientLastUsed(ctx context.Context, apiKey string) error {
+	query := `UPDATE api_clients SET last_used_at = ? WHERE api_key = ?`
+
+	if _, err := r.db.ExecContext(ctx, query, sqliteTimeArg(time.Now()), apiKey); err != nil {
+		return fmt.Errorf("failed to update client last_used_at: %w", err)
+	}
+
+	return nil
+}
+
+// EnsureJWTClient returns the ID of the client row of a JWT subject, creating
+// it inactive with no permissions on first use
+func (r *SqliteRepository) EnsureJWTClient(ctx context.Context, subject, name string) (int, error) {
+	apiKey, err := models.GenerateApiKey()
+	if err != nil {
+		return 0, fmt.Errorf("failed to generate api key: %w", err)
+	}
+
+	now := sqliteTimeArg(time.Now())
+	query := `
+		INSERT INTO api_clients (name, api_key, is_active, created_at, permissions, metadata, jwt_subject, last_used_at)
+		VALUES (?, ?, 0, ?, '[]', '{}', ?, ?)
+		ON CONFLICT (jwt_subject) DO UPDATE SET last_used_at = excluded.last_used_at
+		RETURNING id
+	`
+
+	var id int
+	if err := r.db.QueryRowContext(ctx, query, name, apiKey, now, subject, now).Scan(&id); err != nil {
+		return 0, fmt.Errorf("failed to ensure jwt client: %w", err)
+	}
+	return id, nil
+}
+
+// --- Docker hosts ---
+
+// GetHostStates returns the persisted scheduling state of all known hosts, keyed by name
+func (r *SqliteRepository) GetHostStates(ctx context.Context) (map[string]*models.HostState, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT name, draining, drain_mode, updated_at FROM docker_hosts`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get host states: %w", err)
+	}
+	defer rows.Close()
+
+	states := make(map[string]*models.HostState)
+	for rows.Next() {
+		var st models.HostState
+		var mode sql.NullString
+		var updatedAt sqliteTime
+		if err := rows.Scan(&st.Name, &st.Draining, &mode, &updatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan host state: %w", err)
+		}
+		st.DrainMode = models.DrainMode(mode.String)
+		st.UpdatedAt = updatedAt.Time
+		states[st.Name] = &st
+	}
+
+	return states, rows.Err()
+}
+
+// SetHostState creates or updates the scheduling state of a host
+func (r *SqliteRepository) SetHostState(ctx context.Context, st *models.HostState) error {
+	query := `
+		INSERT INTO docker_hosts (name, draining, drain_mode, updated_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (name) DO UPDATE
+		SET draining = excluded.draining, drain_mode = excluded.drain_mode, updated_at = excluded.updated_at
+	`
+
+	now := time.Now().UTC()
+	if _, err := r.db.ExecContext(ctx, query, st.Name, st.Draining, nullString(string(st.DrainMode)), sqliteTimeArg(now)); err != nil {
+		return fmt.Errorf("failed to set host state: %w", err)
+	}
+	st.UpdatedAt = now
+
+	return nil
+}
+
+// AssignUnplacedSandboxes records host on sandboxes created before placement was tracked
+func (r *SqliteRepository) AssignUnplacedSandboxes(ctx context.Context, host string) (int64, error) {
+	result, err := r.db.ExecContext(ctx, `UPDATE sandboxes SET host = ? WHERE host IS NULL AND deleted_at IS NULL`, host)
+	if err != nil {
+		return 0, fmt.Errorf("failed to assign unplaced sandboxes: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// CountSandboxesByHost counts sandboxes still holding resources per host.
+// Sandboxes created before placement was recorded are counted on defaultHost.
+func (r *SqliteRepository) CountSandboxesByHost(ctx context.Context, defaultHost string) (map[string]int, error) {
+	query := `
+		SELECT COALESCE(host, ?), COUNT(*)
+		FROM sandboxes
+		WHERE status NOT IN ('failed', 'expired', 'deleting') AND deleted_at IS NULL
+		GROUP BY 1
+	`
+
+	counts, err := r.countByHost(ctx, query, defaultHost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count sandboxes by host: %w", err)
+	}
+	return counts, nil
+}
+
+// CountGPUSandboxesByHost counts sandboxes holding GPUs per host, i.e. those
+// whose metadata records a resources.gpus grant
+func (r *SqliteRepository) CountGPUSandboxesByHost(ctx context.Context, defaultHost string) (map[string]int, error) {
+	query := `
+		SELECT COALESCE(host, ?), COUNT(*)
+		FROM sandboxes
+		WHERE status NOT IN ('failed', 'expired', 'deleting') AND deleted_at IS NULL
+		  AND EXISTS (SELECT 1 FROM json_each(metadata) WHERE key = 'resources.gpus')
+		GROUP BY 1
+	`
+
+	counts, err := r.countByHost(ctx, query, defaultHost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count GPU sandboxes by host: %w", err)
+	}
+	return counts, nil
+}
+
+// countByHost scans host, count rows
+func (r *SqliteRepository) countByHost(ctx context.Context, query string, args ...interface{}) (map[string]int, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var host string
+		var n int
+		if err := rows.Scan(&host, &n); err != nil {
+			return nil, fmt.Errorf("failed to scan host count: %w", err)
+		}
+		counts[host] = n
+	}
+
+	return counts, rows.Err()
+}
+
+// --- Sessions ---
+
+// scanSqliteSession scans a row selected with sessionColumns
+func scanSqliteSession(row sqliteRow) (*models.Session, error) {
+	var s models.Session
+	var statusStr string
+	var statusMsg, sandboxID, taskDescription, createdBy, uniqueKey, onConflict, revokedBy, sandboxStatus, callbackURL, callbackSecret sql.NullString
+	var createdAt, activatedAt, expiresAt, revokedAt, joinBy, submittedAt, prewarmAt sqliteTime
+	var ownerClientID sql.NullInt64
+	var envJSON, metadataJSON, servicesJSON []byte
+
+	err := row.Scan(
+		&s.ID,
+		&s.Token,
+		&s.TemplateID,
+		&statusStr,
+		&statusMsg,
+		&envJSON,
+		&metadataJSON,
+		&servicesJSON,
+		&s.TTLSeconds,
+		&sandboxID,
+		&taskDescription,
+		&createdAt,
+		&activatedAt,
+		&expiresAt,
+		&createdBy,
+		&uniqueKey,
+		&onConflict,
+		&revokedAt,
+		&revokedBy,
+		&joinBy,
+		&submittedAt,
+		&s.ExtendedSeconds,
+		&sandboxStatus,
+		&prewarmAt,
+		&callbackURL,
+		&callbackSecret,
+		&s.RecordTerminal,
+		&ownerClientID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Status = models.SessionStatus(statusStr)
+	s.StatusMessage = statusMsg.String
+	s.SandboxID = sandboxID.String
+	s.TaskDescription = taskDescription.String
+	s.CreatedBy = createdBy.String
+	s.OwnerClientID = int(ownerClientID.Int64)
+	s.UniqueKey = uniqueKey.String
+	s.OnConflict = models.ConflictPolicy(onConflict.String)
+	s.SandboxStatus = models.SandboxStatus(sandboxStatus.String)
+	s.CallbackURL = callbackURL.String
+	s.CallbackSecret = callbackSecret.String
+	s.CreatedAt = createdAt.Time
+	s.ActivatedAt = activatedAt.ptr()
+	s.ExpiresAt = expiresAt.ptr()
+	s.RevokedAt = revokedAt.ptr()
+	s.JoinBy = joinBy.ptr()
+	s.SubmittedAt = submittedAt.ptr()
+	s.PrewarmAt = prewarmAt.ptr()
+	s.RevokedBy = revokedBy.String
+
+	if envJSON != nil {
+		if err := json.Unmarshal(envJSON, &s.Env); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal env: %w", err)
+		}
+	}
+
+	if metadataJSON != nil {
+		if err := json.Unmarshal(metadataJSON, &s.Metadata); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal metadata: %w", err)
+		}
+	}
+
+	if servicesJSON != nil {
+		if err := json.Unmarshal(servicesJSON, &s.Services); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal services: %w", err)
+		}
+	}
+
+	return &s, nil
+}
+
+// querySessions runs a session SELECT and scans all rows
+func (r *SqliteRepository) querySessions(ctx context.Context, query string, args ...interface{}) ([]*models.Session, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*models.Session
+	for rows.Next() {
+		s, err := scanSqliteSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		sessions = append(sessions, s)
+	}
+
+	return sessions, rows.Err()
+}
+
+// CreateSession creates a new session record
+func (r *SqliteRepository) CreateSession(ctx context.Context, s *models.Session) error {
+	envJSON, err := json.Marshal(s.Env)
+	if err != nil {
+		return fmt.Errorf("failed to marshal env: %w", err)
+	}
+
+	metadataJSON, err := json.Marshal(s.Metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+
+	servicesJSON, err := json.Marshal(s.Services)
+	if err != nil {
+		return fmt.Errorf("failed to marshal services: %w", err)
+	}
+
+	query := `
+		INSERT INTO sessions (id, token, template_id, status, status_message, env, metadata, services, ttl_seconds, sandbox_id, task_description, created_at, activated_at, expires_at, created_by, unique_key, on_conflict, join_by, prewarm_at, callback_url, callback_secret, record_terminal, owner_client_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	_, err = r.db.ExecContext(ctx, query,
+		s.ID,
+		s.Token,
+		s.TemplateID,
+		string(s.Status),
+		nullString(s.StatusMessage),
+		string(envJSON),
+		string(metadataJSON),
+		string(servicesJSON),
+		s.TTLSeconds,
+		nullString(s.SandboxID),
+		s.TaskDescription,
+		sqliteTimeArg(s.CreatedAt),
+		sqliteNullTimeArg(s.ActivatedAt),
+		sqliteNullTimeArg(s.ExpiresAt),
+		nullString(s.CreatedBy),
+		nullString(s.UniqueKey),
+		nullString(string(s.OnConflict)),
+		sqliteNullTimeArg(s.JoinBy),
+		sqliteNullTimeArg(s.PrewarmAt),
+		nullString(s.CallbackURL),
+		nullString(s.CallbackSecret),
+		s.RecordTerminal,
+		nullInt(s.OwnerClientID),
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+
+	return nil
+}
+
+// GetSessionByToken retrieves a session by its join token
+func (r *SqliteRepository) GetSessionByToken(ctx context.Context, token string) (*models.Session, error) {
+	return r.getSession(ctx, "token", token)
+}
+
+// GetSessionByID retrieves a session by its ID
+func (r *SqliteRepository) GetSessionByID(ctx context.Context, id string) (*models.Session, error) {
+	return r.getSession(ctx, "id", id)
+}
+
+// GetSessionBySandboxID retrieves the session bound to a sandbox
+func (r *SqliteRepository) GetSessionBySandboxID(ctx context.Context, sandboxID string) (*models.Session, error) {
+	return r.getSession(ctx, "sandbox_id", sandboxID)
+}
+
+func (r *SqliteRepository) getSession(ctx context.Context, field, value string) (*models.Session, error) {
+	query := fmt.Sprintf(`SELECT `+sessionColumns+` FROM sessions WHERE %s = ?`, field)
+
+	s, err := scanSqliteSession(r.db.QueryRowContext(ctx, query, value))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+
+	return s, nil
+}
+
+// GetLiveSessionByUniqueKey returns the provisioning or active session holding
+// a unique key, or nil if none does
+func (r *SqliteRepository) GetLiveSessionByUniqueKey(ctx context.Context, uniqueKey string) (*models.Session, error) {
+	query := `SELECT ` + sessionColumns + `
+		FROM sessions
+		WHERE unique_key = ?
+		  AND status IN ('provisioning', 'active')
+	`
+
+	s, err := scanSqliteSession(r.db.QueryRowContext(ctx, query, uniqueKey))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get session by unique key: %w", err)
+	}
+
+	return s, nil
+}
+
+// UpdateSession updates an existing session. It returns ErrUniqueKeyConflict
+// if the new status would give the session's unique key a second live session.
+func (r *SqliteRepository) UpdateSession(ctx context.Context, s *models.Session) error {
+	updated, err := r.updateSession(ctx, s, "")
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return fmt.Errorf("session not found: %s", s.ID)
+	}
+	return nil
+}
+
+// UpdateSessionFrom updates a session like UpdateSession while its stored
+// status is still from. It reports false when another transition got there
+// first or the session is gone.
+func (r *SqliteRepository) UpdateSessionFrom(ctx context.Context, s *models.Session, from models.SessionStatus) (bool, error) {
+	return r.updateSession(ctx, s, from)
+}
+
+// updateSession writes s, only while its stored status is from unless from is empty
+func (r *SqliteRepository) updateSession(ctx context.Context, s *models.Session, from models.SessionStatus) (bool, error) {
+	envJSON, err := json.Marshal(s.Env)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal env: %w", err)
+	}
+
+	metadataJSON, err := json.Marshal(s.Metadata)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+
+	query := `
+		UPDATE sessions
+		SET status = ?, status_message = ?, sandbox_id = ?, activated_at = ?, expires_at = ?, env = ?, metadata = ?, task_description = ?,
+		    revoked_at = ?, revoked_by = ?, submitted_at = ?, extended_seconds = ?
+		WHERE id = ? AND (? = '' OR status = ?)
+	`
+
+	result, err := r.db.ExecContext(ctx, query,
+		string(s.Status),
+		nullString(s.StatusMessage),
+		nullString(s.SandboxID),
+		sqliteNullTimeArg(s.ActivatedAt),
+		sqliteNullTimeArg(s.ExpiresAt),
+		string(envJSON),
+		string(metadataJSON),
+		s.TaskDescription,
+		sqliteNullTimeArg(s.RevokedAt),
+		nullString(s.RevokedBy),
+		sqliteNullTimeArg(s.SubmittedAt),
+		s.ExtendedSeconds,
+		s.ID,
+		string(from),
+		string(from),
+	)
+
+	if err != nil {
+		if isSqliteSessionUniqueKeyViolation(err) {
+			return false, ErrUniqueKeyConflict
+		}
+		return false, fmt.Errorf("failed to update session: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to update session: %w", err)
+	}
+	return n == 1, nil
+}
+
+// ClaimSession moves a ready session to s.Status, writing its status message,
+// activation and expiry times, and reports whether it did. Only one of several
+// concurrent claims for the same session succeeds. It returns
+// ErrUniqueKeyConflict like UpdateSession.
+func (r *SqliteRepository) ClaimSession(ctx context.Context, s *models.Session) (bool, error) {
+	query := `
+		UPDATE sessions
+		SET status = ?, status_message = ?, activated_at = ?, expires_at = ?
+		WHERE id = ? AND status = 'ready'
+	`
+
+	result, err := r.db.ExecContext(ctx, query, string(s.Status), nullString(s.StatusMessage), sqliteNullTimeArg(s.ActivatedAt), sqliteNullTimeArg(s.ExpiresAt), s.ID)
+	if err != nil {
+		if isSqliteSessionUniqueKeyViolation(err) {
+			return false, ErrUniqueKeyConflict
+		}
+		return false, fmt.Errorf("failed to claim session: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim session: %w", err)
+	}
+	return n == 1, nil
+}
+
+// isSqliteSessionUniqueKeyViolation reports whether err comes from the unique
+// key index. SQLite names the columns rather than the index; sessions.unique_key
+// is only unique through sessionUniqueKeyIndex.
+func isSqliteSessionUniqueKeyViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE &&
+		strings.Contains(sqliteErr.Error(), "sessions.unique_key")
+}
+
+// DeleteSession deletes a session by ID
+func (r *SqliteRepository) DeleteSession(ctx context.Context, id string) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM sessions WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("session not found: %s", id)
+	}
+
+	return nil
+}
+
+// PurgeRevokedSessions removes sessions revoked before cutoff
+func (r *SqliteRepository) PurgeRevokedSessions(ctx context.Context, cutoff time.Time) (int64, error) {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM sessions WHERE status = 'revoked' AND revoked_at < ?`, sqliteTimeArg(cutoff))
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge revoked sessions: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// sqliteSessionFilterWhere builds the WHERE clause and its args for filters
+func sqliteSessionFilterWhere(filters models.SessionFilters) (string, []interface{}) {
+	where := ` WHERE 1=1`
+	args := make([]interface{}, 0)
+
+	switch filters.Status {
+	case "":
+	case models.SessionFilterInvalidTemplate:
+		// Ready sessions whose template is gone, and those already failed for it
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(filters.KnownTemplates)), ", ")
+		where += " AND ((status = 'ready' AND template_id NOT IN (" + placeholders + ")) OR (status = 'failed' AND status_message LIKE ? || '%'))"
+		for _, name := range filters.KnownTemplates {
+			args = append(args, name)
+		}
+		args = append(args, models.StatusMsgTemplateMissing)
+	default:
+		where += " AND status = ?"
+		args = append(args, filters.Status)
+	}
+
+	if filters.TemplateID != "" {
+		where += " AND template_id = ?"
+		args = append(args, filters.TemplateID)
+	}
+
+	if filters.CreatedBy != "" {
+		where += " AND created_by = ?"
+		args = append(args, filters.CreatedBy)
+	}
+
+	if !filters.CreatedAfter.IsZero() {
+		where += " AND created_at >= ?"
+		args = append(args, sqliteTimeArg(filters.CreatedAfter))
+	}
+
+	if !filters.CreatedBefore.IsZero() {
+		where += " AND created_at < ?"
+		args = append(args, sqliteTimeArg(filters.CreatedBefore))
+	}
+
+	return where, args
+}
+
+// ListSessions returns sessions matching filters, newest first
+func (r *SqliteRepository) ListSessions(ctx context.Context, filters models.SessionFilters) ([]*models.Session, error) {
+	where, args := sqliteSessionFilterWhere(filters)
+	query := `SELECT ` + sessionColumns + ` FROM sessions` + where + ` ORDER BY created_at DESC`
+	query, args = sqliteLimitOffset(query, args, filters.Limit, filters.Offset)
+
+	sessions, err := r.querySessions(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// CountSessions counts sessions matching filters, ignoring Limit and Offset
+func (r *SqliteRepository) CountSessions(ctx context.Context, filters models.SessionFilters) (int, error) {
+	where, args := sqliteSessionFilterWhere(filters)
+
+	var count int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sessions`+where, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count sessions: %w", err)
+	}
+
+	return count, nil
+}
+
+// sqliteExpiredSessionsWhere is expiredSessionsWhere for SQLite; both
+// placeholders take the current time
+const sqliteExpiredSessionsWhere = `(status = 'active' AND expires_at < ?) OR (status = 'ready' AND join_by < ?)`
+
+// GetExpiredSessions returns up to limit sessions past their TTL or join
+// deadline, oldest deadline first
+func (r *SqliteRepository) GetExpiredSessions(ctx context.Context, limit int) ([]*models.Session, error) {
+	query := `SELECT ` + sessionColumns + `
+		FROM sessions
+		WHERE ` + sqliteExpiredSessionsWhere + `
+		ORDER BY COALESCE(expires_at, join_by) ASC
+		LIMIT ?
+	`
+
+	now := sqliteTimeArg(time.Now())
+	sessions, err := r.querySessions(ctx, query, now, now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get expired sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// CountExpiredSessions counts sessions past their TTL or join deadline not yet expired by the cleaner
+func (r *SqliteRepository) CountExpiredSessions(ctx context.Context) (int, error) {
+	var count int
+	now := sqliteTimeArg(time.Now())
+	err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sessions WHERE `+sqliteExpiredSessionsWhere, now, now).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count expired sessions: %w", err)
+	}
+	return count, nil
+}
+
+// GetPrewarmDueSessions returns up to limit ready sessions without a sandbox
+// whose prewarm time has come, skipping those past their join deadline,
+// earliest first
+func (r *SqliteRepository) GetPrewarmDueSessions(ctx context.Context, limit int) ([]*models.Session, error) {
+	query := `SELECT ` + sessionColumns + `
+		FROM sessions
+		WHERE status = 'ready' AND sandbox_id IS NULL AND prewarm_at <= ? AND (join_by IS NULL OR join_by > ?)
+		ORDER BY prewarm_at ASC
+		LIMIT ?
+	`
+
+	now := sqliteTimeArg(time.Now())
+	sessions, err := r.querySessions(ctx, query, now, now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get prewarm due sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// AttachSessionSandbox sets the sandbox of a session still ready without one.
+// It reports false when the session was activated, expired or revoked first.
+func (r *SqliteRepository) AttachSessionSandbox(ctx context.Context, id, sandboxID string) (bool, error) {
+	result, err := r.db.ExecContext(ctx, `UPDATE sessions SET sandbox_id = ? WHERE id = ? AND status = 'ready' AND sandbox_id IS NULL`, sandboxID, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to attach session sandbox: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to attach session sandbox: %w", err)
+	}
+	return n == 1, nil
+}
+
+// RevokeSession revokes a session not revoked yet and returns the sandbox it
+// had at that moment, so one attached meanwhile isn't missed. It reports
+// false when the session is gone or was revoked first.
+func (r *SqliteRepository) RevokeSession(ctx context.Context, id, revokedBy string, at time.Time) (string, bool, error) {
+	var sandboxID sql.NullString
+	err := r.db.QueryRowContext(ctx, `
+		UPDATE sessions SET status = 'revoked', revoked_at = ?, revoked_by = ?
+		WHERE id = ? AND status <> 'revoked'
+		RETURNING sandbox_id
+	`, sqliteNullTimeArg(&at), nullString(revokedBy), id).Scan(&sandboxID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to revoke session: %w", err)
+	}
+	return sandboxID.String, true, nil
+}
+
+// SetSessionSandboxStatus records the last status of a sandbox on the session
+// it belongs to, if any
+func (r *SqliteRepository) SetSessionSandboxStatus(ctx context.Context, sandboxID string, status models.SandboxStatus) error {
+	if _, err := r.db.ExecContext(ctx, `UPDATE sessions SET sandbox_status = ? WHERE sandbox_id = ?`, string(status), sandboxID); err != nil {
+		return fmt.Errorf("failed to set session sandbox status: %w", err)
+	}
+	return nil
+}
+
+// OpenSessionConnection records a terminal connection of a session, assigning its ID
+func (r *SqliteRepository) OpenSessionConnection(ctx context.Context, c *models.SessionConnection) error {
+	query := `
+		INSERT INTO session_connections (session_id, connected_at)
+		VALUES (?, ?)
+		RETURNING id
+	`
+
+	if err := r.db.QueryRowContext(ctx, query, c.SessionID, sqliteTimeArg(c.ConnectedAt)).Scan(&c.ID); err != nil {
+		return fmt.Errorf("failed to open session connection: %w", err)
+	}
+	return nil
+}
+
+// CloseSessionConnection records when a terminal connection ended. A
+// connection that is gone, with its session, is ignored.
+func (r *SqliteRepository) CloseSessionConnection(ctx context.Context, id int64, at time.Time) error {
+	if _, err := r.db.ExecContext(ctx, `UPDATE session_connections SET disconnected_at = ? WHERE id = ?`, sqliteTimeArg(at), id); err != nil {
+		return fmt.Errorf("failed to close session connection: %w", err)
+	}
+	return nil
+}
+
+// ListSessionConnections returns a session's terminal connections, oldest first
+func (r *SqliteRepository) ListSessionConnections(ctx context.Context, sessionID string) ([]*models.SessionConnection, error) {
+	query := `
+		SELECT id, session_id, connected_at, disconnected_at
+		FROM session_connections
+		WHERE session_id = ?
+		ORDER BY connected_at, id
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list session connections: %w", err)
+	}
+	defer rows.Close()
+
+	var conns []*models.SessionConnection
+	for rows.Next() {
+		var c models.SessionConnection
+		var connectedAt, disconnectedAt sqliteTime
+		if err := rows.Scan(&c.ID, &c.SessionID, &connectedAt, &disconnectedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan session connection: %w", err)
+		}
+		c.ConnectedAt = connectedAt.Time
+		c.DisconnectedAt = disconnectedAt.ptr()
+		conns = append(conns, &c)
+	}
+
+	return conns, rows.Err()
+}
+
+// RecordCallbackDelivery adds a session callback to the delivery outbox, assigning its ID
+func (r *SqliteRepository) RecordCallbackDelivery(ctx context.Context, d *models.CallbackDelivery) error {
+	query := `
+		INSERT INTO session_callback_deliveries (session_id, event, delivered, attempts, status_code, error, attempted_at, url, secret, payload, next_attempt_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`
+
+	err := r.db.QueryRowContext(ctx, query,
+		d.SessionID, string(d.Event), d.Delivered, d.Attempts, nullInt(d.StatusCode), nullString(d.Error), sqliteTimeArg(d.AttemptedAt),
+		d.URL, d.Secret, string(d.Payload), sqliteNullTimeArg(d.NextAttemptAt),
+	).Scan(&d.ID)
+	if err != nil {
+		return fmt.Errorf("failed to record callback delivery: %w", err)
+	}
+	return nil
+}
+
+// UpdateCallbackDelivery stores the outcome of a delivery attempt and when to try next
+func (r *SqliteRepository) UpdateCallbackDelivery(ctx context.Context, d *models.CallbackDelivery) error {
+	query := `
+		UPDATE session_callback_deliveries
+		SET delivered = ?, attempts = ?, status_code = ?, error = ?, attempted_at = ?, next_attempt_at = ?
+		WHERE id = ?
+	`
+
+	_, err := r.db.ExecContext(ctx, query, d.Delivered, d.Attempts, nullInt(d.StatusCode), nullString(d.Error), sqliteTimeArg(d.AttemptedAt), sqliteNullTimeArg(d.NextAttemptAt), d.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update callback delivery: %w", err)
+	}
+	return nil
+}
+
+// ClaimCallbackDeliveries returns up to limit of the pending deliveries due at
+// now, the longest due first, and pushes their next attempt back by lease. The single
+// connection makes the claim atomic.
+func (r *SqliteRepository) ClaimCallbackDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*models.CallbackDelivery, error) {
+	query := `
+		UPDATE session_callback_deliveries
+		SET next_attempt_at = ?
+		WHERE id IN (
+			SELECT id FROM session_callback_deliveries
+			WHERE next_attempt_at <= ?
+			ORDER BY next_attempt_at
+			LIMIT ?
+		)
+		RETURNING id, session_id, event, delivered, attempts, status_code, error, attempted_at, url, secret, payload, next_attempt_at
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, sqliteTimeArg(now.Add(lease)), sqliteTimeArg(now), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim callback deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []*models.CallbackDelivery
+	for rows.Next() {
+		var d models.CallbackDelivery
+		var event, payload string
+		var statusCode sql.NullInt64
+		var errMsg sql.NullString
+		var attemptedAt, nextAttemptAt sqliteTime
+		if err := rows.Scan(&d.ID, &d.SessionID, &event, &d.Delivered, &d.Attempts, &statusCode, &errMsg, &attemptedAt, &d.URL, &d.Secret, &payload, &nextAttemptAt); err != nil {
+			return nil, fmt.Errorf("failed to scan callback delivery: %w", err)
+		}
+		d.Event = models.CallbackEvent(event)
+		d.StatusCode = int(statusCode.Int64)
+		d.Error = errMsg.String
+		d.AttemptedAt = attemptedAt.Time
+		d.Payload = []byte(payload)
+		d.NextAttemptAt = nextAttemptAt.ptr()
+		deliveries = append(deliveries, &d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// PurgeCallbackDeliveries removes the finished deliveries of deleted sessions.
+// Deliveries don't cascade with their session, so the callback of an expiry
+// is still delivered after the session is gone.
+func (r *SqliteRepository) PurgeCallbackDeliveries(ctx context.Context) (int64, error) {
+	query := `
+		DELETE FROM session_callback_deliveries
+		WHERE next_attempt_at IS NULL
+		  AND NOT EXISTS (SELECT 1 FROM sessions s WHERE s.id = session_callback_deliveries.session_id)
+	`
+
+	result, err := r.db.ExecContext(ctx, query)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge callback deliveries: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// ListCallbackDeliveries returns the callback deliveries of a session, oldest first
+func (r *SqliteRepository) ListCallbackDeliveries(ctx context.Context, sessionID string) ([]*models.CallbackDelivery, error) {
+	query := `
+		SELECT id, session_id, event, delivered, attempts, status_code, error, attempted_at, next_attempt_at
+		FROM session_callback_deliveries
+		WHERE session_id = ?
+		ORDER BY id
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list callback deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []*models.CallbackDelivery
+	for rows.Next() {
+		var d models.CallbackDelivery
+		var event string
+		var statusCode sql.NullInt64
+		var errMsg sql.NullString
+		var attemptedAt, nextAttemptAt sqliteTime
+		if err := rows.Scan(&d.ID, &d.SessionID, &event, &d.Delivered, &d.Attempts, &statusCode, &errMsg, &attemptedAt, &nextAttemptAt); err != nil {
+			return nil, fmt.Errorf("failed to scan callback delivery: %w", err)
+		}
+		d.Event = models.CallbackEvent(event)
+		d.StatusCode = int(statusCode.Int64)
+		d.Error = errMsg.String
+		d.AttemptedAt = attemptedAt.Time
+		d.NextAttemptAt = nextAttemptAt.ptr()
+		deliveries = append(deliveries, &d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// --- Terminal recordings ---
+
+// CreateTerminalRecording stores a started recording, assigning its ID
+func (r *SqliteRepository) CreateTerminalRecording(ctx context.Context, rec *models.TerminalRecording) error {
+	query := `
+		INSERT INTO terminal_recordings (sandbox_id, session_id, owner_client_id, started_at, input)
+		VALUES (?, ?, ?, ?, ?)
+		RETURNING id
+	`
+
+	err := r.db.QueryRowContext(ctx, query, rec.SandboxID, nullString(rec.SessionID), nullInt(rec.OwnerClientID), sqliteTimeArg(rec.StartedAt), rec.Input).Scan(&rec.ID)
+	if err != nil {
+		return fmt.Errorf("failed to create terminal recording: %w", err)
+	}
+	return nil
+}
+
+// FinishTerminalRecording stores the end, size and truncation of a recording
+func (r *SqliteRepository) FinishTerminalRecording(ctx context.Context, rec *models.TerminalRecording) error {
+	query := `
+		UPDATE terminal_recordings
+		SET ended_at = ?, size_bytes = ?, truncated = ?
+		WHERE id = ?
+	`
+
+	if _, err := r.db.ExecContext(ctx, query, sqliteNullTimeArg(rec.EndedAt), rec.SizeBytes, rec.Truncated, rec.ID); err != nil {
+		return fmt.Errorf("failed to finish terminal recording: %w", err)
+	}
+	return nil
+}
+
+// ListTerminalRecordings returns the recordings of a sandbox, oldest first
+func (r *SqliteRepository) ListTerminalRecordings(ctx context.Context, sandboxID string) ([]*models.TerminalRecording, error) {
+	query := `SELECT ` + recordingColumns + ` FROM terminal_recordings WHERE sandbox_id = ? ORDER BY id`
+
+	rows, err := r.db.QueryContext(ctx, query, sandboxID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list terminal recordings: %w", err)
+	}
+	defer rows.Close()
+
+	var recs []*models.TerminalRecording
+	for rows.Next() {
+		rec, err := scanSqliteRecording(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan terminal recording: %w", err)
+		}
+		recs = append(recs, rec)
+	}
+
+	return recs, rows.Err()
+}
+
+// GetTerminalRecording retrieves a recording by ID
+func (r *SqliteRepository) GetTerminalRecording(ctx context.Context, id int64) (*models.TerminalRecording, error) {
+	query := `SELECT ` + recordingColumns + ` FROM terminal_recordings WHERE id = ?`
+
+	rec, err := scanSqliteRecording(r.db.QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get terminal recording: %w", err)
+	}
+	return rec, nil
+}
+
+// PurgeTerminalRecordings deletes the recordings that ended before cutoff, and
+// those never finished that started before it, returning them so their files
+// can be removed
+func (r *SqliteRepository) PurgeTerminalRecordings(ctx context.Context, cutoff time.Time) ([]*models.TerminalRecording, error) {
+	query := `DELETE FROM terminal_recordings WHERE COALESCE(ended_at, started_at) < ? RETURNING ` + recordingColumns
+
+	rows, err := r.db.QueryContext(ctx, query, sqliteTimeArg(cutoff))
+	if err != nil {
+		return nil, fmt.Errorf("failed to purge terminal recordings: %w", err)
+	}
+	defer rows.Close()
+
+	var recs []*models.TerminalRecording
+	for rows.Next() {
+		rec, err := scanSqliteRecording(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan terminal recording: %w", err)
+		}
+		recs = append(recs, rec)
+	}
+
+	return recs, rows.Err()
+}
+
+// scanSqliteRecording scans a row selected with recordingColumns
+func scanSqliteRecording(row sqliteRow) (*models.TerminalRecording, error) {
+	var rec models.TerminalRecording
+	var sessionID sql.NullString
+	var ownerClientID sql.NullInt64
+	var startedAt, endedAt sqliteTime
+	if err := row.Scan(&rec.ID, &rec.SandboxID, &sessionID, &ownerClientID, &startedAt, &endedAt, &rec.SizeBytes, &rec.Truncated, &rec.Input); err != nil {
+		return nil, err
+	}
+	rec.SessionID = sessionID.String
+	rec.OwnerClientID = int(ownerClientID.Int64)
+	rec.StartedAt = startedAt.Time
+	rec.EndedAt = endedAt.ptr()
+	return &rec, nil
+}
+
+// --- Grading results ---
+
+// CreateGradingResult stores a grading run, assigning its ID and creation time
+func (r *SqliteRepository) CreateGradingResult(ctx context.Context, res *models.GradingResult) error {
+	var junitJSON sql.NullString
+	if res.JUnit != nil {
+		data, err := json.Marshal(res.JUnit)
+		if err != nil {
+			return fmt.Errorf("failed to marshal junit report: %w", err)
+		}
+		junitJSON = sql.NullString{String: string(data), Valid: true}
+	}
+
+	query := `
+		INSERT INTO grading_results (sandbox_id, session_id, task_id, owner_client_id, passed, exit_code, stdout, stderr, truncated, duration_ms, junit, error, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`
+
+	createdAt := time.Now().UTC()
+	err := r.db.QueryRowContext(ctx, query, res.SandboxID, nullString(res.SessionID), res.TaskID, nullInt(res.OwnerClientID),
+		res.Passed, res.ExitCode, res.Stdout, res.Stderr, res.Truncated, res.DurationMs, junitJSON, res.Error, sqliteTimeArg(createdAt),
+	).Scan(&res.ID)
+	if err != nil {
+		return fmt.Errorf("failed to create grading result: %w", err)
+	}
+	res.CreatedAt = createdAt
+	return nil
+}
+
+// ListGradingResults returns the grading runs of a sandbox, oldest first
+func (r *SqliteRepository) ListGradingResults(ctx context.Context, sandboxID string) ([]*models.GradingResult, error) {
+	query := `SELECT ` + gradingColumns + ` FROM grading_results WHERE sandbox_id = ? ORDER BY id`
+
+	rows, err := r.db.QueryContext(ctx, query, sandboxID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list grading results: %w", err)
+	}
+	defer rows.Close()
+
+	var results []*models.GradingResult
+	for rows.Next() {
+		var res models.GradingResult
+		var sessionID, junitJSON sql.NullString
+		var ownerClientID sql.NullInt64
+		var createdAt sqliteTime
+		if err := rows.Scan(&res.ID, &res.SandboxID, &sessionID, &res.TaskID, &ownerClientID, &res.Passed, &res.ExitCode,
+			&res.Stdout, &res.Stderr, &res.Truncated, &res.DurationMs, &junitJSON, &res.Error, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan grading result: %w", err)
+		}
+		res.SessionID = sessionID.String
+		res.OwnerClientID = int(ownerClientID.Int64)
+		res.CreatedAt = createdAt.Time
+		if junitJSON.Valid {
+			if err := json.Unmarshal([]byte(junitJSON.String), &res.JUnit); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal junit report: %w", err)
+			}
+		}
+		results = append(results, &res)
+	}
+
+	return results, rows.Err()
+}
+
+// --- Template documents ---
+
+// ListTemplateDocuments returns the templates created through the API, by name
+func (r *SqliteRepository) ListTemplateDocuments(ctx context.Context) ([]*models.TemplateDocument, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT name, document, created_at, updated_at FROM templates ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list templates: %w", err)
+	}
+	defer rows.Close()
+
+	var docs []*models.TemplateDocument
+	for rows.Next() {
+		doc, err := scanSqliteTemplateDocument(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan template: %w", err)
+		}
+		docs = append(docs, doc)
+	}
+
+	return docs, rows.Err()
+}
+
+// GetTemplateDocument retrieves a template created through the API by name
+func (r *SqliteRepository) GetTemplateDocument(ctx context.Context, name string) (*models.TemplateDocument, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT name, document, created_at, updated_at FROM templates WHERE name = ?`, name)
+	doc, err := scanSqliteTemplateDocument(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get template: %w", err)
+	}
+	return doc, nil
+}
+
+// SaveTemplateDocument creates or replaces a template document, setting its
+// creation and update times
+func (r *SqliteRepository) SaveTemplateDocument(ctx context.Context, doc *models.TemplateDocument) error {
+	query := `
+		INSERT INTO templates (name, document, created_at, updated_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET document = excluded.document, updated_at = excluded.updated_at
+		RETURNING created_at, updated_at
+	`
+
+	now := sqliteTimeArg(time.Now())
+	var createdAt, updatedAt sqliteTime
+	if err := r.db.QueryRowContext(ctx, query, doc.Name, doc.Document, now, now).Scan(&createdAt, &updatedAt); err != nil {
+		return fmt.Errorf("failed to save template: %w", err)
+	}
+	doc.CreatedAt, doc.UpdatedAt = createdAt.Time, updatedAt.Time
+	return nil
+}
+
+// CreateTemplateDocument inserts a template document unless its name is taken
+func (r *SqliteRepository) CreateTemplateDocument(ctx context.Context, doc *models.TemplateDocument) (bool, error) {
+	query := `
+		INSERT INTO templates (name, document, created_at, updated_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (name) DO NOTHING
+		RETURNING created_at, updated_at
+	`
+
+	now := sqliteTimeArg(time.Now())
+	var createdAt, updatedAt sqliteTime
+	err := r.db.QueryRowContext(ctx, query, doc.Name, doc.Document, now, now).Scan(&createdAt, &updatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to create template: %w", err)
+	}
+	doc.CreatedAt, doc.UpdatedAt = createdAt.Time, updatedAt.Time
+	return true, nil
+}
+
+// LockTemplateDocument reports whether a template document exists; SQLite
+// transactions are serialized, so nothing needs locking
+func (r *SqliteRepository) LockTemplateDocument(ctx context.Context, name string, forUpdate bool) (bool, error) {
+	var found int
+	err := r.db.QueryRowContext(ctx, `SELECT 1 FROM templates WHERE name = ?`, name).Scan(&found)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to lock template: %w", err)
+	}
+	return true, nil
+}
+
+// DeleteTemplateDocument deletes a template document
+func (r *SqliteRepository) DeleteTemplateDocument(ctx context.Context, name string) error {
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM templates WHERE name = ?`, name); err != nil {
+		return fmt.Errorf("failed to delete template: %w", err)
+	}
+	return nil
+}
+
+func scanSqliteTemplateDocument(row sqliteRow) (*models.TemplateDocument, error) {
+	var doc models.TemplateDocument
+	var createdAt, updatedAt sqliteTime
+	if err := row.Scan(&doc.Name, &doc.Document, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+	doc.CreatedAt, doc.UpdatedAt = createdAt.Time, updatedAt.Time
+	return &doc, nil
+}
+
+// --- Template usage ---
+
+// RecordTemplateUsage adds a sandbox's usage to the daily counters of its
+// template and task. Each sandbox is counted at most once: the claim on
+// sandboxes.usage_recorded and the upsert share a transaction, so repeating
+// the call is a no-op. Returns whether the usage was counted.
+func (r *SqliteRepository) RecordTemplateUsage(ctx context.Context, rec *models.UsageRecord) (bool, error) {
+	var successes, failures int64
+	if rec.Succeeded {
+		successes = 1
+	}
+	if rec.Failed {
+		failures = 1
+	}
+
+	counted := false
+	err := r.withTx(ctx, func(tx *SqliteRepository) error {
+		result, err := tx.db.ExecContext(ctx, `UPDATE sandboxes SET usage_recorded = 1 WHERE id = ? AND NOT usage_recorded`, rec.SandboxID)
+		if err != nil {
+			return err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return nil
+		}
+
+		query := `
+			INSERT INTO template_usage_daily (template_id, task_id, day, creations, successes, failures, session_seconds, last_used_at)
+			VALUES (?, ?, ?, 1, ?, ?, ?, ?)
+			ON CONFLICT (template_id, task_id, day) DO UPDATE SET
+				creations = creations + excluded.creations,
+				successes = successes + excluded.successes,
+				failures = failures + excluded.failures,
+				session_seconds = session_seconds + excluded.session_seconds,
+				last_used_at = max(last_used_at, excluded.last_used_at)
+		`
+		if _, err := tx.db.ExecContext(ctx, query,
+			rec.TemplateID, rec.TaskID, usageDay(rec.UsedAt),
+			successes, failures, rec.SessionSeconds, sqliteTimeArg(rec.UsedAt),
+		); err != nil {
+			return err
+		}
+
+		counted = true
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to record template usage: %w", err)
+	}
+
+	return counted, nil
+}
+
+// GetTemplateUsage sums the daily counters per template and task for days in [from, to]
+func (r *SqliteRepository) GetTemplateUsage(ctx context.Context, from, to time.Time) ([]*models.TemplateUsage, error) {
+	query := `
+		SELECT template_id, task_id, SUM(creations), SUM(successes), SUM(failures), SUM(session_seconds), MAX(last_used_at)
+		FROM template_usage_daily
+		WHERE day >= ? AND day <= ?
+		GROUP BY template_id, task_id
+		ORDER BY template_id, task_id
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, usageDay(from), usageDay(to))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get template usage: %w", err)
+	}
+	defer rows.Close()
+
+	var usage []*models.TemplateUsage
+	for rows.Next() {
+		var u models.TemplateUsage
+		var lastUsed sqliteTime
+		if err := rows.Scan(&u.TemplateID, &u.TaskID, &u.Creations, &u.Successes, &u.Failures, &u.SessionSeconds, &lastUsed); err != nil {
+			return nil, fmt.Errorf("failed to scan template usage: %w", err)
+		}
+		u.LastUsedAt = lastUsed.ptr()
+		usage = append(usage, &u)
+	}
+
+	return usage, rows.Err()
+}
+
+// --- Sandbox events ---
+
+// AppendEvent records a sandbox lifecycle event
+func (r *SqliteRepository) AppendEvent(ctx context.Context, ev *models.SandboxEvent) error {
+	query := `
+		INSERT INTO sandbox_events (sandbox_id, type, message, created_at)
+		VALUES (?, ?, ?, ?)
+		RETURNING id
+	`
+
+	if err := r.db.QueryRowContext(ctx, query, ev.SandboxID, ev.Type, ev.Message, sqliteTimeArg(ev.CreatedAt)).Scan(&ev.ID); err != nil {
+		return fmt.Errorf("failed to append sandbox event: %w", err)
+	}
+	return nil
+}
+
+// ListEvents returns a sandbox's events, oldest first
+func (r *SqliteRepository) ListEvents(ctx context.Context, sandboxID string) ([]*models.SandboxEvent, error) {
+	query := `
+		SELECT id, sandbox_id, type, message, created_at
+		FROM sandbox_events
+		WHERE sandbox_id = ?
+		ORDER BY created_at, id
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, sandboxID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sandbox events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*models.SandboxEvent
+	for rows.Next() {
+		var ev models.SandboxEvent
+		var createdAt sqliteTime
+		if err := rows.Scan(&ev.ID, &ev.SandboxID, &ev.Type, &ev.Message, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan sandbox event: %w", err)
+		}
+		ev.CreatedAt = createdAt.Time
+		events = append(events, &ev)
+	}
+
+	return events, rows.Err()
+}
+
+// DeleteEventsBefore deletes all events of deleted sandboxes whose last event
+// (normally "deleted") was recorded before cutoff. Events of sandboxes that
+// aren't deleted are kept regardless of age.
+func (r *SqliteRepository) DeleteEventsBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	query := `
+		DELETE FROM sandbox_events
+		WHERE sandbox_id IN (
+			SELECT e.sandbox_id
+			FROM sandbox_events e
+			WHERE NOT EXISTS (SELECT 1 FROM sandboxes s WHERE s.id = e.sandbox_id AND s.deleted_at IS NULL)
+			GROUP BY e.sandbox_id
+			HAVING MAX(e.created_at) < ?
+		)
+	`
+
+	result, err := r.db.ExecContext(ctx, query, sqliteTimeArg(cutoff))
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete sandbox events: %w", err)
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,953 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/migrations"
+)
+
+func newTestSqlite(t *testing.T) *SqliteRepository {
+	t.Helper()
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "engine.db")
+	sqliteMigrations, err := fs.Sub(migrations.FS, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Applying the set twice must be a no-op
+	for i := 0; i < 2; i++ {
+		if err := MigrateSqlite(ctx, path, sqliteMigrations); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	repo, err := NewSqliteRepository(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	return repo
+}
+
+func TestSqliteSandboxes(t *testing.T) {
+	repo := newTestSqlite(t)
+	ctx := context.Background()
+
+	created := time.Date(2026, 3, 1, 12, 0, 0, 123456789, time.UTC)
+	sb := &models.Sandbox{
+		ID:         "sb-1",
+		TemplateID: "python",
+		UserID:     "user-1",
+		Status:     models.StatusRunning,
+		CreatedAt:  created,
+		ExpiresAt:  created.Add(time.Hour),
+		Metadata:   map[string]string{"cohort": "backend-2024", "resources.gpus": "1"},
+		Endpoints:  map[string]string{"main": "https://sb-1.example.com"},
+		Host:       "default",
+
+		OwnerClientID: 1, // the seeded terra-sandbox client
+	}
+	if err := repo.CreateSandbox(ctx, sb); err != nil {
+		t.Fatal(err)
+	}
+	svc := &models.ServiceInstance{
+		Name:        "postgres",
+		Type:        "postgres",
+		Status:      models.ServiceStatusReady,
+		Credentials: &models.ServiceCredentials{Host: "db", Port: 5432, Username: "sb", Password: "secret", Database: "sb"},
+		CreatedAt:   created,
+		Mode:        models.ServiceDedicated,
+		ContainerID: "ctr-1",
+	}
+	if err := repo.CreateService(ctx, sb.ID, svc); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := repo.GetSandbox(ctx, "sb-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.CreatedAt.Equal(created) || got.Metadata["cohort"] != "backend-2024" || got.Endpoints["main"] != sb.Endpoints["main"] {
+		t.Errorf("sandbox didn't round-trip: %+v", got)
+	}
+	if s := got.Services["postgres"]; s == nil || s.Credentials.Password != "secret" || !s.IsDedicated() || s.ContainerID != "ctr-1" {
+		t.Errorf("expected the service with its credentials, got %+v", got.Services)
+	}
+
+	if got.OwnerClientID != 1 {
+		t.Errorf("expected owner 1, got %d", got.OwnerClientID)
+	}
+	if count, _ := repo.CountSandboxes(ctx, models.ListFilters{OwnerClientID: 2}); count != 0 {
+		t.Errorf("expected no sandboxes owned by client 2, got %d", count)
+	}
+
+	if missing, err := repo.GetSandbox(ctx, "sb-missing"); missing != nil || err != nil {
+		t.Errorf("expected nil, nil for a missing sandbox, got %v, %v", missing, err)
+	}
+
+	counts, err := repo.CountGPUSandboxesByHost(ctx, "default")
+	if err != nil || counts["default"] != 1 {
+		t.Errorf("expected one GPU sandbox on default, got %v, %v", counts, err)
+	}
+
+	if err := repo.DeleteSandbox(ctx, "sb-1", true); err != nil {
+		t.Fatal(err)
+	}
+	if services, _ := repo.GetServices(ctx, "sb-1"); len(services) != 0 {
+		t.Errorf("expected services deleted with their sandbox, got %d", len(services))
+	}
+}
+
+func TestSqliteExtendSandboxFrom(t *testing.T) {
+	repo := newTestSqlite(t)
+	ctx := context.Background()
+
+	expires := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	sb := &models.Sandbox{ID: "sb-1", TemplateID: "python", UserID: "user-1", Status: models.StatusRunning, CreatedAt: expires.Add(-time.Hour), ExpiresAt: expires}
+	if err := repo.CreateSandbox(ctx, sb); err != nil {
+		t.Fatal(err)
+	}
+
+	sb.ExpiresAt = expires.Add(15 * time.Minute)
+	sb.Metadata = map[string]string{"auto_extend.count": "1"}
+	if ok, err := repo.ExtendSandboxFrom(ctx, sb, expires); err != nil || !ok {
+		t.Fatalf("expected the extension written, got %v, %v", ok, err)
+	}
+	got, _ := repo.GetSandbox(ctx, "sb-1")
+	if !got.ExpiresAt.Equal(sb.ExpiresAt) || got.Metadata["auto_extend.count"] != "1" {
+		t.Errorf("expected the new expiry and metadata, got %+v", got)
+	}
+
+	// A stale expiry or a sandbox no longer running is left alone
+	sb.ExpiresAt = expires.Add(30 * time.Minute)
+	if ok, err := repo.ExtendSandboxFrom(ctx, sb, expires); err != nil || ok {
+		t.Errorf("expected a stale expiry refused, got %v, %v", ok, err)
+	}
+	got.Status = models.StatusStopped
+	if err := repo.UpdateSandbox(ctx, got); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := repo.ExtendSandboxFrom(ctx, sb, got.ExpiresAt); err != nil || ok {
+		t.Errorf("expected a stopped sandbox refused, got %v, %v", ok, err)
+	}
+}
+
+func TestSqliteSoftDelete(t *testing.T) {
+	repo := newTestSqlite(t)
+	ctx := context.Background()
+
+	now := time.Now()
+	sb := &models.Sandbox{ID: "sb-1", TemplateID: "python", UserID: "user-1", Status: models.StatusRunning, CreatedAt: now, ExpiresAt: now.Add(-time.Minute)}
+	if err := repo.CreateSandbox(ctx, sb); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.DeleteSandbox(ctx, "sb-1", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := repo.GetSandbox(ctx, "sb-1"); got != nil || err != nil {
+		t.Errorf("expected a deleted sandbox not found, got %v, %v", got, err)
+	}
+	if err := repo.DeleteSandbox(ctx, "sb-1", false); err == nil {
+		t.Error("expected an error deleting a deleted sandbox again")
+	}
+	if count, _ := repo.CountExpiredSandboxes(ctx); count != 0 {
+		t.Errorf("expected a deleted sandbox never expired, got %d", count)
+	}
+
+	listed, err := repo.ListSandboxes(ctx, models.ListFilters{UserID: "user-1", IncludeDeleted: true})
+	if err != nil || len(listed) != 1 || listed[0].DeletedAt == nil {
+		t.Fatalf("expected the deleted sandbox listed with deleted_at, got %v, %v", listed, err)
+	}
+	if count, _ := repo.CountSandboxes(ctx, models.ListFilters{UserID: "user-1"}); count != 0 {
+		t.Errorf("expected deleted sandboxes excluded by default, got %d", count)
+	}
+
+	if n, err := repo.PurgeDeletedSandboxes(ctx, listed[0].DeletedAt.Add(-time.Second)); err != nil || n != 0 {
+		t.Errorf("expected nothing purged before the retention passed, got %d, %v", n, err)
+	}
+	if n, err := repo.PurgeDeletedSandboxes(ctx, listed[0].DeletedAt.Add(time.Second)); err != nil || n != 1 {
+		t.Errorf("expected the deleted sandbox purged, got %d, %v", n, err)
+	}
+}
+
+func TestSqliteListSandboxes(t *testing.T) {
+	repo := newTestSqlite(t)
+	ctx := context.Background()
+
+	base := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	for i, cohort := range []string{"a", "b", "a", "a"} {
+		sb := &models.Sandbox{
+			ID:         string(rune('w' + i)),
+			TemplateID: "python",
+			UserID:     "user-1",
+			Status:     models.StatusRunning,
+			CreatedAt:  base.Add(time.Duration(i) * time.Hour),
+			ExpiresAt:  base.Add(-time.Minute), // already expired
+			Metadata:   map[string]string{"cohort": cohort},
+		}
+		if err := repo.CreateSandbox(ctx, sb); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	filters := models.ListFilters{Metadata: map[string]string{"cohort": "a"}, CreatedAfter: base.Add(time.Hour), Limit: 1, Offset: 1}
+	sandboxes, err := repo.ListSandboxes(ctx, filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Cohort a created from the second hour is z, y; newest first, the second page holds y
+	if len(sandboxes) != 1 || sandboxes[0].ID != "y" {
+		t.Errorf("unexpected page %v", sandboxes)
+	}
+	if count, err := repo.CountSandboxes(ctx, filters); err != nil || count != 2 {
+		t.Errorf("expected 2 matches, got %d, %v", count, err)
+	}
+
+	// Equal user IDs fall back to newest first
+	sandboxes, err = repo.ListSandboxes(ctx, models.ListFilters{Sort: models.SortUserID, Ascending: true, Limit: 2})
+	if err != nil || len(sandboxes) != 2 || sandboxes[0].ID != "z" || sandboxes[1].ID != "y" {
+		t.Errorf("expected z, y sorted by user, got %v, %v", sandboxes, err)
+	}
+
+	// An offset without a limit is valid
+	if sandboxes, err := repo.ListSandboxes(ctx, models.ListFilters{Offset: 3}); err != nil || len(sandboxes) != 1 {
+		t.Errorf("expected the last sandbox, got %v, %v", sandboxes, err)
+	}
+
+	// Sandboxes without a host only match it as the default host
+	if sandboxes, err := repo.ListSandboxes(ctx, models.ListFilters{Host: "default"}); err != nil || len(sandboxes) != 0 {
+		t.Errorf("expected no sandboxes recorded on the host, got %v, %v", sandboxes, err)
+	}
+	if sandboxes, err := repo.ListSandboxes(ctx, models.ListFilters{Host: "default", DefaultHost: "default"}); err != nil || len(sandboxes) != 4 {
+		t.Errorf("expected the unplaced sandboxes on the default host, got %v, %v", sandboxes, err)
+	}
+
+	expired, err := repo.GetExpiredSandboxes(ctx, 3)
+	if err != nil || len(expired) != 3 {
+		t.Errorf("expected a batch of 3 expired sandboxes, got %v, %v", expired, err)
+	}
+	if count, err := repo.CountExpiredSandboxes(ctx); err != nil || count != 4 {
+		t.Errorf("expected 4 expired sandboxes, got %d, %v", count, err)
+	}
+}
+
+func TestSqliteListSessions(t *testing.T) {
+	repo := newTestSqlite(t)
+	ctx := context.Background()
+
+	base := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	for i, s := range []struct{ template, creator string }{{"python", "alice"}, {"python", "bob"}, {"go", "alice"}, {"python", "alice"}} {
+		session := &models.Session{
+			ID:         string(rune('w' + i)),
+			Token:      "tok-" + string(rune('w'+i)),
+			TemplateID: s.template,
+			Status:     models.SessionReady,
+			TTLSeconds: 3600,
+			CreatedBy:  s.creator,
+			CreatedAt:  base.Add(time.Duration(i) * time.Hour),
+		}
+		if err := repo.CreateSession(ctx, session); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	filters := models.SessionFilters{TemplateID: "python", CreatedBy: "alice", CreatedBefore: base.Add(3 * time.Hour)}
+	sessions, err := repo.ListSessions(ctx, filters)
+	if err != nil || len(sessions) != 1 || sessions[0].ID != "w" {
+		t.Errorf("expected only w, got %v, %v", sessions, err)
+	}
+
+	// Python sessions are w, x and z; newest first, the second page holds x
+	filters = models.SessionFilters{TemplateID: "python", Limit: 1, Offset: 1}
+	sessions, err = repo.ListSessions(ctx, filters)
+	if err != nil || len(sessions) != 1 || sessions[0].ID != "x" {
+		t.Errorf("unexpected page %v, %v", sessions, err)
+	}
+	if count, err := repo.CountSessions(ctx, filters); err != nil || count != 3 {
+		t.Errorf("expected 3 matches, got %d, %v", count, err)
+	}
+
+	missing := models.SessionFilters{Status: models.SessionFilterInvalidTemplate, KnownTemplates: []string{"python"}}
+	if count, err := repo.CountSessions(ctx, missing); err != nil || count != 1 {
+		t.Errorf("expected the go session with its template missing, got %d, %v", count, err)
+	}
+}
+
+func TestSqliteSessionUniqueKey(t *testing.T) {
+	repo := newTestSqlite(t)
+	ctx := context.Background()
+
+	for _, id := range []string{"s1", "s2"} {
+		s := &models.Session{
+			ID:         id,
+			Token:      "tok-" + id,
+			TemplateID: "python",
+			Status:     models.SessionReady,
+			TTLSeconds: 3600,
+			Env:        map[string]string{"LEVEL": "senior"},
+			Services:   []string{"postgres"},
+			CreatedAt:  time.Now(),
+			UniqueKey:  "candidate@example.com",
+		}
+		if err := repo.CreateSession(ctx, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s1, err := repo.GetSessionByToken(ctx, "tok-s1")
+	if err != nil || s1 == nil || s1.Env["LEVEL"] != "senior" || len(s1.Services) != 1 {
+		t.Fatalf("session didn't round-trip: %+v, %v", s1, err)
+	}
+
+	s1.Status = models.SessionActive
+	if err := repo.UpdateSession(ctx, s1); err != nil {
+		t.Fatal(err)
+	}
+
+	s2, _ := repo.GetSessionByID(ctx, "s2")
+	s2.Status = models.SessionProvisioning
+	if err := repo.UpdateSession(ctx, s2); !errors.Is(err, ErrUniqueKeyConflict) {
+		t.Errorf("expected ErrUniqueKeyConflict, got %v", err)
+	}
+
+	if live, err := repo.GetLiveSessionByUniqueKey(ctx, "candidate@example.com"); err != nil || live == nil || live.ID != "s1" {
+		t.Errorf("expected s1 live, got %+v, %v", live, err)
+	}
+}
+
+func TestSqliteClaimSession(t *testing.T) {
+	repo := newTestSqlite(t)
+	ctx := context.Background()
+
+	s := &models.Session{ID: "s1", Token: "tok-s1", TemplateID: "python", Status: models.SessionReady, TTLSeconds: 3600, CreatedAt: time.Now()}
+	if err := repo.CreateSession(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	s.Status = models.SessionProvisioning
+	s.ActivatedAt = &now
+	if claimed, err := repo.ClaimSession(ctx, s); err != nil || !claimed {
+		t.Fatalf("expected the ready session claimed, got %v, %v", claimed, err)
+	}
+	if claimed, err := repo.ClaimSession(ctx, s); err != nil || claimed {
+		t.Errorf("expected a second claim to find the session taken, got %v, %v", claimed, err)
+	}
+
+	got, _ := repo.GetSessionByID(ctx, "s1")
+	if got.Status != models.SessionProvisioning || got.ActivatedAt == nil {
+		t.Errorf("expected the claim stored, got %+v", got)
+	}
+}
+
+func TestSqliteExpiredSessions(t *testing.T) {
+	repo := newTestSqlite(t)
+	ctx := context.Background()
+
+	now := time.Now()
+	past, later := now.Add(-time.Hour), now.Add(time.Hour)
+	for _, s := range []*models.Session{
+		{ID: "active", Token: "t1", Status: models.SessionActive, ExpiresAt: &past},
+		{ID: "unjoined", Token: "t2", Status: models.SessionReady, JoinBy: &past},
+		{ID: "waiting", Token: "t3", Status: models.SessionReady, JoinBy: &later},
+		{ID: "no-deadline", Token: "t4", Status: models.SessionReady},
+	} {
+		s.TemplateID, s.TTLSeconds, s.CreatedAt = "python", 3600, now
+		if err := repo.CreateSession(ctx, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expired, err := repo.GetExpiredSessions(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 2 {
+		t.Fatalf("expected the active and the unjoined session, got %d", len(expired))
+	}
+	if n, err := repo.CountExpiredSessions(ctx); err != nil || n != 2 {
+		t.Errorf("expected 2 expired sessions counted, got %d, %v", n, err)
+	}
+	if got, _ := repo.GetSessionByID(ctx, "unjoined"); got.JoinBy == nil || !got.JoinBy.Equal(past) {
+		t.Errorf("join_by didn't round-trip: %+v", got)
+	}
+}
+
+func TestSqliteRevokedSessions(t *testing.T) {
+	repo := newTestSqlite(t)
+	ctx := context.Background()
+
+	revokedAt := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, id := range []string{"old", "recent", "ready"} {
+		s := &models.Session{ID: id, Token: "tok-" + id, TemplateID: "python", Status: models.SessionReady, TTLSeconds: 3600, CreatedAt: revokedAt}
+		if err := repo.CreateSession(ctx, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for id, at := range map[string]time.Time{"old": revokedAt, "recent": revokedAt.Add(48 * time.Hour)} {
+		s, _ := repo.GetSessionByID(ctx, id)
+		s.Status = models.SessionRevoked
+		s.RevokedAt = &at
+		s.RevokedBy = "admin"
+		if err := repo.UpdateSession(ctx, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := repo.GetSessionByID(ctx, "old")
+	if err != nil || got == nil || got.RevokedBy != "admin" || got.RevokedAt == nil || !got.RevokedAt.Equal(revokedAt) {
+		t.Fatalf("revocation didn't round-trip: %+v, %v", got, err)
+	}
+
+	n, err := repo.PurgeRevokedSessions(ctx, revokedAt.Add(24*time.Hour))
+	if err != nil || n != 1 {
+		t.Fatalf("expected one session purged, got %d, %v", n, err)
+	}
+	if s, _ := repo.GetSessionByID(ctx, "old"); s != nil {
+		t.Error("expected the old revoked session purged")
+	}
+	for _, id := range []string{"recent", "ready"} {
+		if s, _ := repo.GetSessionByID(ctx, id); s == nil {
+			t.Errorf("expected session %s kept", id)
+		}
+	}
+}
+
+func TestSqliteSubmittedSession(t *testing.T) {
+	repo := newTestSqlite(t)
+	ctx := context.Background()
+
+	s := &models.Session{ID: "s-1", Token: "tok", TemplateID: "python", Status: models.SessionActive, TTLSeconds: 3600, CreatedAt: time.Now()}
+	if err := repo.CreateSession(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	submittedAt := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	s.Status = models.SessionSubmitted
+	s.SubmittedAt = &submittedAt
+	if err := repo.UpdateSession(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := repo.GetSessionByToken(ctx, "tok")
+	if err != nil || got == nil || got.Status != models.SessionSubmitted || got.SubmittedAt == nil || !got.SubmittedAt.Equal(submittedAt) {
+		t.Fatalf("submission didn't round-trip: %+v, %v", got, err)
+	}
+}
+
+func TestSqliteSessionConnections(t *testing.T) {
+	repo := newTestSqlite(t)
+	ctx := context.Background()
+
+	s := &models.Session{ID: "s-1", Token: "tok", TemplateID: "python", Status: models.SessionActive, TTLSeconds: 3600, SandboxID: "sb-1", CreatedAt: time.Now()}
+	if err := repo.CreateSession(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	s.ExtendedSeconds = 600
+	if err := repo.UpdateSession(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SetSessionSandboxStatus(ctx, "sb-1", models.StatusRunning); err != nil {
+		t.Fatal(err)
+	}
+	got, err := repo.GetSessionByID(ctx, "s-1")
+	if err != nil || got == nil || got.ExtendedSeconds != 600 || got.SandboxStatus != models.StatusRunning {
+		t.Fatalf("activity fields didn't round-trip: %+v, %v", got, err)
+	}
+
+	connectedAt := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	first := &models.SessionConnection{SessionID: "s-1", ConnectedAt: connectedAt}
+	second := &models.SessionConnection{SessionID: "s-1", ConnectedAt: connectedAt.Add(time.Hour)}
+	for _, c := range []*models.SessionConnection{second, first} {
+		if err := repo.OpenSessionConnection(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repo.CloseSessionConnection(ctx, first.ID, connectedAt.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	conns, err := repo.ListSessionConnections(ctx, "s-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conns) != 2 || conns[0].ID != first.ID || conns[0].DisconnectedAt == nil || !conns[0].DisconnectedAt.Equal(connectedAt.Add(time.Minute)) || conns[1].DisconnectedAt != nil {
+		t.Fatalf("expected both connections oldest first, the first closed, got %+v", conns)
+	}
+
+	// Connections go with their session
+	if err := repo.DeleteSession(ctx, "s-1"); err != nil {
+		t.Fatal(err)
+	}
+	if conns, err := repo.ListSessionConnections(ctx, "s-1"); err != nil || len(conns) != 0 {
+		t.Errorf("expected no connections after deleting the session, got %v, %v", conns, err)
+	}
+}
+
+func TestSqlitePrewarmSessions(t *testing.T) {
+	repo := newTestSqlite(t)
+	ctx := context.Background()
+
+	now := time.Now()
+	at := func(d time.Duration) *time.Time {
+		ts := now.Add(d)
+		return &ts
+	}
+	for _, s := range []*models.Session{
+		{ID: "due", Token: "t1", PrewarmAt: at(-time.Minute), JoinBy: at(time.Hour)},
+		{ID: "earlier", Token: "t2", PrewarmAt: at(-time.Hour)},
+		{ID: "later", Token: "t3", PrewarmAt: at(time.Hour)},
+		{ID: "no-prewarm", Token: "t4"},
+		{ID: "past-deadline", Token: "t5", PrewarmAt: at(-time.Hour), JoinBy: at(-time.Minute)},
+	} {
+		s.TemplateID, s.Status, s.TTLSeconds, s.CreatedAt = "python", models.SessionReady, 3600, now
+		if err := repo.CreateSession(ctx, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	due, err := repo.GetPrewarmDueSessions(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 2 || due[0].ID != "earlier" || due[1].ID != "due" || due[1].PrewarmAt == nil {
+		t.Fatalf("expected the due sessions earliest first, got %+v", due)
+	}
+
+	if ok, err := repo.AttachSessionSandbox(ctx, "due", "sb-1"); err != nil || !ok {
+		t.Fatalf("expected the sandbox attached, got %v, %v", ok, err)
+	}
+	if ok, _ := repo.AttachSessionSandbox(ctx, "due", "sb-2"); ok {
+		t.Error("expected a session with a sandbox not to take another")
+	}
+	if got, _ := repo.GetSessionByID(ctx, "due"); got == nil || got.SandboxID != "sb-1" || got.Status != models.SessionReady {
+		t.Errorf("expected the session ready with its prewarmed sandbox, got %+v", got)
+	}
+	if due, _ := repo.GetPrewarmDueSessions(ctx, 10); len(due) != 1 || due[0].ID != "earlier" {
+		t.Errorf("expected a prewarmed session no longer due, got %+v", due)
+	}
+
+	if _, err := repo.ClaimSession(ctx, &models.Session{ID: "earlier", Status: models.SessionProvisioning}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := repo.AttachSessionSandbox(ctx, "earlier", "sb-3"); ok {
+		t.Error("expected an activated session not to take a prewarmed sandbox")
+	}
+
+	// A transition only applies from the status it expects
+	stale := &models.Session{ID: "due", TemplateID: "python", Status: models.SessionFailed, SandboxID: "sb-1"}
+	if ok, err := repo.UpdateSessionFrom(ctx, stale, models.SessionProvisioning); err != nil || ok {
+		t.Errorf("expected no update from another status, got %v, %v", ok, err)
+	}
+
+	// Revoking returns the sandbox attached at that moment, once
+	if sandboxID, ok, err := repo.RevokeSession(ctx, "due", "admin", now); err != nil || !ok || sandboxID != "sb-1" {
+		t.Fatalf("expected the session revoked with its sandbox, got %q, %v, %v", sandboxID, ok, err)
+	}
+	if got, _ := repo.GetSessionByID(ctx, "due"); got == nil || got.Status != models.SessionRevoked || got.RevokedBy != "admin" || got.RevokedAt == nil {
+		t.Errorf("expected the session revoked, got %+v", got)
+	}
+	if _, ok, _ := repo.RevokeSession(ctx, "due", "admin", now); ok {
+		t.Error("expected a revoked session not to be revoked again")
+	}
+	if ok, _ := repo.AttachSessionSandbox(ctx, "due", "sb-4"); ok {
+		t.Error("expected a revoked session not to take a prewarmed sandbox")
+	}
+	if sandboxID, ok, err := repo.RevokeSession(ctx, "earlier", "", now); err != nil || !ok || sandboxID != "" {
+		t.Errorf("expected a session without a sandbox revoked, got %q, %v, %v", sandboxID, ok, err)
+	}
+}
+
+func TestSqliteCallbackDeliveries(t *testing.T) {
+	repo := newTestSqlite(t)
+	ctx := context.Background()
+
+	s := &models.Session{ID: "s-1", Token: "tok", TemplateID: "python", Status: models.SessionReady, TTLSeconds: 3600, CreatedAt: time.Now(), CallbackURL: "https://ats.example.com/hook", CallbackSecret: "secret"}
+	if err := repo.CreateSession(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := repo.GetSessionByID(ctx, "s-1"); got == nil || got.CallbackURL != s.CallbackURL || got.CallbackSecret != "secret" {
+		t.Fatalf("callback fields didn't round-trip: %+v", got)
+	}
+
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, d := range []*models.CallbackDelivery{
+		{SessionID: "s-1", Event: models.CallbackActivated, Delivered: true, Attempts: 1, StatusCode: 200, AttemptedAt: at},
+		{SessionID: "s-1", Event: models.CallbackExpired, Attempts: 4, Error: "connection refused", AttemptedAt: at.Add(time.Hour)},
+	} {
+		if err := repo.RecordCallbackDelivery(ctx, d); err != nil || d.ID == 0 {
+			t.Fatalf("expected the delivery recorded with an ID, got %d, %v", d.ID, err)
+		}
+	}
+
+	deliveries, err := repo.ListCallbackDeliveries(ctx, "s-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 2 || !deliveries[0].Delivered || deliveries[0].StatusCode != 200 || deliveries[1].Error != "connection refused" || !deliveries[1].AttemptedAt.Equal(at.Add(time.Hour)) {
+		t.Fatalf("expected both deliveries in order, got %+v %+v", deliveries[0], deliveries[1])
+	}
+
+	// A pending delivery is claimed once it is due, and leased
+	due := at.Add(2 * time.Hour)
+	pending := &models.CallbackDelivery{SessionID: "s-1", Event: models.CallbackExpired, AttemptedAt: at, NextAttemptAt: &due, URL: s.CallbackURL, Secret: "secret", Payload: []byte(`{"event":"expired"}`)}
+	if err := repo.RecordCallbackDelivery(ctx, pending); err != nil {
+		t.Fatal(err)
+	}
+	if claimed, err := repo.ClaimCallbackDeliveries(ctx, due.Add(-time.Second), time.Minute, 10); err != nil || len(claimed) != 0 {
+		t.Fatalf("expected nothing due yet, got %v, %v", claimed, err)
+	}
+	claimed, err := repo.ClaimCallbackDeliveries(ctx, due, time.Minute, 10)
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("expected the pending delivery claimed, got %v, %v", claimed, err)
+	}
+	if d := claimed[0]; d.ID != pending.ID || d.URL != s.CallbackURL || d.Secret != "secret" || string(d.Payload) != `{"event":"expired"}` || d.NextAttemptAt == nil || !d.NextAttemptAt.Equal(due.Add(time.Minute)) {
+		t.Fatalf("unexpected claimed delivery %+v", d)
+	}
+	if again, _ := repo.ClaimCallbackDeliveries(ctx, due, time.Minute, 10); len(again) != 0 {
+		t.Fatalf("expected a leased delivery not claimed again, got %v", again)
+	}
+
+	// Deliveries outlive their session until they are finished and purged
+	if err := repo.DeleteSession(ctx, "s-1"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := repo.PurgeCallbackDeliveries(ctx); err != nil || n != 2 {
+		t.Fatalf("expected the finished deliveries purged, got %d, %v", n, err)
+	}
+
+	d := claimed[0]
+	d.Delivered, d.Attempts, d.StatusCode, d.AttemptedAt, d.NextAttemptAt = true, 1, 200, due, nil
+	if err := repo.UpdateCallbackDelivery(ctx, d); err != nil {
+		t.Fatal(err)
+	}
+	deliveries, err = repo.ListCallbackDeliveries(ctx, "s-1")
+	if err != nil || len(deliveries) != 1 || !deliveries[0].Delivered || deliveries[0].NextAttemptAt != nil {
+		t.Fatalf("expected the delivered callback kept after its session, got %v, %v", deliveries, err)
+	}
+	if n, err := repo.PurgeCallbackDeliveries(ctx); err != nil || n != 1 {
+		t.Errorf("expected the delivered callback purged, got %d, %v", n, err)
+	}
+}
+
+func TestSqliteTerminalRecordings(t *testing.T) {
+	repo := newTestSqlite(t)
+	ctx := context.Background()
+
+	s := &models.Session{ID: "s-1", Token: "tok", TemplateID: "python", Status: models.SessionReady, TTLSeconds: 3600, CreatedAt: time.Now(), RecordTerminal: true, OwnerClientID: 1}
+	if err := repo.CreateSession(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := repo.GetSessionByID(ctx, "s-1"); got == nil || !got.RecordTerminal || got.OwnerClientID != 1 {
+		t.Fatalf("record_terminal and owner didn't round-trip: %+v", got)
+	}
+
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	rec := &models.TerminalRecording{SandboxID: "sb-1", SessionID: "s-1", OwnerClientID: 3, StartedAt: at, Input: true}
+	if err := repo.CreateTerminalRecording(ctx, rec); err != nil || rec.ID == 0 {
+		t.Fatalf("expected the recording created with an ID, got %d, %v", rec.ID, err)
+	}
+	endedAt := at.Add(time.Minute)
+	rec.EndedAt, rec.SizeBytes, rec.Truncated = &endedAt, 2048, true
+	if err := repo.FinishTerminalRecording(ctx, rec); err != nil {
+		t.Fatal(err)
+	}
+
+	// Recordings outlive their session and sandbox
+	if err := repo.DeleteSession(ctx, "s-1"); err != nil {
+		t.Fatal(err)
+	}
+	recs, err := repo.ListTerminalRecordings(ctx, "sb-1")
+	if err != nil || len(recs) != 1 {
+		t.Fatalf("expected the recording listed, got %v, %v", recs, err)
+	}
+	got := recs[0]
+	if got.SessionID != "s-1" || got.OwnerClientID != 3 || !got.StartedAt.Equal(at) || got.EndedAt == nil || !got.EndedAt.Equal(endedAt) || got.SizeBytes != 2048 || !got.Truncated || !got.Input {
+		t.Errorf("recording didn't round-trip: %+v", got)
+	}
+	if got, err := repo.GetTerminalRecording(ctx, rec.ID+1); err != nil || got != nil {
+		t.Errorf("expected no recording, got %+v, %v", got, err)
+	}
+
+	// Purged once it ended before the cutoff; an unfinished one by its start
+	open := &models.TerminalRecording{SandboxID: "sb-1", StartedAt: at.Add(2 * time.Minute)}
+	if err := repo.CreateTerminalRecording(ctx, open); err != nil {
+		t.Fatal(err)
+	}
+	if purged, err := repo.PurgeTerminalRecordings(ctx, endedAt); err != nil || len(purged) != 0 {
+		t.Fatalf("expected nothing purged yet, got %v, %v", purged, err)
+	}
+	purged, err := repo.PurgeTerminalRecordings(ctx, at.Add(time.Hour))
+	if err != nil || len(purged) != 2 {
+		t.Fatalf("expected both recordings purged, got %v, %v", purged, err)
+	}
+	if recs, _ := repo.ListTerminalRecordings(ctx, "sb-1"); len(recs) != 0 {
+		t.Errorf("expected no recordings left, got %v", recs)
+	}
+}
+
+func TestSqliteGradingResults(t *testing.T) {
+	repo := newTestSqlite(t)
+	ctx := context.Background()
+
+	junit := &models.JUnitReport{Tests: 2, Failures: 1, Cases: []models.JUnitCase{
+		{Name: "adds items", ClassName: "cart", Time: 0.5, Status: models.JUnitPassed},
+		{Name: "applies coupons", ClassName: "cart", Status: models.JUnitFailed, Message: "expected 90"},
+	}}
+	res := &models.GradingResult{SandboxID: "sb-1", SessionID: "s-1", TaskID: "shop/api/cart", OwnerClientID: 3, ExitCode: 1, Stdout: "1 failing", DurationMs: 1200, JUnit: junit}
+	if err := repo.CreateGradingResult(ctx, res); err != nil || res.ID == 0 || res.CreatedAt.IsZero() {
+		t.Fatalf("expected the result created with an ID and time, got %+v, %v", res, err)
+	}
+	timedOut := &models.GradingResult{SandboxID: "sb-1", TaskID: "shop/api/cart", ExitCode: -1, Error: "timed out"}
+	if err := repo.CreateGradingResult(ctx, timedOut); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := repo.ListGradingResults(ctx, "sb-1")
+	if err != nil || len(results) != 2 {
+		t.Fatalf("expected both results listed, got %v, %v", results, err)
+	}
+	got := results[0]
+	if got.SessionID != "s-1" || got.OwnerClientID != 3 || got.ExitCode != 1 || got.Stdout != "1 failing" || got.DurationMs != 1200 || !reflect.DeepEqual(got.JUnit, junit) {
+		t.Errorf("result didn't round-trip: %+v", got)
+	}
+	if results[1].JUnit != nil || results[1].Error != "timed out" || results[1].SessionID != "" {
+		t.Errorf("unexpected second result %+v", results[1])
+	}
+	if results, err := repo.ListGradingResults(ctx, "sb-2"); err != nil || len(results) != 0 {
+		t.Errorf("expected no results, got %v, %v", results, err)
+	}
+}
+
+func TestSqliteTemplateDocuments(t *testing.T) {
+	repo := newTestSqlite(t)
+	ctx := context.Background()
+
+	doc := &models.TemplateDocument{Name: "python", Document: "name: python\nbase_image: python:3.12\n"}
+	if err := repo.SaveTemplateDocument(ctx, doc); err != nil || doc.CreatedAt.IsZero() {
+		t.Fatalf("expected the document saved with its times, got %+v, %v", doc, err)
+	}
+	created := doc.CreatedAt
+
+	time.Sleep(10 * time.Millisecond)
+	doc.Document = "name: python\nbase_image: python:3.13\n"
+	if err := repo.SaveTemplateDocument(ctx, doc); err != nil {
+		t.Fatal(err)
+	}
+	if !doc.CreatedAt.Equal(created) || !doc.UpdatedAt.After(created) {
+		t.Errorf("expected an update to keep created_at, got %v and %v", doc.CreatedAt, doc.UpdatedAt)
+	}
+
+	docs, err := repo.ListTemplateDocuments(ctx)
+	if err != nil || len(docs) != 1 || docs[0].Document != doc.Document {
+		t.Fatalf("expected the updated document listed, got %+v, %v", docs, err)
+	}
+
+	if created, err := repo.CreateTemplateDocument(ctx, &models.TemplateDocument{Name: "python", Document: "name: python\n"}); err != nil || created {
+		t.Errorf("expected a taken name not to be created, got %v, %v", created, err)
+	}
+	if got, _ := repo.GetTemplateDocument(ctx, "python"); got.Document != doc.Document {
+		t.Errorf("expected the existing document kept, got %q", got.Document)
+	}
+	if created, err := repo.CreateTemplateDocument(ctx, &models.TemplateDocument{Name: "go", Document: "name: go\n"}); err != nil || !created {
+		t.Errorf("expected a free name created, got %v, %v", created, err)
+	}
+	if found, err := repo.LockTemplateDocument(ctx, "go", true); err != nil || !found {
+		t.Errorf("expected the document found, got %v, %v", found, err)
+	}
+	if found, err := repo.LockTemplateDocument(ctx, "missing", false); err != nil || found {
+		t.Errorf("expected no document, got %v, %v", found, err)
+	}
+
+	if err := repo.DeleteTemplateDocument(ctx, "python"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := repo.GetTemplateDocument(ctx, "python"); err != nil || got != nil {
+		t.Errorf("expected the document deleted, got %+v, %v", got, err)
+	}
+}
+
+func TestSqliteTemplateUsageCountedOnce(t *testing.T) {
+	repo := newTestSqlite(t)
+	ctx := context.Background()
+
+	used := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	if err := repo.CreateSandbox(ctx, &models.Sandbox{ID: "sb-1", TemplateID: "python", UserID: "u", Status: models.StatusStopped, CreatedAt: used, ExpiresAt: used}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := &models.UsageRecord{SandboxID: "sb-1", TemplateID: "python", UsedAt: used, Succeeded: true, SessionSeconds: 600}
+	for i, want := range []bool{true, false} {
+		counted, err := repo.RecordTemplateUsage(ctx, rec)
+		if err != nil || counted != want {
+			t.Errorf("call %d: expected counted=%v, got %v, %v", i, want, counted, err)
+		}
+	}
+
+	usage, err := repo.GetTemplateUsage(ctx, used, used)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usage) != 1 || usage[0].Creations != 1 || usage[0].SessionSeconds != 600 || !usage[0].LastUsedAt.Equal(used) {
+		t.Errorf("unexpected usage %+v", usage)
+	}
+}
+
+func TestSqliteWithTxRollsBack(t *testing.T) {
+	repo := newTestSqlite(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	err := repo.WithTx(ctx, func(tx Repository) error {
+		if err := tx.CreateSandbox(ctx, &models.Sandbox{ID: "sb-1", TemplateID: "python", UserID: "u", CreatedAt: now, ExpiresAt: now}); err != nil {
+			return err
+		}
+		if err := tx.AppendEvent(ctx, &models.SandboxEvent{SandboxID: "sb-1", Type: models.EventCreated, CreatedAt: now}); err != nil {
+			return err
+		}
+		return errors.New("provisioning failed")
+	})
+	if err == nil {
+		t.Fatal("expected the callback error")
+	}
+
+	if sb, _ := repo.GetSandbox(ctx, "sb-1"); sb != nil {
+		t.Error("expected the sandbox rolled back")
+	}
+	if events, _ := repo.ListEvents(ctx, "sb-1"); len(events) != 0 {
+		t.Errorf("expected the event rolled back, got %d", len(events))
+	}
+}
+
+func TestSqliteSeededClients(t *testing.T) {
+	repo := newTestSqlite(t)
+	ctx := context.Background()
+
+	client, err := repo.GetClientByApiKey(ctx, "sk_dev_terraadmin_xxxxxxxxxxxxx")
+	if err != nil || client == nil {
+		t.Fatalf("expected the seeded admin client, got %v, %v", client, err)
+	}
+	if !client.IsActive || client.CreatedAt.IsZero() || len(client.Permissions) != 5 {
+		t.Errorf("unexpected client %+v", client)
+	}
+
+	if err := repo.UpdateClientLastUsed(ctx, client.ApiKey); err != nil {
+		t.Fatal(err)
+	}
+	if client, _ = repo.GetClientByApiKey(ctx, client.ApiKey); client.LastUsedAt == nil {
+		t.Error("expected last_used_at set")
+	}
+}
+
+func TestSqliteClientLifecycle(t *testing.T) {
+	repo := newTestSqlite(t)
+	ctx := context.Background()
+
+	client := &models.ApiClient{Name: "grader", ApiKey: "sk_test_old", IsActive: true, Permissions: []string{"sessions:*"}}
+	if err := repo.CreateClient(ctx, client); err != nil {
+		t.Fatal(err)
+	}
+	if client.ID == 0 || client.CreatedAt.IsZero() {
+		t.Fatalf("expected the id and created_at set, got %+v", client)
+	}
+	if err := repo.UpdateClientLastUsed(ctx, client.ApiKey); err != nil {
+		t.Fatal(err)
+	}
+
+	client.Permissions = []string{"sessions:read"}
+	client.IsActive = false
+	if err := repo.UpdateClient(ctx, client); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.RotateClientKey(ctx, client.ID, "sk_test_new"); err != nil {
+		t.Fatal(err)
+	}
+
+	if old, err := repo.GetClientByApiKey(ctx, "sk_test_old"); old != nil || err != nil {
+		t.Errorf("expected the old key gone, got %v, %v", old, err)
+	}
+	got, err := repo.GetClient(ctx, client.ID)
+	if err != nil || got == nil {
+		t.Fatalf("expected the client, got %v, %v", got, err)
+	}
+	if got.ApiKey != "sk_test_new" || got.IsActive || len(got.Permissions) != 1 || got.LastUsedAt == nil {
+		t.Errorf("unexpected client %+v", got)
+	}
+
+	if err := repo.RotateClientKey(ctx, 9999, "sk_test_missing"); err == nil {
+		t.Error("expected an error rotating a missing client")
+	}
+	if clients, err := repo.ListClients(ctx); err != nil || len(clients) != 3 {
+		t.Errorf("expected the two seeded clients and the new one, got %d, %v", len(clients), err)
+	}
+}
+
+func TestSqliteDeactivateExpiredClients(t *testing.T) {
+	repo := newTestSqlite(t)
+	ctx := context.Background()
+
+	now := time.Now()
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+	expired := &models.ApiClient{Name: "expired", ApiKey: "sk_test_expired", IsActive: true, ExpiresAt: &past, AllowedCIDRs: []string{"2001:db8::/32"}}
+	current := &models.ApiClient{Name: "current", ApiKey: "sk_test_current", IsActive: true, ExpiresAt: &future}
+	for _, c := range []*models.ApiClient{expired, current} {
+		if err := repo.CreateClient(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := repo.DeactivateExpiredClients(ctx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].ID != expired.ID || got[0].IsActive {
+		t.Fatalf("expected only the expired client deactivated, got %+v", got)
+	}
+	if len(got[0].AllowedCIDRs) != 1 || got[0].AllowedCIDRs[0] != "2001:db8::/32" {
+		t.Errorf("expected the allowlist round-tripped, got %v", got[0].AllowedCIDRs)
+	}
+	if c, _ := repo.GetClient(ctx, current.ID); c == nil || !c.IsActive {
+		t.Errorf("expected the unexpired client still active, got %+v", c)
+	}
+
+	if again, err := repo.DeactivateExpiredClients(ctx, now); err != nil || len(again) != 0 {
+		t.Errorf("expected nothing left to deactivate, got %d, %v", len(again), err)
+	}
+}
+
+func TestSqliteJWTClients(t *testing.T) {
+	repo := newTestSqlite(t)
+	ctx := context.Background()
+
+	id, err := repo.EnsureJWTClient(ctx, "user-42", "jwt:user-42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := repo.EnsureJWTClient(ctx, "user-42", "jwt:user-42"); err != nil || again != id {
+		t.Errorf("expected the same client for the same subject, got %d, %v", again, err)
+	}
+	if other, err := repo.EnsureJWTClient(ctx, "user-43", "jwt:user-43"); err != nil || other == id {
+		t.Errorf("expected a client of its own for another subject, got %d, %v", other, err)
+	}
+
+	c, err := repo.GetClient(ctx, id)
+	if err != nil || c == nil {
+		t.Fatalf("expected the client row, got %+v, %v", c, err)
+	}
+	if c.Name != "jwt:user-42" || c.IsActive || len(c.Permissions) != 0 {
+		t.Errorf("expected an inactive client without permissions, got %+v", c)
+	}
+	// Its key must not authenticate
+	if byKey, _ := repo.GetClientByApiKey(ctx, c.ApiKey); byKey == nil || byKey.IsActive {
+		t.Errorf("expected the key of the row inactive, got %+v", byKey)
+	}
+}
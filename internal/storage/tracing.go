@@ -0,0 +1,569 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+	"github.com/terra-clan/sandbox-engine/internal/tracing"
+)
+
+// tracedRepository records a span around every call to the repository it
+// wraps, so slow queries show up inside the request or worker trace
+type tracedRepository struct {
+	inner Repository
+}
+
+// WithTracing wraps repo so each call is traced
+func WithTracing(repo Repository) Repository {
+	return &tracedRepository{inner: repo}
+}
+
+// WithTx traces the transaction as a whole and each call made inside it
+func (r *tracedRepository) WithTx(ctx context.Context, fn func(Repository) error) error {
+	ctx, span := tracing.Start(ctx, "storage.WithTx")
+	err := r.inner.WithTx(ctx, func(tx Repository) error {
+		return fn(&tracedRepository{inner: tx})
+	})
+	tracing.End(span, err)
+	return err
+}
+
+func (r *tracedRepository) Ping(ctx context.Context) error {
+	return r.inner.Ping(ctx)
+}
+
+func (r *tracedRepository) Close() error {
+	return r.inner.Close()
+}
+
+// HasReadReplica and PingReplica forward ReadReplica when the wrapped repository implements it
+func (r *tracedRepository) HasReadReplica() bool {
+	rr, ok := r.inner.(ReadReplica)
+	return ok && rr.HasReadReplica()
+}
+
+func (r *tracedRepository) PingReplica(ctx context.Context) error {
+	if rr, ok := r.inner.(ReadReplica); ok {
+		return rr.PingReplica(ctx)
+	}
+	return nil
+}
+
+func (r *tracedRepository) CreateSandbox(ctx context.Context, sb *models.Sandbox) error {
+	ctx, span := tracing.Start(ctx, "storage.CreateSandbox")
+	err := r.inner.CreateSandbox(ctx, sb)
+	tracing.End(span, err)
+	return err
+}
+
+func (r *tracedRepository) GetSandbox(ctx context.Context, id string) (*models.Sandbox, error) {
+	ctx, span := tracing.Start(ctx, "storage.GetSandbox")
+	v, err := r.inner.GetSandbox(ctx, id)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) UpdateSandbox(ctx context.Context, sb *models.Sandbox) error {
+	ctx, span := tracing.Start(ctx, "storage.UpdateSandbox")
+	err := r.inner.UpdateSandbox(ctx, sb)
+	tracing.End(span, err)
+	return err
+}
+
+func (r *tracedRepository) DeleteSandbox(ctx context.Context, id string, hard bool) error {
+	ctx, span := tracing.Start(ctx, "storage.DeleteSandbox")
+	err := r.inner.DeleteSandbox(ctx, id, hard)
+	tracing.End(span, err)
+	return err
+}
+
+func (r *tracedRepository) PurgeDeletedSandboxes(ctx context.Context, cutoff time.Time) (int64, error) {
+	ctx, span := tracing.Start(ctx, "storage.PurgeDeletedSandboxes")
+	v, err := r.inner.PurgeDeletedSandboxes(ctx, cutoff)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) ListSandboxes(ctx context.Context, filters models.ListFilters) ([]*models.Sandbox, error) {
+	ctx, span := tracing.Start(ctx, "storage.ListSandboxes")
+	v, err := r.inner.ListSandboxes(ctx, filters)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) CountSandboxes(ctx context.Context, filters models.ListFilters) (int, error) {
+	ctx, span := tracing.Start(ctx, "storage.CountSandboxes")
+	v, err := r.inner.CountSandboxes(ctx, filters)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) GetExpiredSandboxes(ctx context.Context, limit int) ([]*models.Sandbox, error) {
+	ctx, span := tracing.Start(ctx, "storage.GetExpiredSandboxes")
+	v, err := r.inner.GetExpiredSandboxes(ctx, limit)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) CountExpiredSandboxes(ctx context.Context) (int, error) {
+	ctx, span := tracing.Start(ctx, "storage.CountExpiredSandboxes")
+	v, err := r.inner.CountExpiredSandboxes(ctx)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) GetIdleSandboxes(ctx context.Context, idleSince time.Time) ([]*models.Sandbox, error) {
+	ctx, span := tracing.Start(ctx, "storage.GetIdleSandboxes")
+	v, err := r.inner.GetIdleSandboxes(ctx, idleSince)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) TouchSandboxActivity(ctx context.Context, id string, at time.Time) error {
+	ctx, span := tracing.Start(ctx, "storage.TouchSandboxActivity")
+	err := r.inner.TouchSandboxActivity(ctx, id, at)
+	tracing.End(span, err)
+	return err
+}
+
+func (r *tracedRepository) ExtendSandboxFrom(ctx context.Context, sb *models.Sandbox, from time.Time) (bool, error) {
+	ctx, span := tracing.Start(ctx, "storage.ExtendSandboxFrom")
+	v, err := r.inner.ExtendSandboxFrom(ctx, sb, from)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) CreateService(ctx context.Context, sandboxID string, svc *models.ServiceInstance) error {
+	ctx, span := tracing.Start(ctx, "storage.CreateService")
+	err := r.inner.CreateService(ctx, sandboxID, svc)
+	tracing.End(span, err)
+	return err
+}
+
+func (r *tracedRepository) GetServices(ctx context.Context, sandboxID string) ([]*models.ServiceInstance, error) {
+	ctx, span := tracing.Start(ctx, "storage.GetServices")
+	v, err := r.inner.GetServices(ctx, sandboxID)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) UpdateService(ctx context.Context, sandboxID string, svc *models.ServiceInstance) error {
+	ctx, span := tracing.Start(ctx, "storage.UpdateService")
+	err := r.inner.UpdateService(ctx, sandboxID, svc)
+	tracing.End(span, err)
+	return err
+}
+
+func (r *tracedRepository) DeleteServices(ctx context.Context, sandboxID string) error {
+	ctx, span := tracing.Start(ctx, "storage.DeleteServices")
+	err := r.inner.DeleteServices(ctx, sandboxID)
+	tracing.End(span, err)
+	return err
+}
+
+func (r *tracedRepository) GetHostStates(ctx context.Context) (map[string]*models.HostState, error) {
+	ctx, span := tracing.Start(ctx, "storage.GetHostStates")
+	v, err := r.inner.GetHostStates(ctx)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) SetHostState(ctx context.Context, st *models.HostState) error {
+	ctx, span := tracing.Start(ctx, "storage.SetHostState")
+	err := r.inner.SetHostState(ctx, st)
+	tracing.End(span, err)
+	return err
+}
+
+func (r *tracedRepository) CountSandboxesByHost(ctx context.Context, defaultHost string) (map[string]int, error) {
+	ctx, span := tracing.Start(ctx, "storage.CountSandboxesByHost")
+	v, err := r.inner.CountSandboxesByHost(ctx, defaultHost)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) CountGPUSandboxesByHost(ctx context.Context, defaultHost string) (map[string]int, error) {
+	ctx, span := tracing.Start(ctx, "storage.CountGPUSandboxesByHost")
+	v, err := r.inner.CountGPUSandboxesByHost(ctx, defaultHost)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) AssignUnplacedSandboxes(ctx context.Context, host string) (int64, error) {
+	ctx, span := tracing.Start(ctx, "storage.AssignUnplacedSandboxes")
+	v, err := r.inner.AssignUnplacedSandboxes(ctx, host)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) AppendEvent(ctx context.Context, ev *models.SandboxEvent) error {
+	ctx, span := tracing.Start(ctx, "storage.AppendEvent")
+	err := r.inner.AppendEvent(ctx, ev)
+	tracing.End(span, err)
+	return err
+}
+
+func (r *tracedRepository) ListEvents(ctx context.Context, sandboxID string) ([]*models.SandboxEvent, error) {
+	ctx, span := tracing.Start(ctx, "storage.ListEvents")
+	v, err := r.inner.ListEvents(ctx, sandboxID)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) DeleteEventsBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	ctx, span := tracing.Start(ctx, "storage.DeleteEventsBefore")
+	v, err := r.inner.DeleteEventsBefore(ctx, cutoff)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) RecordTemplateUsage(ctx context.Context, rec *models.UsageRecord) (bool, error) {
+	ctx, span := tracing.Start(ctx, "storage.RecordTemplateUsage")
+	v, err := r.inner.RecordTemplateUsage(ctx, rec)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) GetTemplateUsage(ctx context.Context, from, to time.Time) ([]*models.TemplateUsage, error) {
+	ctx, span := tracing.Start(ctx, "storage.GetTemplateUsage")
+	v, err := r.inner.GetTemplateUsage(ctx, from, to)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) CreateSession(ctx context.Context, s *models.Session) error {
+	ctx, span := tracing.Start(ctx, "storage.CreateSession")
+	err := r.inner.CreateSession(ctx, s)
+	tracing.End(span, err)
+	return err
+}
+
+func (r *tracedRepository) GetSessionByToken(ctx context.Context, token string) (*models.Session, error) {
+	ctx, span := tracing.Start(ctx, "storage.GetSessionByToken")
+	v, err := r.inner.GetSessionByToken(ctx, token)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) GetSessionByID(ctx context.Context, id string) (*models.Session, error) {
+	ctx, span := tracing.Start(ctx, "storage.GetSessionByID")
+	v, err := r.inner.GetSessionByID(ctx, id)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) GetSessionBySandboxID(ctx context.Context, sandboxID string) (*models.Session, error) {
+	ctx, span := tracing.Start(ctx, "storage.GetSessionBySandboxID")
+	v, err := r.inner.GetSessionBySandboxID(ctx, sandboxID)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) GetLiveSessionByUniqueKey(ctx context.Context, uniqueKey string) (*models.Session, error) {
+	ctx, span := tracing.Start(ctx, "storage.GetLiveSessionByUniqueKey")
+	v, err := r.inner.GetLiveSessionByUniqueKey(ctx, uniqueKey)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) UpdateSession(ctx context.Context, s *models.Session) error {
+	ctx, span := tracing.Start(ctx, "storage.UpdateSession")
+	err := r.inner.UpdateSession(ctx, s)
+	tracing.End(span, err)
+	return err
+}
+
+func (r *tracedRepository) UpdateSessionFrom(ctx context.Context, s *models.Session, from models.SessionStatus) (bool, error) {
+	ctx, span := tracing.Start(ctx, "storage.UpdateSessionFrom")
+	v, err := r.inner.UpdateSessionFrom(ctx, s, from)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) ClaimSession(ctx context.Context, s *models.Session) (bool, error) {
+	ctx, span := tracing.Start(ctx, "storage.ClaimSession")
+	v, err := r.inner.ClaimSession(ctx, s)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) DeleteSession(ctx context.Context, id string) error {
+	ctx, span := tracing.Start(ctx, "storage.DeleteSession")
+	err := r.inner.DeleteSession(ctx, id)
+	tracing.End(span, err)
+	return err
+}
+
+func (r *tracedRepository) PurgeRevokedSessions(ctx context.Context, cutoff time.Time) (int64, error) {
+	ctx, span := tracing.Start(ctx, "storage.PurgeRevokedSessions")
+	v, err := r.inner.PurgeRevokedSessions(ctx, cutoff)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) ListSessions(ctx context.Context, filters models.SessionFilters) ([]*models.Session, error) {
+	ctx, span := tracing.Start(ctx, "storage.ListSessions")
+	v, err := r.inner.ListSessions(ctx, filters)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) CountSessions(ctx context.Context, filters models.SessionFilters) (int, error) {
+	ctx, span := tracing.Start(ctx, "storage.CountSessions")
+	v, err := r.inner.CountSessions(ctx, filters)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) GetExpiredSessions(ctx context.Context, limit int) ([]*models.Session, error) {
+	ctx, span := tracing.Start(ctx, "storage.GetExpiredSessions")
+	v, err := r.inner.GetExpiredSessions(ctx, limit)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) CountExpiredSessions(ctx context.Context) (int, error) {
+	ctx, span := tracing.Start(ctx, "storage.CountExpiredSessions")
+	v, err := r.inner.CountExpiredSessions(ctx)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) SetSessionSandboxStatus(ctx context.Context, sandboxID string, status models.SandboxStatus) error {
+	ctx, span := tracing.Start(ctx, "storage.SetSessionSandboxStatus")
+	err := r.inner.SetSessionSandboxStatus(ctx, sandboxID, status)
+	tracing.End(span, err)
+	return err
+}
+
+func (r *tracedRepository) GetPrewarmDueSessions(ctx context.Context, limit int) ([]*models.Session, error) {
+	ctx, span := tracing.Start(ctx, "storage.GetPrewarmDueSessions")
+	v, err := r.inner.GetPrewarmDueSessions(ctx, limit)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) AttachSessionSandbox(ctx context.Context, id, sandboxID string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "storage.AttachSessionSandbox")
+	v, err := r.inner.AttachSessionSandbox(ctx, id, sandboxID)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) RevokeSession(ctx context.Context, id, revokedBy string, at time.Time) (string, bool, error) {
+	ctx, span := tracing.Start(ctx, "storage.RevokeSession")
+	sandboxID, ok, err := r.inner.RevokeSession(ctx, id, revokedBy, at)
+	tracing.End(span, err)
+	return sandboxID, ok, err
+}
+
+func (r *tracedRepository) OpenSessionConnection(ctx context.Context, c *models.SessionConnection) error {
+	ctx, span := tracing.Start(ctx, "storage.OpenSessionConnection")
+	err := r.inner.OpenSessionConnection(ctx, c)
+	tracing.End(span, err)
+	return err
+}
+
+func (r *tracedRepository) CloseSessionConnection(ctx context.Context, id int64, at time.Time) error {
+	ctx, span := tracing.Start(ctx, "storage.CloseSessionConnection")
+	err := r.inner.CloseSessionConnection(ctx, id, at)
+	tracing.End(span, err)
+	return err
+}
+
+func (r *tracedRepository) ListSessionConnections(ctx context.Context, sessionID string) ([]*models.SessionConnection, error) {
+	ctx, span := tracing.Start(ctx, "storage.ListSessionConnections")
+	v, err := r.inner.ListSessionConnections(ctx, sessionID)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) RecordCallbackDelivery(ctx context.Context, d *models.CallbackDelivery) error {
+	ctx, span := tracing.Start(ctx, "storage.RecordCallbackDelivery")
+	err := r.inner.RecordCallbackDelivery(ctx, d)
+	tracing.End(span, err)
+	return err
+}
+
+func (r *tracedRepository) UpdateCallbackDelivery(ctx context.Context, d *models.CallbackDelivery) error {
+	ctx, span := tracing.Start(ctx, "storage.UpdateCallbackDelivery")
+	err := r.inner.UpdateCallbackDelivery(ctx, d)
+	tracing.End(span, err)
+	return err
+}
+
+func (r *tracedRepository) ClaimCallbackDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*models.CallbackDelivery, error) {
+	ctx, span := tracing.Start(ctx, "storage.ClaimCallbackDeliveries")
+	v, err := r.inner.ClaimCallbackDeliveries(ctx, now, lease, limit)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) PurgeCallbackDeliveries(ctx context.Context) (int64, error) {
+	ctx, span := tracing.Start(ctx, "storage.PurgeCallbackDeliveries")
+	v, err := r.inner.PurgeCallbackDeliveries(ctx)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) ListCallbackDeliveries(ctx context.Context, sessionID string) ([]*models.CallbackDelivery, error) {
+	ctx, span := tracing.Start(ctx, "storage.ListCallbackDeliveries")
+	v, err := r.inner.ListCallbackDeliveries(ctx, sessionID)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) CreateTerminalRecording(ctx context.Context, rec *models.TerminalRecording) error {
+	ctx, span := tracing.Start(ctx, "storage.CreateTerminalRecording")
+	err := r.inner.CreateTerminalRecording(ctx, rec)
+	tracing.End(span, err)
+	return err
+}
+
+func (r *tracedRepository) FinishTerminalRecording(ctx context.Context, rec *models.TerminalRecording) error {
+	ctx, span := tracing.Start(ctx, "storage.FinishTerminalRecording")
+	err := r.inner.FinishTerminalRecording(ctx, rec)
+	tracing.End(span, err)
+	return err
+}
+
+func (r *tracedRepository) ListTerminalRecordings(ctx context.Context, sandboxID string) ([]*models.TerminalRecording, error) {
+	ctx, span := tracing.Start(ctx, "storage.ListTerminalRecordings")
+	v, err := r.inner.ListTerminalRecordings(ctx, sandboxID)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) PurgeTerminalRecordings(ctx context.Context, cutoff time.Time) ([]*models.TerminalRecording, error) {
+	ctx, span := tracing.Start(ctx, "storage.PurgeTerminalRecordings")
+	v, err := r.inner.PurgeTerminalRecordings(ctx, cutoff)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) GetTerminalRecording(ctx context.Context, id int64) (*models.TerminalRecording, error) {
+	ctx, span := tracing.Start(ctx, "storage.GetTerminalRecording")
+	v, err := r.inner.GetTerminalRecording(ctx, id)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) CreateGradingResult(ctx context.Context, res *models.GradingResult) error {
+	ctx, span := tracing.Start(ctx, "storage.CreateGradingResult")
+	err := r.inner.CreateGradingResult(ctx, res)
+	tracing.End(span, err)
+	return err
+}
+
+func (r *tracedRepository) ListGradingResults(ctx context.Context, sandboxID string) ([]*models.GradingResult, error) {
+	ctx, span := tracing.Start(ctx, "storage.ListGradingResults")
+	v, err := r.inner.ListGradingResults(ctx, sandboxID)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) ListTemplateDocuments(ctx context.Context) ([]*models.TemplateDocument, error) {
+	ctx, span := tracing.Start(ctx, "storage.ListTemplateDocuments")
+	v, err := r.inner.ListTemplateDocuments(ctx)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) GetTemplateDocument(ctx context.Context, name string) (*models.TemplateDocument, error) {
+	ctx, span := tracing.Start(ctx, "storage.GetTemplateDocument")
+	v, err := r.inner.GetTemplateDocument(ctx, name)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) SaveTemplateDocument(ctx context.Context, doc *models.TemplateDocument) error {
+	ctx, span := tracing.Start(ctx, "storage.SaveTemplateDocument")
+	err := r.inner.SaveTemplateDocument(ctx, doc)
+	tracing.End(span, err)
+	return err
+}
+
+func (r *tracedRepository) CreateTemplateDocument(ctx context.Context, doc *models.TemplateDocument) (bool, error) {
+	ctx, span := tracing.Start(ctx, "storage.CreateTemplateDocument")
+	v, err := r.inner.CreateTemplateDocument(ctx, doc)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) LockTemplateDocument(ctx context.Context, name string, forUpdate bool) (bool, error) {
+	ctx, span := tracing.Start(ctx, "storage.LockTemplateDocument")
+	v, err := r.inner.LockTemplateDocument(ctx, name, forUpdate)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) DeleteTemplateDocument(ctx context.Context, name string) error {
+	ctx, span := tracing.Start(ctx, "storage.DeleteTemplateDocument")
+	err := r.inner.DeleteTemplateDocument(ctx, name)
+	tracing.End(span, err)
+	return err
+}
+
+func (r *tracedRepository) GetClientByApiKey(ctx context.Context, apiKey string) (*models.ApiClient, error) {
+	ctx, span := tracing.Start(ctx, "storage.GetClientByApiKey")
+	v, err := r.inner.GetClientByApiKey(ctx, apiKey)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) GetClient(ctx context.Context, id int) (*models.ApiClient, error) {
+	ctx, span := tracing.Start(ctx, "storage.GetClient")
+	v, err := r.inner.GetClient(ctx, id)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) ListClients(ctx context.Context) ([]*models.ApiClient, error) {
+	ctx, span := tracing.Start(ctx, "storage.ListClients")
+	v, err := r.inner.ListClients(ctx)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) CreateClient(ctx context.Context, c *models.ApiClient) error {
+	ctx, span := tracing.Start(ctx, "storage.CreateClient")
+	err := r.inner.CreateClient(ctx, c)
+	tracing.End(span, err)
+	return err
+}
+
+func (r *tracedRepository) UpdateClient(ctx context.Context, c *models.ApiClient) error {
+	ctx, span := tracing.Start(ctx, "storage.UpdateClient")
+	err := r.inner.UpdateClient(ctx, c)
+	tracing.End(span, err)
+	return err
+}
+
+func (r *tracedRepository) RotateClientKey(ctx context.Context, id int, apiKey string) error {
+	ctx, span := tracing.Start(ctx, "storage.RotateClientKey")
+	err := r.inner.RotateClientKey(ctx, id, apiKey)
+	tracing.End(span, err)
+	return err
+}
+
+func (r *tracedRepository) DeactivateExpiredClients(ctx context.Context, now time.Time) ([]*models.ApiClient, error) {
+	ctx, span := tracing.Start(ctx, "storage.DeactivateExpiredClients")
+	v, err := r.inner.DeactivateExpiredClients(ctx, now)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (r *tracedRepository) UpdateClientLastUsed(ctx context.Context, apiKey string) error {
+	ctx, span := tracing.Start(ctx, "storage.UpdateClientLastUsed")
+	err := r.inner.UpdateClientLastUsed(ctx, apiKey)
+	tracing.End(span, err)
+	return err
+}
+
+func (r *tracedRepository) EnsureJWTClient(ctx context.Context, subject, name string) (int, error) {
+	ctx, span := tracing.Start(ctx, "storage.EnsureJWTClient")
+	v, err := r.inner.EnsureJWTClient(ctx, subject, name)
+	tracing.End(span, err)
+	return v, err
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+func TestWithTracingRecordsCalls(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	repo := WithTracing(NewMemoryRepository())
+	ctx := context.Background()
+
+	err := repo.WithTx(ctx, func(tx Repository) error {
+		if err := tx.CreateSandbox(ctx, &models.Sandbox{ID: "sb-1"}); err != nil {
+			return err
+		}
+		return tx.UpdateSandbox(ctx, &models.Sandbox{ID: "missing"})
+	})
+	if err == nil {
+		t.Fatal("expected the update of a missing sandbox to fail")
+	}
+
+	var names []string
+	failed := map[string]bool{}
+	for _, span := range recorder.Ended() {
+		names = append(names, span.Name())
+		failed[span.Name()] = len(span.Events()) > 0
+	}
+	want := []string{"storage.CreateSandbox", "storage.UpdateSandbox", "storage.WithTx"}
+	if len(names) != len(want) {
+		t.Fatalf("expected spans %v, got %v", want, names)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("span %d: expected %s, got %s", i, want[i], names[i])
+		}
+	}
+	if failed["storage.CreateSandbox"] || !failed["storage.UpdateSandbox"] {
+		t.Errorf("expected only the failed update to record an error, got %v", failed)
+	}
+
+	// Wrapping must not hide the replica capability from /ready
+	if _, ok := repo.(ReadReplica); !ok || repo.(ReadReplica).HasReadReplica() {
+		t.Error("expected ReadReplica forwarded, reporting no replica for the memory repository")
+	}
+}
@@ -0,0 +1,343 @@
+package templates
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// MaxCatalogArchiveSize bounds the unpacked size of a catalog archive
+const MaxCatalogArchiveSize = 32 << 20
+
+// ErrInvalidArchive is a catalog archive that is not a tar.gz of a catalog
+// tree as ExportCatalog writes it
+var ErrInvalidArchive = errors.New("invalid catalog archive")
+
+// ImportError is a catalog archive refused because some of its files failed
+// to load or validate. Paths are relative to the archive root.
+type ImportError struct {
+	Skipped   []SkippedFile      `json:"skipped"`
+	Invalid   []*InvalidTemplate `json:"invalid"`
+	Conflicts []Conflict         `json:"conflicts"`
+}
+
+func (e *ImportError) Error() string {
+	return fmt.Sprintf("catalog archive rejected: %d files failed to load, %d templates are invalid, %d names conflict",
+		len(e.Skipped), len(e.Invalid), len(e.Conflicts))
+}
+
+// ExportCatalog writes the catalog as loaded to w, as a tar.gz of its
+// domain/project/task YAML tree laid out like TEMPLATES_DIR. Files that failed
+// to load are left out; placeholders are kept unresolved.
+func (l *Loader) ExportCatalog(w io.Writer) error {
+	l.mu.RLock()
+	files := maps.Clone(l.catalogFiles)
+	l.mu.RUnlock()
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		data := files[name]
+		hdr := &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(data)), ModTime: now}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to finish catalog archive: %w", err)
+	}
+	return gz.Close()
+}
+
+// ImportCatalog loads a catalog archive, as ExportCatalog writes it, into a
+// staging loader in place of the catalog of the templates directory, next to
+// its flat templates and the stored ones, and reports what it changes. An
+// archive with files that fail to load or validate is refused with an
+// *ImportError. With apply, the archive's domains replace the catalog domains
+// of the templates directory and the result is swapped in at once; on any
+// failure the live catalog and the directory are left as they were. Only this
+// process's templates directory changes: other engine replicas keep their own
+// until the archive is imported there too.
+func (l *Loader) ImportCatalog(r io.Reader, apply bool) (*ReloadSummary, error) {
+	files, err := readCatalogArchive(r)
+	if err != nil {
+		return nil, err
+	}
+
+	l.loadMu.Lock()
+	defer l.loadMu.Unlock()
+
+	l.mu.RLock()
+	dir := l.dir
+	l.mu.RUnlock()
+	if dir == "" {
+		return nil, ErrNoTemplatesDir
+	}
+
+	domains := archiveDomains(files)
+	for _, name := range domains {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err == nil && info.IsDir() && !isDomainDir(filepath.Join(dir, name)) {
+			return nil, fmt.Errorf("%w: domain %s would replace a directory of templates", ErrInvalidArchive, name)
+		}
+	}
+
+	// Staged inside dir when applied, so it can be moved into place
+	parent := ""
+	if apply {
+		parent = dir
+	}
+	staged, err := os.MkdirTemp(parent, ".catalog-import-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to stage catalog archive: %w", err)
+	}
+	defer os.RemoveAll(staged)
+	for name, data := range files {
+		p := filepath.Join(staged, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			return nil, fmt.Errorf("failed to stage catalog archive: %w", err)
+		}
+		if err := os.WriteFile(p, data, 0o644); err != nil {
+			return nil, fmt.Errorf("failed to stage catalog archive: %w", err)
+		}
+	}
+
+	staging, source := l.newStaging()
+	staging.loadFlat(dir)
+	if err := staging.loadCatalogFromDir(staged); err != nil {
+		return nil, err
+	}
+	if source != nil {
+		if err := staging.loadDocuments(source); err != nil {
+			return nil, err
+		}
+	}
+	if err := importErrors(staging, staged); err != nil {
+		return nil, err
+	}
+
+	if !apply {
+		l.mu.RLock()
+		defer l.mu.RUnlock()
+		return l.diff(staging), nil
+	}
+
+	backup, restore, err := replaceCatalog(dir, staged, domains)
+	if err != nil {
+		return nil, err
+	}
+	summary, err := l.loadLocked(dir)
+	if err != nil {
+		if restore() {
+			os.RemoveAll(backup)
+		}
+		return nil, err
+	}
+	os.RemoveAll(backup)
+	return summary, nil
+}
+
+// readCatalogArchive reads the files of a catalog archive by slash separated
+// path. Only domain.yaml, project template.yaml and task files are allowed,
+// each under a domain and project the archive defines.
+func readCatalogArchive(r io.Reader) (map[string][]byte, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	tr := tar.NewReader(gz)
+
+	files := make(map[string][]byte)
+	var total int64
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "./"))
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("%w: %s is not a regular file", ErrInvalidArchive, hdr.Name)
+		}
+		if !isCatalogPath(name) {
+			return nil, fmt.Errorf("%w: %s is not a domain.yaml, project template.yaml or task file", ErrInvalidArchive, hdr.Name)
+		}
+		if total += hdr.Size; total > MaxCatalogArchiveSize {
+			return nil, fmt.Errorf("%w: exceeds %d MB unpacked", ErrInvalidArchive, MaxCatalogArchiveSize>>20)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+		files[name] = data
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%w: no catalog files", ErrInvalidArchive)
+	}
+
+	// The loader ignores files outside a domain or project; refuse them
+	for name := range files {
+		parts := strings.Split(name, "/")
+		if _, ok := files[parts[0]+"/domain.yaml"]; !ok {
+			return nil, fmt.Errorf("%w: %s has no %s/domain.yaml", ErrInvalidArchive, name, parts[0])
+		}
+		if len(parts) == 4 {
+			if _, ok := files[path.Join(parts[0], parts[1], "template.yaml")]; !ok {
+				return nil, fmt.Errorf("%w: %s has no %s/%s/template.yaml", ErrInvalidArchive, name, parts[0], parts[1])
+			}
+		}
+	}
+	return files, nil
+}
+
+// isCatalogPath reports whether name is <domain>/domain.yaml,
+// <domain>/<project>/template.yaml or <domain>/<project>/tasks/<task>.yaml
+func isCatalogPath(name string) bool {
+	parts := strings.Split(name, "/")
+	for _, p := range parts {
+		if p == "" || p == ".." || strings.HasPrefix(p, ".") {
+			return false
+		}
+	}
+	switch len(parts) {
+	case 2:
+		return parts[1] == "domain.yaml"
+	case 3:
+		return parts[2] == "template.yaml"
+	case 4:
+		ext := strings.ToLower(path.Ext(parts[3]))
+		return parts[2] == "tasks" && (ext == ".yaml" || ext == ".yml")
+	}
+	return false
+}
+
+// archiveDomains returns the domains of an archive's files, sorted
+func archiveDomains(files map[string][]byte) []string {
+	var domains []string
+	for name := range files {
+		if domain, file, _ := strings.Cut(name, "/"); file == "domain.yaml" {
+			domains = append(domains, domain)
+		}
+	}
+	slices.Sort(domains)
+	return domains
+}
+
+// isDomainDir reports whether dir is a catalog domain, i.e. has a domain.yaml
+func isDomainDir(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, "domain.yaml"))
+	return err == nil
+}
+
+// importErrors reports the files of the staged archive that staging failed
+// to load or validate, with paths relative to staged
+func importErrors(staging *Loader, staged string) error {
+	rel := func(file string) (string, bool) {
+		r, err := filepath.Rel(staged, file)
+		if err != nil || strings.HasPrefix(r, "..") {
+			return file, false
+		}
+		return filepath.ToSlash(r), true
+	}
+
+	e := &ImportError{Skipped: []SkippedFile{}, Invalid: []*InvalidTemplate{}, Conflicts: []Conflict{}}
+	for _, s := range staging.skipped {
+		if file, ok := rel(s.File); ok {
+			e.Skipped = append(e.Skipped, SkippedFile{File: file, Error: s.Error})
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(staging.invalid)) {
+		inv := *staging.invalid[name]
+		var ok bool
+		if inv.File, ok = rel(inv.File); ok {
+			e.Invalid = append(e.Invalid, &inv)
+		}
+	}
+	for _, c := range staging.conflicts {
+		file, inFile := rel(c.File)
+		with, inWith := rel(c.ConflictsWith)
+		if inFile || inWith {
+			e.Conflicts = append(e.Conflicts, Conflict{Name: c.Name, File: file, ConflictsWith: with})
+		}
+	}
+	if len(e.Skipped)+len(e.Invalid)+len(e.Conflicts) > 0 {
+		return e
+	}
+	return nil
+}
+
+// replaceCatalog moves the catalog domains of dir aside into backup and those
+// staged in their place. A failed move puts everything back as it was. On
+// success the caller removes backup once the new catalog has loaded, or calls
+// restore to put the old one back; restore reports whether every domain was
+// restored, and backup is kept for recovery by hand when not.
+func replaceCatalog(dir, staged string, domains []string) (backup string, restore func() bool, err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read templates directory: %w", err)
+	}
+	backup, err = os.MkdirTemp(dir, ".catalog-backup-")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to replace catalog: %w", err)
+	}
+
+	var aside, placed []string
+	restore = func() bool {
+		ok := true
+		for _, name := range placed {
+			if err := os.Rename(filepath.Join(dir, name), filepath.Join(staged, name)); err != nil {
+				slog.Error("failed to undo catalog import", "domain", name, "error", err)
+				ok = false
+			}
+		}
+		for _, name := range aside {
+			if err := os.Rename(filepath.Join(backup, name), filepath.Join(dir, name)); err != nil {
+				slog.Error("failed to restore catalog domain", "domain", name, "backup", backup, "error", err)
+				ok = false
+			}
+		}
+		return ok
+	}
+	fail := func(err error) (string, func() bool, error) {
+		if restore() {
+			os.RemoveAll(backup)
+		}
+		return "", nil, err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || !isDomainDir(filepath.Join(dir, entry.Name())) {
+			continue
+		}
+		if err := os.Rename(filepath.Join(dir, entry.Name()), filepath.Join(backup, entry.Name())); err != nil {
+			return fail(fmt.Errorf("failed to move domain %s aside: %w", entry.Name(), err))
+		}
+		aside = append(aside, entry.Name())
+	}
+	for _, name := range domains {
+		if err := os.Rename(filepath.Join(staged, name), filepath.Join(dir, name)); err != nil {
+			return fail(fmt.Errorf("failed to move domain %s into place: %w", name, err))
+		}
+		placed = append(placed, name)
+	}
+	return backup, restore, nil
+}
@@ -0,0 +1,251 @@
+package templates
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeTree writes files, by slash separated path, under dir
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, doc := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(doc), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// catalogArchive packs files, by path, into a tar.gz
+func catalogArchive(t *testing.T, files map[string]string) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, doc := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(doc))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(doc)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+// archiveFiles unpacks a tar.gz into its files by path
+func archiveFiles(t *testing.T, r io.Reader) map[string]string {
+	t.Helper()
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	files := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[hdr.Name] = string(data)
+	}
+}
+
+func TestCatalogExportImport(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"python.yaml":              "name: python\nbase_image: python:3.12\n",
+		"shop/domain.yaml":         "name: Shop\n",
+		"shop/api/template.yaml":   "name: shop-api\nbase_image: node:20\n",
+		"shop/api/tasks/cart.yaml": "title: Cart\n",
+		"shop/api/tasks/bad.yaml":  "description: no title\n",
+	})
+	loader := NewLoader()
+	if err := loader.LoadFromDir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	var export bytes.Buffer
+	if err := loader.ExportCatalog(&export); err != nil {
+		t.Fatal(err)
+	}
+	exported := archiveFiles(t, &export)
+	want := map[string]string{
+		"shop/domain.yaml":         "name: Shop\n",
+		"shop/api/template.yaml":   "name: shop-api\nbase_image: node:20\n",
+		"shop/api/tasks/cart.yaml": "title: Cart\n",
+	}
+	if !reflect.DeepEqual(exported, want) {
+		t.Errorf("expected the loaded catalog exported, got %v", exported)
+	}
+
+	// Staging: a domain replaced by another, shown as a diff only
+	next := map[string]string{
+		"games/domain.yaml":           "name: Games\n",
+		"games/chess/template.yaml":   "name: chess\nbase_image: golang:1.23\n",
+		"games/chess/tasks/moves.yml": "title: Moves\n",
+	}
+	summary, err := loader.ImportCatalog(catalogArchive(t, next), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(summary.Domains.Added, []string{"games"}) || !reflect.DeepEqual(summary.Domains.Removed, []string{"shop"}) ||
+		!reflect.DeepEqual(summary.Tasks.Added, []string{"games/chess/moves"}) || !reflect.DeepEqual(summary.Templates.Removed, []string{"shop-api"}) {
+		t.Errorf("unexpected import summary %+v", summary)
+	}
+	if loader.GetDomain("games") != nil || loader.GetDomain("shop") == nil {
+		t.Fatal("expected a staged import to leave the catalog alone")
+	}
+
+	summary, err = loader.ImportCatalog(catalogArchive(t, next), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(summary.Domains.Added, []string{"games"}) {
+		t.Errorf("unexpected applied summary %+v", summary)
+	}
+	if loader.GetTask("games/chess/moves") == nil || loader.GetDomain("shop") != nil || loader.Get("python") == nil {
+		t.Fatal("expected the catalog replaced and the flat templates kept")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "shop")); !os.IsNotExist(err) {
+		t.Errorf("expected the old domain removed from the directory, got %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("expected only python.yaml and games left, got %v", entries)
+	}
+
+	// The import survives a reload from the directory
+	if _, err := loader.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if loader.GetTask("games/chess/moves") == nil {
+		t.Error("expected the imported catalog reloaded from the directory")
+	}
+}
+
+func TestCatalogImportRejected(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"python.yaml":              "name: python\nbase_image: python:3.12\n",
+		"shop/domain.yaml":         "name: Shop\n",
+		"shop/api/template.yaml":   "name: shop-api\nbase_image: node:20\n",
+		"shop/api/tasks/cart.yaml": "title: Cart\n",
+	})
+	loader := NewLoader()
+	if err := loader.LoadFromDir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		files       map[string]string
+		wantInvalid bool
+		want        *ImportError
+	}{
+		{name: "path traversal", files: map[string]string{"../shop/domain.yaml": "name: Shop\n"}, wantInvalid: true},
+		{name: "stray file", files: map[string]string{"shop/domain.yaml": "name: Shop\n", "shop/README.md": "hi"}, wantInvalid: true},
+		{name: "task without its project", files: map[string]string{"shop/domain.yaml": "name: Shop\n", "shop/api/tasks/cart.yaml": "title: Cart\n"}, wantInvalid: true},
+		{
+			name: "task without a title",
+			files: map[string]string{
+				"shop/domain.yaml":         "name: Shop\n",
+				"shop/api/template.yaml":   "name: shop-api\nbase_image: node:20\n",
+				"shop/api/tasks/cart.yaml": "description: no title\n",
+			},
+			want: &ImportError{Skipped: []SkippedFile{{File: "shop/api/tasks/cart.yaml", Error: "task title is required"}}, Invalid: []*InvalidTemplate{}, Conflicts: []Conflict{}},
+		},
+		{
+			name: "invalid template",
+			files: map[string]string{
+				"shop/domain.yaml":       "name: Shop\n",
+				"shop/api/template.yaml": "name: shop-api\nbase_image: node:20\nservices: [nosuchdb]\nexpose: [{port: 80}, {port: 80}]\n",
+			},
+		},
+		{
+			name: "template named like a flat one",
+			files: map[string]string{
+				"shop/domain.yaml":       "name: Shop\n",
+				"shop/api/template.yaml": "name: python\nbase_image: python:3.12\n",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loader.ImportCatalog(catalogArchive(t, tt.files), true)
+			var importErr *ImportError
+			switch {
+			case tt.wantInvalid:
+				if !errors.Is(err, ErrInvalidArchive) {
+					t.Errorf("expected ErrInvalidArchive, got %v", err)
+				}
+			case !errors.As(err, &importErr):
+				t.Errorf("expected an ImportError, got %v", err)
+			case tt.want != nil && !reflect.DeepEqual(importErr, tt.want):
+				t.Errorf("expected %+v, got %+v", tt.want, importErr)
+			}
+
+			if loader.GetTask("shop/api/cart") == nil {
+				t.Error("expected the live catalog untouched")
+			}
+			if data, err := os.ReadFile(filepath.Join(dir, "shop", "api", "tasks", "cart.yaml")); err != nil || string(data) != "title: Cart\n" {
+				t.Errorf("expected the directory untouched, got %q, %v", data, err)
+			}
+			if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+				t.Errorf("expected no staging left in the directory, got %v", entries)
+			}
+		})
+	}
+}
+
+func TestReplaceCatalogRestore(t *testing.T) {
+	dir, staged := t.TempDir(), t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"python.yaml":            "name: python\nbase_image: python:3.12\n",
+		"shop/domain.yaml":       "name: Shop\n",
+		"shop/api/template.yaml": "name: shop-api\nbase_image: node:20\n",
+	})
+	writeTree(t, staged, map[string]string{"games/domain.yaml": "name: Games\n"})
+
+	backup, restore, err := replaceCatalog(dir, staged, []string{"games"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isDomainDir(filepath.Join(dir, "games")) || !isDomainDir(filepath.Join(backup, "shop")) {
+		t.Fatal("expected the staged domain in place and the old one in the backup")
+	}
+
+	// A failed reload puts the old catalog back from the backup
+	if !restore() {
+		t.Fatal("expected every domain restored")
+	}
+	if !isDomainDir(filepath.Join(dir, "shop")) || isDomainDir(filepath.Join(dir, "games")) || !isDomainDir(filepath.Join(staged, "games")) {
+		t.Error("expected the directory as it was before the import")
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "shop", "api", "template.yaml")); err != nil || string(data) != "name: shop-api\nbase_image: node:20\n" {
+		t.Errorf("expected the project restored, got %q, %v", data, err)
+	}
+}
@@ -1,10 +1,13 @@
 package templates
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -23,6 +26,75 @@ type Loader struct {
 	domains  map[string]*models.Domain
 	projects map[string]*models.CatalogProject
 	tasks    map[string]*models.CatalogTask
+
+	// Callbacks run after templates are (re)loaded from a directory
+	onLoad []func()
+
+	// strict rejects templates with validation errors instead of loading them with warnings
+	strict bool
+
+	// validators check templates against the environment, after the document checks
+	validators []Validator
+
+	// vars resolve the ${NAME} placeholders of template documents
+	vars map[string]string
+
+	// locale is the locale of the Title and Description of localized tasks
+	// and templates
+	locale string
+
+	// invalid holds the templates that failed validation, by name
+	invalid map[string]*InvalidTemplate
+	// skipped holds the template files the last load could not load at all
+	skipped []SkippedFile
+	// files holds the file each template name was first defined in
+	files map[string]string
+	// conflicts holds the names the last load found defined twice
+	conflicts []Conflict
+	// catalogFiles holds the documents of the catalog as loaded, by slash
+	// separated path under the directory, for ExportCatalog
+	catalogFiles map[string][]byte
+
+	// dir is the directory last loaded, which Reload loads again
+	dir string
+	// source holds the templates created through the API, loaded after dir
+	source DocumentSource
+	// stored holds the names of the templates loaded from source
+	stored map[string]bool
+	// loadMu serializes loads from a directory
+	loadMu sync.Mutex
+}
+
+// DocumentSource lists the template documents stored outside the templates
+// directory, i.e. those created through the API
+type DocumentSource interface {
+	ListTemplateDocuments(ctx context.Context) ([]*models.TemplateDocument, error)
+}
+
+// InvalidTemplate is a template that failed validation. A strict loader
+// leaves it out of List and Get; otherwise it is loaded like a valid one.
+type InvalidTemplate struct {
+	Name   string  `json:"name"`
+	File   string  `json:"file,omitempty"`
+	Reason string  `json:"reason"` // the first error
+	Issues []Issue `json:"issues"`
+}
+
+// SkippedFile is a template or catalog file a load left out because it could
+// not be parsed, e.g. for a missing name, an unparseable TTL or a task without
+// a title
+type SkippedFile struct {
+	File  string `json:"file"`
+	Error string `json:"error"`
+}
+
+// Conflict is a template name defined by two files, or a catalog project alias
+// colliding with a template name. The first definition wins; the later one,
+// in File, is not loaded under the name.
+type Conflict struct {
+	Name          string `json:"name"`
+	File          string `json:"file"`
+	ConflictsWith string `json:"conflicts_with"`
 }
 
 // NewLoader creates a new template loader
@@ -32,11 +104,162 @@ func NewLoader() *Loader {
 		domains:   make(map[string]*models.Domain),
 		projects:  make(map[string]*models.CatalogProject),
 		tasks:     make(map[string]*models.CatalogTask),
+		invalid:   make(map[string]*InvalidTemplate),
+		stored:    make(map[string]bool),
+		files:     make(map[string]string),
+		locale:    DefaultLocale,
+
+		catalogFiles: make(map[string][]byte),
 	}
 }
 
-// LoadFromDir loads all YAML templates from a directory (flat and hierarchical)
+// DefaultLocale is the locale of localized titles and descriptions when
+// SetDefaultLocale is not called
+const DefaultLocale = "en"
+
+// ErrNoTemplatesDir is returned by Reload before any directory was loaded
+var ErrNoTemplatesDir = errors.New("no templates directory loaded")
+
+// LoadFromDir loads all YAML templates and the catalog from a directory
+// (flat and hierarchical), then the documents of the source, replacing
+// whatever was loaded before. An empty dir loads the source alone.
 func (l *Loader) LoadFromDir(dir string) error {
+	_, err := l.load(dir)
+	return err
+}
+
+// Reload loads the directory of the last LoadFromDir again. The new templates
+// and catalog are built aside and swapped in at once, so lookups never see a
+// half-loaded state; sandboxes keep working as they only hold template IDs.
+func (l *Loader) Reload() (*ReloadSummary, error) {
+	l.mu.RLock()
+	dir := l.dir
+	l.mu.RUnlock()
+	if dir == "" && l.documentSource() == nil {
+		return nil, ErrNoTemplatesDir
+	}
+	return l.load(dir)
+}
+
+// load builds the templates and catalog of dir into a fresh loader, swaps
+// them in and runs the OnLoad callbacks
+func (l *Loader) load(dir string) (*ReloadSummary, error) {
+	l.loadMu.Lock()
+	defer l.loadMu.Unlock()
+	return l.loadLocked(dir)
+}
+
+// loadLocked is load with loadMu held
+func (l *Loader) loadLocked(dir string) (*ReloadSummary, error) {
+	if dir != "" {
+		if _, err := os.ReadDir(dir); err != nil {
+			return nil, fmt.Errorf("failed to read templates directory: %w", err)
+		}
+	}
+
+	staging, source := l.newStaging()
+	if dir != "" {
+		staging.loadDir(dir)
+	}
+	if source != nil {
+		if err := staging.loadDocuments(source); err != nil {
+			// A database hiccup mustn't take the directory templates down
+			// with it; the stored ones keep what the last load read
+			slog.Error("failed to load stored templates, keeping the loaded ones", "error", err)
+			l.keepStored(staging)
+		}
+	}
+	return l.commit(staging, dir), nil
+}
+
+// keepStored copies the stored templates of l into staging, except those a
+// template of the directory now shadows
+func (l *Loader) keepStored(staging *Loader) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for name := range l.stored {
+		_, isTemplate := staging.templates[name]
+		if _, isInvalid := staging.invalid[name]; isTemplate || isInvalid {
+			continue
+		}
+		if t, ok := l.templates[name]; ok {
+			staging.templates[name] = t
+		}
+		if t, ok := l.invalid[name]; ok {
+			staging.invalid[name] = t
+		}
+		staging.stored[name] = true
+	}
+}
+
+// newStaging returns an empty loader with the settings of l, to load into
+// aside, and the document source to load last
+func (l *Loader) newStaging() (*Loader, DocumentSource) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	staging := NewLoader()
+	staging.strict = l.strict
+	staging.validators = append([]Validator(nil), l.validators...)
+	staging.vars = l.vars
+	staging.locale = l.locale
+	return staging, l.source
+}
+
+// commit swaps the templates and catalog of staging, loaded from dir, in for
+// those of l, runs the OnLoad callbacks and reports what changed
+func (l *Loader) commit(staging *Loader, dir string) *ReloadSummary {
+	if len(staging.skipped) > 0 {
+		files := make([]string, len(staging.skipped))
+		for i, s := range staging.skipped {
+			files[i] = s.File
+		}
+		slog.Warn("template files skipped", "count", len(files), "files", files)
+	}
+	for _, c := range staging.conflicts {
+		slog.Warn("template name defined twice", "name", c.Name, "file", c.File, "conflicts_with", c.ConflictsWith)
+	}
+
+	l.mu.Lock()
+	summary := l.diff(staging)
+	l.templates, l.invalid, l.stored, l.skipped = staging.templates, staging.invalid, staging.stored, staging.skipped
+	l.files, l.conflicts = staging.files, staging.conflicts
+	l.domains, l.projects, l.tasks = staging.domains, staging.projects, staging.tasks
+	l.catalogFiles = staging.catalogFiles
+	l.dir = dir
+	callbacks := append([]func(){}, l.onLoad...)
+	l.mu.Unlock()
+
+	for _, fn := range callbacks {
+		fn()
+	}
+	return summary
+}
+
+// diff reports what swapping staging in would change; l.mu must be held
+func (l *Loader) diff(staging *Loader) *ReloadSummary {
+	return &ReloadSummary{
+		Templates: diffEntries(templateEntries(l.templates), templateEntries(staging.templates)),
+		Domains:   diffEntries(l.domains, staging.domains),
+		Projects:  diffEntries(l.projects, staging.projects),
+		Tasks:     diffEntries(l.tasks, staging.tasks),
+		Skipped:   append([]SkippedFile{}, staging.skipped...),
+		Conflicts: append([]Conflict{}, staging.conflicts...),
+	}
+}
+
+// loadDir loads the templates and catalog of dir into l
+func (l *Loader) loadDir(dir string) {
+	l.loadFlat(dir)
+
+	// Load hierarchical catalog (domain → project → task)
+	if err := l.loadCatalogFromDir(dir); err != nil {
+		slog.Warn("failed to load catalog", "error", err)
+	}
+}
+
+// loadFlat loads the template files of dir and its direct subdirectories
+// into l, leaving out the catalog
+func (l *Loader) loadFlat(dir string) {
 	slog.Info("loading templates from directory", "dir", dir)
 
 	// Find all YAML files (flat loading for backward compat)
@@ -68,21 +291,101 @@ func (l *Loader) LoadFromDir(dir string) error {
 
 		if err := l.LoadFromFile(file); err != nil {
 			slog.Warn("failed to load template", "file", file, "error", err)
+			l.skip(file, err)
 			continue
 		}
 		loaded++
 	}
 
 	slog.Info("templates loaded (flat)", "count", loaded, "total_files", len(files))
+}
 
-	// Load hierarchical catalog (domain → project → task)
-	if err := l.loadCatalogFromDir(dir); err != nil {
-		slog.Warn("failed to load catalog", "error", err)
+// loadDocuments loads the template documents of source into l. A document
+// named like a template of the directory is skipped: the file wins.
+func (l *Loader) loadDocuments(source DocumentSource) error {
+	docs, err := source.ListTemplateDocuments(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to list stored templates: %w", err)
+	}
+
+	loaded := 0
+	for _, doc := range docs {
+		_, isTemplate := l.templates[doc.Name]
+		if _, isInvalid := l.invalid[doc.Name]; isTemplate || isInvalid {
+			slog.Warn("stored template shadowed by the templates directory", "name", doc.Name)
+			continue
+		}
+		if _, err := l.register([]byte(doc.Document), ""); err != nil {
+			slog.Warn("failed to load stored template", "name", doc.Name, "error", err)
+			continue
+		}
+		l.stored[doc.Name] = true
+		loaded++
 	}
 
+	slog.Info("templates loaded (stored)", "count", loaded, "total", len(docs))
 	return nil
 }
 
+// SetDocumentSource sets where the templates created through the API are
+// loaded from, on every load from now on
+func (l *Loader) SetDocumentSource(source DocumentSource) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.source = source
+}
+
+func (l *Loader) documentSource() DocumentSource {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.source
+}
+
+// IsStored reports whether a template was loaded from the document source,
+// as opposed to the templates directory
+func (l *Loader) IsStored(name string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.stored[name]
+}
+
+// SetStrict enables or disables strict validation for subsequent loads
+func (l *Loader) SetStrict(strict bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.strict = strict
+}
+
+// SetVars sets the values of the ${NAME} placeholders in template documents
+// loaded or validated from now on; see LoadVars
+func (l *Loader) SetVars(vars map[string]string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.vars = vars
+}
+
+// SetDefaultLocale sets the locale whose text becomes the Title and
+// Description of tasks and templates written in several, for subsequent loads
+func (l *Loader) SetDefaultLocale(locale string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.locale = locale
+}
+
+// AddValidator adds a check run on every template loaded or validated from now on
+func (l *Loader) AddValidator(v Validator) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.validators = append(l.validators, v)
+}
+
+// OnLoad registers fn to run after every LoadFromDir
+func (l *Loader) OnLoad(fn func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.onLoad = append(l.onLoad, fn)
+}
+
 // LoadFromFile loads a single template from a YAML file
 func (l *Loader) LoadFromFile(path string) error {
 	data, err := os.ReadFile(path)
@@ -90,39 +393,241 @@ func (l *Loader) LoadFromFile(path string) error {
 		return fmt.Errorf("failed to read file: %w", err)
 	}
 
+	_, err = l.register(data, path)
+	return err
+}
+
+// register validates a template document and adds it; file is the path it was
+// read from, empty for a stored document
+func (l *Loader) register(data []byte, file string) (*models.Template, error) {
+	template, issues, err := l.Validate(data)
+	if err != nil {
+		return nil, err
+	}
+	issues = append(issues, resolveSeeds(template, file)...)
+
+	l.mu.Lock()
+	strict := l.strict
+	if file != "" {
+		// A name is claimed by the first file defining it, even if invalid
+		if prev, ok := l.files[template.Name]; ok && prev != file {
+			l.conflicts = append(l.conflicts, Conflict{Name: template.Name, File: file, ConflictsWith: prev})
+			l.mu.Unlock()
+			return nil, fmt.Errorf("template %s is already defined in %s", template.Name, prev)
+		}
+		l.files[template.Name] = file
+	}
+	l.mu.Unlock()
+
+	for _, issue := range issues {
+		slog.Warn("template validation issue",
+			"template", template.Name,
+			"file", file,
+			"severity", issue.Severity,
+			"field", issue.Field,
+			"message", issue.Message,
+		)
+	}
+
+	l.mu.Lock()
+	delete(l.invalid, template.Name)
+	if HasErrors(issues) {
+		invalid := &InvalidTemplate{Name: template.Name, File: file, Issues: issues}
+		for _, issue := range issues {
+			if issue.Severity == SeverityError {
+				invalid.Reason = issue.String()
+				break
+			}
+		}
+		l.invalid[template.Name] = invalid
+		if strict {
+			delete(l.templates, template.Name)
+			l.mu.Unlock()
+			return nil, fmt.Errorf("template %s is invalid: %s", template.Name, invalid.Reason)
+		}
+	}
+	l.templates[template.Name] = template
+	l.mu.Unlock()
+
+	slog.Info("template loaded", "name", template.Name, "image", template.BaseImage)
+	return template, nil
+}
+
+// resolveSeeds resolves the seed files of a template read from file against
+// its directory. A stored document has no directory to read them from.
+func resolveSeeds(template *models.Template, file string) []Issue {
+	names := make([]string, 0, len(template.ServicesConfig))
+	for name := range template.ServicesConfig {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var issues []Issue
+	for _, name := range names {
+		cfg := template.ServicesConfig[name]
+		if cfg.Seed == "" {
+			continue
+		}
+		field := "services_config." + name + ".seed"
+		if file == "" {
+			issues = append(issues, Issue{Field: field, Severity: SeverityError, Message: "seed files are only read for templates loaded from a directory"})
+			continue
+		}
+		if !filepath.IsLocal(cfg.Seed) {
+			issues = append(issues, Issue{Field: field, Severity: SeverityError, Message: "seed must be relative to and inside the template's directory"})
+			continue
+		}
+		path := filepath.Join(filepath.Dir(file), cfg.Seed)
+		info, err := os.Stat(path)
+		if err != nil {
+			issues = append(issues, Issue{Field: field, Severity: SeverityError, Message: fmt.Sprintf("seed file %s not found", cfg.Seed)})
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			issues = append(issues, Issue{Field: field, Severity: SeverityError, Message: fmt.Sprintf("seed %s is not a file", cfg.Seed)})
+			continue
+		}
+		if !seedInside(filepath.Dir(file), path) {
+			issues = append(issues, Issue{Field: field, Severity: SeverityError, Message: fmt.Sprintf("seed %s links outside the template's directory", cfg.Seed)})
+			continue
+		}
+		cfg.SeedPath = path
+		template.ServicesConfig[name] = cfg
+	}
+	return issues
+}
+
+// seedInside reports whether path, with symlinks resolved, is still under dir
+func seedInside(dir, path string) bool {
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return false
+	}
+	realPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(realDir, realPath)
+	return err == nil && filepath.IsLocal(rel)
+}
+
+// Validate parses a template YAML document and returns its validation issues,
+// those of the loader's validators included, without registering it
+func (l *Loader) Validate(data []byte) (*models.Template, []Issue, error) {
+	l.mu.RLock()
+	vars := l.vars
+	locale := l.locale
+	validators := append([]Validator(nil), l.validators...)
+	l.mu.RUnlock()
+
+	template, issues, err := parseTemplate(data, vars)
+	if err != nil {
+		return nil, nil, err
+	}
+	if template.Descriptions != nil {
+		template.Description = template.Descriptions.Fallback(locale)
+	}
+
+	for _, v := range validators {
+		issues = append(issues, v.ValidateTemplate(template)...)
+	}
+	return template, issues, nil
+}
+
+// parseTemplate parses a template YAML document, resolves its placeholders
+// from vars, applies defaults and validates it. Only malformed documents
+// return an error; other problems are reported as issues.
+func parseTemplate(data []byte, vars map[string]string) (*models.Template, []Issue, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse YAML: %w", err)
+	}
+	// A placeholder left unresolved would reach Docker as literal text, so it
+	// rejects the document outright
+	resolved := make(map[string]string)
+	if err := firstError(substituteNode(&doc, "", vars, resolved)); err != nil {
+		return nil, nil, err
+	}
+
 	var tmpl templateFile
-	if err := yaml.Unmarshal(data, &tmpl); err != nil {
-		return fmt.Errorf("failed to parse YAML: %w", err)
+	if doc.Kind != 0 {
+		if err := doc.Decode(&tmpl); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse YAML: %w", err)
+		}
 	}
 
 	// Validate required fields
 	if tmpl.Name == "" {
-		return fmt.Errorf("template name is required")
+		return nil, nil, fmt.Errorf("template name is required")
 	}
 	if tmpl.BaseImage == "" {
-		return fmt.Errorf("base_image is required")
+		return nil, nil, fmt.Errorf("base_image is required")
 	}
 
-	// Convert TTL string to duration
-	ttl := 1 * time.Hour
-	if tmpl.TTL != "" {
-		if d, err := time.ParseDuration(tmpl.TTL); err == nil {
-			ttl = d
+	// An unparseable TTL or resource would otherwise run sandboxes on a default
+	// nobody asked for, so it rejects the document outright
+	ttl, ttlIssues := ParseTTL(tmpl.TTL, time.Hour)
+	if err := firstError(ttlIssues); err != nil {
+		return nil, nil, err
+	}
+	if err := firstError(ValidateResources(tmpl.Resources)); err != nil {
+		return nil, nil, err
+	}
+	maxTTL, maxTTLIssues := ParseMaxTTL(tmpl.MaxTTL)
+	if err := firstError(maxTTLIssues); err != nil {
+		return nil, nil, err
+	}
+	if maxTTL > 0 && ttl > maxTTL {
+		if tmpl.TTL != "" {
+			ttlIssues = append(ttlIssues, Issue{Field: "ttl", Severity: SeverityError, Message: fmt.Sprintf("ttl %s exceeds max_ttl %s", ttl, maxTTL)})
 		}
+		ttl = maxTTL
+	}
+
+	autoExtend, err := parseAutoExtend(tmpl.AutoExtend)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid auto_extend: %w", err)
 	}
 
+	serviceNames, serviceSpecs := splitServices(tmpl.Services)
+
 	template := &models.Template{
 		Name:        tmpl.Name,
-		Description: tmpl.Description,
+		Description: tmpl.Description.Text,
 		BaseImage:   tmpl.BaseImage,
-		Services:    tmpl.Services,
+		Services:    serviceNames,
 		Resources:   tmpl.Resources,
 		Env:         tmpl.Env,
 		TTL:         ttl,
+		MaxTTL:      maxTTL,
 		Expose:      tmpl.Expose,
 		Volumes:     tmpl.Volumes,
 		Commands:    tmpl.Commands,
 		Labels:      tmpl.Labels,
+		Annotations: tmpl.Annotations,
+		AutoExtend:  autoExtend,
+
+		Command:              tmpl.Command,
+		Entrypoint:           tmpl.Entrypoint,
+		AllowCommandOverride: tmpl.AllowCommandOverride,
+
+		CredentialsDelivery: tmpl.CredentialsDelivery,
+		CredentialsFile:     tmpl.CredentialsFile,
+
+		Recording: tmpl.Recording,
+		Terminal:  tmpl.Terminal,
+
+		Tags:     tmpl.Tags,
+		Icon:     tmpl.Icon,
+		Language: tmpl.Language,
+		Enabled:  tmpl.Enabled,
+
+		Descriptions:   tmpl.Description.Locales,
+		ServiceSpecs:   serviceSpecs,
+		ServicesConfig: tmpl.ServicesConfig,
+	}
+	if len(resolved) > 0 {
+		template.Resolved = resolved
 	}
 
 	// Apply defaults
@@ -132,15 +637,85 @@ func (l *Loader) LoadFromFile(path string) error {
 	if template.Resources.MemoryLimit == "" {
 		template.Resources.MemoryLimit = "512m"
 	}
+	if template.CredentialsDelivery == "" {
+		template.CredentialsDelivery = models.CredentialsEnv
+	}
+	if template.CredentialsDelivery.InFile() {
+		template.CredentialsFile = withCredentialsFileDefaults(template.CredentialsFile)
+	}
 
-	l.mu.Lock()
-	l.templates[tmpl.Name] = template
-	l.mu.Unlock()
+	issues := append(ttlIssues, ValidatePorts(template.Expose)...)
+	issues = append(issues, ValidateCommand("command", template.Command)...)
+	issues = append(issues, ValidateCommand("entrypoint", template.Entrypoint)...)
+	if template.Terminal != nil {
+		issues = append(issues, ValidateCommand("terminal.shell", template.Terminal.Shell)...)
+	}
+	issues = append(issues, ValidateVolumes(template.Volumes)...)
+	issues = append(issues, ValidateServiceSpecs(template.Services, template.ServiceSpecs)...)
+	issues = append(issues, ValidateServicesConfig(template.Services, template.ServiceSpecs, template.ServicesConfig)...)
+	issues = append(issues, ValidateCredentials(template.CredentialsDelivery, template.CredentialsFile)...)
 
-	slog.Info("template loaded", "name", tmpl.Name, "image", tmpl.BaseImage)
+	return template, issues, nil
+}
+
+// firstError returns the first error-level issue as an error, nil without one
+func firstError(issues []Issue) error {
+	for _, issue := range issues {
+		if issue.Severity == SeverityError {
+			return errors.New(issue.String())
+		}
+	}
 	return nil
 }
 
+// withCredentialsFileDefaults fills the unset parts of a credentials file spec
+func withCredentialsFileDefaults(f *models.CredentialsFileSpec) *models.CredentialsFileSpec {
+	spec := models.CredentialsFileSpec{}
+	if f != nil {
+		spec = *f
+	}
+	if spec.Format == "" {
+		spec.Format = models.CredentialsFormatJSON
+	}
+	if spec.Path == "" {
+		spec.Path = "/run/sandbox/credentials." + spec.Format
+	}
+	if spec.User == "" {
+		spec.User = models.DefaultCredentialsUser
+	}
+	return &spec
+}
+
+// parseAutoExtend converts the YAML auto_extend section into a policy
+func parseAutoExtend(f *autoExtendFile) (*models.AutoExtendPolicy, error) {
+	if f == nil {
+		return nil, nil
+	}
+
+	policy := &models.AutoExtendPolicy{Enabled: f.Enabled}
+	fields := []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"window", f.Window, &policy.Window},
+		{"step", f.Step, &policy.Step},
+		{"max_ttl", f.MaxTTL, &policy.MaxTTL},
+	}
+	for _, field := range fields {
+		if field.value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(field.value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", field.name, err)
+		}
+		*field.dst = d
+	}
+
+	return policy, nil
+}
+
 // Get retrieves a template by name
 func (l *Loader) Get(name string) *models.Template {
 	l.mu.RLock()
@@ -148,7 +723,7 @@ func (l *Loader) Get(name string) *models.Template {
 	return l.templates[name]
 }
 
-// List returns all loaded templates
+// List returns all loaded templates, the same ones Get returns
 func (l *Loader) List() []*models.Template {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
@@ -160,6 +735,61 @@ func (l *Loader) List() []*models.Template {
 	return result
 }
 
+// IDs returns every ID Get resolves: template names and the IDs of the
+// catalog projects they are aliased under
+func (l *Loader) IDs() []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	ids := make([]string, 0, len(l.templates))
+	for id := range l.templates {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// skip records a template file the load leaves out
+func (l *Loader) skip(file string, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.skipped = append(l.skipped, SkippedFile{File: file, Error: err.Error()})
+}
+
+// addCatalogFile keeps the document of a loaded catalog file for ExportCatalog
+func (l *Loader) addCatalogFile(name string, data []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.catalogFiles[name] = data
+}
+
+// Skipped returns the template and catalog files the last load left out, in load order
+func (l *Loader) Skipped() []SkippedFile {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return append([]SkippedFile{}, l.skipped...)
+}
+
+// Conflicts returns the template names the last load found defined twice, in
+// load order
+func (l *Loader) Conflicts() []Conflict {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return append([]Conflict{}, l.conflicts...)
+}
+
+// Invalid returns the templates that failed validation, sorted by name
+func (l *Loader) Invalid() []*InvalidTemplate {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	result := make([]*InvalidTemplate, 0, len(l.invalid))
+	for _, t := range l.invalid {
+		result = append(result, t)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
 // Add programmatically adds a template
 func (l *Loader) Add(template *models.Template) {
 	l.mu.Lock()
@@ -172,6 +802,8 @@ func (l *Loader) Remove(name string) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	delete(l.templates, name)
+	delete(l.invalid, name)
+	delete(l.stored, name)
 }
 
 // --- Catalog accessors ---
@@ -261,6 +893,7 @@ func (l *Loader) loadCatalogFromDir(dir string) error {
 		domain, err := l.loadDomain(entry.Name(), domainDir)
 		if err != nil {
 			slog.Warn("failed to load domain", "dir", entry.Name(), "error", err)
+			l.skip(domainYaml, err)
 			continue
 		}
 
@@ -287,6 +920,7 @@ func (l *Loader) loadDomain(id string, dir string) (*models.Domain, error) {
 	if err := yaml.Unmarshal(data, &df); err != nil {
 		return nil, fmt.Errorf("failed to parse domain.yaml: %w", err)
 	}
+	l.addCatalogFile(id+"/domain.yaml", data)
 
 	domain := &models.Domain{
 		ID:          id,
@@ -315,6 +949,7 @@ func (l *Loader) loadDomain(id string, dir string) (*models.Domain, error) {
 		project, err := l.loadProject(id, entry.Name(), projectDir)
 		if err != nil {
 			slog.Warn("failed to load project", "domain", id, "project", entry.Name(), "error", err)
+			l.skip(templateYaml, err)
 			continue
 		}
 
@@ -334,29 +969,27 @@ func (l *Loader) loadProject(domainID, projectName, dir string) (*models.Catalog
 	templatePath := filepath.Join(dir, "template.yaml")
 
 	// Load the template using existing mechanism (registers under YAML name)
-	if err := l.LoadFromFile(templatePath); err != nil {
+	data, err := os.ReadFile(templatePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load template: failed to read file: %w", err)
+	}
+	tmpl, err := l.register(data, templatePath)
+	if err != nil {
 		return nil, fmt.Errorf("failed to load template: %w", err)
 	}
 
 	projectID := domainID + "/" + projectName
+	l.addCatalogFile(projectID+"/template.yaml", data)
 
-	// Read the YAML name to find the template in cache
-	data, _ := os.ReadFile(templatePath)
-	var tf templateFile
-	yaml.Unmarshal(data, &tf)
-
-	// Get the loaded template and register alias under projectID
-	name := projectName
-	description := ""
-
+	// Register alias so template is also accessible by projectID, unless a
+	// template is named like it
+	name := tmpl.Name
+	description := tmpl.Description
 	l.mu.Lock()
-	if tf.Name != "" {
-		if tmpl, ok := l.templates[tf.Name]; ok {
-			name = tmpl.Name
-			description = tmpl.Description
-			// Register alias so template is also accessible by projectID
-			l.templates[projectID] = tmpl
-		}
+	if prev, ok := l.files[projectID]; ok {
+		l.conflicts = append(l.conflicts, Conflict{Name: projectID, File: templatePath, ConflictsWith: prev})
+	} else {
+		l.templates[projectID] = tmpl
 	}
 	l.mu.Unlock()
 
@@ -404,6 +1037,7 @@ func (l *Loader) loadTasks(domainID, projectID, dir string) ([]*models.CatalogTa
 		task, err := l.loadTask(domainID, projectID, taskPath)
 		if err != nil {
 			slog.Warn("failed to load task", "file", entry.Name(), "error", err)
+			l.skip(taskPath, err)
 			continue
 		}
 
@@ -436,9 +1070,37 @@ func (l *Loader) loadTask(domainID, projectID, path string) (*models.CatalogTask
 		code = strings.TrimSuffix(base, filepath.Ext(base))
 	}
 
-	if tf.Title == "" {
+	l.mu.RLock()
+	locale := l.locale
+	l.mu.RUnlock()
+	title, titles := tf.Title.resolve(locale)
+	description, descriptions := tf.Description.resolve(locale)
+	if title == "" {
 		return nil, fmt.Errorf("task title is required")
 	}
+	if repo := tf.StarterRepo; repo != nil {
+		if repo.URL == "" {
+			return nil, fmt.Errorf("starter_repo.url is required")
+		}
+		if repo.Path == "" {
+			repo.Path = models.DefaultStarterPath
+		}
+		if !strings.HasPrefix(repo.Path, "/") {
+			return nil, fmt.Errorf("starter_repo.path must be absolute, got %q", repo.Path)
+		}
+	}
+
+	if g := tf.Grading; g != nil {
+		if g.Command == "" {
+			return nil, fmt.Errorf("grading.command is required")
+		}
+		if g.WorkingDir != "" && !strings.HasPrefix(g.WorkingDir, "/") {
+			return nil, fmt.Errorf("grading.working_dir must be absolute, got %q", g.WorkingDir)
+		}
+		if g.TimeoutSeconds < 0 {
+			return nil, fmt.Errorf("grading.timeout must not be negative")
+		}
+	}
 
 	taskID := projectID + "/" + code
 
@@ -447,17 +1109,24 @@ func (l *Loader) loadTask(domainID, projectID, path string) (*models.CatalogTask
 		requiredLevel = &tf.RequiredLevel
 	}
 
+	l.addCatalogFile(projectID+"/tasks/"+filepath.Base(path), data)
 	return &models.CatalogTask{
 		ID:            taskID,
 		Code:          code,
-		Title:         tf.Title,
-		Description:   tf.Description,
+		Title:         title,
+		Description:   description,
+		Titles:        titles,
+		Descriptions:  descriptions,
 		Difficulty:    tf.Difficulty,
 		RequiredLevel: requiredLevel,
 		TimeLimit:     tf.TimeLimit,
 		Skills:        tf.Skills,
 		DomainID:      domainID,
 		ProjectID:     projectID,
+		StarterRepo:   tf.StarterRepo,
+		SetupCommands: tf.SetupCommands,
+		Env:           tf.Env,
+		Grading:       tf.Grading,
 	}, nil
 }
 
@@ -466,16 +1135,45 @@ func (l *Loader) loadTask(domainID, projectID, path string) (*models.CatalogTask
 // templateFile represents the YAML structure of a template file
 type templateFile struct {
 	Name        string            `yaml:"name"`
-	Description string            `yaml:"description"`
+	Description localizedText     `yaml:"description"`
 	BaseImage   string            `yaml:"base_image"`
-	Services    []string          `yaml:"services"`
+	Services    []serviceEntry    `yaml:"services"`
 	Resources   models.Resources  `yaml:"resources"`
 	Env         map[string]string `yaml:"env"`
 	TTL         string            `yaml:"ttl"`
+	MaxTTL      string            `yaml:"max_ttl"`
 	Expose      []models.Port     `yaml:"expose"`
 	Volumes     []models.Volume   `yaml:"volumes"`
 	Commands    models.Commands   `yaml:"commands"`
 	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+	AutoExtend  *autoExtendFile   `yaml:"auto_extend"`
+
+	Command              []string `yaml:"command"`
+	Entrypoint           []string `yaml:"entrypoint"`
+	AllowCommandOverride bool     `yaml:"allow_command_override"`
+
+	CredentialsDelivery models.CredentialsDelivery  `yaml:"credentials_delivery"`
+	CredentialsFile     *models.CredentialsFileSpec `yaml:"credentials_file"`
+
+	Recording *models.RecordingPolicy `yaml:"recording"`
+	Terminal  *models.TerminalSpec    `yaml:"terminal"`
+
+	Tags     []string `yaml:"tags"`
+	Icon     string   `yaml:"icon"`
+	Language string   `yaml:"language"`
+	Enabled  *bool    `yaml:"enabled"`
+
+	// ServicesConfig holds per-service options, by service name
+	ServicesConfig map[string]models.ServiceConfig `yaml:"services_config"`
+}
+
+// autoExtendFile represents the auto_extend section of a template file
+type autoExtendFile struct {
+	Enabled *bool  `yaml:"enabled"`
+	Window  string `yaml:"window"`
+	Step    string `yaml:"step"`
+	MaxTTL  string `yaml:"max_ttl"`
 }
 
 // domainFile represents the YAML structure of a domain.yaml file
@@ -486,11 +1184,77 @@ type domainFile struct {
 
 // taskFile represents the YAML structure of a task YAML file
 type taskFile struct {
-	Code          string   `yaml:"code"`
-	Title         string   `yaml:"title"`
-	Description   string   `yaml:"description"`
-	Difficulty    string   `yaml:"difficulty"`
-	RequiredLevel string   `yaml:"required_level"`
-	TimeLimit     int      `yaml:"time_limit"`
-	Skills        []string `yaml:"skills"`
+	Code          string        `yaml:"code"`
+	Title         localizedText `yaml:"title"`
+	Description   localizedText `yaml:"description"`
+	Difficulty    string        `yaml:"difficulty"`
+	RequiredLevel string        `yaml:"required_level"`
+	TimeLimit     int           `yaml:"time_limit"`
+	Skills        []string      `yaml:"skills"`
+
+	StarterRepo   *models.StarterRepo `yaml:"starter_repo"`
+	SetupCommands []string            `yaml:"setup_commands"`
+	Env           map[string]string   `yaml:"env"`
+
+	Grading *models.GradingSpec `yaml:"grading"`
+}
+
+// serviceEntry is a service of a template, written either as its name or as
+// {name: postgres, mode: dedicated, version: "16"}
+type serviceEntry struct {
+	Name    string             `yaml:"name"`
+	Mode    models.ServiceMode `yaml:"mode"`
+	Version string             `yaml:"version"`
+}
+
+func (e *serviceEntry) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.MappingNode {
+		type plain serviceEntry
+		return node.Decode((*plain)(e))
+	}
+	return node.Decode(&e.Name)
+}
+
+// splitServices returns the names of a template's services and the specs of
+// those listed with a mode or version
+func splitServices(entries []serviceEntry) ([]string, map[string]models.ServiceSpec) {
+	if entries == nil {
+		return nil, nil
+	}
+	names := make([]string, 0, len(entries))
+	var specs map[string]models.ServiceSpec
+	for _, e := range entries {
+		names = append(names, e.Name)
+		if e.Mode == "" && e.Version == "" {
+			continue
+		}
+		if specs == nil {
+			specs = make(map[string]models.ServiceSpec)
+		}
+		specs[e.Name] = models.ServiceSpec{Mode: e.Mode, Version: e.Version}
+	}
+	return names, specs
+}
+
+// localizedText is a title or description written either as a string or as
+// a map of locale to string, like {en: Cart, ru: Корзина}
+type localizedText struct {
+	Text    string
+	Locales models.LocalizedText
+}
+
+func (t *localizedText) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.MappingNode {
+		return node.Decode(&t.Locales)
+	}
+	return node.Decode(&t.Text)
+}
+
+// resolve returns the text in locale, falling back to another locale when
+// it lacks one, and all locales; a plain string has none
+func (t localizedText) resolve(locale string) (string, models.LocalizedText) {
+	if t.Locales == nil {
+		return t.Text, nil
+	}
+	return t.Locales.Fallback(locale), t.Locales
 }
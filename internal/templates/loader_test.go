@@ -1,9 +1,16 @@
 package templates
 
 import (
+	"context"
+	"errors"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
 )
 
 func TestLoadCatalogFromDir(t *testing.T) {
@@ -20,6 +27,11 @@ func TestLoadCatalogFromDir(t *testing.T) {
 		t.Fatalf("LoadFromDir failed: %v", err)
 	}
 
+	// The shipped templates must all pass validation
+	for _, invalid := range loader.Invalid() {
+		t.Errorf("template %s is invalid: %s", invalid.Name, invalid.Reason)
+	}
+
 	// Check domains loaded
 	domains := loader.ListDomains()
 	if len(domains) < 2 {
@@ -108,3 +120,439 @@ func TestLoadCatalogFromDir(t *testing.T) {
 		t.Logf("  %s (%s): %d projects, %d tasks", d.ID, d.Name, d.ProjectsCount, d.TasksCount)
 	}
 }
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	write := func(path, doc string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, path)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, path), []byte(doc), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("kept.yaml", "name: kept\nbase_image: alpine\n")
+	write("edited.yaml", "name: edited\nbase_image: alpine\n")
+	write("removed.yaml", "name: removed\nbase_image: alpine\n")
+	write("shop/domain.yaml", "name: Shop\n")
+	write("shop/api/template.yaml", "name: shop-api\nbase_image: node:20\n")
+	write("shop/api/tasks/cart.yaml", "title: Cart\n")
+
+	loader := NewLoader()
+	if _, err := loader.Reload(); err != ErrNoTemplatesDir {
+		t.Fatalf("expected ErrNoTemplatesDir before a load, got %v", err)
+	}
+	if err := loader.LoadFromDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	before := loader.Get("kept")
+
+	write("edited.yaml", "name: edited\nbase_image: alpine:3.20\n")
+	write("added.yaml", "name: added\nbase_image: alpine\n")
+	write("shop/api/tasks/checkout.yaml", "title: Checkout\n")
+	if err := os.Remove(filepath.Join(dir, "removed.yaml")); err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := loader.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Changes{Added: []string{"added"}, Changed: []string{"edited"}, Removed: []string{"removed"}}
+	if !reflect.DeepEqual(summary.Templates, want) {
+		t.Errorf("expected template changes %+v, got %+v", want, summary.Templates)
+	}
+	if !reflect.DeepEqual(summary.Tasks.Added, []string{"shop/api/checkout"}) || len(summary.Tasks.Changed) != 0 {
+		t.Errorf("unexpected task changes %+v", summary.Tasks)
+	}
+	if !reflect.DeepEqual(summary.Projects.Changed, []string{"shop/api"}) || !reflect.DeepEqual(summary.Domains.Changed, []string{"shop"}) {
+		t.Errorf("expected the task counts to change the project and domain, got %+v %+v", summary.Projects, summary.Domains)
+	}
+
+	if loader.Get("removed") != nil {
+		t.Error("expected the removed template gone")
+	}
+	if loader.Get("edited").BaseImage != "alpine:3.20" || loader.Get("shop/api") == nil {
+		t.Error("expected the edited template and the project alias loaded")
+	}
+	if loader.Get("kept") == before {
+		t.Error("expected templates rebuilt rather than kept")
+	}
+	if len(loader.ListTasks("shop/api")) != 2 {
+		t.Errorf("expected 2 tasks, got %d", len(loader.ListTasks("shop/api")))
+	}
+
+	write("typo.yaml", "name: typo\nbase_image: alpine\nttl: 90m0\n")
+	summary, err = loader.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.Skipped) != 1 || summary.Skipped[0].File != filepath.Join(dir, "typo.yaml") || !strings.Contains(summary.Skipped[0].Error, "90m0") {
+		t.Errorf("expected the file with the bad ttl skipped, got %+v", summary.Skipped)
+	}
+	if loader.Get("typo") != nil || len(loader.Skipped()) != 1 {
+		t.Errorf("expected typo left out and reported, got %v", loader.Skipped())
+	}
+
+	if err := loader.LoadFromDir(filepath.Join(dir, "missing")); err == nil || loader.Get("kept") == nil {
+		t.Errorf("expected a missing directory to fail and keep the templates, got %v", err)
+	}
+}
+
+// flakySource serves its documents until err is set
+type flakySource struct {
+	docs []*models.TemplateDocument
+	err  error
+}
+
+func (s *flakySource) ListTemplateDocuments(ctx context.Context) ([]*models.TemplateDocument, error) {
+	return s.docs, s.err
+}
+
+func TestReloadDocumentSourceFailure(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file.yaml"), []byte("name: file\nbase_image: alpine\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	source := &flakySource{err: errors.New("connection refused")}
+	loader := NewLoader()
+	loader.SetDocumentSource(source)
+
+	if err := loader.LoadFromDir(dir); err != nil {
+		t.Fatalf("expected a failing source not to fail the load, got %v", err)
+	}
+	if loader.Get("file") == nil {
+		t.Fatal("expected the directory templates loaded without the source")
+	}
+
+	source.docs, source.err = []*models.TemplateDocument{{Name: "api", Document: "name: api\nbase_image: alpine\n"}}, nil
+	if _, err := loader.Reload(); err != nil || loader.Get("api") == nil {
+		t.Fatalf("expected the stored template once the source is back, got %v", err)
+	}
+
+	source.err = errors.New("connection refused")
+	if _, err := loader.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if loader.Get("api") == nil || !loader.IsStored("api") || loader.Get("file") == nil {
+		t.Error("expected a failing source to keep the stored templates of the last load")
+	}
+}
+
+func TestDuplicateTemplateNames(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a-python.yaml":          "name: python-base\nbase_image: python:3.11\n",
+		"b-python.yaml":          "name: python-base\nbase_image: python:3.12\n",
+		"legacy/shop-api.yaml":   "name: shop/api\nbase_image: node:18\n",
+		"shop/domain.yaml":       "name: Shop\n",
+		"shop/api/template.yaml": "name: shop-api\nbase_image: node:20\n",
+	}
+	for name, doc := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(doc), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	loader := NewLoader()
+	summary, err := loader.load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Conflict{
+		{Name: "python-base", File: filepath.Join(dir, "b-python.yaml"), ConflictsWith: filepath.Join(dir, "a-python.yaml")},
+		{Name: "shop/api", File: filepath.Join(dir, "shop/api/template.yaml"), ConflictsWith: filepath.Join(dir, "legacy/shop-api.yaml")},
+	}
+	if !reflect.DeepEqual(summary.Conflicts, want) || !reflect.DeepEqual(loader.Conflicts(), want) {
+		t.Errorf("expected conflicts %+v, got %+v", want, summary.Conflicts)
+	}
+	if len(summary.Skipped) != 1 || summary.Skipped[0].File != filepath.Join(dir, "b-python.yaml") {
+		t.Errorf("expected the second definition skipped, got %+v", summary.Skipped)
+	}
+	if tmpl := loader.Get("python-base"); tmpl == nil || tmpl.BaseImage != "python:3.11" {
+		t.Errorf("expected the first definition loaded, got %+v", tmpl)
+	}
+	if tmpl := loader.Get("shop/api"); tmpl == nil || tmpl.BaseImage != "node:18" {
+		t.Errorf("expected the flat template kept over the project alias, got %+v", tmpl)
+	}
+	if loader.Get("shop-api") == nil || loader.GetProject("shop/api") == nil {
+		t.Error("expected the project loaded under its own name")
+	}
+
+	// Reloading the same files is no conflict
+	if err := loader.LoadFromFile(filepath.Join(dir, "a-python.yaml")); err != nil {
+		t.Errorf("expected a file to reload its own template, got %v", err)
+	}
+}
+
+func TestServiceSeeds(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"orders.yaml": `name: orders
+base_image: postgres-client
+services: [postgres, {name: redis, mode: dedicated}]
+services_config:
+  postgres: {seed: fixtures/orders.sql}
+  redis: {seed: fixtures/cache.redis}
+`,
+		"fixtures/orders.sql":  "CREATE TABLE orders (id int);",
+		"fixtures/cache.redis": "SET {prefix}k v",
+		"shop/domain.yaml":     "name: Shop\n",
+		"shop/api/template.yaml": `name: shop-api
+base_image: node:20
+services: [postgres]
+services_config:
+  postgres: {seed: seed.sql}
+`,
+		"shop/api/seed.sql": "SELECT 1;",
+		"missing.yaml": `name: missing
+base_image: alpine
+services: [postgres]
+services_config: {postgres: {seed: nope.sql}}
+`,
+		"absolute.yaml": `name: absolute
+base_image: alpine
+services: [postgres]
+services_config: {postgres: {seed: /etc/passwd}}
+`,
+		"directory.yaml": `name: directory
+base_image: alpine
+services: [postgres]
+services_config: {postgres: {seed: fixtures}}
+`,
+		"traversal.yaml": `name: traversal
+base_image: alpine
+services: [postgres]
+services_config: {postgres: {seed: fixtures/../../outside.sql}}
+`,
+		"symlink.yaml": `name: symlink
+base_image: alpine
+services: [postgres]
+services_config: {postgres: {seed: fixtures/link.sql}}
+`,
+		"unlisted.yaml": `name: unlisted
+base_image: alpine
+services: [postgres]
+services_config: {redis: {seed: fixtures/cache.redis}}
+`,
+		"unseedable.yaml": `name: unseedable
+base_image: alpine
+services: [minio]
+services_config: {minio: {seed: fixtures/orders.sql}}
+`,
+	})
+
+	outside := filepath.Join(t.TempDir(), "outside.sql")
+	if err := os.WriteFile(outside, []byte("SELECT 1;"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "fixtures", "link.sql")); err != nil {
+		t.Fatal(err)
+	}
+
+	loader := NewLoader()
+	if _, err := loader.load(dir); err != nil {
+		t.Fatal(err)
+	}
+	tmpl := loader.Get("orders")
+	if tmpl == nil {
+		t.Fatal("expected orders loaded")
+	}
+	want := map[string]models.ServiceConfig{
+		"postgres": {Seed: "fixtures/orders.sql", SeedPath: filepath.Join(dir, "fixtures/orders.sql")},
+		"redis":    {Seed: "fixtures/cache.redis", SeedPath: filepath.Join(dir, "fixtures/cache.redis")},
+	}
+	if !reflect.DeepEqual(tmpl.ServicesConfig, want) {
+		t.Errorf("expected seeds resolved next to the template, got %+v", tmpl.ServicesConfig)
+	}
+	if tmpl := loader.Get("shop-api"); tmpl == nil || tmpl.ServicesConfig["postgres"].SeedPath != filepath.Join(dir, "shop/api/seed.sql") {
+		t.Errorf("expected a project seed resolved in the project directory, got %+v", tmpl)
+	}
+
+	invalid := map[string]string{}
+	for _, it := range loader.Invalid() {
+		invalid[it.Name] = it.Reason
+	}
+	for name, reason := range map[string]string{
+		"missing":    "seed file nope.sql not found",
+		"absolute":   "must be relative",
+		"directory":  "is not a file",
+		"traversal":  "must be relative to and inside",
+		"symlink":    "links outside the template's directory",
+		"unlisted":   "redis is not one of the template's services",
+		"unseedable": "minio can't be seeded",
+	} {
+		if !strings.Contains(invalid[name], reason) {
+			t.Errorf("%s: expected invalid with %q, got %q", name, reason, invalid[name])
+		}
+	}
+
+	// A stored document has no directory to read seeds from
+	stored := NewLoader()
+	if _, err := stored.register([]byte("name: stored\nbase_image: alpine\nservices: [postgres]\nservices_config: {postgres: {seed: x.sql}}\n"), ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := stored.Invalid(); len(got) != 1 || !strings.Contains(got[0].Reason, "loaded from a directory") {
+		t.Errorf("expected the stored template invalid, got %+v", got)
+	}
+}
+
+func TestLocalizedText(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"shop/domain.yaml": "name: Shop\n",
+		"shop/api/template.yaml": `name: shop-api
+base_image: node:20
+description:
+  en: Online shop API
+  ru: API интернет-магазина
+`,
+		"shop/api/tasks/cart.yaml": `title:
+  en: Cart
+  ru: Корзина
+description: Add items to the cart
+`,
+		"shop/api/tasks/untitled.yaml": "title:\n  en: \"\"\n",
+	}
+	for name, doc := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(doc), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	loader := NewLoader()
+	if err := loader.LoadFromDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	task := loader.GetTask("shop/api/cart")
+	if task == nil {
+		t.Fatal("expected the cart task loaded")
+	}
+	if task.Title != "Cart" || !reflect.DeepEqual(task.Titles, models.LocalizedText{"en": "Cart", "ru": "Корзина"}) {
+		t.Errorf("unexpected titles %q %v", task.Title, task.Titles)
+	}
+	if task.Description != "Add items to the cart" || task.Descriptions != nil {
+		t.Errorf("expected a plain description kept as is, got %q %v", task.Description, task.Descriptions)
+	}
+	if tmpl := loader.Get("shop-api"); tmpl.Description != "Online shop API" || tmpl.Descriptions["ru"] != "API интернет-магазина" {
+		t.Errorf("unexpected template description %q %v", tmpl.Description, tmpl.Descriptions)
+	}
+	if loader.GetTask("shop/api/untitled") != nil {
+		t.Error("expected a task with an empty title map to fail")
+	}
+
+	// The default locale picks the plain text, falling back to the first
+	// locale by name when a text lacks it
+	loader.SetDefaultLocale("ru")
+	if _, err := loader.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if task := loader.GetTask("shop/api/cart"); task.Title != "Корзина" {
+		t.Errorf("expected the ru title, got %q", task.Title)
+	}
+	loader.SetDefaultLocale("de")
+	if _, err := loader.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if tmpl := loader.Get("shop-api"); tmpl.Description != "Online shop API" {
+		t.Errorf("expected the en description as the fallback, got %q", tmpl.Description)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.yaml"), []byte("name: a\nbase_image: alpine\n"), 0o644)
+
+	loader := NewLoader()
+	if err := loader.LoadFromDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	reloaded := make(chan struct{}, 1)
+	loader.OnLoad(func() { reloaded <- struct{}{} })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go loader.Watch(ctx, 10*time.Millisecond)
+
+	// Written elsewhere and renamed in, so a check never sees half a file
+	time.Sleep(30 * time.Millisecond)
+	tmp := filepath.Join(t.TempDir(), "b.yaml")
+	if err := os.WriteFile(tmp, []byte("name: b\nbase_image: alpine\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, "b.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-reloaded:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected a reload after a new file")
+	}
+	if loader.Get("b") == nil {
+		t.Error("expected the new template loaded")
+	}
+}
+
+func TestTaskSetup(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"shop/domain.yaml":       "name: Shop\n",
+		"shop/api/template.yaml": "name: shop-api\nbase_image: node:20\n",
+		"shop/api/tasks/cart.yaml": `title: Cart
+starter_repo:
+  url: https://git.example.com/shop/cart.git
+  ref: v1
+setup_commands:
+  - npm ci
+env:
+  CART_MODE: exercise
+grading:
+  command: npm test -- --reporter junit
+  timeout: 300
+  junit_path: reports/junit.xml
+`,
+		"shop/api/tasks/relative.yaml": "title: Relative\nstarter_repo:\n  url: https://git.example.com/x.git\n  path: work\n",
+		"shop/api/tasks/ungraded.yaml": "title: Ungraded\ngrading:\n  junit_path: junit.xml\n",
+	}
+	for name, doc := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(doc), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	loader := NewLoader()
+	if err := loader.LoadFromDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	task := loader.GetTask("shop/api/cart")
+	if task == nil {
+		t.Fatal("expected the cart task loaded")
+	}
+	want := &models.StarterRepo{URL: "https://git.example.com/shop/cart.git", Ref: "v1", Path: models.DefaultStarterPath}
+	if !reflect.DeepEqual(task.StarterRepo, want) || !reflect.DeepEqual(task.SetupCommands, []string{"npm ci"}) || task.Env["CART_MODE"] != "exercise" {
+		t.Errorf("unexpected task setup %+v %v %v", task.StarterRepo, task.SetupCommands, task.Env)
+	}
+	grading := &models.GradingSpec{Command: "npm test -- --reporter junit", TimeoutSeconds: 300, JUnitPath: "reports/junit.xml"}
+	if !reflect.DeepEqual(task.Grading, grading) {
+		t.Errorf("unexpected grading %+v", task.Grading)
+	}
+	if loader.GetTask("shop/api/relative") != nil {
+		t.Error("expected a relative starter path to fail the task")
+	}
+	if loader.GetTask("shop/api/ungraded") != nil {
+		t.Error("expected grading without a command to fail the task")
+	}
+}
@@ -0,0 +1,128 @@
+package templates
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+// ReloadSummary is what a load changed, by template name or catalog ID
+type ReloadSummary struct {
+	Templates Changes `json:"templates"`
+	Domains   Changes `json:"domains"`
+	Projects  Changes `json:"projects"`
+	Tasks     Changes `json:"tasks"`
+	// Skipped are the template and catalog files that could not be loaded
+	Skipped []SkippedFile `json:"skipped"`
+	// Conflicts are the template names defined twice; see Conflict
+	Conflicts []Conflict `json:"conflicts"`
+}
+
+// Changes are the entries a load added, changed and removed, each sorted
+type Changes struct {
+	Added   []string `json:"added"`
+	Changed []string `json:"changed"`
+	Removed []string `json:"removed"`
+}
+
+// templateEntries drops the aliases catalog projects register templates under,
+// leaving each template once under its name
+func templateEntries(templates map[string]*models.Template) map[string]*models.Template {
+	entries := make(map[string]*models.Template, len(templates))
+	for key, tmpl := range templates {
+		if key == tmpl.Name {
+			entries[key] = tmpl
+		}
+	}
+	return entries
+}
+
+// diffEntries compares two generations of entries by key and value
+func diffEntries[T any](old, cur map[string]*T) Changes {
+	c := Changes{Added: []string{}, Changed: []string{}, Removed: []string{}}
+	for key, v := range cur {
+		prev, ok := old[key]
+		switch {
+		case !ok:
+			c.Added = append(c.Added, key)
+		case !reflect.DeepEqual(prev, v):
+			c.Changed = append(c.Changed, key)
+		}
+	}
+	for key := range old {
+		if _, ok := cur[key]; !ok {
+			c.Removed = append(c.Removed, key)
+		}
+	}
+	sort.Strings(c.Added)
+	sort.Strings(c.Changed)
+	sort.Strings(c.Removed)
+	return c
+}
+
+// Watch reloads the last directory loaded whenever a file in it changes,
+// checking every interval until ctx is done. Changes are noticed by file size
+// and modification time, and reloaded once the directory is unchanged for a
+// check, so a file still being written or an editor saving twice reloads once.
+func (l *Loader) Watch(ctx context.Context, interval time.Duration) {
+	l.mu.RLock()
+	dir := l.dir
+	l.mu.RUnlock()
+	if dir == "" {
+		return
+	}
+
+	last := fingerprint(dir)
+	changed := false
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// Wait for a check without changes before reloading
+		if current := fingerprint(dir); current != last {
+			last, changed = current, true
+			continue
+		}
+		if !changed {
+			continue
+		}
+		changed = false
+
+		summary, err := l.Reload()
+		if err != nil {
+			slog.Warn("template reload failed", "dir", dir, "error", err)
+			continue
+		}
+		slog.Info("templates reloaded after a change",
+			"templates", summary.Templates, "domains", summary.Domains,
+			"projects", summary.Projects, "tasks", summary.Tasks)
+	}
+}
+
+// fingerprint summarizes the files under dir by path, size and modification time
+func fingerprint(dir string) string {
+	var b strings.Builder
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			fmt.Fprintf(&b, "%s %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+		}
+		return nil
+	})
+	return b.String()
+}
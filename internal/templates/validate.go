@@ -0,0 +1,613 @@
+package templates
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+// Issue severities
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Issue is a single template validation finding
+type Issue struct {
+	Field      string `json:"field"`
+	Severity   string `json:"severity"`
+	Message    string `json:"message"`
+	Suggestion string `json:"suggestion,omitempty"`
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("%s: %s", i.Field, i.Message)
+}
+
+// HasErrors reports whether any issue is an error
+func HasErrors(issues []Issue) bool {
+	for _, i := range issues {
+		if i.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// portNameRe restricts port names to DNS label characters: they become part of
+// the Traefik router name and the public hostname
+var portNameRe = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// httpProtocols are the protocols Traefik HTTP routers can serve
+var httpProtocols = map[string]bool{"tcp": true}
+
+// ValidatePorts checks a template's exposed ports: unique names and container
+// ports, a known protocol, ports in range, and public ports that Traefik can route.
+// Empty protocols are normalized to tcp in place.
+func ValidatePorts(ports []models.Port) []Issue {
+	var issues []Issue
+
+	names := make(map[string]int)
+	containerPorts := make(map[string]int)
+
+	// -1 marks the sandbox's main router
+	routers := map[string]int{RouterName("{id}", ""): -1}
+
+	for i := range ports {
+		p := &ports[i]
+		field := fmt.Sprintf("expose[%d]", i)
+
+		if p.Protocol == "" {
+			p.Protocol = "tcp"
+		}
+		p.Protocol = strings.ToLower(p.Protocol)
+		if p.Protocol != "tcp" && p.Protocol != "udp" {
+			issues = append(issues, Issue{
+				Field:    field + ".protocol",
+				Severity: SeverityError,
+				Message:  fmt.Sprintf("protocol %q must be tcp or udp", p.Protocol),
+			})
+		}
+
+		if p.Container < 1 || p.Container > 65535 {
+			issues = append(issues, Issue{
+				Field:    field + ".container",
+				Severity: SeverityError,
+				Message:  fmt.Sprintf("container port %d is out of range 1-65535", p.Container),
+			})
+		} else {
+			key := fmt.Sprintf("%d/%s", p.Container, p.Protocol)
+			if prev, ok := containerPorts[key]; ok {
+				issues = append(issues, Issue{
+					Field:    field + ".container",
+					Severity: SeverityError,
+					Message:  fmt.Sprintf("container port %s is already exposed by expose[%d]", key, prev),
+				})
+			} else {
+				containerPorts[key] = i
+			}
+		}
+
+		switch {
+		case p.Name == "":
+			issues = append(issues, Issue{
+				Field:      field + ".name",
+				Severity:   SeverityError,
+				Message:    "port name is required",
+				Suggestion: suggestPortName(fmt.Sprintf("port-%d", p.Container), names),
+			})
+		case !portNameRe.MatchString(p.Name):
+			issues = append(issues, Issue{
+				Field:      field + ".name",
+				Severity:   SeverityError,
+				Message:    fmt.Sprintf("port name %q must be lowercase letters, digits and dashes", p.Name),
+				Suggestion: suggestPortName(sanitizePortName(p.Name), names),
+			})
+		default:
+			if prev, ok := names[p.Name]; ok {
+				issues = append(issues, Issue{
+					Field:      field + ".name",
+					Severity:   SeverityError,
+					Message:    fmt.Sprintf("port name %q is already used by expose[%d]", p.Name, prev),
+					Suggestion: suggestPortName(p.Name, names),
+				})
+			} else {
+				names[p.Name] = i
+			}
+		}
+
+		if p.Public {
+			if !httpProtocols[p.Protocol] {
+				issues = append(issues, Issue{
+					Field:    field + ".public",
+					Severity: SeverityWarning,
+					Message:  fmt.Sprintf("public port uses %s, Traefik only routes HTTP over tcp", p.Protocol),
+				})
+			}
+
+			// Guard against two ports rendering the same router, which Traefik
+			// resolves silently by dropping one of them
+			router := RouterName("{id}", p.Name)
+			if prev, ok := routers[router]; ok {
+				other := fmt.Sprintf("expose[%d]", prev)
+				if prev < 0 {
+					other = "the main sandbox router"
+				}
+				issues = append(issues, Issue{
+					Field:    field + ".name",
+					Severity: SeverityError,
+					Message:  fmt.Sprintf("router %q collides with %s", router, other),
+				})
+			} else {
+				routers[router] = i
+			}
+		}
+	}
+
+	return issues
+}
+
+// RouterName returns the Traefik router name for a sandbox port.
+// An empty port name is the sandbox's main router.
+// Sandbox IDs have a fixed length, so different sandboxes can never render the same name;
+// only ports within one template can collide, which ValidatePorts rejects.
+func RouterName(sandboxID, portName string) string {
+	if portName == "" {
+		return "sandbox-" + sandboxID
+	}
+	return strings.ToLower(fmt.Sprintf("sandbox-%s-%s", sandboxID, portName))
+}
+
+// sanitizePortName turns an arbitrary name into a valid port name
+func sanitizePortName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('-')
+		}
+	}
+	out := strings.Trim(b.String(), "-")
+	if out == "" {
+		return "port"
+	}
+	return out
+}
+
+// suggestPortName returns base, or base-N for the first N that isn't taken
+func suggestPortName(base string, taken map[string]int) string {
+	if _, ok := taken[base]; !ok {
+		return base
+	}
+	for n := 2; ; n++ {
+		candidate := fmt.Sprintf("%s-%d", base, n)
+		if _, ok := taken[candidate]; !ok {
+			return candidate
+		}
+	}
+}
+
+// ValidateCommand checks a command or entrypoint: when given (non-nil) it
+// must have at least one argument and no empty arguments
+func ValidateCommand(field string, args []string) []Issue {
+	if args == nil {
+		return nil
+	}
+	if len(args) == 0 {
+		return []Issue{{
+			Field:    field,
+			Severity: SeverityError,
+			Message:  "must not be an empty list; omit it to use the image default",
+		}}
+	}
+
+	var issues []Issue
+	for i, arg := range args {
+		if strings.TrimSpace(arg) == "" {
+			issues = append(issues, Issue{
+				Field:    fmt.Sprintf("%s[%d]", field, i),
+				Severity: SeverityError,
+				Message:  "must not be empty",
+			})
+		}
+	}
+	return issues
+}
+
+// ValidateResources checks resource fields parse: CPUs as a positive number,
+// memory and disk as sizes like 512m, and the GPU count
+func ValidateResources(r models.Resources) []Issue {
+	var issues []Issue
+	for _, f := range []struct{ field, value string }{
+		{"resources.cpu_limit", r.CPULimit},
+		{"resources.cpu_request", r.CPURequest},
+	} {
+		if n, err := strconv.ParseFloat(f.value, 64); f.value != "" && (err != nil || n <= 0) {
+			issues = append(issues, Issue{
+				Field:    f.field,
+				Severity: SeverityError,
+				Message:  fmt.Sprintf("%q must be a positive number of CPUs", f.value),
+			})
+		}
+	}
+	for _, f := range []struct{ field, value string }{
+		{"resources.memory_limit", r.MemoryLimit},
+		{"resources.memory_request", r.MemoryRequest},
+		{"resources.disk_limit", r.DiskLimit},
+	} {
+		if n, err := ParseSize(f.value); f.value != "" && (err != nil || n <= 0) {
+			issues = append(issues, Issue{
+				Field:    f.field,
+				Severity: SeverityError,
+				Message:  fmt.Sprintf("%q must be a size like 512m or 2g", f.value),
+			})
+		}
+	}
+	if _, err := r.GPUCount(); err != nil {
+		issues = append(issues, Issue{
+			Field:    "resources.gpus",
+			Severity: SeverityError,
+			Message:  err.Error(),
+		})
+	}
+	return issues
+}
+
+// sizeUnits are the binary multiples of the size suffixes
+var sizeUnits = map[byte]float64{'k': 1 << 10, 'm': 1 << 20, 'g': 1 << 30, 't': 1 << 40, 'p': 1 << 50}
+
+// ParseSize parses a size in bytes like 512m, 2g or 1.5gb, accepting
+// Kubernetes-style binary suffixes such as 4Gi as well; suffixes are
+// case-insensitive and multiples of 1024
+func ParseSize(s string) (int64, error) {
+	num := strings.TrimSuffix(strings.ToLower(s), "b")
+	multiple := 1.0
+	if trimmed := strings.TrimSuffix(num, "i"); trimmed != "" {
+		if unit, ok := sizeUnits[trimmed[len(trimmed)-1]]; ok {
+			num, multiple = trimmed[:len(trimmed)-1], unit
+		}
+	}
+	if num == "" || strings.Trim(num, "0123456789.") != "" {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * multiple), nil
+}
+
+// ParseTTL parses a template TTL, falling back to def when it is empty or
+// invalid; an invalid TTL is reported as an issue. A bare number is minutes,
+// as older templates wrote it, with a deprecation warning.
+func ParseTTL(s string, def time.Duration) (time.Duration, []Issue) {
+	if s == "" {
+		return def, nil
+	}
+	if n, err := strconv.Atoi(s); err == nil && n > 0 {
+		return time.Duration(n) * time.Minute, []Issue{{
+			Field:    "ttl",
+			Severity: SeverityWarning,
+			Message:  fmt.Sprintf("ttl %d without a unit is read as minutes; this is deprecated, write %dm", n, n),
+		}}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return def, []Issue{{
+			Field:    "ttl",
+			Severity: SeverityError,
+			Message:  fmt.Sprintf("%q must be a positive duration like 30m or 2h", s),
+		}}
+	}
+	return d, nil
+}
+
+// ParseMaxTTL parses the max_ttl of a template; an empty one is no cap
+func ParseMaxTTL(s string) (time.Duration, []Issue) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, []Issue{{
+			Field:    "max_ttl",
+			Severity: SeverityError,
+			Message:  fmt.Sprintf("%q must be a positive duration like 8h", s),
+		}}
+	}
+	return d, nil
+}
+
+// ValidateVolumes checks volumes mount at distinct absolute paths
+func ValidateVolumes(volumes []models.Volume) []Issue {
+	var issues []Issue
+	mounts := make(map[string]int)
+	for i, v := range volumes {
+		field := fmt.Sprintf("volumes[%d].mount_path", i)
+		if !path.IsAbs(v.MountPath) {
+			issues = append(issues, Issue{
+				Field:    field,
+				Severity: SeverityError,
+				Message:  fmt.Sprintf("mount path %q must be absolute", v.MountPath),
+			})
+			continue
+		}
+		mount := path.Clean(v.MountPath)
+		if prev, ok := mounts[mount]; ok {
+			issues = append(issues, Issue{
+				Field:    field,
+				Severity: SeverityError,
+				Message:  fmt.Sprintf("mount path %s is already used by volumes[%d]", mount, prev),
+			})
+			continue
+		}
+		mounts[mount] = i
+	}
+	return issues
+}
+
+// imageTagRe is a valid Docker image tag
+var imageTagRe = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+
+// ValidateServiceSpecs checks the mode and version of the services listed
+// with one: only the services of models.DedicatedServiceImages run
+// dedicated, and only a dedicated one takes a version
+func ValidateServiceSpecs(names []string, specs map[string]models.ServiceSpec) []Issue {
+	var issues []Issue
+	for i, name := range names {
+		spec, ok := specs[name]
+		if !ok {
+			continue
+		}
+		field := fmt.Sprintf("services[%d]", i)
+		switch spec.Mode {
+		case "", models.ServiceShared:
+			if spec.Version != "" {
+				issues = append(issues, Issue{
+					Field:      field + ".version",
+					Severity:   SeverityError,
+					Message:    fmt.Sprintf("a shared %s runs the engine's version", name),
+					Suggestion: "set mode to dedicated",
+				})
+			}
+		case models.ServiceDedicated:
+			if _, ok := models.DedicatedServiceImages[name]; !ok {
+				issues = append(issues, Issue{
+					Field:    field + ".mode",
+					Severity: SeverityError,
+					Message:  fmt.Sprintf("%s can't run dedicated (available: %s)", name, strings.Join(dedicatedServiceNames(), ", ")),
+				})
+			}
+			if spec.Version != "" && !imageTagRe.MatchString(spec.Version) {
+				issues = append(issues, Issue{
+					Field:    field + ".version",
+					Severity: SeverityError,
+					Message:  fmt.Sprintf("version %q is not an image tag", spec.Version),
+				})
+			}
+		default:
+			issues = append(issues, Issue{
+				Field:    field + ".mode",
+				Severity: SeverityError,
+				Message:  fmt.Sprintf("unknown mode %q (available: shared, dedicated)", spec.Mode),
+			})
+		}
+	}
+	return issues
+}
+
+// seedableServices are the services whose seed files the engine can load
+var seedableServices = []string{"postgres", "redis"}
+
+// ValidateServicesConfig checks that per-service options name services the
+// template lists, that seeds are only set where they can be loaded and
+// provider options only on shared services. The options themselves are
+// checked by ServiceOptionsValidator.
+func ValidateServicesConfig(names []string, specs map[string]models.ServiceSpec, config map[string]models.ServiceConfig) []Issue {
+	keys := make([]string, 0, len(config))
+	for name := range config {
+		keys = append(keys, name)
+	}
+	slices.Sort(keys)
+
+	var issues []Issue
+	for _, name := range keys {
+		field := "services_config." + name
+		if !slices.Contains(names, name) {
+			issues = append(issues, Issue{
+				Field:      field,
+				Severity:   SeverityError,
+				Message:    fmt.Sprintf("%s is not one of the template's services", name),
+				Suggestion: "add it to services",
+			})
+			continue
+		}
+		if config[name].Seed != "" && !slices.Contains(seedableServices, name) {
+			issues = append(issues, Issue{
+				Field:    field + ".seed",
+				Severity: SeverityError,
+				Message:  fmt.Sprintf("%s can't be seeded (available: %s)", name, strings.Join(seedableServices, ", ")),
+			})
+		}
+		if len(config[name].Options) > 0 && specs[name].Mode == models.ServiceDedicated {
+			issues = append(issues, Issue{
+				Field:      field + ".options",
+				Severity:   SeverityError,
+				Message:    fmt.Sprintf("options apply to shared services; a dedicated %s is configured by its superuser", name),
+				Suggestion: "use a seed",
+			})
+		}
+	}
+	return issues
+}
+
+// dedicatedServiceNames returns the services that can run dedicated, sorted
+func dedicatedServiceNames() []string {
+	names := make([]string, 0, len(models.DedicatedServiceImages))
+	for name := range models.DedicatedServiceImages {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// ValidateCredentials checks the credentials delivery mode and, when
+// credentials go to a file, where that file is written
+func ValidateCredentials(delivery models.CredentialsDelivery, file *models.CredentialsFileSpec) []Issue {
+	switch delivery {
+	case models.CredentialsEnv:
+		if file != nil {
+			return []Issue{{
+				Field:      "credentials_file",
+				Severity:   SeverityWarning,
+				Message:    "ignored with credentials_delivery env",
+				Suggestion: "set credentials_delivery to file or both",
+			}}
+		}
+		return nil
+	case models.CredentialsFile, models.CredentialsBoth:
+	default:
+		return []Issue{{
+			Field:    "credentials_delivery",
+			Severity: SeverityError,
+			Message:  fmt.Sprintf("credentials_delivery %q must be env, file or both", delivery),
+		}}
+	}
+
+	if file == nil {
+		return []Issue{{
+			Field:    "credentials_file",
+			Severity: SeverityError,
+			Message:  fmt.Sprintf("required with credentials_delivery %s", delivery),
+		}}
+	}
+
+	var issues []Issue
+	if !path.IsAbs(file.Path) || path.Clean(file.Path) == "/" || strings.HasSuffix(file.Path, "/") {
+		issues = append(issues, Issue{
+			Field:    "credentials_file.path",
+			Severity: SeverityError,
+			Message:  fmt.Sprintf("path %q must be an absolute file path", file.Path),
+		})
+	}
+	if file.Format != models.CredentialsFormatJSON && file.Format != models.CredentialsFormatINI {
+		issues = append(issues, Issue{
+			Field:    "credentials_file.format",
+			Severity: SeverityError,
+			Message:  fmt.Sprintf("format %q must be json or ini", file.Format),
+		})
+	}
+	if _, _, err := file.Owner(); err != nil {
+		issues = append(issues, Issue{
+			Field:    "credentials_file.user",
+			Severity: SeverityError,
+			Message:  err.Error(),
+		})
+	}
+	return issues
+}
+
+// Validator checks a template against the environment it runs in, such as the
+// registered service providers, beyond what the document itself tells
+type Validator interface {
+	ValidateTemplate(tmpl *models.Template) []Issue
+}
+
+// ValidatorFunc adapts a function to a Validator
+type ValidatorFunc func(tmpl *models.Template) []Issue
+
+// ValidateTemplate calls f(tmpl)
+func (f ValidatorFunc) ValidateTemplate(tmpl *models.Template) []Issue {
+	return f(tmpl)
+}
+
+// ServiceValidator reports shared services that none of the providers listed
+// by providers (e.g. services.Registry.List) can provision
+func ServiceValidator(providers func() []string) Validator {
+	return ValidatorFunc(func(tmpl *models.Template) []Issue {
+		known := providers()
+		slices.Sort(known)
+
+		var issues []Issue
+		for i, name := range tmpl.Services {
+			// A dedicated service runs in a container, not on a provider
+			if slices.Contains(known, name) || tmpl.ServiceSpec(name).Mode == models.ServiceDedicated {
+				continue
+			}
+			available := strings.Join(known, ", ")
+			if available == "" {
+				available = "none"
+			}
+			issues = append(issues, Issue{
+				Field:      fmt.Sprintf("services[%d]", i),
+				Severity:   SeverityError,
+				Message:    fmt.Sprintf("unknown service %q (available: %s)", name, available),
+				Suggestion: closest(name, known),
+			})
+		}
+		return issues
+	})
+}
+
+// ServiceOptionsValidator reports the provider options of shared services
+// that validate (e.g. services.Registry.ValidateOptions) rejects
+func ServiceOptionsValidator(validate func(service string, opts map[string]string) error) Validator {
+	return ValidatorFunc(func(tmpl *models.Template) []Issue {
+		var issues []Issue
+		for _, name := range tmpl.Services {
+			opts := tmpl.ServicesConfig[name].Options
+			if len(opts) == 0 || tmpl.ServiceSpec(name).Mode == models.ServiceDedicated {
+				continue
+			}
+			if err := validate(name, opts); err != nil {
+				issues = append(issues, Issue{
+					Field:    "services_config." + name + ".options",
+					Severity: SeverityError,
+					Message:  err.Error(),
+				})
+			}
+		}
+		return issues
+	})
+}
+
+// closest returns the candidate within two edits of name, if any, to suggest
+// for a typo
+func closest(name string, candidates []string) string {
+	best, bestDist := "", 3
+	for _, c := range candidates {
+		if d := editDistance(name, c); d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
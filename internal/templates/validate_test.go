@@ -0,0 +1,533 @@
+package templates
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+func TestValidatePorts(t *testing.T) {
+	tests := []struct {
+		name       string
+		ports      []models.Port
+		field      string // field of the expected issue, empty for none
+		severity   string
+		suggestion string
+	}{
+		{
+			name: "valid",
+			ports: []models.Port{
+				{Container: 8080, Name: "web", Public: true},
+				{Container: 5432, Protocol: "tcp", Name: "db"},
+			},
+		},
+		{
+			name: "duplicate name",
+			ports: []models.Port{
+				{Container: 8080, Name: "web"},
+				{Container: 8081, Name: "web"},
+			},
+			field:      "expose[1].name",
+			severity:   SeverityError,
+			suggestion: "web-2",
+		},
+		{
+			name: "duplicate name skips taken suggestion",
+			ports: []models.Port{
+				{Container: 8080, Name: "web"},
+				{Container: 8081, Name: "web-2"},
+				{Container: 8082, Name: "web"},
+			},
+			field:      "expose[2].name",
+			severity:   SeverityError,
+			suggestion: "web-3",
+		},
+		{
+			name: "duplicate container port",
+			ports: []models.Port{
+				{Container: 8080, Name: "web"},
+				{Container: 8080, Name: "api"},
+			},
+			field:    "expose[1].container",
+			severity: SeverityError,
+		},
+		{
+			name: "same port on different protocols",
+			ports: []models.Port{
+				{Container: 53, Protocol: "tcp", Name: "dns-tcp"},
+				{Container: 53, Protocol: "udp", Name: "dns-udp"},
+			},
+		},
+		{
+			name:     "unknown protocol",
+			ports:    []models.Port{{Container: 8080, Protocol: "sctp", Name: "web"}},
+			field:    "expose[0].protocol",
+			severity: SeverityError,
+		},
+		{
+			name:     "port out of range",
+			ports:    []models.Port{{Container: 70000, Name: "web"}},
+			field:    "expose[0].container",
+			severity: SeverityError,
+		},
+		{
+			name:       "missing name",
+			ports:      []models.Port{{Container: 3000}},
+			field:      "expose[0].name",
+			severity:   SeverityError,
+			suggestion: "port-3000",
+		},
+		{
+			name:       "invalid name",
+			ports:      []models.Port{{Container: 3000, Name: "Web_UI"}},
+			field:      "expose[0].name",
+			severity:   SeverityError,
+			suggestion: "web-ui",
+		},
+		{
+			name:     "public udp port",
+			ports:    []models.Port{{Container: 9000, Protocol: "udp", Name: "game", Public: true}},
+			field:    "expose[0].public",
+			severity: SeverityWarning,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issues := ValidatePorts(tt.ports)
+
+			if tt.field == "" {
+				if len(issues) != 0 {
+					t.Fatalf("expected no issues, got %v", issues)
+				}
+				return
+			}
+
+			if len(issues) != 1 {
+				t.Fatalf("expected one issue, got %v", issues)
+			}
+			got := issues[0]
+			if got.Field != tt.field || got.Severity != tt.severity {
+				t.Errorf("expected %s %s, got %s %s (%s)", tt.severity, tt.field, got.Severity, got.Field, got.Message)
+			}
+			if got.Suggestion != tt.suggestion {
+				t.Errorf("expected suggestion %q, got %q", tt.suggestion, got.Suggestion)
+			}
+		})
+	}
+}
+
+func TestValidatePortsDefaultsProtocol(t *testing.T) {
+	ports := []models.Port{{Container: 8080, Name: "web", Protocol: "TCP"}, {Container: 8081, Name: "api"}}
+	ValidatePorts(ports)
+
+	for _, p := range ports {
+		if p.Protocol != "tcp" {
+			t.Errorf("expected protocol tcp for %s, got %q", p.Name, p.Protocol)
+		}
+	}
+}
+
+func TestLoaderStrict(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "conflict.yaml")
+	doc := `name: conflict
+base_image: alpine:3.19
+expose:
+  - container: 8080
+    name: web
+  - container: 8080
+    name: web
+`
+	if err := os.WriteFile(path, []byte(doc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	lenient := NewLoader()
+	if err := lenient.LoadFromFile(path); err != nil {
+		t.Fatalf("lenient load failed: %v", err)
+	}
+	if lenient.Get("conflict") == nil {
+		t.Fatal("expected lenient loader to keep the template")
+	}
+
+	strict := NewLoader()
+	strict.SetStrict(true)
+	if err := strict.LoadFromFile(path); err == nil {
+		t.Fatal("expected strict load to fail")
+	}
+	if strict.Get("conflict") != nil {
+		t.Fatal("expected strict loader to skip the template")
+	}
+}
+
+func TestValidateCommand(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		fields []string
+	}{
+		{name: "omitted", args: nil},
+		{name: "valid", args: []string{"npm", "run", "dev"}},
+		{name: "empty list", args: []string{}, fields: []string{"command"}},
+		{name: "empty arguments", args: []string{"npm", "", " "}, fields: []string{"command[1]", "command[2]"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issues := ValidateCommand("command", tt.args)
+			if len(issues) != len(tt.fields) {
+				t.Fatalf("expected %d issues, got %v", len(tt.fields), issues)
+			}
+			for i, issue := range issues {
+				if issue.Field != tt.fields[i] || issue.Severity != SeverityError {
+					t.Errorf("unexpected issue %+v", issue)
+				}
+			}
+		})
+	}
+}
+
+func TestParseTemplateCommand(t *testing.T) {
+	doc := `name: web
+base_image: node:20
+entrypoint: ["/bin/sh", "-c"]
+command: ["npm run dev"]
+allow_command_override: true
+`
+	tmpl, issues, err := parseTemplate([]byte(doc), nil)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if HasErrors(issues) {
+		t.Fatalf("unexpected issues: %v", issues)
+	}
+	if len(tmpl.Entrypoint) != 2 || len(tmpl.Command) != 1 || tmpl.Command[0] != "npm run dev" || !tmpl.AllowCommandOverride {
+		t.Fatalf("unexpected command fields: %+v", tmpl)
+	}
+
+	_, issues, err = parseTemplate([]byte("name: web\nbase_image: node:20\ncommand: []\n"), nil)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if !HasErrors(issues) {
+		t.Fatal("expected an empty command to be rejected")
+	}
+}
+
+func TestValidateResourcesGPUs(t *testing.T) {
+	for _, gpus := range []string{"", "1", "4", "all"} {
+		if issues := ValidateResources(models.Resources{GPUs: gpus}); len(issues) != 0 {
+			t.Errorf("gpus %q: unexpected issues %v", gpus, issues)
+		}
+	}
+	for _, gpus := range []string{"0", "-1", "two", "ALL"} {
+		issues := ValidateResources(models.Resources{GPUs: gpus})
+		if len(issues) != 1 || issues[0].Field != "resources.gpus" {
+			t.Errorf("gpus %q: expected a resources.gpus issue, got %v", gpus, issues)
+		}
+	}
+}
+
+func TestValidateResourcesSizes(t *testing.T) {
+	valid := models.Resources{CPULimit: "2", CPURequest: "0.5", MemoryLimit: "4Gi", MemoryRequest: "512m", DiskLimit: "10G"}
+	if issues := ValidateResources(valid); len(issues) != 0 {
+		t.Errorf("unexpected issues %v", issues)
+	}
+
+	invalid := models.Resources{CPULimit: "two", CPURequest: "0", MemoryLimit: "lots", DiskLimit: "-1g"}
+	var fields []string
+	for _, issue := range ValidateResources(invalid) {
+		fields = append(fields, issue.Field)
+	}
+	want := []string{"resources.cpu_limit", "resources.cpu_request", "resources.memory_limit", "resources.disk_limit"}
+	if strings.Join(fields, ",") != strings.Join(want, ",") {
+		t.Errorf("expected issues on %v, got %v", want, fields)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]int64{"512": 512, "512b": 512, "64k": 64 << 10, "512m": 512 << 20, "512MB": 512 << 20, "1.5g": 3 << 29, "4Gi": 4 << 30, "4GiB": 4 << 30, "1t": 1 << 40}
+	for s, want := range tests {
+		if got, err := ParseSize(s); err != nil || got != want {
+			t.Errorf("%s: got %d, %v, want %d", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "lots", "-1g", "4i", "mi", "1x", "1 g"} {
+		if _, err := ParseSize(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestValidateVolumes(t *testing.T) {
+	issues := ValidateVolumes([]models.Volume{
+		{Name: "work", MountPath: "/workspace"},
+		{Name: "cache", MountPath: "cache"},
+		{Name: "again", MountPath: "/workspace/"},
+	})
+	if len(issues) != 2 || issues[0].Field != "volumes[1].mount_path" || issues[1].Field != "volumes[2].mount_path" {
+		t.Errorf("expected a relative and a duplicate mount path, got %v", issues)
+	}
+}
+
+func TestParseTemplateTTL(t *testing.T) {
+	tests := []struct {
+		ttl     string
+		want    time.Duration
+		warning bool
+	}{
+		{"", time.Hour, false},
+		{"2h", 2 * time.Hour, false},
+		{"90", 90 * time.Minute, true}, // legacy minutes
+		{"90m0", 0, false},
+		{"-5m", 0, false},
+		{"0", 0, false},
+	}
+	for _, tt := range tests {
+		tmpl, issues, err := parseTemplate([]byte("name: t\nbase_image: alpine\nttl: \""+tt.ttl+"\"\n"), nil)
+		if tt.want == 0 {
+			if err == nil || !strings.Contains(err.Error(), tt.ttl) {
+				t.Errorf("ttl %q: expected an error naming the value, got %v", tt.ttl, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("ttl %q: %v", tt.ttl, err)
+		}
+		if tmpl.TTL != tt.want || (len(issues) == 1 && issues[0].Severity == SeverityWarning) != tt.warning {
+			t.Errorf("ttl %q: expected %s (warning %v), got %s %v", tt.ttl, tt.want, tt.warning, tmpl.TTL, issues)
+		}
+	}
+
+	_, _, err := parseTemplate([]byte("name: t\nbase_image: alpine\nresources:\n  memory_limit: lots\n"), nil)
+	if err == nil || !strings.Contains(err.Error(), "resources.memory_limit") {
+		t.Errorf("expected an unparseable resource to fail, got %v", err)
+	}
+}
+
+func TestParseTemplateMaxTTL(t *testing.T) {
+	// The default TTL is cut to the cap, an explicit one over it is an error
+	tmpl, issues, err := parseTemplate([]byte("name: t\nbase_image: alpine\nmax_ttl: 30m\n"), nil)
+	if err != nil || HasErrors(issues) || tmpl.MaxTTL != 30*time.Minute || tmpl.TTL != 30*time.Minute {
+		t.Errorf("expected the default ttl cut to max_ttl, got %+v %v %v", tmpl, issues, err)
+	}
+	_, issues, err = parseTemplate([]byte("name: t\nbase_image: alpine\nttl: 2h\nmax_ttl: 1h\n"), nil)
+	if err != nil || !HasErrors(issues) || issues[0].Field != "ttl" {
+		t.Errorf("expected ttl over max_ttl to be an error, got %v %v", issues, err)
+	}
+	if _, _, err := parseTemplate([]byte("name: t\nbase_image: alpine\nmax_ttl: forever\n"), nil); err == nil || !strings.Contains(err.Error(), "max_ttl") {
+		t.Errorf("expected an unparseable max_ttl to fail, got %v", err)
+	}
+}
+
+func TestServiceValidator(t *testing.T) {
+	v := ServiceValidator(func() []string { return []string{"redis", "postgres"} })
+	issues := v.ValidateTemplate(&models.Template{Services: []string{"postgres", "postgress", "kafka"}})
+	if len(issues) != 2 {
+		t.Fatalf("expected two unknown services, got %v", issues)
+	}
+	if issues[0].Field != "services[1]" || issues[0].Suggestion != "postgres" || !strings.Contains(issues[0].Message, "available: postgres, redis") {
+		t.Errorf("expected the typo reported with a suggestion, got %+v", issues[0])
+	}
+	if issues[1].Field != "services[2]" || issues[1].Suggestion != "" {
+		t.Errorf("expected no suggestion for an unrelated name, got %+v", issues[1])
+	}
+}
+
+func TestServiceOptionsValidator(t *testing.T) {
+	tmpl, issues, err := parseTemplate([]byte(`name: opts
+base_image: alpine
+services: [postgres, redis, {name: minio}, {name: cache, mode: dedicated}]
+services_config:
+  postgres: {options: {extensions: "pg_trgm", size: 1G}}
+  redis: {options: {maxmemory: 64mb}}
+  cache: {options: {x: y}}
+`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Options of a dedicated service are rejected by the document itself
+	if len(issues) != 2 || issues[1].Field != "services_config.cache.options" {
+		t.Fatalf("expected the dedicated service's options rejected, got %v", issues)
+	}
+	if got := tmpl.ServicesConfig["postgres"].Options; got["extensions"] != "pg_trgm" || got["size"] != "1G" {
+		t.Errorf("expected the options parsed, got %v", got)
+	}
+
+	var checked []string
+	v := ServiceOptionsValidator(func(service string, opts map[string]string) error {
+		checked = append(checked, service)
+		if _, ok := opts["size"]; ok {
+			return errors.New(`unknown postgres option "size"`)
+		}
+		return nil
+	})
+	issues = v.ValidateTemplate(tmpl)
+	if !slices.Equal(checked, []string{"postgres", "redis"}) {
+		t.Errorf("expected the shared services with options checked, got %v", checked)
+	}
+	if len(issues) != 1 || issues[0].Field != "services_config.postgres.options" || !strings.Contains(issues[0].Message, `"size"`) {
+		t.Errorf("expected the unknown option reported, got %v", issues)
+	}
+}
+
+func TestLoaderInvalidTemplates(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "typo.yaml")
+	if err := os.WriteFile(path, []byte("name: typo\nbase_image: alpine\nservices: [postgress]\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, strict := range []bool{false, true} {
+		loader := NewLoader()
+		loader.SetStrict(strict)
+		loader.AddValidator(ServiceValidator(func() []string { return []string{"postgres"} }))
+		loader.Add(&models.Template{Name: "ok"})
+		err := loader.LoadFromFile(path)
+		if strict != (err != nil) {
+			t.Errorf("strict %v: unexpected load error %v", strict, err)
+		}
+
+		// List and Get agree: only a strict loader leaves the template out
+		listed := slices.ContainsFunc(loader.List(), func(tmpl *models.Template) bool { return tmpl.Name == "typo" })
+		if listed == strict || (loader.Get("typo") != nil) == strict {
+			t.Errorf("strict %v: unexpected List or Get result", strict)
+		}
+		invalid := loader.Invalid()
+		if len(invalid) != 1 || invalid[0].File != path || !strings.Contains(invalid[0].Reason, `unknown service "postgress"`) {
+			t.Errorf("strict %v: expected the template reported with its reason, got %+v", strict, invalid)
+		}
+	}
+}
+
+func TestParseTemplateCredentialsDelivery(t *testing.T) {
+	tmpl, issues, err := parseTemplate([]byte("name: web\nbase_image: node:20\ncredentials_delivery: file\ncredentials_file:\n  format: ini\n"), nil)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if HasErrors(issues) {
+		t.Fatalf("unexpected issues: %v", issues)
+	}
+	f := tmpl.CredentialsFile
+	if f == nil || f.Path != "/run/sandbox/credentials.ini" || f.User != models.DefaultCredentialsUser {
+		t.Fatalf("expected credentials file defaults, got %+v", f)
+	}
+
+	tmpl, _, err = parseTemplate([]byte("name: web\nbase_image: node:20\n"), nil)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if tmpl.CredentialsDelivery != models.CredentialsEnv || tmpl.CredentialsFile != nil {
+		t.Errorf("expected env delivery by default, got %q %+v", tmpl.CredentialsDelivery, tmpl.CredentialsFile)
+	}
+}
+
+func TestValidateCredentials(t *testing.T) {
+	valid := &models.CredentialsFileSpec{Path: "/run/sandbox/credentials.json", Format: "json", User: "1000"}
+
+	tests := []struct {
+		name     string
+		delivery models.CredentialsDelivery
+		file     *models.CredentialsFileSpec
+		fields   []string
+	}{
+		{name: "env", delivery: models.CredentialsEnv},
+		{name: "file", delivery: models.CredentialsFile, file: valid},
+		{name: "unknown mode", delivery: "vault", fields: []string{"credentials_delivery"}},
+		{name: "missing file", delivery: models.CredentialsBoth, fields: []string{"credentials_file"}},
+		{name: "file ignored", delivery: models.CredentialsEnv, file: valid, fields: []string{"credentials_file"}},
+		{
+			name:     "bad file",
+			delivery: models.CredentialsFile,
+			file:     &models.CredentialsFileSpec{Path: "run/creds/", Format: "yaml", User: "coder"},
+			fields:   []string{"credentials_file.path", "credentials_file.format", "credentials_file.user"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issues := ValidateCredentials(tt.delivery, tt.file)
+			if len(issues) != len(tt.fields) {
+				t.Fatalf("expected %d issues, got %v", len(tt.fields), issues)
+			}
+			for i, issue := range issues {
+				if issue.Field != tt.fields[i] {
+					t.Errorf("unexpected issue %+v", issue)
+				}
+			}
+		})
+	}
+}
+
+func TestParseTemplateRecording(t *testing.T) {
+	tmpl, _, err := parseTemplate([]byte("name: web\nbase_image: node:20\nrecording:\n  enabled: true\n  input: true\n"), nil)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if r := tmpl.Recording; r == nil || !r.Enabled || !r.Input {
+		t.Errorf("expected the recording policy, got %+v", r)
+	}
+}
+
+func TestParseTemplateTerminal(t *testing.T) {
+	tmpl, issues, err := parseTemplate([]byte("name: web\nbase_image: alpine\nterminal:\n  shell: [/bin/ash, -l]\n  user: coder\n  allow_command: true\n"), nil)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if HasErrors(issues) {
+		t.Fatalf("unexpected issues: %v", issues)
+	}
+	if term := tmpl.Terminal; term == nil || len(term.Shell) != 2 || term.User != "coder" || !term.AllowCommand {
+		t.Errorf("expected the terminal spec, got %+v", term)
+	}
+
+	_, issues, _ = parseTemplate([]byte("name: web\nbase_image: alpine\nterminal:\n  shell: []\n"), nil)
+	if len(issues) != 1 || issues[0].Field != "terminal.shell" {
+		t.Errorf("expected an empty shell rejected, got %v", issues)
+	}
+}
+
+func TestParseTemplateServiceSpecs(t *testing.T) {
+	doc := "name: web\nbase_image: node:20\nservices:\n  - redis\n  - {name: postgres, mode: dedicated, version: \"16\"}\n"
+	tmpl, issues, err := parseTemplate([]byte(doc), nil)
+	if err != nil || HasErrors(issues) {
+		t.Fatalf("parse failed: %v %v", err, issues)
+	}
+	if !slices.Equal(tmpl.Services, []string{"redis", "postgres"}) {
+		t.Errorf("expected both names in order, got %v", tmpl.Services)
+	}
+	if spec := tmpl.ServiceSpec("postgres"); spec.Mode != models.ServiceDedicated || spec.Version != "16" {
+		t.Errorf("expected postgres dedicated, got %+v", spec)
+	}
+	if spec := tmpl.ServiceSpec("redis"); spec.Mode != models.ServiceShared {
+		t.Errorf("expected redis shared, got %+v", spec)
+	}
+
+	// A dedicated service needs no provider
+	v := ServiceValidator(func() []string { return []string{"redis"} })
+	if issues := v.ValidateTemplate(tmpl); len(issues) != 0 {
+		t.Errorf("expected no unknown services, got %v", issues)
+	}
+
+	for doc, want := range map[string]string{
+		"services: [{name: minio, mode: dedicated}]":                   "services[0].mode: minio can't run dedicated",
+		"services: [{name: redis, mode: cluster}]":                     `services[0].mode: unknown mode "cluster"`,
+		"services: [{name: redis, version: \"7\"}]":                    "services[0].version: a shared redis",
+		"services: [{name: redis, mode: dedicated, version: \"7/x\"}]": `services[0].version: version "7/x" is not an image tag`,
+	} {
+		_, issues, err := parseTemplate([]byte("name: web\nbase_image: node:20\n"+doc+"\n"), nil)
+		if err != nil {
+			t.Fatalf("%s: parse failed: %v", doc, err)
+		}
+		if len(issues) != 1 || !strings.HasPrefix(issues[0].String(), want) {
+			t.Errorf("%s: expected %q, got %v", doc, want, issues)
+		}
+	}
+}
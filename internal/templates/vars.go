@@ -0,0 +1,152 @@
+package templates
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// VarPrefix marks the engine environment variables templates can use:
+// TPL_REGISTRY is ${REGISTRY} in a template
+const VarPrefix = "TPL_"
+
+// RuntimeVars are the placeholders resolved when a sandbox is created, in the
+// values of the template's env; loading leaves them as they are
+var RuntimeVars = []string{"SANDBOX_ID", "USER_ID", "TEMPLATE_ID"}
+
+// RuntimeValues are the values of RuntimeVars for a sandbox
+func RuntimeValues(sandboxID, userID, templateID string) map[string]string {
+	return map[string]string{"SANDBOX_ID": sandboxID, "USER_ID": userID, "TEMPLATE_ID": templateID}
+}
+
+// placeholderPattern matches ${NAME} and ${NAME:-default}, and their escaped
+// form $${NAME}, which stands for the literal text
+var placeholderPattern = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)(:-[^}]*)?\}`)
+
+// LoadVars builds the substitution map of template loads: the KEY=VALUE lines
+// of file, when set, then the TPL_ variables of environ, which win
+func LoadVars(file string, environ []string) (map[string]string, error) {
+	vars := make(map[string]string)
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open template vars file: %w", err)
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for n := 1; scanner.Scan(); n++ {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			key, value, ok := strings.Cut(line, "=")
+			key = strings.TrimSpace(key)
+			if !ok || key == "" {
+				return nil, fmt.Errorf("template vars file line %d: expected KEY=VALUE", n)
+			}
+			value = strings.TrimSpace(value)
+			if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+				value = value[1 : len(value)-1]
+			} else if unquoted, err := strconv.Unquote(value); err == nil {
+				value = unquoted
+			}
+			vars[key] = value
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read template vars file: %w", err)
+		}
+	}
+
+	for _, kv := range environ {
+		key, value, _ := strings.Cut(kv, "=")
+		if name, ok := strings.CutPrefix(key, VarPrefix); ok && name != "" {
+			vars[name] = value
+		}
+	}
+	return vars, nil
+}
+
+// Substitute resolves the placeholders of s from vars. A placeholder without
+// a value (unset or empty) takes its default; those with neither are returned
+// as missing and left in place. Names in keep are left for a later pass.
+func Substitute(s string, vars map[string]string, keep []string) (string, []string) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+
+	var missing []string
+	out := placeholderPattern.ReplaceAllStringFunc(s, func(match string) string {
+		sub := placeholderPattern.FindStringSubmatch(match)
+		name, def := sub[1], strings.TrimPrefix(sub[2], ":-")
+		if slices.Contains(keep, name) {
+			return match
+		}
+		if strings.HasPrefix(match, "$$") {
+			return match[1:]
+		}
+		if v := vars[name]; v != "" {
+			return v
+		}
+		if sub[2] != "" {
+			return def
+		}
+		missing = append(missing, name)
+		return match
+	})
+	return out, missing
+}
+
+// substituteNode resolves the placeholders in the scalar values of a YAML
+// document, reporting each variable without a value and recording the text
+// of the values it changed in resolved, by field path. A plain scalar is
+// typed again after substitution, so ${PORT} can fill a number.
+func substituteNode(n *yaml.Node, path string, vars, resolved map[string]string) []Issue {
+	var issues []Issue
+	switch n.Kind {
+	case yaml.DocumentNode:
+		for _, c := range n.Content {
+			issues = append(issues, substituteNode(c, path, vars, resolved)...)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			field := n.Content[i].Value
+			if path != "" {
+				field = path + "." + field
+			}
+			issues = append(issues, substituteNode(n.Content[i+1], field, vars, resolved)...)
+		}
+	case yaml.SequenceNode:
+		for i, c := range n.Content {
+			issues = append(issues, substituteNode(c, fmt.Sprintf("%s[%d]", path, i), vars, resolved)...)
+		}
+	case yaml.ScalarNode:
+		// The runtime placeholders only exist in env values
+		var keep []string
+		if strings.HasPrefix(path, "env.") {
+			keep = RuntimeVars
+		}
+		value, missing := Substitute(n.Value, vars, keep)
+		if value != n.Value {
+			resolved[path] = n.Value
+			n.Value = value
+			if n.Style == 0 {
+				n.Tag = ""
+			}
+		}
+		for _, name := range missing {
+			msg := fmt.Sprintf("variable %s is not set and has no default (set %s%s or use ${%s:-default})", name, VarPrefix, name, name)
+			if slices.Contains(RuntimeVars, name) {
+				msg = fmt.Sprintf("variable %s is only resolved in env values", name)
+			}
+			issues = append(issues, Issue{Severity: SeverityError, Field: path, Message: msg})
+		}
+	}
+	return issues
+}
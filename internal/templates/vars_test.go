@@ -0,0 +1,128 @@
+package templates
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSubstitute(t *testing.T) {
+	vars := map[string]string{"REGISTRY": "registry.local", "EMPTY": ""}
+	tests := []struct {
+		in      string
+		want    string
+		missing []string
+	}{
+		{"plain", "plain", nil},
+		{"${REGISTRY}/python:3.12", "registry.local/python:3.12", nil},
+		{"${MIRROR:-https://github.com}/org", "https://github.com/org", nil},
+		{"${EMPTY:-fallback}", "fallback", nil},
+		{"${EMPTY:-}", "", nil},
+		{"$${REGISTRY} stays", "${REGISTRY} stays", nil},
+		{"${PROXY}", "${PROXY}", []string{"PROXY"}},
+		{"id-${SANDBOX_ID}", "id-${SANDBOX_ID}", nil},
+	}
+	for _, tt := range tests {
+		got, missing := Substitute(tt.in, vars, RuntimeVars)
+		if got != tt.want || !reflect.DeepEqual(missing, tt.missing) {
+			t.Errorf("Substitute(%q) = %q, %v; want %q, %v", tt.in, got, missing, tt.want, tt.missing)
+		}
+	}
+
+	got, _ := Substitute("${SANDBOX_ID}:$${USER_ID}", RuntimeValues("sb-1", "u-1", "python"), nil)
+	if got != "sb-1:${USER_ID}" {
+		t.Errorf("expected the runtime pass to resolve, got %q", got)
+	}
+}
+
+func TestLoadVars(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "vars")
+	content := "# deploy values\nREGISTRY=registry.file\nMIRROR = \"https://mirror.local\"\nPROXY='http://proxy:3128'\n\n"
+	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	vars, err := LoadVars(file, []string{"TPL_REGISTRY=registry.env", "HOME=/root", "TPL_=ignored"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"REGISTRY": "registry.env", "MIRROR": "https://mirror.local", "PROXY": "http://proxy:3128"}
+	if !reflect.DeepEqual(vars, want) {
+		t.Errorf("expected %v, got %v", want, vars)
+	}
+
+	if err := os.WriteFile(file, []byte("not a pair\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadVars(file, nil); err == nil {
+		t.Error("expected a malformed line to fail")
+	}
+}
+
+func TestParseTemplateVars(t *testing.T) {
+	vars := map[string]string{"REGISTRY": "registry.local", "PORT": "8080"}
+	doc := `name: web
+base_image: ${REGISTRY}/node:20
+expose:
+  - container: ${PORT}
+    name: web
+env:
+  GIT_MIRROR: ${GIT_MIRROR:-https://github.com}
+  WORKSPACE: /work/${SANDBOX_ID}
+`
+	tmpl, issues, err := parseTemplate([]byte(doc), vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if HasErrors(issues) {
+		t.Fatalf("unexpected issues %v", issues)
+	}
+	if tmpl.BaseImage != "registry.local/node:20" || len(tmpl.Expose) != 1 || tmpl.Expose[0].Container != 8080 {
+		t.Errorf("placeholders not resolved: %+v", tmpl)
+	}
+	if tmpl.Env["GIT_MIRROR"] != "https://github.com" || tmpl.Env["WORKSPACE"] != "/work/${SANDBOX_ID}" {
+		t.Errorf("unexpected env %v", tmpl.Env)
+	}
+
+	// The resolved fields are shown as written, the others as they are
+	redacted := tmpl.Redacted()
+	if redacted.BaseImage != "${REGISTRY}/node:20" || redacted.Env["GIT_MIRROR"] != "${GIT_MIRROR:-https://github.com}" || redacted.Env["WORKSPACE"] != "/work/${SANDBOX_ID}" {
+		t.Errorf("expected the placeholders shown, got %s %v", redacted.BaseImage, redacted.Env)
+	}
+	if tmpl.BaseImage != "registry.local/node:20" || tmpl.Env["GIT_MIRROR"] != "https://github.com" {
+		t.Error("expected Redacted to leave the template as it is")
+	}
+
+	// An unresolved placeholder rejects the document, strict or not
+	for _, doc := range []string{
+		"name: web\nbase_image: ${REGISTRY}/node\n",
+		"name: web\nbase_image: node\ncommand:\n  - run\n  - ${SANDBOX_ID}\n",
+	} {
+		if _, _, err := parseTemplate([]byte(doc), nil); err == nil {
+			t.Errorf("expected %q rejected", doc)
+		}
+	}
+	l := NewLoader()
+	if _, err := l.register([]byte("name: web\nbase_image: ${REGISTRY}/node\n"), ""); err == nil || !strings.Contains(err.Error(), "REGISTRY") {
+		t.Errorf("expected a non-strict load to fail, got %v", err)
+	}
+	if l.Get("web") != nil {
+		t.Error("expected the template not served")
+	}
+}
+
+func TestRedactedCommands(t *testing.T) {
+	tmpl, _, err := parseTemplate([]byte("name: web\nbase_image: node\ncommand: [run, \"--token=${TOKEN}\"]\ncommands:\n  healthcheck: curl -H ${TOKEN} localhost\nlabels:\n  owner: ${TEAM}\n"), map[string]string{"TOKEN": "s3cret", "TEAM": "core"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	redacted := tmpl.Redacted()
+	if redacted.Command[1] != "--token=${TOKEN}" || redacted.Command[0] != "run" || tmpl.Command[1] != "--token=s3cret" {
+		t.Errorf("unexpected command %v (template %v)", redacted.Command, tmpl.Command)
+	}
+	if redacted.Commands.Healthcheck != "curl -H ${TOKEN} localhost" || redacted.Labels["owner"] != "${TEAM}" {
+		t.Errorf("unexpected redaction %+v", redacted)
+	}
+}
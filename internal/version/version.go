@@ -0,0 +1,51 @@
+// Package version describes the build of the running binary. Builds set the
+// variables with -ldflags "-X github.com/terra-clan/sandbox-engine/internal/version.Version=...",
+// see the Makefile.
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// Set at link time
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
+
+// Info is the build of the running binary
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build information. A plain go build inside the repository
+// has no -ldflags, so commit and date fall back to the VCS stamp Go embeds.
+var Get = sync.OnceValue(func() Info {
+	info := Info{Version: Version, Commit: Commit, BuildDate: BuildDate, GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && info.Commit == "unknown":
+				info.Commit = s.Value
+				if len(info.Commit) > 7 {
+					info.Commit = info.Commit[:7]
+				}
+			case s.Key == "vcs.time" && info.BuildDate == "unknown":
+				info.BuildDate = s.Value
+			}
+		}
+	}
+	return info
+})
+
+// String is the version with its commit, e.g. 1.4.0+3f2c1ab
+func String() string {
+	info := Get()
+	return info.Version + "+" + info.Commit
+}
@@ -0,0 +1,5 @@
+-- Track last terminal activity per sandbox for idle detection
+-- NULL means no activity yet; idle time is then measured from started_at
+ALTER TABLE sandboxes ADD COLUMN IF NOT EXISTS last_activity_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_sandboxes_last_activity_at ON sandboxes(last_activity_at);
@@ -0,0 +1,3 @@
+-- Result of the last per-instance connectivity check
+ALTER TABLE sandbox_services ADD COLUMN IF NOT EXISTS status_message TEXT;
+ALTER TABLE sandbox_services ADD COLUMN IF NOT EXISTS last_checked_at TIMESTAMP WITH TIME ZONE;
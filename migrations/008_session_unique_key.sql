@@ -0,0 +1,8 @@
+-- Optional per-candidate uniqueness for sessions
+-- At most one session per unique_key may be provisioning or active at a time
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS unique_key VARCHAR(255);
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS on_conflict VARCHAR(20) DEFAULT 'reject';
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sessions_unique_key_active
+    ON sessions(unique_key)
+    WHERE unique_key IS NOT NULL AND status IN ('provisioning', 'active');
@@ -0,0 +1,16 @@
+-- Scheduling state of Docker hosts, shared by all engine replicas
+CREATE TABLE IF NOT EXISTS docker_hosts (
+    name VARCHAR(100) PRIMARY KEY,
+    draining BOOLEAN NOT NULL DEFAULT false,
+    drain_mode VARCHAR(20),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Host each sandbox was placed on (NULL for sandboxes created before placement)
+ALTER TABLE sandboxes ADD COLUMN IF NOT EXISTS host VARCHAR(100);
+CREATE INDEX IF NOT EXISTS idx_sandboxes_host ON sandboxes(host);
+
+-- Host administration is an admin:* permission
+UPDATE api_clients
+SET permissions = permissions || '["admin:*"]'::jsonb
+WHERE name = 'terra-admin' AND NOT permissions ? 'admin:*';
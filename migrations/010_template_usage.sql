@@ -0,0 +1,17 @@
+-- Daily usage counters per template and catalog task ('' for sandboxes without a task)
+CREATE TABLE IF NOT EXISTS template_usage_daily (
+    template_id VARCHAR(255) NOT NULL,
+    task_id VARCHAR(255) NOT NULL DEFAULT '',
+    day DATE NOT NULL,
+    creations BIGINT NOT NULL DEFAULT 0,
+    successes BIGINT NOT NULL DEFAULT 0,
+    failures BIGINT NOT NULL DEFAULT 0,
+    session_seconds BIGINT NOT NULL DEFAULT 0,
+    last_used_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (template_id, task_id, day)
+);
+
+CREATE INDEX IF NOT EXISTS idx_template_usage_daily_day ON template_usage_daily(day);
+
+-- Set once a sandbox's usage has been counted, so repeated transitions don't count twice
+ALTER TABLE sandboxes ADD COLUMN IF NOT EXISTS usage_recorded BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,12 @@
+-- Lifecycle events per sandbox. No foreign key: events are kept after the
+-- sandbox is deleted and purged by the cleanup worker after the retention period.
+CREATE TABLE IF NOT EXISTS sandbox_events (
+    id BIGSERIAL PRIMARY KEY,
+    sandbox_id VARCHAR(12) NOT NULL,
+    type VARCHAR(50) NOT NULL,
+    message TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_sandbox_events_sandbox_id ON sandbox_events(sandbox_id, id);
+CREATE INDEX IF NOT EXISTS idx_sandbox_events_created_at ON sandbox_events(created_at);
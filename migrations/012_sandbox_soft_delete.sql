@@ -0,0 +1,5 @@
+-- Deleted sandboxes keep their row, marked by deleted_at, so their history
+-- survives; the cleanup worker purges them after the retention period
+ALTER TABLE sandboxes ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_sandboxes_deleted_at ON sandboxes(deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,4 @@
+-- Managing API clients and their keys is a separate clients:admin permission
+UPDATE api_clients
+SET permissions = permissions || '["clients:admin"]'::jsonb
+WHERE name = 'terra-admin' AND NOT permissions ? 'clients:admin';
@@ -0,0 +1,5 @@
+-- API client that created each sandbox (NULL for sandboxes created before
+-- ownership was tracked, and for session sandboxes); clients without
+-- sandboxes:admin only see their own
+ALTER TABLE sandboxes ADD COLUMN IF NOT EXISTS owner_client_id INTEGER REFERENCES api_clients(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_sandboxes_owner_client_id ON sandboxes(owner_client_id);
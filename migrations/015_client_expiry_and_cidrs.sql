@@ -0,0 +1,5 @@
+-- Keys handed to partners can expire and be limited to source networks
+ALTER TABLE api_clients ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE api_clients ADD COLUMN IF NOT EXISTS allowed_cidrs JSONB NOT NULL DEFAULT '[]';
+
+CREATE INDEX IF NOT EXISTS idx_api_clients_expires_at ON api_clients(expires_at) WHERE expires_at IS NOT NULL;
@@ -0,0 +1,4 @@
+-- Sessions not started by their join deadline are expired by the cleaner
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS join_by TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_sessions_join_by ON sessions(join_by) WHERE status = 'ready';
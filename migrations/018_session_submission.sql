@@ -0,0 +1,2 @@
+-- Submitted sessions keep the time the candidate handed in; the archive lives on disk
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS submitted_at TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,14 @@
+-- Terminal connections per session, for the activity report. They go with
+-- the session, not its sandbox, so the report outlives the sandbox.
+CREATE TABLE IF NOT EXISTS session_connections (
+    id BIGSERIAL PRIMARY KEY,
+    session_id UUID NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+    connected_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    disconnected_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_session_connections_session_id ON session_connections(session_id, id);
+
+-- Time added by extensions, and the last status of the sandbox once deleted
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS extended_seconds INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS sandbox_status VARCHAR(20);
@@ -0,0 +1,5 @@
+-- Sessions with prewarm_at get their sandbox created at that time, before
+-- the candidate joins
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS prewarm_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_sessions_prewarm_at ON sessions(prewarm_at) WHERE status = 'ready' AND sandbox_id IS NULL;
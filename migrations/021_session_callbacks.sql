@@ -0,0 +1,17 @@
+-- Per-session callbacks: where status changes are POSTed, the secret signing
+-- them, and the outcome of each delivery
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS callback_url TEXT;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS callback_secret VARCHAR(64);
+
+CREATE TABLE IF NOT EXISTS session_callback_deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    session_id UUID NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+    event VARCHAR(20) NOT NULL,
+    delivered BOOLEAN NOT NULL,
+    attempts INTEGER NOT NULL,
+    status_code INTEGER,
+    error TEXT,
+    attempted_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_session_callback_deliveries_session_id ON session_callback_deliveries(session_id, id);
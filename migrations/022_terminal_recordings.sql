@@ -0,0 +1,17 @@
+-- Terminal recordings: asciicast files of sandbox shells, kept after the
+-- sandbox is deleted and purged, hence no foreign key
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS record_terminal BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS terminal_recordings (
+    id BIGSERIAL PRIMARY KEY,
+    sandbox_id VARCHAR(12) NOT NULL,
+    session_id UUID,
+    owner_client_id INTEGER,
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    ended_at TIMESTAMP WITH TIME ZONE,
+    size_bytes BIGINT NOT NULL DEFAULT 0,
+    truncated BOOLEAN NOT NULL DEFAULT FALSE,
+    input BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX IF NOT EXISTS idx_terminal_recordings_sandbox_id ON terminal_recordings(sandbox_id, id);
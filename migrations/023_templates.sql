@@ -0,0 +1,8 @@
+-- Templates created through the API, stored as the document they were
+-- created from; the loader reads them alongside TEMPLATES_DIR
+CREATE TABLE IF NOT EXISTS templates (
+    name VARCHAR(255) PRIMARY KEY,
+    document TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
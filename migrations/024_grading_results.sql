@@ -0,0 +1,20 @@
+-- Grading runs of task sandboxes, kept after the sandbox is deleted and
+-- purged, hence no foreign key
+CREATE TABLE IF NOT EXISTS grading_results (
+    id BIGSERIAL PRIMARY KEY,
+    sandbox_id VARCHAR(12) NOT NULL,
+    session_id UUID,
+    task_id VARCHAR(255) NOT NULL,
+    owner_client_id INTEGER,
+    passed BOOLEAN NOT NULL DEFAULT FALSE,
+    exit_code INTEGER NOT NULL,
+    stdout TEXT NOT NULL DEFAULT '',
+    stderr TEXT NOT NULL DEFAULT '',
+    truncated BOOLEAN NOT NULL DEFAULT FALSE,
+    duration_ms BIGINT NOT NULL DEFAULT 0,
+    junit JSONB,
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_grading_results_sandbox_id ON grading_results(sandbox_id, id);
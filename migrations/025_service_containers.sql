@@ -0,0 +1,4 @@
+-- Services a template runs in a container of the sandbox's own; container_id
+-- is that container, so Delete and the orphan pruner can remove it
+ALTER TABLE sandbox_services ADD COLUMN IF NOT EXISTS mode VARCHAR(20);
+ALTER TABLE sandbox_services ADD COLUMN IF NOT EXISTS container_id VARCHAR(64);
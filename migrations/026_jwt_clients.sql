@@ -0,0 +1,6 @@
+-- JWT principals get a client row of their own on first use, keyed by the
+-- token subject, so the sandboxes they create have an owner and per-client
+-- limits count them apart. The row is inactive: its key never authenticates.
+ALTER TABLE api_clients ADD COLUMN IF NOT EXISTS jwt_subject VARCHAR(255);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_clients_jwt_subject ON api_clients(jwt_subject);
@@ -0,0 +1,11 @@
+-- Callback deliveries become a durable outbox: each row keeps what to POST
+-- and when to try it next, so retries survive a restart. Rows no longer
+-- cascade with their session, so the expiry callback outlives the delete of
+-- the session it reports; the cleanup worker purges finished orphans.
+ALTER TABLE session_callback_deliveries DROP CONSTRAINT IF EXISTS session_callback_deliveries_session_id_fkey;
+ALTER TABLE session_callback_deliveries ADD COLUMN IF NOT EXISTS url TEXT NOT NULL DEFAULT '';
+ALTER TABLE session_callback_deliveries ADD COLUMN IF NOT EXISTS secret VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE session_callback_deliveries ADD COLUMN IF NOT EXISTS payload TEXT NOT NULL DEFAULT '';
+ALTER TABLE session_callback_deliveries ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_session_callback_deliveries_next_attempt ON session_callback_deliveries(next_attempt_at) WHERE next_attempt_at IS NOT NULL;
@@ -0,0 +1,7 @@
+-- API client that created each session. Its sandbox is created on the
+-- client's behalf, so the client can reach the session's sandbox,
+-- recordings and grading results without sandboxes:admin.
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS owner_client_id INTEGER REFERENCES api_clients(id) ON DELETE SET NULL;
+
+-- Recordings are purged by age; finished ones by when they ended
+CREATE INDEX IF NOT EXISTS idx_terminal_recordings_started_at ON terminal_recordings(started_at);
@@ -0,0 +1,10 @@
+// Package migrations embeds the SQL schema migrations so the binary can apply
+// them without the migrations directory next to it
+package migrations
+
+import "embed"
+
+// FS holds the PostgreSQL migrations at its root and the SQLite set in sqlite/
+//
+//go:embed *.sql sqlite/*.sql
+var FS embed.FS
@@ -0,0 +1,118 @@
+-- Migration: 001_initial (SQLite)
+-- Description: Schema of migrations/001-011 for DATABASE_DRIVER=sqlite.
+-- JSONB columns are TEXT holding the same JSON; timestamps are UTC text
+-- written by the repository in a fixed-width, sortable format.
+
+CREATE TABLE IF NOT EXISTS sandboxes (
+    id VARCHAR(12) PRIMARY KEY,
+    template_id VARCHAR(255) NOT NULL,
+    user_id VARCHAR(255) NOT NULL,
+    status VARCHAR(50) NOT NULL DEFAULT 'pending',
+    status_message TEXT,
+    container_id VARCHAR(64),
+    created_at TIMESTAMP NOT NULL,
+    started_at TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    metadata TEXT DEFAULT '{}',
+    endpoints TEXT DEFAULT '{}',
+    last_activity_at TIMESTAMP,
+    host VARCHAR(100),
+    usage_recorded BOOLEAN NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_sandboxes_user_id ON sandboxes(user_id);
+CREATE INDEX IF NOT EXISTS idx_sandboxes_status ON sandboxes(status);
+CREATE INDEX IF NOT EXISTS idx_sandboxes_expires_at ON sandboxes(expires_at);
+CREATE INDEX IF NOT EXISTS idx_sandboxes_template_id ON sandboxes(template_id);
+CREATE INDEX IF NOT EXISTS idx_sandboxes_last_activity_at ON sandboxes(last_activity_at);
+CREATE INDEX IF NOT EXISTS idx_sandboxes_host ON sandboxes(host);
+
+CREATE TABLE IF NOT EXISTS sandbox_services (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    sandbox_id VARCHAR(12) REFERENCES sandboxes(id) ON DELETE CASCADE,
+    service_name VARCHAR(100) NOT NULL,
+    service_type VARCHAR(100) NOT NULL,
+    status VARCHAR(50) NOT NULL DEFAULT 'pending',
+    credentials TEXT,
+    created_at TIMESTAMP NOT NULL,
+    status_message TEXT,
+    last_checked_at TIMESTAMP,
+    UNIQUE(sandbox_id, service_name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_sandbox_services_sandbox_id ON sandbox_services(sandbox_id);
+
+CREATE TABLE IF NOT EXISTS api_clients (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(100) NOT NULL,
+    api_key VARCHAR(64) UNIQUE NOT NULL,
+    is_active BOOLEAN DEFAULT 1,
+    created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    last_used_at TIMESTAMP,
+    permissions TEXT DEFAULT '["sandboxes:read", "sandboxes:write", "templates:read", "sessions:read", "sessions:write"]',
+    metadata TEXT DEFAULT '{}'
+);
+
+-- Default clients (for development)
+INSERT OR IGNORE INTO api_clients (name, api_key, permissions) VALUES
+    ('terra-sandbox', 'sk_dev_terrasandbox_xxxxxxxxxxxxx', '["sandboxes:*", "templates:*", "sessions:*"]'),
+    ('terra-admin', 'sk_dev_terraadmin_xxxxxxxxxxxxx', '["sandboxes:*", "templates:*", "sessions:*", "admin:*"]');
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id VARCHAR(36) PRIMARY KEY,
+    token VARCHAR(64) UNIQUE NOT NULL,
+    template_id VARCHAR(100) NOT NULL,
+    status VARCHAR(20) DEFAULT 'ready',
+    status_message TEXT DEFAULT '',
+    env TEXT DEFAULT '{}',
+    metadata TEXT DEFAULT '{}',
+    services TEXT DEFAULT '[]',
+    ttl_seconds INTEGER NOT NULL,
+    sandbox_id VARCHAR(36),
+    task_description TEXT DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    activated_at TIMESTAMP,
+    expires_at TIMESTAMP,
+    created_by VARCHAR(100),
+    unique_key VARCHAR(255),
+    on_conflict VARCHAR(20) DEFAULT 'reject'
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_status ON sessions(status);
+CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions(expires_at);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sessions_unique_key_active
+    ON sessions(unique_key)
+    WHERE unique_key IS NOT NULL AND status IN ('provisioning', 'active');
+
+CREATE TABLE IF NOT EXISTS docker_hosts (
+    name VARCHAR(100) PRIMARY KEY,
+    draining BOOLEAN NOT NULL DEFAULT 0,
+    drain_mode VARCHAR(20),
+    updated_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS template_usage_daily (
+    template_id VARCHAR(255) NOT NULL,
+    task_id VARCHAR(255) NOT NULL DEFAULT '',
+    day DATE NOT NULL,
+    creations BIGINT NOT NULL DEFAULT 0,
+    successes BIGINT NOT NULL DEFAULT 0,
+    failures BIGINT NOT NULL DEFAULT 0,
+    session_seconds BIGINT NOT NULL DEFAULT 0,
+    last_used_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (template_id, task_id, day)
+);
+
+CREATE INDEX IF NOT EXISTS idx_template_usage_daily_day ON template_usage_daily(day);
+
+CREATE TABLE IF NOT EXISTS sandbox_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    sandbox_id VARCHAR(12) NOT NULL,
+    type VARCHAR(50) NOT NULL,
+    message TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_sandbox_events_sandbox_id ON sandbox_events(sandbox_id, id);
+CREATE INDEX IF NOT EXISTS idx_sandbox_events_created_at ON sandbox_events(created_at);
@@ -0,0 +1,5 @@
+-- Migration: 002_sandbox_soft_delete (SQLite)
+-- Description: migrations/012 for DATABASE_DRIVER=sqlite.
+ALTER TABLE sandboxes ADD COLUMN deleted_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_sandboxes_deleted_at ON sandboxes(deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,6 @@
+-- Migration: 003_client_admin_permission (SQLite)
+-- Description: migrations/013 for DATABASE_DRIVER=sqlite.
+UPDATE api_clients
+SET permissions = json_insert(permissions, '$[#]', 'clients:admin')
+WHERE name = 'terra-admin'
+  AND NOT EXISTS (SELECT 1 FROM json_each(api_clients.permissions) WHERE value = 'clients:admin');
@@ -0,0 +1,5 @@
+-- Migration: 004_sandbox_owner (SQLite)
+-- Description: migrations/014 for DATABASE_DRIVER=sqlite.
+ALTER TABLE sandboxes ADD COLUMN owner_client_id INTEGER REFERENCES api_clients(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_sandboxes_owner_client_id ON sandboxes(owner_client_id);
@@ -0,0 +1,6 @@
+-- Migration: 005_client_expiry_and_cidrs (SQLite)
+-- Description: migrations/015 for DATABASE_DRIVER=sqlite.
+ALTER TABLE api_clients ADD COLUMN expires_at TIMESTAMP;
+ALTER TABLE api_clients ADD COLUMN allowed_cidrs TEXT NOT NULL DEFAULT '[]';
+
+CREATE INDEX IF NOT EXISTS idx_api_clients_expires_at ON api_clients(expires_at) WHERE expires_at IS NOT NULL;
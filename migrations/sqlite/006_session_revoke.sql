@@ -0,0 +1,6 @@
+-- Migration: 006_session_revoke (SQLite)
+-- Description: migrations/016 for DATABASE_DRIVER=sqlite.
+ALTER TABLE sessions ADD COLUMN revoked_at TIMESTAMP;
+ALTER TABLE sessions ADD COLUMN revoked_by VARCHAR(100);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_revoked_at ON sessions(revoked_at) WHERE revoked_at IS NOT NULL;
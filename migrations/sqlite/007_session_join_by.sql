@@ -0,0 +1,5 @@
+-- Migration: 007_session_join_by (SQLite)
+-- Description: migrations/017 for DATABASE_DRIVER=sqlite.
+ALTER TABLE sessions ADD COLUMN join_by TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_sessions_join_by ON sessions(join_by) WHERE status = 'ready';
@@ -0,0 +1,3 @@
+-- Migration: 008_session_submission (SQLite)
+-- Description: migrations/018 for DATABASE_DRIVER=sqlite.
+ALTER TABLE sessions ADD COLUMN submitted_at TIMESTAMP;
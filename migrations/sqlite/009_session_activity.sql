@@ -0,0 +1,13 @@
+-- Migration: 009_session_activity (SQLite)
+-- Description: migrations/019 for DATABASE_DRIVER=sqlite.
+CREATE TABLE IF NOT EXISTS session_connections (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    session_id VARCHAR(36) NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+    connected_at TIMESTAMP NOT NULL,
+    disconnected_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_session_connections_session_id ON session_connections(session_id, id);
+
+ALTER TABLE sessions ADD COLUMN extended_seconds INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE sessions ADD COLUMN sandbox_status VARCHAR(20);
@@ -0,0 +1,5 @@
+-- Migration: 010_session_prewarm (SQLite)
+-- Description: migrations/020 for DATABASE_DRIVER=sqlite.
+ALTER TABLE sessions ADD COLUMN prewarm_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_sessions_prewarm_at ON sessions(prewarm_at) WHERE status = 'ready' AND sandbox_id IS NULL;
@@ -0,0 +1,17 @@
+-- Migration: 011_session_callbacks (SQLite)
+-- Description: migrations/021 for DATABASE_DRIVER=sqlite.
+ALTER TABLE sessions ADD COLUMN callback_url TEXT;
+ALTER TABLE sessions ADD COLUMN callback_secret VARCHAR(64);
+
+CREATE TABLE IF NOT EXISTS session_callback_deliveries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    session_id VARCHAR(36) NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+    event VARCHAR(20) NOT NULL,
+    delivered BOOLEAN NOT NULL,
+    attempts INTEGER NOT NULL,
+    status_code INTEGER,
+    error TEXT,
+    attempted_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_session_callback_deliveries_session_id ON session_callback_deliveries(session_id, id);
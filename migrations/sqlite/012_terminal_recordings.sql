@@ -0,0 +1,17 @@
+-- Migration: 012_terminal_recordings (SQLite)
+-- Description: migrations/022 for DATABASE_DRIVER=sqlite.
+ALTER TABLE sessions ADD COLUMN record_terminal BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS terminal_recordings (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    sandbox_id VARCHAR(12) NOT NULL,
+    session_id VARCHAR(36),
+    owner_client_id INTEGER,
+    started_at TIMESTAMP NOT NULL,
+    ended_at TIMESTAMP,
+    size_bytes INTEGER NOT NULL DEFAULT 0,
+    truncated BOOLEAN NOT NULL DEFAULT FALSE,
+    input BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX IF NOT EXISTS idx_terminal_recordings_sandbox_id ON terminal_recordings(sandbox_id, id);
@@ -0,0 +1,8 @@
+-- Migration: 013_templates (SQLite)
+-- Description: migrations/023 for DATABASE_DRIVER=sqlite.
+CREATE TABLE IF NOT EXISTS templates (
+    name VARCHAR(255) PRIMARY KEY,
+    document TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
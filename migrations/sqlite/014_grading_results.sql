@@ -0,0 +1,20 @@
+-- Migration: 014_grading_results (SQLite)
+-- Description: migrations/024 for DATABASE_DRIVER=sqlite.
+CREATE TABLE IF NOT EXISTS grading_results (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    sandbox_id VARCHAR(12) NOT NULL,
+    session_id VARCHAR(36),
+    task_id VARCHAR(255) NOT NULL,
+    owner_client_id INTEGER,
+    passed BOOLEAN NOT NULL DEFAULT FALSE,
+    exit_code INTEGER NOT NULL,
+    stdout TEXT NOT NULL DEFAULT '',
+    stderr TEXT NOT NULL DEFAULT '',
+    truncated BOOLEAN NOT NULL DEFAULT FALSE,
+    duration_ms INTEGER NOT NULL DEFAULT 0,
+    junit TEXT,
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_grading_results_sandbox_id ON grading_results(sandbox_id, id);
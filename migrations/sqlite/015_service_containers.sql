@@ -0,0 +1,4 @@
+-- Migration: 015_service_containers (SQLite)
+-- Description: migrations/025 for DATABASE_DRIVER=sqlite.
+ALTER TABLE sandbox_services ADD COLUMN mode TEXT;
+ALTER TABLE sandbox_services ADD COLUMN container_id TEXT;
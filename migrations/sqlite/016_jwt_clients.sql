@@ -0,0 +1,5 @@
+-- Migration: 016_jwt_clients (SQLite)
+-- Description: migrations/026 for DATABASE_DRIVER=sqlite.
+ALTER TABLE api_clients ADD COLUMN jwt_subject TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_clients_jwt_subject ON api_clients(jwt_subject);
@@ -0,0 +1,26 @@
+-- Migration: 017_callback_outbox (SQLite)
+-- Description: migrations/027 for DATABASE_DRIVER=sqlite. SQLite can't drop
+-- a foreign key, so the table is rebuilt without it.
+CREATE TABLE session_callback_deliveries_outbox (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    session_id VARCHAR(36) NOT NULL,
+    event VARCHAR(20) NOT NULL,
+    delivered BOOLEAN NOT NULL,
+    attempts INTEGER NOT NULL,
+    status_code INTEGER,
+    error TEXT,
+    attempted_at TIMESTAMP NOT NULL,
+    url TEXT NOT NULL DEFAULT '',
+    secret VARCHAR(64) NOT NULL DEFAULT '',
+    payload TEXT NOT NULL DEFAULT '',
+    next_attempt_at TIMESTAMP
+);
+
+INSERT INTO session_callback_deliveries_outbox (id, session_id, event, delivered, attempts, status_code, error, attempted_at)
+SELECT id, session_id, event, delivered, attempts, status_code, error, attempted_at FROM session_callback_deliveries;
+
+DROP TABLE session_callback_deliveries;
+ALTER TABLE session_callback_deliveries_outbox RENAME TO session_callback_deliveries;
+
+CREATE INDEX IF NOT EXISTS idx_session_callback_deliveries_session_id ON session_callback_deliveries(session_id, id);
+CREATE INDEX IF NOT EXISTS idx_session_callback_deliveries_next_attempt ON session_callback_deliveries(next_attempt_at) WHERE next_attempt_at IS NOT NULL;
@@ -0,0 +1,5 @@
+-- Migration: 018_session_owner (SQLite)
+-- Description: migrations/028 for DATABASE_DRIVER=sqlite.
+ALTER TABLE sessions ADD COLUMN owner_client_id INTEGER REFERENCES api_clients(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_terminal_recordings_started_at ON terminal_recordings(started_at);
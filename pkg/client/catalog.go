@@ -0,0 +1,177 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Domain is a top-level assessment category of the catalog, e.g. fintech
+type Domain struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	Description   string `json:"description"`
+	ProjectsCount int    `json:"projectsCount"`
+	TasksCount    int    `json:"tasksCount"`
+}
+
+// CatalogProject is a project within a domain: an environment template and its tasks
+type CatalogProject struct {
+	ID          string `json:"id"` // "fintech/python-trading"
+	DomainID    string `json:"domainId"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	TasksCount  int    `json:"tasksCount"`
+}
+
+// CatalogTask is a coding task within a project
+type CatalogTask struct {
+	ID            string   `json:"id"`   // "fintech/python-trading/limit-orders"
+	Code          string   `json:"code"` // "limit-orders"
+	Title         string   `json:"title"`
+	Description   string   `json:"description"`
+	Difficulty    string   `json:"difficulty"`    // easy | medium | hard
+	RequiredLevel *string  `json:"requiredLevel"` // junior | middle | senior | null
+	TimeLimit     int      `json:"timeLimit"`     // seconds
+	Skills        []string `json:"skills"`
+	DomainID      string   `json:"domainId"`
+	ProjectID     string   `json:"projectId"`
+
+	// Titles and Descriptions hold every locale of a task written with
+	// several, by locale; Title and Description are in the default one
+	Titles       map[string]string `json:"titles,omitempty"`
+	Descriptions map[string]string `json:"descriptions,omitempty"`
+}
+
+// ListDomains retrieves the catalog's domains
+func (c *Client) ListDomains(ctx context.Context) ([]*Domain, error) {
+	var data struct {
+		Domains []*Domain `json:"domains"`
+	}
+	if err := c.catalogRequest(ctx, "/domains", &data); err != nil {
+		return nil, err
+	}
+	return data.Domains, nil
+}
+
+// GetDomain retrieves a catalog domain by ID
+func (c *Client) GetDomain(ctx context.Context, domainID string) (*Domain, error) {
+	var domain Domain
+	if err := c.catalogRequest(ctx, "/domains/"+url.PathEscape(domainID), &domain); err != nil {
+		return nil, err
+	}
+	return &domain, nil
+}
+
+// ListProjects retrieves the projects of a catalog domain
+func (c *Client) ListProjects(ctx context.Context, domainID string) ([]*CatalogProject, error) {
+	var data struct {
+		Projects []*CatalogProject `json:"projects"`
+	}
+	if err := c.catalogRequest(ctx, "/domains/"+url.PathEscape(domainID)+"/projects", &data); err != nil {
+		return nil, err
+	}
+	return data.Projects, nil
+}
+
+// GetProject retrieves a catalog project by ID, e.g. "fintech/python-trading"
+func (c *Client) GetProject(ctx context.Context, projectID string) (*CatalogProject, error) {
+	path, err := projectPath(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	var project CatalogProject
+	if err := c.catalogRequest(ctx, path, &project); err != nil {
+		return nil, err
+	}
+	return &project, nil
+}
+
+// ListTasks retrieves the tasks of a catalog project
+func (c *Client) ListTasks(ctx context.Context, projectID string) ([]*CatalogTask, error) {
+	path, err := projectPath(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	var data struct {
+		Tasks []*CatalogTask `json:"tasks"`
+	}
+	if err := c.catalogRequest(ctx, path+"/tasks", &data); err != nil {
+		return nil, err
+	}
+	return data.Tasks, nil
+}
+
+// GetTask retrieves a catalog task by ID, e.g. "fintech/python-trading/limit-orders"
+func (c *Client) GetTask(ctx context.Context, taskID string) (*CatalogTask, error) {
+	id, code, ok := cutLast(taskID)
+	if !ok {
+		return nil, fmt.Errorf("invalid task id %q: expected domain/project/task", taskID)
+	}
+	path, err := projectPath(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid task id %q: expected domain/project/task", taskID)
+	}
+
+	var task CatalogTask
+	if err := c.catalogRequest(ctx, path+"/tasks/"+url.PathEscape(code), &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// projectPath maps the ID of a catalog project, "domain/project", to its path
+// under /catalog with each segment escaped
+func projectPath(id string) (string, error) {
+	parts := strings.SplitN(id, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("invalid project id %q: expected domain/project", id)
+	}
+	return "/domains/" + url.PathEscape(parts[0]) + "/projects/" + url.PathEscape(parts[1]), nil
+}
+
+// cutLast splits id at its last slash
+func cutLast(id string) (string, string, bool) {
+	i := strings.LastIndex(id, "/")
+	if i < 0 || i == len(id)-1 {
+		return "", "", false
+	}
+	return id[:i], id[i+1:], true
+}
+
+// catalogRequest performs a GET under /api/v1/catalog and unmarshals its data
+// into out
+func (c *Client) catalogRequest(ctx context.Context, path string, out any) error {
+	// The catalog types match the legacy serialization (camelCase fields)
+	resp, err := c.doRequest(ctx, "GET", "/api/v1/catalog"+path+"?api_style=legacy", nil)
+	if err != nil {
+		return err
+	}
+
+	var result struct {
+		Success bool            `json:"success"`
+		Data    json.RawMessage `json:"data"`
+		Error   *struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	if !result.Success {
+		return fmt.Errorf("API error: %s - %s", result.Error.Code, result.Error.Message)
+	}
+
+	if err := json.Unmarshal(result.Data, out); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	return nil
+}
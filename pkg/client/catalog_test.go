@@ -0,0 +1,117 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// catalogServer serves canned catalog responses, in the legacy style the SDK
+// asks for, keyed by escaped request path
+func catalogServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	canned := map[string]string{
+		"/api/v1/catalog/domains":                                           `{"domains":[{"id":"fintech","name":"Fintech","projectsCount":1,"tasksCount":2}],"total":1}`,
+		"/api/v1/catalog/domains/fintech":                                   `{"id":"fintech","name":"Fintech","projectsCount":1,"tasksCount":2}`,
+		"/api/v1/catalog/domains/fintech/projects":                          `{"projects":[{"id":"fintech/q?a #1","domainId":"fintech","name":"Q&A","tasksCount":2}],"total":1}`,
+		"/api/v1/catalog/domains/fintech/projects/q%3Fa%20%231":             `{"id":"fintech/q?a #1","domainId":"fintech","name":"Q&A","tasksCount":2}`,
+		"/api/v1/catalog/domains/fintech/projects/q%3Fa%20%231/tasks":       `{"tasks":[{"id":"fintech/q?a #1/limit-orders","code":"limit-orders","timeLimit":3600,"usage":{"sessions":3}}],"total":1}`,
+		"/api/v1/catalog/domains/fintech/projects/q%3Fa%20%231/tasks/50%25": `{"id":"fintech/q?a #1/50%","code":"50%","title":"Half","timeLimit":1800,"skills":["go"]}`,
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("api_style") != "legacy" {
+			t.Errorf("expected the legacy style on %s", r.URL)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		data, ok := canned[r.URL.EscapedPath()]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]any{"success": false, "error": map[string]string{"code": "not_found", "message": "not found: " + r.URL.EscapedPath()}})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"success": true, "data": json.RawMessage(data)})
+	}))
+}
+
+func TestCatalogDomains(t *testing.T) {
+	ts := catalogServer(t)
+	defer ts.Close()
+	c := NewClient(ts.URL, "key")
+
+	domains, err := c.ListDomains(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(domains) != 1 || domains[0].ID != "fintech" || domains[0].ProjectsCount != 1 {
+		t.Errorf("unexpected domains %+v", domains)
+	}
+
+	domain, err := c.GetDomain(context.Background(), "fintech")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if domain.Name != "Fintech" || domain.TasksCount != 2 {
+		t.Errorf("unexpected domain %+v", domain)
+	}
+}
+
+func TestCatalogProjectsEscaped(t *testing.T) {
+	ts := catalogServer(t)
+	defer ts.Close()
+	c := NewClient(ts.URL, "key")
+
+	projects, err := c.ListProjects(context.Background(), "fintech")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(projects) != 1 || projects[0].DomainID != "fintech" {
+		t.Fatalf("unexpected projects %+v", projects)
+	}
+
+	project, err := c.GetProject(context.Background(), projects[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if project.Name != "Q&A" {
+		t.Errorf("unexpected project %+v", project)
+	}
+
+	tasks, err := c.ListTasks(context.Background(), project.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 1 || tasks[0].Code != "limit-orders" || tasks[0].TimeLimit != 3600 {
+		t.Errorf("unexpected tasks %+v", tasks)
+	}
+
+	task, err := c.GetTask(context.Background(), "fintech/q?a #1/50%")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Title != "Half" || task.TimeLimit != 1800 || len(task.Skills) != 1 {
+		t.Errorf("unexpected task %+v", task)
+	}
+}
+
+func TestCatalogInvalidIDs(t *testing.T) {
+	c := NewClient("http://127.0.0.1:0", "key")
+	if _, err := c.GetProject(context.Background(), "fintech"); err == nil || !strings.Contains(err.Error(), "domain/project") {
+		t.Errorf("expected an invalid project id error, got %v", err)
+	}
+	if _, err := c.GetTask(context.Background(), "fintech/limit-orders"); err == nil || !strings.Contains(err.Error(), "domain/project/task") {
+		t.Errorf("expected an invalid task id error, got %v", err)
+	}
+}
+
+func TestCatalogNotFound(t *testing.T) {
+	ts := catalogServer(t)
+	defer ts.Close()
+
+	if _, err := NewClient(ts.URL, "key").GetDomain(context.Background(), "missing"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("expected the lookup error, got %v", err)
+	}
+}
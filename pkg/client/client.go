@@ -7,9 +7,9 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
-
-	"github.com/terra-clan/sandbox-engine/internal/models"
 )
 
 // Client is a Go SDK for sandbox-engine API
@@ -17,6 +17,7 @@ type Client struct {
 	baseURL    string
 	apiKey     string
 	httpClient *http.Client
+	retry      RetryPolicy
 }
 
 // Option configures the client
@@ -53,29 +54,110 @@ func NewClient(baseURL, apiKey string, opts ...Option) *Client {
 	return c
 }
 
+// SandboxStatus is the state of a sandbox
+type SandboxStatus string
+
+const (
+	StatusPending SandboxStatus = "pending"
+	StatusRunning SandboxStatus = "running"
+	StatusStopped SandboxStatus = "stopped"
+	StatusFailed  SandboxStatus = "failed"
+	StatusExpired SandboxStatus = "expired"
+	// StatusDeleting is a deleted sandbox whose services failed to
+	// deprovision; the engine retries the delete
+	StatusDeleting SandboxStatus = "deleting"
+)
+
+// IsTerminal reports whether the status is final. Stopped sandboxes are not:
+// they can be started again.
+func (s SandboxStatus) IsTerminal() bool {
+	return s == StatusFailed || s == StatusExpired || s == StatusDeleting
+}
+
+// ExpiryBehavior describes what happens at expiry, so frontends can tell users
+// whether their work survives
+type ExpiryBehavior struct {
+	Action             string `json:"action"`               // delete
+	GracePeriodSeconds int    `json:"grace_period_seconds"` // delay between expiry and the action
+	Archive            bool   `json:"archive"`              // workspace archived before removal
+	RetentionSeconds   int    `json:"retention_seconds"`    // how long an archive is kept
+}
+
 // Sandbox represents a sandbox response
 type Sandbox struct {
-	ID          string                 `json:"id"`
-	TemplateID  string                 `json:"template_id"`
-	UserID      string                 `json:"user_id"`
-	Status      string                 `json:"status"`
-	StatusMsg   string                 `json:"status_message,omitempty"`
-	CreatedAt   time.Time              `json:"created_at"`
-	StartedAt   *time.Time             `json:"started_at,omitempty"`
-	ExpiresAt   time.Time              `json:"expires_at"`
-	ContainerID string                 `json:"container_id,omitempty"`
-	Services    map[string]interface{} `json:"services,omitempty"`
-	Endpoints   map[string]string      `json:"endpoints,omitempty"`
-	Metadata    map[string]string      `json:"metadata,omitempty"`
+	ID          string            `json:"id"`
+	TemplateID  string            `json:"template_id"`
+	UserID      string            `json:"user_id"`
+	Status      string            `json:"status"`
+	StatusMsg   string            `json:"status_message,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	StartedAt   *time.Time        `json:"started_at,omitempty"`
+	ExpiresAt   time.Time         `json:"expires_at"`
+	ContainerID string            `json:"container_id,omitempty"`
+	Endpoints   map[string]string `json:"endpoints,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+
+	// Services are the sandbox's provisioned services by name
+	Services map[string]*ServiceInstance `json:"services,omitempty"`
+
+	// ExpiryBehavior describes what happens at expiry; nil once the sandbox failed or expired
+	ExpiryBehavior *ExpiryBehavior `json:"expiry_behavior,omitempty"`
+}
+
+// ServiceURI returns the connection URI of the sandbox's service name, or ""
+// when it has no such service or the service has no URI
+func (s *Sandbox) ServiceURI(name string) string {
+	svc := s.Services[name]
+	if svc == nil || svc.Credentials == nil {
+		return ""
+	}
+	return svc.Credentials.URI
+}
+
+// Service instance statuses
+const (
+	ServiceStatusReady        = "ready"
+	ServiceStatusUnhealthy    = "unhealthy"
+	ServiceStatusProvisioning = "provisioning"
+)
+
+// ServiceInstance is a service provisioned for a sandbox
+type ServiceInstance struct {
+	Name        string              `json:"name"`
+	Type        string              `json:"type"`
+	Status      string              `json:"status"`
+	Credentials *ServiceCredentials `json:"credentials,omitempty"`
+	CreatedAt   time.Time           `json:"created_at"`
+
+	// Result of the last connectivity check
+	StatusMsg     string     `json:"status_message,omitempty"`
+	LastCheckedAt *time.Time `json:"last_checked_at,omitempty"`
+}
+
+// ServiceCredentials holds connection information for a service. Listing
+// services returns them with the password and token redacted.
+type ServiceCredentials struct {
+	Host      string `json:"host"`
+	Port      int    `json:"port"`
+	Username  string `json:"username,omitempty"`
+	Password  string `json:"password,omitempty"`
+	Database  string `json:"database,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Prefix    string `json:"prefix,omitempty"`
+	URI       string `json:"uri,omitempty"`
+	// Token is the session token of temporary credentials, e.g. an S3 key pair
+	Token string `json:"token,omitempty"`
 }
 
 // CreateSandboxRequest represents a sandbox creation request
 type CreateSandboxRequest struct {
-	TemplateID string            `json:"template_id"`
+	TemplateID string            `json:"template_id,omitempty"` // defaults to the task's project template
+	TaskID     string            `json:"task_id,omitempty"`
 	UserID     string            `json:"user_id"`
 	TTL        *time.Duration    `json:"ttl,omitempty"`
 	Env        map[string]string `json:"env,omitempty"`
 	Metadata   map[string]string `json:"metadata,omitempty"`
+	Command    []string          `json:"command,omitempty"` // requires allow_command_override on the template
 }
 
 // ExtendTTLRequest represents a TTL extension request
@@ -90,6 +172,47 @@ type ListOptions struct {
 	Status     string
 	Limit      int
 	Offset     int
+
+	// CreatedAfter (inclusive) and CreatedBefore (exclusive) bound the creation time; zero leaves a side open
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	// Metadata matches sandboxes whose metadata contains every given key/value pair
+	Metadata map[string]string
+
+	// Sort is created_at (default), expires_at, status or user_id; Order is
+	// "asc" or "desc" (default)
+	Sort  string
+	Order string
+}
+
+// SandboxList is a page of sandboxes; Total counts every sandbox matching the filters
+type SandboxList struct {
+	Sandboxes []*Sandbox `json:"sandboxes"`
+	Total     int        `json:"total"`
+	Limit     int        `json:"limit"`
+	Offset    int        `json:"offset"`
+}
+
+// SessionListOptions contains options for listing sessions
+type SessionListOptions struct {
+	// Status is a session status, or "invalid_template" for sessions whose template is gone
+	Status     string
+	TemplateID string
+	CreatedBy  string // name of the API client that created the session
+	Limit      int
+	Offset     int
+
+	// CreatedAfter (inclusive) and CreatedBefore (exclusive) bound the creation time; zero leaves a side open
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+}
+
+// SessionList is a page of sessions; Total counts every session matching the filters
+type SessionList struct {
+	Sessions []*Session `json:"sessions"`
+	Total    int        `json:"total"`
+	Limit    int        `json:"limit"`
+	Offset   int        `json:"offset"`
 }
 
 // CreateSandbox creates a new sandbox
@@ -177,6 +300,32 @@ func (c *Client) DeleteSandbox(ctx context.Context, id string) error {
 	return nil
 }
 
+// StartSandbox starts a stopped sandbox again
+func (c *Client) StartSandbox(ctx context.Context, id string) error {
+	resp, err := c.doRequest(ctx, "POST", fmt.Sprintf("/api/v1/sandboxes/%s/start", id), nil)
+	if err != nil {
+		return err
+	}
+
+	var result struct {
+		Success bool `json:"success"`
+		Error   *struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	if !result.Success {
+		return fmt.Errorf("API error: %s - %s", result.Error.Code, result.Error.Message)
+	}
+
+	return nil
+}
+
 // StopSandbox stops a running sandbox
 func (c *Client) StopSandbox(ctx context.Context, id string) error {
 	resp, err := c.doRequest(ctx, "POST", fmt.Sprintf("/api/v1/sandboxes/%s/stop", id), nil)
@@ -203,37 +352,49 @@ func (c *Client) StopSandbox(ctx context.Context, id string) error {
 	return nil
 }
 
-// ListSandboxes retrieves a list of sandboxes
-func (c *Client) ListSandboxes(ctx context.Context, opts ListOptions) ([]*Sandbox, error) {
-	path := "/api/v1/sandboxes?"
+// ListSandboxes retrieves a page of sandboxes along with the total matching count
+func (c *Client) ListSandboxes(ctx context.Context, opts ListOptions) (*SandboxList, error) {
+	params := url.Values{}
 	if opts.UserID != "" {
-		path += fmt.Sprintf("user_id=%s&", opts.UserID)
+		params.Set("user_id", opts.UserID)
 	}
 	if opts.TemplateID != "" {
-		path += fmt.Sprintf("template_id=%s&", opts.TemplateID)
+		params.Set("template_id", opts.TemplateID)
 	}
 	if opts.Status != "" {
-		path += fmt.Sprintf("status=%s&", opts.Status)
+		params.Set("status", opts.Status)
 	}
 	if opts.Limit > 0 {
-		path += fmt.Sprintf("limit=%d&", opts.Limit)
+		params.Set("limit", strconv.Itoa(opts.Limit))
 	}
 	if opts.Offset > 0 {
-		path += fmt.Sprintf("offset=%d&", opts.Offset)
+		params.Set("offset", strconv.Itoa(opts.Offset))
+	}
+	if !opts.CreatedAfter.IsZero() {
+		params.Set("created_after", opts.CreatedAfter.Format(time.RFC3339))
+	}
+	if !opts.CreatedBefore.IsZero() {
+		params.Set("created_before", opts.CreatedBefore.Format(time.RFC3339))
+	}
+	for key, value := range opts.Metadata {
+		params.Set("metadata."+key, value)
+	}
+	if opts.Sort != "" {
+		params.Set("sort", opts.Sort)
+	}
+	if opts.Order != "" {
+		params.Set("order", opts.Order)
 	}
 
-	resp, err := c.doRequest(ctx, "GET", path, nil)
+	resp, err := c.doRequest(ctx, "GET", "/api/v1/sandboxes?"+params.Encode(), nil)
 	if err != nil {
 		return nil, err
 	}
 
 	var result struct {
-		Success bool `json:"success"`
-		Data    struct {
-			Sandboxes []*Sandbox `json:"sandboxes"`
-			Total     int        `json:"total"`
-		} `json:"data"`
-		Error *struct {
+		Success bool         `json:"success"`
+		Data    *SandboxList `json:"data"`
+		Error   *struct {
 			Code    string `json:"code"`
 			Message string `json:"message"`
 		} `json:"error"`
@@ -247,7 +408,7 @@ func (c *Client) ListSandboxes(ctx context.Context, opts ListOptions) ([]*Sandbo
 		return nil, fmt.Errorf("API error: %s - %s", result.Error.Code, result.Error.Message)
 	}
 
-	return result.Data.Sandboxes, nil
+	return result.Data, nil
 }
 
 // ExtendTTL extends the expiration time of a sandbox
@@ -317,9 +478,203 @@ func (c *Client) GetLogs(ctx context.Context, id string, tail int) (string, erro
 	return result.Data.Logs, nil
 }
 
+// ExportLogsRequest selects sandboxes for a bulk log export
+type ExportLogsRequest struct {
+	UserID     string `json:"user_id,omitempty"`
+	TemplateID string `json:"template_id,omitempty"`
+	Status     string `json:"status,omitempty"`
+	TailBytes  int64  `json:"tail_bytes,omitempty"`
+}
+
+// ExportLogs streams a tar.gz with the logs of all matching sandboxes into w.
+// The archive holds one <sandbox-id>.log per sandbox and a manifest.json.
+// The client timeout covers the whole download; raise it with WithTimeout, or
+// for this call with WithRequestTimeout, for large exports. It is not retried.
+func (c *Client) ExportLogs(ctx context.Context, req ExportLogsRequest, w io.Writer) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/api/v1/sandboxes/logs/export", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	if c.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := c.httpClientFor(ctx).Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return newHTTPError(resp.StatusCode, respBody)
+	}
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("failed to read export: %w", err)
+	}
+
+	return nil
+}
+
+// ListServices retrieves the service instances of a sandbox (credentials redacted)
+func (c *Client) ListServices(ctx context.Context, id string) ([]*ServiceInstance, error) {
+	resp, err := c.doRequest(ctx, "GET", fmt.Sprintf("/api/v1/sandboxes/%s/services", id), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Success bool `json:"success"`
+		Data    struct {
+			Services []*ServiceInstance `json:"services"`
+			Total    int                `json:"total"`
+		} `json:"data"`
+		Error *struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	if !result.Success {
+		return nil, fmt.Errorf("API error: %s - %s", result.Error.Code, result.Error.Message)
+	}
+
+	return result.Data.Services, nil
+}
+
+// GetService retrieves a single service instance of a sandbox (credentials redacted)
+func (c *Client) GetService(ctx context.Context, id, name string) (*ServiceInstance, error) {
+	return c.serviceRequest(ctx, "GET", fmt.Sprintf("/api/v1/sandboxes/%s/services/%s", id, name))
+}
+
+// CheckService re-runs the connectivity check for a service instance and returns its updated status
+func (c *Client) CheckService(ctx context.Context, id, name string) (*ServiceInstance, error) {
+	return c.serviceRequest(ctx, "POST", fmt.Sprintf("/api/v1/sandboxes/%s/services/%s/healthcheck", id, name))
+}
+
+// serviceRequest performs a request returning a single service instance
+func (c *Client) serviceRequest(ctx context.Context, method, path string) (*ServiceInstance, error) {
+	resp, err := c.doRequest(ctx, method, path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Success bool             `json:"success"`
+		Data    *ServiceInstance `json:"data"`
+		Error   *struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	if !result.Success {
+		return nil, fmt.Errorf("API error: %s - %s", result.Error.Code, result.Error.Message)
+	}
+
+	return result.Data, nil
+}
+
+// Template is a sandbox template as the engine lists it
+type Template struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	BaseImage   string            `json:"base_image"`
+	Services    []string          `json:"services"`
+	Resources   Resources         `json:"resources"`
+	Env         map[string]string `json:"env"`
+	TTL         time.Duration     `json:"ttl"`
+	MaxTTL      time.Duration     `json:"max_ttl,omitempty"`
+	Expose      []Port            `json:"expose"`
+	Volumes     []Volume          `json:"volumes"`
+	Commands    Commands          `json:"commands"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	AutoExtend  *AutoExtendPolicy `json:"auto_extend,omitempty"`
+
+	Command              []string `json:"command,omitempty"`
+	Entrypoint           []string `json:"entrypoint,omitempty"`
+	AllowCommandOverride bool     `json:"allow_command_override,omitempty"`
+
+	Tags     []string `json:"tags,omitempty"`
+	Icon     string   `json:"icon,omitempty"`
+	Language string   `json:"language,omitempty"`
+	// Enabled false hides the template from the list and from new sandboxes
+	// and sessions; nil enables it
+	Enabled *bool `json:"enabled,omitempty"`
+
+	// Descriptions holds every locale of a description written with several
+	Descriptions map[string]string `json:"descriptions,omitempty"`
+}
+
+// IsEnabled reports whether new sandboxes and sessions may use the template
+func (t *Template) IsEnabled() bool {
+	return t.Enabled == nil || *t.Enabled
+}
+
+// Resources are the resource limits of a template's sandboxes
+type Resources struct {
+	CPULimit      string `json:"cpu_limit"`
+	MemoryLimit   string `json:"memory_limit"`
+	CPURequest    string `json:"cpu_request"`
+	MemoryRequest string `json:"memory_request"`
+	DiskLimit     string `json:"disk_limit"`
+	GPUs          string `json:"gpus,omitempty"` // a device count or "all"
+}
+
+// Port is a container port a template exposes
+type Port struct {
+	Container   int    `json:"container"`
+	Protocol    string `json:"protocol"`
+	Name        string `json:"name"`
+	Public      bool   `json:"public"`
+	TraefikRule string `json:"traefik_rule,omitempty"`
+}
+
+// Volume is a volume mount of a template
+type Volume struct {
+	Name      string `json:"name"`
+	MountPath string `json:"mount_path"`
+	ReadOnly  bool   `json:"read_only"`
+	Size      string `json:"size"`
+}
+
+// Commands are the lifecycle commands of a template
+type Commands struct {
+	Init        []string `json:"init"`
+	Start       []string `json:"start"`
+	Stop        []string `json:"stop"`
+	Healthcheck string   `json:"healthcheck"`
+}
+
+// AutoExtendPolicy is a template's override of the engine's auto-extend settings
+type AutoExtendPolicy struct {
+	Enabled *bool         `json:"enabled,omitempty"`
+	Window  time.Duration `json:"window,omitempty"`
+	Step    time.Duration `json:"step,omitempty"`
+	MaxTTL  time.Duration `json:"max_ttl,omitempty"`
+}
+
 // ListTemplates retrieves all available templates
-func (c *Client) ListTemplates(ctx context.Context) ([]*models.Template, error) {
-	resp, err := c.doRequest(ctx, "GET", "/api/v1/templates", nil)
+func (c *Client) ListTemplates(ctx context.Context) ([]*Template, error) {
+	// Template matches the legacy serialization (ttl in nanoseconds)
+	resp, err := c.doRequest(ctx, "GET", "/api/v1/templates?api_style=legacy", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -327,8 +682,8 @@ func (c *Client) ListTemplates(ctx context.Context) ([]*models.Template, error)
 	var result struct {
 		Success bool `json:"success"`
 		Data    struct {
-			Templates []*models.Template `json:"templates"`
-			Total     int                `json:"total"`
+			Templates []*Template `json:"templates"`
+			Total     int         `json:"total"`
 		} `json:"data"`
 		Error *struct {
 			Code    string `json:"code"`
@@ -347,40 +702,475 @@ func (c *Client) ListTemplates(ctx context.Context) ([]*models.Template, error)
 	return result.Data.Templates, nil
 }
 
+// TemplateUsage is the usage of a template, or of one of its catalog tasks
+type TemplateUsage struct {
+	TemplateID        string           `json:"template_id"`
+	TaskID            string           `json:"task_id,omitempty"`
+	Creations         int64            `json:"creations"`
+	Successes         int64            `json:"successes"`
+	Failures          int64            `json:"failures"`
+	AvgSessionSeconds float64          `json:"avg_session_seconds"`
+	LastUsedAt        *time.Time       `json:"last_used_at,omitempty"`
+	Tasks             []*TemplateUsage `json:"tasks,omitempty"`
+}
+
+// UsageReport is the usage of all templates over a date range
+type UsageReport struct {
+	From      time.Time        `json:"from"`
+	To        time.Time        `json:"to"`
+	Templates []*TemplateUsage `json:"templates"`
+	Totals    TemplateUsage    `json:"totals"`
+}
+
+// TemplateUsage retrieves template and catalog task usage for the UTC days from..to (inclusive).
+// Zero times leave the bound to the server default (the last 30 days).
+func (c *Client) TemplateUsage(ctx context.Context, from, to time.Time) (*UsageReport, error) {
+	params := url.Values{}
+	if !from.IsZero() {
+		params.Set("from", from.UTC().Format("2006-01-02"))
+	}
+	if !to.IsZero() {
+		params.Set("to", to.UTC().Format("2006-01-02"))
+	}
+
+	path := "/api/v1/admin/templates/usage"
+	if len(params) > 0 {
+		path += "?" + params.Encode()
+	}
+
+	resp, err := c.doRequest(ctx, "GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Success bool         `json:"success"`
+		Data    *UsageReport `json:"data"`
+		Error   *struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	if !result.Success {
+		return nil, fmt.Errorf("API error: %s - %s", result.Error.Code, result.Error.Message)
+	}
+
+	return result.Data, nil
+}
+
+// CreateSessions creates one session per entry of req, for a cohort of
+// candidates. Entries that fail are reported in the response's results
+// rather than as an error.
+func (c *Client) CreateSessions(ctx context.Context, req BulkCreateSessionsRequest) (*BulkCreateSessionsResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	resp, err := c.doRequest(ctx, "POST", "/api/v1/sessions/bulk", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Success bool                        `json:"success"`
+		Data    *BulkCreateSessionsResponse `json:"data"`
+		Error   *struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	if !result.Success {
+		return nil, fmt.Errorf("API error: %s - %s", result.Error.Code, result.Error.Message)
+	}
+
+	return result.Data, nil
+}
+
+// CreateSession creates a session for a candidate. The response carries the
+// join token and, when a callback URL was given, the callback secret, neither
+// of which can be read back later.
+func (c *Client) CreateSession(ctx context.Context, req CreateSessionRequest) (*CreateSessionResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	resp, err := c.doRequest(ctx, "POST", "/api/v1/sessions", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Success bool                   `json:"success"`
+		Data    *CreateSessionResponse `json:"data"`
+		Error   *struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	if !result.Success {
+		return nil, fmt.Errorf("API error: %s - %s", result.Error.Code, result.Error.Message)
+	}
+
+	return result.Data, nil
+}
+
+// GetSession retrieves a session by ID
+func (c *Client) GetSession(ctx context.Context, id string) (*Session, error) {
+	resp, err := c.doRequest(ctx, "GET", fmt.Sprintf("/api/v1/sessions/%s", id), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Success bool     `json:"success"`
+		Data    *Session `json:"data"`
+		Error   *struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	if !result.Success {
+		return nil, fmt.Errorf("API error: %s - %s", result.Error.Code, result.Error.Message)
+	}
+
+	return result.Data, nil
+}
+
+// DeleteSession deletes a session along with its sandbox
+func (c *Client) DeleteSession(ctx context.Context, id string) error {
+	resp, err := c.doRequest(ctx, "DELETE", fmt.Sprintf("/api/v1/sessions/%s", id), nil)
+	if err != nil {
+		return err
+	}
+
+	var result struct {
+		Success bool `json:"success"`
+		Error   *struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	if !result.Success {
+		return fmt.Errorf("API error: %s - %s", result.Error.Code, result.Error.Message)
+	}
+
+	return nil
+}
+
+// RevokeSession revokes a session, deleting its sandbox; the candidate's join
+// link then reports it as revoked
+func (c *Client) RevokeSession(ctx context.Context, id string) (*Session, error) {
+	resp, err := c.doRequest(ctx, "POST", fmt.Sprintf("/api/v1/sessions/%s/revoke", id), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Success bool     `json:"success"`
+		Data    *Session `json:"data"`
+		Error   *struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	if !result.Success {
+		return nil, fmt.Errorf("API error: %s - %s", result.Error.Code, result.Error.Message)
+	}
+
+	return result.Data, nil
+}
+
+// JoinSession retrieves what a candidate sees when opening their join link.
+// The token is the credential, so the API key is not sent. A revoked session
+// is returned with its status rather than as an error.
+func (c *Client) JoinSession(ctx context.Context, token string) (*JoinSessionResponse, error) {
+	var data JoinSessionResponse
+	if err := c.publicRequest(ctx, "GET", fmt.Sprintf("/api/v1/join/%s", url.PathEscape(token)), &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// ActivateSession starts a candidate's session, creating its sandbox. Like
+// JoinSession it authenticates with the token alone, and a revoked session is
+// returned with its status.
+func (c *Client) ActivateSession(ctx context.Context, token string) (*ActivateSessionResponse, error) {
+	var data ActivateSessionResponse
+	if err := c.publicRequest(ctx, "POST", fmt.Sprintf("/api/v1/join/%s/activate", url.PathEscape(token)), &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// publicRequest performs a request to a token-authenticated join endpoint
+// without the API key and unmarshals its data into out. These endpoints
+// answer 410 Gone with the session's status once it was revoked, which is
+// unmarshaled too.
+func (c *Client) publicRequest(ctx context.Context, method, path string, out any) error {
+	status, resp, err := c.send(ctx, method, path, nil, false)
+	if err != nil {
+		return err
+	}
+	if status >= 400 && status != http.StatusGone {
+		return newHTTPError(status, resp)
+	}
+
+	var result struct {
+		Data  json.RawMessage `json:"data"`
+		Error *struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	if result.Error != nil {
+		return fmt.Errorf("API error: %s - %s", result.Error.Code, result.Error.Message)
+	}
+
+	if err := json.Unmarshal(result.Data, out); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	return nil
+}
+
+// ListSessions retrieves a page of sessions along with the total matching count
+func (c *Client) ListSessions(ctx context.Context, opts SessionListOptions) (*SessionList, error) {
+	params := url.Values{}
+	if opts.Status != "" {
+		params.Set("status", opts.Status)
+	}
+	if opts.TemplateID != "" {
+		params.Set("template_id", opts.TemplateID)
+	}
+	if opts.CreatedBy != "" {
+		params.Set("created_by", opts.CreatedBy)
+	}
+	if opts.Limit > 0 {
+		params.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Offset > 0 {
+		params.Set("offset", strconv.Itoa(opts.Offset))
+	}
+	if !opts.CreatedAfter.IsZero() {
+		params.Set("created_after", opts.CreatedAfter.Format(time.RFC3339))
+	}
+	if !opts.CreatedBefore.IsZero() {
+		params.Set("created_before", opts.CreatedBefore.Format(time.RFC3339))
+	}
+
+	resp, err := c.doRequest(ctx, "GET", "/api/v1/sessions?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Success bool         `json:"success"`
+		Data    *SessionList `json:"data"`
+		Error   *struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	if !result.Success {
+		return nil, fmt.Errorf("API error: %s - %s", result.Error.Code, result.Error.Message)
+	}
+
+	return result.Data, nil
+}
+
 // Health checks if the service is healthy
 func (c *Client) Health(ctx context.Context) error {
 	_, err := c.doRequest(ctx, "GET", "/health", nil)
 	return err
 }
 
+// ServerVersion is the build of the engine and its service providers
+type ServerVersion struct {
+	Version   string   `json:"version"`
+	Commit    string   `json:"commit"`
+	BuildDate string   `json:"build_date"`
+	GoVersion string   `json:"go_version"`
+	Services  []string `json:"services"`
+}
+
+// ServerVersion retrieves the build information of the engine
+func (c *Client) ServerVersion(ctx context.Context) (*ServerVersion, error) {
+	resp, err := c.doRequest(ctx, "GET", "/version", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Success bool           `json:"success"`
+		Data    *ServerVersion `json:"data"`
+		Error   *struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	if !result.Success {
+		return nil, fmt.Errorf("API error: %s - %s", result.Error.Code, result.Error.Message)
+	}
+
+	return result.Data, nil
+}
+
 // doRequest performs an HTTP request
 func (c *Client) doRequest(ctx context.Context, method, path string, body io.Reader) ([]byte, error) {
-	url := c.baseURL + path
+	status, respBody, err := c.send(ctx, method, path, body, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if status >= 400 {
+		return nil, newHTTPError(status, respBody)
+	}
+
+	return respBody, nil
+}
+
+// HTTPError is returned for responses with a status of 400 or more. Code and
+// Message come from the API's error envelope, and are empty when the body
+// isn't one.
+type HTTPError struct {
+	StatusCode int
+	Code       string
+	Message    string
+	Body       []byte
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("HTTP %d: %s", e.StatusCode, string(e.Body))
+}
+
+func newHTTPError(status int, body []byte) *HTTPError {
+	e := &HTTPError{StatusCode: status, Body: body}
+	var result struct {
+		Error *struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &result) == nil && result.Error != nil {
+		e.Code, e.Message = result.Error.Code, result.Error.Message
+	}
+	return e
+}
 
-	req, err := http.NewRequestWithContext(ctx, method, url, body)
+// send performs an HTTP request, with the API key when withKey is set, and
+// returns the response status and body. The request is retried according to
+// the client's RetryPolicy, so the body is buffered to be sent again.
+func (c *Client) send(ctx context.Context, method, path string, body io.Reader, withKey bool) (int, []byte, error) {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = io.ReadAll(body); err != nil {
+			return 0, nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+	}
+
+	key := idempotencyKey(ctx)
+	retry := c.retry.MaxAttempts > 1 && (idempotent(method) || key != "")
+	backoff := c.retry.Backoff
+	for attempt := 1; ; attempt++ {
+		status, header, respBody, err := c.attempt(ctx, method, path, payload, key, withKey)
+		if !retry || attempt >= c.retry.MaxAttempts || !retryable(ctx, status, err) {
+			return status, respBody, err
+		}
+
+		wait := backoff
+		if after, ok := retryAfter(header); ok {
+			// A server or proxy may ask for hours; the call would look hung
+			wait = min(after, c.retry.MaxRetryAfter)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return status, respBody, err
+		}
+		backoff = min(backoff*2, c.retry.MaxBackoff)
+	}
+}
+
+// attempt sends a request once
+func (c *Client) attempt(ctx context.Context, method, path string, payload []byte, key string, withKey bool) (int, http.Header, []byte, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return 0, nil, nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	if c.apiKey != "" {
+	if withKey && c.apiKey != "" {
 		req.Header.Set("Authorization", "Bearer "+c.apiKey)
 	}
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.httpClientFor(ctx).Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
+		return 0, nil, nil, fmt.Errorf("request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
-	}
-
-	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(respBody))
+		return 0, nil, nil, fmt.Errorf("failed to read response: %w", err)
 	}
 
-	return respBody, nil
+	return resp.StatusCode, resp.Header, respBody, nil
 }
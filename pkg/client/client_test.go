@@ -0,0 +1,164 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCreateSession(t *testing.T) {
+	var got CreateSessionRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v1/sessions" || r.Header.Get("Authorization") != "Bearer key" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]any{"success": true, "data": CreateSessionResponse{ID: "s-1", Token: "tok", Status: SessionReady}})
+	}))
+	defer ts.Close()
+
+	resp, err := NewClient(ts.URL, "key").CreateSession(context.Background(), CreateSessionRequest{TemplateID: "python", TTL: 3600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.TemplateID != "python" || got.TTL != 3600 {
+		t.Errorf("unexpected request body %+v", got)
+	}
+	if resp.ID != "s-1" || resp.Token != "tok" || resp.Status != SessionReady {
+		t.Errorf("unexpected response %+v", resp)
+	}
+}
+
+func TestGetSessionNotFound(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]any{"success": false, "error": map[string]string{"code": "not_found", "message": "session not found"}})
+	}))
+	defer ts.Close()
+
+	_, err := NewClient(ts.URL, "key").GetSession(context.Background(), "missing")
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("expected the lookup error, got %v", err)
+	}
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusNotFound || httpErr.Code != "not_found" || httpErr.Message != "session not found" {
+		t.Errorf("expected an HTTPError with the envelope's code, got %#v", err)
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v1/sessions/s-1/revoke" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		json.NewEncoder(w).Encode(map[string]any{"success": true, "data": Session{ID: "s-1", Status: SessionRevoked}})
+	}))
+	defer ts.Close()
+
+	session, err := NewClient(ts.URL, "key").RevokeSession(context.Background(), "s-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Status != SessionRevoked {
+		t.Errorf("expected the revoked session, got %+v", session)
+	}
+}
+
+func TestJoinSessionWithoutAPIKey(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); auth != "" {
+			t.Errorf("expected no API key on %s, got %q", r.URL.Path, auth)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v1/join/tok":
+			json.NewEncoder(w).Encode(map[string]any{"success": true, "data": JoinSessionResponse{Status: SessionReady, TaskDescription: "fix the bug"}})
+		case "/api/v1/join/tok/activate":
+			json.NewEncoder(w).Encode(map[string]any{"success": true, "data": ActivateSessionResponse{Status: SessionActive, SandboxID: "sb-1"}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	c := NewClient(ts.URL, "key")
+	join, err := c.JoinSession(context.Background(), "tok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if join.Status != SessionReady || join.TaskDescription != "fix the bug" {
+		t.Errorf("unexpected join response %+v", join)
+	}
+
+	activate, err := c.ActivateSession(context.Background(), "tok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if activate.Status != SessionActive || activate.SandboxID != "sb-1" {
+		t.Errorf("unexpected activate response %+v", activate)
+	}
+}
+
+func TestJoinSessionRevoked(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusGone)
+		json.NewEncoder(w).Encode(map[string]any{"success": false, "data": JoinSessionResponse{Status: SessionRevoked}})
+	}))
+	defer ts.Close()
+
+	join, err := NewClient(ts.URL, "key").JoinSession(context.Background(), "tok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if join.Status != SessionRevoked {
+		t.Errorf("expected the revoked status, got %+v", join)
+	}
+}
+
+func TestActivateSessionConflict(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]any{"success": false, "error": map[string]string{"code": "not_ready", "message": "session is not in ready state"}})
+	}))
+	defer ts.Close()
+
+	_, err := NewClient(ts.URL, "").ActivateSession(context.Background(), "tok")
+	if err == nil || !strings.Contains(err.Error(), "409") {
+		t.Errorf("expected the conflict error, got %v", err)
+	}
+}
+
+func TestSandboxServices(t *testing.T) {
+	// Fields a newer server might add, at every level, are ignored
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"success":true,"data":{"id":"sb-1","status":"running","region":"eu-1","services":{
+			"postgres":{"name":"postgres","type":"postgres","status":"ready","size_mb":12,
+				"credentials":{"host":"pg","port":5432,"database":"sb_1","uri":"postgres://u:p@pg:5432/sb_1","sslmode":"disable"}},
+			"redis":{"name":"redis","type":"redis","status":"provisioning"}}}}`)
+	}))
+	defer ts.Close()
+
+	sb, err := NewClient(ts.URL, "key").GetSandbox(context.Background(), "sb-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pg := sb.Services["postgres"]; pg == nil || pg.Status != ServiceStatusReady || pg.Credentials.Port != 5432 {
+		t.Errorf("unexpected postgres service %+v", pg)
+	}
+	if uri := sb.ServiceURI("postgres"); uri != "postgres://u:p@pg:5432/sb_1" {
+		t.Errorf("unexpected postgres URI %q", uri)
+	}
+	if sb.ServiceURI("redis") != "" || sb.ServiceURI("mongo") != "" {
+		t.Error("expected no URI for a service without credentials or a missing one")
+	}
+}
@@ -0,0 +1,132 @@
+package clienttest
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/pkg/client"
+)
+
+var errCatalogNotFound = APIError(http.StatusNotFound, "not_found", "not found")
+
+// ListTemplates returns the enabled Templates
+func (f *Fake) ListTemplates(ctx context.Context) ([]*client.Template, error) {
+	if err := f.call(ctx, "ListTemplates"); err != nil {
+		return nil, err
+	}
+	templates := make([]*client.Template, 0, len(f.Templates))
+	for _, t := range f.Templates {
+		if t.IsEnabled() {
+			templates = append(templates, t)
+		}
+	}
+	return templates, nil
+}
+
+// TemplateUsage counts the sandboxes the fake holds per template, by when
+// they were created; a zero from or to leaves that end open
+func (f *Fake) TemplateUsage(ctx context.Context, from, to time.Time) (*client.UsageReport, error) {
+	if err := f.call(ctx, "TemplateUsage", from, to); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	report := &client.UsageReport{From: from, To: to}
+	byTemplate := make(map[string]*client.TemplateUsage)
+	for _, id := range f.sandboxOrder {
+		fs, _ := f.sandbox(id)
+		sb := fs.sb
+		if !from.IsZero() && sb.CreatedAt.Before(from) || !to.IsZero() && !sb.CreatedAt.Before(to) {
+			continue
+		}
+		usage := byTemplate[sb.TemplateID]
+		if usage == nil {
+			usage = &client.TemplateUsage{TemplateID: sb.TemplateID}
+			byTemplate[sb.TemplateID] = usage
+			report.Templates = append(report.Templates, usage)
+		}
+		for _, u := range []*client.TemplateUsage{usage, &report.Totals} {
+			u.Creations++
+			switch sb.Status {
+			case string(client.StatusFailed):
+				u.Failures++
+			case string(client.StatusRunning), string(client.StatusStopped), string(client.StatusExpired):
+				u.Successes++
+			}
+			if createdAt := sb.CreatedAt; u.LastUsedAt == nil || createdAt.After(*u.LastUsedAt) {
+				u.LastUsedAt = &createdAt
+			}
+		}
+	}
+	return report, nil
+}
+
+// ListDomains returns Domains
+func (f *Fake) ListDomains(ctx context.Context) ([]*client.Domain, error) {
+	if err := f.call(ctx, "ListDomains"); err != nil {
+		return nil, err
+	}
+	return f.Domains, nil
+}
+
+// GetDomain returns the domain with domainID from Domains
+func (f *Fake) GetDomain(ctx context.Context, domainID string) (*client.Domain, error) {
+	if err := f.call(ctx, "GetDomain", domainID); err != nil {
+		return nil, err
+	}
+	return find(f.Domains, func(d *client.Domain) bool { return d.ID == domainID })
+}
+
+// ListProjects returns the projects of a domain from Projects
+func (f *Fake) ListProjects(ctx context.Context, domainID string) ([]*client.CatalogProject, error) {
+	if err := f.call(ctx, "ListProjects", domainID); err != nil {
+		return nil, err
+	}
+	return filter(f.Projects, func(p *client.CatalogProject) bool { return p.DomainID == domainID }), nil
+}
+
+// GetProject returns the project with projectID from Projects
+func (f *Fake) GetProject(ctx context.Context, projectID string) (*client.CatalogProject, error) {
+	if err := f.call(ctx, "GetProject", projectID); err != nil {
+		return nil, err
+	}
+	return find(f.Projects, func(p *client.CatalogProject) bool { return p.ID == projectID })
+}
+
+// ListTasks returns the tasks of a project from Tasks
+func (f *Fake) ListTasks(ctx context.Context, projectID string) ([]*client.CatalogTask, error) {
+	if err := f.call(ctx, "ListTasks", projectID); err != nil {
+		return nil, err
+	}
+	return filter(f.Tasks, func(t *client.CatalogTask) bool { return t.ProjectID == projectID }), nil
+}
+
+// GetTask returns the task with taskID from Tasks
+func (f *Fake) GetTask(ctx context.Context, taskID string) (*client.CatalogTask, error) {
+	if err := f.call(ctx, "GetTask", taskID); err != nil {
+		return nil, err
+	}
+	return find(f.Tasks, func(t *client.CatalogTask) bool { return t.ID == taskID })
+}
+
+func find[T any](items []T, match func(T) bool) (T, error) {
+	for _, item := range items {
+		if match(item) {
+			return item, nil
+		}
+	}
+	var zero T
+	return zero, errCatalogNotFound
+}
+
+func filter[T any](items []T, match func(T) bool) []T {
+	out := []T{}
+	for _, item := range items {
+		if match(item) {
+			out = append(out, item)
+		}
+	}
+	return out
+}
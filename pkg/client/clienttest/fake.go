@@ -0,0 +1,230 @@
+// Package clienttest provides Fake, an in-memory client.Interface for testing
+// code built on the SDK without a running engine.
+package clienttest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/pkg/client"
+)
+
+// Fake is an in-memory client.Interface. Sandboxes and sessions live in maps,
+// statuses follow the rules of the engine closely enough for orchestration
+// logic, and errors are *client.HTTPError with the engine's status and code.
+//
+// Scripts set the statuses created sandboxes go through, FailNext injects
+// errors, SetLatency slows calls down, and Calls records every call. TTLs
+// are measured against Now, which a Clock can drive. A Fake is safe for
+// concurrent use; set its fields before the first call.
+type Fake struct {
+	// Now is the fake's clock, time.Now when nil; see Clock
+	Now func() time.Time
+
+	// Templates are what ListTemplates returns, the disabled ones left out.
+	// When set, creating a sandbox or session from a template not listed
+	// fails with template_not_found, or template_disabled for a disabled one.
+	Templates []*client.Template
+	// Domains, Projects and Tasks are the catalog
+	Domains  []*client.Domain
+	Projects []*client.CatalogProject
+	Tasks    []*client.CatalogTask
+
+	// ExecFunc runs the commands of Exec; when nil they succeed with no output
+	ExecFunc func(id string, req client.ExecRequest) (*client.ExecResult, error)
+
+	mu           sync.Mutex
+	calls        []Call
+	failures     map[string][]error
+	latencies    map[string]time.Duration
+	scripts      []Script
+	sandboxes    map[string]*fakeSandbox
+	sessions     map[string]*client.Session
+	sandboxOrder []string
+	sessionOrder []string
+	nextID       int
+}
+
+// Call is a call made to a Fake
+type Call struct {
+	Method string
+	Args   []any // the arguments after the context
+}
+
+// Script is the statuses a created sandbox goes through: CreateSandbox
+// returns the first, and each GetSandbox moves to the next until the last.
+// Message becomes the status message with the last status, e.g. why the
+// sandbox failed. The script []{pending, pending, pending, failed} keeps a
+// sandbox pending for two polls, then fails it on the third.
+type Script struct {
+	Statuses []client.SandboxStatus
+	Message  string
+}
+
+// New returns an empty Fake
+func New() *Fake {
+	return &Fake{
+		failures:  make(map[string][]error),
+		latencies: make(map[string]time.Duration),
+		sandboxes: make(map[string]*fakeSandbox),
+		sessions:  make(map[string]*client.Session),
+	}
+}
+
+var _ client.Interface = (*Fake)(nil)
+
+// ScriptNextSandbox sets the statuses of the next sandbox created, by
+// CreateSandbox or a session activation. Scripts queue up in order; sandboxes
+// created without one are running at once.
+func (f *Fake) ScriptNextSandbox(s Script) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.scripts = append(f.scripts, s)
+}
+
+// FailNext makes the next calls to method return errs, one per call, before
+// it behaves normally again. APIError builds errors like the engine's.
+func (f *Fake) FailNext(method string, errs ...error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures[method] = append(f.failures[method], errs...)
+}
+
+// SetLatency delays every call to method by d, or every call when method is
+// "". A call whose context ends first fails like a timed out request.
+func (f *Fake) SetLatency(method string, d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.latencies[method] = d
+}
+
+// Calls returns the calls made so far, oldest first. Helpers record their own
+// call and the calls they make: WaitForStatus is followed by its GetSandbox
+// polls, and an iterator by its ListSandboxes or ListSessions pages.
+func (f *Fake) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Call(nil), f.calls...)
+}
+
+// CallsTo returns the calls made to method, oldest first
+func (f *Fake) CallsTo(method string) []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var calls []Call
+	for _, c := range f.calls {
+		if c.Method == method {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// APIError returns the error the SDK reports for an engine error response
+func APIError(status int, code, message string) *client.HTTPError {
+	body, _ := json.Marshal(map[string]any{
+		"success": false,
+		"error":   map[string]string{"code": code, "message": message},
+	})
+	return &client.HTTPError{StatusCode: status, Code: code, Message: message, Body: body}
+}
+
+// call records a call to method, waits out its latency and returns the error
+// injected for it, if any
+func (f *Fake) call(ctx context.Context, method string, args ...any) error {
+	f.mu.Lock()
+	f.calls = append(f.calls, Call{Method: method, Args: args})
+	latency := f.latencies[method] + f.latencies[""]
+	var err error
+	if errs := f.failures[method]; len(errs) > 0 {
+		err, f.failures[method] = errs[0], errs[1:]
+	}
+	f.mu.Unlock()
+
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("request failed: %w", ctx.Err())
+		}
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("request failed: %w", ctxErr)
+	}
+	return err
+}
+
+func (f *Fake) now() time.Time {
+	if f.Now != nil {
+		return f.Now()
+	}
+	return time.Now()
+}
+
+// newID returns a fresh ID with prefix; callers hold f.mu
+func (f *Fake) newID(prefix string) string {
+	f.nextID++
+	return fmt.Sprintf("%s-%d", prefix, f.nextID)
+}
+
+// template returns the named template, and whether creating from it is
+// allowed; callers hold f.mu
+func (f *Fake) template(name string) (*client.Template, bool) {
+	for _, t := range f.Templates {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return nil, len(f.Templates) == 0
+}
+
+func newToken() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Health succeeds unless an error is injected
+func (f *Fake) Health(ctx context.Context) error {
+	return f.call(ctx, "Health")
+}
+
+// ServerVersion reports the version "fake"
+func (f *Fake) ServerVersion(ctx context.Context) (*client.ServerVersion, error) {
+	if err := f.call(ctx, "ServerVersion"); err != nil {
+		return nil, err
+	}
+	return &client.ServerVersion{Version: "fake"}, nil
+}
+
+// Clock is a manual clock for Fake.Now
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewClock returns a clock stopped at now
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+// Now returns the clock's time
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
@@ -0,0 +1,251 @@
+package clienttest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/pkg/client"
+)
+
+func TestScriptedCreation(t *testing.T) {
+	f := New()
+	f.ScriptNextSandbox(Script{
+		Statuses: []client.SandboxStatus{client.StatusPending, client.StatusPending, client.StatusPending, client.StatusFailed},
+		Message:  "image pull failed",
+	})
+
+	sb, err := f.CreateAndWait(context.Background(), client.CreateSandboxRequest{TemplateID: "python", UserID: "u-1"})
+	var failed *client.SandboxFailedError
+	if !errors.As(err, &failed) || failed.Sandbox.StatusMsg != "image pull failed" {
+		t.Fatalf("expected a SandboxFailedError, got %v", err)
+	}
+	if sb.Status != string(client.StatusFailed) {
+		t.Errorf("expected the failed sandbox returned, got %s", sb.Status)
+	}
+	if polls := len(f.CallsTo("GetSandbox")); polls != 3 {
+		t.Errorf("expected 3 polls, got %d", polls)
+	}
+
+	sb, err = f.CreateAndWait(context.Background(), client.CreateSandboxRequest{TemplateID: "python", UserID: "u-1"})
+	if err != nil || sb.Status != string(client.StatusRunning) {
+		t.Errorf("expected an unscripted sandbox running at once, got %v %v", sb, err)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	clock := NewClock(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	f := New()
+	f.Now = clock.Now
+	ctx := context.Background()
+
+	ttl := 10 * time.Minute
+	sb, _ := f.CreateSandbox(ctx, client.CreateSandboxRequest{TemplateID: "python", UserID: "u-1", TTL: &ttl})
+	clock.Advance(5 * time.Minute)
+	if _, err := f.ExtendTTL(ctx, sb.ID, 5*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(9 * time.Minute)
+	if got, _ := f.GetSandbox(ctx, sb.ID); got.Status != string(client.StatusRunning) {
+		t.Errorf("expected the extended sandbox running, got %s", got.Status)
+	}
+	clock.Advance(time.Minute)
+	if got, _ := f.GetSandbox(ctx, sb.ID); got.Status != string(client.StatusExpired) {
+		t.Errorf("expected the sandbox expired, got %s", got.Status)
+	}
+
+	var httpErr *client.HTTPError
+	if _, err := f.ExtendTTL(ctx, sb.ID, time.Minute); !errors.As(err, &httpErr) || httpErr.Code != "sandbox_not_active" {
+		t.Errorf("expected sandbox_not_active, got %v", err)
+	}
+}
+
+func TestFailNextAndLatency(t *testing.T) {
+	f := New()
+	f.FailNext("GetSandbox", APIError(http.StatusServiceUnavailable, "unavailable", "try again"))
+	ctx := context.Background()
+
+	sb, _ := f.CreateSandbox(ctx, client.CreateSandboxRequest{TemplateID: "python", UserID: "u-1"})
+	var httpErr *client.HTTPError
+	if _, err := f.GetSandbox(ctx, sb.ID); !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected the injected error, got %v", err)
+	}
+	if _, err := f.GetSandbox(ctx, sb.ID); err != nil {
+		t.Errorf("expected the next call to succeed, got %v", err)
+	}
+
+	f.SetLatency("GetSandbox", time.Second)
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := f.GetSandbox(timeout, sb.ID); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the call to time out, got %v", err)
+	}
+
+	calls := f.Calls()
+	if len(calls) != 4 || calls[0].Method != "CreateSandbox" || calls[1].Args[0] != sb.ID {
+		t.Errorf("unexpected calls %+v", calls)
+	}
+}
+
+func TestSessionFlow(t *testing.T) {
+	f := New()
+	f.Templates = []*client.Template{{Name: "python", TTL: 30 * time.Minute}}
+	ctx := context.Background()
+
+	var httpErr *client.HTTPError
+	if _, err := f.CreateSession(ctx, client.CreateSessionRequest{TemplateID: "go"}); !errors.As(err, &httpErr) || httpErr.Code != "template_not_found" {
+		t.Errorf("expected template_not_found, got %v", err)
+	}
+
+	created, err := f.CreateSession(ctx, client.CreateSessionRequest{TemplateID: "python"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.ScriptNextSandbox(Script{Statuses: []client.SandboxStatus{client.StatusPending, client.StatusRunning}})
+	activated, err := f.ActivateSession(ctx, created.Token)
+	if err != nil || activated.Status != client.SessionProvisioning {
+		t.Fatalf("expected the session provisioning, got %+v %v", activated, err)
+	}
+	if _, err := f.ActivateSession(ctx, created.Token); !errors.As(err, &httpErr) || httpErr.Code != "not_ready" {
+		t.Errorf("expected not_ready on a second activation, got %v", err)
+	}
+
+	f.GetSandbox(ctx, activated.SandboxID)
+	s, _ := f.GetSession(ctx, created.ID)
+	if s.Status != client.SessionActive || s.TTLSeconds != 1800 || s.ExpiresAt == nil {
+		t.Errorf("expected the session active with the template's TTL, got %+v", s)
+	}
+
+	if s, err = f.RevokeSession(ctx, created.ID); err != nil || s.Status != client.SessionRevoked {
+		t.Fatalf("expected the session revoked, got %+v %v", s, err)
+	}
+	if _, err := f.GetSandbox(ctx, activated.SandboxID); !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusNotFound {
+		t.Errorf("expected the sandbox deleted with the revoke, got %v", err)
+	}
+	if joined, err := f.JoinSession(ctx, created.Token); err != nil || joined.Status != client.SessionRevoked {
+		t.Errorf("expected a revoked join reported by status, got %+v %v", joined, err)
+	}
+}
+
+func TestHelpersHonorFailuresAndLatency(t *testing.T) {
+	f := New()
+	ctx := context.Background()
+	f.AddSandbox(client.Sandbox{ID: "sb-1", Status: string(client.StatusRunning), ExpiresAt: time.Now().Add(time.Hour)})
+	injected := APIError(http.StatusServiceUnavailable, "unavailable", "try again")
+
+	f.FailNext("WaitForStatus", injected)
+	if _, err := f.WaitForStatus(ctx, "sb-1", client.StatusRunning, client.WaitOptions{}); !errors.Is(err, injected) {
+		t.Errorf("expected the injected WaitForStatus error, got %v", err)
+	}
+	if polls := len(f.CallsTo("GetSandbox")); polls != 0 {
+		t.Errorf("expected no polls after the injected error, got %d", polls)
+	}
+
+	f.FailNext("CreateAndWait", injected)
+	if _, err := f.CreateAndWait(ctx, client.CreateSandboxRequest{TemplateID: "python", UserID: "u-1"}); !errors.Is(err, injected) {
+		t.Errorf("expected the injected CreateAndWait error, got %v", err)
+	}
+
+	f.FailNext("Sandboxes", injected)
+	it := f.Sandboxes(ctx, client.ListOptions{})
+	if it.Next() || !errors.Is(it.Err(), injected) {
+		t.Errorf("expected the injected Sandboxes error from the first page, got %v", it.Err())
+	}
+	if it = f.Sandboxes(ctx, client.ListOptions{}); !it.Next() || it.Value().ID != "sb-1" {
+		t.Errorf("expected the next iteration to list the sandbox, got %v", it.Err())
+	}
+
+	f.SetLatency("Sessions", time.Second)
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if sessions := f.Sessions(timeout, client.SessionListOptions{}); sessions.Next() || !errors.Is(sessions.Err(), context.DeadlineExceeded) {
+		t.Errorf("expected Sessions to time out, got %v", sessions.Err())
+	}
+}
+
+func TestWaitForStatusTimesOut(t *testing.T) {
+	f := New()
+	f.AddSandbox(client.Sandbox{ID: "sb-1", Status: string(client.StatusPending), ExpiresAt: time.Now().Add(time.Hour)})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	sb, err := f.WaitForStatus(ctx, "sb-1", client.StatusRunning, client.WaitOptions{Interval: 10 * time.Millisecond})
+	if !errors.Is(err, context.DeadlineExceeded) || sb == nil || sb.Status != string(client.StatusPending) {
+		t.Errorf("expected the pending sandbox with a deadline error, got %v %v", sb, err)
+	}
+}
+
+func TestCatalog(t *testing.T) {
+	f := New()
+	f.Templates = []*client.Template{{Name: "python"}, {Name: "legacy", Enabled: new(bool)}}
+	f.Domains = []*client.Domain{{ID: "backend"}}
+	f.Projects = []*client.CatalogProject{{ID: "backend/shop", DomainID: "backend"}, {ID: "frontend/app", DomainID: "frontend"}}
+	f.Tasks = []*client.CatalogTask{{ID: "backend/shop/cart", ProjectID: "backend/shop"}}
+	ctx := context.Background()
+
+	if templates, err := f.ListTemplates(ctx); err != nil || len(templates) != 1 || templates[0].Name != "python" {
+		t.Errorf("expected only the enabled template, got %v %v", templates, err)
+	}
+	if d, err := f.GetDomain(ctx, "backend"); err != nil || d.ID != "backend" {
+		t.Errorf("expected the domain, got %v %v", d, err)
+	}
+	if projects, err := f.ListProjects(ctx, "backend"); err != nil || len(projects) != 1 || projects[0].ID != "backend/shop" {
+		t.Errorf("expected the projects of the domain, got %v %v", projects, err)
+	}
+	if tasks, err := f.ListTasks(ctx, "frontend/app"); err != nil || tasks == nil || len(tasks) != 0 {
+		t.Errorf("expected an empty task list, got %v %v", tasks, err)
+	}
+	if task, err := f.GetTask(ctx, "backend/shop/cart"); err != nil || task.ProjectID != "backend/shop" {
+		t.Errorf("expected the task, got %v %v", task, err)
+	}
+
+	var httpErr *client.HTTPError
+	if _, err := f.GetProject(ctx, "backend/missing"); !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusNotFound {
+		t.Errorf("expected not_found for a missing project, got %v", err)
+	}
+}
+
+func TestExecAndFiles(t *testing.T) {
+	f := New()
+	f.ExecFunc = func(id string, req client.ExecRequest) (*client.ExecResult, error) {
+		return &client.ExecResult{ExitCode: 3, Stdout: id + ":" + req.Command[0]}, nil
+	}
+	ctx := context.Background()
+	f.AddSandbox(client.Sandbox{ID: "sb-1", Status: string(client.StatusRunning), ExpiresAt: time.Now().Add(time.Hour)})
+	f.AddSandbox(client.Sandbox{ID: "sb-2", Status: string(client.StatusStopped), ExpiresAt: time.Now().Add(time.Hour)})
+
+	if res, err := f.Exec(ctx, "sb-1", client.ExecRequest{Command: []string{"ls"}}); err != nil || res.ExitCode != 3 || res.Stdout != "sb-1:ls" {
+		t.Errorf("expected the ExecFunc result, got %+v %v", res, err)
+	}
+	var httpErr *client.HTTPError
+	if _, err := f.Exec(ctx, "sb-1", client.ExecRequest{}); !errors.As(err, &httpErr) || httpErr.Code != "validation_error" {
+		t.Errorf("expected validation_error for an empty command, got %v", err)
+	}
+	if _, err := f.Exec(ctx, "sb-2", client.ExecRequest{Command: []string{"ls"}}); !errors.As(err, &httpErr) || httpErr.Code != "sandbox_not_running" {
+		t.Errorf("expected sandbox_not_running for a stopped sandbox, got %v", err)
+	}
+
+	if err := f.UploadFile(ctx, "sb-1", "/workspace/../tmp/a.txt", strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	download, err := f.DownloadFile(ctx, "sb-1", "/tmp/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(download)
+	download.Close()
+	if string(data) != "hello" || download.Size != 5 {
+		t.Errorf("expected the uploaded file, got %q (%d bytes)", data, download.Size)
+	}
+
+	if err := f.UploadFile(ctx, "sb-1", "relative.txt", strings.NewReader("x")); !errors.As(err, &httpErr) || httpErr.Code != "validation_error" {
+		t.Errorf("expected validation_error for a relative path, got %v", err)
+	}
+	if _, err := f.DownloadFile(ctx, "sb-1", "/tmp/missing.txt"); !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusNotFound {
+		t.Errorf("expected not_found for a missing file, got %v", err)
+	}
+}
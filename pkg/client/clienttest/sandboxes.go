@@ -0,0 +1,676 @@
+package clienttest
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/pkg/client"
+)
+
+// defaultTTL is the TTL of sandboxes whose request and template set none
+const defaultTTL = time.Hour
+
+type fakeSandbox struct {
+	sb      client.Sandbox
+	pending []client.SandboxStatus // scripted statuses still to come
+	message string                 // status message of the last scripted status
+	logs    string
+	files   map[string][]byte
+}
+
+var (
+	errSandboxNotFound    = APIError(http.StatusNotFound, "not_found", "sandbox not found")
+	errSandboxNotRunning  = APIError(http.StatusConflict, "sandbox_not_running", "sandbox is not running")
+	errTemplateNotFound   = APIError(http.StatusNotFound, "template_not_found", "template not found")
+	errTemplateDisabled   = APIError(http.StatusConflict, "template_disabled", "template is disabled")
+	errSandboxNotActive   = APIError(http.StatusConflict, "sandbox_not_active", "sandbox has failed or expired")
+	errAlreadyRunning     = APIError(http.StatusConflict, "already_running", "sandbox is already running")
+	errAlreadyStopped     = APIError(http.StatusConflict, "already_stopped", "sandbox is already stopped")
+	errSandboxExpired     = APIError(http.StatusConflict, "sandbox_expired", "sandbox has expired, extend its TTL first")
+	errOnlyStoppedStarted = APIError(http.StatusConflict, "sandbox_not_active", "only stopped sandboxes can be started")
+)
+
+// AddSandbox stores sb as is, e.g. to start a test from an existing sandbox;
+// an ID is assigned when it has none
+func (f *Fake) AddSandbox(sb client.Sandbox) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if sb.ID == "" {
+		sb.ID = f.newID("sb")
+	}
+	if _, ok := f.sandboxes[sb.ID]; !ok {
+		f.sandboxOrder = append(f.sandboxOrder, sb.ID)
+	}
+	f.sandboxes[sb.ID] = &fakeSandbox{sb: sb, files: make(map[string][]byte)}
+	return sb.ID
+}
+
+// SetLogs sets the log GetLogs and ExportLogs return for a sandbox
+func (f *Fake) SetLogs(id, logs string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if fs := f.sandboxes[id]; fs != nil {
+		fs.logs = logs
+	}
+}
+
+// sandbox returns the sandbox id with its expiry applied; callers hold f.mu
+func (f *Fake) sandbox(id string) (*fakeSandbox, error) {
+	fs := f.sandboxes[id]
+	if fs == nil {
+		return nil, errSandboxNotFound
+	}
+	f.expire(fs)
+	return fs, nil
+}
+
+// expire marks a sandbox whose TTL ran out as expired; callers hold f.mu
+func (f *Fake) expire(fs *fakeSandbox) {
+	if !client.SandboxStatus(fs.sb.Status).IsTerminal() && !f.now().Before(fs.sb.ExpiresAt) {
+		fs.sb.Status, fs.sb.StatusMsg, fs.pending = string(client.StatusExpired), "", nil
+	}
+}
+
+// setStatus moves a sandbox to status; callers hold f.mu
+func (f *Fake) setStatus(fs *fakeSandbox, status client.SandboxStatus) {
+	fs.sb.Status = string(status)
+	if status == client.StatusRunning && fs.sb.StartedAt == nil {
+		now := f.now()
+		fs.sb.StartedAt = &now
+	}
+}
+
+// advance moves a sandbox to its next scripted status; callers hold f.mu
+func (f *Fake) advance(fs *fakeSandbox) {
+	if len(fs.pending) == 0 {
+		return
+	}
+	f.setStatus(fs, fs.pending[0])
+	if fs.pending = fs.pending[1:]; len(fs.pending) == 0 {
+		fs.sb.StatusMsg = fs.message
+	}
+}
+
+// createSandbox creates a sandbox following the next script; callers hold f.mu
+func (f *Fake) createSandbox(req client.CreateSandboxRequest) (*fakeSandbox, error) {
+	if req.TemplateID == "" {
+		return nil, APIError(http.StatusBadRequest, "validation_error", "template_id is required")
+	}
+	tmpl, ok := f.template(req.TemplateID)
+	if !ok {
+		return nil, errTemplateNotFound
+	}
+	if tmpl != nil && !tmpl.IsEnabled() {
+		return nil, errTemplateDisabled
+	}
+
+	ttl := defaultTTL
+	switch {
+	case req.TTL != nil && *req.TTL > 0:
+		ttl = *req.TTL
+	case tmpl != nil && tmpl.TTL > 0:
+		ttl = tmpl.TTL
+	}
+
+	var script Script
+	if len(f.scripts) > 0 {
+		script, f.scripts = f.scripts[0], f.scripts[1:]
+	}
+
+	now := f.now()
+	fs := &fakeSandbox{
+		sb: client.Sandbox{
+			ID:         f.newID("sb"),
+			TemplateID: req.TemplateID,
+			UserID:     req.UserID,
+			CreatedAt:  now,
+			ExpiresAt:  now.Add(ttl),
+			Metadata:   req.Metadata,
+		},
+		message: script.Message,
+		files:   make(map[string][]byte),
+	}
+	if len(script.Statuses) == 0 {
+		script.Statuses = []client.SandboxStatus{client.StatusRunning}
+	}
+	fs.pending = script.Statuses
+	f.advance(fs)
+
+	f.sandboxes[fs.sb.ID] = fs
+	f.sandboxOrder = append(f.sandboxOrder, fs.sb.ID)
+	return fs, nil
+}
+
+// CreateSandbox creates a sandbox in the first status of the next script,
+// running when there is none. Its TTL is the request's, then the template's,
+// then an hour.
+func (f *Fake) CreateSandbox(ctx context.Context, req client.CreateSandboxRequest) (*client.Sandbox, error) {
+	if err := f.call(ctx, "CreateSandbox", req); err != nil {
+		return nil, err
+	}
+	if req.TemplateID != "" && req.UserID == "" {
+		return nil, APIError(http.StatusBadRequest, "validation_error", "user_id is required")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fs, err := f.createSandbox(req)
+	if err != nil {
+		return nil, err
+	}
+	sb := fs.sb
+	return &sb, nil
+}
+
+// GetSandbox returns a sandbox, moving it to its next scripted status first
+func (f *Fake) GetSandbox(ctx context.Context, id string) (*client.Sandbox, error) {
+	if err := f.call(ctx, "GetSandbox", id); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fs, err := f.sandbox(id)
+	if err != nil {
+		return nil, err
+	}
+	f.advance(fs)
+	f.expire(fs)
+	sb := fs.sb
+	return &sb, nil
+}
+
+// DeleteSandbox removes a sandbox
+func (f *Fake) DeleteSandbox(ctx context.Context, id string) error {
+	if err := f.call(ctx, "DeleteSandbox", id); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.sandboxes[id] == nil {
+		return errSandboxNotFound
+	}
+	f.deleteSandbox(id)
+	return nil
+}
+
+// deleteSandbox removes a sandbox; callers hold f.mu
+func (f *Fake) deleteSandbox(id string) {
+	delete(f.sandboxes, id)
+	for i, other := range f.sandboxOrder {
+		if other == id {
+			f.sandboxOrder = append(f.sandboxOrder[:i], f.sandboxOrder[i+1:]...)
+			break
+		}
+	}
+}
+
+// StartSandbox starts a stopped sandbox
+func (f *Fake) StartSandbox(ctx context.Context, id string) error {
+	if err := f.call(ctx, "StartSandbox", id); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fs, err := f.sandbox(id)
+	if err != nil {
+		return err
+	}
+	switch client.SandboxStatus(fs.sb.Status) {
+	case client.StatusStopped:
+		f.setStatus(fs, client.StatusRunning)
+		return nil
+	case client.StatusRunning:
+		return errAlreadyRunning
+	case client.StatusExpired:
+		return errSandboxExpired
+	}
+	return errOnlyStoppedStarted
+}
+
+// StopSandbox stops a sandbox that hasn't failed or expired
+func (f *Fake) StopSandbox(ctx context.Context, id string) error {
+	if err := f.call(ctx, "StopSandbox", id); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fs, err := f.sandbox(id)
+	if err != nil {
+		return err
+	}
+	switch client.SandboxStatus(fs.sb.Status) {
+	case client.StatusStopped:
+		return errAlreadyStopped
+	case client.StatusFailed, client.StatusExpired:
+		return errSandboxNotActive
+	}
+	fs.pending = nil
+	f.setStatus(fs, client.StatusStopped)
+	return nil
+}
+
+// ListSandboxes filters the sandboxes like the engine, newest first unless
+// opts.Order is asc. A zero opts.Limit lists them all; Sort is ignored.
+func (f *Fake) ListSandboxes(ctx context.Context, opts client.ListOptions) (*client.SandboxList, error) {
+	if err := f.call(ctx, "ListSandboxes", opts); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var matched []*client.Sandbox
+	for _, id := range f.sandboxOrder {
+		fs := f.sandboxes[id]
+		f.expire(fs)
+		if sandboxMatches(&fs.sb, opts) {
+			sb := fs.sb
+			matched = append(matched, &sb)
+		}
+	}
+	if opts.Order != "asc" {
+		reverse(matched)
+	}
+
+	return &client.SandboxList{
+		Sandboxes: page(matched, opts.Offset, opts.Limit),
+		Total:     len(matched),
+		Limit:     opts.Limit,
+		Offset:    opts.Offset,
+	}, nil
+}
+
+func sandboxMatches(sb *client.Sandbox, opts client.ListOptions) bool {
+	switch {
+	case opts.UserID != "" && sb.UserID != opts.UserID,
+		opts.TemplateID != "" && sb.TemplateID != opts.TemplateID,
+		opts.Status != "" && sb.Status != opts.Status,
+		!opts.CreatedAfter.IsZero() && sb.CreatedAt.Before(opts.CreatedAfter),
+		!opts.CreatedBefore.IsZero() && !sb.CreatedAt.Before(opts.CreatedBefore):
+		return false
+	}
+	for k, v := range opts.Metadata {
+		if sb.Metadata[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Sandboxes pages through ListSandboxes. An error injected for Sandboxes, or
+// its latency running out ctx, fails the first page.
+func (f *Fake) Sandboxes(ctx context.Context, opts client.ListOptions) *client.Iterator[*client.Sandbox] {
+	err := f.call(ctx, "Sandboxes", opts)
+	return client.NewIterator(ctx, opts.Offset, opts.Limit, func(ctx context.Context, offset, limit int) ([]*client.Sandbox, int, error) {
+		if err != nil {
+			return nil, 0, err
+		}
+		opts.Offset, opts.Limit = offset, limit
+		list, err := f.ListSandboxes(ctx, opts)
+		if err != nil {
+			return nil, 0, err
+		}
+		return list.Sandboxes, list.Total, nil
+	})
+}
+
+// ExtendTTL pushes back the expiry of a sandbox that hasn't failed or expired
+func (f *Fake) ExtendTTL(ctx context.Context, id string, duration time.Duration) (*client.Sandbox, error) {
+	if err := f.call(ctx, "ExtendTTL", id, duration); err != nil {
+		return nil, err
+	}
+	if duration <= 0 {
+		return nil, APIError(http.StatusBadRequest, "validation_error", "duration must be positive")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fs, err := f.sandbox(id)
+	if err != nil {
+		return nil, err
+	}
+	if client.SandboxStatus(fs.sb.Status).IsTerminal() {
+		return nil, errSandboxNotActive
+	}
+	fs.sb.ExpiresAt = fs.sb.ExpiresAt.Add(duration)
+	sb := fs.sb
+	return &sb, nil
+}
+
+// WaitForStatus polls GetSandbox with client.PollStatus, like the Client.
+// Polls follow each other at once while the sandbox has scripted statuses to
+// go through, then wait the intervals of opts, so a sandbox that never gets
+// there holds the call until ctx is done.
+func (f *Fake) WaitForStatus(ctx context.Context, id string, status client.SandboxStatus, opts client.WaitOptions) (*client.Sandbox, error) {
+	if err := f.call(ctx, "WaitForStatus", id, status, opts); err != nil {
+		return nil, err
+	}
+
+	return client.PollStatus(ctx, id, status, opts, func(ctx context.Context, id string) (*client.Sandbox, error) {
+		for {
+			sb, err := f.GetSandbox(ctx, id)
+			if err != nil || !f.scripted(id) {
+				return sb, err
+			}
+			if done, _ := reached(sb, status); done {
+				return sb, nil
+			}
+		}
+	})
+}
+
+// scripted reports whether a sandbox has scripted statuses to go through
+func (f *Fake) scripted(id string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fs := f.sandboxes[id]
+	return fs != nil && len(fs.pending) > 0
+}
+
+// CreateAndWait creates a sandbox and waits for it to run, like the Client
+func (f *Fake) CreateAndWait(ctx context.Context, req client.CreateSandboxRequest) (*client.Sandbox, error) {
+	if err := f.call(ctx, "CreateAndWait", req); err != nil {
+		return nil, err
+	}
+
+	sb, err := f.CreateSandbox(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if done, err := reached(sb, client.StatusRunning); done {
+		return sb, err
+	}
+
+	running, err := f.WaitForStatus(ctx, sb.ID, client.StatusRunning, client.WaitOptions{})
+	if running == nil {
+		running = sb
+	}
+	return running, err
+}
+
+func reached(sb *client.Sandbox, status client.SandboxStatus) (bool, error) {
+	current := client.SandboxStatus(sb.Status)
+	switch {
+	case current == status:
+		return true, nil
+	case current.IsTerminal():
+		return true, &client.SandboxFailedError{Sandbox: sb}
+	}
+	return false, nil
+}
+
+// GetLogs returns the last tail lines of the log set with SetLogs
+func (f *Fake) GetLogs(ctx context.Context, id string, tail int) (string, error) {
+	if err := f.call(ctx, "GetLogs", id, tail); err != nil {
+		return "", err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fs, err := f.sandbox(id)
+	if err != nil {
+		return "", err
+	}
+	return tailLines(fs.logs, tail), nil
+}
+
+func tailLines(logs string, tail int) string {
+	if tail <= 0 || logs == "" {
+		return logs
+	}
+	lines := strings.SplitAfter(strings.TrimSuffix(logs, "\n"), "\n")
+	if len(lines) <= tail {
+		return logs
+	}
+	out := strings.Join(lines[len(lines)-tail:], "")
+	if strings.HasSuffix(logs, "\n") {
+		out += "\n"
+	}
+	return out
+}
+
+// ExportLogs writes a tar.gz with a <sandbox-id>.log per matching sandbox,
+// without the engine's manifest.json
+func (f *Fake) ExportLogs(ctx context.Context, req client.ExportLogsRequest, w io.Writer) error {
+	if err := f.call(ctx, "ExportLogs", req); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	opts := client.ListOptions{UserID: req.UserID, TemplateID: req.TemplateID, Status: req.Status}
+	for _, id := range f.sandboxOrder {
+		fs := f.sandboxes[id]
+		f.expire(fs)
+		if !sandboxMatches(&fs.sb, opts) {
+			continue
+		}
+		logs := fs.logs
+		if req.TailBytes > 0 && int64(len(logs)) > req.TailBytes {
+			logs = logs[int64(len(logs))-req.TailBytes:]
+		}
+		hdr := &tar.Header{Name: id + ".log", Mode: 0o644, Size: int64(len(logs)), ModTime: f.now()}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("failed to read export: %w", err)
+		}
+		if _, err := io.WriteString(tw, logs); err != nil {
+			return fmt.Errorf("failed to read export: %w", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to read export: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to read export: %w", err)
+	}
+	return nil
+}
+
+// ListServices returns the services of a sandbox sorted by name, with their
+// secrets redacted like the engine does
+func (f *Fake) ListServices(ctx context.Context, id string) ([]*client.ServiceInstance, error) {
+	if err := f.call(ctx, "ListServices", id); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fs, err := f.sandbox(id)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(fs.sb.Services))
+	for name := range fs.sb.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	services := make([]*client.ServiceInstance, 0, len(names))
+	for _, name := range names {
+		services = append(services, redacted(fs.sb.Services[name]))
+	}
+	return services, nil
+}
+
+// GetService returns a service of a sandbox, redacted
+func (f *Fake) GetService(ctx context.Context, id, name string) (*client.ServiceInstance, error) {
+	if err := f.call(ctx, "GetService", id, name); err != nil {
+		return nil, err
+	}
+	return f.service(id, name, false)
+}
+
+// CheckService returns a service of a sandbox, redacted, recording the check
+func (f *Fake) CheckService(ctx context.Context, id, name string) (*client.ServiceInstance, error) {
+	if err := f.call(ctx, "CheckService", id, name); err != nil {
+		return nil, err
+	}
+	return f.service(id, name, true)
+}
+
+func (f *Fake) service(id, name string, check bool) (*client.ServiceInstance, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fs, err := f.sandbox(id)
+	if err != nil {
+		return nil, err
+	}
+	svc := fs.sb.Services[name]
+	if svc == nil {
+		return nil, APIError(http.StatusNotFound, "not_found", "service not found")
+	}
+	if check {
+		now := f.now()
+		svc.LastCheckedAt = &now
+	}
+	return redacted(svc), nil
+}
+
+// redacted returns a copy of svc with its password masked, both in its own
+// field and inside the URI, and its token masked, as the engine lists them
+func redacted(svc *client.ServiceInstance) *client.ServiceInstance {
+	out := *svc
+	if c := svc.Credentials; c != nil {
+		creds := *c
+		if creds.Password != "" {
+			creds.URI = strings.ReplaceAll(creds.URI, ":"+creds.Password+"@", ":"+redactedValue+"@")
+			creds.Password = redactedValue
+		}
+		if creds.Token != "" {
+			creds.Token = redactedValue
+		}
+		out.Credentials = &creds
+	}
+	return &out
+}
+
+// redactedValue replaces secrets in listed services
+const redactedValue = "[REDACTED]"
+
+// Exec runs a command through ExecFunc in a running sandbox
+func (f *Fake) Exec(ctx context.Context, id string, req client.ExecRequest) (*client.ExecResult, error) {
+	if err := f.call(ctx, "Exec", id, req); err != nil {
+		return nil, err
+	}
+	if len(req.Command) == 0 {
+		return nil, APIError(http.StatusBadRequest, "validation_error", "command is required")
+	}
+	if _, err := f.running(id); err != nil {
+		return nil, err
+	}
+
+	if f.ExecFunc == nil {
+		return &client.ExecResult{}, nil
+	}
+	return f.ExecFunc(id, req)
+}
+
+// UploadFile stores a file in a running sandbox
+func (f *Fake) UploadFile(ctx context.Context, id, filePath string, r io.Reader) error {
+	if err := f.call(ctx, "UploadFile", id, filePath); err != nil {
+		return err
+	}
+	cleaned, err := checkFilePath(filePath)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+
+	fs, err := f.running(id)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fs.files[cleaned] = data
+	return nil
+}
+
+// DownloadFile returns a file uploaded to a running sandbox
+func (f *Fake) DownloadFile(ctx context.Context, id, filePath string) (*client.FileDownload, error) {
+	if err := f.call(ctx, "DownloadFile", id, filePath); err != nil {
+		return nil, err
+	}
+	cleaned, err := checkFilePath(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	fs, err := f.running(id)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := fs.files[cleaned]
+	if !ok {
+		return nil, APIError(http.StatusNotFound, "not_found", "file not found")
+	}
+	return &client.FileDownload{ReadCloser: io.NopCloser(bytes.NewReader(data)), Size: int64(len(data))}, nil
+}
+
+func checkFilePath(p string) (string, error) {
+	if !path.IsAbs(p) || strings.HasSuffix(p, "/") {
+		return "", APIError(http.StatusBadRequest, "validation_error", "path must be an absolute file path")
+	}
+	return path.Clean(p), nil
+}
+
+// GetStats returns zero usage for a running sandbox
+func (f *Fake) GetStats(ctx context.Context, id string) (*client.SandboxStats, error) {
+	if err := f.call(ctx, "GetStats", id); err != nil {
+		return nil, err
+	}
+	if _, err := f.running(id); err != nil {
+		return nil, err
+	}
+	return &client.SandboxStats{ReadAt: f.now()}, nil
+}
+
+// running returns a sandbox that must be running
+func (f *Fake) running(id string) (*fakeSandbox, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fs, err := f.sandbox(id)
+	if err != nil {
+		return nil, err
+	}
+	if fs.sb.Status != string(client.StatusRunning) {
+		return nil, errSandboxNotRunning
+	}
+	return fs, nil
+}
+
+func reverse[T any](s []T) {
+	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
+		s[i], s[j] = s[j], s[i]
+	}
+}
+
+// page returns the items from offset, at most limit of them unless limit is 0
+func page[T any](items []T, offset, limit int) []T {
+	if offset >= len(items) {
+		return []T{}
+	}
+	items = items[offset:]
+	if limit > 0 && limit < len(items) {
+		items = items[:limit]
+	}
+	return items
+}
@@ -0,0 +1,354 @@
+package clienttest
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/terra-clan/sandbox-engine/pkg/client"
+)
+
+var (
+	errSessionNotFound = APIError(http.StatusNotFound, "not_found", "session not found")
+	errSessionRevoked  = APIError(http.StatusConflict, "session_revoked", "session is already revoked")
+	errNotReady        = APIError(http.StatusConflict, "not_ready", "session is not in ready state")
+)
+
+// AddSession stores s as is, e.g. a session already active; an ID and token
+// are assigned when it has none
+func (f *Fake) AddSession(s client.Session) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s.ID == "" {
+		s.ID = f.newID("sess")
+	}
+	if s.Token == "" {
+		s.Token = newToken()
+	}
+	if _, ok := f.sessions[s.ID]; !ok {
+		f.sessionOrder = append(f.sessionOrder, s.ID)
+	}
+	f.sessions[s.ID] = &s
+	return s.ID
+}
+
+// session returns the session id brought up to date; callers hold f.mu
+func (f *Fake) session(id string) (*client.Session, error) {
+	s := f.sessions[id]
+	if s == nil {
+		return nil, errSessionNotFound
+	}
+	f.syncSession(s)
+	return s, nil
+}
+
+// syncSession applies the sandbox's progress and the deadlines to a session:
+// a provisioning session follows its sandbox, and a session expires past its
+// join deadline or TTL; callers hold f.mu
+func (f *Fake) syncSession(s *client.Session) {
+	now := f.now()
+	if s.Status == client.SessionProvisioning {
+		fs := f.sandboxes[s.SandboxID]
+		switch {
+		case fs == nil:
+		case fs.sb.Status == string(client.StatusRunning):
+			s.Status = client.SessionActive
+		case fs.sb.Status == string(client.StatusFailed):
+			s.Status, s.StatusMessage = client.SessionFailed, fs.sb.StatusMsg
+		}
+	}
+	switch {
+	case s.Status == client.SessionReady && s.JoinBy != nil && !now.Before(*s.JoinBy),
+		(s.Status == client.SessionActive || s.Status == client.SessionProvisioning) && s.ExpiresAt != nil && !now.Before(*s.ExpiresAt):
+		s.Status = client.SessionExpired
+	}
+}
+
+// createSession creates a ready session; callers hold f.mu
+func (f *Fake) createSession(req client.CreateSessionRequest) (*client.CreateSessionResponse, error) {
+	if req.TemplateID == "" && req.TaskID == "" {
+		return nil, APIError(http.StatusBadRequest, "validation_error", "template_id or task_id is required")
+	}
+	tmpl, ok := f.template(req.TemplateID)
+	if req.TemplateID != "" && !ok {
+		return nil, errTemplateNotFound
+	}
+	if tmpl != nil && !tmpl.IsEnabled() {
+		return nil, errTemplateDisabled
+	}
+
+	ttl := req.TTL
+	if ttl <= 0 {
+		ttl = int(defaultTTL.Seconds())
+		if tmpl != nil && tmpl.TTL > 0 {
+			ttl = int(tmpl.TTL.Seconds())
+		}
+	}
+
+	now := f.now()
+	s := &client.Session{
+		ID:              f.newID("sess"),
+		Token:           newToken(),
+		TemplateID:      req.TemplateID,
+		Status:          client.SessionReady,
+		Env:             req.Env,
+		Metadata:        req.Metadata,
+		Services:        req.Services,
+		TTLSeconds:      ttl,
+		TaskDescription: req.TaskDescription,
+		CreatedAt:       now,
+		UniqueKey:       req.UniqueKey,
+	}
+	if req.TaskID != "" {
+		if s.Metadata == nil {
+			s.Metadata = make(map[string]string)
+		}
+		s.Metadata["task_id"] = req.TaskID
+	}
+	if req.JoinTTL > 0 {
+		joinBy := now.Add(time.Duration(req.JoinTTL) * time.Second)
+		s.JoinBy = &joinBy
+	}
+	f.sessions[s.ID] = s
+	f.sessionOrder = append(f.sessionOrder, s.ID)
+
+	return &client.CreateSessionResponse{
+		ID:         s.ID,
+		Token:      s.Token,
+		TemplateID: s.TemplateID,
+		Status:     s.Status,
+		JoinURL:    "http://sandbox-engine.test/join/" + s.Token,
+		JoinBy:     s.JoinBy,
+		CreatedAt:  s.CreatedAt,
+	}, nil
+}
+
+// CreateSession creates a ready session; its TTL is the request's, then the
+// template's, then an hour
+func (f *Fake) CreateSession(ctx context.Context, req client.CreateSessionRequest) (*client.CreateSessionResponse, error) {
+	if err := f.call(ctx, "CreateSession", req); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.createSession(req)
+}
+
+// CreateSessions creates a session per entry, reporting each outcome
+func (f *Fake) CreateSessions(ctx context.Context, req client.BulkCreateSessionsRequest) (*client.BulkCreateSessionsResponse, error) {
+	if err := f.call(ctx, "CreateSessions", req); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resp := &client.BulkCreateSessionsResponse{Total: len(req.Entries)}
+	for i, entry := range req.Entries {
+		one := req.CreateSessionRequest
+		one.Metadata = make(map[string]string, len(req.Metadata)+len(entry.Metadata))
+		for k, v := range req.Metadata {
+			one.Metadata[k] = v
+		}
+		for k, v := range entry.Metadata {
+			one.Metadata[k] = v
+		}
+		one.UniqueKey = entry.UniqueKey
+
+		result := client.BulkCreateSessionResult{Index: i}
+		if created, err := f.createSession(one); err != nil {
+			httpErr := err.(*client.HTTPError)
+			result.Code, result.Error = httpErr.Code, httpErr.Message
+			resp.Failed++
+		} else {
+			result.Success, result.Session = true, created
+			resp.Created++
+		}
+		resp.Results = append(resp.Results, result)
+	}
+	return resp, nil
+}
+
+// GetSession returns a session
+func (f *Fake) GetSession(ctx context.Context, id string) (*client.Session, error) {
+	if err := f.call(ctx, "GetSession", id); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, err := f.session(id)
+	if err != nil {
+		return nil, err
+	}
+	out := *s
+	return &out, nil
+}
+
+// DeleteSession removes a session along with its sandbox
+func (f *Fake) DeleteSession(ctx context.Context, id string) error {
+	if err := f.call(ctx, "DeleteSession", id); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s := f.sessions[id]
+	if s == nil {
+		return errSessionNotFound
+	}
+	f.deleteSandbox(s.SandboxID)
+	delete(f.sessions, id)
+	for i, other := range f.sessionOrder {
+		if other == id {
+			f.sessionOrder = append(f.sessionOrder[:i], f.sessionOrder[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// RevokeSession revokes a session and deletes its sandbox
+func (f *Fake) RevokeSession(ctx context.Context, id string) (*client.Session, error) {
+	if err := f.call(ctx, "RevokeSession", id); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, err := f.session(id)
+	if err != nil {
+		return nil, err
+	}
+	if s.Status == client.SessionRevoked {
+		return nil, errSessionRevoked
+	}
+	now := f.now()
+	s.Status, s.RevokedAt = client.SessionRevoked, &now
+	f.deleteSandbox(s.SandboxID)
+	out := *s
+	return &out, nil
+}
+
+// ListSessions filters the sessions like the engine, newest first; a zero
+// opts.Limit lists them all and CreatedBy is ignored
+func (f *Fake) ListSessions(ctx context.Context, opts client.SessionListOptions) (*client.SessionList, error) {
+	if err := f.call(ctx, "ListSessions", opts); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var matched []*client.Session
+	for _, id := range f.sessionOrder {
+		s := f.sessions[id]
+		f.syncSession(s)
+		switch {
+		case opts.Status != "" && string(s.Status) != opts.Status,
+			opts.TemplateID != "" && s.TemplateID != opts.TemplateID,
+			!opts.CreatedAfter.IsZero() && s.CreatedAt.Before(opts.CreatedAfter),
+			!opts.CreatedBefore.IsZero() && !s.CreatedAt.Before(opts.CreatedBefore):
+			continue
+		}
+		out := *s
+		matched = append(matched, &out)
+	}
+	reverse(matched)
+
+	return &client.SessionList{
+		Sessions: page(matched, opts.Offset, opts.Limit),
+		Total:    len(matched),
+		Limit:    opts.Limit,
+		Offset:   opts.Offset,
+	}, nil
+}
+
+// Sessions pages through ListSessions. An error injected for Sessions, or its
+// latency running out ctx, fails the first page.
+func (f *Fake) Sessions(ctx context.Context, opts client.SessionListOptions) *client.Iterator[*client.Session] {
+	err := f.call(ctx, "Sessions", opts)
+	return client.NewIterator(ctx, opts.Offset, opts.Limit, func(ctx context.Context, offset, limit int) ([]*client.Session, int, error) {
+		if err != nil {
+			return nil, 0, err
+		}
+		opts.Offset, opts.Limit = offset, limit
+		list, err := f.ListSessions(ctx, opts)
+		if err != nil {
+			return nil, 0, err
+		}
+		return list.Sessions, list.Total, nil
+	})
+}
+
+// sessionByToken returns the session with token; callers hold f.mu
+func (f *Fake) sessionByToken(token string) (*client.Session, error) {
+	for _, s := range f.sessions {
+		if s.Token == token {
+			f.syncSession(s)
+			return s, nil
+		}
+	}
+	return nil, errSessionNotFound
+}
+
+// JoinSession returns what the candidate sees; a revoked session comes back
+// with its status rather than as an error, like from the engine
+func (f *Fake) JoinSession(ctx context.Context, token string) (*client.JoinSessionResponse, error) {
+	if err := f.call(ctx, "JoinSession", token); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, err := f.sessionByToken(token)
+	if err != nil {
+		return nil, err
+	}
+	resp := &client.JoinSessionResponse{
+		Status:          s.Status,
+		Metadata:        s.Metadata,
+		TaskDescription: s.TaskDescription,
+		JoinBy:          s.JoinBy,
+	}
+	if tmpl, _ := f.template(s.TemplateID); tmpl != nil {
+		resp.Template = &client.TemplateInfo{Name: tmpl.Name, Description: tmpl.Description, Language: tmpl.Language, Tags: tmpl.Tags}
+	}
+	if fs := f.sandboxes[s.SandboxID]; fs != nil {
+		resp.Sandbox = &client.SandboxInfo{ID: fs.sb.ID, Status: fs.sb.Status, Endpoints: fs.sb.Endpoints, ExpiresAt: s.ExpiresAt}
+	}
+	return resp, nil
+}
+
+// ActivateSession starts a ready session: its sandbox is created following
+// the next script, and the session is provisioning until the sandbox runs
+func (f *Fake) ActivateSession(ctx context.Context, token string) (*client.ActivateSessionResponse, error) {
+	if err := f.call(ctx, "ActivateSession", token); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, err := f.sessionByToken(token)
+	if err != nil {
+		return nil, err
+	}
+	switch s.Status {
+	case client.SessionRevoked:
+		return &client.ActivateSessionResponse{Status: s.Status}, nil
+	case client.SessionReady:
+	default:
+		return nil, errNotReady
+	}
+
+	fs, err := f.createSandbox(client.CreateSandboxRequest{TemplateID: s.TemplateID, Env: s.Env, Metadata: s.Metadata})
+	if err != nil {
+		return nil, err
+	}
+	now := f.now()
+	expiresAt := now.Add(time.Duration(s.TTLSeconds) * time.Second)
+	fs.sb.ExpiresAt = expiresAt
+	s.Status, s.SandboxID, s.ActivatedAt, s.ExpiresAt = client.SessionProvisioning, fs.sb.ID, &now, &expiresAt
+	f.syncSession(s)
+
+	return &client.ActivateSessionResponse{Status: s.Status, StatusMessage: s.StatusMessage, SandboxID: s.SandboxID}, nil
+}
@@ -0,0 +1,234 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// execDefaultTimeout is the server's timeout for a command that sets none
+	execDefaultTimeout = 60 * time.Second
+	// execTimeoutMargin covers the round trip on top of a command's timeout
+	execTimeoutMargin = 30 * time.Second
+	// fileTransferTimeout matches the server's deadline for a file transfer
+	fileTransferTimeout = 10 * time.Minute
+)
+
+// ExecRequest runs a command in a sandbox to completion
+type ExecRequest struct {
+	Command        []string          `json:"command"`
+	WorkingDir     string            `json:"working_dir,omitempty"`
+	Env            map[string]string `json:"env,omitempty"`
+	TimeoutSeconds int               `json:"timeout_seconds,omitempty"` // 0 uses the default of 60
+}
+
+// ExecResult is how a command ended. A non-zero exit code is a result, not an
+// error.
+type ExecResult struct {
+	ExitCode   int    `json:"exit_code"`
+	Stdout     string `json:"stdout"`
+	Stderr     string `json:"stderr"`
+	Truncated  bool   `json:"truncated,omitempty"` // output past the per-stream cap was dropped
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// SandboxStats is a snapshot of a sandbox container's resource usage
+type SandboxStats struct {
+	CPUPercent       float64   `json:"cpu_percent"` // of one CPU, so up to 100 per CPU
+	MemoryBytes      uint64    `json:"memory_bytes"`
+	MemoryLimitBytes uint64    `json:"memory_limit_bytes"`
+	NetworkRxBytes   uint64    `json:"network_rx_bytes"`
+	NetworkTxBytes   uint64    `json:"network_tx_bytes"`
+	PIDs             uint64    `json:"pids"`
+	ReadAt           time.Time `json:"read_at"`
+}
+
+// Exec runs a command in a running sandbox to completion. A command that
+// exits non-zero is not an error: check the result's ExitCode. Errors are
+// reserved for failed requests, including a command outliving its timeout.
+// The call may take the command's timeout plus a margin, also when that's
+// longer than the client timeout.
+func (c *Client) Exec(ctx context.Context, id string, req ExecRequest) (*ExecResult, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	timeout := execDefaultTimeout
+	if req.TimeoutSeconds > 0 {
+		timeout = time.Duration(req.TimeoutSeconds) * time.Second
+	}
+	ctx = c.withCallTimeout(ctx, timeout+execTimeoutMargin)
+	resp, err := c.doRequest(ctx, "POST", fmt.Sprintf("/api/v1/sandboxes/%s/exec", id), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Success bool        `json:"success"`
+		Data    *ExecResult `json:"data"`
+		Error   *struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	if !result.Success {
+		return nil, fmt.Errorf("API error: %s - %s", result.Error.Code, result.Error.Message)
+	}
+
+	return result.Data, nil
+}
+
+// UploadFile writes the content of r to path in a running sandbox, creating
+// missing directories and replacing an existing file. The content is streamed
+// rather than buffered, so the server needs its size up front: r must be an
+// *os.File, a reader with a Len method like *bytes.Reader, or an io.Seeker.
+// Uploads are not retried.
+func (c *Client) UploadFile(ctx context.Context, id, path string, r io.Reader) error {
+	size, err := readerSize(r)
+	if err != nil {
+		return err
+	}
+
+	// Wrapped, r isn't closed by net/http, so a caller's file stays open; an
+	// empty body must be NoBody, as a zero length otherwise means unknown
+	body := io.NopCloser(r)
+	if size == 0 {
+		body = http.NoBody
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, "PUT", c.filesURL(id, path), body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.ContentLength = size
+	httpReq.Header.Set("Content-Type", "application/octet-stream")
+	if c.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := c.httpClientFor(c.withCallTimeout(ctx, fileTransferTimeout)).Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return newHTTPError(resp.StatusCode, respBody)
+	}
+
+	return nil
+}
+
+// readerSize returns how many bytes are left to read from r
+func readerSize(r io.Reader) (int64, error) {
+	switch v := r.(type) {
+	case interface{ Len() int }:
+		return int64(v.Len()), nil
+	case io.Seeker:
+		return remaining(v)
+	case interface{ Stat() (fs.FileInfo, error) }:
+		info, err := v.Stat()
+		if err != nil {
+			return 0, fmt.Errorf("failed to stat file: %w", err)
+		}
+		return info.Size(), nil
+	}
+	return 0, errors.New("cannot tell the size of the upload: pass an *os.File, a *bytes.Reader or an io.Seeker")
+}
+
+// remaining returns the bytes between s's offset and its end, leaving the
+// offset where it was
+func remaining(s io.Seeker) (int64, error) {
+	cur, err := s.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, fmt.Errorf("failed to seek upload: %w", err)
+	}
+	end, err := s.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, fmt.Errorf("failed to seek upload: %w", err)
+	}
+	if _, err := s.Seek(cur, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("failed to seek upload: %w", err)
+	}
+	return end - cur, nil
+}
+
+// FileDownload is the content of a file downloaded from a sandbox, read as it
+// arrives; the caller closes it
+type FileDownload struct {
+	io.ReadCloser
+	// Size is the file's length in bytes, or -1 when the server didn't say
+	Size int64
+}
+
+// DownloadFile opens the file at path in a running sandbox. The content is
+// streamed from the response, so close it when done. Downloads are not
+// retried.
+func (c *Client) DownloadFile(ctx context.Context, id, path string) (*FileDownload, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", c.filesURL(id, path), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if c.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := c.httpClientFor(c.withCallTimeout(ctx, fileTransferTimeout)).Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, newHTTPError(resp.StatusCode, respBody)
+	}
+
+	return &FileDownload{ReadCloser: resp.Body, Size: resp.ContentLength}, nil
+}
+
+func (c *Client) filesURL(id, path string) string {
+	return c.baseURL + fmt.Sprintf("/api/v1/sandboxes/%s/files?", id) + url.Values{"path": {path}}.Encode()
+}
+
+// GetStats samples the resource usage of a running sandbox. The server takes
+// about a second to measure the CPU usage.
+func (c *Client) GetStats(ctx context.Context, id string) (*SandboxStats, error) {
+	resp, err := c.doRequest(ctx, "GET", fmt.Sprintf("/api/v1/sandboxes/%s/stats", id), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Success bool          `json:"success"`
+		Data    *SandboxStats `json:"data"`
+		Error   *struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	if !result.Success {
+		return nil, fmt.Errorf("API error: %s - %s", result.Error.Code, result.Error.Message)
+	}
+
+	return result.Data, nil
+}
@@ -0,0 +1,159 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fileServer keeps the files uploaded to sandbox sb-1 by path
+type fileServer struct {
+	files   map[string]string
+	lengths []int64 // Content-Length of each upload
+}
+
+func (s *fileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/api/v1/sandboxes/sb-1/files" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	p := r.URL.Query().Get("path")
+	switch r.Method {
+	case http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		s.files[p] = string(data)
+		s.lengths = append(s.lengths, r.ContentLength)
+		json.NewEncoder(w).Encode(map[string]any{"success": true, "data": map[string]any{"path": p}})
+	case http.MethodGet:
+		data, ok := s.files[p]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]any{"success": false, "error": map[string]string{"code": "not_found", "message": "file not found"}})
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		io.WriteString(w, data)
+	}
+}
+
+func TestExecExitCode(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ExecRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if r.URL.Path != "/api/v1/sandboxes/sb-1/exec" || req.Command[0] != "false" {
+			t.Errorf("unexpected request %s %+v", r.URL.Path, req)
+		}
+		json.NewEncoder(w).Encode(map[string]any{"success": true, "data": ExecResult{ExitCode: 1, Stderr: "failed"}})
+	}))
+	defer ts.Close()
+
+	result, err := NewClient(ts.URL, "key").Exec(context.Background(), "sb-1", ExecRequest{Command: []string{"false"}})
+	if err != nil {
+		t.Fatalf("expected a non-zero exit not to be an error, got %v", err)
+	}
+	if result.ExitCode != 1 || result.Stderr != "failed" {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
+
+func TestUploadDownloadFile(t *testing.T) {
+	srv := &fileServer{files: map[string]string{}}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	c := NewClient(ts.URL, "key")
+
+	if err := c.UploadFile(context.Background(), "sb-1", "/app/main.py", strings.NewReader("print(1)\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A file is sent from its current offset, and left open
+	path := filepath.Join(t.TempDir(), "data.txt")
+	os.WriteFile(path, []byte("skip:keep"), 0o644)
+	f, _ := os.Open(path)
+	defer f.Close()
+	f.Seek(5, io.SeekStart)
+	if err := c.UploadFile(context.Background(), "sb-1", "/data.txt", f); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Errorf("expected the file left open, got %v", err)
+	}
+
+	if err := c.UploadFile(context.Background(), "sb-1", "/empty", bytes.NewReader(nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	if srv.files["/app/main.py"] != "print(1)\n" || srv.files["/data.txt"] != "keep" || srv.files["/empty"] != "" {
+		t.Errorf("unexpected files %q", srv.files)
+	}
+	if len(srv.lengths) != 3 || srv.lengths[0] != 9 || srv.lengths[1] != 4 || srv.lengths[2] != 0 {
+		t.Errorf("expected every upload with its Content-Length, got %v", srv.lengths)
+	}
+
+	download, err := c.DownloadFile(context.Background(), "sb-1", "/app/main.py")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer download.Close()
+	data, _ := io.ReadAll(download)
+	if string(data) != "print(1)\n" || download.Size != 9 {
+		t.Errorf("unexpected download %q of size %d", data, download.Size)
+	}
+
+	if _, err := c.DownloadFile(context.Background(), "sb-1", "/missing"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("expected the not found error, got %v", err)
+	}
+}
+
+func TestUploadFileUnsized(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+
+	err := NewClient("http://127.0.0.1:0", "key").UploadFile(context.Background(), "sb-1", "/a.txt", r)
+	if err == nil || !strings.Contains(err.Error(), "size") {
+		t.Errorf("expected an error for a reader of unknown size, got %v", err)
+	}
+}
+
+func TestGetStats(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"success": true, "data": SandboxStats{CPUPercent: 12.5, MemoryBytes: 1 << 20, PIDs: 3}})
+	}))
+	defer ts.Close()
+
+	stats, err := NewClient(ts.URL, "key").GetStats(context.Background(), "sb-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.CPUPercent != 12.5 || stats.MemoryBytes != 1<<20 || stats.PIDs != 3 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestWithCallTimeout(t *testing.T) {
+	c := NewClient("http://engine", "key")
+	if got := c.httpClientFor(c.withCallTimeout(context.Background(), 90*time.Second)).Timeout; got != 90*time.Second {
+		t.Errorf("expected a long call to raise the 30s default, got %v", got)
+	}
+	if got := c.httpClientFor(c.withCallTimeout(context.Background(), time.Second)).Timeout; got != 30*time.Second {
+		t.Errorf("expected a short call to keep the client timeout, got %v", got)
+	}
+	ctx := WithRequestTimeout(context.Background(), 5*time.Second)
+	if got := c.httpClientFor(c.withCallTimeout(ctx, 90*time.Second)).Timeout; got != 5*time.Second {
+		t.Errorf("expected WithRequestTimeout to win, got %v", got)
+	}
+
+	c = NewClient("http://engine", "key", WithTimeout(time.Hour))
+	if got := c.httpClientFor(c.withCallTimeout(context.Background(), 90*time.Second)).Timeout; got != time.Hour {
+		t.Errorf("expected a longer client timeout to be kept, got %v", got)
+	}
+}
@@ -0,0 +1,59 @@
+package client
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Interface is the API of Client. Depend on it rather than on *Client to swap
+// in a fake in tests, such as clienttest.Fake.
+type Interface interface {
+	// Sandboxes
+	CreateSandbox(ctx context.Context, req CreateSandboxRequest) (*Sandbox, error)
+	GetSandbox(ctx context.Context, id string) (*Sandbox, error)
+	DeleteSandbox(ctx context.Context, id string) error
+	StartSandbox(ctx context.Context, id string) error
+	StopSandbox(ctx context.Context, id string) error
+	ListSandboxes(ctx context.Context, opts ListOptions) (*SandboxList, error)
+	Sandboxes(ctx context.Context, opts ListOptions) *Iterator[*Sandbox]
+	ExtendTTL(ctx context.Context, id string, duration time.Duration) (*Sandbox, error)
+	WaitForStatus(ctx context.Context, id string, status SandboxStatus, opts WaitOptions) (*Sandbox, error)
+	CreateAndWait(ctx context.Context, req CreateSandboxRequest) (*Sandbox, error)
+	GetLogs(ctx context.Context, id string, tail int) (string, error)
+	ExportLogs(ctx context.Context, req ExportLogsRequest, w io.Writer) error
+	ListServices(ctx context.Context, id string) ([]*ServiceInstance, error)
+	GetService(ctx context.Context, id, name string) (*ServiceInstance, error)
+	CheckService(ctx context.Context, id, name string) (*ServiceInstance, error)
+	Exec(ctx context.Context, id string, req ExecRequest) (*ExecResult, error)
+	UploadFile(ctx context.Context, id, path string, r io.Reader) error
+	DownloadFile(ctx context.Context, id, path string) (*FileDownload, error)
+	GetStats(ctx context.Context, id string) (*SandboxStats, error)
+
+	// Templates and catalog
+	ListTemplates(ctx context.Context) ([]*Template, error)
+	TemplateUsage(ctx context.Context, from, to time.Time) (*UsageReport, error)
+	ListDomains(ctx context.Context) ([]*Domain, error)
+	GetDomain(ctx context.Context, domainID string) (*Domain, error)
+	ListProjects(ctx context.Context, domainID string) ([]*CatalogProject, error)
+	GetProject(ctx context.Context, projectID string) (*CatalogProject, error)
+	ListTasks(ctx context.Context, projectID string) ([]*CatalogTask, error)
+	GetTask(ctx context.Context, taskID string) (*CatalogTask, error)
+
+	// Sessions
+	CreateSession(ctx context.Context, req CreateSessionRequest) (*CreateSessionResponse, error)
+	CreateSessions(ctx context.Context, req BulkCreateSessionsRequest) (*BulkCreateSessionsResponse, error)
+	GetSession(ctx context.Context, id string) (*Session, error)
+	DeleteSession(ctx context.Context, id string) error
+	RevokeSession(ctx context.Context, id string) (*Session, error)
+	ListSessions(ctx context.Context, opts SessionListOptions) (*SessionList, error)
+	Sessions(ctx context.Context, opts SessionListOptions) *Iterator[*Session]
+	JoinSession(ctx context.Context, token string) (*JoinSessionResponse, error)
+	ActivateSession(ctx context.Context, token string) (*ActivateSessionResponse, error)
+
+	// Server
+	Health(ctx context.Context) error
+	ServerVersion(ctx context.Context) (*ServerVersion, error)
+}
+
+var _ Interface = (*Client)(nil)
@@ -0,0 +1,107 @@
+package client
+
+import "context"
+
+// defaultPageSize is how many items an Iterator fetches per request when the
+// list options leave the limit unset
+const defaultPageSize = 100
+
+// Iterator walks a paged listing, fetching the next page with limit/offset as
+// the current one runs out:
+//
+//	it := c.Sandboxes(ctx, client.ListOptions{Status: "running"})
+//	for it.Next() {
+//		sb := it.Value()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+//
+// Items created or deleted while iterating may shift the pages, so an item can
+// be skipped or seen twice.
+type Iterator[T any] struct {
+	ctx   context.Context
+	fetch func(ctx context.Context, offset, limit int) (items []T, total int, err error)
+	limit int
+
+	offset int
+	page   []T
+	cur    T
+	total  int
+	done   bool
+	err    error
+}
+
+// NewIterator returns an Iterator over the pages fetch returns, starting at
+// offset with pages of limit items (100 when 0). fetch returns a
+// page and the total matching the listing. It lets other implementations of
+// Interface, such as fakes, page like the Client.
+func NewIterator[T any](ctx context.Context, offset, limit int, fetch func(context.Context, int, int) ([]T, int, error)) *Iterator[T] {
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	return &Iterator[T]{ctx: ctx, fetch: fetch, limit: limit, offset: offset, total: -1}
+}
+
+// Next advances to the next item, fetching a page when needed. It returns
+// false once the listing is exhausted or a request failed; see Err.
+func (it *Iterator[T]) Next() bool {
+	if len(it.page) == 0 && !it.done {
+		it.page, it.total, it.err = it.fetch(it.ctx, it.offset, it.limit)
+		if it.err != nil {
+			it.page, it.done = nil, true
+			return false
+		}
+		it.offset += len(it.page)
+		// A short page is the last one, as is the page reaching the total
+		it.done = len(it.page) < it.limit || it.offset >= it.total
+	}
+	if len(it.page) == 0 {
+		return false
+	}
+	it.cur, it.page = it.page[0], it.page[1:]
+	return true
+}
+
+// Value returns the current item
+func (it *Iterator[T]) Value() T {
+	return it.cur
+}
+
+// Err returns the error that ended the iteration, if any
+func (it *Iterator[T]) Err() error {
+	return it.err
+}
+
+// Total returns the number of items matching the listing's filters as of the
+// last page fetched, or -1 before the first Next
+func (it *Iterator[T]) Total() int {
+	return it.total
+}
+
+// Sandboxes iterates over the sandboxes matching opts. opts.Limit sets the
+// page size and opts.Offset where to start.
+func (c *Client) Sandboxes(ctx context.Context, opts ListOptions) *Iterator[*Sandbox] {
+	return NewIterator(ctx, opts.Offset, opts.Limit, func(ctx context.Context, offset, limit int) ([]*Sandbox, int, error) {
+		opts.Offset, opts.Limit = offset, limit
+		list, err := c.ListSandboxes(ctx, opts)
+		if err != nil {
+			return nil, 0, err
+		}
+		return list.Sandboxes, list.Total, nil
+	})
+}
+
+// Sessions iterates over the sessions matching opts. opts.Limit sets the page
+// size and opts.Offset where to start.
+func (c *Client) Sessions(ctx context.Context, opts SessionListOptions) *Iterator[*Session] {
+	return NewIterator(ctx, opts.Offset, opts.Limit, func(ctx context.Context, offset, limit int) ([]*Session, int, error) {
+		opts.Offset, opts.Limit = offset, limit
+		list, err := c.ListSessions(ctx, opts)
+		if err != nil {
+			return nil, 0, err
+		}
+		return list.Sessions, list.Total, nil
+	})
+}
@@ -0,0 +1,131 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// pageServer serves n sandboxes and n sessions by limit/offset and counts the
+// requests
+type pageServer struct {
+	n        int
+	requests int
+}
+
+func (s *pageServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.requests++
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	end := min(offset+limit, s.n)
+
+	data := map[string]any{"total": s.n, "limit": limit, "offset": offset}
+	switch r.URL.Path {
+	case "/api/v1/sandboxes":
+		page := []*Sandbox{}
+		for i := offset; i < end; i++ {
+			page = append(page, &Sandbox{ID: fmt.Sprintf("sb-%d", i)})
+		}
+		data["sandboxes"] = page
+	case "/api/v1/sessions":
+		page := []*Session{}
+		for i := offset; i < end; i++ {
+			page = append(page, &Session{ID: fmt.Sprintf("s-%d", i)})
+		}
+		data["sessions"] = page
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"success": true, "data": data})
+}
+
+func TestSandboxesIterator(t *testing.T) {
+	for _, tc := range []struct {
+		n, limit, requests int
+	}{
+		{n: 250, requests: 3},            // default page size of 100
+		{n: 200, requests: 2},            // the total ends it without an empty page
+		{n: 7, limit: 3, requests: 3},    // short last page
+		{n: 0, requests: 1},              // empty result
+		{n: 1, limit: 1000, requests: 1}, // page size larger than the listing
+	} {
+		t.Run(fmt.Sprintf("%d/%d", tc.n, tc.limit), func(t *testing.T) {
+			srv := &pageServer{n: tc.n}
+			ts := httptest.NewServer(srv)
+			defer ts.Close()
+
+			it := NewClient(ts.URL, "key").Sandboxes(context.Background(), ListOptions{Limit: tc.limit})
+			var ids []string
+			for it.Next() {
+				ids = append(ids, it.Value().ID)
+			}
+			if err := it.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if len(ids) != tc.n || (tc.n > 0 && ids[tc.n-1] != fmt.Sprintf("sb-%d", tc.n-1)) {
+				t.Errorf("expected %d sandboxes in order, got %d", tc.n, len(ids))
+			}
+			if it.Total() != tc.n {
+				t.Errorf("expected total %d, got %d", tc.n, it.Total())
+			}
+			if srv.requests != tc.requests {
+				t.Errorf("expected %d requests, got %d", tc.requests, srv.requests)
+			}
+			if it.Next() {
+				t.Error("expected an exhausted iterator to stay exhausted")
+			}
+		})
+	}
+}
+
+func TestSandboxesIteratorOffset(t *testing.T) {
+	ts := httptest.NewServer(&pageServer{n: 10})
+	defer ts.Close()
+
+	it := NewClient(ts.URL, "key").Sandboxes(context.Background(), ListOptions{Limit: 4, Offset: 6})
+	var ids []string
+	for it.Next() {
+		ids = append(ids, it.Value().ID)
+	}
+	if strings.Join(ids, ",") != "sb-6,sb-7,sb-8,sb-9" {
+		t.Errorf("expected the listing from offset 6, got %v", ids)
+	}
+}
+
+func TestSessionsIterator(t *testing.T) {
+	srv := &pageServer{n: 5}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	it := NewClient(ts.URL, "key").Sessions(context.Background(), SessionListOptions{Limit: 2})
+	n := 0
+	for it.Next() {
+		if it.Value().ID != fmt.Sprintf("s-%d", n) {
+			t.Errorf("unexpected session %s at %d", it.Value().ID, n)
+		}
+		n++
+	}
+	if it.Err() != nil || n != 5 || srv.requests != 3 {
+		t.Errorf("expected 5 sessions in 3 requests, got %d in %d (%v)", n, srv.requests, it.Err())
+	}
+}
+
+func TestIteratorError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]any{"success": false, "error": map[string]string{"code": "forbidden", "message": "missing permission"}})
+	}))
+	defer ts.Close()
+
+	it := NewClient(ts.URL, "key").Sandboxes(context.Background(), ListOptions{})
+	if it.Next() {
+		t.Fatal("expected no items")
+	}
+	if err := it.Err(); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("expected the request error, got %v", err)
+	}
+}
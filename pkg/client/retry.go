@@ -0,0 +1,131 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy configures how a request is retried after a transport error or
+// a 429, 502 or 503 response. Only GET, HEAD, PUT, DELETE and OPTIONS are
+// retried, and POSTs carrying an idempotency key (see WithIdempotencyKey). A
+// Retry-After header on the response overrides the backoff, up to
+// MaxRetryAfter.
+type RetryPolicy struct {
+	MaxAttempts   int           // attempts in total, including the first; 1 or less never retries
+	Backoff       time.Duration // delay before the first retry, doubled after each; defaults to 200ms
+	MaxBackoff    time.Duration // cap on the delay; defaults to 5s
+	MaxRetryAfter time.Duration // cap on a delay asked for by Retry-After; defaults to 30s
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.Backoff <= 0 {
+		p.Backoff = 200 * time.Millisecond
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = 5 * time.Second
+	}
+	if p.MaxBackoff < p.Backoff {
+		p.MaxBackoff = p.Backoff
+	}
+	if p.MaxRetryAfter <= 0 {
+		p.MaxRetryAfter = 30 * time.Second
+	}
+	return p
+}
+
+// WithRetry retries failed requests according to policy. By default requests
+// are not retried.
+func WithRetry(policy RetryPolicy) Option {
+	return func(c *Client) {
+		c.retry = policy.withDefaults()
+	}
+}
+
+type (
+	requestTimeoutKey struct{}
+	idempotencyKeyKey struct{}
+)
+
+// WithRequestTimeout overrides the client timeout for calls made with the
+// returned context, e.g. for a large log export. Each attempt gets the full
+// timeout; bound the call as a whole with a context deadline.
+func WithRequestTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, requestTimeoutKey{}, timeout)
+}
+
+// WithIdempotencyKey sends key as the Idempotency-Key header of calls made
+// with the returned context, which marks a POST as safe to retry. Use a new
+// key for each logical operation.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyKey{}, key)
+}
+
+func idempotencyKey(ctx context.Context) string {
+	key, _ := ctx.Value(idempotencyKeyKey{}).(string)
+	return key
+}
+
+// httpClientFor returns the HTTP client for a call, with the timeout set by
+// WithRequestTimeout if any
+func (c *Client) httpClientFor(ctx context.Context) *http.Client {
+	timeout, ok := ctx.Value(requestTimeoutKey{}).(time.Duration)
+	if !ok {
+		return c.httpClient
+	}
+	hc := *c.httpClient
+	hc.Timeout = timeout
+	return &hc
+}
+
+// withCallTimeout raises the client timeout to timeout for a call that may
+// take that long, unless WithRequestTimeout already set one or the client's
+// own is longer
+func (c *Client) withCallTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	if _, ok := ctx.Value(requestTimeoutKey{}).(time.Duration); ok {
+		return ctx
+	}
+	if c.httpClient.Timeout == 0 || c.httpClient.Timeout >= timeout {
+		return ctx
+	}
+	return WithRequestTimeout(ctx, timeout)
+}
+
+// idempotent reports whether a request with method can be repeated safely
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// retryable reports whether an attempt that ended with status or err is worth
+// retrying. A canceled or expired context is not.
+func retryable(ctx context.Context, status int, err error) bool {
+	if err != nil {
+		return ctx.Err() == nil && !errors.Is(err, context.Canceled)
+	}
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable:
+		return true
+	}
+	return false
+}
+
+// retryAfter parses a Retry-After header, in seconds or as an HTTP date
+func retryAfter(header http.Header) (time.Duration, bool) {
+	v := header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if at, err := http.ParseTime(v); err == nil {
+		return max(time.Until(at), 0), true
+	}
+	return 0, false
+}
@@ -0,0 +1,228 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var fastRetry = RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+
+// flakyServer answers each request with the next of statuses, then with 200,
+// and records the requests' bodies and idempotency keys
+type flakyServer struct {
+	mu       sync.Mutex
+	statuses []int
+	header   http.Header
+	bodies   []string
+	keys     []string
+}
+
+func (s *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	s.mu.Lock()
+	attempt := len(s.bodies)
+	s.bodies = append(s.bodies, string(body))
+	s.keys = append(s.keys, r.Header.Get("Idempotency-Key"))
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if attempt < len(s.statuses) {
+		for k, v := range s.header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(s.statuses[attempt])
+		json.NewEncoder(w).Encode(map[string]any{"success": false, "error": map[string]string{"code": "unavailable", "message": "try again"}})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"success": true, "data": &Sandbox{ID: "sb-1", Status: "running"}})
+}
+
+func (s *flakyServer) attempts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.bodies)
+}
+
+func TestRetryIdempotentRequest(t *testing.T) {
+	srv := &flakyServer{statuses: []int{http.StatusServiceUnavailable, http.StatusBadGateway}}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	sb, err := NewClient(ts.URL, "key", WithRetry(fastRetry)).GetSandbox(context.Background(), "sb-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sb.ID != "sb-1" || srv.attempts() != 3 {
+		t.Errorf("expected sb-1 after 3 attempts, got %+v after %d", sb, srv.attempts())
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	srv := &flakyServer{statuses: []int{429, 429, 429, 429}}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	_, err := NewClient(ts.URL, "key", WithRetry(fastRetry)).GetSandbox(context.Background(), "sb-1")
+	if err == nil || !strings.Contains(err.Error(), "429") {
+		t.Errorf("expected the last 429, got %v", err)
+	}
+	if srv.attempts() != 3 {
+		t.Errorf("expected MaxAttempts attempts, got %d", srv.attempts())
+	}
+}
+
+func TestRetryNotByDefault(t *testing.T) {
+	srv := &flakyServer{statuses: []int{http.StatusServiceUnavailable}}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	if _, err := NewClient(ts.URL, "key").GetSandbox(context.Background(), "sb-1"); err == nil {
+		t.Error("expected the 503 without retries")
+	}
+	if srv.attempts() != 1 {
+		t.Errorf("expected a single attempt, got %d", srv.attempts())
+	}
+}
+
+func TestRetryOtherStatus(t *testing.T) {
+	srv := &flakyServer{statuses: []int{http.StatusInternalServerError}}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	NewClient(ts.URL, "key", WithRetry(fastRetry)).GetSandbox(context.Background(), "sb-1")
+	if srv.attempts() != 1 {
+		t.Errorf("expected a 500 not to be retried, got %d attempts", srv.attempts())
+	}
+}
+
+func TestRetryPost(t *testing.T) {
+	srv := &flakyServer{statuses: []int{http.StatusServiceUnavailable}}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	c := NewClient(ts.URL, "key", WithRetry(fastRetry))
+
+	if _, err := c.CreateSandbox(context.Background(), CreateSandboxRequest{TemplateID: "python"}); err == nil {
+		t.Error("expected a POST without an idempotency key not to be retried")
+	}
+	if srv.attempts() != 1 {
+		t.Fatalf("expected a single attempt, got %d", srv.attempts())
+	}
+
+	srv.statuses = []int{200, http.StatusServiceUnavailable}
+	ctx := WithIdempotencyKey(context.Background(), "create-1")
+	if _, err := c.CreateSandbox(ctx, CreateSandboxRequest{TemplateID: "python"}); err != nil {
+		t.Fatal(err)
+	}
+	if srv.attempts() != 3 {
+		t.Fatalf("expected the keyed POST to be retried, got %d attempts", srv.attempts())
+	}
+	if srv.bodies[1] == "" || srv.bodies[2] != srv.bodies[1] {
+		t.Errorf("expected the body sent again, got %q then %q", srv.bodies[1], srv.bodies[2])
+	}
+	if srv.keys[1] != "create-1" || srv.keys[2] != "create-1" {
+		t.Errorf("expected the idempotency key on every attempt, got %q", srv.keys)
+	}
+}
+
+func TestRetryTransportError(t *testing.T) {
+	var failed sync.Once
+	srv := &flakyServer{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		drop := false
+		failed.Do(func() { drop = true })
+		if drop {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		srv.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	if _, err := NewClient(ts.URL, "key", WithRetry(fastRetry)).GetSandbox(context.Background(), "sb-1"); err != nil {
+		t.Fatalf("expected the dropped connection to be retried, got %v", err)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	srv := &flakyServer{statuses: []int{429}, header: http.Header{"Retry-After": {"1"}}}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	start := time.Now()
+	if _, err := NewClient(ts.URL, "key", WithRetry(fastRetry)).GetSandbox(context.Background(), "sb-1"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("expected Retry-After to be honored, retried after %s", elapsed)
+	}
+
+	// A long Retry-After is capped
+	srv = &flakyServer{statuses: []int{503}, header: http.Header{"Retry-After": {"3600"}}}
+	ts = httptest.NewServer(srv)
+	defer ts.Close()
+	capped := fastRetry
+	capped.MaxRetryAfter = 10 * time.Millisecond
+	start = time.Now()
+	if _, err := NewClient(ts.URL, "key", WithRetry(capped)).GetSandbox(context.Background(), "sb-1"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected Retry-After capped, retried after %s", elapsed)
+	}
+
+	date := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if d, ok := retryAfter(http.Header{"Retry-After": {date}}); !ok || d < 59*time.Minute {
+		t.Errorf("expected an HTTP date to parse, got %s %v", d, ok)
+	}
+	if _, ok := retryAfter(http.Header{"Retry-After": {"soon"}}); ok {
+		t.Error("expected an invalid Retry-After to be ignored")
+	}
+}
+
+func TestRetryContextCanceled(t *testing.T) {
+	srv := &flakyServer{statuses: []int{503, 503, 503}}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	policy := RetryPolicy{MaxAttempts: 3, Backoff: time.Hour}
+	if _, err := NewClient(ts.URL, "key", WithRetry(policy)).GetSandbox(ctx, "sb-1"); err == nil {
+		t.Error("expected the 503 once the context ended")
+	}
+	if srv.attempts() != 1 {
+		t.Errorf("expected no retry after the context ended, got %d attempts", srv.attempts())
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		json.NewEncoder(w).Encode(map[string]any{"success": true, "data": &Sandbox{ID: "sb-1"}})
+	}))
+	defer ts.Close()
+	c := NewClient(ts.URL, "key", WithTimeout(20*time.Millisecond))
+
+	if _, err := c.GetSandbox(context.Background(), "sb-1"); err == nil {
+		t.Error("expected the client timeout")
+	}
+	if _, err := c.GetSandbox(WithRequestTimeout(context.Background(), time.Second), "sb-1"); err != nil {
+		t.Errorf("expected the per-call timeout to override the client's, got %v", err)
+	}
+}
+
+func TestRetryPolicyDefaults(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 4, Backoff: 10 * time.Second}.withDefaults()
+	if p.Backoff != 10*time.Second || p.MaxBackoff != 10*time.Second {
+		t.Errorf("unexpected defaults %+v", p)
+	}
+}
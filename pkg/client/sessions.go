@@ -0,0 +1,199 @@
+package client
+
+import "time"
+
+// SessionStatus is the state of a session
+type SessionStatus string
+
+const (
+	SessionReady        SessionStatus = "ready"        // Created, waiting for candidate
+	SessionProvisioning SessionStatus = "provisioning" // Candidate joined, sandbox starting
+	SessionActive       SessionStatus = "active"       // Sandbox running, timer ticking
+	SessionExpired      SessionStatus = "expired"      // TTL elapsed
+	SessionFailed       SessionStatus = "failed"       // Error during provisioning
+	SessionRevoked      SessionStatus = "revoked"      // Access cut by an admin, record kept
+	SessionSubmitted    SessionStatus = "submitted"    // Candidate handed in, workspace archived
+
+	// SessionInvalid is only reported by JoinSession: the session can never
+	// start, e.g. because its template is gone
+	SessionInvalid SessionStatus = "invalid"
+)
+
+// ConflictPolicy decides what activation does when another session with the
+// same unique key is already live
+type ConflictPolicy string
+
+const (
+	ConflictReject    ConflictPolicy = "reject"    // Refuse to activate the new session
+	ConflictSupersede ConflictPolicy = "supersede" // Expire the older session, then activate
+)
+
+// Session is a deferred sandbox: created by an orchestrator, it gets its
+// sandbox when the candidate opens the join link
+type Session struct {
+	ID              string            `json:"id"`
+	Token           string            `json:"token"`
+	TemplateID      string            `json:"template_id"`
+	Status          SessionStatus     `json:"status"`
+	StatusMessage   string            `json:"status_message,omitempty"`
+	Env             map[string]string `json:"env,omitempty"`
+	Metadata        map[string]string `json:"metadata,omitempty"`
+	Services        []string          `json:"services,omitempty"`
+	TTLSeconds      int               `json:"ttl_seconds"`
+	SandboxID       string            `json:"sandbox_id,omitempty"`
+	TaskDescription string            `json:"task_description,omitempty"`
+	CreatedAt       time.Time         `json:"created_at"`
+	ActivatedAt     *time.Time        `json:"activated_at,omitempty"`
+	ExpiresAt       *time.Time        `json:"expires_at,omitempty"`
+	CreatedBy       string            `json:"created_by,omitempty"`
+
+	// JoinBy is the deadline for activation; nil means none
+	JoinBy *time.Time `json:"join_by,omitempty"`
+	// PrewarmAt is when the sandbox of a ready session is created ahead of activation
+	PrewarmAt *time.Time `json:"prewarm_at,omitempty"`
+
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+	RevokedBy   string     `json:"revoked_by,omitempty"`
+	SubmittedAt *time.Time `json:"submitted_at,omitempty"`
+
+	// ExtendedSeconds is the time added by extensions
+	ExtendedSeconds int `json:"extended_seconds,omitempty"`
+	// SandboxStatus is the last status of the session's sandbox once deleted
+	SandboxStatus SandboxStatus `json:"sandbox_status,omitempty"`
+
+	UniqueKey  string         `json:"unique_key,omitempty"`
+	OnConflict ConflictPolicy `json:"on_conflict,omitempty"`
+
+	CallbackURL        string              `json:"callback_url,omitempty"`
+	CallbackDeliveries []*CallbackDelivery `json:"callback_deliveries,omitempty"`
+
+	RecordTerminal bool `json:"record_terminal,omitempty"`
+}
+
+// CallbackDelivery is the delivery state of one callback of a session
+type CallbackDelivery struct {
+	ID          int64     `json:"id"`
+	Event       string    `json:"event"` // activated, expired, submitted or failed
+	Delivered   bool      `json:"delivered"`
+	Attempts    int       `json:"attempts"`
+	StatusCode  int       `json:"status_code,omitempty"` // of the last attempt
+	Error       string    `json:"error,omitempty"`
+	AttemptedAt time.Time `json:"attempted_at"` // of the last attempt
+
+	// NextAttemptAt is when the delivery is tried again, while it is pending
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+}
+
+// CreateSessionRequest represents a session creation request
+type CreateSessionRequest struct {
+	TemplateID      string            `json:"template_id"`                // defaults to the task's project template
+	TaskID          string            `json:"task_id,omitempty"`          // catalog task, stored as metadata task_id
+	TTL             int               `json:"ttl"`                        // seconds; defaults to the task's time limit
+	JoinTTL         int               `json:"join_ttl_seconds,omitempty"` // seconds to activate; 0 uses the server default
+	PrewarmAt       *time.Time        `json:"prewarm_at,omitempty"`       // creates the sandbox ahead of activation
+	Env             map[string]string `json:"env,omitempty"`
+	Metadata        map[string]string `json:"metadata,omitempty"`
+	Services        []string          `json:"services,omitempty"`
+	TaskDescription string            `json:"task_description,omitempty"`
+
+	// UniqueKey (e.g. a hash of the candidate's email) prevents two live sessions for the same key
+	UniqueKey string `json:"unique_key,omitempty"`
+	// OnConflict is reject (default) or supersede
+	OnConflict ConflictPolicy `json:"on_conflict,omitempty"`
+	// CallbackURL is POSTed to when the session is activated, expires, is submitted or fails
+	CallbackURL string `json:"callback_url,omitempty"`
+	// RecordTerminal records the candidate's terminal output for playback
+	RecordTerminal bool `json:"record_terminal,omitempty"`
+}
+
+// CreateSessionResponse is a created session
+type CreateSessionResponse struct {
+	ID         string        `json:"id"`
+	Token      string        `json:"token"`
+	TemplateID string        `json:"template_id"`
+	Status     SessionStatus `json:"status"`
+	JoinURL    string        `json:"join_url"`
+	JoinBy     *time.Time    `json:"join_by,omitempty"`
+	CreatedAt  time.Time     `json:"created_at"`
+
+	// CallbackSecret signs the session's callbacks; it is not shown again
+	CallbackSecret string `json:"callback_secret,omitempty"`
+}
+
+// BulkCreateSessionsRequest creates one session per entry, for a cohort of
+// candidates. Everything but the entries is shared by all sessions.
+type BulkCreateSessionsRequest struct {
+	CreateSessionRequest
+	Entries []BulkSessionEntry `json:"entries"`
+}
+
+// BulkSessionEntry is what sets one session of a bulk create apart
+type BulkSessionEntry struct {
+	Metadata  map[string]string `json:"metadata,omitempty"` // merged over the shared metadata
+	UniqueKey string            `json:"unique_key,omitempty"`
+}
+
+// BulkCreateSessionResult is the outcome of one entry of a bulk create
+type BulkCreateSessionResult struct {
+	Index   int                    `json:"index"` // position in the request's entries
+	Success bool                   `json:"success"`
+	Session *CreateSessionResponse `json:"session,omitempty"`
+	Code    string                 `json:"code,omitempty"`
+	Error   string                 `json:"error,omitempty"`
+}
+
+// BulkCreateSessionsResponse reports a bulk create, one result per entry in request order
+type BulkCreateSessionsResponse struct {
+	Results []BulkCreateSessionResult `json:"results"`
+	Total   int                       `json:"total"`
+	Created int                       `json:"created"`
+	Failed  int                       `json:"failed"`
+}
+
+// JoinSessionResponse is what a candidate sees when opening their join link
+type JoinSessionResponse struct {
+	Status          SessionStatus     `json:"status"`
+	Template        *TemplateInfo     `json:"template,omitempty"`
+	Reason          string            `json:"reason,omitempty"` // set when status is invalid
+	Metadata        map[string]string `json:"metadata,omitempty"`
+	TaskDescription string            `json:"task_description,omitempty"`
+	Sandbox         *SandboxInfo      `json:"sandbox,omitempty"`
+	Task            *CatalogTask      `json:"task,omitempty"`
+	JoinBy          *time.Time        `json:"join_by,omitempty"` // set while the session waits to be started
+	SubmittedAt     *time.Time        `json:"submitted_at,omitempty"`
+
+	// ExpiryBehavior tells the candidate what happens when time runs out; omitted once the session ended
+	ExpiryBehavior *ExpiryBehavior `json:"expiry_behavior,omitempty"`
+}
+
+// TemplateInfo is the part of a template the join response shows
+type TemplateInfo struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Language    string   `json:"language,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+}
+
+// SandboxInfo is the sandbox of a started session
+type SandboxInfo struct {
+	ID        string            `json:"id"`
+	Status    string            `json:"status"`
+	Endpoints map[string]string `json:"endpoints,omitempty"`
+	Services  []*ServiceInfo    `json:"services,omitempty"`
+	ExpiresAt *time.Time        `json:"expires_at,omitempty"`
+}
+
+// ServiceInfo is a service of a started session's sandbox
+type ServiceInfo struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Status string `json:"status"`
+	Port   int    `json:"port,omitempty"`
+}
+
+// ActivateSessionResponse is a started session
+type ActivateSessionResponse struct {
+	Status        SessionStatus `json:"status"`
+	StatusMessage string        `json:"status_message,omitempty"`
+	SandboxID     string        `json:"sandbox_id,omitempty"`
+}
@@ -0,0 +1,95 @@
+package client
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/terra-clan/sandbox-engine/internal/models"
+)
+
+// jsonFields returns the JSON names of t's serialized fields, embedded
+// structs flattened
+func jsonFields(t reflect.Type) map[string]bool {
+	fields := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			for name := range jsonFields(f.Type) {
+				fields[name] = true
+			}
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if !f.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = true
+	}
+	return fields
+}
+
+// The SDK mirrors the engine's models, which other modules can't import.
+// Every SDK field must exist on the engine's side, and requests must carry
+// every field the engine takes.
+func TestTypesMatchEngineModels(t *testing.T) {
+	requests := []struct{ sdk, engine any }{
+		{CreateSessionRequest{}, models.CreateSessionRequest{}},
+		{BulkCreateSessionsRequest{}, models.BulkCreateSessionsRequest{}},
+		{BulkSessionEntry{}, models.BulkSessionEntry{}},
+		{ExecRequest{}, models.ExecRequest{}},
+	}
+	responses := []struct{ sdk, engine any }{
+		{ServiceInstance{}, models.ServiceInstance{}},
+		{ServiceCredentials{}, models.ServiceCredentials{}},
+		{ExpiryBehavior{}, models.ExpiryBehavior{}},
+		{ExecResult{}, models.ExecResult{}},
+		{SandboxStats{}, models.SandboxStats{}},
+		{Session{}, models.Session{}},
+		{CallbackDelivery{}, models.CallbackDelivery{}},
+		{CreateSessionResponse{}, models.CreateSessionResponse{}},
+		{BulkCreateSessionResult{}, models.BulkCreateSessionResult{}},
+		{BulkCreateSessionsResponse{}, models.BulkCreateSessionsResponse{}},
+		{JoinSessionResponse{}, models.JoinSessionResponse{}},
+		{TemplateInfo{}, models.TemplateInfo{}},
+		{SandboxInfo{}, models.SandboxInfo{}},
+		{ServiceInfo{}, models.ServiceInfo{}},
+		{ActivateSessionResponse{}, models.ActivateSessionResponse{}},
+		{Template{}, models.Template{}},
+		{Resources{}, models.Resources{}},
+		{Port{}, models.Port{}},
+		{Volume{}, models.Volume{}},
+		{Commands{}, models.Commands{}},
+		{AutoExtendPolicy{}, models.AutoExtendPolicy{}},
+		{TemplateUsage{}, models.TemplateUsage{}},
+		{UsageReport{}, models.UsageReport{}},
+		{Domain{}, models.Domain{}},
+		{CatalogProject{}, models.CatalogProject{}},
+		{CatalogTask{}, models.CatalogTask{}},
+	}
+
+	missing := func(from, in map[string]bool) []string {
+		var out []string
+		for name := range from {
+			if !in[name] {
+				out = append(out, name)
+			}
+		}
+		return out
+	}
+	for _, pair := range append(requests, responses...) {
+		sdk, engine := reflect.TypeOf(pair.sdk), reflect.TypeOf(pair.engine)
+		if extra := missing(jsonFields(sdk), jsonFields(engine)); len(extra) > 0 {
+			t.Errorf("%s: fields unknown to the engine: %v", sdk.Name(), extra)
+		}
+	}
+	for _, pair := range requests {
+		sdk, engine := reflect.TypeOf(pair.sdk), reflect.TypeOf(pair.engine)
+		if absent := missing(jsonFields(engine), jsonFields(sdk)); len(absent) > 0 {
+			t.Errorf("%s: engine fields the SDK can't send: %v", sdk.Name(), absent)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// WaitOptions configures how WaitForStatus polls. Zero fields take the
+// defaults: polls start 500ms apart and back off by 1.5x up to 5s.
+type WaitOptions struct {
+	Interval    time.Duration
+	MaxInterval time.Duration
+	Multiplier  float64
+}
+
+func (o WaitOptions) withDefaults() WaitOptions {
+	if o.Interval <= 0 {
+		o.Interval = 500 * time.Millisecond
+	}
+	if o.MaxInterval <= 0 {
+		o.MaxInterval = 5 * time.Second
+	}
+	if o.MaxInterval < o.Interval {
+		o.MaxInterval = o.Interval
+	}
+	if o.Multiplier < 1 {
+		o.Multiplier = 1.5
+	}
+	return o
+}
+
+// SandboxFailedError is returned by WaitForStatus when the sandbox reaches
+// failed or expired instead of the awaited status
+type SandboxFailedError struct {
+	Sandbox *Sandbox
+}
+
+func (e *SandboxFailedError) Error() string {
+	if e.Sandbox.StatusMsg == "" {
+		return fmt.Sprintf("sandbox %s is %s", e.Sandbox.ID, e.Sandbox.Status)
+	}
+	return fmt.Sprintf("sandbox %s is %s: %s", e.Sandbox.ID, e.Sandbox.Status, e.Sandbox.StatusMsg)
+}
+
+// WaitForStatus polls a sandbox until it reaches status and returns it. It
+// fails with a *SandboxFailedError as soon as the sandbox is failed or
+// expired, and with the context's error, wrapped with the last status seen,
+// when ctx is done first, also while a poll is in flight. Bound the wait
+// with a context deadline.
+func (c *Client) WaitForStatus(ctx context.Context, id string, status SandboxStatus, opts WaitOptions) (*Sandbox, error) {
+	return PollStatus(ctx, id, status, opts, c.GetSandbox)
+}
+
+// PollStatus waits like WaitForStatus, getting the sandbox with get instead
+// of GetSandbox; other implementations of Interface, such as the fake of
+// clienttest, build their WaitForStatus on it
+func PollStatus(ctx context.Context, id string, status SandboxStatus, opts WaitOptions, get func(context.Context, string) (*Sandbox, error)) (*Sandbox, error) {
+	opts = opts.withDefaults()
+
+	interval := opts.Interval
+	var last *Sandbox
+	for {
+		sb, err := get(ctx, id)
+		if err != nil {
+			switch {
+			case ctx.Err() != nil && last != nil:
+				return last, fmt.Errorf("sandbox %s still %s, not %s: %w", id, last.Status, status, ctx.Err())
+			case ctx.Err() != nil:
+				return nil, fmt.Errorf("sandbox %s did not reach %s: %w", id, status, ctx.Err())
+			}
+			return nil, fmt.Errorf("failed to get sandbox: %w", err)
+		}
+		last = sb
+		if done, err := reached(sb, status); done {
+			return sb, err
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return sb, fmt.Errorf("sandbox %s still %s, not %s: %w", id, sb.Status, status, ctx.Err())
+		}
+		interval = min(time.Duration(float64(interval)*opts.Multiplier), opts.MaxInterval)
+	}
+}
+
+// CreateAndWait creates a sandbox and waits with the default WaitOptions for
+// it to run. On failure the sandbox is returned along with the error when it
+// was created, so the caller can inspect or delete it.
+func (c *Client) CreateAndWait(ctx context.Context, req CreateSandboxRequest) (*Sandbox, error) {
+	sb, err := c.CreateSandbox(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if done, err := reached(sb, StatusRunning); done {
+		return sb, err
+	}
+
+	running, err := c.WaitForStatus(ctx, sb.ID, StatusRunning, WaitOptions{})
+	if running == nil {
+		running = sb
+	}
+	return running, err
+}
+
+// reached reports whether waiting for status is over for sb, with the error
+// when it ended in a terminal status instead
+func reached(sb *Sandbox, status SandboxStatus) (bool, error) {
+	current := SandboxStatus(sb.Status)
+	switch {
+	case current == status:
+		return true, nil
+	case current.IsTerminal():
+		return true, &SandboxFailedError{Sandbox: sb}
+	}
+	return false, nil
+}
@@ -0,0 +1,178 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// statusServer answers GET /api/v1/sandboxes/sb-1 with each of statuses in
+// turn, repeating the last one, and counts the polls
+type statusServer struct {
+	mu       sync.Mutex
+	statuses []string
+	msg      string
+	polls    int
+}
+
+func (s *statusServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Path != "/api/v1/sandboxes/sb-1" {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]any{"success": false, "error": map[string]string{"code": "not_found", "message": "sandbox not found"}})
+		return
+	}
+
+	status := s.statuses[min(s.polls, len(s.statuses)-1)]
+	if r.Method == http.MethodGet {
+		s.polls++
+	}
+	sb := &Sandbox{ID: "sb-1", Status: status}
+	if status == string(StatusFailed) {
+		sb.StatusMsg = s.msg
+	}
+	json.NewEncoder(w).Encode(map[string]any{"success": true, "data": sb})
+}
+
+func (s *statusServer) pollCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.polls
+}
+
+var fastWait = WaitOptions{Interval: time.Millisecond, MaxInterval: 5 * time.Millisecond}
+
+func TestWaitForStatusRunning(t *testing.T) {
+	srv := &statusServer{statuses: []string{"pending", "pending", "running"}}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	sb, err := NewClient(ts.URL, "key").WaitForStatus(context.Background(), "sb-1", StatusRunning, fastWait)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sb.Status != "running" || srv.pollCount() != 3 {
+		t.Errorf("expected running after 3 polls, got %s after %d", sb.Status, srv.pollCount())
+	}
+}
+
+func TestWaitForStatusFailed(t *testing.T) {
+	srv := &statusServer{statuses: []string{"pending", "failed"}, msg: "image pull failed"}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	sb, err := NewClient(ts.URL, "key").WaitForStatus(context.Background(), "sb-1", StatusRunning, fastWait)
+	var failed *SandboxFailedError
+	if !errors.As(err, &failed) {
+		t.Fatalf("expected a SandboxFailedError, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "image pull failed") || failed.Sandbox.StatusMsg != "image pull failed" {
+		t.Errorf("expected the status message in the error, got %q", err)
+	}
+	if sb == nil || sb.Status != "failed" {
+		t.Errorf("expected the failed sandbox returned, got %+v", sb)
+	}
+}
+
+func TestWaitForStatusTimeout(t *testing.T) {
+	srv := &statusServer{statuses: []string{"pending"}}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := NewClient(ts.URL, "key").WaitForStatus(ctx, "sb-1", StatusRunning, fastWait)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "still pending") {
+		t.Errorf("expected the last status in the error, got %q", err)
+	}
+}
+
+func TestWaitForStatusTimeoutDuringPoll(t *testing.T) {
+	var polls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The second poll outlasts the deadline
+		if polls.Add(1) > 1 {
+			<-r.Context().Done()
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"success": true, "data": &Sandbox{ID: "sb-1", Status: "pending"}})
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	sb, err := NewClient(ts.URL, "key").WaitForStatus(ctx, "sb-1", StatusRunning, fastWait)
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "still pending") {
+		t.Fatalf("expected the deadline error with the last status, got %v", err)
+	}
+	if sb == nil || sb.Status != "pending" {
+		t.Errorf("expected the last sandbox seen returned, got %+v", sb)
+	}
+}
+
+func TestWaitForStatusBackoff(t *testing.T) {
+	srv := &statusServer{statuses: []string{"pending"}}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	// 10ms, 20ms, 40ms, then capped at 40ms: about 5 polls in 150ms, not 15
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+	opts := WaitOptions{Interval: 10 * time.Millisecond, MaxInterval: 40 * time.Millisecond, Multiplier: 2}
+	NewClient(ts.URL, "key").WaitForStatus(ctx, "sb-1", StatusRunning, opts)
+	if n := srv.pollCount(); n < 3 || n > 7 {
+		t.Errorf("expected the polls to back off, got %d", n)
+	}
+}
+
+func TestWaitForStatusNotFound(t *testing.T) {
+	ts := httptest.NewServer(&statusServer{statuses: []string{"pending"}})
+	defer ts.Close()
+
+	_, err := NewClient(ts.URL, "key").WaitForStatus(context.Background(), "gone", StatusRunning, fastWait)
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("expected the lookup error, got %v", err)
+	}
+}
+
+func TestWaitOptionsDefaults(t *testing.T) {
+	opts := WaitOptions{Interval: 10 * time.Second}.withDefaults()
+	if opts.Interval != 10*time.Second || opts.MaxInterval != 10*time.Second || opts.Multiplier != 1.5 {
+		t.Errorf("unexpected defaults %+v", opts)
+	}
+}
+
+func TestCreateAndWait(t *testing.T) {
+	srv := &statusServer{statuses: []string{"pending", "running"}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/sandboxes", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]any{"success": true, "data": &Sandbox{ID: "sb-1", Status: "pending"}})
+	})
+	mux.Handle("/", srv)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	sb, err := NewClient(ts.URL, "key").CreateAndWait(context.Background(), CreateSandboxRequest{TemplateID: "python"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sb.ID != "sb-1" || sb.Status != "running" {
+		t.Errorf("expected sb-1 running, got %+v", sb)
+	}
+}
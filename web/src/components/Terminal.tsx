@@ -40,6 +40,7 @@ export const Terminal: React.FC<TerminalProps> = ({
 
     const wsUrl = getWsUrl();
     const ws = new WebSocket(wsUrl);
+    ws.binaryType = 'arraybuffer';
     wsRef.current = ws;
 
     ws.onopen = () => {
@@ -60,9 +61,16 @@ export const Terminal: React.FC<TerminalProps> = ({
         cols: term.cols,
         rows: term.rows
       }));
+
+      // Receive output as raw binary frames instead of JSON messages
+      ws.send(JSON.stringify({ type: 'binary', enabled: true }));
     };
 
     ws.onmessage = (event) => {
+      if (event.data instanceof ArrayBuffer) {
+        term.write(new Uint8Array(event.data));
+        return;
+      }
       try {
         const msg = JSON.parse(event.data);
         switch (msg.type) {
@@ -78,6 +86,17 @@ export const Terminal: React.FC<TerminalProps> = ({
           case 'error':
             term.writeln(`\r\n\x1b[1;31m Error: ${msg.data}\x1b[0m`);
             break;
+          case 'ttl_warning': {
+            const minutes = Math.ceil(msg.seconds_remaining / 60);
+            term.writeln(`\r\n\x1b[1;33m Session ends in ${minutes} min — save your work\x1b[0m`);
+            break;
+          }
+          case 'session_expired':
+            term.writeln('\r\n\x1b[1;31m Session expired\x1b[0m');
+            break;
+          case 'sandbox_stopped':
+            term.writeln('\r\n\x1b[1;31m Sandbox stopped\x1b[0m');
+            break;
         }
       } catch {
         term.write(event.data);
@@ -7,7 +7,8 @@ import { Workspace } from './Workspace';
 import { SandboxInfo } from '../types';
 
 interface SessionInfo {
-  status: 'ready' | 'provisioning' | 'active' | 'expired' | 'failed';
+  status: 'ready' | 'provisioning' | 'active' | 'expired' | 'failed' | 'invalid' | 'revoked' | 'submitted';
+  reason?: string;
   task_description?: string;
   template?: {
     name: string;
@@ -27,6 +28,32 @@ interface SessionInfo {
     }>;
     expires_at?: string;
   };
+  expiry_behavior?: ExpiryBehavior;
+  join_by?: string;
+  submitted_at?: string;
+  task?: {
+    id: string;
+    title: string;
+    description: string;
+    timeLimit: number;
+    skills?: string[];
+  };
+}
+
+interface ExpiryBehavior {
+  action: 'delete';
+  grace_period_seconds: number;
+  archive: boolean;
+  retention_seconds: number;
+}
+
+// expiryNotice tells the candidate what happens to their work when time runs out
+function expiryNotice(expiry: ExpiryBehavior): string {
+  if (expiry.archive) {
+    const days = Math.round(expiry.retention_seconds / 86400);
+    return `When time runs out, your work will be archived and kept for ${days} days.`;
+  }
+  return 'When time runs out, the environment and your work in it are deleted. Save anything you need before then.';
 }
 
 interface JoinPageProps {
@@ -53,6 +80,13 @@ export const JoinPage: React.FC<JoinPageProps> = ({ token, apiBaseUrl, wsBaseUrl
   const fetchSession = useCallback(async () => {
     try {
       const res = await fetch(`${apiBaseUrl}/api/v1/join/${token}`);
+      // A revoked session answers 410 with its status
+      if (res.status === 410) {
+        const data = await res.json();
+        setSession(data.data);
+        setError(null);
+        return;
+      }
       if (!res.ok) {
         if (res.status === 404) throw new Error('Session not found or expired');
         throw new Error(`HTTP ${res.status}`);
@@ -118,6 +152,22 @@ export const JoinPage: React.FC<JoinPageProps> = ({ token, apiBaseUrl, wsBaseUrl
     }
   };
 
+  const handleSubmit = async () => {
+    try {
+      const res = await fetch(`${apiBaseUrl}/api/v1/join/${token}/submit`, {
+        method: 'POST',
+      });
+      if (!res.ok && res.status !== 410) {
+        const data = await res.json().catch(() => null);
+        throw new Error(data?.error?.message || `HTTP ${res.status}`);
+      }
+      setShowWorkspace(false);
+      await fetchSession();
+    } catch (err) {
+      window.alert(err instanceof Error ? err.message : 'Failed to submit');
+    }
+  };
+
   // Workspace mode — session is active, show full workspace
   if (showWorkspace && session?.sandbox) {
     const sandboxInfo: SandboxInfo = {
@@ -145,6 +195,7 @@ export const JoinPage: React.FC<JoinPageProps> = ({ token, apiBaseUrl, wsBaseUrl
         loading={false}
         error={null}
         taskDescription={session.task_description}
+        onSubmit={handleSubmit}
       />
     );
   }
@@ -217,6 +268,31 @@ export const JoinPage: React.FC<JoinPageProps> = ({ token, apiBaseUrl, wsBaseUrl
                 </div>
               )}
 
+              {/* Catalog task bound to the session */}
+              {session.task && (
+                <div className="border-t border-slate-700 pt-4">
+                  <div className="text-xs text-slate-500 uppercase tracking-wider mb-1">Task</div>
+                  <div className="text-white font-medium">{session.task.title}</div>
+                  {session.task.description && (
+                    <div className="text-slate-400 text-sm mt-1">{session.task.description}</div>
+                  )}
+                  {session.task.timeLimit > 0 && (
+                    <div className="text-slate-400 text-sm mt-1">
+                      Time limit: {Math.round(session.task.timeLimit / 60)} min
+                    </div>
+                  )}
+                  {session.task.skills && session.task.skills.length > 0 && (
+                    <div className="flex flex-wrap gap-1 mt-2">
+                      {session.task.skills.map((skill) => (
+                        <span key={skill} className="text-xs px-2 py-0.5 rounded bg-slate-700 text-slate-300">
+                          {skill}
+                        </span>
+                      ))}
+                    </div>
+                  )}
+                </div>
+              )}
+
               {/* Task description button */}
               {session.task_description && (
                 <div className="border-t border-slate-700 pt-4">
@@ -243,6 +319,22 @@ export const JoinPage: React.FC<JoinPageProps> = ({ token, apiBaseUrl, wsBaseUrl
                 </div>
               )}
 
+              {/* What happens at expiry */}
+              {session.expiry_behavior && (
+                <div className="border-t border-slate-700 pt-4 flex items-start gap-2 text-sm text-slate-400">
+                  <Clock className="w-4 h-4 mt-0.5 flex-shrink-0" />
+                  <span>{expiryNotice(session.expiry_behavior)}</span>
+                </div>
+              )}
+
+              {/* Deadline to start the session */}
+              {session.join_by && (
+                <div className="flex items-start gap-2 text-sm text-slate-400">
+                  <Clock className="w-4 h-4 mt-0.5 flex-shrink-0" />
+                  <span>Start before {new Date(session.join_by).toLocaleString()}</span>
+                </div>
+              )}
+
               {/* Error banner */}
               {error && (
                 <div className="bg-red-500/10 border border-red-500/30 rounded-lg p-3">
@@ -391,6 +483,61 @@ export const JoinPage: React.FC<JoinPageProps> = ({ token, apiBaseUrl, wsBaseUrl
           </motion.div>
         )}
 
+        {/* Invalid — the invitation can no longer be used */}
+        {session?.status === 'invalid' && (
+          <motion.div
+            key="invalid"
+            initial={{ opacity: 0 }}
+            animate={{ opacity: 1 }}
+            className="max-w-md w-full mx-4 text-center"
+          >
+            <div className="bg-slate-800 border border-slate-700 rounded-xl p-6">
+              <X className="w-12 h-12 text-slate-500 mx-auto mb-4" />
+              <h2 className="text-xl font-bold text-white mb-2">Invitation No Longer Valid</h2>
+              <p className="text-slate-400">
+                This invitation is no longer valid. Please contact the person who sent it for a new link.
+              </p>
+            </div>
+          </motion.div>
+        )}
+
+        {/* Revoked — access was withdrawn */}
+        {session?.status === 'revoked' && (
+          <motion.div
+            key="revoked"
+            initial={{ opacity: 0 }}
+            animate={{ opacity: 1 }}
+            className="max-w-md w-full mx-4 text-center"
+          >
+            <div className="bg-slate-800 border border-slate-700 rounded-xl p-6">
+              <X className="w-12 h-12 text-slate-500 mx-auto mb-4" />
+              <h2 className="text-xl font-bold text-white mb-2">Access Revoked</h2>
+              <p className="text-slate-400">
+                Access to this session has been withdrawn. Please contact the person who sent the invitation.
+              </p>
+            </div>
+          </motion.div>
+        )}
+
+        {/* Submitted — the candidate handed in */}
+        {session?.status === 'submitted' && (
+          <motion.div
+            key="submitted"
+            initial={{ opacity: 0 }}
+            animate={{ opacity: 1 }}
+            className="max-w-md w-full mx-4 text-center"
+          >
+            <div className="bg-slate-800 border border-slate-700 rounded-xl p-6">
+              <Check className="w-12 h-12 text-cyan-400 mx-auto mb-4" />
+              <h2 className="text-xl font-bold text-white mb-2">Work Submitted</h2>
+              <p className="text-slate-400">
+                Your work has been handed in
+                {session.submitted_at && <> on {new Date(session.submitted_at).toLocaleString()}</>}. You can close this page.
+              </p>
+            </div>
+          </motion.div>
+        )}
+
         {/* Expired */}
         {session?.status === 'expired' && (
           <motion.div
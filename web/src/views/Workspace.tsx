@@ -1,5 +1,5 @@
 import React, { useState } from 'react';
-import { FolderTree, Server, ChevronLeft, ChevronRight, FileText, X } from 'lucide-react';
+import { FolderTree, Server, ChevronLeft, ChevronRight, FileText, Send, X } from 'lucide-react';
 import { motion, AnimatePresence } from 'framer-motion';
 import Markdown from 'react-markdown';
 import remarkGfm from 'remark-gfm';
@@ -20,6 +20,8 @@ interface WorkspaceProps {
   loading: boolean;
   error: string | null;
   taskDescription?: string;
+  // Hands in the session; the button is shown only when set
+  onSubmit?: () => void;
 }
 
 export const Workspace: React.FC<WorkspaceProps> = ({
@@ -31,6 +33,7 @@ export const Workspace: React.FC<WorkspaceProps> = ({
   loading,
   error,
   taskDescription,
+  onSubmit,
 }) => {
   const [sidebarCollapsed, setSidebarCollapsed] = useState(false);
   const [activeTab, setActiveTab] = useState<'files' | 'services'>('files');
@@ -115,6 +118,17 @@ export const Workspace: React.FC<WorkspaceProps> = ({
                     <FileText size={14} />
                   </button>
                 )}
+                {onSubmit && (
+                  <button
+                    onClick={() => {
+                      if (window.confirm('Hand in your work? The workspace will be closed.')) onSubmit();
+                    }}
+                    className="px-2 py-1 rounded text-xs transition-colors text-slate-400 hover:text-cyan-400 hover:bg-slate-700"
+                    title="Hand in"
+                  >
+                    <Send size={14} />
+                  </button>
+                )}
               </div>
             )}
             <button